@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+)
+
+// runAudit exports the records of an audit trail written by the audit
+// callback: gosible audit [options] FILE
+func runAudit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	host := flags.String("host", "", "Only export the changes made on this host")
+	runID := flags.String("run", "", "Only export the changes made by this run")
+	lines := flags.Bool("jsonl", false, "Write one record per line instead of a JSON array")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s audit [options] FILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nExport the changes recorded in an audit trail, with the state before and after\n")
+		fmt.Fprintf(os.Stderr, "each change. Record a trail with -callbacks audit=FILE.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s audit -host web1 -run 20261015T083427.512034117Z audit.jsonl\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("audit takes exactly one audit trail file")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open audit trail: %w", err)
+	}
+	defer file.Close()
+
+	records, err := callback.ReadAuditTrail(file, callback.AuditFilter{Host: *host, RunID: *runID})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	if *lines {
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 	
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/config"
 	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/logging"
+	"github.com/liliang-cn/gosible/pkg/modules"
 	"github.com/liliang-cn/gosible/pkg/playbook"
 	"github.com/liliang-cn/gosible/pkg/runner"
 	"github.com/liliang-cn/gosible/pkg/types"
-	"gopkg.in/yaml.v3"
+	"github.com/liliang-cn/gosible/pkg/vault"
 )
 
 var (
@@ -28,23 +35,111 @@ func main() {
 		moduleCmd     = flag.String("m", "", "Module to execute")
 		moduleArgs    = flag.String("a", "", "Module arguments (key=value pairs)")
 		hosts         = flag.String("hosts", "all", "Host pattern to match")
+		limit         = flag.String("limit", "", "Further limit the hosts of plays and ad-hoc commands to this host pattern; @FILE reads the hosts from a file such as a retry file")
 		check         = flag.Bool("check", false, "Run in check mode (dry run)")
-		diff          = flag.Bool("diff", false, "Show differences")
+		diff          = flag.Bool("diff", false, "Show the changes tasks make as unified diffs")
 		verbose       = flag.Bool("v", false, "Verbose output")
 		versionFlag   = flag.Bool("version", false, "Show version information")
 		listHosts     = flag.Bool("list-hosts", false, "List matching hosts")
 		listTasks     = flag.Bool("list-tasks", false, "List tasks in playbook")
+		listTags      = flag.Bool("list-tags", false, "List the tags of the plays and tasks in playbook")
+		onlyTags      = flag.String("tags", "", "Comma-separated tags; only run plays and tasks tagged with them")
+		skipTags      = flag.String("skip-tags", "", "Comma-separated tags; skip plays and tasks tagged with them")
+		startAtTask   = flag.String("start-at-task", "", "Start the playbook at the first task with this name, which may use wildcards")
+		step          = flag.Bool("step", false, "Confirm each task before running it")
 		become        = flag.Bool("b", false, "Run with become (sudo)")
 		becomeUser    = flag.String("become-user", "root", "User to become")
+		becomeMethod  = flag.String("become-method", "sudo", "Privilege escalation method (sudo, su, doas, runas)")
+		askBecomePass = flag.Bool("K", false, "Ask for privilege escalation password")
+		vaultPassFile = flag.String("vault-password-file", "", "Vault password file, or an executable printing the password, used to decrypt vaulted files and variables")
+		askVaultPass  = flag.Bool("ask-vault-pass", false, "Ask for the vault password")
 		forks         = flag.Int("f", 5, "Number of parallel processes")
 		extraVars     = flag.String("e", "", "Extra variables (key=value pairs or @file.yml)")
+		previewHook   = flag.String("preview-webhook", "", "Post a check mode change preview to this URL before running")
+		previewFormat = flag.String("preview-format", "json", "Change preview payload format (json or slack)")
+		previewAck    = flag.String("preview-ack-url", "", "Wait for approval from this URL before running the previewed changes")
+		previewWait   = flag.Duration("preview-ack-timeout", time.Hour, "Maximum time to wait for change preview approval")
+		maxOutput     = flag.Int("max-output", 0, "Maximum bytes of stdout/stderr kept per result, 0 for no limit")
+		outputSpool   = flag.String("output-spool-dir", "", "Directory to write the full output of truncated results to")
+		bundleDir     = flag.String("support-bundle-dir", "", "Collect a support bundle per failed host into this directory")
+		preflight     = flag.Bool("preflight", false, "Check that all targeted hosts are reachable before running any task")
+		preflightWait = flag.Duration("preflight-timeout", 5*time.Second, "Time each host has to answer the preflight check")
+		preflightStop = flag.Bool("preflight-abort", false, "Abort the run when the preflight check finds unreachable hosts")
+		callbacks     = flag.String("callbacks", "default", "Comma-separated callback plugins (default, minimal, json, jsonl, junit, profile_tasks, audit); name=FILE writes a plugin's output to FILE")
+		redactRules   = flag.String("redact-rules", "", "YAML file of redaction rules (patterns, fields, secrets) masking secrets in all output, on top of the built-in rules")
+		reconcile     = flag.Duration("reconcile", 0, "Keep enforcing the playbook: apply it again at this interval until interrupted")
+		reconcileRuns = flag.Int("reconcile-passes", 3, "Runs per reconcile round while hosts keep changing")
+		reconcileScan = flag.Bool("reconcile-drift", false, "Start reconcile rounds with a silent check mode run, applying only when hosts drifted")
+		watchChanges  = flag.Bool("reconcile-watch", false, "Start a reconcile round as soon as the inventory or playbook file changes")
+		reconcileHook = flag.String("reconcile-webhook", "", "Post the changes of reconcile rounds that changed or failed hosts to this URL, in the -preview-format")
+		modulePath    = flag.String("module-path", "", "Directories of third-party modules, separated by colons: Go plugins (.so) and executables speaking the external module protocol; defaults to gosible_MODULE_PATH")
 	)
 	
+	// Vault subcommands have their own flags
+	if len(os.Args) > 1 && os.Args[1] == "vault" {
+		if err := runVault(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	// audit exports the changes recorded in an audit trail
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := runAudit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	// query aggregates a read-only module's results across hosts
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		if err := runQuery(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	// plan previews a playbook's execution without connecting to hosts
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		if err := runPlan(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	// serve runs the HTTP API
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := runServe(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	// new-module generates a module skeleton
+	if len(os.Args) > 1 && os.Args[1] == "new-module" {
+		if err := runNewModule(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "gosible - Ansible-compatible automation tool in Go\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
 		fmt.Fprintf(os.Stderr, "  %s -i INVENTORY -p PLAYBOOK [options]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i INVENTORY -m MODULE -a ARGS [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s vault COMMAND [options] [FILE...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s new-module [options] NAME\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s audit [options] FILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s query -i INVENTORY [options] [PATTERN]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s plan -i INVENTORY [options] PLAYBOOK\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s serve [options]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -54,6 +149,23 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -m ping\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n  # Install a package\n")
 		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -m apt -a \"name=nginx state=present\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Only deploy, without the slow checks\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p site.yml -tags deploy -skip-tags slow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Run a playbook on the staging web servers, except the first one\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p site.yml -limit 'webservers:&staging:!webservers[0]'\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Rerun a playbook on the hosts that failed, with gosible_RETRY_FILES_ENABLED=true\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p site.yml -limit @site.retry\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Resume a failed rollout at its restart task, confirming each task\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p site.yml -start-at-task 'Restart nginx' -step\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Time tasks and write a JUnit report for CI\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p playbook.yml -callbacks default,profile_tasks,junit=report.xml\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Record the state before and after every change, then export one host's changes\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p playbook.yml -callbacks default,audit=audit.jsonl\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s audit -host web1 audit.jsonl\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Enforce a playbook every 15 minutes, and whenever the inventory or playbook changes\n")
+		fmt.Fprintf(os.Stderr, "  %s -i inventory.yml -p site.yml -reconcile 15m -reconcile-drift -reconcile-watch\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  # Count the OpenSSL versions across the web servers\n")
+		fmt.Fprintf(os.Stderr, "  %s query -i inventory.yml -m command -a \"openssl version\" -group-by stdout webservers\n", os.Args[0])
 	}
 	
 	flag.Parse()
@@ -64,6 +176,11 @@ func main() {
 		os.Exit(0)
 	}
 	
+	// Register third-party modules before anything runs
+	if err := loadModulePath(*modulePath, *verbose); err != nil {
+		log.Fatalf("Failed to load modules: %v", err)
+	}
+	
 	// Validate required arguments
 	if *inventoryFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: inventory file is required (-i)\n\n")
@@ -83,11 +200,17 @@ func main() {
 		log.Fatalf("Failed to load inventory: %v", err)
 	}
 	
+	// Read the hosts of @file limits, such as retry files
+	hostLimit, err := inventory.ExpandLimitFiles(*limit)
+	if err != nil {
+		log.Fatalf("Failed to read limit: %v", err)
+	}
+	
 	// List hosts if requested
 	if *listHosts {
-		matchedHosts, err := inv.GetHosts(*hosts)
+		matchedHosts, err := matchHosts(inv, *hosts, hostLimit)
 		if err != nil {
-			log.Fatalf("Failed to get hosts: %v", err)
+			log.Fatalf("Failed to match hosts: %v", err)
 		}
 		fmt.Printf("Matched hosts (%d):\n", len(matchedHosts))
 		for _, host := range matchedHosts {
@@ -96,10 +219,36 @@ func main() {
 		os.Exit(0)
 	}
 	
+	// Secrets are masked centrally before anything is written, with the
+	// decrypted vault values added as they are decrypted
+	redactor, err := newRedactor(*redactRules)
+	if err != nil {
+		log.Fatalf("Failed to load redaction rules: %v", err)
+	}
+	
+	// Load vault passwords for vaulted playbooks, var files and variables
+	vaults, err := vault.InitManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load vault passwords: %v", err)
+	}
+	vaults.SetSecretSink(redactor)
+	if *vaultPassFile != "" {
+		if err := vaults.AddVaultFromSource(vault.DefaultVaultIDLabel, *vaultPassFile); err != nil {
+			log.Fatalf("Failed to load vault password: %v", err)
+		}
+	}
+	if *askVaultPass {
+		password, err := promptPassword("Vault password: ")
+		if err != nil {
+			log.Fatalf("Failed to read vault password: %v", err)
+		}
+		vaults.AddVault(vault.DefaultVaultIDLabel, password)
+	}
+	
 	// Parse extra variables
 	vars := make(map[string]interface{})
 	if *extraVars != "" {
-		vars = parseExtraVars(*extraVars)
+		vars = parseExtraVars(*extraVars, vaults)
 	}
 	
 	// Add runtime variables
@@ -107,23 +256,216 @@ func main() {
 	vars["ansible_diff_mode"] = *diff
 	vars["ansible_become"] = *become
 	vars["ansible_become_user"] = *becomeUser
+	vars["ansible_become_method"] = *becomeMethod
 	vars["ansible_forks"] = *forks
+	if usesCallback(*callbacks, "audit") {
+		vars["_capture_state"] = true
+	}
+	
+	if *askBecomePass {
+		password, err := promptPassword("BECOME password: ")
+		if err != nil {
+			log.Fatalf("Failed to read become password: %v", err)
+		}
+		vars["ansible_become_password"] = password
+		redactor.AddSecret(password)
+	}
 	
 	ctx := context.Background()
+	limits := runner.OutputLimits{MaxBytes: *maxOutput, SpoolDir: *outputSpool}
+	bundles := runner.SupportBundles{Dir: *bundleDir}
+	
+	// Set up the callback plugins reporting the run
+	manager, closeOutputs, err := newCallbackManager(*callbacks, *verbose)
+	if err != nil {
+		log.Fatalf("Failed to set up callbacks: %v", err)
+	}
+	manager.SetRedactor(redactor)
+	
+	// Set up the reachability check run before any task
+	var checks *preflightOptions
+	if *preflight || *preflightStop {
+		checks = &preflightOptions{timeout: *preflightWait, abort: *preflightStop}
+	}
 	
 	if *playbookFile != "" {
-		// Execute playbook
-		if err := runPlaybook(ctx, *playbookFile, inv, vars, *listTasks, *verbose); err != nil {
+		// Set up change preview review if requested
+		var preview *playbook.PreviewOptions
+		if *previewHook != "" || *previewAck != "" {
+			preview = &playbook.PreviewOptions{Name: *playbookFile}
+			if *previewHook != "" {
+				preview.Publisher = playbook.NewWebhookPublisher(*previewHook, *previewFormat)
+			}
+			if *previewAck != "" {
+				preview.Acknowledger = playbook.NewHTTPAcknowledger(*previewAck, *previewWait)
+			}
+		}
+
+		if *step && preview != nil {
+			log.Fatalf("Change preview review cannot be combined with -step")
+		}
+		
+		tags := playbook.TagSelection{Tags: splitTags(*onlyTags), SkipTags: splitTags(*skipTags)}
+		start := startOptions{task: *startAtTask, step: *step}
+		listing := *listTasks || *listTags
+
+		// Execute playbook, or keep enforcing it in reconcile mode
+		if *reconcile > 0 && !listing {
+			if preview != nil {
+				log.Fatalf("Reconcile mode cannot be combined with change preview review")
+			}
+			if *step {
+				log.Fatalf("Reconcile mode cannot be combined with -step")
+			}
+			opts := playbook.ReconcileOptions{Name: *playbookFile, Interval: *reconcile, MaxPasses: *reconcileRuns, DetectDrift: *reconcileScan}
+			if *reconcileHook != "" {
+				opts.Publisher = playbook.NewWebhookPublisher(*reconcileHook, *previewFormat)
+			}
+			err = runReconcile(ctx, *playbookFile, *inventoryFile, inv, vars, vaults, opts, *watchChanges, checks, limits, bundles, tags, hostLimit, *startAtTask, manager)
+		} else {
+			// Failed hosts are written to a retry file when enabled
+			var retryFile string
+			if cfg := config.NewConfig(); cfg.GetBool("retry_files_enabled") && !listing {
+				retryFile = playbook.RetryFilePath(*playbookFile, cfg.GetString("retry_files_save_path"))
+			}
+			err = runPlaybook(ctx, *playbookFile, inv, vars, vaults, preview, checks, limits, bundles, tags, hostLimit, start, retryFile, manager, *listTasks, *listTags, *verbose)
+		}
+		if !listing {
+			manager.OnRunnerEnd()
+		}
+		closeOutputs()
+		if err != nil {
 			log.Fatalf("Playbook execution failed: %v", err)
 		}
 	} else if *moduleCmd != "" {
 		// Execute ad-hoc command
-		if err := runAdHoc(ctx, *moduleCmd, *moduleArgs, *hosts, inv, vars, *verbose); err != nil {
+		err = runAdHoc(ctx, *moduleCmd, *moduleArgs, *hosts, hostLimit, inv, vars, vaults, checks, limits, bundles, manager, *verbose)
+		manager.OnRunnerEnd()
+		closeOutputs()
+		if err != nil {
 			log.Fatalf("Ad-hoc command failed: %v", err)
 		}
 	}
 }
 
+// preflightOptions configures the reachability check run before any task
+type preflightOptions struct {
+	timeout time.Duration
+	abort   bool // Stop instead of leaving unreachable hosts out
+}
+
+// startOptions selects where a playbook run starts and whether the
+// operator confirms each task
+type startOptions struct {
+	task string // Name of the task to start at, empty for the first
+	step bool
+}
+
+// newCallbackManager registers the callback plugins named in a
+// comma-separated list. A plugin given as name=FILE writes its output to
+// FILE instead of stdout; the returned function closes those files.
+func newCallbackManager(spec string, verbose bool) (*callback.CallbackManager, func(), error) {
+	manager := callback.NewCallbackManager()
+	var files []*os.File
+	closeOutputs := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	
+	for _, entry := range strings.Split(spec, ",") {
+		name, path, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name == "" {
+			continue
+		}
+		
+		plugin, err := callback.NewCallback(name)
+		if err != nil {
+			closeOutputs()
+			return nil, nil, err
+		}
+		// Diffs are colored on a terminal unless NO_COLOR is set
+		color := path == "" && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+		if err := plugin.Initialize(map[string]interface{}{"verbose": verbose, "color": color}); err != nil {
+			closeOutputs()
+			return nil, nil, fmt.Errorf("failed to initialize callback %s: %w", name, err)
+		}
+		if path != "" {
+			// The audit trail keeps the changes of every run
+			flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if name == "audit" {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			file, err := os.OpenFile(path, flags, 0666)
+			if err != nil {
+				closeOutputs()
+				return nil, nil, fmt.Errorf("failed to open output of callback %s: %w", name, err)
+			}
+			files = append(files, file)
+			plugin.SetOutput(file)
+		}
+		manager.Register(plugin)
+	}
+	
+	return manager, closeOutputs, nil
+}
+
+// usesCallback reports whether a callback plugin list names a plugin
+func usesCallback(spec, plugin string) bool {
+	for _, entry := range strings.Split(spec, ",") {
+		if name, _, _ := strings.Cut(strings.TrimSpace(entry), "="); name == plugin {
+			return true
+		}
+	}
+	return false
+}
+
+// newRedactor builds the redactor applied to all output from the built-in
+// rules and those in an optional rules file
+func newRedactor(path string) (*logging.Redactor, error) {
+	rules := logging.DefaultRedactionRules()
+	if path != "" {
+		extra, err := logging.LoadRedactionRules(path)
+		if err != nil {
+			return nil, err
+		}
+		rules.Patterns = append(rules.Patterns, extra.Patterns...)
+		rules.Fields = append(rules.Fields, extra.Fields...)
+		rules.Secrets = append(rules.Secrets, extra.Secrets...)
+		if extra.Mask != "" {
+			rules.Mask = extra.Mask
+		}
+	}
+	return logging.NewRedactor(rules)
+}
+
+// loadModulePath registers the third-party modules in the directories of a
+// module path, by default the module_path setting. When the ansible_path or
+// ansible_library settings are given, the Ansible Python modules found
+// there run for modules that are not registered.
+func loadModulePath(spec string, verbose bool) error {
+	cfg := config.NewConfig()
+	if spec == "" {
+		spec = cfg.GetString("module_path")
+	}
+	loaded, err := modules.DefaultModuleRegistry.LoadModulePath(filepath.SplitList(spec)...)
+	if err != nil {
+		return err
+	}
+	if verbose && len(loaded) > 0 {
+		fmt.Printf("Loaded modules: %s\n", strings.Join(loaded, ", "))
+	}
+
+	ansible := &modules.AnsibleModules{
+		Root:    cfg.GetString("ansible_path"),
+		Library: filepath.SplitList(cfg.GetString("ansible_library")),
+	}
+	if ansible.Root != "" || len(ansible.Library) > 0 {
+		modules.DefaultModuleRegistry.SetFallback(ansible.Load)
+	}
+	return nil
+}
+
 // loadInventory loads inventory from a file
 func loadInventory(filename string) (*inventory.StaticInventory, error) {
 	data, err := os.ReadFile(filename)
@@ -134,6 +476,9 @@ func loadInventory(filename string) (*inventory.StaticInventory, error) {
 	// Try YAML format
 	inv, err := inventory.NewFromYAML(data)
 	if err == nil {
+		if err := inv.LoadVarsPlugins(context.Background(), filepath.Dir(filename)); err != nil {
+			return nil, err
+		}
 		return inv, nil
 	}
 	
@@ -142,22 +487,13 @@ func loadInventory(filename string) (*inventory.StaticInventory, error) {
 }
 
 // runPlaybook executes a playbook
-func runPlaybook(ctx context.Context, filename string, inv *inventory.StaticInventory, vars map[string]interface{}, listTasks, verbose bool) error {
-	// Read playbook file
-	data, err := os.ReadFile(filename)
+func runPlaybook(ctx context.Context, filename string, inv *inventory.StaticInventory, vars map[string]interface{}, vaults *vault.Manager, preview *playbook.PreviewOptions, preflight *preflightOptions, limits runner.OutputLimits, bundles runner.SupportBundles, tags playbook.TagSelection, limit string, start startOptions, retryFile string, callbacks *callback.CallbackManager, listTasks, listTags, verbose bool) error {
+	// Parse playbook, decrypting vaulted files and values
+	parser := playbook.NewParser()
+	parser.SetVaultManager(vaults)
+	pb, err := parser.ParseFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read playbook: %w", err)
-	}
-	
-	// Parse playbook
-	var pb types.Playbook
-	if err := yaml.Unmarshal(data, &pb); err != nil {
-		// Try parsing as list of plays
-		var plays []types.Play
-		if err := yaml.Unmarshal(data, &plays); err != nil {
-			return fmt.Errorf("failed to parse playbook: %w", err)
-		}
-		pb.Plays = plays
+		return fmt.Errorf("failed to parse playbook: %w", err)
 	}
 	
 	// List tasks if requested
@@ -176,22 +512,59 @@ func runPlaybook(ctx context.Context, filename string, inv *inventory.StaticInve
 	}
 	
 	// Create playbook executor
-	taskRunner := runner.NewTaskRunner()
-	executor := playbook.NewExecutor(taskRunner, inv, nil)
+	executor := newPlaybookExecutor(filename, inv, vaults, preflight, limits, bundles, tags, limit, callbacks)
+
+	// List tags, including those of roles, if requested
+	if listTags {
+		plays, err := executor.ListTags(pb)
+		if err != nil {
+			return fmt.Errorf("failed to list tags: %w", err)
+		}
+		fmt.Printf("Playbook: %s\n\n", filename)
+		for i, play := range plays {
+			fmt.Printf("Play #%d (%v): %s\tTAGS: [%s]\n", i+1, play.Hosts, play.Name, strings.Join(play.Tags, ", "))
+			fmt.Printf("  TASK TAGS: [%s]\n\n", strings.Join(play.TaskTags, ", "))
+		}
+		return nil
+	}
+	
+	// Start at a task, and confirm each task, if requested
+	executor.SetStartAtTask(start.task)
+	if start.step {
+		executor.SetStepper(playbook.NewPromptStepper(os.Stdin, os.Stdout))
+	}
 	
 	// Execute playbook
 	if verbose {
 		fmt.Printf("Executing playbook: %s\n", filename)
 	}
 	
-	results, err := executor.Execute(ctx, &pb, vars)
+	var results []types.Result
+	if preview != nil {
+		var report *playbook.ChangePreview
+		results, report, err = executor.ExecuteWithPreview(ctx, pb, vars, *preview)
+		if report != nil && verbose {
+			fmt.Println(report.Text())
+		}
+	} else {
+		results, err = executor.Execute(ctx, pb, vars)
+	}
+	
+	// Record the failed hosts, so the run can be repeated on them
+	if retryFile != "" {
+		if failed := playbook.FailedHosts(results); len(failed) > 0 {
+			if err := playbook.WriteRetryFile(retryFile, failed); err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				fmt.Printf("\tto retry, use: -limit @%s\n", retryFile)
+			}
+		}
+	}
+	
 	if err != nil {
 		return fmt.Errorf("playbook execution failed: %w", err)
 	}
 	
-	// Display results
-	displayResults(results, verbose)
-	
 	// Check for failures
 	for _, result := range results {
 		if !result.Success {
@@ -202,10 +575,33 @@ func runPlaybook(ctx context.Context, filename string, inv *inventory.StaticInve
 	return nil
 }
 
+// newPlaybookExecutor creates the executor of a playbook file, resolving
+// its roles and included task files next to it
+func newPlaybookExecutor(filename string, inv types.Inventory, vaults *vault.Manager, preflight *preflightOptions, limits runner.OutputLimits, bundles runner.SupportBundles, tags playbook.TagSelection, limit string, callbacks *callback.CallbackManager) *playbook.Executor {
+	taskRunner := runner.NewTaskRunner()
+	taskRunner.Configure(config.NewConfig())
+	taskRunner.SetVaultManager(vaults)
+	taskRunner.SetOutputLimits(limits)
+	taskRunner.SetSupportBundles(bundles)
+	taskRunner.SetInventory(inv)
+	taskRunner.SetPrompter(newTerminalPrompter(callbacks))
+	executor := playbook.NewExecutor(taskRunner, inv, nil)
+	executor.SetCallbacks(callbacks)
+	executor.SetVaultManager(vaults)
+	executor.SetTags(tags)
+	executor.SetLimit(limit)
+	if preflight != nil {
+		executor.SetPreflight(taskRunner, preflight.timeout, preflight.abort)
+	}
+	executor.SetRolesPath(filepath.Join(filepath.Dir(filename), "roles"), "/etc/ansible/roles")
+	executor.SetIncludePath(filepath.Dir(filename))
+	return executor
+}
+
 // runAdHoc executes an ad-hoc command
-func runAdHoc(ctx context.Context, module, args, hostPattern string, inv *inventory.StaticInventory, vars map[string]interface{}, verbose bool) error {
+func runAdHoc(ctx context.Context, module, args, hostPattern, limit string, inv *inventory.StaticInventory, vars map[string]interface{}, vaults *vault.Manager, preflight *preflightOptions, limits runner.OutputLimits, bundles runner.SupportBundles, callbacks *callback.CallbackManager, verbose bool) error {
 	// Get matching hosts
-	hosts, err := inv.GetHosts(hostPattern)
+	hosts, err := matchHosts(inv, hostPattern, limit)
 	if err != nil {
 		return fmt.Errorf("failed to get hosts: %w", err)
 	}
@@ -214,8 +610,8 @@ func runAdHoc(ctx context.Context, module, args, hostPattern string, inv *invent
 		return fmt.Errorf("no hosts matched pattern: %s", hostPattern)
 	}
 	
-	// Parse module arguments
-	moduleArgs := parseModuleArgs(args)
+	// Parse module arguments, a command line for command, shell and raw
+	moduleArgs := types.ParseFreeFormArgs(types.ModuleType(module), args)
 	
 	// Create task
 	task := types.Task{
@@ -226,6 +622,20 @@ func runAdHoc(ctx context.Context, module, args, hostPattern string, inv *invent
 	
 	// Create runner
 	taskRunner := runner.NewTaskRunner()
+	taskRunner.Configure(config.NewConfig())
+	taskRunner.SetVaultManager(vaults)
+	taskRunner.SetOutputLimits(limits)
+	taskRunner.SetSupportBundles(bundles)
+	taskRunner.SetCallbacks(callbacks)
+	taskRunner.SetPrompter(newTerminalPrompter(callbacks))
+	
+	// Leave out unreachable hosts, or stop, before running the module
+	if preflight != nil {
+		hosts, err = preflightHosts(ctx, taskRunner, hosts, preflight, callbacks)
+		if err != nil {
+			return err
+		}
+	}
 	
 	// Execute task
 	if verbose {
@@ -237,9 +647,6 @@ func runAdHoc(ctx context.Context, module, args, hostPattern string, inv *invent
 		return fmt.Errorf("task execution failed: %w", err)
 	}
 	
-	// Display results
-	displayResults(results, verbose)
-	
 	// Check for failures
 	for _, result := range results {
 		if !result.Success {
@@ -250,6 +657,72 @@ func runAdHoc(ctx context.Context, module, args, hostPattern string, inv *invent
 	return nil
 }
 
+// matchHosts returns the hosts matching pattern, narrowed to those also
+// matching limit when one is given
+func matchHosts(inv *inventory.StaticInventory, pattern, limit string) ([]types.Host, error) {
+	hosts, err := inv.GetHosts(pattern)
+	if err != nil {
+		return nil, err
+	}
+	
+	allowed := map[string]bool{}
+	if limit != "" {
+		limited, err := inv.GetHosts(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %q: %w", limit, err)
+		}
+		for _, host := range limited {
+			allowed[host.Name] = true
+		}
+	}
+	
+	matched := make([]types.Host, 0, len(hosts))
+	for _, host := range hosts {
+		if limit == "" || allowed[host.Name] {
+			matched = append(matched, host)
+		}
+	}
+	return matched, nil
+}
+
+// preflightHosts probes the hosts of an ad-hoc run, reporting unreachable
+// ones to the callbacks, and returns the reachable hosts
+func preflightHosts(ctx context.Context, taskRunner *runner.TaskRunner, hosts []types.Host, preflight *preflightOptions, callbacks *callback.CallbackManager) ([]types.Host, error) {
+	task := &types.Task{Name: "Preflight", Module: "preflight"}
+	callbacks.OnTaskStart(task, hosts)
+	
+	unreachable := make(map[string]bool)
+	for _, result := range taskRunner.Preflight(ctx, hosts, preflight.timeout) {
+		callbacks.OnTaskResult(task, &result)
+		unreachable[result.Host] = true
+	}
+	if len(unreachable) > 0 && preflight.abort {
+		return nil, fmt.Errorf("preflight failed: %d of %d hosts unreachable", len(unreachable), len(hosts))
+	}
+	
+	reachable := make([]types.Host, 0, len(hosts))
+	for _, host := range hosts {
+		if !unreachable[host.Name] {
+			reachable = append(reachable, host)
+		}
+	}
+	if len(reachable) == 0 {
+		return nil, fmt.Errorf("no reachable hosts")
+	}
+	return reachable, nil
+}
+
+// stdin is shared by prompts and other input so neither loses what the
+// other buffered
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptPassword reads a password from stdin, disabling terminal echo with
+// stty when stdin is a terminal
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	return readLine(false)
+}
+
 // parseModuleArgs parses module arguments from string
 func parseModuleArgs(args string) map[string]interface{} {
 	result := make(map[string]interface{})
@@ -274,8 +747,20 @@ func parseModuleArgs(args string) map[string]interface{} {
 	return result
 }
 
-// parseExtraVars parses extra variables
-func parseExtraVars(vars string) map[string]interface{} {
+// splitTags splits a comma-separated --tags or --skip-tags value
+func splitTags(spec string) []string {
+	var tags []string
+	for _, tag := range strings.Split(spec, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseExtraVars parses extra variables. Files given as @file may be vault
+// encrypted or hold inline !vault values.
+func parseExtraVars(vars string, vaults *vault.Manager) map[string]interface{} {
 	result := make(map[string]interface{})
 	
 	// Check if it's a file reference
@@ -287,7 +772,7 @@ func parseExtraVars(vars string) map[string]interface{} {
 			return result
 		}
 		
-		if err := yaml.Unmarshal(data, &result); err != nil {
+		if err := vaults.DecodeYAML(data, &result); err != nil {
 			log.Printf("Warning: failed to parse vars file %s: %v", filename, err)
 		}
 		return result
@@ -297,69 +782,6 @@ func parseExtraVars(vars string) map[string]interface{} {
 	return parseModuleArgs(vars)
 }
 
-// displayResults displays task execution results
-func displayResults(results []types.Result, verbose bool) {
-	successCount := 0
-	failureCount := 0
-	changedCount := 0
-	
-	for _, result := range results {
-		if result.Success {
-			successCount++
-			if result.Changed {
-				changedCount++
-				fmt.Printf("changed: [%s] => %s\n", result.Host, result.TaskName)
-			} else {
-				if verbose {
-					fmt.Printf("ok: [%s] => %s\n", result.Host, result.TaskName)
-				}
-			}
-		} else {
-			failureCount++
-			fmt.Printf("failed: [%s] => %s: %v\n", result.Host, result.TaskName, result.Error)
-		}
-		
-		// Show diff if available
-		if result.Diff != nil && result.Diff.Prepared {
-			fmt.Printf("--- before\n")
-			fmt.Printf("%s\n", result.Diff.Before)
-			fmt.Printf("+++ after\n")
-			fmt.Printf("%s\n", result.Diff.After)
-		}
-		
-		// Show output if verbose
-		if verbose && result.Message != "" {
-			fmt.Printf("  Output: %s\n", result.Message)
-		}
-	}
-	
-	// Summary
-	fmt.Printf("\nPLAY RECAP *********************************************************************\n")
-	hostSummary := make(map[string]struct {
-		ok      int
-		changed int
-		failed  int
-	})
-	
-	for _, result := range results {
-		summary := hostSummary[result.Host]
-		if result.Success {
-			summary.ok++
-			if result.Changed {
-				summary.changed++
-			}
-		} else {
-			summary.failed++
-		}
-		hostSummary[result.Host] = summary
-	}
-	
-	for host, summary := range hostSummary {
-		fmt.Printf("%-20s : ok=%-3d changed=%-3d unreachable=%-3d failed=%-3d\n",
-			host, summary.ok, summary.changed, 0, summary.failed)
-	}
-}
-
 // Built-in help command
 func showBuiltinModules() {
 	fmt.Println("Built-in modules:")
@@ -369,8 +791,13 @@ func showBuiltinModules() {
 	fmt.Println("  copy         - Copy files to remote hosts")
 	fmt.Println("  file         - Manage files and directories")
 	fmt.Println("  template     - Deploy files from templates")
+	fmt.Println("  package      - Manage packages with the host's package manager")
 	fmt.Println("  apt          - Manage apt packages (Debian/Ubuntu)")
 	fmt.Println("  yum          - Manage yum packages (RedHat/CentOS)")
+	fmt.Println("  dnf          - Manage dnf packages (Fedora/RHEL 8+)")
+	fmt.Println("  zypper       - Manage zypper packages (SUSE/openSUSE)")
+	fmt.Println("  apk          - Manage apk packages (Alpine)")
+	fmt.Println("  pacman       - Manage pacman packages (Arch)")
 	fmt.Println("  service      - Manage services")
 	fmt.Println("  systemd      - Manage systemd services")
 	fmt.Println("  user         - Manage user accounts")
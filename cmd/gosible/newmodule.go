@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/liliang-cn/gosible/pkg/scaffold"
+)
+
+// runNewModule generates a module skeleton with
+// gosible new-module [options] NAME
+func runNewModule(args []string) error {
+	flags := flag.NewFlagSet("new-module", flag.ExitOnError)
+	dir := flags.String("dir", "pkg/modules", "Modules package directory to write to")
+	description := flags.String("description", "", "One-line module description")
+	platform := flags.String("platform", "linux", "Platform the module supports")
+	root := flags.Bool("root", false, "Declare that the module requires root")
+	noRegister := flags.Bool("no-register", false, "Do not add the module to registry.go")
+	force := flags.Bool("force", false, "Overwrite existing files")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s new-module [options] NAME\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nGenerate NAME.go and NAME_test.go with documentation, validation, check and\n")
+		fmt.Fprintf(os.Stderr, "diff mode handling and tests, and register the module in registry.go.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s new-module -description \"Manage ufw firewall rules\" -root ufw_rule\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("new-module takes exactly one module name")
+	}
+	name := flags.Arg(0)
+
+	written, err := scaffold.GenerateModule(name, scaffold.Options{
+		Dir:          *dir,
+		Description:  *description,
+		Platform:     *platform,
+		RequiresRoot: *root,
+		Register:     !*noRegister,
+		Force:        *force,
+	})
+	for _, path := range written {
+		fmt.Printf("wrote %s\n", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nNext: replace the TODOs in %s/%s.go, then run go test ./%s -run 'Test%sModule|TestBuiltinModuleConformance'\n",
+		*dir, name, *dir, scaffold.TypeName(name))
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/playbook"
+	"github.com/liliang-cn/gosible/pkg/runner"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+// runPlan prints the execution plan of a playbook without connecting to
+// any host, for review before the playbook runs:
+// gosible plan -i INVENTORY [options] PLAYBOOK
+func runPlan(args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	inventoryFile := flags.String("i", "", "Inventory file")
+	extraVars := flags.String("e", "", "Extra variables (key=value or @file)")
+	limit := flags.String("limit", "", "Further limit selected hosts to an additional pattern, or @FILE")
+	onlyTags := flags.String("tags", "", "Only plan tasks and plays tagged with these comma-separated tags")
+	skipTags := flags.String("skip-tags", "", "Leave out tasks and plays tagged with these comma-separated tags")
+	format := flags.String("format", "text", "Output format: text or json")
+	vaultPassFile := flags.String("vault-password-file", "", "Vault password file or executable script")
+	redactRules := flags.String("redact-rules", "", "YAML file of redaction rules masking secrets in the task arguments")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s plan -i INVENTORY [options] PLAYBOOK\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nShow the plays, hosts and tasks running PLAYBOOK would go through, in order,\n")
+		fmt.Fprintf(os.Stderr, "without connecting to any host. Tasks are marked + when they run, ? when the\n")
+		fmt.Fprintf(os.Stderr, "outcome depends on the run, such as facts or registered results, and - when\n")
+		fmt.Fprintf(os.Stderr, "their condition skips them on every host.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s plan -i inventory.yml site.yml\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s plan -i inventory.yml -limit webservers -tags deploy -format json site.yml\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if *inventoryFile == "" {
+		flags.Usage()
+		return fmt.Errorf("inventory file is required (-i)")
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("plan takes one playbook")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use text or json", *format)
+	}
+	filename := flags.Arg(0)
+
+	inv, err := loadInventory(*inventoryFile)
+	if err != nil {
+		return fmt.Errorf("failed to load inventory: %w", err)
+	}
+	hostLimit, err := inventory.ExpandLimitFiles(*limit)
+	if err != nil {
+		return fmt.Errorf("failed to read limit: %w", err)
+	}
+
+	redactor, err := newRedactor(*redactRules)
+	if err != nil {
+		return fmt.Errorf("failed to load redaction rules: %w", err)
+	}
+	vaults, err := vault.InitManagerFromEnv()
+	if err != nil {
+		return fmt.Errorf("failed to load vault passwords: %w", err)
+	}
+	vaults.SetSecretSink(redactor)
+	if *vaultPassFile != "" {
+		if err := vaults.AddVaultFromSource(vault.DefaultVaultIDLabel, *vaultPassFile); err != nil {
+			return fmt.Errorf("failed to load vault password: %w", err)
+		}
+	}
+
+	vars := make(map[string]interface{})
+	if *extraVars != "" {
+		vars = parseExtraVars(*extraVars, vaults)
+	}
+
+	parser := playbook.NewParser()
+	parser.SetVaultManager(vaults)
+	pb, err := parser.ParseFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to parse playbook: %w", err)
+	}
+
+	tags := playbook.TagSelection{Tags: splitTags(*onlyTags), SkipTags: splitTags(*skipTags)}
+	executor := newPlaybookExecutor(filename, inv, vaults, nil, runner.OutputLimits{}, runner.SupportBundles{}, tags, hostLimit, nil)
+	plan, err := executor.Plan(pb, vars)
+	if err != nil {
+		return fmt.Errorf("failed to plan playbook: %w", err)
+	}
+	plan.Playbook = filename
+
+	// Arguments may hold secrets the playbook does not mark no_log
+	for i := range plan.Plays {
+		for j := range plan.Plays[i].Tasks {
+			task := &plan.Plays[i].Tasks[j]
+			if args, ok := redactor.Value(task.Args).(map[string]interface{}); ok {
+				task.Args = args
+			}
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+	fmt.Print(plan.Text())
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+)
+
+// terminalPrompter shows pause tasks through the callback plugins and
+// reads the operator's answers from the terminal
+type terminalPrompter struct {
+	callbacks *callback.CallbackManager
+}
+
+// newTerminalPrompter returns a prompter announcing pauses to callbacks
+func newTerminalPrompter(callbacks *callback.CallbackManager) *terminalPrompter {
+	return &terminalPrompter{callbacks: callbacks}
+}
+
+// isTerminal reports whether a file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Interactive reports whether stdin is a terminal
+func (p *terminalPrompter) Interactive() bool {
+	return isTerminal(os.Stdin)
+}
+
+// Announce shows the pause through the callback plugins, or on stderr when
+// none of them shows pauses
+func (p *terminalPrompter) Announce(prompt string, duration time.Duration) {
+	if p.callbacks != nil && p.callbacks.OnPause(prompt, duration) {
+		return
+	}
+	switch {
+	case duration == 0:
+		fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	case prompt != "":
+		fmt.Fprintf(os.Stderr, "Pausing for %s\n%s\n", duration, prompt)
+	default:
+		fmt.Fprintf(os.Stderr, "Pausing for %s\n", duration)
+	}
+}
+
+// ReadInput reads a line from stdin, giving up when ctx is done. The read
+// itself cannot be interrupted, so a line typed later is dropped.
+func (p *terminalPrompter) ReadInput(ctx context.Context, echo bool) (string, error) {
+	type answer struct {
+		line string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		line, err := readLine(echo)
+		answers <- answer{line, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case a := <-answers:
+		return a.line, a.err
+	}
+}
+
+// readLine reads a line from stdin. Without echo, terminal echo is
+// disabled with stty while reading when stdin is a terminal.
+func readLine(echo bool) (string, error) {
+	if !echo {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if stty.Run() == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
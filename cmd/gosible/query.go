@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/config"
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/runner"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+// freeFormModules take the query arguments as the command to run
+var freeFormModules = map[string]bool{"command": true, "shell": true}
+
+// runQuery runs a read-only module across the hosts of a pattern and
+// aggregates the results into one table or JSON document:
+// gosible query -i INVENTORY -m MODULE [options] [PATTERN]
+func runQuery(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	inventoryFile := flags.String("i", "", "Inventory file")
+	module := flags.String("m", "setup", "Read-only module to run; command and shell run with changed_when: false, other modules in check mode")
+	moduleArgs := flags.String("a", "", "Module arguments; the command line for command and shell")
+	extraVars := flags.String("e", "", "Extra variables (key=value or @file)")
+	fields := flags.String("field", "", "Comma-separated dotted paths into each host's result to report, e.g. ansible_facts.ansible_distribution")
+	groupBy := flags.String("group-by", "", "Dotted path whose distinct values the hosts are grouped and counted by")
+	format := flags.String("format", "table", "Output format: table or json")
+	become := flags.Bool("b", false, "Run operations with become")
+	becomeUser := flags.String("become-user", "root", "Run operations as this user")
+	forks := flags.Int("f", 5, "Number of parallel processes")
+	vaultPassFile := flags.String("vault-password-file", "", "Vault password file or executable script")
+	redactRules := flags.String("redact-rules", "", "YAML file of redaction rules masking secrets in the output")
+	modulePath := flags.String("module-path", "", "Directories of third-party modules, separated by colons; defaults to gosible_MODULE_PATH")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s query -i INVENTORY [options] [PATTERN]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nRun a read-only module on the hosts matching PATTERN (default all) and report\n")
+		fmt.Fprintf(os.Stderr, "the results as one table or JSON document.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s query -i inventory.yml -group-by ansible_facts.ansible_distribution_version\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s query -i inventory.yml -m command -a \"openssl version\" -group-by stdout webservers\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if *inventoryFile == "" {
+		flags.Usage()
+		return fmt.Errorf("inventory file is required (-i)")
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("query takes at most one host pattern")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use table or json", *format)
+	}
+	pattern := "all"
+	if flags.NArg() == 1 {
+		pattern = flags.Arg(0)
+	}
+
+	if err := loadModulePath(*modulePath, false); err != nil {
+		return fmt.Errorf("failed to load modules: %w", err)
+	}
+
+	inv, err := loadInventory(*inventoryFile)
+	if err != nil {
+		return fmt.Errorf("failed to load inventory: %w", err)
+	}
+	hosts, err := inv.GetHosts(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to get hosts: %w", err)
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hosts matched pattern: %s", pattern)
+	}
+
+	redactor, err := newRedactor(*redactRules)
+	if err != nil {
+		return fmt.Errorf("failed to load redaction rules: %w", err)
+	}
+	vaults, err := vault.InitManagerFromEnv()
+	if err != nil {
+		return fmt.Errorf("failed to load vault passwords: %w", err)
+	}
+	vaults.SetSecretSink(redactor)
+	if *vaultPassFile != "" {
+		if err := vaults.AddVaultFromSource(vault.DefaultVaultIDLabel, *vaultPassFile); err != nil {
+			return fmt.Errorf("failed to load vault password: %w", err)
+		}
+	}
+
+	vars := make(map[string]interface{})
+	if *extraVars != "" {
+		vars = parseExtraVars(*extraVars, vaults)
+	}
+	vars["ansible_become"] = *become
+	vars["ansible_become_user"] = *becomeUser
+	vars["ansible_forks"] = *forks
+
+	// Queries never change hosts: commands run but are never reported as
+	// changes, every other module must be able to run in check mode
+	task := types.Task{Name: fmt.Sprintf("Query: %s", *module), Module: types.ModuleType(*module)}
+	if freeFormModules[*module] {
+		if *moduleArgs == "" {
+			return fmt.Errorf("module %s needs the command to run (-a)", *module)
+		}
+		task.Args = map[string]interface{}{"cmd": *moduleArgs}
+		task.ChangedWhen = false
+	} else {
+		instance, err := modules.DefaultModuleRegistry.GetModule(*module)
+		if err != nil {
+			return err
+		}
+		capable, ok := instance.(types.ModuleWithCapabilities)
+		if !ok || capable.Capabilities() == nil || !capable.Capabilities().CheckMode {
+			return fmt.Errorf("module %s does not support check mode and cannot be queried", *module)
+		}
+		task.Args = parseModuleArgs(*moduleArgs)
+		vars["ansible_check_mode"] = true
+	}
+
+	options := runner.QueryOptions{GroupBy: *groupBy}
+	for _, field := range strings.Split(*fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			options.Fields = append(options.Fields, field)
+		}
+	}
+	if len(options.Fields) == 0 && options.GroupBy == "" {
+		if freeFormModules[*module] {
+			options.Fields = []string{"stdout"}
+		} else {
+			options.Fields = []string{"msg"}
+		}
+	}
+
+	taskRunner := runner.NewTaskRunner()
+	taskRunner.Configure(config.NewConfig())
+	taskRunner.SetVaultManager(vaults)
+	results, err := taskRunner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	for i := range results {
+		results[i] = *redactor.Result(&results[i])
+	}
+
+	report := runner.AggregateQuery(results, options)
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Failed == report.Hosts {
+		return fmt.Errorf("query failed on every host")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/playbook"
+	"github.com/liliang-cn/gosible/pkg/runner"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+// reconcileWatchInterval is how often watched files are checked for changes
+const reconcileWatchInterval = 5 * time.Second
+
+// runReconcile keeps enforcing a playbook until interrupted, re-reading the
+// playbook and inventory before each round so changes pulled into the
+// working copy are applied
+func runReconcile(ctx context.Context, filename, inventoryFile string, inv *inventory.StaticInventory, vars map[string]interface{}, vaults *vault.Manager, opts playbook.ReconcileOptions, watch bool, preflight *preflightOptions, limits runner.OutputLimits, bundles runner.SupportBundles, tags playbook.TagSelection, limit, startAt string, callbacks *callback.CallbackManager) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	parser := playbook.NewParser()
+	parser.SetVaultManager(vaults)
+	pb, err := parser.ParseFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to parse playbook: %w", err)
+	}
+
+	// A broken playbook or inventory pushed mid-flight must not stop the
+	// enforcement, the last good ones stay in use until it is fixed
+	first := true
+	opts.Reload = func() (*types.Playbook, types.Inventory, error) {
+		if first {
+			first = false
+			return nil, nil, nil
+		}
+		reloaded, err := parser.ParseFile(filename)
+		if err != nil {
+			log.Printf("Warning: keeping the previous playbook: %v", err)
+			return nil, nil, nil
+		}
+		reloadedInv, err := loadInventory(inventoryFile)
+		if err != nil {
+			log.Printf("Warning: keeping the previous inventory: %v", err)
+			return reloaded, nil, nil
+		}
+		return reloaded, reloadedInv, nil
+	}
+	if watch {
+		opts.Triggers = playbook.WatchFiles(ctx, reconcileWatchInterval, filename, inventoryFile)
+	}
+	opts.OnRound = func(round *playbook.ReconcileRound) {
+		status := "converged"
+		if !round.Converged {
+			status = "not converged"
+		}
+		log.Printf("Reconcile round %d (%s): %s after %d run(s), %d change(s), %d failure(s) in %s",
+			round.Number, round.Trigger, status, round.Passes, round.Changes.Summary.Changes, round.Changes.Summary.Failures, round.Duration.Round(time.Millisecond))
+		if round.Err != nil {
+			log.Printf("Reconcile round %d failed: %v", round.Number, round.Err)
+		}
+	}
+
+	executor := newPlaybookExecutor(filename, inv, vaults, preflight, limits, bundles, tags, limit, callbacks)
+	executor.SetStartAtTask(startAt)
+	return executor.Reconcile(ctx, pb, vars, opts)
+}
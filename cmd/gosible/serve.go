@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/api"
+	"github.com/liliang-cn/gosible/pkg/vault"
+	"github.com/liliang-cn/gosible/pkg/websocket"
+)
+
+// runServe serves the HTTP API submitting runs and reporting their
+// results, with their events streamed over WebSocket:
+// gosible serve [options]
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	inventoryFile := flags.String("i", "", "Inventory file served as the inventory named after the file")
+	playbookDir := flags.String("playbook-dir", ".", "Directory submitted playbooks resolve relative files against")
+	tokenFile := flags.String("token-file", "", "File of USER:TOKEN lines; requests need one of the tokens as a bearer token")
+	insecureNoAuth := flags.Bool("insecure-no-auth", false, "Serve without a token file, letting anyone who can reach the address run tasks on every host")
+	vaultPassFile := flags.String("vault-password-file", "", "Vault password file or executable script")
+	redactRules := flags.String("redact-rules", "", "YAML file of redaction rules masking secrets in results, logs and events")
+	modulePath := flags.String("module-path", "", "Directories of third-party modules, separated by colons; defaults to gosible_MODULE_PATH")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s serve [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nServe the HTTP API under /api/v1: manage inventories, submit ad-hoc tasks\n")
+		fmt.Fprintf(os.Stderr, "and playbooks, and follow their status, per-host results and logs. Run events\n")
+		fmt.Fprintf(os.Stderr, "are streamed over WebSocket at /api/v1/stream, on a channel named after the run.\n")
+		fmt.Fprintf(os.Stderr, "Requests must present a token of -token-file, unless -insecure-no-auth is given.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s serve -i inventory.yml -token-file tokens\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  curl -H 'Authorization: Bearer TOKEN' -H 'Content-Type: application/json' -d '{\"inventory\": \"inventory\", \"module\": \"ping\"}' http://127.0.0.1:8080/api/v1/runs\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("serve takes no arguments")
+	}
+	if *tokenFile == "" && !*insecureNoAuth {
+		flags.Usage()
+		return fmt.Errorf("a token file is required (-token-file), or -insecure-no-auth to serve without authentication")
+	}
+	if *tokenFile != "" && *insecureNoAuth {
+		return fmt.Errorf("-token-file and -insecure-no-auth cannot be used together")
+	}
+	if err := loadModulePath(*modulePath, false); err != nil {
+		return fmt.Errorf("failed to load modules: %w", err)
+	}
+
+	redactor, err := newRedactor(*redactRules)
+	if err != nil {
+		return fmt.Errorf("failed to load redaction rules: %w", err)
+	}
+	vaults, err := vault.InitManagerFromEnv()
+	if err != nil {
+		return fmt.Errorf("failed to load vault passwords: %w", err)
+	}
+	vaults.SetSecretSink(redactor)
+	if *vaultPassFile != "" {
+		if err := vaults.AddVaultFromSource(vault.DefaultVaultIDLabel, *vaultPassFile); err != nil {
+			return fmt.Errorf("failed to load vault password: %w", err)
+		}
+	}
+
+	server := api.NewServer()
+	server.SetVaultManager(vaults)
+	server.SetRedactor(redactor)
+	server.SetPlaybookDir(*playbookDir)
+	if *inventoryFile != "" {
+		inv, err := loadInventory(*inventoryFile)
+		if err != nil {
+			return fmt.Errorf("failed to load inventory: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(*inventoryFile), filepath.Ext(*inventoryFile))
+		server.SetInventory(name, inv)
+	}
+
+	if *insecureNoAuth {
+		fmt.Fprintf(os.Stderr, "WARNING: serving the API WITHOUT AUTHENTICATION. Anyone who can reach %s can run\n", *listen)
+		fmt.Fprintf(os.Stderr, "WARNING: any module, shell included, on every host of the inventories. Use -token-file.\n")
+	} else {
+		tokens, err := loadTokens(*tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to load tokens: %w", err)
+		}
+		server.SetAuthenticator(websocket.StaticTokens(tokens))
+	}
+
+	stream := websocket.NewStreamServer()
+	stream.SetRedactor(redactor)
+	server.SetStreamServer(stream)
+	stream.Start()
+	defer stream.Stop()
+
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving the API on http://%s/api/v1\n", *listen)
+	err = httpServer.ListenAndServe()
+	server.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// loadTokens reads the USER:TOKEN lines of a token file into the tokens
+// StaticTokens accepts. Blank lines and lines starting with # are skipped.
+func loadTokens(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, token, ok := strings.Cut(line, ":")
+		if !ok || user == "" || token == "" {
+			return nil, fmt.Errorf("%s:%d: expected USER:TOKEN", filename, number)
+		}
+		tokens[token] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s holds no tokens", filename)
+	}
+	return tokens, nil
+}
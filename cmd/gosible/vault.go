@@ -0,0 +1,447 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+// vaultCommands maps each vault subcommand to its implementation and summary
+var vaultCommands = map[string]struct {
+	run     func(args []string) error
+	summary string
+}{
+	"create":         {runCreate, "Create a new encrypted file in $EDITOR"},
+	"decrypt":        {runDecrypt, "Decrypt files in place, or to --output"},
+	"edit":           {runEdit, "Edit an encrypted file in $EDITOR"},
+	"encrypt":        {runEncrypt, "Encrypt files in place, or to --output"},
+	"encrypt_string": {runEncryptString, "Encrypt a string into an inline !vault block"},
+	"rekey":          {runRekey, "Re-encrypt files with a new password or vault ID"},
+	"view":           {runView, "Print the decrypted content of files"},
+}
+
+// runVault runs a vault subcommand, the ansible-vault counterpart of
+// gosible vault COMMAND [options] [FILE...]
+func runVault(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		vaultUsage()
+		os.Exit(2)
+	}
+
+	command, ok := vaultCommands[args[0]]
+	if !ok {
+		vaultUsage()
+		return fmt.Errorf("unknown vault command %q", args[0])
+	}
+	return command.run(args[1:])
+}
+
+func vaultUsage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s vault COMMAND [options] [FILE...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range []string{"create", "decrypt", "edit", "encrypt", "encrypt_string", "rekey", "view"} {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, vaultCommands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s vault COMMAND -h' for the options of a command.\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nExamples:\n")
+	fmt.Fprintf(os.Stderr, "  # Edit secrets encrypted with the prod vault ID\n")
+	fmt.Fprintf(os.Stderr, "  %s vault edit --vault-id prod@~/.vault_prod group_vars/prod/vault.yml\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\n  # Produce an inline value to paste into a vars file\n")
+	fmt.Fprintf(os.Stderr, "  %s vault encrypt_string --vault-password-file ~/.vault_pass -n db_password 's3cr3t'\n", os.Args[0])
+}
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// vaultFlags are the password options shared by every command
+type vaultFlags struct {
+	ids           stringList
+	passwordFiles stringList
+	askPass       bool
+	encryptID     string
+}
+
+// newFlagSet creates the flags of a command with the vault password options
+func newFlagSet(name, args string, encrypts bool) (*flag.FlagSet, *vaultFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	vf := &vaultFlags{}
+	flags.Var(&vf.ids, "vault-id", "Vault identity as label@source, where source is a password file, an executable printing the password or prompt (repeatable)")
+	flags.Var(&vf.passwordFiles, "vault-password-file", "File holding the default vault password, or an executable printing it (repeatable)")
+	flags.BoolVar(&vf.askPass, "ask-vault-pass", false, "Prompt for the default vault password")
+	if encrypts {
+		flags.StringVar(&vf.encryptID, "encrypt-vault-id", "", "Vault ID to encrypt with when several are given")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s vault %s [options] %s\n\nOptions:\n", os.Args[0], name, args)
+		flags.PrintDefaults()
+	}
+	return flags, vf
+}
+
+// load builds the vault manager from ANSIBLE_VAULT_* environment variables
+// and the flags. With confirm, prompted passwords are asked twice, as they
+// are about to encrypt.
+func (vf *vaultFlags) load(confirm bool) (*vault.Manager, error) {
+	manager, err := vault.InitManagerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	identities := append([]string(nil), vf.ids...)
+	for _, file := range vf.passwordFiles {
+		identities = append(identities, vault.DefaultVaultIDLabel+"@"+file)
+	}
+	if vf.askPass {
+		identities = append(identities, vault.DefaultVaultIDLabel+"@prompt")
+	}
+	if err := addIdentities(manager, identities, confirm, ""); err != nil {
+		return nil, err
+	}
+
+	if len(manager.VaultIDs()) == 0 {
+		return nil, fmt.Errorf("a vault password is required: use --vault-id, --vault-password-file or --ask-vault-pass")
+	}
+	return manager, nil
+}
+
+// encryptVaultID picks the vault to encrypt with: --encrypt-vault-id, or
+// the only vault given
+func (vf *vaultFlags) encryptVaultID(manager *vault.Manager) (string, error) {
+	if vf.encryptID != "" {
+		if _, err := manager.GetVault(vf.encryptID); err != nil {
+			return "", err
+		}
+		return vf.encryptID, nil
+	}
+
+	ids := manager.VaultIDs()
+	if len(ids) > 1 {
+		return "", fmt.Errorf("several vault IDs were given (%s), choose one with --encrypt-vault-id", strings.Join(ids, ", "))
+	}
+	return ids[0], nil
+}
+
+// addIdentities adds label@source vault identities to a manager. A bare
+// source uses the default label and the source prompt asks for the password.
+func addIdentities(manager *vault.Manager, identities []string, confirm bool, promptPrefix string) error {
+	for _, identity := range identities {
+		label, source, found := strings.Cut(identity, "@")
+		if !found {
+			label, source = vault.DefaultVaultIDLabel, identity
+		}
+
+		if source != "prompt" {
+			if err := manager.AddVaultFromSource(label, expandHome(source)); err != nil {
+				return fmt.Errorf("vault ID %s: %w", label, err)
+			}
+			continue
+		}
+
+		prompt := promptPrefix + "Vault password"
+		if label != vault.DefaultVaultIDLabel {
+			prompt += " (" + label + ")"
+		}
+		password, err := promptPassword(prompt + ": ")
+		if err != nil {
+			return fmt.Errorf("failed to read vault password: %w", err)
+		}
+		if confirm {
+			again, err := promptPassword("Confirm " + prompt + ": ")
+			if err != nil {
+				return fmt.Errorf("failed to read vault password: %w", err)
+			}
+			if again != password {
+				return fmt.Errorf("passwords for vault ID %s do not match", label)
+			}
+		}
+		manager.AddVault(label, password)
+	}
+	return nil
+}
+
+func runCreate(args []string) error {
+	flags, vf := newFlagSet("create", "FILE", true)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("create takes exactly one file")
+	}
+
+	manager, err := vf.load(true)
+	if err != nil {
+		return err
+	}
+	vaultID, err := vf.encryptVaultID(manager)
+	if err != nil {
+		return err
+	}
+	return manager.Create(flags.Arg(0), vaultID)
+}
+
+func runEdit(args []string) error {
+	flags, vf := newFlagSet("edit", "FILE", false)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("edit takes exactly one file")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !vault.IsVaultFile(data) {
+		return fmt.Errorf("%s is not vault encrypted", flags.Arg(0))
+	}
+
+	manager, err := vf.load(false)
+	if err != nil {
+		return err
+	}
+	return manager.Edit(flags.Arg(0))
+}
+
+func runView(args []string) error {
+	flags, vf := newFlagSet("view", "FILE...", false)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("view needs at least one file")
+	}
+
+	manager, err := vf.load(false)
+	if err != nil {
+		return err
+	}
+	for _, file := range flags.Args() {
+		if err := manager.View(file, os.Stdout); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func runEncrypt(args []string) error {
+	flags, vf := newFlagSet("encrypt", "FILE...", true)
+	output := flags.String("output", "", "Write the result to this file, - for stdout, instead of in place")
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*output != "" && flags.NArg() != 1) {
+		flags.Usage()
+		return fmt.Errorf("encrypt needs one file with --output, or any number without")
+	}
+
+	manager, err := vf.load(true)
+	if err != nil {
+		return err
+	}
+	vaultID, err := vf.encryptVaultID(manager)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range flags.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if vault.IsVaultFile(data) {
+			return fmt.Errorf("%s is already vault encrypted", file)
+		}
+		encrypted, err := manager.Encrypt(data, vaultID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := writeOutput(file, *output, []byte(encrypted)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Encryption successful")
+	return nil
+}
+
+func runDecrypt(args []string) error {
+	flags, vf := newFlagSet("decrypt", "FILE...", false)
+	output := flags.String("output", "", "Write the result to this file, - for stdout, instead of in place")
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*output != "" && flags.NArg() != 1) {
+		flags.Usage()
+		return fmt.Errorf("decrypt needs one file with --output, or any number without")
+	}
+
+	manager, err := vf.load(false)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range flags.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !vault.IsVaultFile(data) {
+			return fmt.Errorf("%s is not vault encrypted", file)
+		}
+		decrypted, err := manager.Decrypt(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := writeOutput(file, *output, decrypted); err != nil {
+			return err
+		}
+	}
+	if *output != "-" {
+		fmt.Fprintln(os.Stderr, "Decryption successful")
+	}
+	return nil
+}
+
+func runRekey(args []string) error {
+	flags, vf := newFlagSet("rekey", "FILE...", false)
+	var newIDs, newPasswordFiles stringList
+	flags.Var(&newIDs, "new-vault-id", "Vault identity to re-encrypt with, as label@source")
+	flags.Var(&newPasswordFiles, "new-vault-password-file", "File holding the new default vault password")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("rekey needs at least one file")
+	}
+
+	manager, err := vf.load(false)
+	if err != nil {
+		return err
+	}
+
+	// The new password is kept apart so it may reuse the old vault ID
+	identities := append([]string(nil), newIDs...)
+	for _, file := range newPasswordFiles {
+		identities = append(identities, vault.DefaultVaultIDLabel+"@"+file)
+	}
+	if len(identities) == 0 {
+		identities = []string{vault.DefaultVaultIDLabel + "@prompt"}
+	}
+	if len(identities) > 1 {
+		return fmt.Errorf("rekey takes one new vault identity")
+	}
+	newVaults := vault.NewManager()
+	if err := addIdentities(newVaults, identities, true, "New "); err != nil {
+		return err
+	}
+	newVaultID := newVaults.VaultIDs()[0]
+
+	for _, file := range flags.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !vault.IsVaultFile(data) {
+			return fmt.Errorf("%s is not vault encrypted", file)
+		}
+		decrypted, err := manager.Decrypt(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		encrypted, err := newVaults.Encrypt(decrypted, newVaultID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := writeOutput(file, "", []byte(encrypted)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Rekey successful")
+	return nil
+}
+
+func runEncryptString(args []string) error {
+	flags, vf := newFlagSet("encrypt_string", "[STRING...]", true)
+	var names stringList
+	flags.Var(&names, "n", "Variable name for the string in the same position (repeatable)")
+	flags.Var(&names, "name", "Same as -n")
+	stdinName := flags.String("stdin-name", "", "Variable name for the string read from stdin")
+	prompt := flags.Bool("p", false, "Prompt for the string instead of reading it from the arguments or stdin")
+	flags.Parse(args)
+
+	manager, err := vf.load(true)
+	if err != nil {
+		return err
+	}
+	vaultID, err := vf.encryptVaultID(manager)
+	if err != nil {
+		return err
+	}
+
+	// Strings come from the arguments, a prompt or stdin
+	values := flags.Args()
+	switch {
+	case *prompt:
+		value, err := promptPassword("String to encrypt (hidden): ")
+		if err != nil {
+			return err
+		}
+		values, names = []string{value}, stringList{*stdinName}
+	case len(values) == 0:
+		fmt.Fprintln(os.Stderr, "Reading plaintext input from stdin (ctrl-d to end input)")
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		values, names = []string{string(data)}, stringList{*stdinName}
+	}
+	if len(names) > len(values) {
+		return fmt.Errorf("%d names given for %d strings", len(names), len(values))
+	}
+
+	for i, value := range values {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		block, err := manager.EncryptString(name, value, vaultID)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(block)
+	}
+	fmt.Fprintln(os.Stderr, "Encryption successful")
+	return nil
+}
+
+// writeOutput writes a command's result in place of file, to output or,
+// when output is -, to stdout
+func writeOutput(file, output string, data []byte) error {
+	switch output {
+	case "-":
+		_, err := os.Stdout.Write(data)
+		return err
+	case "":
+		output = file
+	}
+	return os.WriteFile(output, data, 0600)
+}
+
+// expandHome expands a leading ~/ in a password source path
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + "/" + rest
+		}
+	}
+	return path
+}
@@ -74,6 +74,8 @@ func connectionPoolExample() {
 	config := connection.DefaultConnectionPoolConfig()
 	config.MaxConnections = 5
 	config.MaxIdleTime = 2 * time.Minute
+	config.MaxSessionsPerConnection = 4 // parallel commands share one SSH handshake
+	config.KeepAliveInterval = 15 * time.Second
 
 	manager := connection.NewPooledConnectionManager(config)
 	defer manager.Close()
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/playbook"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/websocket"
+)
+
+// RunStatus is the state of a run
+type RunStatus string
+
+// Run statuses
+const (
+	RunPending   RunStatus = "pending"
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunCanceled  RunStatus = "canceled"
+)
+
+// Run kinds
+const (
+	RunAdHoc    = "adhoc"
+	RunPlaybook = "playbook"
+)
+
+// Message types published to the stream server, on the channel named
+// after the run
+const (
+	MessageTypeRun    = "run"
+	MessageTypeResult = "result"
+)
+
+// RunRequest submits an ad-hoc task or a playbook. Exactly one of Module
+// and Playbook is given.
+type RunRequest struct {
+	// Inventory names the inventory the run targets
+	Inventory string `json:"inventory"`
+	// Limit further narrows the hosts, like -limit
+	Limit string `json:"limit,omitempty"`
+	// ExtraVars are variables of the highest precedence, like -e
+	ExtraVars map[string]interface{} `json:"extra_vars,omitempty"`
+	Check     bool                   `json:"check,omitempty"`
+	Diff      bool                   `json:"diff,omitempty"`
+
+	// Ad-hoc tasks run Module with Args, a key=value string or an object,
+	// on the hosts matching Pattern, all hosts by default
+	Module  string      `json:"module,omitempty"`
+	Args    interface{} `json:"args,omitempty"`
+	Pattern string      `json:"pattern,omitempty"`
+
+	// Playbooks are given as YAML, and may use tags like -tags and
+	// -skip-tags
+	Playbook string   `json:"playbook,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	SkipTags []string `json:"skip_tags,omitempty"`
+}
+
+// Run describes a submitted run and how far it got
+type Run struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Inventory   string     `json:"inventory"`
+	Module      string     `json:"module,omitempty"`
+	Pattern     string     `json:"pattern,omitempty"`
+	Limit       string     `json:"limit,omitempty"`
+	Check       bool       `json:"check,omitempty"`
+	Status      RunStatus  `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	// Hosts counts the outcomes of the tasks on each host so far
+	Hosts map[string]HostSummary `json:"hosts"`
+}
+
+// Done reports whether the run has finished
+func (r Run) Done() bool {
+	return r.Status == RunSucceeded || r.Status == RunFailed || r.Status == RunCanceled
+}
+
+// HostSummary counts the outcomes of the tasks of a run on a host, like
+// the play recap
+type HostSummary struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+}
+
+// TaskResult is the outcome of a task on a host
+type TaskResult struct {
+	Host     string                 `json:"host"`
+	Play     string                 `json:"play,omitempty"`
+	Task     string                 `json:"task"`
+	Module   string                 `json:"module"`
+	Success  bool                   `json:"success"`
+	Changed  bool                   `json:"changed"`
+	Message  string                 `json:"message,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Diff     string                 `json:"diff,omitempty"`
+	Duration time.Duration          `json:"duration"`
+	Time     time.Time              `json:"time"`
+}
+
+// runState is a run with what it recorded, guarded by mu
+type runState struct {
+	mu      sync.Mutex
+	run     Run
+	results []TaskResult
+	log     bytes.Buffer
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// snapshot returns a copy of the run
+func (s *runState) snapshot() Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := s.run
+	run.Hosts = make(map[string]HostSummary, len(s.run.Hosts))
+	for host, summary := range s.run.Hosts {
+		run.Hosts[host] = summary
+	}
+	return run
+}
+
+// hostResults returns the results recorded so far, only those of host
+// when it is given
+func (s *runState) hostResults(host string) []TaskResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]TaskResult, 0, len(s.results))
+	for _, result := range s.results {
+		if host == "" || result.Host == host {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// logFrom returns the run's log from offset on, and the offset of its end
+func (s *runState) logFrom(offset int) ([]byte, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.log.Bytes()
+	if offset < 0 || offset > len(data) {
+		offset = len(data)
+	}
+	return append([]byte(nil), data[offset:]...), len(data)
+}
+
+// logWriter appends to the log of a run
+type logWriter struct {
+	state *runState
+}
+
+// Write appends p to the log
+func (w logWriter) Write(p []byte) (int, error) {
+	w.state.mu.Lock()
+	defer w.state.mu.Unlock()
+	return w.state.log.Write(p)
+}
+
+// recorder is the callback plugin recording the results of a run, and
+// publishing them to the run's channel of the stream server
+type recorder struct {
+	state  *runState
+	stream *websocket.StreamServer
+	inv    *inventory.StaticInventory
+
+	// plays maps each host to the play last started on it. Plays with
+	// depends_on run alongside each other, so results are attributed to
+	// the play of their host rather than the play started last.
+	plays map[string]string
+}
+
+// newRecorder creates the recorder of a run on inv
+func newRecorder(state *runState, stream *websocket.StreamServer, inv *inventory.StaticInventory) *recorder {
+	return &recorder{state: state, stream: stream, inv: inv, plays: make(map[string]string)}
+}
+
+// Name returns "api"
+func (r *recorder) Name() string {
+	return "api"
+}
+
+// Initialize sets up the plugin
+func (r *recorder) Initialize(config map[string]interface{}) error {
+	return nil
+}
+
+// SetOutput does nothing, results are kept with the run
+func (r *recorder) SetOutput(writer io.Writer) {}
+
+// OnPlayStart remembers the play the results of its hosts belong to
+func (r *recorder) OnPlayStart(play *types.Play) {
+	patterns := playbook.NewParser().ParseInventoryPattern(play.Hosts)
+	if len(patterns) == 0 {
+		return
+	}
+	hosts, err := r.inv.GetHosts(strings.Join(patterns, ","))
+	if err != nil {
+		return
+	}
+
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	for _, host := range hosts {
+		r.plays[host.Name] = play.Name
+	}
+}
+
+// OnTaskStart does nothing, runs report results
+func (r *recorder) OnTaskStart(task *types.Task, hosts []types.Host) {}
+
+// OnTaskResult records a result and counts it for its host, the way the
+// play recap does
+func (r *recorder) OnTaskResult(task *types.Task, result *types.Result) {
+	r.state.mu.Lock()
+	taskResult := TaskResult{
+		Host:     result.Host,
+		Play:     r.plays[result.Host],
+		Task:     task.Name,
+		Module:   task.Module.String(),
+		Success:  result.Success,
+		Changed:  result.Changed,
+		Message:  result.Message,
+		Data:     result.Data,
+		Duration: result.Duration,
+		Time:     result.EndTime,
+	}
+	if result.Error != nil {
+		taskResult.Error = result.Error.Error()
+	}
+	if result.Diff != nil {
+		taskResult.Diff = result.Diff.Diff
+	}
+	r.state.results = append(r.state.results, taskResult)
+
+	summary := r.state.run.Hosts[result.Host]
+	skipped, _ := result.Data["skipped"].(bool)
+	unreachable, _ := result.Data["unreachable"].(bool)
+	switch {
+	case skipped:
+		summary.Skipped++
+	case unreachable:
+		summary.Unreachable++
+	case result.Success:
+		summary.Ok++
+		if result.Changed {
+			summary.Changed++
+		}
+	default:
+		summary.Failed++
+	}
+	r.state.run.Hosts[result.Host] = summary
+	r.state.mu.Unlock()
+
+	if r.stream != nil {
+		r.stream.Publish(r.state.run.ID, websocket.StreamMessage{
+			Type:   MessageTypeResult,
+			Source: taskResult.Module,
+			Data: map[string]interface{}{
+				"host":    taskResult.Host,
+				"play":    taskResult.Play,
+				"task":    taskResult.Task,
+				"success": taskResult.Success,
+				"changed": taskResult.Changed,
+				"message": taskResult.Message,
+				"error":   taskResult.Error,
+			},
+		})
+	}
+}
+
+// OnPlayEnd does nothing, results were recorded as they came
+func (r *recorder) OnPlayEnd(play *types.Play, results []types.Result) {}
+
+// OnRunnerEnd does nothing, the server finishes the run
+func (r *recorder) OnRunnerEnd(stats *callback.RunStats) {}
+
+// runError describes why a run failed from its results when it returned
+// no error itself
+func runError(results []types.Result) error {
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d task result(s) failed", failed)
+	}
+	return nil
+}
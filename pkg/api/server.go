@@ -0,0 +1,679 @@
+// Package api serves gosible over HTTP, so that it can back a web UI or be
+// driven by other services: inventories are managed, ad-hoc tasks and
+// playbooks submitted as runs, and runs followed through their status,
+// per-host results and log, or live through the stream server.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/config"
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/logging"
+	"github.com/liliang-cn/gosible/pkg/playbook"
+	"github.com/liliang-cn/gosible/pkg/runner"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+	"github.com/liliang-cn/gosible/pkg/websocket"
+)
+
+// MaxRetainedRuns is how many runs the server keeps; the oldest finished
+// runs are dropped beyond it
+const MaxRetainedRuns = 200
+
+// maxRequestBytes bounds the bodies of requests
+const maxRequestBytes = 10 << 20
+
+// maxWait bounds how long a request may wait for a run to finish
+const maxWait = 5 * time.Minute
+
+var (
+	// ErrRunNotFound is returned for runs the server does not hold
+	ErrRunNotFound = errors.New("run not found")
+	// ErrInventoryNotFound is returned for inventories the server does
+	// not hold
+	ErrInventoryNotFound = errors.New("inventory not found")
+)
+
+// Server runs ad-hoc tasks and playbooks submitted over HTTP against the
+// inventories it holds. Each run gets its own task runner and callbacks,
+// and its results are published to the run's channel of the stream server
+// when one is set.
+type Server struct {
+	mu          sync.RWMutex
+	inventories map[string]*inventory.StaticInventory
+	runs        map[string]*runState
+	order       []string
+	seq         int
+
+	config        *config.Config
+	vaults        *vault.Manager
+	redactor      *logging.Redactor
+	stream        *websocket.StreamServer
+	authenticator websocket.Authenticator
+	playbookDir   string
+}
+
+// NewServer creates an API server without inventories, configured by
+// config.NewConfig
+func NewServer() *Server {
+	return &Server{
+		inventories: make(map[string]*inventory.StaticInventory),
+		runs:        make(map[string]*runState),
+		config:      config.NewConfig(),
+		playbookDir: ".",
+	}
+}
+
+// SetConfig configures the task runners of the runs
+func (s *Server) SetConfig(cfg *config.Config) {
+	s.config = cfg
+}
+
+// SetVaultManager decrypts the vault encrypted playbooks, variables and
+// files of the runs
+func (s *Server) SetVaultManager(vaults *vault.Manager) {
+	s.vaults = vaults
+}
+
+// SetRedactor masks secrets in the results and logs of the runs
+func (s *Server) SetRedactor(redactor *logging.Redactor) {
+	s.redactor = redactor
+}
+
+// SetStreamServer publishes the status changes and results of each run to
+// the channel of stream named after the run's ID, and serves stream at
+// /api/v1/stream
+func (s *Server) SetStreamServer(stream *websocket.StreamServer) {
+	s.stream = stream
+	if s.authenticator != nil {
+		stream.SetAuthenticator(s.authenticator)
+	}
+}
+
+// SetAuthenticator requires requests to present a bearer token the
+// authenticator accepts, and the stream server to, if set
+func (s *Server) SetAuthenticator(authenticator websocket.Authenticator) {
+	s.authenticator = authenticator
+	if s.stream != nil {
+		s.stream.SetAuthenticator(authenticator)
+	}
+}
+
+// SetPlaybookDir sets the directory submitted playbooks are relative to,
+// where their included files and roles are found
+func (s *Server) SetPlaybookDir(dir string) {
+	s.playbookDir = dir
+}
+
+// SetInventory adds the inventory name, replacing any of that name. Runs
+// in progress keep the inventory they started with.
+func (s *Server) SetInventory(name string, inv *inventory.StaticInventory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inventories[name] = inv
+}
+
+// RemoveInventory removes the inventory name
+func (s *Server) RemoveInventory(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.inventories[name]; !ok {
+		return ErrInventoryNotFound
+	}
+	delete(s.inventories, name)
+	return nil
+}
+
+// inventory returns the inventory name
+func (s *Server) inventory(name string) (*inventory.StaticInventory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inv, ok := s.inventories[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInventoryNotFound, name)
+	}
+	return inv, nil
+}
+
+// Submit validates a request and starts its run, returning it as
+// submitted
+func (s *Server) Submit(req RunRequest) (Run, error) {
+	if (req.Module == "") == (req.Playbook == "") {
+		return Run{}, fmt.Errorf("a run takes either a module or a playbook")
+	}
+	if req.Inventory == "" {
+		return Run{}, fmt.Errorf("a run takes an inventory")
+	}
+	inv, err := s.inventory(req.Inventory)
+	if err != nil {
+		return Run{}, err
+	}
+
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("run_%d_%d", time.Now().Unix(), s.seq)
+	s.mu.Unlock()
+
+	run := Run{
+		ID:          id,
+		Inventory:   req.Inventory,
+		Limit:       req.Limit,
+		Check:       req.Check,
+		Status:      RunPending,
+		SubmittedAt: time.Now(),
+		Hosts:       make(map[string]HostSummary),
+	}
+
+	// Problems with the request are reported now rather than as failed runs
+	var start func(ctx context.Context, taskRunner *runner.TaskRunner, callbacks *callback.CallbackManager, vars map[string]interface{}) error
+	if req.Module != "" {
+		run.Kind = RunAdHoc
+		run.Module = req.Module
+		run.Pattern = req.Pattern
+		if run.Pattern == "" {
+			run.Pattern = "all"
+		}
+		task, hosts, err := adHocTask(inv, req, run.Pattern)
+		if err != nil {
+			return Run{}, err
+		}
+		start = func(ctx context.Context, taskRunner *runner.TaskRunner, callbacks *callback.CallbackManager, vars map[string]interface{}) error {
+			taskRunner.SetCallbacks(callbacks)
+			results, err := taskRunner.Run(ctx, task, hosts, vars)
+			if err != nil {
+				return err
+			}
+			return runError(results)
+		}
+	} else {
+		run.Kind = RunPlaybook
+		source := filepath.Join(s.playbookDir, id+".yml")
+		parser := playbook.NewParser()
+		parser.SetVaultManager(s.vaults)
+		pb, err := parser.Parse([]byte(req.Playbook), source)
+		if err != nil {
+			return Run{}, fmt.Errorf("invalid playbook: %w", err)
+		}
+		tags := playbook.TagSelection{Tags: req.Tags, SkipTags: req.SkipTags}
+		start = func(ctx context.Context, taskRunner *runner.TaskRunner, callbacks *callback.CallbackManager, vars map[string]interface{}) error {
+			executor := playbook.NewExecutor(taskRunner, inv, nil)
+			executor.SetCallbacks(callbacks)
+			executor.SetVaultManager(s.vaults)
+			executor.SetTags(tags)
+			executor.SetLimit(req.Limit)
+			executor.SetRolesPath(filepath.Join(s.playbookDir, "roles"), "/etc/ansible/roles")
+			executor.SetIncludePath(s.playbookDir)
+			results, err := executor.Execute(ctx, pb, vars)
+			if err != nil {
+				return err
+			}
+			return runError(results)
+		}
+	}
+
+	vars := make(map[string]interface{}, len(req.ExtraVars)+2)
+	for key, value := range req.ExtraVars {
+		vars[key] = value
+	}
+	vars["ansible_check_mode"] = req.Check
+	vars["ansible_diff_mode"] = req.Diff
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &runState{run: run, cancel: cancel, done: make(chan struct{})}
+	s.mu.Lock()
+	s.runs[id] = state
+	s.order = append(s.order, id)
+	s.pruneRuns()
+	s.mu.Unlock()
+
+	go s.execute(ctx, state, inv, vars, start)
+	return state.snapshot(), nil
+}
+
+// adHocTask builds the task of an ad-hoc request and finds its hosts
+func adHocTask(inv *inventory.StaticInventory, req RunRequest, pattern string) (types.Task, []types.Host, error) {
+	var args map[string]interface{}
+	switch value := req.Args.(type) {
+	case nil:
+		args = map[string]interface{}{}
+	case string:
+		args = types.ParseFreeFormArgs(types.ModuleType(req.Module), value)
+	case map[string]interface{}:
+		args = value
+	default:
+		return types.Task{}, nil, fmt.Errorf("args must be a string or an object")
+	}
+
+	hosts, err := inv.GetHosts(pattern)
+	if err != nil {
+		return types.Task{}, nil, fmt.Errorf("failed to get hosts: %w", err)
+	}
+	if req.Limit != "" {
+		limited, err := inv.GetHosts(req.Limit)
+		if err != nil {
+			return types.Task{}, nil, fmt.Errorf("invalid limit %q: %w", req.Limit, err)
+		}
+		allowed := make(map[string]bool, len(limited))
+		for _, host := range limited {
+			allowed[host.Name] = true
+		}
+		var matched []types.Host
+		for _, host := range hosts {
+			if allowed[host.Name] {
+				matched = append(matched, host)
+			}
+		}
+		hosts = matched
+	}
+	if len(hosts) == 0 {
+		return types.Task{}, nil, fmt.Errorf("no hosts matched pattern: %s", pattern)
+	}
+
+	task := types.Task{
+		Name:   fmt.Sprintf("Ad-hoc: %s", req.Module),
+		Module: types.ModuleType(req.Module),
+		Args:   args,
+	}
+	return task, hosts, nil
+}
+
+// execute carries out a run, recording its log and results
+func (s *Server) execute(ctx context.Context, state *runState, inv *inventory.StaticInventory, vars map[string]interface{}, start func(context.Context, *runner.TaskRunner, *callback.CallbackManager, map[string]interface{}) error) {
+	defer close(state.done)
+	defer state.cancel()
+
+	now := time.Now()
+	state.mu.Lock()
+	state.run.Status = RunRunning
+	state.run.StartedAt = &now
+	state.mu.Unlock()
+	s.publishRun(state)
+
+	log := callback.NewDefaultCallback()
+	log.SetOutput(logWriter{state: state})
+	callbacks := callback.NewCallbackManager()
+	callbacks.SetRedactor(s.redactor)
+	callbacks.Register(log)
+	callbacks.Register(newRecorder(state, s.stream, inv))
+
+	taskRunner := runner.NewTaskRunner()
+	taskRunner.Configure(s.config)
+	taskRunner.SetVaultManager(s.vaults)
+	taskRunner.SetInventory(inv)
+
+	err := start(ctx, taskRunner, callbacks, vars)
+	callbacks.OnRunnerEnd()
+
+	finished := time.Now()
+	state.mu.Lock()
+	state.run.FinishedAt = &finished
+	switch {
+	case ctx.Err() != nil:
+		state.run.Status = RunCanceled
+		state.run.Error = "canceled"
+	case err != nil:
+		state.run.Status = RunFailed
+		state.run.Error = s.redactor.String(err.Error())
+	default:
+		state.run.Status = RunSucceeded
+	}
+	state.mu.Unlock()
+	s.publishRun(state)
+}
+
+// publishRun publishes the status of a run to its channel
+func (s *Server) publishRun(state *runState) {
+	if s.stream == nil {
+		return
+	}
+	run := state.snapshot()
+	s.stream.Publish(run.ID, websocket.StreamMessage{
+		Type:   MessageTypeRun,
+		Source: run.Kind,
+		Data: map[string]interface{}{
+			"id":     run.ID,
+			"status": string(run.Status),
+			"error":  run.Error,
+		},
+	})
+}
+
+// pruneRuns drops the oldest finished runs beyond MaxRetainedRuns. The
+// caller holds s.mu.
+func (s *Server) pruneRuns() {
+	excess := len(s.order) - MaxRetainedRuns
+	if excess <= 0 {
+		return
+	}
+	kept := s.order[:0]
+	for _, id := range s.order {
+		if excess > 0 && s.runs[id].snapshot().Done() {
+			delete(s.runs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// run returns the state of the run id
+func (s *Server) run(id string) (*runState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.runs[id]
+	if !ok {
+		return nil, ErrRunNotFound
+	}
+	return state, nil
+}
+
+// Runs returns the runs the server holds, in the order they were
+// submitted
+func (s *Server) Runs() []Run {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runs := make([]Run, 0, len(s.order))
+	for _, id := range s.order {
+		runs = append(runs, s.runs[id].snapshot())
+	}
+	return runs
+}
+
+// Wait waits for the run id to finish, or ctx to be done, and returns it
+func (s *Server) Wait(ctx context.Context, id string) (Run, error) {
+	state, err := s.run(id)
+	if err != nil {
+		return Run{}, err
+	}
+	select {
+	case <-state.done:
+	case <-ctx.Done():
+	}
+	return state.snapshot(), nil
+}
+
+// Cancel cancels the run id. Runs that already finished are left as they
+// are.
+func (s *Server) Cancel(id string) error {
+	state, err := s.run(id)
+	if err != nil {
+		return err
+	}
+	state.cancel()
+	return nil
+}
+
+// Close cancels the runs in progress and waits for them to stop
+func (s *Server) Close() {
+	s.mu.RLock()
+	states := make([]*runState, 0, len(s.runs))
+	for _, state := range s.runs {
+		states = append(states, state)
+	}
+	s.mu.RUnlock()
+
+	for _, state := range states {
+		state.cancel()
+		<-state.done
+	}
+}
+
+// Handler returns the HTTP handler of the API:
+//
+//	GET    /api/v1/inventories             list inventories
+//	GET    /api/v1/inventories/{name}      show an inventory's hosts and groups
+//	PUT    /api/v1/inventories/{name}      create or replace an inventory from YAML
+//	DELETE /api/v1/inventories/{name}      remove an inventory
+//	GET    /api/v1/runs                    list runs
+//	POST   /api/v1/runs                    submit a RunRequest
+//	GET    /api/v1/runs/{id}               show a run, waiting up to ?wait= for it to finish
+//	GET    /api/v1/runs/{id}/results       list a run's results, of ?host= only
+//	GET    /api/v1/runs/{id}/log           read a run's log, from byte ?offset=
+//	POST   /api/v1/runs/{id}/cancel        cancel a run
+//	GET    /api/v1/stream                  follow runs over WebSocket, with ?channel=ID
+//
+// Runs are submitted as application/json. Without an authenticator,
+// browsers may only change the server from its own origin.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/inventories", s.handleListInventories)
+	mux.HandleFunc("GET /api/v1/inventories/{name}", s.handleGetInventory)
+	mux.HandleFunc("PUT /api/v1/inventories/{name}", s.handlePutInventory)
+	mux.HandleFunc("DELETE /api/v1/inventories/{name}", s.handleDeleteInventory)
+	mux.HandleFunc("GET /api/v1/runs", s.handleListRuns)
+	mux.HandleFunc("POST /api/v1/runs", s.handleSubmitRun)
+	mux.HandleFunc("GET /api/v1/runs/{id}", s.handleGetRun)
+	mux.HandleFunc("GET /api/v1/runs/{id}/results", s.handleRunResults)
+	mux.HandleFunc("GET /api/v1/runs/{id}/log", s.handleRunLog)
+	mux.HandleFunc("POST /api/v1/runs/{id}/cancel", s.handleCancelRun)
+
+	// Without authentication, any page the operator visits could change
+	// the server, so browsers may only do so from its own origin
+	sameOrigin := http.NewCrossOriginProtection().Handler(mux)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The stream server authenticates its clients itself, since
+		// browsers cannot set headers on WebSocket requests
+		if r.URL.Path == "/api/v1/stream" {
+			if s.stream == nil {
+				writeError(w, http.StatusNotFound, errors.New("no stream server"))
+				return
+			}
+			s.stream.HandleWebSocket(w, r)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		if s.authenticator == nil {
+			sameOrigin.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := s.authenticator(bearerToken(r)); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// inventorySummary is how an inventory is shown
+type inventorySummary struct {
+	Name   string              `json:"name"`
+	Hosts  []string            `json:"hosts"`
+	Groups map[string][]string `json:"groups,omitempty"`
+}
+
+// summarizeInventory lists the hosts of an inventory, and of its groups
+func summarizeInventory(name string, inv *inventory.StaticInventory) inventorySummary {
+	summary := inventorySummary{Name: name, Hosts: inv.HostNames("all"), Groups: make(map[string][]string)}
+	sort.Strings(summary.Hosts)
+	if groups, err := inv.GetGroups(); err == nil {
+		for _, group := range groups {
+			hosts := inv.HostNames(group.Name)
+			sort.Strings(hosts)
+			summary.Groups[group.Name] = hosts
+		}
+	}
+	return summary
+}
+
+func (s *Server) handleListInventories(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	summaries := make([]inventorySummary, 0, len(s.inventories))
+	for name, inv := range s.inventories {
+		summaries = append(summaries, summarizeInventory(name, inv))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) handleGetInventory(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	inv, err := s.inventory(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeInventory(name, inv))
+}
+
+func (s *Server) handlePutInventory(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	inv, err := inventory.NewFromYAML(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid inventory: %w", err))
+		return
+	}
+
+	name := r.PathValue("name")
+	s.SetInventory(name, inv)
+	writeJSON(w, http.StatusOK, summarizeInventory(name, inv))
+}
+
+func (s *Server) handleDeleteInventory(w http.ResponseWriter, r *http.Request) {
+	if err := s.RemoveInventory(r.PathValue("name")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	runs := s.Runs()
+	if status := r.URL.Query().Get("status"); status != "" {
+		filtered := runs[:0]
+		for _, run := range runs {
+			if string(run.Status) == status {
+				filtered = append(filtered, run)
+			}
+		}
+		runs = filtered
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) handleSubmitRun(w http.ResponseWriter, r *http.Request) {
+	// Browsers send forms and text/plain across origins without asking,
+	// so only JSON is taken
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("runs must be submitted as application/json"))
+		return
+	}
+
+	var req RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	run, err := s.Submit(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	state, err := s.run(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid wait: %w", err))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), min(timeout, maxWait))
+		defer cancel()
+		s.Wait(ctx, id)
+	}
+	writeJSON(w, http.StatusOK, state.snapshot())
+}
+
+func (s *Server) handleRunResults(w http.ResponseWriter, r *http.Request) {
+	state, err := s.run(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state.hostResults(r.URL.Query().Get("host")))
+}
+
+func (s *Server) handleRunLog(w http.ResponseWriter, r *http.Request) {
+	state, err := s.run(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %w", err))
+			return
+		}
+	}
+
+	// The offset of the end lets clients poll for what comes next
+	data, end := state.logFrom(offset)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Log-Offset", strconv.Itoa(end))
+	w.Write(data)
+}
+
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.Cancel(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	state, _ := s.run(id)
+	writeJSON(w, http.StatusAccepted, state.snapshot())
+}
+
+// bearerToken returns the bearer token of the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// writeJSON writes value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
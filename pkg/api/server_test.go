@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/liliang-cn/gosible/pkg/websocket"
+)
+
+const localInventory = `
+all:
+  hosts:
+    localhost:
+      ansible_connection: local
+`
+
+// apiClient calls a test server
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+	token  string
+}
+
+// do sends a request, decoding the JSON response into out, and returns
+// the status code
+func (c *apiClient) do(method, path, body string, out interface{}) int {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if method == "POST" && body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if out != nil {
+		if s, ok := out.(*string); ok {
+			*s = buf.String()
+		} else if err := json.Unmarshal(buf.Bytes(), out); err != nil {
+			c.t.Fatalf("%s %s gave invalid JSON %q: %v", method, path, buf.String(), err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// newTestServer starts an API server holding the local inventory
+func newTestServer(t *testing.T) (*Server, *apiClient) {
+	t.Helper()
+	server := NewServer()
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+
+	client := &apiClient{t: t, server: httpServer}
+	if status := client.do("PUT", "/api/v1/inventories/local", localInventory, nil); status != http.StatusOK {
+		t.Fatalf("Expected the inventory to be stored, got %d", status)
+	}
+	return server, client
+}
+
+// submit submits a run and waits for it to finish
+func (c *apiClient) submit(req RunRequest) Run {
+	c.t.Helper()
+	body, _ := json.Marshal(req)
+	var run Run
+	if status := c.do("POST", "/api/v1/runs", string(body), &run); status != http.StatusAccepted {
+		c.t.Fatalf("Expected the run to be accepted, got %d", status)
+	}
+	if status := c.do("GET", "/api/v1/runs/"+run.ID+"?wait=10s", "", &run); status != http.StatusOK {
+		c.t.Fatalf("Expected the run, got %d", status)
+	}
+	return run
+}
+
+func TestServer_Inventories(t *testing.T) {
+	_, client := newTestServer(t)
+
+	var inventories []inventorySummary
+	client.do("GET", "/api/v1/inventories", "", &inventories)
+	if len(inventories) != 1 || inventories[0].Name != "local" || len(inventories[0].Hosts) != 1 || inventories[0].Hosts[0] != "localhost" {
+		t.Errorf("Unexpected inventories %+v", inventories)
+	}
+
+	if status := client.do("PUT", "/api/v1/inventories/bad", "all: [", nil); status != http.StatusBadRequest {
+		t.Errorf("Expected invalid YAML to be refused, got %d", status)
+	}
+	if status := client.do("DELETE", "/api/v1/inventories/local", "", nil); status != http.StatusNoContent {
+		t.Errorf("Expected the inventory to be removed, got %d", status)
+	}
+	if status := client.do("GET", "/api/v1/inventories/local", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected the inventory to be gone, got %d", status)
+	}
+}
+
+func TestServer_AdHocRun(t *testing.T) {
+	_, client := newTestServer(t)
+
+	run := client.submit(RunRequest{Inventory: "local", Module: "command", Args: "echo hello"})
+	if run.Status != RunSucceeded || run.Kind != RunAdHoc || run.FinishedAt == nil {
+		t.Fatalf("Expected the run to succeed, got %+v", run)
+	}
+	if summary := run.Hosts["localhost"]; summary.Ok != 1 || summary.Changed != 1 {
+		t.Errorf("Unexpected host summary %+v", summary)
+	}
+
+	var results []TaskResult
+	client.do("GET", "/api/v1/runs/"+run.ID+"/results?host=localhost", "", &results)
+	if len(results) != 1 || !results[0].Success || results[0].Data["stdout"] != "hello\n" {
+		t.Errorf("Unexpected results %+v", results)
+	}
+
+	var log string
+	client.do("GET", "/api/v1/runs/"+run.ID+"/log", "", &log)
+	if !strings.Contains(log, "changed: [localhost]") {
+		t.Errorf("Expected the log to report the result, got %q", log)
+	}
+
+	var runs []Run
+	client.do("GET", "/api/v1/runs?status=succeeded", "", &runs)
+	if len(runs) != 1 || runs[0].ID != run.ID {
+		t.Errorf("Expected the run to be listed, got %+v", runs)
+	}
+}
+
+func TestServer_PlaybookRun(t *testing.T) {
+	_, client := newTestServer(t)
+
+	playbook := `
+- hosts: all
+  gather_facts: false
+  tasks:
+    - name: Say hello
+      debug:
+        msg: "hello {{ who }}"
+    - name: Fail
+      command: /bin/false
+`
+	run := client.submit(RunRequest{Inventory: "local", Playbook: playbook, ExtraVars: map[string]interface{}{"who": "api"}})
+	if run.Status != RunFailed || run.Error == "" {
+		t.Fatalf("Expected the run to fail, got %+v", run)
+	}
+	if summary := run.Hosts["localhost"]; summary.Failed != 1 {
+		t.Errorf("Unexpected host summary %+v", summary)
+	}
+
+	var results []TaskResult
+	client.do("GET", "/api/v1/runs/"+run.ID+"/results", "", &results)
+	outcomes := make(map[string]TaskResult)
+	for _, result := range results {
+		outcomes[result.Task] = result
+	}
+	if hello := outcomes["Say hello"]; !hello.Success || hello.Message != "hello api" {
+		t.Errorf("Unexpected result of Say hello: %s", hello.Message)
+	}
+	if fail, ok := outcomes["Fail"]; !ok || fail.Success {
+		t.Errorf("Expected Fail to fail")
+	}
+}
+
+func TestServer_ConcurrentPlays(t *testing.T) {
+	_, client := newTestServer(t)
+	inventory := `
+all:
+  hosts:
+    web:
+      ansible_connection: local
+    db:
+      ansible_connection: local
+`
+	if status := client.do("PUT", "/api/v1/inventories/pair", inventory, nil); status != http.StatusOK {
+		t.Fatalf("Expected the inventory to be stored, got %d", status)
+	}
+
+	// Web and DB run alongside each other, Smoke after both
+	playbook := `
+- name: Web
+  hosts: web
+  gather_facts: false
+  tasks:
+    - name: web task
+      command: sleep 0.2
+- name: DB
+  hosts: db
+  gather_facts: false
+  tasks:
+    - name: db task
+      command: sleep 0.2
+- name: Smoke
+  hosts: all
+  gather_facts: false
+  depends_on: [Web, DB]
+  tasks:
+    - name: smoke task
+      command: "true"
+`
+	run := client.submit(RunRequest{Inventory: "pair", Playbook: playbook})
+	if run.Status != RunSucceeded {
+		t.Fatalf("Expected the run to succeed, got %+v", run)
+	}
+
+	var results []TaskResult
+	client.do("GET", "/api/v1/runs/"+run.ID+"/results", "", &results)
+	expected := map[string]string{"web task": "Web", "db task": "DB", "smoke task": "Smoke"}
+	matched := 0
+	for _, result := range results {
+		if play, ok := expected[result.Task]; ok {
+			matched++
+			if result.Play != play {
+				t.Errorf("Expected %s on %s to belong to %s, got %q", result.Task, result.Host, play, result.Play)
+			}
+		}
+	}
+	if matched != 4 {
+		t.Errorf("Expected a result of each task on each of its hosts, got %+v", results)
+	}
+}
+
+func TestServer_InvalidRuns(t *testing.T) {
+	_, client := newTestServer(t)
+
+	for name, body := range map[string]string{
+		"json":      "{",
+		"neither":   `{"inventory": "local"}`,
+		"both":      `{"inventory": "local", "module": "ping", "playbook": "- hosts: all"}`,
+		"inventory": `{"inventory": "missing", "module": "ping"}`,
+		"hosts":     `{"inventory": "local", "module": "ping", "pattern": "web"}`,
+		"playbook":  `{"inventory": "local", "playbook": "not: [a playbook"}`,
+	} {
+		if status := client.do("POST", "/api/v1/runs", body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected the run to be refused, got %d", name, status)
+		}
+	}
+	if status := client.do("GET", "/api/v1/runs/missing", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown run to be not found, got %d", status)
+	}
+}
+
+func TestServer_CancelRun(t *testing.T) {
+	server, client := newTestServer(t)
+
+	run, err := server.Submit(RunRequest{Inventory: "local", Module: "command", Args: "sleep 30"})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if status := client.do("POST", "/api/v1/runs/"+run.ID+"/cancel", "", nil); status != http.StatusAccepted {
+		t.Fatalf("Expected the run to be canceled, got %d", status)
+	}
+
+	start := time.Now()
+	client.do("GET", "/api/v1/runs/"+run.ID+"?wait=10s", "", &run)
+	if run.Status != RunCanceled {
+		t.Errorf("Expected the run to be canceled, got %+v", run)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected the run to stop when canceled")
+	}
+}
+
+func TestServer_Authentication(t *testing.T) {
+	server, client := newTestServer(t)
+	server.SetAuthenticator(websocket.StaticTokens(map[string]string{"t0ken": "ci"}))
+
+	if status := client.do("GET", "/api/v1/runs", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected the request to be refused, got %d", status)
+	}
+	client.token = "t0ken"
+	if status := client.do("GET", "/api/v1/runs", "", nil); status != http.StatusOK {
+		t.Errorf("Expected the request to be accepted, got %d", status)
+	}
+}
+
+func TestServer_CrossOrigin(t *testing.T) {
+	server, client := newTestServer(t)
+	stream := websocket.NewStreamServer()
+	stream.Start()
+	defer stream.Stop()
+	server.SetStreamServer(stream)
+
+	post := func(contentType string, header http.Header) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", client.server.URL+"/api/v1/runs", strings.NewReader(`{"inventory": "local", "module": "ping"}`))
+		req.Header = header
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A page submitting a form or text/plain cannot start a run
+	if status := post("text/plain", http.Header{}); status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected text/plain to be refused, got %d", status)
+	}
+	if status := post("application/json; charset=utf-8", http.Header{}); status != http.StatusAccepted {
+		t.Errorf("Expected JSON to be accepted, got %d", status)
+	}
+
+	// Without authentication, browsers may only change the server from
+	// its own origin
+	crossSite := http.Header{"Origin": {"https://evil.example"}, "Sec-Fetch-Site": {"cross-site"}}
+	if status := post("application/json", crossSite); status != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin request to be refused, got %d", status)
+	}
+	wsURL := "ws" + strings.TrimPrefix(client.server.URL, "http") + "/api/v1/stream"
+	if _, resp, err := gorilla.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example"}}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin stream client to be refused, got %v", err)
+	}
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL, http.Header{"Origin": {client.server.URL}})
+	if err != nil {
+		t.Fatalf("Expected a same-origin stream client to connect: %v", err)
+	}
+	conn.Close()
+
+	// With authentication, the token is what counts
+	server.SetAuthenticator(websocket.StaticTokens(map[string]string{"t0ken": "ci"}))
+	crossSite.Set("Authorization", "Bearer t0ken")
+	if status := post("application/json", crossSite); status != http.StatusAccepted {
+		t.Errorf("Expected an authenticated cross-origin request to be accepted, got %d", status)
+	}
+	conn, _, err = gorilla.DefaultDialer.Dial(wsURL+"?token=t0ken", http.Header{"Origin": {"https://ui.example"}})
+	if err != nil {
+		t.Fatalf("Expected an authenticated stream client to connect: %v", err)
+	}
+	conn.Close()
+}
+
+func TestServer_Stream(t *testing.T) {
+	server, client := newTestServer(t)
+	stream := websocket.NewStreamServer()
+	stream.Start()
+	defer stream.Stop()
+	server.SetStreamServer(stream)
+
+	run := client.submit(RunRequest{Inventory: "local", Module: "command", Args: "echo hello"})
+
+	// The stream replays the run's messages to a client connecting late
+	wsURL := "ws" + strings.TrimPrefix(client.server.URL, "http") + "/api/v1/stream?channel=" + run.ID
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to the stream: %v", err)
+	}
+	defer conn.Close()
+
+	var received []string
+	for len(received) < 3 {
+		var message websocket.StreamMessage
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read the stream: %v", err)
+		}
+		if message.Type == websocket.MessageTypeConnection {
+			continue
+		}
+		if message.Channel != run.ID || !message.Replay {
+			t.Errorf("Expected replayed messages of %s, got %+v", run.ID, message)
+		}
+		status, _ := message.Data["status"].(string)
+		received = append(received, message.Type+":"+status)
+	}
+	expected := "run:running,result:,run:succeeded"
+	if strings.Join(received, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, received)
+	}
+}
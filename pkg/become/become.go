@@ -0,0 +1,299 @@
+// Package become implements privilege escalation plugins. Connections wrap
+// every command through a Manager so that sudo, su, doas and runas behave
+// the same regardless of transport.
+package become
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+// Command is a command line wrapped for privilege escalation
+type Command struct {
+	Line  string // Command line to execute
+	Stdin string // Data the connection must write to stdin, e.g. the password
+	TTY   bool   // The connection must run the command in a pseudo-terminal
+}
+
+// Plugin wraps commands to run as another user
+type Plugin interface {
+	// Name returns the become method name
+	Name() string
+	// Wrap returns the command line that runs command as config.User
+	Wrap(command string, config types.BecomeConfig) (Command, error)
+}
+
+// Manager manages become plugins
+type Manager struct {
+	plugins map[string]Plugin
+	mu      sync.RWMutex
+}
+
+// NewManager creates a manager with the built-in plugins registered
+func NewManager() *Manager {
+	m := &Manager{
+		plugins: make(map[string]Plugin),
+	}
+
+	// Register built-in plugins
+	m.Register(&SudoPlugin{})
+	m.Register(&SuPlugin{})
+	m.Register(&DoasPlugin{})
+	m.Register(&RunasPlugin{})
+
+	return m
+}
+
+// DefaultManager is used by the built-in connections
+var DefaultManager = NewManager()
+
+// Register adds a become plugin, replacing any plugin with the same name
+func (m *Manager) Register(plugin Plugin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plugins[plugin.Name()] = plugin
+}
+
+// Get returns a become plugin by name
+func (m *Manager) Get(name string) (Plugin, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	plugin, exists := m.plugins[name]
+	if !exists {
+		return nil, fmt.Errorf("become method '%s' not found", name)
+	}
+
+	return plugin, nil
+}
+
+// Wrap applies the become settings of options to command. Commands without
+// privilege escalation are returned unchanged.
+func (m *Manager) Wrap(command string, options types.ExecuteOptions) (Command, error) {
+	config := FromOptions(options)
+	if config == nil {
+		return Command{Line: command}, nil
+	}
+
+	method := config.Method
+	if method == "" {
+		method = "sudo"
+	}
+	plugin, err := m.Get(method)
+	if err != nil {
+		return Command{}, err
+	}
+	return plugin.Wrap(command, *config)
+}
+
+// FromOptions returns the become configuration for options. The legacy
+// Sudo and User fields map to the sudo and su methods.
+func FromOptions(options types.ExecuteOptions) *types.BecomeConfig {
+	if options.Become != nil {
+		return options.Become
+	}
+	if options.User == "" {
+		return nil
+	}
+	if options.Sudo {
+		return &types.BecomeConfig{Method: "sudo", User: options.User}
+	}
+	return &types.BecomeConfig{Method: "su", User: options.User}
+}
+
+// FromVars builds a become configuration from ansible_become* variables.
+// It returns nil unless ansible_become is true. Vault encrypted passwords
+// are decrypted with vaults, which may be nil.
+func FromVars(vars map[string]interface{}, vaults *vault.Manager) (*types.BecomeConfig, error) {
+	if !types.ConvertToBool(vars["ansible_become"]) {
+		return nil, nil
+	}
+
+	config := &types.BecomeConfig{
+		Method: stringVar(vars, "ansible_become_method"),
+		User:   stringVar(vars, "ansible_become_user"),
+		Flags:  stringVar(vars, "ansible_become_flags"),
+		Exe:    stringVar(vars, "ansible_become_exe"),
+	}
+
+	config.Password = stringVar(vars, "ansible_become_password")
+	if config.Password == "" {
+		config.Password = stringVar(vars, "ansible_become_pass")
+	}
+	if vault.IsVaultString(config.Password) {
+		if vaults == nil {
+			return nil, fmt.Errorf("become password is vault encrypted but no vault password was provided")
+		}
+		decrypted := map[string]interface{}{"password": config.Password}
+		if err := vaults.ProcessVariables(decrypted); err != nil {
+			return nil, fmt.Errorf("failed to decrypt become password: %w", err)
+		}
+		config.Password = decrypted["password"].(string)
+	}
+
+	return config, nil
+}
+
+func stringVar(vars map[string]interface{}, name string) string {
+	if value, ok := vars[name]; ok && value != nil {
+		return types.ConvertToString(value)
+	}
+	return ""
+}
+
+// Quote quotes s as a single POSIX shell word
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func userOrDefault(user, fallback string) string {
+	if user == "" {
+		return fallback
+	}
+	return user
+}
+
+func exeOrDefault(exe, fallback string) string {
+	if exe == "" {
+		return fallback
+	}
+	return exe
+}
+
+// SudoPlugin runs commands with sudo. A password is written to sudo's
+// stdin (-S) so it never appears on a command line.
+type SudoPlugin struct{}
+
+// Name implements Plugin
+func (p *SudoPlugin) Name() string { return "sudo" }
+
+// Wrap implements Plugin
+func (p *SudoPlugin) Wrap(command string, config types.BecomeConfig) (Command, error) {
+	flags := config.Flags
+	if flags == "" {
+		flags = "-H -S -n"
+	}
+
+	parts := []string{exeOrDefault(config.Exe, "sudo")}
+	stdin := ""
+	if config.Password != "" {
+		// -n would make sudo fail instead of reading the password
+		for _, flag := range strings.Fields(flags) {
+			if flag != "-n" {
+				parts = append(parts, flag)
+			}
+		}
+		if !strings.Contains(flags, "-S") {
+			parts = append(parts, "-S")
+		}
+		parts = append(parts, "-p", "''")
+		stdin = config.Password + "\n"
+	} else {
+		parts = append(parts, strings.Fields(flags)...)
+	}
+
+	parts = append(parts, "-u", Quote(userOrDefault(config.User, "root")), "sh", "-c", Quote(command))
+	return Command{Line: strings.Join(parts, " "), Stdin: stdin, TTY: config.RequireTTY}, nil
+}
+
+// SuPlugin runs commands with su. su only reads passwords from a terminal,
+// so it is limited to connections that are already privileged.
+type SuPlugin struct{}
+
+// Name implements Plugin
+func (p *SuPlugin) Name() string { return "su" }
+
+// Wrap implements Plugin
+func (p *SuPlugin) Wrap(command string, config types.BecomeConfig) (Command, error) {
+	if config.Password != "" {
+		return Command{}, fmt.Errorf("become method su cannot send a password without a terminal; use sudo or connect as root")
+	}
+
+	parts := []string{exeOrDefault(config.Exe, "su")}
+	parts = append(parts, strings.Fields(config.Flags)...)
+	parts = append(parts, Quote(userOrDefault(config.User, "root")), "-c", Quote(command))
+	return Command{Line: strings.Join(parts, " ")}, nil
+}
+
+// DoasPlugin runs commands with OpenBSD's doas, which requires a nopass rule
+// for non-interactive use.
+type DoasPlugin struct{}
+
+// Name implements Plugin
+func (p *DoasPlugin) Name() string { return "doas" }
+
+// Wrap implements Plugin
+func (p *DoasPlugin) Wrap(command string, config types.BecomeConfig) (Command, error) {
+	if config.Password != "" {
+		return Command{}, fmt.Errorf("become method doas cannot send a password without a terminal; configure a nopass rule")
+	}
+
+	flags := config.Flags
+	if flags == "" {
+		flags = "-n"
+	}
+
+	parts := []string{exeOrDefault(config.Exe, "doas")}
+	parts = append(parts, strings.Fields(flags)...)
+	parts = append(parts, "-u", Quote(userOrDefault(config.User, "root")), "sh", "-c", Quote(command))
+	return Command{Line: strings.Join(parts, " ")}, nil
+}
+
+// RunasPlugin runs Windows commands as another account by starting a
+// cmd.exe process with explicit credentials from PowerShell.
+type RunasPlugin struct{}
+
+// Name implements Plugin
+func (p *RunasPlugin) Name() string { return "runas" }
+
+// Wrap implements Plugin
+func (p *RunasPlugin) Wrap(command string, config types.BecomeConfig) (Command, error) {
+	if config.User == "" {
+		return Command{}, fmt.Errorf("become method runas requires become_user")
+	}
+	if config.Password == "" {
+		return Command{}, fmt.Errorf("become method runas requires a password")
+	}
+
+	script := strings.Join([]string{
+		"$ErrorActionPreference = 'Stop'",
+		fmt.Sprintf("$password = ConvertTo-SecureString %s -AsPlainText -Force", QuotePowerShell(config.Password)),
+		fmt.Sprintf("$credential = New-Object System.Management.Automation.PSCredential(%s, $password)", QuotePowerShell(config.User)),
+		"$out = [IO.Path]::GetTempFileName()",
+		"$err = [IO.Path]::GetTempFileName()",
+		"try {",
+		fmt.Sprintf("  $process = Start-Process -FilePath %s -ArgumentList %s -Credential $credential -Wait -PassThru -NoNewWindow -RedirectStandardOutput $out -RedirectStandardError $err",
+			QuotePowerShell(exeOrDefault(config.Exe, "cmd.exe")), QuotePowerShell("/c "+command)),
+		"  [Console]::Out.Write([IO.File]::ReadAllText($out))",
+		"  [Console]::Error.Write([IO.File]::ReadAllText($err))",
+		"  exit $process.ExitCode",
+		"} finally {",
+		"  Remove-Item -Force $out, $err -ErrorAction SilentlyContinue",
+		"}",
+	}, "\n")
+
+	return Command{Line: EncodePowerShell(script)}, nil
+}
+
+// QuotePowerShell quotes s as a PowerShell single-quoted string
+func QuotePowerShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// EncodePowerShell returns a powershell.exe command line running script
+// through -EncodedCommand, which avoids any cmd.exe quoting
+func EncodePowerShell(script string) string {
+	encoded := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		buf[2*i] = byte(r)
+		buf[2*i+1] = byte(r >> 8)
+	}
+	return "powershell.exe -NoProfile -NonInteractive -EncodedCommand " + base64.StdEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,257 @@
+package become
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+func TestManagerWrap(t *testing.T) {
+	m := NewManager()
+
+	tests := []struct {
+		name     string
+		options  types.ExecuteOptions
+		expected string
+		stdin    string
+		tty      bool
+	}{
+		{
+			name:     "no become",
+			options:  types.ExecuteOptions{},
+			expected: "echo 'hi'",
+		},
+		{
+			name:     "legacy sudo",
+			options:  types.ExecuteOptions{Sudo: true, User: "root"},
+			expected: `sudo -H -S -n -u 'root' sh -c 'echo '"'"'hi'"'"''`,
+		},
+		{
+			name:     "legacy su",
+			options:  types.ExecuteOptions{User: "postgres"},
+			expected: `su 'postgres' -c 'echo '"'"'hi'"'"''`,
+		},
+		{
+			name:     "sudo with password",
+			options:  types.ExecuteOptions{Become: &types.BecomeConfig{User: "app", Password: "s3cret"}},
+			expected: `sudo -H -S -p '' -u 'app' sh -c 'echo '"'"'hi'"'"''`,
+			stdin:    "s3cret\n",
+		},
+		{
+			name:     "sudo with requiretty",
+			options:  types.ExecuteOptions{Become: &types.BecomeConfig{RequireTTY: true}},
+			expected: `sudo -H -S -n -u 'root' sh -c 'echo '"'"'hi'"'"''`,
+			tty:      true,
+		},
+		{
+			name:     "doas with flags and exe",
+			options:  types.ExecuteOptions{Become: &types.BecomeConfig{Method: "doas", Flags: "-s", Exe: "/usr/bin/doas"}},
+			expected: `/usr/bin/doas -s -u 'root' sh -c 'echo '"'"'hi'"'"''`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := m.Wrap("echo 'hi'", tt.options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cmd.Line != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, cmd.Line)
+			}
+			if cmd.Stdin != tt.stdin {
+				t.Errorf("expected stdin %q, got %q", tt.stdin, cmd.Stdin)
+			}
+			if cmd.TTY != tt.tty {
+				t.Errorf("expected tty %t, got %t", tt.tty, cmd.TTY)
+			}
+		})
+	}
+}
+
+func TestManagerWrapErrors(t *testing.T) {
+	m := NewManager()
+
+	for _, config := range []*types.BecomeConfig{
+		{Method: "pbrun", User: "root"},
+		{Method: "su", User: "root", Password: "pw"},
+		{Method: "doas", Password: "pw"},
+		{Method: "runas", User: "Administrator"},
+	} {
+		if _, err := m.Wrap("whoami", types.ExecuteOptions{Become: config}); err == nil {
+			t.Errorf("expected error for %+v", *config)
+		}
+	}
+}
+
+func TestRunasPlugin(t *testing.T) {
+	cmd, err := (&RunasPlugin{}).Wrap("whoami", types.BecomeConfig{User: `CORP\svc`, Password: "it's"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prefix := "powershell.exe -NoProfile -NonInteractive -EncodedCommand "
+	if !strings.HasPrefix(cmd.Line, prefix) {
+		t.Fatalf("expected encoded PowerShell command, got %q", cmd.Line)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(cmd.Line, prefix))
+	if err != nil {
+		t.Fatalf("invalid base64: %v", err)
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+	}
+	script := string(utf16.Decode(units))
+
+	for _, want := range []string{
+		`ConvertTo-SecureString 'it''s'`,
+		`PSCredential('CORP\svc', $password)`,
+		`-FilePath 'cmd.exe' -ArgumentList '/c whoami'`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected script to contain %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestFromVars(t *testing.T) {
+	config, err := FromVars(map[string]interface{}{"ansible_become_user": "root"}, nil)
+	if err != nil || config != nil {
+		t.Fatalf("expected no config without ansible_become, got %+v, %v", config, err)
+	}
+
+	config, err = FromVars(map[string]interface{}{
+		"ansible_become":        "yes",
+		"ansible_become_method": "doas",
+		"ansible_become_user":   "www",
+		"ansible_become_pass":   "pw",
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Method != "doas" || config.User != "www" || config.Password != "pw" {
+		t.Errorf("unexpected config %+v", *config)
+	}
+}
+
+func TestFromVarsVaultPassword(t *testing.T) {
+	encrypted, err := vault.NewVaultString(vault.New("vaultpw"), "sudopw").Encrypt()
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	vars := map[string]interface{}{
+		"ansible_become":          true,
+		"ansible_become_password": encrypted,
+	}
+
+	if _, err := FromVars(vars, nil); err == nil {
+		t.Error("expected error without a vault manager")
+	}
+
+	vaults := vault.NewManager()
+	vaults.AddVault(vault.DefaultVaultIDLabel, "vaultpw")
+	config, err := FromVars(vars, vaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Password != "sudopw" {
+		t.Errorf("expected decrypted password, got %q", config.Password)
+	}
+	if vars["ansible_become_password"] != encrypted {
+		t.Error("FromVars must not modify the variables")
+	}
+}
+
+// recordingConnection records executed commands and their options
+type recordingConnection struct {
+	types.Connection
+	commands []string
+	options  []types.ExecuteOptions
+	copies   []string
+}
+
+func (c *recordingConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	c.commands = append(c.commands, command)
+	c.options = append(c.options, options)
+	return &types.Result{Success: true}, nil
+}
+
+func (c *recordingConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	c.copies = append(c.copies, fmt.Sprintf("%s %04o", dest, mode))
+	return nil
+}
+
+func TestWrapConnection(t *testing.T) {
+	rec := &recordingConnection{}
+	if WrapConnection(rec, nil) != types.Connection(rec) {
+		t.Error("expected nil config to return the connection unchanged")
+	}
+
+	config := &types.BecomeConfig{Method: "sudo", User: "root"}
+	conn := WrapConnection(rec, config)
+	if _, ok := conn.(types.StreamingConnection); ok {
+		t.Error("non-streaming connection must not gain ExecuteStream")
+	}
+
+	conn.Execute(context.Background(), "id", types.ExecuteOptions{})
+	conn.Execute(context.Background(), "id", types.ExecuteOptions{User: "app"})
+
+	if rec.options[0].Become != config {
+		t.Error("expected become config to be applied")
+	}
+	if rec.options[1].Become != nil {
+		t.Error("explicit user options must not be overridden")
+	}
+}
+
+func TestWrapConnectionCopy(t *testing.T) {
+	rec := &recordingConnection{}
+	config := &types.BecomeConfig{Method: "sudo", User: "app"}
+	conn := WrapConnection(rec, config)
+
+	if err := conn.Copy(context.Background(), strings.NewReader("data"), "/srv/app/config.yml", 0640); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	if len(rec.copies) != 1 || !strings.HasPrefix(rec.copies[0], "/tmp/.gosible-upload-") || !strings.HasSuffix(rec.copies[0], "-config.yml 0600") {
+		t.Fatalf("expected a private upload to a temporary file, got %v", rec.copies)
+	}
+	if len(rec.commands) != 3 {
+		t.Fatalf("expected grant, install and cleanup commands, got %q", rec.commands)
+	}
+	if !strings.HasPrefix(rec.commands[0], "setfacl -m u:'app':r ") || rec.options[0].Become != nil {
+		t.Errorf("expected the login user to share the upload with app, got %q", rec.commands[0])
+	}
+	if !strings.HasPrefix(rec.commands[1], "mkdir -p '/srv/app' && cp ") ||
+		!strings.HasSuffix(rec.commands[1], "'/srv/app/config.yml' && chmod 0640 '/srv/app/config.yml'") {
+		t.Errorf("unexpected install command %q", rec.commands[1])
+	}
+	if rec.options[1].Become != config {
+		t.Error("expected the install to run with become")
+	}
+	if !strings.HasPrefix(rec.commands[2], "rm -f '/tmp/.gosible-upload-") || rec.options[2].Become != nil {
+		t.Errorf("expected the login user to remove the upload, got %q", rec.commands[2])
+	}
+
+	// Root reads any file, so there is nothing to share
+	rec = &recordingConnection{}
+	WrapConnection(rec, &types.BecomeConfig{Method: "sudo"}).Copy(context.Background(), strings.NewReader("data"), "/etc/motd", 0644)
+	if len(rec.commands) != 2 || !strings.HasPrefix(rec.commands[0], "mkdir -p '/etc' && cp ") {
+		t.Errorf("expected install and cleanup only, got %q", rec.commands)
+	}
+
+	rec = &recordingConnection{}
+	WrapConnection(rec, &types.BecomeConfig{Method: "runas", User: "Administrator"}).Copy(context.Background(), strings.NewReader("data"), `C:\app.txt`, 0644)
+	if len(rec.copies) != 1 || len(rec.commands) != 0 {
+		t.Errorf("expected runas to copy directly, got %v %q", rec.copies, rec.commands)
+	}
+}
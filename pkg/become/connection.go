@@ -0,0 +1,112 @@
+package become
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+var errNoHostname = errors.New("connection does not report a hostname")
+
+// connection applies a task's become settings to commands that do not
+// set their own, so modules escalate without knowing about become
+type connection struct {
+	types.Connection
+	config *types.BecomeConfig
+}
+
+// streamingConnection is a connection whose transport supports streaming
+type streamingConnection struct {
+	*connection
+	stream types.StreamingConnection
+}
+
+// WrapConnection returns conn with config applied to every command.
+// A nil config returns conn unchanged.
+func WrapConnection(conn types.Connection, config *types.BecomeConfig) types.Connection {
+	if config == nil {
+		return conn
+	}
+
+	wrapped := &connection{Connection: conn, config: config}
+	if stream, ok := conn.(types.StreamingConnection); ok {
+		return &streamingConnection{connection: wrapped, stream: stream}
+	}
+	return wrapped
+}
+
+func (c *connection) options(options types.ExecuteOptions) types.ExecuteOptions {
+	if options.Become == nil && options.User == "" {
+		options.Become = c.config
+	}
+	return options
+}
+
+// Execute implements types.Connection
+func (c *connection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	return c.Connection.Execute(ctx, command, c.options(options))
+}
+
+// Copy uploads src as the login user to a temporary file and installs it
+// at dest with become, so transfers work where only the become user can
+// write. An existing dest keeps its owner; a new one belongs to the become
+// user. Windows runas has no such split and copies directly.
+func (c *connection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	if c.config.Method == "runas" {
+		return c.Connection.Copy(ctx, src, dest, mode)
+	}
+
+	tmp := fmt.Sprintf("/tmp/.gosible-upload-%d-%s", time.Now().UnixNano(), path.Base(dest))
+	if err := c.Connection.Copy(ctx, src, tmp, 0600); err != nil {
+		return err
+	}
+	defer c.Connection.Execute(context.Background(), "rm -f "+Quote(tmp), types.ExecuteOptions{})
+
+	// An unprivileged become user needs to read the login user's file
+	if user := userOrDefault(c.config.User, "root"); user != "root" {
+		grant := fmt.Sprintf("setfacl -m u:%s:r %s 2>/dev/null || chmod a+r %s", Quote(user), Quote(tmp), Quote(tmp))
+		if err := c.run(ctx, grant, types.ExecuteOptions{}); err != nil {
+			return fmt.Errorf("failed to share %s with %s: %w", tmp, user, err)
+		}
+	}
+
+	install := fmt.Sprintf("mkdir -p %s && cp %s %s && chmod %04o %s",
+		Quote(path.Dir(dest)), Quote(tmp), Quote(dest), mode, Quote(dest))
+	if err := c.run(ctx, install, c.options(types.ExecuteOptions{})); err != nil {
+		return fmt.Errorf("failed to install %s: %w", dest, err)
+	}
+	return nil
+}
+
+// run executes command on the wrapped connection, failing on a non-zero exit
+func (c *connection) run(ctx context.Context, command string, options types.ExecuteOptions) error {
+	result, err := c.Connection.Execute(ctx, command, options)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		if result.Error != nil {
+			return result.Error
+		}
+		return fmt.Errorf("%s", result.Message)
+	}
+	return nil
+}
+
+// GetHostname reports the wrapped connection's host name when available
+func (c *connection) GetHostname() (string, error) {
+	if provider, ok := c.Connection.(interface{ GetHostname() (string, error) }); ok {
+		return provider.GetHostname()
+	}
+	return "", errNoHostname
+}
+
+// ExecuteStream implements types.StreamingConnection
+func (c *streamingConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	return c.stream.ExecuteStream(ctx, command, c.options(options))
+}
@@ -0,0 +1,157 @@
+package callback
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// AuditRecord is one change in the audit trail: what a task changed on a
+// host in a run, with the state captured before and after when the module
+// records it
+type AuditRecord struct {
+	RunID     string      `json:"run_id"`
+	Time      time.Time   `json:"time"`
+	Host      string      `json:"host"`
+	Play      string      `json:"play,omitempty"`
+	Task      string      `json:"task"`
+	Module    string      `json:"module"`
+	Simulated bool        `json:"simulated,omitempty"` // Check mode, nothing was changed
+	Failed    bool        `json:"failed,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+}
+
+// AuditCallback appends a record of every changed task result to the audit
+// trail, one JSON object per line. Runs append to the same trail and are
+// told apart by their run ID.
+type AuditCallback struct {
+	encoder *json.Encoder
+	runID   string
+	play    string
+	mu      sync.Mutex
+}
+
+// NewAuditCallback creates a new audit callback with a run ID derived from
+// the current time
+func NewAuditCallback() *AuditCallback {
+	return &AuditCallback{
+		encoder: json.NewEncoder(os.Stdout),
+		runID:   time.Now().UTC().Format("20060102T150405.000000000Z"),
+	}
+}
+
+// Name returns "audit"
+func (ac *AuditCallback) Name() string {
+	return "audit"
+}
+
+// Initialize sets up the plugin. A run_id in the config replaces the
+// generated one.
+func (ac *AuditCallback) Initialize(config map[string]interface{}) error {
+	if runID, ok := config["run_id"].(string); ok && runID != "" {
+		ac.runID = runID
+	}
+	return nil
+}
+
+// RunID returns the ID the records of this run carry
+func (ac *AuditCallback) RunID() string {
+	return ac.runID
+}
+
+// SetOutput sets the output writer
+func (ac *AuditCallback) SetOutput(writer io.Writer) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.encoder = json.NewEncoder(writer)
+}
+
+// OnPlayStart handles play start
+func (ac *AuditCallback) OnPlayStart(play *types.Play) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.play = play.Name
+}
+
+// OnTaskStart handles task start
+func (ac *AuditCallback) OnTaskStart(task *types.Task, hosts []types.Host) {}
+
+// OnTaskResult records changed results
+func (ac *AuditCallback) OnTaskResult(task *types.Task, result *types.Result) {
+	if !result.Changed {
+		return
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	record := AuditRecord{
+		RunID:     ac.runID,
+		Time:      result.EndTime.UTC(),
+		Host:      result.Host,
+		Play:      ac.play,
+		Task:      task.Name,
+		Module:    task.Module.String(),
+		Simulated: result.Simulated,
+		Failed:    !result.Success,
+		Message:   result.Message,
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	if result.State != nil {
+		record.Before = result.State.Before
+		record.After = result.State.After
+	}
+	ac.encoder.Encode(record)
+}
+
+// OnPlayEnd handles play end
+func (ac *AuditCallback) OnPlayEnd(play *types.Play, results []types.Result) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.play = ""
+}
+
+// OnRunnerEnd handles runner end
+func (ac *AuditCallback) OnRunnerEnd(stats *RunStats) {}
+
+// AuditFilter selects audit records. Empty fields do not filter.
+type AuditFilter struct {
+	Host  string
+	RunID string
+}
+
+// ReadAuditTrail reads the records of an audit trail matching the filter,
+// in the order they were written
+func ReadAuditTrail(reader io.Reader, filter AuditFilter) ([]AuditRecord, error) {
+	records := make([]AuditRecord, 0)
+	scanner := bufio.NewScanner(reader)
+	// Captured states such as file contents make long lines
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid audit record on line %d: %w", line, err)
+		}
+		if filter.Host != "" && record.Host != filter.Host || filter.RunID != "" && record.RunID != filter.RunID {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit trail: %w", err)
+	}
+	return records, nil
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/liliang-cn/gosible/pkg/logging"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -33,6 +34,14 @@ type CallbackPlugin interface {
 	SetOutput(writer io.Writer)
 }
 
+// PausePlugin is implemented by plugins that show the operator the pauses
+// of pause tasks
+type PausePlugin interface {
+	// OnPause is called as a pause starts, with its prompt and how long it
+	// lasts, zero when it waits for the operator to answer
+	OnPause(prompt string, duration time.Duration)
+}
+
 // RunStats contains statistics for a run
 type RunStats struct {
 	StartTime    time.Time
@@ -43,6 +52,7 @@ type RunStats struct {
 	SkippedTasks int
 	ChangedTasks int
 	HostStats    map[string]*HostStats
+	Diff         DiffStats
 }
 
 // HostStats contains statistics for a single host
@@ -56,11 +66,14 @@ type HostStats struct {
 	TotalTime    time.Duration
 }
 
-// CallbackManager manages callback plugins
+// CallbackManager manages callback plugins. The task runner and the
+// playbook executor report their events to it, and it keeps the run
+// statistics the plugins receive at the end.
 type CallbackManager struct {
-	plugins []CallbackPlugin
-	mu      sync.RWMutex
-	stats   *RunStats
+	plugins  []CallbackPlugin
+	mu       sync.RWMutex
+	stats    *RunStats
+	redactor *logging.Redactor
 }
 
 // NewCallbackManager creates a new callback manager
@@ -68,6 +81,7 @@ func NewCallbackManager() *CallbackManager {
 	return &CallbackManager{
 		plugins: []CallbackPlugin{},
 		stats: &RunStats{
+			StartTime: time.Now(),
 			HostStats: make(map[string]*HostStats),
 		},
 	}
@@ -80,6 +94,43 @@ func (cm *CallbackManager) Register(plugin CallbackPlugin) {
 	cm.plugins = append(cm.plugins, plugin)
 }
 
+// SetRedactor masks secrets in the results passed to plugins, so no
+// output depends on tasks setting no_log
+func (cm *CallbackManager) SetRedactor(redactor *logging.Redactor) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.redactor = redactor
+}
+
+// NewCallback returns the built-in callback plugin with the given name:
+// default, minimal, json, jsonl, junit, profile_tasks or audit
+func NewCallback(name string) (CallbackPlugin, error) {
+	switch name {
+	case "default":
+		return NewDefaultCallback(), nil
+	case "minimal":
+		return NewMinimalCallback(), nil
+	case "json":
+		return NewJSONCallback(), nil
+	case "jsonl":
+		return NewJSONLinesCallback(), nil
+	case "junit":
+		return NewJUnitCallback(), nil
+	case "profile_tasks":
+		return NewProfileTasksCallback(), nil
+	case "audit":
+		return NewAuditCallback(), nil
+	}
+	return nil, fmt.Errorf("unknown callback plugin: %s", name)
+}
+
+// Stats returns the statistics of the run so far
+func (cm *CallbackManager) Stats() *RunStats {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.stats
+}
+
 // OnPlayStart notifies all plugins of play start
 func (cm *CallbackManager) OnPlayStart(play *types.Play) {
 	cm.mu.RLock()
@@ -92,11 +143,12 @@ func (cm *CallbackManager) OnPlayStart(play *types.Play) {
 
 // OnTaskStart notifies all plugins of task start
 func (cm *CallbackManager) OnTaskStart(task *types.Task, hosts []types.Host) {
-	cm.mu.RLock()
-	defer cm.mu.RUnlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	
 	cm.stats.TotalTasks++
 	
+	task = types.CensorTask(task)
 	for _, plugin := range cm.plugins {
 		plugin.OnTaskStart(task, hosts)
 	}
@@ -108,7 +160,10 @@ func (cm *CallbackManager) OnTaskResult(task *types.Task, result *types.Result)
 	defer cm.mu.Unlock()
 	
 	// Update stats
-	if result.Success {
+	skipped := isSkipped(result)
+	if skipped {
+		cm.stats.SkippedTasks++
+	} else if result.Success {
 		cm.stats.SuccessTasks++
 		if result.Changed {
 			cm.stats.ChangedTasks++
@@ -123,7 +178,11 @@ func (cm *CallbackManager) OnTaskResult(task *types.Task, result *types.Result)
 	}
 	
 	hostStat := cm.stats.HostStats[result.Host]
-	if result.Success {
+	if skipped {
+		hostStat.Skipped++
+	} else if isUnreachable(result) {
+		hostStat.Unreachable++
+	} else if result.Success {
 		hostStat.Ok++
 		if result.Changed {
 			hostStat.Changed++
@@ -133,6 +192,23 @@ func (cm *CallbackManager) OnTaskResult(task *types.Task, result *types.Result)
 	}
 	hostStat.TotalTime += result.Duration
 	
+	task = types.CensorTask(task)
+	result = cm.redactor.Result(types.CensorResult(result))
+
+	// Render the diff once for all plugins and count the lines it changes
+	if unified := RenderDiff(result.Diff); unified != "" {
+		added, removed := countDiffLines(unified)
+		cm.stats.Diff.Tasks++
+		cm.stats.Diff.Added += added
+		cm.stats.Diff.Removed += removed
+
+		diff := *result.Diff
+		diff.Diff = unified
+		rendered := *result
+		rendered.Diff = &diff
+		result = &rendered
+	}
+
 	for _, plugin := range cm.plugins {
 		plugin.OnTaskResult(task, result)
 	}
@@ -143,15 +219,37 @@ func (cm *CallbackManager) OnPlayEnd(play *types.Play, results []types.Result) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 	
+	redacted := make([]types.Result, len(results))
+	for i := range results {
+		redacted[i] = *cm.redactor.Result(types.CensorResult(&results[i]))
+	}
+	results = redacted
+	
 	for _, plugin := range cm.plugins {
 		plugin.OnPlayEnd(play, results)
 	}
 }
 
-// OnRunnerEnd notifies all plugins of runner end
-func (cm *CallbackManager) OnRunnerEnd() {
+// OnPause notifies the plugins showing pauses that one started, and
+// reports whether any did, so the prompt can be shown elsewhere otherwise
+func (cm *CallbackManager) OnPause(prompt string, duration time.Duration) bool {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
+
+	shown := false
+	for _, plugin := range cm.plugins {
+		if pauser, ok := plugin.(PausePlugin); ok {
+			pauser.OnPause(prompt, duration)
+			shown = true
+		}
+	}
+	return shown
+}
+
+// OnRunnerEnd notifies all plugins of runner end
+func (cm *CallbackManager) OnRunnerEnd() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	
 	cm.stats.EndTime = time.Now()
 	
@@ -160,10 +258,44 @@ func (cm *CallbackManager) OnRunnerEnd() {
 	}
 }
 
-// DefaultCallback is the default stdout callback
+// isSkipped reports whether a result is for a task that did not run on its
+// host because of a condition or tags
+func isSkipped(result *types.Result) bool {
+	skipped, _ := result.Data["skipped"].(bool)
+	return skipped
+}
+
+// banner pads a play or task header with stars to a fixed width
+func banner(name string) string {
+	return strings.Repeat("*", max(70-len(name), 3))
+}
+
+// isUnreachable reports whether a result is for a host that could not be
+// connected to
+func isUnreachable(result *types.Result) bool {
+	unreachable, _ := result.Data["unreachable"].(bool)
+	return unreachable
+}
+
+// sortedHosts returns the hosts of the run statistics in name order
+func sortedHosts(stats *RunStats) []string {
+	hosts := make([]string, 0, len(stats.HostStats))
+	for host := range stats.HostStats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// DefaultCallback is the default stdout callback. With the verbose option
+// it also prints the message of every result, and with the color option it
+// colors diffs.
 type DefaultCallback struct {
-	output io.Writer
-	config map[string]interface{}
+	output  io.Writer
+	config  map[string]interface{}
+	verbose bool
+	color   bool
+	mu      sync.Mutex
 }
 
 // NewDefaultCallback creates a new default callback
@@ -182,6 +314,8 @@ func (dc *DefaultCallback) Name() string {
 // Initialize sets up the plugin
 func (dc *DefaultCallback) Initialize(config map[string]interface{}) error {
 	dc.config = config
+	dc.verbose = types.ConvertToBool(config["verbose"])
+	dc.color = types.ConvertToBool(config["color"])
 	return nil
 }
 
@@ -192,24 +326,69 @@ func (dc *DefaultCallback) SetOutput(writer io.Writer) {
 
 // OnPlayStart handles play start
 func (dc *DefaultCallback) OnPlayStart(play *types.Play) {
-	fmt.Fprintf(dc.output, "\nPLAY [%s] %s\n", play.Name, strings.Repeat("*", 70-len(play.Name)))
+	fmt.Fprintf(dc.output, "\nPLAY [%s] %s\n", play.Name, banner(play.Name))
 }
 
 // OnTaskStart handles task start
 func (dc *DefaultCallback) OnTaskStart(task *types.Task, hosts []types.Host) {
-	fmt.Fprintf(dc.output, "\nTASK [%s] %s\n", task.Name, strings.Repeat("*", 70-len(task.Name)))
+	fmt.Fprintf(dc.output, "\nTASK [%s] %s\n", task.Name, banner(task.Name))
+}
+
+// OnPause shows a pause, leaving a prompt waiting for input on its line
+func (dc *DefaultCallback) OnPause(prompt string, duration time.Duration) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if duration == 0 {
+		fmt.Fprintf(dc.output, "%s: ", prompt)
+		return
+	}
+	fmt.Fprintf(dc.output, "Pausing for %s\n", duration)
+	if prompt != "" {
+		fmt.Fprintln(dc.output, prompt)
+	}
 }
 
 // OnTaskResult handles task results
 func (dc *DefaultCallback) OnTaskResult(task *types.Task, result *types.Result) {
-	status := "ok"
-	if !result.Success {
-		status = "failed"
-	} else if result.Changed {
-		status = "changed"
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	switch {
+	case isSkipped(result):
+		fmt.Fprintf(dc.output, "skipping: [%s]\n", result.Host)
+		return
+	case isUnreachable(result):
+		fmt.Fprintf(dc.output, "unreachable: [%s] => %s\n", result.Host, result.Message)
+		return
+	case !result.Success:
+		message := result.Message
+		if message == "" && result.Error != nil {
+			message = result.Error.Error()
+		}
+		fmt.Fprintf(dc.output, "failed: [%s] => %s\n", result.Host, message)
+		if bundle, ok := result.Data["support_bundle"].(string); ok {
+			fmt.Fprintf(dc.output, "  Support bundle: %s\n", bundle)
+		}
+	case result.Changed:
+		fmt.Fprintf(dc.output, "changed: [%s]\n", result.Host)
+	default:
+		fmt.Fprintf(dc.output, "ok: [%s]\n", result.Host)
+	}
+
+	// Show diff if available
+	if diff := RenderDiff(result.Diff); diff != "" {
+		if dc.color {
+			diff = colorizeDiff(diff)
+		} else if !strings.HasSuffix(diff, "\n") {
+			diff += "\n"
+		}
+		fmt.Fprint(dc.output, diff)
+	}
+
+	if dc.verbose && result.Success && result.Message != "" {
+		fmt.Fprintf(dc.output, "  Output: %s\n", result.Message)
 	}
-	
-	fmt.Fprintf(dc.output, "%s: [%s] => %s\n", status, result.Host, result.Message)
 }
 
 // OnPlayEnd handles play end
@@ -221,17 +400,24 @@ func (dc *DefaultCallback) OnPlayEnd(play *types.Play, results []types.Result) {
 func (dc *DefaultCallback) OnRunnerEnd(stats *RunStats) {
 	fmt.Fprintf(dc.output, "\nPLAY RECAP %s\n", strings.Repeat("*", 70))
 	
-	for host, hostStats := range stats.HostStats {
-		fmt.Fprintf(dc.output, "%s : ok=%d changed=%d unreachable=%d failed=%d skipped=%d\n",
-			host, hostStats.Ok, hostStats.Changed, hostStats.Unreachable, 
+	for _, host := range sortedHosts(stats) {
+		hostStats := stats.HostStats[host]
+		fmt.Fprintf(dc.output, "%-20s : ok=%-3d changed=%-3d unreachable=%-3d failed=%-3d skipped=%-3d\n",
+			host, hostStats.Ok, hostStats.Changed, hostStats.Unreachable,
 			hostStats.Failed, hostStats.Skipped)
 	}
+
+	if stats.Diff.Tasks > 0 {
+		fmt.Fprintf(dc.output, "\nDIFF SUMMARY: %d task(s) with changes, %d line(s) added, %d line(s) removed\n",
+			stats.Diff.Tasks, stats.Diff.Added, stats.Diff.Removed)
+	}
 }
 
 // JSONCallback outputs in JSON format
 type JSONCallback struct {
 	output  io.Writer
 	results []interface{}
+	diffs   []map[string]interface{}
 	mu      sync.Mutex
 }
 
@@ -293,7 +479,7 @@ func (jc *JSONCallback) OnTaskResult(task *types.Task, result *types.Result) {
 	jc.mu.Lock()
 	defer jc.mu.Unlock()
 	
-	jc.results = append(jc.results, map[string]interface{}{
+	event := map[string]interface{}{
 		"event":   "task_result",
 		"task":    task.Name,
 		"host":    result.Host,
@@ -301,7 +487,19 @@ func (jc *JSONCallback) OnTaskResult(task *types.Task, result *types.Result) {
 		"changed": result.Changed,
 		"message": result.Message,
 		"time":    time.Now().Unix(),
-	})
+	}
+	if diff := RenderDiff(result.Diff); diff != "" {
+		added, removed := countDiffLines(diff)
+		event["diff"] = diff
+		jc.diffs = append(jc.diffs, map[string]interface{}{
+			"task":    task.Name,
+			"host":    result.Host,
+			"added":   added,
+			"removed": removed,
+			"diff":    diff,
+		})
+	}
+	jc.results = append(jc.results, event)
 }
 
 // OnPlayEnd handles play end
@@ -325,6 +523,19 @@ func (jc *JSONCallback) OnRunnerEnd(stats *RunStats) {
 		"events": jc.results,
 		"stats":  stats,
 	}
+	if len(jc.diffs) > 0 {
+		added, removed := 0, 0
+		for _, diff := range jc.diffs {
+			added += diff["added"].(int)
+			removed += diff["removed"].(int)
+		}
+		output["diff"] = map[string]interface{}{
+			"tasks":         len(jc.diffs),
+			"lines_added":   added,
+			"lines_removed": removed,
+			"changes":       jc.diffs,
+		}
+	}
 	
 	encoder := json.NewEncoder(jc.output)
 	encoder.SetIndent("", "  ")
@@ -3,11 +3,13 @@ package callback
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/liliang-cn/gosible/pkg/logging"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -203,6 +205,74 @@ func TestDefaultCallback_Output(t *testing.T) {
 	}
 }
 
+func TestCallbackManager_Redactor(t *testing.T) {
+	var buf bytes.Buffer
+	cm := NewCallbackManager()
+	callback := NewDefaultCallback()
+	callback.SetOutput(&buf)
+	cm.Register(callback)
+	
+	redactor, err := logging.NewRedactor(logging.RedactionRules{Fields: []string{"password"}, Secrets: []string{"s3cr3t-token"}})
+	if err != nil {
+		t.Fatalf("NewRedactor failed: %v", err)
+	}
+	cm.SetRedactor(redactor)
+	
+	task := &types.Task{Name: "Test Task"}
+	result := &types.Result{
+		Host:    "host1",
+		Success: false,
+		Message: "login with s3cr3t-token rejected",
+		Data:    map[string]interface{}{"password": "hunter2"},
+	}
+	cm.OnTaskResult(task, result)
+	
+	if strings.Contains(buf.String(), "s3cr3t-token") || !strings.Contains(buf.String(), "login with ********") {
+		t.Errorf("Expected the secret to be masked, got %q", buf.String())
+	}
+	if result.Message != "login with s3cr3t-token rejected" || result.Data["password"] != "hunter2" {
+		t.Error("Expected the original result to be unchanged")
+	}
+	if cm.Stats().HostStats["host1"].Failed != 1 {
+		t.Error("Expected stats to count the result")
+	}
+}
+
+func TestCallbackManager_NoLog(t *testing.T) {
+	var buf bytes.Buffer
+	cm := NewCallbackManager()
+	callback := NewJSONCallback()
+	callback.SetOutput(&buf)
+	cm.Register(callback)
+
+	noLog := true
+	task := &types.Task{Name: "Set password", Module: "user", Args: map[string]interface{}{"password": "hunter2"}, NoLog: &noLog}
+	result := types.Result{
+		Host:    "host1",
+		Success: true,
+		Changed: true,
+		Message: "password hunter2 set",
+		Data:    map[string]interface{}{"changed": true, "stdout": "hunter2"},
+		NoLog:   true,
+	}
+	play := &types.Play{Name: "Users", Hosts: "host1"}
+	cm.OnPlayStart(play)
+	cm.OnTaskStart(task, []types.Host{{Name: "host1"}})
+	cm.OnTaskResult(task, &result)
+	cm.OnPlayEnd(play, []types.Result{result})
+	cm.OnRunnerEnd()
+
+	if strings.Contains(buf.String(), "hunter2") || !strings.Contains(buf.String(), types.NoLogValue) {
+		t.Errorf("Expected the no_log result to be censored, got %q", buf.String())
+	}
+	if task.Args["password"] != "hunter2" || result.Data["stdout"] != "hunter2" {
+		t.Error("Expected the original task and result to be unchanged")
+	}
+	if cm.Stats().HostStats["host1"].Changed != 1 {
+		t.Error("Expected stats to count the censored result")
+	}
+}
+
 func TestJSONCallback_Output(t *testing.T) {
 	var buf bytes.Buffer
 	callback := NewJSONCallback()
@@ -343,4 +413,326 @@ func (et *EventTracker) OnPlayEnd(play *types.Play, results []types.Result) {
 
 func (et *EventTracker) OnRunnerEnd(stats *RunStats) {
 	*et.events = append(*et.events, "runner_end")
-}
\ No newline at end of file
+}
+func TestNewCallback(t *testing.T) {
+	for _, name := range []string{"default", "minimal", "json", "jsonl", "junit", "profile_tasks", "audit"} {
+		plugin, err := NewCallback(name)
+		if err != nil {
+			t.Fatalf("NewCallback(%q) failed: %v", name, err)
+		}
+		if plugin.Name() != name {
+			t.Errorf("expected plugin %q, got %q", name, plugin.Name())
+		}
+	}
+
+	if _, err := NewCallback("yaml"); err == nil {
+		t.Error("expected an error for an unknown callback")
+	}
+}
+
+func TestCallbackManager_SkippedStats(t *testing.T) {
+	cm := NewCallbackManager()
+	task := &types.Task{Name: "Conditional"}
+
+	cm.OnTaskStart(task, []types.Host{{Name: "host1"}})
+	cm.OnTaskResult(task, &types.Result{Host: "host1", Success: true, Data: map[string]interface{}{"skipped": true}})
+
+	stats := cm.Stats()
+	if stats.SkippedTasks != 1 || stats.SuccessTasks != 0 {
+		t.Errorf("expected 1 skipped and 0 successful tasks, got %d and %d", stats.SkippedTasks, stats.SuccessTasks)
+	}
+	if host := stats.HostStats["host1"]; host.Skipped != 1 || host.Ok != 0 {
+		t.Errorf("expected host1 to have 1 skipped and 0 ok, got %+v", host)
+	}
+}
+
+func TestCallbackManager_UnreachableStats(t *testing.T) {
+	var buf bytes.Buffer
+	plugin := NewDefaultCallback()
+	plugin.SetOutput(&buf)
+	cm := NewCallbackManager()
+	cm.Register(plugin)
+	task := &types.Task{Name: "Preflight"}
+
+	cm.OnTaskResult(task, &types.Result{Host: "host1", Success: false, Message: "Host unreachable: connection refused", Data: map[string]interface{}{"unreachable": true}})
+
+	stats := cm.Stats()
+	if host := stats.HostStats["host1"]; host.Unreachable != 1 || host.Failed != 0 {
+		t.Errorf("expected host1 to have 1 unreachable and 0 failed, got %+v", host)
+	}
+	if expected := "unreachable: [host1] => Host unreachable: connection refused\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestDefaultCallback_Verbose(t *testing.T) {
+	var buf bytes.Buffer
+	callback := NewDefaultCallback()
+	callback.SetOutput(&buf)
+	if err := callback.Initialize(map[string]interface{}{"verbose": true}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+
+	task := &types.Task{Name: "Write config"}
+	callback.OnTaskResult(task, &types.Result{
+		Host:    "web1",
+		Success: true,
+		Changed: true,
+		Message: "File updated",
+		Diff:    &types.DiffResult{Prepared: true, Before: "a=1", After: "a=2"},
+	})
+	callback.OnTaskResult(task, &types.Result{Host: "web2", Success: false, Error: io.ErrUnexpectedEOF})
+	callback.OnTaskResult(task, &types.Result{Host: "web3", Success: true, Data: map[string]interface{}{"skipped": true}})
+
+	expected := "changed: [web1]\n--- before\n+++ after\n@@ -1 +1 @@\n-a=1\n+a=2\n  Output: File updated\n" +
+		"failed: [web2] => unexpected EOF\n" +
+		"skipping: [web3]\n"
+	if buf.String() != expected {
+		t.Errorf("expected output:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	expected := "--- before\n+++ after\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -10,3 +10,4 @@\n j\n k\n l\n+m\n"
+	if diff := UnifiedDiff(before, after, "before", "after"); diff != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	// Changes up to twice the context apart share a hunk
+	if diff := UnifiedDiff("1\n2\n3\n4\n5\n6\n7\n8\n", "1\nx\n3\n4\n5\n6\n7\ny\n", "a", "b"); strings.Count(diff, "@@ -") != 1 {
+		t.Errorf("expected a single hunk, got:\n%s", diff)
+	}
+	if diff := UnifiedDiff("", "new\n", "a", "b"); diff != "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n" {
+		t.Errorf("unexpected diff of a new file:\n%s", diff)
+	}
+	if diff := UnifiedDiff("same\n", "same\n", "a", "b"); diff != "" {
+		t.Errorf("expected no diff, got:\n%s", diff)
+	}
+	if added, removed := countDiffLines(expected); added != 2 || removed != 1 {
+		t.Errorf("expected 2 added and 1 removed lines, got %d and %d", added, removed)
+	}
+}
+
+func TestCallbackManager_Diff(t *testing.T) {
+	var text, colored, jsonOut bytes.Buffer
+	cm := NewCallbackManager()
+	for _, setup := range []struct {
+		plugin CallbackPlugin
+		output io.Writer
+		config map[string]interface{}
+	}{
+		{NewDefaultCallback(), &text, map[string]interface{}{}},
+		{NewDefaultCallback(), &colored, map[string]interface{}{"color": true}},
+		{NewJSONCallback(), &jsonOut, map[string]interface{}{}},
+	} {
+		setup.plugin.Initialize(setup.config)
+		setup.plugin.SetOutput(setup.output)
+		cm.Register(setup.plugin)
+	}
+
+	task := &types.Task{Name: "Write config"}
+	cm.OnTaskResult(task, &types.Result{Host: "web1", Success: true, Changed: true,
+		Diff: &types.DiffResult{Prepared: true, Before: "port=80\nworkers=2\n", After: "port=8080\nworkers=2\nlog=on\n"}})
+	cm.OnTaskResult(task, &types.Result{Host: "web2", Success: true, Changed: true,
+		Diff: &types.DiffResult{Diff: "--- /etc/app.conf\n+++ /etc/app.conf\n-port=80\n+port=8080\n"}})
+	cm.OnTaskResult(task, &types.Result{Host: "web3", Success: true})
+	cm.OnRunnerEnd()
+
+	if stats := cm.Stats().Diff; stats != (DiffStats{Tasks: 2, Added: 3, Removed: 2}) {
+		t.Errorf("unexpected diff stats %+v", stats)
+	}
+	if !strings.Contains(text.String(), "changed: [web1]\n--- before\n+++ after\n@@ -1,2 +1,3 @@\n-port=80\n+port=8080\n workers=2\n+log=on\n") {
+		t.Errorf("expected a unified diff, got:\n%s", text.String())
+	}
+	if !strings.Contains(text.String(), "DIFF SUMMARY: 2 task(s) with changes, 3 line(s) added, 2 line(s) removed") {
+		t.Errorf("expected a diff summary, got:\n%s", text.String())
+	}
+	if !strings.Contains(colored.String(), colorRed+"-port=80"+colorReset) || !strings.Contains(colored.String(), colorGreen+"+log=on"+colorReset) {
+		t.Errorf("expected a colored diff, got %q", colored.String())
+	}
+
+	var output struct {
+		Diff struct {
+			Tasks        int `json:"tasks"`
+			LinesAdded   int `json:"lines_added"`
+			LinesRemoved int `json:"lines_removed"`
+			Changes      []struct {
+				Host  string `json:"host"`
+				Added int    `json:"added"`
+				Diff  string `json:"diff"`
+			} `json:"changes"`
+		} `json:"diff"`
+	}
+	if err := json.Unmarshal(jsonOut.Bytes(), &output); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if output.Diff.Tasks != 2 || output.Diff.LinesAdded != 3 || output.Diff.LinesRemoved != 2 || len(output.Diff.Changes) != 2 {
+		t.Fatalf("unexpected diff section %+v", output.Diff)
+	}
+	if change := output.Diff.Changes[1]; change.Host != "web2" || change.Added != 1 || !strings.HasPrefix(change.Diff, "--- /etc/app.conf\n") {
+		t.Errorf("expected the module's own diff for web2, got %+v", change)
+	}
+}
+
+func TestCallbackManager_OnPause(t *testing.T) {
+	var buf bytes.Buffer
+	callback := NewDefaultCallback()
+	callback.SetOutput(&buf)
+
+	cm := NewCallbackManager()
+	if cm.OnPause("Continue?", 0) {
+		t.Error("expected no plugin to show the pause")
+	}
+	cm.Register(NewMinimalCallback())
+	cm.Register(callback)
+	if !cm.OnPause("Continue?", 0) {
+		t.Error("expected the default callback to show the pause")
+	}
+	cm.OnPause("Draining", 30*time.Second)
+
+	expected := "Continue?: Pausing for 30s\nDraining\n"
+	if buf.String() != expected {
+		t.Errorf("expected output:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMinimalCallback(t *testing.T) {
+	var buf bytes.Buffer
+	callback := NewMinimalCallback()
+	callback.SetOutput(&buf)
+
+	task := &types.Task{Name: "Ad-hoc: ping"}
+	callback.OnTaskStart(task, []types.Host{{Name: "web1"}})
+	callback.OnTaskResult(task, &types.Result{Host: "web1", Success: true, Data: map[string]interface{}{"ping": "pong"}})
+	callback.OnTaskResult(task, &types.Result{Host: "web2", Success: false, Message: "unreachable"})
+
+	output := buf.String()
+	if !strings.HasPrefix(output, "web1 | SUCCESS => {") || !strings.Contains(output, `"ping": "pong"`) {
+		t.Errorf("expected a success line with the result data, got: %s", output)
+	}
+	if !strings.Contains(output, "web2 | FAILED! => {") || !strings.Contains(output, `"msg": "unreachable"`) {
+		t.Errorf("expected a failure line with the message, got: %s", output)
+	}
+	if strings.Contains(output, "TASK") {
+		t.Errorf("expected no task headers, got: %s", output)
+	}
+}
+
+func TestJSONLinesCallback(t *testing.T) {
+	var buf bytes.Buffer
+	callback := NewJSONLinesCallback()
+	callback.SetOutput(&buf)
+
+	play := &types.Play{Name: "Deploy", Hosts: "web"}
+	task := &types.Task{Name: "Restart", Module: "service"}
+	callback.OnPlayStart(play)
+	callback.OnTaskStart(task, []types.Host{{Name: "web1"}})
+	callback.OnTaskResult(task, &types.Result{Host: "web1", Success: true, Changed: true, Duration: 2 * time.Second})
+	callback.OnPlayEnd(play, nil)
+	callback.OnRunnerEnd(&RunStats{HostStats: map[string]*HostStats{"web1": {Host: "web1", Ok: 1, Changed: 1}}})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{"play_start", "task_start", "task_result", "play_end", "stats"}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d: %s", len(expected), len(lines), buf.String())
+	}
+
+	for i, line := range lines {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		if event["event"] != expected[i] {
+			t.Errorf("line %d: expected event %s, got %v", i, expected[i], event["event"])
+		}
+		if i == 2 {
+			if event["play"] != "Deploy" || event["host"] != "web1" || event["changed"] != true || event["duration"] != 2.0 {
+				t.Errorf("unexpected task result: %v", event)
+			}
+		}
+	}
+}
+
+func TestAuditCallback(t *testing.T) {
+	var trail bytes.Buffer
+	for run, host := range []string{"web1", "web2"} {
+		callback := NewAuditCallback()
+		callback.Initialize(map[string]interface{}{"run_id": fmt.Sprintf("run%d", run+1)})
+		callback.SetOutput(&trail)
+
+		play := &types.Play{Name: "Deploy", Hosts: "web"}
+		restart := &types.Task{Name: "Restart", Module: "systemd"}
+		ping := &types.Task{Name: "Ping", Module: "ping"}
+		callback.OnPlayStart(play)
+		callback.OnTaskResult(ping, &types.Result{Host: host, Success: true})
+		callback.OnTaskResult(restart, &types.Result{
+			Host:    host,
+			Success: true,
+			Changed: true,
+			Message: "Changed service nginx: started",
+			EndTime: time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC),
+			State: &types.StateCapture{
+				Before: map[string]interface{}{"active_state": "inactive"},
+				After:  map[string]interface{}{"active_state": "active"},
+			},
+		})
+		callback.OnPlayEnd(play, nil)
+	}
+
+	if lines := strings.Count(trail.String(), "\n"); lines != 2 {
+		t.Fatalf("expected only the two changes to be recorded, got %d lines: %s", lines, trail.String())
+	}
+
+	records, err := ReadAuditTrail(strings.NewReader(trail.String()), AuditFilter{Host: "web2"})
+	if err != nil {
+		t.Fatalf("ReadAuditTrail failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected one record for web2, got %d", len(records))
+	}
+	record := records[0]
+	if record.RunID != "run2" || record.Play != "Deploy" || record.Task != "Restart" || record.Module != "systemd" || !record.Time.Equal(time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if after, _ := record.After.(map[string]interface{}); after["active_state"] != "active" {
+		t.Errorf("expected the state after the change, got %v", record.After)
+	}
+
+	if records, _ := ReadAuditTrail(strings.NewReader(trail.String()), AuditFilter{RunID: "run1"}); len(records) != 1 || records[0].Host != "web1" {
+		t.Errorf("expected the record of run1, got %+v", records)
+	}
+	if _, err := ReadAuditTrail(strings.NewReader("{\"host\":\"web1\"}\nnot json\n"), AuditFilter{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming line 2, got %v", err)
+	}
+}
+
+func TestJUnitCallback(t *testing.T) {
+	var buf bytes.Buffer
+	callback := NewJUnitCallback()
+	callback.SetOutput(&buf)
+
+	play := &types.Play{Name: "Deploy"}
+	task := &types.Task{Name: "Install", Module: "apt"}
+	callback.OnPlayStart(play)
+	callback.OnTaskResult(task, &types.Result{Host: "web1", Success: true, Message: "installed", Duration: 1500 * time.Millisecond})
+	callback.OnTaskResult(task, &types.Result{Host: "web2", Success: false, Message: "apt failed", Duration: 500 * time.Millisecond})
+	callback.OnTaskResult(task, &types.Result{Host: "web3", Success: true, Message: "Skipped due to when condition", Data: map[string]interface{}{"skipped": true}})
+	callback.OnRunnerEnd(&RunStats{})
+
+	output := buf.String()
+	for _, expected := range []string{
+		`<testsuites name="gosible" tests="3" failures="1" skipped="1"`,
+		`<testsuite name="Deploy" tests="3" failures="1" skipped="1" time="2.000">`,
+		`<testcase name="[web1] Install" classname="Deploy" time="1.500">`,
+		`<failure message="apt failed" type="apt">apt failed</failure>`,
+		`<skipped message="Skipped due to when condition"></skipped>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in report:\n%s", expected, output)
+		}
+	}
+}
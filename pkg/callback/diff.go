@@ -0,0 +1,220 @@
+package callback
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// ANSI colors of unified diff lines
+const (
+	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+)
+
+// DiffStats counts the diffs of a run and the lines they change
+type DiffStats struct {
+	Tasks   int
+	Added   int
+	Removed int
+}
+
+// diffOp is a line of an edit script: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// RenderDiff returns the unified diff of a task's diff. A diff the module
+// rendered itself is returned as is; otherwise it is computed from the
+// before and after states.
+func RenderDiff(diff *types.DiffResult) string {
+	if diff == nil {
+		return ""
+	}
+	if diff.Diff != "" {
+		return diff.Diff
+	}
+	before, after := diff.Before, diff.After
+	if before == "" && after == "" {
+		before, after = strings.Join(diff.BeforeLines, "\n"), strings.Join(diff.AfterLines, "\n")
+	}
+	return UnifiedDiff(before, after, "before", "after")
+}
+
+// UnifiedDiff returns the changes from before to after in unified format,
+// or "" when they have the same lines
+func UnifiedDiff(before, after, fromLabel, toLabel string) string {
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	// aPos and bPos give the line of each text an op starts at
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.kind != '+' {
+			aPos[i+1]++
+		}
+		if op.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Take in the following changes up to twice the context apart, so
+		// their hunks would overlap
+		start, end := max(i-diffContext, 0), i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end+1 > 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(ops))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aPos[start], aPos[end]), hunkRange(bPos[start], bPos[end]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines from..to of a hunk header, which counts lines
+// from one and names the line before an empty range
+func hunkRange(from, to int) string {
+	if to-from == 1 {
+		return fmt.Sprintf("%d", from+1)
+	}
+	if to == from {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+// splitLines splits a text into lines, without an empty last line for the
+// final newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script turning a into b, using
+// Myers' algorithm
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+
+	// trace keeps the furthest reaching paths before each round, to walk
+	// the edit script back from the end
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			done = x >= n && y >= m
+		}
+		if done {
+			break
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// countDiffLines counts the lines a unified diff adds and removes, leaving
+// out the --- and +++ file headers
+func countDiffLines(unified string) (added, removed int) {
+	lines := strings.Split(unified, "\n")
+	for i := 0; i < len(lines); i++ {
+		switch {
+		case strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			i++
+		case strings.HasPrefix(lines[i], "+"):
+			added++
+		case strings.HasPrefix(lines[i], "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// colorizeDiff colors the removed lines of a unified diff red, the added
+// ones green and the hunk headers cyan
+func colorizeDiff(unified string) string {
+	lines := strings.Split(strings.TrimSuffix(unified, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+			lines[i] = colorBold + line + colorReset
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = colorCyan + line + colorReset
+		case strings.HasPrefix(line, "+"):
+			lines[i] = colorGreen + line + colorReset
+		case strings.HasPrefix(line, "-"):
+			lines[i] = colorRed + line + colorReset
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
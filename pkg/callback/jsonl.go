@@ -0,0 +1,138 @@
+package callback
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// JSONLinesCallback writes every event as a JSON object on its own line as
+// it happens, so the output can be followed by log shippers and other tools
+// while the run is in progress
+type JSONLinesCallback struct {
+	encoder *json.Encoder
+	play    string
+	mu      sync.Mutex
+}
+
+// NewJSONLinesCallback creates a new JSON lines callback
+func NewJSONLinesCallback() *JSONLinesCallback {
+	return &JSONLinesCallback{encoder: json.NewEncoder(os.Stdout)}
+}
+
+// Name returns "jsonl"
+func (jl *JSONLinesCallback) Name() string {
+	return "jsonl"
+}
+
+// Initialize sets up the plugin
+func (jl *JSONLinesCallback) Initialize(config map[string]interface{}) error {
+	return nil
+}
+
+// SetOutput sets the output writer
+func (jl *JSONLinesCallback) SetOutput(writer io.Writer) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+	jl.encoder = json.NewEncoder(writer)
+}
+
+// emit writes one event line
+func (jl *JSONLinesCallback) emit(event string, fields map[string]interface{}) {
+	fields["event"] = event
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	jl.encoder.Encode(fields)
+}
+
+// OnPlayStart handles play start
+func (jl *JSONLinesCallback) OnPlayStart(play *types.Play) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	jl.play = play.Name
+	jl.emit("play_start", map[string]interface{}{
+		"play":  play.Name,
+		"hosts": play.Hosts,
+	})
+}
+
+// OnTaskStart handles task start
+func (jl *JSONLinesCallback) OnTaskStart(task *types.Task, hosts []types.Host) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	hostNames := make([]string, len(hosts))
+	for i, h := range hosts {
+		hostNames[i] = h.Name
+	}
+	jl.emit("task_start", map[string]interface{}{
+		"play":   jl.play,
+		"task":   task.Name,
+		"module": task.Module.String(),
+		"hosts":  hostNames,
+	})
+}
+
+// OnTaskResult handles task results
+func (jl *JSONLinesCallback) OnTaskResult(task *types.Task, result *types.Result) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	fields := map[string]interface{}{
+		"play":     jl.play,
+		"task":     task.Name,
+		"host":     result.Host,
+		"success":  result.Success,
+		"changed":  result.Changed,
+		"skipped":  isSkipped(result),
+		"message":  result.Message,
+		"duration": result.Duration.Seconds(),
+	}
+	if result.Error != nil {
+		fields["error"] = result.Error.Error()
+	}
+	if len(result.Data) > 0 {
+		fields["data"] = result.Data
+	}
+	if diff := RenderDiff(result.Diff); diff != "" {
+		fields["diff"] = diff
+	}
+	jl.emit("task_result", fields)
+}
+
+// OnPlayEnd handles play end
+func (jl *JSONLinesCallback) OnPlayEnd(play *types.Play, results []types.Result) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	jl.emit("play_end", map[string]interface{}{
+		"play":    play.Name,
+		"results": len(results),
+	})
+	jl.play = ""
+}
+
+// OnRunnerEnd writes the run statistics
+func (jl *JSONLinesCallback) OnRunnerEnd(stats *RunStats) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	hosts := make(map[string]interface{}, len(stats.HostStats))
+	for host, hostStats := range stats.HostStats {
+		hosts[host] = map[string]int{
+			"ok":          hostStats.Ok,
+			"changed":     hostStats.Changed,
+			"unreachable": hostStats.Unreachable,
+			"failed":      hostStats.Failed,
+			"skipped":     hostStats.Skipped,
+		}
+	}
+	jl.emit("stats", map[string]interface{}{
+		"duration": stats.EndTime.Sub(stats.StartTime).Seconds(),
+		"hosts":    hosts,
+	})
+}
@@ -0,0 +1,159 @@
+package callback
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the test cases of one play
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+
+	duration time.Duration
+}
+
+// junitTestCase is the result of one task on one host
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure marks a failed test case
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitSkipped marks a skipped test case
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// JUnitCallback writes a JUnit XML report at the end of the run, with a test
+// suite per play and a test case per task and host, so CI systems can show
+// playbook runs as test results. Results outside a play, as in ad-hoc runs,
+// go to a suite named "adhoc".
+type JUnitCallback struct {
+	output io.Writer
+	suites []junitTestSuite
+	mu     sync.Mutex
+}
+
+// NewJUnitCallback creates a new JUnit callback
+func NewJUnitCallback() *JUnitCallback {
+	return &JUnitCallback{output: os.Stdout}
+}
+
+// Name returns "junit"
+func (jc *JUnitCallback) Name() string {
+	return "junit"
+}
+
+// Initialize sets up the plugin
+func (jc *JUnitCallback) Initialize(config map[string]interface{}) error {
+	return nil
+}
+
+// SetOutput sets the output writer
+func (jc *JUnitCallback) SetOutput(writer io.Writer) {
+	jc.output = writer
+}
+
+// OnPlayStart opens a test suite for the play
+func (jc *JUnitCallback) OnPlayStart(play *types.Play) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	jc.suites = append(jc.suites, junitTestSuite{Name: play.Name})
+}
+
+// OnTaskStart handles task start
+func (jc *JUnitCallback) OnTaskStart(task *types.Task, hosts []types.Host) {}
+
+// OnTaskResult adds a test case to the current suite
+func (jc *JUnitCallback) OnTaskResult(task *types.Task, result *types.Result) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	if len(jc.suites) == 0 {
+		jc.suites = append(jc.suites, junitTestSuite{Name: "adhoc"})
+	}
+	suite := &jc.suites[len(jc.suites)-1]
+
+	testCase := junitTestCase{
+		Name:      fmt.Sprintf("[%s] %s", result.Host, task.Name),
+		ClassName: suite.Name,
+		Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+	}
+	switch {
+	case isSkipped(result):
+		testCase.Skipped = &junitSkipped{Message: result.Message}
+		suite.Skipped++
+	case !result.Success:
+		failure := &junitFailure{Message: result.Message, Type: task.Module.String(), Text: result.Message}
+		if result.Error != nil {
+			failure.Text = result.Error.Error()
+		}
+		testCase.Failure = failure
+		suite.Failures++
+	default:
+		testCase.SystemOut = result.Message
+	}
+	suite.Tests++
+	suite.duration += result.Duration
+	suite.Cases = append(suite.Cases, testCase)
+}
+
+// OnPlayEnd handles play end
+func (jc *JUnitCallback) OnPlayEnd(play *types.Play, results []types.Result) {}
+
+// OnRunnerEnd writes the report
+func (jc *JUnitCallback) OnRunnerEnd(stats *RunStats) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	report := junitTestSuites{
+		Name:   "gosible",
+		Time:   fmt.Sprintf("%.3f", stats.EndTime.Sub(stats.StartTime).Seconds()),
+		Suites: jc.suites,
+	}
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		suite.Time = fmt.Sprintf("%.3f", suite.duration.Seconds())
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+	}
+
+	io.WriteString(jc.output, xml.Header)
+	encoder := xml.NewEncoder(jc.output)
+	encoder.Indent("", "  ")
+	encoder.Encode(report)
+	io.WriteString(jc.output, "\n")
+}
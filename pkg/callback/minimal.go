@@ -0,0 +1,85 @@
+package callback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// MinimalCallback prints one line per result without play or task headers,
+// like Ansible's minimal callback used for ad-hoc commands
+type MinimalCallback struct {
+	output io.Writer
+	mu     sync.Mutex
+}
+
+// NewMinimalCallback creates a new minimal callback
+func NewMinimalCallback() *MinimalCallback {
+	return &MinimalCallback{output: os.Stdout}
+}
+
+// Name returns "minimal"
+func (mc *MinimalCallback) Name() string {
+	return "minimal"
+}
+
+// Initialize sets up the plugin
+func (mc *MinimalCallback) Initialize(config map[string]interface{}) error {
+	return nil
+}
+
+// SetOutput sets the output writer
+func (mc *MinimalCallback) SetOutput(writer io.Writer) {
+	mc.output = writer
+}
+
+// OnPlayStart handles play start
+func (mc *MinimalCallback) OnPlayStart(play *types.Play) {}
+
+// OnTaskStart handles task start
+func (mc *MinimalCallback) OnTaskStart(task *types.Task, hosts []types.Host) {}
+
+// OnTaskResult prints the host, its status and the result data
+func (mc *MinimalCallback) OnTaskResult(task *types.Task, result *types.Result) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	status := "SUCCESS"
+	switch {
+	case isSkipped(result):
+		status = "SKIPPED"
+	case isUnreachable(result):
+		status = "UNREACHABLE!"
+	case !result.Success:
+		status = "FAILED!"
+	case result.Changed:
+		status = "CHANGED"
+	}
+
+	data := map[string]interface{}{"changed": result.Changed}
+	for key, value := range result.Data {
+		data[key] = value
+	}
+	if result.Message != "" {
+		data["msg"] = result.Message
+	}
+	if result.Error != nil {
+		data["error"] = result.Error.Error()
+	}
+
+	encoded, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%q", result.Message))
+	}
+	fmt.Fprintf(mc.output, "%s | %s => %s\n", result.Host, status, encoded)
+}
+
+// OnPlayEnd handles play end
+func (mc *MinimalCallback) OnPlayEnd(play *types.Play, results []types.Result) {}
+
+// OnRunnerEnd handles runner end
+func (mc *MinimalCallback) OnRunnerEnd(stats *RunStats) {}
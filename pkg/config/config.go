@@ -165,7 +165,11 @@ func (c *Config) GetDefaults() map[string]interface{} {
 	defaults["gather_facts"] = true
 	defaults["host_key_checking"] = true
 	defaults["retry_files_enabled"] = false
+	defaults["retry_files_save_path"] = ""
 	defaults["log_path"] = ""
+	defaults["module_path"] = ""
+	defaults["ansible_path"] = ""
+	defaults["ansible_library"] = ""
 	defaults["private_key_file"] = ""
 	defaults["remote_user"] = ""
 	defaults["become"] = false
@@ -188,6 +192,8 @@ func (c *Config) GetDefaults() map[string]interface{} {
 	defaults["display_skipped_hosts"] = true
 	defaults["display_ok_hosts"] = true
 	defaults["error_on_undefined_vars"] = false
+	defaults["hash_behaviour"] = "replace"
+	defaults["template_module_args"] = true
 	defaults["system_warnings"] = true
 	defaults["deprecation_warnings"] = true
 	defaults["command_warnings"] = false
@@ -220,7 +226,11 @@ func (c *Config) loadFromEnv() {
 		"gosible_GATHER_FACTS":          "gather_facts",
 		"gosible_HOST_KEY_CHECKING":     "host_key_checking",
 		"gosible_RETRY_FILES_ENABLED":   "retry_files_enabled",
+		"gosible_RETRY_FILES_SAVE_PATH": "retry_files_save_path",
 		"gosible_LOG_PATH":              "log_path",
+		"gosible_MODULE_PATH":           "module_path",
+		"gosible_ANSIBLE_PATH":          "ansible_path",
+		"gosible_ANSIBLE_LIBRARY":       "ansible_library",
 		"gosible_PRIVATE_KEY_FILE":      "private_key_file",
 		"gosible_REMOTE_USER":           "remote_user",
 		"gosible_BECOME":                "become",
@@ -237,6 +247,8 @@ func (c *Config) loadFromEnv() {
 		"gosible_DISPLAY_SKIPPED_HOSTS": "display_skipped_hosts",
 		"gosible_DISPLAY_OK_HOSTS":      "display_ok_hosts",
 		"gosible_ERROR_ON_UNDEFINED_VARS": "error_on_undefined_vars",
+		"gosible_TEMPLATE_MODULE_ARGS":    "template_module_args",
+		"gosible_HASH_BEHAVIOUR":          "hash_behaviour",
 		"gosible_SYSTEM_WARNINGS":       "system_warnings",
 		"gosible_DEPRECATION_WARNINGS":  "deprecation_warnings",
 		"gosible_COMMAND_WARNINGS":      "command_warnings",
@@ -402,6 +414,11 @@ func (c *Config) Validate() error {
 		return types.NewValidationError("become_method", becomeMethod, "invalid become method")
 	}
 	
+	// Validate hash_behaviour
+	if hashBehaviour := c.GetString("hash_behaviour"); hashBehaviour != "replace" && hashBehaviour != "merge" {
+		return types.NewValidationError("hash_behaviour", hashBehaviour, "hash_behaviour must be replace or merge")
+	}
+	
 	return nil
 }
 
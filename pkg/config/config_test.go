@@ -131,10 +131,14 @@ func TestConfigEnvironmentVariables(t *testing.T) {
 	os.Setenv("gosible_TIMEOUT", "60")
 	os.Setenv("gosible_FORKS", "10")
 	os.Setenv("gosible_GATHER_FACTS", "false")
+	os.Setenv("gosible_RETRY_FILES_ENABLED", "true")
+	os.Setenv("gosible_RETRY_FILES_SAVE_PATH", "/var/lib/gosible/retry")
 	defer func() {
 		os.Unsetenv("gosible_TIMEOUT")
 		os.Unsetenv("gosible_FORKS")
 		os.Unsetenv("gosible_GATHER_FACTS")
+		os.Unsetenv("gosible_RETRY_FILES_ENABLED")
+		os.Unsetenv("gosible_RETRY_FILES_SAVE_PATH")
 	}()
 
 	config := NewConfig()
@@ -151,6 +155,10 @@ func TestConfigEnvironmentVariables(t *testing.T) {
 	if config.GetBool("gather_facts") {
 		t.Error("expected gather_facts false from env, got true")
 	}
+
+	if !config.GetBool("retry_files_enabled") || config.GetString("retry_files_save_path") != "/var/lib/gosible/retry" {
+		t.Errorf("expected retry files settings from env, got %v and %q", config.GetBool("retry_files_enabled"), config.GetString("retry_files_save_path"))
+	}
 }
 
 func TestConfigHasDelete(t *testing.T) {
@@ -256,6 +264,14 @@ func TestConfigValidate(t *testing.T) {
 	if err == nil {
 		t.Error("invalid become_method should cause validation error")
 	}
+
+	// Reset and test invalid hash_behaviour
+	config.Reset()
+	config.SetString("hash_behaviour", "deep")
+	err = config.Validate()
+	if err == nil {
+		t.Error("invalid hash_behaviour should cause validation error")
+	}
 }
 
 func TestConfigDefaults(t *testing.T) {
@@ -281,6 +297,11 @@ func TestConfigDefaults(t *testing.T) {
 			t.Errorf("default %s expected %v, got %v", key, expectedValue, defaults[key])
 		}
 	}
+
+	// Task arguments are templated, leaving undefined variables in place
+	if !config.GetBool("template_module_args") || config.GetBool("error_on_undefined_vars") {
+		t.Error("expected argument templating to be enabled and non-strict by default")
+	}
 }
 
 func TestGetConfigPaths(t *testing.T) {
@@ -12,8 +12,10 @@ import (
 type ConnectionType string
 
 const (
-	ConnectionTypeLocal ConnectionType = "local"
-	ConnectionTypeSSH   ConnectionType = "ssh"
+	ConnectionTypeLocal   ConnectionType = "local"
+	ConnectionTypeSSH     ConnectionType = "ssh"
+	ConnectionTypeKubectl ConnectionType = "kubectl"
+	ConnectionTypeHTTPAPI ConnectionType = "httpapi"
 )
 
 // ConnectionManager manages connection plugins
@@ -37,6 +39,12 @@ func NewConnectionManager() *ConnectionManager {
 	manager.RegisterPlugin(ConnectionTypeSSH, func() types.Connection {
 		return NewSSHConnection()
 	})
+	manager.RegisterPlugin(ConnectionTypeKubectl, func() types.Connection {
+		return NewKubernetesConnection()
+	})
+	manager.RegisterPlugin(ConnectionTypeHTTPAPI, func() types.Connection {
+		return NewHTTPAPIConnection()
+	})
 
 	return manager
 }
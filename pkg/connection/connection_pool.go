@@ -19,6 +19,8 @@ type ConnectionPoolConfig struct {
 	HealthCheckInterval time.Duration // Interval for health checking idle connections
 	RetryAttempts      int           // Number of retry attempts for failed connections
 	RetryDelay         time.Duration // Delay between retry attempts
+	MaxSessionsPerConnection int     // Maximum concurrent sessions multiplexed over one SSH connection
+	KeepAliveInterval  time.Duration // Interval between keepalives on pooled SSH connections, 0 to disable
 }
 
 // DefaultConnectionPoolConfig returns default configuration for connection pooling
@@ -30,6 +32,8 @@ func DefaultConnectionPoolConfig() ConnectionPoolConfig {
 		HealthCheckInterval: 1 * time.Minute,
 		RetryAttempts:       3,
 		RetryDelay:          1 * time.Second,
+		MaxSessionsPerConnection: 10,
+		KeepAliveInterval:   30 * time.Second,
 	}
 }
 
@@ -42,6 +46,19 @@ type PooledConnection struct {
 	HealthCheck  time.Time
 	CreatedAt    time.Time
 	UseCount     int64
+	Sessions     int // Sessions currently multiplexed over the connection
+}
+
+// multiplexer is implemented by connections that run concurrent sessions
+// over one transport, such as an SSH client opening a channel per command
+type multiplexer interface {
+	Multiplexed() bool
+}
+
+// keepAliver is implemented by connections that can send a keepalive
+// without running a command
+type keepAliver interface {
+	KeepAlive() error
 }
 
 // ConnectionPool manages a pool of connections for efficient reuse
@@ -50,7 +67,11 @@ type ConnectionPool struct {
 	connections map[string][]*PooledConnection // keyed by host:port:user
 	mutex       sync.RWMutex
 	healthCheck *time.Ticker
+	keepAlive   *time.Ticker
 	quit        chan bool
+
+	// newConnection creates an unconnected connection for a host
+	newConnection func(info types.ConnectionInfo) types.Connection
 }
 
 // NewConnectionPool creates a new connection pool with the given configuration
@@ -59,38 +80,53 @@ func NewConnectionPool(config ConnectionPoolConfig) *ConnectionPool {
 		config:      config,
 		connections: make(map[string][]*PooledConnection),
 		quit:        make(chan bool),
+		newConnection: func(info types.ConnectionInfo) types.Connection {
+			if info.IsWindows() {
+				return NewWinRMConnection()
+			}
+			return NewSSHConnection()
+		},
 	}
 
-	// Start background health checker
+	// Start background health checker and keepalives
 	pool.healthCheck = time.NewTicker(config.HealthCheckInterval)
+	if config.KeepAliveInterval > 0 {
+		pool.keepAlive = time.NewTicker(config.KeepAliveInterval)
+	}
 	go pool.backgroundHealthCheck()
 
 	return pool
 }
 
-// Get retrieves or creates a connection for the given connection info
+// Get retrieves or creates a connection for the given connection info. SSH
+// connections are shared: each caller gets a session multiplexed over the
+// same client until MaxSessionsPerConnection is reached, so parallel tasks
+// on a host reuse one handshake. Every Get must be paired with a Release.
 func (p *ConnectionPool) Get(ctx context.Context, info types.ConnectionInfo) (types.Connection, error) {
 	key := p.connectionKey(info)
 
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	// Look for available connection in pool
+	// Look for a connection with a free session in the pool
 	if conns, exists := p.connections[key]; exists {
 		for _, conn := range conns {
-			if !conn.InUse && conn.Connection.IsConnected() {
-				// Check if connection is too old
-				if time.Since(conn.LastUsed) > p.config.MaxIdleTime {
-					p.removeConnection(key, conn)
-					continue
-				}
+			if conn.Sessions >= p.maxSessions(conn) || !conn.Connection.IsConnected() {
+				continue
+			}
 
-				// Mark as in use and return
-				conn.InUse = true
-				conn.LastUsed = time.Now()
-				conn.UseCount++
-				return conn.Connection, nil
+			// Check if connection is too old
+			if conn.Sessions == 0 && time.Since(conn.LastUsed) > p.config.MaxIdleTime {
+				p.removeConnection(key, conn)
+				continue
 			}
+
+			// Add a session and return
+			conn.Sessions++
+			conn.InUse = true
+			conn.LastUsed = time.Now()
+			conn.UseCount++
+			return conn.Connection, nil
 		}
 	}
 
@@ -111,12 +147,7 @@ func (p *ConnectionPool) Get(ctx context.Context, info types.ConnectionInfo) (ty
 			time.Sleep(p.config.RetryDelay)
 		}
 
-		// Create connection based on type
-		if info.IsWindows() {
-			conn = NewWinRMConnection()
-		} else {
-			conn = NewSSHConnection()
-		}
+		conn = p.newConnection(info)
 
 		// Set connection timeout
 		ctxWithTimeout := ctx
@@ -150,6 +181,7 @@ func (p *ConnectionPool) Get(ctx context.Context, info types.ConnectionInfo) (ty
 		HealthCheck: time.Now(),
 		CreatedAt:   time.Now(),
 		UseCount:    1,
+		Sessions:    1,
 	}
 
 	if _, exists := p.connections[key]; !exists {
@@ -160,7 +192,8 @@ func (p *ConnectionPool) Get(ctx context.Context, info types.ConnectionInfo) (ty
 	return conn, nil
 }
 
-// Release returns a connection to the pool
+// Release ends a session on a connection, returning the connection to the
+// pool once its last session is released
 func (p *ConnectionPool) Release(conn types.Connection) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
@@ -169,7 +202,10 @@ func (p *ConnectionPool) Release(conn types.Connection) {
 	for key, conns := range p.connections {
 		for _, pooledConn := range conns {
 			if pooledConn.Connection == conn {
-				pooledConn.InUse = false
+				if pooledConn.Sessions > 0 {
+					pooledConn.Sessions--
+				}
+				pooledConn.InUse = pooledConn.Sessions > 0
 				pooledConn.LastUsed = time.Now()
 				return
 			}
@@ -178,6 +214,14 @@ func (p *ConnectionPool) Release(conn types.Connection) {
 	}
 }
 
+// maxSessions returns how many callers may share a connection
+func (p *ConnectionPool) maxSessions(conn *PooledConnection) int {
+	if m, ok := conn.Connection.(multiplexer); ok && m.Multiplexed() && p.config.MaxSessionsPerConnection > 1 {
+		return p.config.MaxSessionsPerConnection
+	}
+	return 1
+}
+
 // Close closes all connections in the pool and stops background tasks
 func (p *ConnectionPool) Close() error {
 	p.mutex.Lock()
@@ -187,6 +231,9 @@ func (p *ConnectionPool) Close() error {
 	if p.healthCheck != nil {
 		p.healthCheck.Stop()
 	}
+	if p.keepAlive != nil {
+		p.keepAlive.Stop()
+	}
 	
 	// Check if quit channel is already closed
 	select {
@@ -236,6 +283,8 @@ func (p *ConnectionPool) Stats() PoolStats {
 				hostStats.IdleConnections++
 			}
 			hostStats.TotalUseCount += conn.UseCount
+			hostStats.ActiveSessions += conn.Sessions
+			stats.ActiveSessions += conn.Sessions
 		}
 
 		stats.HostStats[key] = hostStats
@@ -249,6 +298,7 @@ type PoolStats struct {
 	TotalConnections  int
 	ActiveConnections int
 	IdleConnections   int
+	ActiveSessions    int
 	HostStats         map[string]HostStats
 }
 
@@ -257,6 +307,7 @@ type HostStats struct {
 	TotalConnections  int
 	ActiveConnections int
 	IdleConnections   int
+	ActiveSessions    int
 	TotalUseCount     int64
 }
 
@@ -274,7 +325,11 @@ func (p *ConnectionPool) connectionKey(info types.ConnectionInfo) string {
 			port = 22
 		}
 	}
-	return fmt.Sprintf("%s:%d:%s", info.Host, port, info.User)
+	key := fmt.Sprintf("%s:%d:%s", info.Host, port, info.User)
+	if route := routeKey(info); route != "" {
+		key += "|" + route
+	}
+	return key
 }
 
 // getTotalConnections returns the total number of connections (assumes mutex is held)
@@ -322,17 +377,73 @@ func (p *ConnectionPool) removeConnection(key string, conn *PooledConnection) {
 }
 
 // backgroundHealthCheck periodically checks the health of idle connections
+// and keeps pooled connections alive
 func (p *ConnectionPool) backgroundHealthCheck() {
+	var keepAlive <-chan time.Time
+	if p.keepAlive != nil {
+		keepAlive = p.keepAlive.C
+	}
+
 	for {
 		select {
 		case <-p.healthCheck.C:
 			p.performHealthCheck()
+		case <-keepAlive:
+			p.performKeepAlive()
 		case <-p.quit:
 			return
 		}
 	}
 }
 
+// performKeepAlive sends a keepalive over every pooled connection that
+// supports one, so idle connections survive NAT and firewall timeouts, and
+// drops idle connections that no longer answer. The keepalives are sent
+// without holding the pool lock.
+func (p *ConnectionPool) performKeepAlive() {
+	p.mutex.RLock()
+	var pooled []*PooledConnection
+	for _, conns := range p.connections {
+		for _, conn := range conns {
+			if _, ok := conn.Connection.(keepAliver); ok && conn.Connection.IsConnected() {
+				pooled = append(pooled, conn)
+			}
+		}
+	}
+	p.mutex.RUnlock()
+
+	var dead []*PooledConnection
+	for _, conn := range pooled {
+		if err := conn.Connection.(keepAliver).KeepAlive(); err != nil {
+			dead = append(dead, conn)
+		}
+	}
+	if len(dead) == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range dead {
+		if conn.Sessions > 0 {
+			// Callers holding sessions see the failure themselves
+			continue
+		}
+		key := p.connectionKey(conn.Info)
+		conns := p.connections[key]
+		for i, candidate := range conns {
+			if candidate == conn {
+				p.removeConnection(key, conn)
+				p.connections[key] = append(conns[:i], conns[i+1:]...)
+				break
+			}
+		}
+		if len(p.connections[key]) == 0 {
+			delete(p.connections, key)
+		}
+	}
+}
+
 // performHealthCheck checks the health of all idle connections
 func (p *ConnectionPool) performHealthCheck() {
 	p.mutex.Lock()
@@ -2,6 +2,7 @@ package connection
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync"
 	"testing"
@@ -144,6 +145,24 @@ func TestConnectionPool_ConnectionKey(t *testing.T) {
 			},
 			expected: "winhost:5986:winuser",
 		},
+		{
+			name: "SSH via jump hosts",
+			info: types.ConnectionInfo{
+				Host:      "10.0.0.5",
+				User:      "deploy",
+				ProxyJump: "ops@bastion1,bastion2:2200",
+			},
+			expected: "10.0.0.5:22:deploy|ops@bastion1:22,@bastion2:2200",
+		},
+		{
+			name: "SSH via proxy command",
+			info: types.ConnectionInfo{
+				Host:         "10.0.0.5",
+				User:         "deploy",
+				ProxyCommand: "ssh -W %h:%p bastion",
+			},
+			expected: "10.0.0.5:22:deploy|proxy=ssh -W %h:%p bastion",
+		},
 	}
 	
 	for _, tt := range tests {
@@ -382,4 +401,170 @@ func BenchmarkConnectionPool_Stats(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		pool.Stats()
 	}
-}
\ No newline at end of file
+}
+// multiplexedConnection is a MockConnection that shares sessions and
+// answers keepalives like an SSH connection
+type multiplexedConnection struct {
+	MockConnection
+	keepAlives   int
+	keepAliveErr error
+}
+
+func (m *multiplexedConnection) Multiplexed() bool {
+	return true
+}
+
+func (m *multiplexedConnection) KeepAlive() error {
+	m.keepAlives++
+	return m.keepAliveErr
+}
+
+func newTestPool(config ConnectionPoolConfig, newConnection func() types.Connection) (*ConnectionPool, *int) {
+	dials := 0
+	pool := NewConnectionPool(config)
+	pool.newConnection = func(info types.ConnectionInfo) types.Connection {
+		dials++
+		return newConnection()
+	}
+	return pool, &dials
+}
+
+func TestConnectionPool_MultiplexedSessions(t *testing.T) {
+	config := DefaultConnectionPoolConfig()
+	config.MaxSessionsPerConnection = 2
+	pool, dials := newTestPool(config, func() types.Connection { return &multiplexedConnection{} })
+	defer pool.Close()
+
+	ctx := context.Background()
+	info := types.ConnectionInfo{Host: "web1", User: "deploy"}
+
+	first, _ := pool.Get(ctx, info)
+	second, _ := pool.Get(ctx, info)
+	third, _ := pool.Get(ctx, info)
+	if first != second || first == third || *dials != 2 {
+		t.Fatalf("expected two sessions on the first connection and a second connection, got %d dials", *dials)
+	}
+
+	stats := pool.Stats()
+	if stats.TotalConnections != 2 || stats.ActiveSessions != 3 || stats.HostStats["web1:22:deploy"].ActiveSessions != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// A released session is reused without dialing
+	pool.Release(first)
+	if again, _ := pool.Get(ctx, info); again != first || *dials != 2 {
+		t.Errorf("expected the freed session to be reused, got %d dials", *dials)
+	}
+
+	// The connection goes idle only when its last session is released
+	pool.Release(first)
+	pool.Release(first)
+	if stats := pool.Stats(); stats.IdleConnections != 1 || stats.ActiveConnections != 1 {
+		t.Errorf("expected one idle and one active connection, got %+v", stats)
+	}
+
+	// Another user gets its own connection
+	if other, _ := pool.Get(ctx, types.ConnectionInfo{Host: "web1", User: "root"}); other == first || other == third {
+		t.Error("expected a separate connection per user")
+	}
+}
+
+func TestConnectionPool_ExclusiveConnections(t *testing.T) {
+	pool, dials := newTestPool(DefaultConnectionPoolConfig(), func() types.Connection { return &MockConnection{} })
+	defer pool.Close()
+
+	ctx := context.Background()
+	info := types.ConnectionInfo{Host: "win1", User: "admin", Type: "winrm"}
+
+	first, _ := pool.Get(ctx, info)
+	second, _ := pool.Get(ctx, info)
+	if first == second || *dials != 2 {
+		t.Fatalf("expected connections without multiplexing to be exclusive, got %d dials", *dials)
+	}
+
+	pool.Release(first)
+	if again, _ := pool.Get(ctx, info); again != first || *dials != 2 {
+		t.Errorf("expected the released connection to be reused, got %d dials", *dials)
+	}
+}
+
+func TestConnectionPool_KeepAlive(t *testing.T) {
+	config := DefaultConnectionPoolConfig()
+	config.KeepAliveInterval = 0
+	var conns []*multiplexedConnection
+	pool, _ := newTestPool(config, func() types.Connection {
+		conn := &multiplexedConnection{}
+		conns = append(conns, conn)
+		return conn
+	})
+	defer pool.Close()
+
+	ctx := context.Background()
+	healthy, _ := pool.Get(ctx, types.ConnectionInfo{Host: "web1"})
+	busy, _ := pool.Get(ctx, types.ConnectionInfo{Host: "web2"})
+	dead, _ := pool.Get(ctx, types.ConnectionInfo{Host: "web3"})
+	pool.Release(healthy)
+	pool.Release(dead)
+	conns[1].keepAliveErr = errors.New("broken pipe")
+	conns[2].keepAliveErr = errors.New("broken pipe")
+
+	pool.performKeepAlive()
+
+	for i, conn := range conns {
+		if conn.keepAlives != 1 {
+			t.Errorf("expected connection %d to get one keepalive, got %d", i, conn.keepAlives)
+		}
+	}
+	stats := pool.Stats()
+	if _, ok := stats.HostStats["web3:22:"]; ok || dead.IsConnected() {
+		t.Error("expected the idle connection that failed its keepalive to be closed and dropped")
+	}
+	if stats.TotalConnections != 2 || !busy.IsConnected() {
+		t.Errorf("expected connections in use to be left to their callers, got %+v", stats)
+	}
+}
+
+func TestPooledConnectionManager_MultiplexesSSH(t *testing.T) {
+	server := newTestSSHServer(t, "deploy", "secret")
+	manager := NewPooledConnectionManagerWithDefaults()
+	defer manager.Close()
+
+	info := types.ConnectionInfo{Host: "127.0.0.1", Port: server.port(), User: "deploy", Password: "secret", Timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := manager.ExecuteOnHost(t.Context(), info, "true", types.ExecuteOptions{})
+			if err == nil && !result.Success {
+				err = result.Error
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("ExecuteOnHost failed: %v", err)
+		}
+	}
+
+	if server.activeConns() != 1 {
+		t.Errorf("expected parallel commands to share one SSH connection, got %d", server.activeConns())
+	}
+	if stats := manager.Stats(); stats.TotalConnections != 1 || stats.ActiveSessions != 0 {
+		t.Errorf("expected one idle connection, got %+v", stats)
+	}
+
+	conn, err := manager.Connect(t.Context(), info)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer manager.Release(conn)
+	if err := conn.(*SSHConnection).KeepAlive(); err != nil {
+		t.Errorf("expected the keepalive to be answered: %v", err)
+	}
+}
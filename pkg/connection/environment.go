@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/become"
+)
+
+// envExports returns the POSIX shell statements exporting env, ordered by
+// variable name
+func envExports(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	exports := make([]string, len(keys))
+	for i, k := range keys {
+		exports[i] = fmt.Sprintf("export %s=%s", k, shellQuote(env[k]))
+	}
+	return exports
+}
+
+// withEnv prefixes a POSIX shell command with the exports of env. Applied
+// before become, the variables survive sudo resetting the environment, and
+// over SSH they do not depend on the server accepting them.
+func withEnv(command string, env map[string]string) string {
+	if len(env) == 0 {
+		return command
+	}
+	return strings.Join(append(envExports(env), command), " && ")
+}
+
+// withWindowsEnv prefixes a PowerShell or cmd.exe command with statements
+// setting env
+func withWindowsEnv(command string, env map[string]string, powershell bool) string {
+	if len(env) == 0 {
+		return command
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		if powershell {
+			fmt.Fprintf(&b, "$env:%s = %s; ", k, become.QuotePowerShell(env[k]))
+		} else {
+			fmt.Fprintf(&b, "set \"%s=%s\" && ", k, env[k])
+		}
+	}
+	return b.String() + command
+}
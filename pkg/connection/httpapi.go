@@ -0,0 +1,382 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// HTTP API authentication schemes
+const (
+	HTTPAPIAuthNone    = "none"
+	HTTPAPIAuthBasic   = "basic"   // User and password on every request
+	HTTPAPIAuthToken   = "token"   // A static API token
+	HTTPAPIAuthSession = "session" // A token or cookie obtained by logging in
+)
+
+// httpAPISettings is the endpoint and authentication of an httpapi host,
+// resolved from its connection info and ansible_httpapi_* variables
+type httpAPISettings struct {
+	baseURL     string
+	auth        string
+	user        string
+	password    string
+	token       string
+	tokenHeader string
+	loginPath   string
+	tokenField  string
+	tlsConfig   *tls.Config
+}
+
+// resolveHTTPAPI reads the settings of an httpapi host. The variables are:
+//
+//	ansible_httpapi_use_ssl          https instead of http (use_ssl)
+//	ansible_httpapi_port             port, 443 or 80 by default
+//	ansible_httpapi_validate_certs   verify the server certificate, true by default
+//	ansible_httpapi_base_path        prefix of every request path, e.g. /api/v2
+//	ansible_httpapi_auth             basic, token, session or none
+//	ansible_httpapi_token            the API token of token auth
+//	ansible_httpapi_token_header     header carrying the token, Authorization by default
+//	ansible_httpapi_login_path       where session auth posts the user and password
+//	ansible_httpapi_token_field      field of the login response holding the token
+//	ansible_httpapi_ca_cert          PEM file of CAs to trust (ca_cert)
+//	ansible_httpapi_client_cert      PEM client certificate (client_cert)
+//	ansible_httpapi_client_key       PEM key of the client certificate (client_key)
+//	ansible_httpapi_tls_server_name  name to verify the certificate against (tls_server_name)
+//
+// Auth defaults to token when a token is set, to basic when a user is set
+// and to none otherwise.
+func resolveHTTPAPI(info types.ConnectionInfo) (httpAPISettings, error) {
+	vars := info.Variables
+	str := func(name, fallback string) string {
+		if v, ok := vars["ansible_httpapi_"+name]; ok {
+			return types.ConvertToString(v)
+		}
+		return fallback
+	}
+	flag := func(name string, fallback bool) bool {
+		if v, ok := vars["ansible_httpapi_"+name]; ok {
+			return types.ConvertToBool(v)
+		}
+		return fallback
+	}
+
+	useSSL := flag("use_ssl", info.UseSSL)
+	port := info.Port
+	if v, ok := vars["ansible_httpapi_port"]; ok {
+		p, err := strconv.Atoi(types.ConvertToString(v))
+		if err != nil {
+			return httpAPISettings{}, fmt.Errorf("invalid ansible_httpapi_port: %w", err)
+		}
+		port = p
+	} else if port == 0 || port == 22 {
+		// 22 is the inventory default meant for SSH
+		port = 80
+		if useSSL {
+			port = 443
+		}
+	}
+	scheme := "http"
+	if useSSL {
+		scheme = "https"
+	}
+	basePath := strings.TrimRight(str("base_path", ""), "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	settings := httpAPISettings{
+		baseURL:     fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(info.Host, strconv.Itoa(port)), basePath),
+		user:        info.User,
+		password:    info.Password,
+		token:       str("token", ""),
+		tokenHeader: str("token_header", "Authorization"),
+		loginPath:   str("login_path", ""),
+		tokenField:  str("token_field", "token"),
+	}
+
+	settings.auth = str("auth", "")
+	if settings.auth == "" {
+		switch {
+		case settings.token != "":
+			settings.auth = HTTPAPIAuthToken
+		case settings.user != "":
+			settings.auth = HTTPAPIAuthBasic
+		default:
+			settings.auth = HTTPAPIAuthNone
+		}
+	}
+	switch settings.auth {
+	case HTTPAPIAuthNone, HTTPAPIAuthBasic:
+	case HTTPAPIAuthToken:
+		if settings.token == "" {
+			return settings, fmt.Errorf("token auth requires ansible_httpapi_token")
+		}
+	case HTTPAPIAuthSession:
+		if settings.loginPath == "" {
+			return settings, fmt.Errorf("session auth requires ansible_httpapi_login_path")
+		}
+	default:
+		return settings, fmt.Errorf("unknown ansible_httpapi_auth %q, expected basic, token, session or none", settings.auth)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: info.SkipVerify || !flag("validate_certs", true),
+		ServerName:         str("tls_server_name", info.TLSServerName),
+	}
+	if caCert := str("ca_cert", info.CACert); caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return settings, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return settings, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	clientCert, clientKey := str("client_cert", info.ClientCert), str("client_key", info.ClientKey)
+	if clientCert != "" || clientKey != "" {
+		if clientKey == "" {
+			clientKey = clientCert // Both in one PEM file
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return settings, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	settings.tlsConfig = tlsConfig
+
+	return settings, nil
+}
+
+// HTTPAPIConnection manages devices and appliances through their HTTP API,
+// keeping an authenticated session for the modules that send requests over
+// it. It has no shell, so commands and file transfers are not supported.
+type HTTPAPIConnection struct {
+	settings  httpAPISettings
+	client    *http.Client
+	host      string
+	connected bool
+
+	mu           sync.Mutex
+	sessionToken string
+}
+
+// NewHTTPAPIConnection creates a new HTTP API connection
+func NewHTTPAPIConnection() *HTTPAPIConnection {
+	return &HTTPAPIConnection{}
+}
+
+// Connect resolves the API endpoint and, for session auth, logs in
+func (c *HTTPAPIConnection) Connect(ctx context.Context, info types.ConnectionInfo) error {
+	c.host = info.Host
+	if info.Host == "" {
+		return types.NewConnectionError(info.Host, "no host specified", nil)
+	}
+
+	settings, err := resolveHTTPAPI(info)
+	if err != nil {
+		return types.NewConnectionError(info.Host, "invalid httpapi settings", err)
+	}
+	c.settings = settings
+
+	jar, _ := cookiejar.New(nil)
+	c.client = &http.Client{
+		Timeout:   info.Timeout,
+		Jar:       jar,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: settings.tlsConfig},
+	}
+
+	if settings.auth == HTTPAPIAuthSession {
+		if err := c.login(ctx); err != nil {
+			return types.NewConnectionError(info.Host, "login failed", err)
+		}
+	}
+
+	c.connected = true
+	return nil
+}
+
+// login posts the user and password as JSON to the login path and keeps
+// the token the response holds. Devices that answer with a session cookie
+// instead are authenticated through the cookie jar.
+func (c *HTTPAPIConnection) login(ctx context.Context) error {
+	body, err := json.Marshal(map[string]string{"username": c.settings.user, "password": c.settings.password})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, types.HTTPRequest{
+		Method:  http.MethodPost,
+		Path:    c.settings.loginPath,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}, false)
+	if err != nil {
+		return err
+	}
+	if err := resp.Error(); err != nil {
+		return err
+	}
+
+	var data interface{}
+	if len(resp.Body) > 0 {
+		if err := resp.JSON(&data); err != nil {
+			return err
+		}
+	}
+	token := lookupField(data, c.settings.tokenField)
+	if token == "" && len(resp.Headers.Values("Set-Cookie")) == 0 {
+		return fmt.Errorf("login response has no %q field and sets no cookie", c.settings.tokenField)
+	}
+
+	c.mu.Lock()
+	c.sessionToken = token
+	c.mu.Unlock()
+	return nil
+}
+
+// lookupField returns the string at a dotted path of a decoded JSON
+// document, such as "data.token"
+func lookupField(data interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		data = object[key]
+	}
+	if data == nil {
+		return ""
+	}
+	return types.ConvertToString(data)
+}
+
+// Send sends a request to the device API. A session that expired, shown by
+// a 401 response, is renewed by logging in again and the request retried
+// once.
+func (c *HTTPAPIConnection) Send(ctx context.Context, request types.HTTPRequest) (*types.HTTPResponse, error) {
+	if !c.connected {
+		return nil, types.NewConnectionError(c.host, "not connected", nil)
+	}
+
+	resp, err := c.do(ctx, request, true)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && c.settings.auth == HTTPAPIAuthSession {
+		if err := c.login(ctx); err != nil {
+			return nil, types.NewConnectionError(c.host, "session renewal failed", err)
+		}
+		resp, err = c.do(ctx, request, true)
+	}
+	if err != nil {
+		return nil, types.NewConnectionError(c.host, fmt.Sprintf("%s %s failed", request.Method, request.Path), err)
+	}
+	return resp, nil
+}
+
+// do sends a single request, with credentials when authenticate is set
+func (c *HTTPAPIConnection) do(ctx context.Context, request types.HTTPRequest, authenticate bool) (*types.HTTPResponse, error) {
+	method := request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := request.Path
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var body io.Reader
+	if request.Body != nil {
+		body = bytes.NewReader(request.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.settings.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range request.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if authenticate {
+		switch c.settings.auth {
+		case HTTPAPIAuthBasic:
+			req.SetBasicAuth(c.settings.user, c.settings.password)
+		case HTTPAPIAuthToken:
+			c.setToken(req, c.settings.token)
+		case HTTPAPIAuthSession:
+			c.mu.Lock()
+			token := c.sessionToken
+			c.mu.Unlock()
+			if token != "" {
+				c.setToken(req, token)
+			}
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &types.HTTPResponse{StatusCode: resp.StatusCode, Headers: resp.Header, Body: data}, nil
+}
+
+// setToken sets the token header, as a bearer token when it is the
+// Authorization header
+func (c *HTTPAPIConnection) setToken(req *http.Request, token string) {
+	if strings.EqualFold(c.settings.tokenHeader, "Authorization") {
+		token = "Bearer " + token
+	}
+	req.Header.Set(c.settings.tokenHeader, token)
+}
+
+// Execute is not supported; modules managing the device use Send
+func (c *HTTPAPIConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	return nil, types.NewConnectionError(c.host, "httpapi connections cannot execute commands", nil)
+}
+
+// Copy is not supported; the device API has no file system to copy to
+func (c *HTTPAPIConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	return types.NewConnectionError(c.host, "httpapi connections cannot copy files", nil)
+}
+
+// Fetch is not supported; the device API has no file system to fetch from
+func (c *HTTPAPIConnection) Fetch(ctx context.Context, src string) (io.Reader, error) {
+	return nil, types.NewConnectionError(c.host, "httpapi connections cannot fetch files", nil)
+}
+
+// Close drops the session and its idle connections
+func (c *HTTPAPIConnection) Close() error {
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
+	c.mu.Lock()
+	c.sessionToken = ""
+	c.mu.Unlock()
+	c.connected = false
+	return nil
+}
+
+// IsConnected returns true if the connection is active
+func (c *HTTPAPIConnection) IsConnected() bool {
+	return c.connected
+}
@@ -0,0 +1,261 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// httpAPIInfo returns the connection info of an httpapi host served by server
+func httpAPIInfo(t *testing.T, server *httptest.Server, vars map[string]interface{}) types.ConnectionInfo {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(server.URL, "https://"), "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return types.ConnectionInfo{
+		Type:      "httpapi",
+		Host:      host,
+		Port:      p,
+		User:      "admin",
+		Password:  "secret",
+		Timeout:   5 * time.Second,
+		Variables: vars,
+	}
+}
+
+func TestResolveHTTPAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    types.ConnectionInfo
+		baseURL string
+		auth    string
+	}{
+		{"Defaults", types.ConnectionInfo{Host: "lb1", Port: 22}, "http://lb1:80", HTTPAPIAuthNone},
+		{"SSL", types.ConnectionInfo{Host: "lb1", UseSSL: true, User: "admin"}, "https://lb1:443", HTTPAPIAuthBasic},
+		{"Vars", types.ConnectionInfo{Host: "lb1", Variables: map[string]interface{}{
+			"ansible_httpapi_use_ssl":   true,
+			"ansible_httpapi_port":      8443,
+			"ansible_httpapi_base_path": "api/v2/",
+			"ansible_httpapi_token":     "abc",
+		}}, "https://lb1:8443/api/v2", HTTPAPIAuthToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := resolveHTTPAPI(tt.info)
+			if err != nil {
+				t.Fatalf("resolveHTTPAPI failed: %v", err)
+			}
+			if settings.baseURL != tt.baseURL || settings.auth != tt.auth {
+				t.Errorf("expected %s with %s auth, got %s with %s", tt.baseURL, tt.auth, settings.baseURL, settings.auth)
+			}
+		})
+	}
+
+	for _, vars := range []map[string]interface{}{
+		{"ansible_httpapi_auth": "kerberos"},
+		{"ansible_httpapi_auth": "token"},
+		{"ansible_httpapi_auth": "session"},
+		{"ansible_httpapi_port": "https"},
+		{"ansible_httpapi_ca_cert": "/nonexistent/ca.pem"},
+	} {
+		if _, err := resolveHTTPAPI(types.ConnectionInfo{Host: "lb1", Variables: vars}); err == nil {
+			t.Errorf("expected %v to be rejected", vars)
+		}
+	}
+}
+
+func TestHTTPAPIConnection_Auth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":          r.URL.RequestURI(),
+			"user":          user + ":" + password,
+			"authorization": r.Header.Get("Authorization"),
+			"api_key":       r.Header.Get("X-API-Key"),
+		})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		vars     map[string]interface{}
+		expected map[string]string
+	}{
+		{
+			name:     "Basic",
+			vars:     map[string]interface{}{"ansible_httpapi_base_path": "/api"},
+			expected: map[string]string{"path": "/api/pools?limit=5", "user": "admin:secret"},
+		},
+		{
+			name:     "BearerToken",
+			vars:     map[string]interface{}{"ansible_httpapi_token": "abc"},
+			expected: map[string]string{"path": "/pools?limit=5", "user": ":", "authorization": "Bearer abc"},
+		},
+		{
+			name:     "TokenHeader",
+			vars:     map[string]interface{}{"ansible_httpapi_token": "abc", "ansible_httpapi_token_header": "X-API-Key"},
+			expected: map[string]string{"path": "/pools?limit=5", "user": ":", "api_key": "abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewHTTPAPIConnection()
+			if err := conn.Connect(context.Background(), httpAPIInfo(t, server, tt.vars)); err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			defer conn.Close()
+
+			resp, err := conn.Send(context.Background(), types.HTTPRequest{Path: "pools?limit=5"})
+			if err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			var got map[string]string
+			if err := resp.JSON(&got); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.expected {
+				if got[k] != v {
+					t.Errorf("expected %s=%q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPAPIConnection_Session(t *testing.T) {
+	var logins, token atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			var creds map[string]string
+			json.NewDecoder(r.Body).Decode(&creds)
+			if creds["username"] != "admin" || creds["password"] != "secret" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			logins.Add(1)
+			token.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"token": "t" + strconv.Itoa(int(token.Load()))}})
+		case "/expire":
+			token.Add(1)
+		default:
+			if r.Header.Get("Authorization") != "Bearer t"+strconv.Itoa(int(token.Load())) {
+				http.Error(w, "session expired", http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	vars := map[string]interface{}{
+		"ansible_httpapi_auth":        "session",
+		"ansible_httpapi_login_path":  "/login",
+		"ansible_httpapi_token_field": "data.token",
+	}
+	conn := NewHTTPAPIConnection()
+	if err := conn.Connect(context.Background(), httpAPIInfo(t, server, vars)); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	request, _ := types.NewJSONRequest(http.MethodPost, "/pools", map[string]string{"name": "web"})
+	resp, err := conn.Send(context.Background(), request)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %v, %v", resp, err)
+	}
+
+	// The device forgets the session; the connection logs in again
+	conn.Send(context.Background(), types.HTTPRequest{Path: "/expire"})
+	resp, err = conn.Send(context.Background(), request)
+	if err != nil || !resp.OK() {
+		t.Fatalf("expected the request to succeed after logging in again, got %v, %v", resp, err)
+	}
+	if logins.Load() != 2 {
+		t.Errorf("expected 2 logins, got %d", logins.Load())
+	}
+
+	info := httpAPIInfo(t, server, vars)
+	info.Password = "wrong"
+	if err := NewHTTPAPIConnection().Connect(context.Background(), info); err == nil || !strings.Contains(err.Error(), "bad credentials") {
+		t.Errorf("expected a login failure, got %v", err)
+	}
+}
+
+func TestHTTPAPIConnection_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	// The test certificate is issued for example.com
+	info := httpAPIInfo(t, server, map[string]interface{}{"ansible_httpapi_use_ssl": true})
+	info.CACert = caFile
+	info.TLSServerName = "example.com"
+	conn := NewHTTPAPIConnection()
+	if err := conn.Connect(context.Background(), info); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if resp, err := conn.Send(context.Background(), types.HTTPRequest{Path: "/"}); err != nil || !resp.OK() {
+		t.Errorf("expected the CA to be trusted, got %v, %v", resp, err)
+	}
+
+	untrusted := httpAPIInfo(t, server, map[string]interface{}{"ansible_httpapi_use_ssl": true})
+	conn = NewHTTPAPIConnection()
+	conn.Connect(context.Background(), untrusted)
+	if _, err := conn.Send(context.Background(), types.HTTPRequest{Path: "/"}); err == nil {
+		t.Error("expected an unknown CA to be rejected")
+	}
+
+	untrusted.Variables["ansible_httpapi_validate_certs"] = false
+	conn = NewHTTPAPIConnection()
+	conn.Connect(context.Background(), untrusted)
+	if _, err := conn.Send(context.Background(), types.HTTPRequest{Path: "/"}); err != nil {
+		t.Errorf("expected validate_certs=false to skip verification, got %v", err)
+	}
+
+	if result := Probe(context.Background(), info, time.Second); !result.Reachable() || !result.Probed {
+		t.Errorf("expected the probe to reach the device, got %+v", result)
+	}
+}
+
+func TestHTTPAPIConnection_Unsupported(t *testing.T) {
+	conn := NewHTTPAPIConnection()
+	if _, err := conn.Send(context.Background(), types.HTTPRequest{}); err == nil {
+		t.Error("expected Send without a connection to fail")
+	}
+	if _, err := conn.Execute(context.Background(), "show version", types.ExecuteOptions{}); err == nil {
+		t.Error("expected Execute to fail")
+	}
+	if err := conn.Copy(context.Background(), strings.NewReader(""), "/tmp/x", 0644); err == nil {
+		t.Error("expected Copy to fail")
+	}
+	if _, err := conn.Fetch(context.Background(), "/tmp/x"); err == nil {
+		t.Error("expected Fetch to fail")
+	}
+
+	var _ types.HTTPAPIConnection = conn
+	if c, err := DefaultConnectionManager.CreateConnection(ConnectionTypeHTTPAPI); err != nil {
+		t.Errorf("expected httpapi to be registered: %v", err)
+	} else if _, ok := c.(types.HTTPAPIConnection); !ok {
+		t.Error("expected the httpapi plugin to implement types.HTTPAPIConnection")
+	}
+}
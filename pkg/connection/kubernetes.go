@@ -0,0 +1,359 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/become"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// KubernetesConnection executes commands inside a pod container the way
+// `kubectl exec` does. The target is selected with ansible_kubectl_* host
+// variables; the pod defaults to the inventory host address.
+type KubernetesConnection struct {
+	binary     string
+	kubeconfig string
+	context    string
+	namespace  string
+	pod        string
+	container  string
+	extraArgs  []string
+	connected  bool
+	info       types.ConnectionInfo
+}
+
+// NewKubernetesConnection creates a new Kubernetes pod connection
+func NewKubernetesConnection() *KubernetesConnection {
+	return &KubernetesConnection{binary: "kubectl"}
+}
+
+// Connect resolves the target pod and verifies that it accepts exec
+func (c *KubernetesConnection) Connect(ctx context.Context, info types.ConnectionInfo) error {
+	c.info = info
+	c.pod = info.Host
+
+	vars := info.Variables
+	if v, ok := vars["ansible_kubectl_pod"]; ok {
+		c.pod = types.ConvertToString(v)
+	}
+	if v, ok := vars["ansible_kubectl_namespace"]; ok {
+		c.namespace = types.ConvertToString(v)
+	}
+	if v, ok := vars["ansible_kubectl_container"]; ok {
+		c.container = types.ConvertToString(v)
+	}
+	if v, ok := vars["ansible_kubectl_context"]; ok {
+		c.context = types.ConvertToString(v)
+	}
+	if v, ok := vars["ansible_kubectl_kubeconfig"]; ok {
+		c.kubeconfig = types.ConvertToString(v)
+	}
+	if v, ok := vars["ansible_kubectl_extra_args"]; ok {
+		c.extraArgs = strings.Fields(types.ConvertToString(v))
+	}
+
+	if c.pod == "" {
+		return types.NewConnectionError(info.Host, "no pod specified", nil)
+	}
+
+	if _, err := exec.LookPath(c.binary); err != nil {
+		return types.NewConnectionError(c.pod, "kubectl not found", err)
+	}
+
+	cmd := exec.CommandContext(ctx, c.binary, c.execArgs(false, "true")...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return types.NewConnectionError(c.pod, fmt.Sprintf("cannot exec into pod: %s", strings.TrimSpace(string(output))), err)
+	}
+
+	c.connected = true
+	return nil
+}
+
+// execArgs builds the kubectl arguments to run command in the target container
+func (c *KubernetesConnection) execArgs(stdin bool, command string) []string {
+	var args []string
+	if c.kubeconfig != "" {
+		args = append(args, "--kubeconfig", c.kubeconfig)
+	}
+	if c.context != "" {
+		args = append(args, "--context", c.context)
+	}
+	if c.namespace != "" {
+		args = append(args, "--namespace", c.namespace)
+	}
+
+	args = append(args, "exec")
+	if stdin {
+		args = append(args, "-i")
+	}
+	args = append(args, c.pod)
+	if c.container != "" {
+		args = append(args, "--container", c.container)
+	}
+	args = append(args, c.extraArgs...)
+
+	return append(args, "--", "sh", "-c", command)
+}
+
+// buildCommand applies working directory, environment and become options
+func (c *KubernetesConnection) buildCommand(command string, options types.ExecuteOptions) (become.Command, error) {
+	wrapped, err := become.DefaultManager.Wrap(command, options)
+	if err != nil {
+		return become.Command{}, err
+	}
+
+	var parts []string
+
+	parts = append(parts, envExports(options.Env)...)
+
+	if options.WorkingDir != "" {
+		parts = append(parts, fmt.Sprintf("cd %s", shellQuote(options.WorkingDir)))
+	}
+
+	parts = append(parts, wrapped.Line)
+	wrapped.Line = strings.Join(parts, " && ")
+	return wrapped, nil
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word
+func shellQuote(s string) string {
+	return become.Quote(s)
+}
+
+// command creates the kubectl process for a remote command, attaching
+// stdin when the command needs input such as a become password
+func (c *KubernetesConnection) command(ctx context.Context, command become.Command) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.binary, c.execArgs(command.Stdin != "", command.Line)...)
+	if command.Stdin != "" {
+		cmd.Stdin = strings.NewReader(command.Stdin)
+	}
+	return cmd
+}
+
+// Execute runs a command in the pod
+func (c *KubernetesConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	if !c.connected {
+		return nil, types.NewConnectionError(c.pod, "not connected", nil)
+	}
+
+	startTime := time.Now()
+	result := &types.Result{
+		StartTime:  startTime,
+		Host:       c.info.Host,
+		ModuleName: "command",
+	}
+
+	cmdCtx := ctx
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	fullCommand, err := c.buildCommand(command, options)
+	if err != nil {
+		return nil, types.NewConnectionError(c.pod, "failed to apply become", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := c.command(cmdCtx, fullCommand)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	endTime := time.Now()
+	result.EndTime = endTime
+	result.Duration = endTime.Sub(startTime)
+	result.Data = map[string]interface{}{
+		"stdout": stdout.String(),
+		"stderr": stderr.String(),
+		"cmd":    command,
+	}
+	c.applyExitStatus(result, err)
+
+	return result, nil
+}
+
+// applyExitStatus records the outcome of a kubectl exec in result
+func (c *KubernetesConnection) applyExitStatus(result *types.Result, err error) {
+	if err == nil {
+		result.Success = true
+		result.Changed = true
+		result.Message = "command executed successfully"
+		result.Data["exit_code"] = 0
+		return
+	}
+
+	result.Success = false
+	result.Error = err
+	result.Message = fmt.Sprintf("command failed: %v", err)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.Data["exit_code"] = exitErr.ExitCode()
+	}
+}
+
+// ExecuteStream runs a command in the pod with real-time output streaming
+func (c *KubernetesConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	if !c.connected {
+		return nil, types.NewConnectionError(c.pod, "not connected", nil)
+	}
+
+	eventChan := make(chan types.StreamEvent, 100)
+
+	go func() {
+		defer close(eventChan)
+
+		startTime := time.Now()
+		fail := func(message string, err error) {
+			eventChan <- types.StreamEvent{
+				Type:      types.StreamError,
+				Error:     types.NewConnectionError(c.pod, message, err),
+				Timestamp: time.Now(),
+			}
+		}
+
+		cmdCtx := ctx
+		if options.Timeout > 0 {
+			var cancel context.CancelFunc
+			cmdCtx, cancel = context.WithTimeout(ctx, options.Timeout)
+			defer cancel()
+		}
+
+		fullCommand, err := c.buildCommand(command, options)
+		if err != nil {
+			fail("failed to apply become", err)
+			return
+		}
+
+		cmd := c.command(cmdCtx, fullCommand)
+		stdoutPipe, err := cmd.StdoutPipe()
+		if err != nil {
+			fail("failed to create stdout pipe", err)
+			return
+		}
+		stderrPipe, err := cmd.StderrPipe()
+		if err != nil {
+			fail("failed to create stderr pipe", err)
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			fail("failed to start kubectl exec", err)
+			return
+		}
+
+		var stdout, stderr strings.Builder
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go c.streamLines(&wg, stdoutPipe, &stdout, false, options, eventChan)
+		go c.streamLines(&wg, stderrPipe, &stderr, true, options, eventChan)
+
+		// Drain the pipes before Wait closes them
+		wg.Wait()
+		waitErr := cmd.Wait()
+
+		endTime := time.Now()
+		result := &types.Result{
+			Host:       c.info.Host,
+			StartTime:  startTime,
+			EndTime:    endTime,
+			Duration:   endTime.Sub(startTime),
+			ModuleName: "streaming_command",
+			Data: map[string]interface{}{
+				"stdout": stdout.String(),
+				"stderr": stderr.String(),
+				"cmd":    command,
+			},
+		}
+		c.applyExitStatus(result, waitErr)
+
+		eventChan <- types.StreamEvent{
+			Type:      types.StreamDone,
+			Result:    result,
+			Timestamp: time.Now(),
+		}
+	}()
+
+	return eventChan, nil
+}
+
+// streamLines forwards output lines to the event channel and callback
+func (c *KubernetesConnection) streamLines(wg *sync.WaitGroup, r io.Reader, buf *strings.Builder, isStderr bool, options types.ExecuteOptions, events chan<- types.StreamEvent) {
+	defer wg.Done()
+
+	eventType := types.StreamStdout
+	if isStderr {
+		eventType = types.StreamStderr
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		buf.WriteString(line + "\n")
+
+		if options.OutputCallback != nil {
+			options.OutputCallback(line, isStderr)
+		}
+		if options.StreamOutput {
+			events <- types.StreamEvent{Type: eventType, Data: line, Timestamp: time.Now()}
+		}
+	}
+}
+
+// Copy writes src to dest inside the container through the exec stdin
+func (c *KubernetesConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	if !c.connected {
+		return types.NewConnectionError(c.pod, "not connected", nil)
+	}
+
+	script := fmt.Sprintf("cat > %s && chmod %o %s", shellQuote(dest), mode, shellQuote(dest))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.binary, c.execArgs(true, script)...)
+	cmd.Stdin = src
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return types.NewConnectionError(c.pod, fmt.Sprintf("failed to copy to %s: %s", dest, strings.TrimSpace(stderr.String())), err)
+	}
+	return nil
+}
+
+// Fetch reads a file from the container
+func (c *KubernetesConnection) Fetch(ctx context.Context, src string) (io.Reader, error) {
+	if !c.connected {
+		return nil, types.NewConnectionError(c.pod, "not connected", nil)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := c.command(ctx, become.Command{Line: "cat " + shellQuote(src)})
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, types.NewConnectionError(c.pod, fmt.Sprintf("failed to fetch %s: %s", src, strings.TrimSpace(stderr.String())), err)
+	}
+	return &stdout, nil
+}
+
+// Close marks the connection closed; kubectl processes are per command
+func (c *KubernetesConnection) Close() error {
+	c.connected = false
+	return nil
+}
+
+// IsConnected returns true if the pod was reachable at connect time
+func (c *KubernetesConnection) IsConnected() bool {
+	return c.connected
+}
@@ -0,0 +1,205 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// fakeKubectl installs a kubectl stand-in that logs its arguments and runs
+// the command after "--" locally, so pod exec behaviour can be exercised
+func fakeKubectl(t *testing.T) (binary, logFile string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	logFile = filepath.Join(dir, "kubectl.log")
+	binary = filepath.Join(dir, "kubectl")
+
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + logFile + "\n" +
+		"while [ \"$1\" != \"--\" ]; do shift; done\n" +
+		"shift\n" +
+		"exec \"$@\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake kubectl: %v", err)
+	}
+	return binary, logFile
+}
+
+func connectFakePod(t *testing.T, vars map[string]interface{}) (*KubernetesConnection, string) {
+	t.Helper()
+
+	binary, logFile := fakeKubectl(t)
+	conn := NewKubernetesConnection()
+	conn.binary = binary
+
+	err := conn.Connect(context.Background(), types.ConnectionInfo{Type: "kubectl", Host: "web-0", Variables: vars})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	return conn, logFile
+}
+
+func TestKubernetesConnection_ExecArgs(t *testing.T) {
+	conn := &KubernetesConnection{
+		kubeconfig: "/etc/kube/config",
+		context:    "prod",
+		namespace:  "shop",
+		pod:        "web-0",
+		container:  "app",
+		extraArgs:  []string{"--request-timeout=10s"},
+	}
+
+	expected := []string{
+		"--kubeconfig", "/etc/kube/config", "--context", "prod", "--namespace", "shop",
+		"exec", "-i", "web-0", "--container", "app", "--request-timeout=10s",
+		"--", "sh", "-c", "uptime",
+	}
+	if got := conn.execArgs(true, "uptime"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestKubernetesConnection_BuildCommand(t *testing.T) {
+	conn := &KubernetesConnection{}
+	got, err := conn.buildCommand("echo $GREETING", types.ExecuteOptions{
+		WorkingDir: "/srv/app",
+		Env:        map[string]string{"GREETING": "it's me"},
+	})
+	if err != nil {
+		t.Fatalf("buildCommand returned error: %v", err)
+	}
+
+	expected := `export GREETING='it'"'"'s me' && cd '/srv/app' && echo $GREETING`
+	if got.Line != expected {
+		t.Errorf("expected %q, got %q", expected, got.Line)
+	}
+
+	got, err = conn.buildCommand("id -u", types.ExecuteOptions{
+		Become: &types.BecomeConfig{Method: "sudo", User: "root", Password: "pw"},
+	})
+	if err != nil {
+		t.Fatalf("buildCommand returned error: %v", err)
+	}
+	if got.Line != `sudo -H -S -p '' -u 'root' sh -c 'id -u'` || got.Stdin != "pw\n" {
+		t.Errorf("unexpected become command %q (stdin %q)", got.Line, got.Stdin)
+	}
+}
+
+func TestKubernetesConnection_Execute(t *testing.T) {
+	conn, logFile := connectFakePod(t, map[string]interface{}{
+		"ansible_kubectl_namespace": "shop",
+		"ansible_kubectl_container": "app",
+	})
+	defer conn.Close()
+
+	result, err := conn.Execute(context.Background(), "echo hello; echo oops >&2; exit 3", types.ExecuteOptions{})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if result.Success {
+		t.Error("expected failure for non-zero exit")
+	}
+	if result.Data["exit_code"] != 3 {
+		t.Errorf("expected exit code 3, got %v", result.Data["exit_code"])
+	}
+	if result.Data["stdout"] != "hello\n" || result.Data["stderr"] != "oops\n" {
+		t.Errorf("unexpected output: %v", result.Data)
+	}
+
+	log, _ := os.ReadFile(logFile)
+	if !strings.Contains(string(log), "--namespace shop exec web-0 --container app --") {
+		t.Errorf("expected namespaced exec into container, got log:\n%s", log)
+	}
+}
+
+func TestKubernetesConnection_CopyAndFetch(t *testing.T) {
+	conn, _ := connectFakePod(t, nil)
+	defer conn.Close()
+
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	if err := conn.Copy(context.Background(), strings.NewReader("listen 8080\n"), dest, 0600); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatalf("copied file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	reader, err := conn.Fetch(context.Background(), dest)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != "listen 8080\n" {
+		t.Errorf("unexpected fetched content %q", data)
+	}
+
+	if _, err := conn.Fetch(context.Background(), dest+".missing"); err == nil {
+		t.Error("expected error fetching missing file")
+	}
+}
+
+func TestKubernetesConnection_ExecuteStream(t *testing.T) {
+	conn, _ := connectFakePod(t, nil)
+	defer conn.Close()
+
+	var callbackLines bytes.Buffer
+	events, err := conn.ExecuteStream(context.Background(), "echo one; echo two", types.ExecuteOptions{
+		StreamOutput: true,
+		OutputCallback: func(line string, isStderr bool) {
+			callbackLines.WriteString(line + ",")
+		},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream failed: %v", err)
+	}
+
+	var lines []string
+	var done *types.Result
+	for event := range events {
+		switch event.Type {
+		case types.StreamStdout:
+			lines = append(lines, event.Data)
+		case types.StreamDone:
+			done = event.Result
+		}
+	}
+
+	if !reflect.DeepEqual(lines, []string{"one", "two"}) {
+		t.Errorf("unexpected streamed lines %v", lines)
+	}
+	if callbackLines.String() != "one,two," {
+		t.Errorf("unexpected callback lines %q", callbackLines.String())
+	}
+	if done == nil || !done.Success {
+		t.Errorf("expected successful final result, got %+v", done)
+	}
+}
+
+func TestKubernetesConnection_ConnectFailures(t *testing.T) {
+	conn := NewKubernetesConnection()
+	conn.binary = filepath.Join(t.TempDir(), "no-kubectl")
+	if err := conn.Connect(context.Background(), types.ConnectionInfo{Host: "web-0"}); err == nil {
+		t.Error("expected error when kubectl is missing")
+	}
+
+	if err := NewKubernetesConnection().Connect(context.Background(), types.ConnectionInfo{}); err == nil {
+		t.Error("expected error when no pod is specified")
+	}
+
+	if _, err := NewKubernetesConnection().Execute(context.Background(), "true", types.ExecuteOptions{}); err == nil {
+		t.Error("expected error executing on unconnected pod")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/liliang-cn/gosible/pkg/become"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -55,16 +56,21 @@ func (c *LocalConnection) Execute(ctx context.Context, command string, options t
 		defer cancel()
 	}
 
-	var cmd *exec.Cmd
-	if options.Sudo && options.User != "" {
-		// Use sudo to run as different user
-		cmd = exec.CommandContext(cmdCtx, "sudo", "-u", options.User, "sh", "-c", command)
-	} else if options.User != "" {
-		// Use su to run as different user
-		cmd = exec.CommandContext(cmdCtx, "su", "-c", command, options.User)
-	} else {
-		// Run as current user
-		cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
+	// Run as current user unless become is requested. sudo resets the
+	// environment, so an escalated command exports it itself.
+	if become.FromOptions(options) != nil {
+		command = withEnv(command, options.Env)
+	}
+	wrapped, err := become.DefaultManager.Wrap(command, options)
+	if err != nil {
+		return nil, types.NewConnectionError("local", "failed to apply become", err)
+	}
+	cmd := exec.CommandContext(cmdCtx, "sh", "-c", wrapped.Line)
+	// Killing the shell leaves its children holding the output open, stop
+	// waiting for them shortly after a cancel
+	cmd.WaitDelay = time.Second
+	if wrapped.Stdin != "" {
+		cmd.Stdin = strings.NewReader(wrapped.Stdin)
 	}
 
 	// Set working directory
@@ -159,16 +165,22 @@ func (c *LocalConnection) ExecuteStream(ctx context.Context, command string, opt
 			defer cancel()
 		}
 
-		var cmd *exec.Cmd
-		if options.Sudo && options.User != "" {
-			// Use sudo to run as different user
-			cmd = exec.CommandContext(cmdCtx, "sudo", "-u", options.User, "sh", "-c", command)
-		} else if options.User != "" {
-			// Use su to run as different user
-			cmd = exec.CommandContext(cmdCtx, "su", "-c", command, options.User)
-		} else {
-			// Run as current user
-			cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
+		// Run as current user unless become is requested
+		if become.FromOptions(options) != nil {
+			command = withEnv(command, options.Env)
+		}
+		wrapped, err := become.DefaultManager.Wrap(command, options)
+		if err != nil {
+			eventChan <- types.StreamEvent{
+				Type:      types.StreamError,
+				Error:     types.NewConnectionError("local", "failed to apply become", err),
+				Timestamp: time.Now(),
+			}
+			return
+		}
+		cmd := exec.CommandContext(cmdCtx, "sh", "-c", wrapped.Line)
+		if wrapped.Stdin != "" {
+			cmd.Stdin = strings.NewReader(wrapped.Stdin)
 		}
 
 		// Set working directory
@@ -268,9 +280,22 @@ func (c *LocalConnection) ExecuteStream(ctx context.Context, command string, opt
 			}
 		}()
 
-		// Wait for command completion
+		// Wait for the output readers to finish before the command, as
+		// Wait closes the pipes and would cut off unread output. A timeout
+		// or cancellation closes them instead, since children of the killed
+		// shell may hold them open.
+		readersDone := make(chan struct{})
+		go func() {
+			select {
+			case <-cmdCtx.Done():
+				stdoutPipe.Close()
+				stderrPipe.Close()
+			case <-readersDone:
+			}
+		}()
+		wg.Wait()
+		close(readersDone)
 		err = cmd.Wait()
-		wg.Wait() // Wait for output readers to finish
 
 		endTime := time.Now()
 
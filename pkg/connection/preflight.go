@@ -0,0 +1,158 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ProbeResult is the outcome of checking that a host accepts connections
+type ProbeResult struct {
+	Endpoint string        // Address that was probed
+	Probed   bool          // False when the connection type has no endpoint to probe
+	Latency  time.Duration // Time until the endpoint answered
+	Err      error         // Why the endpoint is unreachable
+}
+
+// Reachable reports whether the host passed the probe or could not be probed
+func (p ProbeResult) Reachable() bool {
+	return p.Err == nil
+}
+
+// Probe checks that the endpoint a connection would use answers, without
+// authenticating: an SSH server must send its banner, and a WinRM listener
+// or httpapi device must answer HTTP. Hosts behind jump hosts are probed through the first
+// hop, since only it is reachable directly. Local, kubectl and ProxyCommand
+// connections are not probed.
+func Probe(ctx context.Context, info types.ConnectionInfo, timeout time.Duration) ProbeResult {
+	switch info.Type {
+	case "", string(ConnectionTypeSSH):
+		if proxyCommand(info) != "" {
+			return ProbeResult{}
+		}
+		host, port := info.Host, info.Port
+		if hops, err := jumpChain(info); err != nil {
+			return ProbeResult{Probed: true, Err: err}
+		} else if len(hops) > 0 {
+			host, port = hops[0].Host, hopPort(hops[0])
+		}
+		if port == 0 {
+			port = 22
+		}
+		return probeSSH(ctx, net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	case "winrm":
+		port := info.Port
+		if port == 0 {
+			port = 5985
+			if info.UseSSL {
+				port = 5986
+			}
+		}
+		scheme := "http"
+		if info.UseSSL {
+			scheme = "https"
+		}
+		return probeWinRM(ctx, fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(info.Host, strconv.Itoa(port))), info.SkipVerify, timeout)
+	case string(ConnectionTypeHTTPAPI):
+		settings, err := resolveHTTPAPI(info)
+		if err != nil {
+			return ProbeResult{Probed: true, Err: err}
+		}
+		return probeHTTPAPI(ctx, settings, timeout)
+	}
+	return ProbeResult{}
+}
+
+// probeSSH connects to an SSH server and reads its identification banner
+func probeSSH(ctx context.Context, address string, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Endpoint: address, Probed: true}
+	start := time.Now()
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	// Servers may send other lines before the banner (RFC 4253 section 4.2)
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 10; i++ {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			result.Latency = time.Since(start)
+			return result
+		}
+		if err != nil {
+			result.Err = fmt.Errorf("no SSH banner from %s: %w", address, err)
+			return result
+		}
+	}
+	result.Err = fmt.Errorf("no SSH banner from %s", address)
+	return result
+}
+
+// probeWinRM sends an unauthenticated request to a WinRM listener. Any HTTP
+// response, typically 401, shows the listener is up.
+func probeWinRM(ctx context.Context, endpoint string, skipVerify bool, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Endpoint: endpoint, Probed: true}
+	start := time.Now()
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	resp.Body.Close()
+	result.Latency = time.Since(start)
+	return result
+}
+
+// probeHTTPAPI sends an unauthenticated GET to the API base URL with the
+// host's TLS settings. Any HTTP response shows the device is up.
+func probeHTTPAPI(ctx context.Context, settings httpAPISettings, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Endpoint: settings.baseURL, Probed: true}
+	start := time.Now()
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: settings.tlsConfig},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, settings.baseURL, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	resp.Body.Close()
+	result.Latency = time.Since(start)
+	return result
+}
@@ -0,0 +1,129 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// serveBanner accepts connections on a local port and writes lines to each
+func serveBanner(t *testing.T, lines ...string) (string, int) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			for _, line := range lines {
+				conn.Write([]byte(line + "\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestProbeSSH(t *testing.T) {
+	ctx := context.Background()
+
+	host, port := serveBanner(t, "SSH-2.0-OpenSSH_9.6")
+	result := Probe(ctx, types.ConnectionInfo{Type: "ssh", Host: host, Port: port}, time.Second)
+	if !result.Probed || !result.Reachable() {
+		t.Fatalf("expected the SSH server to be reachable, got %+v", result)
+	}
+	if result.Endpoint != net.JoinHostPort(host, strconv.Itoa(port)) {
+		t.Errorf("unexpected endpoint %q", result.Endpoint)
+	}
+
+	host, port = serveBanner(t, "Welcome", "SSH-2.0-dropbear")
+	if result := Probe(ctx, types.ConnectionInfo{Host: host, Port: port}, time.Second); !result.Reachable() {
+		t.Errorf("expected a banner after other lines to be accepted, got %v", result.Err)
+	}
+
+	host, port = serveBanner(t, "HTTP/1.1 400 Bad Request")
+	if result := Probe(ctx, types.ConnectionInfo{Host: host, Port: port}, time.Second); result.Reachable() {
+		t.Error("expected a server without an SSH banner to be unreachable")
+	}
+
+	if result := Probe(ctx, types.ConnectionInfo{Host: "127.0.0.1", Port: closedPort(t)}, time.Second); result.Reachable() {
+		t.Error("expected a closed port to be unreachable")
+	}
+}
+
+func TestProbeSSHJumpHost(t *testing.T) {
+	host, port := serveBanner(t, "SSH-2.0-OpenSSH_9.6")
+	info := types.ConnectionInfo{
+		Type:      "ssh",
+		Host:      "10.255.255.1",
+		JumpHosts: []types.JumpHost{{Host: host, Port: port}},
+	}
+
+	result := Probe(context.Background(), info, time.Second)
+	if !result.Reachable() || result.Endpoint != net.JoinHostPort(host, strconv.Itoa(port)) {
+		t.Fatalf("expected the first jump host to be probed, got %+v", result)
+	}
+}
+
+func TestProbeWinRM(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	result := Probe(context.Background(), types.ConnectionInfo{Type: "winrm", Host: addr.IP.String(), Port: addr.Port}, time.Second)
+	if !result.Reachable() {
+		t.Fatalf("expected a 401 from the listener to count as reachable, got %v", result.Err)
+	}
+	if !strings.HasSuffix(result.Endpoint, "/wsman") {
+		t.Errorf("unexpected endpoint %q", result.Endpoint)
+	}
+
+	result = Probe(context.Background(), types.ConnectionInfo{Type: "winrm", Host: "127.0.0.1", Port: closedPort(t)}, time.Second)
+	if result.Reachable() {
+		t.Error("expected a closed WinRM port to be unreachable")
+	}
+}
+
+func TestProbeSkipped(t *testing.T) {
+	for _, info := range []types.ConnectionInfo{
+		{Type: "local", Host: "localhost"},
+		{Type: "kubectl", Host: "pod"},
+		{Type: "ssh", Host: "10.255.255.1", ProxyCommand: "nc %h %p"},
+	} {
+		result := Probe(context.Background(), info, time.Second)
+		if result.Probed || !result.Reachable() {
+			t.Errorf("expected %s connection not to be probed, got %+v", info.Type, result)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package connection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SFTP version 3 (draft-ietf-secsh-filexfer-02) packet types used by
+// sftpClient
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpFSetstat = 10
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+)
+
+const (
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpAttrPermissions = 0x04
+
+	sftpStatusOK  = 0
+	sftpStatusEOF = 1
+)
+
+const (
+	// sftpChunkSize is the payload of each READ and WRITE request; 32KiB
+	// is the largest every server must accept
+	sftpChunkSize = 32 * 1024
+
+	// sftpWindow is how many WRITE requests are kept in flight, so large
+	// uploads are not bound by round trips
+	sftpWindow = 16
+)
+
+// errTransferUnsupported reports that the remote end does not offer a
+// transfer method, as opposed to a transfer that failed
+var errTransferUnsupported = errors.New("transfer method not supported by the remote host")
+
+// sftpStatusError is a non-OK SSH_FXP_STATUS reply
+type sftpStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.Code, e.Message)
+}
+
+// sftpClient is a minimal SFTP client, enough to upload and download
+// whole files over the "sftp" subsystem of an SSH session
+type sftpClient struct {
+	w      io.Writer
+	r      io.Reader
+	nextID uint32
+}
+
+// newSFTPClient performs the version handshake on an SFTP stream
+func newSFTPClient(w io.Writer, r io.Reader) (*sftpClient, error) {
+	c := &sftpClient{w: w, r: r}
+	if err := c.send(sftpInit, uint32(3)); err != nil {
+		return nil, err
+	}
+	typ, _, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion {
+		return nil, fmt.Errorf("unexpected sftp packet %d during handshake", typ)
+	}
+	return c, nil
+}
+
+// WriteFile uploads src to path, truncating any existing file, and sets
+// its permissions to mode
+func (c *sftpClient) WriteFile(path string, src io.Reader, mode os.FileMode) error {
+	handle, err := c.open(path, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc, mode)
+	if err != nil {
+		return err
+	}
+
+	writeErr := c.write(handle, src)
+	if writeErr == nil {
+		// Permissions passed to OPEN only apply to new files and are
+		// subject to the server's umask
+		writeErr = c.request(sftpFSetstat, handle, uint32(sftpAttrPermissions), uint32(mode.Perm()))
+	}
+	closeErr := c.request(sftpClose, handle)
+	if writeErr != nil {
+		return writeErr
+	}
+	return closeErr
+}
+
+// ReadFile downloads the contents of path
+func (c *sftpClient) ReadFile(path string) (data []byte, err error) {
+	handle, err := c.open(path, sftpFlagRead, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := c.request(sftpClose, handle); err == nil && closeErr != nil {
+			data, err = nil, closeErr
+		}
+	}()
+
+	for {
+		id := c.id()
+		if err := c.send(sftpRead, id, handle, uint64(len(data)), uint32(sftpChunkSize)); err != nil {
+			return nil, err
+		}
+		typ, payload, err := c.recv()
+		if err != nil {
+			return nil, err
+		}
+		if typ == sftpStatus {
+			err := statusError(payload)
+			var status *sftpStatusError
+			if errors.As(err, &status) && status.Code == sftpStatusEOF {
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("unexpected sftp OK status in reply to READ")
+			}
+			return nil, err
+		}
+		if typ != sftpData || len(payload) < 8 {
+			return nil, fmt.Errorf("unexpected sftp packet %d in reply to READ", typ)
+		}
+		n := binary.BigEndian.Uint32(payload[4:8])
+		if int(n) > len(payload)-8 {
+			return nil, fmt.Errorf("short sftp DATA packet")
+		}
+		data = append(data, payload[8:8+n]...)
+	}
+	return data, nil
+}
+
+func (c *sftpClient) open(path string, flags uint32, mode os.FileMode) (string, error) {
+	id := c.id()
+	attrs := []interface{}{uint32(0)}
+	if flags&sftpFlagCreat != 0 {
+		attrs = []interface{}{uint32(sftpAttrPermissions), uint32(mode.Perm())}
+	}
+	if err := c.send(sftpOpen, append([]interface{}{id, path, flags}, attrs...)...); err != nil {
+		return "", err
+	}
+
+	typ, payload, err := c.recv()
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case sftpHandle:
+		if len(payload) < 8 {
+			return "", fmt.Errorf("short sftp HANDLE packet")
+		}
+		n := binary.BigEndian.Uint32(payload[4:8])
+		if int(n) > len(payload)-8 {
+			return "", fmt.Errorf("short sftp HANDLE packet")
+		}
+		return string(payload[8 : 8+n]), nil
+	case sftpStatus:
+		if err := statusError(payload); err != nil {
+			return "", fmt.Errorf("failed to open %s: %w", path, err)
+		}
+	}
+	return "", fmt.Errorf("unexpected sftp packet %d in reply to OPEN", typ)
+}
+
+// write streams src to handle in chunks, keeping up to sftpWindow
+// requests outstanding
+func (c *sftpClient) write(handle string, src io.Reader) error {
+	buf := make([]byte, sftpChunkSize)
+	var offset uint64
+	var pending int
+	var firstErr error
+
+	ack := func() error {
+		typ, payload, err := c.recv()
+		if err != nil {
+			return err
+		}
+		pending--
+		if typ != sftpStatus {
+			return fmt.Errorf("unexpected sftp packet %d in reply to WRITE", typ)
+		}
+		if err := statusError(payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return nil
+	}
+
+	for firstErr == nil {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			if pending == sftpWindow {
+				if err := ack(); err != nil {
+					return err
+				}
+			}
+			if err := c.send(sftpWrite, c.id(), handle, offset, buf[:n]); err != nil {
+				return err
+			}
+			pending++
+			offset += uint64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			firstErr = readErr
+		}
+	}
+
+	for pending > 0 {
+		if err := ack(); err != nil {
+			return err
+		}
+	}
+	return firstErr
+}
+
+// request sends a packet whose reply is a plain status
+func (c *sftpClient) request(typ byte, fields ...interface{}) error {
+	if err := c.send(typ, append([]interface{}{c.id()}, fields...)...); err != nil {
+		return err
+	}
+	reply, payload, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if reply != sftpStatus {
+		return fmt.Errorf("unexpected sftp packet %d in reply to %d", reply, typ)
+	}
+	return statusError(payload)
+}
+
+func (c *sftpClient) id() uint32 {
+	c.nextID++
+	return c.nextID
+}
+
+// send marshals fields (uint32, uint64, string or []byte) into a packet
+func (c *sftpClient) send(typ byte, fields ...interface{}) error {
+	packet := make([]byte, 5, 64)
+	packet[4] = typ
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint32:
+			packet = binary.BigEndian.AppendUint32(packet, v)
+		case uint64:
+			packet = binary.BigEndian.AppendUint64(packet, v)
+		case string:
+			packet = binary.BigEndian.AppendUint32(packet, uint32(len(v)))
+			packet = append(packet, v...)
+		case []byte:
+			packet = binary.BigEndian.AppendUint32(packet, uint32(len(v)))
+			packet = append(packet, v...)
+		default:
+			panic(fmt.Sprintf("sftp: cannot marshal %T", field))
+		}
+	}
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	_, err := c.w.Write(packet)
+	return err
+}
+
+// recv reads one packet, returning its type and payload
+func (c *sftpClient) recv() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > 256*1024 {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// statusError decodes an SSH_FXP_STATUS payload, returning nil for OK
+func statusError(payload []byte) error {
+	if len(payload) < 8 {
+		return fmt.Errorf("short sftp STATUS packet")
+	}
+	code := binary.BigEndian.Uint32(payload[4:8])
+	if code == sftpStatusOK {
+		return nil
+	}
+	status := &sftpStatusError{Code: code}
+	if len(payload) >= 12 {
+		n := binary.BigEndian.Uint32(payload[8:12])
+		if int(n) <= len(payload)-12 {
+			status.Message = string(payload[12 : 12+n])
+		}
+	}
+	return status
+}
@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,14 +17,22 @@ import (
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/liliang-cn/gosible/pkg/become"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
 // SSHConnection implements the Connection interface for SSH connections
 type SSHConnection struct {
-	client    *ssh.Client
-	connected bool
-	info      types.ConnectionInfo
+	client      *ssh.Client
+	jumpClients []*ssh.Client // Intermediate bastion connections, closed with the client
+	connected   bool
+	info        types.ConnectionInfo
+
+	// transfer is the configured file transfer method; under smart,
+	// detectedTransfer remembers the first method that worked
+	transfer         string
+	detectedTransfer string
+	transferMu       sync.Mutex
 }
 
 // NewSSHConnection creates a new SSH connection
@@ -36,6 +44,13 @@ func NewSSHConnection() *SSHConnection {
 func (c *SSHConnection) Connect(ctx context.Context, info types.ConnectionInfo) error {
 	c.info = info
 
+	transfer, err := transferMethod(info)
+	if err != nil {
+		return types.NewConnectionError(info.Host, "invalid SSH connection variables", err)
+	}
+	c.transfer = transfer
+	c.detectedTransfer = ""
+
 	// Set default timeout if not specified
 	timeout := info.Timeout
 	if timeout == 0 {
@@ -49,42 +64,14 @@ func (c *SSHConnection) Connect(ctx context.Context, info types.ConnectionInfo)
 	}
 
 	// Create SSH client configuration
-	config := &ssh.ClientConfig{
-		User:            info.User,
-		Timeout:         timeout,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, implement proper host key verification
-	}
-
-	// Add authentication methods
-	// Priority 1: Password authentication
-	if info.Password != "" {
-		config.Auth = append(config.Auth, ssh.Password(info.Password))
-	}
-
-	// Priority 2: Private key from configuration
-	if info.PrivateKey != "" {
-		signer, err := c.parsePrivateKey(info.PrivateKey)
-		if err != nil {
-			return types.NewConnectionError(info.Host, "failed to parse private key", err)
-		}
-		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
-	}
-
-	// Priority 3: Try default SSH keys only if no other auth method is provided
-	if len(config.Auth) == 0 {
-		if signers, err := c.loadDefaultKeys(); err == nil && len(signers) > 0 {
-			config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
-		}
-	}
-
-	// If still no auth methods, return error
-	if len(config.Auth) == 0 {
-		return types.NewConnectionError(info.Host, "no authentication method provided", nil)
+	config, err := c.clientConfig(info.User, info.Password, info.PrivateKey, timeout)
+	if err != nil {
+		return types.NewConnectionError(info.Host, "invalid SSH client configuration", err)
 	}
 
-	// Establish connection
+	// Establish connection, traversing jump hosts if configured
 	address := fmt.Sprintf("%s:%d", info.Host, port)
-	client, err := ssh.Dial("tcp", address, config)
+	client, err := c.dial(info, address, config, timeout)
 	if err != nil {
 		return types.NewConnectionError(info.Host, fmt.Sprintf("failed to connect to %s", address), err)
 	}
@@ -114,6 +101,12 @@ func (c *SSHConnection) Execute(ctx context.Context, command string, options typ
 		ModuleName: "command",
 	}
 
+	// Set up command with options
+	fullCommand, err := c.buildCommand(command, options)
+	if err != nil {
+		return nil, types.NewConnectionError(c.info.Host, "failed to apply become", err)
+	}
+
 	// Create SSH session
 	session, err := c.client.NewSession()
 	if err != nil {
@@ -121,13 +114,18 @@ func (c *SSHConnection) Execute(ctx context.Context, command string, options typ
 	}
 	defer session.Close()
 
-	// Set up command with options
-	fullCommand := c.buildCommand(command, options)
-
 	// Set up output capture
 	var stdout, stderr bytes.Buffer
 	session.Stdout = &stdout
 	session.Stderr = &stderr
+	if fullCommand.Stdin != "" {
+		session.Stdin = strings.NewReader(fullCommand.Stdin)
+	}
+	if fullCommand.TTY {
+		if err := requestPty(session); err != nil {
+			return nil, types.NewConnectionError(c.info.Host, "failed to allocate a pseudo-terminal", err)
+		}
+	}
 
 	// Set environment variables
 	if options.Env != nil {
@@ -139,7 +137,7 @@ func (c *SSHConnection) Execute(ctx context.Context, command string, options typ
 	// Execute command with timeout
 	done := make(chan error, 1)
 	go func() {
-		done <- session.Run(fullCommand)
+		done <- session.Run(fullCommand.Line)
 	}()
 
 	var execErr error
@@ -168,7 +166,7 @@ func (c *SSHConnection) Execute(ctx context.Context, command string, options typ
 	result.Data = map[string]interface{}{
 		"stdout": stdout.String(),
 		"stderr": stderr.String(),
-		"cmd":    fullCommand,
+		"cmd":    fullCommand.Line,
 	}
 
 	if execErr != nil {
@@ -262,7 +260,28 @@ func (c *SSHConnection) ExecuteStream(ctx context.Context, command string, optio
 		}
 
 		// Set up command with options
-		fullCommand := c.buildCommand(command, options)
+		fullCommand, err := c.buildCommand(command, options)
+		if err != nil {
+			eventChan <- types.StreamEvent{
+				Type:      types.StreamError,
+				Error:     types.NewConnectionError(c.info.Host, "failed to apply become", err),
+				Timestamp: time.Now(),
+			}
+			return
+		}
+		if fullCommand.Stdin != "" {
+			session.Stdin = strings.NewReader(fullCommand.Stdin)
+		}
+		if fullCommand.TTY {
+			if err := requestPty(session); err != nil {
+				eventChan <- types.StreamEvent{
+					Type:      types.StreamError,
+					Error:     types.NewConnectionError(c.info.Host, "failed to allocate a pseudo-terminal", err),
+					Timestamp: time.Now(),
+				}
+				return
+			}
+		}
 
 		// Set up pipes for real-time output
 		stdoutPipe, err := session.StdoutPipe()
@@ -347,7 +366,7 @@ func (c *SSHConnection) ExecuteStream(ctx context.Context, command string, optio
 		// Start command execution
 		done := make(chan error, 1)
 		go func() {
-			done <- session.Run(fullCommand)
+			done <- session.Run(fullCommand.Line)
 		}()
 
 		var execErr error
@@ -387,7 +406,7 @@ func (c *SSHConnection) ExecuteStream(ctx context.Context, command string, optio
 			Data: map[string]interface{}{
 				"stdout": stdout.String(),
 				"stderr": stderr.String(),
-				"cmd":    fullCommand,
+				"cmd":    fullCommand.Line,
 			},
 		}
 
@@ -441,113 +460,78 @@ func (c *SSHConnection) ExecuteStream(ctx context.Context, command string, optio
 	return eventChan, nil
 }
 
-// Copy transfers a file to the remote host via chunked base64 encoding
+// Copy transfers a file to the remote host over SFTP, SCP or the shell,
+// according to the connection's transfer method
 func (c *SSHConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
 	if !c.connected {
 		return types.NewConnectionError(c.info.Host, "not connected", nil)
 	}
 
-	// Read source data
-	data, err := ioutil.ReadAll(src)
+	reader, size, cleanup, err := sizedReader(src)
 	if err != nil {
 		return types.NewConnectionError(c.info.Host, "failed to read source data", err)
 	}
-
-	// Encode data as base64
-	encoded := base64.StdEncoding.EncodeToString(data)
+	defer cleanup()
 
 	// Sanitize destination path
 	dest = types.SanitizePath(dest)
 
 	// Create destination directory if needed
 	destDir := filepath.Dir(dest)
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", destDir)
+	mkdirCmd := fmt.Sprintf("mkdir -p %s", become.Quote(destDir))
 	if _, err := c.Execute(ctx, mkdirCmd, types.ExecuteOptions{}); err != nil {
 		return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to create directory %s", destDir), err)
 	}
 
-	// Use a temporary file for transfer
-	tempFile := fmt.Sprintf("/tmp/gosible_copy_%d.b64", time.Now().Unix())
-
-	// Split encoded data into chunks (to avoid command line length limits)
-	chunkSize := 64000 // Safe size for command line
-	for i := 0; i < len(encoded); i += chunkSize {
-		end := i + chunkSize
-		if end > len(encoded) {
-			end = len(encoded)
+	for _, method := range c.transferMethods() {
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			return types.NewConnectionError(c.info.Host, "failed to rewind source data", err)
 		}
-		chunk := encoded[i:end]
-
-		// Append chunk to temp file
-		appendCmd := fmt.Sprintf("echo -n '%s' >> %s", chunk, tempFile)
-		if i == 0 {
-			// First chunk, create new file
-			appendCmd = fmt.Sprintf("echo -n '%s' > %s", chunk, tempFile)
+		err := c.upload(ctx, method, reader, size, dest, mode)
+		if errors.Is(err, errTransferUnsupported) {
+			continue
 		}
-
-		result, err := c.Execute(ctx, appendCmd, types.ExecuteOptions{})
-		if err != nil || !result.Success {
-			// Clean up temp file on error
-			c.Execute(ctx, fmt.Sprintf("rm -f %s", tempFile), types.ExecuteOptions{})
-			return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to transfer chunk to %s", dest), err)
+		if err != nil {
+			return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to copy file to %s over %s", dest, method), err)
 		}
+		c.useTransferMethod(method)
+		return nil
 	}
-
-	// Decode and move to final destination with correct permissions
-	finalCmd := fmt.Sprintf("base64 -d < %s > %s && rm -f %s && chmod %04o %s",
-		tempFile, dest, tempFile, mode, dest)
-
-	result, err := c.Execute(ctx, finalCmd, types.ExecuteOptions{})
-	if err != nil {
-		// Clean up temp file on error
-		c.Execute(ctx, fmt.Sprintf("rm -f %s", tempFile), types.ExecuteOptions{})
-		return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to decode and install file to %s", dest), err)
-	}
-
-	if !result.Success {
-		// Clean up temp file on error
-		c.Execute(ctx, fmt.Sprintf("rm -f %s", tempFile), types.ExecuteOptions{})
-		return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to copy file to %s: %v", dest, result.Error), nil)
-	}
-
-	return nil
+	return types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to copy file to %s", dest), errTransferUnsupported)
 }
 
-// Fetch retrieves a file from the remote host via SCP
+// Fetch retrieves a file from the remote host over SFTP, SCP or the shell,
+// according to the connection's transfer method
 func (c *SSHConnection) Fetch(ctx context.Context, src string) (io.Reader, error) {
 	if !c.connected {
 		return nil, types.NewConnectionError(c.info.Host, "not connected", nil)
 	}
 
-	// Create SSH session for cat command (simpler than SCP for fetching)
-	session, err := c.client.NewSession()
-	if err != nil {
-		return nil, types.NewConnectionError(c.info.Host, "failed to create SSH session for fetch", err)
-	}
-	defer session.Close()
-
-	// Use cat to read the file
 	src = types.SanitizePath(src)
-	var output bytes.Buffer
-	session.Stdout = &output
-
-	if err := session.Run(fmt.Sprintf("cat %s", src)); err != nil {
-		return nil, types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to fetch %s", src), err)
+	for _, method := range c.transferMethods() {
+		data, err := c.download(ctx, method, src)
+		if errors.Is(err, errTransferUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to fetch %s over %s", src, method), err)
+		}
+		c.useTransferMethod(method)
+		return bytes.NewReader(data), nil
 	}
-
-	return &output, nil
+	return nil, types.NewConnectionError(c.info.Host, fmt.Sprintf("failed to fetch %s", src), errTransferUnsupported)
 }
 
 // Close terminates the SSH connection
 func (c *SSHConnection) Close() error {
+	var err error
 	if c.client != nil {
-		err := c.client.Close()
+		err = c.client.Close()
 		c.client = nil
-		c.connected = false
-		return err
 	}
+	c.closeJumpClients()
 	c.connected = false
-	return nil
+	return err
 }
 
 // IsConnected returns true if the SSH connection is active
@@ -555,24 +539,67 @@ func (c *SSHConnection) IsConnected() bool {
 	return c.connected && c.client != nil
 }
 
-// buildCommand builds the full command string with options
-func (c *SSHConnection) buildCommand(command string, options types.ExecuteOptions) string {
-	var parts []string
+// buildCommand builds the full command with options, applying the
+// environment and become
+func (c *SSHConnection) buildCommand(command string, options types.ExecuteOptions) (become.Command, error) {
+	// sshd only accepts the variables its AcceptEnv allows, so they are
+	// also exported by the command itself
+	wrapped, err := become.DefaultManager.Wrap(withEnv(command, options.Env), options)
+	if err != nil {
+		return become.Command{}, err
+	}
 
 	// Change directory if specified
 	if options.WorkingDir != "" {
-		parts = append(parts, fmt.Sprintf("cd %s", options.WorkingDir))
+		wrapped.Line = fmt.Sprintf("cd %s && %s", options.WorkingDir, wrapped.Line)
+	}
+
+	return wrapped, nil
+}
+
+// requestPty gives a session the pseudo-terminal sudo's requiretty option
+// asks for. Echo is turned off so that a become password written to stdin
+// is not copied into the command output.
+func requestPty(session *ssh.Session) error {
+	return session.RequestPty("xterm", 40, 200, ssh.TerminalModes{ssh.ECHO: 0})
+}
+
+// clientConfig builds an SSH client configuration for the given credentials
+func (c *SSHConnection) clientConfig(user, password, privateKey string, timeout time.Duration) (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{
+		User:            user,
+		Timeout:         timeout,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, implement proper host key verification
 	}
 
-	// Set up sudo if requested
-	if options.Sudo && options.User != "" {
-		command = fmt.Sprintf("sudo -u %s %s", options.User, command)
-	} else if options.User != "" {
-		command = fmt.Sprintf("su -c '%s' %s", command, options.User)
+	// Add authentication methods
+	// Priority 1: Password authentication
+	if password != "" {
+		config.Auth = append(config.Auth, ssh.Password(password))
 	}
 
-	parts = append(parts, command)
-	return strings.Join(parts, " && ")
+	// Priority 2: Private key from configuration
+	if privateKey != "" {
+		signer, err := c.parsePrivateKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	// Priority 3: Try default SSH keys only if no other auth method is provided
+	if len(config.Auth) == 0 {
+		if signers, err := c.loadDefaultKeys(); err == nil && len(signers) > 0 {
+			config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+		}
+	}
+
+	// If still no auth methods, return error
+	if len(config.Auth) == 0 {
+		return nil, fmt.Errorf("no authentication method provided")
+	}
+
+	return config, nil
 }
 
 // parsePrivateKey parses a private key string
@@ -795,6 +822,25 @@ func (c *SSHConnection) Ping() error {
 	return nil
 }
 
+// Multiplexed reports that concurrent commands share the connection, each
+// running in its own session over the one SSH client
+func (c *SSHConnection) Multiplexed() bool {
+	return true
+}
+
+// KeepAlive sends an OpenSSH keepalive request, which any server answers,
+// without opening a session
+func (c *SSHConnection) KeepAlive() error {
+	client := c.client
+	if !c.connected || client == nil {
+		return types.NewConnectionError(c.info.Host, "not connected", nil)
+	}
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		return types.NewConnectionError(c.info.Host, "keepalive failed", err)
+	}
+	return nil
+}
+
 // PortForward creates a port forward from local to remote host
 func (c *SSHConnection) PortForward(localAddr, remoteAddr string) error {
 	if !c.connected {
@@ -0,0 +1,461 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// maxProxyStderr bounds how much proxy command stderr is kept for error reporting
+const maxProxyStderr = 4096
+
+// ParseProxyJump parses an OpenSSH style ProxyJump specification such as
+// "admin@bastion1:2222,bastion2" into an ordered list of jump hosts.
+func ParseProxyJump(spec string) ([]types.JumpHost, error) {
+	var hops []types.JumpHost
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		hop := types.JumpHost{}
+		if at := strings.LastIndex(part, "@"); at >= 0 {
+			hop.User = part[:at]
+			part = part[at+1:]
+		}
+
+		host, portStr, err := net.SplitHostPort(part)
+		if err != nil {
+			// No port specified
+			host = strings.Trim(part, "[]")
+		} else {
+			port, err := strconv.Atoi(portStr)
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid port in jump host %q", part)
+			}
+			hop.Port = port
+		}
+
+		if host == "" {
+			return nil, fmt.Errorf("empty host in proxy jump specification %q", spec)
+		}
+		hop.Host = host
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// ParseJumpHosts converts the inventory form of ansible_ssh_jump_hosts, a list
+// of mappings with host, port, user, password and private_key_file keys, into
+// jump hosts. Plain strings in the list are parsed as ProxyJump entries.
+func ParseJumpHosts(value interface{}) ([]types.JumpHost, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ansible_ssh_jump_hosts must be a list, got %T", value)
+	}
+
+	var hops []types.JumpHost
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			parsed, err := ParseProxyJump(v)
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, parsed...)
+		case map[string]interface{}:
+			hop := types.JumpHost{
+				Host:     types.ConvertToString(v["host"]),
+				User:     types.ConvertToString(v["user"]),
+				Password: types.ConvertToString(v["password"]),
+			}
+			if key, ok := v["private_key_file"]; ok {
+				hop.PrivateKey = types.ConvertToString(key)
+			} else if key, ok := v["private_key"]; ok {
+				hop.PrivateKey = types.ConvertToString(key)
+			}
+			if port, ok := v["port"]; ok {
+				p, err := strconv.Atoi(types.ConvertToString(port))
+				if err != nil || p <= 0 || p > 65535 {
+					return nil, fmt.Errorf("invalid port in jump host %d: %v", i+1, port)
+				}
+				hop.Port = p
+			}
+			if hop.Host == "" {
+				return nil, fmt.Errorf("jump host %d has no host", i+1)
+			}
+			hops = append(hops, hop)
+		default:
+			return nil, fmt.Errorf("jump host %d must be a string or mapping, got %T", i+1, item)
+		}
+	}
+
+	return hops, nil
+}
+
+// jumpChain resolves the ordered list of hops needed to reach the target.
+// When a ProxyJump string is set it defines the chain and each hop picks up
+// per-hop credentials from the first JumpHosts entry with the same host and
+// port; otherwise JumpHosts is used as the chain directly.
+func jumpChain(info types.ConnectionInfo) ([]types.JumpHost, error) {
+	spec := info.ProxyJump
+	if spec == "" {
+		if v, ok := info.Variables["ansible_ssh_proxy_jump"]; ok {
+			spec = types.ConvertToString(v)
+		}
+	}
+
+	if spec == "" {
+		return info.JumpHosts, nil
+	}
+
+	hops, err := ParseProxyJump(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hops {
+		for _, configured := range info.JumpHosts {
+			if configured.Host != hops[i].Host || hopPort(configured) != hopPort(hops[i]) {
+				continue
+			}
+			if hops[i].User == "" {
+				hops[i].User = configured.User
+			}
+			if configured.Password != "" {
+				hops[i].Password = configured.Password
+			}
+			if configured.PrivateKey != "" {
+				hops[i].PrivateKey = configured.PrivateKey
+			}
+			break
+		}
+	}
+
+	return hops, nil
+}
+
+// proxyCommand returns the ProxyCommand for the connection, if any
+func proxyCommand(info types.ConnectionInfo) string {
+	if info.ProxyCommand != "" {
+		return info.ProxyCommand
+	}
+	if v, ok := info.Variables["ansible_ssh_proxy_command"]; ok {
+		return types.ConvertToString(v)
+	}
+	return ""
+}
+
+// routeKey identifies the path used to reach a host, so that connections to
+// the same address behind different bastions are never shared
+func routeKey(info types.ConnectionInfo) string {
+	var parts []string
+
+	hops, err := jumpChain(info)
+	if err != nil {
+		// Fall back to the raw specification, it will fail on dial anyway
+		parts = append(parts, info.ProxyJump)
+	}
+	for _, hop := range hops {
+		parts = append(parts, fmt.Sprintf("%s@%s", hop.User, net.JoinHostPort(hop.Host, strconv.Itoa(hopPort(hop)))))
+	}
+
+	if cmd := proxyCommand(info); cmd != "" {
+		parts = append(parts, "proxy="+cmd)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// expandProxyCommand substitutes the OpenSSH %h, %p, %r and %% tokens
+func expandProxyCommand(command, host string, port int, user string) string {
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%p", strconv.Itoa(port),
+		"%r", user,
+	)
+	return replacer.Replace(command)
+}
+
+// dial establishes the SSH client for address, traversing jump hosts or a
+// proxy command when configured. Intermediate clients are kept so that Close
+// can tear down the whole chain.
+func (c *SSHConnection) dial(info types.ConnectionInfo, address string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	hops, err := jumpChain(info)
+	if err != nil {
+		return nil, err
+	}
+	proxyCmd := proxyCommand(info)
+
+	if len(hops) > 0 && proxyCmd != "" {
+		return nil, errors.New("proxy_command and proxy_jump are mutually exclusive")
+	}
+
+	if proxyCmd != "" {
+		host, portStr, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portStr)
+		return dialProxyCommand(expandProxyCommand(proxyCmd, host, port, config.User), address, config, timeout)
+	}
+
+	if len(hops) == 0 {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	var previous *ssh.Client
+	for i, hop := range hops {
+		hopConfig, hopAddress, err := c.hopConfig(info, hop, timeout)
+		if err != nil {
+			c.closeJumpClients()
+			return nil, fmt.Errorf("jump host %d (%s): %w", i+1, hop.Host, err)
+		}
+
+		var client *ssh.Client
+		if previous == nil {
+			client, err = dialDirect(hopAddress, hopConfig, timeout)
+		} else {
+			client, err = dialVia(previous, hopAddress, hopConfig, timeout)
+		}
+		if err != nil {
+			c.closeJumpClients()
+			return nil, fmt.Errorf("failed to reach jump host %s: %w", hopAddress, err)
+		}
+
+		c.jumpClients = append(c.jumpClients, client)
+		previous = client
+	}
+
+	client, err := dialVia(previous, address, config, timeout)
+	if err != nil {
+		c.closeJumpClients()
+		return nil, err
+	}
+	return client, nil
+}
+
+// hopConfig builds the client configuration for a hop, inheriting unset
+// credentials from the target connection info
+func (c *SSHConnection) hopConfig(info types.ConnectionInfo, hop types.JumpHost, timeout time.Duration) (*ssh.ClientConfig, string, error) {
+	user := hop.User
+	if user == "" {
+		user = info.User
+	}
+
+	password, privateKey := hop.Password, hop.PrivateKey
+	if password == "" && privateKey == "" {
+		password, privateKey = info.Password, info.PrivateKey
+	}
+
+	config, err := c.clientConfig(user, password, privateKey, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return config, net.JoinHostPort(hop.Host, strconv.Itoa(hopPort(hop))), nil
+}
+
+// dialDirect opens an SSH client over TCP with both the connect and the
+// handshake bounded by timeout
+func dialDirect(address string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, address, config, timeout)
+}
+
+// dialProxyCommand opens an SSH client over the stdin/stdout of a local command
+func dialProxyCommand(command, address string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := newProxyCommandConn(command)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(conn, address, config, timeout)
+	if err != nil {
+		if stderr := conn.Stderr(); stderr != "" {
+			return nil, fmt.Errorf("%w (proxy command stderr: %s)", err, stderr)
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// dialVia opens an SSH client to address tunnelled through an existing client
+func dialVia(via *ssh.Client, address string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	// Opening the forwarding channel has no deadline of its own
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := via.Dial("tcp", address)
+		done <- dialResult{conn, err}
+	}()
+
+	var conn net.Conn
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		conn = res.conn
+	case <-time.After(timeout):
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out opening tunnel to %s", address)
+	}
+
+	return newClient(conn, address, config, timeout)
+}
+
+// newClient performs the SSH handshake over conn. ssh.ClientConfig.Timeout
+// only applies to the TCP connect in ssh.Dial, so the handshake is bounded
+// here by closing conn when timeout expires.
+func newClient(conn net.Conn, address string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	timer := time.AfterFunc(timeout, func() { conn.Close() })
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if !timer.Stop() {
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, fmt.Errorf("SSH handshake with %s timed out after %s", address, timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// closeJumpClients closes intermediate clients from the innermost hop outwards
+func (c *SSHConnection) closeJumpClients() {
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		c.jumpClients[i].Close()
+	}
+	c.jumpClients = nil
+}
+
+func hopPort(hop types.JumpHost) int {
+	if hop.Port == 0 {
+		return 22
+	}
+	return hop.Port
+}
+
+// proxyCommandConn adapts the stdin/stdout of a local proxy command to net.Conn
+type proxyCommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *tailBuffer
+
+	closeOnce sync.Once
+}
+
+// newProxyCommandConn starts command through the local shell. The process
+// lives as long as the SSH connection, so it is not bound to the dial context.
+func newProxyCommandConn(command string) (*proxyCommandConn, error) {
+	cmd := exec.Command("sh", "-c", command)
+	stderr := &tailBuffer{limit: maxProxyStderr}
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command %q: %w", command, err)
+	}
+
+	return &proxyCommandConn{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+func (p *proxyCommandConn) Read(b []byte) (int, error)  { return p.stdout.Read(b) }
+func (p *proxyCommandConn) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+func (p *proxyCommandConn) Close() error {
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		p.stdout.Close()
+		if p.cmd.Process != nil {
+			p.cmd.Process.Kill()
+		}
+		p.cmd.Wait()
+	})
+	return nil
+}
+
+// Stderr returns the tail of the command's stderr. It closes the connection
+// first so that everything the process wrote has been collected.
+func (p *proxyCommandConn) Stderr() string {
+	p.Close()
+	return strings.TrimSpace(p.stderr.String())
+}
+
+func (p *proxyCommandConn) LocalAddr() net.Addr  { return proxyAddr("local") }
+func (p *proxyCommandConn) RemoteAddr() net.Addr { return proxyAddr(strings.Join(p.cmd.Args, " ")) }
+
+// Deadlines are not supported on process pipes; newClient bounds the
+// handshake by closing the connection instead.
+func (p *proxyCommandConn) SetDeadline(t time.Time) error      { return nil }
+func (p *proxyCommandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (p *proxyCommandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "proxycommand" }
+func (a proxyAddr) String() string  { return string(a) }
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
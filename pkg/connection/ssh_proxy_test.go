@@ -0,0 +1,546 @@
+package connection
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected []types.JumpHost
+		wantErr  bool
+	}{
+		{
+			name:     "single host",
+			spec:     "bastion",
+			expected: []types.JumpHost{{Host: "bastion"}},
+		},
+		{
+			name:     "user and port",
+			spec:     "admin@bastion.example.com:2222",
+			expected: []types.JumpHost{{Host: "bastion.example.com", Port: 2222, User: "admin"}},
+		},
+		{
+			name: "chained hops",
+			spec: "ops@bastion1, bastion2:2200",
+			expected: []types.JumpHost{
+				{Host: "bastion1", User: "ops"},
+				{Host: "bastion2", Port: 2200},
+			},
+		},
+		{
+			name:     "ipv6 with port",
+			spec:     "[fd00::1]:22",
+			expected: []types.JumpHost{{Host: "fd00::1", Port: 22}},
+		},
+		{
+			name:    "invalid port",
+			spec:    "bastion:notaport",
+			wantErr: true,
+		},
+		{
+			name:    "missing host",
+			spec:    "user@",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hops, err := ParseProxyJump(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProxyJump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(hops, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, hops)
+			}
+		})
+	}
+}
+
+func TestJumpChain(t *testing.T) {
+	t.Run("per-hop credentials from JumpHosts", func(t *testing.T) {
+		info := types.ConnectionInfo{
+			ProxyJump: "bastion1:2222,deploy@bastion2",
+			JumpHosts: []types.JumpHost{
+				{Host: "bastion1", User: "jump", Port: 2222, PrivateKey: "/keys/bastion1"},
+				{Host: "bastion2", User: "ignored", Password: "secret"},
+			},
+		}
+
+		hops, err := jumpChain(info)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := []types.JumpHost{
+			{Host: "bastion1", User: "jump", Port: 2222, PrivateKey: "/keys/bastion1"},
+			{Host: "bastion2", User: "deploy", Password: "secret"},
+		}
+		if !reflect.DeepEqual(hops, expected) {
+			t.Errorf("expected %+v, got %+v", expected, hops)
+		}
+	})
+
+	t.Run("first matching host and port wins", func(t *testing.T) {
+		info := types.ConnectionInfo{
+			ProxyJump: "bastion",
+			JumpHosts: []types.JumpHost{
+				{Host: "bastion", Port: 2222, Password: "other-port"},
+				{Host: "bastion", User: "jump", PrivateKey: "/keys/bastion"},
+				{Host: "bastion", Password: "shadowed"},
+			},
+		}
+
+		hops, err := jumpChain(info)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := []types.JumpHost{{Host: "bastion", User: "jump", PrivateKey: "/keys/bastion"}}
+		if !reflect.DeepEqual(hops, expected) {
+			t.Errorf("expected %+v, got %+v", expected, hops)
+		}
+	})
+
+	t.Run("JumpHosts used directly without ProxyJump", func(t *testing.T) {
+		info := types.ConnectionInfo{
+			JumpHosts: []types.JumpHost{{Host: "bastion"}},
+		}
+
+		hops, err := jumpChain(info)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(hops) != 1 || hops[0].Host != "bastion" {
+			t.Errorf("unexpected hops: %+v", hops)
+		}
+	})
+
+	t.Run("inventory variable fallback", func(t *testing.T) {
+		info := types.ConnectionInfo{
+			Variables: map[string]interface{}{"ansible_ssh_proxy_jump": "bastion:2200"},
+		}
+
+		hops, err := jumpChain(info)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(hops) != 1 || hops[0].Port != 2200 {
+			t.Errorf("unexpected hops: %+v", hops)
+		}
+	})
+
+	t.Run("no jump configuration", func(t *testing.T) {
+		hops, err := jumpChain(types.ConnectionInfo{Host: "target"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(hops) != 0 {
+			t.Errorf("expected no hops, got %+v", hops)
+		}
+	})
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	got := expandProxyCommand("ssh -W %h:%p -l %r bastion # 100%%", "10.0.0.5", 22, "deploy")
+	expected := "ssh -W 10.0.0.5:22 -l deploy bastion # 100%"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestProxyCommandConn(t *testing.T) {
+	conn, err := newProxyCommandConn("cat")
+	if err != nil {
+		t.Fatalf("failed to start proxy command: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected echo of 'ping', got %q", string(buf))
+	}
+}
+
+func TestParseJumpHosts(t *testing.T) {
+	hops, err := ParseJumpHosts([]interface{}{
+		map[string]interface{}{"host": "bastion1", "port": 2222, "user": "jump", "private_key_file": "/keys/b1"},
+		"ops@bastion2",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []types.JumpHost{
+		{Host: "bastion1", Port: 2222, User: "jump", PrivateKey: "/keys/b1"},
+		{Host: "bastion2", User: "ops"},
+	}
+	if !reflect.DeepEqual(hops, expected) {
+		t.Errorf("expected %+v, got %+v", expected, hops)
+	}
+
+	invalid := []interface{}{
+		"bastion",
+		[]interface{}{map[string]interface{}{"user": "jump"}},
+		[]interface{}{map[string]interface{}{"host": "b", "port": "ssh"}},
+		[]interface{}{42},
+	}
+	for _, value := range invalid {
+		if _, err := ParseJumpHosts(value); err == nil {
+			t.Errorf("expected error for %#v", value)
+		}
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	direct := routeKey(types.ConnectionInfo{Host: "10.0.0.5"})
+	viaA := routeKey(types.ConnectionInfo{Host: "10.0.0.5", ProxyJump: "bastion-a"})
+	viaB := routeKey(types.ConnectionInfo{Host: "10.0.0.5", ProxyJump: "bastion-b"})
+
+	if direct != "" {
+		t.Errorf("expected empty route for direct connection, got %q", direct)
+	}
+	if viaA == viaB {
+		t.Errorf("routes through different bastions must differ, both %q", viaA)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	buf := &tailBuffer{limit: 5}
+	buf.Write([]byte("abc"))
+	buf.Write([]byte("defgh"))
+
+	if got := buf.String(); got != "defgh" {
+		t.Errorf("expected %q, got %q", "defgh", got)
+	}
+}
+
+// testSSHServer is a minimal in-process SSH server that accepts password
+// auth, answers exec requests and forwards direct-tcpip channels, which is
+// enough to act as either a bastion or a target.
+type testSSHServer struct {
+	addr     string
+	listener net.Listener
+	active   int32
+	wg       sync.WaitGroup
+}
+
+func newTestSSHServer(t *testing.T, user, password string) *testSSHServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if meta.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied for %s", meta.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testSSHServer{addr: listener.Addr().String(), listener: listener}
+	go s.serve(config)
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *testSSHServer) port() int {
+	_, port, _ := net.SplitHostPort(s.addr)
+	var p int
+	fmt.Sscanf(port, "%d", &p)
+	return p
+}
+
+// activeConns reports how many client connections are currently open
+func (s *testSSHServer) activeConns() int {
+	return int(atomic.LoadInt32(&s.active))
+}
+
+func (s *testSSHServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			atomic.AddInt32(&s.active, 1)
+			defer atomic.AddInt32(&s.active, -1)
+
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				switch newChannel.ChannelType() {
+				case "session":
+					go handleTestSession(newChannel)
+				case "direct-tcpip":
+					go handleTestForward(newChannel)
+				default:
+					newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+				}
+			}
+			sshConn.Close()
+		}()
+	}
+}
+
+func handleTestSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		io.WriteString(channel, "connection test\n")
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func handleTestForward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "bad payload")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprintf("%d", target.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+	channel.Close()
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHConnection_ConnectThroughJumpHosts(t *testing.T) {
+	bastion1 := newTestSSHServer(t, "jump", "bastion1-secret")
+	bastion2 := newTestSSHServer(t, "deploy", "bastion2-secret")
+	target := newTestSSHServer(t, "deploy", "target-secret")
+
+	conn := NewSSHConnection()
+	err := conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:     "127.0.0.1",
+		Port:     target.port(),
+		User:     "deploy",
+		Password: "target-secret",
+		Timeout:  5 * time.Second,
+		JumpHosts: []types.JumpHost{
+			{Host: "127.0.0.1", Port: bastion1.port(), User: "jump", Password: "bastion1-secret"},
+			// User is inherited from the target
+			{Host: "127.0.0.1", Port: bastion2.port(), Password: "bastion2-secret"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Connect through jump hosts failed: %v", err)
+	}
+
+	if len(conn.jumpClients) != 2 {
+		t.Fatalf("expected 2 jump clients, got %d", len(conn.jumpClients))
+	}
+	for _, s := range []*testSSHServer{bastion1, bastion2, target} {
+		if s.activeConns() != 1 {
+			t.Errorf("expected 1 active connection on %s, got %d", s.addr, s.activeConns())
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if conn.jumpClients != nil {
+		t.Error("expected jump clients to be cleared on Close")
+	}
+	for _, s := range []*testSSHServer{bastion1, bastion2, target} {
+		waitFor(t, "hop "+s.addr+" to disconnect", func() bool { return s.activeConns() == 0 })
+	}
+}
+
+func TestSSHConnection_FailedHopClosesEarlierHops(t *testing.T) {
+	bastion := newTestSSHServer(t, "jump", "secret")
+
+	// Reserve a port and close it so the second hop is refused
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	conn := NewSSHConnection()
+	err = conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:     "127.0.0.1",
+		Port:     closedPort,
+		User:     "jump",
+		Password: "secret",
+		Timeout:  5 * time.Second,
+		JumpHosts: []types.JumpHost{
+			{Host: "127.0.0.1", Port: bastion.port()},
+		},
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected connect to fail when the target is unreachable")
+	}
+
+	if conn.jumpClients != nil {
+		t.Errorf("expected no leaked jump clients, got %d", len(conn.jumpClients))
+	}
+	waitFor(t, "bastion connection to be closed", func() bool { return bastion.activeConns() == 0 })
+}
+
+func TestSSHConnection_StalledHopTimesOut(t *testing.T) {
+	// Accepts TCP connections but never speaks SSH
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			c, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	bastion := newTestSSHServer(t, "jump", "secret")
+	stalledPort := stalled.Addr().(*net.TCPAddr).Port
+
+	conn := NewSSHConnection()
+	start := time.Now()
+	err = conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:      "127.0.0.1",
+		Port:      stalledPort,
+		User:      "jump",
+		Password:  "secret",
+		Timeout:   300 * time.Millisecond,
+		JumpHosts: []types.JumpHost{{Host: "127.0.0.1", Port: bastion.port()}},
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected handshake with a stalled host to fail")
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("handshake timeout took too long: %s", elapsed)
+	}
+	waitFor(t, "bastion connection to be closed", func() bool { return bastion.activeConns() == 0 })
+}
+
+func TestSSHConnection_ProxyCommandAndJumpExclusive(t *testing.T) {
+	conn := NewSSHConnection()
+	err := conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:         "10.0.0.5",
+		User:         "deploy",
+		Password:     "secret",
+		ProxyJump:    "bastion",
+		ProxyCommand: "ssh -W %h:%p bastion",
+	})
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("expected mutually exclusive error, got %v", err)
+	}
+}
+
+func TestSSHConnection_ProxyCommandStderr(t *testing.T) {
+	conn := NewSSHConnection()
+	err := conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:         "10.0.0.5",
+		User:         "deploy",
+		Password:     "secret",
+		Timeout:      5 * time.Second,
+		ProxyCommand: "echo 'bastion: Permission denied (publickey)' >&2; exit 255",
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected failing proxy command to fail the connection")
+	}
+	if !strings.Contains(err.Error(), "Permission denied (publickey)") {
+		t.Errorf("expected proxy command stderr in error, got %v", err)
+	}
+}
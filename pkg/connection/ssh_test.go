@@ -46,7 +46,7 @@ func TestSSHConnection_BuildCommand(t *testing.T) {
 				Sudo: true,
 				User: "root",
 			},
-			expected: "sudo -u root systemctl start nginx",
+			expected: `sudo -H -S -n -u 'root' sh -c 'systemctl start nginx'`,
 		},
 		{
 			name:    "with su user",
@@ -54,7 +54,7 @@ func TestSSHConnection_BuildCommand(t *testing.T) {
 			options: types.ExecuteOptions{
 				User: "nginx",
 			},
-			expected: "su -c 'whoami' nginx",
+			expected: `su 'nginx' -c 'whoami'`,
 		},
 		{
 			name:    "complex command",
@@ -64,15 +64,28 @@ func TestSSHConnection_BuildCommand(t *testing.T) {
 				Sudo:       true,
 				User:       "root",
 			},
-			expected: "cd /home/user && sudo -u root echo 'test'",
+			expected: `cd /home/user && sudo -H -S -n -u 'root' sh -c 'echo '"'"'test'"'"''`,
+		},
+		{
+			name:    "environment exported inside become",
+			command: "env",
+			options: types.ExecuteOptions{
+				Env:  map[string]string{"LANG": "C", "APP_MODE": "it's prod"},
+				Sudo: true,
+				User: "root",
+			},
+			expected: `sudo -H -S -n -u 'root' sh -c 'export APP_MODE='"'"'it'"'"'"'"'"'"'"'"'s prod'"'"' && export LANG='"'"'C'"'"' && env'`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := conn.buildCommand(tt.command, tt.options)
-			if result != tt.expected {
-				t.Errorf("expected %q, got %q", tt.expected, result)
+			result, err := conn.buildCommand(tt.command, tt.options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Line != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result.Line)
 			}
 		})
 	}
@@ -0,0 +1,361 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/liliang-cn/gosible/pkg/become"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// File transfer methods, selected with the ansible_ssh_transfer_method (or
+// transfer_method) connection variable. smart tries SFTP, then SCP, then
+// piping base64 through the shell, and remembers the first that the remote
+// sshd offers.
+const (
+	TransferSmart = "smart"
+	TransferSFTP  = "sftp"
+	TransferSCP   = "scp"
+	TransferPiped = "piped"
+)
+
+// pipedChunkSize is how many bytes each shell command of a piped transfer
+// carries, keeping the base64 argument under command line limits
+const pipedChunkSize = 48000
+
+// transferMethod reads the configured transfer method from the connection
+// variables
+func transferMethod(info types.ConnectionInfo) (string, error) {
+	method := TransferSmart
+	for _, name := range []string{"ansible_ssh_transfer_method", "transfer_method"} {
+		if v, ok := info.Variables[name]; ok {
+			method = strings.ToLower(types.ConvertToString(v))
+			break
+		}
+	}
+
+	switch method {
+	case TransferSmart, TransferSFTP, TransferSCP, TransferPiped:
+		return method, nil
+	}
+	return "", fmt.Errorf("unsupported transfer method %q (expected smart, sftp, scp or piped)", method)
+}
+
+// transferMethods lists the methods to try, in order
+func (c *SSHConnection) transferMethods() []string {
+	c.transferMu.Lock()
+	defer c.transferMu.Unlock()
+
+	if c.transfer != TransferSmart {
+		return []string{c.transfer}
+	}
+	if c.detectedTransfer != "" {
+		return []string{c.detectedTransfer}
+	}
+	return []string{TransferSFTP, TransferSCP, TransferPiped}
+}
+
+// useTransferMethod remembers the method smart detection settled on
+func (c *SSHConnection) useTransferMethod(method string) {
+	c.transferMu.Lock()
+	c.detectedTransfer = method
+	c.transferMu.Unlock()
+}
+
+// upload writes src to dest with the given method
+func (c *SSHConnection) upload(ctx context.Context, method string, src io.Reader, size int64, dest string, mode int) error {
+	switch method {
+	case TransferSFTP:
+		return c.withSession(ctx, func(session *ssh.Session, w io.WriteCloser, r io.Reader) error {
+			if err := session.RequestSubsystem("sftp"); err != nil {
+				return errTransferUnsupported
+			}
+			client, err := newSFTPClient(w, r)
+			if err != nil {
+				return err
+			}
+			return client.WriteFile(dest, src, os.FileMode(mode))
+		})
+	case TransferSCP:
+		err := c.withSession(ctx, func(session *ssh.Session, w io.WriteCloser, r io.Reader) error {
+			if err := session.Start("scp -t " + become.Quote(dest)); err != nil {
+				return err
+			}
+			if err := scpSend(w, bufio.NewReader(r), filepath.Base(dest), src, size, mode); err != nil {
+				return err
+			}
+			w.Close()
+			return session.Wait()
+		})
+		if err != nil {
+			return err
+		}
+		// scp only applies the mode to files it creates
+		return c.run(ctx, fmt.Sprintf("chmod %04o %s", mode, become.Quote(dest)))
+	default:
+		return c.pipedUpload(ctx, src, dest, mode)
+	}
+}
+
+// download reads src with the given method
+func (c *SSHConnection) download(ctx context.Context, method string, src string) ([]byte, error) {
+	var data []byte
+	var err error
+	switch method {
+	case TransferSFTP:
+		err = c.withSession(ctx, func(session *ssh.Session, w io.WriteCloser, r io.Reader) error {
+			if err := session.RequestSubsystem("sftp"); err != nil {
+				return errTransferUnsupported
+			}
+			client, err := newSFTPClient(w, r)
+			if err != nil {
+				return err
+			}
+			data, err = client.ReadFile(src)
+			return err
+		})
+	case TransferSCP:
+		err = c.withSession(ctx, func(session *ssh.Session, w io.WriteCloser, r io.Reader) error {
+			if err := session.Start("scp -f " + become.Quote(src)); err != nil {
+				return err
+			}
+			var err error
+			if data, err = scpReceive(w, bufio.NewReader(r)); err != nil {
+				return err
+			}
+			w.Close()
+			return session.Wait()
+		})
+	default:
+		err = c.withSession(ctx, func(session *ssh.Session, w io.WriteCloser, r io.Reader) error {
+			if err := session.Start("cat " + become.Quote(src)); err != nil {
+				return err
+			}
+			var err error
+			if data, err = io.ReadAll(r); err != nil {
+				return err
+			}
+			return session.Wait()
+		})
+	}
+	return data, err
+}
+
+// withSession runs fn on a new session with its stdin and stdout piped,
+// closing the session if ctx is cancelled
+func (c *SSHConnection) withSession(ctx context.Context, fn func(session *ssh.Session, w io.WriteCloser, r io.Reader) error) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := fn(session, w, r); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" && !errors.Is(err, errTransferUnsupported) {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// pipedUpload streams src through the shell as base64 chunks appended to a
+// temporary file, which is decoded into place once complete
+func (c *SSHConnection) pipedUpload(ctx context.Context, src io.Reader, dest string, mode int) error {
+	tempFile := fmt.Sprintf("/tmp/gosible_copy_%d.b64", time.Now().UnixNano())
+	defer c.Execute(context.Background(), "rm -f "+tempFile, types.ExecuteOptions{})
+
+	if err := c.run(ctx, ": > "+tempFile); err != nil {
+		return err
+	}
+	buf := make([]byte, pipedChunkSize)
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			chunk := base64.StdEncoding.EncodeToString(buf[:n])
+			if err := c.run(ctx, fmt.Sprintf("printf '%%s' '%s' >> %s", chunk, tempFile)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.run(ctx, fmt.Sprintf("base64 -d < %s > %s && chmod %04o %s",
+		tempFile, become.Quote(dest), mode, become.Quote(dest)))
+}
+
+// run executes command and turns a non-zero exit into an error
+func (c *SSHConnection) run(ctx context.Context, command string) error {
+	result, err := c.Execute(ctx, command, types.ExecuteOptions{})
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		stderr, _ := result.Data["stderr"].(string)
+		return fmt.Errorf("%v: %s", result.Error, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// sizedReader returns src as a seekable reader along with the number of
+// bytes left in it, spooling to a local temporary file when src cannot
+// seek. SCP needs the size up front and smart fallback rewinds the reader.
+func sizedReader(src io.Reader) (io.ReadSeeker, int64, func(), error) {
+	if seeker, ok := src.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err == nil {
+				if _, err := seeker.Seek(start, io.SeekStart); err == nil {
+					return &offsetReader{ReadSeeker: seeker, start: start}, end - start, func() {}, nil
+				}
+			}
+		}
+	}
+
+	spool, err := os.CreateTemp("", "gosible-transfer-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	size, err := io.Copy(spool, src)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return spool, size, cleanup, nil
+}
+
+// offsetReader rewinds to where the caller's reader started rather than to
+// its beginning
+type offsetReader struct {
+	io.ReadSeeker
+	start int64
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset += r.start
+	}
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	return pos - r.start, err
+}
+
+// scpSend speaks the sink side of the SCP protocol ("scp -t") to upload a
+// single file of size bytes
+func scpSend(w io.Writer, r *bufio.Reader, name string, src io.Reader, size int64, mode int) error {
+	// The sink acknowledges that it started before anything else; a shell
+	// without scp closes the stream instead
+	if err := scpAck(r); err != nil {
+		if err == io.EOF {
+			return errTransferUnsupported
+		}
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", mode&0777, size, name); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, src, size); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+// scpReceive speaks the sending side of the SCP protocol ("scp -f") to
+// download a single file
+func scpReceive(w io.Writer, r *bufio.Reader) ([]byte, error) {
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && header == "" {
+			return nil, errTransferUnsupported
+		}
+		return nil, err
+	}
+	if header[0] == 1 || header[0] == 2 {
+		return nil, fmt.Errorf("scp: %s", strings.TrimSpace(header[1:]))
+	}
+
+	var mode, size int64
+	var name string
+	if _, err := fmt.Sscanf(header, "C%o %d %s", &mode, &size, &name); err != nil {
+		return nil, fmt.Errorf("unexpected scp header %q", strings.TrimSpace(header))
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	if err := scpAck(r); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// scpAck reads a response byte: 0 is success, 1 and 2 are followed by an
+// error message
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
@@ -0,0 +1,370 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestTransferMethod(t *testing.T) {
+	tests := []struct {
+		vars     map[string]interface{}
+		expected string
+		wantErr  bool
+	}{
+		{vars: nil, expected: TransferSmart},
+		{vars: map[string]interface{}{"transfer_method": "scp"}, expected: TransferSCP},
+		{vars: map[string]interface{}{"ansible_ssh_transfer_method": "SFTP"}, expected: TransferSFTP},
+		{vars: map[string]interface{}{"ansible_ssh_transfer_method": "piped", "transfer_method": "scp"}, expected: TransferPiped},
+		{vars: map[string]interface{}{"transfer_method": "rsync"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		method, err := transferMethod(types.ConnectionInfo{Variables: tt.vars})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.vars)
+			}
+			continue
+		}
+		if err != nil || method != tt.expected {
+			t.Errorf("%v: expected %q, got %q (%v)", tt.vars, tt.expected, method, err)
+		}
+	}
+}
+
+func TestSSHConnection_TransferMethods(t *testing.T) {
+	conn := &SSHConnection{transfer: TransferSmart}
+	if got := conn.transferMethods(); strings.Join(got, ",") != "sftp,scp,piped" {
+		t.Errorf("expected smart to try sftp, scp and piped, got %v", got)
+	}
+	conn.useTransferMethod(TransferSCP)
+	if got := conn.transferMethods(); strings.Join(got, ",") != "scp" {
+		t.Errorf("expected the detected method to be reused, got %v", got)
+	}
+
+	conn = &SSHConnection{transfer: TransferPiped}
+	if got := conn.transferMethods(); strings.Join(got, ",") != "piped" {
+		t.Errorf("expected only the configured method, got %v", got)
+	}
+}
+
+func TestSizedReader(t *testing.T) {
+	src := strings.NewReader("skip:payload")
+	src.Seek(5, io.SeekStart)
+	reader, size, cleanup, err := sizedReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if size != 7 {
+		t.Errorf("expected the remaining 7 bytes, got %d", size)
+	}
+	io.ReadAll(reader)
+	reader.Seek(0, io.SeekStart)
+	if data, _ := io.ReadAll(reader); string(data) != "payload" {
+		t.Errorf("expected rewinding to return to the caller's offset, got %q", data)
+	}
+
+	// Readers that cannot seek are spooled
+	reader, size, cleanup, err = sizedReader(io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if data, _ := io.ReadAll(reader); size != 6 || string(data) != "abcdef" {
+		t.Errorf("expected 6 spooled bytes, got %d %q", size, data)
+	}
+}
+
+// fakeSFTPServer serves an in-memory file system over the SFTP packets
+// sftpClient uses
+type fakeSFTPServer struct {
+	files   map[string][]byte
+	modes   map[string]uint32
+	writes  int
+	handles map[string]string
+	// garbled files answer READ with a packet of the wrong type
+	garbled map[string]bool
+}
+
+func (s *fakeSFTPServer) serve(r io.Reader, w io.Writer) {
+	handles := s.handles
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[:4])-1)
+		io.ReadFull(r, payload)
+		p := &fakePacket{data: payload}
+
+		reply := func(typ byte, fields ...[]byte) {
+			body := append([]byte{typ}, bytes.Join(fields, nil)...)
+			w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body))))
+			w.Write(body)
+		}
+		u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+		str := func(v string) []byte { return append(u32(uint32(len(v))), v...) }
+		status := func(id, code uint32) { reply(sftpStatus, u32(id), u32(code), str(""), str("")) }
+
+		if header[4] == sftpInit {
+			reply(sftpVersion, u32(3))
+			continue
+		}
+		id := p.uint32()
+		switch header[4] {
+		case sftpOpen:
+			path, flags := p.string(), p.uint32()
+			if _, ok := s.files[path]; !ok && flags&sftpFlagCreat == 0 {
+				status(id, 2)
+				continue
+			}
+			if flags&sftpFlagTrunc != 0 {
+				s.files[path] = nil
+			}
+			handle := fmt.Sprintf("h%d", id)
+			handles[handle] = path
+			reply(sftpHandle, u32(id), str(handle))
+		case sftpWrite:
+			path := handles[p.string()]
+			offset := binary.BigEndian.Uint64(p.next(8))
+			data := p.bytes()
+			file := s.files[path]
+			if end := int(offset) + len(data); end > len(file) {
+				file = append(file, make([]byte, end-len(file))...)
+			}
+			copy(file[offset:], data)
+			s.files[path] = file
+			s.writes++
+			status(id, sftpStatusOK)
+		case sftpRead:
+			path := handles[p.string()]
+			if s.garbled[path] {
+				reply(sftpVersion, u32(3))
+				continue
+			}
+			file := s.files[path]
+			offset := binary.BigEndian.Uint64(p.next(8))
+			length := p.uint32()
+			if int(offset) >= len(file) {
+				status(id, sftpStatusEOF)
+				continue
+			}
+			end := int(offset) + int(length)
+			if end > len(file) {
+				end = len(file)
+			}
+			reply(sftpData, u32(id), str(string(file[offset:end])))
+		case sftpFSetstat:
+			path := handles[p.string()]
+			if p.uint32()&sftpAttrPermissions != 0 {
+				s.modes[path] = p.uint32()
+			}
+			status(id, sftpStatusOK)
+		case sftpClose:
+			delete(handles, p.string())
+			status(id, sftpStatusOK)
+		default:
+			status(id, 8)
+		}
+	}
+}
+
+type queueWriter chan []byte
+
+func (q queueWriter) Write(p []byte) (int, error) {
+	q <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+type fakePacket struct{ data []byte }
+
+func (p *fakePacket) next(n int) []byte {
+	v := p.data[:n]
+	p.data = p.data[n:]
+	return v
+}
+func (p *fakePacket) uint32() uint32 { return binary.BigEndian.Uint32(p.next(4)) }
+func (p *fakePacket) bytes() []byte  { return p.next(int(p.uint32())) }
+func (p *fakePacket) string() string { return string(p.bytes()) }
+
+func newFakeSFTP(t *testing.T) (*sftpClient, *fakeSFTPServer) {
+	t.Helper()
+	server := &fakeSFTPServer{
+		files:   make(map[string][]byte),
+		modes:   make(map[string]uint32),
+		handles: make(map[string]string),
+		garbled: make(map[string]bool),
+	}
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	// Replies are queued like an SSH channel's window would buffer them,
+	// so pipelined writes do not deadlock on the synchronous pipe
+	replies := make(chan []byte, 4*sftpWindow)
+	go func() {
+		for reply := range replies {
+			serverW.Write(reply)
+		}
+	}()
+	go func() {
+		server.serve(serverR, queueWriter(replies))
+		close(replies)
+	}()
+	t.Cleanup(func() {
+		clientW.Close()
+		serverW.Close()
+	})
+
+	client, err := newSFTPClient(clientW, clientR)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	return client, server
+}
+
+func TestSFTPClient_RoundTrip(t *testing.T) {
+	client, server := newFakeSFTP(t)
+
+	// Large enough to span more chunks than the request window
+	data := bytes.Repeat([]byte("0123456789abcdef"), sftpChunkSize*(sftpWindow+3)/16+7)
+	if err := client.WriteFile("/etc/app.conf", bytes.NewReader(data), 0640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if !bytes.Equal(server.files["/etc/app.conf"], data) {
+		t.Errorf("uploaded %d bytes, server has %d", len(data), len(server.files["/etc/app.conf"]))
+	}
+	if server.modes["/etc/app.conf"] != 0640 {
+		t.Errorf("expected mode 0640 to be set, got %o", server.modes["/etc/app.conf"])
+	}
+	if expected := (len(data) + sftpChunkSize - 1) / sftpChunkSize; server.writes != expected {
+		t.Errorf("expected %d chunked writes, got %d", expected, server.writes)
+	}
+
+	fetched, err := client.ReadFile("/etc/app.conf")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Errorf("downloaded %d bytes, expected %d", len(fetched), len(data))
+	}
+
+	_, err = client.ReadFile("/missing")
+	var status *sftpStatusError
+	if !errors.As(err, &status) || status.Code != 2 {
+		t.Errorf("expected a no such file status, got %v", err)
+	}
+}
+
+func TestSFTPClient_ReadFileClosesHandle(t *testing.T) {
+	client, server := newFakeSFTP(t)
+	server.files["/etc/garbled"] = []byte("data")
+	server.garbled["/etc/garbled"] = true
+
+	if _, err := client.ReadFile("/etc/garbled"); err == nil {
+		t.Fatal("expected an unexpected packet to fail the read")
+	}
+	// A further request is answered only after the CLOSE before it
+	if _, err := client.ReadFile("/missing"); err == nil {
+		t.Fatal("expected /missing not to be found")
+	}
+	if len(server.handles) != 0 {
+		t.Errorf("expected the handle to be closed, still open: %v", server.handles)
+	}
+}
+
+// scpPeer runs fn as the remote scp over a pair of pipes
+func scpPeer(fn func(r *bufio.Reader, w io.Writer)) (io.WriteCloser, *bufio.Reader) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go func() {
+		fn(bufio.NewReader(serverR), serverW)
+		serverW.Close()
+	}()
+	return clientW, bufio.NewReader(clientR)
+}
+
+func TestSCPSend(t *testing.T) {
+	var header string
+	var received []byte
+	done := make(chan struct{})
+	w, r := scpPeer(func(r *bufio.Reader, w io.Writer) {
+		defer close(done)
+		w.Write([]byte{0})
+		header, _ = r.ReadString('\n')
+		w.Write([]byte{0})
+		var size int
+		fmt.Sscanf(header, "C0600 %d", &size)
+		received = make([]byte, size+1)
+		io.ReadFull(r, received)
+		w.Write([]byte{0})
+	})
+
+	if err := scpSend(w, r, "id_rsa", strings.NewReader("secret"), 6, 0600); err != nil {
+		t.Fatalf("scpSend failed: %v", err)
+	}
+	<-done
+	if header != "C0600 6 id_rsa\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if string(received) != "secret\x00" {
+		t.Errorf("unexpected data %q", received)
+	}
+}
+
+func TestSCPSendErrors(t *testing.T) {
+	// A shell without scp closes the channel without acknowledging
+	w, r := scpPeer(func(r *bufio.Reader, w io.Writer) {})
+	if err := scpSend(w, r, "f", strings.NewReader(""), 0, 0644); !errors.Is(err, errTransferUnsupported) {
+		t.Errorf("expected scp to be reported unsupported, got %v", err)
+	}
+
+	w, r = scpPeer(func(r *bufio.Reader, w io.Writer) {
+		w.Write([]byte{0})
+		r.ReadString('\n')
+		io.WriteString(w, "\x01scp: /etc/f: Permission denied\n")
+	})
+	if err := scpSend(w, r, "f", strings.NewReader("x"), 1, 0644); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected the remote error, got %v", err)
+	}
+}
+
+func TestSCPReceive(t *testing.T) {
+	w, r := scpPeer(func(r *bufio.Reader, w io.Writer) {
+		r.ReadByte()
+		io.WriteString(w, "C0644 5 motd\n")
+		r.ReadByte()
+		io.WriteString(w, "hello\x00")
+		r.ReadByte()
+	})
+	data, err := scpReceive(w, r)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected hello, got %q (%v)", data, err)
+	}
+
+	w, r = scpPeer(func(r *bufio.Reader, w io.Writer) {
+		r.ReadByte()
+		io.WriteString(w, "\x01scp: /missing: No such file or directory\n")
+	})
+	if _, err := scpReceive(w, r); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("expected the remote error, got %v", err)
+	}
+}
+
+func TestSSHConnection_InvalidTransferMethod(t *testing.T) {
+	conn := NewSSHConnection()
+	err := conn.Connect(t.Context(), types.ConnectionInfo{
+		Host:      "127.0.0.1",
+		Variables: map[string]interface{}{"transfer_method": "ftp"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unsupported transfer method") {
+		t.Errorf("expected the transfer method to be rejected before connecting, got %v", err)
+	}
+}
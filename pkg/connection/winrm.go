@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/liliang-cn/gosible/pkg/become"
 	"github.com/liliang-cn/gosible/pkg/types"
 	"github.com/masterzen/winrm"
 )
@@ -83,7 +84,10 @@ func (c *WinRMConnection) Execute(ctx context.Context, command string, options t
 	}
 
 	// Build command with options
-	fullCommand := c.buildCommand(command, options)
+	fullCommand, err := c.buildCommand(command, options)
+	if err != nil {
+		return nil, types.NewConnectionError(c.info.Host, "failed to apply become", err)
+	}
 
 	// Create shell
 	shell, err := c.client.CreateShell()
@@ -168,7 +172,15 @@ func (c *WinRMConnection) ExecuteStream(ctx context.Context, command string, opt
 		}
 
 		// Build command
-		fullCommand := c.buildCommand(command, options)
+		fullCommand, err := c.buildCommand(command, options)
+		if err != nil {
+			eventChan <- types.StreamEvent{
+				Type:      types.StreamError,
+				Error:     types.NewConnectionError(c.info.Host, "failed to apply become", err),
+				Timestamp: time.Now(),
+			}
+			return
+		}
 
 		// Create shell
 		shell, err := c.client.CreateShell()
@@ -439,9 +451,12 @@ func (c *WinRMConnection) IsConnected() bool {
 }
 
 // buildCommand builds the full command string with options
-func (c *WinRMConnection) buildCommand(command string, options types.ExecuteOptions) string {
+func (c *WinRMConnection) buildCommand(command string, options types.ExecuteOptions) (string, error) {
+	powershell := options.Shell == "powershell" || strings.HasPrefix(command, "$")
+	command = withWindowsEnv(command, options.Env, powershell)
+
 	// Handle shell option
-	if options.Shell == "powershell" || strings.HasPrefix(command, "$") {
+	if powershell {
 		// PowerShell command
 		if options.WorkingDir != "" {
 			command = fmt.Sprintf("Set-Location '%s'; %s", options.WorkingDir, command)
@@ -453,14 +468,31 @@ func (c *WinRMConnection) buildCommand(command string, options types.ExecuteOpti
 		}
 	}
 
-	// Handle run as user (requires appropriate privileges)
-	if options.User != "" && options.User != c.info.User {
-		// This would require more complex handling with scheduled tasks or PSExec
-		// For now, we'll add a comment indicating this limitation
-		command = fmt.Sprintf("REM Run as user %s not supported yet\n%s", options.User, command)
+	// Handle run as user. The legacy User option only escalates when it
+	// differs from the connection user, and always maps to runas since
+	// sudo and su do not exist on Windows.
+	config := become.FromOptions(options)
+	if config == nil || (options.Become == nil && options.User == c.info.User) {
+		return command, nil
+	}
+	method := config.Method
+	if options.Become == nil || method == "" {
+		method = "runas"
+	}
+	plugin, err := become.DefaultManager.Get(method)
+	if err != nil {
+		return "", err
 	}
 
-	return command
+	// runas starts the command through cmd.exe
+	if powershell {
+		command = become.EncodePowerShell(command)
+	}
+	wrapped, err := plugin.Wrap(command, *config)
+	if err != nil {
+		return "", err
+	}
+	return wrapped.Line, nil
 }
 
 // testConnection tests if the WinRM connection is working
@@ -63,18 +63,30 @@ func TestWinRMConnection_BuildCommand(t *testing.T) {
 			expected: "$processes = Get-Process",
 		},
 		{
-			name:    "with different user",
-			command: "whoami",
+			name:    "environment - PowerShell",
+			command: "$env:APP_MODE",
 			options: types.ExecuteOptions{
-				User: "testuser",
+				Env:        map[string]string{"APP_MODE": "it's prod"},
+				WorkingDir: "C:\\app",
 			},
-			expected: "REM Run as user testuser not supported yet\nwhoami",
+			expected: `Set-Location 'C:\app'; $env:APP_MODE = 'it''s prod'; $env:APP_MODE`,
+		},
+		{
+			name:    "environment - CMD",
+			command: "echo %APP_MODE%",
+			options: types.ExecuteOptions{
+				Env: map[string]string{"APP_MODE": "prod", "LANG": "C"},
+			},
+			expected: `set "APP_MODE=prod" && set "LANG=C" && echo %APP_MODE%`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := conn.buildCommand(tt.command, tt.options)
+			result, err := conn.buildCommand(tt.command, tt.options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
 			if result != tt.expected {
 				t.Errorf("expected %q, got %q", tt.expected, result)
 			}
@@ -82,6 +94,28 @@ func TestWinRMConnection_BuildCommand(t *testing.T) {
 	}
 }
 
+func TestWinRMConnection_BuildCommandBecome(t *testing.T) {
+	conn := NewWinRMConnection()
+
+	// runas cannot prompt, so a different user without a password fails
+	if _, err := conn.buildCommand("whoami", types.ExecuteOptions{User: "testuser"}); err == nil {
+		t.Error("expected error for runas without a password")
+	}
+
+	result, err := conn.buildCommand("$env:USERNAME", types.ExecuteOptions{
+		Become: &types.BecomeConfig{Method: "runas", User: "svc", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(result, "powershell.exe -NoProfile -NonInteractive -EncodedCommand ") {
+		t.Errorf("expected encoded runas wrapper, got %q", result)
+	}
+	if strings.Contains(result, "secret") {
+		t.Error("password must not appear in plain text on the command line")
+	}
+}
+
 func TestWinRMConnection_IsConnected(t *testing.T) {
 	conn := NewWinRMConnection()
 	
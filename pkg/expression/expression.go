@@ -0,0 +1,105 @@
+// Package expression evaluates the conditions of tasks: when, failed_when,
+// changed_when and until. Conditions are Jinja2 expressions, as in Ansible,
+// with boolean operators, comparisons, membership, tests such as
+// "is defined" or "is failed" and filters.
+package expression
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/template"
+)
+
+// Evaluator evaluates conditions with a template engine's filters, tests
+// and lookups
+type Evaluator struct {
+	engine *template.Engine
+}
+
+// NewEvaluator creates an evaluator using a new template engine
+func NewEvaluator() *Evaluator {
+	return NewEvaluatorWithEngine(template.NewEngine())
+}
+
+// NewEvaluatorWithEngine creates an evaluator using the filters and
+// lookups of an existing template engine
+func NewEvaluatorWithEngine(engine *template.Engine) *Evaluator {
+	return &Evaluator{engine: engine}
+}
+
+// Evaluate returns the value of an expression. An expression wrapped in
+// {{ }} as a whole is evaluated without the braces.
+func (e *Evaluator) Evaluate(expr string, vars map[string]interface{}) (interface{}, error) {
+	expr = unwrap(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return e.engine.Evaluate(expr, vars)
+}
+
+// Condition evaluates a condition: a boolean, an expression or a list of
+// conditions that must all hold. A nil condition holds. YAML booleans
+// written as strings, like "yes", count as booleans.
+func (e *Evaluator) Condition(condition interface{}, vars map[string]interface{}) (bool, error) {
+	switch v := condition.(type) {
+	case nil:
+		return true, nil
+	case bool:
+		return v, nil
+	case string:
+		expr := unwrap(v)
+		switch strings.ToLower(expr) {
+		case "true", "yes", "on":
+			return true, nil
+		case "false", "no", "off":
+			return false, nil
+		}
+		value, err := e.Evaluate(expr, vars)
+		if err != nil {
+			return false, fmt.Errorf("condition %q: %w", expr, err)
+		}
+		return template.Truthy(value), nil
+	case []interface{}:
+		for _, cond := range v {
+			ok, err := e.Condition(cond, vars)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case []string:
+		for _, cond := range v {
+			ok, err := e.Condition(cond, vars)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case int, int64, float64:
+		return template.Truthy(v), nil
+	}
+	return false, fmt.Errorf("unsupported condition type %T", condition)
+}
+
+// unwrap trims an expression and removes {{ }} around it as a whole
+func unwrap(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "{{") && strings.HasSuffix(expr, "}}") && strings.Count(expr, "{{") == 1 {
+		expr = strings.TrimSpace(expr[2 : len(expr)-2])
+	}
+	return expr
+}
+
+// DefaultEvaluator evaluates conditions with the default template engine
+var DefaultEvaluator = NewEvaluatorWithEngine(template.DefaultTemplateEngine)
+
+// Evaluate returns the value of an expression using the default evaluator
+func Evaluate(expr string, vars map[string]interface{}) (interface{}, error) {
+	return DefaultEvaluator.Evaluate(expr, vars)
+}
+
+// Condition evaluates a condition using the default evaluator
+func Condition(condition interface{}, vars map[string]interface{}) (bool, error) {
+	return DefaultEvaluator.Condition(condition, vars)
+}
@@ -0,0 +1,142 @@
+package expression
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testVars() map[string]interface{} {
+	return map[string]interface{}{
+		"env":     "prod",
+		"count":   10,
+		"ratio":   0.5,
+		"debug":   false,
+		"servers": []interface{}{"web1", "web2", "db1"},
+		"config": map[string]interface{}{
+			"database": map[string]interface{}{"host": "localhost", "port": 5432},
+			"features": []interface{}{"tls", "http2"},
+		},
+		"ansible_distribution":         "Ubuntu",
+		"ansible_distribution_version": "22.04",
+		"ansible_facts":                map[string]interface{}{"os_family": "Debian"},
+		"out": map[string]interface{}{
+			"rc":           0,
+			"stdout":       "service is running\nready",
+			"stdout_lines": []interface{}{"service is running", "ready"},
+			"changed":      true,
+			"failed":       false,
+		},
+		"broken":  map[string]interface{}{"rc": 2, "failed": true, "changed": false},
+		"skipped": map[string]interface{}{"skipped": true, "changed": false},
+	}
+}
+
+func TestCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition interface{}
+		expected  bool
+	}{
+		{"nil holds", nil, true},
+		{"boolean", false, false},
+		{"yaml boolean string", "yes", true},
+		{"jinja literal", "True", true},
+		{"equality", "env == 'prod'", true},
+		{"inequality", "env != 'prod'", false},
+		{"numeric comparison", "count > 5 and ratio <= 0.5", true},
+		{"chained boolean operators", "env == 'dev' or count >= 10 and not debug", true},
+		{"parentheses", "(env == 'dev' or count >= 10) and debug", false},
+		{"is defined", "config.database is defined and missing is undefined", true},
+		{"is not defined", "config.cache is not defined", true},
+		{"in list", "'web2' in servers", true},
+		{"not in list", "'web3' not in servers", true},
+		{"in string", "'running' in out.stdout", true},
+		{"in dict", "'host' in config.database", true},
+		{"index and attribute", "servers[-1] == 'db1' and config['database'].port == 5432", true},
+		{"filters", "servers | length == 3 and (env | upper) == 'PROD'", true},
+		{"filter with default", "(missing | default('x')) == 'x'", true},
+		{"select filter", "servers | select('match', '^web') | list | length == 2", true},
+		{"facts", "ansible_distribution == 'Ubuntu' and ansible_facts.os_family == 'Debian'", true},
+		{"version test", "ansible_distribution_version is version('20.04', '>=')", true},
+		{"version numeric parts", "'1.10.0' is version('1.9', 'gt')", true},
+		{"registered result", "out.rc == 0 and 'ready' in out.stdout_lines", true},
+		{"result tests", "out is succeeded and out is changed and broken is failed", true},
+		{"skipped test", "skipped is skipped and out is not skipped", true},
+		{"match test", "env is match('pr')", true},
+		{"search test", "out.stdout is search('is run+ing')", true},
+		{"subset test", "['web1'] is subset(servers)", true},
+		{"conditional expression", "('a' if debug else 'b') == 'b'", true},
+		{"wrapped in braces", "{{ count == 10 }}", true},
+		{"truthy value", "servers", true},
+		{"falsy value", "config.cache | default([])", false},
+		{"list of conditions", []interface{}{"env == 'prod'", "count > 5"}, true},
+		{"list with a false condition", []interface{}{"env == 'prod'", "debug"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Condition(tt.condition, testVars())
+			if err != nil {
+				t.Fatalf("Condition(%v) failed: %v", tt.condition, err)
+			}
+			if got != tt.expected {
+				t.Errorf("Condition(%v) = %v, want %v", tt.condition, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition interface{}
+		contains  string
+	}{
+		{"undefined variable", "missing == 1", "'missing' is undefined"},
+		{"undefined attribute", "config.cache.size > 1", "'config.cache.size' is undefined"},
+		{"syntax error", "env ==", "failed to parse expression"},
+		{"unknown test", "env is shiny", "no test named 'shiny'"},
+		{"invalid version operator", "'1.0' is version('1.0', '~')", "invalid version operator"},
+		{"result test on a string", "env is failed", "needs a task result"},
+		{"unsupported type", map[string]interface{}{"env": "prod"}, "unsupported condition type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Condition(tt.condition, testVars())
+			if err == nil || !strings.Contains(err.Error(), tt.contains) {
+				t.Fatalf("expected an error containing %q, got %v", tt.contains, err)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"count * 2", 20},
+		{"servers | first", "web1"},
+		{"config.features", []interface{}{"tls", "http2"}},
+		{"{{ env ~ '-' ~ count }}", "prod-10"},
+		{"out.rc == 0", true},
+	}
+
+	evaluator := NewEvaluator()
+	for _, tt := range tests {
+		got, err := evaluator.Evaluate(tt.expr, testVars())
+		if err != nil {
+			t.Errorf("Evaluate(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Evaluate(%q) = %#v, want %#v", tt.expr, got, tt.expected)
+		}
+	}
+
+	if _, err := evaluator.Evaluate("  ", nil); err == nil {
+		t.Error("expected an empty expression to fail")
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // FilterPlugin interface for all filter plugins
@@ -103,6 +105,9 @@ func (fm *FilterManager) RegisterBuiltinFilters() {
 	
 	// IP filters
 	fm.Register(&IPAddrFilter{})
+	fm.Register(&NthHostFilter{})
+	fm.Register(&IPSubnetFilter{})
+	fm.Register(&IPMathFilter{})
 	fm.Register(&IPWrapFilter{})
 	fm.Register(&IPv4Filter{})
 	fm.Register(&IPv6Filter{})
@@ -116,7 +121,9 @@ func (fm *FilterManager) RegisterBuiltinFilters() {
 	// JSON/YAML filters
 	fm.Register(&ToJSONFilter{})
 	fm.Register(&FromJSONFilter{})
+	fm.Register(&ToNiceJSONFilter{})
 	fm.Register(&ToYAMLFilter{})
+	fm.Register(&ToNiceYAMLFilter{})
 	fm.Register(&FromYAMLFilter{})
 }
 
@@ -1085,32 +1092,6 @@ func (f *ToDatetimeFilter) Filter(input interface{}, args ...interface{}) (inter
 
 // IP Filters
 
-// IPAddrFilter formats IP address
-type IPAddrFilter struct{}
-
-func (f *IPAddrFilter) Name() string { return "ipaddr" }
-func (f *IPAddrFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
-	str, ok := input.(string)
-	if !ok {
-		return nil, fmt.Errorf("ipaddr filter requires string input")
-	}
-	
-	// Parse IP or CIDR
-	if strings.Contains(str, "/") {
-		_, ipnet, err := net.ParseCIDR(str)
-		if err != nil {
-			return nil, fmt.Errorf("invalid CIDR: %w", err)
-		}
-		return ipnet.String(), nil
-	} else {
-		ip := net.ParseIP(str)
-		if ip == nil {
-			return nil, fmt.Errorf("invalid IP address")
-		}
-		return ip.String(), nil
-	}
-}
-
 // IPWrapFilter wraps IPv6 addresses in brackets
 type IPWrapFilter struct{}
 
@@ -1281,13 +1262,46 @@ func (f *FromJSONFilter) Filter(input interface{}, args ...interface{}) (interfa
 	return result, nil
 }
 
-// ToYAMLFilter converts to YAML
+// ToNiceJSONFilter converts to indented JSON, 4 spaces unless an indent is given
+type ToNiceJSONFilter struct{}
+
+func (f *ToNiceJSONFilter) Name() string { return "to_nice_json" }
+func (f *ToNiceJSONFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	indent, err := indentArg(args, 4)
+	if err != nil {
+		return nil, err
+	}
+	
+	data, err := json.MarshalIndent(input, "", strings.Repeat(" ", indent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// ToYAMLFilter converts to YAML, with an optional indent (default 2)
 type ToYAMLFilter struct{}
 
 func (f *ToYAMLFilter) Name() string { return "to_yaml" }
 func (f *ToYAMLFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
-	// This would use a YAML library in real implementation
-	return fmt.Sprintf("%v", input), nil
+	indent, err := indentArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return marshalYAML(input, indent)
+}
+
+// ToNiceYAMLFilter converts to human readable YAML, 4 spaces unless an
+// indent is given
+type ToNiceYAMLFilter struct{}
+
+func (f *ToNiceYAMLFilter) Name() string { return "to_nice_yaml" }
+func (f *ToNiceYAMLFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	indent, err := indentArg(args, 4)
+	if err != nil {
+		return nil, err
+	}
+	return marshalYAML(input, indent)
 }
 
 // FromYAMLFilter parses YAML
@@ -1295,8 +1309,62 @@ type FromYAMLFilter struct{}
 
 func (f *FromYAMLFilter) Name() string { return "from_yaml" }
 func (f *FromYAMLFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
-	// This would use a YAML library in real implementation
-	return input, nil
+	str, ok := input.(string)
+	if !ok {
+		return nil, fmt.Errorf("from_yaml filter requires string input")
+	}
+	
+	var result interface{}
+	if err := yaml.Unmarshal([]byte(str), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	
+	return result, nil
+}
+
+// marshalYAML encodes input as YAML indented by the given number of spaces
+func marshalYAML(input interface{}, indent int) (string, error) {
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(input); err != nil {
+		return "", fmt.Errorf("failed to marshal to YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to marshal to YAML: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// indentArg reads the optional indent argument of the to_nice_* filters
+func indentArg(args []interface{}, defaultIndent int) (int, error) {
+	if len(args) == 0 {
+		return defaultIndent, nil
+	}
+	
+	var indent int
+	switch v := args[0].(type) {
+	case nil:
+		return defaultIndent, nil
+	case int:
+		indent = v
+	case int64:
+		indent = int(v)
+	case float64:
+		indent = int(v)
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("indent must be a number, got %q", v)
+		}
+		indent = n
+	default:
+		return 0, fmt.Errorf("indent must be a number, got %T", args[0])
+	}
+	if indent < 0 {
+		return 0, fmt.Errorf("indent cannot be negative")
+	}
+	return indent, nil
 }
 
 // Helper function to convert Python strftime format to Go format
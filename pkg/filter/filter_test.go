@@ -663,6 +663,148 @@ func TestIPWrapFilter(t *testing.T) {
 	}
 }
 
+func TestIPAddrQueries(t *testing.T) {
+	filter := &IPAddrFilter{}
+	
+	tests := []struct {
+		input    string
+		query    interface{}
+		expected interface{}
+	}{
+		{"192.0.2.0/24", "net", "192.0.2.0/24"},
+		{"192.0.2.5/24", "net", false},
+		{"192.0.2.5/24", "subnet", "192.0.2.0/24"},
+		{"192.0.2.5/24", "address", "192.0.2.5"},
+		{"192.0.2.0/24", "address", false},
+		{"192.0.2.5/24", "host", "192.0.2.5/24"},
+		{"192.0.2.5", "host", "192.0.2.5/32"},
+		{"192.0.2.5/24", "network", "192.0.2.0"},
+		{"192.0.2.5/24", "netmask", "255.255.255.0"},
+		{"10.1.2.3/20", "netmask", "255.255.240.0"},
+		{"10.1.2.3/20", "hostmask", "0.0.15.255"},
+		{"192.0.2.5/24", "prefix", 24},
+		{"192.0.2.0/24", "size", 256},
+		{"2001:db8::/32", "size", "79228162514264337593543950336"},
+		{"192.0.2.0/24", "broadcast", "192.0.2.255"},
+		{"192.0.2.0/24", "first_usable", "192.0.2.1"},
+		{"192.0.2.0/24", "last_usable", "192.0.2.254"},
+		{"192.0.2.0/31", "range_usable", "192.0.2.0-192.0.2.1"},
+		{"192.0.2.5/24", "next_usable", "192.0.2.6"},
+		{"192.0.2.254/24", "next_usable", false},
+		{"192.0.2.5/24", "previous_usable", "192.0.2.4"},
+		{"192.0.2.0/24", 5, "192.0.2.5/24"},
+		{"192.0.2.0/24", -1, "192.0.2.255/24"},
+		{"2001:db8::/64", "3", "2001:db8::3/64"},
+		{"10.0.0.1", "private", "10.0.0.1"},
+		{"8.8.8.8", "private", false},
+		{"8.8.8.8", "public", "8.8.8.8"},
+		{"192.0.2.1", "ipv6", false},
+		{"2001:db8::1", "ipv6", "2001:db8::1"},
+		{"2001:db8::1", "wrap", "[2001:db8::1]"},
+		{"192.0.2.5", "revdns", "5.2.0.192.in-addr.arpa."},
+	}
+	
+	for _, tt := range tests {
+		result, err := filter.Filter(tt.input, tt.query)
+		if err != nil {
+			t.Errorf("ipaddr(%v) of %s failed: %v", tt.query, tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ipaddr(%v) of %s: expected %v, got %v", tt.query, tt.input, tt.expected, result)
+		}
+	}
+	
+	// Lists keep the items the query applies to
+	result, err := filter.Filter([]interface{}{"192.0.2.1", "not an ip", "192.0.2.0/24", "2001:db8::/64"}, "net")
+	if err != nil {
+		t.Fatalf("ipaddr of a list failed: %v", err)
+	}
+	if !reflect.DeepEqual(result, []interface{}{"192.0.2.0/24", "2001:db8::/64"}) {
+		t.Errorf("expected only the networks, got %v", result)
+	}
+	
+	if _, err := filter.Filter("192.0.2.0/24", 256); err == nil {
+		t.Error("expected an error for an address outside the network")
+	}
+	if _, err := filter.Filter("192.0.2.0/24", "nonsense"); err == nil {
+		t.Error("expected an error for an unknown query")
+	}
+}
+
+func TestNthHostFilter(t *testing.T) {
+	result, err := (&NthHostFilter{}).Filter("10.0.0.0/8", 305)
+	if err != nil || result != "10.0.1.49" {
+		t.Errorf("expected 10.0.1.49, got %v (%v)", result, err)
+	}
+	
+	result, err = (&NthHostFilter{}).Filter("192.0.2.0/24", -2)
+	if err != nil || result != "192.0.2.254" {
+		t.Errorf("expected 192.0.2.254, got %v (%v)", result, err)
+	}
+}
+
+func TestIPSubnetFilter(t *testing.T) {
+	filter := &IPSubnetFilter{}
+	
+	tests := []struct {
+		input    string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"192.0.2.5/24", nil, "192.0.2.0/24"},
+		{"192.0.2.0/24", []interface{}{27}, 8},
+		{"192.0.2.0/24", []interface{}{27, 0}, "192.0.2.0/27"},
+		{"192.0.2.0/24", []interface{}{27, 2}, "192.0.2.64/27"},
+		{"192.0.2.0/24", []interface{}{27, -1}, "192.0.2.224/27"},
+		{"192.0.2.5/24", []interface{}{20}, "192.0.0.0/20"},
+		{"2001:db8::/32", []interface{}{48, 1}, "2001:db8:1::/48"},
+	}
+	
+	for _, tt := range tests {
+		result, err := filter.Filter(tt.input, tt.args...)
+		if err != nil {
+			t.Errorf("ipsubnet%v of %s failed: %v", tt.args, tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ipsubnet%v of %s: expected %v, got %v", tt.args, tt.input, tt.expected, result)
+		}
+	}
+	
+	if _, err := filter.Filter("192.0.2.0/24", 27, 8); err == nil {
+		t.Error("expected an error for a subnet index out of range")
+	}
+	if _, err := filter.Filter("192.0.2.0/24", 33); err == nil {
+		t.Error("expected an error for an invalid prefix length")
+	}
+}
+
+func TestIPMathFilter(t *testing.T) {
+	filter := &IPMathFilter{}
+	
+	tests := []struct {
+		input    string
+		amount   interface{}
+		expected string
+	}{
+		{"192.0.2.1", 5, "192.0.2.6"},
+		{"192.0.2.1", -2, "192.0.1.255"},
+		{"192.0.2.250/24", 10, "192.0.3.4"},
+		{"2001:db8::ffff", 1, "2001:db8::1:0"},
+	}
+	for _, tt := range tests {
+		result, err := filter.Filter(tt.input, tt.amount)
+		if err != nil || result != tt.expected {
+			t.Errorf("ipmath(%v) of %s: expected %s, got %v (%v)", tt.amount, tt.input, tt.expected, result, err)
+		}
+	}
+	
+	if _, err := filter.Filter("255.255.255.255", 1); err == nil {
+		t.Error("expected an error past the end of the address space")
+	}
+}
+
 // JSON Filters Tests
 
 func TestToFromJSONFilters(t *testing.T) {
@@ -706,6 +848,70 @@ func TestToFromJSONFilters(t *testing.T) {
 	}
 }
 
+func TestToFromYAMLFilters(t *testing.T) {
+	data := map[string]interface{}{
+		"name": "web",
+		"ports": []interface{}{80, 443},
+	}
+	
+	yamlStr, err := (&ToYAMLFilter{}).Filter(data, nil)
+	if err != nil {
+		t.Fatalf("ToYAML filter failed: %v", err)
+	}
+	if yamlStr != "name: web\nports:\n  - 80\n  - 443\n" {
+		t.Errorf("unexpected YAML: %q", yamlStr)
+	}
+	
+	niceStr, err := (&ToNiceYAMLFilter{}).Filter(map[string]interface{}{"app": data}, nil)
+	if err != nil {
+		t.Fatalf("ToNiceYAML filter failed: %v", err)
+	}
+	if !strings.Contains(niceStr.(string), "\n    name: web\n") {
+		t.Errorf("expected 4 space indentation by default, got %q", niceStr)
+	}
+	
+	niceStr, err = (&ToNiceYAMLFilter{}).Filter(map[string]interface{}{"app": data}, 2)
+	if err != nil {
+		t.Fatalf("ToNiceYAML filter with indent failed: %v", err)
+	}
+	if !strings.Contains(niceStr.(string), "\n  name: web\n") {
+		t.Errorf("expected 2 space indentation, got %q", niceStr)
+	}
+	
+	if _, err := (&ToNiceYAMLFilter{}).Filter(data, "wide"); err == nil {
+		t.Error("expected an error for a non-numeric indent")
+	}
+	
+	parsed, err := (&FromYAMLFilter{}).Filter(yamlStr, nil)
+	if err != nil {
+		t.Fatalf("FromYAML filter failed: %v", err)
+	}
+	expected := map[string]interface{}{"name": "web", "ports": []interface{}{80, 443}}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("expected %v, got %v", expected, parsed)
+	}
+	
+	if _, err := (&FromYAMLFilter{}).Filter("key: [unclosed", nil); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+}
+
+func TestToNiceJSONFilter(t *testing.T) {
+	result, err := (&ToNiceJSONFilter{}).Filter(map[string]interface{}{"b": 1, "a": []int{1}}, nil)
+	if err != nil {
+		t.Fatalf("ToNiceJSON filter failed: %v", err)
+	}
+	expected := "{\n    \"a\": [\n        1\n    ],\n    \"b\": 1\n}"
+	if result != expected {
+		t.Errorf("expected sorted keys with 4 space indentation, got %q", result)
+	}
+	
+	result, _ = (&ToNiceJSONFilter{}).Filter(map[string]interface{}{"a": 1}, 2)
+	if result != "{\n  \"a\": 1\n}" {
+		t.Errorf("expected 2 space indentation, got %q", result)
+	}
+}
+
 // Test ChainFilters function
 func TestChainFilters(t *testing.T) {
 	fm := NewFilterManager()
@@ -0,0 +1,508 @@
+package filter
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ipValue is an address, optionally with the network it belongs to, as the
+// ipaddr family of filters sees its input
+type ipValue struct {
+	addr      netip.Addr
+	prefix    netip.Prefix
+	hasPrefix bool
+}
+
+// parseIPValue parses an address such as 192.0.2.5 or an address with a
+// prefix length such as 192.0.2.5/24 or 2001:db8::/32
+func parseIPValue(input interface{}) (ipValue, error) {
+	str, ok := input.(string)
+	if !ok {
+		return ipValue{}, fmt.Errorf("expected an IP address string, got %T", input)
+	}
+	str = strings.TrimSpace(str)
+
+	if strings.Contains(str, "/") {
+		prefix, err := netip.ParsePrefix(str)
+		if err != nil {
+			return ipValue{}, fmt.Errorf("invalid CIDR: %w", err)
+		}
+		return ipValue{addr: prefix.Addr(), prefix: prefix, hasPrefix: true}, nil
+	}
+
+	addr, err := netip.ParseAddr(str)
+	if err != nil {
+		return ipValue{}, fmt.Errorf("invalid IP address: %s", str)
+	}
+	addr = addr.Unmap()
+	return ipValue{addr: addr, prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// network returns the first address of the network
+func (v ipValue) network() netip.Addr {
+	return v.prefix.Masked().Addr()
+}
+
+// size returns the number of addresses in the network
+func (v ipValue) size() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(v.addr.BitLen()-v.prefix.Bits()))
+}
+
+// isNetwork reports whether the value names a whole network rather than an
+// address within it
+func (v ipValue) isNetwork() bool {
+	return v.prefix.Bits() < v.addr.BitLen() && v.addr == v.network()
+}
+
+// nth returns the address n places from the start of the network, counting
+// from the end when n is negative
+func (v ipValue) nth(n *big.Int) (netip.Addr, error) {
+	size := v.size()
+	index := new(big.Int).Set(n)
+	if index.Sign() < 0 {
+		index.Add(index, size)
+	}
+	if index.Sign() < 0 || index.Cmp(size) >= 0 {
+		return netip.Addr{}, fmt.Errorf("index %s is out of range for %s", n, v.prefix.Masked())
+	}
+	return addAddr(v.network(), index)
+}
+
+// usable returns the first and last addresses hosts can use: the network
+// and broadcast addresses are excluded unless the network is a point to
+// point /31 (or /127) or a single address
+func (v ipValue) usable() (netip.Addr, netip.Addr, error) {
+	size := v.size()
+	if size.Cmp(big.NewInt(2)) <= 0 {
+		last, err := addAddr(v.network(), new(big.Int).Sub(size, big.NewInt(1)))
+		return v.network(), last, err
+	}
+	first, err := addAddr(v.network(), big.NewInt(1))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	last, err := addAddr(v.network(), new(big.Int).Sub(size, big.NewInt(2)))
+	return first, last, err
+}
+
+// addrInt converts an address to an integer
+func addrInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+// addAddr returns the address n places after addr, which may be negative
+func addAddr(addr netip.Addr, n *big.Int) (netip.Addr, error) {
+	sum := addrInt(addr)
+	sum.Add(sum, n)
+	if sum.Sign() < 0 || sum.BitLen() > addr.BitLen() {
+		return netip.Addr{}, fmt.Errorf("%s %+d is outside the IPv%d address space", addr, n, ipVersion(addr))
+	}
+
+	bytes := make([]byte, addr.BitLen()/8)
+	sum.FillBytes(bytes)
+	result, _ := netip.AddrFromSlice(bytes)
+	return result, nil
+}
+
+// maskAddr returns the netmask of a prefix length as an address
+func maskAddr(bits, length int) netip.Addr {
+	mask := make([]byte, length/8)
+	for i := range mask {
+		switch {
+		case bits >= 8:
+			mask[i] = 0xff
+			bits -= 8
+		case bits > 0:
+			mask[i] = byte(0xff << (8 - bits))
+			bits = 0
+		}
+	}
+	addr, _ := netip.AddrFromSlice(mask)
+	return addr
+}
+
+// invertAddr flips every bit of an address
+func invertAddr(addr netip.Addr) netip.Addr {
+	bytes := addr.AsSlice()
+	for i := range bytes {
+		bytes[i] = ^bytes[i]
+	}
+	result, _ := netip.AddrFromSlice(bytes)
+	return result
+}
+
+func ipVersion(addr netip.Addr) int {
+	if addr.Is4() {
+		return 4
+	}
+	return 6
+}
+
+// bigArg converts a numeric filter argument
+func bigArg(arg interface{}) (*big.Int, bool) {
+	switch v := arg.(type) {
+	case int:
+		return big.NewInt(int64(v)), true
+	case int64:
+		return big.NewInt(v), true
+	case float64:
+		if v != float64(int64(v)) {
+			return nil, false
+		}
+		return big.NewInt(int64(v)), true
+	case string:
+		n, ok := new(big.Int).SetString(strings.TrimSpace(v), 10)
+		return n, ok
+	}
+	return nil, false
+}
+
+// bigResult returns n as an int when it fits, otherwise as a decimal string
+func bigResult(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return int(n.Int64())
+	}
+	return n.String()
+}
+
+// IPAddrFilter validates and queries IP addresses and networks, like
+// Ansible's ipaddr. Without a query the normalized value is returned. A query
+// names the part to return, e.g. address, network, netmask, prefix,
+// broadcast or size; an integer selects the nth address of the network.
+// Values the query does not apply to yield false, and lists are filtered
+// down to the items the query applies to.
+type IPAddrFilter struct{}
+
+func (f *IPAddrFilter) Name() string { return "ipaddr" }
+func (f *IPAddrFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	var query interface{}
+	if len(args) > 0 {
+		query = args[0]
+	}
+
+	if items, ok := ipListInput(input); ok {
+		result := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := ipaddrQuery(item, query)
+			if err != nil || value == false {
+				continue
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+
+	if _, ok := input.(string); !ok {
+		return nil, fmt.Errorf("ipaddr filter requires string input")
+	}
+	return ipaddrQuery(input, query)
+}
+
+// ipListInput returns the items of a list input
+func ipListInput(input interface{}) ([]interface{}, bool) {
+	switch v := input.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// ipaddrQuery answers one ipaddr query about one value
+func ipaddrQuery(input, query interface{}) (interface{}, error) {
+	v, err := parseIPValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, ok := bigArg(query); ok {
+		addr, err := v.nth(n)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%s/%d", addr, v.prefix.Bits()), nil
+	}
+
+	q := ""
+	if query != nil {
+		q = fmt.Sprint(query)
+	}
+
+	switch q {
+	case "":
+		if v.hasPrefix {
+			if v.isNetwork() {
+				return v.prefix.Masked().String(), nil
+			}
+			return v.prefix.String(), nil
+		}
+		return v.addr.String(), nil
+
+	case "address":
+		if v.isNetwork() {
+			return false, nil
+		}
+		return v.addr.String(), nil
+
+	case "host":
+		if v.isNetwork() && v.size().Cmp(big.NewInt(2)) > 0 {
+			return false, nil
+		}
+		return v.prefix.String(), nil
+
+	case "host/prefix", "address/prefix":
+		if !v.hasPrefix || v.isNetwork() {
+			return false, nil
+		}
+		return v.prefix.String(), nil
+
+	case "net":
+		if !v.isNetwork() {
+			return false, nil
+		}
+		return v.prefix.Masked().String(), nil
+
+	case "subnet", "cidr", "network/prefix":
+		return v.prefix.Masked().String(), nil
+
+	case "network":
+		return v.network().String(), nil
+
+	case "netmask":
+		return maskAddr(v.prefix.Bits(), v.addr.BitLen()).String(), nil
+
+	case "hostmask":
+		return invertAddr(maskAddr(v.prefix.Bits(), v.addr.BitLen())).String(), nil
+
+	case "prefix":
+		return v.prefix.Bits(), nil
+
+	case "size":
+		return bigResult(v.size()), nil
+
+	case "broadcast":
+		if !v.addr.Is4() || v.size().Cmp(big.NewInt(2)) <= 0 {
+			return false, nil
+		}
+		addr, err := v.nth(big.NewInt(-1))
+		if err != nil {
+			return nil, err
+		}
+		return addr.String(), nil
+
+	case "first_usable", "last_usable", "range_usable":
+		if !v.hasPrefix || v.size().Cmp(big.NewInt(1)) == 0 {
+			return false, nil
+		}
+		first, last, err := v.usable()
+		if err != nil {
+			return nil, err
+		}
+		switch q {
+		case "first_usable":
+			return first.String(), nil
+		case "last_usable":
+			return last.String(), nil
+		}
+		return first.String() + "-" + last.String(), nil
+
+	case "next_usable", "previous_usable":
+		if !v.hasPrefix || v.size().Cmp(big.NewInt(1)) == 0 {
+			return false, nil
+		}
+		first, last, err := v.usable()
+		if err != nil {
+			return nil, err
+		}
+		if q == "next_usable" {
+			if v.addr.Compare(last) >= 0 {
+				return false, nil
+			}
+			return v.addr.Next().String(), nil
+		}
+		if v.addr.Compare(first) <= 0 {
+			return false, nil
+		}
+		return v.addr.Prev().String(), nil
+
+	case "ipv4", "ipv6", "4", "6":
+		if strings.TrimPrefix(q, "ipv") != strconv.Itoa(ipVersion(v.addr)) {
+			return false, nil
+		}
+		return ipaddrQuery(input, nil)
+
+	case "bool":
+		return true, nil
+
+	case "version":
+		return ipVersion(v.addr), nil
+
+	case "type":
+		if v.isNetwork() {
+			return "network", nil
+		}
+		return "address", nil
+
+	case "private", "public":
+		private := v.addr.IsPrivate() || v.addr.IsLoopback() || v.addr.IsLinkLocalUnicast() || v.addr.IsUnspecified()
+		if private != (q == "private") {
+			return false, nil
+		}
+		return ipaddrQuery(input, nil)
+
+	case "loopback":
+		if !v.addr.IsLoopback() {
+			return false, nil
+		}
+		return ipaddrQuery(input, nil)
+
+	case "multicast":
+		if !v.addr.IsMulticast() {
+			return false, nil
+		}
+		return ipaddrQuery(input, nil)
+
+	case "wrap":
+		if v.addr.Is6() && !v.hasPrefix {
+			return "[" + v.addr.String() + "]", nil
+		}
+		return ipaddrQuery(input, nil)
+
+	case "revdns":
+		return reverseDNS(v.addr), nil
+
+	case "int":
+		return bigResult(addrInt(v.addr)), nil
+	}
+
+	return nil, fmt.Errorf("unknown ipaddr query: %s", q)
+}
+
+// reverseDNS returns the PTR record name of an address
+func reverseDNS(addr netip.Addr) string {
+	bytes := addr.AsSlice()
+	var labels []string
+	if addr.Is4() {
+		for i := len(bytes) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(bytes[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa."
+	}
+	for i := len(bytes) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(bytes[i]&0x0f), 16), strconv.FormatUint(uint64(bytes[i]>>4), 16))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa."
+}
+
+// NthHostFilter returns the nth address of a network, e.g.
+// '10.0.0.0/8' | nthhost(305) gives 10.0.1.49
+type NthHostFilter struct{}
+
+func (f *NthHostFilter) Name() string { return "nthhost" }
+func (f *NthHostFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("nthhost filter requires an index")
+	}
+	n, ok := bigArg(args[0])
+	if !ok {
+		return nil, fmt.Errorf("nthhost index must be an integer, got %v", args[0])
+	}
+
+	v, err := parseIPValue(input)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := v.nth(n)
+	if err != nil {
+		return nil, err
+	}
+	return addr.String(), nil
+}
+
+// IPSubnetFilter splits and widens networks. With a prefix length it
+// returns the number of subnets of that size, or the containing network
+// when the length is shorter than the input's; with an index as well it
+// returns that subnet, counting from the end when negative.
+type IPSubnetFilter struct{}
+
+func (f *IPSubnetFilter) Name() string { return "ipsubnet" }
+func (f *IPSubnetFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	v, err := parseIPValue(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] == nil {
+		return v.prefix.Masked().String(), nil
+	}
+
+	length, ok := bigArg(args[0])
+	if !ok || !length.IsInt64() || length.Int64() < 0 || length.Int64() > int64(v.addr.BitLen()) {
+		return nil, fmt.Errorf("ipsubnet prefix length must be between 0 and %d, got %v", v.addr.BitLen(), args[0])
+	}
+	bits := int(length.Int64())
+
+	// A shorter prefix returns the network containing the input
+	if bits <= v.prefix.Bits() {
+		supernet, err := v.addr.Prefix(bits)
+		if err != nil {
+			return nil, err
+		}
+		return supernet.String(), nil
+	}
+
+	count := new(big.Int).Lsh(big.NewInt(1), uint(bits-v.prefix.Bits()))
+	if len(args) < 2 || args[1] == nil {
+		return bigResult(count), nil
+	}
+
+	index, ok := bigArg(args[1])
+	if !ok {
+		return nil, fmt.Errorf("ipsubnet index must be an integer, got %v", args[1])
+	}
+	if index.Sign() < 0 {
+		index = new(big.Int).Add(index, count)
+	}
+	if index.Sign() < 0 || index.Cmp(count) >= 0 {
+		return nil, fmt.Errorf("ipsubnet index %v is out of range for %d subnets of /%d", args[1], count, bits)
+	}
+
+	offset := new(big.Int).Lsh(index, uint(v.addr.BitLen()-bits))
+	start, err := addAddr(v.network(), offset)
+	if err != nil {
+		return nil, err
+	}
+	return netip.PrefixFrom(start, bits).String(), nil
+}
+
+// IPMathFilter adds to or subtracts from an address, e.g.
+// '192.0.2.1' | ipmath(5) gives 192.0.2.6
+type IPMathFilter struct{}
+
+func (f *IPMathFilter) Name() string { return "ipmath" }
+func (f *IPMathFilter) Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ipmath filter requires an amount")
+	}
+	n, ok := bigArg(args[0])
+	if !ok {
+		return nil, fmt.Errorf("ipmath amount must be an integer, got %v", args[0])
+	}
+
+	v, err := parseIPValue(input)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := addAddr(v.addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return addr.String(), nil
+}
@@ -0,0 +1,110 @@
+package inventory
+
+import (
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// hostEntry is the compact form hosts are stored in. Large inventories hold
+// tens of thousands of them, so fields that usually repeat the defaults are
+// left empty and the types.Host handed to callers is materialized on demand.
+type hostEntry struct {
+	address   string // Empty when the address is the host name
+	user      string
+	password  string
+	port      int
+	variables map[string]interface{} // Nil when the host has no variables of its own
+	groups    []string               // Interned, shared with other hosts in the same groups
+}
+
+// host materializes the entry as a types.Host. Variables and Groups are
+// shared with the inventory and must not be modified in place.
+func (e hostEntry) host(name string) types.Host {
+	host := types.Host{
+		Name:      name,
+		Address:   e.hostAddress(name),
+		Port:      e.port,
+		User:      e.user,
+		Password:  e.password,
+		Variables: e.variables,
+		Groups:    e.groups,
+	}
+	if host.Variables == nil {
+		host.Variables = make(map[string]interface{})
+	}
+	if host.Groups == nil {
+		host.Groups = make([]string, 0)
+	}
+	return host
+}
+
+// hostAddress returns the address of the host called name
+func (e hostEntry) hostAddress(name string) string {
+	if e.address == "" {
+		return name
+	}
+	return e.address
+}
+
+// internPool deduplicates the names, variable keys and group lists that
+// repeat across hosts, so each is stored once however many hosts use it
+type internPool struct {
+	strings map[string]string
+	groups  map[string][]string
+}
+
+func newInternPool() *internPool {
+	return &internPool{
+		strings: make(map[string]string),
+		groups:  make(map[string][]string),
+	}
+}
+
+// string returns the pooled copy of s
+func (p *internPool) string(s string) string {
+	if pooled, ok := p.strings[s]; ok {
+		return pooled
+	}
+	p.strings[s] = s
+	return s
+}
+
+// stringList interns every element of values in place
+func (p *internPool) stringList(values []string) []string {
+	for i, value := range values {
+		values[i] = p.string(value)
+	}
+	return values
+}
+
+// groupList returns the pooled copy of a host's group list. The result is
+// shared and must be copied before it is changed.
+func (p *internPool) groupList(groups []string) []string {
+	if len(groups) == 0 {
+		return nil
+	}
+	key := strings.Join(groups, "\x00")
+	if pooled, ok := p.groups[key]; ok {
+		return pooled
+	}
+	pooled := make([]string, len(groups))
+	for i, group := range groups {
+		pooled[i] = p.string(group)
+	}
+	p.groups[key] = pooled
+	return pooled
+}
+
+// variables interns the keys of a variable map, returning nil for an empty
+// map so hosts without variables of their own cost nothing
+func (p *internPool) variables(vars map[string]interface{}) map[string]interface{} {
+	if len(vars) == 0 {
+		return nil
+	}
+	interned := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		interned[p.string(k)] = v
+	}
+	return interned
+}
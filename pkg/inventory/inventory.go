@@ -2,11 +2,15 @@
 package inventory
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"iter"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 
@@ -18,8 +22,16 @@ import (
 // StaticInventory implements the Inventory interface with static host and group data
 type StaticInventory struct {
 	mu     sync.RWMutex
-	hosts  map[string]types.Host
+	hosts  map[string]hostEntry
 	groups map[string]types.Group
+	pool   *internPool
+
+	// members indexes the hosts of groups hosts are being added to, so
+	// adding thousands of hosts to one group does not rescan its host list
+	members map[string]map[string]struct{}
+
+	// Vars plugins declared by the inventory file, merged by LoadVarsPlugins
+	varsPlugins []VarsPluginConfig
 }
 
 // InventoryData represents the structure of inventory YAML files
@@ -29,25 +41,38 @@ type InventoryData struct {
 		Children map[string]types.Group            `yaml:"children,omitempty"`
 		Vars     map[string]interface{}            `yaml:"vars,omitempty"`
 	} `yaml:"all"`
+
+	// VarsPlugins enrich the hosts with variables from other sources
+	VarsPlugins []VarsPluginConfig `yaml:"vars_plugins,omitempty"`
 }
 
 // NewStaticInventory creates a new static inventory
 func NewStaticInventory() *StaticInventory {
 	return &StaticInventory{
-		hosts:  make(map[string]types.Host),
-		groups: make(map[string]types.Group),
+		hosts:   make(map[string]hostEntry),
+		groups:  make(map[string]types.Group),
+		pool:    newInternPool(),
+		members: make(map[string]map[string]struct{}),
 	}
 }
 
-// NewFromFile creates an inventory from a YAML file
-func NewFromFile(filepath string) (*StaticInventory, error) {
-	file, err := os.Open(filepath)
+// NewFromFile creates an inventory from a YAML file, loading the vars
+// plugins it declares relative to the file's directory
+func NewFromFile(path string) (*StaticInventory, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, types.NewInventoryError(filepath, "failed to open file", err)
+		return nil, types.NewInventoryError(path, "failed to open file", err)
 	}
 	defer file.Close()
 
-	return NewFromReader(file)
+	inv, err := NewFromReader(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.LoadVarsPlugins(context.Background(), filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return inv, nil
 }
 
 // NewFromReader creates an inventory from an io.Reader containing YAML data
@@ -78,6 +103,7 @@ func NewFromYAML(data []byte) (*StaticInventory, error) {
 	}
 
 	inv := NewStaticInventory()
+	inv.varsPlugins = inventoryData.VarsPlugins
 
 	// Add hosts
 	for name, hostVars := range inventoryData.All.Hosts {
@@ -91,49 +117,7 @@ func NewFromYAML(data []byte) (*StaticInventory, error) {
 			host.Variables = make(map[string]interface{})
 		}
 
-		// Map ansible_host or address to Address
-		if ansibleHost, ok := host.Variables["ansible_host"].(string); ok {
-			host.Address = ansibleHost
-		} else if address, ok := host.Variables["address"].(string); ok {
-			host.Address = address
-		}
-
-		// Map ansible_user or user to User
-		if ansibleUser, ok := host.Variables["ansible_user"].(string); ok {
-			host.User = ansibleUser
-		} else if user, ok := host.Variables["user"].(string); ok {
-			host.User = user
-		}
-
-		// Map ansible_password or password to Password
-		if ansiblePassword, ok := host.Variables["ansible_password"].(string); ok {
-			host.Password = ansiblePassword
-		} else if password, ok := host.Variables["password"].(string); ok {
-			host.Password = password
-		}
-
-		// Map ansible_port or port to Port
-		if ansiblePort, ok := host.Variables["ansible_port"]; ok {
-			switch v := ansiblePort.(type) {
-			case int:
-				host.Port = v
-			case float64:
-				host.Port = int(v)
-			case string:
-				// Try to parse string port
-				fmt.Sscanf(v, "%d", &host.Port)
-			}
-		} else if port, ok := host.Variables["port"]; ok {
-			switch v := port.(type) {
-			case int:
-				host.Port = v
-			case float64:
-				host.Port = int(v)
-			case string:
-				// Try to parse string port
-				fmt.Sscanf(v, "%d", &host.Port)
-			}
-		}
+		applyConnectionVars(&host)
 
 		if err := inv.AddHost(host); err != nil {
 			return nil, err
@@ -174,104 +158,155 @@ func NewFromYAML(data []byte) (*StaticInventory, error) {
 				}
 			}
 			inv.groups["all"] = existingGroup
+			delete(inv.members, "all")
 		}
 		inv.mu.Unlock()
 	}
 
 	// Add "all" group to each host's group list
+	inv.mu.Lock()
 	for name := range inventoryData.All.Hosts {
-		inv.mu.Lock()
 		if host, exists := inv.hosts[name]; exists {
-			if !contains(host.Groups, "all") {
-				host.Groups = append(host.Groups, "all")
+			if !contains(host.groups, "all") {
+				host.groups = inv.pool.groupList(append(slices.Clone(host.groups), "all"))
 				inv.hosts[name] = host
 			}
 		}
-		inv.mu.Unlock()
 	}
+	inv.mu.Unlock()
 
 	return inv, nil
 }
 
-// GetHosts returns all hosts matching the pattern
+// applyConnectionVars maps the Ansible connection variables a host sets,
+// or their short forms, to the host's connection fields
+func applyConnectionVars(host *types.Host) {
+	// Map ansible_host or address to Address
+	if ansibleHost, ok := host.Variables["ansible_host"].(string); ok {
+		host.Address = ansibleHost
+	} else if address, ok := host.Variables["address"].(string); ok {
+		host.Address = address
+	}
+
+	// Map ansible_user or user to User
+	if ansibleUser, ok := host.Variables["ansible_user"].(string); ok {
+		host.User = ansibleUser
+	} else if user, ok := host.Variables["user"].(string); ok {
+		host.User = user
+	}
+
+	// Map ansible_password or password to Password
+	if ansiblePassword, ok := host.Variables["ansible_password"].(string); ok {
+		host.Password = ansiblePassword
+	} else if password, ok := host.Variables["password"].(string); ok {
+		host.Password = password
+	}
+
+	// Map ansible_port or port to Port
+	if ansiblePort, ok := host.Variables["ansible_port"]; ok {
+		switch v := ansiblePort.(type) {
+		case int:
+			host.Port = v
+		case float64:
+			host.Port = int(v)
+		case string:
+			// Try to parse string port
+			fmt.Sscanf(v, "%d", &host.Port)
+		}
+	} else if port, ok := host.Variables["port"]; ok {
+		switch v := port.(type) {
+		case int:
+			host.Port = v
+		case float64:
+			host.Port = int(v)
+		case string:
+			// Try to parse string port
+			fmt.Sscanf(v, "%d", &host.Port)
+		}
+	}
+}
+
+// GetHosts returns all hosts matching the pattern. Patterns follow
+// Ansible: "webservers:&staging" intersects, "all:!db*" excludes, commas
+// and colons join, "~web\\d+" matches a regex and "webservers[0:2]" selects
+// hosts by position. Hosts come in the order of the pattern's terms, and by
+// name within a term.
 func (inv *StaticInventory) GetHosts(pattern string) ([]types.Host, error) {
 	inv.mu.RLock()
 	defer inv.mu.RUnlock()
 
-	var result []types.Host
-
-	// If pattern is empty or "*", return all hosts
-	if pattern == "" || pattern == "*" {
-		for _, host := range inv.hosts {
-			result = append(result, host)
-		}
-		return result, nil
+	names, err := inv.matchHostNames(pattern)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.Host, 0, len(names))
+	for _, name := range names {
+		result = append(result, inv.hosts[name].host(name))
 	}
 
-	// Parse pattern to separate hosts and groups
-	hostPatterns, groupPatterns := types.ParseHostPattern(pattern)
+	return result, nil
+}
 
-	// Collect hosts matching host patterns
-	hostSet := make(map[string]bool)
-	for _, hostPattern := range hostPatterns {
-		for name, host := range inv.hosts {
-			if types.MatchPattern(hostPattern, name) || types.MatchPattern(hostPattern, host.Address) {
-				if !hostSet[name] {
-					result = append(result, host)
-					hostSet[name] = true
-				}
+// Hosts returns an iterator over the hosts matching the pattern, in the
+// order GetHosts returns them. Each host is materialized only when it is reached, so callers can
+// walk very large inventories without holding every host at once.
+func (inv *StaticInventory) Hosts(pattern string) iter.Seq[types.Host] {
+	return func(yield func(types.Host) bool) {
+		for _, name := range inv.HostNames(pattern) {
+			inv.mu.RLock()
+			host, exists := inv.hosts[name]
+			inv.mu.RUnlock()
+
+			// Skip hosts removed since the pattern was matched
+			if exists && !yield(host.host(name)) {
+				return
 			}
 		}
 	}
+}
 
-	// Collect hosts from matching groups
-	for _, groupPattern := range groupPatterns {
-		for groupName, group := range inv.groups {
-			if types.MatchPattern(groupPattern, groupName) {
-				for _, hostname := range group.Hosts {
-					if host, exists := inv.hosts[hostname]; exists && !hostSet[hostname] {
-						result = append(result, host)
-						hostSet[hostname] = true
-					}
-				}
+// HostNames returns the names of the hosts matching the pattern, in the
+// order GetHosts returns them. Invalid patterns match no hosts; GetHosts reports their errors.
+func (inv *StaticInventory) HostNames(pattern string) []string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
 
-				// Also check child groups recursively
-				childHosts, err := inv.getHostsFromChildGroups(group, hostSet)
-				if err != nil {
-					return nil, err
-				}
-				result = append(result, childHosts...)
-			}
+	names, _ := inv.matchHostNames(pattern)
+	return names
+}
+
+// matchHostNames returns the names of the hosts matching the pattern, in
+// the order of its terms and by name within a term. The caller must hold the lock.
+func (inv *StaticInventory) matchHostNames(pattern string) ([]string, error) {
+	// If pattern is empty or "*", return all hosts
+	if pattern == "" || pattern == "*" {
+		result := make([]string, 0, len(inv.hosts))
+		for name := range inv.hosts {
+			result = append(result, name)
 		}
+		sort.Strings(result)
+		return result, nil
 	}
 
-	return result, nil
+	return inv.matchPattern(pattern)
 }
 
-// getHostsFromChildGroups recursively gets hosts from child groups
-func (inv *StaticInventory) getHostsFromChildGroups(group types.Group, hostSet map[string]bool) ([]types.Host, error) {
-	var result []types.Host
-
-	for _, childGroupName := range group.Children {
-		if childGroup, exists := inv.groups[childGroupName]; exists {
-			// Add direct hosts from child group
-			for _, hostname := range childGroup.Hosts {
-				if host, exists := inv.hosts[hostname]; exists && !hostSet[hostname] {
-					result = append(result, host)
-					hostSet[hostname] = true
-				}
-			}
+// collectGroupHosts adds the hosts of a group and its child groups to hostSet
+func (inv *StaticInventory) collectGroupHosts(groupName string, hostSet, visited map[string]bool) {
+	group, exists := inv.groups[groupName]
+	if !exists || visited[groupName] {
+		return
+	}
+	visited[groupName] = true
 
-			// Recursively check grandchild groups
-			grandchildHosts, err := inv.getHostsFromChildGroups(childGroup, hostSet)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, grandchildHosts...)
+	for _, hostname := range group.Hosts {
+		if _, exists := inv.hosts[hostname]; exists {
+			hostSet[hostname] = true
 		}
 	}
-
-	return result, nil
+	for _, child := range group.Children {
+		inv.collectGroupHosts(child, hostSet, visited)
+	}
 }
 
 // GetHost returns a specific host by name
@@ -279,13 +314,15 @@ func (inv *StaticInventory) GetHost(name string) (*types.Host, error) {
 	inv.mu.RLock()
 	defer inv.mu.RUnlock()
 
-	if host, exists := inv.hosts[name]; exists {
+	if entry, exists := inv.hosts[name]; exists {
+		host := entry.host(name)
 		return &host, nil
 	}
 
 	// Also try to match by address
-	for _, host := range inv.hosts {
-		if host.Address == name {
+	for hostname, entry := range inv.hosts {
+		if entry.address == name {
+			host := entry.host(hostname)
 			return &host, nil
 		}
 	}
@@ -328,33 +365,38 @@ func (inv *StaticInventory) AddHost(host types.Host) error {
 	}
 
 	// Set default values
-	if host.Variables == nil {
-		host.Variables = make(map[string]interface{})
-	}
-	if host.Groups == nil {
-		host.Groups = make([]string, 0)
-	}
-	if host.Address == "" {
-		host.Address = host.Name
+	if host.Address == host.Name {
+		host.Address = ""
 	}
 	if host.Port == 0 {
 		host.Port = 22 // Default SSH port
 	}
 
-	inv.hosts[host.Name] = host
+	name := inv.pool.string(host.Name)
+	entry := hostEntry{
+		address:   host.Address,
+		user:      inv.pool.string(host.User),
+		password:  host.Password,
+		port:      host.Port,
+		variables: inv.pool.variables(host.Variables),
+		groups:    inv.pool.groupList(host.Groups),
+	}
+	inv.hosts[name] = entry
 
 	// Add host to specified groups
-	for _, groupName := range host.Groups {
+	for _, groupName := range entry.groups {
 		if group, exists := inv.groups[groupName]; exists {
-			if !types.StringSliceContains(group.Hosts, host.Name) {
-				group.Hosts = append(group.Hosts, host.Name)
+			members := inv.groupMembers(groupName, group)
+			if _, exists := members[name]; !exists {
+				group.Hosts = append(group.Hosts, name)
 				inv.groups[groupName] = group
+				members[name] = struct{}{}
 			}
 		} else {
 			// Create group if it doesn't exist
 			newGroup := types.Group{
 				Name:      groupName,
-				Hosts:     []string{host.Name},
+				Hosts:     []string{name},
 				Variables: make(map[string]interface{}),
 			}
 			inv.groups[groupName] = newGroup
@@ -364,6 +406,20 @@ func (inv *StaticInventory) AddHost(host types.Host) error {
 	return nil
 }
 
+// groupMembers returns the index of a group's hosts, building it on first
+// use. The caller must hold the write lock.
+func (inv *StaticInventory) groupMembers(name string, group types.Group) map[string]struct{} {
+	members, exists := inv.members[name]
+	if !exists {
+		members = make(map[string]struct{}, len(group.Hosts))
+		for _, host := range group.Hosts {
+			members[host] = struct{}{}
+		}
+		inv.members[name] = members
+	}
+	return members
+}
+
 // AddGroup adds a group to the inventory
 func (inv *StaticInventory) AddGroup(group types.Group) error {
 	inv.mu.Lock()
@@ -384,7 +440,11 @@ func (inv *StaticInventory) AddGroup(group types.Group) error {
 		group.Children = make([]string, 0)
 	}
 
+	group.Name = inv.pool.string(group.Name)
+	group.Hosts = inv.pool.stringList(group.Hosts)
+	group.Children = inv.pool.stringList(group.Children)
 	inv.groups[group.Name] = group
+	delete(inv.members, group.Name)
 	return nil
 }
 
@@ -400,22 +460,22 @@ func (inv *StaticInventory) GetHostVars(hostname string) (map[string]interface{}
 
 	// Start with group variables (lower precedence)
 	result := make(map[string]interface{})
-	for _, groupName := range host.Groups {
+	for _, groupName := range host.groups {
 		if group, exists := inv.groups[groupName]; exists {
 			result = types.DeepMergeInterfaceMaps(result, group.Variables)
 		}
 	}
 
 	// Merge host variables (higher precedence)
-	result = types.DeepMergeInterfaceMaps(result, host.Variables)
+	result = types.DeepMergeInterfaceMaps(result, host.variables)
 
 	// Add built-in variables
-	result["inventory_hostname"] = host.Name
-	result["inventory_hostname_short"] = strings.Split(host.Name, ".")[0]
-	result["ansible_host"] = host.Address
-	result["ansible_port"] = host.Port
-	if host.User != "" {
-		result["ansible_user"] = host.User
+	result["inventory_hostname"] = hostname
+	result["inventory_hostname_short"] = strings.Split(hostname, ".")[0]
+	result["ansible_host"] = host.hostAddress(hostname)
+	result["ansible_port"] = host.port
+	if host.user != "" {
+		result["ansible_user"] = host.user
 	}
 
 	return result, nil
@@ -451,7 +511,7 @@ func (inv *StaticInventory) RemoveHost(hostname string) error {
 	}
 
 	// Remove host from all groups
-	for _, groupName := range host.Groups {
+	for _, groupName := range host.groups {
 		if group, exists := inv.groups[groupName]; exists {
 			newHosts := make([]string, 0, len(group.Hosts))
 			for _, h := range group.Hosts {
@@ -461,6 +521,7 @@ func (inv *StaticInventory) RemoveHost(hostname string) error {
 			}
 			group.Hosts = newHosts
 			inv.groups[groupName] = group
+			delete(inv.members[groupName], hostname)
 		}
 	}
 
@@ -479,13 +540,16 @@ func (inv *StaticInventory) RemoveGroup(groupname string) error {
 
 	// Remove group from all hosts
 	for hostname, host := range inv.hosts {
-		newGroups := make([]string, 0, len(host.Groups))
-		for _, g := range host.Groups {
+		if !contains(host.groups, groupname) {
+			continue
+		}
+		newGroups := make([]string, 0, len(host.groups))
+		for _, g := range host.groups {
 			if g != groupname {
 				newGroups = append(newGroups, g)
 			}
 		}
-		host.Groups = newGroups
+		host.groups = inv.pool.groupList(newGroups)
 		inv.hosts[hostname] = host
 	}
 
@@ -505,6 +569,7 @@ func (inv *StaticInventory) RemoveGroup(groupname string) error {
 	}
 
 	delete(inv.groups, groupname)
+	delete(inv.members, groupname)
 	return nil
 }
 
@@ -522,21 +587,21 @@ func (inv *StaticInventory) ToYAML() ([]byte, error) {
 		hostVars := make(map[string]interface{})
 
 		// Add ansible variables
-		if host.Address != "" && host.Address != name {
-			hostVars["ansible_host"] = host.Address
+		if host.address != "" && host.address != name {
+			hostVars["ansible_host"] = host.address
 		}
-		if host.User != "" {
-			hostVars["ansible_user"] = host.User
+		if host.user != "" {
+			hostVars["ansible_user"] = host.user
 		}
-		if host.Password != "" {
-			hostVars["ansible_password"] = host.Password
+		if host.password != "" {
+			hostVars["ansible_password"] = host.password
 		}
-		if host.Port != 0 && host.Port != 22 {
-			hostVars["ansible_port"] = host.Port
+		if host.port != 0 && host.port != 22 {
+			hostVars["ansible_port"] = host.port
 		}
 
 		// Add other variables
-		for k, v := range host.Variables {
+		for k, v := range host.variables {
 			hostVars[k] = v
 		}
 
@@ -2,8 +2,12 @@ package inventory
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/liliang-cn/gosible/pkg/types"
 )
@@ -61,8 +65,8 @@ all:
 	if !exists {
 		t.Error("web1 host not found")
 	}
-	if web1.Address != "192.168.1.10" {
-		t.Errorf("web1 address expected 192.168.1.10, got %s", web1.Address)
+	if web1.address != "192.168.1.10" {
+		t.Errorf("web1 address expected 192.168.1.10, got %s", web1.address)
 	}
 
 	// Check groups (all, webservers, databases)
@@ -103,8 +107,8 @@ func TestAddHost(t *testing.T) {
 	if !exists {
 		t.Error("host was not stored")
 	}
-	if storedHost.Address != "192.168.1.100" {
-		t.Errorf("host address expected 192.168.1.100, got %s", storedHost.Address)
+	if storedHost.address != "192.168.1.100" {
+		t.Errorf("host address expected 192.168.1.100, got %s", storedHost.address)
 	}
 
 	// Check group was created
@@ -474,4 +478,194 @@ func BenchmarkGetHostVars(b *testing.B) {
 			b.Fatal(err)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestHostsIterator(t *testing.T) {
+	inv := NewStaticInventory()
+	for _, host := range []types.Host{
+		{Name: "web2", Groups: []string{"webservers"}},
+		{Name: "web1", Groups: []string{"webservers"}},
+		{Name: "db1", Groups: []string{"databases"}},
+	} {
+		inv.AddHost(host)
+	}
+	inv.AddGroup(types.Group{Name: "prod", Children: []string{"webservers", "databases"}})
+
+	var names []string
+	for host := range inv.Hosts("prod") {
+		if host.Address != host.Name || host.Port != 22 || host.Variables == nil {
+			t.Errorf("expected host %s to be materialized with defaults, got %+v", host.Name, host)
+		}
+		names = append(names, host.Name)
+	}
+	if strings.Join(names, ",") != "db1,web1,web2" {
+		t.Errorf("expected hosts in name order, got %v", names)
+	}
+
+	// Stopping early ends the iteration
+	count := 0
+	for range inv.Hosts("*") {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("expected iteration to stop after one host, got %d", count)
+	}
+
+	if names := inv.HostNames("web*"); strings.Join(names, ",") != "web1,web2" {
+		t.Errorf("expected web hosts, got %v", names)
+	}
+}
+
+func TestGetHostsPatterns(t *testing.T) {
+	inv := NewStaticInventory()
+	for _, host := range []types.Host{
+		{Name: "web1", Groups: []string{"webservers", "staging"}},
+		{Name: "web2", Groups: []string{"webservers", "staging"}},
+		{Name: "web3", Groups: []string{"webservers", "production"}},
+		{Name: "db1", Groups: []string{"databases", "staging"}},
+		{Name: "db2", Groups: []string{"databases", "production"}},
+	} {
+		inv.AddHost(host)
+	}
+
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"webservers:&staging", "web1,web2"},
+		{"all:!db*", "web1,web2,web3"},
+		{"webservers,databases:!production", "web1,web2,db1"},
+		{"!webservers", "db1,db2"},
+		{"webservers:databases:&production", "web3,db2"},
+		{"web1;db2", "web1,db2"},
+		{"~web[13]", "web1,web3"},
+		{"~(web|db)1", "db1,web1"},
+		{"~stag.*:&databases", ""},
+		{"~stag.*,&databases", "db1"},
+		{"webservers[0]", "web1"},
+		{"webservers[-1]", "web3"},
+		{"webservers[0:1]", "web1,web2"},
+		{"webservers[1:]", "web2,web3"},
+		{"webservers[:0]:db1", "web1,db1"},
+		{"webservers[0:1]:&webservers[1:2]", "web2"},
+		{"webservers[5]", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			hosts, err := inv.GetHosts(tt.pattern)
+			if err != nil {
+				t.Fatalf("GetHosts failed: %v", err)
+			}
+			names := make([]string, len(hosts))
+			for i, host := range hosts {
+				names[i] = host.Name
+			}
+			if got := strings.Join(names, ","); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"~web[", "webservers[]", "&"} {
+		if _, err := inv.GetHosts(pattern); err == nil {
+			t.Errorf("expected an error for pattern %q", pattern)
+		}
+	}
+}
+
+func TestExpandLimitFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.retry")
+	if err := os.WriteFile(path, []byte("web2\n\n# failed\ndb1\n"), 0644); err != nil {
+		t.Fatalf("failed to write limit file: %v", err)
+	}
+
+	limit, err := ExpandLimitFiles("web1,@" + path)
+	if err != nil {
+		t.Fatalf("ExpandLimitFiles failed: %v", err)
+	}
+	if limit != "web1,web2,db1" {
+		t.Errorf("expected the hosts of the file in the limit, got %q", limit)
+	}
+
+	if limit, err := ExpandLimitFiles("webservers:&staging"); err != nil || limit != "webservers:&staging" {
+		t.Errorf("expected a limit without files to be unchanged, got %q, %v", limit, err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.retry")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("failed to write limit file: %v", err)
+	}
+	for _, limit := range []string{"@" + empty, "@" + filepath.Join(t.TempDir(), "missing")} {
+		if _, err := ExpandLimitFiles(limit); err == nil {
+			t.Errorf("expected an error for limit %s", limit)
+		}
+	}
+}
+
+func TestInternedStorage(t *testing.T) {
+	inv := NewStaticInventory()
+	for i := 0; i < 3; i++ {
+		inv.AddHost(types.Host{
+			Name:      fmt.Sprintf("host%d", i),
+			Groups:    []string{"web", "prod"},
+			Variables: map[string]interface{}{fmt.Sprint("ansible_", "connection"): "ssh"},
+		})
+	}
+	inv.AddHost(types.Host{Name: "bare"})
+
+	first, second := inv.hosts["host0"], inv.hosts["host1"]
+	if &first.groups[0] != &second.groups[0] {
+		t.Error("expected hosts in the same groups to share one group list")
+	}
+	var firstKey, secondKey string
+	for k := range first.variables {
+		firstKey = k
+	}
+	for k := range second.variables {
+		secondKey = k
+	}
+	if unsafe.StringData(firstKey) != unsafe.StringData(secondKey) {
+		t.Error("expected variable names to be interned")
+	}
+	if bare := inv.hosts["bare"]; bare.variables != nil || bare.address != "" {
+		t.Errorf("expected defaults to be stored compactly, got %+v", bare)
+	}
+
+	// Removing a group copies the shared list instead of changing it
+	inv.RemoveGroup("prod")
+	host, _ := inv.GetHost("host2")
+	if len(host.Groups) != 1 || host.Groups[0] != "web" {
+		t.Errorf("expected prod to be removed, got %v", host.Groups)
+	}
+	if groups := inv.pool.groupList([]string{"web", "prod"}); len(groups) != 2 {
+		t.Errorf("expected the pooled list to be unchanged, got %v", groups)
+	}
+}
+
+// BenchmarkLargeInventory reports the memory a 50k host inventory holds
+func BenchmarkLargeInventory(b *testing.B) {
+	const size = 50000
+	var before, after runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		inv := NewStaticInventory()
+		for j := 0; j < size; j++ {
+			inv.AddHost(types.Host{
+				Name:      fmt.Sprintf("host%05d.example.com", j),
+				User:      "deploy",
+				Groups:    []string{"web", fmt.Sprintf("rack%d", j%40)},
+				Variables: map[string]interface{}{"ansible_connection": "ssh", "rack": j % 40},
+			})
+		}
+		for range inv.Hosts("web") {
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/size, "B/host")
+		runtime.KeepAlive(inv)
+	}
+}
@@ -0,0 +1,276 @@
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// patternTerm is one element of an Ansible host pattern such as
+// "webservers:&staging:!db*", "~web\d+" or "webservers[0:2]"
+type patternTerm struct {
+	op    byte           // 0 for unions, '&' for intersections, '!' for exclusions
+	name  string         // Host or group name, possibly with wildcards
+	regex *regexp.Regexp // Set for patterns starting with ~
+	slice *hostSlice     // Set for patterns ending with [i] or [a:b]
+}
+
+// hostSlice selects hosts of a term by their position. Ranges include
+// their end, as in Ansible, and negative positions count from the end.
+type hostSlice struct {
+	start, end       int
+	hasStart, hasEnd bool
+}
+
+var sliceRegex = regexp.MustCompile(`^(.+)\[(-?\d*)(:(-?\d*))?\]$`)
+
+// parsePattern splits a host pattern into its terms. Terms are separated by
+// commas, semicolons or colons; colons inside slices, regular expressions
+// and IPv6 addresses do not separate terms.
+func parsePattern(pattern string) ([]patternTerm, error) {
+	var terms []patternTerm
+	for _, part := range regexp.MustCompile(`[,;]`).Split(pattern, -1) {
+		for _, element := range splitColons(strings.TrimSpace(part)) {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			term, err := parseTerm(element)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
+
+// ExpandLimitFiles replaces the "@file" terms of a limit, such as the
+// "@site.retry" a failed run leaves behind, with the hosts the file lists
+// one per line. Blank lines and lines starting with # are ignored.
+func ExpandLimitFiles(limit string) (string, error) {
+	terms := regexp.MustCompile(`[,;]`).Split(limit, -1)
+	for i, term := range terms {
+		term = strings.TrimSpace(term)
+		if !strings.HasPrefix(term, "@") {
+			continue
+		}
+
+		data, err := os.ReadFile(term[1:])
+		if err != nil {
+			return "", fmt.Errorf("failed to read limit file: %w", err)
+		}
+		var hosts []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				hosts = append(hosts, line)
+			}
+		}
+		if len(hosts) == 0 {
+			return "", fmt.Errorf("limit file %s lists no hosts", term[1:])
+		}
+		terms[i] = strings.Join(hosts, ",")
+	}
+	return strings.Join(terms, ","), nil
+}
+
+// splitColons splits a pattern element on the colons outside brackets
+func splitColons(element string) []string {
+	if strings.HasPrefix(element, "~") || net.ParseIP(element) != nil {
+		return []string{element}
+	}
+
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range element {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, element[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, element[start:])
+}
+
+// parseTerm parses one term, with its operator, regex and slice
+func parseTerm(element string) (patternTerm, error) {
+	var term patternTerm
+	if element[0] == '&' || element[0] == '!' {
+		term.op = element[0]
+		element = strings.TrimSpace(element[1:])
+	}
+
+	if strings.HasPrefix(element, "~") {
+		regex, err := regexp.Compile(element[1:])
+		if err != nil {
+			return term, fmt.Errorf("invalid host pattern regex %q: %w", element[1:], err)
+		}
+		term.regex = regex
+		return term, nil
+	}
+
+	if matches := sliceRegex.FindStringSubmatch(element); matches != nil {
+		slice, err := parseSlice(matches[2], matches[3] != "", matches[4])
+		if err != nil {
+			return term, fmt.Errorf("invalid host pattern %q: %w", element, err)
+		}
+		element = matches[1]
+		term.slice = slice
+	}
+
+	if element == "" {
+		return term, fmt.Errorf("empty host pattern term")
+	}
+	term.name = element
+	return term, nil
+}
+
+// parseSlice parses the [i] or [a:b] subscript of a term
+func parseSlice(startStr string, isRange bool, endStr string) (*hostSlice, error) {
+	slice := &hostSlice{}
+	if startStr != "" {
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q", startStr)
+		}
+		slice.start, slice.hasStart = start, true
+	}
+
+	if !isRange {
+		if !slice.hasStart {
+			return nil, fmt.Errorf("missing index")
+		}
+		slice.end, slice.hasEnd = slice.start, true
+		return slice, nil
+	}
+
+	if endStr != "" {
+		end, err := strconv.Atoi(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q", endStr)
+		}
+		slice.end, slice.hasEnd = end, true
+	}
+	return slice, nil
+}
+
+// apply returns the names at the positions the slice selects
+func (s *hostSlice) apply(names []string) []string {
+	position := func(i int) int {
+		if i < 0 {
+			i += len(names)
+		}
+		return i
+	}
+
+	start, end := 0, len(names)-1
+	if s.hasStart {
+		start = max(position(s.start), 0)
+	}
+	if s.hasEnd {
+		end = min(position(s.end), len(names)-1)
+	}
+	if start > end {
+		return nil
+	}
+	return names[start : end+1]
+}
+
+// matchPattern returns the names of the hosts matching the pattern, in the
+// order of its terms and by name within a term. Union terms are combined
+// first, defaulting to every host when the pattern only has intersections
+// and exclusions, then intersections and exclusions are applied in turn.
+// The caller must hold the lock.
+func (inv *StaticInventory) matchPattern(pattern string) ([]string, error) {
+	terms, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	hostSet := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			if !hostSet[name] {
+				hostSet[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	for _, term := range terms {
+		if term.op == 0 {
+			add(inv.matchTerm(term))
+		}
+	}
+	if !slices.ContainsFunc(terms, func(term patternTerm) bool { return term.op == 0 }) {
+		add(inv.matchTerm(patternTerm{name: "all"}))
+	}
+
+	for _, op := range []byte{'&', '!'} {
+		for _, term := range terms {
+			if term.op != op {
+				continue
+			}
+			matched := make(map[string]bool)
+			for _, name := range inv.matchTerm(term) {
+				matched[name] = true
+			}
+			for name := range hostSet {
+				if matched[name] == (op == '!') {
+					delete(hostSet, name)
+				}
+			}
+		}
+	}
+
+	return slices.DeleteFunc(result, func(name string) bool { return !hostSet[name] }), nil
+}
+
+// matchTerm returns the sorted names of the hosts a single term matches,
+// by host name or address, or through the groups it matches
+func (inv *StaticInventory) matchTerm(term patternTerm) []string {
+	matches := func(text string) bool {
+		if term.regex != nil {
+			return term.regex.MatchString(text)
+		}
+		return types.MatchPattern(term.name, text)
+	}
+
+	hostSet := make(map[string]bool)
+	for name, host := range inv.hosts {
+		if term.name == "all" || matches(name) || matches(host.hostAddress(name)) {
+			hostSet[name] = true
+		}
+	}
+
+	visited := make(map[string]bool)
+	for groupName := range inv.groups {
+		if matches(groupName) {
+			inv.collectGroupHosts(groupName, hostSet, visited)
+		}
+	}
+
+	names := make([]string, 0, len(hostSet))
+	for name := range hostSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if term.slice != nil {
+		names = term.slice.apply(names)
+	}
+	return names
+}
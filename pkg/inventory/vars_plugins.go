@@ -0,0 +1,255 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Vars plugin types
+const (
+	VarsPluginCSV  = "csv"
+	VarsPluginXLSX = "xlsx"
+	VarsPluginSQL  = "sql"
+)
+
+// Precedence of the variables a vars plugin supplies over those the
+// inventory already sets for a host
+const (
+	VarsPrecedenceOverride = "override" // The plugin's values win
+	VarsPrecedenceDefault  = "default"  // The plugin only fills in missing variables
+)
+
+// defaultVarsKey is the column holding host names when a plugin names none
+const defaultVarsKey = "host"
+
+// VarsPluginConfig declares a source of host variables in an inventory file:
+//
+//	vars_plugins:
+//	  - type: csv
+//	    path: assets.csv
+//	    key: hostname
+//	    precedence: default
+//	  - type: sql
+//	    driver: postgres
+//	    dsn_env: ASSETS_DSN
+//	    query: SELECT hostname AS host, rack, owner FROM servers
+//
+// Every row becomes the variables of the host named in its key column, one
+// variable per other column. Hosts missing from the inventory are ignored.
+type VarsPluginConfig struct {
+	Type string `yaml:"type" json:"type"`
+
+	// CSV and XLSX: the file, relative to the inventory file, and for XLSX
+	// the sheet to read (the first one when empty). The first row holds the
+	// column names.
+	Path  string `yaml:"path,omitempty" json:"path,omitempty"`
+	Sheet string `yaml:"sheet,omitempty" json:"sheet,omitempty"`
+
+	// SQL: a database/sql driver registered by the binary, the data source
+	// name or the environment variable holding it, and the query. The
+	// gosible CLI registers no driver itself; build it with one by adding
+	// a file to cmd/gosible that blank imports the driver, such as
+	// import _ "github.com/lib/pq" for postgres.
+	Driver string `yaml:"driver,omitempty" json:"driver,omitempty"`
+	DSN    string `yaml:"dsn,omitempty" json:"dsn,omitempty"`
+	DSNEnv string `yaml:"dsn_env,omitempty" json:"dsn_env,omitempty"`
+	Query  string `yaml:"query,omitempty" json:"query,omitempty"`
+
+	// Key names the column holding host names, "host" by default. Columns
+	// limits the variables to the listed columns.
+	Key     string   `yaml:"key,omitempty" json:"key,omitempty"`
+	Columns []string `yaml:"columns,omitempty" json:"columns,omitempty"`
+
+	// Precedence is "override" (the default) or "default"
+	Precedence string `yaml:"precedence,omitempty" json:"precedence,omitempty"`
+}
+
+// VarsSource supplies variables for inventory hosts, keyed by host name
+type VarsSource interface {
+	// Name describes the source in errors
+	Name() string
+	// HostVars reads the variables of every host the source knows
+	HostVars(ctx context.Context) (map[string]map[string]interface{}, error)
+}
+
+// NewVarsSource creates the source a vars plugin declaration describes.
+// Relative paths are resolved against baseDir.
+func NewVarsSource(cfg VarsPluginConfig, baseDir string) (VarsSource, error) {
+	key := cfg.Key
+	if key == "" {
+		key = defaultVarsKey
+	}
+	path := cfg.Path
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+
+	switch cfg.Type {
+	case VarsPluginCSV, VarsPluginXLSX:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("%s vars plugin requires a path", cfg.Type)
+		}
+		return &tableVarsSource{kind: cfg.Type, path: path, sheet: cfg.Sheet, key: key, columns: cfg.Columns}, nil
+	case VarsPluginSQL:
+		dsn := cfg.DSN
+		if cfg.DSNEnv != "" {
+			dsn = os.Getenv(cfg.DSNEnv)
+			if dsn == "" {
+				return nil, fmt.Errorf("sql vars plugin: environment variable %s is not set", cfg.DSNEnv)
+			}
+		}
+		if cfg.Driver == "" || dsn == "" || cfg.Query == "" {
+			return nil, fmt.Errorf("sql vars plugin requires a driver, a dsn or dsn_env, and a query")
+		}
+		if !slices.Contains(sql.Drivers(), cfg.Driver) {
+			return nil, fmt.Errorf("sql vars plugin: database driver %q is not built into this binary, add a blank import of it to cmd/gosible and rebuild", cfg.Driver)
+		}
+		return &sqlVarsSource{driver: cfg.Driver, dsn: dsn, query: cfg.Query, key: key, columns: cfg.Columns}, nil
+	default:
+		return nil, fmt.Errorf("unknown vars plugin type %q, expected %q, %q or %q", cfg.Type, VarsPluginCSV, VarsPluginXLSX, VarsPluginSQL)
+	}
+}
+
+// varsPrecedence validates a plugin's precedence, defaulting to override
+func varsPrecedence(precedence string) (string, error) {
+	switch precedence {
+	case "", VarsPrecedenceOverride:
+		return VarsPrecedenceOverride, nil
+	case VarsPrecedenceDefault:
+		return VarsPrecedenceDefault, nil
+	default:
+		return "", fmt.Errorf("invalid vars plugin precedence %q, expected %q or %q", precedence, VarsPrecedenceOverride, VarsPrecedenceDefault)
+	}
+}
+
+// LoadVarsPlugins merges the variables of the vars plugins the inventory
+// file declares into its hosts, in the order they are listed, so a later
+// overriding plugin wins over an earlier one. Relative paths are resolved
+// against baseDir.
+func (inv *StaticInventory) LoadVarsPlugins(ctx context.Context, baseDir string) error {
+	for i, cfg := range inv.varsPlugins {
+		source, err := NewVarsSource(cfg, baseDir)
+		if err != nil {
+			return types.NewInventoryError(fmt.Sprintf("vars_plugins[%d]", i), "invalid vars plugin", err)
+		}
+		if _, err := inv.ApplyVarsSource(ctx, source, cfg.Precedence); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyVarsSource reads a vars source and merges its variables into the
+// inventory's hosts with the given precedence. It returns the host names
+// the source knows that are not in the inventory.
+func (inv *StaticInventory) ApplyVarsSource(ctx context.Context, source VarsSource, precedence string) ([]string, error) {
+	precedence, err := varsPrecedence(precedence)
+	if err != nil {
+		return nil, types.NewInventoryError(source.Name(), "invalid vars plugin", err)
+	}
+	vars, err := source.HostVars(ctx)
+	if err != nil {
+		return nil, types.NewInventoryError(source.Name(), "failed to load host variables", err)
+	}
+	return inv.MergeHostVars(vars, precedence), nil
+}
+
+// MergeHostVars merges variables into the hosts they are keyed by,
+// updating connection fields from connection variables such as
+// ansible_host. It returns the sorted names missing from the inventory.
+func (inv *StaticInventory) MergeHostVars(vars map[string]map[string]interface{}, precedence string) []string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	var unknown []string
+	for name, hostVars := range vars {
+		entry, exists := inv.hosts[name]
+		if !exists {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		host := entry.host(name)
+		merged := make(map[string]interface{}, len(host.Variables)+len(hostVars))
+		for k, v := range host.Variables {
+			merged[k] = v
+		}
+		for k, v := range hostVars {
+			if _, set := merged[k]; set && precedence == VarsPrecedenceDefault {
+				continue
+			}
+			merged[k] = v
+		}
+		host.Variables = merged
+		applyConnectionVars(&host)
+
+		entry.address = host.Address
+		if entry.address == name {
+			entry.address = ""
+		}
+		entry.user = inv.pool.string(host.User)
+		entry.password = host.Password
+		if host.Port != 0 {
+			entry.port = host.Port
+		}
+		entry.variables = inv.pool.variables(merged)
+		inv.hosts[name] = entry
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// rowVars turns the rows of a table, whose first row names the columns,
+// into host variables. Empty cells are left out, and a host listed on
+// several rows gets the variables of all of them, later rows winning.
+func rowVars(rows [][]string, key string, columns []string) (map[string]map[string]interface{}, error) {
+	if len(rows) == 0 {
+		return map[string]map[string]interface{}{}, nil
+	}
+
+	header := rows[0]
+	keyColumn := -1
+	for i, name := range header {
+		if name == key {
+			keyColumn = i
+			break
+		}
+	}
+	if keyColumn < 0 {
+		return nil, fmt.Errorf("no %q column holding host names", key)
+	}
+
+	wanted := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		wanted[column] = true
+	}
+
+	vars := make(map[string]map[string]interface{})
+	for _, row := range rows[1:] {
+		if keyColumn >= len(row) || row[keyColumn] == "" {
+			continue
+		}
+		host := row[keyColumn]
+		if vars[host] == nil {
+			vars[host] = make(map[string]interface{})
+		}
+		for i, value := range row {
+			if i == keyColumn || i >= len(header) || header[i] == "" || value == "" {
+				continue
+			}
+			if len(wanted) > 0 && !wanted[header[i]] {
+				continue
+			}
+			vars[host][header[i]] = value
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,271 @@
+package inventory
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSQLDriver serves the rows of assetRows to any query
+type fakeSQLDriver struct{}
+
+type fakeSQLConn struct{}
+
+type fakeSQLStmt struct{}
+
+type fakeSQLRows struct{ next int }
+
+var assetColumns = []string{"host", "rack", "owner", "cores", "audited"}
+
+var assetRows = [][]driver.Value{
+	{"web1", []byte("r12"), "payments", int64(16), time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+	{"web2", []byte("r13"), nil, int64(8), nil},
+	{"db9", []byte("r01"), "data", int64(64), nil},
+}
+
+func init() {
+	sql.Register("inventorytest", fakeSQLDriver{})
+}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	if name != "assets" {
+		return nil, errors.New("unknown database " + name)
+	}
+	return fakeSQLConn{}, nil
+}
+
+func (fakeSQLConn) Prepare(query string) (driver.Stmt, error) { return fakeSQLStmt{}, nil }
+func (fakeSQLConn) Close() error                              { return nil }
+func (fakeSQLConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (fakeSQLStmt) Close() error  { return nil }
+func (fakeSQLStmt) NumInput() int { return 0 }
+func (fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) { return &fakeSQLRows{}, nil }
+
+func (r *fakeSQLRows) Columns() []string { return assetColumns }
+func (r *fakeSQLRows) Close() error      { return nil }
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if r.next == len(assetRows) {
+		return io.EOF
+	}
+	copy(dest, assetRows[r.next])
+	r.next++
+	return nil
+}
+
+// writeXLSX writes a workbook whose second sheet, "Servers", holds the
+// given rows as shared strings
+func writeXLSX(t *testing.T, file string, rows [][]string) {
+	t.Helper()
+
+	var shared, sheet strings.Builder
+	index := 0
+	for r, row := range rows {
+		sheet.WriteString(`<row r="` + strconv.Itoa(r+1) + `">`)
+		for c, value := range row {
+			if value == "" {
+				continue
+			}
+			ref := string(rune('A'+c)) + strconv.Itoa(r+1)
+			sheet.WriteString(`<c r="` + ref + `" t="s"><v>` + strconv.Itoa(index) + `</v></c>`)
+			shared.WriteString(`<si><t>` + value + `</t></si>`)
+			index++
+		}
+		sheet.WriteString(`</row>`)
+	}
+
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Summary" sheetId="1" r:id="rId1"/><sheet name="Servers" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":     `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` + shared.String() + `</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>host</t></is></c><c r="B1" t="b"><v>1</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData>` + sheet.String() + `</sheetData></worksheet>`,
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	archive := zip.NewWriter(f)
+	for name, content := range parts {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVarsSources(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "assets.csv")
+	os.WriteFile(csvFile, []byte("\ufeffhostname,rack,owner\nweb1,r12,payments\nweb2,r13,\n,r99,nobody\n"), 0644)
+	xlsxFile := filepath.Join(dir, "assets.xlsx")
+	writeXLSX(t, xlsxFile, [][]string{{"host", "", "rack"}, {"web1", "", "r12"}, {"web2", "ignored", "r13"}})
+
+	tests := []struct {
+		name     string
+		cfg      VarsPluginConfig
+		expected map[string]map[string]interface{}
+	}{
+		{
+			name: "CSV",
+			cfg:  VarsPluginConfig{Type: VarsPluginCSV, Path: "assets.csv", Key: "hostname"},
+			expected: map[string]map[string]interface{}{
+				"web1": {"rack": "r12", "owner": "payments"},
+				"web2": {"rack": "r13"},
+			},
+		},
+		{
+			name: "CSVColumns",
+			cfg:  VarsPluginConfig{Type: VarsPluginCSV, Path: csvFile, Key: "hostname", Columns: []string{"owner"}},
+			expected: map[string]map[string]interface{}{
+				"web1": {"owner": "payments"},
+				"web2": {},
+			},
+		},
+		{
+			name: "XLSX",
+			cfg:  VarsPluginConfig{Type: VarsPluginXLSX, Path: "assets.xlsx", Sheet: "Servers"},
+			expected: map[string]map[string]interface{}{
+				"web1": {"rack": "r12"},
+				"web2": {"rack": "r13"},
+			},
+		},
+		{
+			name: "SQL",
+			cfg:  VarsPluginConfig{Type: VarsPluginSQL, Driver: "inventorytest", DSN: "assets", Query: "SELECT * FROM servers"},
+			expected: map[string]map[string]interface{}{
+				"web1": {"rack": "r12", "owner": "payments", "cores": int64(16), "audited": "2026-03-01T12:00:00Z"},
+				"web2": {"rack": "r13", "cores": int64(8)},
+				"db9":  {"rack": "r01", "owner": "data", "cores": int64(64)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewVarsSource(tt.cfg, dir)
+			if err != nil {
+				t.Fatalf("NewVarsSource failed: %v", err)
+			}
+			vars, err := source.HostVars(context.Background())
+			if err != nil {
+				t.Fatalf("HostVars failed: %v", err)
+			}
+			if !reflect.DeepEqual(vars, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, vars)
+			}
+		})
+	}
+
+	t.Run("FirstSheet", func(t *testing.T) {
+		rows, err := readXLSXSheet(xlsxFile, "")
+		if err != nil {
+			t.Fatalf("readXLSXSheet failed: %v", err)
+		}
+		if !reflect.DeepEqual(rows, [][]string{{"host", "true"}}) {
+			t.Errorf("unexpected rows %v", rows)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, cfg := range []VarsPluginConfig{
+			{Type: "ldap"},
+			{Type: VarsPluginCSV},
+			{Type: VarsPluginSQL, Driver: "inventorytest", Query: "SELECT 1"},
+			{Type: VarsPluginSQL, Driver: "inventorytest", DSNEnv: "GOSIBLE_TEST_UNSET_DSN", Query: "SELECT 1"},
+		} {
+			if _, err := NewVarsSource(cfg, dir); err == nil {
+				t.Errorf("expected %+v to be rejected", cfg)
+			}
+		}
+
+		_, err := NewVarsSource(VarsPluginConfig{Type: VarsPluginSQL, Driver: "postgres", DSN: "host=db", Query: "SELECT 1"}, dir)
+		if err == nil || !strings.Contains(err.Error(), `driver "postgres" is not built into this binary`) {
+			t.Errorf("expected an unregistered driver to be rejected, got %v", err)
+		}
+
+		source, _ := NewVarsSource(VarsPluginConfig{Type: VarsPluginCSV, Path: "assets.csv"}, dir)
+		if _, err := source.HostVars(context.Background()); err == nil || !strings.Contains(err.Error(), `no "host" column`) {
+			t.Errorf("expected a missing key column error, got %v", err)
+		}
+	})
+}
+
+func TestLoadVarsPlugins(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "assets.csv"), []byte("host,rack,env,ansible_host\nweb1,r12,staging,10.0.0.1\nghost,r99,prod,\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "owners.csv"), []byte("host,owner,rack\nweb1,payments,r77\n"), 0644)
+	inventoryFile := filepath.Join(dir, "hosts.yml")
+	os.WriteFile(inventoryFile, []byte(`
+all:
+  hosts:
+    web1:
+      env: production
+    web2:
+vars_plugins:
+  - type: csv
+    path: assets.csv
+    precedence: default
+  - type: csv
+    path: owners.csv
+`), 0644)
+
+	inv, err := NewFromFile(inventoryFile)
+	if err != nil {
+		t.Fatalf("NewFromFile failed: %v", err)
+	}
+
+	vars, err := inv.GetHostVars("web1")
+	if err != nil {
+		t.Fatalf("GetHostVars failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"env":   "production", // the inventory wins over a default plugin
+		"rack":  "r77",        // a later overriding plugin wins
+		"owner": "payments",
+	}
+	for k, v := range expected {
+		if vars[k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, vars[k])
+		}
+	}
+
+	host, _ := inv.GetHost("web1")
+	if host.Address != "10.0.0.1" {
+		t.Errorf("expected ansible_host from the plugin to set the address, got %q", host.Address)
+	}
+	if _, err := inv.GetHost("ghost"); err == nil {
+		t.Error("expected hosts missing from the inventory not to be added")
+	}
+
+	unknown := inv.MergeHostVars(map[string]map[string]interface{}{"ghost": {"rack": "r1"}}, VarsPrecedenceOverride)
+	if !reflect.DeepEqual(unknown, []string{"ghost"}) {
+		t.Errorf("expected ghost to be reported as unknown, got %v", unknown)
+	}
+
+	os.WriteFile(inventoryFile, []byte("all:\n  hosts:\n    web1:\nvars_plugins:\n  - type: csv\n    path: assets.csv\n    precedence: first\n"), 0644)
+	if _, err := NewFromFile(inventoryFile); err == nil || !strings.Contains(err.Error(), "precedence") {
+		t.Errorf("expected an invalid precedence error, got %v", err)
+	}
+}
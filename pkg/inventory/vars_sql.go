@@ -0,0 +1,102 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlVarsSource reads host variables from the rows of a SQL query.
+// NewVarsSource only creates it for drivers registered with database/sql,
+// which the binary does with a blank import such as github.com/lib/pq.
+type sqlVarsSource struct {
+	driver  string
+	dsn     string
+	query   string
+	key     string
+	columns []string
+}
+
+// Name returns the driver; the data source name may hold credentials
+func (s *sqlVarsSource) Name() string {
+	return "sql:" + s.driver
+}
+
+// HostVars runs the query, turning each row into the variables of the host
+// named in its key column. NULL values are left out, text columns become
+// strings and timestamps RFC 3339 strings.
+func (s *sqlVarsSource) HostVars(ctx context.Context) (map[string]map[string]interface{}, error) {
+	db, err := sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, s.query)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	keyColumn := -1
+	for i, name := range columns {
+		if name == s.key {
+			keyColumn = i
+			break
+		}
+	}
+	if keyColumn < 0 {
+		return nil, fmt.Errorf("query returns no %q column holding host names", s.key)
+	}
+
+	wanted := make(map[string]bool, len(s.columns))
+	for _, column := range s.columns {
+		wanted[column] = true
+	}
+
+	vars := make(map[string]map[string]interface{})
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		host := fmt.Sprint(sqlValue(values[keyColumn]))
+		if values[keyColumn] == nil || host == "" {
+			continue
+		}
+		if vars[host] == nil {
+			vars[host] = make(map[string]interface{})
+		}
+		for i, value := range values {
+			if i == keyColumn || value == nil || (len(wanted) > 0 && !wanted[columns[i]]) {
+				continue
+			}
+			vars[host][columns[i]] = sqlValue(value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// sqlValue converts a scanned value into a variable value
+func sqlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return v
+	}
+}
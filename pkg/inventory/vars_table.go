@@ -0,0 +1,255 @@
+package inventory
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// tableVarsSource reads host variables from a CSV file or an XLSX sheet,
+// such as an export of an asset database
+type tableVarsSource struct {
+	kind    string // VarsPluginCSV or VarsPluginXLSX
+	path    string
+	sheet   string
+	key     string
+	columns []string
+}
+
+// Name returns the source's file, and sheet when one is selected
+func (s *tableVarsSource) Name() string {
+	if s.sheet != "" {
+		return s.path + "#" + s.sheet
+	}
+	return s.path
+}
+
+// HostVars reads the file's rows as host variables
+func (s *tableVarsSource) HostVars(ctx context.Context) (map[string]map[string]interface{}, error) {
+	var rows [][]string
+	var err error
+	if s.kind == VarsPluginXLSX {
+		rows, err = readXLSXSheet(s.path, s.sheet)
+	} else {
+		rows, err = readCSV(s.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rowVars(rows, s.key, s.columns)
+}
+
+// readCSV reads a CSV file, dropping the byte order mark spreadsheet
+// applications write at its start
+func readCSV(file string) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+// XLSX parts read by readXLSXSheet. Only the values of cells are read;
+// styles, and so date formats, are ignored.
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxText is a string, either plain or made of formatted runs
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var text strings.Builder
+	for _, run := range t.Runs {
+		text.WriteString(run.Text)
+	}
+	return text.String()
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSXSheet reads the cell values of a sheet of an XLSX workbook, the
+// first sheet when name is empty
+func readXLSXSheet(file, name string) ([][]string, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var workbook xlsxWorkbook
+	if err := readXLSXPart(&archive.Reader, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+	sheetID := workbook.Sheets[0].ID
+	if name != "" {
+		sheetID = ""
+		for _, sheet := range workbook.Sheets {
+			if sheet.Name == name {
+				sheetID = sheet.ID
+			}
+		}
+		if sheetID == "" {
+			return nil, fmt.Errorf("workbook has no sheet %q", name)
+		}
+	}
+
+	var rels xlsxRelationships
+	if err := readXLSXPart(&archive.Reader, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetPart := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == sheetID {
+			sheetPart = rel.Target
+		}
+	}
+	if sheetPart == "" {
+		return nil, fmt.Errorf("workbook does not locate sheet %s", sheetID)
+	}
+	if strings.HasPrefix(sheetPart, "/") {
+		sheetPart = strings.TrimPrefix(sheetPart, "/")
+	} else {
+		sheetPart = path.Join("xl", sheetPart)
+	}
+
+	// Workbooks without text cells have no shared strings
+	var shared xlsxSharedStrings
+	if err := readXLSXPart(&archive.Reader, "xl/sharedStrings.xml", &shared); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var sheet xlsxWorksheet
+	if err := readXLSXPart(&archive.Reader, sheetPart, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var values []string
+		for i, cell := range row.Cells {
+			column := i
+			if cell.Ref != "" {
+				if column, err = xlsxColumn(cell.Ref); err != nil {
+					return nil, err
+				}
+			}
+
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(shared.Items) {
+					return nil, fmt.Errorf("cell %s refers to missing shared string %q", cell.Ref, cell.Value)
+				}
+				value = shared.Items[index].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "b":
+				value = strconv.FormatBool(cell.Value == "1")
+			}
+
+			for len(values) <= column {
+				values = append(values, "")
+			}
+			values[column] = value
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// readXLSXPart decodes an XML part of a workbook, returning an error
+// matching fs.ErrNotExist when the part is missing
+func readXLSXPart(archive *zip.Reader, name string, out interface{}) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// xlsxMaxColumns is the number of columns of an Excel sheet, A to XFD
+const xlsxMaxColumns = 16384
+
+// xlsxColumn returns the zero-based column of a cell reference such as
+// "AB12"
+func xlsxColumn(ref string) (int, error) {
+	column := 0
+	letters := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+		letters++
+	}
+	if letters == 0 || letters > 3 || column > xlsxMaxColumns {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return column - 1, nil
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// bootstrapInstallers install packages with whichever package manager a
+// host has, tried in order
+var bootstrapInstallers = []struct {
+	manager string
+	install string
+}{
+	{"apt-get", "apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq"},
+	{"dnf", "dnf install -y -q"},
+	{"yum", "yum install -y -q"},
+	{"apk", "apk add --no-cache -q"},
+	{"zypper", "zypper --non-interactive --quiet install"},
+	{"pacman", "pacman -Sy --noconfirm --needed"},
+}
+
+// Markers the bootstrap command prints, which tell whether it changed the
+// host
+const (
+	bootstrapReady     = "bootstrap: ready"
+	bootstrapInstalled = "bootstrap: installed"
+)
+
+// BootstrapTasks prepares pristine hosts for the other modules. Its tasks
+// only use the raw module, which needs nothing on the host but a POSIX
+// shell.
+type BootstrapTasks struct {
+	prerequisites []string
+}
+
+// NewBootstrapTasks creates a new BootstrapTasks instance installing
+// python3, which modules such as pip rely on, and sudo for become
+func NewBootstrapTasks() *BootstrapTasks {
+	return &BootstrapTasks{
+		prerequisites: []string{"python3", "sudo"},
+	}
+}
+
+// WithPrerequisites replaces the commands the bootstrap makes sure hosts
+// have. Each command is installed from the package of the same name.
+func (bt *BootstrapTasks) WithPrerequisites(commands ...string) *BootstrapTasks {
+	bt.prerequisites = commands
+	return bt
+}
+
+// Prerequisites returns the commands the bootstrap makes sure hosts have
+func (bt *BootstrapTasks) Prerequisites() []string {
+	return append([]string(nil), bt.prerequisites...)
+}
+
+// Command returns the shell command that finds which prerequisites are
+// missing with command -v, installs them with the host's package manager
+// and reports what it did. Hosts that have every prerequisite are left
+// untouched.
+func (bt *BootstrapTasks) Command() string {
+	quoted := make([]string, len(bt.prerequisites))
+	for i, command := range bt.prerequisites {
+		quoted[i] = "'" + strings.ReplaceAll(command, "'", `'\''`) + "'"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `missing=; for c in %s; do command -v "$c" >/dev/null 2>&1 || missing="$missing $c"; done; `, strings.Join(quoted, " "))
+	fmt.Fprintf(&b, `if [ -z "$missing" ]; then echo '%s'; exit 0; fi; `, bootstrapReady)
+	for i, installer := range bootstrapInstallers {
+		keyword := "elif"
+		if i == 0 {
+			keyword = "if"
+		}
+		fmt.Fprintf(&b, "%s command -v %s >/dev/null 2>&1; then %s $missing || exit 1; ", keyword, installer.manager, installer.install)
+	}
+	b.WriteString(`else echo "bootstrap: no supported package manager to install$missing" >&2; exit 1; fi; `)
+	fmt.Fprintf(&b, `echo "%s$missing"`, bootstrapInstalled)
+	return b.String()
+}
+
+// Bootstrap returns the task installing the missing prerequisites. It
+// needs root, and only reports a change when it installed something.
+func (bt *BootstrapTasks) Bootstrap() []types.Task {
+	become := true
+	return []types.Task{
+		{
+			Name:   "Install missing prerequisites",
+			Module: types.TypeRaw,
+			Args: map[string]interface{}{
+				"cmd": bt.Command(),
+			},
+			Become:      &become,
+			Register:    "bootstrap",
+			ChangedWhen: fmt.Sprintf("'%s' in stdout", bootstrapInstalled),
+			Tags:        []string{"bootstrap"},
+		},
+	}
+}
+
+// Play returns a play bootstrapping the given hosts
+func (bt *BootstrapTasks) Play(hosts string) types.Play {
+	return types.Play{
+		Name:  "Bootstrap hosts",
+		Hosts: hosts,
+		Tasks: bt.Bootstrap(),
+	}
+}
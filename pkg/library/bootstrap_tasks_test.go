@@ -0,0 +1,62 @@
+package library
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestBootstrapTasks_Bootstrap(t *testing.T) {
+	bt := NewBootstrapTasks()
+	if prerequisites := bt.Prerequisites(); len(prerequisites) != 2 || prerequisites[0] != "python3" {
+		t.Errorf("expected python3 and sudo by default, got %v", prerequisites)
+	}
+
+	tasks := bt.Bootstrap()
+	if len(tasks) != 1 {
+		t.Fatalf("expected a single task, got %d", len(tasks))
+	}
+	task := tasks[0]
+	if task.Module != types.TypeRaw || task.Become == nil || !*task.Become {
+		t.Errorf("expected a raw task with become, got %+v", task)
+	}
+	module, err := modules.DefaultModuleRegistry.GetModule(string(task.Module))
+	if err != nil {
+		t.Fatalf("task uses unknown module %s", task.Module)
+	}
+	if err := module.Validate(task.Args); err != nil {
+		t.Errorf("task has invalid args: %v", err)
+	}
+
+	command := bt.Command()
+	for _, installer := range bootstrapInstallers {
+		if !strings.Contains(command, "command -v "+installer.manager) {
+			t.Errorf("expected the command to try %s", installer.manager)
+		}
+	}
+}
+
+func TestBootstrapTasks_Command(t *testing.T) {
+	// A host with every prerequisite is left untouched
+	out, err := exec.Command("/bin/sh", "-c", NewBootstrapTasks().WithPrerequisites("sh").Command()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("bootstrap failed: %v: %s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != bootstrapReady {
+		t.Errorf("expected %q, got %q", bootstrapReady, out)
+	}
+
+	// Without a package manager the missing commands are reported
+	cmd := exec.Command("/bin/sh", "-c", NewBootstrapTasks().WithPrerequisites("gosible-missing").Command())
+	cmd.Env = []string{"PATH=" + t.TempDir()}
+	out, err = cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected the bootstrap to fail, got %q", out)
+	}
+	if !strings.Contains(string(out), "no supported package manager to install gosible-missing") {
+		t.Errorf("expected the missing command to be reported, got %q", out)
+	}
+}
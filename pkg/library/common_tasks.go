@@ -147,7 +147,7 @@ func (ct *CommonTasks) RunScriptWithCheck(script string, creates string, onlyIf
 		Name:   "Execute script",
 		Module: "script",
 		Args: map[string]interface{}{
-			"script": script,
+			"cmd": script,
 		},
 		Register: "script_result",
 	}
@@ -179,6 +179,12 @@ func (ct *CommonTasks) RunScriptWithCheck(script string, creates string, onlyIf
 
 // DockerContainer creates tasks to manage a Docker container
 func (ct *CommonTasks) DockerContainer(name, image string, ports []string, env map[string]string, volumes []string) []types.Task {
+	// Module arguments hold dicts as map[string]interface{}, as parsed from YAML
+	containerEnv := make(map[string]interface{}, len(env))
+	for key, value := range env {
+		containerEnv[key] = value
+	}
+
 	return []types.Task{
 		{
 			Name:   "Ensure Docker is installed",
@@ -205,7 +211,7 @@ func (ct *CommonTasks) DockerContainer(name, image string, ports []string, env m
 				"state":    "started",
 				"restart_policy": "unless-stopped",
 				"ports":    ports,
-				"env":      env,
+				"env":      containerEnv,
 				"volumes":  volumes,
 			},
 		},
@@ -0,0 +1,147 @@
+package library
+
+import (
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// credentialPhase orders the steps of a rotation
+type credentialPhase int
+
+const (
+	// SSH keys go first: each rotation verifies a login with the new key
+	// before the old keys are removed
+	phaseSSHKeys credentialPhase = iota
+	// A Windows account's password changes right before its services are
+	// given it, and the services restart last
+	phaseServiceAccounts
+	// Linux passwords go last, as one may be the become password the
+	// remaining tasks of the play still use
+	phasePasswords
+)
+
+type credentialStep struct {
+	phase credentialPhase
+	tasks []types.Task
+}
+
+// CredentialRotation builds the tasks of a credential rotation in an order
+// that never leaves an account without a working credential, whatever
+// order the rotations were added in. Tasks keep the order they were added
+// in within a phase.
+//
+// Connections opened before the rotation stay authenticated, so the play
+// doing the rotation can finish; the inventory must be given the new
+// credentials before the next run.
+type CredentialRotation struct {
+	steps []credentialStep
+}
+
+// NewCredentialRotation creates an empty credential rotation
+func NewCredentialRotation() *CredentialRotation {
+	return &CredentialRotation{}
+}
+
+// UserPassword rotates the password of a local Linux account. When
+// oldPassword is not empty the rotation fails unless the account still has
+// it.
+func (cr *CredentialRotation) UserPassword(user, oldPassword, newPassword string) *CredentialRotation {
+	args := map[string]interface{}{"name": user, "password": newPassword}
+	if oldPassword != "" {
+		args["old_password"] = oldPassword
+	}
+	cr.steps = append(cr.steps, credentialStep{phase: phasePasswords, tasks: []types.Task{{
+		Name:   "Rotate password of " + user,
+		Module: "password_rotate",
+		Args:   args,
+	}}})
+	return cr
+}
+
+// SSHKey replaces oldKeys of user with newKey, verifying a login with
+// privateKeyFile first. Without oldKeys every other key is removed.
+func (cr *CredentialRotation) SSHKey(user, newKey, privateKeyFile string, oldKeys ...string) *CredentialRotation {
+	args := map[string]interface{}{"user": user, "key": newKey, "private_key_file": privateKeyFile}
+	if len(oldKeys) > 0 {
+		keys := make([]interface{}, len(oldKeys))
+		for i, key := range oldKeys {
+			keys[i] = key
+		}
+		args["old_keys"] = keys
+	} else {
+		args["exclusive"] = true
+	}
+	cr.steps = append(cr.steps, credentialStep{phase: phaseSSHKeys, tasks: []types.Task{{
+		Name:   "Rotate SSH key of " + user,
+		Module: "ssh_key_rotate",
+		Args:   args,
+	}}})
+	return cr
+}
+
+// ServiceAccount rotates the password of the Windows account username and
+// of the services running as it, restarting the running ones. services
+// limits the update to the named services. The password of a domain
+// account is changed in the directory beforehand, so only the services are
+// updated for one.
+func (cr *CredentialRotation) ServiceAccount(username, newPassword string, services ...string) *CredentialRotation {
+	var tasks []types.Task
+	if local := localAccountName(username); local != "" {
+		tasks = append(tasks, types.Task{
+			Name:   "Rotate password of " + username,
+			Module: "win_user",
+			Args:   map[string]interface{}{"name": local, "password": newPassword, "update_password": "always"},
+		})
+	}
+
+	args := map[string]interface{}{"username": username, "password": newPassword, "restart": true}
+	if len(services) > 0 {
+		names := make([]interface{}, len(services))
+		for i, name := range services {
+			names[i] = name
+		}
+		args["services"] = names
+	}
+	tasks = append(tasks, types.Task{
+		Name:   "Update services running as " + username,
+		Module: "win_service_password",
+		Args:   args,
+	})
+
+	cr.steps = append(cr.steps, credentialStep{phase: phaseServiceAccounts, tasks: tasks})
+	return cr
+}
+
+// localAccountName returns the name of a local Windows account, or "" for
+// a domain account
+func localAccountName(username string) string {
+	if strings.Contains(username, "@") {
+		return ""
+	}
+	if i := strings.Index(username, `\`); i >= 0 {
+		if username[:i] != "." {
+			return ""
+		}
+		return username[i+1:]
+	}
+	return username
+}
+
+// Tasks returns the tasks of the rotation in no-lockout order, tagged
+// credential_rotation
+func (cr *CredentialRotation) Tasks() []types.Task {
+	var tasks []types.Task
+	for _, phase := range []credentialPhase{phaseSSHKeys, phaseServiceAccounts, phasePasswords} {
+		for _, step := range cr.steps {
+			if step.phase != phase {
+				continue
+			}
+			for _, task := range step.tasks {
+				task.Tags = append([]string{"credential_rotation"}, task.Tags...)
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks
+}
@@ -0,0 +1,57 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/modules"
+)
+
+func TestCredentialRotation_Tasks(t *testing.T) {
+	newKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAII29mf8LhdZdPjEWtkG6JFVKEVIyzuvR/y5JABiH5ez1 deploy-2026"
+	oldKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMqxL5+9MOXz4KgY1PLpi3ds0CvMNCS2utaZCMDkJ97o deploy-2025"
+
+	tasks := NewCredentialRotation().
+		UserPassword("deploy", "0ld-s3cret", "s3cret").
+		ServiceAccount(`CORP\svc-db`, "s3cret", "SQLAgent").
+		SSHKey("deploy", newKey, "keys/deploy-2026", oldKey).
+		ServiceAccount(`.\svc-app`, "s3cret").
+		SSHKey("backup", newKey, "keys/deploy-2026").
+		Tasks()
+
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+
+		if len(task.Tags) == 0 || task.Tags[0] != "credential_rotation" {
+			t.Errorf("expected task %q to be tagged, got %v", task.Name, task.Tags)
+		}
+		module, err := modules.DefaultModuleRegistry.GetModule(string(task.Module))
+		if err != nil {
+			t.Errorf("task %q uses unknown module %s", task.Name, task.Module)
+			continue
+		}
+		if err := module.Validate(task.Args); err != nil {
+			t.Errorf("task %q has invalid args: %v", task.Name, err)
+		}
+	}
+
+	expected := []string{
+		"Rotate SSH key of deploy",
+		"Rotate SSH key of backup",
+		`Update services running as CORP\svc-db`,
+		`Rotate password of .\svc-app`,
+		`Update services running as .\svc-app`,
+		"Rotate password of deploy",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected task order:\n%v\nwant:\n%v", names, expected)
+	}
+
+	if tasks[1].Args["exclusive"] != true {
+		t.Error("expected a key rotation without old keys to be exclusive")
+	}
+	if tasks[3].Args["name"] != "svc-app" {
+		t.Errorf("expected the local account name, got %v", tasks[3].Args["name"])
+	}
+}
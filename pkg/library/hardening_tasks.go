@@ -0,0 +1,495 @@
+package library
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Hardening control categories
+const (
+	HardeningSysctl      = "sysctl"
+	HardeningAuditd      = "auditd"
+	HardeningPAM         = "pam"
+	HardeningSSH         = "ssh"
+	HardeningPermissions = "permissions"
+)
+
+// Compliance statuses reported per control
+const (
+	ControlPass       = "pass"
+	ControlFail       = "fail"
+	ControlRemediated = "remediated"
+	ControlSkipped    = "skipped"
+)
+
+// Handlers notified by hardening tasks
+const (
+	reloadSSHHandler   = "reload sshd for hardening"
+	loadAuditHandler   = "load audit rules for hardening"
+	sshdDropInTemplate = "/etc/ssh/sshd_config.d/10-gosible-cis-%s.conf"
+)
+
+// HardeningControl is one control of a hardening baseline, numbered after
+// the CIS Benchmark section it implements
+type HardeningControl struct {
+	ID       string
+	Title    string
+	Category string
+	Tasks    []types.Task
+}
+
+// HardeningTasks applies a configurable subset of CIS Benchmark controls
+// for Linux: kernel parameters, auditd, PAM password policy, sshd settings
+// and permissions of sensitive files. Every task is idempotent, so running
+// the tasks and passing their results to Report yields a compliance report;
+// running them in check mode audits without remediating.
+//
+// sshd settings are written as drop-ins under /etc/ssh/sshd_config.d, which
+// needs an Include of that directory at the top of sshd_config (the default
+// on OpenSSH 8.2 and later packages).
+type HardeningTasks struct {
+	controls []HardeningControl
+	disabled map[string]bool
+}
+
+// NewHardeningTasks creates a HardeningTasks instance with the CIS baseline
+// controls, all enabled
+func NewHardeningTasks() *HardeningTasks {
+	ht := &HardeningTasks{disabled: make(map[string]bool)}
+
+	// Network and kernel parameters
+	ht.AddControl(sysctlControl("1.5.2", "Ensure address space layout randomization is enabled",
+		"kernel.randomize_va_space", "2"))
+	ht.AddControl(sysctlControl("3.3.1", "Ensure source routed packets are not accepted",
+		"net.ipv4.conf.all.accept_source_route", "0", "net.ipv4.conf.default.accept_source_route", "0"))
+	ht.AddControl(sysctlControl("3.3.2", "Ensure ICMP redirects are not accepted",
+		"net.ipv4.conf.all.accept_redirects", "0", "net.ipv4.conf.default.accept_redirects", "0"))
+	ht.AddControl(sysctlControl("3.3.4", "Ensure suspicious packets are logged",
+		"net.ipv4.conf.all.log_martians", "1", "net.ipv4.conf.default.log_martians", "1"))
+	ht.AddControl(sysctlControl("3.3.5", "Ensure broadcast ICMP requests are ignored",
+		"net.ipv4.icmp_echo_ignore_broadcasts", "1"))
+	ht.AddControl(sysctlControl("3.3.8", "Ensure TCP SYN cookies are enabled",
+		"net.ipv4.tcp_syncookies", "1"))
+
+	// Auditing
+	ht.AddControl(HardeningControl{
+		ID:       "4.1.1.2",
+		Title:    "Ensure auditd service is enabled and running",
+		Category: HardeningAuditd,
+		Tasks: []types.Task{{
+			Name:   "Enable auditd",
+			Module: "service",
+			Args:   map[string]interface{}{"name": "auditd", "state": "started", "enabled": true},
+		}},
+	})
+	ht.AddControl(HardeningControl{
+		ID:       "4.1.3",
+		Title:    "Ensure changes to identity, time, sudoers and login records are audited",
+		Category: HardeningAuditd,
+		Tasks: []types.Task{{
+			Name:   "Install audit rules",
+			Module: "copy",
+			Args: map[string]interface{}{
+				"dest":    "/etc/audit/rules.d/50-gosible-hardening.rules",
+				"content": auditRules,
+				"owner":   "root",
+				"group":   "root",
+				"mode":    "0640",
+			},
+			Notify: []string{loadAuditHandler},
+		}},
+	})
+
+	// PAM password policy
+	ht.AddControl(HardeningControl{
+		ID:       "5.4.1",
+		Title:    "Ensure password creation requirements are configured",
+		Category: HardeningPAM,
+		Tasks: []types.Task{{
+			Name:   "Configure pwquality",
+			Module: "copy",
+			Args: map[string]interface{}{
+				"dest":    "/etc/security/pwquality.conf.d/50-gosible-hardening.conf",
+				"content": "minlen = 14\nminclass = 4\n",
+				"owner":   "root",
+				"group":   "root",
+				"mode":    "0644",
+			},
+		}},
+	})
+	ht.AddControl(HardeningControl{
+		ID:       "5.4.2",
+		Title:    "Ensure lockout for failed password attempts is configured",
+		Category: HardeningPAM,
+		Tasks: []types.Task{{
+			Name:   "Configure faillock",
+			Module: "copy",
+			Args: map[string]interface{}{
+				"dest":    "/etc/security/faillock.conf",
+				"content": "deny = 5\nunlock_time = 900\n",
+				"owner":   "root",
+				"group":   "root",
+				"mode":    "0644",
+			},
+		}},
+	})
+
+	// SSH server
+	ht.AddControl(HardeningControl{
+		ID:       "5.2.1",
+		Title:    "Ensure permissions on /etc/ssh/sshd_config are configured",
+		Category: HardeningSSH,
+		Tasks:    []types.Task{fileModeTask("/etc/ssh/sshd_config", "root", "0600")},
+	})
+	ht.AddControl(sshdControl("5.2.5", "Ensure SSH LogLevel is appropriate", "LogLevel INFO"))
+	ht.AddControl(sshdControl("5.2.7", "Ensure SSH MaxAuthTries is set to 4 or less", "MaxAuthTries 4"))
+	ht.AddControl(sshdControl("5.2.10", "Ensure SSH root login is disabled", "PermitRootLogin no"))
+	ht.AddControl(sshdControl("5.2.11", "Ensure SSH PermitEmptyPasswords is disabled", "PermitEmptyPasswords no"))
+	ht.AddControl(sshdControl("5.2.16", "Ensure SSH Idle Timeout Interval is configured",
+		"ClientAliveInterval 300", "ClientAliveCountMax 3"))
+
+	// File permissions
+	ht.AddControl(HardeningControl{
+		ID:       "5.1.2",
+		Title:    "Ensure permissions on /etc/crontab are configured",
+		Category: HardeningPermissions,
+		Tasks:    []types.Task{fileModeTask("/etc/crontab", "root", "0600")},
+	})
+	ht.AddControl(HardeningControl{
+		ID:       "6.1.2",
+		Title:    "Ensure permissions on /etc/passwd are configured",
+		Category: HardeningPermissions,
+		Tasks:    []types.Task{fileModeTask("/etc/passwd", "root", "0644")},
+	})
+	ht.AddControl(HardeningControl{
+		ID:       "6.1.3",
+		Title:    "Ensure permissions on /etc/shadow are configured",
+		Category: HardeningPermissions,
+		Tasks:    []types.Task{fileModeTask("/etc/shadow", "root", "0640")},
+	})
+	ht.AddControl(HardeningControl{
+		ID:       "6.1.4",
+		Title:    "Ensure permissions on /etc/group are configured",
+		Category: HardeningPermissions,
+		Tasks:    []types.Task{fileModeTask("/etc/group", "root", "0644")},
+	})
+
+	return ht
+}
+
+// auditRules records changes to identity files, the clock, sudoers and
+// login records
+const auditRules = `-w /etc/group -p wa -k identity
+-w /etc/passwd -p wa -k identity
+-w /etc/gshadow -p wa -k identity
+-w /etc/shadow -p wa -k identity
+-w /etc/security/opasswd -p wa -k identity
+-a always,exit -F arch=b64 -S adjtimex -S settimeofday -S clock_settime -k time-change
+-w /etc/localtime -p wa -k time-change
+-w /etc/sudoers -p wa -k scope
+-w /etc/sudoers.d -p wa -k scope
+-w /var/log/lastlog -p wa -k logins
+-w /var/run/faillock -p wa -k logins
+`
+
+func sysctlControl(id, title string, params ...string) HardeningControl {
+	control := HardeningControl{ID: id, Title: title, Category: HardeningSysctl}
+	for i := 0; i+1 < len(params); i += 2 {
+		control.Tasks = append(control.Tasks, types.Task{
+			Name:   fmt.Sprintf("Set %s to %s", params[i], params[i+1]),
+			Module: "sysctl",
+			Args: map[string]interface{}{
+				"name":        params[i],
+				"value":       params[i+1],
+				"state":       "present",
+				"sysctl_file": "/etc/sysctl.d/60-gosible-hardening.conf",
+				"reload":      true,
+			},
+		})
+	}
+	return control
+}
+
+func sshdControl(id, title string, directives ...string) HardeningControl {
+	return HardeningControl{
+		ID:       id,
+		Title:    title,
+		Category: HardeningSSH,
+		Tasks: []types.Task{{
+			Name:   "Set " + strings.Join(directives, ", "),
+			Module: "copy",
+			Args: map[string]interface{}{
+				"dest":    fmt.Sprintf(sshdDropInTemplate, id),
+				"content": strings.Join(directives, "\n") + "\n",
+				"owner":   "root",
+				"group":   "root",
+				"mode":    "0600",
+			},
+			Notify: []string{reloadSSHHandler},
+		}},
+	}
+}
+
+func fileModeTask(path, owner, mode string) types.Task {
+	return types.Task{
+		Name:   fmt.Sprintf("Set %s on %s", mode, path),
+		Module: "file",
+		Args: map[string]interface{}{
+			"path":  path,
+			"state": "file",
+			"owner": owner,
+			"mode":  mode,
+		},
+	}
+}
+
+// AddControl adds a control to the baseline, replacing any control with
+// the same ID
+func (ht *HardeningTasks) AddControl(control HardeningControl) {
+	for i := range ht.controls {
+		if ht.controls[i].ID == control.ID {
+			ht.controls[i] = control
+			return
+		}
+	}
+	ht.controls = append(ht.controls, control)
+}
+
+// Controls returns every control of the baseline, enabled or not
+func (ht *HardeningTasks) Controls() []HardeningControl {
+	return append([]HardeningControl(nil), ht.controls...)
+}
+
+// Enable enables controls by ID or by category
+func (ht *HardeningTasks) Enable(selectors ...string) error {
+	return ht.setDisabled(false, selectors)
+}
+
+// Disable disables controls by ID or by category
+func (ht *HardeningTasks) Disable(selectors ...string) error {
+	return ht.setDisabled(true, selectors)
+}
+
+// Only enables exactly the selected controls and categories
+func (ht *HardeningTasks) Only(selectors ...string) error {
+	previous := ht.disabled
+	ht.disabled = make(map[string]bool)
+	for _, control := range ht.controls {
+		ht.disabled[control.ID] = true
+	}
+	if err := ht.Enable(selectors...); err != nil {
+		ht.disabled = previous
+		return err
+	}
+	return nil
+}
+
+func (ht *HardeningTasks) setDisabled(disabled bool, selectors []string) error {
+	var ids []string
+	for _, selector := range selectors {
+		matched := false
+		for _, control := range ht.controls {
+			if control.ID == selector || control.Category == selector {
+				ids = append(ids, control.ID)
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("unknown hardening control or category '%s'", selector)
+		}
+	}
+	for _, id := range ids {
+		ht.disabled[id] = disabled
+	}
+	return nil
+}
+
+// Enabled reports whether a control is enabled
+func (ht *HardeningTasks) Enabled(id string) bool {
+	return !ht.disabled[id]
+}
+
+// Apply creates the tasks of every enabled control. Task names carry the
+// control ID, which Report uses to attribute results, and tasks are tagged
+// with "hardening", the control ID and its category.
+func (ht *HardeningTasks) Apply() []types.Task {
+	var tasks []types.Task
+	for _, control := range ht.controls {
+		if !ht.Enabled(control.ID) {
+			continue
+		}
+		for _, task := range control.Tasks {
+			task.Name = controlPrefix(control.ID) + task.Name
+			task.Tags = append([]string{"hardening", "cis_" + control.ID, control.Category}, task.Tags...)
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Handlers creates the handlers notified by the enabled controls
+func (ht *HardeningTasks) Handlers() []types.Task {
+	notified := make(map[string]bool)
+	for _, task := range ht.Apply() {
+		for _, handler := range task.Notify {
+			notified[handler] = true
+		}
+	}
+
+	var handlers []types.Task
+	if notified[reloadSSHHandler] {
+		handlers = append(handlers, types.Task{
+			Name:   reloadSSHHandler,
+			Module: "shell",
+			Args:   map[string]interface{}{"cmd": "sshd -t && (systemctl reload sshd || systemctl reload ssh)"},
+		})
+	}
+	if notified[loadAuditHandler] {
+		handlers = append(handlers, types.Task{
+			Name:   loadAuditHandler,
+			Module: "command",
+			Args:   map[string]interface{}{"cmd": "augenrules --load"},
+		})
+	}
+	return handlers
+}
+
+// Play creates a play applying the enabled controls to hosts with become
+func (ht *HardeningTasks) Play(hosts string) types.Play {
+	become := true
+	return types.Play{
+		Name:     "Apply CIS hardening baseline",
+		Hosts:    hosts,
+		Become:   &become,
+		Tasks:    ht.Apply(),
+		Handlers: ht.Handlers(),
+	}
+}
+
+// ControlResult is the compliance status of one control
+type ControlResult struct {
+	ID       string   `json:"id"`
+	Title    string   `json:"title"`
+	Category string   `json:"category"`
+	Status   string   `json:"status"`
+	Details  []string `json:"details,omitempty"`
+}
+
+// ComplianceReport summarizes the compliance of a host with the baseline
+type ComplianceReport struct {
+	Host       string          `json:"host,omitempty"`
+	Controls   []ControlResult `json:"controls"`
+	Passed     int             `json:"passed"`
+	Failed     int             `json:"failed"`
+	Remediated int             `json:"remediated"`
+	Skipped    int             `json:"skipped"`
+}
+
+// Report builds the compliance report of one host from the results of the
+// tasks created by Apply. A control passes when none of its tasks changed
+// anything, is remediated when a task changed the host and fails when a
+// task failed or did not run. In check mode a pending change is a failure,
+// since nothing was remediated.
+func (ht *HardeningTasks) Report(host string, results []*types.Result, checkMode bool) *ComplianceReport {
+	byControl := make(map[string][]*types.Result)
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		for _, control := range ht.controls {
+			if strings.HasPrefix(result.TaskName, controlPrefix(control.ID)) {
+				byControl[control.ID] = append(byControl[control.ID], result)
+				break
+			}
+		}
+	}
+
+	report := &ComplianceReport{Host: host}
+	for _, control := range ht.controls {
+		entry := ControlResult{ID: control.ID, Title: control.Title, Category: control.Category}
+		controlResults := byControl[control.ID]
+
+		switch {
+		case !ht.Enabled(control.ID):
+			entry.Status = ControlSkipped
+		case len(controlResults) < len(control.Tasks):
+			entry.Status = ControlFail
+			entry.Details = append(entry.Details, fmt.Sprintf("%d of %d checks did not run", len(control.Tasks)-len(controlResults), len(control.Tasks)))
+		default:
+			entry.Status = ControlPass
+			for _, result := range controlResults {
+				name := strings.TrimPrefix(result.TaskName, controlPrefix(control.ID))
+				switch {
+				case !result.Success:
+					entry.Status = ControlFail
+					entry.Details = append(entry.Details, fmt.Sprintf("%s: %s", name, result.Message))
+				case result.Changed && checkMode:
+					entry.Status = ControlFail
+					entry.Details = append(entry.Details, name+": not compliant")
+				case result.Changed && entry.Status == ControlPass:
+					entry.Status = ControlRemediated
+					entry.Details = append(entry.Details, name+": remediated")
+				case result.Changed:
+					entry.Details = append(entry.Details, name+": remediated")
+				}
+			}
+		}
+
+		switch entry.Status {
+		case ControlPass:
+			report.Passed++
+		case ControlFail:
+			report.Failed++
+		case ControlRemediated:
+			report.Remediated++
+		case ControlSkipped:
+			report.Skipped++
+		}
+		report.Controls = append(report.Controls, entry)
+	}
+
+	sort.SliceStable(report.Controls, func(i, j int) bool {
+		return compareControlIDs(report.Controls[i].ID, report.Controls[j].ID) < 0
+	})
+	return report
+}
+
+// Compliant reports whether no enabled control failed
+func (r *ComplianceReport) Compliant() bool {
+	return r.Failed == 0
+}
+
+// String renders the report as one line per control followed by totals
+func (r *ComplianceReport) String() string {
+	var b strings.Builder
+	for _, control := range r.Controls {
+		fmt.Fprintf(&b, "%-10s %-8s %s\n", control.Status, control.ID, control.Title)
+		for _, detail := range control.Details {
+			fmt.Fprintf(&b, "%19s %s\n", "", detail)
+		}
+	}
+	fmt.Fprintf(&b, "passed=%d remediated=%d failed=%d skipped=%d\n", r.Passed, r.Remediated, r.Failed, r.Skipped)
+	return b.String()
+}
+
+func controlPrefix(id string) string {
+	return "[CIS " + id + "] "
+}
+
+// compareControlIDs orders dotted section numbers numerically, so 5.2.10
+// follows 5.2.7
+func compareControlIDs(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		var x, y int
+		fmt.Sscanf(as[i], "%d", &x)
+		fmt.Sscanf(bs[i], "%d", &y)
+		if x != y {
+			return x - y
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,155 @@
+package library
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestHardeningTasks_Apply(t *testing.T) {
+	ht := NewHardeningTasks()
+
+	categories := make(map[string]bool)
+	for _, control := range ht.Controls() {
+		categories[control.Category] = true
+	}
+	for _, category := range []string{HardeningSysctl, HardeningAuditd, HardeningPAM, HardeningSSH, HardeningPermissions} {
+		if !categories[category] {
+			t.Errorf("expected the baseline to cover %s", category)
+		}
+	}
+
+	tasks := ht.Apply()
+	for _, task := range tasks {
+		if !strings.HasPrefix(task.Name, "[CIS ") {
+			t.Errorf("expected task %q to carry its control ID", task.Name)
+		}
+		if len(task.Tags) < 3 || task.Tags[0] != "hardening" {
+			t.Errorf("expected task %q to be tagged, got %v", task.Name, task.Tags)
+		}
+
+		module, err := modules.DefaultModuleRegistry.GetModule(string(task.Module))
+		if err != nil {
+			t.Errorf("task %q uses unknown module %s", task.Name, task.Module)
+			continue
+		}
+		if err := module.Validate(task.Args); err != nil {
+			t.Errorf("task %q has invalid args: %v", task.Name, err)
+		}
+	}
+
+	handlers := ht.Handlers()
+	if len(handlers) != 2 {
+		t.Fatalf("expected sshd and audit handlers, got %d", len(handlers))
+	}
+	for _, handler := range handlers {
+		module, _ := modules.DefaultModuleRegistry.GetModule(string(handler.Module))
+		if err := module.Validate(handler.Args); err != nil {
+			t.Errorf("handler %q has invalid args: %v", handler.Name, err)
+		}
+	}
+
+	play := ht.Play("webservers")
+	if play.Become == nil || !*play.Become || len(play.Tasks) != len(tasks) {
+		t.Error("expected a become play with every enabled task")
+	}
+}
+
+func TestHardeningTasks_EnableDisable(t *testing.T) {
+	ht := NewHardeningTasks()
+
+	if err := ht.Disable(HardeningSSH, "6.1.3"); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+	for _, task := range ht.Apply() {
+		if strings.Contains(task.Name, "sshd") || strings.HasPrefix(task.Name, "[CIS 6.1.3] ") {
+			t.Errorf("expected %q to be disabled", task.Name)
+		}
+	}
+	if len(ht.Handlers()) != 1 {
+		t.Error("expected the sshd handler to be dropped with the ssh controls")
+	}
+
+	ht.Enable("5.2.10")
+	if !ht.Enabled("5.2.10") || ht.Enabled("5.2.11") {
+		t.Error("expected only 5.2.10 to be re-enabled")
+	}
+
+	if err := ht.Only("5.2.10", HardeningPermissions); err != nil {
+		t.Fatalf("Only failed: %v", err)
+	}
+	for _, control := range ht.Controls() {
+		expected := control.ID == "5.2.10" || control.Category == HardeningPermissions
+		if ht.Enabled(control.ID) != expected {
+			t.Errorf("control %s: expected enabled=%v", control.ID, expected)
+		}
+	}
+
+	if err := ht.Disable("9.9.9"); err == nil {
+		t.Error("expected unknown controls to be rejected")
+	}
+	if err := ht.Only("bogus"); err == nil || !ht.Enabled("5.2.10") {
+		t.Error("expected a failed Only to keep the previous selection")
+	}
+}
+
+func TestHardeningTasks_Report(t *testing.T) {
+	ht := NewHardeningTasks()
+	ht.Only("5.2.7", "5.2.10", "5.2.11", "3.3.1", "6.1.2")
+	ht.Enable("6.1.3")
+
+	results := []*types.Result{
+		{TaskName: "[CIS 5.2.10] Set PermitRootLogin no", Success: true, Changed: true},
+		{TaskName: "[CIS 5.2.7] Set MaxAuthTries 4", Success: true},
+		{TaskName: "[CIS 5.2.11] Set PermitEmptyPasswords no", Success: false, Message: "permission denied"},
+		{TaskName: "[CIS 3.3.1] Set net.ipv4.conf.all.accept_source_route to 0", Success: true},
+		{TaskName: "[CIS 6.1.2] Set 0644 on /etc/passwd", Success: true},
+		{TaskName: "[CIS 6.1.3] Set 0640 on /etc/shadow", Success: true},
+		{TaskName: "Unrelated task", Success: false},
+	}
+	report := ht.Report("web1", results, false)
+
+	statuses := make(map[string]string)
+	for _, control := range report.Controls {
+		statuses[control.ID] = control.Status
+	}
+	expected := map[string]string{
+		"5.2.10": ControlRemediated,
+		"5.2.7":  ControlPass,
+		"5.2.11": ControlFail,
+		"3.3.1":  ControlFail, // only one of its two parameters was checked
+		"6.1.2":  ControlPass,
+		"6.1.3":  ControlPass,
+		"5.2.16": ControlSkipped,
+	}
+	for id, status := range expected {
+		if statuses[id] != status {
+			t.Errorf("control %s: expected %s, got %s", id, status, statuses[id])
+		}
+	}
+	if report.Remediated != 1 || report.Failed != 2 || report.Passed != 3 || report.Compliant() {
+		t.Errorf("unexpected totals: %+v", report)
+	}
+
+	// Numeric ordering of sections
+	var order []string
+	for _, control := range report.Controls {
+		order = append(order, control.ID)
+	}
+	if joined := strings.Join(order, " "); !strings.Contains(joined, "5.2.7 5.2.10 5.2.11") {
+		t.Errorf("expected controls in section order, got %s", joined)
+	}
+	if out := report.String(); !strings.Contains(out, "remediated 5.2.10") || !strings.Contains(out, "passed=3 remediated=1 failed=2") {
+		t.Errorf("unexpected report rendering:\n%s", out)
+	}
+
+	// In check mode a pending change means the host is not compliant
+	report = ht.Report("web1", results, true)
+	for _, control := range report.Controls {
+		if control.ID == "5.2.10" && control.Status != ControlFail {
+			t.Errorf("expected a pending change to fail in check mode, got %s", control.Status)
+		}
+	}
+}
@@ -13,8 +13,18 @@ func NewNetworkTasks() *NetworkTasks {
 	return &NetworkTasks{}
 }
 
-// ConfigureFirewall creates tasks to manage firewall rules
+// ConfigureFirewall creates tasks that open or close a port with the
+// host's firewall: ufw on Ubuntu, firewalld on RedHat and iptables on other
+// Debian systems. action is allow, limit, deny or reject.
 func (nt *NetworkTasks) ConfigureFirewall(port int, protocol, action string) []types.Task {
+	firewalldState, jump := "enabled", "ACCEPT"
+	switch action {
+	case "deny":
+		firewalldState, jump = "disabled", "DROP"
+	case "reject":
+		firewalldState, jump = "disabled", "REJECT"
+	}
+
 	return []types.Task{
 		{
 			Name:   "Install UFW (Ubuntu)",
@@ -30,7 +40,7 @@ func (nt *NetworkTasks) ConfigureFirewall(port int, protocol, action string) []t
 			Module: "ufw",
 			Args: map[string]interface{}{
 				"rule":  action,
-				"port":  port,
+				"port":  fmt.Sprintf("%d", port),
 				"proto": protocol,
 			},
 			When: "ansible_distribution == 'Ubuntu'",
@@ -41,7 +51,7 @@ func (nt *NetworkTasks) ConfigureFirewall(port int, protocol, action string) []t
 			Args: map[string]interface{}{
 				"port":      fmt.Sprintf("%d/%s", port, protocol),
 				"permanent": true,
-				"state":     "enabled",
+				"state":     firewalldState,
 				"immediate": true,
 			},
 			When: "ansible_os_family == 'RedHat'",
@@ -50,11 +60,11 @@ func (nt *NetworkTasks) ConfigureFirewall(port int, protocol, action string) []t
 			Name:   "Configure iptables rule",
 			Module: "iptables",
 			Args: map[string]interface{}{
-				"chain":    "INPUT",
-				"protocol": protocol,
+				"chain":            "INPUT",
+				"protocol":         protocol,
 				"destination_port": port,
-				"jump":     "ACCEPT",
-				"state":    "present",
+				"jump":             jump,
+				"state":            "present",
 			},
 			When: "ansible_os_family == 'Debian' and ansible_distribution != 'Ubuntu'",
 		},
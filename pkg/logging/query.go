@@ -0,0 +1,245 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultQueryLimit is the page size of queries that do not set one
+	DefaultQueryLimit = 100
+	// MaxQueryLimit caps the page size of HTTP queries
+	MaxQueryLimit = 1000
+)
+
+// LogQuery selects captured log entries. Zero fields do not filter.
+type LogQuery struct {
+	MinLevel  LogLevel  // Lowest level to include
+	Host      string    // Exact target host
+	Task      string    // Exact task name
+	Source    string    // Exact logger source
+	SessionID string    // Exact execution session
+	Since     time.Time // Entries at or after this time
+	Until     time.Time // Entries before this time
+	Text      string    // Case-insensitive text in the message, error or field values
+	Offset    int       // Matches to skip
+	Limit     int       // Page size, DefaultQueryLimit when zero
+	Newest    bool      // Return the newest entries first
+}
+
+// LogQueryResult is a page of entries matching a query
+type LogQueryResult struct {
+	Entries    []LogEntry `json:"entries"`
+	Total      int        `json:"total"`                 // Matches before pagination
+	Offset     int        `json:"offset"`                // Offset of the first entry
+	NextOffset int        `json:"next_offset,omitempty"` // Offset of the next page, zero on the last one
+}
+
+// LogQuerier answers log queries
+type LogQuerier interface {
+	Query(query LogQuery) LogQueryResult
+}
+
+// ParseLogLevel parses a level name, as LogLevel.String returns it in any
+// case, or its number
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN", "WARNING":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	case "FATAL":
+		return LevelFatal, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= int(LevelDebug) && n <= int(LevelFatal) {
+		return LogLevel(n), nil
+	}
+	return LevelDebug, fmt.Errorf("unknown log level %q", s)
+}
+
+// Matches reports whether an entry passes the query's filters
+func (q LogQuery) Matches(entry LogEntry) bool {
+	switch {
+	case entry.Level < q.MinLevel:
+		return false
+	case q.Host != "" && entry.Host != q.Host:
+		return false
+	case q.Task != "" && entry.TaskName != q.Task:
+		return false
+	case q.Source != "" && entry.Source != q.Source:
+		return false
+	case q.SessionID != "" && entry.SessionID != q.SessionID:
+		return false
+	case !q.Since.IsZero() && entry.Timestamp.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !entry.Timestamp.Before(q.Until):
+		return false
+	}
+	return q.Text == "" || entryContains(entry, strings.ToLower(q.Text))
+}
+
+// entryContains reports whether the lowercase text appears in the entry's
+// message, error or field values
+func entryContains(entry LogEntry, text string) bool {
+	if strings.Contains(strings.ToLower(entry.Message), text) || strings.Contains(strings.ToLower(entry.Error), text) {
+		return true
+	}
+	for _, value := range entry.Fields {
+		if strings.Contains(strings.ToLower(fmt.Sprint(value)), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns a page of the captured entries matching the query, oldest
+// first unless the query asks for the newest
+func (m *MemoryLogOutput) Query(query LogQuery) LogQueryResult {
+	return runQuery(m.GetEntries(), query)
+}
+
+// Query flushes buffered entries and queries the first memory output, so
+// entries logged just before are included. Without a memory output the
+// result is empty.
+func (l *StreamLogger) Query(query LogQuery) LogQueryResult {
+	l.bufferMu.Lock()
+	l.flush()
+	l.bufferMu.Unlock()
+
+	l.mu.RLock()
+	var memory *MemoryLogOutput
+	for _, output := range l.outputs {
+		if m, ok := output.(*MemoryLogOutput); ok {
+			memory = m
+			break
+		}
+	}
+	l.mu.RUnlock()
+
+	if memory == nil {
+		return runQuery(nil, query)
+	}
+	return memory.Query(query)
+}
+
+// runQuery filters, orders and paginates entries
+func runQuery(entries []LogEntry, query LogQuery) LogQueryResult {
+	matched := make([]LogEntry, 0)
+	for _, entry := range entries {
+		if query.Matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	// Outputs keep entries in flush order, which can differ slightly from
+	// timestamp order across goroutines
+	sort.SliceStable(matched, func(i, j int) bool {
+		if query.Newest {
+			return matched[i].Timestamp.After(matched[j].Timestamp)
+		}
+		return matched[i].Timestamp.Before(matched[j].Timestamp)
+	})
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	offset := query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	result := LogQueryResult{Entries: matched[offset:end], Total: len(matched), Offset: offset}
+	if end < len(matched) {
+		result.NextOffset = end
+	}
+	return result
+}
+
+// ParseLogQuery builds a query from URL parameters: level, host, task,
+// source, session, since and until (RFC 3339), q, offset, limit and order
+// (asc or desc)
+func ParseLogQuery(values url.Values) (LogQuery, error) {
+	query := LogQuery{
+		Host:      values.Get("host"),
+		Task:      values.Get("task"),
+		Source:    values.Get("source"),
+		SessionID: values.Get("session"),
+		Text:      values.Get("q"),
+	}
+
+	if level := values.Get("level"); level != "" {
+		parsed, err := ParseLogLevel(level)
+		if err != nil {
+			return query, err
+		}
+		query.MinLevel = parsed
+	}
+	for name, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := values.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s %q: expected an RFC 3339 time", name, value)
+			}
+			*target = parsed
+		}
+	}
+	for name, target := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("invalid %s %q: expected a non-negative integer", name, value)
+			}
+			*target = n
+		}
+	}
+	if query.Limit > MaxQueryLimit {
+		query.Limit = MaxQueryLimit
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Newest = true
+	default:
+		return query, fmt.Errorf("invalid order %q: expected asc or desc", order)
+	}
+	return query, nil
+}
+
+// NewLogQueryHandler returns an HTTP handler answering GET requests with a
+// JSON LogQueryResult for the query in the URL parameters
+func NewLogQueryHandler(querier LogQuerier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		query, err := ParseLogQuery(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(querier.Query(query))
+	})
+}
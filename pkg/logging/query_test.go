@@ -0,0 +1,148 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func queryEntries() *MemoryLogOutput {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	output := &MemoryLogOutput{maxSize: 100}
+	output.Write(LogEntry{Timestamp: base, Level: LevelInfo, Message: "Step Started: install nginx", Host: "web1", TaskName: "install"})
+	output.Write(LogEntry{Timestamp: base.Add(time.Second), Level: LevelWarn, Message: "Error Output: retrying", Host: "web2", TaskName: "install"})
+	output.Write(LogEntry{Timestamp: base.Add(2 * time.Second), Level: LevelError, Message: "Step failed", Error: "connection refused", Host: "web1", TaskName: "start"})
+	output.Write(LogEntry{Timestamp: base.Add(3 * time.Second), Level: LevelDebug, Message: "Output: ok", Host: "web2", TaskName: "start", Fields: map[string]interface{}{"step_id": "unit-Reload"}})
+	return output
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]LogLevel{"debug": LevelDebug, "WARN": LevelWarn, "warning": LevelWarn, "Error": LevelError, "4": LevelFatal}
+	for input, expected := range tests {
+		if level, err := ParseLogLevel(input); err != nil || level != expected {
+			t.Errorf("ParseLogLevel(%q) = %s, %v; expected %s", input, level, err, expected)
+		}
+	}
+	for _, input := range []string{"verbose", "9", ""} {
+		if _, err := ParseLogLevel(input); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestMemoryLogOutput_Query(t *testing.T) {
+	output := queryEntries()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    LogQuery
+		expected []string
+	}{
+		{"All", LogQuery{}, []string{"Step Started: install nginx", "Error Output: retrying", "Step failed", "Output: ok"}},
+		{"Level", LogQuery{MinLevel: LevelWarn}, []string{"Error Output: retrying", "Step failed"}},
+		{"HostAndTask", LogQuery{Host: "web1", Task: "start"}, []string{"Step failed"}},
+		{"TimeRange", LogQuery{Since: base.Add(time.Second), Until: base.Add(3 * time.Second)}, []string{"Error Output: retrying", "Step failed"}},
+		{"TextInError", LogQuery{Text: "REFUSED"}, []string{"Step failed"}},
+		{"TextInFields", LogQuery{Text: "unit-reload"}, []string{"Output: ok"}},
+		{"Newest", LogQuery{Newest: true, Limit: 2}, []string{"Output: ok", "Step failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := output.Query(tt.query)
+			var messages []string
+			for _, entry := range result.Entries {
+				messages = append(messages, entry.Message)
+			}
+			if len(messages) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, messages)
+			}
+			for i := range messages {
+				if messages[i] != tt.expected[i] {
+					t.Fatalf("expected %v, got %v", tt.expected, messages)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLogOutput_QueryPagination(t *testing.T) {
+	output := queryEntries()
+
+	page := output.Query(LogQuery{Limit: 3})
+	if len(page.Entries) != 3 || page.Total != 4 || page.NextOffset != 3 {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page = output.Query(LogQuery{Offset: page.NextOffset, Limit: 3})
+	if len(page.Entries) != 1 || page.Entries[0].Message != "Output: ok" || page.NextOffset != 0 {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+	page = output.Query(LogQuery{Offset: 10})
+	if len(page.Entries) != 0 || page.Offset != 4 {
+		t.Fatalf("expected an empty page past the end, got %+v", page)
+	}
+}
+
+func TestStreamLogger_QueryFlushes(t *testing.T) {
+	logger := NewStreamLogger("test", "session")
+	defer logger.Close()
+	logger.AddMemoryOutput(10)
+
+	logger.Log(LevelInfo, "buffered", nil)
+	result := logger.Query(LogQuery{Text: "buffered"})
+	if result.Total != 1 {
+		t.Fatalf("expected the buffered entry to be queried, got %+v", result)
+	}
+}
+
+func TestParseLogQuery(t *testing.T) {
+	query, err := ParseLogQuery(url.Values{
+		"level": {"warn"}, "host": {"web1"}, "q": {"timeout"},
+		"since": {"2024-05-01T12:00:00Z"}, "offset": {"20"}, "limit": {"5000"}, "order": {"desc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.MinLevel != LevelWarn || query.Host != "web1" || query.Text != "timeout" || query.Offset != 20 ||
+		query.Limit != MaxQueryLimit || !query.Newest || query.Since.IsZero() {
+		t.Errorf("unexpected query %+v", query)
+	}
+
+	for _, values := range []url.Values{{"level": {"loud"}}, {"since": {"yesterday"}}, {"limit": {"-1"}}, {"order": {"random"}}} {
+		if _, err := ParseLogQuery(values); err == nil {
+			t.Errorf("expected %v to be rejected", values)
+		}
+	}
+}
+
+func TestLogQueryHandler(t *testing.T) {
+	handler := NewLogQueryHandler(queryEntries())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs?host=web2&limit=1", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body)
+	}
+	var result LogQueryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Entries) != 1 || result.Entries[0].Message != "Error Output: retrying" || result.NextOffset != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs?level=loud", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/logs", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,352 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// DefaultRedactionMask replaces redacted values
+const DefaultRedactionMask = "********"
+
+// minSecretLength is the length below which literal secrets are ignored, as
+// very short values would mask unrelated text
+const minSecretLength = 4
+
+// RedactionRules configure a Redactor
+type RedactionRules struct {
+	// Patterns are regular expressions masked in all text. When a pattern
+	// has capture groups only the groups are masked, so the context such
+	// as "Authorization: Bearer" stays readable.
+	Patterns []string `yaml:"patterns" json:"patterns"`
+	// Fields are paths of structured values that are masked whole: dotted
+	// keys matched case-insensitively against the end of a value's path,
+	// so password masks every password key and headers.authorization only
+	// those under headers. A * segment matches any key or list index and a
+	// leading $. anchors the path at the root.
+	Fields []string `yaml:"fields" json:"fields"`
+	// Secrets are literal values masked wherever they appear
+	Secrets []string `yaml:"secrets" json:"secrets"`
+	// Mask replaces redacted values, DefaultRedactionMask when empty
+	Mask string `yaml:"mask" json:"mask"`
+}
+
+// DefaultRedactionRules mask well-known secret fields, credentials in
+// authorization headers and URLs, and private keys
+func DefaultRedactionRules() RedactionRules {
+	return RedactionRules{
+		Patterns: []string{
+			`(?i)\b(?:authorization|proxy-authorization)\s*[:=]\s*(?:bearer|basic|token)\s+([^\s"',]+)`,
+			`(?i)\b[a-z][a-z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`,
+			`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+			`\bAKIA[0-9A-Z]{16}\b`,
+		},
+		Fields: []string{
+			"password", "passwd", "secret", "token", "api_key", "private_key", "access_key", "secret_key",
+			"client_secret", "ansible_password", "ansible_become_password", "ansible_ssh_pass",
+			"headers.authorization",
+		},
+	}
+}
+
+// LoadRedactionRules reads rules from a YAML or JSON file
+func LoadRedactionRules(path string) (RedactionRules, error) {
+	var rules RedactionRules
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read redaction rules: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to parse redaction rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Redactor masks secrets in log entries, stream events and task results
+// before they are written anywhere. Secrets learned while running, such as
+// decrypted vault values, are added with AddSecret. A nil Redactor leaves
+// everything unchanged.
+type Redactor struct {
+	mu       sync.RWMutex
+	patterns []*regexp.Regexp
+	fields   [][]string
+	secrets  []string // Longest first so one containing another is fully masked
+	mask     string
+}
+
+// NewRedactor compiles redaction rules
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	r := &Redactor{mask: rules.Mask}
+	if r.mask == "" {
+		r.mask = DefaultRedactionMask
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, field := range rules.Fields {
+		path := strings.Split(strings.ToLower(strings.TrimSpace(field)), ".")
+		for i, segment := range path {
+			if segment == "" || segment == "$" && (i > 0 || len(path) == 1) {
+				return nil, fmt.Errorf("invalid redaction field %q", field)
+			}
+		}
+		r.fields = append(r.fields, path)
+	}
+	for _, secret := range rules.Secrets {
+		r.AddSecret(secret)
+	}
+	return r, nil
+}
+
+// AddSecret masks value wherever it appears from now on
+func (r *Redactor) AddSecret(value string) {
+	if r == nil || len(value) < minSecretLength {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if contains(r.secrets, value) {
+		return
+	}
+	r.secrets = append(r.secrets, value)
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// String masks secrets and pattern matches in text
+func (r *Redactor) String(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, r.mask)
+	}
+	for _, re := range r.patterns {
+		text = r.maskPattern(re, text)
+	}
+	return text
+}
+
+// maskPattern masks the matches of a pattern, or only their capture groups
+// when it has any
+func (r *Redactor) maskPattern(re *regexp.Regexp, text string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(text, r.mask)
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(text, -1) {
+		for g := 2; g < len(match); g += 2 {
+			start, end := match[g], match[g+1]
+			if start < last || start == end {
+				continue
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(r.mask)
+			last = end
+		}
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Value returns a copy of a structured value with secret fields masked and
+// secrets masked in all strings
+func (r *Redactor) Value(value interface{}) interface{} {
+	if r == nil {
+		return value
+	}
+	return r.value(nil, value)
+}
+
+func (r *Redactor) value(path []string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		masked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			child := append(path[:len(path):len(path)], strings.ToLower(key))
+			if item != nil && item != "" && r.secretField(child) {
+				masked[key] = r.mask
+			} else {
+				masked[key] = r.value(child, item)
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = r.value(append(path[:len(path):len(path)], "*"), item)
+		}
+		return masked
+	case []string:
+		if v == nil {
+			return v
+		}
+		masked := make([]string, len(v))
+		for i, item := range v {
+			masked[i] = r.String(item)
+		}
+		return masked
+	case string:
+		return r.String(v)
+	}
+	return value
+}
+
+// secretField reports whether a value's path matches a field rule
+func (r *Redactor) secretField(path []string) bool {
+	for _, rule := range r.fields {
+		anchored := rule[0] == "$"
+		if anchored {
+			rule = rule[1:]
+			if len(rule) != len(path) {
+				continue
+			}
+		} else if len(rule) > len(path) {
+			continue
+		}
+		offset := len(path) - len(rule)
+		matched := true
+		for i, segment := range rule {
+			if segment != "*" && segment != path[offset+i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Error masks secrets in an error's message
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	if message := r.String(err.Error()); message != err.Error() {
+		return errors.New(message)
+	}
+	return err
+}
+
+// Result returns a copy of a task result with secrets masked in its
+// message, data, error, diff and captured state
+func (r *Redactor) Result(result *types.Result) *types.Result {
+	if r == nil || result == nil {
+		return result
+	}
+	masked := *result
+	masked.Message = r.String(result.Message)
+	masked.Error = r.Error(result.Error)
+	if result.Data != nil {
+		masked.Data = r.Value(result.Data).(map[string]interface{})
+	}
+	if result.Diff != nil {
+		diff := *result.Diff
+		diff.Before = r.String(diff.Before)
+		diff.After = r.String(diff.After)
+		diff.Diff = r.String(diff.Diff)
+		diff.BeforeLines = r.Value(diff.BeforeLines).([]string)
+		diff.AfterLines = r.Value(diff.AfterLines).([]string)
+		masked.Diff = &diff
+	}
+	if result.State != nil {
+		masked.State = &types.StateCapture{
+			Before: r.Value(result.State.Before),
+			After:  r.Value(result.State.After),
+		}
+	}
+	return &masked
+}
+
+// StreamEvent returns a copy of an event with secrets masked in its output,
+// error, progress, step and result
+func (r *Redactor) StreamEvent(event types.StreamEvent) types.StreamEvent {
+	if r == nil {
+		return event
+	}
+	event.Data = r.String(event.Data)
+	event.Error = r.Error(event.Error)
+	event.Result = r.Result(event.Result)
+	event.Step = r.Step(event.Step)
+	event.Progress = r.Progress(event.Progress)
+	return event
+}
+
+// Progress returns a copy of progress information with secrets masked in
+// its message and current step
+func (r *Redactor) Progress(progress *types.ProgressInfo) *types.ProgressInfo {
+	if r == nil || progress == nil {
+		return progress
+	}
+	masked := *progress
+	masked.Message = r.String(progress.Message)
+	masked.CurrentStep = r.Step(progress.CurrentStep)
+	return &masked
+}
+
+// Step returns a copy of a step with secrets masked in its name,
+// description and metadata
+func (r *Redactor) Step(step *types.StepInfo) *types.StepInfo {
+	if r == nil || step == nil {
+		return step
+	}
+	masked := *step
+	masked.Name = r.String(step.Name)
+	masked.Description = r.String(step.Description)
+	if step.Metadata != nil {
+		masked.Metadata = r.Value(step.Metadata).(map[string]interface{})
+	}
+	return &masked
+}
+
+// Entry returns a copy of a log entry with secrets masked
+func (r *Redactor) Entry(entry LogEntry) LogEntry {
+	if r == nil {
+		return entry
+	}
+	entry.Message = r.String(entry.Message)
+	entry.Error = r.String(entry.Error)
+	entry.StackTrace = r.String(entry.StackTrace)
+	if entry.Fields != nil {
+		entry.Fields = r.Value(entry.Fields).(map[string]interface{})
+	}
+	if entry.StreamEvent != nil {
+		event := r.StreamEvent(*entry.StreamEvent)
+		entry.StreamEvent = &event
+	}
+	entry.StepInfo = r.Step(entry.StepInfo)
+	entry.Progress = r.Progress(entry.Progress)
+	return entry
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
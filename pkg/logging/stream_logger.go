@@ -71,6 +71,7 @@ type StreamLogger struct {
 	sessionID string
 	source    string
 	enabled   bool
+	redactor  *Redactor
 
 	// Filtering options
 	includeSteps    bool
@@ -210,6 +211,13 @@ func (l *StreamLogger) SetFilters(steps, progress, output, errors bool) {
 	l.includeErrors = errors
 }
 
+// SetRedactor masks secrets in every entry before it reaches any output
+func (l *StreamLogger) SetRedactor(redactor *Redactor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redactor = redactor
+}
+
 // Enable/Disable logging
 func (l *StreamLogger) SetEnabled(enabled bool) {
 	l.mu.Lock()
@@ -240,6 +248,7 @@ func (l *StreamLogger) LogStreamEvent(event types.StreamEvent, taskName, host st
 	if !l.enabled {
 		return
 	}
+	event = types.CensorStreamEvent(event)
 
 	// Apply filters
 	switch event.Type {
@@ -405,6 +414,11 @@ func (l *StreamLogger) shouldLog(level LogLevel) bool {
 }
 
 func (l *StreamLogger) writeEntry(entry LogEntry) {
+	l.mu.RLock()
+	redactor := l.redactor
+	l.mu.RUnlock()
+	entry = redactor.Entry(entry)
+
 	l.bufferMu.Lock()
 	defer l.bufferMu.Unlock()
 
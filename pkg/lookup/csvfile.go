@@ -0,0 +1,110 @@
+package lookup
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CSVFileLookup reads a value from a CSV file, matching the key against
+// the first column
+type CSVFileLookup struct {
+	basePath string
+}
+
+// NewCSVFileLookup creates a new csvfile lookup plugin
+func NewCSVFileLookup() *CSVFileLookup {
+	return &CSVFileLookup{
+		basePath: ".",
+	}
+}
+
+// Name returns "csvfile"
+func (cl *CSVFileLookup) Name() string {
+	return "csvfile"
+}
+
+// SetOptions sets csvfile lookup options
+func (cl *CSVFileLookup) SetOptions(options map[string]interface{}) error {
+	if basePath, ok := options["basepath"].(string); ok {
+		cl.basePath = basePath
+	}
+	return nil
+}
+
+// Lookup returns the requested column of the row for each key. Terms take
+// the options file (ansible.csv), delimiter (TAB), col (1) and default.
+func (cl *CSVFileLookup) Lookup(ctx context.Context, terms []string, variables map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+
+	for _, term := range terms {
+		key, options := parseTerm(term)
+
+		file := optionOr(options, "file", "ansible.csv")
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cl.basePath, file)
+		}
+		delimiter := optionOr(options, "delimiter", "TAB")
+		if delimiter == "TAB" || delimiter == `\t` {
+			delimiter = "\t"
+		}
+		if len([]rune(delimiter)) != 1 {
+			return nil, fmt.Errorf("csvfile delimiter must be a single character, got '%s'", delimiter)
+		}
+		col, err := strconv.Atoi(optionOr(options, "col", "1"))
+		if err != nil || col < 0 {
+			return nil, fmt.Errorf("invalid csvfile column '%s'", options["col"])
+		}
+
+		value, err := readCSVValue(file, key, []rune(delimiter)[0], col)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			value = optionOr(options, "default", "")
+		}
+		results = append(results, value)
+	}
+
+	return results, nil
+}
+
+// readCSVValue returns column col of the first row whose first column is
+// key, or nil when there is no such row
+func readCSVValue(file, key string, delimiter rune, col int) (interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv file '%s': %w", file, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csv file '%s': %w", file, err)
+	}
+
+	for _, record := range records {
+		if len(record) == 0 || record[0] != key {
+			continue
+		}
+		if col >= len(record) {
+			return nil, fmt.Errorf("csv file '%s' has no column %d for '%s'", file, col, key)
+		}
+		return record[col], nil
+	}
+	return nil, nil
+}
+
+// optionOr returns a term option or its default
+func optionOr(options map[string]string, name, defaultValue string) string {
+	if value, ok := options[name]; ok {
+		return value
+	}
+	return defaultValue
+}
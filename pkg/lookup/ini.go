@@ -0,0 +1,126 @@
+package lookup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// INILookup reads values from INI and Java properties files
+type INILookup struct {
+	basePath string
+}
+
+// NewINILookup creates a new ini lookup plugin
+func NewINILookup() *INILookup {
+	return &INILookup{
+		basePath: ".",
+	}
+}
+
+// Name returns "ini"
+func (il *INILookup) Name() string {
+	return "ini"
+}
+
+// SetOptions sets ini lookup options
+func (il *INILookup) SetOptions(options map[string]interface{}) error {
+	if basePath, ok := options["basepath"].(string); ok {
+		il.basePath = basePath
+	}
+	return nil
+}
+
+// Lookup returns the value of each key. Terms take the options file
+// (ansible.ini), section (global), type (ini or properties), default, and
+// re=true to treat the key as a regular expression returning every match.
+func (il *INILookup) Lookup(ctx context.Context, terms []string, variables map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+
+	for _, term := range terms {
+		key, options := parseTerm(term)
+
+		file := optionOr(options, "file", "ansible.ini")
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(il.basePath, file)
+		}
+		section := optionOr(options, "section", "global")
+		fileType := optionOr(options, "type", "ini")
+		if fileType != "ini" && fileType != "properties" {
+			return nil, fmt.Errorf("invalid ini lookup type '%s'", fileType)
+		}
+
+		values, err := readINIValues(file, fileType == "properties")
+		if err != nil {
+			return nil, err
+		}
+		if fileType == "properties" {
+			section = ""
+		}
+
+		if optionOr(options, "re", "false") == "true" {
+			re, err := regexp.Compile(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ini key pattern '%s': %w", key, err)
+			}
+			for _, entry := range values[section] {
+				if re.MatchString(entry[0]) {
+					results = append(results, entry[1])
+				}
+			}
+			continue
+		}
+
+		value := optionOr(options, "default", "")
+		for _, entry := range values[section] {
+			if entry[0] == key {
+				value = entry[1]
+			}
+		}
+		results = append(results, value)
+	}
+
+	return results, nil
+}
+
+// readINIValues returns the key/value pairs of each section in file order.
+// Properties files have no sections and also accept ':' separators.
+func readINIValues(file string, properties bool) (map[string][][2]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ini file '%s': %w", file, err)
+	}
+	defer f.Close()
+
+	values := make(map[string][][2]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || (properties && strings.HasPrefix(line, "!")) {
+			continue
+		}
+		if !properties && strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		sep := strings.IndexByte(line, '=')
+		if properties {
+			sep = strings.IndexAny(line, "=:")
+		}
+		if sep < 0 {
+			values[section] = append(values[section], [2]string{line, ""})
+			continue
+		}
+		values[section] = append(values[section], [2]string{strings.TrimSpace(line[:sep]), strings.TrimSpace(line[sep+1:])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ini file '%s': %w", file, err)
+	}
+	return values, nil
+}
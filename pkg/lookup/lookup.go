@@ -6,8 +6,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -43,6 +45,8 @@ func NewLookupManager() *LookupManager {
 	lm.Register(NewURLLookup())
 	lm.Register(NewPipeLookup())
 	lm.Register(NewTemplateLookup())
+	lm.Register(NewCSVFileLookup())
+	lm.Register(NewINILookup())
 	
 	return lm
 }
@@ -77,6 +81,21 @@ func (lm *LookupManager) Lookup(ctx context.Context, pluginName string, terms []
 	return plugin.Lookup(ctx, terms, variables)
 }
 
+// parseTerm splits a term such as "key file=users.csv delimiter=," into
+// its first word and its key=value options
+func parseTerm(term string) (string, map[string]string) {
+	options := make(map[string]string)
+	var key []string
+	for _, field := range strings.Fields(term) {
+		if name, value, ok := strings.Cut(field, "="); ok && len(key) > 0 {
+			options[name] = value
+			continue
+		}
+		key = append(key, field)
+	}
+	return strings.Join(key, " "), options
+}
+
 // FileLookup reads file contents
 type FileLookup struct {
 	basePath string
@@ -185,29 +204,41 @@ func (pl *PasswordLookup) Lookup(ctx context.Context, terms []string, variables
 	}
 	
 	for _, term := range terms {
-		parts := strings.Split(term, " ")
-		passwordFile := filepath.Join(pl.passwordDir, parts[0])
+		path, options := parseTerm(term)
+		passwordFile := path
+		if !filepath.IsAbs(passwordFile) {
+			passwordFile = filepath.Join(pl.passwordDir, passwordFile)
+		}
 		
 		// Parse options from term
 		length := pl.length
-		for _, part := range parts[1:] {
-			if strings.HasPrefix(part, "length=") {
-				fmt.Sscanf(part, "length=%d", &length)
+		if value, ok := options["length"]; ok {
+			if _, err := fmt.Sscanf(value, "%d", &length); err != nil || length <= 0 {
+				return nil, fmt.Errorf("invalid password length '%s'", value)
 			}
 		}
+		chars := pl.chars
+		if value, ok := options["chars"]; ok && value != "" {
+			chars = value
+		}
 		
-		// Check if password file exists
+		// An existing password is returned unchanged, so repeated runs agree
 		if content, err := os.ReadFile(passwordFile); err == nil {
 			results = append(results, strings.TrimSpace(string(content)))
 			continue
 		}
 		
 		// Generate new password
-		password := pl.generatePassword(length)
+		password := generatePassword(length, chars)
 		
-		// Save password to file
-		if err := os.WriteFile(passwordFile, []byte(password), 0600); err != nil {
-			return nil, fmt.Errorf("failed to save password: %w", err)
+		// Save password to file, except for /dev/null which always generates
+		if passwordFile != os.DevNull {
+			if err := os.MkdirAll(filepath.Dir(passwordFile), 0700); err != nil {
+				return nil, fmt.Errorf("failed to create password directory: %w", err)
+			}
+			if err := os.WriteFile(passwordFile, []byte(password), 0600); err != nil {
+				return nil, fmt.Errorf("failed to save password: %w", err)
+			}
 		}
 		
 		results = append(results, password)
@@ -216,15 +247,17 @@ func (pl *PasswordLookup) Lookup(ctx context.Context, terms []string, variables
 	return results, nil
 }
 
-// generatePassword generates a random password
-func (pl *PasswordLookup) generatePassword(length int) string {
+// generatePassword generates a random password from the given characters
+func generatePassword(length int, chars string) string {
 	b := make([]byte, length)
-	charLen := len(pl.chars)
+	charLen := big.NewInt(int64(len(chars)))
 	
 	for i := range b {
-		randByte := make([]byte, 1)
-		rand.Read(randByte)
-		b[i] = pl.chars[int(randByte[0])%charLen]
+		n, err := rand.Int(rand.Reader, charLen)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		b[i] = chars[n.Int64()]
 	}
 	
 	return string(b)
@@ -352,7 +385,7 @@ func (ul *URLLookup) Lookup(ctx context.Context, terms []string, variables map[s
 	return results, nil
 }
 
-// PipeLookup executes commands and returns their output
+// PipeLookup executes commands on the controller and returns their output
 type PipeLookup struct {
 	executable string
 }
@@ -382,19 +415,29 @@ func (pl *PipeLookup) Lookup(ctx context.Context, terms []string, variables map[
 	results := make([]interface{}, 0, len(terms))
 	
 	for _, command := range terms {
-		// Execute command using connection
-		// This would integrate with the connection plugin system
-		// For now, return a placeholder
-		results = append(results, fmt.Sprintf("Output of: %s", command))
+		var stderr strings.Builder
+		cmd := exec.CommandContext(ctx, pl.executable, "-c", command)
+		cmd.Stderr = &stderr
+		
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("command '%s' failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		
+		results = append(results, strings.TrimRight(string(output), "\n"))
 	}
 	
 	return results, nil
 }
 
+// Renderer renders template source with variables
+type Renderer func(source string, variables map[string]interface{}) (string, error)
+
 // TemplateLookup renders templates
 type TemplateLookup struct {
 	basePath string
 	convert  bool
+	renderer Renderer
 }
 
 // NewTemplateLookup creates a new template lookup plugin
@@ -421,6 +464,12 @@ func (tl *TemplateLookup) SetOptions(options map[string]interface{}) error {
 	return nil
 }
 
+// SetRenderer sets the template engine used to render templates. Without
+// one the raw template source is returned.
+func (tl *TemplateLookup) SetRenderer(renderer Renderer) {
+	tl.renderer = renderer
+}
+
 // Lookup renders templates and returns the result
 func (tl *TemplateLookup) Lookup(ctx context.Context, terms []string, variables map[string]interface{}) ([]interface{}, error) {
 	results := make([]interface{}, 0, len(terms))
@@ -437,9 +486,16 @@ func (tl *TemplateLookup) Lookup(ctx context.Context, terms []string, variables
 			return nil, fmt.Errorf("failed to read template '%s': %w", templatePath, err)
 		}
 		
-		// TODO: Integrate with template engine
-		// For now, return raw content
-		results = append(results, string(content))
+		if tl.renderer == nil {
+			results = append(results, string(content))
+			continue
+		}
+		
+		rendered, err := tl.renderer(string(content), variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render template '%s': %w", templatePath, err)
+		}
+		results = append(results, rendered)
 	}
 	
 	return results, nil
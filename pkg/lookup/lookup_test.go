@@ -132,6 +132,19 @@ func TestPasswordLookup(t *testing.T) {
 	if len(password3) != 30 {
 		t.Errorf("Expected password length 30, got %d", len(password3))
 	}
+	
+	// Absolute paths and custom characters
+	absFile := filepath.Join(tmpDir, "creds", "db")
+	results, err = lookup.Lookup(ctx, []string{absFile + " length=8 chars=xy"}, nil)
+	if err != nil {
+		t.Fatalf("Password generation with chars failed: %v", err)
+	}
+	if password := results[0].(string); len(password) != 8 || strings.Trim(password, "xy") != "" {
+		t.Errorf("Expected 8 characters of x and y, got '%s'", password)
+	}
+	if _, err := os.Stat(absFile); err != nil {
+		t.Errorf("Expected password to be stored at %s: %v", absFile, err)
+	}
 }
 
 func TestEnvLookup(t *testing.T) {
@@ -247,9 +260,12 @@ func TestPipeLookup(t *testing.T) {
 		t.Fatalf("Expected 2 results, got %d", len(results))
 	}
 	
-	// Check placeholder output (actual implementation would execute commands)
-	if !strings.Contains(results[0].(string), "echo hello") {
-		t.Errorf("Expected command in output, got '%v'", results[0])
+	if results[0] != "hello" {
+		t.Errorf("Expected command output 'hello', got '%v'", results[0])
+	}
+	
+	if _, err := lookup.Lookup(ctx, []string{"echo oops >&2; exit 3"}, nil); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected failing command error with stderr, got %v", err)
 	}
 }
 
@@ -280,10 +296,91 @@ func TestTemplateLookup(t *testing.T) {
 		t.Fatalf("Expected 1 result, got %d", len(results))
 	}
 	
-	// For now, returns raw content (would need template engine integration)
+	// Without a renderer the raw template is returned
 	if results[0] != templateContent {
 		t.Errorf("Expected template content, got '%v'", results[0])
 	}
+	
+	lookup.SetRenderer(func(source string, vars map[string]interface{}) (string, error) {
+		return strings.ReplaceAll(source, "{{ name }}", vars["name"].(string)), nil
+	})
+	results, err = lookup.Lookup(ctx, []string{"template.j2"}, variables)
+	if err != nil {
+		t.Fatalf("Template lookup failed: %v", err)
+	}
+	if results[0] != "Hello World!" {
+		t.Errorf("Expected rendered template, got '%v'", results[0])
+	}
+}
+
+func TestCSVFileLookup(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvFile := filepath.Join(tmpDir, "users.csv")
+	if err := os.WriteFile(csvFile, []byte("alice,1001,admin\nbob,1002,dev\n"), 0644); err != nil {
+		t.Fatalf("Failed to create csv file: %v", err)
+	}
+	
+	lookup := NewCSVFileLookup()
+	lookup.SetOptions(map[string]interface{}{"basepath": tmpDir})
+	ctx := context.Background()
+	
+	results, err := lookup.Lookup(ctx, []string{
+		"bob file=users.csv delimiter=,",
+		"alice file=users.csv delimiter=, col=2",
+		"carol file=users.csv delimiter=, default=nobody",
+	}, nil)
+	if err != nil {
+		t.Fatalf("csvfile lookup failed: %v", err)
+	}
+	expected := []interface{}{"1002", "admin", "nobody"}
+	for i, want := range expected {
+		if results[i] != want {
+			t.Errorf("Expected '%v', got '%v'", want, results[i])
+		}
+	}
+	
+	if _, err := lookup.Lookup(ctx, []string{"bob file=users.csv delimiter=, col=5"}, nil); err == nil {
+		t.Error("Expected error for missing column")
+	}
+}
+
+func TestINILookup(t *testing.T) {
+	tmpDir := t.TempDir()
+	iniContent := "; users\n[global]\nuser = root\n\n[db]\nuser = postgres\nport=5432\npool_min = 1\npool_max = 10\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "app.ini"), []byte(iniContent), 0644); err != nil {
+		t.Fatalf("Failed to create ini file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "app.properties"), []byte("# java\ndb.user: app\ndb.port=5433\n"), 0644); err != nil {
+		t.Fatalf("Failed to create properties file: %v", err)
+	}
+	
+	lookup := NewINILookup()
+	lookup.SetOptions(map[string]interface{}{"basepath": tmpDir})
+	ctx := context.Background()
+	
+	results, err := lookup.Lookup(ctx, []string{
+		"user file=app.ini",
+		"user section=db file=app.ini",
+		"missing section=db file=app.ini default=none",
+		"db.user type=properties file=app.properties",
+	}, nil)
+	if err != nil {
+		t.Fatalf("ini lookup failed: %v", err)
+	}
+	expected := []interface{}{"root", "postgres", "none", "app"}
+	for i, want := range expected {
+		if results[i] != want {
+			t.Errorf("Expected '%v', got '%v'", want, results[i])
+		}
+	}
+	
+	results, err = lookup.Lookup(ctx, []string{"pool_.* section=db file=app.ini re=true"}, nil)
+	if err != nil {
+		t.Fatalf("ini regex lookup failed: %v", err)
+	}
+	if len(results) != 2 || results[0] != "1" || results[1] != "10" {
+		t.Errorf("Expected both pool values, got %v", results)
+	}
 }
 
 func TestConsulLookup(t *testing.T) {
@@ -0,0 +1,423 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// aclTypes maps the entry types and their short forms, as setfacl accepts
+// them, to the names getfacl prints
+var aclTypes = map[string]string{
+	"u": "user", "user": "user",
+	"g": "group", "group": "group",
+	"m": "mask", "mask": "mask",
+	"o": "other", "other": "other",
+}
+
+// ACLModule manages POSIX ACL entries of files with setfacl
+type ACLModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewACLModule creates a new acl module instance
+func NewACLModule() *ACLModule {
+	doc := types.ModuleDoc{
+		Name:        "acl",
+		Description: "Set, remove or read POSIX ACL entries of files with setfacl and getfacl, comparing the entry's permissions so unchanged entries are left alone",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "File or directory whose ACL is managed",
+				Required:    true,
+				Type:        "path",
+			},
+			"entity": {
+				Description: "User or group the entry is for; empty for the owner, owning group, mask and other entries",
+				Required:    false,
+				Type:        "string",
+			},
+			"etype": {
+				Description: "Type of the entry",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"user", "group", "mask", "other"},
+			},
+			"permissions": {
+				Description: "Permissions of the entry as any of r, w and x, e.g. rw or r-x; required when state is present",
+				Required:    false,
+				Type:        "string",
+			},
+			"entry": {
+				Description: "The entry in setfacl's short form instead of etype, entity and permissions, e.g. u:deploy:rwx or default:g:web:r-x",
+				Required:    false,
+				Type:        "string",
+			},
+			"default": {
+				Description: "Manage the default ACL of a directory, inherited by the files created in it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"state": {
+				Description: "Whether the entry exists, or query to only read the ACL",
+				Required:    false,
+				Type:        "string",
+				Default:     "query",
+				Choices:     []string{"present", "absent", "query"},
+			},
+			"recursive": {
+				Description: "Apply the entry to every file under path; with default, to every directory",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"follow": {
+				Description: "Follow symbolic links, both path and those met when recursing",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"recalculate_mask": {
+				Description: "Whether setfacl recalculates the mask after changing an entry: its default, always with mask, or never with no_mask",
+				Required:    false,
+				Type:        "string",
+				Default:     "default",
+				Choices:     []string{"default", "mask", "no_mask"},
+			},
+		},
+		Examples: []string{
+			"- name: Let the deploy user write the releases\n  acl:\n    path: /srv/app/releases\n    entity: deploy\n    etype: user\n    permissions: rwx\n    state: present",
+			"- name: Give the web group read access to new files\n  acl:\n    path: /srv/app/shared\n    entry: default:group:www-data:r-x\n    state: present",
+			"- name: Revoke a contractor's access\n  acl:\n    path: /srv/app\n    entity: contractor\n    etype: user\n    recursive: true\n    state: absent",
+		},
+		Returns: map[string]string{
+			"path": "The file whose ACL is managed",
+			"acl":  "ACL entries of path after the run, as getfacl prints them",
+		},
+	}
+
+	base := NewBaseModule("acl", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: false,
+	})
+
+	return &ACLModule{BaseModule: base}
+}
+
+// aclEntry is an ACL entry, as in default:user:deploy:rwx
+type aclEntry struct {
+	dflt        bool
+	etype       string
+	entity      string
+	permissions string
+}
+
+// key identifies the entry in an ACL, leaving out its permissions
+func (e aclEntry) key() string {
+	key := e.etype + ":" + e.entity
+	if e.dflt {
+		key = "default:" + key
+	}
+	return key
+}
+
+// String returns the entry as getfacl prints it
+func (e aclEntry) String() string {
+	return e.key() + ":" + e.permissions
+}
+
+// normalizeACLPermissions turns permissions given as any of r, w, x and -
+// into the rwx form getfacl prints, e.g. "xr" into "r-x"
+func normalizeACLPermissions(value string) (string, error) {
+	perms := []byte("---")
+	for _, c := range value {
+		switch c {
+		case 'r':
+			perms[0] = 'r'
+		case 'w':
+			perms[1] = 'w'
+		case 'x':
+			perms[2] = 'x'
+		case '-':
+		default:
+			return "", fmt.Errorf("invalid permission %q in %q", c, value)
+		}
+	}
+	return string(perms), nil
+}
+
+// parseACLEntry parses an entry in setfacl's short form, its permissions
+// optional
+func parseACLEntry(value string) (aclEntry, error) {
+	var entry aclEntry
+	fields := strings.Split(value, ":")
+	if len(fields) > 0 && (fields[0] == "d" || fields[0] == "default") {
+		entry.dflt = true
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return entry, fmt.Errorf("invalid ACL entry %q", value)
+	}
+	etype, ok := aclTypes[fields[0]]
+	if !ok {
+		return entry, fmt.Errorf("invalid ACL entry type in %q", value)
+	}
+	entry.etype, entry.entity = etype, fields[1]
+	if len(fields) == 3 {
+		perms, err := normalizeACLPermissions(fields[2])
+		if err != nil {
+			return entry, err
+		}
+		entry.permissions = perms
+	}
+	return entry, nil
+}
+
+// entry returns the entry the arguments describe
+func (m *ACLModule) entry(args map[string]interface{}) (aclEntry, error) {
+	if value := m.GetStringArg(args, "entry", ""); value != "" {
+		entry, err := parseACLEntry(value)
+		entry.dflt = entry.dflt || m.GetBoolArg(args, "default", false)
+		return entry, err
+	}
+	entry := aclEntry{
+		dflt:   m.GetBoolArg(args, "default", false),
+		etype:  m.GetStringArg(args, "etype", ""),
+		entity: m.GetStringArg(args, "entity", ""),
+	}
+	if permissions := m.GetStringArg(args, "permissions", ""); permissions != "" {
+		perms, err := normalizeACLPermissions(permissions)
+		if err != nil {
+			return entry, err
+		}
+		entry.permissions = perms
+	}
+	return entry, nil
+}
+
+// Validate validates the module arguments
+func (m *ACLModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"path"}); err != nil {
+		return err
+	}
+	for param, choices := range map[string][]string{
+		"etype":            {"user", "group", "mask", "other"},
+		"state":            {"present", "absent", "query"},
+		"recalculate_mask": {"default", "mask", "no_mask"},
+	} {
+		if err := m.ValidateChoices(args, param, choices); err != nil {
+			return err
+		}
+	}
+
+	state := m.GetStringArg(args, "state", "query")
+	if m.GetStringArg(args, "entry", "") != "" && (m.GetStringArg(args, "etype", "") != "" || m.GetStringArg(args, "entity", "") != "" || m.GetStringArg(args, "permissions", "") != "") {
+		return types.NewValidationError("entry", nil, "entry is mutually exclusive with etype, entity and permissions")
+	}
+	entry, err := m.entry(args)
+	if err != nil {
+		return types.NewValidationError("entry", m.GetStringArg(args, "entry", ""), err.Error())
+	}
+	if state == "query" {
+		return nil
+	}
+	if entry.etype == "" {
+		return types.NewValidationError("etype", nil, "etype or entry is required when state is "+state)
+	}
+	if state == "present" && entry.permissions == "" {
+		return types.NewValidationError("permissions", nil, "permissions are required when state is present")
+	}
+	if state == "absent" && entry.entity == "" && entry.etype != "mask" {
+		return types.NewValidationError("entity", nil, "the owner, owning group and other entries cannot be removed")
+	}
+	if (entry.etype == "mask" || entry.etype == "other") && entry.entity != "" {
+		return types.NewValidationError("entity", entry.entity, entry.etype+" entries have no entity")
+	}
+	return nil
+}
+
+// Run executes the acl module
+func (m *ACLModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	path := m.GetStringArg(args, "path", "")
+	state := m.GetStringArg(args, "state", "query")
+	recursive := m.GetBoolArg(args, "recursive", false)
+	entry, err := m.entry(args)
+	if err != nil {
+		return nil, err
+	}
+
+	acls, err := m.read(ctx, conn, path, recursive && state != "query", entry.dflt, m.GetBoolArg(args, "follow", true))
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the entry on every file it applies to
+	var before, after strings.Builder
+	var files []string
+	for _, file := range sortedACLPaths(acls) {
+		current, exists := acls[file][entry.key()]
+		var wanted string
+		switch {
+		case state == "present" && current != entry.permissions:
+			wanted = entry.permissions
+		case state == "absent" && exists:
+		default:
+			continue
+		}
+		files = append(files, file)
+		if exists {
+			fmt.Fprintf(&before, "%s: %s:%s\n", file, entry.key(), current)
+		}
+		if wanted != "" {
+			fmt.Fprintf(&after, "%s: %s:%s\n", file, entry.key(), wanted)
+		}
+	}
+
+	change := ""
+	if len(files) > 0 {
+		what := fmt.Sprintf("set the ACL entry %s on %s", entry, path)
+		if state == "absent" {
+			what = fmt.Sprintf("removed the ACL entry %s from %s", entry.key(), path)
+		}
+		if recursive {
+			what += fmt.Sprintf(" (%d files)", len(files))
+		}
+		change = what
+		if !checkMode {
+			if _, err := m.cli.run(ctx, conn, "changing the ACL of "+path, m.setfacl(args, entry, state, path)); err != nil {
+				return nil, err
+			}
+			if acls, err = m.read(ctx, conn, path, false, false, m.GetBoolArg(args, "follow", true)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	acl, ok := acls[path]
+	if !ok && len(acls) == 1 {
+		// getfacl may print the path cleaned up
+		for _, only := range acls {
+			acl = only
+		}
+	}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("ACL of %s is already in desired state", path), map[string]interface{}{
+		"path": path,
+		"acl":  aclListing(acl),
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// setfacl returns the command setting or removing the entry
+func (m *ACLModule) setfacl(args map[string]interface{}, entry aclEntry, state, path string) string {
+	cmd := []string{"setfacl"}
+	if m.GetBoolArg(args, "recursive", false) {
+		cmd = append(cmd, "-R")
+	}
+	if !m.GetBoolArg(args, "follow", true) {
+		cmd = append(cmd, "--physical")
+	}
+	switch m.GetStringArg(args, "recalculate_mask", "default") {
+	case "mask":
+		cmd = append(cmd, "--mask")
+	case "no_mask":
+		cmd = append(cmd, "--no-mask")
+	}
+	if state == "absent" {
+		cmd = append(cmd, "-x", m.cli.shellEscape(entry.key()))
+	} else {
+		cmd = append(cmd, "-m", m.cli.shellEscape(entry.String()))
+	}
+	return strings.Join(append(cmd, "--", m.cli.shellEscape(path)), " ")
+}
+
+// read returns the ACL entries of path, or with recursive of every file
+// under it, and with directories only of every directory, by file and key
+func (m *ACLModule) read(ctx context.Context, conn types.Connection, path string, recursive, directoriesOnly, follow bool) (map[string]map[string]string, error) {
+	cmd := "getfacl --absolute-names"
+	if !follow {
+		cmd += " --physical"
+	}
+	cmd += " -- " + m.cli.shellEscape(path)
+	if recursive {
+		find := "find"
+		if follow {
+			find = "find -L"
+		}
+		cmd = fmt.Sprintf("%s %s", find, m.cli.shellEscape(path))
+		if directoriesOnly {
+			cmd += " -type d"
+		}
+		cmd += " -exec getfacl --absolute-names {} +"
+	}
+
+	result, err := m.cli.run(ctx, conn, "reading the ACL of "+path, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGetfacl(types.ConvertToString(result.Data["stdout"])), nil
+}
+
+// parseGetfacl parses getfacl's output into the entries of each file by
+// key, leaving out the effective rights comments
+func parseGetfacl(output string) map[string]map[string]string {
+	acls := make(map[string]map[string]string)
+	var file map[string]string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if name, ok := strings.CutPrefix(line, "# file: "); ok {
+			file = make(map[string]string)
+			acls[name] = file
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || file == nil {
+			continue
+		}
+		if i := strings.IndexAny(line, " \t#"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.LastIndex(line, ":"); i > 0 {
+			file[line[:i]] = line[i+1:]
+		}
+	}
+	return acls
+}
+
+// sortedACLPaths returns the files of a getfacl listing in order
+func sortedACLPaths(acls map[string]map[string]string) []string {
+	paths := make([]string, 0, len(acls))
+	for file := range acls {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// aclListing returns the entries of an ACL as getfacl prints them, the
+// access entries first
+func aclListing(acl map[string]string) []string {
+	listing := make([]string, 0, len(acl))
+	for key, perms := range acl {
+		listing = append(listing, key+":"+perms)
+	}
+	sort.Slice(listing, func(i, j int) bool {
+		di, dj := strings.HasPrefix(listing[i], "default:"), strings.HasPrefix(listing[j], "default:")
+		if di != dj {
+			return dj
+		}
+		return listing[i] < listing[j]
+	})
+	return listing
+}
@@ -0,0 +1,156 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseACLEntry(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"u:deploy:rx", "user:deploy:r-x"},
+		{"default:group:www-data:wr", "default:group:www-data:rw-"},
+		{"m::r", "mask::r--"},
+		{"d:o::-", "default:other::---"},
+	}
+	for _, tt := range tests {
+		entry, err := parseACLEntry(tt.value)
+		if err != nil || entry.String() != tt.expected {
+			t.Errorf("parseACLEntry(%q) = %q (%v), expected %q", tt.value, entry.String(), err, tt.expected)
+		}
+	}
+	for _, value := range []string{"deploy:rx", "x:deploy:r", "u:deploy:rz", "u:a:b:c"} {
+		if _, err := parseACLEntry(value); err == nil {
+			t.Errorf("expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestParseGetfacl(t *testing.T) {
+	output := "# file: /srv/app\n# owner: root\n# group: root\n# flags: -s-\nuser::rwx\nuser:deploy:rwx\t\t#effective:r-x\ngroup::r-x\nmask::r-x\nother::---\ndefault:user::rwx\n\n" +
+		"# file: /srv/app/run.sh\nuser::rw-\ngroup::r--\nother::r--\n\n"
+	acls := parseGetfacl(output)
+	if paths := sortedACLPaths(acls); !reflect.DeepEqual(paths, []string{"/srv/app", "/srv/app/run.sh"}) {
+		t.Fatalf("unexpected files %v", paths)
+	}
+	expected := []string{"group::r-x", "mask::r-x", "other::---", "user::rwx", "user:deploy:rwx", "default:user::rwx"}
+	if listing := aclListing(acls["/srv/app"]); !reflect.DeepEqual(listing, expected) {
+		t.Errorf("expected %v, got %v", expected, listing)
+	}
+}
+
+func TestACLModule(t *testing.T) {
+	module := NewACLModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Query", Args: map[string]interface{}{"path": "/srv/app"}, ExpectValid: true},
+		{Name: "Present", Args: map[string]interface{}{"path": "/srv/app", "etype": "user", "entity": "deploy", "permissions": "rx", "state": "present"}, ExpectValid: true},
+		{Name: "Entry", Args: map[string]interface{}{"path": "/srv/app", "entry": "d:g:www-data:r", "state": "present"}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{"etype": "user"}, ExpectValid: false},
+		{Name: "EntryAndEtype", Args: map[string]interface{}{"path": "/srv/app", "entry": "u:deploy:r", "etype": "user", "state": "present"}, ExpectValid: false},
+		{Name: "MissingPermissions", Args: map[string]interface{}{"path": "/srv/app", "etype": "user", "entity": "deploy", "state": "present"}, ExpectValid: false},
+		{Name: "BadPermissions", Args: map[string]interface{}{"path": "/srv/app", "etype": "user", "entity": "deploy", "permissions": "rwz", "state": "present"}, ExpectValid: false},
+		{Name: "RemoveOwner", Args: map[string]interface{}{"path": "/srv/app", "etype": "user", "state": "absent"}, ExpectValid: false},
+		{Name: "OtherWithEntity", Args: map[string]interface{}{"path": "/srv/app", "etype": "other", "entity": "deploy", "permissions": "r", "state": "present"}, ExpectValid: false},
+	})
+
+	getfacl := "getfacl --absolute-names -- '/srv/app'"
+	acl := "# file: /srv/app\nuser::rwx\nuser:deploy:r-x\ngroup::r-x\nmask::r-x\nother::---\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "GrantWrite",
+			Args:     map[string]interface{}{"path": "/srv/app", "etype": "user", "entity": "deploy", "permissions": "rwx", "state": "present"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: acl})
+				h.GetConnection().ExpectCommand("setfacl -m 'user:deploy:rwx' -- '/srv/app'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: "# file: /srv/app\nuser::rwx\nuser:deploy:rwx\ngroup::r-x\nmask::rwx\nother::---\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the ACL entry user:deploy:rwx on /srv/app")
+				h.AssertDiffBefore(result, "/srv/app: user:deploy:r-x\n")
+				if value := result.Data["acl"]; !reflect.DeepEqual(value, []string{"group::r-x", "mask::rwx", "other::---", "user::rwx", "user:deploy:rwx"}) {
+					t.Errorf("unexpected acl %v", value)
+				}
+			},
+		},
+		{
+			Name: "AlreadyPresent",
+			Args: map[string]interface{}{"path": "/srv/app", "entry": "user:deploy:xr", "state": "present"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: acl})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "RemoveWithoutMask",
+			Args: map[string]interface{}{"path": "/srv/app", "etype": "user", "entity": "deploy", "state": "absent", "recalculate_mask": "no_mask", "follow": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("getfacl --absolute-names --physical -- '/srv/app'", &testhelper.CommandResponse{Stdout: acl})
+				h.GetConnection().ExpectCommand("setfacl --physical --no-mask -x 'user:deploy' -- '/srv/app'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("getfacl --absolute-names --physical -- '/srv/app'", &testhelper.CommandResponse{Stdout: acl})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed the ACL entry user:deploy from /srv/app")
+			},
+		},
+		{
+			Name: "RecursiveDefault",
+			Args: map[string]interface{}{"path": "/srv/app", "entry": "d:g:www-data:rx", "state": "present", "recursive": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("find -L '/srv/app' -type d -exec getfacl --absolute-names {} +", &testhelper.CommandResponse{
+					Stdout: "# file: /srv/app\nuser::rwx\ndefault:group:www-data:r-x\n\n# file: /srv/app/logs\nuser::rwx\n\n# file: /srv/app/tmp\nuser::rwx\n",
+				})
+				h.GetConnection().ExpectCommand("setfacl -R -m 'default:group:www-data:r-x' -- '/srv/app'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: "# file: /srv/app\nuser::rwx\ndefault:group:www-data:r-x\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the ACL entry default:group:www-data:r-x on /srv/app (2 files)")
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"path": "/srv/app", "etype": "group", "entity": "ops", "permissions": "r", "state": "present"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: acl})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "Query",
+			Args: map[string]interface{}{"path": "/srv/app"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{Stdout: acl})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				if value := result.Data["acl"]; !reflect.DeepEqual(value, []string{"group::r-x", "mask::r-x", "other::---", "user::rwx", "user:deploy:r-x"}) {
+					t.Errorf("unexpected acl %v", value)
+				}
+			},
+		},
+		{
+			Name:        "NoSuchFile",
+			Args:        map[string]interface{}{"path": "/srv/app"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand(getfacl, &testhelper.CommandResponse{ExitCode: 1, Stderr: "getfacl: /srv/app: No such file or directory"})
+			},
+		},
+	})
+}
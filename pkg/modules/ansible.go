@@ -0,0 +1,478 @@
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ansibleBuiltinPrefixes are the collection prefixes naming Ansible-core
+// modules, which are looked up by their short name
+var ansibleBuiltinPrefixes = []string{"ansible.builtin.", "ansible.legacy."}
+
+var (
+	// ansibleFromImport matches "from X import a, b", including relative
+	// imports and names in parentheses over several lines
+	ansibleFromImport = regexp.MustCompile(`(?m)^[ \t]*from[ \t]+(\.+[\w.]*|ansible\.module_utils[\w.]*)[ \t]+import[ \t]+(\([^)]*\)|[^\n#]+)`)
+	// ansibleImport matches "import ansible.module_utils.X"
+	ansibleImport = regexp.MustCompile(`(?m)^[ \t]*import[ \t]+(ansible\.module_utils[\w.]*)`)
+	// ansibleShortDescription matches the short description in a module's
+	// DOCUMENTATION
+	ansibleShortDescription = regexp.MustCompile(`(?m)^short_description:[ \t]*(.+)$`)
+)
+
+// AnsibleModules finds Ansible Python modules to run when a module is not
+// implemented natively, so that playbooks can use any module of
+// Ansible-core, or of a library directory, while native ones are written.
+// Python must be installed on the target hosts.
+type AnsibleModules struct {
+	// Root is the directory of the ansible Python package on the
+	// controller, holding the modules and module_utils of Ansible-core
+	Root string
+	// Library lists directories of further modules, searched before Root
+	Library []string
+}
+
+// Find returns the path of the Python module implementing name
+func (a *AnsibleModules) Find(name string) (string, bool) {
+	for _, prefix := range ansibleBuiltinPrefixes {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if name == "" || strings.ContainsAny(name, "./\\") {
+		return "", false
+	}
+
+	dirs := append([]string{}, a.Library...)
+	if a.Root != "" {
+		dirs = append(dirs, filepath.Join(a.Root, "modules"))
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name+".py")
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Load returns the module running the Python module implementing name,
+// or types.ErrModuleNotFound when there is none. It can serve as the
+// fallback of a ModuleRegistry.
+func (a *AnsibleModules) Load(name string) (types.Module, error) {
+	path, ok := a.Find(name)
+	if !ok {
+		return nil, types.ErrModuleNotFound
+	}
+	return NewAnsibleModule(name, path, a.Root)
+}
+
+// AnsibleModule runs an Ansible Python module on the target host. Like
+// Ansible's AnsiballZ, the module is sent in a zip archive together with
+// the module_utils it imports, then run by a Python wrapper giving it the
+// task's arguments. The JSON it prints becomes the task's result.
+type AnsibleModule struct {
+	*BaseModule
+	path    string
+	fqn     string
+	payload []byte
+}
+
+// NewAnsibleModule prepares the Python module at path to run as the module
+// name, resolving its module_utils under root, the directory of the
+// ansible Python package
+func NewAnsibleModule(name, path, root string) (*AnsibleModule, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Ansible module %s: %w", name, err)
+	}
+
+	short := strings.TrimSuffix(filepath.Base(path), ".py")
+	fqn := "ansible.modules." + short
+	files := map[string][]byte{
+		"ansible/__init__.py":              nil,
+		"ansible/modules/__init__.py":      nil,
+		"ansible/modules/" + short + ".py": source,
+	}
+	if root != "" {
+		if err := addModuleUtils(files, root, "ansible.modules", source); err != nil {
+			return nil, fmt.Errorf("failed to collect module_utils of Ansible module %s: %w", name, err)
+		}
+	}
+	payload, err := zipFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to package Ansible module %s: %w", name, err)
+	}
+
+	description := "Ansible module " + fqn
+	if match := ansibleShortDescription.FindSubmatch(source); match != nil {
+		description = strings.Trim(strings.TrimSpace(string(match[1])), `"'`)
+	}
+	doc := types.ModuleDoc{
+		Name:        name,
+		Description: description,
+		Returns: map[string]string{
+			"*": "The values the Ansible module returns",
+		},
+	}
+
+	base := NewBaseModule(name, doc)
+	// The Python module decides whether it supports check mode, and
+	// reports itself skipped when it does not
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+	return &AnsibleModule{BaseModule: base, path: path, fqn: fqn, payload: payload}, nil
+}
+
+// Path returns the Python module run
+func (m *AnsibleModule) Path() string {
+	return m.path
+}
+
+// Validate accepts any arguments, which the Python module validates itself
+func (m *AnsibleModule) Validate(args map[string]interface{}) error {
+	return nil
+}
+
+// Run copies the wrapper to the host, runs it with the interpreter of
+// ansible_python_interpreter and translates the module's JSON result
+func (m *AnsibleModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+	interpreter := m.GetTaskVar(args, "ansible_python_interpreter", "python3")
+
+	// Arguments starting with _ pass the modes of the task, which Ansible
+	// modules take as their own internal arguments
+	moduleArgs := make(map[string]interface{}, len(args)+8)
+	for key, value := range args {
+		if !strings.HasPrefix(key, "_") {
+			moduleArgs[key] = value
+		}
+	}
+	moduleArgs["_ansible_check_mode"] = checkMode
+	moduleArgs["_ansible_diff"] = diffMode
+	moduleArgs["_ansible_module_name"] = m.Name()
+	moduleArgs["_ansible_no_log"] = m.NoLog(args)
+	moduleArgs["_ansible_debug"] = false
+	moduleArgs["_ansible_verbosity"] = 0
+	moduleArgs["_ansible_keep_remote_files"] = false
+	moduleArgs["_ansible_shell_executable"] = "/bin/sh"
+
+	wrapper, err := m.wrapper(moduleArgs)
+	if err != nil {
+		return nil, types.NewModuleError(m.Name(), hostname, "failed to prepare the Ansible module", err)
+	}
+
+	// The wrapper holds the arguments, which may be secret, so it goes to
+	// a private temporary file mktemp names on the host
+	created, err := remoteCLI{}.run(ctx, conn, "creating a temporary file", "mktemp")
+	if err != nil {
+		return nil, types.NewModuleError(m.Name(), hostname, "failed to copy the Ansible module", err)
+	}
+	remotePath, _ := created.Data["stdout"].(string)
+	remotePath = strings.TrimSpace(remotePath)
+	if remotePath == "" {
+		return nil, types.NewModuleError(m.Name(), hostname, "failed to copy the Ansible module", fmt.Errorf("mktemp printed no path"))
+	}
+	quoted := remoteCLI{}.shellEscape(remotePath)
+	if err := conn.Copy(ctx, bytes.NewReader(wrapper), remotePath, 0600); err != nil {
+		conn.Execute(ctx, "rm -f "+quoted, types.ExecuteOptions{})
+		return nil, types.NewModuleError(m.Name(), hostname, "failed to copy the Ansible module", err)
+	}
+
+	cmd := fmt.Sprintf("%s %s; rc=$?; rm -f %s; exit $rc", interpreter, quoted, quoted)
+	execResult, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	if err != nil {
+		return nil, types.NewModuleError(m.Name(), hostname, "failed to run the Ansible module", err)
+	}
+	stdout, _ := execResult.Data["stdout"].(string)
+
+	output, err := ansibleModuleOutput(stdout)
+	if err != nil {
+		return nil, types.NewModuleError(m.Name(), hostname, "the Ansible module gave no result", fmt.Errorf("%w%s", err, stderrDetail(commandStderr(execResult))))
+	}
+
+	changed, _ := output["changed"].(bool)
+	failed, _ := output["failed"].(bool)
+	msg, _ := output["msg"].(string)
+	diff := output["diff"]
+	data := make(map[string]interface{}, len(output))
+	for key, value := range output {
+		switch key {
+		case "changed", "failed", "msg", "diff", "invocation":
+		default:
+			if !strings.HasPrefix(key, "_ansible") {
+				data[key] = value
+			}
+		}
+	}
+	if !failed && !execResult.Success {
+		failed = true
+		if msg == "" {
+			msg = fmt.Sprintf("the Ansible module exited with %v", execResult.Data["exit_code"])
+		}
+	}
+
+	var result *types.Result
+	if failed {
+		result = m.CreateResult(hostname, false, changed, msg, data, types.NewModuleError(m.Name(), hostname, msg, nil))
+	} else {
+		result = m.CreateSuccessResult(hostname, changed, msg, data)
+	}
+	if changed && checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+	if diffMode {
+		result.Diff = ansibleDiff(diff)
+	}
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// ansibleWrapper unpacks the zip archive of the module and its
+// module_utils, gives the module its arguments the way AnsiballZ does and
+// runs it as a script
+const ansibleWrapper = `#!/usr/bin/env python
+# Generated by gosible to run an Ansible module
+import base64
+import os
+import runpy
+import shutil
+import sys
+import tempfile
+
+PAYLOAD = %q
+ARGS = %q
+MODULE = %q
+
+
+def main():
+    tmpdir = tempfile.mkdtemp(prefix='gosible_ansible_')
+    modlib = os.path.join(tmpdir, 'modlib.zip')
+    try:
+        with open(modlib, 'wb') as f:
+            f.write(base64.b64decode(PAYLOAD))
+        sys.path.insert(0, modlib)
+        from ansible.module_utils import basic
+        basic._ANSIBLE_ARGS = base64.b64decode(ARGS)
+        runpy.run_module(mod_name=MODULE, init_globals=dict(_module_fqn=MODULE, _modlib_path=modlib),
+                         run_name='__main__', alter_sys=True)
+    finally:
+        shutil.rmtree(tmpdir, ignore_errors=True)
+
+
+if __name__ == '__main__':
+    main()
+`
+
+// wrapper renders the Python wrapper running the module with args
+func (m *AnsibleModule) wrapper(args map[string]interface{}) ([]byte, error) {
+	params, err := json.Marshal(map[string]interface{}{"ANSIBLE_MODULE_ARGS": args})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(ansibleWrapper,
+		base64.StdEncoding.EncodeToString(m.payload),
+		base64.StdEncoding.EncodeToString(params),
+		m.fqn)), nil
+}
+
+// ansibleModuleOutput parses the JSON an Ansible module prints, skipping
+// what comes before it, such as warnings of the interpreter
+func ansibleModuleOutput(stdout string) (map[string]interface{}, error) {
+	start := strings.Index(stdout, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON in the module output %q", strings.TrimSpace(stdout))
+	}
+	var output map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(stdout[start:]))
+	if err := decoder.Decode(&output); err != nil {
+		return nil, fmt.Errorf("invalid JSON in the module output: %w", err)
+	}
+	return output, nil
+}
+
+// ansibleDiff converts the diff an Ansible module returns, a before and
+// after pair, a prepared diff, or a list of those
+func ansibleDiff(value interface{}) *types.DiffResult {
+	var parts []map[string]interface{}
+	switch diff := value.(type) {
+	case map[string]interface{}:
+		parts = append(parts, diff)
+	case []interface{}:
+		for _, item := range diff {
+			if part, ok := item.(map[string]interface{}); ok {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	var before, after, prepared []string
+	for _, part := range parts {
+		if text, ok := part["prepared"].(string); ok {
+			prepared = append(prepared, text)
+			continue
+		}
+		before = append(before, ansibleDiffText(part["before"]))
+		after = append(after, ansibleDiffText(part["after"]))
+	}
+	return &types.DiffResult{
+		Before:   strings.Join(before, ""),
+		After:    strings.Join(after, ""),
+		Diff:     strings.Join(prepared, "\n"),
+		Prepared: true,
+	}
+}
+
+// ansibleDiffText renders one side of a diff, which modules may give as
+// structured data
+func ansibleDiffText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data) + "\n"
+	}
+}
+
+// addModuleUtils adds to files the module_utils that source, a file of
+// the package pkg, imports, and recursively theirs
+func addModuleUtils(files map[string][]byte, root, pkg string, source []byte) error {
+	var names []string
+	for _, match := range ansibleFromImport.FindAllSubmatch(source, -1) {
+		from := string(match[1])
+		if strings.HasPrefix(from, ".") {
+			dots := len(from) - len(strings.TrimLeft(from, "."))
+			parts := strings.Split(pkg, ".")
+			if dots-1 >= len(parts) {
+				continue
+			}
+			from = strings.Join(parts[:len(parts)-(dots-1)], ".")
+			if rest := strings.TrimLeft(string(match[1]), "."); rest != "" {
+				from += "." + rest
+			}
+		}
+		names = append(names, from)
+		// Imported names may themselves be modules
+		imported := strings.Trim(string(match[2]), "() \t\r\n")
+		for _, name := range strings.Split(imported, ",") {
+			if fields := strings.Fields(name); len(fields) > 0 && fields[0] != "*" {
+				names = append(names, from+"."+fields[0])
+			}
+		}
+	}
+	for _, match := range ansibleImport.FindAllSubmatch(source, -1) {
+		names = append(names, string(match[1]))
+	}
+
+	for _, name := range names {
+		if name != "ansible.module_utils" && !strings.HasPrefix(name, "ansible.module_utils.") {
+			continue
+		}
+		if err := addModuleUtil(files, root, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addModuleUtil adds the module or package name of module_utils, with the
+// packages holding it, unless it is already there. Names that are not
+// found are left out: they are not modules, or are optional imports the
+// module guards itself.
+func addModuleUtil(files map[string][]byte, root, name string) error {
+	parts := strings.Split(name, ".")
+	for i := 2; i < len(parts); i++ {
+		pkgInit := strings.Join(parts[:i], "/") + "/__init__.py"
+		if _, done := files[pkgInit]; done {
+			continue
+		}
+		if err := addPythonFile(files, root, pkgInit, strings.Join(parts[:i], ".")); err != nil {
+			return err
+		}
+	}
+
+	rel := strings.Join(parts, "/")
+	if _, done := files[rel+".py"]; done {
+		return nil
+	}
+	if _, done := files[rel+"/__init__.py"]; done {
+		return nil
+	}
+	if err := addPythonFile(files, root, rel+".py", strings.Join(parts[:len(parts)-1], ".")); err != nil {
+		return err
+	}
+	if _, found := files[rel+".py"]; found {
+		return nil
+	}
+	return addPythonFile(files, root, rel+"/__init__.py", name)
+}
+
+// addPythonFile adds the file at rel, relative to the directory holding
+// root, if it exists, and the module_utils it imports. pkg is the package
+// the file belongs to.
+func addPythonFile(files map[string][]byte, root, rel, pkg string) error {
+	source, err := os.ReadFile(filepath.Join(filepath.Dir(root), filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	files[rel] = source
+	return addModuleUtils(files, root, pkg, source)
+}
+
+// zipFiles archives files in a zip, in name order so that the archive is
+// the same for the same files
+func zipFiles(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
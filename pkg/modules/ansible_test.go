@@ -0,0 +1,235 @@
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// writeAnsibleTree writes a minimal ansible package with a hello module
+// importing module_utils, and returns its directory
+func writeAnsibleTree(t *testing.T) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "ansible")
+	files := map[string]string{
+		"__init__.py":                     "raise ImportError('not shipped')\n",
+		"module_utils/__init__.py":        "",
+		"module_utils/basic.py":           "import json\nfrom .common.text import (\n    to_text,\n)\n_ANSIBLE_ARGS = None\n",
+		"module_utils/common/__init__.py": "",
+		"module_utils/common/text.py":     "def to_text(b):\n    return b\n",
+		"module_utils/unused.py":          "",
+		"modules/hello.py": "DOCUMENTATION = r'''\nmodule: hello\nshort_description: Say hello\n'''\n" +
+			"from ansible.module_utils.basic import AnsibleModule\ntry:\n    from ansible.module_utils.optional import thing\nexcept ImportError:\n    pass\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestAnsibleModules_Load(t *testing.T) {
+	root := writeAnsibleTree(t)
+	library := t.TempDir()
+	if err := os.WriteFile(filepath.Join(library, "custom.py"), []byte("print('{}')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ansible := &AnsibleModules{Root: root, Library: []string{library}}
+
+	for _, name := range []string{"hello", "ansible.builtin.hello", "custom"} {
+		if _, ok := ansible.Find(name); !ok {
+			t.Errorf("expected %s to be found", name)
+		}
+	}
+	for _, name := range []string{"missing", "../modules/hello", "community.general.hello"} {
+		if _, err := ansible.Load(name); !errors.Is(err, types.ErrModuleNotFound) {
+			t.Errorf("%s: expected ErrModuleNotFound, got %v", name, err)
+		}
+	}
+
+	module, err := ansible.Load("ansible.builtin.hello")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	hello := module.(*AnsibleModule)
+	if hello.Name() != "ansible.builtin.hello" || hello.Documentation().Description != "Say hello" {
+		t.Errorf("unexpected module %s: %s", hello.Name(), hello.Documentation().Description)
+	}
+
+	// The payload holds the module and the module_utils it imports, but not
+	// the ansible package's own __init__
+	archive, err := zip.NewReader(bytes.NewReader(hello.payload), int64(len(hello.payload)))
+	if err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if file.Name == "ansible/__init__.py" && file.UncompressedSize64 != 0 {
+			t.Error("expected an empty ansible/__init__.py")
+		}
+	}
+	sort.Strings(names)
+	expected := []string{
+		"ansible/__init__.py",
+		"ansible/module_utils/__init__.py",
+		"ansible/module_utils/basic.py",
+		"ansible/module_utils/common/__init__.py",
+		"ansible/module_utils/common/text.py",
+		"ansible/modules/__init__.py",
+		"ansible/modules/hello.py",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected payload %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected payload %v, got %v", expected, names)
+			break
+		}
+	}
+}
+
+func TestAnsibleModule(t *testing.T) {
+	module, err := NewAnsibleModule("hello", filepath.Join(writeAnsibleTree(t), "modules", "hello.py"), "")
+	if err != nil {
+		t.Fatalf("NewAnsibleModule failed: %v", err)
+	}
+
+	helper := testhelper.NewModuleTestHelper(t, module)
+	command := `^python3 '/tmp/tmp\.x7Kq2p'; rc=\$\?; rm -f '/tmp/tmp\.x7Kq2p'`
+	mktemp := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommand("mktemp", &testhelper.CommandResponse{Stdout: "/tmp/tmp.x7Kq2p\n"})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Changed",
+			Args:     map[string]interface{}{"name": "world"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetConnection().ExpectCommandPattern(command, &testhelper.CommandResponse{
+					Stdout: "warning: old interpreter\n" + `{"changed": true, "msg": "Hello world", "greeting": "hello", "diff": {"before": "", "after": "world\n"}, "invocation": {"module_args": {"name": "world"}}}`,
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Hello world")
+				h.AssertDataValue(result, "greeting", "hello")
+				h.AssertDiffAfter(result, "world\n")
+				if _, ok := result.Data["invocation"]; ok {
+					t.Error("expected the invocation to be left out")
+				}
+				if transfers := h.GetConnection().GetTransfers(); len(transfers) != 1 {
+					t.Errorf("expected the wrapper to be copied, got %v", transfers)
+				}
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "world"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetConnection().ExpectCommandPattern(command, &testhelper.CommandResponse{Stdout: `{"changed": true}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+			},
+		},
+		{
+			Name: "NoLog",
+			Args: map[string]interface{}{"name": "world", "_no_log": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetFileSystem().CreateDirectory("/tmp", 0o1777)
+				h.GetConnection().UseFileSystem(h.GetFileSystem())
+				h.GetConnection().ExpectCommandPattern(command, &testhelper.CommandResponse{Stdout: `{"changed": false}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				wrapper := string(h.GetFileSystem().GetFileContent("/tmp/tmp.x7Kq2p"))
+				match := regexp.MustCompile(`(?m)^ARGS = "(.*)"$`).FindStringSubmatch(wrapper)
+				if match == nil {
+					t.Fatalf("expected the wrapper to hold the arguments, got %q", wrapper)
+				}
+				params, _ := base64.StdEncoding.DecodeString(match[1])
+				if !strings.Contains(string(params), `"_ansible_no_log":true`) {
+					t.Errorf("expected the module to be told about no_log, got %s", params)
+				}
+				if mode := h.GetFileSystem().GetFileMode("/tmp/tmp.x7Kq2p"); mode.Perm() != 0600 {
+					t.Errorf("expected the wrapper to be private, got %o", mode)
+				}
+			},
+		},
+		{
+			Name: "Interpreter",
+			Args: map[string]interface{}{
+				"name":       "world",
+				"_task_vars": map[string]interface{}{"ansible_python_interpreter": "/usr/bin/python3.11"},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetConnection().ExpectCommandPattern(`^/usr/bin/python3\.11 `, &testhelper.CommandResponse{Stdout: `{"changed": false}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "Failed",
+			Args:        map[string]interface{}{"name": "nobody"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetConnection().ExpectCommandPattern(command, &testhelper.CommandResponse{ExitCode: 1, Stdout: `{"failed": true, "msg": "Nobody to greet"}`})
+			},
+		},
+		{
+			Name:        "NoResult",
+			Args:        map[string]interface{}{"name": "world"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				mktemp(h)
+				h.GetConnection().ExpectCommandPattern(command, &testhelper.CommandResponse{ExitCode: 1, Stderr: "Traceback (most recent call last):"})
+			},
+		},
+	})
+}
+
+func TestModuleRegistry_Fallback(t *testing.T) {
+	registry := NewModuleRegistry()
+	registry.SetFallback((&AnsibleModules{Root: writeAnsibleTree(t)}).Load)
+
+	module, err := registry.GetModule("hello")
+	if err != nil {
+		t.Fatalf("expected the fallback to provide hello: %v", err)
+	}
+	if again, _ := registry.GetModule("hello"); again != module {
+		t.Error("expected the module provided to be registered")
+	}
+	if copyModule, _ := registry.GetModule("copy"); copyModule == nil {
+		t.Error("expected registered modules to be preferred")
+	} else if _, ok := copyModule.(*AnsibleModule); ok {
+		t.Error("expected the native copy module")
+	}
+	if _, err := registry.GetModule("missing"); !errors.Is(err, types.ErrModuleNotFound) {
+		t.Errorf("expected ErrModuleNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package modules
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// apkVersionPattern splits an apk package such as curl-8.5.0-r0 into its
+// name and version
+var apkVersionPattern = regexp.MustCompile(`^(.+)-[0-9][^-]*-r[0-9]+$`)
+
+// ApkModule manages packages using apk on Alpine Linux
+type ApkModule struct {
+	*BaseModule
+}
+
+// NewApkModule creates a new apk module instance
+func NewApkModule() *ApkModule {
+	doc := types.ModuleDoc{
+		Name:        "apk",
+		Description: "Manage packages using apk on Alpine Linux",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Package name, or list or comma separated string of names",
+				Required:    false,
+				Type:        "list",
+			},
+			"names": {
+				Description: "List of packages to manage",
+				Required:    false,
+				Type:        "list",
+			},
+			"state": {
+				Description: "State of the packages",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     packageStates,
+			},
+			"update_cache": {
+				Description: "Update the repository indexes before managing packages",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"repository": {
+				Description: "Repository URL to use in addition to /etc/apk/repositories",
+				Required:    false,
+				Type:        "string",
+			},
+			"no_cache": {
+				Description: "Do not use or update the local package cache",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Type:        "int",
+				Default:     defaultLockTimeout,
+			},
+		},
+		Examples: []string{
+			"- name: Install the build tools\n  apk:\n    name: [build-base, git]\n    update_cache: true",
+			"- name: Install from the edge testing repository\n  apk:\n    name: just\n    repository: https://dl-cdn.alpinelinux.org/alpine/edge/testing",
+		},
+		Returns: map[string]string{
+			"installed":     "Packages that were installed",
+			"upgraded":      "Packages that were upgraded",
+			"removed":       "Packages that were removed",
+			"cache_updated": "Whether the repository indexes were updated",
+		},
+	}
+
+	base := NewBaseModule("apk", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:        true,
+		DiffMode:         true,
+		Platform:         "linux",
+		RequiresRoot:     true,
+		ConcurrencyClass: types.ConcurrencyClassPackageManager,
+	})
+
+	return &ApkModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ApkModule) Validate(args map[string]interface{}) error {
+	return validatePackageArgs(m.BaseModule, args)
+}
+
+// Run executes the apk module
+func (m *ApkModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	var cli remoteCLI
+	global := "apk"
+	if repository := m.GetStringArg(args, "repository", ""); repository != "" {
+		global += " --repository " + cli.shellEscape(repository)
+	}
+	if m.GetBoolArg(args, "no_cache", false) {
+		global += " --no-cache"
+	}
+	return nativePackageManager{
+		name:          "apk",
+		probe:         "apk info -e",
+		outdated:      global + " version -l '<'",
+		refresh:       global + " update",
+		install:       global + " add",
+		upgrade:       global + " add --upgrade",
+		remove:        "apk del",
+		parseOutdated: parseApkUpdates,
+	}.run(ctx, m.BaseModule, conn, args)
+}
+
+// parseApkUpdates reads the output of apk version -l '<', one upgradable
+// package per line such as "curl-8.5.0-r0  < 8.9.0-r0"
+func parseApkUpdates(stdout string) []string {
+	var packages []string
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "<" {
+			if match := apkVersionPattern.FindStringSubmatch(fields[0]); match != nil {
+				packages = append(packages, match[1])
+			}
+		}
+	}
+	return packages
+}
@@ -0,0 +1,133 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ApparmorProfileModule sets the enforcement mode of AppArmor profiles
+type ApparmorProfileModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewApparmorProfileModule creates a new apparmor_profile module instance
+func NewApparmorProfileModule() *ApparmorProfileModule {
+	doc := types.ModuleDoc{
+		Name:        "apparmor_profile",
+		Description: "Put an AppArmor profile into enforce or complain mode, or disable it, with aa-enforce, aa-complain and aa-disable",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Profile name as the kernel lists it, usually the confined program's path, e.g. /usr/sbin/nginx",
+				Required:    true,
+				Type:        "string",
+			},
+			"path": {
+				Description: "Profile file; derived from name under /etc/apparmor.d when omitted, e.g. /etc/apparmor.d/usr.sbin.nginx",
+				Required:    false,
+				Type:        "path",
+			},
+			"state": {
+				Description: "Profile mode",
+				Required:    false,
+				Type:        "string",
+				Default:     "enforce",
+				Choices:     []string{"enforce", "complain", "disabled"},
+			},
+		},
+		Examples: []string{
+			"- name: Enforce the nginx profile\n  apparmor_profile:\n    name: /usr/sbin/nginx",
+			"- name: Let the profile log instead of deny while tuning it\n  apparmor_profile:\n    name: /usr/sbin/nginx\n    state: complain",
+		},
+		Returns: map[string]string{
+			"name": "Profile name",
+			"path": "Profile file",
+			"mode": "Mode after the run, disabled when the profile is not loaded",
+		},
+	}
+
+	base := NewBaseModule("apparmor_profile", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &ApparmorProfileModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ApparmorProfileModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"enforce", "complain", "disabled"}); err != nil {
+		return err
+	}
+	if name := m.GetStringArg(args, "name", ""); strings.ContainsAny(name, "\n") || strings.HasPrefix(name, "-") {
+		return types.NewValidationError("name", name, "invalid profile name")
+	}
+	return nil
+}
+
+// apparmorProfilePath derives the profile file from a profile name the way
+// the AppArmor tools name them, /usr/sbin/nginx becoming usr.sbin.nginx
+func apparmorProfilePath(name string) string {
+	return "/etc/apparmor.d/" + strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", ".")
+}
+
+// Run executes the apparmor_profile module
+func (m *ApparmorProfileModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	path := m.GetStringArg(args, "path", apparmorProfilePath(name))
+	state := m.GetStringArg(args, "state", "enforce")
+
+	result, err := m.cli.run(ctx, conn, "reading AppArmor profiles",
+		"cat /sys/module/apparmor/parameters/enabled && cat /sys/kernel/security/apparmor/profiles")
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if strings.TrimSpace(lines[0]) != "Y" {
+		return nil, fmt.Errorf("AppArmor is not enabled on %s", hostname)
+	}
+
+	// Loaded profiles are listed as "name (mode)"
+	mode := "disabled"
+	for _, line := range lines[1:] {
+		profile, current, ok := strings.Cut(strings.TrimSpace(line), " (")
+		if ok && profile == name {
+			mode = strings.TrimSuffix(current, ")")
+		}
+	}
+
+	change := ""
+	if mode != state {
+		tool := map[string]string{"enforce": "aa-enforce", "complain": "aa-complain", "disabled": "aa-disable"}[state]
+		if !checkMode {
+			if _, err := m.cli.run(ctx, conn, "changing AppArmor profile "+name, tool+" "+m.cli.shellEscape(path)); err != nil {
+				return nil, err
+			}
+		}
+		change = fmt.Sprintf("set AppArmor profile %s to %s (was %s)", name, state, mode)
+	}
+
+	result = m.CreateSuccessResult(hostname, false, fmt.Sprintf("AppArmor profile %s is already %s", name, state), map[string]interface{}{
+		"name": name,
+		"path": path,
+		"mode": state,
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		fmt.Sprintf("%s: %s\n", name, mode), fmt.Sprintf("%s: %s\n", name, state), startTime), nil
+}
@@ -0,0 +1,75 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestApparmorProfilePath(t *testing.T) {
+	if got := apparmorProfilePath("/usr/sbin/nginx"); got != "/etc/apparmor.d/usr.sbin.nginx" {
+		t.Errorf("apparmorProfilePath() = %q", got)
+	}
+}
+
+func TestApparmorProfileModule(t *testing.T) {
+	module := NewApparmorProfileModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "/usr/sbin/nginx", "state": "complain"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "/usr/sbin/nginx", "state": "audit"}, ExpectValid: false},
+	})
+
+	profiles := func(h *testhelper.ModuleTestHelper, stdout string) {
+		h.GetConnection().ExpectCommand("cat /sys/module/apparmor/parameters/enabled && cat /sys/kernel/security/apparmor/profiles", &testhelper.CommandResponse{Stdout: stdout})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "EnforceComplaining",
+			Args:     map[string]interface{}{"name": "/usr/sbin/nginx"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				profiles(h, "Y\n/usr/sbin/nginx (complain)\n/usr/bin/man (enforce)\n")
+				h.GetConnection().ExpectCommand("aa-enforce '/etc/apparmor.d/usr.sbin.nginx'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set AppArmor profile /usr/sbin/nginx to enforce (was complain)")
+				h.AssertDiffBefore(result, "/usr/sbin/nginx: complain\n")
+			},
+		},
+		{
+			Name: "DisableWithPath",
+			Args: map[string]interface{}{"name": "nginx-custom", "path": "/etc/apparmor.d/local-nginx", "state": "disabled"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				profiles(h, "Y\nnginx-custom (enforce)\n")
+				h.GetConnection().ExpectCommand("aa-disable '/etc/apparmor.d/local-nginx'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "AlreadyUnloaded",
+			Args: map[string]interface{}{"name": "/usr/sbin/nginx", "state": "disabled"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				profiles(h, "Y\n/usr/bin/man (enforce)\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "AppArmorDisabled",
+			Args:        map[string]interface{}{"name": "/usr/sbin/nginx"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				profiles(h, "N\n")
+			},
+		},
+	})
+}
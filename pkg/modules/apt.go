@@ -64,10 +64,21 @@ func NewAptModule() *AptModule {
 				Default:     false,
 				Type:        "bool",
 			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Default:     60,
+				Type:        "int",
+			},
 		},
 	}
+	base := NewBaseModule("apt", doc)
+	caps := types.DefaultCapabilities()
+	caps.ConcurrencyClass = types.ConcurrencyClassPackageManager
+	base.SetCapabilities(caps)
+
 	return &AptModule{
-		BaseModule: NewBaseModule("apt", doc),
+		BaseModule: base,
 	}
 }
 
@@ -82,6 +93,10 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 	autoremove := m.GetBoolArg(args, "autoremove", false)
 	autoclean := m.GetBoolArg(args, "autoclean", false)
 	forceAptGet := m.GetBoolArg(args, "force_apt_get", false)
+	lockTimeout, err := m.lockTimeoutArg(args)
+	if err != nil {
+		return m.CreateErrorResult("", err.Error(), nil), nil
+	}
 
 	// Convert slice to string array
 	var names []string
@@ -124,7 +139,7 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Update cache if requested
 	if updateCache {
 		cmd := fmt.Sprintf("DEBIAN_FRONTEND=noninteractive %s update", aptCmd)
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to update APT cache", err), nil
 		}
@@ -147,7 +162,7 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 			cmd = fmt.Sprintf("DEBIAN_FRONTEND=noninteractive %s upgrade -y", aptCmd)
 		}
 		
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", fmt.Sprintf("Failed to upgrade packages: %s", upgradePackages), err), nil
 		}
@@ -163,7 +178,7 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 	for _, pkg := range packages {
 		cmd := m.buildAptCommand(pkg, state, aptCmd)
 		
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			// Check if package is already in desired state
 			if result != nil && result.Data != nil {
@@ -200,7 +215,7 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Autoremove if requested
 	if autoremove {
 		cmd := fmt.Sprintf("DEBIAN_FRONTEND=noninteractive %s autoremove -y", aptCmd)
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to autoremove packages", err), nil
 		}
@@ -217,7 +232,7 @@ func (m *AptModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Autoclean if requested
 	if autoclean {
 		cmd := fmt.Sprintf("DEBIAN_FRONTEND=noninteractive %s autoclean -y", aptCmd)
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to autoclean", err), nil
 		}
@@ -283,6 +298,10 @@ func (m *AptModule) Validate(args map[string]interface{}) error {
 		}
 	}
 
+	if _, err := m.lockTimeoutArg(args); err != nil {
+		return err
+	}
+
 	// Check that at least one action is specified
 	name := m.GetStringArg(args, "name", "")
 	namesSlice := m.GetSliceArg(args, "names")
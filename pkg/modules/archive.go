@@ -133,6 +133,12 @@ func (m *ArchiveModule) Validate(args map[string]interface{}) error {
 
 // Run executes the archive module
 func (m *ArchiveModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *ArchiveModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Validate arguments
 	if err := m.Validate(args); err != nil {
 		return &types.Result{
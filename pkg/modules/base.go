@@ -2,6 +2,7 @@ package modules
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -60,6 +61,17 @@ func (m *BaseModule) GetBoolArg(args map[string]interface{}, key string, default
 	return defaultValue
 }
 
+// GetTaskVar gets a host variable the runner passes along with the
+// arguments, with optional default
+func (m *BaseModule) GetTaskVar(args map[string]interface{}, key string, defaultValue string) string {
+	if vars, ok := args["_task_vars"].(map[string]interface{}); ok {
+		if value, exists := vars[key]; exists && value != nil {
+			return types.ConvertToString(value)
+		}
+	}
+	return defaultValue
+}
+
 // GetIntArg gets an integer argument with optional default
 func (m *BaseModule) GetIntArg(args map[string]interface{}, key string, defaultValue int) (int, error) {
 	if value, exists := args[key]; exists {
@@ -161,6 +173,9 @@ func (m *BaseModule) RunWithModes(ctx context.Context, module types.Module, conn
 	if opts.DiffMode {
 		args["_diff"] = true
 	}
+	if opts.CaptureState {
+		args["_capture_state"] = true
+	}
 	
 	// Validate module supports requested modes
 	if opts.CheckMode && !m.capabilities.CheckMode {
@@ -170,26 +185,47 @@ func (m *BaseModule) RunWithModes(ctx context.Context, module types.Module, conn
 		return nil, fmt.Errorf("module %s does not support diff mode", m.name)
 	}
 	
-	return module.Run(ctx, conn, args)
+	// Modules without a state structure of their own capture the before
+	// and after text of their diff, which is dropped again unless diff
+	// mode was asked for
+	capture := m.CaptureState(args) && m.capabilities != nil && m.capabilities.DiffMode
+	if capture {
+		args["_diff"] = true
+	}
+	
+	result, err := module.Run(ctx, conn, args)
+	if capture && result != nil {
+		if result.State == nil && result.Diff != nil {
+			result.State = &types.StateCapture{Before: result.Diff.Before, After: result.Diff.After}
+		}
+		if !opts.DiffMode {
+			result.Diff = nil
+		}
+	}
+	return result, err
 }
 
-// ExecuteWithTiming wraps execution with timing information
+// ExecuteWithTiming wraps execution with timing information and fills in
+// the result fields every module is expected to populate
 func (m *BaseModule) ExecuteWithTiming(ctx context.Context, conn types.Connection, args map[string]interface{}, executeFunc func() (*types.Result, error)) (*types.Result, error) {
 	startTime := time.Now()
 
 	result, err := executeFunc()
-	if err != nil {
-		return result, err
-	}
 
 	endTime := time.Now()
 	if result != nil {
 		result.StartTime = startTime
 		result.EndTime = endTime
 		result.Duration = endTime.Sub(startTime)
+		if result.ModuleName == "" {
+			result.ModuleName = m.name
+		}
+		if result.Data == nil {
+			result.Data = make(map[string]interface{})
+		}
 	}
 
-	return result, nil
+	return result, err
 }
 
 // CheckMode determines if the module is running in check mode
@@ -208,6 +244,41 @@ func (m *BaseModule) DiffMode(args map[string]interface{}) bool {
 	return m.GetBoolArg(args, "_diff", false)
 }
 
+// NoLog determines if the task hides its arguments and results, so the
+// module must not log them itself
+func (m *BaseModule) NoLog(args map[string]interface{}) bool {
+	return m.GetBoolArg(args, "_no_log", false)
+}
+
+// CaptureState determines if the module should record the before/after
+// state of what it manages for the audit trail
+func (m *BaseModule) CaptureState(args map[string]interface{}) bool {
+	return m.GetBoolArg(args, "_capture_state", false)
+}
+
+// SetState records the before/after state in the result when state capture
+// is enabled. The states are stored as their JSON form, so structures such
+// as SystemdServiceState are redacted and exported like any other value.
+func (m *BaseModule) SetState(result *types.Result, args map[string]interface{}, before, after interface{}) {
+	if result == nil || !m.CaptureState(args) {
+		return
+	}
+	result.State = &types.StateCapture{Before: jsonValue(before), After: jsonValue(after)}
+}
+
+// jsonValue converts a value to the generic form encoding/json decodes it to
+func jsonValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return fmt.Sprint(value)
+	}
+	return generic
+}
+
 // ExpandPath expands variables in a file path
 func (m *BaseModule) ExpandPath(path string, vars map[string]interface{}) string {
 	if vars == nil {
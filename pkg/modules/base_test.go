@@ -259,6 +259,61 @@ func TestBaseModule_RunWithModes(t *testing.T) {
 	}
 }
 
+func TestBaseModule_CaptureState(t *testing.T) {
+	base := NewBaseModule("test", types.ModuleDoc{})
+	base.SetCapabilities(&types.ModuleCapability{CheckMode: true, DiffMode: true})
+	
+	mockModule := &MockModule{
+		BaseModule: base,
+		runFunc: func(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+			result := base.CreateSuccessResult("testhost", true, "Changed", nil)
+			if base.DiffMode(args) {
+				result.Diff = base.GenerateDiff("swappiness=60\n", "swappiness=10\n")
+			}
+			return result, nil
+		},
+	}
+	
+	// Modules without a state of their own capture their diff, which is
+	// dropped again when diff mode was not asked for
+	result, err := base.RunWithModes(context.Background(), mockModule, nil, map[string]interface{}{}, types.ExecuteOptions{CaptureState: true})
+	if err != nil {
+		t.Fatalf("RunWithModes failed: %v", err)
+	}
+	if result.State == nil || result.State.Before != "swappiness=60\n" || result.State.After != "swappiness=10\n" {
+		t.Errorf("expected the diff to be captured as the state, got %+v", result.State)
+	}
+	if result.Diff != nil {
+		t.Error("expected no diff without diff mode")
+	}
+	
+	result, _ = base.RunWithModes(context.Background(), mockModule, nil, map[string]interface{}{}, types.ExecuteOptions{CaptureState: true, DiffMode: true})
+	if result.State == nil || result.Diff == nil {
+		t.Error("expected both the state and the diff in diff mode")
+	}
+	
+	result, _ = base.RunWithModes(context.Background(), mockModule, nil, map[string]interface{}{}, types.ExecuteOptions{})
+	if result.State != nil {
+		t.Error("expected no state without state capture")
+	}
+	
+	// SetState stores structures as their JSON form
+	result = base.CreateSuccessResult("testhost", true, "Changed", nil)
+	base.SetState(result, map[string]interface{}{"_capture_state": true},
+		SystemdServiceState{Name: "nginx", ActiveState: "inactive"},
+		SystemdServiceState{Name: "nginx", ActiveState: "active"})
+	after, ok := result.State.After.(map[string]interface{})
+	if !ok || after["active_state"] != "active" {
+		t.Errorf("expected the state as a JSON object, got %#v", result.State.After)
+	}
+	
+	result = base.CreateSuccessResult("testhost", true, "Changed", nil)
+	base.SetState(result, map[string]interface{}{}, "before", "after")
+	if result.State != nil {
+		t.Error("expected SetState to do nothing without state capture")
+	}
+}
+
 // MockModule for testing
 type MockModule struct {
 	*BaseModule
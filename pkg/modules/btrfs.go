@@ -0,0 +1,274 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// btrfsSubvolume describes the desired state of a subvolume or snapshot
+type btrfsSubvolume struct {
+	cli      remoteCLI
+	kind     string // Human readable kind for messages
+	path     string // Subvolume path on a mounted btrfs filesystem
+	present  bool
+	readonly *bool  // Desired ro property, nil leaves it alone
+	create   string // Command creating the subvolume
+}
+
+// read returns the ro property of the subvolume and whether it exists
+func (s *btrfsSubvolume) read(ctx context.Context, conn types.Connection) (bool, bool, error) {
+	path := s.cli.shellEscape(s.path)
+	output, exists, err := s.cli.inspect(ctx, conn, s.path, "btrfs subvolume show "+path, "btrfs property get -ts "+path+" ro")
+	if err != nil || !exists {
+		return false, exists, err
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(output), "ro="))
+	ro, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, true, fmt.Errorf("unexpected ro property of %s: %q", s.path, output)
+	}
+	return ro, true, nil
+}
+
+// apply brings the subvolume to the desired state and builds the result
+func (s *btrfsSubvolume) apply(ctx context.Context, m *BaseModule, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	ro, exists, err := s.read(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Btrfs %s %s is already in desired state", s.kind, s.path), map[string]interface{}{
+		"path":     s.path,
+		"readonly": ro,
+	})
+
+	current := map[string]string{"ro": strconv.FormatBool(ro)}
+	path := s.cli.shellEscape(s.path)
+	var change, cmd, before, after string
+
+	switch {
+	case !s.present && exists:
+		change = fmt.Sprintf("deleted %s %s", s.kind, s.path)
+		before = formatStorageProperties(s.path, current)
+		cmd = "btrfs subvolume delete " + path
+
+	case s.present && !exists:
+		change = fmt.Sprintf("created %s %s", s.kind, s.path)
+		cmd = fmt.Sprintf("mkdir -p %s && %s", s.cli.shellEscape(parentDir(s.path)), s.create)
+		desired := map[string]string{"ro": "false"}
+		if s.readonly != nil {
+			desired["ro"] = strconv.FormatBool(*s.readonly)
+		}
+		after = formatStorageProperties(s.path, desired)
+		result.Data["readonly"] = desired["ro"] == "true"
+
+	case s.present && s.readonly != nil && *s.readonly != ro:
+		change = fmt.Sprintf("set ro=%t on %s %s", *s.readonly, s.kind, s.path)
+		before = formatStorageProperties(s.path, current)
+		after = formatStorageProperties(s.path, map[string]string{"ro": strconv.FormatBool(*s.readonly)})
+		cmd = fmt.Sprintf("btrfs property set -ts %s ro %t", path, *s.readonly)
+		result.Data["readonly"] = *s.readonly
+	}
+
+	if change != "" && !checkMode {
+		if _, err := s.cli.run(ctx, conn, "btrfs", cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	return changeResult(m, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// BtrfsSubvolumeModule manages btrfs subvolumes
+type BtrfsSubvolumeModule struct {
+	*BaseModule
+}
+
+// NewBtrfsSubvolumeModule creates a new btrfs_subvolume module instance
+func NewBtrfsSubvolumeModule() *BtrfsSubvolumeModule {
+	doc := types.ModuleDoc{
+		Name:        "btrfs_subvolume",
+		Description: "Manage btrfs subvolumes on a mounted filesystem",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Subvolume path, e.g. /srv/@data",
+				Required:    true,
+				Type:        "path",
+			},
+			"state": {
+				Description: "Whether the subvolume should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"readonly": {
+				Description: "Whether the subvolume is read-only; left unchanged when omitted",
+				Required:    false,
+				Type:        "bool",
+			},
+		},
+		Examples: []string{
+			"- name: Create a subvolume for container storage\n  btrfs_subvolume:\n    path: /var/lib/containers",
+		},
+		Returns: map[string]string{
+			"path":     "Subvolume path",
+			"readonly": "Whether the subvolume is read-only",
+		},
+	}
+
+	base := NewBaseModule("btrfs_subvolume", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &BtrfsSubvolumeModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *BtrfsSubvolumeModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "path", "") == "" {
+		return types.NewValidationError("path", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	return validateBtrfsReadonly(args)
+}
+
+// Run executes the btrfs_subvolume module
+func (m *BtrfsSubvolumeModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	subvolume := &btrfsSubvolume{
+		kind:     "subvolume",
+		path:     m.GetStringArg(args, "path", ""),
+		present:  m.GetStringArg(args, "state", "present") == "present",
+		readonly: btrfsReadonlyArg(m.BaseModule, args, nil),
+	}
+	subvolume.create = "btrfs subvolume create " + subvolume.cli.shellEscape(subvolume.path)
+	if subvolume.readonly != nil && *subvolume.readonly {
+		subvolume.create += " && btrfs property set -ts " + subvolume.cli.shellEscape(subvolume.path) + " ro true"
+	}
+
+	return subvolume.apply(ctx, m.BaseModule, conn, args)
+}
+
+// BtrfsSnapshotModule manages btrfs snapshots of subvolumes
+type BtrfsSnapshotModule struct {
+	*BaseModule
+}
+
+// NewBtrfsSnapshotModule creates a new btrfs_snapshot module instance
+func NewBtrfsSnapshotModule() *BtrfsSnapshotModule {
+	doc := types.ModuleDoc{
+		Name:        "btrfs_snapshot",
+		Description: "Manage btrfs snapshots of subvolumes. An existing snapshot is never retaken",
+		Parameters: map[string]types.ParamDoc{
+			"source": {
+				Description: "Subvolume to snapshot",
+				Required:    false,
+				Type:        "path",
+			},
+			"dest": {
+				Description: "Snapshot path",
+				Required:    true,
+				Type:        "path",
+			},
+			"state": {
+				Description: "Whether the snapshot should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"readonly": {
+				Description: "Whether the snapshot is read-only",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			"- name: Snapshot home before the upgrade\n  btrfs_snapshot:\n    source: /home\n    dest: /.snapshots/home-pre-upgrade",
+			"- name: Drop the snapshot\n  btrfs_snapshot:\n    dest: /.snapshots/home-pre-upgrade\n    state: absent",
+		},
+		Returns: map[string]string{
+			"path":     "Snapshot path",
+			"readonly": "Whether the snapshot is read-only",
+		},
+	}
+
+	base := NewBaseModule("btrfs_snapshot", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &BtrfsSnapshotModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *BtrfsSnapshotModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "dest", "") == "" {
+		return types.NewValidationError("dest", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "state", "present") == "present" && m.GetStringArg(args, "source", "") == "" {
+		return types.NewValidationError("source", nil, "source is required when state is present")
+	}
+	return validateBtrfsReadonly(args)
+}
+
+// Run executes the btrfs_snapshot module
+func (m *BtrfsSnapshotModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	readonly := true
+	snapshot := &btrfsSubvolume{
+		kind:     "snapshot",
+		path:     m.GetStringArg(args, "dest", ""),
+		present:  m.GetStringArg(args, "state", "present") == "present",
+		readonly: btrfsReadonlyArg(m.BaseModule, args, &readonly),
+	}
+
+	cmd := "btrfs subvolume snapshot "
+	if *snapshot.readonly {
+		cmd += "-r "
+	}
+	snapshot.create = cmd + snapshot.cli.shellEscape(m.GetStringArg(args, "source", "")) + " " + snapshot.cli.shellEscape(snapshot.path)
+
+	return snapshot.apply(ctx, m.BaseModule, conn, args)
+}
+
+// validateBtrfsReadonly checks the optional readonly argument
+func validateBtrfsReadonly(args map[string]interface{}) error {
+	if value, ok := args["readonly"]; ok {
+		if _, ok := value.(bool); !ok {
+			return types.NewValidationError("readonly", value, "readonly must be a boolean")
+		}
+	}
+	return nil
+}
+
+// btrfsReadonlyArg returns the readonly argument, or def when it is unset
+func btrfsReadonlyArg(m *BaseModule, args map[string]interface{}, def *bool) *bool {
+	if _, ok := args["readonly"]; !ok {
+		return def
+	}
+	readonly := m.GetBoolArg(args, "readonly", false)
+	return &readonly
+}
@@ -0,0 +1,118 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestBtrfsSubvolumeModule(t *testing.T) {
+	module := NewBtrfsSubvolumeModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"path": "/srv/@data"}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "ReadonlyNotBool", Args: map[string]interface{}{"path": "/srv/@data", "readonly": "yes"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Create",
+			Args: map[string]interface{}{"path": "/srv/@data"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if btrfs subvolume show '/srv/@data' >/dev/null 2>&1; then .*; btrfs property get -ts '/srv/@data' ro; fi$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/srv' && btrfs subvolume create '/srv/@data'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created subvolume /srv/@data")
+			},
+		},
+		{
+			Name: "ExistingUnchanged",
+			Args: map[string]interface{}{"path": "/srv/@data"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show `, &testhelper.CommandResponse{Stdout: existsMarker + "ro=true\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "readonly", true)
+			},
+		},
+		{
+			Name:      "ReadonlyDiffInCheckMode",
+			Args:      map[string]interface{}{"path": "/srv/@data", "readonly": true},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show `, &testhelper.CommandResponse{Stdout: existsMarker + "ro=false\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would have set ro=true on subvolume /srv/@data")
+			},
+		},
+		{
+			Name: "Delete",
+			Args: map[string]interface{}{"path": "/srv/@data", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show `, &testhelper.CommandResponse{Stdout: existsMarker + "ro=false\n"})
+				h.GetConnection().ExpectCommandPattern(`^btrfs subvolume delete '/srv/@data'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+	})
+}
+
+func TestBtrfsSnapshotModule(t *testing.T) {
+	module := NewBtrfsSnapshotModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"source": "/home", "dest": "/.snapshots/home"}, ExpectValid: true},
+		{Name: "AbsentWithoutSource", Args: map[string]interface{}{"dest": "/.snapshots/home", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingSource", Args: map[string]interface{}{"dest": "/.snapshots/home"}, ExpectValid: false},
+		{Name: "MissingDest", Args: map[string]interface{}{"source": "/home"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "ReadonlySnapshot",
+			Args: map[string]interface{}{"source": "/home", "dest": "/.snapshots/home-1"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show '/.snapshots/home-1'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/.snapshots' && btrfs subvolume snapshot -r '/home' '/.snapshots/home-1'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "readonly", true)
+			},
+		},
+		{
+			Name: "WritableSnapshot",
+			Args: map[string]interface{}{"source": "/home", "dest": "/.snapshots/home-rw", "readonly": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`btrfs subvolume snapshot '/home' '/.snapshots/home-rw'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "ExistingSnapshotNotRetaken",
+			Args: map[string]interface{}{"source": "/home", "dest": "/.snapshots/home-1"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if btrfs subvolume show `, &testhelper.CommandResponse{Stdout: existsMarker + "ro=true\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+	})
+}
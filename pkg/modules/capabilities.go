@@ -0,0 +1,214 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// capabilityPattern matches a capability and the flags it is set with, e.g.
+// cap_net_bind_service+ep
+var capabilityPattern = regexp.MustCompile(`^(cap_[a-z_]+)(?:[=+]([eip]+))?$`)
+
+// CapabilitiesModule manages Linux file capabilities with setcap
+type CapabilitiesModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewCapabilitiesModule creates a new capabilities module instance
+func NewCapabilitiesModule() *CapabilitiesModule {
+	doc := types.ModuleDoc{
+		Name:        "capabilities",
+		Description: "Grant or revoke a Linux capability of an executable with setcap, keeping its other capabilities",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "The file whose capabilities are managed",
+				Required:    true,
+				Type:        "path",
+			},
+			"capability": {
+				Description: "The capability with its effective, permitted and inheritable flags, e.g. cap_net_bind_service+ep. Only the name counts when state is absent",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the file has the capability",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Let node bind to port 443 without root\n  capabilities:\n    path: /usr/bin/node\n    capability: cap_net_bind_service+ep",
+			"- name: Take raw sockets away from ping\n  capabilities:\n    path: /usr/bin/ping\n    capability: cap_net_raw\n    state: absent",
+		},
+		Returns: map[string]string{
+			"path":         "The file whose capabilities are managed",
+			"capabilities": "Capabilities of the file after the run, as name=flags",
+		},
+	}
+
+	base := NewBaseModule("capabilities", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &CapabilitiesModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *CapabilitiesModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"path", "capability"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	capability := strings.ToLower(m.GetStringArg(args, "capability", ""))
+	match := capabilityPattern.FindStringSubmatch(capability)
+	if match == nil || (match[2] == "" && m.GetStringArg(args, "state", "present") == "present") {
+		return types.NewValidationError("capability", capability, "expected a capability with its flags, e.g. cap_net_bind_service+ep")
+	}
+	return nil
+}
+
+// Run executes the capabilities module
+func (m *CapabilitiesModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	path := m.GetStringArg(args, "path", "")
+	state := m.GetStringArg(args, "state", "present")
+	match := capabilityPattern.FindStringSubmatch(strings.ToLower(m.GetStringArg(args, "capability", "")))
+	if match == nil {
+		return nil, fmt.Errorf("invalid capability %q", m.GetStringArg(args, "capability", ""))
+	}
+	name, flags := match[1], sortedFlags(match[2])
+
+	output, exists, err := m.cli.inspect(ctx, conn, path, "test -e "+m.cli.shellEscape(path), "getcap "+m.cli.shellEscape(path))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s does not exist", path)
+	}
+	current := parseGetcap(output, path)
+
+	wanted := make(map[string]string, len(current)+1)
+	for cap, capFlags := range current {
+		wanted[cap] = capFlags
+	}
+	change := ""
+	switch {
+	case state == "present" && current[name] != flags:
+		wanted[name] = flags
+		change = fmt.Sprintf("set %s=%s on %s", name, flags, path)
+	case state == "absent" && current[name] != "":
+		delete(wanted, name)
+		change = fmt.Sprintf("removed %s from %s", name, path)
+	}
+
+	if change != "" && !checkMode {
+		cmd := "setcap -r " + m.cli.shellEscape(path)
+		if len(wanted) > 0 {
+			cmd = fmt.Sprintf("setcap %s %s", m.cli.shellEscape(capabilityText(wanted)), m.cli.shellEscape(path))
+		}
+		if _, err := m.cli.run(ctx, conn, "setting the capabilities of "+path, cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Capabilities of %s are already in desired state", path), map[string]interface{}{
+		"path":         path,
+		"capabilities": strings.Fields(capabilityText(wanted)),
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		capabilityText(current)+"\n", capabilityText(wanted)+"\n", startTime), nil
+}
+
+// parseGetcap parses the capabilities getcap prints for path, in the
+// "path cap_a,cap_b=ep cap_c+i" form of libcap 2.3x or the older
+// "path = cap_a+ep", into the flags of each capability
+func parseGetcap(output, path string) map[string]string {
+	caps := make(map[string]string)
+	text := strings.TrimSpace(output)
+	text = strings.TrimSpace(strings.TrimPrefix(text, path))
+	text = strings.TrimSpace(strings.TrimPrefix(text, "="))
+
+	for _, clause := range strings.Fields(text) {
+		i := strings.IndexAny(clause, "=+-")
+		if i <= 0 {
+			continue
+		}
+		names := strings.Split(clause[:i], ",")
+		// Apply each operator and its flags in turn, as in "=ep-i"
+		ops := clause[i:]
+		for len(ops) > 0 {
+			op := ops[0]
+			end := strings.IndexAny(ops[1:], "=+-")
+			if end < 0 {
+				end = len(ops) - 1
+			}
+			opFlags := ops[1 : end+1]
+			ops = ops[end+1:]
+			for _, name := range names {
+				switch op {
+				case '=':
+					caps[name] = opFlags
+				case '+':
+					caps[name] += opFlags
+				case '-':
+					caps[name] = strings.Map(func(r rune) rune {
+						if strings.ContainsRune(opFlags, r) {
+							return -1
+						}
+						return r
+					}, caps[name])
+				}
+			}
+		}
+	}
+
+	for name, flags := range caps {
+		if flags = sortedFlags(flags); flags == "" {
+			delete(caps, name)
+		} else {
+			caps[name] = flags
+		}
+	}
+	return caps
+}
+
+// sortedFlags returns capability flags once each, in eip order
+func sortedFlags(flags string) string {
+	var sorted strings.Builder
+	for _, flag := range "eip" {
+		if strings.ContainsRune(flags, flag) {
+			sorted.WriteRune(flag)
+		}
+	}
+	return sorted.String()
+}
+
+// capabilityText returns capabilities in the text form setcap takes, e.g.
+// "cap_net_admin=ep cap_net_raw=ep"
+func capabilityText(caps map[string]string) string {
+	clauses := make([]string, 0, len(caps))
+	for name, flags := range caps {
+		clauses = append(clauses, name+"="+flags)
+	}
+	sort.Strings(clauses)
+	return strings.Join(clauses, " ")
+}
@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseGetcap(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected map[string]string
+	}{
+		{"/usr/bin/ping cap_net_raw=ep\n", map[string]string{"cap_net_raw": "ep"}},
+		{"/usr/bin/ping = cap_net_raw+pe\n", map[string]string{"cap_net_raw": "ep"}},
+		{"/usr/bin/ping cap_net_admin,cap_net_raw=eip cap_net_admin-i\n", map[string]string{"cap_net_admin": "ep", "cap_net_raw": "eip"}},
+		{"/usr/bin/ping cap_net_raw=ep-e\n", map[string]string{"cap_net_raw": "p"}},
+		{"", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if caps := parseGetcap(tt.output, "/usr/bin/ping"); !reflect.DeepEqual(caps, tt.expected) {
+			t.Errorf("parseGetcap(%q) = %v, expected %v", tt.output, caps, tt.expected)
+		}
+	}
+	if text := capabilityText(map[string]string{"cap_net_raw": "ep", "cap_chown": "i"}); text != "cap_chown=i cap_net_raw=ep" {
+		t.Errorf("capabilityText() = %q", text)
+	}
+}
+
+func TestCapabilitiesModule(t *testing.T) {
+	module := NewCapabilitiesModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service+ep"}, ExpectValid: true},
+		{Name: "AbsentWithoutFlags", Args: map[string]interface{}{"path": "/usr/bin/ping", "capability": "cap_net_raw", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{"capability": "cap_net_raw+ep"}, ExpectValid: false},
+		{Name: "PresentWithoutFlags", Args: map[string]interface{}{"path": "/usr/bin/ping", "capability": "cap_net_raw"}, ExpectValid: false},
+		{Name: "BadCapability", Args: map[string]interface{}{"path": "/usr/bin/ping", "capability": "net_raw+ep"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"path": "/usr/bin/ping", "capability": "cap_net_raw+ep", "state": "latest"}, ExpectValid: false},
+	})
+
+	getcap := func(h *testhelper.ModuleTestHelper, stdout string) {
+		h.GetConnection().ExpectCommandPattern(`^if test -e '/usr/bin/node' `, &testhelper.CommandResponse{Stdout: existsMarker + stdout})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Grant",
+			Args:     map[string]interface{}{"path": "/usr/bin/node", "capability": "CAP_NET_BIND_SERVICE+pe"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				getcap(h, "/usr/bin/node cap_net_raw=ep\n")
+				h.GetConnection().ExpectCommand("setcap 'cap_net_bind_service=ep cap_net_raw=ep' '/usr/bin/node'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set cap_net_bind_service=ep on /usr/bin/node")
+				h.AssertDiffBefore(result, "cap_net_raw=ep\n")
+				if value := result.Data["capabilities"]; !reflect.DeepEqual(value, []string{"cap_net_bind_service=ep", "cap_net_raw=ep"}) {
+					t.Errorf("unexpected capabilities %v", value)
+				}
+			},
+		},
+		{
+			Name: "AlreadyGranted",
+			Args: map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service=ep"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				getcap(h, "/usr/bin/node = cap_net_bind_service+ep\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "RemoveLast",
+			Args: map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				getcap(h, "/usr/bin/node cap_net_bind_service=ep\n")
+				h.GetConnection().ExpectCommand("setcap -r '/usr/bin/node'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed cap_net_bind_service from /usr/bin/node")
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service+ep"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				getcap(h, "")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name:        "MissingFile",
+			Args:        map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service+ep"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if test -e `, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
@@ -19,24 +19,19 @@ func NewCommandModule() *CommandModule {
 	doc := types.ModuleDoc{
 		Name:        "command",
 		Description: "Execute commands on targets",
-		Parameters: map[string]types.ParamDoc{
+		Parameters: withExecGuardParams(map[string]types.ParamDoc{
 			"cmd": {
-				Description: "The command to execute",
-				Required:    true,
-				Type:        "string",
-			},
-			"chdir": {
-				Description: "Change to this directory before running the command",
+				Description: "The command to execute. Either cmd or argv is required",
 				Required:    false,
 				Type:        "string",
 			},
-			"creates": {
-				Description: "A filename or glob pattern. If it already exists, this step will not be run",
+			"argv": {
+				Description: "The command as a list of arguments, which are quoted for the shell. Mutually exclusive with cmd",
 				Required:    false,
-				Type:        "string",
+				Type:        "list",
 			},
-			"removes": {
-				Description: "A filename or glob pattern. If it does not exist, this step will not be run",
+			"chdir": {
+				Description: "Change to this directory before running the command",
 				Required:    false,
 				Type:        "string",
 			},
@@ -57,6 +52,12 @@ func NewCommandModule() *CommandModule {
 				Required:    false,
 				Type:        "string",
 			},
+			"stdin_add_newline": {
+				Description: "Add a newline to the end of stdin",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
 			"env": {
 				Description: "Environment variables to set for the command",
 				Required:    false,
@@ -73,7 +74,7 @@ func NewCommandModule() *CommandModule {
 				Required:    false,
 				Type:        "string",
 			},
-		},
+		}),
 		Examples: []string{
 			`- name: Return motd to registered var
   command: cat /etc/motd
@@ -86,12 +87,25 @@ func NewCommandModule() *CommandModule {
   command: /bin/long_running_command
   args:
     timeout: 300`,
+			`- name: Initialize the database once
+  command: /usr/bin/make_database.sh
+  args:
+    unless: test -s /var/lib/app/db.sqlite`,
+			`- name: Create the database unless it exists, given free-form
+  command: /usr/bin/make_database.sh arg1 creates=/var/lib/app/db.sqlite`,
+			`- name: Pass arguments without shell quoting
+  command:
+    argv:
+      - /usr/bin/useradd
+      - --comment
+      - "Deploy user"
+      - deploy`,
 		},
 		Returns: map[string]string{
 			"stdout":    "Standard output of the command",
 			"stderr":    "Standard error of the command",
 			"exit_code": "Exit code of the command",
-			"cmd":       "The executed command",
+			"cmd":       "The executed command, as a list when given as argv",
 		},
 	}
 
@@ -102,23 +116,32 @@ func NewCommandModule() *CommandModule {
 
 // Validate validates the module arguments
 func (m *CommandModule) Validate(args map[string]interface{}) error {
-	// Validate required fields
-	if err := m.ValidateRequired(args, []string{"cmd"}); err != nil {
-		return err
+	// Either cmd or argv must be provided, but not both
+	_, hasCmd := args["cmd"]
+	_, hasArgv := args["argv"]
+	if !hasCmd && !hasArgv {
+		return types.NewValidationError("cmd/argv", nil, "either cmd or argv must be provided")
+	}
+	if hasCmd && hasArgv {
+		return types.NewValidationError("cmd/argv", nil, "cmd and argv are mutually exclusive")
 	}
 
 	// Validate field types
 	fieldTypes := map[string]string{
-		"cmd":         "string",
-		"chdir":       "string",
-		"creates":     "string",
-		"removes":     "string",
-		"timeout":     "int",
-		"warn":        "bool",
-		"stdin":       "string",
-		"env":         "map",
-		"become":      "bool",
-		"become_user": "string",
+		"cmd":               "string",
+		"argv":              "slice",
+		"chdir":             "string",
+		"creates":           "string",
+		"removes":           "string",
+		"unless":            "string",
+		"onlyif":            "string",
+		"timeout":           "int",
+		"warn":              "bool",
+		"stdin":             "string",
+		"env":               "map",
+		"stdin_add_newline": "bool",
+		"become":            "bool",
+		"become_user":       "string",
 	}
 	if err := m.ValidateTypes(args, fieldTypes); err != nil {
 		return err
@@ -139,54 +162,26 @@ func (m *CommandModule) Run(ctx context.Context, conn types.Connection, args map
 	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
 		host := m.GetHostFromConnection(conn)
 
-		// Get parameters
+		// Get parameters. The arguments of argv are quoted for the shell.
 		cmd := m.GetStringArg(args, "cmd", "")
+		var reported interface{} = cmd
+		if argv := m.GetSliceArg(args, "argv"); argv != nil {
+			words := make([]string, len(argv))
+			for i, arg := range argv {
+				words[i] = m.escapeShell(types.ConvertToString(arg))
+			}
+			cmd = strings.Join(words, " ")
+			reported = argv
+		}
 		chdir := m.GetStringArg(args, "chdir", "")
-		creates := m.GetStringArg(args, "creates", "")
-		removes := m.GetStringArg(args, "removes", "")
 		timeoutSecs, _ := m.GetIntArg(args, "timeout", 30)
 		warn := m.GetBoolArg(args, "warn", true)
 		stdin := m.GetStringArg(args, "stdin", "")
+		stdinNewline := m.GetBoolArg(args, "stdin_add_newline", true)
 		envMap := m.GetMapArg(args, "env")
 		become := m.GetBoolArg(args, "become", false)
 		becomeUser := m.GetStringArg(args, "become_user", "")
 		
-		// Check mode handling
-		if m.CheckMode(args) {
-			return m.CreateCheckModeResult(host, true, fmt.Sprintf("Would execute: %s", cmd), map[string]interface{}{
-				"cmd": cmd,
-			}), nil
-		}
-
-		// Check creates condition
-		if creates != "" {
-			if exists, err := m.checkFileExists(conn, creates); err != nil {
-				return m.CreateErrorResult(host, "Failed to check creates condition", err), nil
-			} else if exists {
-				return m.CreateSuccessResult(host, false, fmt.Sprintf("Skipped, since %s exists", creates), map[string]interface{}{
-					"cmd":     cmd,
-					"skipped": true,
-				}), nil
-			}
-		}
-
-		// Check removes condition
-		if removes != "" {
-			if exists, err := m.checkFileExists(conn, removes); err != nil {
-				return m.CreateErrorResult(host, "Failed to check removes condition", err), nil
-			} else if !exists {
-				return m.CreateSuccessResult(host, false, fmt.Sprintf("Skipped, since %s does not exist", removes), map[string]interface{}{
-					"cmd":     cmd,
-					"skipped": true,
-				}), nil
-			}
-		}
-
-		// Show warnings for potentially dangerous commands
-		if warn {
-			m.checkAndWarnDangerousCommand(cmd)
-		}
-
 		// Prepare execution options
 		options := types.ExecuteOptions{
 			WorkingDir: chdir,
@@ -203,15 +198,30 @@ func (m *CommandModule) Run(ctx context.Context, conn types.Connection, args map
 			}
 		}
 
+		// Guards only read the host, so they are evaluated in check mode too
+		guards := execGuardsArg(args)
+		if skipped, err := guards.check(ctx, conn, options); err != nil {
+			return m.CreateErrorResult(host, "Failed to check guards", err), nil
+		} else if skipped != "" {
+			return m.CreateSuccessResult(host, false, skipped, map[string]interface{}{
+				"cmd":     reported,
+				"skipped": true,
+			}), nil
+		}
+
+		// Check mode handling
+		if m.CheckMode(args) {
+			return guards.checkModeResult(m.BaseModule, host, reported), nil
+		}
+
+		// Show warnings for potentially dangerous commands
+		if warn {
+			m.checkAndWarnDangerousCommand(cmd)
+		}
+
 		// Execute command with timeout handling
 		result, err := m.HandleTimeout(ctx, options.Timeout, func(timeoutCtx context.Context) (*types.Result, error) {
-			// Handle stdin if provided
-			finalCmd := cmd
-			if stdin != "" {
-				finalCmd = fmt.Sprintf("echo %s | %s", m.escapeShell(stdin), cmd)
-			}
-
-			return conn.Execute(timeoutCtx, finalCmd, options)
+			return conn.Execute(timeoutCtx, withStdin(cmd, stdin, stdinNewline), options)
 		})
 
 		if err != nil {
@@ -227,7 +237,7 @@ func (m *CommandModule) Run(ctx context.Context, conn types.Connection, args map
 			if result.Data == nil {
 				result.Data = make(map[string]interface{})
 			}
-			result.Data["cmd"] = cmd
+			result.Data["cmd"] = reported
 
 			// Warn about non-zero exit codes if not expected
 			if exitCode, ok := result.Data["exit_code"].(int); ok && exitCode != 0 && result.Success {
@@ -239,17 +249,6 @@ func (m *CommandModule) Run(ctx context.Context, conn types.Connection, args map
 	})
 }
 
-// checkFileExists checks if a file exists using the connection
-func (m *CommandModule) checkFileExists(conn types.Connection, path string) (bool, error) {
-	// Use a simple test command to check if file exists
-	result, err := conn.Execute(context.Background(), fmt.Sprintf("test -e %s", m.escapeShell(path)), types.ExecuteOptions{})
-	if err != nil {
-		return false, err
-	}
-
-	return result.Success, nil
-}
-
 // escapeShell escapes shell special characters
 func (m *CommandModule) escapeShell(input string) string {
 	// Simple shell escaping - in production, use a more robust solution
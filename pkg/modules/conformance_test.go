@@ -0,0 +1,597 @@
+package modules
+
+import (
+	"sort"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+func TestBuiltinModuleConformance(t *testing.T) {
+	registry := NewModuleRegistry()
+	names := registry.ListModules()
+	sort.Strings(names)
+
+	for _, name := range names {
+		module, err := registry.GetModule(name)
+		if err != nil {
+			t.Fatalf("failed to get module %s: %v", name, err)
+		}
+		t.Run(name, func(t *testing.T) {
+			testhelper.RunConformance(t, module, conformanceSpecs[name])
+		})
+	}
+}
+
+// conformanceSpecs gives the modules whose minimal arguments cannot be
+// derived from their documentation a valid set, and adds host states to
+// exercise check mode, diff and idempotence against
+var conformanceSpecs = map[string]testhelper.ConformanceSpec{
+	"alertmanager_config": {Args: map[string]interface{}{"content": "route:\n  receiver: default\n"}},
+	"apk":                 {Args: map[string]interface{}{"name": "curl"}},
+	"apt":                 {Args: map[string]interface{}{"name": "curl"}},
+	"archive":             {Args: map[string]interface{}{"path": "/srv/app", "dest": "/tmp/app.tar.gz"}},
+	"btrfs_snapshot":      {Args: map[string]interface{}{"source": "/srv/data", "dest": "/srv/.snapshots/data"}},
+	"command":             {Args: map[string]interface{}{"cmd": "true"}},
+	"consul_kv":           {Args: map[string]interface{}{"key": "app/config", "value": "on", "host": "127.0.0.1", "port": 1}},
+	"copy":                {Args: map[string]interface{}{"content": "hello\n", "dest": "/tmp/conformance"}},
+	"debug":               {Args: map[string]interface{}{"msg": "hello"}},
+	"dnf":                 {Args: map[string]interface{}{"name": "curl"}},
+	"dns_client":          {Args: map[string]interface{}{"nameservers": []interface{}{"192.0.2.53"}}},
+	"etcd3":               {Args: map[string]interface{}{"key": "app/config", "value": "on", "host": "127.0.0.1", "port": 1}},
+	"gem":                 {Args: map[string]interface{}{"name": "bundler"}},
+	"grafana_dashboard":   {Args: map[string]interface{}{"grafana_url": "http://127.0.0.1:1", "grafana_api_key": "key", "dashboard": map[string]interface{}{"title": "App"}}},
+	"grafana_datasource":  {Args: map[string]interface{}{"name": "prometheus", "grafana_url": "http://127.0.0.1:1", "grafana_api_key": "key", "ds_type": "prometheus", "ds_url": "http://127.0.0.1:9090"}},
+	"homebrew":            {Args: map[string]interface{}{"name": "wget"}},
+	"iptables":            {Args: map[string]interface{}{"chain": "INPUT", "jump": "ACCEPT"}},
+	"java_cert": {
+		Args: map[string]interface{}{"keystore_path": "/opt/app/truststore.jks", "keystore_pass": "changeit", "cert_alias": "app", "cert_content": testCertificatePEM},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Certificate",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "keytool error: java.lang.Exception: Alias <app> does not exist\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "Alias name: app\n\t SHA256: " + testCertificateSHA256 + "\n"})
+			},
+			Mutating: []string{`-importcert`, `-importkeystore`, `-delete`},
+		}},
+	},
+	"java_keystore": {
+		Args: map[string]interface{}{"name": "app", "dest": "/opt/app/keystore.p12", "password": "changeit", "certificate": testCertificatePEM, "private_key": testPrivateKeyPEM},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Keystore",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "600 root root\nKeystore type: PKCS12\n\nAlias name: app\n\t SHA256: " + testCertificateSHA256 + "\n"})
+			},
+			Mutating: []string{`openssl pkcs12`, `^chmod `, `^rm -f `},
+		}},
+	},
+	"keycloak_group": {Args: map[string]interface{}{"auth_keycloak_url": "http://127.0.0.1:1", "name": "admins", "token": "token"}},
+	"keycloak_user":  {Args: map[string]interface{}{"auth_keycloak_url": "http://127.0.0.1:1", "username": "alice", "token": "token"}},
+	"ldap_entry":     {Args: map[string]interface{}{"dn": "cn=alice,dc=example,dc=com", "objectClass": []interface{}{"person"}}},
+	"modprobe": {
+		Args: map[string]interface{}{"name": "loop", "persistent": "present"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Persistent",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if awk -v m='loop' `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if awk -v m='loop' `, &testhelper.CommandResponse{Stdout: "loaded\n"})
+				conn.ExpectCommandPattern(`^if \[ -f '/etc/modules-load.d/loop.conf' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "loop\n"})
+			},
+			Mutating: []string{`^modprobe `, `^mkdir -p `, `^rm -f `},
+		}},
+	},
+	"mount": {
+		Args: map[string]interface{}{"path": "/mnt/data", "src": "/dev/sdb1", "fstype": "ext4"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "MountedEntry",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("cat /proc/mounts", &testhelper.CommandResponse{Stdout: "sysfs /sys sysfs rw 0 0\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f '/etc/fstab' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "/dev/sdb1 /mnt/data ext4 defaults 0 0\n"})
+				conn.ExpectCommand("cat /proc/mounts", &testhelper.CommandResponse{Stdout: "/dev/sdb1 /mnt/data ext4 rw,relatime 0 0\n"})
+			},
+			Mutating: []string{`mv -f "\$tmp" '/etc/fstab'`, `mount -t `},
+		}},
+	},
+	"pip":             {Args: map[string]interface{}{"name": "requests"}},
+	"prometheus_rule": {Args: map[string]interface{}{"path": "/etc/prometheus/rules/app.yml", "content": "groups: []\n"}},
+	"script":          {Args: map[string]interface{}{"cmd": "setup.sh"}},
+	"sysctl": {
+		Args: map[string]interface{}{"name": "vm.swappiness", "value": "10"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Present",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("sysctl -n 'vm.swappiness'", &testhelper.CommandResponse{Stdout: "60\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("sysctl -n 'vm.swappiness'", &testhelper.CommandResponse{Stdout: "10\n"})
+				conn.ExpectCommandPattern(`^if \[ -f '/etc/sysctl.d/99-gosible.conf' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "vm.swappiness = 10\n"})
+			},
+			Mutating: []string{`^sysctl (-w|-p)`, `^mkdir -p `},
+		}},
+	},
+	"network_interface": {
+		Args: map[string]interface{}{"name": "eth1", "addresses": []interface{}{"192.0.2.10/24"}, "provider": "netplan"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Netplan",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f '/etc/netplan/90-gosible-eth1.yaml' \]`, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f '/etc/netplan/90-gosible-eth1.yaml' \]`, &testhelper.CommandResponse{Stdout: existsMarker + netplanFiles(networkSpec{name: "eth1", kind: "ethernet", addresses: []string{"192.0.2.10/24"}})[0].content})
+			},
+			Mutating: []string{`^rm -rf `, `^netplan `, `^nohup `},
+		}},
+	},
+	"pacman": {
+		Args: map[string]interface{}{"name": "curl"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Package",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^for p in 'curl'; `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^for p in 'curl'; `, &testhelper.CommandResponse{Stdout: "curl\n"})
+			},
+			Mutating: []string{`^pacman -(S|R|Rs) `},
+		}},
+	},
+	"sysfs": {
+		Args: map[string]interface{}{"path": "/proc/sys/vm/swappiness", "value": "10", "persistent": false},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Tunable",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("cat '/proc/sys/vm/swappiness'", &testhelper.CommandResponse{Stdout: "60\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("cat '/proc/sys/vm/swappiness'", &testhelper.CommandResponse{Stdout: "10\n"})
+			},
+			Mutating: []string{`^printf `, `^rm -f `, `^mkdir -p `},
+		}},
+	},
+	"timesync": {Args: map[string]interface{}{"servers": []interface{}{"pool.ntp.org"}}},
+	"hostname": {
+		Args: map[string]interface{}{"name": "web01"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Systemd",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^echo "current=`, &testhelper.CommandResponse{Stdout: "current=localhost\nsystemd=localhost\nfile=localhost\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^echo "current=`, &testhelper.CommandResponse{Stdout: "current=web01\nsystemd=web01\nfile=web01\n"})
+			},
+			Mutating: []string{`^hostnamectl `, `> /etc/hostname`, `^sed -i `},
+		}},
+	},
+	"timezone": {
+		Args: map[string]interface{}{"name": "Europe/Berlin"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Zone",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if command -v timedatectl `, &testhelper.CommandResponse{Stdout: "systemd=yes\nlocaltime=/usr/share/zoneinfo/Etc/UTC\nzone=yes\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if command -v timedatectl `, &testhelper.CommandResponse{Stdout: "systemd=yes\nlocaltime=/usr/share/zoneinfo/Europe/Berlin\nzone=yes\n"})
+			},
+			Mutating: []string{`^timedatectl `, `^ln `, `^hwclock `},
+		}},
+	},
+	"locale_gen": {
+		Args: map[string]interface{}{"name": "de_DE.UTF-8"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Locale",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^echo "supported=`, &testhelper.CommandResponse{Stdout: "supported=UTF-8\ngen=yes\navailable=C.utf8\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^echo "supported=`, &testhelper.CommandResponse{Stdout: "supported=UTF-8\ngen=yes\navailable=C.utf8\navailable=de_DE.utf8\n"})
+			},
+			Mutating: []string{`^sed `, `^localedef `},
+		}},
+	},
+	"tuned": {
+		Args: map[string]interface{}{"name": "throughput-performance"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Profile",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^command -v tuned-adm `, &testhelper.CommandResponse{Stdout: "daemon=active\nCurrent active profile: balanced\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^command -v tuned-adm `, &testhelper.CommandResponse{Stdout: "daemon=active\nCurrent active profile: throughput-performance\n"})
+				conn.ExpectCommand("tuned-adm verify", &testhelper.CommandResponse{Stdout: "Verification succeeded\n"})
+			},
+			Mutating: []string{`^tuned-adm (profile|off)`, `^systemctl `, `^mkdir -p `},
+		}},
+	},
+	"unarchive": {
+		Args: map[string]interface{}{"src": "/tmp/app.tar.gz", "dest": "/srv/app", "remote_src": true},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Tarball",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^dest='/srv/app'; stage=`, &testhelper.CommandResponse{Stdout: "stage=/srv/.gosible-unarchive.k2P\nfile=bin\nnew=bin\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^dest='/srv/app'; stage=`, &testhelper.CommandResponse{Stdout: "stage=/srv/.gosible-unarchive.k2P\nfile=bin\n"})
+			},
+			Mutating: []string{`tar -xpf - -C `},
+		}},
+	},
+	"win_copy": {
+		Args: map[string]interface{}{"content": "hello\n", "dest": `C:\app\motd.txt`},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Content",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-FileHash`, &testhelper.CommandResponse{Stdout: `[{"exists":false,"directory":false}]`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-FileHash`, &testhelper.CommandResponse{Stdout: `[{"exists":true,"directory":false,"size":6,"checksum":"5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"}]`})
+			},
+			Mutating: []string{`Copy-Item`},
+		}},
+	},
+	"win_dsc": {
+		Args: map[string]interface{}{"resource_name": "File", "properties": map[string]interface{}{"DestinationPath": `C:\app\motd.txt`, "Contents": "hello"}},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Resource",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`-Method Test `, &testhelper.CommandResponse{Stdout: `{"InDesiredState":false}`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`-Method Test `, &testhelper.CommandResponse{Stdout: `{"InDesiredState":true}`})
+			},
+			Mutating: []string{`-Method Set `},
+		}},
+	},
+	"win_feature": {
+		Args: map[string]interface{}{"name": "Web-Server"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Feature",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-WindowsFeature`, &testhelper.CommandResponse{Stdout: `[{"name":"Web-Server","installed":false}]`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-WindowsFeature`, &testhelper.CommandResponse{Stdout: `[{"name":"Web-Server","installed":true}]`})
+			},
+			Mutating: []string{`Install-WindowsFeature`, `Uninstall-WindowsFeature`},
+		}},
+	},
+	"win_regedit": {
+		Args: map[string]interface{}{"path": `HKLM:\Software\Gosible`, "name": "Enabled", "type": "dword", "data": 1},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Value",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`ConvertTo-Json`, &testhelper.CommandResponse{Stdout: `{"key":true,"value":false}`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`ConvertTo-Json`, &testhelper.CommandResponse{Stdout: `{"key":true,"value":true,"type":"DWord","data":1}`})
+			},
+			Mutating: []string{`New-Item`, `Remove-Item`},
+		}},
+	},
+	"win_service": {
+		Args: map[string]interface{}{"name": "Spooler", "state": "stopped", "start_mode": "disabled"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Service",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Win32_Service`, &testhelper.CommandResponse{Stdout: `{"exists":true,"state":"Running","start_mode":"Auto","display_name":"Print Spooler","username":"LocalSystem"}`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Win32_Service`, &testhelper.CommandResponse{Stdout: `{"exists":true,"state":"Stopped","start_mode":"Disabled","display_name":"Print Spooler","username":"LocalSystem"}`})
+			},
+			Mutating: []string{`Set-Service`, `Stop-Service`, `Start-Service`},
+		}},
+	},
+	"win_user": {
+		Args: map[string]interface{}{"name": "svc-app", "fullname": "Application", "groups": []interface{}{"Users"}},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "User",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-LocalUser`, &testhelper.CommandResponse{Stdout: `{"exists":false,"groups":[]}`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`Get-LocalUser`, &testhelper.CommandResponse{Stdout: `{"exists":true,"full_name":"Application","groups":["Users"]}`})
+			},
+			Mutating: []string{`New-LocalUser`, `Set-LocalUser`, `Add-LocalGroupMember`, `Remove-LocalGroupMember`},
+		}},
+	},
+	"yum": {Args: map[string]interface{}{"name": "curl"}},
+	"zypper": {
+		Args: map[string]interface{}{"name": "curl"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Package",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^for p in 'curl'; `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^for p in 'curl'; `, &testhelper.CommandResponse{Stdout: "curl\n"})
+			},
+			Mutating: []string{`^zypper --non-interactive (install|update|remove) `},
+		}},
+	},
+	"docker_container": {
+		Args: map[string]interface{}{"name": "web", "image": "nginx:1.27", "ports": []interface{}{"8080:80"}},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Container",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if docker container inspect 'web' `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if docker container inspect 'web' `, &testhelper.CommandResponse{Stdout: existsMarker + `{"Image":"sha256:1f6a","State":{"Status":"running"},"Config":{"Image":"nginx:1.27"},"HostConfig":{"PortBindings":{"80/tcp":[{"HostIp":"","HostPort":"8080"}]}}}`})
+				conn.ExpectCommandPattern(`^if docker image inspect 'nginx:1.27' `, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:1f6a\n"})
+			},
+			Mutating: []string{`^docker (run|create|start|stop|rm) `},
+		}},
+	},
+	"docker_image": {
+		Args: map[string]interface{}{"name": "nginx", "tag": "1.27"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Image",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if docker image inspect 'nginx:1.27' `, &testhelper.CommandResponse{})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if docker image inspect 'nginx:1.27' `, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:1f6a\n"})
+			},
+			Mutating: []string{`^docker (pull|build|tag|image rm) `},
+		}},
+	},
+	"password_rotate": {
+		Args: map[string]interface{}{"name": "deploy", "password": "s3cret"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Password",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if getent passwd 'deploy' `, &testhelper.CommandResponse{Stdout: existsMarker + "deploy:" + oldPasswordHash + ":19800:0:99999:7:::\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if getent passwd 'deploy' `, &testhelper.CommandResponse{Stdout: existsMarker + "deploy:" + newPasswordHash + ":19800:0:99999:7:::\n"})
+			},
+			Mutating: []string{`chpasswd`},
+		}},
+	},
+	"ssh_key_rotate": {
+		Args: map[string]interface{}{"user": "deploy", "key": newDeployKey, "old_keys": []interface{}{oldDeployKey}, "verify": false},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Keys",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if getent passwd 'deploy' `, &testhelper.CommandResponse{Stdout: existsMarker + "deploy:x:1001:1001::/home/deploy:/bin/bash\n"})
+				conn.ExpectCommandPattern(`^if test -f `, &testhelper.CommandResponse{Stdout: existsMarker + oldDeployKey + "\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if getent passwd 'deploy' `, &testhelper.CommandResponse{Stdout: existsMarker + "deploy:x:1001:1001::/home/deploy:/bin/bash\n"})
+				conn.ExpectCommandPattern(`^if test -f `, &testhelper.CommandResponse{Stdout: existsMarker + newDeployKey + "\n"})
+			},
+			Mutating: []string{`>> `, `authorized_keys.gosible`},
+		}},
+	},
+	"win_service_password": {
+		Args: map[string]interface{}{"username": `.\svc-app`, "password": "s3cret"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Services",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`StartName IS NOT NULL`, &testhelper.CommandResponse{Stdout: `[{"name":"AppSvc","username":".\\svc-app","state":"Running"}]`})
+				conn.ExpectCommandPattern(`ValidateCredentials`, &testhelper.CommandResponse{Stdout: "true"})
+			},
+			Mutating: []string{`Invoke-CimMethod`, `Restart-Service`},
+		}},
+	},
+	"docker_compose": {
+		Args: map[string]interface{}{"project_src": "/srv/app"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Stack",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`config '--format' 'json'$`, &testhelper.CommandResponse{Stdout: `{"name":"app","services":{"web":{"image":"nginx:1.27"}}}`})
+				conn.ExpectCommandPattern(`config '--hash=\*'$`, &testhelper.CommandResponse{Stdout: "web 1f6a\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`config '--format' 'json'$`, &testhelper.CommandResponse{Stdout: `{"name":"app","services":{"web":{"image":"nginx:1.27"}}}`})
+				conn.ExpectCommandPattern(`config '--hash=\*'$`, &testhelper.CommandResponse{Stdout: "web 1f6a\n"})
+				conn.ExpectCommandPattern(`^docker ps `, &testhelper.CommandResponse{Stdout: "web\tc1\trunning\t1f6a\n"})
+			},
+			Mutating: []string{` (up|down) `},
+		}},
+	},
+	"k8s": {
+		Args: map[string]interface{}{"definition": map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "app", "namespace": "shop"}, "data": map[string]interface{}{"mode": "on"}}},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "ConfigMap",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`'--dry-run=server'`, &testhelper.CommandResponse{Stdout: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app","namespace":"shop"},"data":{"mode":"on"}}`})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(` get '--ignore-not-found'`, &testhelper.CommandResponse{Stdout: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app","namespace":"shop","resourceVersion":"7"},"data":{"mode":"on"}}`})
+				conn.ExpectCommandPattern(`'--dry-run=server'`, &testhelper.CommandResponse{Stdout: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app","namespace":"shop","resourceVersion":"7"},"data":{"mode":"on"}}`})
+			},
+			Mutating: []string{`--field-manager' 'gosible' --filename -$`, ` delete `},
+		}},
+	},
+	"git": {
+		Args: map[string]interface{}{"repo": "https://git.example.com/app.git", "dest": "/srv/app"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "DefaultBranch",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`'ls-remote' '--symref'`, &testhelper.CommandResponse{Stdout: "ref: refs/heads/main\tHEAD\n2222222222222222222222222222222222222222\tHEAD\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if test -e '/srv/app/\.git' `, &testhelper.CommandResponse{Stdout: existsMarker + "head=2222222222222222222222222222222222222222\nurl=https://git.example.com/app.git\n"})
+				conn.ExpectCommandPattern(`'ls-remote' '--symref'`, &testhelper.CommandResponse{Stdout: "ref: refs/heads/main\tHEAD\n2222222222222222222222222222222222222222\tHEAD\n"})
+			},
+			Mutating: []string{` 'clone' `, ` 'fetch' `, ` 'checkout' `, ` 'submodule' 'update' `},
+		}},
+	},
+	"get_url": {
+		Args: map[string]interface{}{"url": "https://downloads.example.com/app.tar.gz", "dest": "/opt/app.tar.gz", "checksum": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Checksum",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "checksum=2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae\nmode=644\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "checksum=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\nmode=644\n"})
+			},
+			Mutating: []string{`^tmp=`, `mv -f `, `^chmod `},
+		}},
+	},
+	"firewalld": {
+		Args: map[string]interface{}{"service": "https", "zone": "public"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Service",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`--permanent --zone='public' --query-service='https'`, &testhelper.CommandResponse{Stdout: "no\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^firewall-cmd --permanent --zone='public' --query-service='https'`, &testhelper.CommandResponse{Stdout: "yes\n"})
+				conn.ExpectCommandPattern(`^firewall-cmd --zone='public' --query-service='https'`, &testhelper.CommandResponse{Stdout: "yes\n"})
+			},
+			Mutating: []string{`--add-service`},
+		}},
+	},
+	"ufw": {
+		Args: map[string]interface{}{"rule": "limit", "port": "22", "proto": "tcp"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Rule",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^ufw --dry-run limit `, &testhelper.CommandResponse{Stdout: "Rule added\nRule added (v6)\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^ufw --dry-run limit `, &testhelper.CommandResponse{Stdout: "Skipping adding existing rule\nSkipping adding existing rule (v6)\n"})
+			},
+			Mutating: []string{`^ufw limit `},
+		}},
+	},
+	"selinux": {
+		Args: map[string]interface{}{"state": "enforcing"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Permissive",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("getenforce 2>/dev/null || echo Disabled", &testhelper.CommandResponse{Stdout: "Permissive\n"})
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "SELINUX=enforcing\nSELINUXTYPE=targeted\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("getenforce 2>/dev/null || echo Disabled", &testhelper.CommandResponse{Stdout: "Enforcing\n"})
+				conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "SELINUX=enforcing\nSELINUXTYPE=targeted\n"})
+			},
+			Mutating: []string{`^setenforce`, `mktemp`},
+		}},
+	},
+	"seboolean": {
+		Args: map[string]interface{}{"name": "httpd_can_network_connect", "state": true},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Off",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("getsebool 'httpd_can_network_connect'", &testhelper.CommandResponse{Stdout: "httpd_can_network_connect --> off\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("getsebool 'httpd_can_network_connect'", &testhelper.CommandResponse{Stdout: "httpd_can_network_connect --> on\n"})
+			},
+			Mutating: []string{`^setsebool`},
+		}},
+	},
+	"sefcontext": {
+		Args: map[string]interface{}{"target": "/srv/web(/.*)?", "setype": "httpd_sys_content_t"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Mapping",
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommand("semanage fcontext -l -C -n", &testhelper.CommandResponse{Stdout: "/srv/web(/.*)?    all files    system_u:object_r:httpd_sys_content_t:s0\n"})
+			},
+			Mutating: []string{`^semanage fcontext -[adm] `},
+		}},
+	},
+	"apparmor_profile": {
+		Args: map[string]interface{}{"name": "/usr/sbin/nginx"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Complain",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^cat /sys/module/apparmor`, &testhelper.CommandResponse{Stdout: "Y\n/usr/sbin/nginx (complain)\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^cat /sys/module/apparmor`, &testhelper.CommandResponse{Stdout: "Y\n/usr/sbin/nginx (enforce)\n"})
+			},
+			Mutating: []string{`^aa-`},
+		}},
+	},
+	"acl": {
+		Args: map[string]interface{}{"path": "/srv/app", "entry": "user:deploy:rx", "state": "present"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Entry",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^getfacl `, &testhelper.CommandResponse{Stdout: "# file: /srv/app\nuser::rwx\nuser:deploy:r--\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^getfacl `, &testhelper.CommandResponse{Stdout: "# file: /srv/app\nuser::rwx\nuser:deploy:r-x\n"})
+			},
+			Mutating: []string{`^setfacl `},
+		}},
+	},
+	"capabilities": {
+		Args: map[string]interface{}{"path": "/usr/bin/node", "capability": "cap_net_bind_service+ep"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Capability",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if test -e `, &testhelper.CommandResponse{Stdout: existsMarker})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`^if test -e `, &testhelper.CommandResponse{Stdout: existsMarker + "/usr/bin/node cap_net_bind_service=ep\n"})
+			},
+			Mutating: []string{`^setcap `},
+		}},
+	},
+	"postgresql_db": {
+		Args: map[string]interface{}{"name": "app", "owner": "app"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Database",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`pg_catalog.pg_database`, &testhelper.CommandResponse{Stdout: "postgres\tUTF8\tC\tC\t-1\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`pg_catalog.pg_database`, &testhelper.CommandResponse{Stdout: "app\tUTF8\tC\tC\t-1\n"})
+			},
+			Mutating: []string{`\| psql `},
+		}},
+	},
+	"postgresql_user": {
+		Args: map[string]interface{}{"name": "app", "db": "app", "priv": "CONNECT"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Grant",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`-c 'SELECT rolsuper`, &testhelper.CommandResponse{Stdout: "f\tf\tf\tt\tt\tf\tf\t-1\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`-c 'SELECT rolsuper`, &testhelper.CommandResponse{Stdout: "f\tf\tf\tt\tt\tf\tf\t-1\n"})
+				conn.ExpectCommandPattern(`aclexplode`, &testhelper.CommandResponse{Stdout: "CONNECT\n"})
+			},
+			Mutating: []string{`\| psql `},
+		}},
+	},
+	"mysql_db": {
+		Args: map[string]interface{}{"name": "app"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Database",
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`information_schema.SCHEMATA`, &testhelper.CommandResponse{Stdout: "utf8mb4\tutf8mb4_0900_ai_ci\n"})
+			},
+			Mutating: []string{`\| mysql `},
+		}},
+	},
+	"mysql_user": {
+		Args: map[string]interface{}{"name": "app", "priv": "app.*:SELECT"},
+		Cases: []testhelper.ConformanceCase{{
+			Name: "Grant",
+			Pending: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`FROM mysql.user`, &testhelper.CommandResponse{Stdout: "caching_sha2_password\t\n"})
+			},
+			Converged: func(conn *testhelper.MockConnection) {
+				conn.ExpectCommandPattern(`FROM mysql.user`, &testhelper.CommandResponse{Stdout: "caching_sha2_password\t\n"})
+				conn.ExpectCommandPattern(`SHOW GRANTS`, &testhelper.CommandResponse{Stdout: "GRANT USAGE ON *.* TO `app`@`localhost`\nGRANT SELECT ON `app`.* TO `app`@`localhost`\n"})
+			},
+			Mutating: []string{`\| mysql`},
+		}},
+	},
+}
@@ -0,0 +1,784 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// consulConnectionParams documents the options shared by the Consul modules
+var consulConnectionParams = map[string]types.ParamDoc{
+	"host": {
+		Description: "Consul agent host",
+		Required:    false,
+		Type:        "string",
+		Default:     "localhost",
+	},
+	"port": {
+		Description: "Consul HTTP API port",
+		Required:    false,
+		Type:        "int",
+		Default:     8500,
+	},
+	"scheme": {
+		Description: "Protocol of the HTTP API",
+		Required:    false,
+		Type:        "string",
+		Default:     "http",
+		Choices:     []string{"http", "https"},
+	},
+	"token": {
+		Description: "ACL token sent with every request",
+		Required:    false,
+		Type:        "string",
+	},
+	"datacenter": {
+		Description: "Datacenter to query; defaults to the agent's datacenter",
+		Required:    false,
+		Type:        "string",
+	},
+	"validate_certs": {
+		Description: "Verify the server certificate when scheme is https",
+		Required:    false,
+		Type:        "bool",
+		Default:     true,
+	},
+}
+
+// errConsulNotFound is returned for 404 responses from the HTTP API
+var errConsulNotFound = errors.New("consul resource not found")
+
+// consulClient talks to the Consul HTTP API. Like the Grafana modules,
+// requests are made from the control node.
+type consulClient struct {
+	baseURL    string
+	token      string
+	datacenter string
+	client     *http.Client
+}
+
+// newConsulClient creates a client from the shared connection options
+func newConsulClient(m *BaseModule, args map[string]interface{}) (*consulClient, error) {
+	port, err := m.GetIntArg(args, "port", 8500)
+	if err != nil {
+		return nil, types.NewValidationError("port", args["port"], "port must be an integer")
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if !m.GetBoolArg(args, "validate_certs", true) {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	return &consulClient{
+		baseURL:    fmt.Sprintf("%s://%s:%d", m.GetStringArg(args, "scheme", "http"), m.GetStringArg(args, "host", "localhost"), port),
+		token:      m.GetStringArg(args, "token", ""),
+		datacenter: m.GetStringArg(args, "datacenter", ""),
+		client:     httpClient,
+	}, nil
+}
+
+// do calls an API endpoint and decodes the JSON response into out. A
+// []byte body is sent as is, anything else is encoded as JSON.
+func (c *consulClient) do(ctx context.Context, method, endpoint string, query url.Values, body, out interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+	target := c.baseURL + endpoint
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul %s %s failed: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errConsulNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul %s %s returned %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid consul response for %s %s: %w", method, endpoint, err)
+		}
+	}
+	return nil
+}
+
+// consulKVPair is a key as returned by the KV endpoint
+type consulKVPair struct {
+	Key         string `json:"Key"`
+	Value       []byte `json:"Value"` // base64 in JSON
+	Flags       uint64 `json:"Flags"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+}
+
+func validateConsulArgs(m *BaseModule, args map[string]interface{}, states []string) error {
+	if _, err := m.GetIntArg(args, "port", 8500); err != nil {
+		return types.NewValidationError("port", args["port"], "port must be an integer")
+	}
+	if err := m.ValidateChoices(args, "scheme", []string{"http", "https"}); err != nil {
+		return err
+	}
+	return m.ValidateChoices(args, "state", states)
+}
+
+// ConsulKVModule reads, writes and deletes keys in the Consul KV store
+type ConsulKVModule struct {
+	*BaseModule
+}
+
+// NewConsulKVModule creates a new consul_kv module instance
+func NewConsulKVModule() *ConsulKVModule {
+	params := map[string]types.ParamDoc{
+		"key": {
+			Description: "Key to manage",
+			Required:    true,
+			Type:        "string",
+		},
+		"value": {
+			Description: "Value to store; required when state is present",
+			Required:    false,
+			Type:        "string",
+		},
+		"flags": {
+			Description: "Opaque integer stored with the key",
+			Required:    false,
+			Type:        "int",
+		},
+		"cas": {
+			Description: "Only write or delete when the key's modify index still matches; 0 writes only if the key does not exist",
+			Required:    false,
+			Type:        "int",
+		},
+		"recurse": {
+			Description: "Treat key as a prefix when reading or deleting",
+			Required:    false,
+			Type:        "bool",
+			Default:     false,
+		},
+		"state": {
+			Description: "present writes the value, absent deletes the key and get only reads it",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent", "get"},
+		},
+	}
+	for name, doc := range consulConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "consul_kv",
+		Description: "Put, get and delete keys in the Consul KV store",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Publish the deployed version\n  consul_kv:\n    key: services/checkout/version\n    value: \"{{ release }}\"\n    token: \"{{ consul_token }}\"",
+			"- name: Read the feature flags\n  consul_kv:\n    key: flags/\n    recurse: true\n    state: get\n  register: flags",
+			"- name: Remove the maintenance marker\n  consul_kv:\n    key: services/checkout/maintenance\n    state: absent",
+		},
+		Returns: map[string]string{
+			"value": "Value of the key after the task, when it exists",
+			"index": "Modify index of the key before the task",
+			"data":  "Keys and values under the prefix with recurse and state=get",
+		},
+	}
+
+	base := NewBaseModule("consul_kv", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &ConsulKVModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ConsulKVModule) Validate(args map[string]interface{}) error {
+	if err := validateConsulArgs(m.BaseModule, args, []string{"present", "absent", "get"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "key", "") == "" {
+		return types.NewValidationError("key", nil, "required parameter")
+	}
+	if m.GetStringArg(args, "state", "present") == "present" {
+		if _, exists := args["value"]; !exists {
+			return types.NewValidationError("value", nil, "required when state is present")
+		}
+		if m.GetBoolArg(args, "recurse", false) {
+			return types.NewValidationError("recurse", true, "recurse cannot be used when state is present")
+		}
+	}
+	for _, name := range []string{"flags", "cas"} {
+		if _, exists := args[name]; exists {
+			if n, err := m.GetIntArg(args, name, 0); err != nil || n < 0 {
+				return types.NewValidationError(name, args[name], name+" must be a non-negative integer")
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the consul_kv module
+func (m *ConsulKVModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	key := strings.TrimPrefix(m.GetStringArg(args, "key", ""), "/")
+	state := m.GetStringArg(args, "state", "present")
+	recurse := m.GetBoolArg(args, "recurse", false)
+
+	client, err := newConsulClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if recurse {
+		query.Set("recurse", "true")
+	}
+	var pairs []consulKVPair
+	err = client.do(ctx, http.MethodGet, "/v1/kv/"+key, query, nil, &pairs)
+	if err != nil && !errors.Is(err, errConsulNotFound) {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"key":   key,
+		"state": state,
+	})
+	var current *consulKVPair
+	for i := range pairs {
+		if pairs[i].Key == key {
+			current = &pairs[i]
+		}
+	}
+	if current != nil {
+		result.Data["value"] = string(current.Value)
+		result.Data["index"] = current.ModifyIndex
+		result.Data["flags"] = current.Flags
+	}
+
+	var change, before, after string
+	switch state {
+	case "get":
+		if recurse {
+			data := make(map[string]interface{}, len(pairs))
+			for _, pair := range pairs {
+				data[pair.Key] = string(pair.Value)
+			}
+			result.Data["data"] = data
+		}
+
+	case "absent":
+		if len(pairs) == 0 {
+			break
+		}
+		change = fmt.Sprintf("deleted %s", key)
+		if current != nil {
+			before = string(current.Value) + "\n"
+		}
+		if !checkMode {
+			if cas, exists := args["cas"]; exists {
+				query.Set("cas", types.ConvertToString(cas))
+			}
+			var deleted bool
+			if err := client.do(ctx, http.MethodDelete, "/v1/kv/"+key, query, nil, &deleted); err != nil {
+				return nil, err
+			}
+			if !deleted {
+				return m.CreateErrorResult(hostname, fmt.Sprintf("Key %s was modified since index %v", key, args["cas"]), nil), nil
+			}
+		}
+		delete(result.Data, "value")
+
+	case "present":
+		value := m.GetStringArg(args, "value", "")
+		flags, _ := m.GetIntArg(args, "flags", 0)
+		if current != nil && string(current.Value) == value && (args["flags"] == nil || current.Flags == uint64(flags)) {
+			break
+		}
+		change = fmt.Sprintf("set %s", key)
+		if current != nil {
+			before = string(current.Value) + "\n"
+		}
+		after = value + "\n"
+
+		if !checkMode {
+			put := url.Values{}
+			if args["flags"] != nil {
+				put.Set("flags", strconv.Itoa(flags))
+			}
+			if cas, exists := args["cas"]; exists {
+				put.Set("cas", types.ConvertToString(cas))
+			}
+			var written bool
+			if err := client.do(ctx, http.MethodPut, "/v1/kv/"+key, put, []byte(value), &written); err != nil {
+				return nil, err
+			}
+			if !written {
+				return m.CreateErrorResult(hostname, fmt.Sprintf("Key %s was modified since index %v", key, args["cas"]), nil), nil
+			}
+		}
+		result.Data["value"] = value
+	}
+
+	result.Changed = change != ""
+	result.Message = grafanaMessage(change, checkMode, "Consul key is already in desired state")
+	if checkMode && result.Changed {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// ConsulServiceModule registers services and their health check with the
+// local Consul agent
+type ConsulServiceModule struct {
+	*BaseModule
+}
+
+// NewConsulServiceModule creates a new consul module instance
+func NewConsulServiceModule() *ConsulServiceModule {
+	params := map[string]types.ParamDoc{
+		"service_name": {
+			Description: "Name the service is discovered by",
+			Required:    true,
+			Type:        "string",
+		},
+		"service_id": {
+			Description: "Unique id of the service instance on the agent",
+			Required:    false,
+			Type:        "string",
+			Default:     "service_name",
+		},
+		"service_address": {
+			Description: "Address of the service; defaults to the agent's address",
+			Required:    false,
+			Type:        "string",
+		},
+		"service_port": {
+			Description: "Port of the service",
+			Required:    false,
+			Type:        "int",
+		},
+		"tags": {
+			Description: "Tags of the service",
+			Required:    false,
+			Type:        "list",
+		},
+		"meta": {
+			Description: "Key/value metadata of the service",
+			Required:    false,
+			Type:        "dict",
+		},
+		"check_id": {
+			Description: "Id of the health check",
+			Required:    false,
+			Type:        "string",
+			Default:     "service:<service_id>",
+		},
+		"check_name": {
+			Description: "Name of the health check",
+			Required:    false,
+			Type:        "string",
+		},
+		"http": {
+			Description: "URL the health check requests; a 2xx status passes",
+			Required:    false,
+			Type:        "string",
+		},
+		"tcp": {
+			Description: "host:port the health check connects to",
+			Required:    false,
+			Type:        "string",
+		},
+		"ttl": {
+			Description: "TTL of a check the service reports itself, e.g. 30s",
+			Required:    false,
+			Type:        "string",
+		},
+		"interval": {
+			Description: "How often http and tcp checks run, e.g. 10s",
+			Required:    false,
+			Type:        "string",
+		},
+		"timeout": {
+			Description: "Timeout of http and tcp checks",
+			Required:    false,
+			Type:        "string",
+		},
+		"state": {
+			Description: "Whether the service should be registered",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range consulConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "consul",
+		Description: "Register and deregister services and health checks with the Consul agent",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Register the API with an HTTP check\n  consul:\n    service_name: api\n    service_port: 8080\n    tags: [v2, primary]\n    http: http://localhost:8080/health\n    interval: 10s",
+			"- name: Take the instance out of discovery before deploying\n  consul:\n    service_name: api\n    state: absent",
+		},
+		Returns: map[string]string{
+			"service_id":     "Id of the service instance",
+			"changed_fields": "Registration fields that were updated",
+		},
+	}
+
+	base := NewBaseModule("consul", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &ConsulServiceModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ConsulServiceModule) Validate(args map[string]interface{}) error {
+	if err := validateConsulArgs(m.BaseModule, args, []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "service_name", "") == "" && m.GetStringArg(args, "service_id", "") == "" {
+		return types.NewValidationError("service_name", nil, "required parameter")
+	}
+	if _, err := m.GetIntArg(args, "service_port", 0); err != nil {
+		return types.NewValidationError("service_port", args["service_port"], "service_port must be an integer")
+	}
+	if value, exists := args["meta"]; exists {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return types.NewValidationError("meta", value, "meta must be a map")
+		}
+	}
+
+	kinds := 0
+	for _, name := range []string{"http", "tcp", "ttl"} {
+		if m.GetStringArg(args, name, "") != "" {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return types.NewValidationError("http", nil, "only one of http, tcp or ttl can be given")
+	}
+	if (m.GetStringArg(args, "http", "") != "" || m.GetStringArg(args, "tcp", "") != "") && m.GetStringArg(args, "interval", "") == "" {
+		return types.NewValidationError("interval", nil, "required for http and tcp checks")
+	}
+	for _, name := range []string{"ttl", "interval", "timeout"} {
+		if value := m.GetStringArg(args, name, ""); value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return types.NewValidationError(name, value, name+" must be a duration such as 10s")
+			}
+		}
+	}
+	return nil
+}
+
+// desiredService builds the agent service registration for the task
+func (m *ConsulServiceModule) desiredService(args map[string]interface{}, id string) map[string]interface{} {
+	service := map[string]interface{}{
+		"ID":      id,
+		"Name":    m.GetStringArg(args, "service_name", id),
+		"Address": m.GetStringArg(args, "service_address", ""),
+		"Tags":    []string{},
+		"Meta":    map[string]interface{}{},
+	}
+	port, _ := m.GetIntArg(args, "service_port", 0)
+	service["Port"] = port
+	for _, tag := range m.GetSliceArg(args, "tags") {
+		service["Tags"] = append(service["Tags"].([]string), types.ConvertToString(tag))
+	}
+	if meta := m.GetMapArg(args, "meta"); meta != nil {
+		converted := make(map[string]interface{}, len(meta))
+		for k, v := range meta {
+			converted[k] = types.ConvertToString(v)
+		}
+		service["Meta"] = converted
+	}
+	return service
+}
+
+// desiredCheck builds the health check for the task, or nil without one
+func (m *ConsulServiceModule) desiredCheck(args map[string]interface{}, id string) map[string]interface{} {
+	check := map[string]interface{}{
+		"CheckID": m.GetStringArg(args, "check_id", "service:"+id),
+		"Name":    m.GetStringArg(args, "check_name", "Service '"+m.GetStringArg(args, "service_name", id)+"' check"),
+	}
+	switch {
+	case m.GetStringArg(args, "http", "") != "":
+		check["Type"] = "http"
+		check["HTTP"] = m.GetStringArg(args, "http", "")
+	case m.GetStringArg(args, "tcp", "") != "":
+		check["Type"] = "tcp"
+		check["TCP"] = m.GetStringArg(args, "tcp", "")
+	case m.GetStringArg(args, "ttl", "") != "":
+		check["Type"] = "ttl"
+		check["TTL"] = m.GetStringArg(args, "ttl", "")
+		return check
+	default:
+		return nil
+	}
+	check["Interval"] = m.GetStringArg(args, "interval", "")
+	if timeout := m.GetStringArg(args, "timeout", ""); timeout != "" {
+		check["Timeout"] = timeout
+	}
+	return check
+}
+
+// currentConsulCheck converts the agent's view of a check to the form built by
+// desiredCheck so the two can be compared
+func currentConsulCheck(check map[string]interface{}) map[string]interface{} {
+	current := map[string]interface{}{
+		"CheckID": check["CheckID"],
+		"Name":    check["Name"],
+		"Type":    check["Type"],
+	}
+	definition, _ := check["Definition"].(map[string]interface{})
+	switch check["Type"] {
+	case "http":
+		current["HTTP"] = definition["HTTP"]
+	case "tcp":
+		current["TCP"] = definition["TCP"]
+	case "ttl":
+		// The agent does not report the TTL, only that the check is one
+		return current
+	}
+	for _, field := range []string{"Interval", "Timeout"} {
+		if value := types.ConvertToString(definition[field]); value != "" && value != "0s" {
+			current[field] = normalizeConsulDuration(value)
+		}
+	}
+	return current
+}
+
+// normalizeConsulDuration formats durations the way Go prints them, so 10s
+// and 10000ms compare equal
+func normalizeConsulDuration(value string) string {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.String()
+	}
+	return value
+}
+
+// Run executes the consul module
+func (m *ConsulServiceModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	id := m.GetStringArg(args, "service_id", m.GetStringArg(args, "service_name", ""))
+	state := m.GetStringArg(args, "state", "present")
+
+	client, err := newConsulClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var current map[string]interface{}
+	err = client.do(ctx, http.MethodGet, "/v1/agent/service/"+url.PathEscape(id), nil, nil, &current)
+	if err != nil && !errors.Is(err, errConsulNotFound) {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"service_id": id,
+		"state":      state,
+	})
+
+	managed := []string{"ID", "Name", "Address", "Port", "Tags", "Meta", "Check"}
+	var change, before, after string
+
+	switch {
+	case state == "absent" && current != nil:
+		change = fmt.Sprintf("deregistered service %s", id)
+		before = formatKeycloakFields(consulServiceFields(current, nil), managed)
+		if !checkMode {
+			if err := client.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil, nil); err != nil {
+				return nil, err
+			}
+		}
+
+	case state == "present":
+		desired := m.desiredService(args, id)
+		if check := m.desiredCheck(args, id); check != nil {
+			desired["Check"] = check
+		}
+
+		var fields []string
+		if current == nil {
+			change = fmt.Sprintf("registered service %s", id)
+		} else {
+			var checks map[string]map[string]interface{}
+			if err := client.do(ctx, http.MethodGet, "/v1/agent/checks", url.Values{"filter": {fmt.Sprintf("ServiceID == %q", id)}}, nil, &checks); err != nil {
+				return nil, err
+			}
+			var existing map[string]interface{}
+			for _, check := range checks {
+				if check["ServiceID"] == id {
+					existing = currentConsulCheck(check)
+					break
+				}
+			}
+			currentFields := consulServiceFields(current, existing)
+			_, fields = keycloakChanges(currentFields, consulComparable(desired))
+			if len(fields) > 0 {
+				change = fmt.Sprintf("updated service %s", id)
+				result.Data["changed_fields"] = fields
+				before = formatKeycloakFields(currentFields, managed)
+			}
+		}
+
+		if change != "" {
+			after = formatKeycloakFields(desired, managed)
+			if !checkMode {
+				query := url.Values{"replace-existing-checks": {"true"}}
+				if err := client.do(ctx, http.MethodPut, "/v1/agent/service/register", query, desired, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	result.Changed = change != ""
+	result.Message = grafanaMessage(change, checkMode, "Consul service is already in desired state")
+	if checkMode && result.Changed {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// consulServiceFields converts the agent's view of a service and its check
+// to the registration form
+func consulServiceFields(service, check map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{
+		"ID":      service["ID"],
+		"Name":    service["Service"],
+		"Address": service["Address"],
+		"Port":    service["Port"],
+		"Tags":    sortedConsulTags(service["Tags"]),
+		"Meta":    service["Meta"],
+	}
+	if fields["Meta"] == nil {
+		fields["Meta"] = map[string]interface{}{}
+	}
+	if check != nil {
+		fields["Check"] = check
+	}
+	return fields
+}
+
+// consulComparable normalizes a desired registration for comparison: tags
+// are unordered and check durations are compared by value
+func consulComparable(desired map[string]interface{}) map[string]interface{} {
+	comparable := make(map[string]interface{}, len(desired))
+	for k, v := range desired {
+		comparable[k] = v
+	}
+	comparable["Tags"] = sortedConsulTags(desired["Tags"])
+	if check, ok := desired["Check"].(map[string]interface{}); ok {
+		normalized := make(map[string]interface{}, len(check))
+		for k, v := range check {
+			normalized[k] = v
+		}
+		for _, field := range []string{"Interval", "Timeout"} {
+			if value, ok := normalized[field].(string); ok {
+				normalized[field] = normalizeConsulDuration(value)
+			}
+		}
+		// The agent does not report the TTL of a check
+		delete(normalized, "TTL")
+		comparable["Check"] = normalized
+	}
+	if _, ok := comparable["Check"]; !ok {
+		comparable["Check"] = nil
+	}
+	return comparable
+}
+
+func sortedConsulTags(value interface{}) []string {
+	tags := []string{}
+	switch v := value.(type) {
+	case []string:
+		tags = append(tags, v...)
+	case []interface{}:
+		for _, tag := range v {
+			tags = append(tags, types.ConvertToString(tag))
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
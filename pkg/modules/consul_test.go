@@ -0,0 +1,282 @@
+package modules
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+// fakeConsul is an in-memory stand-in for the KV and agent endpoints the
+// consul modules use
+type fakeConsul struct {
+	mu       sync.Mutex
+	kv       map[string]consulKVPair
+	services map[string]map[string]interface{}
+	checks   map[string]map[string]interface{}
+	writes   []string
+	index    uint64
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, map[string]interface{}) {
+	fake := &fakeConsul{
+		kv:       make(map[string]consulKVPair),
+		services: make(map[string]map[string]interface{}),
+		checks:   make(map[string]map[string]interface{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	host, port, _ := strings.Cut(u.Host, ":")
+	return fake, map[string]interface{}{"host": host, "port": port, "token": "tok"}
+}
+
+func (f *fakeConsul) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Consul-Token") != "tok" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	}
+	body, _ := io.ReadAll(r.Body)
+	query := r.URL.Query()
+	route := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(route, "/v1/kv/"):
+		key := strings.TrimPrefix(route, "/v1/kv/")
+		var matched []consulKVPair
+		for k, pair := range f.kv {
+			if k == key || (query.Get("recurse") == "true" && strings.HasPrefix(k, key)) {
+				matched = append(matched, pair)
+			}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if len(matched) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(matched)
+		case http.MethodPut:
+			if cas := query.Get("cas"); cas != "" && cas != strconv.FormatUint(f.kv[key].ModifyIndex, 10) {
+				w.Write([]byte("false"))
+				return
+			}
+			f.index++
+			flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+			f.kv[key] = consulKVPair{Key: key, Value: body, Flags: flags, ModifyIndex: f.index}
+			w.Write([]byte("true"))
+		case http.MethodDelete:
+			for _, pair := range matched {
+				delete(f.kv, pair.Key)
+			}
+			w.Write([]byte("true"))
+		}
+	case strings.HasPrefix(route, "/v1/agent/service/register"):
+		var registration map[string]interface{}
+		json.Unmarshal(body, &registration)
+		id := registration["ID"].(string)
+		for checkID, check := range f.checks {
+			if check["ServiceID"] == id && query.Get("replace-existing-checks") == "true" {
+				delete(f.checks, checkID)
+			}
+		}
+		if check, ok := registration["Check"].(map[string]interface{}); ok {
+			definition := map[string]interface{}{"Interval": check["Interval"], "Timeout": "0s"}
+			if check["HTTP"] != nil {
+				definition["HTTP"] = check["HTTP"]
+			}
+			f.checks[check["CheckID"].(string)] = map[string]interface{}{
+				"CheckID":    check["CheckID"],
+				"Name":       check["Name"],
+				"Type":       check["Type"],
+				"ServiceID":  id,
+				"Definition": definition,
+			}
+		}
+		registration["Service"] = registration["Name"]
+		delete(registration, "Name")
+		delete(registration, "Check")
+		f.services[id] = registration
+	case strings.HasPrefix(route, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(route, "/v1/agent/service/deregister/")
+		delete(f.services, id)
+	case strings.HasPrefix(route, "/v1/agent/service/"):
+		service, ok := f.services[strings.TrimPrefix(route, "/v1/agent/service/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(service)
+	case route == "/v1/agent/checks":
+		json.NewEncoder(w).Encode(f.checks)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+route, http.StatusBadRequest)
+	}
+}
+
+func consulArgs(conn, extra map[string]interface{}) map[string]interface{} {
+	args := make(map[string]interface{})
+	for k, v := range conn {
+		args[k] = v
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	return args
+}
+
+func TestConsulKVModule(t *testing.T) {
+	module := NewConsulKVModule()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "Valid", Args: map[string]interface{}{"key": "app/version", "value": "1.2"}, ExpectValid: true},
+			{Name: "Get", Args: map[string]interface{}{"key": "app/", "recurse": true, "state": "get"}, ExpectValid: true},
+			{Name: "MissingKey", Args: map[string]interface{}{"value": "1.2"}, ExpectValid: false},
+			{Name: "MissingValue", Args: map[string]interface{}{"key": "app/version"}, ExpectValid: false},
+			{Name: "RecursivePut", Args: map[string]interface{}{"key": "app/", "value": "x", "recurse": true}, ExpectValid: false},
+			{Name: "InvalidState", Args: map[string]interface{}{"key": "app/version", "state": "locked"}, ExpectValid: false},
+			{Name: "NegativeFlags", Args: map[string]interface{}{"key": "app/version", "value": "1", "flags": -1}, ExpectValid: false},
+		})
+	})
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		fake, conn := newFakeConsul(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		put := func() map[string]interface{} {
+			return consulArgs(conn, map[string]interface{}{"key": "app/version", "value": "1.2"})
+		}
+		result := helper.Execute(put(), true, false)
+		helper.AssertChanged(result)
+		helper.AssertCheckModeSimulated(result)
+		if len(fake.writes) != 0 {
+			t.Fatalf("check mode must not write, got %v", fake.writes)
+		}
+
+		result = helper.Execute(put(), false, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		helper.AssertMessage(result, "Set app/version")
+		if string(fake.kv["app/version"].Value) != "1.2" {
+			t.Errorf("expected the value to be stored, got %v", fake.kv)
+		}
+
+		result = helper.Execute(put(), false, false)
+		helper.AssertNotChanged(result)
+
+		helper.Execute(consulArgs(conn, map[string]interface{}{"key": "app/color", "value": "blue", "flags": 3}), false, false)
+		if fake.kv["app/color"].Flags != 3 {
+			t.Errorf("expected flags to be stored, got %v", fake.kv["app/color"])
+		}
+
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"key": "app/", "recurse": true, "state": "get"}), false, false)
+		helper.AssertNotChanged(result)
+		data := result.Data["data"].(map[string]interface{})
+		if data["app/version"] != "1.2" || data["app/color"] != "blue" {
+			t.Errorf("expected every key under the prefix, got %v", data)
+		}
+
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"key": "app/version", "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		if _, exists := fake.kv["app/version"]; exists {
+			t.Error("expected the key to be deleted")
+		}
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"key": "app/version", "state": "absent"}), false, false)
+		helper.AssertNotChanged(result)
+	})
+
+	t.Run("CheckAndSet", func(t *testing.T) {
+		_, conn := newFakeConsul(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		helper.Execute(consulArgs(conn, map[string]interface{}{"key": "leader", "value": "web1", "cas": 0}), false, false)
+		result := helper.Execute(consulArgs(conn, map[string]interface{}{"key": "leader", "value": "web2", "cas": 0}), false, false)
+		helper.AssertFailure(result)
+	})
+}
+
+func TestConsulServiceModule(t *testing.T) {
+	module := NewConsulServiceModule()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "Valid", Args: map[string]interface{}{"service_name": "api", "service_port": 8080, "http": "http://localhost:8080/health", "interval": "10s"}, ExpectValid: true},
+			{Name: "TTL", Args: map[string]interface{}{"service_name": "api", "ttl": "30s"}, ExpectValid: true},
+			{Name: "MissingName", Args: map[string]interface{}{"service_port": 8080}, ExpectValid: false},
+			{Name: "MissingInterval", Args: map[string]interface{}{"service_name": "api", "tcp": "localhost:8080"}, ExpectValid: false},
+			{Name: "TwoCheckKinds", Args: map[string]interface{}{"service_name": "api", "tcp": "localhost:8080", "ttl": "30s", "interval": "10s"}, ExpectValid: false},
+			{Name: "InvalidInterval", Args: map[string]interface{}{"service_name": "api", "tcp": "localhost:8080", "interval": "often"}, ExpectValid: false},
+		})
+	})
+
+	t.Run("RegisterUpdateDeregister", func(t *testing.T) {
+		fake, conn := newFakeConsul(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		args := func() map[string]interface{} {
+			return consulArgs(conn, map[string]interface{}{
+				"service_name": "api",
+				"service_port": 8080,
+				"tags":         []interface{}{"v2", "primary"},
+				"meta":         map[string]interface{}{"version": "2.1"},
+				"http":         "http://localhost:8080/health",
+				"interval":     "10s",
+			})
+		}
+
+		result := helper.Execute(args(), true, false)
+		helper.AssertChanged(result)
+		if len(fake.writes) != 0 {
+			t.Fatalf("check mode must not write, got %v", fake.writes)
+		}
+
+		result = helper.Execute(args(), false, false)
+		helper.AssertChanged(result)
+		helper.AssertMessage(result, "Registered service api")
+		if fake.checks["service:api"] == nil {
+			t.Errorf("expected the health check to be registered, got %v", fake.checks)
+		}
+
+		// Tag order and the agent's duration format do not matter
+		same := args()
+		same["tags"] = []interface{}{"primary", "v2"}
+		same["interval"] = "10000ms"
+		result = helper.Execute(same, false, false)
+		helper.AssertNotChanged(result)
+
+		update := args()
+		update["service_port"] = 8081
+		update["http"] = "http://localhost:8081/health"
+		result = helper.Execute(update, false, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		if fields := result.Data["changed_fields"].([]string); strings.Join(fields, ",") != "Check,Port" {
+			t.Errorf("expected the port and check to change, got %v", fields)
+		}
+
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"service_name": "api", "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		if len(fake.services) != 0 {
+			t.Errorf("expected the service to be deregistered, got %v", fake.services)
+		}
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"service_name": "api", "state": "absent"}), false, false)
+		helper.AssertNotChanged(result)
+	})
+}
@@ -37,11 +37,16 @@ func NewCopyModule() *CopyModule {
 				Type:        "string",
 			},
 			"backup": {
-				Description: "Create a backup file including the timestamp information",
+				Description: "Keep a copy of the replaced file, named after it with a timestamp",
 				Required:    false,
 				Type:        "bool",
 				Default:     false,
 			},
+			"validate": {
+				Description: "Command checking the new file before it replaces dest, with %s standing for its path, e.g. \"nginx -t -c %s\"",
+				Required:    false,
+				Type:        "string",
+			},
 			"force": {
 				Description: "Influence when the file is being transferred",
 				Required:    false,
@@ -97,6 +102,12 @@ func NewCopyModule() *CopyModule {
     src: foo.conf
     dest: /etc/foo.conf
     backup: yes`,
+			`- name: Install a sudoers drop-in only when it parses
+  copy:
+    src: sudoers.d/deploy
+    dest: /etc/sudoers.d/deploy
+    mode: '0440'
+    validate: visudo -cf %s`,
 		},
 		Returns: map[string]string{
 			"backup_file": "Name of backup file created",
@@ -149,10 +160,14 @@ func (m *CopyModule) Validate(args map[string]interface{}) error {
 		"group":          "string",
 		"follow":         "bool",
 		"directory_mode": "string",
+		"validate":       "string",
 	}
 	if err := m.ValidateTypes(args, fieldTypes); err != nil {
 		return err
 	}
+	if err := validateInstallCommand(m.GetStringArg(args, "validate", "")); err != nil {
+		return err
+	}
 
 	// Validate mode format (basic validation)
 	mode := m.GetStringArg(args, "mode", "preserve")
@@ -179,6 +194,7 @@ func (m *CopyModule) Run(ctx context.Context, conn types.Connection, args map[st
 		group := m.GetStringArg(args, "group", "")
 		_ = m.GetBoolArg(args, "follow", false) // TODO: implement follow functionality
 		dirMode := m.GetStringArg(args, "directory_mode", "0755")
+		validate := m.GetStringArg(args, "validate", "")
 
 		// Validate and sanitize destination path
 		dest, err := m.ValidatePath(dest)
@@ -224,15 +240,6 @@ func (m *CopyModule) Run(ctx context.Context, conn types.Connection, args map[st
 			}
 		}
 
-		// Create backup if requested
-		var backupFile string
-		if backup && destExists {
-			backupFile, err = m.createBackup(conn, dest)
-			if err != nil {
-				return m.CreateErrorResult(host, "Failed to create backup", err), nil
-			}
-		}
-
 		// Ensure parent directory exists
 		if err := m.ensureParentDirectory(conn, dest, dirMode); err != nil {
 			return m.CreateErrorResult(host, "Failed to create parent directory", err), nil
@@ -271,8 +278,15 @@ func (m *CopyModule) Run(ctx context.Context, conn types.Connection, args map[st
 			sourceInfo = src
 		}
 
-		// Perform the copy
-		if err := conn.Copy(ctx, reader, dest, fileMode); err != nil {
+		// Perform the copy through a validated temporary file renamed over
+		// dest, backing up the file it replaces if requested
+		backupFile, err := remoteCLI{}.installFile(ctx, conn, reader, dest, fileInstall{
+			mode:         fileMode,
+			preserveMode: mode == "preserve",
+			validate:     validate,
+			backup:       backup,
+		})
+		if err != nil {
 			return m.CreateErrorResult(host, "Failed to copy file", err), nil
 		}
 
@@ -386,22 +400,6 @@ func (m *CopyModule) getFileChecksum(conn types.Connection, path string) (string
 	return strings.TrimSpace(result.Data["stdout"].(string)), nil
 }
 
-// createBackup creates a backup of the existing file
-func (m *CopyModule) createBackup(conn types.Connection, dest string) (string, error) {
-	backupFile := fmt.Sprintf("%s.backup", dest)
-	
-	result, err := conn.Execute(context.Background(), fmt.Sprintf("cp %s %s", dest, backupFile), types.ExecuteOptions{})
-	if err != nil {
-		return "", err
-	}
-
-	if !result.Success {
-		return "", fmt.Errorf("failed to create backup")
-	}
-
-	return backupFile, nil
-}
-
 // ensureParentDirectory ensures the parent directory exists
 func (m *CopyModule) ensureParentDirectory(conn types.Connection, dest, dirMode string) error {
 	// Extract parent directory
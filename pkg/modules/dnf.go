@@ -75,10 +75,21 @@ func NewDnfModule() *DnfModule {
 				Default:     false,
 				Type:        "bool",
 			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Default:     60,
+				Type:        "int",
+			},
 		},
 	}
+	base := NewBaseModule("dnf", doc)
+	caps := types.DefaultCapabilities()
+	caps.ConcurrencyClass = types.ConcurrencyClassPackageManager
+	base.SetCapabilities(caps)
+
 	return &DnfModule{
-		BaseModule: NewBaseModule("dnf", doc),
+		BaseModule: base,
 	}
 }
 
@@ -95,6 +106,10 @@ func (m *DnfModule) Run(ctx context.Context, conn types.Connection, args map[str
 	autoremove := m.GetBoolArg(args, "autoremove", false)
 	allowerasing := m.GetBoolArg(args, "allowerasing", false)
 	nobest := m.GetBoolArg(args, "nobest", false)
+	lockTimeout, err := m.lockTimeoutArg(args)
+	if err != nil {
+		return m.CreateErrorResult("", err.Error(), nil), nil
+	}
 
 	// Convert slice to string array
 	var names []string
@@ -155,7 +170,7 @@ func (m *DnfModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Update cache if requested
 	if updateCache {
 		cmd := "dnf makecache"
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to update DNF cache", err), nil
 		}
@@ -169,7 +184,7 @@ func (m *DnfModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Handle security updates without specific packages
 	if securityUpdates && len(packages) == 0 {
 		cmd := fmt.Sprintf("dnf upgrade -y --security %s", optionsStr)
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to apply security updates", err), nil
 		}
@@ -188,7 +203,7 @@ func (m *DnfModule) Run(ctx context.Context, conn types.Connection, args map[str
 	for _, pkg := range packages {
 		cmd := m.buildDnfCommand(pkg, state, optionsStr)
 		
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			// Check if package is already in desired state
 			if result != nil && result.Data != nil {
@@ -227,7 +242,7 @@ func (m *DnfModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Autoremove if requested
 	if autoremove {
 		cmd := "dnf autoremove -y"
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to autoremove packages", err), nil
 		}
@@ -299,6 +314,10 @@ func (m *DnfModule) Validate(args map[string]interface{}) error {
 		}
 	}
 
+	if _, err := m.lockTimeoutArg(args); err != nil {
+		return err
+	}
+
 	// Check that at least one action is specified
 	name := m.GetStringArg(args, "name", "")
 	namesSlice := m.GetSliceArg(args, "names")
@@ -0,0 +1,333 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// resolvConfStatus is the state of /etc/resolv.conf on the target host
+type resolvConfStatus struct {
+	exists    bool
+	content   string
+	link      string // Symlink target, e.g. the systemd-resolved stub file
+	immutable bool   // Whether the file carries the immutable attribute
+}
+
+// resolvConfContent renders a resolv.conf file. glibc reads at most three
+// nameservers, which Validate and Run enforce for this backend.
+func resolvConfContent(nameservers, search, options []string) string {
+	var b strings.Builder
+	b.WriteString("# Managed by gosible\n")
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(options, " "))
+	}
+	return b.String()
+}
+
+// resolvedDropinContent renders a systemd-resolved drop-in
+func resolvedDropinContent(nameservers, search []string) string {
+	var b strings.Builder
+	b.WriteString("# Managed by gosible\n[Resolve]\n")
+	fmt.Fprintf(&b, "DNS=%s\n", strings.Join(nameservers, " "))
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "Domains=%s\n", strings.Join(search, " "))
+	}
+	return b.String()
+}
+
+// DNSClientModule configures the DNS resolvers of a host, through a
+// systemd-resolved drop-in or by managing resolv.conf directly
+type DNSClientModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewDNSClientModule creates a new dns_client module instance
+func NewDNSClientModule() *DNSClientModule {
+	doc := types.ModuleDoc{
+		Name:        "dns_client",
+		Description: "Configure nameservers and search domains with a systemd-resolved drop-in or by managing resolv.conf, preserving or setting its immutable attribute",
+		Parameters: map[string]types.ParamDoc{
+			"nameservers": {
+				Description: "Nameserver IP addresses",
+				Required:    true,
+				Type:        "list",
+			},
+			"search": {
+				Description: "Search domains",
+				Required:    false,
+				Type:        "list",
+			},
+			"options": {
+				Description: "resolv.conf options such as rotate or timeout:2; not supported by systemd-resolved",
+				Required:    false,
+				Type:        "list",
+			},
+			"backend": {
+				Description: "resolved writes a systemd-resolved drop-in, file writes resolv.conf, auto uses resolved when the service is active",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "resolved", "file"},
+			},
+			"dropin": {
+				Description: "Name of the drop-in under /etc/systemd/resolved.conf.d",
+				Required:    false,
+				Type:        "string",
+				Default:     "gosible",
+			},
+			"path": {
+				Description: "resolv.conf path for the file backend",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/resolv.conf",
+			},
+			"immutable": {
+				Description: "Whether resolv.conf should carry the immutable attribute that stops DHCP clients rewriting it; the current attribute is kept when unset",
+				Required:    false,
+				Type:        "bool",
+			},
+			"force": {
+				Description: "Replace a resolv.conf that is a symlink, such as the systemd-resolved stub, with a regular file",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Use the internal resolvers\n  dns_client:\n    nameservers: [10.0.0.53, 10.0.1.53]\n    search: [corp.example.com]",
+			"- name: Pin resolv.conf against DHCP\n  dns_client:\n    backend: file\n    nameservers: [10.0.0.53]\n    options: [rotate, timeout:2]\n    immutable: true",
+		},
+		Returns: map[string]string{
+			"backend":   "Backend that was configured",
+			"path":      "File that holds the configuration",
+			"immutable": "Whether resolv.conf is immutable, for the file backend",
+		},
+	}
+
+	base := NewBaseModule("dns_client", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &DNSClientModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *DNSClientModule) Validate(args map[string]interface{}) error {
+	nameservers := stringList(args["nameservers"])
+	if len(nameservers) == 0 {
+		return types.NewValidationError("nameservers", nil, "required parameter")
+	}
+	for _, ns := range nameservers {
+		if net.ParseIP(ns) == nil {
+			return types.NewValidationError("nameservers", ns, "nameservers must be IP addresses")
+		}
+	}
+	if err := m.ValidateChoices(args, "backend", []string{"auto", "resolved", "file"}); err != nil {
+		return err
+	}
+
+	backend := m.GetStringArg(args, "backend", "auto")
+	if backend == "resolved" && len(stringList(args["options"])) > 0 {
+		return types.NewValidationError("options", args["options"], "options are not supported by systemd-resolved")
+	}
+	if backend == "file" && len(nameservers) > 3 {
+		return types.NewValidationError("nameservers", args["nameservers"], "resolv.conf supports at most 3 nameservers")
+	}
+	if dropin := m.GetStringArg(args, "dropin", "gosible"); dropin == "" || strings.ContainsAny(dropin, "/ ") {
+		return types.NewValidationError("dropin", dropin, "invalid drop-in name")
+	}
+	return nil
+}
+
+// Run executes the dns_client module
+func (m *DNSClientModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	nameservers := stringList(args["nameservers"])
+	search := stringList(args["search"])
+	options := stringList(args["options"])
+
+	backend := m.GetStringArg(args, "backend", "auto")
+	if backend == "auto" {
+		result, err := conn.Execute(ctx, "systemctl is-active --quiet systemd-resolved", types.ExecuteOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("detecting systemd-resolved failed: %w", err)
+		}
+		backend = "file"
+		if result.Success {
+			backend = "resolved"
+		}
+	}
+
+	if backend == "resolved" {
+		if len(options) > 0 {
+			return nil, fmt.Errorf("options are not supported by systemd-resolved")
+		}
+		return m.runResolved(ctx, conn, args, nameservers, search, hostname, checkMode, diffMode, startTime)
+	}
+	if len(nameservers) > 3 {
+		return nil, fmt.Errorf("resolv.conf supports at most 3 nameservers, got %d", len(nameservers))
+	}
+	return m.runFile(ctx, conn, args, resolvConfContent(nameservers, search, options), hostname, checkMode, diffMode, startTime)
+}
+
+// runResolved manages the systemd-resolved drop-in
+func (m *DNSClientModule) runResolved(ctx context.Context, conn types.Connection, args map[string]interface{}, nameservers, search []string, hostname string, checkMode, diffMode bool, startTime time.Time) (*types.Result, error) {
+	path := "/etc/systemd/resolved.conf.d/" + m.GetStringArg(args, "dropin", "gosible") + ".conf"
+	quoted := m.cli.shellEscape(path)
+	desired := resolvedDropinContent(nameservers, search)
+
+	current, exists, err := m.cli.inspect(ctx, conn, path, "[ -f "+quoted+" ]", "cat "+quoted)
+	if err != nil {
+		return nil, err
+	}
+
+	change := ""
+	if !exists {
+		change = "created " + path
+	} else if current != desired {
+		change = "updated " + path
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "DNS client configuration is already in desired state", map[string]interface{}{
+		"backend": "resolved",
+		"path":    path,
+	})
+
+	if change != "" && !checkMode {
+		tmp := m.cli.shellEscape(path + ".gosible.tmp")
+		cmd := fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s && mv -f %s %s",
+			m.cli.shellEscape(parentDir(path)), m.cli.shellEscape(desired), tmp, tmp, quoted)
+		if _, err := m.cli.run(ctx, conn, "writing "+path, cmd); err != nil {
+			return nil, err
+		}
+		if _, err := m.cli.run(ctx, conn, "restarting systemd-resolved", "systemctl restart systemd-resolved"); err != nil {
+			return nil, err
+		}
+		change += ", restarted systemd-resolved"
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current, desired, startTime), nil
+}
+
+// runFile manages resolv.conf, lifting the immutable attribute around the
+// write and putting it back unless told otherwise
+func (m *DNSClientModule) runFile(ctx context.Context, conn types.Connection, args map[string]interface{}, desired, hostname string, checkMode, diffMode bool, startTime time.Time) (*types.Result, error) {
+	path := m.GetStringArg(args, "path", "/etc/resolv.conf")
+	quoted := m.cli.shellEscape(path)
+
+	status, err := m.status(ctx, conn, path)
+	if err != nil {
+		return nil, err
+	}
+
+	immutable := status.immutable
+	if _, ok := args["immutable"]; ok {
+		immutable = m.GetBoolArg(args, "immutable", false)
+	}
+
+	var steps, changes []string
+	rewrite := !status.exists || status.link != "" || status.content != desired
+	if rewrite {
+		if status.link != "" {
+			if !m.GetBoolArg(args, "force", false) {
+				return nil, fmt.Errorf("%s is a symlink to %s, use the resolved backend or set force to replace it", path, status.link)
+			}
+			steps = append(steps, "rm -f "+quoted)
+			changes = append(changes, "replaced symlink "+path+" with a file")
+		} else if !status.exists {
+			changes = append(changes, "created "+path)
+		} else {
+			if status.immutable {
+				steps = append(steps, "chattr -i "+quoted)
+			}
+			changes = append(changes, "updated "+path)
+		}
+
+		tmp := m.cli.shellEscape(path + ".gosible.tmp")
+		steps = append(steps, fmt.Sprintf("printf '%%s' %s > %s && chmod 0644 %s && mv -f %s %s",
+			m.cli.shellEscape(desired), tmp, tmp, tmp, quoted))
+	}
+
+	// A rewritten file starts without the attribute
+	switch {
+	case immutable && (rewrite || !status.immutable):
+		steps = append(steps, "chattr +i "+quoted)
+	case !immutable && status.immutable && !rewrite:
+		steps = append(steps, "chattr -i "+quoted)
+	}
+	if immutable != status.immutable {
+		if immutable {
+			changes = append(changes, "made "+path+" immutable")
+		} else {
+			changes = append(changes, "made "+path+" mutable")
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "DNS client configuration is already in desired state", map[string]interface{}{
+		"backend":   "file",
+		"path":      path,
+		"immutable": immutable,
+	})
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "configuring "+path, step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, status.content, desired, startTime), nil
+}
+
+// status reads resolv.conf together with its symlink target and attributes
+func (m *DNSClientModule) status(ctx context.Context, conn types.Connection, path string) (*resolvConfStatus, error) {
+	quoted := m.cli.shellEscape(path)
+	cmd := strings.Join([]string{
+		fmt.Sprintf("if [ -L %s ]; then echo link=$(readlink %s); fi", quoted, quoted),
+		fmt.Sprintf("if lsattr -d %s 2>/dev/null | awk '{exit !($1 ~ /i/)}'; then echo immutable=true; fi", quoted),
+		fmt.Sprintf("if [ -f %s ]; then printf '%%s' '%s'; cat %s; fi", quoted, existsMarker, quoted),
+	}, "; ")
+
+	result, err := m.cli.run(ctx, conn, "reading "+path, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &resolvConfStatus{}
+	stdout, _ := result.Data["stdout"].(string)
+	header, content, found := strings.Cut(stdout, existsMarker)
+	if found {
+		status.exists, status.content = true, content
+	}
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "link":
+			status.link = value
+		case "immutable":
+			status.immutable = value == "true"
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,96 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestDNSClientModule(t *testing.T) {
+	module := NewDNSClientModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"nameservers": []interface{}{"10.0.0.53", "fd00::53"}, "search": "corp.example.com"}, ExpectValid: true},
+		{Name: "MissingNameservers", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "HostnameNameserver", Args: map[string]interface{}{"nameservers": "dns.example.com"}, ExpectValid: false},
+		{Name: "OptionsWithResolved", Args: map[string]interface{}{"nameservers": "10.0.0.53", "backend": "resolved", "options": "rotate"}, ExpectValid: false},
+		{Name: "TooManyForFile", Args: map[string]interface{}{"nameservers": []interface{}{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, "backend": "file"}, ExpectValid: false},
+		{Name: "InvalidDropin", Args: map[string]interface{}{"nameservers": "10.0.0.53", "dropin": "../evil"}, ExpectValid: false},
+	})
+
+	resolvConf := func(stdout string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if \[ -L '/etc/resolv.conf' \]`, &testhelper.CommandResponse{Stdout: stdout})
+		}
+	}
+	const managed = "# Managed by gosible\nnameserver 10.0.0.53\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "ResolvedDropin",
+			Args: map[string]interface{}{"nameservers": []interface{}{"10.0.0.53", "10.0.1.53"}, "search": "corp.example.com"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^systemctl is-active --quiet systemd-resolved$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/systemd/resolved.conf.d/gosible.conf' \]`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/systemd/resolved.conf.d' && printf '%s' '# Managed by gosible\n\[Resolve\]\nDNS=10.0.0.53 10.0.1.53\nDomains=corp.example.com\n' > `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^systemctl restart systemd-resolved$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created /etc/systemd/resolved.conf.d/gosible.conf, restarted systemd-resolved")
+				h.AssertDataValue(result, "backend", "resolved")
+			},
+		},
+		{
+			Name: "ImmutableFileKeepsFlag",
+			Args: map[string]interface{}{"nameservers": "10.0.0.53", "backend": "file"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				resolvConf("immutable=true\n" + existsMarker + "nameserver 192.168.1.1\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^chattr -i '/etc/resolv.conf'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' '# Managed by gosible\nnameserver 10.0.0.53\n' > '/etc/resolv.conf.gosible.tmp'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^chattr \+i '/etc/resolv.conf'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Updated /etc/resolv.conf")
+				h.AssertDataValue(result, "immutable", true)
+			},
+		},
+		{
+			Name: "MakeImmutable",
+			Args: map[string]interface{}{"nameservers": "10.0.0.53", "backend": "file", "immutable": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				resolvConf(existsMarker + managed)(h)
+				h.GetConnection().ExpectCommandPattern(`^chattr \+i '/etc/resolv.conf'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Made /etc/resolv.conf immutable")
+			},
+		},
+		{
+			Name:  "AlreadyConfigured",
+			Args:  map[string]interface{}{"nameservers": "10.0.0.53", "backend": "file"},
+			Setup: resolvConf("immutable=true\n" + existsMarker + managed),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "SymlinkWithoutForce",
+			Args:        map[string]interface{}{"nameservers": "10.0.0.53", "backend": "file"},
+			ExpectError: true,
+			Setup:       resolvConf("link=../run/systemd/resolve/stub-resolv.conf\n" + existsMarker + "nameserver 127.0.0.53\n"),
+		},
+		{
+			Name:      "ReplaceSymlinkInCheckMode",
+			Args:      map[string]interface{}{"nameservers": "10.0.0.53", "backend": "file", "force": true},
+			CheckMode: true,
+			Setup:     resolvConf("link=../run/systemd/resolve/stub-resolv.conf\n" + existsMarker + "nameserver 127.0.0.53\n"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, "Would have replaced symlink /etc/resolv.conf with a file")
+			},
+		},
+	})
+}
@@ -0,0 +1,767 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+var (
+	dockerContainerStates = []string{"started", "stopped", "present", "absent"}
+	dockerRestartPolicies = []string{"no", "always", "on-failure", "unless-stopped"}
+	dockerImageSources    = []string{"pull", "build", "local"}
+)
+
+// dockerCLI builds docker command lines on the target host
+type dockerCLI struct {
+	remoteCLI
+	host string // Daemon to talk to, the default socket when empty
+}
+
+// command builds a docker command line, escaping the arguments of the
+// subcommand
+func (c dockerCLI) command(subcommand string, args ...string) string {
+	parts := []string{"docker"}
+	if c.host != "" {
+		parts = append(parts, "--host", c.shellEscape(c.host))
+	}
+	parts = append(parts, subcommand)
+	for _, arg := range args {
+		parts = append(parts, c.shellEscape(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// imageID returns the ID of a local image
+func (c dockerCLI) imageID(ctx context.Context, conn types.Connection, ref string) (string, bool, error) {
+	output, exists, err := c.inspect(ctx, conn, "image "+ref, c.command("image inspect", ref), c.command("image inspect --format '{{.Id}}'", ref))
+	return strings.TrimSpace(output), exists, err
+}
+
+// dockerInspect is the part of docker container inspect output the
+// docker_container module compares
+type dockerInspect struct {
+	Image string `json:"Image"` // ID of the image the container was created from
+	State struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Cmd    []string          `json:"Cmd"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Binds        []string `json:"Binds"`
+		PortBindings map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"PortBindings"`
+		RestartPolicy struct {
+			Name string `json:"Name"`
+		} `json:"RestartPolicy"`
+	} `json:"HostConfig"`
+}
+
+// dockerContainer is the configuration of a container the module manages.
+// Env and labels hold only the keys the task sets, as images add their own.
+type dockerContainer struct {
+	image         string
+	status        string
+	command       []string
+	env           map[string]string
+	labels        map[string]string
+	ports         []string
+	volumes       []string
+	restartPolicy string
+}
+
+// dockerContainerFrom converts inspect output, keeping the env and label
+// keys of desired
+func dockerContainerFrom(inspect *dockerInspect, desired *dockerContainer) *dockerContainer {
+	c := &dockerContainer{
+		image:         inspect.Config.Image,
+		status:        inspect.State.Status,
+		command:       inspect.Config.Cmd,
+		env:           make(map[string]string),
+		labels:        make(map[string]string),
+		volumes:       append([]string(nil), inspect.HostConfig.Binds...),
+		restartPolicy: inspect.HostConfig.RestartPolicy.Name,
+	}
+	if c.restartPolicy == "" {
+		c.restartPolicy = "no"
+	}
+	for _, entry := range inspect.Config.Env {
+		key, value, _ := strings.Cut(entry, "=")
+		if _, ok := desired.env[key]; ok {
+			c.env[key] = value
+		}
+	}
+	for key, value := range inspect.Config.Labels {
+		if _, ok := desired.labels[key]; ok {
+			c.labels[key] = value
+		}
+	}
+	for port, bindings := range inspect.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			c.ports = append(c.ports, fmt.Sprintf("%s:%s->%s", binding.HostIP, binding.HostPort, port))
+		}
+	}
+	sort.Strings(c.ports)
+	sort.Strings(c.volumes)
+	return c
+}
+
+// drift lists the settings of the container that differ from desired
+func (c *dockerContainer) drift(desired *dockerContainer) []string {
+	var fields []string
+	if c.image != desired.image {
+		fields = append(fields, "image")
+	}
+	if desired.command != nil && !reflect.DeepEqual(c.command, desired.command) {
+		fields = append(fields, "command")
+	}
+	if !reflect.DeepEqual(c.env, desired.env) {
+		fields = append(fields, "env")
+	}
+	if !reflect.DeepEqual(c.labels, desired.labels) {
+		fields = append(fields, "labels")
+	}
+	if desired.ports != nil && strings.Join(c.ports, ",") != strings.Join(desired.ports, ",") {
+		fields = append(fields, "ports")
+	}
+	if desired.volumes != nil && strings.Join(c.volumes, ",") != strings.Join(desired.volumes, ",") {
+		fields = append(fields, "volumes")
+	}
+	if desired.restartPolicy != "" && c.restartPolicy != desired.restartPolicy {
+		fields = append(fields, "restart_policy")
+	}
+	return fields
+}
+
+// describe renders the container for diffs
+func (c *dockerContainer) describe(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n  image: %s\n  status: %s\n", name, c.image, c.status)
+	if c.command != nil {
+		fmt.Fprintf(&b, "  command: %s\n", strings.Join(c.command, " "))
+	}
+	fmt.Fprintf(&b, "  restart_policy: %s\n", c.restartPolicy)
+	for _, key := range sortedKeys(c.env) {
+		fmt.Fprintf(&b, "  env %s=%s\n", key, c.env[key])
+	}
+	for _, key := range sortedKeys(c.labels) {
+		fmt.Fprintf(&b, "  label %s=%s\n", key, c.labels[key])
+	}
+	for _, port := range c.ports {
+		fmt.Fprintf(&b, "  port %s\n", port)
+	}
+	for _, volume := range c.volumes {
+		fmt.Fprintf(&b, "  volume %s\n", volume)
+	}
+	return b.String()
+}
+
+// parseDockerPort normalizes a port mapping such as 8080:80,
+// 127.0.0.1:8080:80/tcp or 53/udp to the form ip:host->container/protocol
+// used for comparison with the bindings of a container
+func parseDockerPort(spec string) (string, error) {
+	mapping, protocol, _ := strings.Cut(spec, "/")
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	parts := strings.Split(mapping, ":")
+	container := parts[len(parts)-1]
+	hostIP, hostPort := "", ""
+	if len(parts) >= 2 {
+		hostPort = parts[len(parts)-2]
+	}
+	if len(parts) >= 3 {
+		hostIP = strings.Trim(strings.Join(parts[:len(parts)-2], ":"), "[]")
+	}
+	for _, port := range []string{container, hostPort} {
+		if strings.Trim(port, "0123456789-") != "" {
+			return "", fmt.Errorf("invalid port mapping %q", spec)
+		}
+	}
+	if container == "" || protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid port mapping %q", spec)
+	}
+	return fmt.Sprintf("%s:%s->%s/%s", hostIP, hostPort, container, protocol), nil
+}
+
+// dockerCommandArg returns the command argument as a list. Strings are split
+// on whitespace; arguments containing spaces need a list.
+func dockerCommandArg(value interface{}) []string {
+	if s, ok := value.(string); ok {
+		return strings.Fields(s)
+	}
+	return stringList(value)
+}
+
+// DockerContainerModule manages Docker containers with the docker client on
+// the target host
+type DockerContainerModule struct {
+	*BaseModule
+}
+
+// NewDockerContainerModule creates a new docker_container module instance
+func NewDockerContainerModule() *DockerContainerModule {
+	doc := types.ModuleDoc{
+		Name:        "docker_container",
+		Description: "Manage Docker containers: create, start, stop and remove them, recreating a container when its image or configuration drifts from the task",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Container name",
+				Required:    true,
+				Type:        "string",
+			},
+			"image": {
+				Description: "Image to run, e.g. nginx:1.27. Required unless state is absent",
+				Required:    false,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Desired container state. present creates the container without starting it",
+				Required:    false,
+				Type:        "string",
+				Default:     "started",
+				Choices:     dockerContainerStates,
+			},
+			"command": {
+				Description: "Command to run instead of the image's, a list or a string split on whitespace",
+				Required:    false,
+				Type:        "list",
+			},
+			"env": {
+				Description: "Environment variables of the container",
+				Required:    false,
+				Type:        "dict",
+			},
+			"labels": {
+				Description: "Labels of the container",
+				Required:    false,
+				Type:        "dict",
+			},
+			"ports": {
+				Description: "Published ports as [ip:]host:container[/protocol] or container[/protocol]",
+				Required:    false,
+				Type:        "list",
+			},
+			"volumes": {
+				Description: "Mounted volumes and host paths as source:target[:options]",
+				Required:    false,
+				Type:        "list",
+			},
+			"restart_policy": {
+				Description: "Restart policy of the container",
+				Required:    false,
+				Type:        "string",
+				Choices:     dockerRestartPolicies,
+			},
+			"recreate": {
+				Description: "Recreate the container even when its configuration matches",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"docker_host": {
+				Description: "Docker daemon to manage, e.g. unix:///run/user/1000/docker.sock. Uses the default daemon when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Run the web server\n  docker_container:\n    name: web\n    image: nginx:1.27\n    ports: [\"8080:80\"]\n    volumes: [/srv/www:/usr/share/nginx/html:ro]\n    env:\n      TZ: UTC\n    restart_policy: unless-stopped",
+			"- name: Remove the container\n  docker_container:\n    name: web\n    state: absent",
+		},
+		Returns: map[string]string{
+			"name":   "Container name",
+			"status": "Container status after the run, e.g. running, exited or absent",
+			"drift":  "Settings that differed from the task and caused the container to be recreated",
+		},
+	}
+
+	base := NewBaseModule("docker_container", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &DockerContainerModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *DockerContainerModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "name", "") == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", dockerContainerStates); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "restart_policy", dockerRestartPolicies); err != nil {
+		return err
+	}
+	for _, key := range []string{"env", "labels"} {
+		if value, ok := args[key]; ok && value != nil {
+			if _, ok := value.(map[string]interface{}); !ok {
+				return types.NewValidationError(key, value, key+" must be a dict")
+			}
+		}
+	}
+	for _, port := range stringList(args["ports"]) {
+		if _, err := parseDockerPort(port); err != nil {
+			return types.NewValidationError("ports", port, err.Error())
+		}
+	}
+	for _, volume := range stringList(args["volumes"]) {
+		if !strings.Contains(volume, ":") {
+			return types.NewValidationError("volumes", volume, "volumes must be given as source:target[:options]")
+		}
+	}
+	return nil
+}
+
+// desired builds the configuration the task asks for. Settings the task
+// leaves out are nil so they are not compared.
+func (m *DockerContainerModule) desired(args map[string]interface{}) *dockerContainer {
+	desired := &dockerContainer{
+		image:         m.GetStringArg(args, "image", ""),
+		env:           zfsPropertiesArg(args["env"]),
+		labels:        zfsPropertiesArg(args["labels"]),
+		restartPolicy: m.GetStringArg(args, "restart_policy", ""),
+	}
+	if _, ok := args["command"]; ok {
+		desired.command = dockerCommandArg(args["command"])
+	}
+	if _, ok := args["ports"]; ok {
+		desired.ports = []string{}
+		for _, port := range stringList(args["ports"]) {
+			normalized, _ := parseDockerPort(port)
+			desired.ports = append(desired.ports, normalized)
+		}
+		sort.Strings(desired.ports)
+	}
+	if _, ok := args["volumes"]; ok {
+		desired.volumes = append([]string{}, stringList(args["volumes"])...)
+		sort.Strings(desired.volumes)
+	}
+	return desired
+}
+
+// create builds the command creating the container, running it when start
+// is set
+func (m *DockerContainerModule) create(cli dockerCLI, args map[string]interface{}, name string, start bool) string {
+	subcommand := "create"
+	if start {
+		subcommand = "run --detach"
+	}
+	create := []string{"--name", name}
+	if policy := m.GetStringArg(args, "restart_policy", ""); policy != "" {
+		create = append(create, "--restart", policy)
+	}
+	env := zfsPropertiesArg(args["env"])
+	for _, key := range sortedKeys(env) {
+		create = append(create, "--env", key+"="+env[key])
+	}
+	labels := zfsPropertiesArg(args["labels"])
+	for _, key := range sortedKeys(labels) {
+		create = append(create, "--label", key+"="+labels[key])
+	}
+	for _, port := range stringList(args["ports"]) {
+		create = append(create, "--publish", port)
+	}
+	for _, volume := range stringList(args["volumes"]) {
+		create = append(create, "--volume", volume)
+	}
+	create = append(create, m.GetStringArg(args, "image", ""))
+	create = append(create, dockerCommandArg(args["command"])...)
+	return cli.command(subcommand, create...)
+}
+
+// Run executes the docker_container module
+func (m *DockerContainerModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+	cli := dockerCLI{host: m.GetStringArg(args, "docker_host", "")}
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "started")
+	desired := m.desired(args)
+
+	output, exists, err := cli.inspect(ctx, conn, "container "+name, cli.command("container inspect", name), cli.command("container inspect --format '{{json .}}'", name))
+	if err != nil {
+		return nil, err
+	}
+	var current *dockerContainer
+	var imageID string
+	if exists {
+		inspect := &dockerInspect{}
+		if err := json.Unmarshal([]byte(output), inspect); err != nil {
+			return nil, fmt.Errorf("failed to parse container %s: %w", name, err)
+		}
+		current = dockerContainerFrom(inspect, desired)
+		imageID = inspect.Image
+	}
+
+	var steps, changes, drift []string
+	before, after := "", ""
+	if exists {
+		before = current.describe(name)
+	}
+
+	if state == "absent" {
+		if exists {
+			steps = append(steps, cli.command("rm --force", name))
+			changes = append(changes, "removed container "+name)
+		}
+	} else {
+		if desired.image == "" {
+			if !exists {
+				return nil, types.NewValidationError("image", nil, fmt.Sprintf("image is required to create container %s", name))
+			}
+			desired.image = current.image
+		}
+
+		recreate := !exists
+		if exists {
+			drift = current.drift(desired)
+			// The image may have been pulled or rebuilt under the same name
+			if len(drift) == 0 {
+				id, found, err := cli.imageID(ctx, conn, desired.image)
+				if err != nil {
+					return nil, err
+				}
+				if found && id != imageID {
+					drift = append(drift, "image")
+				}
+			}
+			recreate = len(drift) > 0 || m.GetBoolArg(args, "recreate", false)
+		}
+
+		if recreate {
+			m.fillUnset(desired, current)
+			if exists {
+				steps = append(steps, cli.command("rm --force", name))
+			}
+			steps = append(steps, m.create(cli, args, name, state == "started"))
+			switch {
+			case !exists:
+				changes = append(changes, fmt.Sprintf("created container %s from %s", name, desired.image))
+			case len(drift) > 0:
+				changes = append(changes, fmt.Sprintf("recreated container %s (%s changed)", name, strings.Join(drift, ", ")))
+			default:
+				changes = append(changes, "recreated container "+name)
+			}
+			desired.status = "created"
+			if state == "started" {
+				desired.status = "running"
+			}
+		} else {
+			m.fillUnset(desired, current)
+			desired.status = current.status
+			switch {
+			case state == "started" && current.status != "running":
+				steps = append(steps, cli.command("start", name))
+				changes = append(changes, "started container "+name)
+				desired.status = "running"
+			case state == "stopped" && current.status == "running":
+				steps = append(steps, cli.command("stop", name))
+				changes = append(changes, "stopped container "+name)
+				desired.status = "exited"
+			}
+		}
+		after = desired.describe(name)
+	}
+
+	status := "absent"
+	if state != "absent" {
+		status = desired.status
+	}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Container %s is already in desired state", name), map[string]interface{}{
+		"name":   name,
+		"status": status,
+	})
+	if len(drift) > 0 {
+		result.Data["drift"] = drift
+	}
+
+	change := strings.Join(changes, ", ")
+	if change != "" && !checkMode {
+		for _, step := range steps {
+			if _, err := cli.run(ctx, conn, "docker", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// fillUnset copies the settings the task leaves out from the current
+// container, so diffs only show what the task changes
+func (m *DockerContainerModule) fillUnset(desired, current *dockerContainer) {
+	if current == nil {
+		if desired.restartPolicy == "" {
+			desired.restartPolicy = "no"
+		}
+		return
+	}
+	if desired.command == nil {
+		desired.command = current.command
+	}
+	if desired.ports == nil {
+		desired.ports = current.ports
+	}
+	if desired.volumes == nil {
+		desired.volumes = current.volumes
+	}
+	if desired.restartPolicy == "" {
+		desired.restartPolicy = current.restartPolicy
+	}
+}
+
+// dockerImageRef returns name with tag appended unless it already names a
+// tag or digest
+func dockerImageRef(name, tag string) string {
+	if strings.Contains(name, "@") || strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name
+	}
+	return name + ":" + tag
+}
+
+// DockerImageModule manages Docker images with the docker client on the
+// target host
+type DockerImageModule struct {
+	*BaseModule
+}
+
+// NewDockerImageModule creates a new docker_image module instance
+func NewDockerImageModule() *DockerImageModule {
+	doc := types.ModuleDoc{
+		Name:        "docker_image",
+		Description: "Manage Docker images: pull or build them, tag them under another name and remove them",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Image name, optionally with a tag, e.g. nginx or registry.example.com/app:1.2",
+				Required:    true,
+				Type:        "string",
+			},
+			"tag": {
+				Description: "Tag used when name has none",
+				Required:    false,
+				Type:        "string",
+				Default:     "latest",
+			},
+			"state": {
+				Description: "Whether the image should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"source": {
+				Description: "Where a missing image comes from: pulled from its registry, built from build.path, or only checked for locally",
+				Required:    false,
+				Type:        "string",
+				Default:     "pull",
+				Choices:     dockerImageSources,
+			},
+			"force_source": {
+				Description: "Pull or build the image even when it exists, reporting a change when its ID changes",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"build": {
+				Description: "Build settings for source build: path (the build context, required), dockerfile, args (build arguments), target and pull (refresh the base image)",
+				Required:    false,
+				Type:        "dict",
+			},
+			"repository": {
+				Description: "Additional name to tag the image as, with the tag appended when it has none",
+				Required:    false,
+				Type:        "string",
+			},
+			"force_absent": {
+				Description: "Remove the image even when containers or other tags use it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"docker_host": {
+				Description: "Docker daemon to manage. Uses the default daemon when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Pull the web server image\n  docker_image:\n    name: nginx\n    tag: \"1.27\"",
+			"- name: Build the application image and tag it for the registry\n  docker_image:\n    name: app\n    tag: \"{{ version }}\"\n    source: build\n    build:\n      path: /srv/app\n      args:\n        VERSION: \"{{ version }}\"\n    repository: registry.example.com/app",
+		},
+		Returns: map[string]string{
+			"image": "Image reference",
+			"id":    "Image ID after the run",
+		},
+	}
+
+	base := NewBaseModule("docker_image", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &DockerImageModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *DockerImageModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "name", "") == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "source", dockerImageSources); err != nil {
+		return err
+	}
+	build, ok := args["build"].(map[string]interface{})
+	if value, set := args["build"]; set && value != nil && !ok {
+		return types.NewValidationError("build", value, "build must be a dict")
+	}
+	if args := build["args"]; args != nil {
+		if _, ok := args.(map[string]interface{}); !ok {
+			return types.NewValidationError("build", args, "build.args must be a dict")
+		}
+	}
+	if m.GetStringArg(args, "source", "pull") == "build" && types.ConvertToString(build["path"]) == "" {
+		return types.NewValidationError("build", nil, "build.path is required when source is build")
+	}
+	return nil
+}
+
+// fetch builds the command pulling or building the image
+func (m *DockerImageModule) fetch(cli dockerCLI, args map[string]interface{}, ref string) string {
+	if m.GetStringArg(args, "source", "pull") == "pull" {
+		return cli.command("pull", ref)
+	}
+	build, _ := args["build"].(map[string]interface{})
+	options := []string{"--tag", ref}
+	if dockerfile := types.ConvertToString(build["dockerfile"]); dockerfile != "" {
+		options = append(options, "--file", dockerfile)
+	}
+	buildArgs := zfsPropertiesArg(build["args"])
+	for _, key := range sortedKeys(buildArgs) {
+		options = append(options, "--build-arg", key+"="+buildArgs[key])
+	}
+	if target := types.ConvertToString(build["target"]); target != "" {
+		options = append(options, "--target", target)
+	}
+	cmd := cli.command("build", options...)
+	if types.ConvertToBool(build["pull"]) {
+		cmd += " --pull"
+	}
+	return cmd + " " + cli.shellEscape(types.ConvertToString(build["path"]))
+}
+
+// Run executes the docker_image module
+func (m *DockerImageModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+	cli := dockerCLI{host: m.GetStringArg(args, "docker_host", "")}
+
+	tag := m.GetStringArg(args, "tag", "latest")
+	ref := dockerImageRef(m.GetStringArg(args, "name", ""), tag)
+	source := m.GetStringArg(args, "source", "pull")
+	verb := map[string]string{"pull": "pulled", "build": "built"}[source]
+
+	id, exists, err := cli.imageID(ctx, conn, ref)
+	if err != nil {
+		return nil, err
+	}
+	describe := func(name, id string) string {
+		if id == "" {
+			return name + ": absent\n"
+		}
+		return name + ": " + id + "\n"
+	}
+	before := describe(ref, id)
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Image %s is already in desired state", ref), map[string]interface{}{
+		"image": ref,
+		"id":    id,
+	})
+	var changes []string
+
+	if m.GetStringArg(args, "state", "present") == "absent" {
+		if exists {
+			changes = append(changes, "removed image "+ref)
+			if !checkMode {
+				rm := "image rm"
+				if m.GetBoolArg(args, "force_absent", false) {
+					rm += " --force"
+				}
+				if _, err := cli.run(ctx, conn, "removing image "+ref, cli.command(rm, ref)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result.Data["id"] = ""
+		return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before, describe(ref, ""), startTime), nil
+	}
+
+	newID := id
+	switch {
+	case !exists && source == "local":
+		return nil, fmt.Errorf("image %s does not exist and source is local", ref)
+	case !exists || m.GetBoolArg(args, "force_source", false) && source != "local":
+		if checkMode {
+			changes = append(changes, fmt.Sprintf("%s image %s", verb, ref))
+			newID = "(" + verb + ")"
+			break
+		}
+		if _, err := cli.run(ctx, conn, "fetching image "+ref, m.fetch(cli, args, ref)); err != nil {
+			return nil, err
+		}
+		if newID, _, err = cli.imageID(ctx, conn, ref); err != nil {
+			return nil, err
+		}
+		if newID != id {
+			changes = append(changes, fmt.Sprintf("%s image %s", verb, ref))
+		}
+	}
+	after := describe(ref, newID)
+
+	if repository := m.GetStringArg(args, "repository", ""); repository != "" {
+		target := dockerImageRef(repository, tag)
+		targetID, _, err := cli.imageID(ctx, conn, target)
+		if err != nil {
+			return nil, err
+		}
+		before += describe(target, targetID)
+		after += describe(target, newID)
+		if targetID == "" || targetID != newID {
+			changes = append(changes, fmt.Sprintf("tagged %s as %s", ref, target))
+			if !checkMode {
+				if _, err := cli.run(ctx, conn, "tagging image "+ref, cli.command("tag", ref, target)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if !checkMode {
+		result.Data["id"] = newID
+	}
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before, after, startTime), nil
+}
@@ -0,0 +1,531 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+var (
+	composePullPolicies     = []string{"always", "missing", "never", "policy"}
+	composeBuildPolicies    = []string{"always", "never", "policy"}
+	composeRecreatePolicies = []string{"auto", "always", "never"}
+)
+
+// composeProject builds docker compose command lines for one project. An
+// inline definition is piped to every command on stdin.
+type composeProject struct {
+	cli        dockerCLI
+	options    []string // Global options selecting the project
+	definition string
+}
+
+// command builds a docker compose command line
+func (p composeProject) command(subcommand string, args ...string) string {
+	parts := []string{p.cli.command("compose")}
+	for _, option := range p.options {
+		parts = append(parts, p.cli.shellEscape(option))
+	}
+	parts = append(parts, subcommand)
+	for _, arg := range args {
+		parts = append(parts, p.cli.shellEscape(arg))
+	}
+	cmd := strings.Join(parts, " ")
+	if p.definition != "" {
+		cmd = fmt.Sprintf("printf '%%s' %s | %s", p.cli.shellEscape(p.definition), cmd)
+	}
+	return cmd
+}
+
+// composeConfig is the part of docker compose config output the module
+// needs
+type composeConfig struct {
+	Name     string `json:"name"`
+	Services map[string]struct {
+		Scale  *int `json:"scale"`
+		Deploy *struct {
+			Replicas *int `json:"replicas"`
+		} `json:"deploy"`
+	} `json:"services"`
+}
+
+// replicas returns the number of containers the definition asks for
+func (c *composeConfig) replicas(service string) int {
+	svc := c.Services[service]
+	switch {
+	case svc.Scale != nil:
+		return *svc.Scale
+	case svc.Deploy != nil && svc.Deploy.Replicas != nil:
+		return *svc.Deploy.Replicas
+	}
+	return 1
+}
+
+// composeContainer is a container of a compose project
+type composeContainer struct {
+	id    string
+	state string
+	hash  string // Hash of the service configuration it was created from
+}
+
+// composeState maps the services of a project to their containers
+type composeState map[string][]composeContainer
+
+// describe renders the state for diffs, one line per service
+func (s composeState) describe() string {
+	var b strings.Builder
+	for _, service := range s.services() {
+		states := make([]string, 0, len(s[service]))
+		for _, c := range s[service] {
+			states = append(states, c.state)
+		}
+		sort.Strings(states)
+		fmt.Fprintf(&b, "%s: %s\n", service, strings.Join(states, ", "))
+	}
+	return b.String()
+}
+
+// services returns the services with containers, sorted
+func (s composeState) services() []string {
+	services := make([]string, 0, len(s))
+	for service := range s {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// pending returns s with the containers of services replaced by a pending
+// placeholder, for check mode diffs
+func (s composeState) pending(services []string) composeState {
+	state := make(composeState, len(s))
+	for service, containers := range s {
+		state[service] = containers
+	}
+	for _, service := range services {
+		state[service] = []composeContainer{{state: "pending"}}
+	}
+	return state
+}
+
+// changed lists the services whose containers differ between s and other
+func (s composeState) changed(other composeState) []string {
+	seen := make(map[string]bool)
+	var changed []string
+	for _, state := range []composeState{s, other} {
+		for service := range state {
+			if seen[service] {
+				continue
+			}
+			seen[service] = true
+			key := func(containers []composeContainer) string {
+				ids := make([]string, len(containers))
+				for i, c := range containers {
+					ids[i] = c.id + "/" + c.state
+				}
+				sort.Strings(ids)
+				return strings.Join(ids, ",")
+			}
+			if key(s[service]) != key(other[service]) {
+				changed = append(changed, service)
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// DockerComposeModule deploys multi-service stacks with docker compose on
+// the target host
+type DockerComposeModule struct {
+	*BaseModule
+}
+
+// NewDockerComposeModule creates a new docker_compose module instance
+func NewDockerComposeModule() *DockerComposeModule {
+	doc := types.ModuleDoc{
+		Name:        "docker_compose",
+		Description: "Deploy and remove Docker Compose stacks from a project directory or an inline definition, reporting the services that changed",
+		Parameters: map[string]types.ParamDoc{
+			"project_src": {
+				Description: "Project directory on the target host; required unless definition is given",
+				Required:    false,
+				Type:        "path",
+			},
+			"files": {
+				Description: "Compose files, relative to project_src; the compose defaults when omitted",
+				Required:    false,
+				Type:        "list",
+			},
+			"definition": {
+				Description: "Inline compose definition, used instead of files",
+				Required:    false,
+				Type:        "dict",
+			},
+			"project_name": {
+				Description: "Project name; required with definition unless it names the project. Derived from project_src otherwise",
+				Required:    false,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the stack should be up or removed",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"services": {
+				Description: "Only deploy these services and their dependencies",
+				Required:    false,
+				Type:        "list",
+			},
+			"scale": {
+				Description: "Number of containers per service, overriding the definition",
+				Required:    false,
+				Type:        "dict",
+			},
+			"pull": {
+				Description: "When to pull images; always also looks for newer images of running services",
+				Required:    false,
+				Type:        "string",
+				Default:     "policy",
+				Choices:     composePullPolicies,
+			},
+			"build": {
+				Description: "When to build images of services with a build section",
+				Required:    false,
+				Type:        "string",
+				Default:     "policy",
+				Choices:     composeBuildPolicies,
+			},
+			"recreate": {
+				Description: "auto recreates containers whose configuration changed, always recreates every container and never only creates missing ones",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     composeRecreatePolicies,
+			},
+			"remove_orphans": {
+				Description: "Remove containers of services no longer in the definition",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"remove_images": {
+				Description: "Images to remove with the stack when state is absent",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"all", "local"},
+			},
+			"remove_volumes": {
+				Description: "Remove the named volumes of the stack when state is absent",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"docker_host": {
+				Description: "Docker daemon to manage, e.g. unix:///run/user/1000/docker.sock. Uses the default daemon when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Deploy the application stack\n  docker_compose:\n    project_src: /srv/app\n    pull: always\n    scale:\n      worker: 3",
+			"- name: Run a cache\n  docker_compose:\n    project_name: cache\n    definition:\n      services:\n        redis:\n          image: redis:7\n          ports: [\"6379:6379\"]",
+			"- name: Remove the stack and its volumes\n  docker_compose:\n    project_src: /srv/app\n    state: absent\n    remove_volumes: true",
+		},
+		Returns: map[string]string{
+			"project_name":     "Name of the compose project",
+			"services":         "States of the containers of each service after the run",
+			"changed_services": "Services whose containers were created, recreated, started, stopped or removed",
+		},
+	}
+
+	base := NewBaseModule("docker_compose", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &DockerComposeModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *DockerComposeModule) Validate(args map[string]interface{}) error {
+	_, hasDefinition := args["definition"]
+	if m.GetStringArg(args, "project_src", "") == "" && !hasDefinition {
+		return types.NewValidationError("project_src", nil, "project_src or definition is required")
+	}
+	if hasDefinition {
+		if len(stringList(args["files"])) > 0 {
+			return types.NewValidationError("files", args["files"], "files and definition are mutually exclusive")
+		}
+		definition := m.GetMapArg(args, "definition")
+		if definition == nil {
+			return types.NewValidationError("definition", args["definition"], "must be a compose definition")
+		}
+		if m.GetStringArg(args, "project_name", "") == "" && types.ConvertToString(definition["name"]) == "" && m.GetStringArg(args, "project_src", "") == "" {
+			return types.NewValidationError("project_name", nil, "project_name is required with an inline definition")
+		}
+	}
+	for _, check := range []struct {
+		name    string
+		choices []string
+	}{
+		{"state", []string{"present", "absent"}},
+		{"pull", composePullPolicies},
+		{"build", composeBuildPolicies},
+		{"recreate", composeRecreatePolicies},
+		{"remove_images", []string{"all", "local"}},
+	} {
+		if err := m.ValidateChoices(args, check.name, check.choices); err != nil {
+			return err
+		}
+	}
+	if scale, ok := args["scale"]; ok {
+		replicas, isMap := scale.(map[string]interface{})
+		if !isMap {
+			return types.NewValidationError("scale", scale, "must map services to container counts")
+		}
+		for service, count := range replicas {
+			if n, err := types.ConvertToInt(count); err != nil || n < 0 {
+				return types.NewValidationError("scale", count, fmt.Sprintf("the count of %s must be a non-negative integer", service))
+			}
+		}
+	}
+	return nil
+}
+
+// project builds the compose project of the task
+func (m *DockerComposeModule) project(args map[string]interface{}) (composeProject, error) {
+	p := composeProject{cli: dockerCLI{host: m.GetStringArg(args, "docker_host", "")}}
+	if name := m.GetStringArg(args, "project_name", ""); name != "" {
+		p.options = append(p.options, "--project-name", name)
+	}
+	src := m.GetStringArg(args, "project_src", "")
+	if src != "" {
+		p.options = append(p.options, "--project-directory", src)
+	}
+	if definition := m.GetMapArg(args, "definition"); definition != nil {
+		content, err := yaml.Marshal(definition)
+		if err != nil {
+			return p, fmt.Errorf("failed to render the compose definition: %w", err)
+		}
+		p.definition = string(content)
+		p.options = append(p.options, "--file", "-")
+	}
+	for _, file := range stringList(args["files"]) {
+		if src != "" && !strings.HasPrefix(file, "/") {
+			file = strings.TrimSuffix(src, "/") + "/" + file
+		}
+		p.options = append(p.options, "--file", file)
+	}
+	return p, nil
+}
+
+// inspect reads the resolved configuration of the project and the hash of
+// each service configuration, which compose labels containers with
+func (m *DockerComposeModule) inspect(ctx context.Context, conn types.Connection, p composeProject) (*composeConfig, map[string]string, error) {
+	result, err := p.cli.run(ctx, conn, "reading the compose project", p.command("config", "--format", "json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	config := &composeConfig{}
+	if err := json.Unmarshal([]byte(stdout), config); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the compose configuration: %w", err)
+	}
+
+	result, err = p.cli.run(ctx, conn, "hashing the compose services", p.command("config", "--hash=*"))
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make(map[string]string)
+	stdout, _ = result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			hashes[fields[0]] = fields[1]
+		}
+	}
+	return config, hashes, nil
+}
+
+// containers lists the containers of the project
+func (m *DockerComposeModule) containers(ctx context.Context, conn types.Connection, cli dockerCLI, name string) (composeState, error) {
+	format := `{{.Label "com.docker.compose.service"}}	{{.ID}}	{{.State}}	{{.Label "com.docker.compose.config-hash"}}`
+	result, err := cli.run(ctx, conn, "listing the containers of "+name,
+		cli.command("ps", "--all", "--no-trunc", "--filter", "label=com.docker.compose.project="+name, "--format", format))
+	if err != nil {
+		return nil, err
+	}
+	state := make(composeState)
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) == 4 && fields[0] != "" {
+			state[fields[0]] = append(state[fields[0]], composeContainer{id: fields[1], state: fields[2], hash: fields[3]})
+		}
+	}
+	return state, nil
+}
+
+// plan lists the services the task would change
+func (m *DockerComposeModule) plan(args map[string]interface{}, config *composeConfig, hashes map[string]string, current composeState) []string {
+	services := stringList(args["services"])
+	if len(services) == 0 {
+		for service := range config.Services {
+			services = append(services, service)
+		}
+	}
+	scale := m.GetMapArg(args, "scale")
+	recreate := m.GetStringArg(args, "recreate", "auto")
+
+	var changed []string
+	for _, service := range services {
+		replicas := config.replicas(service)
+		if count, ok := scale[service]; ok {
+			replicas, _ = types.ConvertToInt(count)
+		}
+		containers := current[service]
+		drift := len(containers) != replicas || recreate == "always" && len(containers) > 0
+		for _, c := range containers {
+			if c.state != "running" || recreate == "auto" && c.hash != hashes[service] {
+				drift = true
+			}
+		}
+		if drift {
+			changed = append(changed, service)
+		}
+	}
+	if m.GetBoolArg(args, "remove_orphans", false) {
+		for service := range current {
+			if _, ok := config.Services[service]; !ok {
+				changed = append(changed, service)
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// upArgs builds the arguments of docker compose up
+func (m *DockerComposeModule) upArgs(args map[string]interface{}) []string {
+	up := []string{"--detach", "--pull", m.GetStringArg(args, "pull", "policy")}
+	switch m.GetStringArg(args, "build", "policy") {
+	case "always":
+		up = append(up, "--build")
+	case "never":
+		up = append(up, "--no-build")
+	}
+	switch m.GetStringArg(args, "recreate", "auto") {
+	case "always":
+		up = append(up, "--force-recreate")
+	case "never":
+		up = append(up, "--no-recreate")
+	}
+	if m.GetBoolArg(args, "remove_orphans", false) {
+		up = append(up, "--remove-orphans")
+	}
+	scale := m.GetMapArg(args, "scale")
+	services := make([]string, 0, len(scale))
+	for service := range scale {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		up = append(up, "--scale", fmt.Sprintf("%s=%s", service, types.ConvertToString(scale[service])))
+	}
+	return append(up, stringList(args["services"])...)
+}
+
+// Run executes the docker_compose module
+func (m *DockerComposeModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	p, err := m.project(args)
+	if err != nil {
+		return nil, err
+	}
+	config, hashes, err := m.inspect(ctx, conn, p)
+	if err != nil {
+		return nil, err
+	}
+	name := config.Name
+	current, err := m.containers(ctx, conn, p.cli, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	var step, change string
+	after := current
+	if m.GetStringArg(args, "state", "present") == "absent" {
+		changed = current.services()
+		after = composeState{}
+		if len(changed) > 0 {
+			down := []string{"--remove-orphans"}
+			if m.GetBoolArg(args, "remove_volumes", false) {
+				down = append(down, "--volumes")
+			}
+			if images := m.GetStringArg(args, "remove_images", ""); images != "" {
+				down = append(down, "--rmi", images)
+			}
+			step = p.command("down", down...)
+			change = "removed project " + name
+		}
+	} else {
+		changed = m.plan(args, config, hashes, current)
+		if checkMode {
+			after = current.pending(changed)
+		}
+		// Newer images only show once compose pulls or builds them
+		refresh := m.GetStringArg(args, "pull", "policy") == "always" || m.GetStringArg(args, "build", "policy") == "always"
+		if len(changed) > 0 || refresh && !checkMode {
+			step = p.command("up", m.upArgs(args)...)
+		}
+	}
+
+	if step != "" && !checkMode {
+		if _, err := p.cli.run(ctx, conn, "docker compose", step); err != nil {
+			return nil, err
+		}
+		// What compose did is told by the containers it left behind
+		if change == "" {
+			if after, err = m.containers(ctx, conn, p.cli, name); err != nil {
+				return nil, err
+			}
+			changed = current.changed(after)
+		}
+	}
+	if change == "" && len(changed) > 0 {
+		change = fmt.Sprintf("deployed project %s (%s changed)", name, strings.Join(changed, ", "))
+	}
+
+	services := make(map[string]interface{})
+	for _, service := range after.services() {
+		states := make([]string, 0, len(after[service]))
+		for _, c := range after[service] {
+			states = append(states, c.state)
+		}
+		services[service] = states
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Project %s is already in desired state", name), map[string]interface{}{
+		"project_name":     name,
+		"services":         services,
+		"changed_services": changed,
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current.describe(), after.describe(), startTime), nil
+}
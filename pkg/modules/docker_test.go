@@ -0,0 +1,354 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseDockerPort(t *testing.T) {
+	tests := map[string]string{
+		"8080:80":                ":8080->80/tcp",
+		"127.0.0.1:8080:80/tcp":  "127.0.0.1:8080->80/tcp",
+		"53/udp":                 ":->53/udp",
+		"[::1]:8443:443":         "::1:8443->443/tcp",
+		"9000-9001:9000-9001":    ":9000-9001->9000-9001/tcp",
+		"0.0.0.0::5432":          "0.0.0.0:->5432/tcp",
+		"80/icmp":                "",
+		"http:80":                "",
+		"127.0.0.1:8080:web/tcp": "",
+	}
+	for spec, want := range tests {
+		got, err := parseDockerPort(spec)
+		if want == "" {
+			if err == nil {
+				t.Errorf("parseDockerPort(%q) = %q, expected an error", spec, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("parseDockerPort(%q) = %q, %v; want %q", spec, got, err, want)
+		}
+	}
+}
+
+func TestDockerContainerModule(t *testing.T) {
+	module := NewDockerContainerModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const running = `{"Image":"sha256:1f6a","State":{"Status":"running"},"Config":{"Image":"nginx:1.27","Cmd":["nginx","-g","daemon off;"],"Env":["PATH=/usr/local/sbin:/usr/bin","TZ=UTC"],"Labels":{"maintainer":"NGINX"}},"HostConfig":{"Binds":["/srv/www:/usr/share/nginx/html:ro"],"PortBindings":{"80/tcp":[{"HostIp":"","HostPort":"8080"}]},"RestartPolicy":{"Name":"unless-stopped"}}}`
+	web := map[string]interface{}{
+		"name":           "web",
+		"image":          "nginx:1.27",
+		"ports":          []interface{}{"8080:80"},
+		"volumes":        []interface{}{"/srv/www:/usr/share/nginx/html:ro"},
+		"env":            map[string]interface{}{"TZ": "UTC"},
+		"restart_policy": "unless-stopped",
+	}
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		args := make(map[string]interface{})
+		for key, value := range web {
+			args[key] = value
+		}
+		for key, value := range changes {
+			args[key] = value
+		}
+		return args
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: web, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"image": "nginx"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "web", "state": "paused"}, ExpectValid: false},
+		{Name: "InvalidRestartPolicy", Args: map[string]interface{}{"name": "web", "restart_policy": "sometimes"}, ExpectValid: false},
+		{Name: "InvalidPort", Args: map[string]interface{}{"name": "web", "ports": []interface{}{"http:80"}}, ExpectValid: false},
+		{Name: "VolumeWithoutTarget", Args: map[string]interface{}{"name": "web", "volumes": []interface{}{"/data"}}, ExpectValid: false},
+		{Name: "EnvNotDict", Args: map[string]interface{}{"name": "web", "env": "TZ=UTC"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Create",
+			Args: with(map[string]interface{}{"command": "nginx -g daemon-off"}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`(?s)^if docker container inspect 'web' >/dev/null 2>&1; then .*; docker container inspect --format '\{\{json \.\}\}' 'web'; fi$`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`docker run --detach '--name' 'web' '--restart' 'unless-stopped' '--env' 'TZ=UTC' '--publish' '8080:80' '--volume' '/srv/www:/usr/share/nginx/html:ro' 'nginx:1.27' 'nginx' '-g' 'daemon-off'`, &testhelper.CommandResponse{Stdout: "4b1f\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created container web from nginx:1.27")
+				h.AssertDataValue(result, "status", "running")
+			},
+		},
+		{
+			Name: "Unchanged",
+			Args: web,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker container inspect 'web'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				h.GetConnection().ExpectCommandPattern(`^if docker image inspect 'nginx:1.27'`, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:1f6a\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "DriftInCheckMode",
+			Args:      with(map[string]interface{}{"env": map[string]interface{}{"TZ": "Europe/Berlin"}, "ports": []interface{}{"127.0.0.1:8080:80"}}),
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker container inspect 'web'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have recreated container web (env, ports changed)")
+				h.AssertDiffBefore(result, "web\n  image: nginx:1.27\n  status: running\n  command: nginx -g daemon off;\n  restart_policy: unless-stopped\n  env TZ=UTC\n  port :8080->80/tcp\n  volume /srv/www:/usr/share/nginx/html:ro\n")
+				h.AssertDiffAfter(result, "web\n  image: nginx:1.27\n  status: running\n  command: nginx -g daemon off;\n  restart_policy: unless-stopped\n  env TZ=Europe/Berlin\n  port 127.0.0.1:8080->80/tcp\n  volume /srv/www:/usr/share/nginx/html:ro\n")
+				h.GetConnection().AssertPatternCalledTimes(`^docker `, 0)
+			},
+		},
+		{
+			Name: "RecreateForUpdatedImage",
+			Args: web,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`^if docker container inspect 'web'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				conn.ExpectCommandPattern(`^if docker image inspect 'nginx:1.27'`, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:9c3e\n"})
+				conn.ExpectCommand(`docker rm --force 'web'`, &testhelper.CommandResponse{})
+				conn.ExpectCommandPattern(`^docker run --detach '--name' 'web' `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Recreated container web (image changed)")
+				if drift, _ := result.Data["drift"].([]string); len(drift) != 1 || drift[0] != "image" {
+					t.Errorf("expected the image to have drifted, got %v", result.Data["drift"])
+				}
+			},
+		},
+		{
+			Name: "Stop",
+			Args: map[string]interface{}{"name": "web", "state": "stopped", "docker_host": "unix:///run/user/1000/docker.sock"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`^if docker --host 'unix:///run/user/1000/docker.sock' container inspect 'web'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				conn.ExpectCommandPattern(`^if docker --host 'unix:///run/user/1000/docker.sock' image inspect 'nginx:1.27'`, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:1f6a\n"})
+				conn.ExpectCommand(`docker --host 'unix:///run/user/1000/docker.sock' stop 'web'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Stopped container web")
+				h.AssertDataValue(result, "status", "exited")
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"name": "web", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker container inspect 'web'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				h.GetConnection().ExpectCommand(`docker rm --force 'web'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "status", "absent")
+			},
+		},
+		{
+			Name:        "CreateWithoutImage",
+			Args:        map[string]interface{}{"name": "web"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker container inspect 'web'`, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
+
+func TestDockerImageModule(t *testing.T) {
+	module := NewDockerImageModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "nginx"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"tag": "1.27"}, ExpectValid: false},
+		{Name: "InvalidSource", Args: map[string]interface{}{"name": "nginx", "source": "registry"}, ExpectValid: false},
+		{Name: "BuildWithoutPath", Args: map[string]interface{}{"name": "app", "source": "build", "build": map[string]interface{}{"dockerfile": "Dockerfile"}}, ExpectValid: false},
+		{Name: "BuildArgsNotDict", Args: map[string]interface{}{"name": "app", "source": "build", "build": map[string]interface{}{"path": "/srv/app", "args": "VERSION=1"}}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Pull",
+			Args:     map[string]interface{}{"name": "nginx", "tag": "1.27"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: `if docker image inspect 'nginx:1.27' >/dev/null 2>&1; then printf '%s' '` + existsMarker + `'; docker image inspect --format '{{.Id}}' 'nginx:1.27'; fi`, Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: `docker pull 'nginx:1.27'`, Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: `if docker image inspect 'nginx:1.27' >/dev/null 2>&1; then printf '%s' '` + existsMarker + `'; docker image inspect --format '{{.Id}}' 'nginx:1.27'; fi`, Response: &testhelper.CommandResponse{Stdout: existsMarker + "sha256:1f6a\n"}},
+				)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Pulled image nginx:1.27")
+				h.AssertDataValue(result, "id", "sha256:1f6a")
+				h.AssertDiffBefore(result, "nginx:1.27: absent\n")
+				h.AssertDiffAfter(result, "nginx:1.27: sha256:1f6a\n")
+			},
+		},
+		{
+			Name: "BuildAndTag",
+			Args: map[string]interface{}{
+				"name":       "app",
+				"tag":        "1.2",
+				"source":     "build",
+				"build":      map[string]interface{}{"path": "/srv/app", "dockerfile": "Dockerfile.prod", "args": map[string]interface{}{"VERSION": "1.2"}, "pull": true},
+				"repository": "registry.example.com/app",
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectInOrder(
+					testhelper.ExpectedCall{Command: `if docker image inspect 'app:1.2' >/dev/null 2>&1; then printf '%s' '` + existsMarker + `'; docker image inspect --format '{{.Id}}' 'app:1.2'; fi`, Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: `docker build '--tag' 'app:1.2' '--file' 'Dockerfile.prod' '--build-arg' 'VERSION=1.2' --pull '/srv/app'`, Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: `if docker image inspect 'app:1.2' >/dev/null 2>&1; then printf '%s' '` + existsMarker + `'; docker image inspect --format '{{.Id}}' 'app:1.2'; fi`, Response: &testhelper.CommandResponse{Stdout: existsMarker + "sha256:77d0\n"}},
+				)
+				conn.ExpectCommandPattern(`^if docker image inspect 'registry.example.com/app:1.2'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`docker tag 'app:1.2' 'registry.example.com/app:1.2'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Built image app:1.2, tagged app:1.2 as registry.example.com/app:1.2")
+			},
+		},
+		{
+			Name: "ForcePullWithoutUpdate",
+			Args: map[string]interface{}{"name": "registry.example.com:5000/base:3", "force_source": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				inspect := `if docker image inspect 'registry.example.com:5000/base:3' >/dev/null 2>&1; then printf '%s' '` + existsMarker + `'; docker image inspect --format '{{.Id}}' 'registry.example.com:5000/base:3'; fi`
+				conn.ExpectInOrder(
+					testhelper.ExpectedCall{Command: inspect, Response: &testhelper.CommandResponse{Stdout: existsMarker + "sha256:aa01\n"}},
+					testhelper.ExpectedCall{Command: `docker pull 'registry.example.com:5000/base:3'`, Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: inspect, Response: &testhelper.CommandResponse{Stdout: existsMarker + "sha256:aa01\n"}},
+				)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "id", "sha256:aa01")
+			},
+		},
+		{
+			Name:        "LocalMissing",
+			Args:        map[string]interface{}{"name": "app", "source": "local"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker image inspect 'app:latest'`, &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"name": "nginx:1.25", "state": "absent", "force_absent": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if docker image inspect 'nginx:1.25'`, &testhelper.CommandResponse{Stdout: existsMarker + "sha256:5e2b\n"})
+				h.GetConnection().ExpectCommand(`docker image rm --force 'nginx:1.25'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed image nginx:1.25")
+			},
+		},
+	})
+}
+
+func TestDockerComposeModule(t *testing.T) {
+	module := NewDockerComposeModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Directory", Args: map[string]interface{}{"project_src": "/srv/app", "scale": map[string]interface{}{"worker": 3}}, ExpectValid: true},
+		{Name: "Inline", Args: map[string]interface{}{"project_name": "cache", "definition": map[string]interface{}{"services": map[string]interface{}{"redis": map[string]interface{}{"image": "redis:7"}}}}, ExpectValid: true},
+		{Name: "NoProject", Args: map[string]interface{}{"state": "absent"}, ExpectValid: false},
+		{Name: "InlineWithoutName", Args: map[string]interface{}{"definition": map[string]interface{}{"services": map[string]interface{}{}}}, ExpectValid: false},
+		{Name: "FilesAndDefinition", Args: map[string]interface{}{"project_name": "cache", "files": []interface{}{"a.yml"}, "definition": map[string]interface{}{}}, ExpectValid: false},
+		{Name: "NegativeScale", Args: map[string]interface{}{"project_src": "/srv/app", "scale": map[string]interface{}{"worker": -1}}, ExpectValid: false},
+		{Name: "InvalidPull", Args: map[string]interface{}{"project_src": "/srv/app", "pull": "sometimes"}, ExpectValid: false},
+	})
+
+	config := &testhelper.CommandResponse{Stdout: `{"name":"app","services":{"web":{"image":"nginx:1.27"},"worker":{"image":"app-worker","deploy":{"replicas":2}}}}`}
+	hashes := &testhelper.CommandResponse{Stdout: "web 1f6a\nworker 9c2e\n"}
+	ps := `^docker ps '--all' '--no-trunc' '--filter' 'label=com.docker.compose.project=app' `
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Deploy",
+			Args:     map[string]interface{}{"project_src": "/srv/app", "files": []interface{}{"compose.yml", "compose.prod.yml"}, "scale": map[string]interface{}{"worker": 3}, "pull": "missing", "remove_orphans": true},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				project := "docker compose '--project-directory' '/srv/app' '--file' '/srv/app/compose.yml' '--file' '/srv/app/compose.prod.yml'"
+				h.GetConnection().ExpectCommand(project+" config '--format' 'json'", config)
+				h.GetConnection().ExpectCommand(project+" config '--hash=*'", hashes)
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Pattern: ps, Response: &testhelper.CommandResponse{Stdout: "web\tc1\trunning\t1f6a\nworker\tc2\trunning\t9c2e\nworker\tc3\trunning\t9c2e\n"}},
+					testhelper.ExpectedCall{Pattern: ps, Response: &testhelper.CommandResponse{Stdout: "web\tc1\trunning\t1f6a\nworker\tc2\trunning\t9c2e\nworker\tc3\trunning\t9c2e\nworker\tc4\trunning\t9c2e\n"}},
+				)
+				h.GetConnection().ExpectCommand(project+" up '--detach' '--pull' 'missing' '--remove-orphans' '--scale' 'worker=3'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Deployed project app (worker changed)")
+				h.AssertDiffBefore(result, "web: running\nworker: running, running\n")
+				h.AssertDiffAfter(result, "web: running\nworker: running, running, running\n")
+				if changed, _ := result.Data["changed_services"].([]string); len(changed) != 1 || changed[0] != "worker" {
+					t.Errorf("unexpected changed services %v", result.Data["changed_services"])
+				}
+			},
+		},
+		{
+			Name: "UpToDate",
+			Args: map[string]interface{}{"project_src": "/srv/app"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`config '--format' 'json'$`, config)
+				h.GetConnection().ExpectCommandPattern(`config '--hash=\*'$`, hashes)
+				h.GetConnection().ExpectCommandPattern(ps, &testhelper.CommandResponse{Stdout: "web\tc1\trunning\t1f6a\nworker\tc2\trunning\t9c2e\nworker\tc3\trunning\t9c2e\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(` up `, 0)
+			},
+		},
+		{
+			Name:      "CheckModeConfigChanged",
+			Args:      map[string]interface{}{"project_name": "app", "definition": map[string]interface{}{"services": map[string]interface{}{"web": map[string]interface{}{"image": "nginx:1.27"}}}},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'services:\n    web:\n        image: nginx:1.27\n' \| docker compose '--project-name' 'app' '--file' '-' config '--format' 'json'$`, &testhelper.CommandResponse{Stdout: `{"name":"app","services":{"web":{"image":"nginx:1.27"}}}`})
+				h.GetConnection().ExpectCommandPattern(`config '--hash=\*'$`, &testhelper.CommandResponse{Stdout: "web 77aa\n"})
+				h.GetConnection().ExpectCommandPattern(ps, &testhelper.CommandResponse{Stdout: "web\tc1\trunning\t1f6a\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have deployed project app (web changed)")
+				h.AssertDiffAfter(result, "web: pending\n")
+				h.GetConnection().AssertPatternCalledTimes(` up `, 0)
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"project_src": "/srv/app", "state": "absent", "remove_volumes": true, "remove_images": "local"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`config '--format' 'json'$`, config)
+				h.GetConnection().ExpectCommandPattern(`config '--hash=\*'$`, hashes)
+				h.GetConnection().ExpectCommandPattern(ps, &testhelper.CommandResponse{Stdout: "web\tc1\texited\t1f6a\n"})
+				h.GetConnection().ExpectCommand("docker compose '--project-directory' '/srv/app' down '--remove-orphans' '--volumes' '--rmi' 'local'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed project app")
+			},
+		},
+	})
+}
@@ -0,0 +1,281 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// sssdConfPath is where realmd writes the SSSD configuration of joined domains
+const sssdConfPath = "/etc/sssd/sssd.conf"
+
+// DomainJoinModule joins hosts to an Active Directory domain with realmd,
+// which drives adcli or samba to create the computer account and configures
+// SSSD to resolve domain users
+type DomainJoinModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewDomainJoinModule creates a new domain_join module instance
+func NewDomainJoinModule() *DomainJoinModule {
+	doc := types.ModuleDoc{
+		Name:        "domain_join",
+		Description: "Join or leave an Active Directory domain with realmd, tune the SSSD domain settings and verify that domain accounts resolve",
+		Parameters: map[string]types.ParamDoc{
+			"domain": {
+				Description: "Domain to join, e.g. corp.example.com",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the host should be joined to the domain",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"user": {
+				Description: "Domain account allowed to join computers; required to join",
+				Required:    false,
+				Type:        "string",
+			},
+			"password": {
+				Description: "Password of the join account, usually from a vaulted variable; passed on stdin",
+				Required:    false,
+				Type:        "string",
+			},
+			"ou": {
+				Description: "Organizational unit for the computer account, e.g. OU=Linux,OU=Servers,DC=corp,DC=example,DC=com",
+				Required:    false,
+				Type:        "string",
+			},
+			"computer_name": {
+				Description: "Computer account name, defaults to the short host name",
+				Required:    false,
+				Type:        "string",
+			},
+			"membership_software": {
+				Description: "Software that creates the computer account",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"adcli", "samba"},
+			},
+			"client_software": {
+				Description: "Software that resolves domain accounts",
+				Required:    false,
+				Type:        "string",
+				Default:     "sssd",
+				Choices:     []string{"sssd", "winbind"},
+			},
+			"sssd_options": {
+				Description: "Options set in the domain's section of sssd.conf, e.g. use_fully_qualified_names: false",
+				Required:    false,
+				Type:        "dict",
+			},
+			"verify_user": {
+				Description: "Domain account looked up with id to verify the join",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Join the corporate domain\n  domain_join:\n    domain: corp.example.com\n    user: svc-join\n    password: \"{{ vault_join_password }}\"\n    ou: OU=Linux,OU=Servers,DC=corp,DC=example,DC=com\n    sssd_options:\n      use_fully_qualified_names: false\n      fallback_homedir: /home/%u\n    verify_user: alice",
+			"- name: Leave the domain\n  domain_join:\n    domain: corp.example.com\n    state: absent",
+		},
+		Returns: map[string]string{
+			"domain":   "Domain name",
+			"joined":   "Whether the host is joined to the domain",
+			"verified": "Whether verify_user resolved",
+		},
+	}
+
+	base := NewBaseModule("domain_join", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &DomainJoinModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *DomainJoinModule) Validate(args map[string]interface{}) error {
+	domain := m.GetStringArg(args, "domain", "")
+	if domain == "" {
+		return types.NewValidationError("domain", nil, "required parameter")
+	}
+	if strings.ContainsAny(domain, " \t\n/") {
+		return types.NewValidationError("domain", domain, "invalid domain name")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "membership_software", []string{"adcli", "samba"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "client_software", []string{"sssd", "winbind"}); err != nil {
+		return err
+	}
+	if _, ok := args["password"]; ok && m.GetStringArg(args, "user", "") == "" {
+		return types.NewValidationError("user", nil, "user is required with password")
+	}
+	if options, ok := args["sssd_options"]; ok {
+		if _, isMap := options.(map[string]interface{}); !isMap {
+			return types.NewValidationError("sssd_options", options, "sssd_options must be a dict")
+		}
+		if m.GetStringArg(args, "client_software", "sssd") != "sssd" {
+			return types.NewValidationError("sssd_options", options, "sssd_options require client_software sssd")
+		}
+	}
+	return nil
+}
+
+// Run executes the domain_join module
+func (m *DomainJoinModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	domain := m.GetStringArg(args, "domain", "")
+	state := m.GetStringArg(args, "state", "present")
+	user := m.GetStringArg(args, "user", "")
+
+	joined, err := m.joined(ctx, conn, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	var before, after strings.Builder
+	fmt.Fprintf(&before, "joined=%t\n", joined)
+	fmt.Fprintf(&after, "joined=%t\n", state == "present")
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Domain membership of %s is already in desired state", domain), map[string]interface{}{
+		"domain": domain,
+		"joined": state == "present",
+	})
+
+	switch {
+	case state == "present" && !joined:
+		if user == "" {
+			return nil, fmt.Errorf("user is required to join %s", domain)
+		}
+		if !checkMode {
+			if _, err := m.cli.run(ctx, conn, "joining "+domain, m.joinCommand(args)); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, "joined "+domain)
+
+	case state == "absent" && joined:
+		if !checkMode {
+			cmd := "realm leave " + m.cli.shellEscape(domain)
+			if user != "" {
+				cmd = fmt.Sprintf("printf '%%s' %s | realm leave --remove --user=%s %s",
+					m.cli.shellEscape(m.GetStringArg(args, "password", "")), m.cli.shellEscape(user), m.cli.shellEscape(domain))
+			}
+			if _, err := m.cli.run(ctx, conn, "leaving "+domain, cmd); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, "left "+domain)
+	}
+
+	if state == "present" {
+		if options := m.GetMapArg(args, "sssd_options"); len(options) > 0 {
+			change, err := m.configureSSSD(ctx, conn, domain, options, checkMode, &before, &after)
+			if err != nil {
+				return nil, err
+			}
+			if change != "" {
+				changes = append(changes, change)
+			}
+		}
+
+		// A host that has not actually joined cannot resolve domain accounts
+		if verifyUser := m.GetStringArg(args, "verify_user", ""); verifyUser != "" && (joined || !checkMode) {
+			if _, err := m.cli.run(ctx, conn, "resolving "+verifyUser, "id "+m.cli.shellEscape(verifyUser)); err != nil {
+				return nil, fmt.Errorf("joined %s but domain account %s does not resolve: %w", domain, verifyUser, err)
+			}
+			result.Data["verified"] = true
+		}
+	}
+
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// joined reports whether the host is a member of the domain
+func (m *DomainJoinModule) joined(ctx context.Context, conn types.Connection, domain string) (bool, error) {
+	result, err := m.cli.run(ctx, conn, "listing joined realms", "realm list --name-only")
+	if err != nil {
+		return false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		if strings.EqualFold(strings.TrimSpace(line), domain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// joinCommand builds the realm join command, which reads the password from
+// stdin so that it never appears in the process list
+func (m *DomainJoinModule) joinCommand(args map[string]interface{}) string {
+	cmd := fmt.Sprintf("printf '%%s' %s | realm join --user=%s", m.cli.shellEscape(m.GetStringArg(args, "password", "")), m.cli.shellEscape(m.GetStringArg(args, "user", "")))
+	for _, opt := range []struct{ arg, flag string }{
+		{"ou", "--computer-ou"},
+		{"computer_name", "--computer-name"},
+		{"membership_software", "--membership-software"},
+		{"client_software", "--client-software"},
+	} {
+		if value := m.GetStringArg(args, opt.arg, ""); value != "" {
+			cmd += fmt.Sprintf(" %s=%s", opt.flag, m.cli.shellEscape(value))
+		}
+	}
+	return cmd + " " + m.cli.shellEscape(m.GetStringArg(args, "domain", ""))
+}
+
+// configureSSSD sets options in the domain's sssd.conf section and restarts
+// SSSD when they change
+func (m *DomainJoinModule) configureSSSD(ctx context.Context, conn types.Connection, domain string, options map[string]interface{}, checkMode bool, before, after *strings.Builder) (string, error) {
+	quoted := m.cli.shellEscape(sssdConfPath)
+	current, _, err := m.cli.inspect(ctx, conn, sssdConfPath, "[ -f "+quoted+" ]", "cat "+quoted)
+	if err != nil {
+		return "", err
+	}
+
+	section := "domain/" + strings.ToLower(domain)
+	parser := &iniParser{content: current}
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parser.content, _ = parser.setValue(section, key, types.ConvertToString(options[key]), false)
+	}
+	fmt.Fprintf(before, "%s:\n%s", sssdConfPath, current)
+	fmt.Fprintf(after, "%s:\n%s", sssdConfPath, parser.content)
+
+	if parser.content == current {
+		return "", nil
+	}
+	if !checkMode {
+		tmp := m.cli.shellEscape(sssdConfPath + ".gosible.tmp")
+		cmd := fmt.Sprintf("printf '%%s' %s > %s && chmod 0600 %s && mv -f %s %s && systemctl restart sssd",
+			m.cli.shellEscape(parser.content), tmp, tmp, tmp, quoted)
+		if _, err := m.cli.run(ctx, conn, "configuring sssd", cmd); err != nil {
+			return "", err
+		}
+	}
+	return "updated sssd options for " + domain, nil
+}
@@ -0,0 +1,102 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestDomainJoinModule(t *testing.T) {
+	module := NewDomainJoinModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"domain": "corp.example.com", "user": "svc-join", "password": "secret"}, ExpectValid: true},
+		{Name: "MissingDomain", Args: map[string]interface{}{"user": "svc-join"}, ExpectValid: false},
+		{Name: "PasswordWithoutUser", Args: map[string]interface{}{"domain": "corp.example.com", "password": "secret"}, ExpectValid: false},
+		{Name: "InvalidMembership", Args: map[string]interface{}{"domain": "corp.example.com", "membership_software": "net"}, ExpectValid: false},
+		{Name: "SSSDOptionsWithWinbind", Args: map[string]interface{}{"domain": "corp.example.com", "client_software": "winbind", "sssd_options": map[string]interface{}{"use_fully_qualified_names": false}}, ExpectValid: false},
+	})
+
+	realms := func(stdout string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^realm list --name-only$`, &testhelper.CommandResponse{Stdout: stdout})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Join",
+			Args: map[string]interface{}{
+				"domain":       "CORP.example.com",
+				"user":         "svc-join",
+				"password":     "s3cret",
+				"ou":           "OU=Linux,DC=corp,DC=example,DC=com",
+				"sssd_options": map[string]interface{}{"use_fully_qualified_names": false},
+				"verify_user":  "alice",
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				realms("")(h)
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 's3cret' \| realm join --user='svc-join' --computer-ou='OU=Linux,DC=corp,DC=example,DC=com' 'CORP.example.com'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/sssd/sssd.conf' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "[sssd]\ndomains = corp.example.com\n\n[domain/corp.example.com]\nuse_fully_qualified_names = True\n"})
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' '.*use_fully_qualified_names = false\n' > '/etc/sssd/sssd.conf.gosible.tmp' && chmod 0600 .* && systemctl restart sssd$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^id 'alice'$`, &testhelper.CommandResponse{Stdout: "uid=1234(alice)"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Joined CORP.example.com, updated sssd options for CORP.example.com")
+				h.AssertDataValue(result, "verified", true)
+				if strings.Contains(result.Message, "s3cret") {
+					t.Error("password leaked into the result")
+				}
+			},
+		},
+		{
+			Name:  "AlreadyJoined",
+			Args:  map[string]interface{}{"domain": "corp.example.com", "user": "svc-join", "password": "s3cret"},
+			Setup: realms("corp.example.com\n"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "JoinInCheckMode",
+			Args:      map[string]interface{}{"domain": "corp.example.com", "user": "svc-join", "password": "s3cret", "verify_user": "alice"},
+			CheckMode: true,
+			Setup:     realms(""),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, "Would have joined corp.example.com")
+			},
+		},
+		{
+			Name:        "JoinWithoutUser",
+			Args:        map[string]interface{}{"domain": "corp.example.com"},
+			ExpectError: true,
+			Setup:       realms(""),
+		},
+		{
+			Name:        "VerificationFails",
+			Args:        map[string]interface{}{"domain": "corp.example.com", "verify_user": "alice"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				realms("corp.example.com\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^id 'alice'$`, &testhelper.CommandResponse{ExitCode: 1, Stderr: "id: 'alice': no such user"})
+			},
+		},
+		{
+			Name: "Leave",
+			Args: map[string]interface{}{"domain": "corp.example.com", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				realms("corp.example.com\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^realm leave 'corp.example.com'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Left corp.example.com")
+				h.AssertDataValue(result, "joined", false)
+			},
+		},
+	})
+}
@@ -0,0 +1,411 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// etcdClient talks to the JSON gateway of the etcd v3 API. Requests are
+// made from the control node, like the other service API modules.
+type etcdClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newEtcdClient creates a client from the connection, TLS and
+// authentication options, authenticating when a user is given
+func newEtcdClient(ctx context.Context, m *BaseModule, args map[string]interface{}) (*etcdClient, error) {
+	port, err := m.GetIntArg(args, "port", 2379)
+	if err != nil {
+		return nil, types.NewValidationError("port", args["port"], "port must be an integer")
+	}
+	timeout, err := m.GetIntArg(args, "timeout", 30)
+	if err != nil {
+		return nil, types.NewValidationError("timeout", args["timeout"], "timeout must be an integer")
+	}
+
+	caCert := m.GetStringArg(args, "ca_cert", "")
+	clientCert := m.GetStringArg(args, "client_cert", "")
+	clientKey := m.GetStringArg(args, "client_key", "")
+	scheme := "http"
+	if caCert != "" || clientCert != "" {
+		scheme = "https"
+	}
+	scheme = m.GetStringArg(args, "scheme", scheme)
+
+	httpClient := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	if scheme == "https" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: !m.GetBoolArg(args, "validate_certs", true)}
+		if caCert != "" {
+			pem, err := os.ReadFile(caCert)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ca_cert: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in ca_cert %s", caCert)
+			}
+		}
+		if clientCert != "" {
+			cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	client := &etcdClient{
+		baseURL: fmt.Sprintf("%s://%s:%d", scheme, m.GetStringArg(args, "host", "localhost"), port),
+		client:  httpClient,
+	}
+
+	if user := m.GetStringArg(args, "user", ""); user != "" {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		credentials := map[string]string{"name": user, "password": m.GetStringArg(args, "password", "")}
+		if err := client.do(ctx, "/v3/auth/authenticate", credentials, &auth); err != nil {
+			return nil, fmt.Errorf("etcd authentication failed: %w", err)
+		}
+		client.token = auth.Token
+	}
+	return client, nil
+}
+
+// do posts a request to an API endpoint and decodes the JSON response into out
+func (c *etcdClient) do(ctx context.Context, endpoint string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("etcd %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("etcd %s returned %s: %s", endpoint, resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("etcd %s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid etcd response for %s: %w", endpoint, err)
+		}
+	}
+	return nil
+}
+
+// etcdKeyValue is a key as returned by the range endpoint; 64-bit integers
+// are encoded as strings by the gateway
+type etcdKeyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+// etcdKeyRange builds the key and range_end selecting a key or, with prefix, all
+// keys starting with it
+func etcdKeyRange(key string, prefix bool) map[string]interface{} {
+	request := map[string]interface{}{"key": base64.StdEncoding.EncodeToString([]byte(key))}
+	if prefix {
+		end := []byte(key)
+		for i := len(end) - 1; i >= 0; i-- {
+			if end[i] < 0xff {
+				end[i]++
+				end = end[:i+1]
+				request["range_end"] = base64.StdEncoding.EncodeToString(end)
+				return request
+			}
+		}
+		// Every byte is 0xff: the range ends at the last key
+		request["range_end"] = base64.StdEncoding.EncodeToString([]byte{0})
+	}
+	return request
+}
+
+// Etcd3Module puts, gets and deletes keys through the etcd v3 API
+type Etcd3Module struct {
+	*BaseModule
+}
+
+// NewEtcd3Module creates a new etcd3 module instance
+func NewEtcd3Module() *Etcd3Module {
+	doc := types.ModuleDoc{
+		Name:        "etcd3",
+		Description: "Put, get and delete keys in etcd through the v3 API",
+		Parameters: map[string]types.ParamDoc{
+			"key": {
+				Description: "Key to manage",
+				Required:    true,
+				Type:        "string",
+			},
+			"value": {
+				Description: "Value to store; required when state is present",
+				Required:    false,
+				Type:        "string",
+			},
+			"prefix": {
+				Description: "Treat key as a prefix when reading or deleting",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"state": {
+				Description: "present writes the value, absent deletes the key and get only reads it",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent", "get"},
+			},
+			"host": {
+				Description: "etcd member host",
+				Required:    false,
+				Type:        "string",
+				Default:     "localhost",
+			},
+			"port": {
+				Description: "etcd client port",
+				Required:    false,
+				Type:        "int",
+				Default:     2379,
+			},
+			"scheme": {
+				Description: "Protocol of the API; https when ca_cert or client_cert is given",
+				Required:    false,
+				Type:        "string",
+				Default:     "http",
+				Choices:     []string{"http", "https"},
+			},
+			"user": {
+				Description: "User to authenticate as when etcd auth is enabled",
+				Required:    false,
+				Type:        "string",
+			},
+			"password": {
+				Description: "Password of the user",
+				Required:    false,
+				Type:        "string",
+			},
+			"ca_cert": {
+				Description: "CA certificate file on the control node verifying the server",
+				Required:    false,
+				Type:        "path",
+			},
+			"client_cert": {
+				Description: "Client certificate file for mutual TLS",
+				Required:    false,
+				Type:        "path",
+			},
+			"client_key": {
+				Description: "Private key of the client certificate",
+				Required:    false,
+				Type:        "path",
+			},
+			"validate_certs": {
+				Description: "Verify the server certificate",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"timeout": {
+				Description: "Request timeout in seconds",
+				Required:    false,
+				Type:        "int",
+				Default:     30,
+			},
+		},
+		Examples: []string{
+			"- name: Point the canary at the new release\n  etcd3:\n    key: /config/checkout/release\n    value: \"{{ release }}\"\n    host: etcd.example.com\n    ca_cert: /etc/ssl/etcd/ca.pem\n    client_cert: /etc/ssl/etcd/client.pem\n    client_key: /etc/ssl/etcd/client-key.pem",
+			"- name: Read the service configuration\n  etcd3:\n    key: /config/checkout/\n    prefix: true\n    state: get\n    user: deploy\n    password: \"{{ etcd_password }}\"\n  register: config",
+			"- name: Drop the lock left by a failed deploy\n  etcd3:\n    key: /locks/checkout\n    state: absent",
+		},
+		Returns: map[string]string{
+			"value":    "Value of the key after the task, when it exists",
+			"revision": "Modification revision of the key before the task",
+			"data":     "Keys and values under the prefix with prefix and state=get",
+		},
+	}
+
+	base := NewBaseModule("etcd3", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &Etcd3Module{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *Etcd3Module) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "key", "") == "" {
+		return types.NewValidationError("key", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent", "get"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "scheme", []string{"http", "https"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "state", "present") == "present" {
+		if _, exists := args["value"]; !exists {
+			return types.NewValidationError("value", nil, "required when state is present")
+		}
+		if m.GetBoolArg(args, "prefix", false) {
+			return types.NewValidationError("prefix", true, "prefix cannot be used when state is present")
+		}
+	}
+	for _, name := range []string{"port", "timeout"} {
+		if _, err := m.GetIntArg(args, name, 0); err != nil {
+			return types.NewValidationError(name, args[name], name+" must be an integer")
+		}
+	}
+	if (m.GetStringArg(args, "client_cert", "") == "") != (m.GetStringArg(args, "client_key", "") == "") {
+		return types.NewValidationError("client_key", nil, "client_cert and client_key must be given together")
+	}
+	if m.GetStringArg(args, "password", "") != "" && m.GetStringArg(args, "user", "") == "" {
+		return types.NewValidationError("user", nil, "required when password is given")
+	}
+	return nil
+}
+
+// Run executes the etcd3 module
+func (m *Etcd3Module) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	key := m.GetStringArg(args, "key", "")
+	state := m.GetStringArg(args, "state", "present")
+	prefix := m.GetBoolArg(args, "prefix", false)
+
+	client, err := newEtcdClient(ctx, m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var current struct {
+		Kvs []etcdKeyValue `json:"kvs"`
+	}
+	if err := client.do(ctx, "/v3/kv/range", etcdKeyRange(key, prefix), &current); err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"key":   key,
+		"state": state,
+	})
+	var existing *etcdKeyValue
+	for i := range current.Kvs {
+		if string(current.Kvs[i].Key) == key {
+			existing = &current.Kvs[i]
+		}
+	}
+	if existing != nil {
+		result.Data["value"] = string(existing.Value)
+		result.Data["revision"] = existing.ModRevision
+	}
+
+	var change, before, after string
+	switch state {
+	case "get":
+		if prefix {
+			data := make(map[string]interface{}, len(current.Kvs))
+			for _, kv := range current.Kvs {
+				data[string(kv.Key)] = string(kv.Value)
+			}
+			result.Data["data"] = data
+		}
+
+	case "absent":
+		if len(current.Kvs) == 0 {
+			break
+		}
+		change = fmt.Sprintf("deleted %s", key)
+		if prefix {
+			change = fmt.Sprintf("deleted %d keys under %s", len(current.Kvs), key)
+		}
+		if existing != nil {
+			before = string(existing.Value) + "\n"
+		}
+		if !checkMode {
+			if err := client.do(ctx, "/v3/kv/deleterange", etcdKeyRange(key, prefix), nil); err != nil {
+				return nil, err
+			}
+		}
+		delete(result.Data, "value")
+
+	case "present":
+		value := m.GetStringArg(args, "value", "")
+		if existing != nil && string(existing.Value) == value {
+			break
+		}
+		change = fmt.Sprintf("set %s", key)
+		if existing != nil {
+			before = string(existing.Value) + "\n"
+		}
+		after = value + "\n"
+
+		if !checkMode {
+			put := map[string]interface{}{
+				"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+				"value": base64.StdEncoding.EncodeToString([]byte(value)),
+			}
+			if err := client.do(ctx, "/v3/kv/put", put, nil); err != nil {
+				return nil, err
+			}
+		}
+		result.Data["value"] = value
+	}
+
+	result.Changed = change != ""
+	result.Message = grafanaMessage(change, checkMode, "etcd key is already in desired state")
+	if checkMode && result.Changed {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
@@ -0,0 +1,179 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+// fakeEtcd is an in-memory stand-in for the etcd v3 JSON gateway, with
+// authentication enabled
+type fakeEtcd struct {
+	mu       sync.Mutex
+	kv       map[string]string
+	revision int
+	writes   []string
+}
+
+func newFakeEtcd(t *testing.T) (*fakeEtcd, map[string]interface{}) {
+	fake := &fakeEtcd{kv: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	host, port, _ := strings.Cut(u.Host, ":")
+	return fake, map[string]interface{}{"host": host, "port": port, "user": "root", "password": "secret"}
+}
+
+func (f *fakeEtcd) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+		Value    []byte `json:"value"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if r.URL.Path == "/v3/auth/authenticate" {
+		if body.Name != "root" || body.Password != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"authentication failed","code":3,"message":"etcdserver: authentication failed, invalid user ID or password"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "tok"})
+		return
+	}
+	if r.Header.Get("Authorization") != "tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":16,"message":"etcdserver: user name is empty"}`))
+		return
+	}
+
+	// matching returns the keys selected by key and range_end
+	matching := func() []string {
+		var keys []string
+		for k := range f.kv {
+			if k == string(body.Key) || (body.RangeEnd != nil && k >= string(body.Key) && bytes.Compare([]byte(k), body.RangeEnd) < 0) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var kvs []map[string]interface{}
+		for _, k := range matching() {
+			kvs = append(kvs, map[string]interface{}{"key": []byte(k), "value": []byte(f.kv[k]), "mod_revision": strconv.Itoa(f.revision)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs, "count": strconv.Itoa(len(kvs))})
+	case "/v3/kv/put":
+		f.writes = append(f.writes, "put "+string(body.Key))
+		f.revision++
+		f.kv[string(body.Key)] = string(body.Value)
+		w.Write([]byte(`{}`))
+	case "/v3/kv/deleterange":
+		f.writes = append(f.writes, "delete "+string(body.Key))
+		keys := matching()
+		for _, k := range keys {
+			delete(f.kv, k)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"deleted": strconv.Itoa(len(keys))})
+	default:
+		http.Error(w, "unexpected request "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func TestEtcd3Module(t *testing.T) {
+	module := NewEtcd3Module()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "Valid", Args: map[string]interface{}{"key": "/config/release", "value": "v2"}, ExpectValid: true},
+			{Name: "PrefixGet", Args: map[string]interface{}{"key": "/config/", "prefix": true, "state": "get"}, ExpectValid: true},
+			{Name: "MissingKey", Args: map[string]interface{}{"value": "v2"}, ExpectValid: false},
+			{Name: "MissingValue", Args: map[string]interface{}{"key": "/config/release"}, ExpectValid: false},
+			{Name: "CertWithoutKey", Args: map[string]interface{}{"key": "/config/release", "state": "get", "client_cert": "client.pem"}, ExpectValid: false},
+			{Name: "PasswordWithoutUser", Args: map[string]interface{}{"key": "/config/release", "state": "get", "password": "secret"}, ExpectValid: false},
+		})
+	})
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		fake, conn := newFakeEtcd(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		put := func(key, value string) map[string]interface{} {
+			return consulArgs(conn, map[string]interface{}{"key": key, "value": value})
+		}
+
+		result := helper.Execute(put("/config/release", "v2"), true, false)
+		helper.AssertChanged(result)
+		helper.AssertCheckModeSimulated(result)
+		if len(fake.writes) != 0 {
+			t.Fatalf("check mode must not write, got %v", fake.writes)
+		}
+
+		result = helper.Execute(put("/config/release", "v2"), false, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		if fake.kv["/config/release"] != "v2" {
+			t.Errorf("expected the value to be stored, got %v", fake.kv)
+		}
+
+		result = helper.Execute(put("/config/release", "v2"), false, false)
+		helper.AssertNotChanged(result)
+		helper.AssertDataValue(result, "value", "v2")
+
+		helper.Execute(put("/config/replicas", "3"), false, false)
+		helper.Execute(put("/configs", "other"), false, false)
+
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"key": "/config/", "prefix": true, "state": "get"}), false, false)
+		data := result.Data["data"].(map[string]interface{})
+		if len(data) != 2 || data["/config/replicas"] != "3" {
+			t.Errorf("expected only the keys under the prefix, got %v", data)
+		}
+
+		result = helper.Execute(consulArgs(conn, map[string]interface{}{"key": "/config/", "prefix": true, "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		helper.AssertMessage(result, "Deleted 2 keys under /config/")
+		if len(fake.kv) != 1 {
+			t.Errorf("expected only /configs to remain, got %v", fake.kv)
+		}
+	})
+
+	t.Run("AuthenticationFailure", func(t *testing.T) {
+		_, conn := newFakeEtcd(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		args := consulArgs(conn, map[string]interface{}{"key": "/config/release", "state": "get", "password": "wrong"})
+		err := helper.ExecuteExpectingError(args)
+		if err == nil || !strings.Contains(err.Error(), "invalid user ID or password") {
+			t.Errorf("expected the authentication error, got %v", err)
+		}
+	})
+}
+
+func TestEtcdKeyRange(t *testing.T) {
+	request := etcdKeyRange("/a/", true)
+	if request["key"] != "L2Ev" || request["range_end"] != "L2Ew" {
+		t.Errorf("expected the range /a/ to /a0, got %v", request)
+	}
+	if _, ok := etcdKeyRange("/a", false)["range_end"]; ok {
+		t.Error("expected no range end for a single key")
+	}
+}
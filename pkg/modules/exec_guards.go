@@ -0,0 +1,170 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// execGuardParams documents the guards shared by the modules that run
+// commands. Modules merge them into their own parameters.
+var execGuardParams = map[string]types.ParamDoc{
+	"creates": {
+		Description: "A filename or glob pattern. If it already exists, this step will not be run",
+		Required:    false,
+		Type:        "string",
+	},
+	"removes": {
+		Description: "A filename or glob pattern. If it does not exist, this step will not be run",
+		Required:    false,
+		Type:        "string",
+	},
+	"unless": {
+		Description: "A shell command. If it succeeds, this step will not be run",
+		Required:    false,
+		Type:        "string",
+	},
+	"onlyif": {
+		Description: "A shell command. If it fails, this step will not be run",
+		Required:    false,
+		Type:        "string",
+	},
+}
+
+// withExecGuardParams adds the guard parameters to a module's parameters
+func withExecGuardParams(params map[string]types.ParamDoc) map[string]types.ParamDoc {
+	for name, doc := range execGuardParams {
+		params[name] = doc
+	}
+	return params
+}
+
+// execGuards are the conditions under which an exec-style module skips its
+// command, making imperative tasks idempotent
+type execGuards struct {
+	Creates string
+	Removes string
+	Unless  string
+	Onlyif  string
+}
+
+// execGuardsArg reads the guards from the module arguments
+func execGuardsArg(args map[string]interface{}) execGuards {
+	get := func(key string) string {
+		if value, ok := args[key]; ok && value != nil {
+			return types.ConvertToString(value)
+		}
+		return ""
+	}
+	return execGuards{
+		Creates: get("creates"),
+		Removes: get("removes"),
+		Unless:  get("unless"),
+		Onlyif:  get("onlyif"),
+	}
+}
+
+// empty reports whether no guard is set
+func (g execGuards) empty() bool {
+	return g.Creates == "" && g.Removes == "" && g.Unless == "" && g.Onlyif == ""
+}
+
+// globEscape escapes a path for the shell while leaving its glob characters
+// and a leading ~/ to expand
+func globEscape(pattern string) string {
+	var b strings.Builder
+	if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+		b.WriteString("~/")
+		pattern = rest
+	}
+	for _, r := range pattern {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("*?[]/._-+,:@%", r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// script builds the shell script checking every guard. It prints the name
+// of the first guard that skips the command, or "run".
+func (g execGuards) script() string {
+	var cli remoteCLI
+	var checks []string
+	if g.Creates != "" {
+		checks = append(checks, fmt.Sprintf(`for p in %s; do if [ -e "$p" ]; then echo creates; exit 0; fi; done`, globEscape(g.Creates)))
+	}
+	if g.Removes != "" {
+		checks = append(checks, fmt.Sprintf(`found=; for p in %s; do if [ -e "$p" ]; then found=1; fi; done; if [ -z "$found" ]; then echo removes; exit 0; fi`, globEscape(g.Removes)))
+	}
+	if g.Unless != "" {
+		checks = append(checks, fmt.Sprintf("if sh -c %s >/dev/null 2>&1; then echo unless; exit 0; fi", cli.shellEscape(g.Unless)))
+	}
+	if g.Onlyif != "" {
+		checks = append(checks, fmt.Sprintf("if ! sh -c %s >/dev/null 2>&1; then echo onlyif; exit 0; fi", cli.shellEscape(g.Onlyif)))
+	}
+	return strings.Join(append(checks, "echo run"), "; ")
+}
+
+// check evaluates the guards on the host in a single round trip, from the
+// working directory and with the environment the command would have. It
+// returns why the command is skipped, or "" when it should run.
+func (g execGuards) check(ctx context.Context, conn types.Connection, options types.ExecuteOptions) (string, error) {
+	if g.empty() {
+		return "", nil
+	}
+
+	result, err := conn.Execute(ctx, g.script(), options)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate guards: %w", err)
+	}
+	stdout, _ := result.Data["stdout"].(string)
+
+	switch strings.TrimSpace(stdout) {
+	case "run":
+		return "", nil
+	case "creates":
+		return fmt.Sprintf("Skipped, since %s exists", g.Creates), nil
+	case "removes":
+		return fmt.Sprintf("Skipped, since %s does not exist", g.Removes), nil
+	case "unless":
+		return fmt.Sprintf("Skipped, since unless command succeeded: %s", g.Unless), nil
+	case "onlyif":
+		return fmt.Sprintf("Skipped, since onlyif command failed: %s", g.Onlyif), nil
+	}
+	return "", fmt.Errorf("failed to evaluate guards: %s", commandStderr(result))
+}
+
+// checkModeResult reports what a command would do in check mode without
+// running it. As in Ansible, a guarded command that passed its guards is
+// predicted to change, and any other is skipped since its effect cannot be
+// known; tasks set check_mode: false to run a command in check mode.
+func (g execGuards) checkModeResult(m *BaseModule, host string, cmd interface{}) *types.Result {
+	message := "Command would have run if not in check mode"
+	if g.empty() {
+		return m.CreateCheckModeResult(host, false, message, map[string]interface{}{
+			"cmd":     cmd,
+			"skipped": true,
+		})
+	}
+	return m.CreateCheckModeResult(host, true, message, map[string]interface{}{"cmd": cmd})
+}
+
+// withStdin returns a command line feeding stdin to cmd, followed by a
+// newline when newline is set. An empty stdin returns cmd unchanged.
+func withStdin(cmd, stdin string, newline bool) string {
+	if stdin == "" {
+		return cmd
+	}
+	var cli remoteCLI
+	format := "%s"
+	if newline {
+		format = "%s\\n"
+	}
+	return fmt.Sprintf("printf %s %s | %s", cli.shellEscape(format), cli.shellEscape(stdin), cmd)
+}
@@ -0,0 +1,180 @@
+package modules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestGlobEscape(t *testing.T) {
+	tests := map[string]string{
+		"/var/log/*.log":      "/var/log/*.log",
+		"/srv/my dir/[ab]?":   `/srv/my\ dir/[ab]?`,
+		"~/.app/done":         "~/.app/done",
+		"/tmp/$(reboot);x'y":  `/tmp/\$\(reboot\)\;x\'y`,
+		"relative/~not-home":  `relative/\~not-home`,
+		"C:/Program Files/x":  `C:/Program\ Files/x`,
+		"/opt/app-1.2+build1": "/opt/app-1.2+build1",
+	}
+	for pattern, expected := range tests {
+		if got := globEscape(pattern); got != expected {
+			t.Errorf("globEscape(%q) = %q, expected %q", pattern, got, expected)
+		}
+	}
+}
+
+func TestExecGuards(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name     string
+		args     map[string]interface{}
+		expected string
+	}{
+		{"NoGuards", map[string]interface{}{}, ""},
+		{"CreatesGlobMatches", map[string]interface{}{"creates": filepath.Join(dir, "*.lock")}, "Skipped, since " + filepath.Join(dir, "*.lock") + " exists"},
+		{"CreatesMissing", map[string]interface{}{"creates": filepath.Join(dir, "*.pid")}, ""},
+		{"CreatesRelativeToChdir", map[string]interface{}{"creates": "app.lock"}, "Skipped, since app.lock exists"},
+		{"RemovesMissing", map[string]interface{}{"removes": filepath.Join(dir, "*.pid")}, "Skipped, since " + filepath.Join(dir, "*.pid") + " does not exist"},
+		{"RemovesPresent", map[string]interface{}{"removes": filepath.Join(dir, "app.lock")}, ""},
+		{"UnlessSucceeds", map[string]interface{}{"unless": "grep -q x /dev/null || test -f app.lock"}, "Skipped, since unless command succeeded: grep -q x /dev/null || test -f app.lock"},
+		{"UnlessFails", map[string]interface{}{"unless": "false"}, ""},
+		{"OnlyifFails", map[string]interface{}{"onlyif": "test -d missing"}, "Skipped, since onlyif command failed: test -d missing"},
+		{"AllPass", map[string]interface{}{"creates": "*.pid", "removes": "*.lock", "unless": "false", "onlyif": "true"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipped, err := execGuardsArg(tt.args).check(ctx, conn, types.ExecuteOptions{WorkingDir: dir})
+			if err != nil {
+				t.Fatalf("check failed: %v", err)
+			}
+			if skipped != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, skipped)
+			}
+		})
+	}
+}
+
+func TestExecModuleGuards(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "done")
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	modules := map[string]types.Module{"command": NewCommandModule(), "shell": NewShellModule()}
+	for name, module := range modules {
+		t.Run(name, func(t *testing.T) {
+			os.Remove(marker)
+			args := map[string]interface{}{"cmd": "touch " + marker, "creates": marker}
+
+			// Check mode still honours the guards
+			result, err := module.Run(ctx, conn, map[string]interface{}{"cmd": args["cmd"], "creates": marker, "_check_mode": true})
+			if err != nil || !result.Changed || !result.Simulated {
+				t.Fatalf("expected a simulated change in check mode, got %+v (%v)", result, err)
+			}
+
+			result, err = module.Run(ctx, conn, args)
+			if err != nil || !result.Success || !result.Changed {
+				t.Fatalf("expected the command to run, got %+v (%v)", result, err)
+			}
+
+			result, err = module.Run(ctx, conn, args)
+			if err != nil || result.Changed || result.Data["skipped"] != true || !strings.HasSuffix(result.Message, "done exists") {
+				t.Fatalf("expected the command to be skipped, got %+v (%v)", result, err)
+			}
+
+			result, err = module.Run(ctx, conn, map[string]interface{}{"cmd": args["cmd"], "creates": marker, "_check_mode": true})
+			if err != nil || result.Changed || result.Simulated {
+				t.Fatalf("expected check mode to skip the command too, got %+v (%v)", result, err)
+			}
+		})
+	}
+}
+
+func TestExecModuleCheckMode(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "done")
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	modules := map[string]types.Module{"command": NewCommandModule(), "shell": NewShellModule()}
+	for name, module := range modules {
+		t.Run(name, func(t *testing.T) {
+			// Without guards the effect cannot be predicted, so the command
+			// is skipped rather than reported as a change
+			result, err := module.Run(ctx, conn, map[string]interface{}{"cmd": "touch " + marker, "_check_mode": true})
+			if err != nil || result.Changed || result.Data["skipped"] != true || !result.Simulated {
+				t.Fatalf("expected the command to be skipped in check mode, got %+v (%v)", result, err)
+			}
+			if _, err := os.Stat(marker); !os.IsNotExist(err) {
+				t.Fatal("expected the command not to run in check mode")
+			}
+		})
+	}
+}
+
+func TestExecModuleStdin(t *testing.T) {
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	modules := map[string]types.Module{"command": NewCommandModule(), "shell": NewShellModule()}
+	for name, module := range modules {
+		t.Run(name, func(t *testing.T) {
+			result, err := module.Run(ctx, conn, map[string]interface{}{"cmd": "wc -c", "stdin": "it's", "stdin_add_newline": false})
+			if err != nil || !result.Success || strings.TrimSpace(types.ConvertToString(result.Data["stdout"])) != "4" {
+				t.Fatalf("expected the stdin without a newline, got %+v (%v)", result, err)
+			}
+		})
+	}
+}
+
+func TestCommandModuleArgv(t *testing.T) {
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	argv := []interface{}{"printf", "%s|", "hello world", "it's $HOME"}
+	result, err := NewCommandModule().Run(ctx, conn, map[string]interface{}{"argv": argv})
+	if err != nil || !result.Success {
+		t.Fatalf("expected the command to run, got %+v (%v)", result, err)
+	}
+	if stdout := types.ConvertToString(result.Data["stdout"]); stdout != "hello world|it's $HOME|" {
+		t.Errorf("expected the arguments to be passed unchanged, got %q", stdout)
+	}
+	if !reflect.DeepEqual(result.Data["cmd"], argv) {
+		t.Errorf("expected cmd to be reported as the argv list, got %v", result.Data["cmd"])
+	}
+}
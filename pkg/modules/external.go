@@ -0,0 +1,325 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ExternalProtocolVersion is the version of the protocol external modules
+// speak. Modules describing a different version are refused.
+const ExternalProtocolVersion = 1
+
+// externalDescribeTimeout bounds how long an external module may take to
+// describe itself when loaded
+const externalDescribeTimeout = 10 * time.Second
+
+// ExternalModule is a module implemented by an executable outside of
+// gosible, so that custom modules can be shipped without rebuilding it.
+//
+// The executable runs on the controller and speaks JSON, one object per
+// line. Run with the argument "describe", it prints an ExternalDescription.
+// Run with "run", it reads an ExternalRequest of type "run" on stdin, then
+// writes messages on stdout: "execute", "copy" and "fetch" messages ask
+// gosible to act on the target host, and are answered on stdin by an
+// ExternalRequest of type "response" with the same id, until a "result"
+// message ends the run. What the executable writes on stderr is reported
+// when it fails.
+type ExternalModule struct {
+	*BaseModule
+	path string
+}
+
+// ExternalDescription is what an external module prints when asked to
+// describe itself: its protocol version, documentation and capabilities
+type ExternalDescription struct {
+	Protocol int `json:"protocol"`
+	types.ModuleDoc
+	Capabilities *types.ModuleCapability `json:"capabilities,omitempty"`
+}
+
+// ExternalRequest is a message gosible writes to an external module
+type ExternalRequest struct {
+	Type string `json:"type"` // run or response
+	ID   int    `json:"id,omitempty"`
+
+	// Run requests give the task's host, arguments and modes
+	Host      string                 `json:"host,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+	CheckMode bool                   `json:"check_mode,omitempty"`
+	DiffMode  bool                   `json:"diff_mode,omitempty"`
+
+	// Responses give the outcome of an execute, copy or fetch message
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Content  []byte `json:"content,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ExternalMessage is a message an external module writes to gosible
+type ExternalMessage struct {
+	Type string `json:"type"` // execute, copy, fetch or result
+	ID   int    `json:"id,omitempty"`
+
+	// Execute messages run a command on the host
+	Command    string            `json:"command,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Timeout    int               `json:"timeout,omitempty"` // Seconds
+
+	// Copy messages write content to dest on the host, fetch messages read
+	// src from it
+	Src     string `json:"src,omitempty"`
+	Dest    string `json:"dest,omitempty"`
+	Mode    int    `json:"mode,omitempty"`
+	Content []byte `json:"content,omitempty"`
+
+	// Result messages end the run
+	Changed bool                   `json:"changed,omitempty"`
+	Failed  bool                   `json:"failed,omitempty"`
+	Msg     string                 `json:"msg,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Diff    *types.DiffResult      `json:"diff,omitempty"`
+}
+
+// LoadExternalModule loads the external module implemented by the
+// executable at path, asking it to describe itself
+func LoadExternalModule(path string) (*ExternalModule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalDescribeTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "describe")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("external module %s failed to describe itself: %w%s", path, err, stderrDetail(stderr.String()))
+	}
+
+	var desc ExternalDescription
+	if err := json.Unmarshal(output, &desc); err != nil {
+		return nil, fmt.Errorf("external module %s gave an invalid description: %w", path, err)
+	}
+	if desc.Protocol != ExternalProtocolVersion {
+		return nil, fmt.Errorf("external module %s speaks protocol %d, expected %d", path, desc.Protocol, ExternalProtocolVersion)
+	}
+	if desc.Name == "" {
+		return nil, fmt.Errorf("external module %s has no name", path)
+	}
+
+	base := NewBaseModule(desc.Name, desc.ModuleDoc)
+	if desc.Capabilities != nil {
+		base.SetCapabilities(desc.Capabilities)
+	}
+	return &ExternalModule{BaseModule: base, path: path}, nil
+}
+
+// Path returns the executable implementing the module
+func (m *ExternalModule) Path() string {
+	return m.path
+}
+
+// Validate checks the arguments against the parameters the module
+// documents: required ones must be given, and those with choices must take
+// one of them
+func (m *ExternalModule) Validate(args map[string]interface{}) error {
+	params := m.Documentation().Parameters
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		param := params[name]
+		if param.Required {
+			if err := m.ValidateRequired(args, []string{name}); err != nil {
+				return err
+			}
+		}
+		if len(param.Choices) > 0 {
+			if err := m.ValidateChoices(args, name, param.Choices); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run runs the executable for a task, acting on the host for it
+func (m *ExternalModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	// Arguments starting with _ pass the modes of the task, which the
+	// request gives on its own
+	moduleArgs := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if !strings.HasPrefix(key, "_") {
+			moduleArgs[key] = value
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.path, "run")
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start external module %s: %w", m.Name(), err)
+	}
+
+	session := &externalSession{conn: conn, stdin: json.NewEncoder(stdin)}
+	msg, err := session.serve(ctx, stdout, ExternalRequest{
+		Type:      "run",
+		Host:      hostname,
+		Args:      moduleArgs,
+		CheckMode: checkMode,
+		DiffMode:  diffMode,
+	})
+	stdin.Close()
+	if err != nil {
+		// The module may be blocked writing messages nobody reads anymore
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if err == nil && msg == nil {
+		err = fmt.Errorf("exited without a result")
+		if waitErr != nil {
+			err = waitErr
+		}
+	}
+	if err != nil {
+		return nil, types.NewModuleError(m.Name(), hostname, "external module failed", fmt.Errorf("%w%s", err, stderrDetail(stderr.String())))
+	}
+
+	var result *types.Result
+	if msg.Failed {
+		result = m.CreateResult(hostname, false, msg.Changed, msg.Msg, msg.Data, types.NewModuleError(m.Name(), hostname, msg.Msg, nil))
+	} else {
+		result = m.CreateSuccessResult(hostname, msg.Changed, msg.Msg, msg.Data)
+	}
+	if msg.Changed && checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+	if diffMode && msg.Diff != nil {
+		result.Diff = msg.Diff
+		result.Diff.Prepared = true
+	}
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// externalSession answers the messages of one run of an external module
+type externalSession struct {
+	conn  types.Connection
+	stdin *json.Encoder
+}
+
+// serve sends the run request, then answers the module's messages until it
+// gives its result, which is nil when the module stops without one
+func (s *externalSession) serve(ctx context.Context, stdout io.Reader, run ExternalRequest) (*ExternalMessage, error) {
+	if err := s.stdin.Encode(run); err != nil {
+		return nil, fmt.Errorf("failed to send the run request: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg ExternalMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid message %q: %w", line, err)
+		}
+		if msg.Type == "result" {
+			return &msg, nil
+		}
+
+		response, err := s.answer(ctx, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.stdin.Encode(response); err != nil {
+			return nil, fmt.Errorf("failed to answer message %d: %w", msg.ID, err)
+		}
+	}
+	return nil, scanner.Err()
+}
+
+// answer carries out an execute, copy or fetch message on the host.
+// Failures on the host are reported to the module, which decides what
+// they mean.
+func (s *externalSession) answer(ctx context.Context, msg *ExternalMessage) (ExternalRequest, error) {
+	response := ExternalRequest{Type: "response", ID: msg.ID}
+	switch msg.Type {
+	case "execute":
+		result, err := s.conn.Execute(ctx, msg.Command, types.ExecuteOptions{
+			WorkingDir: msg.WorkingDir,
+			Env:        msg.Env,
+			Timeout:    time.Duration(msg.Timeout) * time.Second,
+		})
+		if result != nil {
+			response.Stdout, _ = result.Data["stdout"].(string)
+			response.Stderr, _ = result.Data["stderr"].(string)
+			response.ExitCode, _ = types.ConvertToInt(result.Data["exit_code"])
+		}
+		if err != nil {
+			response.Error = err.Error()
+		} else if result != nil && !result.Success && response.ExitCode == 0 {
+			response.ExitCode = 1
+		}
+	case "copy":
+		mode := msg.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := s.conn.Copy(ctx, bytes.NewReader(msg.Content), msg.Dest, mode); err != nil {
+			response.Error = err.Error()
+		}
+	case "fetch":
+		reader, err := s.conn.Fetch(ctx, msg.Src)
+		if err == nil {
+			response.Content, err = io.ReadAll(reader)
+		}
+		if err != nil {
+			response.Error = err.Error()
+		}
+	default:
+		return response, fmt.Errorf("unknown message type %q", msg.Type)
+	}
+	return response, nil
+}
+
+// stderrDetail formats what a failed executable wrote on stderr for an
+// error message
+func stderrDetail(stderr string) string {
+	if stderr = strings.TrimSpace(stderr); stderr == "" {
+		return ""
+	}
+	return ": " + stderr
+}
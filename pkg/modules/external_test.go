@@ -0,0 +1,179 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// greetingModule is an external module that writes /etc/greeting unless
+// it already holds hello
+const greetingModule = `#!/bin/sh
+case "$1" in
+describe)
+  echo '{"protocol": 1, "name": "greeting", "description": "Write a greeting",
+    "parameters": {"name": {"description": "Who to greet", "required": true, "type": "str"},
+                   "state": {"type": "str", "choices": ["present", "absent"]}},
+    "capabilities": {"check_mode": true, "diff_mode": true, "platform": "linux"}}' | tr -d '\n'
+  echo
+  ;;
+run)
+  read request
+  echo '{"type": "execute", "id": 1, "command": "cat /etc/greeting"}'
+  read response
+  case "$response" in
+  *'"stdout":"hello'*)
+    echo '{"type": "result", "msg": "Already greeted", "data": {"greeting": "hello"}}' ;;
+  *'"exit_code":2'*)
+    echo 'no greeting file' >&2
+    exit 3 ;;
+  *)
+    case "$request" in
+    *'"check_mode":true'*)
+      echo '{"type": "result", "changed": true, "msg": "Would greet"}'
+      exit 0 ;;
+    esac
+    echo '{"type": "copy", "id": 2, "dest": "/etc/greeting", "content": "aGVsbG8K", "mode": 420}'
+    read response
+    printf '%s\n' '{"type": "result", "changed": true, "msg": "Greeted", "diff": {"before": "", "after": "hello\n"}}' ;;
+  esac
+  ;;
+esac
+`
+
+func writeExternalModule(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExternalModule(t *testing.T) {
+	module, err := LoadExternalModule(writeExternalModule(t, t.TempDir(), "greeting", greetingModule))
+	if err != nil {
+		t.Fatalf("LoadExternalModule failed: %v", err)
+	}
+	if module.Name() != "greeting" || !module.Capabilities().CheckMode {
+		t.Errorf("unexpected module %s with capabilities %+v", module.Name(), module.Capabilities())
+	}
+
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "world", "state": "present"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "world", "state": "latest"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Greet",
+			Args:     map[string]interface{}{"name": "world"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat /etc/greeting", &testhelper.CommandResponse{ExitCode: 1})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Greeted")
+				h.AssertDiffAfter(result, "hello\n")
+				if transfers := h.GetConnection().GetTransfers(); len(transfers) != 1 || transfers[0] != "/etc/greeting" {
+					t.Errorf("expected /etc/greeting to be copied, got %v", transfers)
+				}
+			},
+		},
+		{
+			Name: "AlreadyGreeted",
+			Args: map[string]interface{}{"name": "world"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat /etc/greeting", &testhelper.CommandResponse{Stdout: "hello\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "greeting", "hello")
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "world"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat /etc/greeting", &testhelper.CommandResponse{ExitCode: 1})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				if transfers := h.GetConnection().GetTransfers(); len(transfers) != 0 {
+					t.Errorf("expected no copy in check mode, got %v", transfers)
+				}
+			},
+		},
+		{
+			Name:        "ModuleFails",
+			Args:        map[string]interface{}{"name": "world"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat /etc/greeting", &testhelper.CommandResponse{ExitCode: 2})
+			},
+		},
+	})
+}
+
+func TestLoadExternalModule_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"protocol": "#!/bin/sh\necho '{\"protocol\": 2, \"name\": \"future\"}'\n",
+		"name":     "#!/bin/sh\necho '{\"protocol\": 1}'\n",
+		"json":     "#!/bin/sh\necho 'not json'\n",
+		"failure":  "#!/bin/sh\necho 'unsupported' >&2\nexit 1\n",
+	}
+	for name, content := range tests {
+		if _, err := LoadExternalModule(writeExternalModule(t, dir, name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestModuleRegistry_LoadModulePath(t *testing.T) {
+	dir := t.TempDir()
+	writeExternalModule(t, dir, "greeting", greetingModule)
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewModuleRegistry()
+	loaded, err := registry.LoadModulePath("", dir)
+	if err != nil {
+		t.Fatalf("LoadModulePath failed: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0] != "greeting" {
+		t.Errorf("expected greeting to be loaded, got %v", loaded)
+	}
+	if _, err := registry.GetModule("greeting"); err != nil {
+		t.Errorf("expected greeting to be registered: %v", err)
+	}
+
+	// Modules may not replace those already registered
+	if _, err := registry.LoadModulePath(dir); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	shadow := t.TempDir()
+	writeExternalModule(t, shadow, "copy", strings.Replace(greetingModule, `"name": "greeting"`, `"name": "copy"`, 1))
+	if _, err := NewModuleRegistry().LoadModulePath(shadow); err == nil {
+		t.Error("expected a module replacing copy to be refused")
+	}
+
+	// Plugins that fail to load are reported
+	if err := os.WriteFile(filepath.Join(shadow, "broken.so"), []byte("not a plugin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPluginModules(filepath.Join(shadow, "broken.so")); err == nil {
+		t.Error("expected an invalid plugin to be refused")
+	}
+}
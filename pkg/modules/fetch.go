@@ -0,0 +1,223 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// FetchModule implements the fetch module, which copies files from the
+// host into a local directory tree organized by host name
+type FetchModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewFetchModule creates a new fetch module
+func NewFetchModule() *FetchModule {
+	doc := types.ModuleDoc{
+		Name:        "fetch",
+		Description: "Fetch files from remote hosts into a local directory",
+		Parameters: map[string]types.ParamDoc{
+			"src": {
+				Description: "The file on the host to fetch. Directories are not supported",
+				Required:    true,
+				Type:        "path",
+			},
+			"dest": {
+				Description: "A local directory to save the file into, under <dest>/<inventory_hostname>/<src>",
+				Required:    true,
+				Type:        "path",
+			},
+			"flat": {
+				Description: "Save the file as dest itself, or under dest when it ends with /, instead of under a directory per host",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"fail_on_missing": {
+				Description: "Fail when the file does not exist on the host",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"validate_checksum": {
+				Description: "Verify that the fetched file matches the checksum of the file on the host",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			`- name: Collect the configuration of every host
+  fetch:
+    src: /etc/app/app.conf
+    dest: backups/`,
+			`- name: Fetch a report to a fixed name
+  fetch:
+    src: /tmp/report.json
+    dest: reports/{{ inventory_hostname }}.json
+    flat: true`,
+		},
+		Returns: map[string]string{
+			"src":             "The file on the host",
+			"dest":            "The local file the host's file was saved to",
+			"checksum":        "SHA1 checksum of the local file",
+			"remote_checksum": "SHA1 checksum of the file on the host",
+		},
+	}
+
+	return &FetchModule{
+		BaseModule: NewBaseModule("fetch", doc),
+	}
+}
+
+// Validate validates the module arguments
+func (m *FetchModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"src", "dest"}); err != nil {
+		return err
+	}
+
+	fieldTypes := map[string]string{
+		"src":               "string",
+		"dest":              "string",
+		"flat":              "bool",
+		"fail_on_missing":   "bool",
+		"validate_checksum": "bool",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the fetch module
+func (m *FetchModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+
+		src := m.GetStringArg(args, "src", "")
+		dest := m.localDest(args, src, m.GetTaskVar(args, "inventory_hostname", host))
+		data := map[string]interface{}{"src": src, "dest": dest}
+
+		remoteChecksum, err := m.remoteChecksum(ctx, conn, src)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to inspect %s", src), err), nil
+		}
+		switch remoteChecksum {
+		case "":
+			if m.GetBoolArg(args, "fail_on_missing", true) {
+				return m.CreateErrorResult(host, fmt.Sprintf("Failed to fetch %s", src), fmt.Errorf("the remote file does not exist")), nil
+			}
+			return m.CreateSuccessResult(host, false, "The remote file does not exist, not transferring", data), nil
+		case "directory":
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to fetch %s", src), fmt.Errorf("the remote file is a directory, fetch cannot work on directories")), nil
+		}
+		data["remote_checksum"] = remoteChecksum
+
+		if localChecksum, err := fileChecksum(dest); err == nil && localChecksum == remoteChecksum {
+			data["checksum"] = localChecksum
+			return m.CreateSuccessResult(host, false, fmt.Sprintf("%s is up to date", dest), data), nil
+		}
+
+		if m.CheckMode(args) {
+			return m.CreateCheckModeResult(host, true, fmt.Sprintf("Would fetch %s to %s", src, dest), data), nil
+		}
+
+		reader, err := conn.Fetch(ctx, src)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to fetch %s", src), err), nil
+		}
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to fetch %s", src), err), nil
+		}
+
+		sum := sha1.Sum(content)
+		checksum := hex.EncodeToString(sum[:])
+		if m.GetBoolArg(args, "validate_checksum", true) && checksum != remoteChecksum {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to fetch %s", src),
+				fmt.Errorf("checksum mismatch: the host reported %s, the fetched file has %s", remoteChecksum, checksum)), nil
+		}
+
+		if err := writeFileAtomic(dest, bytes.NewReader(content), 0644); err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to save %s", dest), err), nil
+		}
+		data["checksum"] = checksum
+		return m.CreateSuccessResult(host, true, fmt.Sprintf("Fetched %s to %s", src, dest), data), nil
+	})
+}
+
+// localDest returns where the host's file is saved: under a directory per
+// host mirroring src, or with flat, dest itself
+func (m *FetchModule) localDest(args map[string]interface{}, src, hostname string) string {
+	dest := m.GetStringArg(args, "dest", "")
+	if m.GetBoolArg(args, "flat", false) {
+		if strings.HasSuffix(dest, "/") {
+			return filepath.Join(dest, filepath.Base(src))
+		}
+		return dest
+	}
+	return filepath.Join(dest, hostname, filepath.FromSlash(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(src)), "/")))
+}
+
+// remoteChecksum returns the SHA1 checksum of a file on the host,
+// "directory" for a directory, or "" when it does not exist
+func (m *FetchModule) remoteChecksum(ctx context.Context, conn types.Connection, src string) (string, error) {
+	path := m.cli.shellEscape(src)
+	probe := fmt.Sprintf("if [ -d %s ]; then echo directory; elif [ -e %s ]; then sha1sum %s | cut -d' ' -f1; fi", path, path, path)
+	result, err := m.cli.run(ctx, conn, "checksumming "+src, probe)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(types.ConvertToString(result.Data["stdout"])), nil
+}
+
+// fileChecksum returns the SHA1 checksum of a local file
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeFileAtomic writes a local file through a temporary file in the same
+// directory, so readers never see it half written
+func writeFileAtomic(path string, content io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
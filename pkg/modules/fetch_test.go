@@ -0,0 +1,143 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestFetchModule(t *testing.T) {
+	remote := t.TempDir()
+	src := filepath.Join(remote, "app.conf")
+	if err := os.WriteFile(src, []byte("port=80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewFetchModule()
+	dest := t.TempDir()
+	vars := map[string]interface{}{"inventory_hostname": "web1"}
+
+	t.Run("PerHost", func(t *testing.T) {
+		args := map[string]interface{}{"src": src, "dest": dest, "_task_vars": vars}
+		expected := filepath.Join(dest, "web1", strings.TrimPrefix(src, "/"))
+
+		result, _ := m.Run(ctx, conn, args)
+		if !result.Success || !result.Changed || result.Data["dest"] != expected {
+			t.Fatalf("expected %s to be fetched, got %+v", expected, result)
+		}
+		if content, err := os.ReadFile(expected); err != nil || string(content) != "port=80\n" {
+			t.Errorf("expected the fetched content, got %q (%v)", content, err)
+		}
+
+		// Fetching the same file again changes nothing
+		if result, _ := m.Run(ctx, conn, args); !result.Success || result.Changed {
+			t.Errorf("expected an unchanged second run, got %+v", result)
+		}
+	})
+
+	t.Run("Flat", func(t *testing.T) {
+		tests := map[string]string{
+			filepath.Join(dest, "flat.conf"): filepath.Join(dest, "flat.conf"),
+			dest + "/flat/":                  filepath.Join(dest, "flat", "app.conf"),
+		}
+		for to, expected := range tests {
+			result, _ := m.Run(ctx, conn, map[string]interface{}{"src": src, "dest": to, "flat": true})
+			if !result.Success || result.Data["dest"] != expected {
+				t.Errorf("expected dest %s to save %s, got %+v", to, expected, result)
+			}
+			if _, err := os.Stat(expected); err != nil {
+				t.Errorf("expected %s to exist: %v", expected, err)
+			}
+		}
+	})
+
+	t.Run("CheckMode", func(t *testing.T) {
+		to := filepath.Join(dest, "check.conf")
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"src": src, "dest": to, "flat": true, "_check_mode": true})
+		if !result.Success || !result.Changed {
+			t.Errorf("expected a pending change, got %+v", result)
+		}
+		if _, err := os.Stat(to); !os.IsNotExist(err) {
+			t.Errorf("expected check mode not to write %s", to)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		args := map[string]interface{}{"src": filepath.Join(remote, "missing"), "dest": dest}
+		if result, _ := m.Run(ctx, conn, args); result.Success {
+			t.Error("expected a missing file to fail")
+		}
+		args["fail_on_missing"] = false
+		if result, _ := m.Run(ctx, conn, args); !result.Success || result.Changed {
+			t.Errorf("expected a missing file to be ignored, got %+v", result)
+		}
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		if result, _ := m.Run(ctx, conn, map[string]interface{}{"src": remote, "dest": dest}); result.Success {
+			t.Error("expected fetching a directory to fail")
+		}
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		mock := testhelper.NewMockConnection(t)
+		mock.ExpectCommandPattern(`sha1sum`, &testhelper.CommandResponse{Stdout: "0000000000000000000000000000000000000000\n"})
+		to := filepath.Join(dest, "corrupt.conf")
+		args := map[string]interface{}{"src": "/etc/app.conf", "dest": to, "flat": true}
+
+		if result, _ := m.Run(ctx, mock, args); result.Success {
+			t.Error("expected a checksum mismatch to fail")
+		}
+		if _, err := os.Stat(to); !os.IsNotExist(err) {
+			t.Errorf("expected a corrupt fetch not to be saved")
+		}
+
+		args["validate_checksum"] = false
+		mock.ExpectCommandPattern(`sha1sum`, &testhelper.CommandResponse{Stdout: "0000000000000000000000000000000000000000\n"})
+		if result, _ := m.Run(ctx, mock, args); !result.Success {
+			t.Errorf("expected the fetch to succeed without validation, got %+v", result)
+		}
+	})
+}
+
+func TestSlurpModule(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(src, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewSlurpModule()
+	result, _ := m.Run(ctx, conn, map[string]interface{}{"src": src})
+	if !result.Success || result.Changed {
+		t.Fatalf("expected an unchanged success, got %+v", result)
+	}
+	content, err := base64.StdEncoding.DecodeString(types.ConvertToString(result.Data["content"]))
+	if err != nil || string(content) != "s3cret\n" || result.Data["encoding"] != "base64" {
+		t.Errorf("expected the base64 content, got %v", result.Data)
+	}
+
+	if result, _ := m.Run(ctx, conn, map[string]interface{}{"src": src + ".missing"}); result.Success {
+		t.Error("expected a missing file to fail")
+	}
+}
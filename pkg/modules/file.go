@@ -25,6 +25,12 @@ func NewFileModule() *FileModule {
 
 // Run executes the file module
 func (m *FileModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *FileModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	path, _ := args["path"].(string)
 	state, _ := args["state"].(string)
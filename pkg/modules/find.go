@@ -0,0 +1,490 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// findStatFormat is the stat format of every file a find lists, the name
+// last so it may contain the separator
+const findStatFormat = `'%F|%s|%Y|%X|%Z|%a|%u|%g|%U|%G|%i|%h|%n'`
+
+// findAgeUnits and findSizeUnits are the suffixes accepted by age and size
+var (
+	findAgeUnits  = map[string]int64{"": 1, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+	findSizeUnits = map[string]int64{"": 1, "b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+)
+
+// FindModule implements the find module, which lists the files under
+// directories on the host that match patterns, age, size and type
+type FindModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewFindModule creates a new find module
+func NewFindModule() *FindModule {
+	doc := types.ModuleDoc{
+		Name:        "find",
+		Description: "Find files on the host by name, age, size and type",
+		Parameters: map[string]types.ParamDoc{
+			"paths": {
+				Description: "Directories to search, as a list or comma separated. path is accepted too",
+				Required:    true,
+				Type:        "list",
+			},
+			"patterns": {
+				Description: "Shell patterns, or regular expressions with use_regex, the base name of a file must match one of. By default every file matches",
+				Required:    false,
+				Type:        "list",
+			},
+			"excludes": {
+				Description: "Patterns of base names to leave out, in the same syntax as patterns",
+				Required:    false,
+				Type:        "list",
+			},
+			"use_regex": {
+				Description: "Treat patterns and excludes as regular expressions matching the whole base name",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"age": {
+				Description: "Select files at least this old, or with a leading -, at most this old. A number with an optional unit: s, m, h, d or w",
+				Required:    false,
+				Type:        "string",
+			},
+			"age_stamp": {
+				Description: "The time the age is measured from",
+				Required:    false,
+				Type:        "string",
+				Default:     "mtime",
+				Choices:     []string{"mtime", "atime", "ctime"},
+			},
+			"size": {
+				Description: "Select files at least this large, or with a leading -, at most this large. A number with an optional unit: b, k, m, g or t",
+				Required:    false,
+				Type:        "string",
+			},
+			"file_type": {
+				Description: "The type of files to select",
+				Required:    false,
+				Type:        "string",
+				Default:     "file",
+				Choices:     []string{"file", "directory", "link", "any"},
+			},
+			"recurse": {
+				Description: "Search the directories' subdirectories",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"depth": {
+				Description: "How many levels of subdirectories to search when recursing. By default there is no limit",
+				Required:    false,
+				Type:        "int",
+			},
+			"hidden": {
+				Description: "Include hidden files, and the files in hidden directories",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"follow": {
+				Description: "Follow symbolic links, reporting what they point to",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			`- name: Find logs older than two weeks
+  find:
+    paths: /var/log/app
+    patterns: "*.log,*.gz"
+    age: 2w
+    recurse: true
+  register: old_logs`,
+			`- name: Find large core dumps
+  find:
+    paths: [/var/crash, /tmp]
+    patterns: '^core\.\d+$'
+    use_regex: true
+    size: 100m`,
+		},
+		Returns: map[string]string{
+			"files":         "The matched files, each with path, size, mode, mtime, atime, ctime, uid, gid, pw_name, gr_name, inode, nlink, isreg, isdir and islnk",
+			"matched":       "The number of matched files",
+			"examined":      "The number of files looked at",
+			"skipped_paths": "Searched paths that are not directories, with the reason",
+		},
+	}
+
+	base := NewBaseModule("find", doc)
+	// Finding files only reads the host, so it runs alike in check mode
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     false,
+		Platform:     "linux",
+		RequiresRoot: false,
+	})
+
+	return &FindModule{
+		BaseModule: base,
+	}
+}
+
+// Validate validates the module arguments
+func (m *FindModule) Validate(args map[string]interface{}) error {
+	if len(findPaths(args)) == 0 {
+		return fmt.Errorf("missing required parameter: paths")
+	}
+	if err := m.ValidateChoices(args, "age_stamp", []string{"mtime", "atime", "ctime"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "file_type", []string{"file", "directory", "link", "any"}); err != nil {
+		return err
+	}
+	if _, err := m.filter(args); err != nil {
+		return err
+	}
+
+	fieldTypes := map[string]string{
+		"use_regex": "bool",
+		"recurse":   "bool",
+		"hidden":    "bool",
+		"follow":    "bool",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the find module
+func (m *FindModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+
+		filter, err := m.filter(args)
+		if err != nil {
+			return m.CreateErrorResult(host, "Invalid find arguments", err), nil
+		}
+
+		files := []map[string]interface{}{}
+		skipped := map[string]interface{}{}
+		examined := 0
+		for _, root := range findPaths(args) {
+			now, entries, isDir, err := m.list(ctx, conn, root, args)
+			if err != nil {
+				return m.CreateErrorResult(host, fmt.Sprintf("Failed to search %s", root), err), nil
+			}
+			if !isDir {
+				skipped[root] = fmt.Sprintf("%s is not a directory", root)
+				continue
+			}
+			examined += len(entries)
+			for _, entry := range entries {
+				if filter.match(entry, root, now) {
+					files = append(files, entry.result())
+				}
+			}
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i]["path"].(string) < files[j]["path"].(string)
+		})
+
+		data := map[string]interface{}{
+			"files":         files,
+			"matched":       len(files),
+			"examined":      examined,
+			"skipped_paths": skipped,
+		}
+		return m.CreateSuccessResult(host, false, fmt.Sprintf("Found %d files", len(files)), data), nil
+	})
+}
+
+// list stats every file under a directory on the host, returning the
+// host's clock with them, or reports that it is not a directory
+func (m *FindModule) list(ctx context.Context, conn types.Connection, root string, args map[string]interface{}) (int64, []findEntry, bool, error) {
+	find, stat := "find", "stat"
+	if m.GetBoolArg(args, "follow", false) {
+		find, stat = "find -L", "stat -L"
+	}
+	depth := " -maxdepth 1"
+	if m.GetBoolArg(args, "recurse", false) {
+		depth = ""
+		if limit, _ := m.GetIntArg(args, "depth", 0); limit > 0 {
+			depth = fmt.Sprintf(" -maxdepth %d", limit)
+		}
+	}
+	script := fmt.Sprintf(`r=%s; date +%%s; if [ -d "$r" ]; then %s "$r" -mindepth 1%s -exec %s -c %s {} + 2>/dev/null; else echo '!'; fi; true`,
+		m.cli.shellEscape(root), find, depth, stat, findStatFormat)
+
+	result, err := m.cli.run(ctx, conn, "listing "+root, script)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return parseFindListing(types.ConvertToString(result.Data["stdout"]))
+}
+
+// findEntry is a file listed by a find
+type findEntry struct {
+	path                string
+	kind                string // stat's file type, e.g. "regular file"
+	size                int64
+	mtime, atime, ctime int64
+	mode                string
+	uid, gid            int
+	owner, group        string
+	inode               int64
+	nlink               int
+}
+
+// result returns the file as returned by the module
+func (e findEntry) result() map[string]interface{} {
+	return map[string]interface{}{
+		"path":    e.path,
+		"size":    e.size,
+		"mode":    e.mode,
+		"mtime":   e.mtime,
+		"atime":   e.atime,
+		"ctime":   e.ctime,
+		"uid":     e.uid,
+		"gid":     e.gid,
+		"pw_name": e.owner,
+		"gr_name": e.group,
+		"inode":   e.inode,
+		"nlink":   e.nlink,
+		"isreg":   e.regular(),
+		"isdir":   e.kind == "directory",
+		"islnk":   e.kind == "symbolic link",
+	}
+}
+
+// regular reports whether the entry is a regular file
+func (e findEntry) regular() bool {
+	return strings.HasPrefix(e.kind, "regular")
+}
+
+// parseFindListing parses the output of a find on the host: its clock,
+// then a stat line per file, or "!" when the path is not a directory
+func parseFindListing(output string) (int64, []findEntry, bool, error) {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	now, err := strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64)
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("unexpected clock %q", lines[0])
+	}
+	if len(lines) > 1 && lines[1] == "!" {
+		return now, nil, false, nil
+	}
+
+	var entries []findEntry
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(line, "|", 13)
+		if len(fields) != 13 {
+			return 0, nil, false, fmt.Errorf("unexpected listing line %q", line)
+		}
+		ints := make([]int64, 0, 10)
+		for _, i := range []int{1, 2, 3, 4, 6, 7, 10, 11} {
+			n, err := strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return 0, nil, false, fmt.Errorf("unexpected listing line %q", line)
+			}
+			ints = append(ints, n)
+		}
+		mode := fields[5]
+		if len(mode) < 4 {
+			mode = strings.Repeat("0", 4-len(mode)) + mode
+		}
+		entries = append(entries, findEntry{
+			path:  fields[12],
+			kind:  fields[0],
+			size:  ints[0],
+			mtime: ints[1],
+			atime: ints[2],
+			ctime: ints[3],
+			mode:  mode,
+			uid:   int(ints[4]),
+			gid:   int(ints[5]),
+			owner: fields[8],
+			group: fields[9],
+			inode: ints[6],
+			nlink: int(ints[7]),
+		})
+	}
+	return now, entries, true, nil
+}
+
+// findPaths returns the directories to search, given as paths or path, as
+// a list or comma separated
+func findPaths(args map[string]interface{}) []string {
+	value, ok := args["paths"]
+	if !ok {
+		value = args["path"]
+	}
+	return findList(value)
+}
+
+// findList returns a list argument, splitting a string at commas
+func findList(value interface{}) []string {
+	var values []string
+	for _, item := range stringList(value) {
+		for _, part := range strings.Split(item, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// findFilter selects the files a find returns
+type findFilter struct {
+	patterns, excludes []string
+	regexps, excluded  []*regexp.Regexp
+	age, size          int64 // Negative for at most, zero for any
+	ageStamp           string
+	fileType           string
+	hidden             bool
+}
+
+// filter builds the filter the arguments describe
+func (m *FindModule) filter(args map[string]interface{}) (*findFilter, error) {
+	f := &findFilter{
+		patterns: findList(args["patterns"]),
+		excludes: findList(args["excludes"]),
+		ageStamp: m.GetStringArg(args, "age_stamp", "mtime"),
+		fileType: m.GetStringArg(args, "file_type", "file"),
+		hidden:   m.GetBoolArg(args, "hidden", false),
+	}
+
+	if m.GetBoolArg(args, "use_regex", false) {
+		for _, list := range []struct {
+			patterns []string
+			compiled *[]*regexp.Regexp
+		}{{f.patterns, &f.regexps}, {f.excludes, &f.excluded}} {
+			for _, pattern := range list.patterns {
+				re, err := regexp.Compile("^(?:" + pattern + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+				}
+				*list.compiled = append(*list.compiled, re)
+			}
+		}
+		f.patterns, f.excludes = nil, nil
+	} else {
+		for _, pattern := range append(append([]string{}, f.patterns...), f.excludes...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	var err error
+	if f.age, err = findQuantity(m.GetStringArg(args, "age", ""), findAgeUnits); err != nil {
+		return nil, fmt.Errorf("invalid age: %v", err)
+	}
+	if f.size, err = findQuantity(m.GetStringArg(args, "size", ""), findSizeUnits); err != nil {
+		return nil, fmt.Errorf("invalid size: %v", err)
+	}
+	return f, nil
+}
+
+// findQuantity parses an age or size: a number, negative for at most, with
+// an optional unit
+func findQuantity(value string, units map[string]int64) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	digits := strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz")
+	multiplier, ok := units[value[len(digits):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q", value)
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	return n * multiplier, nil
+}
+
+// match reports whether a file found under root is selected, at the
+// host's time now
+func (f *findFilter) match(e findEntry, root string, now int64) bool {
+	switch f.fileType {
+	case "file":
+		if !e.regular() {
+			return false
+		}
+	case "directory":
+		if e.kind != "directory" {
+			return false
+		}
+	case "link":
+		if e.kind != "symbolic link" {
+			return false
+		}
+	}
+
+	if !f.hidden {
+		rel := strings.TrimPrefix(e.path, strings.TrimSuffix(root, "/")+"/")
+		for _, part := range strings.Split(rel, "/") {
+			if strings.HasPrefix(part, ".") {
+				return false
+			}
+		}
+	}
+
+	name := path.Base(e.path)
+	if (len(f.patterns) > 0 || len(f.regexps) > 0) && !f.matchName(name, f.patterns, f.regexps) {
+		return false
+	}
+	if f.matchName(name, f.excludes, f.excluded) {
+		return false
+	}
+
+	if f.age != 0 {
+		stamp := e.mtime
+		switch f.ageStamp {
+		case "atime":
+			stamp = e.atime
+		case "ctime":
+			stamp = e.ctime
+		}
+		if !findWithin(now-stamp, f.age) {
+			return false
+		}
+	}
+	return f.size == 0 || findWithin(e.size, f.size)
+}
+
+// matchName reports whether a base name matches a shell pattern or a
+// regular expression
+func (f *findFilter) matchName(name string, patterns []string, regexps []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	for _, re := range regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// findWithin reports whether value is at least limit, or at most -limit
+// for a negative limit
+func findWithin(value, limit int64) bool {
+	if limit < 0 {
+		return value <= -limit
+	}
+	return value >= limit
+}
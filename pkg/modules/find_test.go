@@ -0,0 +1,162 @@
+package modules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseFindListing(t *testing.T) {
+	output := "1700000100\n" +
+		"regular file|6|1700000000|1700000050|1700000001|644|0|0|root|root|42|1|/srv/a|b.log\n" +
+		"directory|4096|1700000000|1700000000|1700000000|755|1000|1000|app|app|43|2|/srv/cache\n"
+	now, entries, isDir, err := parseFindListing(output)
+	if err != nil || !isDir || now != 1700000100 {
+		t.Fatalf("unexpected listing: %d %v (%v)", now, isDir, err)
+	}
+	expected := []findEntry{
+		{path: "/srv/a|b.log", kind: "regular file", size: 6, mtime: 1700000000, atime: 1700000050, ctime: 1700000001, mode: "0644", owner: "root", group: "root", inode: 42, nlink: 1},
+		{path: "/srv/cache", kind: "directory", size: 4096, mtime: 1700000000, atime: 1700000000, ctime: 1700000000, mode: "0755", uid: 1000, gid: 1000, owner: "app", group: "app", inode: 43, nlink: 2},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	if _, _, isDir, err := parseFindListing("1700000100\n!\n"); err != nil || isDir {
+		t.Errorf("expected a path that is not a directory, got %v (%v)", isDir, err)
+	}
+}
+
+func TestFindQuantity(t *testing.T) {
+	tests := []struct {
+		value    string
+		units    map[string]int64
+		expected int64
+	}{
+		{"", findAgeUnits, 0},
+		{"30", findAgeUnits, 30},
+		{"2d", findAgeUnits, 2 * 86400},
+		{"-1w", findAgeUnits, -604800},
+		{"10k", findSizeUnits, 10240},
+		{"1G", findSizeUnits, 1 << 30},
+	}
+	for _, tt := range tests {
+		if n, err := findQuantity(tt.value, tt.units); err != nil || n != tt.expected {
+			t.Errorf("findQuantity(%q) = %d (%v), expected %d", tt.value, n, err, tt.expected)
+		}
+	}
+	for _, value := range []string{"2y", "d", "1.5h"} {
+		if _, err := findQuantity(value, findAgeUnits); err == nil {
+			t.Errorf("expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestFindModule(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel string, size int, age time.Duration) string {
+		file := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stamp := time.Now().Add(-age)
+		os.Chtimes(file, stamp, stamp)
+		return file
+	}
+	oldLog := write("app.log", 10, 48*time.Hour)
+	newLog := write("app.2.log", 2048, 0)
+	nestedLog := write("archive/app.1.log", 10, 72*time.Hour)
+	deepLog := write("archive/2023/app.0.log", 10, 0)
+	write("notes.txt", 10, 0)
+	write(".hidden.log", 10, 0)
+	write(".git/app.log", 10, 0)
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewFindModule()
+	find := func(args map[string]interface{}) []string {
+		t.Helper()
+		if err := m.Validate(args); err != nil {
+			t.Fatalf("invalid arguments %v: %v", args, err)
+		}
+		result, err := m.Run(ctx, conn, args)
+		if err != nil || !result.Success || result.Changed {
+			t.Fatalf("find failed: %+v (%v)", result, err)
+		}
+		var paths []string
+		for _, file := range result.Data["files"].([]map[string]interface{}) {
+			paths = append(paths, file["path"].(string))
+		}
+		if result.Data["matched"] != len(paths) {
+			t.Errorf("expected matched to count the files, got %v", result.Data["matched"])
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		args     map[string]interface{}
+		expected []string
+	}{
+		{"Patterns", map[string]interface{}{"paths": root, "patterns": "*.log"}, []string{newLog, oldLog}},
+		{"Recurse", map[string]interface{}{"path": root, "patterns": []interface{}{"*.log"}, "recurse": true}, []string{newLog, oldLog, deepLog, nestedLog}},
+		{"Depth", map[string]interface{}{"paths": []string{root}, "patterns": "*.log", "recurse": true, "depth": 2}, []string{newLog, oldLog, nestedLog}},
+		{"Regex", map[string]interface{}{"paths": root, "patterns": `app\.\d\.log`, "use_regex": true, "recurse": true}, []string{newLog, deepLog, nestedLog}},
+		{"Excludes", map[string]interface{}{"paths": root, "patterns": "*.log", "excludes": "app.2.*"}, []string{oldLog}},
+		{"Older", map[string]interface{}{"paths": root, "age": "1d", "recurse": true}, []string{oldLog, nestedLog}},
+		{"Newer", map[string]interface{}{"paths": root, "age": "-1h", "patterns": "*.log"}, []string{newLog}},
+		{"Size", map[string]interface{}{"paths": root, "size": "1k"}, []string{newLog}},
+		{"Hidden", map[string]interface{}{"paths": root, "patterns": "*.log", "hidden": true, "recurse": true, "depth": 2},
+			[]string{filepath.Join(root, ".git", "app.log"), filepath.Join(root, ".hidden.log"), newLog, oldLog, nestedLog}},
+		{"Directories", map[string]interface{}{"paths": root, "file_type": "directory", "recurse": true}, []string{filepath.Join(root, "archive"), filepath.Join(root, "archive", "2023")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if paths := find(tt.args); !reflect.DeepEqual(paths, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+
+	t.Run("Metadata", func(t *testing.T) {
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"paths": root, "patterns": "app.2.log"})
+		file := result.Data["files"].([]map[string]interface{})[0]
+		if file["size"] != int64(2048) || file["mode"] != "0644" || file["isreg"] != true || file["isdir"] != false {
+			t.Errorf("unexpected metadata %v", file)
+		}
+	})
+
+	t.Run("NotDirectory", func(t *testing.T) {
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"paths": []interface{}{oldLog, filepath.Join(root, "missing")}})
+		if !result.Success || result.Data["matched"] != 0 || len(result.Data["skipped_paths"].(map[string]interface{})) != 2 {
+			t.Errorf("expected both paths to be skipped, got %+v", result)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, args := range []map[string]interface{}{
+			{},
+			{"paths": root, "age": "soon"},
+			{"paths": root, "patterns": "(", "use_regex": true},
+			{"paths": root, "file_type": "socket"},
+		} {
+			if err := m.Validate(args); err == nil {
+				t.Errorf("expected %v to be invalid", args)
+			}
+		}
+	})
+}
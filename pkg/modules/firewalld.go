@@ -0,0 +1,328 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// firewalldItems are the zone settings the module manages, in the order
+// they are applied, with the firewall-cmd option suffix of each
+var firewalldItems = []struct{ param, option string }{
+	{"source", "source"},
+	{"interface", "interface"},
+	{"service", "service"},
+	{"port", "port"},
+	{"rich_rule", "rich-rule"},
+}
+
+// FirewalldModule manages firewalld zones and their services, ports, rich
+// rules, sources and interfaces
+type FirewalldModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewFirewalldModule creates a new firewalld module instance
+func NewFirewalldModule() *FirewalldModule {
+	doc := types.ModuleDoc{
+		Name:        "firewalld",
+		Description: "Manage services, ports, rich rules, sources and interfaces of firewalld zones in the permanent and runtime configurations",
+		Parameters: map[string]types.ParamDoc{
+			"zone": {
+				Description: "Zone to change; the default zone when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+			"service": {
+				Description: "Service name or list of service names",
+				Required:    false,
+				Type:        "list",
+			},
+			"port": {
+				Description: "Port or port range with protocol, e.g. 443/tcp or 60000-61000/udp, or a list of them",
+				Required:    false,
+				Type:        "list",
+			},
+			"rich_rule": {
+				Description: "Rich rule or list of rich rules",
+				Required:    false,
+				Type:        "list",
+			},
+			"source": {
+				Description: "Source address or network, or a list of them, to bind to the zone",
+				Required:    false,
+				Type:        "list",
+			},
+			"interface": {
+				Description: "Interface or list of interfaces to move into the zone",
+				Required:    false,
+				Type:        "list",
+			},
+			"masquerade": {
+				Description: "Whether masquerading is enabled in the zone",
+				Required:    false,
+				Type:        "bool",
+			},
+			"state": {
+				Description: "enabled and disabled add and remove the given settings; present and absent create and delete the zone itself in the permanent configuration",
+				Required:    false,
+				Type:        "string",
+				Default:     "enabled",
+				Choices:     []string{"enabled", "disabled", "present", "absent"},
+			},
+			"permanent": {
+				Description: "Change the permanent configuration; false only changes the runtime configuration",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"immediate": {
+				Description: "Also apply permanent changes to the runtime configuration",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			"- name: Open HTTPS\n  firewalld:\n    service: https\n    zone: public",
+			"- name: Allow the monitoring network to scrape exporters\n  firewalld:\n    zone: internal\n    source: 10.20.0.0/16\n    port:\n      - 9100/tcp\n      - 9256/tcp",
+			"- name: Rate limit SSH\n  firewalld:\n    rich_rule: rule service name=\"ssh\" accept limit value=\"10/m\"",
+			"- name: Create the dmz2 zone\n  firewalld:\n    zone: dmz2\n    state: present",
+		},
+		Returns: map[string]string{
+			"zone":    "Zone that was changed, empty for the default zone",
+			"changes": "firewall-cmd options that were applied",
+			"offline": "Whether firewalld was not running, so only the permanent configuration was changed",
+		},
+	}
+
+	base := NewBaseModule("firewalld", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &FirewalldModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *FirewalldModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateChoices(args, "state", []string{"enabled", "disabled", "present", "absent"}); err != nil {
+		return err
+	}
+
+	settings := 0
+	for _, item := range firewalldItems {
+		for _, value := range stringList(args[item.param]) {
+			if strings.TrimSpace(value) == "" {
+				return types.NewValidationError(item.param, value, item.param+" must not be empty")
+			}
+			settings++
+		}
+	}
+	for _, port := range stringList(args["port"]) {
+		if number, proto, ok := strings.Cut(port, "/"); !ok || number == "" || proto == "" {
+			return types.NewValidationError("port", port, "port must be a port or range with a protocol, e.g. 443/tcp")
+		}
+	}
+	if _, ok := args["masquerade"]; ok {
+		settings++
+	}
+
+	state := m.GetStringArg(args, "state", "enabled")
+	zone := m.GetStringArg(args, "zone", "")
+	if state == "present" || state == "absent" {
+		if zone == "" {
+			return types.NewValidationError("zone", nil, "zone is required when state is "+state)
+		}
+		if settings > 0 {
+			return types.NewValidationError("state", state, "state "+state+" manages the zone itself and takes no other settings")
+		}
+		if !m.GetBoolArg(args, "permanent", true) {
+			return types.NewValidationError("permanent", false, "zones can only be created and deleted in the permanent configuration")
+		}
+	} else if settings == 0 {
+		return types.NewValidationError("service", nil, "one of service, port, rich_rule, source, interface or masquerade is required")
+	}
+	return nil
+}
+
+// firewalldCLI builds firewall-cmd invocations for one configuration
+type firewalldCLI struct {
+	remoteCLI
+	executable string
+	permanent  bool
+	zone       string
+}
+
+// command returns a firewall-cmd invocation for the zone with the option
+// and its value, if any
+func (c firewalldCLI) command(option, value string) string {
+	cmd := c.executable
+	if c.permanent && c.executable == "firewall-cmd" {
+		cmd += " --permanent"
+	}
+	if c.zone != "" {
+		cmd += " --zone=" + c.shellEscape(c.zone)
+	}
+	cmd += " --" + option
+	if value != "" {
+		cmd += "=" + c.shellEscape(value)
+	}
+	return cmd
+}
+
+// query reports whether the setting is enabled. firewall-cmd exits 1 for
+// a setting that is not enabled and with other codes on errors.
+func (c firewalldCLI) query(ctx context.Context, conn types.Connection, option, value string) (bool, error) {
+	result, err := c.run(ctx, conn, "querying firewalld", c.command("query-"+option, value)+" || [ $? -eq 1 ]")
+	if err != nil {
+		return false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return strings.TrimSpace(stdout) == "yes", nil
+}
+
+// Run executes the firewalld module
+func (m *FirewalldModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	zone := m.GetStringArg(args, "zone", "")
+	state := m.GetStringArg(args, "state", "enabled")
+	permanent := m.GetBoolArg(args, "permanent", true)
+
+	// firewall-cmd only works while the daemon runs, the permanent
+	// configuration of a stopped firewalld is changed offline
+	result, err := m.cli.run(ctx, conn, "checking firewalld", "firewall-cmd --state 2>&1 || true")
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	offline := strings.Contains(stdout, "not running")
+
+	var configs []firewalldCLI
+	if permanent {
+		executable := "firewall-cmd"
+		if offline {
+			executable = "firewall-offline-cmd"
+		}
+		configs = append(configs, firewalldCLI{executable: executable, permanent: true, zone: zone})
+	}
+	if !offline && (!permanent || m.GetBoolArg(args, "immediate", true)) {
+		configs = append(configs, firewalldCLI{executable: "firewall-cmd", zone: zone})
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("firewalld is not running, so the runtime configuration cannot be changed")
+	}
+
+	data := map[string]interface{}{"zone": zone, "offline": offline}
+	var changes, steps, applied []string
+	var before, after strings.Builder
+
+	if state == "present" || state == "absent" {
+		cli := firewalldCLI{remoteCLI: m.cli, executable: configs[0].executable, permanent: true}
+		zones, err := m.cli.run(ctx, conn, "listing firewalld zones", cli.command("get-zones", ""))
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := zones.Data["stdout"].(string)
+		exists := false
+		for _, name := range strings.Fields(stdout) {
+			exists = exists || name == zone
+		}
+		fmt.Fprintf(&before, "zone %s: %t\n", zone, exists)
+		fmt.Fprintf(&after, "zone %s: %t\n", zone, state == "present")
+
+		switch {
+		case state == "present" && !exists:
+			steps = append(steps, cli.command("new-zone", zone))
+			changes = append(changes, "created zone "+zone)
+		case state == "absent" && exists:
+			steps = append(steps, cli.command("delete-zone", zone))
+			changes = append(changes, "deleted zone "+zone)
+		}
+	} else {
+		enable := state == "enabled"
+		verb, action := "add", "enabled"
+		if !enable {
+			verb, action = "remove", "disabled"
+		}
+
+		type setting struct{ option, value, label string }
+		var settings []setting
+		for _, item := range firewalldItems {
+			for _, value := range stringList(args[item.param]) {
+				settings = append(settings, setting{item.option, value, item.param + " " + value})
+			}
+		}
+
+		for _, config := range configs {
+			config.remoteCLI = m.cli
+			name := "runtime"
+			if config.permanent {
+				name = "permanent"
+			}
+
+			for _, s := range settings {
+				current, err := config.query(ctx, conn, s.option, s.value)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&before, "%s %s: %t\n", name, s.label, current)
+				fmt.Fprintf(&after, "%s %s: %t\n", name, s.label, enable)
+				if current == enable {
+					continue
+				}
+				option := verb + "-" + s.option
+				if s.option == "interface" && enable {
+					// An interface belongs to one zone, adding it fails
+					// while another zone has it
+					option = "change-interface"
+				}
+				steps = append(steps, config.command(option, s.value))
+				applied = append(applied, fmt.Sprintf("%s --%s=%s", name, option, s.value))
+				changes = append(changes, fmt.Sprintf("%s %s in the %s configuration", action, s.label, name))
+			}
+
+			if _, ok := args["masquerade"]; ok {
+				want := m.GetBoolArg(args, "masquerade", false)
+				current, err := config.query(ctx, conn, "masquerade", "")
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&before, "%s masquerade: %t\n", name, current)
+				fmt.Fprintf(&after, "%s masquerade: %t\n", name, want)
+				if current != want {
+					option, done := "add-masquerade", "enabled"
+					if !want {
+						option, done = "remove-masquerade", "disabled"
+					}
+					steps = append(steps, config.command(option, ""))
+					applied = append(applied, fmt.Sprintf("%s --%s", name, option))
+					changes = append(changes, fmt.Sprintf("%s masquerade in the %s configuration", done, name))
+				}
+			}
+		}
+	}
+	data["changes"] = applied
+
+	if !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "changing firewalld", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result = m.CreateSuccessResult(hostname, false, "Firewalld is already in desired state", data)
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
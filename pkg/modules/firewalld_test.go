@@ -0,0 +1,121 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestFirewalldModule(t *testing.T) {
+	module := NewFirewalldModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidService", Args: map[string]interface{}{"service": "https"}, ExpectValid: true},
+		{Name: "ValidPortList", Args: map[string]interface{}{"port": []interface{}{"9100/tcp", "60000-61000/udp"}, "zone": "internal"}, ExpectValid: true},
+		{Name: "ValidZone", Args: map[string]interface{}{"zone": "dmz2", "state": "present"}, ExpectValid: true},
+		{Name: "NothingToManage", Args: map[string]interface{}{"zone": "public"}, ExpectValid: false},
+		{Name: "PortWithoutProtocol", Args: map[string]interface{}{"port": "443"}, ExpectValid: false},
+		{Name: "ZoneStateWithSettings", Args: map[string]interface{}{"zone": "dmz2", "state": "present", "service": "http"}, ExpectValid: false},
+		{Name: "RuntimeZone", Args: map[string]interface{}{"zone": "dmz2", "state": "absent", "permanent": false}, ExpectValid: false},
+	})
+
+	running := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommand("firewall-cmd --state 2>&1 || true", &testhelper.CommandResponse{Stdout: "running\n"})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "EnablePermanentAndRuntime",
+			Args:     map[string]interface{}{"zone": "public", "service": "https", "port": "8443/tcp"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				running(h)
+				h.GetConnection().ExpectCommand(`firewall-cmd --permanent --zone='public' --query-service='https' || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "yes\n"})
+				h.GetConnection().ExpectCommand(`firewall-cmd --permanent --zone='public' --query-port='8443/tcp' || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "no\n"})
+				h.GetConnection().ExpectCommand(`firewall-cmd --zone='public' --query-service='https' || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "no\n"})
+				h.GetConnection().ExpectCommand(`firewall-cmd --zone='public' --query-port='8443/tcp' || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "no\n"})
+				h.GetConnection().ExpectCommand(`firewall-cmd --permanent --zone='public' --add-port='8443/tcp'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`firewall-cmd --zone='public' --add-service='https'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`firewall-cmd --zone='public' --add-port='8443/tcp'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Enabled port 8443/tcp in the permanent configuration, enabled service https in the runtime configuration, enabled port 8443/tcp in the runtime configuration")
+				h.AssertDiffBefore(result, "permanent service https: true\npermanent port 8443/tcp: false\nruntime service https: false\nruntime port 8443/tcp: false\n")
+			},
+		},
+		{
+			Name: "RichRuleAlreadyPresent",
+			Args: map[string]interface{}{"rich_rule": `rule service name="ssh" accept limit value="10/m"`, "immediate": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				running(h)
+				h.GetConnection().ExpectCommand(`firewall-cmd --permanent --query-rich-rule='rule service name="ssh" accept limit value="10/m"' || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "yes\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "InterfaceMovesZone",
+			Args: map[string]interface{}{"zone": "internal", "interface": "eth1", "permanent": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				running(h)
+				h.GetConnection().ExpectCommandPattern(`--query-interface='eth1'`, &testhelper.CommandResponse{Stdout: "no\n"})
+				h.GetConnection().ExpectCommand(`firewall-cmd --zone='internal' --change-interface='eth1'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				if changes, _ := result.Data["changes"].([]string); len(changes) != 1 || changes[0] != "runtime --change-interface=eth1" {
+					t.Errorf("unexpected changes %v", result.Data["changes"])
+				}
+			},
+		},
+		{
+			Name: "DisableMasqueradeOffline",
+			Args: map[string]interface{}{"zone": "external", "masquerade": false, "state": "disabled"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("firewall-cmd --state 2>&1 || true", &testhelper.CommandResponse{Stdout: "not running\n"})
+				h.GetConnection().ExpectCommand(`firewall-offline-cmd --zone='external' --query-masquerade || [ $? -eq 1 ]`, &testhelper.CommandResponse{Stdout: "yes\n"})
+				h.GetConnection().ExpectCommand(`firewall-offline-cmd --zone='external' --remove-masquerade`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Disabled masquerade in the permanent configuration")
+				h.AssertDataValue(result, "offline", true)
+			},
+		},
+		{
+			Name:        "RuntimeOnlyWhileStopped",
+			Args:        map[string]interface{}{"service": "http", "permanent": false},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("firewall-cmd --state 2>&1 || true", &testhelper.CommandResponse{Stdout: "not running\n"})
+			},
+		},
+		{
+			Name:      "CreateZoneCheckMode",
+			Args:      map[string]interface{}{"zone": "dmz2", "state": "present"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				running(h)
+				h.GetConnection().ExpectCommand("firewall-cmd --permanent --get-zones", &testhelper.CommandResponse{Stdout: "block dmz drop external home internal public trusted work\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have created zone dmz2")
+			},
+		},
+		{
+			Name:        "UnknownService",
+			Args:        map[string]interface{}{"service": "bogus", "immediate": false},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				running(h)
+				h.GetConnection().ExpectCommandPattern(`--query-service='bogus'`, &testhelper.CommandResponse{ExitCode: 101, Stderr: "Error: INVALID_SERVICE: bogus"})
+			},
+		},
+	})
+}
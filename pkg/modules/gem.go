@@ -17,7 +17,9 @@ type GemModule struct {
 // NewGemModule creates a new gem module instance
 func NewGemModule() *GemModule {
 	return &GemModule{
-		BaseModule: BaseModule{},
+		BaseModule: BaseModule{
+			name: "gem",
+		},
 	}
 }
 
@@ -0,0 +1,610 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// getURLChecksums maps checksum algorithms to the tools computing them
+var getURLChecksums = map[string]string{
+	"md5":    "md5sum",
+	"sha1":   "sha1sum",
+	"sha224": "sha224sum",
+	"sha256": "sha256sum",
+	"sha384": "sha384sum",
+	"sha512": "sha512sum",
+}
+
+var hexDigestPattern = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// getURLFile is a downloaded file or the file at dest
+type getURLFile struct {
+	checksum string
+	size     int64
+	mode     string
+	owner    string
+	group    string
+	etag     string
+}
+
+func (f *getURLFile) describe(dest string) string {
+	if f == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s (%d bytes, mode %s, %s:%s)\nchecksum: %s\n", dest, f.size, f.mode, f.owner, f.group, f.checksum)
+}
+
+// GetURLModule downloads files over HTTP, HTTPS or FTP on the target host
+type GetURLModule struct {
+	*BaseModule
+	cli remoteCLI
+
+	progress func(types.ProgressInfo)
+}
+
+// NewGetURLModule creates a new get_url module instance
+func NewGetURLModule() *GetURLModule {
+	doc := types.ModuleDoc{
+		Name:        "get_url",
+		Description: "Download files over HTTP, HTTPS or FTP on the target host with curl, verifying checksums and skipping unchanged content",
+		Parameters: map[string]types.ParamDoc{
+			"url": {
+				Description: "HTTP, HTTPS or FTP URL to download",
+				Required:    true,
+				Type:        "string",
+			},
+			"dest": {
+				Description: "Path to download to; a directory gets the file named after the last URL path segment",
+				Required:    true,
+				Type:        "path",
+			},
+			"checksum": {
+				Description: "Expected checksum as algorithm:digest, e.g. sha256:9f86d0...; a file matching it is not downloaded again",
+				Required:    false,
+				Type:        "string",
+			},
+			"force": {
+				Description: "Download again when dest exists; HTTP downloads are conditional on dest's modification time and the stored ETag",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"etag_file": {
+				Description: "File keeping the ETag of the last download, sent as If-None-Match on the next one",
+				Required:    false,
+				Type:        "path",
+			},
+			"headers": {
+				Description: "Extra request headers",
+				Required:    false,
+				Type:        "dict",
+			},
+			"url_username": {
+				Description: "User name for basic authentication",
+				Required:    false,
+				Type:        "string",
+			},
+			"url_password": {
+				Description: "Password for basic authentication",
+				Required:    false,
+				Type:        "string",
+			},
+			"validate_certs": {
+				Description: "Verify the server's TLS certificate",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"use_proxy": {
+				Description: "Honor the proxy environment variables of the target host",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"proxy": {
+				Description: "Proxy URL, overriding the proxy environment variables",
+				Required:    false,
+				Type:        "string",
+			},
+			"timeout": {
+				Description: "Seconds to wait for the connection to the server",
+				Required:    false,
+				Type:        "int",
+				Default:     10,
+			},
+			"retries": {
+				Description: "Retries of transient failures, waiting twice as long after each one",
+				Required:    false,
+				Type:        "int",
+				Default:     3,
+			},
+			"retry_delay": {
+				Description: "Fixed seconds to wait between retries instead of the doubling backoff",
+				Required:    false,
+				Type:        "int",
+			},
+			"tmp_dest": {
+				Description: "Directory the download is written to before it is moved to dest, defaults to dest's directory",
+				Required:    false,
+				Type:        "path",
+			},
+			"mode": {
+				Description: "Permissions of the file, defaults to those of the replaced file or the umask",
+				Required:    false,
+				Type:        "string",
+			},
+			"owner": {
+				Description: "Owner of the file",
+				Required:    false,
+				Type:        "string",
+			},
+			"group": {
+				Description: "Group of the file",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Download node_exporter\n  get_url:\n    url: https://github.com/prometheus/node_exporter/releases/download/v1.8.2/node_exporter-1.8.2.linux-amd64.tar.gz\n    dest: /opt/downloads/\n    checksum: sha256:6809dd0b3ec45fd6e992c19071d6b5253aed3ead7bf0686885a51d85c6643c66",
+			"- name: Keep the blocklist current\n  get_url:\n    url: https://lists.example.com/blocklist.txt\n    dest: /etc/nginx/blocklist.txt\n    force: true\n    etag_file: /var/lib/gosible/blocklist.etag\n    proxy: http://proxy.example.com:3128\n    headers:\n      Authorization: \"Bearer {{ lists_token }}\"\n    mode: \"0644\"",
+		},
+		Returns: map[string]string{
+			"dest":          "Path of the downloaded file",
+			"checksum_dest": "Checksum of the file at dest",
+			"size":          "Size of the file in bytes",
+			"status_code":   "Response code of the download, e.g. 200 or 304",
+			"etag":          "ETag the server sent",
+		},
+	}
+
+	base := NewBaseModule("get_url", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &GetURLModule{BaseModule: base}
+}
+
+// SetProgressCallback sets where download progress goes when the
+// connection streams output; progress is logged otherwise
+func (m *GetURLModule) SetProgressCallback(callback func(types.ProgressInfo)) {
+	m.progress = callback
+}
+
+// Validate validates the module arguments
+func (m *GetURLModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"url", "dest"}); err != nil {
+		return err
+	}
+	raw := m.GetStringArg(args, "url", "")
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return types.NewValidationError("url", raw, "must be an absolute URL")
+	}
+	switch parsed.Scheme {
+	case "http", "https", "ftp":
+	default:
+		return types.NewValidationError("url", raw, "scheme must be http, https or ftp")
+	}
+	if checksum := m.GetStringArg(args, "checksum", ""); checksum != "" {
+		if _, _, err := parseGetURLChecksum(checksum); err != nil {
+			return types.NewValidationError("checksum", checksum, err.Error())
+		}
+	}
+	if mode := m.GetStringArg(args, "mode", ""); mode != "" && !isOctalMode(mode) {
+		return types.NewValidationError("mode", mode, "mode must be octal, e.g. 0644")
+	}
+	for _, name := range []string{"timeout", "retries", "retry_delay"} {
+		if value, err := m.GetIntArg(args, name, 0); err != nil || value < 0 {
+			return types.NewValidationError(name, args[name], "must be a positive number")
+		}
+	}
+	if headers, ok := args["headers"]; ok && headers != nil {
+		if _, ok := headers.(map[string]interface{}); !ok {
+			return types.NewValidationError("headers", headers, "must be a dictionary of header names to values")
+		}
+	}
+	return nil
+}
+
+// parseGetURLChecksum splits algorithm:digest
+func parseGetURLChecksum(checksum string) (string, string, error) {
+	algorithm, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return "", "", fmt.Errorf("must be algorithm:digest")
+	}
+	algorithm = strings.ToLower(algorithm)
+	if _, known := getURLChecksums[algorithm]; !known {
+		return "", "", fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	if !hexDigestPattern.MatchString(digest) {
+		return "", "", fmt.Errorf("digest must be hexadecimal")
+	}
+	return algorithm, strings.ToLower(digest), nil
+}
+
+// curlOptions builds the curl options shared by downloads and probes
+func (m *GetURLModule) curlOptions(args map[string]interface{}) []string {
+	timeout, _ := m.GetIntArg(args, "timeout", 10)
+	retries, _ := m.GetIntArg(args, "retries", 3)
+	options := []string{"--location", "--fail", "--silent", "--show-error", "--connect-timeout", strconv.Itoa(timeout)}
+	if retries > 0 {
+		// Without a delay curl doubles the wait after each retry
+		options = append(options, "--retry", strconv.Itoa(retries), "--retry-connrefused")
+		if delay, _ := m.GetIntArg(args, "retry_delay", 0); delay > 0 {
+			options = append(options, "--retry-delay", strconv.Itoa(delay))
+		}
+	}
+	if !m.GetBoolArg(args, "validate_certs", true) {
+		options = append(options, "--insecure")
+	}
+	if proxy := m.GetStringArg(args, "proxy", ""); proxy != "" {
+		options = append(options, "--proxy", proxy)
+	} else if !m.GetBoolArg(args, "use_proxy", true) {
+		options = append(options, "--noproxy", "*")
+	}
+	if user := m.GetStringArg(args, "url_username", ""); user != "" {
+		options = append(options, "--user", user+":"+m.GetStringArg(args, "url_password", ""))
+	}
+	headers := m.GetMapArg(args, "headers")
+	for _, name := range sortedInterfaceKeys(headers) {
+		options = append(options, "--header", fmt.Sprintf("%s: %v", name, headers[name]))
+	}
+	return options
+}
+
+// sortedInterfaceKeys returns the keys of a map in order
+func sortedInterfaceKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// conditional builds the curl options making a request conditional on the
+// file at dest, which only HTTP servers honor
+func (m *GetURLModule) conditional(rawURL, dest string, current *getURLFile) []string {
+	if current == nil || !strings.HasPrefix(rawURL, "http") {
+		return nil
+	}
+	options := []string{"--time-cond", dest}
+	if current.etag != "" {
+		options = append(options, "--header", "If-None-Match: "+current.etag)
+	}
+	return options
+}
+
+// inspect reads the checksum and attributes of dest and the stored ETag
+func (m *GetURLModule) inspect(ctx context.Context, conn types.Connection, dest, tool, etagFile string) (*getURLFile, error) {
+	quoted := m.cli.shellEscape(dest)
+	show := fmt.Sprintf(`printf 'checksum=%%s\n' "$(%s %s | cut -d' ' -f1)"; stat --printf 'size=%%s\nmode=%%a\nowner=%%U\ngroup=%%G\n' %s`, tool, quoted, quoted)
+	if etagFile != "" {
+		show += fmt.Sprintf("; sed -n '1s/^/etag=/p' %s 2>/dev/null", m.cli.shellEscape(etagFile))
+	}
+	output, exists, err := m.cli.inspect(ctx, conn, dest, "[ -f "+quoted+" ]", show)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return parseGetURLFile(output), nil
+}
+
+// parseGetURLFile reads the key=value lines printed about a file
+func parseGetURLFile(output string) *getURLFile {
+	file := &getURLFile{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		switch key {
+		case "checksum":
+			file.checksum = value
+		case "size":
+			file.size, _ = strconv.ParseInt(value, 10, 64)
+		case "mode":
+			file.mode = value
+		case "owner":
+			file.owner = value
+		case "group":
+			file.group = value
+		case "etag":
+			file.etag = value
+		}
+	}
+	return file
+}
+
+// downloadScript downloads to a temporary file next to dest and reports
+// the response code, checksum, size and ETag of the download. When
+// streaming, the size downloaded so far is printed every second.
+func (m *GetURLModule) downloadScript(args map[string]interface{}, rawURL, dest, tool string, current *getURLFile, streaming bool) string {
+	tmpDir := m.GetStringArg(args, "tmp_dest", path.Dir(dest))
+	options := append(m.curlOptions(args), m.conditional(rawURL, dest, current)...)
+	options = append(options, "--output", `"$tmp"`, "--dump-header", `"$tmp.headers"`, "--write-out", `'status=%{response_code}\n'`)
+
+	curl := []string{"curl"}
+	for _, option := range options {
+		if strings.HasPrefix(option, `"$tmp`) || strings.HasPrefix(option, `'status=`) {
+			curl = append(curl, option)
+		} else {
+			curl = append(curl, m.cli.shellEscape(option))
+		}
+	}
+	curl = append(curl, "--", m.cli.shellEscape(rawURL))
+
+	download := strings.Join(curl, " ") + "; rc=$?"
+	if streaming {
+		download = strings.Join(curl, " ") + ` & pid=$!; ` +
+			`while kill -0 $pid 2>/dev/null; do printf 'progress=%s %s\n' "$(wc -c < "$tmp")" "$(sed -n 's/^[Cc]ontent-[Ll]ength: *\([0-9]*\).*/\1/p' "$tmp.headers" 2>/dev/null | tail -n 1)"; sleep 1; done; ` +
+			`wait $pid; rc=$?`
+	}
+
+	return strings.Join([]string{
+		fmt.Sprintf("tmp=$(mktemp %s) || exit 1", m.cli.shellEscape(strings.TrimSuffix(tmpDir, "/")+"/.gosible-get_url.XXXXXX")),
+		download,
+		`printf 'tmp=%s\n' "$tmp"`,
+		fmt.Sprintf(`if [ $rc -eq 0 ]; then printf 'checksum=%%s\n' "$(%s "$tmp" | cut -d' ' -f1)"; printf 'size=%%s\n' "$(wc -c < "$tmp")"; sed -n 's/^[Ee][Tt][Aa][Gg]: *//p' "$tmp.headers" | tr -d '\r' | tail -n 1 | sed 's/^/etag=/'; else rm -f "$tmp"; fi`, tool),
+		`rm -f "$tmp.headers"`,
+		"exit $rc",
+	}, "; ")
+}
+
+// download runs the download script, streaming progress when the
+// connection supports it
+func (m *GetURLModule) download(ctx context.Context, conn types.Connection, script, rawURL string) (string, error) {
+	streamer, ok := conn.(types.StreamingConnection)
+	if !ok {
+		result, err := m.cli.run(ctx, conn, "downloading "+rawURL, script)
+		if err != nil {
+			return "", err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		return stdout, nil
+	}
+
+	report := m.progress
+	if report == nil {
+		report = func(progress types.ProgressInfo) {
+			m.LogInfo("get_url: %s", progress.Message)
+		}
+	}
+	options := types.ExecuteOptions{
+		StreamOutput:     true,
+		ProgressCallback: report,
+		OutputCallback: func(line string, isStderr bool) {
+			value, ok := strings.CutPrefix(line, "progress=")
+			if isStderr || !ok {
+				return
+			}
+			report(getURLProgress(rawURL, value))
+		},
+	}
+	events, err := streamer.ExecuteStream(ctx, script, options)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s failed: %w", rawURL, err)
+	}
+
+	var result *types.Result
+	for event := range events {
+		switch event.Type {
+		case types.StreamDone:
+			result = event.Result
+		case types.StreamError:
+			return "", fmt.Errorf("downloading %s failed: %w", rawURL, event.Error)
+		}
+	}
+	if result == nil {
+		return "", fmt.Errorf("downloading %s failed: no result from the host", rawURL)
+	}
+	if !result.Success {
+		return "", fmt.Errorf("downloading %s failed: %s", rawURL, commandStderr(result))
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return stdout, nil
+}
+
+// getURLProgress turns a "done total" progress line into progress info
+func getURLProgress(rawURL, value string) types.ProgressInfo {
+	progress := types.ProgressInfo{Stage: "transferring", Timestamp: time.Now()}
+	done, total, _ := strings.Cut(value, " ")
+	progress.BytesDone, _ = strconv.ParseInt(strings.TrimSpace(done), 10, 64)
+	progress.BytesTotal, _ = strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	progress.Message = fmt.Sprintf("Downloading %s: %d bytes", rawURL, progress.BytesDone)
+	if progress.BytesTotal > 0 {
+		progress.Percentage = float64(progress.BytesDone) / float64(progress.BytesTotal) * 100
+		progress.Message = fmt.Sprintf("Downloading %s: %d of %d bytes (%.0f%%)", rawURL, progress.BytesDone, progress.BytesTotal, progress.Percentage)
+	}
+	return progress
+}
+
+// attributesCommand sets the mode and ownership of path. Without a mode,
+// the mode of the file being replaced or the umask default is used.
+func (m *GetURLModule) attributesCommand(args map[string]interface{}, path, dest string, current *getURLFile) string {
+	quoted := m.cli.shellEscape(path)
+	var cmd string
+	switch mode := m.GetStringArg(args, "mode", ""); {
+	case mode != "":
+		cmd = fmt.Sprintf("chmod %s %s", mode, quoted)
+	case current != nil:
+		cmd = fmt.Sprintf("chmod %s %s", current.mode, quoted)
+	default:
+		cmd = fmt.Sprintf(`chmod "$(printf '%%o' $((0666 & ~$(umask))))" %s`, quoted)
+	}
+	owner, group := m.GetStringArg(args, "owner", ""), m.GetStringArg(args, "group", "")
+	switch {
+	case owner != "" && group != "":
+		cmd += fmt.Sprintf(" && chown %s:%s %s", m.cli.shellEscape(owner), m.cli.shellEscape(group), quoted)
+	case owner != "":
+		cmd += fmt.Sprintf(" && chown %s %s", m.cli.shellEscape(owner), quoted)
+	case group != "":
+		cmd += fmt.Sprintf(" && chgrp %s %s", m.cli.shellEscape(group), quoted)
+	}
+	return cmd
+}
+
+// attributesChanged reports whether the file's mode or ownership differs
+// from the requested ones
+func (m *GetURLModule) attributesChanged(args map[string]interface{}, file *getURLFile) bool {
+	if mode := m.GetStringArg(args, "mode", ""); mode != "" && strings.TrimLeft(mode, "0") != strings.TrimLeft(file.mode, "0") {
+		return true
+	}
+	if owner := m.GetStringArg(args, "owner", ""); owner != "" && owner != file.owner {
+		return true
+	}
+	if group := m.GetStringArg(args, "group", ""); group != "" && group != file.group {
+		return true
+	}
+	return false
+}
+
+// Run executes the get_url module
+func (m *GetURLModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	rawURL := m.GetStringArg(args, "url", "")
+	dest := m.GetStringArg(args, "dest", "")
+	etagFile := m.GetStringArg(args, "etag_file", "")
+	force := m.GetBoolArg(args, "force", false)
+
+	algorithm, expected := "sha256", ""
+	if checksum := m.GetStringArg(args, "checksum", ""); checksum != "" {
+		algorithm, expected, _ = parseGetURLChecksum(checksum)
+	}
+	tool := getURLChecksums[algorithm]
+
+	if _, isDir, err := m.cli.inspect(ctx, conn, dest, "[ -d "+m.cli.shellEscape(dest)+" ]", ""); err != nil {
+		return nil, err
+	} else if isDir {
+		parsed, _ := url.Parse(rawURL)
+		name := path.Base(parsed.Path)
+		if name == "/" || name == "." {
+			return nil, fmt.Errorf("%s is a directory and %s names no file", dest, rawURL)
+		}
+		dest = path.Join(dest, name)
+	}
+
+	current, err := m.inspect(ctx, conn, dest, tool, etagFile)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{"dest": dest, "url": rawURL}
+	after := current
+	var changes []string
+
+	// A file matching the checksum is never downloaded again, and without a
+	// checksum an existing file only is when forced
+	download := current == nil || expected != "" && current.checksum != expected || expected == "" && force
+	if download && checkMode && current != nil && expected == "" && strings.HasPrefix(rawURL, "http") {
+		// Ask the server whether the content changed without downloading it
+		probe := append(m.curlOptions(args), m.conditional(rawURL, dest, current)...)
+		probe = append(probe, "--head", "--output", "/dev/null", "--write-out", "%{response_code}", "--", rawURL)
+		escaped := make([]string, len(probe))
+		for i, option := range probe {
+			escaped[i] = m.cli.shellEscape(option)
+		}
+		result, err := m.cli.run(ctx, conn, "querying "+rawURL, "curl "+strings.Join(escaped, " "))
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		download = strings.TrimSpace(stdout) != "304"
+	}
+
+	if download && checkMode {
+		changes = append(changes, fmt.Sprintf("downloaded %s to %s", rawURL, dest))
+		after = &getURLFile{checksum: expected, mode: m.GetStringArg(args, "mode", ""), owner: m.GetStringArg(args, "owner", ""), group: m.GetStringArg(args, "group", "")}
+	} else if download {
+		_, streaming := conn.(types.StreamingConnection)
+		output, err := m.download(ctx, conn, m.downloadScript(args, rawURL, dest, tool, current, streaming), rawURL)
+		if err != nil {
+			return nil, err
+		}
+		fetched := parseGetURLFile(output)
+		var tmp, status string
+		for _, line := range strings.Split(output, "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "tmp="); ok {
+				tmp = value
+			} else if value, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "status="); ok {
+				status = value
+			}
+		}
+		data["status_code"], _ = strconv.Atoi(status)
+		if fetched.etag != "" {
+			data["etag"] = fetched.etag
+		}
+
+		switch {
+		case status == "304":
+			fetched = nil
+		case expected != "" && fetched.checksum != expected:
+			_, _ = m.cli.run(ctx, conn, "removing the download", "rm -f "+m.cli.shellEscape(tmp))
+			return nil, fmt.Errorf("checksum mismatch for %s: expected %s:%s, got %s", rawURL, algorithm, expected, fetched.checksum)
+		case current != nil && fetched.checksum == current.checksum:
+			// The same content was served again
+		default:
+			install := m.attributesCommand(args, tmp, dest, current) + fmt.Sprintf(" && mv -f %s %s", m.cli.shellEscape(tmp), m.cli.shellEscape(dest))
+			if _, err := m.cli.run(ctx, conn, "installing "+dest, install); err != nil {
+				_, _ = m.cli.run(ctx, conn, "removing the download", "rm -f "+m.cli.shellEscape(tmp))
+				return nil, err
+			}
+			changes = append(changes, fmt.Sprintf("downloaded %s to %s", rawURL, dest))
+			tmp = ""
+		}
+		if tmp != "" {
+			if _, err := m.cli.run(ctx, conn, "removing the download", "rm -f "+m.cli.shellEscape(tmp)); err != nil {
+				return nil, err
+			}
+		}
+		if etagFile != "" && fetched != nil && fetched.etag != "" && (current == nil || current.etag != fetched.etag) {
+			if _, err := m.cli.run(ctx, conn, "storing the ETag", fmt.Sprintf("printf '%%s\\n' %s > %s", m.cli.shellEscape(fetched.etag), m.cli.shellEscape(etagFile))); err != nil {
+				return nil, err
+			}
+		}
+		if len(changes) > 0 {
+			if after, err = m.inspect(ctx, conn, dest, tool, ""); err != nil {
+				return nil, err
+			}
+			if after == nil {
+				return nil, fmt.Errorf("%s is missing after the download", dest)
+			}
+		}
+	}
+
+	if len(changes) == 0 && current != nil && m.attributesChanged(args, current) {
+		changes = append(changes, "set the mode and ownership of "+dest)
+		if checkMode {
+			updated := *current
+			updated.mode = m.GetStringArg(args, "mode", current.mode)
+			updated.owner = m.GetStringArg(args, "owner", current.owner)
+			updated.group = m.GetStringArg(args, "group", current.group)
+			after = &updated
+		} else {
+			if _, err := m.cli.run(ctx, conn, "setting the attributes of "+dest, m.attributesCommand(args, dest, dest, current)); err != nil {
+				return nil, err
+			}
+			if after, err = m.inspect(ctx, conn, dest, tool, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if after != nil {
+		data["checksum_dest"] = after.checksum
+		data["size"] = after.size
+	}
+	change := strings.Join(changes, ", ")
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s is up to date", dest), data)
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current.describe(dest), after.describe(dest), startTime), nil
+}
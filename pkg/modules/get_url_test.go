@@ -0,0 +1,196 @@
+package modules
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// streamingMockConnection replays the output of mocked commands through
+// the output callback the way streaming connections do
+type streamingMockConnection struct {
+	*testhelper.MockConnection
+}
+
+func (c *streamingMockConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	result, err := c.Execute(ctx, command, options)
+	events := make(chan types.StreamEvent, 1)
+	go func() {
+		defer close(events)
+		if result == nil {
+			events <- types.StreamEvent{Type: types.StreamError, Error: err}
+			return
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+			options.OutputCallback(line, false)
+		}
+		events <- types.StreamEvent{Type: types.StreamDone, Result: result}
+	}()
+	return events, nil
+}
+
+func TestGetURLModule(t *testing.T) {
+	module := NewGetURLModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const (
+		source   = "https://downloads.example.com/app-1.4.tar.gz"
+		digest   = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		previous = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "sha256:" + digest, "mode": "0640"}, ExpectValid: true},
+		{Name: "ValidFTP", Args: map[string]interface{}{"url": "ftp://mirror.example.com/pub/app.tar.gz", "dest": "/opt/"}, ExpectValid: true},
+		{Name: "MissingURL", Args: map[string]interface{}{"dest": "/opt/app.tar.gz"}, ExpectValid: false},
+		{Name: "FileURL", Args: map[string]interface{}{"url": "file:///etc/passwd", "dest": "/tmp/passwd"}, ExpectValid: false},
+		{Name: "UnknownAlgorithm", Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "crc32:abcd"}, ExpectValid: false},
+		{Name: "DigestNotHex", Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "sha256:xyz"}, ExpectValid: false},
+		{Name: "SymbolicMode", Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "mode": "u+x"}, ExpectValid: false},
+		{Name: "NegativeRetries", Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "retries": -1}, ExpectValid: false},
+	})
+
+	isDir := `^if \[ -d '/opt/app\.tar\.gz' \]`
+	inspect := `^if \[ -f '/opt/app\.tar\.gz' \]`
+	file := func(checksum, extra string) string {
+		return existsMarker + "checksum=" + checksum + "\nsize=2048\nmode=644\nowner=root\ngroup=root\n" + extra
+	}
+	downloaded := func(status, checksum string) string {
+		return "status=" + status + "\ntmp=/opt/.gosible-get_url.Xa81\nchecksum=" + checksum + "\nsize=4096\netag=\"v14\"\n"
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Download",
+			Args:     map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "sha256:" + digest, "proxy": "http://proxy:3128", "headers": map[string]interface{}{"Authorization": "Bearer t0k3n"}},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^tmp=\$\(mktemp '/opt/\.gosible-get_url\.XXXXXX'\) \|\| exit 1; curl '--location' '--fail' .*'--retry' '3' '--retry-connrefused' '--proxy' 'http://proxy:3128' '--header' 'Authorization: Bearer t0k3n' '--output' "\$tmp" .* -- '`+source+`'; rc=\$\?; .*sha256sum "\$tmp"`,
+					&testhelper.CommandResponse{Stdout: downloaded("200", digest)})
+				h.GetConnection().ExpectCommand(`chmod "$(printf '%o' $((0666 & ~$(umask))))" '/opt/.gosible-get_url.Xa81' && mv -f '/opt/.gosible-get_url.Xa81' '/opt/app.tar.gz'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: file(digest, "")})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Downloaded "+source+" to /opt/app.tar.gz")
+				h.AssertDataValue(result, "checksum_dest", digest)
+				h.AssertDataValue(result, "status_code", 200)
+				h.AssertDiffBefore(result, "")
+			},
+		},
+		{
+			Name: "ChecksumMatches",
+			Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "sha256:" + strings.ToUpper(digest), "force": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: file(digest, "")})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`curl`, 0)
+			},
+		},
+		{
+			Name:        "ChecksumMismatch",
+			Args:        map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "checksum": "sha256:" + digest},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: file(previous, "")})
+				h.GetConnection().ExpectCommandPattern(`^tmp=`, &testhelper.CommandResponse{Stdout: downloaded("200", previous)})
+				h.GetConnection().ExpectCommand(`rm -f '/opt/.gosible-get_url.Xa81'`, &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name: "NotModified",
+			Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "force": true, "etag_file": "/var/lib/app.etag"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`sed -n '1s/\^/etag=/p' '/var/lib/app\.etag'`, &testhelper.CommandResponse{Stdout: file(previous, "etag=\"v14\"\n")})
+				h.GetConnection().ExpectCommandPattern(`'--time-cond' '/opt/app\.tar\.gz' '--header' 'If-None-Match: "v14"'`, &testhelper.CommandResponse{Stdout: "status=304\ntmp=/opt/.gosible-get_url.Xa81\nchecksum=e3b0c442\nsize=0\n"})
+				h.GetConnection().ExpectCommand(`rm -f '/opt/.gosible-get_url.Xa81'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "status_code", 304)
+				h.GetConnection().AssertPatternCalledTimes(`mv -f`, 0)
+			},
+		},
+		{
+			Name: "SameContentServed",
+			Args: map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "force": true, "etag_file": "/var/lib/app.etag"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: file(previous, "")})
+				h.GetConnection().ExpectCommandPattern(`^tmp=`, &testhelper.CommandResponse{Stdout: downloaded("200", previous)})
+				h.GetConnection().ExpectCommand(`rm -f '/opt/.gosible-get_url.Xa81'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`printf '%s\n' '"v14"' > '/var/lib/app.etag'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "IntoDirectory",
+			Args: map[string]interface{}{"url": "ftp://mirror.example.com/pub/app.tar.gz", "dest": "/srv", "mode": "0600"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -d '/srv' \]`, &testhelper.CommandResponse{Stdout: existsMarker})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/srv/app\.tar\.gz' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "checksum=" + previous + "\nmode=644\nowner=root\ngroup=root\n"})
+				h.GetConnection().ExpectCommand(`chmod 0600 '/srv/app.tar.gz'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/srv/app\.tar\.gz' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "checksum=" + previous + "\nmode=600\nowner=root\ngroup=root\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the mode and ownership of /srv/app.tar.gz")
+				h.GetConnection().AssertPatternCalledTimes(`curl`, 0)
+			},
+		},
+		{
+			Name:      "CheckModeAsksServer",
+			Args:      map[string]interface{}{"url": source, "dest": "/opt/app.tar.gz", "force": true},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(isDir, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: file(previous, "")})
+				h.GetConnection().ExpectCommandPattern(`^curl .*'--time-cond' '/opt/app\.tar\.gz' '--head' '--output' '/dev/null'`, &testhelper.CommandResponse{Stdout: "200"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(`^tmp=`, 0)
+			},
+		},
+	})
+}
+
+func TestGetURLModuleProgress(t *testing.T) {
+	module := NewGetURLModule()
+	var progress []types.ProgressInfo
+	module.SetProgressCallback(func(info types.ProgressInfo) { progress = append(progress, info) })
+
+	conn := &streamingMockConnection{MockConnection: testhelper.NewMockConnection(t)}
+	conn.ExpectCommandPattern(`^if \[ -d `, &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`& pid=\$!; while kill -0 \$pid`, &testhelper.CommandResponse{
+		Stdout: "progress=0 \nprogress=1024 4096\nstatus=200\ntmp=/opt/.gosible-get_url.Xa81\nchecksum=ab12\nsize=4096\n",
+	})
+	conn.ExpectCommandPattern(`^chmod .* && mv -f `, &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + "checksum=ab12\nsize=4096\nmode=644\nowner=root\ngroup=root\n"})
+
+	result, err := module.Run(context.Background(), conn, map[string]interface{}{"url": "https://downloads.example.com/app.tar.gz", "dest": "/opt/app.tar.gz"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !result.Changed {
+		t.Error("expected the download to change the host")
+	}
+	if len(progress) != 2 || progress[1].BytesDone != 1024 || progress[1].BytesTotal != 4096 || progress[1].Percentage != 25 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+}
@@ -0,0 +1,518 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// gitCommitPattern matches full and abbreviated commit ids
+var gitCommitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// gitCLI builds git command lines on the target host. Commands talking to
+// the remote get the ssh options and never prompt for credentials.
+type gitCLI struct {
+	remoteCLI
+	executable string
+	ssh        string // GIT_SSH_COMMAND, empty for git's default
+}
+
+// command builds a git command line run in dir, escaping the arguments
+func (c gitCLI) command(dir string, args ...string) string {
+	parts := []string{c.shellEscape(c.executable)}
+	if dir != "" {
+		parts = append(parts, "-C", c.shellEscape(dir))
+	}
+	for _, arg := range args {
+		parts = append(parts, c.shellEscape(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// remote builds a git command line talking to the remote
+func (c gitCLI) remote(dir string, args ...string) string {
+	env := "GIT_TERMINAL_PROMPT=0"
+	if c.ssh != "" {
+		env += " GIT_SSH_COMMAND=" + c.shellEscape(c.ssh)
+	}
+	return env + " " + c.command(dir, args...)
+}
+
+// gitTarget is what a version resolves to on the remote
+type gitTarget struct {
+	kind   string // branch, tag or commit
+	name   string // Branch or tag name
+	commit string // Commit id, possibly abbreviated for a commit version
+}
+
+// resolve looks a version up on the remote: HEAD is the default branch,
+// branches win over tags, and anything else must be a commit id
+func (c gitCLI) resolve(ctx context.Context, conn types.Connection, repo, version string) (*gitTarget, error) {
+	var cmd string
+	if version == "HEAD" {
+		cmd = c.remote("", "ls-remote", "--symref", repo, "HEAD")
+	} else {
+		cmd = c.remote("", "ls-remote", repo, "refs/heads/"+version, "refs/tags/"+version, "refs/tags/"+version+"^{}")
+	}
+	result, err := c.run(ctx, conn, "querying "+repo, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+	var head string
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		if ref, ok := strings.CutPrefix(fields[0], "ref: "); ok {
+			head = strings.TrimPrefix(ref, "refs/heads/")
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+
+	if version == "HEAD" {
+		if head == "" || refs["HEAD"] == "" {
+			return nil, fmt.Errorf("%s has no default branch", repo)
+		}
+		return &gitTarget{kind: "branch", name: head, commit: refs["HEAD"]}, nil
+	}
+	if commit, ok := refs["refs/heads/"+version]; ok {
+		return &gitTarget{kind: "branch", name: version, commit: commit}, nil
+	}
+	if commit, ok := refs["refs/tags/"+version+"^{}"]; ok {
+		return &gitTarget{kind: "tag", name: version, commit: commit}, nil
+	}
+	if commit, ok := refs["refs/tags/"+version]; ok {
+		return &gitTarget{kind: "tag", name: version, commit: commit}, nil
+	}
+	if gitCommitPattern.MatchString(version) {
+		return &gitTarget{kind: "commit", commit: version}, nil
+	}
+	return nil, fmt.Errorf("version %s is not a branch, tag or commit of %s", version, repo)
+}
+
+// at reports whether commit is the target commit
+func (t *gitTarget) at(commit string) bool {
+	return commit != "" && strings.HasPrefix(commit, t.commit)
+}
+
+// gitCheckout is the state of a working copy
+type gitCheckout struct {
+	head       string
+	url        string
+	modified   []string // Tracked files with local modifications
+	submodules []string // git submodule status lines
+}
+
+// describe renders the checkout for diffs
+func (c *gitCheckout) describe() string {
+	if c == nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "HEAD: %s\nremote: %s\n", c.head, c.url)
+	for _, file := range c.modified {
+		fmt.Fprintf(&b, "modified: %s\n", file)
+	}
+	for _, submodule := range c.submodules {
+		fmt.Fprintf(&b, "submodule: %s\n", submodule)
+	}
+	return b.String()
+}
+
+// submodulesOutOfSync reports whether submodules are uninitialized or not
+// at the commit the superproject records
+func (c *gitCheckout) submodulesOutOfSync() bool {
+	for _, line := range c.submodules {
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "U") {
+			return true
+		}
+	}
+	return false
+}
+
+// GitModule clones and updates git repositories on the target host
+type GitModule struct {
+	*BaseModule
+}
+
+// NewGitModule creates a new git module instance
+func NewGitModule() *GitModule {
+	doc := types.ModuleDoc{
+		Name:        "git",
+		Description: "Clone git repositories and keep them checked out at a branch, tag or commit, reporting the commits before and after",
+		Parameters: map[string]types.ParamDoc{
+			"repo": {
+				Description: "URL of the repository",
+				Required:    true,
+				Type:        "string",
+			},
+			"dest": {
+				Description: "Path of the working copy on the target host",
+				Required:    true,
+				Type:        "path",
+			},
+			"version": {
+				Description: "Branch, tag or commit to check out; HEAD is the default branch of the remote",
+				Required:    false,
+				Type:        "string",
+				Default:     "HEAD",
+			},
+			"remote": {
+				Description: "Name of the remote",
+				Required:    false,
+				Type:        "string",
+				Default:     "origin",
+			},
+			"force": {
+				Description: "Discard local modifications of tracked files instead of failing",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"depth": {
+				Description: "Create a shallow clone with this many commits of history; a commit version must then be a full commit id",
+				Required:    false,
+				Type:        "int",
+			},
+			"single_branch": {
+				Description: "Only clone the history of the version",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"recursive": {
+				Description: "Initialize and update submodules recursively",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"track_submodules": {
+				Description: "Update submodules to the latest commit of their tracked branch instead of the commit the repository records",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"clone": {
+				Description: "Clone the repository when dest does not exist",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"update": {
+				Description: "Update an existing working copy",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"accept_hostkey": {
+				Description: "Accept any host key of an ssh remote",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"accept_newhostkey": {
+				Description: "Accept the host key of an ssh remote not seen before, rejecting changed keys",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"key_file": {
+				Description: "Private key on the target host used for ssh remotes",
+				Required:    false,
+				Type:        "path",
+			},
+			"ssh_opts": {
+				Description: "Extra ssh options, e.g. -o ProxyJump=bastion",
+				Required:    false,
+				Type:        "string",
+			},
+			"executable": {
+				Description: "Path of the git executable",
+				Required:    false,
+				Type:        "path",
+				Default:     "git",
+			},
+		},
+		Examples: []string{
+			"- name: Deploy the application source\n  git:\n    repo: https://github.com/example/app.git\n    dest: /srv/app\n    version: v2.3.1",
+			"- name: Follow the main branch with a shallow clone\n  git:\n    repo: git@github.com:example/app.git\n    dest: /srv/app\n    version: main\n    depth: 1\n    accept_newhostkey: true\n    key_file: /home/deploy/.ssh/deploy_key",
+		},
+		Returns: map[string]string{
+			"before":             "Commit checked out before the run, null for a new clone",
+			"after":              "Commit checked out after the run",
+			"remote_url_changed": "Whether the URL of the remote was changed to repo",
+			"version_type":       "What version resolved to: branch, tag or commit",
+		},
+	}
+
+	base := NewBaseModule("git", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &GitModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *GitModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"repo", "dest"}); err != nil {
+		return err
+	}
+	if depth, err := m.GetIntArg(args, "depth", 0); err != nil || depth < 0 {
+		return types.NewValidationError("depth", args["depth"], "must be a positive number of commits")
+	}
+	if m.GetBoolArg(args, "accept_hostkey", false) && m.GetBoolArg(args, "accept_newhostkey", false) {
+		return types.NewValidationError("accept_newhostkey", args["accept_newhostkey"], "accept_hostkey and accept_newhostkey are mutually exclusive")
+	}
+	if version := m.GetStringArg(args, "version", "HEAD"); version == "" || strings.HasPrefix(version, "-") {
+		return types.NewValidationError("version", version, "must be a branch, tag or commit")
+	}
+	return nil
+}
+
+// git builds the git command line builder of the task
+func (m *GitModule) git(args map[string]interface{}) gitCLI {
+	cli := gitCLI{executable: m.GetStringArg(args, "executable", "git")}
+
+	var ssh []string
+	if m.GetBoolArg(args, "accept_hostkey", false) {
+		ssh = append(ssh, "-o StrictHostKeyChecking=no")
+	} else if m.GetBoolArg(args, "accept_newhostkey", false) {
+		ssh = append(ssh, "-o StrictHostKeyChecking=accept-new")
+	}
+	if key := m.GetStringArg(args, "key_file", ""); key != "" {
+		ssh = append(ssh, "-i "+cli.shellEscape(key), "-o IdentitiesOnly=yes")
+	}
+	if opts := m.GetStringArg(args, "ssh_opts", ""); opts != "" {
+		ssh = append(ssh, opts)
+	}
+	if len(ssh) > 0 {
+		cli.ssh = "ssh " + strings.Join(ssh, " ")
+	}
+	return cli
+}
+
+// inspect reads the working copy at dest, or returns nil when it does not
+// exist
+func (m *GitModule) inspect(ctx context.Context, conn types.Connection, cli gitCLI, dest, remote string) (*gitCheckout, error) {
+	show := strings.Join([]string{
+		fmt.Sprintf(`printf 'head=%%s\n' "$(%s)"`, cli.command(dest, "rev-parse", "--verify", "-q", "HEAD")),
+		fmt.Sprintf(`printf 'url=%%s\n' "$(%s)"`, cli.command(dest, "config", "--get", "remote."+remote+".url")),
+		cli.command(dest, "status", "--porcelain", "--untracked-files=no") + ` | sed 's/^/modified=/'`,
+		cli.command(dest, "submodule", "status", "--recursive") + ` 2>/dev/null | sed 's/^/submodule=/'`,
+	}, "; ")
+	output, exists, err := cli.inspect(ctx, conn, "working copy "+dest, "test -e "+cli.shellEscape(strings.TrimSuffix(dest, "/")+"/.git"), show)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	checkout := &gitCheckout{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		switch key {
+		case "head":
+			checkout.head = value
+		case "url":
+			checkout.url = value
+		case "modified":
+			checkout.modified = append(checkout.modified, strings.TrimSpace(value))
+		case "submodule":
+			checkout.submodules = append(checkout.submodules, value)
+		}
+	}
+	return checkout, nil
+}
+
+// checkout fetches the target when needed and checks it out, forcing over
+// local modifications. Branches are checked out as a local branch tracking
+// the remote one, tags and commits detached.
+func (m *GitModule) checkout(ctx context.Context, conn types.Connection, cli gitCLI, dest, remote string, target *gitTarget, depth int, fetch bool) error {
+	var fetchArgs []string
+	if depth > 0 {
+		fetchArgs = append(fetchArgs, "--depth", fmt.Sprint(depth))
+	}
+	var refspec, checkout []string
+	switch target.kind {
+	case "branch":
+		refspec = []string{fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", target.name, remote, target.name)}
+		checkout = []string{"checkout", "--force", "-B", target.name, fmt.Sprintf("refs/remotes/%s/%s", remote, target.name)}
+	case "tag":
+		refspec = []string{fmt.Sprintf("+refs/tags/%s:refs/tags/%s", target.name, target.name)}
+		checkout = []string{"checkout", "--force", "--detach", "refs/tags/" + target.name}
+	default:
+		refspec = []string{target.commit}
+		checkout = []string{"checkout", "--force", "--detach", target.commit}
+		// Abbreviated commits can only be found in the fetched history
+		if len(target.commit) < 40 && depth == 0 {
+			refspec = nil
+			fetchArgs = append(fetchArgs, "--tags")
+		}
+	}
+
+	if fetch {
+		fetchCmd := cli.remote(dest, append(append([]string{"fetch", "--force"}, fetchArgs...), append([]string{remote}, refspec...)...)...)
+		if _, err := cli.run(ctx, conn, "fetching "+target.describe(), fetchCmd); err != nil {
+			return err
+		}
+	}
+	_, err := cli.run(ctx, conn, "checking out "+target.describe(), cli.command(dest, checkout...))
+	return err
+}
+
+// describe names the target for messages
+func (t *gitTarget) describe() string {
+	if t.name != "" {
+		return fmt.Sprintf("%s %s", t.kind, t.name)
+	}
+	return "commit " + t.commit
+}
+
+// Run executes the git module
+func (m *GitModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	repo := m.GetStringArg(args, "repo", "")
+	dest := m.GetStringArg(args, "dest", "")
+	remote := m.GetStringArg(args, "remote", "origin")
+	depth, _ := m.GetIntArg(args, "depth", 0)
+	recursive := m.GetBoolArg(args, "recursive", true)
+	trackSubmodules := m.GetBoolArg(args, "track_submodules", false)
+	cli := m.git(args)
+
+	current, err := m.inspect(ctx, conn, cli, dest, remote)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{"before": nil, "after": nil, "remote_url_changed": false}
+	if current != nil {
+		data["before"] = current.head
+		data["after"] = current.head
+	}
+	if current == nil && !m.GetBoolArg(args, "clone", true) {
+		return m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s does not exist and clone is disabled", dest), data), nil
+	}
+	if current != nil && !m.GetBoolArg(args, "update", true) {
+		return m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s exists and update is disabled", dest), data), nil
+	}
+
+	target, err := cli.resolve(ctx, conn, repo, m.GetStringArg(args, "version", "HEAD"))
+	if err != nil {
+		return nil, err
+	}
+	data["version_type"] = target.kind
+
+	var changes []string
+	after := &gitCheckout{head: target.commit, url: repo}
+	if current == nil {
+		changes = append(changes, fmt.Sprintf("cloned %s into %s at %s", repo, dest, target.describe()))
+	} else {
+		if current.url != repo {
+			changes = append(changes, fmt.Sprintf("set the %s remote to %s", remote, repo))
+			data["remote_url_changed"] = true
+		}
+		if !target.at(current.head) {
+			changes = append(changes, fmt.Sprintf("updated %s from %s to %s", dest, shortCommit(current.head), target.describe()))
+		}
+		if len(current.modified) > 0 {
+			if !m.GetBoolArg(args, "force", false) {
+				return nil, fmt.Errorf("%s has local modifications of %s; set force to discard them", dest, strings.Join(current.modified, ", "))
+			}
+			changes = append(changes, "discarded local modifications")
+		}
+		after.submodules = current.submodules
+		if recursive && current.submodulesOutOfSync() {
+			changes = append(changes, "updated submodules")
+		}
+	}
+	submodulesChanged := len(changes) > 0 && changes[len(changes)-1] == "updated submodules"
+
+	if !checkMode && (len(changes) > 0 || current != nil && recursive && trackSubmodules) {
+		if current == nil {
+			clone := []string{"clone", "--origin", remote}
+			if depth > 0 {
+				clone = append(clone, "--depth", fmt.Sprint(depth))
+			}
+			if m.GetBoolArg(args, "single_branch", false) {
+				clone = append(clone, "--single-branch")
+			}
+			if target.kind != "commit" {
+				clone = append(clone, "--branch", target.name)
+			}
+			if _, err := cli.run(ctx, conn, "cloning "+repo, cli.remote("", append(clone, "--", repo, dest)...)); err != nil {
+				return nil, err
+			}
+			if target.kind == "commit" {
+				if err := m.checkout(ctx, conn, cli, dest, remote, target, depth, depth > 0); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			if data["remote_url_changed"] == true {
+				if _, err := cli.run(ctx, conn, "setting the remote URL", cli.command(dest, "remote", "set-url", remote, repo)); err != nil {
+					return nil, err
+				}
+			}
+			if len(changes) > 0 {
+				if err := m.checkout(ctx, conn, cli, dest, remote, target, depth, true); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if recursive {
+			update := []string{"submodule", "update", "--init", "--recursive", "--force"}
+			if trackSubmodules {
+				update = append(update, "--remote")
+			}
+			if depth > 0 {
+				update = append(update, "--depth", fmt.Sprint(depth))
+			}
+			sync := cli.command(dest, "submodule", "sync", "--recursive") + " && " + cli.remote(dest, update...)
+			if _, err := cli.run(ctx, conn, "updating submodules", sync); err != nil {
+				return nil, err
+			}
+		}
+
+		if after, err = m.inspect(ctx, conn, cli, dest, remote); err != nil {
+			return nil, err
+		}
+		if after == nil {
+			return nil, fmt.Errorf("%s is not a git working copy after cloning", dest)
+		}
+		// Submodules tracking their branches may have moved
+		if current != nil && !submodulesChanged && strings.Join(current.submodules, "\n") != strings.Join(after.submodules, "\n") {
+			changes = append(changes, "updated submodules")
+		}
+	}
+	if after.head != "" {
+		data["after"] = after.head
+	}
+
+	change := strings.Join(changes, ", ")
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s is already at %s", dest, shortCommit(target.commit)), data)
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current.describe(), after.describe(), startTime), nil
+}
+
+// shortCommit abbreviates a commit id for messages
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	if commit == "" {
+		return "no commit"
+	}
+	return commit
+}
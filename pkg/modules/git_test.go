@@ -0,0 +1,155 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestGitModule(t *testing.T) {
+	module := NewGitModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const (
+		repo    = "https://git.example.com/app.git"
+		oldHead = "1111111111111111111111111111111111111111"
+		newHead = "2222222222222222222222222222222222222222"
+		tagHead = "3333333333333333333333333333333333333333"
+	)
+	args := func(extra map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{"repo": repo, "dest": "/srv/app"}
+		for k, v := range extra {
+			result[k] = v
+		}
+		return result
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: args(map[string]interface{}{"version": "main", "depth": 1}), ExpectValid: true},
+		{Name: "MissingRepo", Args: map[string]interface{}{"dest": "/srv/app"}, ExpectValid: false},
+		{Name: "NegativeDepth", Args: args(map[string]interface{}{"depth": -1}), ExpectValid: false},
+		{Name: "OptionVersion", Args: args(map[string]interface{}{"version": "--upload-pack=touch"}), ExpectValid: false},
+		{Name: "BothHostKeyPolicies", Args: args(map[string]interface{}{"accept_hostkey": true, "accept_newhostkey": true}), ExpectValid: false},
+	})
+
+	inspect := `^if test -e '/srv/app/\.git' `
+	checkout := func(head string) string {
+		return existsMarker + "head=" + head + "\nurl=" + repo + "\n"
+	}
+	headRefs := "ref: refs/heads/main\tHEAD\n" + newHead + "\tHEAD\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "CloneTag",
+			Args:     args(map[string]interface{}{"version": "v1.2", "depth": 1, "accept_newhostkey": true}),
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^GIT_TERMINAL_PROMPT=0 GIT_SSH_COMMAND='ssh -o StrictHostKeyChecking=accept-new' 'git' 'ls-remote' '`+repo+`' 'refs/heads/v1.2' 'refs/tags/v1.2' 'refs/tags/v1.2\^\{\}'$`,
+					&testhelper.CommandResponse{Stdout: "4444444444444444444444444444444444444444\trefs/tags/v1.2\n" + tagHead + "\trefs/tags/v1.2^{}\n"})
+				h.GetConnection().ExpectCommandPattern(`'git' 'clone' '--origin' 'origin' '--depth' '1' '--branch' 'v1.2' '--' '`+repo+`' '/srv/app'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`'submodule' 'sync' '--recursive' && .* 'submodule' 'update' '--init' '--recursive' '--force' '--depth' '1'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(tagHead)})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Cloned "+repo+" into /srv/app at tag v1.2")
+				h.AssertDataValue(result, "before", nil)
+				h.AssertDataValue(result, "after", tagHead)
+				h.AssertDataValue(result, "version_type", "tag")
+				h.AssertDiffBefore(result, "")
+			},
+		},
+		{
+			Name: "UpdateBranch",
+			Args: args(nil),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(oldHead)})
+				h.GetConnection().ExpectCommandPattern(`'ls-remote' '--symref' '`+repo+`' 'HEAD'$`, &testhelper.CommandResponse{Stdout: headRefs})
+				h.GetConnection().ExpectCommandPattern(`'git' -C '/srv/app' 'fetch' '--force' 'origin' '\+refs/heads/main:refs/remotes/origin/main'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`'git' -C '/srv/app' 'checkout' '--force' '-B' 'main' 'refs/remotes/origin/main'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`'submodule' 'update'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(newHead)})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated /srv/app from 111111111111 to branch main")
+				h.AssertDataValue(result, "before", oldHead)
+				h.AssertDataValue(result, "after", newHead)
+			},
+		},
+		{
+			Name: "AlreadyCurrent",
+			Args: args(map[string]interface{}{"recursive": false}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(newHead)})
+				h.GetConnection().ExpectCommandPattern(`'ls-remote'`, &testhelper.CommandResponse{Stdout: headRefs})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertMessage(result, "/srv/app is already at 222222222222")
+			},
+		},
+		{
+			Name:        "LocalModifications",
+			Args:        args(map[string]interface{}{"version": newHead[:8]}),
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(oldHead) + "modified=M config.yml\n"})
+				h.GetConnection().ExpectCommandPattern(`'ls-remote'`, &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name: "ForceRemoteAndSubmodules",
+			Args: args(map[string]interface{}{"repo": "git@git.example.com:app.git", "force": true, "key_file": "/home/deploy/.ssh/id_ed25519"}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(newHead) + "modified=M config.yml\nsubmodule=-5555555555555555555555555555555555555555 vendor/lib\n"})
+				h.GetConnection().ExpectCommandPattern(`GIT_SSH_COMMAND='ssh -i '"'"'/home/deploy/.ssh/id_ed25519'"'"' -o IdentitiesOnly=yes' 'git' 'ls-remote'`, &testhelper.CommandResponse{Stdout: headRefs})
+				h.GetConnection().ExpectCommand(`'git' -C '/srv/app' 'remote' 'set-url' 'origin' 'git@git.example.com:app.git'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`'fetch' '--force' 'origin'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`'checkout' '--force' '-B' 'main'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`'submodule' 'update'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: existsMarker + "head=" + newHead + "\nurl=git@git.example.com:app.git\nsubmodule= 5555555555555555555555555555555555555555 vendor/lib\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the origin remote to git@git.example.com:app.git, discarded local modifications, updated submodules")
+				h.AssertDataValue(result, "remote_url_changed", true)
+			},
+		},
+		{
+			Name: "TrackedSubmodulesMoved",
+			Args: args(map[string]interface{}{"track_submodules": true}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(newHead) + "submodule= 5555555555555555555555555555555555555555 vendor/lib\n"})
+				h.GetConnection().ExpectCommandPattern(`'ls-remote'`, &testhelper.CommandResponse{Stdout: headRefs})
+				h.GetConnection().ExpectCommandPattern(`'submodule' 'update' '--init' '--recursive' '--force' '--remote'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(newHead) + "submodule=+6666666666666666666666666666666666666666 vendor/lib\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated submodules")
+				h.GetConnection().AssertPatternCalledTimes(`'checkout'`, 0)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      args(map[string]interface{}{"version": "main"}),
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(inspect, &testhelper.CommandResponse{Stdout: checkout(oldHead)})
+				h.GetConnection().ExpectCommandPattern(`'ls-remote' '`+repo+`' 'refs/heads/main'`, &testhelper.CommandResponse{Stdout: newHead + "\trefs/heads/main\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertDataValue(result, "after", newHead)
+				if !strings.HasPrefix(result.Message, "Would have updated /srv/app") {
+					t.Errorf("unexpected message %q", result.Message)
+				}
+			},
+		},
+	})
+}
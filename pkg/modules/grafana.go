@@ -0,0 +1,761 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/template"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// grafanaAuthParams documents the options shared by the Grafana modules
+var grafanaAuthParams = map[string]types.ParamDoc{
+	"grafana_url": {
+		Description: "Grafana base URL",
+		Required:    true,
+		Type:        "string",
+	},
+	"grafana_api_key": {
+		Description: "API key or service account token",
+		Required:    false,
+		Type:        "string",
+	},
+	"url_username": {
+		Description: "Username for basic authentication when no API key is given",
+		Required:    false,
+		Type:        "string",
+	},
+	"url_password": {
+		Description: "Password for basic authentication",
+		Required:    false,
+		Type:        "string",
+	},
+	"org_id": {
+		Description: "Organization to manage; defaults to the organization of the credentials",
+		Required:    false,
+		Type:        "int",
+	},
+	"validate_certs": {
+		Description: "Verify the server certificate",
+		Required:    false,
+		Type:        "bool",
+		Default:     true,
+	},
+}
+
+// errGrafanaNotFound is returned for 404 responses from the HTTP API
+var errGrafanaNotFound = errors.New("grafana resource not found")
+
+// grafanaClient talks to the Grafana HTTP API. Like the Keycloak modules,
+// requests are made from the control node.
+type grafanaClient struct {
+	baseURL  string
+	apiKey   string
+	username string
+	password string
+	orgID    int
+	client   *http.Client
+}
+
+// newGrafanaClient creates a client from the shared authentication options
+func newGrafanaClient(m *BaseModule, args map[string]interface{}) (*grafanaClient, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if !m.GetBoolArg(args, "validate_certs", true) {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	orgID, err := m.GetIntArg(args, "org_id", 0)
+	if err != nil {
+		return nil, types.NewValidationError("org_id", args["org_id"], "org_id must be an integer")
+	}
+
+	return &grafanaClient{
+		baseURL:  strings.TrimRight(m.GetStringArg(args, "grafana_url", ""), "/"),
+		apiKey:   m.GetStringArg(args, "grafana_api_key", ""),
+		username: m.GetStringArg(args, "url_username", ""),
+		password: m.GetStringArg(args, "url_password", ""),
+		orgID:    orgID,
+		client:   httpClient,
+	}, nil
+}
+
+// do calls an API endpoint and decodes the JSON response into out
+func (c *grafanaClient) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	} else {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.orgID > 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.Itoa(c.orgID))
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("grafana %s %s failed: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errGrafanaNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("grafana %s %s returned %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid grafana response for %s %s: %w", method, endpoint, err)
+		}
+	}
+	return nil
+}
+
+// folderByTitle returns the folder with the given title, or nil
+func (c *grafanaClient) folderByTitle(ctx context.Context, title string) (map[string]interface{}, error) {
+	var folders []map[string]interface{}
+	if err := c.do(ctx, http.MethodGet, "/api/folders?limit=1000", nil, &folders); err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if types.ConvertToString(folder["title"]) == title {
+			return folder, nil
+		}
+	}
+	return nil, nil
+}
+
+func validateGrafanaArgs(m *BaseModule, args map[string]interface{}) error {
+	if m.GetStringArg(args, "grafana_url", "") == "" {
+		return types.NewValidationError("grafana_url", nil, "required parameter")
+	}
+	if m.GetStringArg(args, "grafana_api_key", "") == "" && m.GetStringArg(args, "url_username", "") == "" {
+		return types.NewValidationError("grafana_api_key", nil, "one of grafana_api_key or url_username is required")
+	}
+	return m.ValidateChoices(args, "state", []string{"present", "absent"})
+}
+
+// grafanaMessage builds the result message from a change description
+func grafanaMessage(change string, checkMode bool, unchanged string) string {
+	switch {
+	case change == "":
+		return unchanged
+	case checkMode:
+		return "Would have " + change
+	default:
+		return strings.ToUpper(change[:1]) + change[1:]
+	}
+}
+
+// GrafanaDatasourceModule manages data sources through the Grafana HTTP API
+type GrafanaDatasourceModule struct {
+	*BaseModule
+}
+
+// NewGrafanaDatasourceModule creates a new grafana_datasource module instance
+func NewGrafanaDatasourceModule() *GrafanaDatasourceModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "Name of the data source",
+			Required:    true,
+			Type:        "string",
+		},
+		"ds_type": {
+			Description: "Data source plugin, e.g. prometheus, loki or postgres",
+			Required:    false,
+			Type:        "string",
+		},
+		"ds_url": {
+			Description: "URL of the data source",
+			Required:    false,
+			Type:        "string",
+		},
+		"access": {
+			Description: "Whether Grafana proxies requests or the browser queries directly",
+			Required:    false,
+			Type:        "string",
+			Default:     "proxy",
+			Choices:     []string{"proxy", "direct"},
+		},
+		"uid": {
+			Description: "Fixed uid so dashboards can reference the data source",
+			Required:    false,
+			Type:        "string",
+		},
+		"database": {
+			Description: "Database name for SQL data sources",
+			Required:    false,
+			Type:        "string",
+		},
+		"user": {
+			Description: "Database user",
+			Required:    false,
+			Type:        "string",
+		},
+		"is_default": {
+			Description: "Make this the default data source of the organization",
+			Required:    false,
+			Type:        "bool",
+			Default:     false,
+		},
+		"json_data": {
+			Description: "Plugin settings; only the listed keys are managed",
+			Required:    false,
+			Type:        "dict",
+		},
+		"secure_json_data": {
+			Description: "Secret plugin settings. Grafana never returns these, so they are only sent when the data source is created or otherwise updated.",
+			Required:    false,
+			Type:        "dict",
+		},
+		"state": {
+			Description: "Whether the data source should exist",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range grafanaAuthParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "grafana_datasource",
+		Description: "Create, update and delete Grafana data sources",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Add Prometheus\n  grafana_datasource:\n    grafana_url: https://grafana.example.com\n    grafana_api_key: \"{{ grafana_token }}\"\n    name: Prometheus\n    ds_type: prometheus\n    ds_url: http://prometheus:9090\n    uid: prometheus\n    is_default: true\n    json_data:\n      timeInterval: 30s",
+			"- name: Remove a data source\n  grafana_datasource:\n    grafana_url: https://grafana.example.com\n    url_username: admin\n    url_password: \"{{ grafana_admin_password }}\"\n    name: Old Graphite\n    state: absent",
+		},
+		Returns: map[string]string{
+			"id":             "Grafana id of the data source",
+			"uid":            "uid of the data source",
+			"changed_fields": "Data source fields that were updated",
+		},
+	}
+
+	base := NewBaseModule("grafana_datasource", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &GrafanaDatasourceModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *GrafanaDatasourceModule) Validate(args map[string]interface{}) error {
+	if err := validateGrafanaArgs(m.BaseModule, args); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "name", "") == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if m.GetStringArg(args, "state", "present") == "present" {
+		if m.GetStringArg(args, "ds_type", "") == "" {
+			return types.NewValidationError("ds_type", nil, "required when state is present")
+		}
+	}
+	for _, name := range []string{"json_data", "secure_json_data"} {
+		if value, exists := args[name]; exists {
+			if _, ok := value.(map[string]interface{}); !ok {
+				return types.NewValidationError(name, value, name+" must be a map")
+			}
+		}
+	}
+	return m.ValidateChoices(args, "access", []string{"proxy", "direct"})
+}
+
+// desiredDatasource builds the data source fields set by the task.
+// jsonData is merged over the current settings so unmanaged keys survive.
+func (m *GrafanaDatasourceModule) desiredDatasource(args map[string]interface{}, current map[string]interface{}) map[string]interface{} {
+	desired := map[string]interface{}{
+		"name":      m.GetStringArg(args, "name", ""),
+		"type":      m.GetStringArg(args, "ds_type", ""),
+		"access":    m.GetStringArg(args, "access", "proxy"),
+		"isDefault": m.GetBoolArg(args, "is_default", false),
+	}
+	fields := map[string]string{
+		"ds_url":   "url",
+		"uid":      "uid",
+		"database": "database",
+		"user":     "user",
+	}
+	for arg, field := range fields {
+		if _, ok := args[arg]; ok {
+			desired[field] = m.GetStringArg(args, arg, "")
+		}
+	}
+
+	if jsonData := m.GetMapArg(args, "json_data"); jsonData != nil {
+		merged := make(map[string]interface{})
+		if current != nil {
+			if existing, ok := current["jsonData"].(map[string]interface{}); ok {
+				for k, v := range existing {
+					merged[k] = v
+				}
+			}
+		}
+		for k, v := range jsonData {
+			merged[k] = v
+		}
+		desired["jsonData"] = merged
+	}
+	return desired
+}
+
+// Run executes the grafana_datasource module
+func (m *GrafanaDatasourceModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+
+	client, err := newGrafanaClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var current map[string]interface{}
+	err = client.do(ctx, http.MethodGet, "/api/datasources/name/"+url.PathEscape(name), nil, &current)
+	if err != nil && !errors.Is(err, errGrafanaNotFound) {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"name":  name,
+		"state": state,
+	})
+	if current != nil {
+		result.Data["id"] = current["id"]
+		result.Data["uid"] = current["uid"]
+	}
+
+	managed := []string{"name", "type", "url", "access", "uid", "database", "user", "isDefault", "jsonData"}
+	var change, before, after string
+
+	switch {
+	case state == "absent" && current != nil:
+		change = fmt.Sprintf("deleted data source %s", name)
+		before = formatKeycloakFields(current, managed)
+		if !checkMode {
+			if err := client.do(ctx, http.MethodDelete, "/api/datasources/name/"+url.PathEscape(name), nil, nil); err != nil {
+				return nil, err
+			}
+		}
+
+	case state == "present" && current == nil:
+		desired := m.desiredDatasource(args, nil)
+		change = fmt.Sprintf("created data source %s", name)
+		after = formatKeycloakFields(desired, managed)
+		if secure := m.GetMapArg(args, "secure_json_data"); secure != nil {
+			desired["secureJsonData"] = secure
+		}
+		if !checkMode {
+			var created struct {
+				ID         interface{}            `json:"id"`
+				Datasource map[string]interface{} `json:"datasource"`
+			}
+			if err := client.do(ctx, http.MethodPost, "/api/datasources", desired, &created); err != nil {
+				return nil, err
+			}
+			result.Data["id"] = created.ID
+			if created.Datasource != nil {
+				result.Data["uid"] = created.Datasource["uid"]
+			}
+		}
+
+	case state == "present" && current != nil:
+		merged, fields := keycloakChanges(current, m.desiredDatasource(args, current))
+		if len(fields) > 0 {
+			change = fmt.Sprintf("updated data source %s", name)
+			result.Data["changed_fields"] = fields
+			before = formatKeycloakFields(current, managed)
+			after = formatKeycloakFields(merged, managed)
+			if secure := m.GetMapArg(args, "secure_json_data"); secure != nil {
+				merged["secureJsonData"] = secure
+			}
+			if !checkMode {
+				endpoint := "/api/datasources/" + url.PathEscape(types.ConvertToString(current["id"]))
+				if err := client.do(ctx, http.MethodPut, endpoint, merged, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	result.Changed = change != ""
+	result.Message = grafanaMessage(change, checkMode, "Grafana data source is already in desired state")
+	if checkMode && result.Changed {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// GrafanaDashboardModule manages dashboards through the Grafana HTTP API.
+// Dashboards are identified by uid, so the same JSON can be provisioned
+// repeatedly and to several instances.
+type GrafanaDashboardModule struct {
+	*BaseModule
+}
+
+// NewGrafanaDashboardModule creates a new grafana_dashboard module instance
+func NewGrafanaDashboardModule() *GrafanaDashboardModule {
+	params := map[string]types.ParamDoc{
+		"dashboard": {
+			Description: "Dashboard model as a dict or JSON string",
+			Required:    false,
+			Type:        "raw",
+		},
+		"path": {
+			Description: "Dashboard JSON file on the control node",
+			Required:    false,
+			Type:        "path",
+		},
+		"template": {
+			Description: "Dashboard JSON template on the control node, rendered with the task variables",
+			Required:    false,
+			Type:        "path",
+		},
+		"uid": {
+			Description: "uid of the dashboard; overrides the uid in the JSON and is required for state=absent",
+			Required:    false,
+			Type:        "string",
+		},
+		"folder": {
+			Description: "Title of the folder to place the dashboard in; created when missing",
+			Required:    false,
+			Type:        "string",
+			Default:     "General",
+		},
+		"commit_message": {
+			Description: "Message stored in the dashboard version history",
+			Required:    false,
+			Type:        "string",
+			Default:     "Updated by gosible",
+		},
+		"state": {
+			Description: "Whether the dashboard should exist",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range grafanaAuthParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "grafana_dashboard",
+		Description: "Import, update and delete Grafana dashboards by uid",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Provision the node exporter dashboard\n  grafana_dashboard:\n    grafana_url: https://grafana.example.com\n    grafana_api_key: \"{{ grafana_token }}\"\n    path: dashboards/node-exporter.json\n    folder: Infrastructure",
+			"- name: Render a per-environment dashboard\n  grafana_dashboard:\n    grafana_url: https://grafana.example.com\n    grafana_api_key: \"{{ grafana_token }}\"\n    template: dashboards/service.json.tmpl\n    uid: \"checkout-{{ env }}\"",
+			"- name: Remove a dashboard\n  grafana_dashboard:\n    grafana_url: https://grafana.example.com\n    grafana_api_key: \"{{ grafana_token }}\"\n    uid: legacy-overview\n    state: absent",
+		},
+		Returns: map[string]string{
+			"uid":     "uid of the dashboard",
+			"url":     "Path of the dashboard in the Grafana UI",
+			"version": "Dashboard version after the change",
+			"folder":  "Folder containing the dashboard",
+		},
+	}
+
+	base := NewBaseModule("grafana_dashboard", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &GrafanaDashboardModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *GrafanaDashboardModule) Validate(args map[string]interface{}) error {
+	if err := validateGrafanaArgs(m.BaseModule, args); err != nil {
+		return err
+	}
+
+	sources := 0
+	for _, name := range []string{"dashboard", "path", "template"} {
+		if _, exists := args[name]; exists {
+			sources++
+		}
+	}
+	if m.GetStringArg(args, "state", "present") == "absent" {
+		if m.GetStringArg(args, "uid", "") == "" && sources == 0 {
+			return types.NewValidationError("uid", nil, "uid or a dashboard is required when state is absent")
+		}
+		return nil
+	}
+	if sources != 1 {
+		return types.NewValidationError("dashboard", nil, "exactly one of dashboard, path or template is required")
+	}
+	return nil
+}
+
+// loadDashboard returns the dashboard model from the dashboard, path or
+// template argument, with the uid override applied
+func (m *GrafanaDashboardModule) loadDashboard(args map[string]interface{}) (map[string]interface{}, error) {
+	var raw []byte
+	switch {
+	case args["dashboard"] != nil:
+		if model, ok := args["dashboard"].(map[string]interface{}); ok {
+			data, err := json.Marshal(model)
+			if err != nil {
+				return nil, err
+			}
+			raw = data
+		} else {
+			raw = []byte(m.GetStringArg(args, "dashboard", ""))
+		}
+	case m.GetStringArg(args, "path", "") != "":
+		data, err := os.ReadFile(m.GetStringArg(args, "path", ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dashboard: %w", err)
+		}
+		raw = data
+	case m.GetStringArg(args, "template", "") != "":
+		vars, _ := args["_task_vars"].(map[string]interface{})
+		rendered, err := template.NewEngine().RenderFile(m.GetStringArg(args, "template", ""), vars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render dashboard template: %w", err)
+		}
+		raw = []byte(rendered)
+	default:
+		return nil, nil
+	}
+
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(raw, &dashboard); err != nil {
+		return nil, fmt.Errorf("dashboard is not valid JSON: %w", err)
+	}
+	// Exports from the UI wrap the model in {"dashboard": ..., "meta": ...}
+	if inner, ok := dashboard["dashboard"].(map[string]interface{}); ok {
+		dashboard = inner
+	}
+	if uid := m.GetStringArg(args, "uid", ""); uid != "" {
+		dashboard["uid"] = uid
+	}
+	return dashboard, nil
+}
+
+// comparableDashboard strips the fields Grafana assigns on save
+func comparableDashboard(dashboard map[string]interface{}) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(dashboard))
+	for k, v := range dashboard {
+		if k != "id" && k != "version" {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// formatDashboard renders a dashboard model and folder for diffs
+func formatDashboard(dashboard map[string]interface{}, folder string) string {
+	if dashboard == nil {
+		return ""
+	}
+	data, _ := json.MarshalIndent(comparableDashboard(dashboard), "", "  ")
+	return fmt.Sprintf("folder: %s\n%s\n", folder, data)
+}
+
+// Run executes the grafana_dashboard module
+func (m *GrafanaDashboardModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	state := m.GetStringArg(args, "state", "present")
+	folder := m.GetStringArg(args, "folder", "General")
+
+	dashboard, err := m.loadDashboard(args)
+	if err != nil {
+		return nil, err
+	}
+	uid := m.GetStringArg(args, "uid", "")
+	if dashboard != nil {
+		uid = types.ConvertToString(dashboard["uid"])
+	}
+	if uid == "" {
+		return nil, fmt.Errorf("dashboard has no uid; set uid in the JSON or the uid option")
+	}
+
+	client, err := newGrafanaClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var current struct {
+		Dashboard map[string]interface{} `json:"dashboard"`
+		Meta      struct {
+			FolderTitle string `json:"folderTitle"`
+		} `json:"meta"`
+	}
+	err = client.do(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid), nil, &current)
+	if err != nil && !errors.Is(err, errGrafanaNotFound) {
+		return nil, err
+	}
+	exists := err == nil && current.Dashboard != nil
+	if exists && current.Meta.FolderTitle == "" {
+		current.Meta.FolderTitle = "General"
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"uid":   uid,
+		"state": state,
+	})
+	if exists {
+		result.Data["version"] = current.Dashboard["version"]
+		result.Data["folder"] = current.Meta.FolderTitle
+	}
+
+	var change, before, after string
+	if exists {
+		before = formatDashboard(current.Dashboard, current.Meta.FolderTitle)
+	}
+
+	if state == "absent" {
+		if exists {
+			change = fmt.Sprintf("deleted dashboard %s", uid)
+			if !checkMode {
+				if err := client.do(ctx, http.MethodDelete, "/api/dashboards/uid/"+url.PathEscape(uid), nil, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		after = formatDashboard(dashboard, folder)
+		switch {
+		case !exists:
+			change = fmt.Sprintf("created dashboard %s in folder %s", uid, folder)
+		case before != after:
+			var fields []string
+			if current.Meta.FolderTitle != folder {
+				fields = append(fields, "folder")
+			}
+			if !jsonEqual(comparableDashboard(current.Dashboard), comparableDashboard(dashboard)) {
+				fields = append(fields, "dashboard")
+			}
+			sort.Strings(fields)
+			result.Data["changed_fields"] = fields
+			change = fmt.Sprintf("updated dashboard %s", uid)
+		}
+
+		if change != "" {
+			result.Data["folder"] = folder
+			if !checkMode {
+				if err := m.saveDashboard(ctx, client, dashboard, folder, m.GetStringArg(args, "commit_message", "Updated by gosible"), result); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	result.Changed = change != ""
+	result.Message = grafanaMessage(change, checkMode, "Grafana dashboard is already in desired state")
+	if checkMode && result.Changed {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// saveDashboard imports the dashboard into folder, creating the folder if
+// needed, and records the saved url and version in result
+func (m *GrafanaDashboardModule) saveDashboard(ctx context.Context, client *grafanaClient, dashboard map[string]interface{}, folder, message string, result *types.Result) error {
+	model := comparableDashboard(dashboard)
+	model["id"] = nil
+
+	body := map[string]interface{}{
+		"dashboard": model,
+		"overwrite": true,
+		"message":   message,
+	}
+	if folder != "General" {
+		existing, err := client.folderByTitle(ctx, folder)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			if err := client.do(ctx, http.MethodPost, "/api/folders", map[string]interface{}{"title": folder}, &existing); err != nil {
+				return fmt.Errorf("failed to create folder %s: %w", folder, err)
+			}
+		}
+		body["folderUid"] = existing["uid"]
+		body["folderId"] = existing["id"]
+	}
+
+	var saved map[string]interface{}
+	if err := client.do(ctx, http.MethodPost, "/api/dashboards/db", body, &saved); err != nil {
+		return err
+	}
+	result.Data["url"] = saved["url"]
+	result.Data["version"] = saved["version"]
+	return nil
+}
+
+// jsonEqual compares two values in their JSON form
+func jsonEqual(a, b interface{}) bool {
+	left, _ := json.Marshal(normalizeKeycloakValue(a))
+	right, _ := json.Marshal(normalizeKeycloakValue(b))
+	return bytes.Equal(left, right)
+}
@@ -0,0 +1,298 @@
+package modules
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+// fakeGrafana is an in-memory stand-in for the parts of the HTTP API the
+// grafana modules use
+type fakeGrafana struct {
+	mu          sync.Mutex
+	datasources map[string]map[string]interface{} // keyed by name
+	dashboards  map[string]map[string]interface{} // keyed by uid
+	folders     []map[string]interface{}
+	placement   map[string]string // dashboard uid -> folder title
+	writes      []string
+	nextID      float64
+}
+
+func newFakeGrafana(t *testing.T) (*fakeGrafana, *httptest.Server) {
+	fake := &fakeGrafana{
+		datasources: make(map[string]map[string]interface{}),
+		dashboards:  make(map[string]map[string]interface{}),
+		placement:   make(map[string]string),
+	}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeGrafana) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	route := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(route, "/api/datasources/name/"):
+		name := strings.TrimPrefix(route, "/api/datasources/name/")
+		ds, ok := f.datasources[name]
+		if !ok {
+			http.Error(w, `{"message":"Data source not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.datasources, name)
+		}
+		json.NewEncoder(w).Encode(ds)
+	case route == "/api/datasources" && r.Method == http.MethodPost:
+		f.nextID++
+		body["id"] = f.nextID
+		if body["uid"] == nil {
+			body["uid"] = "generated"
+		}
+		if jsonData, ok := body["jsonData"].(map[string]interface{}); ok {
+			jsonData["httpMethod"] = "POST" // default added by the server
+		}
+		delete(body, "secureJsonData")
+		f.datasources[body["name"].(string)] = body
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": body["id"], "datasource": body})
+	case strings.HasPrefix(route, "/api/datasources/") && r.Method == http.MethodPut:
+		delete(body, "secureJsonData")
+		f.datasources[body["name"].(string)] = body
+		w.Write([]byte(`{}`))
+	case strings.HasPrefix(route, "/api/dashboards/uid/"):
+		uid := strings.TrimPrefix(route, "/api/dashboards/uid/")
+		dashboard, ok := f.dashboards[uid]
+		if !ok {
+			http.Error(w, `{"message":"Dashboard not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.dashboards, uid)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dashboard": dashboard,
+			"meta":      map[string]interface{}{"folderTitle": f.placement[uid]},
+		})
+	case route == "/api/dashboards/db":
+		dashboard := body["dashboard"].(map[string]interface{})
+		uid := dashboard["uid"].(string)
+		version := 1.0
+		if existing, ok := f.dashboards[uid]; ok {
+			version = existing["version"].(float64) + 1
+		}
+		f.nextID++
+		dashboard["id"] = f.nextID
+		dashboard["version"] = version
+		f.dashboards[uid] = dashboard
+		f.placement[uid] = ""
+		for _, folder := range f.folders {
+			if folder["uid"] == body["folderUid"] {
+				f.placement[uid] = folder["title"].(string)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"uid": uid, "url": "/d/" + uid, "version": version})
+	case route == "/api/folders" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(f.folders)
+	case route == "/api/folders" && r.Method == http.MethodPost:
+		f.nextID++
+		folder := map[string]interface{}{"id": f.nextID, "uid": "f" + body["title"].(string), "title": body["title"]}
+		f.folders = append(f.folders, folder)
+		json.NewEncoder(w).Encode(folder)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+route, http.StatusBadRequest)
+	}
+}
+
+func grafanaArgs(url string, extra map[string]interface{}) map[string]interface{} {
+	args := map[string]interface{}{
+		"grafana_url":     url,
+		"grafana_api_key": "tok",
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	return args
+}
+
+func TestGrafanaDatasourceModule(t *testing.T) {
+	module := NewGrafanaDatasourceModule()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "Valid", Args: grafanaArgs("https://grafana", map[string]interface{}{"name": "Prometheus", "ds_type": "prometheus"}), ExpectValid: true},
+			{Name: "AbsentWithoutType", Args: grafanaArgs("https://grafana", map[string]interface{}{"name": "Prometheus", "state": "absent"}), ExpectValid: true},
+			{Name: "MissingType", Args: grafanaArgs("https://grafana", map[string]interface{}{"name": "Prometheus"}), ExpectValid: false},
+			{Name: "MissingCredentials", Args: map[string]interface{}{"grafana_url": "https://grafana", "name": "Prometheus", "ds_type": "prometheus"}, ExpectValid: false},
+			{Name: "InvalidAccess", Args: grafanaArgs("https://grafana", map[string]interface{}{"name": "Prometheus", "ds_type": "prometheus", "access": "browser"}), ExpectValid: false},
+		})
+	})
+
+	t.Run("CreateUpdateDelete", func(t *testing.T) {
+		fake, server := newFakeGrafana(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		args := func() map[string]interface{} {
+			return grafanaArgs(server.URL, map[string]interface{}{
+				"name":             "Prometheus",
+				"ds_type":          "prometheus",
+				"ds_url":           "http://prometheus:9090",
+				"uid":              "prometheus",
+				"json_data":        map[string]interface{}{"timeInterval": "30s"},
+				"secure_json_data": map[string]interface{}{"httpHeaderValue1": "secret"},
+			})
+		}
+
+		result := helper.Execute(args(), true, false)
+		helper.AssertChanged(result)
+		helper.AssertCheckModeSimulated(result)
+		if len(fake.writes) != 0 {
+			t.Fatalf("check mode must not write, got %v", fake.writes)
+		}
+
+		result = helper.Execute(args(), false, false)
+		helper.AssertChanged(result)
+		helper.AssertMessage(result, "Created data source Prometheus")
+		if result.Data["uid"] != "prometheus" {
+			t.Errorf("expected uid to be returned, got %v", result.Data["uid"])
+		}
+
+		// Server-side jsonData defaults must not cause a change
+		result = helper.Execute(args(), false, false)
+		helper.AssertNotChanged(result)
+
+		update := args()
+		update["ds_url"] = "http://prometheus:9091"
+		result = helper.Execute(update, false, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		if fields := result.Data["changed_fields"].([]string); len(fields) != 1 || fields[0] != "url" {
+			t.Errorf("expected only url to change, got %v", fields)
+		}
+		if jsonData := fake.datasources["Prometheus"]["jsonData"].(map[string]interface{}); jsonData["httpMethod"] != "POST" {
+			t.Errorf("update should keep unmanaged jsonData keys, got %v", jsonData)
+		}
+
+		result = helper.Execute(grafanaArgs(server.URL, map[string]interface{}{"name": "Prometheus", "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		if len(fake.datasources) != 0 {
+			t.Errorf("expected data source to be deleted, got %v", fake.datasources)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		_, server := newFakeGrafana(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+		args := grafanaArgs(server.URL, map[string]interface{}{"name": "Prometheus", "ds_type": "prometheus"})
+		args["grafana_api_key"] = "wrong"
+		if err := helper.ExecuteExpectingError(args); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("expected unauthorized error, got %v", err)
+		}
+	})
+}
+
+func TestGrafanaDashboardModule(t *testing.T) {
+	module := NewGrafanaDashboardModule()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "ValidPath", Args: grafanaArgs("https://grafana", map[string]interface{}{"path": "dash.json"}), ExpectValid: true},
+			{Name: "ValidAbsent", Args: grafanaArgs("https://grafana", map[string]interface{}{"uid": "abc", "state": "absent"}), ExpectValid: true},
+			{Name: "NoSource", Args: grafanaArgs("https://grafana", nil), ExpectValid: false},
+			{Name: "TwoSources", Args: grafanaArgs("https://grafana", map[string]interface{}{"path": "a.json", "template": "a.json.tmpl"}), ExpectValid: false},
+			{Name: "AbsentWithoutUID", Args: grafanaArgs("https://grafana", map[string]interface{}{"state": "absent"}), ExpectValid: false},
+		})
+	})
+
+	t.Run("ImportFromFileIntoFolder", func(t *testing.T) {
+		fake, server := newFakeGrafana(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		dir := t.TempDir()
+		file := filepath.Join(dir, "nodes.json")
+		// UI exports wrap the model and carry the instance specific id
+		os.WriteFile(file, []byte(`{"dashboard": {"id": 42, "uid": "nodes", "title": "Nodes", "panels": [{"type": "graph", "targets": [{"legendFormat": "{{instance}}"}]}]}}`), 0644)
+
+		args := func() map[string]interface{} {
+			return grafanaArgs(server.URL, map[string]interface{}{"path": file, "folder": "Infrastructure"})
+		}
+
+		result := helper.Execute(args(), false, false)
+		helper.AssertChanged(result)
+		helper.AssertMessage(result, "Created dashboard nodes in folder Infrastructure")
+		if fake.placement["nodes"] != "Infrastructure" || len(fake.folders) != 1 {
+			t.Fatalf("expected dashboard in new folder, got %v %v", fake.placement, fake.folders)
+		}
+		if result.Data["url"] != "/d/nodes" {
+			t.Errorf("expected url to be returned, got %v", result.Data["url"])
+		}
+
+		result = helper.Execute(args(), false, false)
+		helper.AssertNotChanged(result)
+
+		moved := args()
+		moved["folder"] = "General"
+		result = helper.Execute(moved, true, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		if fields := result.Data["changed_fields"].([]string); len(fields) != 1 || fields[0] != "folder" {
+			t.Errorf("expected only folder to change, got %v", fields)
+		}
+
+		result = helper.Execute(grafanaArgs(server.URL, map[string]interface{}{"uid": "nodes", "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		if len(fake.dashboards) != 0 {
+			t.Errorf("expected dashboard to be deleted, got %v", fake.dashboards)
+		}
+	})
+
+	t.Run("TemplateWithUIDOverride", func(t *testing.T) {
+		fake, server := newFakeGrafana(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		file := filepath.Join(t.TempDir(), "service.json.tmpl")
+		os.WriteFile(file, []byte(`{"uid": "service", "title": "Checkout {{ .env }}"}`), 0644)
+
+		args := grafanaArgs(server.URL, map[string]interface{}{
+			"template":   file,
+			"uid":        "checkout-prod",
+			"_task_vars": map[string]interface{}{"env": "prod"},
+		})
+		result := helper.Execute(args, false, false)
+		helper.AssertChanged(result)
+		if fake.dashboards["checkout-prod"]["title"] != "Checkout prod" {
+			t.Errorf("expected rendered dashboard under overridden uid, got %v", fake.dashboards)
+		}
+	})
+
+	t.Run("MissingUID", func(t *testing.T) {
+		_, server := newFakeGrafana(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+		err := helper.ExecuteExpectingError(grafanaArgs(server.URL, map[string]interface{}{"dashboard": `{"title": "No uid"}`}))
+		if err == nil || !strings.Contains(err.Error(), "no uid") {
+			t.Errorf("expected missing uid error, got %v", err)
+		}
+	})
+}
@@ -25,6 +25,12 @@ func NewGroupModule() *GroupModule {
 
 // Run executes the group module
 func (m *GroupModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *GroupModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	name, _ := args["name"].(string)
 	state, _ := args["state"].(string)
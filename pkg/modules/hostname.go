@@ -0,0 +1,151 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// hostnamePattern matches a hostname of dot separated RFC 1123 labels
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// hostnameFactsCommand prints the running hostname and where the permanent
+// one is kept as key=value lines
+const hostnameFactsCommand = `echo "current=$(hostname)"; ` +
+	`if command -v hostnamectl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then echo "systemd=$(hostnamectl --static)"; fi; ` +
+	`if [ -f /etc/sysconfig/network ]; then echo "sysconfig=$(sed -n 's/^HOSTNAME=//p' /etc/sysconfig/network | tr -d '"')"; fi; ` +
+	`echo "file=$(cat /etc/hostname 2>/dev/null)"`
+
+// keyValueLines parses the key=value lines printed by a detection command
+func keyValueLines(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// HostnameModule sets the hostname of a host, both for the running system
+// and across reboots
+type HostnameModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewHostnameModule creates a new hostname module instance
+func NewHostnameModule() *HostnameModule {
+	doc := types.ModuleDoc{
+		Name:        "hostname",
+		Description: "Set the running and permanent hostname with hostnamectl on systemd hosts, /etc/sysconfig/network on older Red Hat systems or /etc/hostname elsewhere",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "The hostname",
+				Required:    true,
+				Type:        "string",
+			},
+			"use": {
+				Description: "How the permanent hostname is stored, auto detects it",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "systemd", "sysconfig", "file"},
+			},
+		},
+		Examples: []string{
+			"- name: Name the host after its inventory entry\n  hostname:\n    name: \"{{ inventory_hostname }}\"",
+			"- name: Set the hostname on a host without systemd\n  hostname:\n    name: web01.example.com\n    use: file",
+		},
+		Returns: map[string]string{
+			"name":          "The hostname",
+			"strategy":      "How the permanent hostname is stored",
+			"ansible_facts": "The hostname facts, updated to the new name",
+		},
+	}
+
+	base := NewBaseModule("hostname", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &HostnameModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *HostnameModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if name := m.GetStringArg(args, "name", ""); len(name) > 253 || !hostnamePattern.MatchString(name) {
+		return types.NewValidationError("name", name, "invalid hostname")
+	}
+	return m.ValidateChoices(args, "use", []string{"auto", "systemd", "sysconfig", "file"})
+}
+
+// Run executes the hostname module
+func (m *HostnameModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	output, err := m.cli.run(ctx, conn, "reading the hostname", hostnameFactsCommand)
+	if err != nil {
+		return nil, err
+	}
+	facts := keyValueLines(types.ConvertToString(output.Data["stdout"]))
+
+	strategy := m.GetStringArg(args, "use", "auto")
+	if strategy == "auto" {
+		strategy = "file"
+		for _, candidate := range []string{"systemd", "sysconfig"} {
+			if _, ok := facts[candidate]; ok {
+				strategy = candidate
+				break
+			}
+		}
+	}
+
+	current, permanent := facts["current"], facts[strategy]
+	change := ""
+	if current != name || permanent != name {
+		change = fmt.Sprintf("set the hostname to %s (was %s)", name, current)
+	}
+
+	if change != "" && !checkMode {
+		quoted := m.cli.shellEscape(name)
+		var cmd string
+		switch strategy {
+		case "systemd":
+			cmd = "hostnamectl set-hostname " + quoted
+		case "sysconfig":
+			cmd = fmt.Sprintf("sed -i '/^HOSTNAME=/d' /etc/sysconfig/network && echo HOSTNAME=%s >> /etc/sysconfig/network && hostname %s", quoted, quoted)
+		default:
+			cmd = fmt.Sprintf("echo %s > /etc/hostname && hostname %s", quoted, quoted)
+		}
+		if _, err := m.cli.run(ctx, conn, "setting the hostname", cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	short, _, _ := strings.Cut(name, ".")
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Hostname is already %s", name), map[string]interface{}{
+		"name":     name,
+		"strategy": strategy,
+		"ansible_facts": map[string]interface{}{
+			"ansible_hostname": short,
+			"ansible_nodename": name,
+		},
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		fmt.Sprintf("hostname: %s\npermanent: %s\n", current, permanent), fmt.Sprintf("hostname: %s\npermanent: %s\n", name, name), startTime), nil
+}
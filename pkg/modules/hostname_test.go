@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestHostnameModule(t *testing.T) {
+	module := NewHostnameModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "web01.example.com"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "web_01"}, ExpectValid: false},
+		{Name: "TrailingDash", Args: map[string]interface{}{"name": "web-.example.com"}, ExpectValid: false},
+		{Name: "InvalidUse", Args: map[string]interface{}{"name": "web01", "use": "openrc"}, ExpectValid: false},
+	})
+
+	facts := func(h *testhelper.ModuleTestHelper, stdout string) {
+		h.GetConnection().ExpectCommandPattern(`^echo "current=\$\(hostname\)"`, &testhelper.CommandResponse{Stdout: stdout})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Systemd",
+			Args:     map[string]interface{}{"name": "web01.example.com"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "current=localhost\nsystemd=localhost\nfile=localhost\n")
+				h.GetConnection().ExpectCommand("hostnamectl set-hostname 'web01.example.com'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the hostname to web01.example.com (was localhost)")
+				h.AssertDataValue(result, "strategy", "systemd")
+				h.AssertDiffBefore(result, "hostname: localhost\npermanent: localhost\n")
+				if facts := result.Data["ansible_facts"].(map[string]interface{}); facts["ansible_hostname"] != "web01" || facts["ansible_nodename"] != "web01.example.com" {
+					t.Errorf("unexpected facts %v", facts)
+				}
+			},
+		},
+		{
+			Name: "SysconfigPermanentOnly",
+			Args: map[string]interface{}{"name": "web01"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "current=web01\nsysconfig=localhost.localdomain\nfile=\n")
+				h.GetConnection().ExpectCommand("sed -i '/^HOSTNAME=/d' /etc/sysconfig/network && echo HOSTNAME='web01' >> /etc/sysconfig/network && hostname 'web01'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "strategy", "sysconfig")
+			},
+		},
+		{
+			Name: "ForcedFile",
+			Args: map[string]interface{}{"name": "web01", "use": "file"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "current=web01\nsystemd=web01\nfile=old\n")
+				h.GetConnection().ExpectCommand("echo 'web01' > /etc/hostname && hostname 'web01'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "AlreadySet",
+			Args: map[string]interface{}{"name": "web01"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "current=web01\nsystemd=web01\nfile=web01\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "web01"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "current=localhost\nfile=localhost\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Would have set the hostname to web01 (was localhost)")
+			},
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// backupTimeFormat timestamps the backups of replaced files, as Ansible
+// names them
+const backupTimeFormat = "2006-01-02@15:04:05"
+
+// backedUpMarker is printed when a replaced file was backed up
+const backedUpMarker = "__GOSIBLE_BACKED_UP__"
+
+// fileInstall describes how installFile puts a file in place
+type fileInstall struct {
+	mode         int    // Mode of the new file
+	preserveMode bool   // Keep the mode of the replaced file instead
+	validate     string // Command checking the new file, %s standing for its path
+	backup       bool   // Keep a timestamped copy of the replaced file
+}
+
+// validateInstallCommand checks that a validate command names the file it
+// checks
+func validateInstallCommand(validate string) error {
+	if validate != "" && !strings.Contains(validate, "%s") {
+		return types.NewValidationError("validate", validate, "validate must contain %s for the path of the file to check")
+	}
+	return nil
+}
+
+// installFile writes content to dest on the host without ever exposing a
+// partial file: it is uploaded next to dest under a temporary name, checked
+// with the validate command, and renamed over dest, taking over the owner
+// of the file it replaces. It returns the path of the backup, if one was
+// made.
+func (c remoteCLI) installFile(ctx context.Context, conn types.Connection, content io.Reader, dest string, opts fileInstall) (string, error) {
+	tmp := path.Join(path.Dir(dest), fmt.Sprintf(".%s.gosible.%s", path.Base(dest), strconv.FormatInt(time.Now().UnixNano(), 36)))
+	if err := conn.Copy(ctx, content, tmp, opts.mode); err != nil {
+		return "", err
+	}
+	installed := false
+	defer func() {
+		if !installed {
+			_, _ = c.run(ctx, conn, "removing "+tmp, "rm -f "+c.shellEscape(tmp))
+		}
+	}()
+
+	if opts.validate != "" {
+		if _, err := c.run(ctx, conn, "validating "+dest, strings.ReplaceAll(opts.validate, "%s", c.shellEscape(tmp))); err != nil {
+			return "", err
+		}
+	}
+
+	backupFile := ""
+	steps := []string{fmt.Sprintf("chown --reference=%s %s 2>/dev/null || true", c.shellEscape(dest), c.shellEscape(tmp))}
+	if opts.preserveMode {
+		steps = append(steps, fmt.Sprintf("chmod --reference=%s %s", c.shellEscape(dest), c.shellEscape(tmp)))
+	}
+	if opts.backup {
+		backupFile = fmt.Sprintf("%s.%s~", dest, time.Now().Format(backupTimeFormat))
+		steps = append(steps, fmt.Sprintf("cp -p %s %s", c.shellEscape(dest), c.shellEscape(backupFile)), "echo "+backedUpMarker)
+	}
+	script := fmt.Sprintf("if [ -e %s ]; then %s; fi && mv -f %s %s",
+		c.shellEscape(dest), strings.Join(steps, " && "), c.shellEscape(tmp), c.shellEscape(dest))
+
+	result, err := c.run(ctx, conn, "installing "+dest, script)
+	if err != nil {
+		return "", err
+	}
+	installed = true
+	if !strings.Contains(types.ConvertToString(result.Data["stdout"]), backedUpMarker) {
+		backupFile = ""
+	}
+	return backupFile, nil
+}
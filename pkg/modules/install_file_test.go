@@ -0,0 +1,100 @@
+package modules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestInstallFile(t *testing.T) {
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(dest, []byte("port=80\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	leftovers := func() []string {
+		entries, _ := filepath.Glob(filepath.Join(dir, ".app.conf.gosible.*"))
+		return entries
+	}
+	cli := remoteCLI{}
+
+	t.Run("ValidationFails", func(t *testing.T) {
+		_, err := cli.installFile(ctx, conn, strings.NewReader("port=\n"), dest, fileInstall{mode: 0644, validate: "grep -q '^port=[0-9]' %s"})
+		if err == nil {
+			t.Fatal("expected the invalid file to be rejected")
+		}
+		if content, _ := os.ReadFile(dest); string(content) != "port=80\n" {
+			t.Errorf("expected dest to be untouched, got %q", content)
+		}
+		if tmp := leftovers(); len(tmp) != 0 {
+			t.Errorf("expected the temporary file to be removed, found %v", tmp)
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		backup, err := cli.installFile(ctx, conn, strings.NewReader("port=8080\n"), dest, fileInstall{mode: 0644, preserveMode: true, validate: "grep -q '^port=[0-9]' %s", backup: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if content, _ := os.ReadFile(dest); string(content) != "port=8080\n" {
+			t.Errorf("expected the new content, got %q", content)
+		}
+		if info, err := os.Stat(dest); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("expected the replaced file's mode to be preserved, got %v (%v)", info, err)
+		}
+		if !strings.HasPrefix(backup, dest+".") || !strings.HasSuffix(backup, "~") {
+			t.Fatalf("expected a timestamped backup, got %q", backup)
+		}
+		if content, _ := os.ReadFile(backup); string(content) != "port=80\n" {
+			t.Errorf("expected the backup to hold the old content, got %q", content)
+		}
+		if tmp := leftovers(); len(tmp) != 0 {
+			t.Errorf("expected no temporary file to be left, found %v", tmp)
+		}
+	})
+
+	t.Run("NewFile", func(t *testing.T) {
+		created := filepath.Join(dir, "new.conf")
+		backup, err := cli.installFile(ctx, conn, strings.NewReader("x\n"), created, fileInstall{mode: 0640, backup: true})
+		if err != nil || backup != "" {
+			t.Fatalf("expected a new file without backup, got %q (%v)", backup, err)
+		}
+		if info, err := os.Stat(created); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("expected the requested mode, got %v (%v)", info, err)
+		}
+	})
+
+	t.Run("CopyModule", func(t *testing.T) {
+		m := NewCopyModule()
+		args := map[string]interface{}{"content": "port=\n", "dest": dest, "validate": "grep -q '^port=[0-9]' %s"}
+		if err := m.Validate(args); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result, _ := m.Run(ctx, conn, args); result.Success {
+			t.Error("expected copy to fail validation")
+		}
+
+		args["content"] = "port=443\n"
+		args["backup"] = true
+		result, _ := m.Run(ctx, conn, args)
+		if !result.Success || result.Data["backup_file"] == nil {
+			t.Errorf("expected a validated copy with a backup, got %+v", result)
+		}
+
+		if err := m.Validate(map[string]interface{}{"content": "x", "dest": dest, "validate": "nginx -t"}); err == nil {
+			t.Error("expected a validate command not naming the file to be rejected")
+		}
+	})
+}
@@ -18,7 +18,9 @@ type IPTablesModule struct {
 // NewIPTablesModule creates a new iptables module instance
 func NewIPTablesModule() *IPTablesModule {
 	return &IPTablesModule{
-		BaseModule: BaseModule{},
+		BaseModule: BaseModule{
+			name: "iptables",
+		},
 	}
 }
 
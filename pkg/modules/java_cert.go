@@ -0,0 +1,289 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// JavaCertModule imports certificates and key pairs into Java keystores
+type JavaCertModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewJavaCertModule creates a new java_cert module instance
+func NewJavaCertModule() *JavaCertModule {
+	doc := types.ModuleDoc{
+		Name:        "java_cert",
+		Description: "Import or remove a certificate or PKCS12 key pair under an alias in a JKS or PKCS12 keystore, replacing it when its fingerprint changes",
+		Parameters: map[string]types.ParamDoc{
+			"keystore_path": {
+				Description: "Path of the keystore, e.g. $JAVA_HOME/lib/security/cacerts",
+				Required:    true,
+				Type:        "string",
+			},
+			"keystore_pass": {
+				Description: "Keystore password; keep it in a vault",
+				Required:    true,
+				Type:        "string",
+			},
+			"keystore_type": {
+				Description: "Keystore type; keytool detects it when omitted",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"jks", "pkcs12"},
+			},
+			"keystore_create": {
+				Description: "Create the keystore when it does not exist",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"cert_alias": {
+				Description: "Alias of the entry",
+				Required:    true,
+				Type:        "string",
+			},
+			"cert_path": {
+				Description: "Certificate file on the host, PEM or DER",
+				Required:    false,
+				Type:        "string",
+			},
+			"cert_content": {
+				Description: "PEM certificate content",
+				Required:    false,
+				Type:        "string",
+			},
+			"pkcs12_path": {
+				Description: "PKCS12 file on the host whose key pair to import",
+				Required:    false,
+				Type:        "string",
+			},
+			"pkcs12_password": {
+				Description: "Password of the PKCS12 file; keep it in a vault",
+				Required:    false,
+				Type:        "string",
+			},
+			"pkcs12_alias": {
+				Description: "Alias of the key pair in the PKCS12 file; defaults to cert_alias",
+				Required:    false,
+				Type:        "string",
+			},
+			"trust_cacert": {
+				Description: "Trust the certificate as a CA certificate",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"executable": {
+				Description: "keytool to run",
+				Required:    false,
+				Type:        "string",
+				Default:     "keytool",
+			},
+			"state": {
+				Description: "Whether the alias should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Trust the internal CA\n  java_cert:\n    keystore_path: /usr/lib/jvm/java-17/lib/security/cacerts\n    keystore_pass: \"{{ vault_cacerts_password }}\"\n    cert_alias: corp-root-ca\n    cert_content: \"{{ lookup('file', 'corp-root-ca.pem') }}\"\n    trust_cacert: true",
+			"- name: Install the server key pair\n  java_cert:\n    keystore_path: /opt/app/conf/keystore.p12\n    keystore_pass: \"{{ vault_keystore_password }}\"\n    keystore_type: pkcs12\n    cert_alias: server\n    pkcs12_path: /etc/pki/app/server.p12\n    pkcs12_password: \"{{ vault_p12_password }}\"",
+		},
+		Returns: map[string]string{
+			"keystore_path": "Path of the keystore",
+			"cert_alias":    "Alias of the entry",
+			"fingerprint":   "SHA-256 fingerprint of the certificate under the alias",
+		},
+	}
+
+	base := NewBaseModule("java_cert", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &JavaCertModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *JavaCertModule) Validate(args map[string]interface{}) error {
+	for _, name := range []string{"keystore_path", "keystore_pass", "cert_alias"} {
+		if m.GetStringArg(args, name, "") == "" {
+			return types.NewValidationError(name, nil, "required parameter")
+		}
+	}
+	if err := m.ValidateChoices(args, "keystore_type", []string{"jks", "pkcs12"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+
+	sources := 0
+	for _, name := range []string{"cert_path", "cert_content", "pkcs12_path"} {
+		if m.GetStringArg(args, name, "") != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return types.NewValidationError("cert_path", nil, "cert_path, cert_content and pkcs12_path are mutually exclusive")
+	}
+	if sources == 0 && m.GetStringArg(args, "state", "present") == "present" {
+		return types.NewValidationError("cert_path", nil, "one of cert_path, cert_content or pkcs12_path is required")
+	}
+	if content := m.GetStringArg(args, "cert_content", ""); content != "" {
+		if _, err := certificateFingerprint(content); err != nil {
+			return types.NewValidationError("cert_content", nil, err.Error())
+		}
+	}
+	if m.GetStringArg(args, "pkcs12_path", "") != "" && m.GetStringArg(args, "pkcs12_password", "") == "" {
+		return types.NewValidationError("pkcs12_password", nil, "pkcs12_password is required with pkcs12_path")
+	}
+	return nil
+}
+
+// Run executes the java_cert module
+func (m *JavaCertModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	kt := keytool{cli: m.cli, executable: m.GetStringArg(args, "executable", "keytool")}
+	path := m.GetStringArg(args, "keystore_path", "")
+	storeType := m.GetStringArg(args, "keystore_type", "")
+	password := m.GetStringArg(args, "keystore_pass", "")
+	alias := m.GetStringArg(args, "cert_alias", "")
+	state := m.GetStringArg(args, "state", "present")
+
+	current, exists, err := kt.entry(ctx, conn, path, storeType, password, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := ""
+	if state == "present" {
+		if !exists && !m.GetBoolArg(args, "keystore_create", true) {
+			return nil, fmt.Errorf("keystore %s does not exist and keystore_create is false", path)
+		}
+		if desired, err = m.desiredFingerprint(ctx, conn, kt, args); err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{
+		"keystore_path": path,
+		"cert_alias":    alias,
+	}
+	if state == "present" {
+		data["fingerprint"] = desired
+	}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Alias %s is already in desired state", alias), data)
+
+	var cmd, change string
+	switch {
+	case state == "absent" && current != "":
+		change = fmt.Sprintf("removed %s from %s", alias, path)
+		cmd = m.deleteCommand(kt, path, storeType, password, alias)
+	case state == "present" && current == "":
+		change = fmt.Sprintf("imported %s into %s", alias, path)
+		cmd = m.importCommand(kt, args)
+	case state == "present" && current != desired:
+		change = fmt.Sprintf("replaced %s in %s", alias, path)
+		cmd = m.deleteCommand(kt, path, storeType, password, alias) + " && " + m.importCommand(kt, args)
+	}
+
+	if change != "" && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "updating "+path, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, renderAlias(alias, current), renderAlias(alias, desired), startTime), nil
+}
+
+// desiredFingerprint returns the fingerprint of the certificate to import
+func (m *JavaCertModule) desiredFingerprint(ctx context.Context, conn types.Connection, kt keytool, args map[string]interface{}) (string, error) {
+	if content := m.GetStringArg(args, "cert_content", ""); content != "" {
+		return certificateFingerprint(content)
+	}
+
+	var cmd, source string
+	if p12 := m.GetStringArg(args, "pkcs12_path", ""); p12 != "" {
+		source = p12
+		cmd = fmt.Sprintf("%s%s -list -v -alias %s -keystore %s -storetype pkcs12 -storepass:env %s 2>&1",
+			kt.env(map[string]string{keytoolStorePassVar: m.GetStringArg(args, "pkcs12_password", "")}), kt.executable,
+			m.cli.shellEscape(m.pkcs12Alias(args)), m.cli.shellEscape(p12), keytoolStorePassVar)
+	} else {
+		source = m.GetStringArg(args, "cert_path", "")
+		cmd = fmt.Sprintf("%s -printcert -file %s 2>&1", kt.executable, m.cli.shellEscape(source))
+	}
+
+	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	if err != nil {
+		return "", fmt.Errorf("reading %s failed: %w", source, err)
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	fingerprint := keytoolFingerprint(stdout)
+	if !result.Success || fingerprint == "" {
+		return "", fmt.Errorf("reading %s failed: %s", source, keytoolError(stdout+commandStderr(result)))
+	}
+	return fingerprint, nil
+}
+
+func (m *JavaCertModule) pkcs12Alias(args map[string]interface{}) string {
+	return m.GetStringArg(args, "pkcs12_alias", m.GetStringArg(args, "cert_alias", ""))
+}
+
+func (m *JavaCertModule) deleteCommand(kt keytool, path, storeType, password, alias string) string {
+	return fmt.Sprintf("%s%s -delete -alias %s %s",
+		kt.env(map[string]string{keytoolStorePassVar: password}), kt.executable, m.cli.shellEscape(alias), kt.storeArgs(path, storeType))
+}
+
+// importCommand builds the import of a certificate or PKCS12 key pair.
+// Certificate content goes through a private temporary file.
+func (m *JavaCertModule) importCommand(kt keytool, args map[string]interface{}) string {
+	path := m.GetStringArg(args, "keystore_path", "")
+	storeType := m.GetStringArg(args, "keystore_type", "")
+	alias := m.cli.shellEscape(m.GetStringArg(args, "cert_alias", ""))
+	passwords := map[string]string{keytoolStorePassVar: m.GetStringArg(args, "keystore_pass", "")}
+
+	if p12 := m.GetStringArg(args, "pkcs12_path", ""); p12 != "" {
+		passwords[keytoolSrcPassVar] = m.GetStringArg(args, "pkcs12_password", "")
+		cmd := fmt.Sprintf("%s%s -importkeystore -noprompt -srckeystore %s -srcstoretype pkcs12 -srcstorepass:env %s -srcalias %s -destalias %s -destkeystore %s",
+			kt.env(passwords), kt.executable, m.cli.shellEscape(p12), keytoolSrcPassVar, m.cli.shellEscape(m.pkcs12Alias(args)), alias, m.cli.shellEscape(path))
+		if storeType != "" {
+			cmd += " -deststoretype " + storeType
+		}
+		return cmd + " -deststorepass:env " + keytoolStorePassVar + " -destkeypass:env " + keytoolStorePassVar
+	}
+
+	trust := ""
+	if m.GetBoolArg(args, "trust_cacert", false) {
+		trust = " -trustcacerts"
+	}
+	importCert := func(file string) string {
+		return fmt.Sprintf("%s%s -importcert -noprompt%s -alias %s -file %s %s",
+			kt.env(passwords), kt.executable, trust, alias, file, kt.storeArgs(path, storeType))
+	}
+	if content := m.GetStringArg(args, "cert_content", ""); content != "" {
+		return fmt.Sprintf(`(umask 077 && tmp=$(mktemp) && printf '%%s' %s > "$tmp" && %s; rc=$?; rm -f "$tmp"; exit $rc)`,
+			m.cli.shellEscape(content), importCert(`"$tmp"`))
+	}
+	return importCert(m.cli.shellEscape(m.GetStringArg(args, "cert_path", "")))
+}
+
+// renderAlias renders a keystore entry for diffs
+func renderAlias(alias, fingerprint string) string {
+	if fingerprint == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s: SHA256 %s\n", alias, fingerprint)
+}
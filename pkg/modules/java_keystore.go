@@ -0,0 +1,341 @@
+package modules
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// JavaKeystoreModule builds a Java keystore holding one private key entry
+// from a PEM certificate and key
+type JavaKeystoreModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewJavaKeystoreModule creates a new java_keystore module instance
+func NewJavaKeystoreModule() *JavaKeystoreModule {
+	doc := types.ModuleDoc{
+		Name:        "java_keystore",
+		Description: "Create a JKS or PKCS12 keystore holding a private key and its certificate chain under an alias, rebuilding it when the certificate, alias, type or password changes",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Alias of the key entry",
+				Required:    true,
+				Type:        "string",
+			},
+			"dest": {
+				Description: "Path of the keystore",
+				Required:    true,
+				Type:        "string",
+			},
+			"password": {
+				Description: "Keystore and key password; keep it in a vault",
+				Required:    false,
+				Type:        "string",
+			},
+			"certificate": {
+				Description: "PEM certificate, followed by any intermediates of its chain",
+				Required:    false,
+				Type:        "string",
+			},
+			"private_key": {
+				Description: "PEM private key",
+				Required:    false,
+				Type:        "string",
+			},
+			"private_key_passphrase": {
+				Description: "Passphrase of an encrypted private key",
+				Required:    false,
+				Type:        "string",
+			},
+			"keystore_type": {
+				Description: "Keystore type",
+				Required:    false,
+				Type:        "string",
+				Default:     "pkcs12",
+				Choices:     []string{"pkcs12", "jks"},
+			},
+			"mode": {
+				Description: "Permissions of the keystore",
+				Required:    false,
+				Type:        "string",
+				Default:     "0600",
+			},
+			"owner": {
+				Description: "Owner of the keystore",
+				Required:    false,
+				Type:        "string",
+			},
+			"group": {
+				Description: "Group of the keystore",
+				Required:    false,
+				Type:        "string",
+			},
+			"force": {
+				Description: "Rebuild the keystore even when it is up to date",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"executable": {
+				Description: "keytool to run",
+				Required:    false,
+				Type:        "string",
+				Default:     "keytool",
+			},
+			"state": {
+				Description: "Whether the keystore should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Build the Tomcat keystore\n  java_keystore:\n    name: tomcat\n    dest: /opt/tomcat/conf/keystore.p12\n    password: \"{{ vault_keystore_password }}\"\n    certificate: \"{{ lookup('file', 'tomcat.crt') }}\"\n    private_key: \"{{ vault_tomcat_key }}\"\n    owner: tomcat\n    mode: \"0640\"",
+		},
+		Returns: map[string]string{
+			"dest":        "Path of the keystore",
+			"name":        "Alias of the key entry",
+			"fingerprint": "SHA-256 fingerprint of the certificate",
+		},
+	}
+
+	base := NewBaseModule("java_keystore", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "posix",
+	})
+
+	return &JavaKeystoreModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *JavaKeystoreModule) Validate(args map[string]interface{}) error {
+	for _, name := range []string{"name", "dest"} {
+		if m.GetStringArg(args, name, "") == "" {
+			return types.NewValidationError(name, nil, "required parameter")
+		}
+	}
+	if err := m.ValidateChoices(args, "keystore_type", []string{"pkcs12", "jks"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if mode := m.GetStringArg(args, "mode", "0600"); !isOctalMode(mode) {
+		return types.NewValidationError("mode", mode, "mode must be octal, e.g. 0600")
+	}
+	if m.GetStringArg(args, "state", "present") == "absent" {
+		return nil
+	}
+
+	for _, name := range []string{"password", "certificate", "private_key"} {
+		if m.GetStringArg(args, name, "") == "" {
+			return types.NewValidationError(name, nil, name+" is required when state is present")
+		}
+	}
+	// keytool rejects store passwords shorter than six characters
+	if len(m.GetStringArg(args, "password", "")) < 6 {
+		return types.NewValidationError("password", nil, "password must be at least 6 characters")
+	}
+	if _, err := certificateFingerprint(m.GetStringArg(args, "certificate", "")); err != nil {
+		return types.NewValidationError("certificate", nil, err.Error())
+	}
+	if block, _ := pem.Decode([]byte(m.GetStringArg(args, "private_key", ""))); block == nil || !strings.Contains(block.Type, "PRIVATE KEY") {
+		return types.NewValidationError("private_key", nil, "no PEM private key found")
+	}
+	return nil
+}
+
+// isOctalMode reports whether s is a permission mode such as 0640
+func isOctalMode(s string) bool {
+	n, err := strconv.ParseUint(s, 8, 32)
+	return err == nil && n <= 07777
+}
+
+// Run executes the java_keystore module
+func (m *JavaKeystoreModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	kt := keytool{cli: m.cli, executable: m.GetStringArg(args, "executable", "keytool")}
+	dest := m.GetStringArg(args, "dest", "")
+	alias := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	storeType := m.GetStringArg(args, "keystore_type", "pkcs12")
+
+	current, err := m.inspect(ctx, conn, kt, dest, alias, m.GetStringArg(args, "password", ""), state == "present")
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"dest": dest, "name": alias}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Keystore %s is already in desired state", dest), data)
+
+	var cmd, change string
+	if state == "absent" {
+		if current.exists {
+			change = "removed " + dest
+			cmd = "rm -f " + m.cli.shellEscape(dest)
+		}
+		return m.finish(ctx, conn, result, cmd, change, checkMode, diffMode, current.render(dest, alias), "", startTime)
+	}
+
+	fingerprint, _ := certificateFingerprint(m.GetStringArg(args, "certificate", ""))
+	data["fingerprint"] = fingerprint
+	desired := keystoreState{
+		exists:      true,
+		fingerprint: fingerprint,
+		storeType:   storeType,
+		mode:        strings.TrimLeft(m.GetStringArg(args, "mode", "0600"), "0"),
+		owner:       m.GetStringArg(args, "owner", current.owner),
+		group:       m.GetStringArg(args, "group", current.group),
+	}
+	if desired.mode == "" {
+		desired.mode = "0"
+	}
+
+	switch {
+	case !current.exists:
+		change = "created " + dest
+		cmd = m.buildCommand(kt, args)
+	case m.GetBoolArg(args, "force", false) || current.fingerprint != desired.fingerprint || !strings.EqualFold(current.storeType, storeType):
+		change = "rebuilt " + dest
+		cmd = m.buildCommand(kt, args)
+	case current.mode != desired.mode || current.owner != desired.owner || current.group != desired.group:
+		change = "updated attributes of " + dest
+		cmd = m.attributesCommand(args, m.cli.shellEscape(dest))
+	}
+	return m.finish(ctx, conn, result, cmd, change, checkMode, diffMode, current.render(dest, alias), desired.render(dest, alias), startTime)
+}
+
+func (m *JavaKeystoreModule) finish(ctx context.Context, conn types.Connection, result *types.Result, cmd, change string, checkMode, diffMode bool, before, after string, startTime time.Time) (*types.Result, error) {
+	if change != "" && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "writing "+types.ConvertToString(result.Data["dest"]), cmd); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// keystoreState is what inspect reads from an existing keystore. A wrong
+// password or a missing alias leaves the fingerprint empty, which forces a
+// rebuild.
+type keystoreState struct {
+	exists      bool
+	fingerprint string
+	storeType   string
+	mode        string
+	owner       string
+	group       string
+}
+
+func (s keystoreState) render(dest, alias string) string {
+	if !s.exists {
+		return ""
+	}
+	out := fmt.Sprintf("%s (%s, mode %s", dest, s.storeType, s.mode)
+	if s.owner != "" || s.group != "" {
+		out += fmt.Sprintf(", %s:%s", s.owner, s.group)
+	}
+	out += ")\n"
+	if s.fingerprint != "" {
+		out += fmt.Sprintf("%s: SHA256 %s\n", alias, s.fingerprint)
+	}
+	return out
+}
+
+// inspect reads the keystore's attributes and, when listing is set, the
+// certificate under the alias
+func (m *JavaKeystoreModule) inspect(ctx context.Context, conn types.Connection, kt keytool, dest, alias, password string, listing bool) (keystoreState, error) {
+	quoted := m.cli.shellEscape(dest)
+	show := "stat -c '%a %U %G' " + quoted
+	if listing {
+		show += fmt.Sprintf("; %s%s -list -v %s 2>&1 || true",
+			kt.env(map[string]string{keytoolStorePassVar: password}), kt.executable, kt.storeArgs(dest, ""))
+	}
+	output, exists, err := m.cli.inspect(ctx, conn, dest, "[ -f "+quoted+" ]", show)
+	if err != nil || !exists {
+		return keystoreState{}, err
+	}
+
+	state := keystoreState{exists: true}
+	attributes, listed, _ := strings.Cut(output, "\n")
+	if fields := strings.Fields(attributes); len(fields) == 3 {
+		state.mode, state.owner, state.group = fields[0], fields[1], fields[2]
+	}
+
+	// Only the entry's own certificate counts, not the others in its chain
+	// or in other entries
+	entry := ""
+	for _, line := range strings.Split(listed, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Keystore type:"); ok {
+			state.storeType = strings.ToLower(strings.TrimSpace(value))
+		} else if value, ok := strings.CutPrefix(line, "Alias name:"); ok {
+			entry = strings.TrimSpace(value)
+		} else if strings.EqualFold(entry, alias) && state.fingerprint == "" {
+			state.fingerprint = keytoolFingerprint(line)
+		}
+	}
+	return state, nil
+}
+
+// buildCommand converts the PEM material to PKCS12 with openssl in a
+// private temporary directory, converts that to JKS when asked, and moves
+// the result into place
+func (m *JavaKeystoreModule) buildCommand(kt keytool, args map[string]interface{}) string {
+	dest := m.GetStringArg(args, "dest", "")
+	tmp := m.cli.shellEscape(dest + ".gosible.tmp")
+	passwords := map[string]string{keytoolStorePassVar: m.GetStringArg(args, "password", "")}
+
+	passin := ""
+	if passphrase := m.GetStringArg(args, "private_key_passphrase", ""); passphrase != "" {
+		passwords[keytoolKeyPassVar] = passphrase
+		passin = " -passin env:" + keytoolKeyPassVar
+	}
+
+	steps := []string{
+		"umask 077",
+		"dir=$(mktemp -d)",
+		fmt.Sprintf(`printf '%%s' %s > "$dir/cert.pem"`, m.cli.shellEscape(m.GetStringArg(args, "certificate", ""))),
+		fmt.Sprintf(`printf '%%s' %s > "$dir/key.pem"`, m.cli.shellEscape(m.GetStringArg(args, "private_key", ""))),
+		fmt.Sprintf(`%sopenssl pkcs12 -export -name %s -in "$dir/cert.pem" -inkey "$dir/key.pem"%s -out "$dir/keystore.p12" -passout env:%s`,
+			kt.env(passwords), m.cli.shellEscape(m.GetStringArg(args, "name", "")), passin, keytoolStorePassVar),
+		"rm -f " + tmp,
+	}
+	if m.GetStringArg(args, "keystore_type", "pkcs12") == "jks" {
+		steps = append(steps, fmt.Sprintf(`%s%s -importkeystore -noprompt -srckeystore "$dir/keystore.p12" -srcstoretype pkcs12 -srcstorepass:env %s -destkeystore %s -deststoretype jks -deststorepass:env %s -destkeypass:env %s`,
+			kt.env(passwords), kt.executable, keytoolStorePassVar, tmp, keytoolStorePassVar, keytoolStorePassVar))
+	} else {
+		steps = append(steps, fmt.Sprintf(`cp "$dir/keystore.p12" %s`, tmp))
+	}
+	steps = append(steps, m.attributesCommand(args, tmp), fmt.Sprintf("mv -f %s %s", tmp, m.cli.shellEscape(dest)))
+
+	return "(" + strings.Join(steps, " && ") + `; rc=$?; rm -rf "$dir"; exit $rc)`
+}
+
+// attributesCommand sets the mode and ownership of the keystore
+func (m *JavaKeystoreModule) attributesCommand(args map[string]interface{}, path string) string {
+	cmd := fmt.Sprintf("chmod %s %s", m.GetStringArg(args, "mode", "0600"), path)
+	owner, group := m.GetStringArg(args, "owner", ""), m.GetStringArg(args, "group", "")
+	switch {
+	case owner != "" && group != "":
+		cmd += fmt.Sprintf(" && chown %s:%s %s", m.cli.shellEscape(owner), m.cli.shellEscape(group), path)
+	case owner != "":
+		cmd += fmt.Sprintf(" && chown %s %s", m.cli.shellEscape(owner), path)
+	case group != "":
+		cmd += fmt.Sprintf(" && chgrp %s %s", m.cli.shellEscape(group), path)
+	}
+	return cmd
+}
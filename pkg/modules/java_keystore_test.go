@@ -0,0 +1,130 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestJavaKeystoreModule(t *testing.T) {
+	module := NewJavaKeystoreModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	base := func(extra map[string]interface{}) map[string]interface{} {
+		args := map[string]interface{}{
+			"name":        "tomcat",
+			"dest":        "/opt/tomcat/conf/keystore.p12",
+			"password":    "changeit",
+			"certificate": testCertificatePEM,
+			"private_key": testPrivateKeyPEM,
+		}
+		for key, value := range extra {
+			args[key] = value
+		}
+		return args
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: base(nil), ExpectValid: true},
+		{Name: "Absent", Args: map[string]interface{}{"name": "tomcat", "dest": "/opt/keystore.p12", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingKey", Args: base(map[string]interface{}{"private_key": ""}), ExpectValid: false},
+		{Name: "CertificateAsKey", Args: base(map[string]interface{}{"private_key": testCertificatePEM}), ExpectValid: false},
+		{Name: "ShortPassword", Args: base(map[string]interface{}{"password": "abc"}), ExpectValid: false},
+		{Name: "InvalidMode", Args: base(map[string]interface{}{"mode": "rw-------"}), ExpectValid: false},
+		{Name: "InvalidType", Args: base(map[string]interface{}{"keystore_type": "jceks"}), ExpectValid: false},
+	})
+
+	keystore := func(output string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if \[ -f '/opt/tomcat/conf/keystore.p12' \] >/dev/null 2>&1; then printf '%s' '`+existsMarker+`'; stat -c '%a %U %G' '/opt/tomcat/conf/keystore.p12'; GOSIBLE_STOREPASS='changeit' keytool -list -v -keystore '/opt/tomcat/conf/keystore.p12' -storepass:env GOSIBLE_STOREPASS 2>&1 \|\| true; fi$`,
+				&testhelper.CommandResponse{Stdout: output})
+		}
+	}
+	listing := func(attributes, storeType, fingerprint string) string {
+		return existsMarker + attributes + "\nKeystore type: " + storeType + "\nKeystore provider: SUN\n\nYour keystore contains 1 entry\n\n" +
+			"Alias name: tomcat\nEntry type: PrivateKeyEntry\nCertificate chain length: 1\nCertificate[1]:\nCertificate fingerprints:\n\t SHA1: 00:11\n\t SHA256: " + fingerprint + "\n"
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Create",
+			Args: base(map[string]interface{}{"owner": "tomcat", "mode": "0640"}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				keystore("")(h)
+				h.GetConnection().ExpectCommandPattern(`(?s)^\(umask 077 && dir=\$\(mktemp -d\) && printf .* && GOSIBLE_STOREPASS='changeit' openssl pkcs12 -export -name 'tomcat' -in "\$dir/cert.pem" -inkey "\$dir/key.pem" -out "\$dir/keystore.p12" -passout env:GOSIBLE_STOREPASS && rm -f '/opt/tomcat/conf/keystore.p12.gosible.tmp' && cp "\$dir/keystore.p12" '/opt/tomcat/conf/keystore.p12.gosible.tmp' && chmod 0640 '/opt/tomcat/conf/keystore.p12.gosible.tmp' && chown 'tomcat' '/opt/tomcat/conf/keystore.p12.gosible.tmp' && mv -f .*; rc=\$\?; rm -rf "\$dir"; exit \$rc\)$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created /opt/tomcat/conf/keystore.p12")
+				h.AssertDataValue(result, "fingerprint", testCertificateSHA256)
+			},
+		},
+		{
+			Name:  "UpToDate",
+			Args:  base(nil),
+			Setup: keystore(listing("600 root root", "PKCS12", testCertificateSHA256)),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:     "RebuildForNewCertificate",
+			Args:     base(nil),
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				keystore(listing("600 root root", "PKCS12", "AA:BB"))(h)
+				h.GetConnection().ExpectCommandPattern(`openssl pkcs12 -export`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Rebuilt /opt/tomcat/conf/keystore.p12")
+				h.AssertDiffBefore(result, "/opt/tomcat/conf/keystore.p12 (pkcs12, mode 600, root:root)\ntomcat: SHA256 AA:BB\n")
+			},
+		},
+		{
+			Name: "RebuildAsJKS",
+			Args: base(map[string]interface{}{"keystore_type": "jks", "private_key_passphrase": "keypass"}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				keystore(listing("600 root root", "PKCS12", testCertificateSHA256))(h)
+				h.GetConnection().ExpectCommandPattern(`GOSIBLE_KEYPASS='keypass' GOSIBLE_STOREPASS='changeit' openssl pkcs12 -export -name 'tomcat' .* -passin env:GOSIBLE_KEYPASS .* keytool -importkeystore -noprompt -srckeystore "\$dir/keystore.p12" -srcstoretype pkcs12 -srcstorepass:env GOSIBLE_STOREPASS -destkeystore '/opt/tomcat/conf/keystore.p12.gosible.tmp' -deststoretype jks `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "WrongPasswordRebuilds",
+			Args: base(nil),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				keystore(existsMarker + "600 root root\nkeytool error: java.io.IOException: keystore password was incorrect\n")(h)
+				h.GetConnection().ExpectCommandPattern(`openssl pkcs12 -export`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Rebuilt /opt/tomcat/conf/keystore.p12")
+			},
+		},
+		{
+			Name: "FixAttributes",
+			Args: base(map[string]interface{}{"group": "tomcat", "mode": "0640"}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				keystore(listing("600 root root", "PKCS12", testCertificateSHA256))(h)
+				h.GetConnection().ExpectCommandPattern(`^chmod 0640 '/opt/tomcat/conf/keystore.p12' && chgrp 'tomcat' '/opt/tomcat/conf/keystore.p12'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Updated attributes of /opt/tomcat/conf/keystore.p12")
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"name": "tomcat", "dest": "/opt/tomcat/conf/keystore.p12", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if \[ -f '/opt/tomcat/conf/keystore.p12' \] .* stat -c '%a %U %G' '/opt/tomcat/conf/keystore.p12'; fi$`, &testhelper.CommandResponse{Stdout: existsMarker + "600 root root\n"})
+				h.GetConnection().ExpectCommandPattern(`^rm -f '/opt/tomcat/conf/keystore.p12'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+	})
+}
@@ -0,0 +1,596 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	tmplengine "github.com/liliang-cn/gosible/pkg/template"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+var k8sStates = []string{"present", "absent", "patched"}
+
+// k8sRolloutKinds are the workloads waited for with kubectl rollout status
+var k8sRolloutKinds = map[string]bool{"Deployment": true, "StatefulSet": true, "DaemonSet": true}
+
+// k8sServerFields are metadata fields the API server maintains, left out
+// when live objects are compared and shown in diffs
+var k8sServerFields = []string{"managedFields", "resourceVersion", "generation", "creationTimestamp", "uid", "selfLink"}
+
+// kubectlCLI builds kubectl command lines on the target host
+type kubectlCLI struct {
+	remoteCLI
+	options   []string // Global options selecting the cluster and namespace
+	inCluster bool     // Authenticate with the service account of the pod running kubectl
+}
+
+// command builds a kubectl command line. A non-empty manifest is piped to
+// the command, which reads it as its only file.
+func (c kubectlCLI) command(manifest, subcommand string, args ...string) string {
+	parts := []string{"kubectl"}
+	if c.inCluster {
+		parts = append(parts,
+			`--server "https://$KUBERNETES_SERVICE_HOST:$KUBERNETES_SERVICE_PORT"`,
+			`--token "$(cat /var/run/secrets/kubernetes.io/serviceaccount/token)"`,
+			"--certificate-authority /var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	}
+	for _, option := range c.options {
+		parts = append(parts, c.shellEscape(option))
+	}
+	parts = append(parts, subcommand)
+	for _, arg := range args {
+		parts = append(parts, c.shellEscape(arg))
+	}
+	if manifest == "" {
+		return strings.Join(parts, " ")
+	}
+	parts = append(parts, "--filename", "-")
+	return fmt.Sprintf("printf '%%s' %s | %s", c.shellEscape(manifest), strings.Join(parts, " "))
+}
+
+// objects runs a kubectl command printing JSON, a single object or a List
+func (c kubectlCLI) objects(ctx context.Context, conn types.Connection, what, cmd string) ([]k8sObject, error) {
+	result, err := c.run(ctx, conn, what, cmd)
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	if strings.TrimSpace(stdout) == "" {
+		return nil, nil
+	}
+	var object k8sObject
+	if err := json.Unmarshal([]byte(stdout), &object); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return object.items(), nil
+}
+
+// k8sObject is a Kubernetes object as decoded from YAML or JSON
+type k8sObject map[string]interface{}
+
+// items expands a List into its objects
+func (o k8sObject) items() []k8sObject {
+	if _, ok := o["items"]; !ok || !strings.HasSuffix(o.kind(), "List") {
+		return []k8sObject{o}
+	}
+	items, _ := o["items"].([]interface{})
+	objects := make([]k8sObject, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, k8sObject(object))
+		}
+	}
+	return objects
+}
+
+func (o k8sObject) kind() string {
+	return types.ConvertToString(o["kind"])
+}
+
+func (o k8sObject) metadata(key string) string {
+	metadata, _ := o["metadata"].(map[string]interface{})
+	return types.ConvertToString(metadata[key])
+}
+
+// key names the object, e.g. Deployment default/web
+func (o k8sObject) key() string {
+	if namespace := o.metadata("namespace"); namespace != "" {
+		return fmt.Sprintf("%s %s/%s", o.kind(), namespace, o.metadata("name"))
+	}
+	return fmt.Sprintf("%s %s", o.kind(), o.metadata("name"))
+}
+
+// matches reports whether live is the object o describes. o may leave its
+// namespace to the kubeconfig context.
+func (o k8sObject) matches(live k8sObject, namespace string) bool {
+	if o.kind() != live.kind() || o.metadata("name") != live.metadata("name") {
+		return false
+	}
+	if ns := o.metadata("namespace"); ns != "" {
+		namespace = ns
+	}
+	return namespace == "" || namespace == live.metadata("namespace")
+}
+
+// normalize returns a copy of the object without its status and the
+// metadata the API server maintains
+func (o k8sObject) normalize() k8sObject {
+	copied := make(k8sObject, len(o))
+	for key, value := range o {
+		if key != "status" {
+			copied[key] = value
+		}
+	}
+	if metadata, ok := o["metadata"].(map[string]interface{}); ok {
+		trimmed := make(map[string]interface{}, len(metadata))
+		for key, value := range metadata {
+			trimmed[key] = value
+		}
+		for _, field := range k8sServerFields {
+			delete(trimmed, field)
+		}
+		if annotations, ok := trimmed["annotations"].(map[string]interface{}); ok {
+			kept := make(map[string]interface{}, len(annotations))
+			for key, value := range annotations {
+				if key != "kubectl.kubernetes.io/last-applied-configuration" {
+					kept[key] = value
+				}
+			}
+			if len(kept) == 0 {
+				delete(trimmed, "annotations")
+			} else {
+				trimmed["annotations"] = kept
+			}
+		}
+		copied["metadata"] = trimmed
+	}
+	return copied
+}
+
+// describeK8sObjects renders objects for diffs, sorted by key
+func describeK8sObjects(objects map[string]k8sObject) string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		content, err := yaml.Marshal(map[string]interface{}(objects[key].normalize()))
+		if err != nil {
+			content = []byte(err.Error() + "\n")
+		}
+		fmt.Fprintf(&b, "# %s\n%s", key, content)
+	}
+	return b.String()
+}
+
+// parseK8sManifest decodes the YAML documents of a manifest, expanding
+// Lists
+func parseK8sManifest(manifest string) ([]k8sObject, error) {
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	var objects []k8sObject
+	for {
+		var doc map[string]interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		if doc == nil {
+			continue
+		}
+		objects = append(objects, k8sObject(doc).items()...)
+	}
+	for _, object := range objects {
+		if types.ConvertToString(object["apiVersion"]) == "" || object.kind() == "" || object.metadata("name") == "" {
+			return nil, fmt.Errorf("every object of the manifest needs apiVersion, kind and metadata.name")
+		}
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("the manifest holds no objects")
+	}
+	return objects, nil
+}
+
+// renderK8sManifest renders objects as a multi-document YAML manifest
+func renderK8sManifest(objects []k8sObject) (string, error) {
+	if len(objects) == 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(map[string]interface{}(object)); err != nil {
+			return "", fmt.Errorf("failed to render manifest: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to render manifest: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// K8sModule applies, patches and deletes Kubernetes objects with kubectl on
+// the target host
+type K8sModule struct {
+	*BaseModule
+}
+
+// NewK8sModule creates a new k8s module instance
+func NewK8sModule() *K8sModule {
+	doc := types.ModuleDoc{
+		Name:        "k8s",
+		Description: "Apply, patch and delete Kubernetes objects with server-side apply, waiting for rollouts or conditions",
+		Parameters: map[string]types.ParamDoc{
+			"definition": {
+				Description: "Inline manifest: an object, a list of objects or YAML text",
+				Required:    false,
+				Type:        "raw",
+			},
+			"src": {
+				Description: "Manifest file on the controller",
+				Required:    false,
+				Type:        "path",
+			},
+			"template": {
+				Description: "Jinja2 manifest template on the controller, rendered with the host variables",
+				Required:    false,
+				Type:        "path",
+			},
+			"state": {
+				Description: "present applies the objects, patched only applies them to objects that exist, absent deletes them",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     k8sStates,
+			},
+			"namespace": {
+				Description: "Namespace of objects that do not name one; the kubeconfig context's when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+			"kubeconfig": {
+				Description: "Kubeconfig file on the target host; kubectl's default when omitted",
+				Required:    false,
+				Type:        "path",
+			},
+			"context": {
+				Description: "Kubeconfig context to use",
+				Required:    false,
+				Type:        "string",
+			},
+			"in_cluster": {
+				Description: "Authenticate with the service account of the pod kubectl runs in",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"field_manager": {
+				Description: "Field manager owning the applied fields",
+				Required:    false,
+				Type:        "string",
+				Default:     "gosible",
+			},
+			"force_conflicts": {
+				Description: "Take over fields owned by other field managers instead of failing",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"wait": {
+				Description: "Wait for Deployments, StatefulSets and DaemonSets to roll out, for wait_condition, or for deleted objects to be gone",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"wait_condition": {
+				Description: "Condition every object must reach, e.g. Ready, or a kubectl wait --for expression such as jsonpath={.status.phase}=Bound",
+				Required:    false,
+				Type:        "string",
+			},
+			"wait_timeout": {
+				Description: "Seconds to wait",
+				Required:    false,
+				Type:        "int",
+				Default:     120,
+			},
+		},
+		Examples: []string{
+			"- name: Deploy the application\n  k8s:\n    src: manifests/app.yml\n    namespace: shop\n    wait: true",
+			"- name: Create a namespace\n  k8s:\n    definition:\n      apiVersion: v1\n      kind: Namespace\n      metadata:\n        name: shop",
+			"- name: Scale the workers\n  k8s:\n    state: patched\n    definition:\n      apiVersion: apps/v1\n      kind: Deployment\n      metadata:\n        name: worker\n        namespace: shop\n      spec:\n        replicas: 5",
+			"- name: Wait for the volume claim\n  k8s:\n    template: templates/pvc.yml.j2\n    wait: true\n    wait_condition: jsonpath={.status.phase}=Bound",
+		},
+		Returns: map[string]string{
+			"resources":         "Objects of the manifest, e.g. Deployment shop/web",
+			"changed_resources": "Objects that were created, updated or deleted",
+			"skipped_resources": "Objects a patched task left alone as they do not exist",
+		},
+	}
+
+	base := NewBaseModule("k8s", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &K8sModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *K8sModule) Validate(args map[string]interface{}) error {
+	sources := 0
+	for _, source := range []string{"definition", "src", "template"} {
+		if value, ok := args[source]; ok && value != nil && value != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return types.NewValidationError("definition", nil, "exactly one of definition, src and template is required")
+	}
+	if definition, ok := args["definition"]; ok {
+		if _, err := m.definition(definition); err != nil {
+			return types.NewValidationError("definition", definition, err.Error())
+		}
+	}
+	if err := m.ValidateChoices(args, "state", k8sStates); err != nil {
+		return err
+	}
+	if timeout, err := m.GetIntArg(args, "wait_timeout", 120); err != nil || timeout <= 0 {
+		return types.NewValidationError("wait_timeout", args["wait_timeout"], "must be a positive number of seconds")
+	}
+	if m.GetBoolArg(args, "in_cluster", false) && m.GetStringArg(args, "kubeconfig", "") != "" {
+		return types.NewValidationError("in_cluster", args["in_cluster"], "in_cluster and kubeconfig are mutually exclusive")
+	}
+	return nil
+}
+
+// definition turns an inline definition into manifest objects
+func (m *K8sModule) definition(definition interface{}) ([]k8sObject, error) {
+	switch value := definition.(type) {
+	case string:
+		return parseK8sManifest(value)
+	case map[string]interface{}:
+		content, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return parseK8sManifest(string(content))
+	case []interface{}:
+		docs := make([]string, 0, len(value))
+		for _, item := range value {
+			content, err := yaml.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, string(content))
+		}
+		return parseK8sManifest(strings.Join(docs, "---\n"))
+	}
+	return nil, fmt.Errorf("must be an object, a list of objects or YAML text")
+}
+
+// manifest loads the objects of the task from its definition, manifest
+// file or template
+func (m *K8sModule) manifest(args map[string]interface{}) ([]k8sObject, error) {
+	if definition, ok := args["definition"]; ok && definition != nil && definition != "" {
+		return m.definition(definition)
+	}
+	if src := m.GetStringArg(args, "src", ""); src != "" {
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		return parseK8sManifest(string(content))
+	}
+
+	path := m.GetStringArg(args, "template", "")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest template: %w", err)
+	}
+	vars, _ := args["_task_vars"].(map[string]interface{})
+	engine := tmplengine.NewEngine()
+	engine.SetSyntax(tmplengine.SyntaxJinja2)
+	rendered, err := engine.Render(string(content), vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render manifest template %s: %w", path, err)
+	}
+	return parseK8sManifest(rendered)
+}
+
+// kubectl builds the kubectl command line builder of the task
+func (m *K8sModule) kubectl(args map[string]interface{}) kubectlCLI {
+	cli := kubectlCLI{inCluster: m.GetBoolArg(args, "in_cluster", false)}
+	for _, option := range []struct{ arg, flag string }{
+		{"kubeconfig", "--kubeconfig"},
+		{"context", "--context"},
+		{"namespace", "--namespace"},
+	} {
+		if value := m.GetStringArg(args, option.arg, ""); value != "" {
+			cli.options = append(cli.options, option.flag, value)
+		}
+	}
+	return cli
+}
+
+// wait waits for the applied objects, as returned by the API server, to
+// roll out or reach the condition
+func (m *K8sModule) wait(ctx context.Context, conn types.Connection, cli kubectlCLI, manifest string, objects []k8sObject, args map[string]interface{}) error {
+	timeout, _ := m.GetIntArg(args, "wait_timeout", 120)
+	timeoutArg := fmt.Sprintf("--timeout=%ds", timeout)
+
+	if condition := m.GetStringArg(args, "wait_condition", ""); condition != "" {
+		if !strings.Contains(condition, "=") && condition != "delete" {
+			condition = "condition=" + condition
+		}
+		_, err := cli.run(ctx, conn, "waiting for "+condition, cli.command(manifest, "wait", "--for="+condition, timeoutArg))
+		return err
+	}
+
+	for _, object := range objects {
+		if !k8sRolloutKinds[object.kind()] {
+			continue
+		}
+		status := cli.command("", "rollout", "status", strings.ToLower(object.kind())+"/"+object.metadata("name"), timeoutArg)
+		if namespace := object.metadata("namespace"); namespace != "" {
+			status += " --namespace " + cli.shellEscape(namespace)
+		}
+		if _, err := cli.run(ctx, conn, "waiting for the rollout of "+object.key(), status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run executes the k8s module
+func (m *K8sModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+	state := m.GetStringArg(args, "state", "present")
+	wait := m.GetBoolArg(args, "wait", false)
+
+	objects, err := m.manifest(args)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := renderK8sManifest(objects)
+	if err != nil {
+		return nil, err
+	}
+	cli := m.kubectl(args)
+
+	liveObjects, err := cli.objects(ctx, conn, "reading the objects", cli.command(manifest, "get", "--ignore-not-found", "--output", "json"))
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]k8sObject, len(liveObjects))
+	for _, object := range liveObjects {
+		live[object.key()] = object
+	}
+
+	resources := make([]string, 0, len(objects))
+	for _, object := range objects {
+		resources = append(resources, object.key())
+	}
+	changed := []string{}
+	skipped := []string{}
+	desired := make(map[string]k8sObject)
+	var change string
+
+	if state == "absent" {
+		for _, object := range liveObjects {
+			changed = append(changed, object.key())
+		}
+		if len(changed) > 0 {
+			change = "deleted " + strings.Join(changed, ", ")
+			if !checkMode {
+				remove := []string{"--ignore-not-found", fmt.Sprintf("--wait=%t", wait)}
+				if wait {
+					timeout, _ := m.GetIntArg(args, "wait_timeout", 120)
+					remove = append(remove, fmt.Sprintf("--timeout=%ds", timeout))
+				}
+				if _, err := cli.run(ctx, conn, "deleting the objects", cli.command(manifest, "delete", remove...)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		// Patching leaves objects that do not exist alone
+		if state == "patched" {
+			namespace := m.GetStringArg(args, "namespace", "")
+			var existing []k8sObject
+			for _, object := range objects {
+				found := false
+				for _, liveObject := range liveObjects {
+					if object.matches(liveObject, namespace) {
+						found = true
+						break
+					}
+				}
+				if found {
+					existing = append(existing, object)
+				} else {
+					skipped = append(skipped, object.key())
+				}
+			}
+			objects = existing
+			if manifest, err = renderK8sManifest(objects); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(objects) > 0 {
+			apply := []string{"--server-side", "--field-manager", m.GetStringArg(args, "field_manager", "gosible")}
+			if m.GetBoolArg(args, "force_conflicts", false) {
+				apply = append(apply, "--force-conflicts")
+			}
+
+			// A server-side dry run tells what the objects would look like
+			// after the apply, defaults and admission included
+			dryRun, err := cli.objects(ctx, conn, "dry-running the apply", cli.command(manifest, "apply", append(apply, "--dry-run=server", "--output", "json")...))
+			if err != nil {
+				return nil, err
+			}
+			for _, object := range dryRun {
+				key := object.key()
+				desired[key] = object
+				if current, ok := live[key]; !ok || !reflect.DeepEqual(current.normalize(), object.normalize()) {
+					changed = append(changed, key)
+				}
+			}
+
+			if len(changed) > 0 {
+				change = "applied " + strings.Join(changed, ", ")
+				if !checkMode {
+					if _, err := cli.run(ctx, conn, "applying the objects", cli.command(manifest, "apply", apply...)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if wait && !checkMode {
+				if err := m.wait(ctx, conn, cli, manifest, dryRun, args); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	before := make(map[string]k8sObject)
+	for _, key := range changed {
+		if object, ok := live[key]; ok {
+			before[key] = object
+		}
+	}
+	after := make(map[string]k8sObject)
+	for _, key := range changed {
+		if object, ok := desired[key]; ok {
+			after[key] = object
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "Objects are already in desired state", map[string]interface{}{
+		"resources":         resources,
+		"changed_resources": changed,
+		"skipped_resources": skipped,
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, describeK8sObjects(before), describeK8sObjects(after), startTime), nil
+}
@@ -0,0 +1,185 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseK8sManifest(t *testing.T) {
+	objects, err := parseK8sManifest(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: web
+    namespace: shop
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web
+    namespace: shop
+`)
+	if err != nil {
+		t.Fatalf("parseK8sManifest failed: %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.key())
+	}
+	if strings.Join(keys, ", ") != "Namespace shop, ConfigMap shop/web, Service shop/web" {
+		t.Errorf("unexpected objects %v", keys)
+	}
+
+	if _, err := parseK8sManifest("apiVersion: v1\nkind: ConfigMap\n"); err == nil {
+		t.Error("expected an object without a name to be rejected")
+	}
+}
+
+func TestK8sModule(t *testing.T) {
+	module := NewK8sModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	deployment := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+		"spec":       map[string]interface{}{"replicas": 3},
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"definition": deployment, "wait": true}, ExpectValid: true},
+		{Name: "ValidList", Args: map[string]interface{}{"definition": []interface{}{deployment, deployment}}, ExpectValid: true},
+		{Name: "NoManifest", Args: map[string]interface{}{"namespace": "shop"}, ExpectValid: false},
+		{Name: "TwoManifests", Args: map[string]interface{}{"definition": deployment, "src": "app.yml"}, ExpectValid: false},
+		{Name: "UnnamedObject", Args: map[string]interface{}{"definition": "apiVersion: v1\nkind: ConfigMap\n"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"definition": deployment, "state": "replaced"}, ExpectValid: false},
+		{Name: "InClusterWithKubeconfig", Args: map[string]interface{}{"definition": deployment, "in_cluster": true, "kubeconfig": "/etc/kube.conf"}, ExpectValid: false},
+	})
+
+	live := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"shop","uid":"4f1c","resourceVersion":"811","generation":4,"managedFields":[{"manager":"gosible"}]},"spec":{"replicas":3},"status":{"readyReplicas":3}}`
+	applied := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"shop","uid":"4f1c","resourceVersion":"812","generation":5},"spec":{"replicas":3}}`
+	scaled := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"shop","uid":"4f1c","resourceVersion":"812","generation":5},"spec":{"replicas":5}}`
+
+	get := `(?s)^printf '%s' .* get '--ignore-not-found' '--output' 'json' --filename -$`
+	dryRun := `apply '--server-side' '--field-manager' 'gosible' '--dry-run=server' '--output' 'json' --filename -$`
+	apply := `apply '--server-side' '--field-manager' 'gosible' --filename -$`
+
+	templateDir := t.TempDir()
+	template := filepath.Join(templateDir, "web.yml.j2")
+	if err := os.WriteFile(template, []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: shop\nspec:\n  replicas: {{ replicas }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Create",
+			Args:     map[string]interface{}{"definition": deployment, "kubeconfig": "/etc/kubernetes/admin.conf"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' 'apiVersion: apps/v1\n.*  replicas: 3\n' \| kubectl '--kubeconfig' '/etc/kubernetes/admin.conf' get `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(dryRun, &testhelper.CommandResponse{Stdout: applied})
+				h.GetConnection().ExpectCommandPattern(apply, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Applied Deployment shop/web")
+				h.AssertDiffBefore(result, "")
+				if !strings.Contains(result.Diff.After, "# Deployment shop/web\n") || strings.Contains(result.Diff.After, "resourceVersion") {
+					t.Errorf("expected the applied object without server fields, got %q", result.Diff.After)
+				}
+			},
+		},
+		{
+			Name: "Unchanged",
+			Args: map[string]interface{}{"definition": deployment},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(get, &testhelper.CommandResponse{Stdout: live})
+				h.GetConnection().ExpectCommandPattern(dryRun, &testhelper.CommandResponse{Stdout: applied})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(apply, 0)
+			},
+		},
+		{
+			Name:     "Scale",
+			Args:     map[string]interface{}{"template": template, "wait": true, "wait_timeout": 60, "_task_vars": map[string]interface{}{"replicas": 5}},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(get, &testhelper.CommandResponse{Stdout: live})
+				h.GetConnection().ExpectCommandPattern(`(?s)  replicas: 5\n' \| kubectl apply .*'--dry-run=server'`, &testhelper.CommandResponse{Stdout: scaled})
+				h.GetConnection().ExpectCommandPattern(apply, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`kubectl rollout 'status' 'deployment/web' '--timeout=60s' --namespace 'shop'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				if !strings.Contains(result.Diff.Before, "replicas: 3") || !strings.Contains(result.Diff.After, "replicas: 5") {
+					t.Errorf("expected the replica change in the diff, got %+v", result.Diff)
+				}
+			},
+		},
+		{
+			Name: "WaitForCondition",
+			Args: map[string]interface{}{"definition": deployment, "wait": true, "wait_condition": "Available", "in_cluster": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(get, &testhelper.CommandResponse{Stdout: live})
+				h.GetConnection().ExpectCommandPattern(dryRun, &testhelper.CommandResponse{Stdout: applied})
+				h.GetConnection().ExpectCommandPattern(`\| kubectl --server "https://\$KUBERNETES_SERVICE_HOST:\$KUBERNETES_SERVICE_PORT" .* wait '--for=condition=Available' '--timeout=120s' --filename -$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "Delete",
+			Args: map[string]interface{}{"definition": []interface{}{deployment, map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"name": "web"}}}, "namespace": "shop", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`kubectl '--namespace' 'shop' get `, &testhelper.CommandResponse{Stdout: `{"apiVersion":"v1","kind":"List","items":[` + live + `]}`})
+				h.GetConnection().ExpectCommandPattern(`delete '--ignore-not-found' '--wait=false' --filename -$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Deleted Deployment shop/web")
+			},
+		},
+		{
+			Name: "PatchMissing",
+			Args: map[string]interface{}{"definition": deployment, "state": "patched"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(get, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				if skipped, _ := result.Data["skipped_resources"].([]string); len(skipped) != 1 || skipped[0] != "Deployment shop/web" {
+					t.Errorf("expected the missing object to be skipped, got %v", result.Data["skipped_resources"])
+				}
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"definition": deployment, "wait": true},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(get, &testhelper.CommandResponse{Stdout: live})
+				h.GetConnection().ExpectCommandPattern(dryRun, &testhelper.CommandResponse{Stdout: scaled})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(apply, 0)
+				h.GetConnection().AssertPatternCalledTimes(`rollout`, 0)
+			},
+		},
+	})
+}
@@ -0,0 +1,797 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// keycloakAuthParams documents the options shared by the Keycloak modules
+var keycloakAuthParams = map[string]types.ParamDoc{
+	"auth_keycloak_url": {
+		Description: "Keycloak base URL, including /auth on legacy deployments",
+		Required:    true,
+		Type:        "string",
+	},
+	"auth_realm": {
+		Description: "Realm used to obtain the admin token",
+		Required:    false,
+		Type:        "string",
+		Default:     "master",
+	},
+	"auth_client_id": {
+		Description: "OpenID Connect client used to obtain the admin token",
+		Required:    false,
+		Type:        "string",
+		Default:     "admin-cli",
+	},
+	"auth_client_secret": {
+		Description: "Client secret; uses the client credentials grant when no username is given",
+		Required:    false,
+		Type:        "string",
+	},
+	"auth_username": {
+		Description: "Admin username",
+		Required:    false,
+		Type:        "string",
+	},
+	"auth_password": {
+		Description: "Admin password",
+		Required:    false,
+		Type:        "string",
+	},
+	"token": {
+		Description: "Pre-obtained bearer token used instead of logging in",
+		Required:    false,
+		Type:        "string",
+	},
+	"validate_certs": {
+		Description: "Verify the server certificate",
+		Required:    false,
+		Type:        "bool",
+		Default:     true,
+	},
+	"realm": {
+		Description: "Realm that owns the managed users and groups",
+		Required:    false,
+		Type:        "string",
+		Default:     "master",
+	},
+}
+
+// errKeycloakNotFound is returned for 404 responses from the admin API
+var errKeycloakNotFound = errors.New("keycloak resource not found")
+
+// keycloakClient talks to the Keycloak admin REST API. Requests are made
+// from the control node, so the target host only names the task result.
+type keycloakClient struct {
+	baseURL string
+	realm   string
+	token   string
+	client  *http.Client
+}
+
+// newKeycloakClient logs in with the configured credentials
+func newKeycloakClient(ctx context.Context, m *BaseModule, args map[string]interface{}) (*keycloakClient, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if !m.GetBoolArg(args, "validate_certs", true) {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	c := &keycloakClient{
+		baseURL: strings.TrimRight(m.GetStringArg(args, "auth_keycloak_url", ""), "/"),
+		realm:   m.GetStringArg(args, "realm", "master"),
+		token:   m.GetStringArg(args, "token", ""),
+		client:  httpClient,
+	}
+	if c.token != "" {
+		return c, nil
+	}
+
+	form := url.Values{}
+	form.Set("client_id", m.GetStringArg(args, "auth_client_id", "admin-cli"))
+	if secret := m.GetStringArg(args, "auth_client_secret", ""); secret != "" {
+		form.Set("client_secret", secret)
+	}
+	if username := m.GetStringArg(args, "auth_username", ""); username != "" {
+		form.Set("grant_type", "password")
+		form.Set("username", username)
+		form.Set("password", m.GetStringArg(args, "auth_password", ""))
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", c.baseURL, url.PathEscape(m.GetStringArg(args, "auth_realm", "master")))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain keycloak token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("failed to obtain keycloak token: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid keycloak token response: %w", err)
+	}
+	c.token = token.AccessToken
+	return c, nil
+}
+
+// do calls an admin endpoint relative to the managed realm and decodes the
+// JSON response into out. It returns the Location header of the response.
+func (c *keycloakClient) do(ctx context.Context, method, endpoint string, body, out interface{}) (string, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := fmt.Sprintf("%s/admin/realms/%s%s", c.baseURL, url.PathEscape(c.realm), endpoint)
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("keycloak %s %s failed: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errKeycloakNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("keycloak %s %s returned %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return "", fmt.Errorf("invalid keycloak response for %s %s: %w", method, endpoint, err)
+		}
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// groupByPath looks up a group by name or "/parent/child" path
+func (c *keycloakClient) groupByPath(ctx context.Context, groupPath string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(groupPath, "/") {
+		groupPath = "/" + groupPath
+	}
+
+	var group map[string]interface{}
+	_, err := c.do(ctx, http.MethodGet, "/group-by-path"+escapeKeycloakPath(groupPath), nil, &group)
+	if errors.Is(err, errKeycloakNotFound) {
+		return nil, nil
+	}
+	return group, err
+}
+
+func escapeKeycloakPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// keycloakAttributes converts an attributes argument to Keycloak's
+// map of string lists
+func keycloakAttributes(raw map[string]interface{}) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(raw))
+	for name, value := range raw {
+		values := make([]interface{}, 0)
+		for _, v := range ldapValues(value) {
+			values = append(values, v)
+		}
+		attrs[name] = values
+	}
+	return attrs
+}
+
+// keycloakChanges overlays desired on current and lists the fields whose
+// values differ. Both sides are compared in their JSON form.
+func keycloakChanges(current, desired map[string]interface{}) (map[string]interface{}, []string) {
+	merged := make(map[string]interface{}, len(current)+len(desired))
+	for k, v := range current {
+		merged[k] = v
+	}
+
+	var fields []string
+	for k, v := range desired {
+		if !reflect.DeepEqual(normalizeKeycloakValue(current[k]), normalizeKeycloakValue(v)) {
+			fields = append(fields, k)
+		}
+		merged[k] = v
+	}
+	sort.Strings(fields)
+	return merged, fields
+}
+
+func normalizeKeycloakValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var normalized interface{}
+	json.Unmarshal(data, &normalized)
+	return normalized
+}
+
+// formatKeycloakFields renders the managed fields of a representation
+func formatKeycloakFields(rep map[string]interface{}, fields []string) string {
+	if rep == nil {
+		return ""
+	}
+	subset := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if v, ok := rep[field]; ok {
+			subset[field] = v
+		}
+	}
+	data, _ := json.MarshalIndent(subset, "", "  ")
+	return string(data) + "\n"
+}
+
+func validateKeycloakArgs(m *BaseModule, args map[string]interface{}, required string) error {
+	if m.GetStringArg(args, "auth_keycloak_url", "") == "" {
+		return types.NewValidationError("auth_keycloak_url", nil, "required parameter")
+	}
+	if m.GetStringArg(args, required, "") == "" {
+		return types.NewValidationError(required, nil, "required parameter")
+	}
+	if m.GetStringArg(args, "token", "") == "" && m.GetStringArg(args, "auth_username", "") == "" && m.GetStringArg(args, "auth_client_secret", "") == "" {
+		return types.NewValidationError("auth_username", nil, "one of token, auth_username or auth_client_secret is required")
+	}
+	if value, exists := args["attributes"]; exists {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return types.NewValidationError("attributes", value, "attributes must be a map")
+		}
+	}
+	return m.ValidateChoices(args, "state", []string{"present", "absent"})
+}
+
+// KeycloakUserModule manages users through the Keycloak admin REST API
+type KeycloakUserModule struct {
+	*BaseModule
+}
+
+// NewKeycloakUserModule creates a new keycloak_user module instance
+func NewKeycloakUserModule() *KeycloakUserModule {
+	params := map[string]types.ParamDoc{
+		"username": {
+			Description: "Username of the user",
+			Required:    true,
+			Type:        "string",
+		},
+		"email": {
+			Description: "Email address",
+			Required:    false,
+			Type:        "string",
+		},
+		"first_name": {
+			Description: "First name",
+			Required:    false,
+			Type:        "string",
+		},
+		"last_name": {
+			Description: "Last name",
+			Required:    false,
+			Type:        "string",
+		},
+		"enabled": {
+			Description: "Whether the account is enabled",
+			Required:    false,
+			Type:        "bool",
+		},
+		"email_verified": {
+			Description: "Whether the email address is verified",
+			Required:    false,
+			Type:        "bool",
+		},
+		"attributes": {
+			Description: "User attributes mapped to a value or list of values",
+			Required:    false,
+			Type:        "dict",
+		},
+		"groups": {
+			Description: "Groups (names or /parent/child paths) the user must belong to",
+			Required:    false,
+			Type:        "list",
+		},
+		"password": {
+			Description: "Initial password, only set when the user is created",
+			Required:    false,
+			Type:        "string",
+		},
+		"password_temporary": {
+			Description: "Require the user to change the initial password",
+			Required:    false,
+			Type:        "bool",
+			Default:     true,
+		},
+		"state": {
+			Description: "Whether the user should exist",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range keycloakAuthParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "keycloak_user",
+		Description: "Create, update and delete Keycloak users",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Provision a developer account\n  keycloak_user:\n    auth_keycloak_url: https://sso.example.com\n    auth_username: admin\n    auth_password: \"{{ keycloak_admin_password }}\"\n    realm: staff\n    username: jdoe\n    email: jdoe@example.com\n    first_name: Jane\n    last_name: Doe\n    groups: [developers]\n    password: \"{{ initial_password }}\"",
+			"- name: Offboard a user\n  keycloak_user:\n    auth_keycloak_url: https://sso.example.com\n    token: \"{{ keycloak_token }}\"\n    realm: staff\n    username: jdoe\n    state: absent",
+		},
+		Returns: map[string]string{
+			"id":             "Keycloak id of the user",
+			"changed_fields": "Representation fields that were updated",
+			"groups_added":   "Groups the user was added to",
+		},
+	}
+
+	base := NewBaseModule("keycloak_user", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &KeycloakUserModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *KeycloakUserModule) Validate(args map[string]interface{}) error {
+	return validateKeycloakArgs(m.BaseModule, args, "username")
+}
+
+// desiredUser builds the user representation fields set by the task
+func (m *KeycloakUserModule) desiredUser(args map[string]interface{}) map[string]interface{} {
+	desired := map[string]interface{}{
+		"username": strings.ToLower(m.GetStringArg(args, "username", "")),
+	}
+	fields := map[string]string{
+		"email":      "email",
+		"first_name": "firstName",
+		"last_name":  "lastName",
+	}
+	for arg, field := range fields {
+		if _, ok := args[arg]; ok {
+			desired[field] = m.GetStringArg(args, arg, "")
+		}
+	}
+	if _, ok := args["enabled"]; ok {
+		desired["enabled"] = m.GetBoolArg(args, "enabled", true)
+	}
+	if _, ok := args["email_verified"]; ok {
+		desired["emailVerified"] = m.GetBoolArg(args, "email_verified", false)
+	}
+	if attrs := m.GetMapArg(args, "attributes"); attrs != nil {
+		desired["attributes"] = keycloakAttributes(attrs)
+	}
+	return desired
+}
+
+// findUser returns the user with the exact username, or nil
+func (m *KeycloakUserModule) findUser(ctx context.Context, client *keycloakClient, username string) (map[string]interface{}, error) {
+	var users []map[string]interface{}
+	query := url.Values{"username": {username}, "exact": {"true"}}
+	if _, err := client.do(ctx, http.MethodGet, "/users?"+query.Encode(), nil, &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if strings.EqualFold(types.ConvertToString(user["username"]), username) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// Run executes the keycloak_user module
+func (m *KeycloakUserModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	username := m.GetStringArg(args, "username", "")
+	state := m.GetStringArg(args, "state", "present")
+
+	client, err := newKeycloakClient(ctx, m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := m.findUser(ctx, client, username)
+	if err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"username": username,
+		"realm":    client.realm,
+		"state":    state,
+	})
+
+	var changes []string
+	var before, after string
+	if state == "absent" {
+		if current != nil {
+			id := types.ConvertToString(current["id"])
+			changes = append(changes, fmt.Sprintf("deleted user %s", username))
+			before = formatKeycloakFields(current, []string{"username", "email", "firstName", "lastName", "enabled"})
+			if !checkMode {
+				if _, err := client.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(id), nil, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		desired := m.desiredUser(args)
+		managed := make([]string, 0, len(desired))
+		for field := range desired {
+			managed = append(managed, field)
+		}
+		sort.Strings(managed)
+
+		var id string
+		if current == nil {
+			changes = append(changes, fmt.Sprintf("created user %s", username))
+			after = formatKeycloakFields(desired, managed)
+			if !checkMode {
+				id, err = m.createUser(ctx, client, desired, args)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			id = types.ConvertToString(current["id"])
+			merged, fields := keycloakChanges(current, desired)
+			if len(fields) > 0 {
+				changes = append(changes, fmt.Sprintf("updated %s", strings.Join(fields, ", ")))
+				result.Data["changed_fields"] = fields
+				before = formatKeycloakFields(current, managed)
+				after = formatKeycloakFields(merged, managed)
+				if !checkMode {
+					if _, err := client.do(ctx, http.MethodPut, "/users/"+url.PathEscape(id), merged, nil); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		result.Data["id"] = id
+
+		added, err := m.ensureGroups(ctx, client, id, ldapValues(m.GetSliceArg(args, "groups")), checkMode)
+		if err != nil {
+			return nil, err
+		}
+		if len(added) > 0 {
+			changes = append(changes, fmt.Sprintf("added to groups %s", strings.Join(added, ", ")))
+			result.Data["groups_added"] = added
+		}
+	}
+
+	result.Changed = len(changes) > 0
+	result.Data["changes"] = changes
+	if !result.Changed {
+		result.Message = "Keycloak user is already in desired state"
+	} else if checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+		result.Message = "Would have " + strings.Join(changes, "; ")
+	} else {
+		result.Message = strings.ToUpper(changes[0][:1]) + strings.Join(changes, "; ")[1:]
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// createUser creates the user and returns its id
+func (m *KeycloakUserModule) createUser(ctx context.Context, client *keycloakClient, desired map[string]interface{}, args map[string]interface{}) (string, error) {
+	rep := make(map[string]interface{}, len(desired)+1)
+	for k, v := range desired {
+		rep[k] = v
+	}
+	if _, ok := rep["enabled"]; !ok {
+		rep["enabled"] = true
+	}
+	if password := m.GetStringArg(args, "password", ""); password != "" {
+		rep["credentials"] = []map[string]interface{}{{
+			"type":      "password",
+			"value":     password,
+			"temporary": m.GetBoolArg(args, "password_temporary", true),
+		}}
+	}
+
+	location, err := client.do(ctx, http.MethodPost, "/users", rep, nil)
+	if err != nil {
+		return "", err
+	}
+	if location != "" {
+		return path.Base(location), nil
+	}
+
+	user, err := m.findUser(ctx, client, types.ConvertToString(desired["username"]))
+	if err != nil || user == nil {
+		return "", fmt.Errorf("created user %v could not be found: %v", desired["username"], err)
+	}
+	return types.ConvertToString(user["id"]), nil
+}
+
+// ensureGroups adds the user to any listed group it is not a member of.
+// Memberships not listed are left alone.
+func (m *KeycloakUserModule) ensureGroups(ctx context.Context, client *keycloakClient, id string, groups []string, checkMode bool) ([]string, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	member := make(map[string]bool)
+	if id != "" {
+		var current []map[string]interface{}
+		if _, err := client.do(ctx, http.MethodGet, "/users/"+url.PathEscape(id)+"/groups", nil, &current); err != nil {
+			return nil, err
+		}
+		for _, group := range current {
+			member[types.ConvertToString(group["id"])] = true
+		}
+	}
+
+	var added []string
+	for _, name := range groups {
+		group, err := client.groupByPath(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			return nil, fmt.Errorf("keycloak group %s does not exist", name)
+		}
+
+		groupID := types.ConvertToString(group["id"])
+		if member[groupID] {
+			continue
+		}
+		added = append(added, name)
+		if checkMode {
+			continue
+		}
+		if _, err := client.do(ctx, http.MethodPut, "/users/"+url.PathEscape(id)+"/groups/"+url.PathEscape(groupID), nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+// KeycloakGroupModule manages groups through the Keycloak admin REST API
+type KeycloakGroupModule struct {
+	*BaseModule
+}
+
+// NewKeycloakGroupModule creates a new keycloak_group module instance
+func NewKeycloakGroupModule() *KeycloakGroupModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "Name of the group",
+			Required:    true,
+			Type:        "string",
+		},
+		"parent": {
+			Description: "Path of the parent group for subgroups, e.g. /engineering",
+			Required:    false,
+			Type:        "string",
+		},
+		"attributes": {
+			Description: "Group attributes mapped to a value or list of values",
+			Required:    false,
+			Type:        "dict",
+		},
+		"state": {
+			Description: "Whether the group should exist",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range keycloakAuthParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "keycloak_group",
+		Description: "Create, update and delete Keycloak groups",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Ensure the developers group exists\n  keycloak_group:\n    auth_keycloak_url: https://sso.example.com\n    auth_client_id: provisioner\n    auth_client_secret: \"{{ provisioner_secret }}\"\n    realm: staff\n    name: developers\n    attributes:\n      cost_center: \"4711\"",
+			"- name: Create a subgroup\n  keycloak_group:\n    auth_keycloak_url: https://sso.example.com\n    token: \"{{ keycloak_token }}\"\n    realm: staff\n    name: oncall\n    parent: /developers",
+		},
+		Returns: map[string]string{
+			"id":   "Keycloak id of the group",
+			"path": "Full path of the group",
+		},
+	}
+
+	base := NewBaseModule("keycloak_group", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &KeycloakGroupModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *KeycloakGroupModule) Validate(args map[string]interface{}) error {
+	if err := validateKeycloakArgs(m.BaseModule, args, "name"); err != nil {
+		return err
+	}
+	if name := m.GetStringArg(args, "name", ""); strings.Contains(name, "/") {
+		return types.NewValidationError("name", name, "name must not contain '/'; use parent for subgroups")
+	}
+	return nil
+}
+
+// Run executes the keycloak_group module
+func (m *KeycloakGroupModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	parent := strings.TrimRight(m.GetStringArg(args, "parent", ""), "/")
+	if parent != "" && !strings.HasPrefix(parent, "/") {
+		parent = "/" + parent
+	}
+	groupPath := parent + "/" + name
+	state := m.GetStringArg(args, "state", "present")
+
+	client, err := newKeycloakClient(ctx, m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := client.groupByPath(ctx, groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"name":  name,
+		"path":  groupPath,
+		"realm": client.realm,
+		"state": state,
+	})
+
+	managed := []string{"name", "path", "attributes"}
+	var message, before, after string
+	if current != nil {
+		result.Data["id"] = current["id"]
+	}
+
+	switch {
+	case state == "absent" && current != nil:
+		message = fmt.Sprintf("deleted group %s", groupPath)
+		before = formatKeycloakFields(current, managed)
+		if !checkMode {
+			if _, err := client.do(ctx, http.MethodDelete, "/groups/"+url.PathEscape(types.ConvertToString(current["id"])), nil, nil); err != nil {
+				return nil, err
+			}
+		}
+
+	case state == "present" && current == nil:
+		desired := map[string]interface{}{"name": name}
+		if attrs := m.GetMapArg(args, "attributes"); attrs != nil {
+			desired["attributes"] = keycloakAttributes(attrs)
+		}
+		message = fmt.Sprintf("created group %s", groupPath)
+		after = formatKeycloakFields(map[string]interface{}{"name": name, "path": groupPath, "attributes": desired["attributes"]}, managed)
+
+		if !checkMode {
+			endpoint := "/groups"
+			if parent != "" {
+				parentGroup, err := client.groupByPath(ctx, parent)
+				if err != nil {
+					return nil, err
+				}
+				if parentGroup == nil {
+					return nil, fmt.Errorf("parent group %s does not exist", parent)
+				}
+				endpoint = "/groups/" + url.PathEscape(types.ConvertToString(parentGroup["id"])) + "/children"
+			}
+			location, err := client.do(ctx, http.MethodPost, endpoint, desired, nil)
+			if err != nil {
+				return nil, err
+			}
+			if location != "" {
+				result.Data["id"] = path.Base(location)
+			}
+		}
+
+	case state == "present" && current != nil:
+		if attrs := m.GetMapArg(args, "attributes"); attrs != nil {
+			merged, fields := keycloakChanges(current, map[string]interface{}{"attributes": keycloakAttributes(attrs)})
+			if len(fields) > 0 {
+				message = fmt.Sprintf("updated attributes of group %s", groupPath)
+				before = formatKeycloakFields(current, managed)
+				after = formatKeycloakFields(merged, managed)
+				if !checkMode {
+					delete(merged, "subGroups")
+					if _, err := client.do(ctx, http.MethodPut, "/groups/"+url.PathEscape(types.ConvertToString(current["id"])), merged, nil); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	result.Changed = message != ""
+	if !result.Changed {
+		result.Message = "Keycloak group is already in desired state"
+	} else if checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+		result.Message = "Would have " + message
+	} else {
+		result.Message = strings.ToUpper(message[:1]) + message[1:]
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
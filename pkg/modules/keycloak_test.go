@@ -0,0 +1,302 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// fakeKeycloak is an in-memory stand-in for the parts of the admin API the
+// keycloak modules use
+type fakeKeycloak struct {
+	mu          sync.Mutex
+	users       map[string]map[string]interface{}
+	groups      map[string]map[string]interface{} // keyed by path
+	memberships map[string]map[string]bool        // user id -> group ids
+	writes      []string
+	nextID      int
+}
+
+func newFakeKeycloak(t *testing.T) (*fakeKeycloak, *httptest.Server) {
+	fake := &fakeKeycloak{
+		users:       make(map[string]map[string]interface{}),
+		groups:      make(map[string]map[string]interface{}),
+		memberships: make(map[string]map[string]bool),
+	}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeKeycloak) addUser(rep map[string]interface{}) string {
+	f.nextID++
+	id := fmt.Sprintf("u%d", f.nextID)
+	rep["id"] = id
+	f.users[id] = rep
+	return id
+}
+
+func (f *fakeKeycloak) addGroup(groupPath string, rep map[string]interface{}) string {
+	f.nextID++
+	id := fmt.Sprintf("g%d", f.nextID)
+	rep["id"] = id
+	rep["path"] = groupPath
+	f.groups[groupPath] = rep
+	return id
+}
+
+func (f *fakeKeycloak) groupByID(id string) (string, map[string]interface{}) {
+	for p, group := range f.groups {
+		if group["id"] == id {
+			return p, group
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeKeycloak) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/realms/master/protocol/openid-connect/token" {
+		r.ParseForm()
+		if r.Form.Get("username") != "admin" || r.Form.Get("password") != "admin" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "tok"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	route := strings.TrimPrefix(r.URL.Path, "/admin/realms/staff")
+	if r.Method != http.MethodGet {
+		f.writes = append(f.writes, r.Method+" "+route)
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	parts := strings.Split(strings.Trim(route, "/"), "/")
+
+	switch {
+	case route == "/users" && r.Method == http.MethodGet:
+		found := []map[string]interface{}{}
+		for _, user := range f.users {
+			if user["username"] == r.URL.Query().Get("username") {
+				found = append(found, user)
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	case route == "/users" && r.Method == http.MethodPost:
+		delete(body, "credentials")
+		id := f.addUser(body)
+		w.Header().Set("Location", "http://"+r.Host+"/admin/realms/staff/users/"+id)
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 2 && parts[0] == "users" && r.Method == http.MethodPut:
+		f.users[parts[1]] = body
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[0] == "users" && r.Method == http.MethodDelete:
+		delete(f.users, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "groups":
+		groups := []map[string]interface{}{}
+		for id := range f.memberships[parts[1]] {
+			_, group := f.groupByID(id)
+			groups = append(groups, group)
+		}
+		json.NewEncoder(w).Encode(groups)
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "groups" && r.Method == http.MethodPut:
+		if f.memberships[parts[1]] == nil {
+			f.memberships[parts[1]] = make(map[string]bool)
+		}
+		f.memberships[parts[1]][parts[3]] = true
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(route, "/group-by-path/"):
+		group, ok := f.groups[strings.TrimPrefix(route, "/group-by-path")]
+		if !ok {
+			http.Error(w, `{"error":"Group path does not exist"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(group)
+	case route == "/groups" && r.Method == http.MethodPost:
+		id := f.addGroup("/"+types.ConvertToString(body["name"]), body)
+		w.Header().Set("Location", "http://"+r.Host+"/admin/realms/staff/groups/"+id)
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "children":
+		parentPath, _ := f.groupByID(parts[1])
+		id := f.addGroup(parentPath+"/"+types.ConvertToString(body["name"]), body)
+		w.Header().Set("Location", "http://"+r.Host+"/admin/realms/staff/groups/"+id)
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 2 && parts[0] == "groups" && r.Method == http.MethodPut:
+		p, _ := f.groupByID(parts[1])
+		f.groups[p] = body
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[0] == "groups" && r.Method == http.MethodDelete:
+		p, _ := f.groupByID(parts[1])
+		delete(f.groups, p)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+route, http.StatusBadRequest)
+	}
+}
+
+func keycloakArgs(url string, extra map[string]interface{}) map[string]interface{} {
+	args := map[string]interface{}{
+		"auth_keycloak_url": url,
+		"auth_username":     "admin",
+		"auth_password":     "admin",
+		"realm":             "staff",
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	return args
+}
+
+func TestKeycloakUserModule(t *testing.T) {
+	module := NewKeycloakUserModule()
+
+	t.Run("ValidationTests", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, module)
+		helper.RunValidationTests([]testhelper.ValidationTestCase{
+			{Name: "Valid", Args: keycloakArgs("https://sso", map[string]interface{}{"username": "jdoe"}), ExpectValid: true},
+			{Name: "MissingURL", Args: map[string]interface{}{"username": "jdoe", "token": "t"}, ExpectValid: false},
+			{Name: "MissingUsername", Args: keycloakArgs("https://sso", nil), ExpectValid: false},
+			{Name: "MissingCredentials", Args: map[string]interface{}{"auth_keycloak_url": "https://sso", "username": "jdoe"}, ExpectValid: false},
+			{Name: "InvalidState", Args: keycloakArgs("https://sso", map[string]interface{}{"username": "jdoe", "state": "disabled"}), ExpectValid: false},
+		})
+	})
+
+	t.Run("CreateUpdateDelete", func(t *testing.T) {
+		fake, server := newFakeKeycloak(t)
+		developers := fake.addGroup("/developers", map[string]interface{}{"name": "developers"})
+		helper := testhelper.NewModuleTestHelper(t, module)
+
+		args := func() map[string]interface{} {
+			return keycloakArgs(server.URL, map[string]interface{}{
+				"username":   "jdoe",
+				"email":      "jdoe@example.com",
+				"first_name": "Jane",
+				"attributes": map[string]interface{}{"team": "platform"},
+				"groups":     []interface{}{"developers"},
+				"password":   "changeme",
+			})
+		}
+
+		result := helper.Execute(args(), true, false)
+		helper.AssertChanged(result)
+		helper.AssertCheckModeSimulated(result)
+		if len(fake.writes) != 0 {
+			t.Fatalf("check mode must not write, got %v", fake.writes)
+		}
+
+		result = helper.Execute(args(), false, false)
+		helper.AssertChanged(result)
+		id := result.Data["id"].(string)
+		if fake.users[id]["email"] != "jdoe@example.com" || !fake.memberships[id][developers] {
+			t.Fatalf("user not created as expected: %v %v", fake.users[id], fake.memberships)
+		}
+
+		result = helper.Execute(args(), false, false)
+		helper.AssertNotChanged(result)
+
+		update := args()
+		update["email"] = "jane@example.com"
+		result = helper.Execute(update, false, true)
+		helper.AssertChanged(result)
+		helper.AssertDiffPresent(result)
+		if fields := result.Data["changed_fields"].([]string); len(fields) != 1 || fields[0] != "email" {
+			t.Errorf("expected only email to change, got %v", fields)
+		}
+		if fake.users[id]["firstName"] != "Jane" {
+			t.Errorf("update should keep unmanaged fields, got %v", fake.users[id])
+		}
+
+		result = helper.Execute(keycloakArgs(server.URL, map[string]interface{}{"username": "jdoe", "state": "absent"}), false, false)
+		helper.AssertChanged(result)
+		if len(fake.users) != 0 {
+			t.Errorf("expected user to be deleted, got %v", fake.users)
+		}
+	})
+
+	t.Run("UnknownGroup", func(t *testing.T) {
+		_, server := newFakeKeycloak(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+		err := helper.ExecuteExpectingError(keycloakArgs(server.URL, map[string]interface{}{
+			"username": "jdoe",
+			"groups":   []interface{}{"nope"},
+		}))
+		if err == nil || !strings.Contains(err.Error(), "group nope does not exist") {
+			t.Errorf("expected missing group error, got %v", err)
+		}
+	})
+
+	t.Run("BadCredentials", func(t *testing.T) {
+		_, server := newFakeKeycloak(t)
+		helper := testhelper.NewModuleTestHelper(t, module)
+		args := keycloakArgs(server.URL, map[string]interface{}{"username": "jdoe"})
+		args["auth_password"] = "wrong"
+		if err := helper.ExecuteExpectingError(args); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Errorf("expected token error, got %v", err)
+		}
+	})
+}
+
+func TestKeycloakGroupModule(t *testing.T) {
+	module := NewKeycloakGroupModule()
+	fake, server := newFakeKeycloak(t)
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.ExecuteValidationTest(keycloakArgs(server.URL, map[string]interface{}{"name": "a/b"}), false)
+
+	result := helper.Execute(keycloakArgs(server.URL, map[string]interface{}{"name": "engineering"}), false, false)
+	helper.AssertChanged(result)
+
+	subgroup := func() map[string]interface{} {
+		return keycloakArgs(server.URL, map[string]interface{}{
+			"name":       "oncall",
+			"parent":     "engineering",
+			"attributes": map[string]interface{}{"pager": []interface{}{"primary", "secondary"}},
+		})
+	}
+	result = helper.Execute(subgroup(), false, false)
+	helper.AssertChanged(result)
+	if _, ok := fake.groups["/engineering/oncall"]; !ok {
+		t.Fatalf("expected subgroup to be created, got %v", fake.groups)
+	}
+
+	result = helper.Execute(subgroup(), false, false)
+	helper.AssertNotChanged(result)
+
+	changed := func() map[string]interface{} {
+		args := subgroup()
+		args["attributes"] = map[string]interface{}{"pager": "primary"}
+		return args
+	}
+	result = helper.Execute(changed(), true, true)
+	helper.AssertChanged(result)
+	helper.AssertDiffPresent(result)
+	helper.AssertMessage(result, "Would have updated attributes of group /engineering/oncall")
+
+	result = helper.Execute(changed(), false, false)
+	helper.AssertChanged(result)
+	if attrs := fake.groups["/engineering/oncall"]["attributes"].(map[string]interface{}); len(attrs["pager"].([]interface{})) != 1 {
+		t.Errorf("expected attributes to be replaced, got %v", attrs)
+	}
+
+	result = helper.Execute(keycloakArgs(server.URL, map[string]interface{}{"name": "oncall", "parent": "/engineering", "state": "absent"}), false, false)
+	helper.AssertChanged(result)
+	if _, ok := fake.groups["/engineering/oncall"]; ok {
+		t.Error("expected subgroup to be deleted")
+	}
+}
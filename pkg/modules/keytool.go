@@ -0,0 +1,118 @@
+package modules
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Environment variables keytool and openssl read passwords from, so they
+// never appear in the process list
+const (
+	keytoolStorePassVar = "GOSIBLE_STOREPASS"
+	keytoolSrcPassVar   = "GOSIBLE_SRCPASS"
+	keytoolKeyPassVar   = "GOSIBLE_KEYPASS"
+)
+
+// keytool runs the JDK keytool on the target host
+type keytool struct {
+	cli        remoteCLI
+	executable string
+}
+
+// env prefixes a command with password variables
+func (k keytool) env(passwords map[string]string) string {
+	names := make([]string, 0, len(passwords))
+	for name := range passwords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var prefix strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&prefix, "%s=%s ", name, k.cli.shellEscape(passwords[name]))
+	}
+	return prefix.String()
+}
+
+// storeArgs returns the -keystore, -storetype and -storepass options for a
+// keystore whose password is in keytoolStorePassVar
+func (k keytool) storeArgs(path, storeType string) string {
+	args := "-keystore " + k.cli.shellEscape(path)
+	if storeType != "" {
+		args += " -storetype " + storeType
+	}
+	return args + " -storepass:env " + keytoolStorePassVar
+}
+
+// entry reads the SHA-256 fingerprint of the certificate under an alias.
+// It reports whether the keystore file exists, and returns an empty
+// fingerprint when the alias does not.
+func (k keytool) entry(ctx context.Context, conn types.Connection, path, storeType, password, alias string) (string, bool, error) {
+	quoted := k.cli.shellEscape(path)
+	show := fmt.Sprintf("%s%s -list -v -alias %s %s 2>&1 || true",
+		k.env(map[string]string{keytoolStorePassVar: password}), k.executable, k.cli.shellEscape(alias), k.storeArgs(path, storeType))
+	output, exists, err := k.cli.inspect(ctx, conn, path, "[ -f "+quoted+" ]", show)
+	if err != nil || !exists {
+		return "", exists, err
+	}
+
+	if fingerprint := keytoolFingerprint(output); fingerprint != "" {
+		return fingerprint, true, nil
+	}
+	if strings.Contains(output, "does not exist") {
+		return "", true, nil
+	}
+	return "", true, fmt.Errorf("reading %s from %s failed: %s", alias, path, keytoolError(output))
+}
+
+// keytoolFingerprint returns the first SHA-256 fingerprint in keytool -list
+// -v or -printcert output, which is the entry's leaf certificate
+func keytoolFingerprint(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "SHA256:"); ok {
+			return strings.ToUpper(strings.TrimSpace(value))
+		}
+	}
+	return ""
+}
+
+// keytoolError picks the error line out of keytool output
+func keytoolError(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if _, message, ok := strings.Cut(line, "keytool error: "); ok {
+			return strings.TrimSpace(message)
+		}
+	}
+	return strings.TrimSpace(output)
+}
+
+// certificateFingerprint parses the first certificate of a PEM bundle and
+// formats its SHA-256 fingerprint the way keytool prints it
+func certificateFingerprint(content string) (string, error) {
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return "", fmt.Errorf("no PEM certificate found")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("invalid certificate: %v", err)
+		}
+		sum := sha256.Sum256(block.Bytes)
+		hex := make([]string, len(sum))
+		for i, b := range sum {
+			hex[i] = fmt.Sprintf("%02X", b)
+		}
+		return strings.Join(hex, ":"), nil
+	}
+}
@@ -0,0 +1,614 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ldapConnectionParams documents the options shared by the LDAP modules
+var ldapConnectionParams = map[string]types.ParamDoc{
+	"server_uri": {
+		Description: "LDAP server URI",
+		Required:    false,
+		Type:        "string",
+		Default:     "ldaps://localhost",
+	},
+	"bind_dn": {
+		Description: "DN to bind as; anonymous bind when omitted",
+		Required:    false,
+		Type:        "string",
+	},
+	"bind_pw": {
+		Description: "Password for bind_dn",
+		Required:    false,
+		Type:        "string",
+	},
+	"start_tls": {
+		Description: "Require StartTLS on ldap:// URIs",
+		Required:    false,
+		Type:        "bool",
+		Default:     false,
+	},
+	"validate_certs": {
+		Description: "Verify the server certificate",
+		Required:    false,
+		Type:        "bool",
+		Default:     true,
+	},
+}
+
+// ldapClient drives the OpenLDAP client tools on the target host
+type ldapClient struct {
+	serverURI     string
+	bindDN        string
+	bindPW        string
+	startTLS      bool
+	validateCerts bool
+}
+
+// newLDAPClient reads the connection options from module arguments
+func newLDAPClient(m *BaseModule, args map[string]interface{}) *ldapClient {
+	return &ldapClient{
+		serverURI:     m.GetStringArg(args, "server_uri", "ldaps://localhost"),
+		bindDN:        m.GetStringArg(args, "bind_dn", ""),
+		bindPW:        m.GetStringArg(args, "bind_pw", ""),
+		startTLS:      m.GetBoolArg(args, "start_tls", false),
+		validateCerts: m.GetBoolArg(args, "validate_certs", true),
+	}
+}
+
+// options returns the command line options common to all LDAP tools
+func (c *ldapClient) options() string {
+	opts := []string{"-H", c.shellEscape(c.serverURI), "-x"}
+	if c.startTLS {
+		opts = append(opts, "-ZZ")
+	}
+	if c.bindDN != "" {
+		opts = append(opts, "-D", c.shellEscape(c.bindDN), "-w", c.shellEscape(c.bindPW))
+	}
+	return strings.Join(opts, " ")
+}
+
+// executeOptions disables certificate checks when requested
+func (c *ldapClient) executeOptions() types.ExecuteOptions {
+	if c.validateCerts {
+		return types.ExecuteOptions{}
+	}
+	return types.ExecuteOptions{Env: map[string]string{"LDAPTLS_REQCERT": "never"}}
+}
+
+// search reads a single entry. A missing entry (result code 32) is
+// reported as found == false rather than an error.
+func (c *ldapClient) search(ctx context.Context, conn types.Connection, dn string) (ldapAttributes, bool, error) {
+	cmd := fmt.Sprintf("ldapsearch -LLL -o ldif-wrap=no %s -b %s -s base '(objectClass=*)' '*' || [ $? -eq 32 ]",
+		c.options(), c.shellEscape(dn))
+
+	result, err := conn.Execute(ctx, cmd, c.executeOptions())
+	if err != nil {
+		return nil, false, fmt.Errorf("ldapsearch failed: %w", err)
+	}
+	if !result.Success {
+		return nil, false, fmt.Errorf("ldapsearch failed: %s", commandStderr(result))
+	}
+
+	stdout, _ := result.Data["stdout"].(string)
+	if strings.TrimSpace(stdout) == "" {
+		return nil, false, nil
+	}
+
+	attrs, err := parseLDIFEntry(stdout)
+	if err != nil {
+		return nil, false, err
+	}
+	return attrs, true, nil
+}
+
+// apply feeds an LDIF document to ldapmodify
+func (c *ldapClient) apply(ctx context.Context, conn types.Connection, ldif string) error {
+	cmd := fmt.Sprintf("printf '%%s' %s | ldapmodify %s", c.shellEscape(ldif), c.options())
+
+	result, err := conn.Execute(ctx, cmd, c.executeOptions())
+	if err != nil {
+		return fmt.Errorf("ldapmodify failed: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("ldapmodify failed: %s", commandStderr(result))
+	}
+	return nil
+}
+
+// shellEscape escapes a string for shell usage
+func (c *ldapClient) shellEscape(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "'\"'\"'"))
+}
+
+func commandStderr(result *types.Result) string {
+	if stderr, ok := result.Data["stderr"].(string); ok && strings.TrimSpace(stderr) != "" {
+		return strings.TrimSpace(stderr)
+	}
+	return result.Message
+}
+
+// ldapAttributes maps lower-cased attribute names to their values
+type ldapAttributes map[string][]string
+
+// parseLDIFEntry parses unwrapped ldapsearch output for a single entry
+func parseLDIFEntry(ldif string) (ldapAttributes, error) {
+	attrs := make(ldapAttributes)
+	for _, line := range strings.Split(ldif, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid LDIF line %q", line)
+		}
+		name := strings.ToLower(line[:idx])
+		value := line[idx+1:]
+
+		if strings.HasPrefix(value, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value for %s: %w", name, err)
+			}
+			value = string(decoded)
+		} else {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		if name == "dn" {
+			continue
+		}
+		attrs[name] = append(attrs[name], value)
+	}
+	return attrs, nil
+}
+
+// ldifLine renders one attribute value, base64 encoding unsafe values
+func ldifLine(name, value string) string {
+	safe := value == strings.TrimSpace(value) &&
+		!strings.HasPrefix(value, ":") && !strings.HasPrefix(value, "<")
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			safe = false
+			break
+		}
+	}
+	if !safe {
+		return fmt.Sprintf("%s:: %s\n", name, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return fmt.Sprintf("%s: %s\n", name, value)
+}
+
+// formatLDAPAttributes renders attributes in a stable order for diffs
+func formatLDAPAttributes(dn string, attrs ldapAttributes) string {
+	if attrs == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(ldifLine("dn", dn))
+	for _, name := range names {
+		for _, value := range attrs[name] {
+			b.WriteString(ldifLine(name, value))
+		}
+	}
+	return b.String()
+}
+
+// ldapValues converts a scalar or list argument into attribute values
+func ldapValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, types.ConvertToString(item))
+		}
+		return values
+	case []string:
+		return v
+	default:
+		return []string{types.ConvertToString(v)}
+	}
+}
+
+// ldapAttributeArgs converts the attributes argument, keeping the
+// caller's spelling of each attribute name for the generated LDIF
+func ldapAttributeArgs(m *BaseModule, args map[string]interface{}) (map[string][]string, []string) {
+	raw := m.GetMapArg(args, "attributes")
+	attrs := make(map[string][]string, len(raw))
+	names := make([]string, 0, len(raw))
+	for name, value := range raw {
+		attrs[name] = ldapValues(value)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return attrs, names
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		if !containsValue(b, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateLDAPArgs(m *BaseModule, args map[string]interface{}, states []string) error {
+	if m.GetStringArg(args, "dn", "") == "" {
+		return types.NewValidationError("dn", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", states); err != nil {
+		return err
+	}
+	if value, exists := args["attributes"]; exists {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return types.NewValidationError("attributes", value, "attributes must be a map of attribute names to values")
+		}
+	}
+	uri := m.GetStringArg(args, "server_uri", "ldaps://localhost")
+	if !strings.HasPrefix(uri, "ldap://") && !strings.HasPrefix(uri, "ldaps://") && !strings.HasPrefix(uri, "ldapi://") {
+		return types.NewValidationError("server_uri", uri, "server_uri must be an ldap://, ldaps:// or ldapi:// URI")
+	}
+	return nil
+}
+
+// LDAPEntryModule adds and removes LDAP entries
+type LDAPEntryModule struct {
+	*BaseModule
+}
+
+// NewLDAPEntryModule creates a new ldap_entry module instance
+func NewLDAPEntryModule() *LDAPEntryModule {
+	params := map[string]types.ParamDoc{
+		"dn": {
+			Description: "Distinguished name of the entry",
+			Required:    true,
+			Type:        "string",
+		},
+		"objectClass": {
+			Description: "Object classes of a new entry",
+			Required:    false,
+			Type:        "list",
+		},
+		"attributes": {
+			Description: "Attributes of a new entry; use ldap_attrs to manage existing entries",
+			Required:    false,
+			Type:        "dict",
+		},
+		"state": {
+			Description: "Whether the entry should exist",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range ldapConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "ldap_entry",
+		Description: "Add or remove LDAP entries with the OpenLDAP client tools",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Create a people OU\n  ldap_entry:\n    server_uri: ldaps://ldap.example.com\n    bind_dn: cn=admin,dc=example,dc=com\n    bind_pw: \"{{ ldap_admin_password }}\"\n    dn: ou=people,dc=example,dc=com\n    objectClass: organizationalUnit",
+			"- name: Remove a user\n  ldap_entry:\n    dn: uid=jdoe,ou=people,dc=example,dc=com\n    state: absent",
+		},
+		Returns: map[string]string{
+			"dn":   "Distinguished name of the entry",
+			"ldif": "LDIF sent to the server",
+		},
+	}
+
+	base := NewBaseModule("ldap_entry", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &LDAPEntryModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *LDAPEntryModule) Validate(args map[string]interface{}) error {
+	if err := validateLDAPArgs(m.BaseModule, args, []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "state", "present") == "present" && len(m.GetSliceArg(args, "objectClass")) == 0 {
+		return types.NewValidationError("objectClass", nil, "objectClass is required when state is present")
+	}
+	return nil
+}
+
+// Run executes the ldap_entry module
+func (m *LDAPEntryModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	dn := m.GetStringArg(args, "dn", "")
+	state := m.GetStringArg(args, "state", "present")
+	client := newLDAPClient(m.BaseModule, args)
+
+	current, exists, err := client.search(ctx, conn, dn)
+	if err != nil {
+		return nil, err
+	}
+
+	var ldif, message, checkMessage string
+	var desired ldapAttributes
+	switch {
+	case state == "present" && !exists:
+		desired = make(ldapAttributes)
+		var b strings.Builder
+		b.WriteString(ldifLine("dn", dn))
+		b.WriteString("changetype: add\n")
+		for _, class := range ldapValues(m.GetSliceArg(args, "objectClass")) {
+			b.WriteString(ldifLine("objectClass", class))
+			desired["objectclass"] = append(desired["objectclass"], class)
+		}
+		attrs, names := ldapAttributeArgs(m.BaseModule, args)
+		for _, name := range names {
+			for _, value := range attrs[name] {
+				b.WriteString(ldifLine(name, value))
+				desired[strings.ToLower(name)] = append(desired[strings.ToLower(name)], value)
+			}
+		}
+		ldif = b.String()
+		message = fmt.Sprintf("Added entry %s", dn)
+		checkMessage = fmt.Sprintf("Would add entry %s", dn)
+	case state == "absent" && exists:
+		ldif = ldifLine("dn", dn) + "changetype: delete\n"
+		message = fmt.Sprintf("Deleted entry %s", dn)
+		checkMessage = fmt.Sprintf("Would delete entry %s", dn)
+	}
+
+	changed := ldif != ""
+	result := m.CreateSuccessResult(hostname, changed, "", map[string]interface{}{
+		"dn":    dn,
+		"state": state,
+	})
+
+	if !changed {
+		result.Message = "LDAP entry is already in desired state"
+	} else {
+		result.Data["ldif"] = ldif
+		if checkMode {
+			result.Simulated = true
+			result.Data["check_mode"] = true
+			result.Message = checkMessage
+		} else {
+			if err := client.apply(ctx, conn, ldif); err != nil {
+				return nil, err
+			}
+			result.Message = message
+		}
+
+		if diffMode {
+			result.Diff = m.GenerateDiff(formatLDAPAttributes(dn, current), formatLDAPAttributes(dn, desired))
+		}
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// LDAPAttrsModule manages attribute values of an existing LDAP entry
+type LDAPAttrsModule struct {
+	*BaseModule
+}
+
+// NewLDAPAttrsModule creates a new ldap_attrs module instance
+func NewLDAPAttrsModule() *LDAPAttrsModule {
+	params := map[string]types.ParamDoc{
+		"dn": {
+			Description: "Distinguished name of the entry to modify",
+			Required:    true,
+			Type:        "string",
+		},
+		"attributes": {
+			Description: "Attribute names mapped to a value or list of values",
+			Required:    true,
+			Type:        "dict",
+		},
+		"state": {
+			Description: "present adds missing values, absent removes the listed values, exact replaces all values",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent", "exact"},
+		},
+	}
+	for name, doc := range ldapConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "ldap_attrs",
+		Description: "Add, remove or replace attribute values on an LDAP entry",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Ensure a user is in the admins group\n  ldap_attrs:\n    dn: cn=admins,ou=groups,dc=example,dc=com\n    attributes:\n      memberUid: jdoe",
+			"- name: Set the exact login shell\n  ldap_attrs:\n    dn: uid=jdoe,ou=people,dc=example,dc=com\n    attributes:\n      loginShell: /bin/zsh\n    state: exact",
+		},
+		Returns: map[string]string{
+			"dn":      "Distinguished name of the entry",
+			"modlist": "Modifications applied to the entry",
+			"ldif":    "LDIF sent to the server",
+		},
+	}
+
+	base := NewBaseModule("ldap_attrs", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &LDAPAttrsModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *LDAPAttrsModule) Validate(args map[string]interface{}) error {
+	if err := validateLDAPArgs(m.BaseModule, args, []string{"present", "absent", "exact"}); err != nil {
+		return err
+	}
+	if len(m.GetMapArg(args, "attributes")) == 0 {
+		return types.NewValidationError("attributes", nil, "required parameter")
+	}
+	return nil
+}
+
+// Run executes the ldap_attrs module
+func (m *LDAPAttrsModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	dn := m.GetStringArg(args, "dn", "")
+	state := m.GetStringArg(args, "state", "present")
+	client := newLDAPClient(m.BaseModule, args)
+
+	current, exists, err := client.search(ctx, conn, dn)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("LDAP entry %s does not exist", dn)
+	}
+
+	desired := make(ldapAttributes, len(current))
+	for name, values := range current {
+		desired[name] = append([]string(nil), values...)
+	}
+
+	attrs, names := ldapAttributeArgs(m.BaseModule, args)
+	var modlist []string
+	var ldif strings.Builder
+	for _, name := range names {
+		key := strings.ToLower(name)
+		have := current[key]
+
+		var op string
+		var values []string
+		switch state {
+		case "present":
+			for _, value := range attrs[name] {
+				if !containsValue(have, value) && !containsValue(values, value) {
+					values = append(values, value)
+				}
+			}
+			if len(values) > 0 {
+				op = "add"
+				desired[key] = append(desired[key], values...)
+			}
+		case "absent":
+			for _, value := range attrs[name] {
+				if containsValue(have, value) {
+					values = append(values, value)
+				}
+			}
+			if len(values) > 0 {
+				op = "delete"
+				var kept []string
+				for _, value := range desired[key] {
+					if !containsValue(values, value) {
+						kept = append(kept, value)
+					}
+				}
+				desired[key] = kept
+			}
+		case "exact":
+			if !sameValues(have, attrs[name]) {
+				op = "replace"
+				values = attrs[name]
+				desired[key] = values
+			}
+		}
+
+		if op == "" {
+			continue
+		}
+		if len(desired[key]) == 0 {
+			delete(desired, key)
+		}
+
+		modlist = append(modlist, fmt.Sprintf("%s %s: %s", op, name, strings.Join(values, ", ")))
+		fmt.Fprintf(&ldif, "%s: %s\n", op, name)
+		for _, value := range values {
+			ldif.WriteString(ldifLine(name, value))
+		}
+		ldif.WriteString("-\n")
+	}
+
+	changed := len(modlist) > 0
+	result := m.CreateSuccessResult(hostname, changed, "", map[string]interface{}{
+		"dn":      dn,
+		"state":   state,
+		"modlist": modlist,
+	})
+
+	if !changed {
+		result.Message = "LDAP attributes are already in desired state"
+	} else {
+		document := ldifLine("dn", dn) + "changetype: modify\n" + ldif.String()
+		result.Data["ldif"] = document
+		if checkMode {
+			result.Simulated = true
+			result.Data["check_mode"] = true
+			result.Message = fmt.Sprintf("Would modify %s: %s", dn, strings.Join(modlist, "; "))
+		} else {
+			if err := client.apply(ctx, conn, document); err != nil {
+				return nil, err
+			}
+			result.Message = fmt.Sprintf("Modified %s: %s", dn, strings.Join(modlist, "; "))
+		}
+
+		if diffMode {
+			result.Diff = m.GenerateDiff(formatLDAPAttributes(dn, current), formatLDAPAttributes(dn, desired))
+		}
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+const jdoeLDIF = "dn: uid=jdoe,ou=people,dc=example,dc=com\n" +
+	"objectClass: inetOrgPerson\n" +
+	"objectClass: posixAccount\n" +
+	"uid: jdoe\n" +
+	"loginShell: /bin/bash\n" +
+	"mail: jdoe@example.com\n" +
+	"description:: w6lxdWlwZSBkZXY=\n"
+
+func TestParseLDIFEntry(t *testing.T) {
+	attrs, err := parseLDIFEntry(jdoeLDIF)
+	if err != nil {
+		t.Fatalf("parseLDIFEntry failed: %v", err)
+	}
+	if len(attrs["objectclass"]) != 2 {
+		t.Errorf("expected two object classes, got %v", attrs["objectclass"])
+	}
+	if attrs["description"][0] != "équipe dev" {
+		t.Errorf("expected base64 value to be decoded, got %q", attrs["description"][0])
+	}
+	if _, ok := attrs["dn"]; ok {
+		t.Error("dn should not be returned as an attribute")
+	}
+
+	if line := ldifLine("description", "équipe dev"); line != "description:: w6lxdWlwZSBkZXY=\n" {
+		t.Errorf("expected non-ASCII value to be base64 encoded, got %q", line)
+	}
+	if line := ldifLine("cn", "Jane Doe"); line != "cn: Jane Doe\n" {
+		t.Errorf("unexpected plain line %q", line)
+	}
+}
+
+func TestLDAPEntryModule(t *testing.T) {
+	module := NewLDAPEntryModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidPresent", Args: map[string]interface{}{"dn": "ou=people,dc=example,dc=com", "objectClass": "organizationalUnit"}, ExpectValid: true},
+		{Name: "ValidAbsent", Args: map[string]interface{}{"dn": "ou=people,dc=example,dc=com", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingDN", Args: map[string]interface{}{"objectClass": "organizationalUnit"}, ExpectValid: false},
+		{Name: "MissingObjectClass", Args: map[string]interface{}{"dn": "ou=people,dc=example,dc=com"}, ExpectValid: false},
+		{Name: "BadURI", Args: map[string]interface{}{"dn": "ou=people,dc=example,dc=com", "state": "absent", "server_uri": "https://ldap"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "AddMissingEntry",
+			Args: map[string]interface{}{
+				"dn":          "ou=people,dc=example,dc=com",
+				"objectClass": "organizationalUnit",
+				"attributes":  map[string]interface{}{"description": "People"},
+				"bind_dn":     "cn=admin,dc=example,dc=com",
+				"bind_pw":     "s3cret",
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^ldapsearch .*-D 'cn=admin,dc=example,dc=com' -w 's3cret' -b 'ou=people,dc=example,dc=com' -s base`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`changetype: add\nobjectClass: organizationalUnit\ndescription: People\n' \| ldapmodify -H 'ldaps://localhost' -x`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Added entry ou=people,dc=example,dc=com")
+			},
+		},
+		{
+			Name: "ExistingEntryUnchanged",
+			Args: map[string]interface{}{
+				"dn":          "uid=jdoe,ou=people,dc=example,dc=com",
+				"objectClass": "inetOrgPerson",
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^ldapsearch `, &testhelper.CommandResponse{Stdout: jdoeLDIF})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "DeleteInCheckMode",
+			Args: map[string]interface{}{
+				"dn":    "uid=jdoe,ou=people,dc=example,dc=com",
+				"state": "absent",
+			},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^ldapsearch `, &testhelper.CommandResponse{Stdout: jdoeLDIF})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would delete entry uid=jdoe,ou=people,dc=example,dc=com")
+			},
+		},
+		{
+			Name: "SearchFailure",
+			Args: map[string]interface{}{
+				"dn":    "uid=jdoe,ou=people,dc=example,dc=com",
+				"state": "absent",
+			},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^ldapsearch `, &testhelper.CommandResponse{ExitCode: 49, Stderr: "Invalid credentials (49)"})
+			},
+		},
+	})
+}
+
+func TestLDAPAttrsModule(t *testing.T) {
+	module := NewLDAPAttrsModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"dn": "uid=jdoe,dc=example,dc=com", "attributes": map[string]interface{}{"mail": "a@b"}}, ExpectValid: true},
+		{Name: "MissingAttributes", Args: map[string]interface{}{"dn": "uid=jdoe,dc=example,dc=com"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"dn": "uid=jdoe,dc=example,dc=com", "attributes": map[string]interface{}{"mail": "a@b"}, "state": "merged"}, ExpectValid: false},
+	})
+
+	search := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommandPattern(`^ldapsearch `, &testhelper.CommandResponse{Stdout: jdoeLDIF})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "PresentAddsOnlyMissingValues",
+			Args: map[string]interface{}{
+				"dn":         "uid=jdoe,ou=people,dc=example,dc=com",
+				"attributes": map[string]interface{}{"mail": []interface{}{"jdoe@example.com", "jane@example.com"}},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				search(h)
+				h.GetConnection().ExpectCommandPattern(`changetype: modify\nadd: mail\nmail: jane@example.com\n-\n' \| ldapmodify `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				modlist := result.Data["modlist"].([]string)
+				if len(modlist) != 1 || modlist[0] != "add mail: jane@example.com" {
+					t.Errorf("unexpected modlist %v", modlist)
+				}
+			},
+		},
+		{
+			Name: "PresentIsIdempotentAcrossAttributeCase",
+			Args: map[string]interface{}{
+				"dn":         "uid=jdoe,ou=people,dc=example,dc=com",
+				"attributes": map[string]interface{}{"loginshell": "/bin/bash", "objectClass": "posixAccount"},
+			},
+			Setup: search,
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "ExactReplacesDifferingValues",
+			Args: map[string]interface{}{
+				"dn":         "uid=jdoe,ou=people,dc=example,dc=com",
+				"attributes": map[string]interface{}{"loginShell": "/bin/zsh", "uid": "jdoe"},
+				"state":      "exact",
+			},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				search(h)
+				h.GetConnection().ExpectCommandPattern(`replace: loginShell\nloginShell: /bin/zsh\n-\n'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDiffPresent(result)
+				if !strings.Contains(result.Diff.After, "loginshell: /bin/zsh") || strings.Contains(result.Diff.After, "/bin/bash") {
+					t.Errorf("unexpected diff after:\n%s", result.Diff.After)
+				}
+			},
+		},
+		{
+			Name: "AbsentRemovesPresentValuesInCheckMode",
+			Args: map[string]interface{}{
+				"dn":         "uid=jdoe,ou=people,dc=example,dc=com",
+				"attributes": map[string]interface{}{"mail": "jdoe@example.com", "title": "CTO"},
+				"state":      "absent",
+			},
+			CheckMode: true,
+			Setup:     search,
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertCheckModeSimulated(result)
+				if !strings.Contains(result.Data["ldif"].(string), "delete: mail\nmail: jdoe@example.com\n-\n") {
+					t.Errorf("unexpected ldif %q", result.Data["ldif"])
+				}
+			},
+		},
+		{
+			Name: "MissingEntry",
+			Args: map[string]interface{}{
+				"dn":         "uid=ghost,ou=people,dc=example,dc=com",
+				"attributes": map[string]interface{}{"mail": "ghost@example.com"},
+			},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^ldapsearch `, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
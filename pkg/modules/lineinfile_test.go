@@ -478,6 +478,7 @@ func testLineInFileDiffMode(t *testing.T, helper *testhelper.ModuleTestHelper) {
 		{
 			Name:     "AddLineDiffMode",
 			DiffMode: true,
+			Snapshot: "testdata/lineinfile_add_line_diff.golden.json",
 			Args: map[string]interface{}{
 				"path": "/tmp/test.conf",
 				"line": "new_setting=value",
@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// localePattern matches locale names such as en_US.UTF-8 or sr_RS@latin
+var localePattern = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+// normalizeLocale returns a locale name as locale -a lists it, with the
+// charset lowercased and without dashes, e.g. en_US.utf8 for en_US.UTF-8
+func normalizeLocale(name string) string {
+	base, modifier, _ := strings.Cut(name, "@")
+	if lang, charset, ok := strings.Cut(base, "."); ok {
+		base = lang + "." + strings.ReplaceAll(strings.ToLower(charset), "-", "")
+	}
+	if modifier != "" {
+		base += "@" + modifier
+	}
+	return base
+}
+
+// LocaleGenModule generates or removes locales
+type LocaleGenModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewLocaleGenModule creates a new locale_gen module instance
+func NewLocaleGenModule() *LocaleGenModule {
+	doc := types.ModuleDoc{
+		Name:        "locale_gen",
+		Description: "Generate or remove a locale, through /etc/locale.gen and locale-gen on Debian family hosts or localedef elsewhere",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Locale name as listed in /usr/share/i18n/SUPPORTED, e.g. en_US.UTF-8",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the locale is available",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Make sure the German UTF-8 locale is available\n  locale_gen:\n    name: de_DE.UTF-8",
+			"- name: Remove an unused locale\n  locale_gen:\n    name: fr_FR.ISO-8859-1\n    state: absent",
+		},
+		Returns: map[string]string{
+			"name": "The locale",
+		},
+	}
+
+	base := NewBaseModule("locale_gen", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &LocaleGenModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *LocaleGenModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if name := m.GetStringArg(args, "name", ""); !localePattern.MatchString(name) {
+		return types.NewValidationError("name", name, "invalid locale name")
+	}
+	return m.ValidateChoices(args, "state", []string{"present", "absent"})
+}
+
+// Run executes the locale_gen module
+func (m *LocaleGenModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+
+	cmd := fmt.Sprintf(`echo "supported=$(awk -v n=%s '$1 == n { print $2; exit }' /usr/share/i18n/SUPPORTED 2>/dev/null)"; `+
+		`if [ -f /etc/locale.gen ]; then echo gen=yes; fi; locale -a 2>/dev/null | sed 's/^/available=/'`, m.cli.shellEscape(name))
+	output, err := m.cli.run(ctx, conn, "listing the locales", cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	available, charset, localeGen := false, "", false
+	for _, line := range strings.Split(types.ConvertToString(output.Data["stdout"]), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "available":
+			available = available || normalizeLocale(value) == normalizeLocale(name)
+		case "supported":
+			charset = value
+		case "gen":
+			localeGen = true
+		}
+	}
+
+	change := ""
+	var cmds []string
+	entry := fmt.Sprintf("%s %s", name, charset)
+	switch {
+	case state == "present" && !available:
+		if charset == "" {
+			return nil, fmt.Errorf("%s is not a supported locale", name)
+		}
+		change = "generated the locale " + name
+		if localeGen {
+			cmds = append(cmds, fmt.Sprintf("sed -i 's/^# *\\(%s\\)$/\\1/' /etc/locale.gen", regexp.QuoteMeta(entry)),
+				fmt.Sprintf("(grep -qx %s /etc/locale.gen || echo %s >> /etc/locale.gen)", m.cli.shellEscape(entry), m.cli.shellEscape(entry)),
+				"locale-gen")
+		} else {
+			// localedef reads the locale source without the charset, as in sr_RS@latin
+			base, modifier, _ := strings.Cut(name, "@")
+			input, _, _ := strings.Cut(base, ".")
+			if modifier != "" {
+				input += "@" + modifier
+			}
+			cmds = append(cmds, fmt.Sprintf("localedef -i %s -f %s %s", m.cli.shellEscape(input), m.cli.shellEscape(charset), m.cli.shellEscape(name)))
+		}
+	case state == "absent" && available:
+		change = "removed the locale " + name
+		if localeGen {
+			cmds = append(cmds, fmt.Sprintf("sed -i 's/^\\(%s \\)/# \\1/' /etc/locale.gen", regexp.QuoteMeta(name)), "locale-gen")
+		} else {
+			cmds = append(cmds, "localedef --delete-from-archive "+m.cli.shellEscape(normalizeLocale(name)))
+		}
+	}
+
+	if change != "" && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "changing the locale "+name, strings.Join(cmds, " && ")); err != nil {
+			return nil, err
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Locale %s is already in desired state", name), map[string]interface{}{
+		"name": name,
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		fmt.Sprintf("%s: %s\n", name, localeState(available)), fmt.Sprintf("%s: %s\n", name, localeState(state == "present")), startTime), nil
+}
+
+// localeState describes whether a locale is available in a diff
+func localeState(available bool) string {
+	if available {
+		return "present"
+	}
+	return "absent"
+}
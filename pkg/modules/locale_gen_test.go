@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := map[string]string{
+		"en_US.UTF-8":       "en_US.utf8",
+		"de_DE.ISO-8859-1":  "de_DE.iso88591",
+		"sr_RS.UTF-8@latin": "sr_RS.utf8@latin",
+		"C":                 "C",
+	}
+	for name, expected := range tests {
+		if got := normalizeLocale(name); got != expected {
+			t.Errorf("normalizeLocale(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestLocaleGenModule(t *testing.T) {
+	module := NewLocaleGenModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "en_US.UTF-8"}, ExpectValid: true},
+		{Name: "Modifier", Args: map[string]interface{}{"name": "sr_RS@latin", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "en_US UTF-8"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "en_US.UTF-8", "state": "latest"}, ExpectValid: false},
+	})
+
+	locales := func(h *testhelper.ModuleTestHelper, stdout string) {
+		h.GetConnection().ExpectCommandPattern(`^echo "supported=\$\(awk -v n='`, &testhelper.CommandResponse{Stdout: stdout})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "LocaleGen",
+			Args:     map[string]interface{}{"name": "de_DE.UTF-8"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=UTF-8\ngen=yes\navailable=C\navailable=C.utf8\navailable=en_US.utf8\n")
+				h.GetConnection().ExpectCommand(`sed -i 's/^# *\(de_DE\.UTF-8 UTF-8\)$/\1/' /etc/locale.gen && `+
+					`(grep -qx 'de_DE.UTF-8 UTF-8' /etc/locale.gen || echo 'de_DE.UTF-8 UTF-8' >> /etc/locale.gen) && locale-gen`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Generated the locale de_DE.UTF-8")
+				h.AssertDiffAfter(result, "de_DE.UTF-8: present\n")
+			},
+		},
+		{
+			Name: "Localedef",
+			Args: map[string]interface{}{"name": "sr_RS.UTF-8@latin"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=UTF-8\navailable=C.utf8\n")
+				h.GetConnection().ExpectCommand("localedef -i 'sr_RS@latin' -f 'UTF-8' 'sr_RS.UTF-8@latin'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "AlreadyAvailable",
+			Args: map[string]interface{}{"name": "en_US.UTF-8"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=UTF-8\ngen=yes\navailable=en_US.utf8\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"name": "en_US.UTF-8", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=UTF-8\ngen=yes\navailable=en_US.utf8\n")
+				h.GetConnection().ExpectCommand(`sed -i 's/^\(en_US\.UTF-8 \)/# \1/' /etc/locale.gen && locale-gen`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed the locale en_US.UTF-8")
+			},
+		},
+		{
+			Name: "AlreadyAbsent",
+			Args: map[string]interface{}{"name": "fr_FR.UTF-8", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=UTF-8\navailable=C.utf8\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "Unsupported",
+			Args:        map[string]interface{}{"name": "xx_XX.UTF-8"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				locales(h, "supported=\ngen=yes\navailable=C.utf8\n")
+			},
+		},
+	})
+}
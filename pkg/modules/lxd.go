@@ -0,0 +1,507 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// lxdInstance is the part of an LXD instance the lxd_container module
+// manages, as returned by lxc query /1.0/instances/<name>
+type lxdInstance struct {
+	Status   string                       `json:"status"`
+	Type     string                       `json:"type"`
+	Profiles []string                     `json:"profiles"`
+	Config   map[string]string            `json:"config"`
+	Devices  map[string]map[string]string `json:"devices"`
+}
+
+// describe renders the instance for diffs
+func (i *lxdInstance) describe(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n  status: %s\n  profiles: %s\n", name, i.Type, i.Status, strings.Join(i.Profiles, ", "))
+	for _, key := range sortedKeys(i.Config) {
+		fmt.Fprintf(&b, "  config %s=%s\n", key, i.Config[key])
+	}
+	for _, device := range sortedKeys(lxdDeviceNames(i.Devices)) {
+		b.WriteString(formatStorageProperties("  device "+device, i.Devices[device]))
+	}
+	return b.String()
+}
+
+// lxdDeviceNames indexes devices by name so sortedKeys can order them
+func lxdDeviceNames(devices map[string]map[string]string) map[string]string {
+	names := make(map[string]string, len(devices))
+	for name := range devices {
+		names[name] = name
+	}
+	return names
+}
+
+// lxdDevicesArg converts the devices argument to device configurations
+func lxdDevicesArg(value interface{}) map[string]map[string]string {
+	devices := make(map[string]map[string]string)
+	m, _ := value.(map[string]interface{})
+	for name, device := range m {
+		devices[name] = zfsPropertiesArg(device)
+	}
+	return devices
+}
+
+// LXDContainerModule manages LXD containers and virtual machines with the
+// lxc client on the target host
+type LXDContainerModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewLXDContainerModule creates a new lxd_container module instance
+func NewLXDContainerModule() *LXDContainerModule {
+	doc := types.ModuleDoc{
+		Name:        "lxd_container",
+		Description: "Manage LXD containers and virtual machines: launch them from images, assign profiles, configure devices and run provisioning commands",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Instance name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Desired instance state",
+				Required:    false,
+				Type:        "string",
+				Default:     "started",
+				Choices:     []string{"started", "stopped", "restarted", "frozen", "absent"},
+			},
+			"image": {
+				Description: "Image to create the instance from, e.g. images:debian/12 or ubuntu:24.04. Required when the instance does not exist",
+				Required:    false,
+				Type:        "string",
+			},
+			"type": {
+				Description: "Instance type, used when the instance is created",
+				Required:    false,
+				Type:        "string",
+				Default:     "container",
+				Choices:     []string{"container", "virtual-machine"},
+			},
+			"profiles": {
+				Description: "Profiles to apply, in order. Instances keep the default profile when omitted",
+				Required:    false,
+				Type:        "list",
+			},
+			"config": {
+				Description: "Instance configuration keys, e.g. limits.cpu: 2. Keys not listed are left alone",
+				Required:    false,
+				Type:        "dict",
+			},
+			"devices": {
+				Description: "Devices by name, each a dict with a type and its options. Devices not listed are left alone",
+				Required:    false,
+				Type:        "dict",
+			},
+			"exec": {
+				Description: "Shell commands to run inside the instance once, after it is created and started",
+				Required:    false,
+				Type:        "list",
+			},
+			"wait_for_ipv4_addresses": {
+				Description: "Wait until the instance has a global IPv4 address and return its addresses",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"timeout": {
+				Description: "Seconds to wait for addresses",
+				Required:    false,
+				Type:        "int",
+				Default:     30,
+			},
+			"force_stop": {
+				Description: "Kill the instance instead of shutting it down cleanly when stopping it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"project": {
+				Description: "LXD project the instance belongs to",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Launch a test container\n  lxd_container:\n    name: web1\n    image: images:debian/12\n    profiles: [default, bridged]\n    config:\n      limits.cpu: \"2\"\n      limits.memory: 2GiB\n    devices:\n      data:\n        type: disk\n        source: /srv/web1\n        path: /srv\n    exec:\n      - apt-get update && apt-get install -y python3 openssh-server\n    wait_for_ipv4_addresses: true\n  register: web1",
+			"- name: Remove the container\n  lxd_container:\n    name: web1\n    state: absent",
+		},
+		Returns: map[string]string{
+			"name":      "Instance name",
+			"status":    "Instance status after the run",
+			"addresses": "IPv4 addresses by interface, when wait_for_ipv4_addresses is set",
+		},
+	}
+
+	base := NewBaseModule("lxd_container", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "linux",
+	})
+
+	return &LXDContainerModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *LXDContainerModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "name", "") == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"started", "stopped", "restarted", "frozen", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "type", []string{"container", "virtual-machine"}); err != nil {
+		return err
+	}
+	if config, ok := args["config"]; ok {
+		if _, ok := config.(map[string]interface{}); !ok {
+			return types.NewValidationError("config", config, "config must be a dict")
+		}
+	}
+	if devices, ok := args["devices"]; ok {
+		entries, ok := devices.(map[string]interface{})
+		if !ok {
+			return types.NewValidationError("devices", devices, "devices must be a dict")
+		}
+		for name, device := range entries {
+			options, ok := device.(map[string]interface{})
+			if !ok || types.ConvertToString(options["type"]) == "" {
+				return types.NewValidationError("devices", device, fmt.Sprintf("device %s must be a dict with a type", name))
+			}
+		}
+	}
+	if timeout, err := m.GetIntArg(args, "timeout", 30); err != nil || timeout < 0 {
+		return types.NewValidationError("timeout", args["timeout"], "timeout must be a non-negative number of seconds")
+	}
+	return nil
+}
+
+// lxc builds an lxc command line, escaping the arguments of the subcommand
+func (m *LXDContainerModule) lxc(project, subcommand string, args ...string) string {
+	parts := []string{"lxc"}
+	if project != "" {
+		parts = append(parts, "--project", m.cli.shellEscape(project))
+	}
+	parts = append(parts, subcommand)
+	for _, arg := range args {
+		parts = append(parts, m.cli.shellEscape(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// query runs lxc query against an instance endpoint
+func (m *LXDContainerModule) query(project, name, suffix string) string {
+	endpoint := "/1.0/instances/" + name + suffix
+	if project != "" {
+		endpoint += "?project=" + project
+	}
+	return m.lxc("", "query", endpoint)
+}
+
+// Run executes the lxd_container module
+func (m *LXDContainerModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "started")
+	project := m.GetStringArg(args, "project", "")
+
+	output, exists, err := m.cli.inspect(ctx, conn, "instance "+name, m.lxc(project, "info", name), m.query(project, name, ""))
+	if err != nil {
+		return nil, err
+	}
+	current := &lxdInstance{}
+	if exists {
+		if err := json.Unmarshal([]byte(output), current); err != nil {
+			return nil, fmt.Errorf("failed to parse instance %s: %w", name, err)
+		}
+	}
+
+	var steps, changes []string
+	before, after := "", ""
+	if exists {
+		before = current.describe(name)
+	}
+	desired := &lxdInstance{
+		Status:   current.Status,
+		Type:     current.Type,
+		Profiles: current.Profiles,
+		Config:   mergeProperties(current.Config, nil),
+		Devices:  make(map[string]map[string]string),
+	}
+	for device, options := range current.Devices {
+		desired.Devices[device] = options
+	}
+
+	created := false
+	execFrom := -1
+	if state == "absent" {
+		if exists {
+			steps = append(steps, m.lxc(project, "delete --force", name))
+			changes = append(changes, "deleted instance "+name)
+		}
+	} else {
+		if !exists {
+			image := m.GetStringArg(args, "image", "")
+			if image == "" {
+				return nil, types.NewValidationError("image", nil, fmt.Sprintf("image is required to create instance %s", name))
+			}
+			desired.Type = m.GetStringArg(args, "type", "container")
+			desired.Status = "Stopped"
+			created = true
+		}
+
+		m.reconcile(args, project, name, created, desired, &steps, &changes)
+
+		if created {
+			changes = append([]string{fmt.Sprintf("created %s %s from %s", desired.Type, name, m.GetStringArg(args, "image", ""))}, changes...)
+		}
+		m.transition(args, project, name, state, desired, &steps, &changes)
+
+		if created && desired.Status == "Running" {
+			execFrom = len(steps)
+			for _, command := range stringList(args["exec"]) {
+				steps = append(steps, m.lxc(project, "exec", name)+" -- sh -c "+m.cli.shellEscape(command))
+			}
+			if commands := len(stringList(args["exec"])); commands > 0 {
+				changes = append(changes, fmt.Sprintf("ran %d commands in %s", commands, name))
+			}
+		}
+		after = desired.describe(name)
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Instance %s is already in desired state", name), map[string]interface{}{
+		"name":   name,
+		"status": desired.Status,
+	})
+	if state == "absent" {
+		result.Data["status"] = "Absent"
+	}
+
+	wait := m.GetBoolArg(args, "wait_for_ipv4_addresses", false) && state != "absent" && desired.Status == "Running" && !checkMode
+	var addresses map[string]interface{}
+
+	change := strings.Join(changes, ", ")
+	if change != "" && !checkMode {
+		for i, step := range steps {
+			// Provisioning commands usually need the network, so wait for
+			// an address before running them
+			if i == execFrom && wait {
+				if addresses, err = m.waitForAddresses(ctx, conn, project, name, args); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := m.cli.run(ctx, conn, "lxc", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if wait && addresses == nil {
+		if addresses, err = m.waitForAddresses(ctx, conn, project, name, args); err != nil {
+			return nil, err
+		}
+	}
+	if addresses != nil {
+		result.Data["addresses"] = addresses
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// reconcile adds the steps bringing the profiles, config and devices of the
+// instance to the desired ones. New instances get them at creation.
+func (m *LXDContainerModule) reconcile(args map[string]interface{}, project, name string, created bool, desired *lxdInstance, steps, changes *[]string) {
+	_, setProfiles := args["profiles"]
+	profiles := stringList(args["profiles"])
+	config := zfsPropertiesArg(args["config"])
+	devices := lxdDevicesArg(args["devices"])
+
+	if created {
+		subcommand := "init"
+		if desired.Type == "virtual-machine" {
+			subcommand += " --vm"
+		}
+		if setProfiles && len(profiles) == 0 {
+			subcommand += " --no-profiles"
+		}
+		init := m.lxc(project, subcommand, m.GetStringArg(args, "image", ""), name)
+		for _, profile := range profiles {
+			init += " --profile " + m.cli.shellEscape(profile)
+		}
+		for _, key := range sortedKeys(config) {
+			init += " --config " + m.cli.shellEscape(key+"="+config[key])
+		}
+		*steps = append(*steps, init)
+		desired.Profiles = profiles
+		desired.Config = config
+		for _, device := range sortedKeys(lxdDeviceNames(devices)) {
+			*steps = append(*steps, m.deviceAdd(project, name, device, devices[device]))
+			desired.Devices[device] = devices[device]
+		}
+		return
+	}
+
+	if setProfiles && !reflect.DeepEqual(profiles, desired.Profiles) && !(len(profiles) == 0 && len(desired.Profiles) == 0) {
+		*steps = append(*steps, m.lxc(project, "profile assign", name, strings.Join(profiles, ",")))
+		*changes = append(*changes, "assigned profiles "+strings.Join(profiles, ", "))
+		desired.Profiles = profiles
+	}
+
+	changedConfig := zfsChangedProperties(desired.Config, config)
+	for _, key := range sortedKeys(changedConfig) {
+		*steps = append(*steps, m.lxc(project, "config set", name, key+"="+changedConfig[key]))
+	}
+	if len(changedConfig) > 0 {
+		*changes = append(*changes, "set config "+strings.Join(sortedKeys(changedConfig), ", "))
+		desired.Config = mergeProperties(desired.Config, changedConfig)
+	}
+
+	var changedDevices []string
+	for _, device := range sortedKeys(lxdDeviceNames(devices)) {
+		want := devices[device]
+		have, ok := desired.Devices[device]
+		switch {
+		case !ok:
+			*steps = append(*steps, m.deviceAdd(project, name, device, want))
+		case have["type"] != want["type"]:
+			*steps = append(*steps, m.lxc(project, "config device remove", name, device), m.deviceAdd(project, name, device, want))
+		default:
+			changed := zfsChangedProperties(have, want)
+			if len(changed) == 0 {
+				continue
+			}
+			for _, key := range sortedKeys(changed) {
+				*steps = append(*steps, m.lxc(project, "config device set", name, device, key+"="+changed[key]))
+			}
+			want = mergeProperties(have, changed)
+		}
+		desired.Devices[device] = want
+		changedDevices = append(changedDevices, device)
+	}
+	if len(changedDevices) > 0 {
+		*changes = append(*changes, "configured devices "+strings.Join(changedDevices, ", "))
+	}
+}
+
+// deviceAdd builds the command adding a device with its options
+func (m *LXDContainerModule) deviceAdd(project, name, device string, options map[string]string) string {
+	add := []string{name, device, options["type"]}
+	for _, key := range sortedKeys(options) {
+		if key != "type" {
+			add = append(add, key+"="+options[key])
+		}
+	}
+	return m.lxc(project, "config device add", add...)
+}
+
+// transition adds the steps moving the instance to the desired run state
+func (m *LXDContainerModule) transition(args map[string]interface{}, project, name, state string, desired *lxdInstance, steps, changes *[]string) {
+	stop := "stop"
+	if m.GetBoolArg(args, "force_stop", false) {
+		stop += " --force"
+	}
+
+	status := desired.Status
+	switch {
+	case state == "started" && status == "Frozen":
+		*steps = append(*steps, m.lxc(project, "start", name))
+		*changes = append(*changes, "resumed "+name)
+	case state == "started" && status != "Running":
+		*steps = append(*steps, m.lxc(project, "start", name))
+		*changes = append(*changes, "started "+name)
+	case state == "stopped" && status != "Stopped":
+		*steps = append(*steps, m.lxc(project, stop, name))
+		*changes = append(*changes, "stopped "+name)
+	case state == "restarted" && status == "Running":
+		*steps = append(*steps, m.lxc(project, "restart", name))
+		*changes = append(*changes, "restarted "+name)
+	case state == "restarted":
+		*steps = append(*steps, m.lxc(project, "start", name))
+		*changes = append(*changes, "started "+name)
+	case state == "frozen" && status == "Stopped":
+		*steps = append(*steps, m.lxc(project, "start", name), m.lxc(project, "pause", name))
+		*changes = append(*changes, "started and froze "+name)
+	case state == "frozen" && status == "Running":
+		*steps = append(*steps, m.lxc(project, "pause", name))
+		*changes = append(*changes, "froze "+name)
+	}
+
+	switch state {
+	case "started", "restarted":
+		desired.Status = "Running"
+	case "stopped":
+		desired.Status = "Stopped"
+	case "frozen":
+		desired.Status = "Frozen"
+	}
+}
+
+// waitForAddresses polls the instance state until an interface other than
+// loopback has a global IPv4 address, returning the addresses by interface
+func (m *LXDContainerModule) waitForAddresses(ctx context.Context, conn types.Connection, project, name string, args map[string]interface{}) (map[string]interface{}, error) {
+	timeout, _ := m.GetIntArg(args, "timeout", 30)
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	for {
+		result, err := m.cli.run(ctx, conn, "reading the state of "+name, m.query(project, name, "/state"))
+		if err != nil {
+			return nil, err
+		}
+
+		var state struct {
+			Network map[string]struct {
+				Addresses []struct {
+					Family  string `json:"family"`
+					Address string `json:"address"`
+					Scope   string `json:"scope"`
+				} `json:"addresses"`
+			} `json:"network"`
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		if err := json.Unmarshal([]byte(stdout), &state); err != nil {
+			return nil, fmt.Errorf("failed to parse the state of %s: %w", name, err)
+		}
+
+		addresses := make(map[string]interface{})
+		for iface, network := range state.Network {
+			var ipv4 []string
+			for _, address := range network.Addresses {
+				if address.Family == "inet" && address.Scope == "global" {
+					ipv4 = append(ipv4, address.Address)
+				}
+			}
+			if iface != "lo" && len(ipv4) > 0 {
+				addresses[iface] = ipv4
+			}
+		}
+		if len(addresses) > 0 {
+			return addresses, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("instance %s has no IPv4 address after %d seconds", name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
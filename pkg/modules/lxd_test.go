@@ -0,0 +1,119 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestLXDContainerModule(t *testing.T) {
+	module := NewLXDContainerModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const running = `{"status":"Running","type":"container","profiles":["default"],"config":{"limits.cpu":"2","volatile.uuid":"x"},"devices":{"data":{"type":"disk","source":"/srv/web1","path":"/srv"}}}`
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "web1", "image": "images:debian/12"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"image": "images:debian/12"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "web1", "state": "paused"}, ExpectValid: false},
+		{Name: "InvalidType", Args: map[string]interface{}{"name": "web1", "type": "vm"}, ExpectValid: false},
+		{Name: "DeviceWithoutType", Args: map[string]interface{}{"name": "web1", "devices": map[string]interface{}{"data": map[string]interface{}{"path": "/srv"}}}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Launch",
+			Args: map[string]interface{}{
+				"name":                    "web1",
+				"image":                   "images:debian/12",
+				"profiles":                []interface{}{"default", "bridged"},
+				"config":                  map[string]interface{}{"limits.cpu": 2},
+				"devices":                 map[string]interface{}{"data": map[string]interface{}{"type": "disk", "source": "/srv/web1", "path": "/srv"}},
+				"exec":                    []interface{}{"apt-get install -y python3"},
+				"wait_for_ipv4_addresses": true,
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`(?s)^if lxc info 'web1' >/dev/null 2>&1; then .*; lxc query '/1.0/instances/web1'; fi$`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`lxc init 'images:debian/12' 'web1' --profile 'default' --profile 'bridged' --config 'limits.cpu=2'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`lxc config device add 'web1' 'data' 'disk' 'path=/srv' 'source=/srv/web1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`lxc start 'web1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`lxc query '/1.0/instances/web1/state'`, &testhelper.CommandResponse{
+					Stdout: `{"network":{"lo":{"addresses":[{"family":"inet","address":"127.0.0.1","scope":"local"}]},"eth0":{"addresses":[{"family":"inet","address":"10.10.0.5","scope":"global"},{"family":"inet6","address":"fd42::5","scope":"global"}]}}}`,
+				})
+				conn.ExpectCommand(`lxc exec 'web1' -- sh -c 'apt-get install -y python3'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created container web1 from images:debian/12, started web1, ran 1 commands in web1")
+				h.AssertDataValue(result, "status", "Running")
+				addresses := result.Data["addresses"].(map[string]interface{})
+				if ips, _ := addresses["eth0"].([]string); len(addresses) != 1 || len(ips) != 1 || ips[0] != "10.10.0.5" {
+					t.Errorf("expected the global IPv4 address of eth0, got %v", addresses)
+				}
+			},
+		},
+		{
+			Name: "Unchanged",
+			Args: map[string]interface{}{
+				"name":    "web1",
+				"config":  map[string]interface{}{"limits.cpu": "2"},
+				"devices": map[string]interface{}{"data": map[string]interface{}{"type": "disk", "source": "/srv/web1", "path": "/srv"}},
+				"exec":    []interface{}{"apt-get install -y python3"},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if lxc info 'web1'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "ReconfigureInCheckMode",
+			Args:      map[string]interface{}{"name": "web1", "profiles": []interface{}{"default", "bridged"}, "config": map[string]interface{}{"limits.cpu": "4"}, "devices": map[string]interface{}{"data": map[string]interface{}{"type": "disk", "source": "/srv/web1", "path": "/data"}}},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if lxc info 'web1'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would have assigned profiles default, bridged, set config limits.cpu, configured devices data")
+			},
+		},
+		{
+			Name: "StopAndFreeze",
+			Args: map[string]interface{}{"name": "web1", "state": "stopped", "force_stop": true, "project": "ci"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if lxc --project 'ci' info 'web1' .*lxc query '/1.0/instances/web1\?project=ci'; fi$`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				h.GetConnection().ExpectCommand(`lxc --project 'ci' stop --force 'web1'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "status", "Stopped")
+			},
+		},
+		{
+			Name: "Delete",
+			Args: map[string]interface{}{"name": "web1", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if lxc info 'web1'`, &testhelper.CommandResponse{Stdout: existsMarker + running})
+				h.GetConnection().ExpectCommand(`lxc delete --force 'web1'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Deleted instance web1")
+			},
+		},
+		{
+			Name:        "MissingImage",
+			Args:        map[string]interface{}{"name": "web2"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if lxc info 'web2'`, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
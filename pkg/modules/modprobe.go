@@ -0,0 +1,225 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ModprobeModule loads and unloads kernel modules and manages their
+// persistence across reboots
+type ModprobeModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewModprobeModule creates a new modprobe module instance
+func NewModprobeModule() *ModprobeModule {
+	doc := types.ModuleDoc{
+		Name:        "modprobe",
+		Description: "Load or unload kernel modules, optionally persisting them in /etc/modules-load.d and their parameters in /etc/modprobe.d",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Kernel module name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the module should be loaded",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"params": {
+				Description: "Module parameters, as a string such as \"max_loop=64\" or a dict",
+				Required:    false,
+				Type:        "raw",
+			},
+			"persistent": {
+				Description: "present loads the module at boot with its parameters, absent removes the boot configuration, disabled leaves it alone",
+				Required:    false,
+				Type:        "string",
+				Default:     "disabled",
+				Choices:     []string{"present", "absent", "disabled"},
+			},
+		},
+		Examples: []string{
+			"- name: Load br_netfilter at boot\n  modprobe:\n    name: br_netfilter\n    persistent: present",
+			"- name: Configure the loop module\n  modprobe:\n    name: loop\n    params:\n      max_loop: 64\n    persistent: present",
+		},
+		Returns: map[string]string{
+			"name":   "Kernel module name",
+			"loaded": "Whether the module is loaded",
+			"params": "Module parameters",
+		},
+	}
+
+	base := NewBaseModule("modprobe", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &ModprobeModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ModprobeModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if strings.ContainsAny(name, "/ \t\n") {
+		return types.NewValidationError("name", name, "invalid kernel module name")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "persistent", []string{"present", "absent", "disabled"}); err != nil {
+		return err
+	}
+	if params, ok := args["params"]; ok {
+		switch params.(type) {
+		case string, map[string]interface{}:
+		default:
+			return types.NewValidationError("params", params, "params must be a string or a dict")
+		}
+	}
+	return nil
+}
+
+// modprobeParams renders the params argument as space separated key=value
+// pairs, sorting dict keys so the options file is stable
+func modprobeParams(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.Join(strings.Fields(v), " ")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + "=" + types.ConvertToString(v[key])
+		}
+		return strings.Join(pairs, " ")
+	}
+	return ""
+}
+
+// Run executes the modprobe module
+func (m *ModprobeModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	persistent := m.GetStringArg(args, "persistent", "disabled")
+	params := modprobeParams(args["params"])
+
+	loaded, err := m.loaded(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes, steps []string
+	var before, after strings.Builder
+	fmt.Fprintf(&before, "loaded=%t\n", loaded)
+
+	nowLoaded := loaded
+	switch {
+	case state == "present" && !loaded:
+		cmd := "modprobe " + m.cli.shellEscape(name)
+		for _, param := range strings.Fields(params) {
+			cmd += " " + m.cli.shellEscape(param)
+		}
+		steps = append(steps, cmd)
+		changes = append(changes, "loaded "+name)
+		nowLoaded = true
+	case state == "absent" && loaded:
+		steps = append(steps, "modprobe -r "+m.cli.shellEscape(name))
+		changes = append(changes, "unloaded "+name)
+		nowLoaded = false
+	}
+	fmt.Fprintf(&after, "loaded=%t\n", nowLoaded)
+
+	if persistent != "disabled" {
+		loadContent, optionsContent := "", ""
+		if persistent == "present" {
+			loadContent = name + "\n"
+			if params != "" {
+				optionsContent = fmt.Sprintf("options %s %s\n", name, params)
+			}
+		}
+
+		for _, file := range []struct{ path, content string }{
+			{"/etc/modules-load.d/" + name + ".conf", loadContent},
+			{"/etc/modprobe.d/" + name + ".conf", optionsContent},
+		} {
+			current, exists, err := m.cli.inspect(ctx, conn, file.path, "[ -f "+m.cli.shellEscape(file.path)+" ]", "cat "+m.cli.shellEscape(file.path))
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&before, "%s:\n%s", file.path, current)
+			fmt.Fprintf(&after, "%s:\n%s", file.path, file.content)
+
+			switch {
+			case file.content == "" && exists:
+				steps = append(steps, "rm -f "+m.cli.shellEscape(file.path))
+				changes = append(changes, "removed "+file.path)
+			case file.content != "" && current != file.content:
+				steps = append(steps, fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s",
+					m.cli.shellEscape(parentDir(file.path)), m.cli.shellEscape(file.content), m.cli.shellEscape(file.path)))
+				if exists {
+					changes = append(changes, "updated "+file.path)
+				} else {
+					changes = append(changes, "created "+file.path)
+				}
+			}
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Kernel module %s is already in desired state", name), map[string]interface{}{
+		"name":   name,
+		"loaded": nowLoaded,
+		"params": params,
+	})
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "modprobe", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// loaded reports whether the module is loaded or built into the kernel.
+// Module names use underscores in /proc/modules, while built-in modules are
+// listed by their file name, which may use dashes.
+func (m *ModprobeModule) loaded(ctx context.Context, conn types.Connection, name string) (bool, error) {
+	underscored := strings.ReplaceAll(name, "-", "_")
+	dashed := strings.ReplaceAll(name, "_", "-")
+	cmd := fmt.Sprintf("if awk -v m=%s '$1 == m {f=1} END {exit !f}' /proc/modules || grep -qE %s /lib/modules/$(uname -r)/modules.builtin 2>/dev/null; then echo loaded; fi",
+		m.cli.shellEscape(underscored), m.cli.shellEscape(fmt.Sprintf("/(%s|%s)\\.ko$", underscored, dashed)))
+
+	result, err := m.cli.run(ctx, conn, "checking kernel module "+name, cmd)
+	if err != nil {
+		return false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return strings.TrimSpace(stdout) == "loaded", nil
+}
@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestModprobeParams(t *testing.T) {
+	if got := modprobeParams("  max_loop=64   max_part=8 "); got != "max_loop=64 max_part=8" {
+		t.Errorf("unexpected string params %q", got)
+	}
+	if got := modprobeParams(map[string]interface{}{"max_part": 8, "max_loop": 64}); got != "max_loop=64 max_part=8" {
+		t.Errorf("unexpected dict params %q", got)
+	}
+}
+
+func TestModprobeModule(t *testing.T) {
+	module := NewModprobeModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "br_netfilter", "persistent": "present"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "../evil"}, ExpectValid: false},
+		{Name: "InvalidPersistent", Args: map[string]interface{}{"name": "loop", "persistent": "yes"}, ExpectValid: false},
+		{Name: "InvalidParams", Args: map[string]interface{}{"name": "loop", "params": []interface{}{"max_loop=64"}}, ExpectValid: false},
+	})
+
+	notLoaded := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommandPattern(`^if awk -v m='loop' `, &testhelper.CommandResponse{})
+	}
+	loaded := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommandPattern(`^if awk -v m='loop' `, &testhelper.CommandResponse{Stdout: "loaded\n"})
+	}
+	file := func(path, content string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			stdout := ""
+			if content != "" {
+				stdout = existsMarker + content
+			}
+			h.GetConnection().ExpectCommandPattern(`^if \[ -f '`+path+`' \]`, &testhelper.CommandResponse{Stdout: stdout})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "LoadWithParams",
+			Args: map[string]interface{}{"name": "loop", "params": "max_loop=64"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				notLoaded(h)
+				h.GetConnection().ExpectCommandPattern(`^modprobe 'loop' 'max_loop=64'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Loaded loop")
+			},
+		},
+		{
+			Name:  "AlreadyLoaded",
+			Args:  map[string]interface{}{"name": "loop"},
+			Setup: loaded,
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "Persist",
+			Args: map[string]interface{}{"name": "loop", "params": map[string]interface{}{"max_loop": 64}, "persistent": "present"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				loaded(h)
+				file("/etc/modules-load.d/loop.conf", "loop\n")(h)
+				file("/etc/modprobe.d/loop.conf", "")(h)
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/modprobe.d' && printf '%s' 'options loop max_loop=64\n' > '/etc/modprobe.d/loop.conf'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created /etc/modprobe.d/loop.conf")
+			},
+		},
+		{
+			Name:      "UnloadAndForgetInCheckMode",
+			Args:      map[string]interface{}{"name": "loop", "state": "absent", "persistent": "absent"},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				loaded(h)
+				file("/etc/modules-load.d/loop.conf", "loop\n")(h)
+				file("/etc/modprobe.d/loop.conf", "options loop max_loop=64\n")(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would have unloaded loop, removed /etc/modules-load.d/loop.conf, removed /etc/modprobe.d/loop.conf")
+			},
+		},
+		{
+			Name:        "UnloadBusyModule",
+			Args:        map[string]interface{}{"name": "loop", "state": "absent"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				loaded(h)
+				h.GetConnection().ExpectCommandPattern(`^modprobe -r 'loop'$`, &testhelper.CommandResponse{ExitCode: 1, Stderr: "modprobe: FATAL: Module loop is in use."})
+			},
+		},
+	})
+}
@@ -95,6 +95,16 @@ func TestCommandModuleValidation(t *testing.T) {
 			args:    map[string]interface{}{"cmd": "echo hello", "timeout": -5},
 			wantErr: true,
 		},
+		{
+			name:    "argv",
+			args:    map[string]interface{}{"argv": []interface{}{"echo", "hello"}},
+			wantErr: false,
+		},
+		{
+			name:    "cmd and argv",
+			args:    map[string]interface{}{"cmd": "echo hello", "argv": []interface{}{"echo", "hello"}},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -3,6 +3,8 @@ package modules
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,7 +13,8 @@ import (
 
 // MountModule manages filesystem mounts
 type MountModule struct {
-	BaseModule
+	*BaseModule
+	cli remoteCLI
 }
 
 // MountEntry represents a mount point entry
@@ -24,70 +27,233 @@ type MountEntry struct {
 	Pass       int    `json:"pass"`
 }
 
-// NewMountModule creates a new mount module instance
-func NewMountModule() *MountModule {
-	return &MountModule{
-		BaseModule: BaseModule{},
+// fstabEscaper escapes the characters fstab and /proc/mounts encode as
+// octal sequences
+var fstabEscaper = strings.NewReplacer(`\`, `\134`, " ", `\040`, "\t", `\011`, "\n", `\012`)
+
+var fstabUnescaper = strings.NewReplacer(`\134`, `\`, `\040`, " ", `\011`, "\t", `\012`, "\n")
+
+// parseMountEntry parses an fstab or /proc/mounts line, returning nil for
+// comments and blank lines
+func parseMountEntry(line string) *MountEntry {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	entry := &MountEntry{
+		Device:     fstabUnescaper.Replace(fields[0]),
+		MountPoint: fstabUnescaper.Replace(fields[1]),
+		Options:    "defaults",
+	}
+	if len(fields) > 2 {
+		entry.FSType = fields[2]
+	}
+	if len(fields) > 3 {
+		entry.Options = fields[3]
+	}
+	if len(fields) > 4 {
+		entry.Dump, _ = strconv.Atoi(fields[4])
+	}
+	if len(fields) > 5 {
+		entry.Pass, _ = strconv.Atoi(fields[5])
 	}
+	return entry
 }
 
-// Name returns the module name
-func (m *MountModule) Name() string {
-	return "mount"
+// String renders the entry as an fstab line
+func (e *MountEntry) String() string {
+	return fmt.Sprintf("%s %s %s %s %d %d", fstabEscaper.Replace(e.Device), fstabEscaper.Replace(e.MountPoint), e.FSType, e.Options, e.Dump, e.Pass)
 }
 
-// Capabilities returns the module capabilities
-func (m *MountModule) Capabilities() *types.ModuleCapability {
-	return &types.ModuleCapability{
+// Equal reports whether two entries describe the same mount, ignoring
+// the order of options and the implied defaults option
+func (e *MountEntry) Equal(other *MountEntry) bool {
+	return e.Device == other.Device && e.MountPoint == other.MountPoint && e.FSType == other.FSType &&
+		e.Dump == other.Dump && e.Pass == other.Pass && mountOptionSet(e.Options) == mountOptionSet(other.Options)
+}
+
+// mountOptionSet normalizes mount options for comparison
+func mountOptionSet(options string) string {
+	var set []string
+	for _, option := range strings.Split(options, ",") {
+		if option = strings.TrimSpace(option); option != "" && option != "defaults" {
+			set = append(set, option)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+// NewMountModule creates a new mount module instance
+func NewMountModule() *MountModule {
+	doc := types.ModuleDoc{
+		Name:        "mount",
+		Description: "Manage fstab entries and live mounts, comparing entries field by field with options in any order",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Mount point",
+				Required:    true,
+				Type:        "path",
+			},
+			"src": {
+				Description: "Device, UUID=, LABEL= or remote filesystem to mount; required for mounted and present",
+				Required:    false,
+				Type:        "string",
+			},
+			"fstype": {
+				Description: "Filesystem type; required for mounted and present",
+				Required:    false,
+				Type:        "string",
+			},
+			"opts": {
+				Description: "Comma separated mount options",
+				Required:    false,
+				Type:        "string",
+				Default:     "defaults",
+			},
+			"dump": {
+				Description: "fstab dump field",
+				Required:    false,
+				Type:        "int",
+				Default:     0,
+			},
+			"pass": {
+				Description: "fstab fsck pass field",
+				Required:    false,
+				Type:        "int",
+				Default:     0,
+			},
+			"state": {
+				Description: "mounted and present manage the fstab entry, mounted also mounts it; unmounted unmounts keeping the entry, absent also removes it; remounted remounts a mounted filesystem",
+				Required:    false,
+				Type:        "string",
+				Default:     "mounted",
+				Choices:     []string{"mounted", "present", "unmounted", "absent", "remounted"},
+			},
+			"boot": {
+				Description: "Mount the filesystem at boot; false adds the noauto option",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"fstab": {
+				Description: "File holding the entries",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/fstab",
+			},
+			"backup": {
+				Description: "Keep a timestamped copy of the fstab before changing it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Mount the data volume\n  mount:\n    path: /srv/data\n    src: UUID=4a1f7a2e-5d36-4b0e-9d8b-2f8e1c6b9a10\n    fstype: xfs\n    opts: noatime,nodev\n    state: mounted",
+			"- name: Keep the NFS share out of boot\n  mount:\n    path: /mnt/archive\n    src: nas.example.com:/export/archive\n    fstype: nfs\n    boot: false\n    state: present\n    backup: true",
+		},
+		Returns: map[string]string{
+			"path":        "Mount point",
+			"state":       "Requested state",
+			"entry":       "fstab line of the mount point after the run",
+			"mounted":     "Whether the mount point is mounted after the run",
+			"backup_file": "Copy of the fstab made before changing it",
+		},
+	}
+
+	base := NewBaseModule("mount", doc)
+	base.SetCapabilities(&types.ModuleCapability{
 		CheckMode:    true,
 		DiffMode:     true,
 		Platform:     "linux",
 		RequiresRoot: true,
-	}
+	})
+
+	return &MountModule{BaseModule: base}
 }
 
 // Validate validates the module arguments
 func (m *MountModule) Validate(args map[string]interface{}) error {
-	// Required parameters
-	path := m.GetStringArg(args, "path", "")
-	if path == "" {
-		return types.NewValidationError("path", nil, "path is required")
+	if err := m.ValidateRequired(args, []string{"path"}); err != nil {
+		return err
 	}
-
-	// State validation
-	state := m.GetStringArg(args, "state", "mounted")
-	validStates := []string{"mounted", "present", "unmounted", "absent", "remounted"}
-	if !m.isValidChoice(state, validStates) {
-		return types.NewValidationError("state", state, fmt.Sprintf("state must be one of: %v", validStates))
+	if err := m.ValidateChoices(args, "state", []string{"mounted", "present", "unmounted", "absent", "remounted"}); err != nil {
+		return err
 	}
 
-	// For mounted/present states, src is required
+	state := m.GetStringArg(args, "state", "mounted")
 	if state == "mounted" || state == "present" {
-		src := m.GetStringArg(args, "src", "")
-		if src == "" {
+		if m.GetStringArg(args, "src", "") == "" {
 			return types.NewValidationError("src", nil, "src is required when state is mounted or present")
 		}
-		
-		fstype := m.GetStringArg(args, "fstype", "")
-		if fstype == "" && state == "mounted" {
-			return types.NewValidationError("fstype", nil, "fstype is required when state is mounted")
+		if m.GetStringArg(args, "fstype", "") == "" {
+			return types.NewValidationError("fstype", nil, "fstype is required when state is mounted or present")
 		}
 	}
 
-	// Validate dump and pass values
-	dump, _ := m.GetIntArg(args, "dump", 0)
-	if dump < 0 || dump > 1 {
+	if dump, _ := m.GetIntArg(args, "dump", 0); dump < 0 || dump > 1 {
 		return types.NewValidationError("dump", dump, "dump must be 0 or 1")
 	}
-
-	pass, _ := m.GetIntArg(args, "pass", 0)
-	if pass < 0 || pass > 2 {
+	if pass, _ := m.GetIntArg(args, "pass", 0); pass < 0 || pass > 2 {
 		return types.NewValidationError("pass", pass, "pass must be 0, 1, or 2")
 	}
-
 	return nil
 }
 
+// desired builds the fstab entry the task asks for
+func (m *MountModule) desired(args map[string]interface{}) *MountEntry {
+	entry := &MountEntry{
+		Device:     m.GetStringArg(args, "src", ""),
+		MountPoint: m.mountPath(args),
+		FSType:     m.GetStringArg(args, "fstype", ""),
+		Options:    m.GetStringArg(args, "opts", "defaults"),
+	}
+	entry.Dump, _ = m.GetIntArg(args, "dump", 0)
+	entry.Pass, _ = m.GetIntArg(args, "pass", 0)
+	if !m.GetBoolArg(args, "boot", true) && !strings.Contains(","+entry.Options+",", ",noauto,") {
+		entry.Options += ",noauto"
+	}
+	return entry
+}
+
+// mountPath returns the path argument without trailing slashes, the form
+// used by fstab and /proc/mounts
+func (m *MountModule) mountPath(args map[string]interface{}) string {
+	path := m.GetStringArg(args, "path", "")
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		return trimmed
+	}
+	return path
+}
+
+// readFstab reads the lines of the fstab, which may not exist yet
+func (m *MountModule) readFstab(ctx context.Context, conn types.Connection, fstab string) ([]string, error) {
+	quoted := m.cli.shellEscape(fstab)
+	content, _, err := m.cli.inspect(ctx, conn, fstab, "[ -f "+quoted+" ]", "cat "+quoted)
+	if err != nil || content == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), nil
+}
+
+// mounted returns the live mount at path, or nil when nothing is mounted
+// there. The last mount stacked on a mount point is the visible one.
+func (m *MountModule) mounted(ctx context.Context, conn types.Connection, path string) (*MountEntry, error) {
+	result, err := m.cli.run(ctx, conn, "reading the mount table", "cat /proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	var live *MountEntry
+	for _, line := range strings.Split(stdout, "\n") {
+		if entry := parseMountEntry(line); entry != nil && entry.MountPoint == path {
+			live = entry
+		}
+	}
+	return live, nil
+}
+
 // Run executes the mount module
 func (m *MountModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	startTime := time.Now()
@@ -95,329 +261,138 @@ func (m *MountModule) Run(ctx context.Context, conn types.Connection, args map[s
 	checkMode := m.CheckMode(args)
 	diffMode := m.DiffMode(args)
 
-	// Parse arguments
-	path := m.GetStringArg(args, "path", "")
-	src := m.GetStringArg(args, "src", "")
-	fstype := m.GetStringArg(args, "fstype", "")
-	opts := m.GetStringArg(args, "opts", "defaults")
+	path := m.mountPath(args)
 	state := m.GetStringArg(args, "state", "mounted")
-	dump, _ := m.GetIntArg(args, "dump", 0)
-	pass, _ := m.GetIntArg(args, "pass", 0)
-	backup := m.GetBoolArg(args, "backup", false)
 	fstab := m.GetStringArg(args, "fstab", "/etc/fstab")
+	desired := m.desired(args)
 
-	// Read current fstab
-	currentFstab, err := m.readFstab(ctx, conn, fstab)
+	lines, err := m.readFstab(ctx, conn, fstab)
+	if err != nil {
+		return nil, err
+	}
+	live, err := m.mounted(ctx, conn, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read fstab: %w", err)
+		return nil, err
 	}
 
-	// Store original for diff mode
-	originalFstab := currentFstab
-
-	// Find existing entry
-	existingEntry := m.findMountEntry(currentFstab, path)
-	
-	// Initialize result
-	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
-		"path":  path,
-		"state": state,
-	})
-
-	changed := false
-	var changes []string
-
-	switch state {
-	case "mounted":
-		// Ensure entry is in fstab and mounted
-		if existingEntry == nil {
-			// Add to fstab
-			changed = true
-			changes = append(changes, fmt.Sprintf("Added mount entry for %s", path))
-			
-			if !checkMode {
-				newEntry := fmt.Sprintf("%s %s %s %s %d %d", src, path, fstype, opts, dump, pass)
-				currentFstab = append(currentFstab, newEntry)
-				
-				if backup {
-					if err := m.backupFile(ctx, conn, fstab); err != nil {
-						return nil, fmt.Errorf("failed to backup fstab: %w", err)
-					}
-				}
-				
-				if err := m.writeFstab(ctx, conn, fstab, currentFstab); err != nil {
-					return nil, fmt.Errorf("failed to write fstab: %w", err)
-				}
-			}
-		} else {
-			// Check if entry needs updating
-			expectedEntry := fmt.Sprintf("%s %s %s %s %d %d", src, path, fstype, opts, dump, pass)
-			if *existingEntry != expectedEntry {
-				changed = true
-				changes = append(changes, fmt.Sprintf("Updated mount entry for %s", path))
-				
-				if !checkMode {
-					// Update the entry
-					for i, line := range currentFstab {
-						if strings.Contains(line, path) {
-							currentFstab[i] = expectedEntry
-							break
-						}
-					}
-					
-					if backup {
-						if err := m.backupFile(ctx, conn, fstab); err != nil {
-							return nil, fmt.Errorf("failed to backup fstab: %w", err)
-						}
-					}
-					
-					if err := m.writeFstab(ctx, conn, fstab, currentFstab); err != nil {
-						return nil, fmt.Errorf("failed to write fstab: %w", err)
-					}
-				}
-			}
-		}
-
-		// Check if currently mounted
-		isMounted, err := m.isMounted(ctx, conn, path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check mount status: %w", err)
+	// Only the first entry of a mount point is managed, later duplicates
+	// are dropped when it changes
+	updated := make([]string, 0, len(lines)+1)
+	var current *MountEntry
+	for _, line := range lines {
+		entry := parseMountEntry(line)
+		if entry == nil || entry.MountPoint != path {
+			updated = append(updated, line)
+			continue
 		}
-
-		if !isMounted {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Mounted %s", path))
-			
-			if !checkMode {
-				if err := m.mount(ctx, conn, src, path, fstype, opts); err != nil {
-					return nil, fmt.Errorf("failed to mount: %w", err)
+		if current == nil {
+			current = entry
+			switch state {
+			case "mounted", "present":
+				if entry.Equal(desired) {
+					updated = append(updated, line)
+				} else {
+					updated = append(updated, desired.String())
 				}
+			case "absent":
+			default:
+				updated = append(updated, line)
 			}
 		}
+	}
+	if current == nil && (state == "mounted" || state == "present") {
+		updated = append(updated, desired.String())
+	}
 
-	case "present":
-		// Ensure entry is in fstab but don't mount
-		if existingEntry == nil {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Added mount entry for %s", path))
-			
-			if !checkMode {
-				newEntry := fmt.Sprintf("%s %s %s %s %d %d", src, path, fstype, opts, dump, pass)
-				currentFstab = append(currentFstab, newEntry)
-				
-				if backup {
-					if err := m.backupFile(ctx, conn, fstab); err != nil {
-						return nil, fmt.Errorf("failed to backup fstab: %w", err)
-					}
-				}
-				
-				if err := m.writeFstab(ctx, conn, fstab, currentFstab); err != nil {
-					return nil, fmt.Errorf("failed to write fstab: %w", err)
-				}
-			}
+	data := map[string]interface{}{"path": path, "state": state, "mounted": live != nil}
+	var changes []string
+	fstabChanged := strings.Join(lines, "\n") != strings.Join(updated, "\n")
+	if fstabChanged {
+		switch {
+		case state == "absent":
+			changes = append(changes, fmt.Sprintf("removed the %s entry for %s", fstab, path))
+		case current == nil:
+			changes = append(changes, fmt.Sprintf("added a %s entry for %s", fstab, path))
+		default:
+			changes = append(changes, fmt.Sprintf("updated the %s entry for %s", fstab, path))
 		}
+	}
 
-	case "unmounted":
-		// Keep in fstab but unmount
-		isMounted, err := m.isMounted(ctx, conn, path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check mount status: %w", err)
+	var mountCmd string
+	quotedPath := m.cli.shellEscape(path)
+	mountNew := fmt.Sprintf("mkdir -p %s && mount -t %s -o %s %s %s", quotedPath, m.cli.shellEscape(desired.FSType), m.cli.shellEscape(desired.Options), m.cli.shellEscape(desired.Device), quotedPath)
+	switch state {
+	case "mounted":
+		switch {
+		case live == nil:
+			mountCmd = mountNew
+			changes = append(changes, "mounted "+path)
+		case live.Device != desired.Device || live.FSType != desired.FSType:
+			// A different filesystem is mounted there, an option change is
+			// not enough
+			mountCmd = "umount " + quotedPath + " && " + mountNew
+			changes = append(changes, "remounted "+path+" from "+desired.Device)
+		case fstabChanged:
+			mountCmd = fmt.Sprintf("mount -o %s %s", m.cli.shellEscape("remount,"+desired.Options), quotedPath)
+			changes = append(changes, "remounted "+path)
 		}
-
-		if isMounted {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Unmounted %s", path))
-			
-			if !checkMode {
-				if err := m.unmount(ctx, conn, path); err != nil {
-					return nil, fmt.Errorf("failed to unmount: %w", err)
-				}
-			}
+	case "unmounted", "absent":
+		if live != nil {
+			mountCmd = "umount " + quotedPath
+			changes = append(changes, "unmounted "+path)
 		}
-
-	case "absent":
-		// Remove from fstab and unmount
-		if existingEntry != nil {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Removed mount entry for %s", path))
-			
-			if !checkMode {
-				// Remove the entry
-				newFstab := []string{}
-				for _, line := range currentFstab {
-					if !strings.Contains(line, path) {
-						newFstab = append(newFstab, line)
-					}
-				}
-				currentFstab = newFstab
-				
-				if backup {
-					if err := m.backupFile(ctx, conn, fstab); err != nil {
-						return nil, fmt.Errorf("failed to backup fstab: %w", err)
-					}
-				}
-				
-				if err := m.writeFstab(ctx, conn, fstab, currentFstab); err != nil {
-					return nil, fmt.Errorf("failed to write fstab: %w", err)
-				}
+	case "remounted":
+		if live != nil {
+			options := "remount"
+			if opts := m.GetStringArg(args, "opts", ""); opts != "" {
+				options += "," + opts
 			}
+			mountCmd = fmt.Sprintf("mount -o %s %s", m.cli.shellEscape(options), quotedPath)
+			changes = append(changes, "remounted "+path)
 		}
+	}
 
-		// Unmount if mounted
-		isMounted, err := m.isMounted(ctx, conn, path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check mount status: %w", err)
-		}
-
-		if isMounted {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Unmounted %s", path))
-			
-			if !checkMode {
-				if err := m.unmount(ctx, conn, path); err != nil {
-					return nil, fmt.Errorf("failed to unmount: %w", err)
+	if !checkMode {
+		if fstabChanged {
+			quoted := m.cli.shellEscape(fstab)
+			if m.GetBoolArg(args, "backup", false) && lines != nil {
+				backupFile := fmt.Sprintf("%s.backup.%d", fstab, startTime.Unix())
+				if _, err := m.cli.run(ctx, conn, "backing up "+fstab, fmt.Sprintf("cp -p %s %s", quoted, m.cli.shellEscape(backupFile))); err != nil {
+					return nil, err
 				}
+				data["backup_file"] = backupFile
+			}
+			content := strings.Join(updated, "\n") + "\n"
+			write := fmt.Sprintf(`tmp=$(mktemp %s.XXXXXX) && printf '%%s' %s > "$tmp" && { chmod --reference=%s "$tmp" 2>/dev/null || chmod 644 "$tmp"; } && mv -f "$tmp" %s`,
+				quoted, m.cli.shellEscape(content), quoted, quoted)
+			if _, err := m.cli.run(ctx, conn, "writing "+fstab, write); err != nil {
+				return nil, err
 			}
 		}
-
-	case "remounted":
-		// Remount the filesystem
-		isMounted, err := m.isMounted(ctx, conn, path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check mount status: %w", err)
-		}
-
-		if isMounted {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Remounted %s", path))
-			
-			if !checkMode {
-				if err := m.remount(ctx, conn, path, opts); err != nil {
-					return nil, fmt.Errorf("failed to remount: %w", err)
-				}
+		if mountCmd != "" {
+			if _, err := m.cli.run(ctx, conn, "changing the mount of "+path, mountCmd); err != nil {
+				return nil, err
 			}
+			data["mounted"] = state == "mounted" || state == "remounted"
 		}
 	}
-
-	result.Changed = changed
-	
-	if changed {
-		result.Message = strings.Join(changes, ", ")
-		
-		if diffMode && (state == "mounted" || state == "present" || state == "absent") {
-			result.Diff = m.GenerateDiff(
-				strings.Join(originalFstab, "\n"),
-				strings.Join(currentFstab, "\n"),
-			)
+	for _, line := range updated {
+		if entry := parseMountEntry(line); entry != nil && entry.MountPoint == path {
+			data["entry"] = line
+			break
 		}
-	} else {
-		result.Message = "Mount point is already in desired state"
 	}
 
-	// Set timing information
-	result.StartTime = startTime
-	result.EndTime = time.Now()
-	result.Duration = result.EndTime.Sub(result.StartTime)
-
-	return result, nil
-}
-
-// Helper methods
-
-func (m *MountModule) readFstab(ctx context.Context, conn types.Connection, fstab string) ([]string, error) {
-	result, err := conn.Execute(ctx, fmt.Sprintf("cat %s", fstab), types.ExecuteOptions{})
-	if err != nil {
-		if result != nil && result.Error != nil && strings.Contains(result.Error.Error(), "No such file") {
-			// fstab doesn't exist, return empty
-			return []string{}, nil
-		}
-		return nil, err
-	}
-	
-	var lines []string
-	if stdout, ok := result.Data["stdout"].(string); ok {
-		lines = strings.Split(strings.TrimSpace(stdout), "\n")
-	}
-	return lines, nil
-}
-
-func (m *MountModule) writeFstab(ctx context.Context, conn types.Connection, fstab string, lines []string) error {
-	content := strings.Join(lines, "\n") + "\n"
-	tmpFile := fmt.Sprintf("%s.tmp.%d", fstab, time.Now().Unix())
-	
-	// Write to temp file
-	cmd := fmt.Sprintf("echo '%s' > %s", strings.ReplaceAll(content, "'", "'\\''"), tmpFile)
-	if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-		return err
-	}
-	
-	// Move temp file to final location
-	if _, err := conn.Execute(ctx, fmt.Sprintf("mv %s %s", tmpFile, fstab), types.ExecuteOptions{}); err != nil {
-		return err
-	}
-	
-	return nil
-}
-
-func (m *MountModule) findMountEntry(lines []string, path string) *string {
-	for _, line := range lines {
-		// Skip comments and empty lines
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && fields[1] == path {
-			return &line
+	before, after := "", ""
+	if fstabChanged {
+		before, after = strings.Join(lines, "\n"), strings.Join(updated, "\n")
+		if before != "" {
+			before += "\n"
 		}
-	}
-	return nil
-}
-
-func (m *MountModule) isMounted(ctx context.Context, conn types.Connection, path string) (bool, error) {
-	result, err := conn.Execute(ctx, fmt.Sprintf("mount | grep ' %s '", path), types.ExecuteOptions{})
-	if err != nil {
-		// grep returns exit code 1 when no match found
-		if result != nil && !result.Success {
-			return false, nil
+		if after != "" {
+			after += "\n"
 		}
-		return false, err
 	}
-	if stdout, ok := result.Data["stdout"].(string); ok {
-		return stdout != "", nil
-	}
-	return false, nil
-}
-
-func (m *MountModule) mount(ctx context.Context, conn types.Connection, src, path, fstype, opts string) error {
-	cmd := fmt.Sprintf("mount -t %s -o %s %s %s", fstype, opts, src, path)
-	_, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
-	return err
+	result := m.CreateSuccessResult(hostname, false, "Mount point is already in desired state", data)
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before, after, startTime), nil
 }
-
-func (m *MountModule) unmount(ctx context.Context, conn types.Connection, path string) error {
-	_, err := conn.Execute(ctx, fmt.Sprintf("umount %s", path), types.ExecuteOptions{})
-	return err
-}
-
-func (m *MountModule) remount(ctx context.Context, conn types.Connection, path, opts string) error {
-	cmd := fmt.Sprintf("mount -o remount,%s %s", opts, path)
-	_, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
-	return err
-}
-
-func (m *MountModule) backupFile(ctx context.Context, conn types.Connection, file string) error {
-	backupFile := fmt.Sprintf("%s.backup.%d", file, time.Now().Unix())
-	_, err := conn.Execute(ctx, fmt.Sprintf("cp %s %s", file, backupFile), types.ExecuteOptions{})
-	return err
-}
-
-func (m *MountModule) isValidChoice(value string, choices []string) bool {
-	for _, choice := range choices {
-		if value == choice {
-			return true
-		}
-	}
-	return false
-}
\ No newline at end of file
@@ -1,7 +1,11 @@
 package modules
 
 import (
+	"strings"
 	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
 )
 
 func TestMountModule(t *testing.T) {
@@ -89,4 +93,137 @@ func TestMountModule(t *testing.T) {
 			})
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestMountEntryEqual(t *testing.T) {
+	entry := parseMountEntry(`/dev/sdb1  /mnt/my\040data  ext4  noatime,nodev  0 2`)
+	if entry.MountPoint != "/mnt/my data" {
+		t.Fatalf("unexpected mount point %q", entry.MountPoint)
+	}
+	if entry.String() != `/dev/sdb1 /mnt/my\040data ext4 noatime,nodev 0 2` {
+		t.Errorf("unexpected line %q", entry.String())
+	}
+	reordered := &MountEntry{Device: "/dev/sdb1", MountPoint: "/mnt/my data", FSType: "ext4", Options: "defaults,nodev,noatime", Pass: 2}
+	if !entry.Equal(reordered) {
+		t.Error("expected option order and defaults to be ignored")
+	}
+	reordered.Options = "nodev"
+	if entry.Equal(reordered) {
+		t.Error("expected a dropped option to differ")
+	}
+	if parseMountEntry("  # /dev/sdc1 /mnt/old ext4 defaults 0 0") != nil {
+		t.Error("expected comments to be skipped")
+	}
+}
+
+func TestMountModuleRun(t *testing.T) {
+	module := NewMountModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const fstab = "# static file system information\nUUID=1234 / ext4 defaults 0 1\n"
+	args := func(extra map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{"path": "/mnt/data/", "src": "/dev/sdb1", "fstype": "ext4", "opts": "noatime,nodev"}
+		for k, v := range extra {
+			result[k] = v
+		}
+		return result
+	}
+	readFstab := `^if \[ -f '/etc/fstab' \] `
+	procMounts := "cat /proc/mounts"
+	proc := "sysfs /sys sysfs rw,nosuid 0 0\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "AddAndMount",
+			Args:     args(nil),
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{Stdout: existsMarker + fstab})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc})
+				h.GetConnection().ExpectCommandPattern(`(?s)^tmp=\$\(mktemp '/etc/fstab'\.XXXXXX\) && printf '%s' '.*/dev/sdb1 /mnt/data ext4 noatime,nodev 0 0\n' > "\$tmp" .* mv -f "\$tmp" '/etc/fstab'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`mkdir -p '/mnt/data' && mount -t 'ext4' -o 'noatime,nodev' '/dev/sdb1' '/mnt/data'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Added a /etc/fstab entry for /mnt/data, mounted /mnt/data")
+				h.AssertDataValue(result, "entry", "/dev/sdb1 /mnt/data ext4 noatime,nodev 0 0")
+				h.AssertDataValue(result, "mounted", true)
+				h.AssertDiffBefore(result, fstab)
+				h.AssertDiffAfter(result, fstab+"/dev/sdb1 /mnt/data ext4 noatime,nodev 0 0\n")
+			},
+		},
+		{
+			Name: "ReorderedOptionsUnchanged",
+			Args: args(nil),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{Stdout: existsMarker + fstab + "/dev/sdb1\t/mnt/data\text4\tnodev,noatime\t0\t0\n"})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc + "/dev/sdb1 /mnt/data ext4 rw,nodev,noatime 0 0\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "mounted", true)
+			},
+		},
+		{
+			Name: "OptionChangeRemounts",
+			Args: args(map[string]interface{}{"backup": true}),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{Stdout: existsMarker + fstab + "/dev/sdb1 /mnt/data ext4 defaults 0 0\n"})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc + "/dev/sdb1 /mnt/data ext4 rw,relatime 0 0\n"})
+				h.GetConnection().ExpectCommandPattern(`^cp -p '/etc/fstab' '/etc/fstab\.backup\.[0-9]+'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`(?s)^tmp=\$\(mktemp `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`mount -o 'remount,noatime,nodev' '/mnt/data'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated the /etc/fstab entry for /mnt/data, remounted /mnt/data")
+				if backup, _ := result.Data["backup_file"].(string); !strings.HasPrefix(backup, "/etc/fstab.backup.") {
+					t.Errorf("unexpected backup_file %v", result.Data["backup_file"])
+				}
+			},
+		},
+		{
+			Name: "AbsentRemovesAndUnmounts",
+			Args: map[string]interface{}{"path": "/mnt/data", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{Stdout: existsMarker + fstab + "/dev/sdb1 /mnt/data ext4 defaults 0 0\n/dev/sdc1 /mnt/data2 ext4 defaults 0 0\n"})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc + "/dev/sdb1 /mnt/data ext4 rw 0 0\n"})
+				h.GetConnection().ExpectCommandPattern(`(?s)^tmp=\$\(mktemp '/etc/fstab'\.XXXXXX\) && printf '%s' '# static file system information\nUUID=1234 / ext4 defaults 0 1\n/dev/sdc1 /mnt/data2 ext4 defaults 0 0\n' `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`umount '/mnt/data'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed the /etc/fstab entry for /mnt/data, unmounted /mnt/data")
+				h.AssertDataValue(result, "mounted", false)
+			},
+		},
+		{
+			Name:      "PresentCheckModeDiff",
+			Args:      args(map[string]interface{}{"state": "present", "boot": false}),
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have added a /etc/fstab entry for /mnt/data")
+				h.AssertDiffBefore(result, "")
+				h.AssertDiffAfter(result, "/dev/sdb1 /mnt/data ext4 noatime,nodev,noauto 0 0\n")
+			},
+		},
+		{
+			Name: "RemountedNotMounted",
+			Args: map[string]interface{}{"path": "/mnt/data", "state": "remounted"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFstab, &testhelper.CommandResponse{Stdout: existsMarker + fstab})
+				h.GetConnection().ExpectCommand(procMounts, &testhelper.CommandResponse{Stdout: proc})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+	})
+}
@@ -0,0 +1,688 @@
+package modules
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// mysqlConnectionParams documents the options shared by the MySQL modules
+var mysqlConnectionParams = map[string]types.ParamDoc{
+	"login_user": {
+		Description: "Account to connect as",
+		Required:    false,
+		Type:        "string",
+		Default:     "root",
+	},
+	"login_password": {
+		Description: "Password of login_user; passed to the client in MYSQL_PWD",
+		Required:    false,
+		Type:        "string",
+	},
+	"login_host": {
+		Description: "Server to connect to over TCP; the local Unix socket is used when omitted",
+		Required:    false,
+		Type:        "string",
+	},
+	"login_port": {
+		Description: "Server port",
+		Required:    false,
+		Type:        "int",
+		Default:     3306,
+	},
+	"login_unix_socket": {
+		Description: "Unix socket, when not the client's default",
+		Required:    false,
+		Type:        "path",
+	},
+	"config_file": {
+		Description: "Option file read in addition to the client's defaults, e.g. /root/.my.cnf",
+		Required:    false,
+		Type:        "path",
+	},
+}
+
+// mysqlClient drives the mysql client on the target host. Without
+// login_password, root usually authenticates over the Unix socket with
+// the auth_socket or unix_socket plugin.
+type mysqlClient struct {
+	remoteCLI
+	user       string
+	password   string
+	host       string
+	port       int
+	socket     string
+	configFile string
+}
+
+// newMySQLClient reads the connection options from module arguments
+func newMySQLClient(m *BaseModule, args map[string]interface{}) (*mysqlClient, error) {
+	port, err := m.GetIntArg(args, "login_port", 3306)
+	if err != nil {
+		return nil, types.NewValidationError("login_port", args["login_port"], "login_port must be an integer")
+	}
+	return &mysqlClient{
+		user:       m.GetStringArg(args, "login_user", "root"),
+		password:   m.GetStringArg(args, "login_password", ""),
+		host:       m.GetStringArg(args, "login_host", ""),
+		port:       port,
+		socket:     m.GetStringArg(args, "login_unix_socket", ""),
+		configFile: m.GetStringArg(args, "config_file", ""),
+	}, nil
+}
+
+// mysql returns the client invocation with tab separated output and no
+// column names
+func (c *mysqlClient) mysql() string {
+	cmd := "mysql"
+	if c.configFile != "" {
+		// The option file must come first
+		cmd += " --defaults-extra-file=" + c.shellEscape(c.configFile)
+	}
+	cmd += " -N -B"
+	if c.host != "" {
+		cmd += fmt.Sprintf(" -h %s -P %d", c.shellEscape(c.host), c.port)
+	} else if c.socket != "" {
+		cmd += " -S " + c.shellEscape(c.socket)
+	}
+	if c.user != "" {
+		cmd += " -u " + c.shellEscape(c.user)
+	}
+	return cmd
+}
+
+// executeOptions passes the login password in the environment
+func (c *mysqlClient) executeOptions() types.ExecuteOptions {
+	if c.password == "" {
+		return types.ExecuteOptions{}
+	}
+	return types.ExecuteOptions{Env: map[string]string{"MYSQL_PWD": c.password}}
+}
+
+// query runs a read-only statement and returns its rows
+func (c *mysqlClient) query(ctx context.Context, conn types.Connection, sql string) ([][]string, error) {
+	result, err := conn.Execute(ctx, c.mysql()+" -e "+c.shellEscape(sql), c.executeOptions())
+	if err != nil {
+		return nil, fmt.Errorf("mysql failed: %w", err)
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("mysql failed: %s", commandStderr(result))
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	var rows [][]string
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows, nil
+}
+
+// exec runs statements one after another, stopping at the first error
+func (c *mysqlClient) exec(ctx context.Context, conn types.Connection, statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	script := strings.Join(statements, ";\n") + ";\n"
+	result, err := conn.Execute(ctx, fmt.Sprintf("printf '%%s' %s | %s", c.shellEscape(script), c.mysql()), c.executeOptions())
+	if err != nil {
+		return fmt.Errorf("mysql failed: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("mysql failed: %s", commandStderr(result))
+	}
+	return nil
+}
+
+// mysqlIdent quotes a MySQL identifier
+func mysqlIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// mysqlLiteral quotes a MySQL string literal
+func mysqlLiteral(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// MySQLDBModule creates and drops MySQL databases
+type MySQLDBModule struct {
+	*BaseModule
+}
+
+// NewMySQLDBModule creates a new mysql_db module instance
+func NewMySQLDBModule() *MySQLDBModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "Database name",
+			Required:    true,
+			Type:        "string",
+		},
+		"state": {
+			Description: "Whether the database exists",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+		"encoding": {
+			Description: "Default character set, e.g. utf8mb4",
+			Required:    false,
+			Type:        "string",
+		},
+		"collation": {
+			Description: "Default collation, e.g. utf8mb4_unicode_ci",
+			Required:    false,
+			Type:        "string",
+		},
+	}
+	for name, doc := range mysqlConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "mysql_db",
+		Description: "Create, change the character set and collation of, and drop MySQL and MariaDB databases with the mysql client on the target host",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Create the application database\n  mysql_db:\n    name: app\n    encoding: utf8mb4\n    collation: utf8mb4_unicode_ci",
+			"- name: Drop a database over TCP\n  mysql_db:\n    name: scratch\n    state: absent\n    login_host: db.example.com\n    login_user: admin\n    login_password: \"{{ vault_mysql_admin }}\"",
+		},
+		Returns: map[string]string{
+			"name":    "Database name",
+			"queries": "Statements that were run",
+		},
+	}
+
+	base := NewBaseModule("mysql_db", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &MySQLDBModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *MySQLDBModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	_, err := newMySQLClient(m.BaseModule, args)
+	return err
+}
+
+// Run executes the mysql_db module
+func (m *MySQLDBModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	client, err := newMySQLClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	encoding := m.GetStringArg(args, "encoding", "")
+	collation := m.GetStringArg(args, "collation", "")
+
+	rows, err := client.query(ctx, conn, "SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = "+mysqlLiteral(name))
+	if err != nil {
+		return nil, err
+	}
+	exists := len(rows) > 0 && len(rows[0]) == 2
+	current := [2]string{}
+	if exists {
+		current = [2]string{rows[0][0], rows[0][1]}
+	}
+
+	var statements, changes []string
+	desired := current
+	switch {
+	case state == "absent" && exists:
+		statements = append(statements, "DROP DATABASE "+mysqlIdent(name))
+		changes = append(changes, "dropped database "+name)
+	case state == "present":
+		var options []string
+		if encoding != "" && !strings.EqualFold(encoding, current[0]) {
+			options = append(options, "CHARACTER SET "+mysqlIdent(encoding))
+			desired[0] = encoding
+		}
+		if collation != "" && !strings.EqualFold(collation, current[1]) {
+			options = append(options, "COLLATE "+mysqlIdent(collation))
+			desired[1] = collation
+		}
+		if !exists {
+			statements = append(statements, strings.TrimSpace("CREATE DATABASE "+mysqlIdent(name)+" "+strings.Join(options, " ")))
+			changes = append(changes, "created database "+name)
+		} else if len(options) > 0 {
+			statements = append(statements, "ALTER DATABASE "+mysqlIdent(name)+" "+strings.Join(options, " "))
+			changes = append(changes, "changed the defaults of database "+name)
+		}
+	}
+
+	if !checkMode {
+		if err := client.exec(ctx, conn, statements); err != nil {
+			return nil, err
+		}
+	}
+
+	describe := func(present bool, settings [2]string) string {
+		if !present {
+			return ""
+		}
+		out := "database " + name + "\n"
+		if settings[0] != "" {
+			out += "encoding: " + settings[0] + "\n"
+		}
+		if settings[1] != "" {
+			out += "collation: " + settings[1] + "\n"
+		}
+		return out
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "Database is already in desired state", map[string]interface{}{
+		"name":    name,
+		"state":   state,
+		"queries": statements,
+	})
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode,
+		describe(exists, current), describe(state == "present", desired), startTime), nil
+}
+
+// mysqlGrants maps objects such as app.* to the privileges held on them.
+// ALL PRIVILEGES is kept as ALL and WITH GRANT OPTION as GRANT.
+type mysqlGrants map[string]map[string]bool
+
+// add records privileges on an object
+func (g mysqlGrants) add(object string, privs ...string) {
+	object = strings.ReplaceAll(object, "`", "")
+	if g[object] == nil {
+		g[object] = make(map[string]bool)
+	}
+	for _, priv := range privs {
+		priv = strings.ToUpper(strings.Join(strings.Fields(priv), " "))
+		if priv == "ALL PRIVILEGES" {
+			priv = "ALL"
+		}
+		if priv != "" && priv != "USAGE" {
+			g[object][priv] = true
+		}
+	}
+}
+
+// parseMySQLPrivileges parses a priv string such as
+// "app.*:SELECT,INSERT/reports.daily:SELECT"
+func parseMySQLPrivileges(spec string) (mysqlGrants, error) {
+	grants := make(mysqlGrants)
+	for _, entry := range strings.Split(spec, "/") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		object, privs, found := strings.Cut(entry, ":")
+		if !found || !strings.Contains(object, ".") || strings.TrimSpace(privs) == "" {
+			return nil, fmt.Errorf("invalid privilege entry %q, expected db.table:PRIV,PRIV", entry)
+		}
+		grants.add(strings.TrimSpace(object), strings.Split(privs, ",")...)
+	}
+	return grants, nil
+}
+
+// parseMySQLShowGrants reads SHOW GRANTS output, skipping proxy and role
+// grants
+func parseMySQLShowGrants(rows [][]string) mysqlGrants {
+	grants := make(mysqlGrants)
+	for _, row := range rows {
+		line := strings.TrimPrefix(row[0], "GRANT ")
+		privs, rest, found := strings.Cut(line, " ON ")
+		if !found || strings.HasPrefix(privs, "PROXY") {
+			continue
+		}
+		object, _, _ := strings.Cut(rest, " TO ")
+		list := strings.Split(privs, ", ")
+		if strings.HasSuffix(line, " WITH GRANT OPTION") {
+			list = append(list, "GRANT")
+		}
+		grants.add(object, list...)
+	}
+	return grants
+}
+
+// mysqlObject quotes a db.table object for GRANT and REVOKE
+func mysqlObject(object string) string {
+	db, table, _ := strings.Cut(object, ".")
+	quote := func(name string) string {
+		if name == "*" {
+			return name
+		}
+		return mysqlIdent(name)
+	}
+	return quote(db) + "." + quote(table)
+}
+
+// mysqlPasswordMatches reports whether an account's stored authentication
+// string is password. known is false for plugins whose hashes cannot be
+// checked.
+func mysqlPasswordMatches(plugin, stored, password string) (match, known bool) {
+	switch {
+	case stored == "":
+		return password == "", true
+	case stored == password:
+		// The password was given already hashed
+		return true, true
+	case plugin == "mysql_native_password":
+		first := sha1.Sum([]byte(password))
+		second := sha1.Sum(first[:])
+		return stored == "*"+strings.ToUpper(hex.EncodeToString(second[:])), true
+	case plugin == "caching_sha2_password" && strings.HasPrefix(stored, "$A$") && len(stored) == 7+20+43:
+		// $A$<rounds / 1000 in hex>$<20 byte salt><SHA-crypt digest>
+		rounds, err := strconv.ParseInt(stored[3:6], 16, 32)
+		if err != nil {
+			return false, false
+		}
+		salt := []byte(stored[7:27])
+		digest := shaCryptDigest(sha256.New, []byte(password), salt, int(rounds)*1000)
+		return shaCryptEncode("5", digest) == stored[27:], true
+	}
+	return false, false
+}
+
+// MySQLUserModule manages MySQL accounts and their privileges
+type MySQLUserModule struct {
+	*BaseModule
+}
+
+// NewMySQLUserModule creates a new mysql_user module instance
+func NewMySQLUserModule() *MySQLUserModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "User name",
+			Required:    true,
+			Type:        "string",
+		},
+		"host": {
+			Description: "Host part of the account",
+			Required:    false,
+			Type:        "string",
+			Default:     "localhost",
+		},
+		"password": {
+			Description: "Password, in clear text or already hashed for the authentication plugin",
+			Required:    false,
+			Type:        "string",
+		},
+		"update_password": {
+			Description: "always sets the password when it differs from the stored one, on_create only sets it on new accounts",
+			Required:    false,
+			Type:        "string",
+			Default:     "always",
+			Choices:     []string{"always", "on_create"},
+		},
+		"plugin": {
+			Description: "Authentication plugin, e.g. caching_sha2_password or auth_socket; the server default when omitted. Switching plugins without password leaves the account without one",
+			Required:    false,
+			Type:        "string",
+		},
+		"priv": {
+			Description: "Privileges, e.g. app.*:SELECT,INSERT/reports.daily:SELECT; GRANT stands for WITH GRANT OPTION",
+			Required:    false,
+			Type:        "string",
+		},
+		"append_privs": {
+			Description: "Add priv to the account's privileges instead of replacing them",
+			Required:    false,
+			Type:        "bool",
+			Default:     false,
+		},
+		"state": {
+			Description: "Whether the account exists",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range mysqlConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "mysql_user",
+		Description: "Create, change and drop MySQL and MariaDB accounts and grant or revoke their privileges",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Create the application account\n  mysql_user:\n    name: app\n    host: \"10.0.%\"\n    password: \"{{ vault_app_db_password }}\"\n    priv: app.*:SELECT,INSERT,UPDATE,DELETE",
+			"- name: Give the backup account read access everywhere\n  mysql_user:\n    name: backup\n    priv: \"*.*:SELECT,LOCK TABLES,SHOW VIEW,EVENT,TRIGGER\"\n    append_privs: true",
+		},
+		Returns: map[string]string{
+			"name":    "User name",
+			"host":    "Host part of the account",
+			"queries": "Statements that were run, with passwords masked",
+		},
+	}
+
+	base := NewBaseModule("mysql_user", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &MySQLUserModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *MySQLUserModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "update_password", []string{"always", "on_create"}); err != nil {
+		return err
+	}
+	if priv := m.GetStringArg(args, "priv", ""); priv != "" {
+		if _, err := parseMySQLPrivileges(priv); err != nil {
+			return types.NewValidationError("priv", priv, err.Error())
+		}
+	}
+	_, err := newMySQLClient(m.BaseModule, args)
+	return err
+}
+
+// Run executes the mysql_user module
+func (m *MySQLUserModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	client, err := newMySQLClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+	name := m.GetStringArg(args, "name", "")
+	host := m.GetStringArg(args, "host", "localhost")
+	state := m.GetStringArg(args, "state", "present")
+	password := m.GetStringArg(args, "password", "")
+	plugin := m.GetStringArg(args, "plugin", "")
+	account := mysqlLiteral(name) + "@" + mysqlLiteral(host)
+
+	rows, err := client.query(ctx, conn, fmt.Sprintf("SELECT plugin, HEX(authentication_string) FROM mysql.user WHERE User = %s AND Host = %s", mysqlLiteral(name), mysqlLiteral(host)))
+	if err != nil {
+		return nil, err
+	}
+	exists := len(rows) > 0 && len(rows[0]) == 2
+	currentPlugin, stored := "", ""
+	if exists {
+		currentPlugin = rows[0][0]
+		decoded, err := hex.DecodeString(rows[0][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid authentication string of %s@%s: %w", name, host, err)
+		}
+		stored = string(decoded)
+	}
+
+	var statements, changes []string
+	var before, after strings.Builder
+	if exists {
+		fmt.Fprintf(&before, "user %s@%s\nplugin: %s\n", name, host, currentPlugin)
+	}
+
+	if state == "absent" {
+		if exists {
+			statements = append(statements, "DROP USER "+account)
+			changes = append(changes, fmt.Sprintf("dropped user %s@%s", name, host))
+		}
+	} else {
+		newPlugin := currentPlugin
+		if plugin != "" {
+			newPlugin = plugin
+		}
+		fmt.Fprintf(&after, "user %s@%s\n", name, host)
+		if newPlugin != "" {
+			fmt.Fprintf(&after, "plugin: %s\n", newPlugin)
+		}
+
+		identified := ""
+		if plugin != "" && plugin != currentPlugin {
+			identified = " IDENTIFIED WITH " + mysqlIdent(plugin)
+		}
+		if password != "" {
+			setPassword := !exists
+			if exists && m.GetStringArg(args, "update_password", "always") == "always" {
+				match, _ := mysqlPasswordMatches(currentPlugin, stored, password)
+				setPassword = !match || identified != ""
+			}
+			if setPassword {
+				if identified == "" {
+					identified = " IDENTIFIED"
+					if plugin != "" {
+						identified += " WITH " + mysqlIdent(plugin)
+					}
+				}
+				identified += " BY " + mysqlLiteral(password)
+				fmt.Fprintf(&before, "password: ********\n")
+				fmt.Fprintf(&after, "password: ******** (changed)\n")
+			}
+		}
+
+		switch {
+		case !exists:
+			statements = append(statements, "CREATE USER "+account+identified)
+			changes = append(changes, fmt.Sprintf("created user %s@%s", name, host))
+		case identified != "":
+			statements = append(statements, "ALTER USER "+account+identified)
+			changes = append(changes, fmt.Sprintf("changed the credentials of %s@%s", name, host))
+		}
+
+		if priv := m.GetStringArg(args, "priv", ""); priv != "" {
+			desired, _ := parseMySQLPrivileges(priv)
+			current := make(mysqlGrants)
+			if exists {
+				rows, err := client.query(ctx, conn, "SHOW GRANTS FOR "+account)
+				if err != nil {
+					return nil, err
+				}
+				current = parseMySQLShowGrants(rows)
+			}
+			appendPrivs := m.GetBoolArg(args, "append_privs", false)
+
+			objects := make(map[string]bool)
+			for object := range desired {
+				objects[object] = true
+			}
+			if !appendPrivs {
+				for object, privs := range current {
+					objects[object] = objects[object] || len(privs) > 0
+				}
+			}
+
+			for _, object := range setMembers(objects) {
+				have, want := current[object], desired[object]
+				result := make(map[string]bool)
+				var grant, revoke []string
+				for priv := range want {
+					result[priv] = true
+					if !have[priv] {
+						grant = append(grant, priv)
+					}
+				}
+				for priv := range have {
+					if appendPrivs {
+						result[priv] = true
+					} else if !want[priv] {
+						revoke = append(revoke, priv)
+					}
+				}
+				sort.Strings(grant)
+				sort.Strings(revoke)
+				fmt.Fprintf(&before, "grants on %s: %s\n", object, strings.Join(setMembers(have), ", "))
+				fmt.Fprintf(&after, "grants on %s: %s\n", object, strings.Join(setMembers(result), ", "))
+
+				if len(revoke) > 0 {
+					privs := make([]string, len(revoke))
+					for i, priv := range revoke {
+						privs[i] = priv
+						if priv == "GRANT" {
+							privs[i] = "GRANT OPTION"
+						}
+					}
+					statements = append(statements, fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(privs, ", "), mysqlObject(object), account))
+					changes = append(changes, fmt.Sprintf("revoked %s on %s", strings.Join(revoke, ", "), object))
+				}
+				if len(grant) > 0 {
+					var privs []string
+					option := ""
+					for _, priv := range grant {
+						if priv == "GRANT" {
+							option = " WITH GRANT OPTION"
+						} else {
+							privs = append(privs, priv)
+						}
+					}
+					if len(privs) == 0 {
+						privs = []string{"USAGE"}
+					}
+					statements = append(statements, fmt.Sprintf("GRANT %s ON %s TO %s%s", strings.Join(privs, ", "), mysqlObject(object), account, option))
+					changes = append(changes, fmt.Sprintf("granted %s on %s", strings.Join(grant, ", "), object))
+				}
+			}
+		}
+	}
+
+	if !checkMode {
+		if err := client.exec(ctx, conn, statements); err != nil {
+			return nil, err
+		}
+	}
+
+	queries := make([]string, len(statements))
+	for i, statement := range statements {
+		queries[i] = statement
+		if password != "" {
+			queries[i] = strings.ReplaceAll(statement, mysqlLiteral(password), "'********'")
+		}
+	}
+	result := m.CreateSuccessResult(hostname, false, "User is already in desired state", map[string]interface{}{
+		"name":    name,
+		"host":    host,
+		"state":   state,
+		"queries": queries,
+	})
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
@@ -0,0 +1,240 @@
+package modules
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestMySQLClient(t *testing.T) {
+	m := NewMySQLDBModule()
+	client, err := newMySQLClient(m.BaseModule, map[string]interface{}{"login_host": "db.example.com", "login_user": "admin", "login_password": "s3cret", "config_file": "/root/.my.cnf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.mysql(), "mysql --defaults-extra-file='/root/.my.cnf' -N -B -h 'db.example.com' -P 3306 -u 'admin'"; got != want {
+		t.Errorf("mysql() = %q, want %q", got, want)
+	}
+	if got := client.executeOptions().Env["MYSQL_PWD"]; got != "s3cret" {
+		t.Errorf("MYSQL_PWD = %q", got)
+	}
+
+	client, _ = newMySQLClient(m.BaseModule, map[string]interface{}{"login_unix_socket": "/run/mysqld/mysqld.sock"})
+	if got, want := client.mysql(), "mysql -N -B -S '/run/mysqld/mysqld.sock' -u 'root'"; got != want {
+		t.Errorf("mysql() over the socket = %q, want %q", got, want)
+	}
+}
+
+func TestMySQLPasswordMatches(t *testing.T) {
+	caching := "$A$005$abcdefghij01234567897CVYP/PBbda.vppaY/NQbKju8EMMidwIHUR4KtfGIb."
+	tests := []struct {
+		name, plugin, stored, password string
+		match, known                   bool
+	}{
+		{"Native", "mysql_native_password", "*B865CAE8F340F6CE1485A06F4492BB49718DF1EC", "s3cret", true, true},
+		{"NativeMismatch", "mysql_native_password", "*B865CAE8F340F6CE1485A06F4492BB49718DF1EC", "other", false, true},
+		{"CachingSHA2", "caching_sha2_password", caching, "s3cret", true, true},
+		{"CachingSHA2Mismatch", "caching_sha2_password", caching, "other", false, true},
+		{"NoPassword", "caching_sha2_password", "", "s3cret", false, true},
+		{"Unknown", "sha256_password", "$5$opaque", "s3cret", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, known := mysqlPasswordMatches(tt.plugin, tt.stored, tt.password)
+			if match != tt.match || known != tt.known {
+				t.Errorf("mysqlPasswordMatches() = %v, %v, want %v, %v", match, known, tt.match, tt.known)
+			}
+		})
+	}
+}
+
+func TestParseMySQLGrants(t *testing.T) {
+	current := parseMySQLShowGrants([][]string{
+		{"GRANT USAGE ON *.* TO `app`@`localhost`"},
+		{"GRANT SELECT, INSERT ON `app`.* TO `app`@`localhost` WITH GRANT OPTION"},
+		{"GRANT ALL PRIVILEGES ON `reports`.`daily` TO `app`@`localhost`"},
+		{"GRANT PROXY ON ``@`` TO `app`@`localhost`"},
+	})
+	want := mysqlGrants{
+		"*.*":           {},
+		"app.*":         {"SELECT": true, "INSERT": true, "GRANT": true},
+		"reports.daily": {"ALL": true},
+	}
+	if !reflect.DeepEqual(current, want) {
+		t.Errorf("parseMySQLShowGrants() = %v, want %v", current, want)
+	}
+
+	desired, err := parseMySQLPrivileges("app.*:select, insert,GRANT/reports.daily:ALL PRIVILEGES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "*.*")
+	if !reflect.DeepEqual(desired, want) {
+		t.Errorf("parseMySQLPrivileges() = %v, want %v", desired, want)
+	}
+	if _, err := parseMySQLPrivileges("app:SELECT"); err == nil {
+		t.Error("expected an error for an object without a table part")
+	}
+}
+
+func TestMySQLDBModule(t *testing.T) {
+	module := NewMySQLDBModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "app", "encoding": "utf8mb4"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "app", "state": "dumped"}, ExpectValid: false},
+	})
+
+	schema := func(h *testhelper.ModuleTestHelper, row string) {
+		h.GetConnection().ExpectCommandPattern(`^mysql -N -B -u 'root' -e 'SELECT DEFAULT_CHARACTER_SET_NAME`, &testhelper.CommandResponse{Stdout: row})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Create",
+			Args: map[string]interface{}{"name": "app", "encoding": "utf8mb4", "collation": "utf8mb4_unicode_ci"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				schema(h, "")
+				h.GetConnection().ExpectCommand("printf '%s' 'CREATE DATABASE `app` CHARACTER SET `utf8mb4` COLLATE `utf8mb4_unicode_ci`;\n' | mysql -N -B -u 'root'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created database app")
+			},
+		},
+		{
+			Name:     "ChangeCollation",
+			Args:     map[string]interface{}{"name": "app", "encoding": "UTF8MB4", "collation": "utf8mb4_unicode_ci"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				schema(h, "utf8mb4\tutf8mb4_0900_ai_ci\n")
+				h.GetConnection().ExpectCommandPattern("^printf '%s' 'ALTER DATABASE `app` COLLATE `utf8mb4_unicode_ci`;\\n' \\| mysql", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDiffBefore(result, "database app\nencoding: utf8mb4\ncollation: utf8mb4_0900_ai_ci\n")
+				h.AssertDiffAfter(result, "database app\nencoding: utf8mb4\ncollation: utf8mb4_unicode_ci\n")
+			},
+		},
+		{
+			Name: "Drop",
+			Args: map[string]interface{}{"name": "scratch", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				schema(h, "utf8mb4\tutf8mb4_0900_ai_ci\n")
+				h.GetConnection().ExpectCommandPattern("^printf '%s' 'DROP DATABASE `scratch`;\\n'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "AlreadyPresent",
+			Args: map[string]interface{}{"name": "app", "encoding": "utf8mb4"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				schema(h, "utf8mb4\tutf8mb4_0900_ai_ci\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+	})
+}
+
+func TestMySQLUserModule(t *testing.T) {
+	module := NewMySQLUserModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "app", "priv": "app.*:SELECT,INSERT/*.*:PROCESS"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidPriv", Args: map[string]interface{}{"name": "app", "priv": "SELECT"}, ExpectValid: false},
+		{Name: "InvalidUpdatePassword", Args: map[string]interface{}{"name": "app", "update_password": "never"}, ExpectValid: false},
+	})
+
+	account := func(h *testhelper.ModuleTestHelper, plugin, stored string) {
+		stdout := ""
+		if plugin != "" {
+			stdout = plugin + "\t" + hex.EncodeToString([]byte(stored)) + "\n"
+		}
+		h.GetConnection().ExpectCommandPattern(`-e 'SELECT plugin, HEX\(authentication_string\) FROM mysql.user`, &testhelper.CommandResponse{Stdout: stdout})
+	}
+	grants := func(h *testhelper.ModuleTestHelper, lines string) {
+		h.GetConnection().ExpectCommandPattern(`-e 'SHOW GRANTS FOR `, &testhelper.CommandResponse{Stdout: lines})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Create",
+			Args:     map[string]interface{}{"name": "app", "host": "10.0.%", "password": "s3cret", "priv": "app.*:SELECT,INSERT"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				account(h, "", "")
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' 'CREATE USER .*app.*@.*10\.0\.%.* IDENTIFIED BY .*s3cret.*;\nGRANT INSERT, SELECT ON `+"`app`"+`\.\* TO .*;\n' \| mysql`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created user app@10.0.%, granted INSERT, SELECT on app.*")
+				h.AssertDiffAfter(result, "user app@10.0.%\npassword: ******** (changed)\ngrants on app.*: INSERT, SELECT\n")
+				h.GetConnection().AssertPatternCalledTimes(`SHOW GRANTS`, 0)
+				queries, _ := result.Data["queries"].([]string)
+				if len(queries) != 2 || queries[0] != `CREATE USER 'app'@'10.0.%' IDENTIFIED BY '********'` {
+					t.Errorf("unexpected queries %q", queries)
+				}
+			},
+		},
+		{
+			Name: "ReplacePrivileges",
+			Args: map[string]interface{}{"name": "app", "password": "s3cret", "priv": "app.*:SELECT,GRANT"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				account(h, "caching_sha2_password", "$A$005$abcdefghij01234567897CVYP/PBbda.vppaY/NQbKju8EMMidwIHUR4KtfGIb.")
+				grants(h, "GRANT USAGE ON *.* TO `app`@`localhost`\nGRANT SELECT, INSERT ON `app`.* TO `app`@`localhost`\nGRANT SELECT ON `reports`.* TO `app`@`localhost` WITH GRANT OPTION\n")
+				h.GetConnection().ExpectCommandPattern("(?s)^printf '%s' 'REVOKE INSERT ON `app`.\\* FROM .*;\nGRANT USAGE ON `app`.\\* TO .* WITH GRANT OPTION;\nREVOKE GRANT OPTION, SELECT ON `reports`.\\* FROM .*;\n' \\| mysql", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Revoked INSERT on app.*, granted GRANT on app.*, revoked GRANT, SELECT on reports.*")
+			},
+		},
+		{
+			Name: "AppendPrivilegesConverged",
+			Args: map[string]interface{}{"name": "backup", "password": "s3cret", "priv": "*.*:SELECT,LOCK TABLES", "append_privs": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				account(h, "mysql_native_password", "*B865CAE8F340F6CE1485A06F4492BB49718DF1EC")
+				grants(h, "GRANT SELECT, LOCK TABLES, PROCESS ON *.* TO `backup`@`localhost`\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`^printf`, 0)
+			},
+		},
+		{
+			Name: "ChangePassword",
+			Args: map[string]interface{}{"name": "app", "password": "n3w"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				account(h, "mysql_native_password", "*B865CAE8F340F6CE1485A06F4492BB49718DF1EC")
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'ALTER USER .* IDENTIFIED BY `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Changed the credentials of app@localhost")
+			},
+		},
+		{
+			Name: "Drop",
+			Args: map[string]interface{}{"name": "legacy", "host": "%", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				account(h, "caching_sha2_password", "")
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'DROP USER `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Dropped user legacy@%")
+			},
+		},
+	})
+}
@@ -0,0 +1,447 @@
+package modules
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Interface kinds managed by the network_interface module
+var networkKinds = []string{"ethernet", "bond", "vlan", "bridge"}
+
+// Bonding modes of the Linux bonding driver
+var bondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+// interfaceNamePattern matches Linux interface names, which are at most 15
+// bytes. Aliases such as eth0:1 are not interfaces.
+var interfaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+
+var searchDomainPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+// networkRoute is a static route; to is "default" or a CIDR
+type networkRoute struct {
+	to     string
+	via    string
+	metric int
+}
+
+// ipv6 reports whether the route is an IPv6 route
+func (r networkRoute) ipv6() bool {
+	if r.to == "default" {
+		return strings.Contains(r.via, ":")
+	}
+	return strings.Contains(r.to, ":")
+}
+
+// destination returns the route's CIDR, spelling out default routes
+func (r networkRoute) destination() string {
+	switch {
+	case r.to != "default":
+		return r.to
+	case r.ipv6():
+		return "::/0"
+	default:
+		return "0.0.0.0/0"
+	}
+}
+
+// networkSpec is the desired configuration of an interface
+type networkSpec struct {
+	name      string
+	kind      string
+	members   []string // Ports of a bond or bridge
+	bondMode  string
+	miimon    int
+	vlanID    int
+	vlanLink  string
+	stp       bool
+	addresses []string
+	dhcp4     bool
+	dhcp6     bool
+	gateway4  string
+	gateway6  string
+	dns       []string
+	dnsSearch []string
+	routes    []networkRoute
+	mtu       int
+}
+
+// interfaces returns every interface the configuration touches: the
+// interface itself, its bond or bridge ports and its VLAN parent
+func (s networkSpec) interfaces() []string {
+	names := append([]string{s.name}, s.members...)
+	if s.vlanLink != "" {
+		names = append(names, s.vlanLink)
+	}
+	return names
+}
+
+// addressesOf returns the addresses of one family
+func (s networkSpec) addressesOf(ipv6 bool) []string {
+	var addresses []string
+	for _, address := range s.addresses {
+		if strings.Contains(address, ":") == ipv6 {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// dnsOf returns the name servers of one family
+func (s networkSpec) dnsOf(ipv6 bool) []string {
+	var servers []string
+	for _, server := range s.dns {
+		if strings.Contains(server, ":") == ipv6 {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// parseNetworkSpec reads and validates the interface configuration of the
+// network_interface module's arguments
+func parseNetworkSpec(m *BaseModule, args map[string]interface{}) (networkSpec, error) {
+	spec := networkSpec{
+		name:      m.GetStringArg(args, "name", ""),
+		kind:      m.GetStringArg(args, "type", "ethernet"),
+		members:   stringList(args["interfaces"]),
+		bondMode:  m.GetStringArg(args, "bond_mode", "active-backup"),
+		vlanLink:  m.GetStringArg(args, "vlan_link", ""),
+		stp:       m.GetBoolArg(args, "stp", false),
+		addresses: stringList(args["addresses"]),
+		dhcp4:     m.GetBoolArg(args, "dhcp4", false),
+		dhcp6:     m.GetBoolArg(args, "dhcp6", false),
+		gateway4:  m.GetStringArg(args, "gateway4", ""),
+		gateway6:  m.GetStringArg(args, "gateway6", ""),
+		dns:       stringList(args["dns"]),
+		dnsSearch: stringList(args["dns_search"]),
+	}
+
+	if !interfaceNamePattern.MatchString(spec.name) {
+		return spec, types.NewValidationError("name", spec.name, "name must be an interface name of at most 15 letters, digits, '.', '-' or '_'")
+	}
+	if !containsValue(networkKinds, spec.kind) {
+		return spec, types.NewValidationError("type", spec.kind, fmt.Sprintf("type must be one of: %v", networkKinds))
+	}
+
+	var err error
+	if spec.mtu, err = m.GetIntArg(args, "mtu", 0); err != nil || (spec.mtu != 0 && (spec.mtu < 68 || spec.mtu > 65535)) {
+		return spec, types.NewValidationError("mtu", args["mtu"], "mtu must be between 68 and 65535")
+	}
+
+	switch spec.kind {
+	case "bond", "bridge":
+		if spec.kind == "bond" && len(spec.members) == 0 {
+			return spec, types.NewValidationError("interfaces", nil, "a bond requires at least one interface")
+		}
+		for _, member := range spec.members {
+			if !interfaceNamePattern.MatchString(member) || member == spec.name {
+				return spec, types.NewValidationError("interfaces", member, "invalid port interface")
+			}
+		}
+		if spec.kind == "bond" && !containsValue(bondModes, spec.bondMode) {
+			return spec, types.NewValidationError("bond_mode", spec.bondMode, fmt.Sprintf("bond_mode must be one of: %v", bondModes))
+		}
+		if spec.miimon, err = m.GetIntArg(args, "miimon", 100); err != nil || spec.miimon < 0 {
+			return spec, types.NewValidationError("miimon", args["miimon"], "miimon must be a number of milliseconds")
+		}
+	case "vlan":
+		if spec.vlanID, err = m.GetIntArg(args, "vlan_id", 0); err != nil || spec.vlanID < 1 || spec.vlanID > 4094 {
+			return spec, types.NewValidationError("vlan_id", args["vlan_id"], "a VLAN requires a vlan_id between 1 and 4094")
+		}
+		if !interfaceNamePattern.MatchString(spec.vlanLink) || spec.vlanLink == spec.name {
+			return spec, types.NewValidationError("vlan_link", spec.vlanLink, "a VLAN requires the vlan_link interface it is carried on")
+		}
+	}
+	if spec.kind != "bond" && spec.kind != "bridge" && len(spec.members) > 0 {
+		return spec, types.NewValidationError("interfaces", args["interfaces"], "only bonds and bridges have port interfaces")
+	}
+
+	for _, address := range spec.addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return spec, types.NewValidationError("addresses", address, "addresses must be in CIDR notation, e.g. 192.0.2.10/24")
+		}
+	}
+	if spec.gateway4 != "" {
+		if ip := net.ParseIP(spec.gateway4); ip == nil || ip.To4() == nil {
+			return spec, types.NewValidationError("gateway4", spec.gateway4, "gateway4 must be an IPv4 address")
+		}
+	}
+	if spec.gateway6 != "" {
+		if ip := net.ParseIP(spec.gateway6); ip == nil || ip.To4() != nil {
+			return spec, types.NewValidationError("gateway6", spec.gateway6, "gateway6 must be an IPv6 address")
+		}
+	}
+	for _, server := range spec.dns {
+		if net.ParseIP(server) == nil {
+			return spec, types.NewValidationError("dns", server, "dns must list IP addresses")
+		}
+	}
+	for _, domain := range spec.dnsSearch {
+		if !searchDomainPattern.MatchString(domain) {
+			return spec, types.NewValidationError("dns_search", domain, "invalid search domain")
+		}
+	}
+
+	for _, item := range m.GetSliceArg(args, "routes") {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return spec, types.NewValidationError("routes", item, "routes must be a list of mappings with to, via and metric")
+		}
+		route := networkRoute{to: types.ConvertToString(fields["to"]), via: types.ConvertToString(fields["via"])}
+		if route.to != "default" {
+			if _, _, err := net.ParseCIDR(route.to); err != nil {
+				return spec, types.NewValidationError("routes", route.to, "a route's to must be default or a CIDR")
+			}
+		}
+		if ip := net.ParseIP(route.via); ip == nil || (route.to != "default" && (ip.To4() == nil) != route.ipv6()) {
+			return spec, types.NewValidationError("routes", route.via, "a route's via must be an address of the destination's family")
+		}
+		if metric, ok := fields["metric"]; ok {
+			if route.metric, err = strconv.Atoi(types.ConvertToString(metric)); err != nil || route.metric < 0 {
+				return spec, types.NewValidationError("routes", metric, "a route's metric must be a non-negative number")
+			}
+		}
+		spec.routes = append(spec.routes, route)
+	}
+
+	return spec, nil
+}
+
+// networkFile is a configuration file rendered for a provider
+type networkFile struct {
+	path    string
+	content string
+	mode    string
+}
+
+// networkHeader marks the files the module writes
+const networkHeader = "# Managed by gosible network_interface\n"
+
+// netplanFiles renders the interface as a netplan YAML file. Bond and
+// bridge ports are declared as ethernets without addresses.
+func netplanFiles(spec networkSpec) []networkFile {
+	var b strings.Builder
+	b.WriteString(networkHeader)
+	b.WriteString("network:\n  version: 2\n")
+	if len(spec.members) > 0 {
+		b.WriteString("  ethernets:\n")
+		for _, member := range spec.members {
+			fmt.Fprintf(&b, "    %s: {}\n", member)
+		}
+	}
+
+	section := map[string]string{"ethernet": "ethernets", "bond": "bonds", "vlan": "vlans", "bridge": "bridges"}[spec.kind]
+	fmt.Fprintf(&b, "  %s:\n    %s:\n", section, spec.name)
+	switch spec.kind {
+	case "bond":
+		fmt.Fprintf(&b, "      interfaces: [%s]\n      parameters:\n        mode: %s\n        mii-monitor-interval: %d\n", strings.Join(spec.members, ", "), spec.bondMode, spec.miimon)
+	case "bridge":
+		fmt.Fprintf(&b, "      interfaces: [%s]\n      parameters:\n        stp: %t\n", strings.Join(spec.members, ", "), spec.stp)
+	case "vlan":
+		fmt.Fprintf(&b, "      id: %d\n      link: %s\n", spec.vlanID, spec.vlanLink)
+	}
+
+	fmt.Fprintf(&b, "      dhcp4: %t\n      dhcp6: %t\n", spec.dhcp4, spec.dhcp6)
+	if len(spec.addresses) > 0 {
+		b.WriteString("      addresses:\n")
+		for _, address := range spec.addresses {
+			fmt.Fprintf(&b, "        - %s\n", address)
+		}
+	}
+	routes := spec.routes
+	if spec.gateway4 != "" {
+		routes = append([]networkRoute{{to: "default", via: spec.gateway4}}, routes...)
+	}
+	if spec.gateway6 != "" {
+		routes = append([]networkRoute{{to: "default", via: spec.gateway6}}, routes...)
+	}
+	if len(routes) > 0 {
+		b.WriteString("      routes:\n")
+		for _, route := range routes {
+			fmt.Fprintf(&b, "        - to: %s\n          via: %s\n", route.to, route.via)
+			if route.metric > 0 {
+				fmt.Fprintf(&b, "          metric: %d\n", route.metric)
+			}
+		}
+	}
+	if len(spec.dns) > 0 || len(spec.dnsSearch) > 0 {
+		b.WriteString("      nameservers:\n")
+		if len(spec.dns) > 0 {
+			fmt.Fprintf(&b, "        addresses: [%s]\n", strings.Join(spec.dns, ", "))
+		}
+		if len(spec.dnsSearch) > 0 {
+			fmt.Fprintf(&b, "        search: [%s]\n", strings.Join(spec.dnsSearch, ", "))
+		}
+	}
+	if spec.mtu > 0 {
+		fmt.Fprintf(&b, "      mtu: %d\n", spec.mtu)
+	}
+
+	// netplan warns about configuration readable by other users
+	return []networkFile{{path: "/etc/netplan/90-gosible-" + spec.name + ".yaml", content: b.String(), mode: "0600"}}
+}
+
+// networkdFiles renders the interface as systemd-networkd units: a .netdev
+// creating a virtual interface, its .network, one .network per bond or
+// bridge port and, for a VLAN, a drop-in adding it to the parent's
+// .network, which must be managed by network_interface too
+func networkdFiles(spec networkSpec) []networkFile {
+	const dir = "/etc/systemd/network/"
+	var files []networkFile
+
+	if spec.kind != "ethernet" {
+		var b strings.Builder
+		b.WriteString(networkHeader)
+		fmt.Fprintf(&b, "[NetDev]\nName=%s\nKind=%s\n", spec.name, spec.kind)
+		if spec.mtu > 0 {
+			fmt.Fprintf(&b, "MTUBytes=%d\n", spec.mtu)
+		}
+		switch spec.kind {
+		case "bond":
+			fmt.Fprintf(&b, "\n[Bond]\nMode=%s\nMIIMonitorSec=%dms\n", spec.bondMode, spec.miimon)
+		case "bridge":
+			fmt.Fprintf(&b, "\n[Bridge]\nSTP=%s\n", yesNo(spec.stp))
+		case "vlan":
+			fmt.Fprintf(&b, "\n[VLAN]\nId=%d\n", spec.vlanID)
+		}
+		files = append(files, networkFile{path: dir + "90-gosible-" + spec.name + ".netdev", content: b.String(), mode: "0644"})
+	}
+
+	var b strings.Builder
+	b.WriteString(networkHeader)
+	fmt.Fprintf(&b, "[Match]\nName=%s\n", spec.name)
+	if spec.mtu > 0 && spec.kind == "ethernet" {
+		fmt.Fprintf(&b, "\n[Link]\nMTUBytes=%d\n", spec.mtu)
+	}
+	dhcp := "no"
+	switch {
+	case spec.dhcp4 && spec.dhcp6:
+		dhcp = "yes"
+	case spec.dhcp4:
+		dhcp = "ipv4"
+	case spec.dhcp6:
+		dhcp = "ipv6"
+	}
+	fmt.Fprintf(&b, "\n[Network]\nDHCP=%s\n", dhcp)
+	for _, address := range spec.addresses {
+		fmt.Fprintf(&b, "Address=%s\n", address)
+	}
+	for _, gateway := range []string{spec.gateway4, spec.gateway6} {
+		if gateway != "" {
+			fmt.Fprintf(&b, "Gateway=%s\n", gateway)
+		}
+	}
+	for _, server := range spec.dns {
+		fmt.Fprintf(&b, "DNS=%s\n", server)
+	}
+	if len(spec.dnsSearch) > 0 {
+		fmt.Fprintf(&b, "Domains=%s\n", strings.Join(spec.dnsSearch, " "))
+	}
+	for _, route := range spec.routes {
+		fmt.Fprintf(&b, "\n[Route]\nDestination=%s\nGateway=%s\n", route.destination(), route.via)
+		if route.metric > 0 {
+			fmt.Fprintf(&b, "Metric=%d\n", route.metric)
+		}
+	}
+	files = append(files, networkFile{path: dir + "90-gosible-" + spec.name + ".network", content: b.String(), mode: "0644"})
+
+	portKey := map[string]string{"bond": "Bond", "bridge": "Bridge"}[spec.kind]
+	for _, member := range spec.members {
+		content := fmt.Sprintf("%s[Match]\nName=%s\n\n[Network]\n%s=%s\n", networkHeader, member, portKey, spec.name)
+		files = append(files, networkFile{path: dir + "90-gosible-" + member + ".network", content: content, mode: "0644"})
+	}
+	if spec.kind == "vlan" {
+		content := fmt.Sprintf("%s[Network]\nVLAN=%s\n", networkHeader, spec.name)
+		path := fmt.Sprintf("%s90-gosible-%s.network.d/vlan-%s.conf", dir, spec.vlanLink, spec.name)
+		files = append(files, networkFile{path: path, content: content, mode: "0644"})
+	}
+	return files
+}
+
+// nmcliFiles renders the interface as NetworkManager keyfile profiles, one
+// for the interface, whose id is gosible-<name>, and one per bond or bridge
+// port. The priority makes the profiles win over ones the installer
+// created for the same interface when NetworkManager starts.
+func nmcliFiles(spec networkSpec) []networkFile {
+	const dir = "/etc/NetworkManager/system-connections/"
+
+	var b strings.Builder
+	b.WriteString(networkHeader)
+	fmt.Fprintf(&b, "[connection]\nid=gosible-%s\ntype=%s\ninterface-name=%s\nautoconnect-priority=10\n", spec.name, spec.kind, spec.name)
+	if len(spec.members) > 0 {
+		b.WriteString("autoconnect-slaves=1\n")
+	}
+	if spec.mtu > 0 {
+		fmt.Fprintf(&b, "\n[ethernet]\nmtu=%d\n", spec.mtu)
+	}
+	switch spec.kind {
+	case "bond":
+		fmt.Fprintf(&b, "\n[bond]\nmiimon=%d\nmode=%s\n", spec.miimon, spec.bondMode)
+	case "bridge":
+		fmt.Fprintf(&b, "\n[bridge]\nstp=%t\n", spec.stp)
+	case "vlan":
+		fmt.Fprintf(&b, "\n[vlan]\nid=%d\nparent=%s\n", spec.vlanID, spec.vlanLink)
+	}
+
+	for _, ipv6 := range []bool{false, true} {
+		section, dhcp, gateway, disabled := "ipv4", spec.dhcp4, spec.gateway4, "disabled"
+		if ipv6 {
+			section, dhcp, gateway = "ipv6", spec.dhcp6, spec.gateway6
+		}
+		addresses := spec.addressesOf(ipv6)
+		method := disabled
+		switch {
+		case dhcp:
+			method = "auto"
+		case len(addresses) > 0:
+			method = "manual"
+		}
+
+		fmt.Fprintf(&b, "\n[%s]\nmethod=%s\n", section, method)
+		for i, address := range addresses {
+			fmt.Fprintf(&b, "address%d=%s\n", i+1, address)
+		}
+		if gateway != "" {
+			fmt.Fprintf(&b, "gateway=%s\n", gateway)
+		}
+		if servers := spec.dnsOf(ipv6); len(servers) > 0 {
+			fmt.Fprintf(&b, "dns=%s;\n", strings.Join(servers, ";"))
+		}
+		if !ipv6 && len(spec.dnsSearch) > 0 {
+			fmt.Fprintf(&b, "dns-search=%s;\n", strings.Join(spec.dnsSearch, ";"))
+		}
+		n := 0
+		for _, route := range spec.routes {
+			if route.ipv6() != ipv6 {
+				continue
+			}
+			n++
+			fmt.Fprintf(&b, "route%d=%s,%s", n, route.destination(), route.via)
+			if route.metric > 0 {
+				fmt.Fprintf(&b, ",%d", route.metric)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	files := []networkFile{{path: dir + "gosible-" + spec.name + ".nmconnection", content: b.String(), mode: "0600"}}
+	for _, member := range spec.members {
+		content := fmt.Sprintf("%s[connection]\nid=gosible-%s-%s\ntype=ethernet\ninterface-name=%s\nautoconnect-priority=10\nmaster=%s\nslave-type=%s\n",
+			networkHeader, spec.name, member, member, spec.name, spec.kind)
+		files = append(files, networkFile{path: dir + "gosible-" + spec.name + "-" + member + ".nmconnection", content: content, mode: "0600"})
+	}
+	return files
+}
+
+// yesNo formats a boolean the way systemd units do
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,440 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// networkProvider renders interface configuration for a network
+// configuration backend and knows how to validate and apply it
+type networkProvider struct {
+	name string
+	cli  remoteCLI
+}
+
+// files renders the configuration files of an interface
+func (p networkProvider) files(spec networkSpec) []networkFile {
+	switch p.name {
+	case "netplan":
+		return netplanFiles(spec)
+	case "networkd":
+		return networkdFiles(spec)
+	default:
+		return nmcliFiles(spec)
+	}
+}
+
+// validate returns the command checking written files before they are
+// applied, or "" when the backend has no such check. netplan generate
+// parses every netplan file without changing the running configuration and
+// nmcli refuses to load invalid profiles.
+func (p networkProvider) validate(files []networkFile) string {
+	switch p.name {
+	case "netplan":
+		return "netplan generate"
+	case "nmcli":
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = p.cli.shellEscape(f.path)
+		}
+		return "nmcli connection load " + strings.Join(paths, " ")
+	default:
+		return ""
+	}
+}
+
+// apply returns the command applying the written configuration. Removed
+// NetworkManager profiles are deactivated by the reload.
+func (p networkProvider) apply(spec networkSpec, present bool) string {
+	switch p.name {
+	case "netplan":
+		return "netplan apply"
+	case "networkd":
+		cmd := "networkctl reload"
+		if present {
+			return cmd + " && networkctl reconfigure " + strings.Join(spec.interfaces(), " ")
+		}
+		if spec.kind != "ethernet" {
+			cmd += " && networkctl delete " + spec.name
+		}
+		return cmd
+	default:
+		if present {
+			return "nmcli connection reload && nmcli connection up id " + p.cli.shellEscape("gosible-"+spec.name)
+		}
+		return "nmcli connection reload"
+	}
+}
+
+// reapply returns the command the rollback runs after restoring the
+// previous files. It carries on past errors to restore what it can.
+func (p networkProvider) reapply(spec networkSpec) string {
+	switch p.name {
+	case "netplan":
+		return "netplan apply"
+	case "networkd":
+		return "networkctl reload; networkctl reconfigure " + strings.Join(spec.interfaces(), " ")
+	default:
+		return "nmcli connection reload; nmcli connection up id " + p.cli.shellEscape("gosible-"+spec.name)
+	}
+}
+
+// connectionLost reports whether a command failed because the host stopped
+// answering rather than with an exit status
+func connectionLost(ctx context.Context, result *types.Result, err error) bool {
+	var connErr *types.ConnectionError
+	return ctx.Err() != nil || errors.As(err, &connErr) || (err != nil && result == nil)
+}
+
+// NetworkInterfaceModule configures ethernet, bond, VLAN and bridge
+// interfaces through netplan, NetworkManager or systemd-networkd, rolling
+// the change back when the host becomes unreachable after applying it
+type NetworkInterfaceModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewNetworkInterfaceModule creates a new network_interface module instance
+func NewNetworkInterfaceModule() *NetworkInterfaceModule {
+	doc := types.ModuleDoc{
+		Name:        "network_interface",
+		Description: "Render and apply the configuration of an ethernet, bond, VLAN or bridge interface with netplan, nmcli or systemd-networkd, validating it first and restoring the previous configuration when the host stops answering after the change",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Interface name, e.g. eth0, bond0, br0 or eth0.100",
+				Required:    true,
+				Type:        "string",
+			},
+			"type": {
+				Description: "Interface kind",
+				Required:    false,
+				Type:        "string",
+				Default:     "ethernet",
+				Choices:     networkKinds,
+			},
+			"state": {
+				Description: "Whether the configuration should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"provider": {
+				Description: "Configuration backend, auto prefers netplan, then a running NetworkManager, then a running systemd-networkd",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "netplan", "nmcli", "networkd"},
+			},
+			"addresses": {
+				Description: "Static addresses in CIDR notation",
+				Required:    false,
+				Type:        "list",
+			},
+			"dhcp4": {
+				Description: "Configure IPv4 with DHCP",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"dhcp6": {
+				Description: "Configure IPv6 with DHCPv6 and router advertisements",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"gateway4": {
+				Description: "IPv4 default gateway",
+				Required:    false,
+				Type:        "string",
+			},
+			"gateway6": {
+				Description: "IPv6 default gateway",
+				Required:    false,
+				Type:        "string",
+			},
+			"routes": {
+				Description: "Static routes, each with to (a CIDR or default), via and an optional metric",
+				Required:    false,
+				Type:        "list",
+			},
+			"dns": {
+				Description: "Name servers",
+				Required:    false,
+				Type:        "list",
+			},
+			"dns_search": {
+				Description: "DNS search domains",
+				Required:    false,
+				Type:        "list",
+			},
+			"mtu": {
+				Description: "MTU in bytes",
+				Required:    false,
+				Type:        "int",
+			},
+			"interfaces": {
+				Description: "Ports of a bond or bridge; with networkd their .network files are owned by the bond or bridge",
+				Required:    false,
+				Type:        "list",
+			},
+			"bond_mode": {
+				Description: "Bonding mode",
+				Required:    false,
+				Type:        "string",
+				Default:     "active-backup",
+				Choices:     bondModes,
+			},
+			"miimon": {
+				Description: "Bond link monitoring interval in milliseconds",
+				Required:    false,
+				Type:        "int",
+				Default:     100,
+			},
+			"stp": {
+				Description: "Enable the spanning tree protocol on a bridge",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"vlan_id": {
+				Description: "VLAN ID, required for VLANs",
+				Required:    false,
+				Type:        "int",
+			},
+			"vlan_link": {
+				Description: "Interface carrying a VLAN, required for VLANs; with networkd it must be configured by network_interface too",
+				Required:    false,
+				Type:        "string",
+			},
+			"rollback_timeout": {
+				Description: "Seconds after applying the change within which the host must still answer, or the previous configuration is restored; 0 disables the rollback",
+				Required:    false,
+				Type:        "int",
+				Default:     120,
+			},
+		},
+		Examples: []string{
+			"- name: Bond the uplinks\n  network_interface:\n    name: bond0\n    type: bond\n    interfaces: [eno1, eno2]\n    bond_mode: 802.3ad\n    addresses: [192.0.2.10/24]\n    gateway4: 192.0.2.1\n    dns: [192.0.2.53]",
+			"- name: Add the storage VLAN\n  network_interface:\n    name: bond0.200\n    type: vlan\n    vlan_id: 200\n    vlan_link: bond0\n    addresses: [10.200.0.10/24]\n    mtu: 9000\n    routes:\n      - to: 10.201.0.0/16\n        via: 10.200.0.1",
+		},
+		Returns: map[string]string{
+			"interface": "Interface name",
+			"provider":  "Configuration backend that was used",
+			"files":     "Configuration files of the interface",
+		},
+	}
+
+	base := NewBaseModule("network_interface", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &NetworkInterfaceModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *NetworkInterfaceModule) Validate(args map[string]interface{}) error {
+	if _, err := parseNetworkSpec(m.BaseModule, args); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "provider", []string{"auto", "netplan", "nmcli", "networkd"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "bond_mode", bondModes); err != nil {
+		return err
+	}
+	if timeout, err := m.GetIntArg(args, "rollback_timeout", 120); err != nil || timeout < 0 {
+		return types.NewValidationError("rollback_timeout", args["rollback_timeout"], "rollback_timeout must be a number of seconds")
+	}
+	return nil
+}
+
+// Run executes the network_interface module
+func (m *NetworkInterfaceModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	spec, err := parseNetworkSpec(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+	present := m.GetStringArg(args, "state", "present") == "present"
+	timeout, _ := m.GetIntArg(args, "rollback_timeout", 120)
+
+	provider, err := m.provider(ctx, conn, args)
+	if err != nil {
+		return nil, err
+	}
+	files := provider.files(spec)
+
+	var before, after strings.Builder
+	existing := make(map[string]bool)
+	paths := make([]string, 0, len(files))
+	outdated := false
+	for _, f := range files {
+		paths = append(paths, f.path)
+		quoted := m.cli.shellEscape(f.path)
+		current, exists, err := m.cli.inspect(ctx, conn, f.path, "[ -f "+quoted+" ]", "cat "+quoted)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			existing[f.path] = true
+			fmt.Fprintf(&before, "# %s\n%s", f.path, current)
+		}
+		if present {
+			fmt.Fprintf(&after, "# %s\n%s", f.path, f.content)
+			outdated = outdated || !exists || current != f.content
+		}
+	}
+
+	change := ""
+	switch {
+	case present && outdated:
+		change = fmt.Sprintf("configured %s with %s", spec.name, provider.name)
+	case !present && len(existing) > 0:
+		change = fmt.Sprintf("removed the %s configuration of %s", provider.name, spec.name)
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Network interface %s is already in desired state", spec.name), map[string]interface{}{
+		"interface": spec.name,
+		"provider":  provider.name,
+		"files":     paths,
+	})
+
+	if change != "" && !checkMode {
+		if err := m.apply(ctx, conn, provider, spec, files, existing, present, timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// apply replaces the interface's files and applies them. The current files
+// are saved on the host with a script restoring them first, so a change
+// failing validation or application is undone right away. Before applying,
+// a detached watchdog is started that runs the rollback after the timeout
+// unless the module, by removing the script over the connection, confirms
+// the host still answers.
+func (m *NetworkInterfaceModule) apply(ctx context.Context, conn types.Connection, p networkProvider, spec networkSpec, files []networkFile, existing map[string]bool, present bool, timeout int) error {
+	dir := "/run/gosible-network-" + spec.name
+	quotedDir := m.cli.shellEscape(dir)
+	restore, rollback := m.cli.shellEscape(dir+"/restore"), m.cli.shellEscape(dir+"/rollback")
+
+	save := []string{"rm -rf " + quotedDir, "mkdir -p " + quotedDir}
+	var restoreScript []string
+	for i, f := range files {
+		quoted := m.cli.shellEscape(f.path)
+		if existing[f.path] {
+			backup := m.cli.shellEscape(fmt.Sprintf("%s/%d", dir, i))
+			save = append(save, fmt.Sprintf("cp -p %s %s", quoted, backup))
+			restoreScript = append(restoreScript, fmt.Sprintf("cp -p %s %s", backup, quoted))
+		} else {
+			restoreScript = append(restoreScript, "rm -f "+quoted)
+		}
+	}
+	rollbackScript := []string{"sh " + restore, p.reapply(spec), "rm -rf " + quotedDir}
+	save = append(save,
+		fmt.Sprintf("printf '%%s\\n' %s > %s", m.cli.shellEscape(strings.Join(restoreScript, "\n")), restore),
+		fmt.Sprintf("printf '%%s\\n' %s > %s", m.cli.shellEscape(strings.Join(rollbackScript, "\n")), rollback))
+	if _, err := m.cli.run(ctx, conn, "saving the current network configuration", strings.Join(save, " && ")); err != nil {
+		return err
+	}
+
+	var write []string
+	for _, f := range files {
+		quoted := m.cli.shellEscape(f.path)
+		if present {
+			tmp := m.cli.shellEscape(f.path + ".gosible.tmp")
+			write = append(write, fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s && chmod %s %s && mv -f %s %s",
+				m.cli.shellEscape(parentDir(f.path)), m.cli.shellEscape(f.content), tmp, f.mode, tmp, tmp, quoted))
+		} else if existing[f.path] {
+			write = append(write, "rm -f "+quoted)
+		}
+	}
+	undo := fmt.Sprintf("sh %s; rm -rf %s", restore, quotedDir)
+	if _, err := m.cli.run(ctx, conn, "writing the network configuration", strings.Join(write, " && ")); err != nil {
+		conn.Execute(ctx, undo, types.ExecuteOptions{})
+		return err
+	}
+	if cmd := p.validate(files); present && cmd != "" {
+		if _, err := m.cli.run(ctx, conn, "validating the network configuration", cmd); err != nil {
+			conn.Execute(ctx, undo, types.ExecuteOptions{})
+			return fmt.Errorf("%w; the previous configuration was restored", err)
+		}
+	}
+
+	applyCtx := ctx
+	if timeout > 0 {
+		watchdog := fmt.Sprintf("sleep %d; [ -f %s ] && sh %s", timeout, rollback, rollback)
+		cmd := fmt.Sprintf("nohup sh -c %s >/dev/null 2>&1 </dev/null &", m.cli.shellEscape(watchdog))
+		if _, err := m.cli.run(ctx, conn, "starting the network rollback watchdog", cmd); err != nil {
+			conn.Execute(ctx, undo, types.ExecuteOptions{})
+			return err
+		}
+		var cancel context.CancelFunc
+		applyCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	lost := func(err error) error {
+		if timeout == 0 {
+			return fmt.Errorf("lost the connection to the host after applying the network configuration: %w", err)
+		}
+		return fmt.Errorf("lost the connection to the host after applying the network configuration, the previous configuration is restored within %d seconds: %w", timeout, err)
+	}
+
+	result, err := conn.Execute(applyCtx, p.apply(spec, present), types.ExecuteOptions{})
+	if connectionLost(applyCtx, result, err) {
+		return lost(err)
+	}
+	if err != nil || !result.Success {
+		conn.Execute(ctx, "sh "+rollback, types.ExecuteOptions{})
+		return fmt.Errorf("applying the network configuration failed, the previous configuration was restored: %s", commandStderr(result))
+	}
+
+	// Removing the saved configuration confirms the host still answers and
+	// disarms the watchdog
+	result, err = conn.Execute(applyCtx, "rm -rf "+quotedDir, types.ExecuteOptions{})
+	if err != nil || !result.Success {
+		return lost(err)
+	}
+	return nil
+}
+
+// provider resolves the configuration backend, detecting it unless given
+func (m *NetworkInterfaceModule) provider(ctx context.Context, conn types.Connection, args map[string]interface{}) (networkProvider, error) {
+	p := networkProvider{name: m.GetStringArg(args, "provider", "auto")}
+	if p.name != "auto" {
+		return p, nil
+	}
+
+	cmd := "if command -v netplan >/dev/null 2>&1 && [ -d /etc/netplan ]; then echo netplan; " +
+		"elif systemctl is-active --quiet NetworkManager 2>/dev/null; then echo nmcli; " +
+		"elif systemctl is-active --quiet systemd-networkd 2>/dev/null; then echo networkd; fi"
+	result, err := m.cli.run(ctx, conn, "detecting the network configuration backend", cmd)
+	if err != nil {
+		return p, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	p.name = strings.TrimSpace(stdout)
+	if p.name == "" {
+		return p, fmt.Errorf("none of netplan, NetworkManager or systemd-networkd manages the network")
+	}
+	return p, nil
+}
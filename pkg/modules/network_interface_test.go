@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestNetworkConfigRendering(t *testing.T) {
+	module := NewNetworkInterfaceModule()
+	spec := func(args map[string]interface{}) networkSpec {
+		t.Helper()
+		s, err := parseNetworkSpec(module.BaseModule, args)
+		if err != nil {
+			t.Fatalf("parseNetworkSpec failed: %v", err)
+		}
+		return s
+	}
+
+	t.Run("NetplanBond", func(t *testing.T) {
+		files := netplanFiles(spec(map[string]interface{}{
+			"name": "bond0", "type": "bond", "interfaces": []interface{}{"eno1", "eno2"}, "bond_mode": "802.3ad",
+			"addresses": []interface{}{"192.0.2.10/24", "2001:db8::10/64"}, "gateway4": "192.0.2.1",
+			"routes": []interface{}{map[string]interface{}{"to": "10.1.0.0/16", "via": "192.0.2.254", "metric": 100}},
+			"dns":    []interface{}{"192.0.2.53"}, "dns_search": []interface{}{"example.com"}, "mtu": 9000,
+		}))
+		expected := networkHeader + `network:
+  version: 2
+  ethernets:
+    eno1: {}
+    eno2: {}
+  bonds:
+    bond0:
+      interfaces: [eno1, eno2]
+      parameters:
+        mode: 802.3ad
+        mii-monitor-interval: 100
+      dhcp4: false
+      dhcp6: false
+      addresses:
+        - 192.0.2.10/24
+        - 2001:db8::10/64
+      routes:
+        - to: default
+          via: 192.0.2.1
+        - to: 10.1.0.0/16
+          via: 192.0.2.254
+          metric: 100
+      nameservers:
+        addresses: [192.0.2.53]
+        search: [example.com]
+      mtu: 9000
+`
+		if len(files) != 1 || files[0].path != "/etc/netplan/90-gosible-bond0.yaml" || files[0].mode != "0600" {
+			t.Fatalf("unexpected files %+v", files)
+		}
+		if files[0].content != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, files[0].content)
+		}
+	})
+
+	t.Run("NetworkdVLAN", func(t *testing.T) {
+		files := networkdFiles(spec(map[string]interface{}{
+			"name": "eth0.200", "type": "vlan", "vlan_id": 200, "vlan_link": "eth0", "dhcp4": true,
+			"routes": []interface{}{map[string]interface{}{"to": "default", "via": "2001:db8::1"}},
+		}))
+		expected := map[string]string{
+			"/etc/systemd/network/90-gosible-eth0.200.netdev":                   networkHeader + "[NetDev]\nName=eth0.200\nKind=vlan\n\n[VLAN]\nId=200\n",
+			"/etc/systemd/network/90-gosible-eth0.200.network":                  networkHeader + "[Match]\nName=eth0.200\n\n[Network]\nDHCP=ipv4\n\n[Route]\nDestination=::/0\nGateway=2001:db8::1\n",
+			"/etc/systemd/network/90-gosible-eth0.network.d/vlan-eth0.200.conf": networkHeader + "[Network]\nVLAN=eth0.200\n",
+		}
+		if len(files) != len(expected) {
+			t.Fatalf("expected %d files, got %+v", len(expected), files)
+		}
+		for _, f := range files {
+			if f.content != expected[f.path] {
+				t.Errorf("%s: expected:\n%s\ngot:\n%s", f.path, expected[f.path], f.content)
+			}
+		}
+	})
+
+	t.Run("NmcliBridge", func(t *testing.T) {
+		files := nmcliFiles(spec(map[string]interface{}{
+			"name": "br0", "type": "bridge", "interfaces": []interface{}{"eth1"},
+			"addresses": []interface{}{"192.0.2.10/24"}, "gateway4": "192.0.2.1", "dhcp6": true,
+			"dns": []interface{}{"192.0.2.53", "2001:db8::53"},
+		}))
+		if len(files) != 2 {
+			t.Fatalf("expected a bridge and a port profile, got %+v", files)
+		}
+		expected := networkHeader + "[connection]\nid=gosible-br0\ntype=bridge\ninterface-name=br0\nautoconnect-priority=10\nautoconnect-slaves=1\n" +
+			"\n[bridge]\nstp=false\n" +
+			"\n[ipv4]\nmethod=manual\naddress1=192.0.2.10/24\ngateway=192.0.2.1\ndns=192.0.2.53;\n" +
+			"\n[ipv6]\nmethod=auto\ndns=2001:db8::53;\n"
+		if files[0].path != "/etc/NetworkManager/system-connections/gosible-br0.nmconnection" || files[0].content != expected {
+			t.Errorf("expected:\n%s\ngot %s:\n%s", expected, files[0].path, files[0].content)
+		}
+		if !strings.Contains(files[1].content, "interface-name=eth1\n") || !strings.Contains(files[1].content, "master=br0\nslave-type=bridge\n") {
+			t.Errorf("unexpected port profile:\n%s", files[1].content)
+		}
+	})
+}
+
+func TestNetworkInterfaceModule(t *testing.T) {
+	module := NewNetworkInterfaceModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidEthernet", Args: map[string]interface{}{"name": "eth0", "dhcp4": true}, ExpectValid: true},
+		{Name: "ValidBond", Args: map[string]interface{}{"name": "bond0", "type": "bond", "interfaces": []interface{}{"eno1", "eno2"}}, ExpectValid: true},
+		{Name: "ValidVLAN", Args: map[string]interface{}{"name": "eth0.20", "type": "vlan", "vlan_id": 20, "vlan_link": "eth0"}, ExpectValid: true},
+		{Name: "LongName", Args: map[string]interface{}{"name": "averyveryverylongname"}, ExpectValid: false},
+		{Name: "BondWithoutPorts", Args: map[string]interface{}{"name": "bond0", "type": "bond"}, ExpectValid: false},
+		{Name: "PortsOnEthernet", Args: map[string]interface{}{"name": "eth0", "interfaces": []interface{}{"eth1"}}, ExpectValid: false},
+		{Name: "VLANWithoutID", Args: map[string]interface{}{"name": "eth0.20", "type": "vlan", "vlan_link": "eth0"}, ExpectValid: false},
+		{Name: "InvalidAddress", Args: map[string]interface{}{"name": "eth0", "addresses": []interface{}{"192.0.2.10"}}, ExpectValid: false},
+		{Name: "IPv6Gateway4", Args: map[string]interface{}{"name": "eth0", "gateway4": "2001:db8::1"}, ExpectValid: false},
+		{Name: "MixedFamilyRoute", Args: map[string]interface{}{"name": "eth0", "routes": []interface{}{map[string]interface{}{"to": "10.0.0.0/8", "via": "2001:db8::1"}}}, ExpectValid: false},
+		{Name: "InvalidMTU", Args: map[string]interface{}{"name": "eth0", "mtu": 20}, ExpectValid: false},
+		{Name: "NegativeRollback", Args: map[string]interface{}{"name": "eth0", "rollback_timeout": -1}, ExpectValid: false},
+	})
+
+	// Executing a case adds mode flags to its arguments
+	args := func() map[string]interface{} {
+		return map[string]interface{}{"name": "eth1", "addresses": []interface{}{"192.0.2.10/24"}}
+	}
+	current := func(content string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if command -v netplan `, &testhelper.CommandResponse{Stdout: "netplan\n"})
+			stdout := ""
+			if content != "" {
+				stdout = existsMarker + content
+			}
+			h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/netplan/90-gosible-eth1.yaml' \]`, &testhelper.CommandResponse{Stdout: stdout})
+		}
+	}
+	staged := func(h *testhelper.ModuleTestHelper) {
+		current("# old\n")(h)
+		h.GetConnection().ExpectCommandPattern(`^rm -rf '/run/gosible-network-eth1' && mkdir -p '/run/gosible-network-eth1' && cp -p '/etc/netplan/90-gosible-eth1.yaml' '/run/gosible-network-eth1/0' && printf `, &testhelper.CommandResponse{})
+		h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/netplan' && printf '%s' '# Managed by gosible`, &testhelper.CommandResponse{})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Apply",
+			Args: args(),
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				staged(h)
+				h.GetConnection().ExpectCommand("netplan generate", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^nohup sh -c 'sleep 120; \[ -f '"'"'/run/gosible-network-eth1/rollback'"'"' \] && sh `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("netplan apply", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("rm -rf '/run/gosible-network-eth1'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Configured eth1 with netplan")
+				h.AssertDataValue(result, "provider", "netplan")
+			},
+		},
+		{
+			Name:  "AlreadyConfigured",
+			Args:  args(),
+			Setup: current(netplanFiles(networkSpec{name: "eth1", kind: "ethernet", addresses: []string{"192.0.2.10/24"}})[0].content),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "CheckModeDiff",
+			Args:      args(),
+			CheckMode: true,
+			DiffMode:  true,
+			Setup:     current(""),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffAfter(result, "# /etc/netplan/90-gosible-eth1.yaml\n"+networkHeader+"network:\n  version: 2\n  ethernets:\n    eth1:\n      dhcp4: false\n      dhcp6: false\n      addresses:\n        - 192.0.2.10/24\n")
+			},
+		},
+		{
+			Name: "AbsentWithoutRollback",
+			Args: map[string]interface{}{"name": "bond0", "type": "bond", "interfaces": []interface{}{"eno1"}, "state": "absent", "provider": "networkd", "rollback_timeout": 0},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/systemd/network/90-gosible-bond0.netdev' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "[NetDev]\n"})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/systemd/network/90-gosible-bond0.network' \]`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/systemd/network/90-gosible-eno1.network' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "[Match]\n"})
+				h.GetConnection().ExpectCommandPattern(`^rm -rf '/run/gosible-network-bond0' && mkdir -p `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("rm -f '/etc/systemd/network/90-gosible-bond0.netdev' && rm -f '/etc/systemd/network/90-gosible-eno1.network'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("networkctl reload && networkctl delete bond0", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("rm -rf '/run/gosible-network-bond0'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed the networkd configuration of bond0")
+			},
+		},
+		{
+			Name:        "InvalidConfigRestored",
+			Args:        args(),
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				staged(h)
+				h.GetConnection().ExpectCommand("netplan generate", &testhelper.CommandResponse{ExitCode: 1, Stderr: "Error in network definition"})
+				h.GetConnection().ExpectCommand("sh '/run/gosible-network-eth1/restore'; rm -rf '/run/gosible-network-eth1'", &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name:        "ApplyFailureRolledBack",
+			Args:        args(),
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				staged(h)
+				h.GetConnection().ExpectCommand("netplan generate", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^nohup sh -c `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("netplan apply", &testhelper.CommandResponse{ExitCode: 1, Stderr: "bond0: device busy"})
+				h.GetConnection().ExpectCommand("sh '/run/gosible-network-eth1/rollback'", &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
+
+func TestNetworkInterfaceConnectionLost(t *testing.T) {
+	module := NewNetworkInterfaceModule()
+	conn := testhelper.NewMockConnection(t)
+	conn.ExpectCommandPattern(`^if \[ -f '/etc/netplan/90-gosible-eth1.yaml' \]`, &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`^rm -rf '/run/gosible-network-eth1' && mkdir -p `, &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`^mkdir -p '/etc/netplan' && printf `, &testhelper.CommandResponse{})
+	conn.ExpectCommand("netplan generate", &testhelper.CommandResponse{})
+	conn.ExpectCommandPattern(`^nohup sh -c 'sleep 30; `, &testhelper.CommandResponse{})
+	conn.ExpectCommand("netplan apply", &testhelper.CommandResponse{Error: types.NewConnectionError("web1", "connection reset by peer", nil)})
+
+	_, err := module.Run(context.Background(), conn, map[string]interface{}{
+		"name": "eth1", "addresses": []interface{}{"192.0.2.10/24"}, "provider": "netplan", "rollback_timeout": 30,
+	})
+	if err == nil || !strings.Contains(err.Error(), "restored within 30 seconds") {
+		t.Errorf("expected the pending rollback to be reported, got %v", err)
+	}
+	conn.AssertCommandNotCalled("rm -rf '/run/gosible-network-eth1'")
+	conn.AssertCommandNotCalled("sh '/run/gosible-network-eth1/rollback'")
+	conn.Verify()
+}
@@ -17,7 +17,9 @@ type NpmModule struct {
 // NewNpmModule creates a new npm module instance
 func NewNpmModule() *NpmModule {
 	return &NpmModule{
-		BaseModule: BaseModule{},
+		BaseModule: BaseModule{
+			name: "npm",
+		},
 	}
 }
 
@@ -0,0 +1,319 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// nspawnSettingsContent renders a .nspawn settings file from sections of
+// keys. List values repeat the key, as Bind= and Port= allow.
+func nspawnSettingsContent(settings map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("# Managed by gosible\n")
+
+	sections := make([]string, 0, len(settings))
+	for section := range settings {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	for _, section := range sections {
+		entries, _ := settings[section].(map[string]interface{})
+		fmt.Fprintf(&b, "\n[%s]\n", section)
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			values := stringList(entries[key])
+			if enabled, ok := entries[key].(bool); ok {
+				values = []string{"no"}
+				if enabled {
+					values = []string{"yes"}
+				}
+			}
+			for _, value := range values {
+				fmt.Fprintf(&b, "%s=%s\n", key, value)
+			}
+		}
+	}
+	return b.String()
+}
+
+// nspawnMachine is the state of a machine and its image
+type nspawnMachine struct {
+	image    bool
+	running  bool
+	enabled  bool
+	settings string
+	hasFile  bool
+}
+
+// NspawnModule manages systemd-nspawn machines with machinectl
+type NspawnModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewNspawnModule creates a new nspawn module instance
+func NewNspawnModule() *NspawnModule {
+	doc := types.ModuleDoc{
+		Name:        "nspawn",
+		Description: "Manage systemd-nspawn machines: pull or clone their images, write their .nspawn settings and start, stop or enable them with machinectl",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Machine name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "present keeps the image without changing whether the machine runs",
+				Required:    false,
+				Type:        "string",
+				Default:     "started",
+				Choices:     []string{"present", "started", "stopped", "absent"},
+			},
+			"image": {
+				Description: "URL of a tar or raw image to pull when the machine has no image. Images ending in .raw, .raw.xz, .qcow2 or .img are pulled as raw disk images",
+				Required:    false,
+				Type:        "string",
+			},
+			"clone": {
+				Description: "Existing image to clone when the machine has no image, instead of pulling one",
+				Required:    false,
+				Type:        "string",
+			},
+			"verify": {
+				Description: "How to verify a pulled image",
+				Required:    false,
+				Type:        "string",
+				Default:     "signature",
+				Choices:     []string{"no", "checksum", "signature"},
+			},
+			"settings": {
+				Description: "Contents of /etc/systemd/nspawn/<name>.nspawn as sections of keys, e.g. Network: {VirtualEthernet: true}. Changes apply the next time the machine starts",
+				Required:    false,
+				Type:        "dict",
+			},
+			"enabled": {
+				Description: "Whether the machine starts at boot",
+				Required:    false,
+				Type:        "bool",
+			},
+		},
+		Examples: []string{
+			"- name: Run a Debian machine with a private network\n  nspawn:\n    name: build1\n    image: https://hub.nspawn.org/storage/debian/bookworm/tar/image.tar.xz\n    verify: checksum\n    settings:\n      Network:\n        VirtualEthernet: true\n      Files:\n        Bind: [/srv/build]\n    enabled: true",
+			"- name: Clone a template machine\n  nspawn:\n    name: test2\n    clone: template\n    state: present",
+		},
+		Returns: map[string]string{
+			"name":    "Machine name",
+			"running": "Whether the machine runs after the run",
+			"enabled": "Whether the machine starts at boot",
+		},
+	}
+
+	base := NewBaseModule("nspawn", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &NspawnModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *NspawnModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if strings.ContainsAny(name, "/ ") {
+		return types.NewValidationError("name", name, "machine names cannot contain slashes or spaces")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "started", "stopped", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "verify", []string{"no", "checksum", "signature"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "image", "") != "" && m.GetStringArg(args, "clone", "") != "" {
+		return types.NewValidationError("clone", args["clone"], "image and clone are mutually exclusive")
+	}
+	if settings, ok := args["settings"]; ok {
+		sections, ok := settings.(map[string]interface{})
+		if !ok {
+			return types.NewValidationError("settings", settings, "settings must be a dict of sections")
+		}
+		for section, entries := range sections {
+			if _, ok := entries.(map[string]interface{}); !ok {
+				return types.NewValidationError("settings", entries, fmt.Sprintf("section %s must be a dict", section))
+			}
+		}
+	}
+	return nil
+}
+
+// settingsPath is where systemd-nspawn looks for the settings of a machine
+func (m *NspawnModule) settingsPath(name string) string {
+	return "/etc/systemd/nspawn/" + name + ".nspawn"
+}
+
+// status reads whether the machine has an image, runs and is enabled,
+// together with its settings file
+func (m *NspawnModule) status(ctx context.Context, conn types.Connection, name string) (*nspawnMachine, error) {
+	quoted := m.cli.shellEscape(name)
+	path := m.cli.shellEscape(m.settingsPath(name))
+	cmd := strings.Join([]string{
+		fmt.Sprintf("if machinectl show-image %s >/dev/null 2>&1; then echo image=yes; fi", quoted),
+		fmt.Sprintf("echo state=$(machinectl show %s -p State --value 2>/dev/null)", quoted),
+		fmt.Sprintf("echo enabled=$(systemctl is-enabled systemd-nspawn@%s.service 2>/dev/null)", quoted),
+		fmt.Sprintf("if [ -f %s ]; then printf '%%s' '%s'; cat %s; fi", path, existsMarker, path),
+	}, "; ")
+
+	result, err := m.cli.run(ctx, conn, "inspecting machine "+name, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := &nspawnMachine{}
+	stdout, _ := result.Data["stdout"].(string)
+	header, content, found := strings.Cut(stdout, existsMarker)
+	if found {
+		machine.hasFile, machine.settings = true, content
+	}
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "image":
+			machine.image = value == "yes"
+		case "state":
+			machine.running = value == "running"
+		case "enabled":
+			machine.enabled = value == "enabled"
+		}
+	}
+	return machine, nil
+}
+
+// pullCommand builds the machinectl command pulling an image, picking
+// pull-raw for disk images
+func (m *NspawnModule) pullCommand(image, name, verify string) string {
+	kind := "pull-tar"
+	for _, suffix := range []string{".raw", ".raw.xz", ".raw.gz", ".qcow2", ".img"} {
+		if strings.HasSuffix(image, suffix) {
+			kind = "pull-raw"
+		}
+	}
+	return fmt.Sprintf("machinectl %s --verify=%s %s %s", kind, verify, m.cli.shellEscape(image), m.cli.shellEscape(name))
+}
+
+// Run executes the nspawn module
+func (m *NspawnModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "started")
+	quoted := m.cli.shellEscape(name)
+	path := m.settingsPath(name)
+
+	machine, err := m.status(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+
+	running, enabled := machine.running, machine.enabled
+	before, after := machine.settings, machine.settings
+	var steps, changes []string
+
+	if state == "absent" {
+		if machine.running {
+			steps = append(steps, "machinectl terminate "+quoted)
+		}
+		if machine.enabled {
+			steps = append(steps, "machinectl disable "+quoted)
+		}
+		if machine.image {
+			steps = append(steps, "machinectl remove "+quoted)
+			changes = append(changes, "removed machine "+name)
+		}
+		if machine.hasFile {
+			steps = append(steps, "rm -f "+m.cli.shellEscape(path))
+			changes = append(changes, "removed "+path)
+		}
+		running, enabled, after = false, false, ""
+	} else {
+		if !machine.image {
+			switch image, clone := m.GetStringArg(args, "image", ""), m.GetStringArg(args, "clone", ""); {
+			case clone != "":
+				steps = append(steps, fmt.Sprintf("machinectl clone %s %s", m.cli.shellEscape(clone), quoted))
+				changes = append(changes, fmt.Sprintf("cloned machine %s from %s", name, clone))
+			case image != "":
+				steps = append(steps, m.pullCommand(image, name, m.GetStringArg(args, "verify", "signature")))
+				changes = append(changes, fmt.Sprintf("pulled machine %s from %s", name, image))
+			default:
+				return nil, types.NewValidationError("image", nil, fmt.Sprintf("machine %s has no image, set image or clone to create it", name))
+			}
+		}
+
+		if settings, ok := args["settings"].(map[string]interface{}); ok {
+			desired := nspawnSettingsContent(settings)
+			if !machine.hasFile || machine.settings != desired {
+				tmp := m.cli.shellEscape(path + ".gosible.tmp")
+				steps = append(steps, fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s && mv -f %s %s",
+					m.cli.shellEscape(parentDir(path)), m.cli.shellEscape(desired), tmp, tmp, m.cli.shellEscape(path)))
+				changes = append(changes, "wrote "+path)
+				after = desired
+			}
+		}
+
+		if _, ok := args["enabled"]; ok {
+			enabled = m.GetBoolArg(args, "enabled", false)
+			if enabled && !machine.enabled {
+				steps = append(steps, "machinectl enable "+quoted)
+				changes = append(changes, "enabled "+name)
+			} else if !enabled && machine.enabled {
+				steps = append(steps, "machinectl disable "+quoted)
+				changes = append(changes, "disabled "+name)
+			}
+		}
+
+		switch {
+		case state == "started" && !machine.running:
+			steps = append(steps, "machinectl start "+quoted)
+			changes = append(changes, "started "+name)
+			running = true
+		case state == "stopped" && machine.running:
+			steps = append(steps, "machinectl poweroff "+quoted)
+			changes = append(changes, "stopped "+name)
+			running = false
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Machine %s is already in desired state", name), map[string]interface{}{
+		"name":    name,
+		"running": running,
+		"enabled": enabled,
+	})
+
+	change := strings.Join(changes, ", ")
+	if change != "" && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "machinectl", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
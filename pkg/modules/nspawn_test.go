@@ -0,0 +1,111 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestNspawnModule(t *testing.T) {
+	module := NewNspawnModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const settings = "# Managed by gosible\n\n[Files]\nBind=/srv/build\nBind=/srv/cache\n\n[Network]\nVirtualEthernet=yes\n"
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "build1", "image": "https://example.com/debian.tar.xz"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "SlashInName", Args: map[string]interface{}{"name": "a/b"}, ExpectValid: false},
+		{Name: "InvalidVerify", Args: map[string]interface{}{"name": "build1", "verify": "gpg"}, ExpectValid: false},
+		{Name: "ImageAndClone", Args: map[string]interface{}{"name": "build1", "image": "https://example.com/debian.tar.xz", "clone": "template"}, ExpectValid: false},
+		{Name: "SectionNotDict", Args: map[string]interface{}{"name": "build1", "settings": map[string]interface{}{"Network": "yes"}}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "PullConfigureAndStart",
+			Args: map[string]interface{}{
+				"name":   "build1",
+				"image":  "https://example.com/debian.raw.xz",
+				"verify": "checksum",
+				"settings": map[string]interface{}{
+					"Network": map[string]interface{}{"VirtualEthernet": true},
+					"Files":   map[string]interface{}{"Bind": []interface{}{"/srv/build", "/srv/cache"}},
+				},
+				"enabled": true,
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`^if machinectl show-image 'build1'`, &testhelper.CommandResponse{Stdout: "state=\nenabled=\n"})
+				conn.ExpectCommand(`machinectl pull-raw --verify=checksum 'https://example.com/debian.raw.xz' 'build1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommandPattern(`(?s)^mkdir -p '/etc/systemd/nspawn' && printf '%s' '# Managed by gosible.*VirtualEthernet=yes.*' > '/etc/systemd/nspawn/build1.nspawn.gosible.tmp' && mv -f`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`machinectl enable 'build1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`machinectl start 'build1'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Pulled machine build1 from https://example.com/debian.raw.xz, wrote /etc/systemd/nspawn/build1.nspawn, enabled build1, started build1")
+				h.AssertDataValue(result, "running", true)
+				h.AssertDataValue(result, "enabled", true)
+			},
+		},
+		{
+			Name: "Unchanged",
+			Args: map[string]interface{}{
+				"name": "build1",
+				"settings": map[string]interface{}{
+					"Network": map[string]interface{}{"VirtualEthernet": true},
+					"Files":   map[string]interface{}{"Bind": []interface{}{"/srv/build", "/srv/cache"}},
+				},
+				"enabled": true,
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if machinectl show-image 'build1'`, &testhelper.CommandResponse{
+					Stdout: "image=yes\nstate=running\nenabled=enabled\n" + existsMarker + settings,
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "CloneInCheckMode",
+			Args:      map[string]interface{}{"name": "test2", "clone": "template", "state": "present"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if machinectl show-image 'test2'`, &testhelper.CommandResponse{Stdout: "state=\nenabled=\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, "Would have cloned machine test2 from template")
+			},
+		},
+		{
+			Name: "Absent",
+			Args: map[string]interface{}{"name": "build1", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				conn := h.GetConnection()
+				conn.ExpectCommandPattern(`^if machinectl show-image 'build1'`, &testhelper.CommandResponse{
+					Stdout: "image=yes\nstate=running\nenabled=enabled\n" + existsMarker + settings,
+				})
+				conn.ExpectCommand(`machinectl terminate 'build1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`machinectl disable 'build1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`machinectl remove 'build1'`, &testhelper.CommandResponse{})
+				conn.ExpectCommand(`rm -f '/etc/systemd/nspawn/build1.nspawn'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "running", false)
+			},
+		},
+		{
+			Name:        "NoImageSource",
+			Args:        map[string]interface{}{"name": "build2"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if machinectl show-image 'build2'`, &testhelper.CommandResponse{Stdout: "state=\nenabled=\n"})
+			},
+		},
+	})
+}
@@ -8,6 +8,23 @@ import (
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
+// packageManagerModules maps the package managers of ansible_pkg_mgr to
+// the native modules the package module dispatches to
+var packageManagerModules = map[string]func() types.Module{
+	"apt":      func() types.Module { return NewAptModule() },
+	"yum":      func() types.Module { return NewYumModule() },
+	"dnf":      func() types.Module { return NewDnfModule() },
+	"dnf5":     func() types.Module { return NewDnfModule() },
+	"zypper":   func() types.Module { return NewZypperModule() },
+	"apk":      func() types.Module { return NewApkModule() },
+	"pacman":   func() types.Module { return NewPacmanModule() },
+	"homebrew": func() types.Module { return NewHomebrewModule() },
+}
+
+// genericPackageManagers maps ansible_pkg_mgr names to those of the
+// commands built into the package module
+var genericPackageManagers = map[string]string{"dnf5": "dnf", "pkgng": "pkg"}
+
 // PackageModule manages system packages
 type PackageModule struct {
 	BaseModule
@@ -18,30 +35,58 @@ func NewPackageModule() *PackageModule {
 	return &PackageModule{
 		BaseModule: BaseModule{
 			name: "package",
+			capabilities: &types.ModuleCapability{
+				CheckMode:        true,
+				Platform:         "all",
+				ConcurrencyClass: types.ConcurrencyClassPackageManager,
+			},
 		},
 	}
 }
 
 // Run executes the package module
 func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *PackageModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	name, _ := args["name"].(string)
 	state, _ := args["state"].(string)
 	updateCache, _ := args["update_cache"].(bool)
+	checkMode := m.CheckMode(args)
 	
 	// Default state is present
 	if state == "" {
 		state = "present"
 	}
 	
+	// The package manager is taken from use or the gathered facts, and runs
+	// through its native module. Not every native module simulates check
+	// mode, so check mode is predicted with the generic queries below.
+	pkgMgr := m.GetStringArg(args, "use", "auto")
+	if pkgMgr == "auto" {
+		pkgMgr = m.GetTaskVar(args, "ansible_pkg_mgr", "")
+	}
+	if newModule, ok := packageManagerModules[pkgMgr]; ok && !checkMode {
+		return m.dispatch(ctx, conn, args, pkgMgr, newModule())
+	}
+	
 	result := &types.Result{
 		Success: true,
 		Changed: false,
 		Data:    make(map[string]interface{}),
 	}
 	
-	// Detect package manager
-	pkgMgr := m.detectPackageManager(ctx, conn)
+	if generic, ok := genericPackageManagers[pkgMgr]; ok {
+		pkgMgr = generic
+	}
+	// Without facts the package manager is detected from the binaries
+	if pkgMgr == "" || pkgMgr == "unknown" {
+		pkgMgr = m.detectPackageManager(ctx, conn)
+	}
 	result.Data["package_manager"] = pkgMgr
 	
 	if pkgMgr == "" {
@@ -51,7 +96,7 @@ func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map
 	}
 	
 	// Update cache if requested
-	if updateCache {
+	if updateCache && !checkMode {
 		if err := m.updatePackageCache(ctx, conn, pkgMgr); err != nil {
 			result.Success = false
 			result.Error = fmt.Errorf("failed to update package cache: %v", err)
@@ -75,27 +120,31 @@ func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map
 		switch state {
 		case "present", "installed":
 			if !installed {
-				if err := m.installPackage(ctx, conn, pkg, pkgMgr); err != nil {
-					result.Success = false
-					result.Error = fmt.Errorf("failed to install %s: %v", pkg, err)
-					return result, nil
+				if !checkMode {
+					if err := m.installPackage(ctx, conn, pkg, pkgMgr); err != nil {
+						result.Success = false
+						result.Error = fmt.Errorf("failed to install %s: %v", pkg, err)
+						return result, nil
+					}
 				}
 				result.Changed = true
 			}
 			
 		case "absent", "removed":
 			if installed {
-				if err := m.removePackage(ctx, conn, pkg, pkgMgr); err != nil {
-					result.Success = false
-					result.Error = fmt.Errorf("failed to remove %s: %v", pkg, err)
-					return result, nil
+				if !checkMode {
+					if err := m.removePackage(ctx, conn, pkg, pkgMgr); err != nil {
+						result.Success = false
+						result.Error = fmt.Errorf("failed to remove %s: %v", pkg, err)
+						return result, nil
+					}
 				}
 				result.Changed = true
 			}
 			
 		case "latest":
 			if installed {
-				updated, err := m.updatePackage(ctx, conn, pkg, pkgMgr)
+				updated, err := m.updatePackage(ctx, conn, pkg, pkgMgr, checkMode)
 				if err != nil {
 					result.Success = false
 					result.Error = fmt.Errorf("failed to update %s: %v", pkg, err)
@@ -106,6 +155,10 @@ func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map
 				}
 			} else {
 				// Install if not present
+				if checkMode {
+					result.Changed = true
+					continue
+				}
 				if err := m.installPackage(ctx, conn, pkg, pkgMgr); err != nil {
 					result.Success = false
 					result.Error = fmt.Errorf("failed to install %s: %v", pkg, err)
@@ -121,7 +174,11 @@ func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map
 		}
 	}
 	
-	if result.Changed {
+	if result.Changed && checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+		result.Message = fmt.Sprintf("Package(s) %s would change to state %s", name, state)
+	} else if result.Changed {
 		result.Message = fmt.Sprintf("Package(s) %s state changed to %s", name, state)
 	} else {
 		result.Message = fmt.Sprintf("Package(s) %s already in state %s", name, state)
@@ -130,6 +187,42 @@ func (m *PackageModule) Run(ctx context.Context, conn types.Connection, args map
 	return result, nil
 }
 
+// dispatch runs the native module of a package manager, passing the
+// packages as names along with the other arguments so that options of that
+// package manager can be given to package as well
+func (m *PackageModule) dispatch(ctx context.Context, conn types.Connection, args map[string]interface{}, pkgMgr string, module types.Module) (*types.Result, error) {
+	forwarded := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if key != "name" && key != "use" {
+			forwarded[key] = value
+		}
+	}
+	var names []interface{}
+	for _, pkg := range packageNames(map[string]interface{}{"name": args["name"]}) {
+		names = append(names, pkg)
+	}
+	forwarded["names"] = names
+	forwarded["state"] = packageState(args)
+	if pkgMgr == "homebrew" {
+		if updateCache, ok := forwarded["update_cache"]; ok {
+			forwarded["update_homebrew"] = updateCache
+			delete(forwarded, "update_cache")
+		}
+	}
+	
+	if err := module.Validate(forwarded); err != nil {
+		return nil, fmt.Errorf("invalid arguments for %s: %w", pkgMgr, err)
+	}
+	result, err := module.Run(ctx, conn, forwarded)
+	if result != nil {
+		if result.Data == nil {
+			result.Data = make(map[string]interface{})
+		}
+		result.Data["package_manager"] = pkgMgr
+	}
+	return result, err
+}
+
 // detectPackageManager detects the system's package manager
 func (m *PackageModule) detectPackageManager(ctx context.Context, conn types.Connection) string {
 	managers := []struct {
@@ -205,6 +298,8 @@ func (m *PackageModule) isPackageInstalled(ctx context.Context, conn types.Conne
 		cmd = fmt.Sprintf("apk info -e %s >/dev/null 2>&1", pkg)
 	case "pkg":
 		cmd = fmt.Sprintf("pkg info %s >/dev/null 2>&1", pkg)
+	case "homebrew":
+		cmd = fmt.Sprintf("brew list --versions %s >/dev/null 2>&1", pkg)
 	default:
 		return false
 	}
@@ -265,8 +360,9 @@ func (m *PackageModule) removePackage(ctx context.Context, conn types.Connection
 	return err
 }
 
-// updatePackage updates a package to latest version
-func (m *PackageModule) updatePackage(ctx context.Context, conn types.Connection, pkg, pkgMgr string) (bool, error) {
+// updatePackage updates a package to latest version. In check mode it only
+// reports whether an update is available.
+func (m *PackageModule) updatePackage(ctx context.Context, conn types.Connection, pkg, pkgMgr string, checkMode bool) (bool, error) {
 	// First check if update is available
 	var checkCmd string
 	switch pkgMgr {
@@ -278,6 +374,9 @@ func (m *PackageModule) updatePackage(ctx context.Context, conn types.Connection
 		checkCmd = fmt.Sprintf("dnf check-update %s >/dev/null 2>&1; [ $? -eq 100 ]", pkg)
 	default:
 		// For other package managers, just try to update
+		if checkMode {
+			return true, nil
+		}
 		return true, m.installPackage(ctx, conn, pkg, pkgMgr)
 	}
 	
@@ -286,6 +385,9 @@ func (m *PackageModule) updatePackage(ctx context.Context, conn types.Connection
 		// No update available
 		return false, nil
 	}
+	if checkMode {
+		return true, nil
+	}
 	
 	// Update the package
 	return true, m.installPackage(ctx, conn, pkg, pkgMgr)
@@ -299,6 +401,13 @@ func (m *PackageModule) Validate(args map[string]interface{}) error {
 		return types.NewValidationError("name", name, "required field is missing")
 	}
 	
+	// Validate use if provided
+	if use, ok := args["use"].(string); ok && use != "" && use != "auto" {
+		if _, native := packageManagerModules[use]; !native && use != "pkgng" {
+			return types.NewValidationError("use", use, "unsupported package manager")
+		}
+	}
+	
 	// Validate state if provided
 	if state, ok := args["state"].(string); ok && state != "" {
 		validStates := []string{"present", "absent", "latest", "installed", "removed"}
@@ -322,7 +431,7 @@ func (m *PackageModule) Validate(args map[string]interface{}) error {
 func (m *PackageModule) Documentation() types.ModuleDoc {
 	return types.ModuleDoc{
 		Name:        "package",
-		Description: "Manage packages",
+		Description: "Manage packages with the package manager of the host, running apt, yum, dnf, zypper, apk, pacman or homebrew as ansible_pkg_mgr names it, so one task works across distributions",
 		Parameters: map[string]types.ParamDoc{
 			"name": {
 				Description: "Name of the package(s) to manage (comma or space separated for multiple)",
@@ -342,6 +451,13 @@ func (m *PackageModule) Documentation() types.ModuleDoc {
 				Type:        "bool",
 				Default:     false,
 			},
+			"use": {
+				Description: "Package manager to use; auto takes it from the ansible_pkg_mgr fact, or detects it when facts were not gathered. Other arguments are passed to its module.",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "apt", "yum", "dnf", "dnf5", "zypper", "apk", "pacman", "homebrew", "pkgng"},
+			},
 		},
 		Examples: []string{
 			"- name: Install nginx\n  package:\n    name: nginx\n    state: present",
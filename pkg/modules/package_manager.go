@@ -0,0 +1,208 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// packageStates are the states native package modules accept; installed and
+// removed are aliases of present and absent
+var packageStates = []string{"present", "absent", "latest", "installed", "removed"}
+
+// nativePackageManager describes the commands a native package module runs.
+// The package names are appended to install, upgrade and remove.
+type nativePackageManager struct {
+	name     string
+	probe    string // Succeeds only when the package given last is installed
+	outdated string // Lists upgradable packages for parseOutdated
+	refresh  string // Refreshes the repository metadata
+	install  string // Installs missing packages
+	upgrade  string // Upgrades installed packages
+	remove   string // Removes packages
+
+	parseOutdated func(stdout string) []string
+}
+
+// packageNames returns the packages of the name argument, a list or a comma
+// or space separated string, followed by those of names
+func packageNames(args map[string]interface{}) []string {
+	var packages []string
+	for _, key := range []string{"name", "names"} {
+		for _, value := range stringList(args[key]) {
+			packages = append(packages, strings.Fields(strings.ReplaceAll(value, ",", " "))...)
+		}
+	}
+	return packages
+}
+
+// packageState returns the state argument with aliases resolved
+func packageState(args map[string]interface{}) string {
+	switch state := types.ConvertToString(args["state"]); state {
+	case "", "installed":
+		return "present"
+	case "removed":
+		return "absent"
+	default:
+		return state
+	}
+}
+
+// validatePackageArgs validates the arguments native package modules share
+func validatePackageArgs(m *BaseModule, args map[string]interface{}) error {
+	if err := m.ValidateChoices(args, "state", packageStates); err != nil {
+		return err
+	}
+	if _, err := m.lockTimeoutArg(args); err != nil {
+		return err
+	}
+	packages := packageNames(args)
+	for _, pkg := range packages {
+		if strings.HasPrefix(pkg, "-") {
+			return types.NewValidationError("name", pkg, "package names cannot start with -")
+		}
+	}
+	if len(packages) == 0 && !m.GetBoolArg(args, "update_cache", false) {
+		return types.NewValidationError("name", nil, "name is required unless update_cache is set")
+	}
+	return nil
+}
+
+// run brings packages to the requested state. Installed and upgradable
+// packages are queried first, so only the packages that differ are passed
+// to the package manager and check mode predicts the change exactly.
+func (p nativePackageManager) run(ctx context.Context, m *BaseModule, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+	var cli remoteCLI
+
+	packages := packageNames(args)
+	state := packageState(args)
+	lockTimeout, err := m.lockTimeoutArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refreshing the metadata does not change what is installed, so it is
+	// not reported as a change
+	cacheUpdated := false
+	if m.GetBoolArg(args, "update_cache", false) && !checkMode {
+		if err := p.execute(ctx, m, conn, "updating the package cache", p.refresh, lockTimeout); err != nil {
+			return nil, err
+		}
+		cacheUpdated = true
+	}
+
+	installed := make(map[string]bool)
+	if len(packages) > 0 {
+		cmd := fmt.Sprintf("for p in %s; do if %s \"$p\" >/dev/null 2>&1; then printf '%%s\\n' \"$p\"; fi; done", quotedPackages(packages), p.probe)
+		result, err := cli.run(ctx, conn, "querying packages", cmd)
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		for _, pkg := range strings.Fields(stdout) {
+			installed[pkg] = true
+		}
+	}
+
+	outdated := make(map[string]bool)
+	if state == "latest" && len(installed) > 0 {
+		result, err := cli.run(ctx, conn, "listing package updates", p.outdated)
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		for _, pkg := range p.parseOutdated(stdout) {
+			outdated[pkg] = true
+		}
+	}
+
+	var install, upgrade, remove []string
+	var before, after strings.Builder
+	for _, pkg := range packages {
+		current := "absent"
+		if installed[pkg] {
+			current = "installed"
+		}
+		desired := current
+		switch {
+		case state == "absent" && installed[pkg]:
+			remove = append(remove, pkg)
+			desired = "absent"
+		case state != "absent" && !installed[pkg]:
+			install = append(install, pkg)
+			desired = "installed"
+		case state == "latest" && outdated[pkg]:
+			upgrade = append(upgrade, pkg)
+			current, desired = "outdated", "latest"
+		}
+		fmt.Fprintf(&before, "%s: %s\n", pkg, current)
+		fmt.Fprintf(&after, "%s: %s\n", pkg, desired)
+	}
+
+	data := map[string]interface{}{
+		"package_manager": p.name,
+		"installed":       install,
+		"upgraded":        upgrade,
+		"removed":         remove,
+		"cache_updated":   cacheUpdated,
+	}
+	result := m.CreateSuccessResult(hostname, false, "Packages are already in desired state", data)
+	if len(packages) == 0 && cacheUpdated {
+		result.Message = "Package cache updated"
+	}
+
+	var changes []string
+	steps := []struct {
+		verb, doing, cmd string
+		packages         []string
+	}{
+		{"installed", "installing", p.install, install},
+		{"upgraded", "upgrading", p.upgrade, upgrade},
+		{"removed", "removing", p.remove, remove},
+	}
+	for _, step := range steps {
+		if len(step.packages) == 0 {
+			continue
+		}
+		changes = append(changes, step.verb+" "+strings.Join(step.packages, ", "))
+		if checkMode {
+			continue
+		}
+		cmd := step.cmd + " " + quotedPackages(step.packages)
+		if err := p.execute(ctx, m, conn, step.doing+" "+strings.Join(step.packages, ", "), cmd, lockTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return changeResult(m, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// execute runs a package manager command, waiting for the package database
+// lock, and turns a failure into an error
+func (p nativePackageManager) execute(ctx context.Context, m *BaseModule, conn types.Connection, what, cmd string, lockTimeout time.Duration) error {
+	result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%s failed: %s", what, commandStderr(result))
+	}
+	return nil
+}
+
+// quotedPackages shell-escapes package names and joins them
+func quotedPackages(packages []string) string {
+	var cli remoteCLI
+	quoted := make([]string, len(packages))
+	for i, pkg := range packages {
+		quoted[i] = cli.shellEscape(pkg)
+	}
+	return strings.Join(quoted, " ")
+}
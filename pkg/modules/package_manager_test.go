@@ -0,0 +1,193 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestPackageNames(t *testing.T) {
+	args := map[string]interface{}{"name": "git, vim curl", "names": []interface{}{"jq"}}
+	if names := packageNames(args); !reflect.DeepEqual(names, []string{"git", "vim", "curl", "jq"}) {
+		t.Errorf("unexpected package names %v", names)
+	}
+	if names := packageNames(map[string]interface{}{"name": []interface{}{"nginx", "certbot"}}); !reflect.DeepEqual(names, []string{"nginx", "certbot"}) {
+		t.Errorf("unexpected package names %v", names)
+	}
+}
+
+func TestParsePackageUpdates(t *testing.T) {
+	zypper := "S | Repository         | Name    | Current Version | Available Version | Arch\n" +
+		"--+--------------------+---------+-----------------+-------------------+-------\n" +
+		"v | Main Update Repo   | curl    | 8.0.1-1.1       | 8.0.1-2.1         | x86_64\n" +
+		"v | Main Update Repo   | openssl | 3.1.4-1.1       | 3.1.4-3.1         | x86_64\n"
+	if packages := parseZypperUpdates(zypper); !reflect.DeepEqual(packages, []string{"curl", "openssl"}) {
+		t.Errorf("parseZypperUpdates = %v", packages)
+	}
+
+	apk := "Installed:                                Available:\n" +
+		"curl-8.5.0-r0                           < 8.9.0-r0\n" +
+		"py3-setuptools-68.2.2-r0                < 70.3.0-r0\n"
+	if packages := parseApkUpdates(apk); !reflect.DeepEqual(packages, []string{"curl", "py3-setuptools"}) {
+		t.Errorf("parseApkUpdates = %v", packages)
+	}
+
+	pacman := "curl 8.5.0-1 -> 8.9.0-1\nlinux 6.7.arch1-1 -> 6.9.arch1-1\n"
+	if packages := parsePacmanUpdates(pacman); !reflect.DeepEqual(packages, []string{"curl", "linux"}) {
+		t.Errorf("parsePacmanUpdates = %v", packages)
+	}
+}
+
+func TestApkModule(t *testing.T) {
+	module := NewApkModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": []interface{}{"curl", "git"}}, ExpectValid: true},
+		{Name: "UpdateCacheOnly", Args: map[string]interface{}{"update_cache": true}, ExpectValid: true},
+		{Name: "NoAction", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "curl", "state": "linked"}, ExpectValid: false},
+		{Name: "Option", Args: map[string]interface{}{"name": "--allow-untrusted"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "InstallMissing",
+			Args:     map[string]interface{}{"name": "curl, git", "repository": "https://dl-cdn.alpinelinux.org/alpine/edge/testing"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'curl' 'git'; do if apk info -e "\$p"`, &testhelper.CommandResponse{Stdout: "git\n"})
+				h.GetConnection().ExpectCommand("apk --repository 'https://dl-cdn.alpinelinux.org/alpine/edge/testing' add 'curl'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Installed curl")
+				h.AssertDiffBefore(result, "curl: absent\ngit: installed\n")
+				h.AssertDiffAfter(result, "curl: installed\ngit: installed\n")
+			},
+		},
+		{
+			Name: "Latest",
+			Args: map[string]interface{}{"name": []interface{}{"curl", "jq"}, "state": "latest", "update_cache": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("apk update", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^for p in 'curl' 'jq'`, &testhelper.CommandResponse{Stdout: "curl\njq\n"})
+				h.GetConnection().ExpectCommand("apk version -l '<'", &testhelper.CommandResponse{Stdout: "Installed:  Available:\ncurl-8.5.0-r0  < 8.9.0-r0\n"})
+				h.GetConnection().ExpectCommand("apk add --upgrade 'curl'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Upgraded curl")
+				h.AssertDataValue(result, "cache_updated", true)
+			},
+		},
+		{
+			Name: "AlreadyAbsent",
+			Args: map[string]interface{}{"name": "telnet", "state": "removed"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'telnet'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "InstallFails",
+			Args:        map[string]interface{}{"name": "nosuchpkg"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nosuchpkg'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("apk add 'nosuchpkg'", &testhelper.CommandResponse{ExitCode: 1, Stderr: "ERROR: unable to select packages:\n  nosuchpkg (no such package)"})
+			},
+		},
+	})
+}
+
+func TestPacmanModule(t *testing.T) {
+	module := NewPacmanModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "RemoveWithDependencies",
+			Args: map[string]interface{}{"name": []interface{}{"docker", "vim"}, "state": "absent", "remove_dependencies": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'docker' 'vim'; do if pacman -Q "\$p"`, &testhelper.CommandResponse{Stdout: "docker\n"})
+				h.GetConnection().ExpectCommand("pacman -Rs --noconfirm 'docker'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Removed docker")
+			},
+		},
+		{
+			Name: "LatestWithoutUpdates",
+			Args: map[string]interface{}{"name": "curl", "state": "latest"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'curl'`, &testhelper.CommandResponse{Stdout: "curl\n"})
+				h.GetConnection().ExpectCommand("pacman -Qu; [ $? -le 1 ]", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "nginx", "update_cache": true},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nginx'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have installed nginx")
+				h.GetConnection().AssertPatternCalledTimes(`^pacman -S`, 0)
+			},
+		},
+	})
+}
+
+func TestZypperModule(t *testing.T) {
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = time.Millisecond
+
+	module := NewZypperModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "InstallAndUpgrade",
+			Args: map[string]interface{}{"name": []interface{}{"nginx", "openssl"}, "state": "latest", "disable_gpg_check": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nginx' 'openssl'; do if rpm -q --quiet "\$p"`, &testhelper.CommandResponse{Stdout: "openssl\n"})
+				h.GetConnection().ExpectCommand("zypper --non-interactive --quiet list-updates", &testhelper.CommandResponse{Stdout: "v | Updates | openssl | 3.1.4-1.1 | 3.1.4-3.1 | x86_64\n"})
+				h.GetConnection().ExpectCommand("zypper --non-interactive --no-gpg-checks install --auto-agree-with-licenses --no-recommends 'nginx'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("zypper --non-interactive --no-gpg-checks update --auto-agree-with-licenses --no-recommends 'openssl'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Installed nginx, upgraded openssl")
+				h.AssertDataValue(result, "package_manager", "zypper")
+			},
+		},
+		{
+			Name: "WaitsForLock",
+			Args: map[string]interface{}{"name": "nginx", "disable_recommends": false, "lock_timeout": 1},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nginx'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: "zypper --non-interactive install --auto-agree-with-licenses 'nginx'", Response: &testhelper.CommandResponse{ExitCode: 7, Stderr: "System management is locked by the application with pid 812 (zypper)."}},
+					testhelper.ExpectedCall{Command: "zypper --non-interactive install --auto-agree-with-licenses 'nginx'", Response: &testhelper.CommandResponse{}},
+				)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Installed nginx")
+			},
+		},
+	})
+}
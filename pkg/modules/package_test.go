@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 	
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
 	"github.com/liliang-cn/gosible/pkg/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -241,4 +242,64 @@ func TestPackageModule_ParsePackageList(t *testing.T) {
 			assert.Equal(t, tt.expected, result)
 		})
 	}
-}
\ No newline at end of file
+}
+func TestPackageModule_Dispatch(t *testing.T) {
+	module := NewPackageModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+	
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "FromFacts",
+			Args: map[string]interface{}{
+				"name":       "nginx,curl",
+				"state":      "installed",
+				"repository": "https://dl-cdn.alpinelinux.org/alpine/edge/main",
+				"_task_vars": map[string]interface{}{"ansible_pkg_mgr": "apk"},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nginx' 'curl'; do if apk info -e`, &testhelper.CommandResponse{Stdout: "curl\n"})
+				h.GetConnection().ExpectCommand("apk --repository 'https://dl-cdn.alpinelinux.org/alpine/edge/main' add 'nginx'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Installed nginx")
+				h.AssertDataValue(result, "package_manager", "apk")
+			},
+		},
+		{
+			Name: "Use",
+			Args: map[string]interface{}{
+				"name":       "nginx",
+				"state":      "absent",
+				"use":        "pacman",
+				"_task_vars": map[string]interface{}{"ansible_pkg_mgr": "apt"},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^for p in 'nginx'; do if pacman -Q`, &testhelper.CommandResponse{Stdout: "nginx\n"})
+				h.GetConnection().ExpectCommand("pacman -R --noconfirm 'nginx'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "package_manager", "pacman")
+			},
+		},
+		{
+			Name:      "CheckModeQueriesOnly",
+			Args:      map[string]interface{}{"name": "nginx", "update_cache": true, "_task_vars": map[string]interface{}{"ansible_pkg_mgr": "dnf5"}},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("rpm -q nginx >/dev/null 2>&1", &testhelper.CommandResponse{ExitCode: 1})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertDataValue(result, "package_manager", "dnf")
+			},
+		},
+	})
+	
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "UseNative", Args: map[string]interface{}{"name": "nginx", "use": "zypper"}, ExpectValid: true},
+		{Name: "UseUnknown", Args: map[string]interface{}{"name": "nginx", "use": "portage"}, ExpectValid: false},
+	})
+}
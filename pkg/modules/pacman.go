@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"context"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// PacmanModule manages packages using pacman on Arch Linux
+type PacmanModule struct {
+	*BaseModule
+}
+
+// NewPacmanModule creates a new pacman module instance
+func NewPacmanModule() *PacmanModule {
+	doc := types.ModuleDoc{
+		Name:        "pacman",
+		Description: "Manage packages using pacman on Arch Linux and its derivatives",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Package name, or list or comma separated string of names",
+				Required:    false,
+				Type:        "list",
+			},
+			"names": {
+				Description: "List of packages to manage",
+				Required:    false,
+				Type:        "list",
+			},
+			"state": {
+				Description: "State of the packages; latest compares against the synchronised databases, so combine it with update_cache",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     packageStates,
+			},
+			"update_cache": {
+				Description: "Synchronise the package databases before managing packages",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"remove_dependencies": {
+				Description: "Also remove the dependencies of removed packages that nothing else needs",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Type:        "int",
+				Default:     defaultLockTimeout,
+			},
+		},
+		Examples: []string{
+			"- name: Install the web server\n  pacman:\n    name: [nginx, certbot]\n    update_cache: true",
+			"- name: Remove a package and its unneeded dependencies\n  pacman:\n    name: docker\n    state: absent\n    remove_dependencies: true",
+		},
+		Returns: map[string]string{
+			"installed":     "Packages that were installed",
+			"upgraded":      "Packages that were upgraded",
+			"removed":       "Packages that were removed",
+			"cache_updated": "Whether the package databases were synchronised",
+		},
+	}
+
+	base := NewBaseModule("pacman", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:        true,
+		DiffMode:         true,
+		Platform:         "linux",
+		RequiresRoot:     true,
+		ConcurrencyClass: types.ConcurrencyClassPackageManager,
+	})
+
+	return &PacmanModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *PacmanModule) Validate(args map[string]interface{}) error {
+	return validatePackageArgs(m.BaseModule, args)
+}
+
+// Run executes the pacman module
+func (m *PacmanModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	remove := "pacman -R --noconfirm"
+	if m.GetBoolArg(args, "remove_dependencies", false) {
+		remove = "pacman -Rs --noconfirm"
+	}
+	return nativePackageManager{
+		name:  "pacman",
+		probe: "pacman -Q",
+		// pacman -Qu exits 1 when nothing is upgradable
+		outdated:      "pacman -Qu; [ $? -le 1 ]",
+		refresh:       "pacman -Sy",
+		install:       "pacman -S --noconfirm --needed",
+		upgrade:       "pacman -S --noconfirm",
+		remove:        remove,
+		parseOutdated: parsePacmanUpdates,
+	}.run(ctx, m.BaseModule, conn, args)
+}
+
+// parsePacmanUpdates reads the output of pacman -Qu, one upgradable package
+// per line such as "curl 8.5.0-1 -> 8.9.0-1"
+func parsePacmanUpdates(stdout string) []string {
+	var packages []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 4 && fields[2] == "->" {
+			packages = append(packages, fields[0])
+		}
+	}
+	return packages
+}
@@ -0,0 +1,190 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// PasswordRotateModule rotates the password of a local Linux account. The
+// new hash is generated on the control node, so only the hash reaches the
+// host.
+type PasswordRotateModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewPasswordRotateModule creates a new password_rotate module instance
+func NewPasswordRotateModule() *PasswordRotateModule {
+	doc := types.ModuleDoc{
+		Name:        "password_rotate",
+		Description: "Rotate the password of a local Linux account, generating a SHA-crypt hash and optionally verifying the old password first",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Account name",
+				Required:    true,
+				Type:        "string",
+			},
+			"password": {
+				Description: "New password in plain text; the account is unchanged when it already has it",
+				Required:    true,
+				Type:        "string",
+			},
+			"old_password": {
+				Description: "Current password; when given, the rotation fails unless the account has it, so a password changed by someone else is not overwritten",
+				Required:    false,
+				Type:        "string",
+			},
+			"hash_scheme": {
+				Description: "Scheme of the generated hash",
+				Required:    false,
+				Type:        "string",
+				Default:     "sha512",
+				Choices:     []string{"sha512", "sha256"},
+			},
+			"rounds": {
+				Description: "Hashing rounds, between 1000 and 999999999; the crypt(3) default of 5000 when omitted",
+				Required:    false,
+				Type:        "int",
+			},
+		},
+		Examples: []string{
+			"- name: Rotate the deploy password\n  password_rotate:\n    name: deploy\n    old_password: \"{{ deploy_password_old }}\"\n    password: \"{{ deploy_password }}\"",
+		},
+		Returns: map[string]string{
+			"name":        "Account name",
+			"hash_scheme": "Scheme of the new hash",
+			"locked":      "Whether the account is locked; a locked account stays locked",
+		},
+	}
+
+	base := NewBaseModule("password_rotate", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     false,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &PasswordRotateModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *PasswordRotateModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if strings.ContainsAny(name, ":\n") {
+		return types.NewValidationError("name", name, "account names cannot contain colons or newlines")
+	}
+	if m.GetStringArg(args, "password", "") == "" {
+		return types.NewValidationError("password", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "hash_scheme", []string{"sha512", "sha256"}); err != nil {
+		return err
+	}
+	if _, ok := args["rounds"]; ok {
+		if rounds, err := m.GetIntArg(args, "rounds", 0); err != nil || rounds < shaCryptMinRounds || rounds > shaCryptMaxRounds {
+			return types.NewValidationError("rounds", args["rounds"], fmt.Sprintf("rounds must be between %d and %d", shaCryptMinRounds, shaCryptMaxRounds))
+		}
+	}
+	return nil
+}
+
+// Run executes the password_rotate module
+func (m *PasswordRotateModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	password := m.GetStringArg(args, "password", "")
+	scheme := m.GetStringArg(args, "hash_scheme", "sha512")
+	rounds, _ := m.GetIntArg(args, "rounds", 0)
+
+	current, err := m.shadowHash(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+	// A leading ! locks the password without losing it
+	locked := strings.HasPrefix(current, "!")
+	current = strings.TrimLeft(current, "!")
+
+	data := map[string]interface{}{"name": name, "hash_scheme": scheme, "locked": locked}
+	result := m.CreateSuccessResult(hostname, false, "Password is already current", data)
+
+	hasNew, err := m.matches(ctx, conn, password, current)
+	if err != nil {
+		return nil, err
+	}
+	if hasNew {
+		return changeResult(m.BaseModule, result, "", checkMode, false, "", "", startTime), nil
+	}
+	if oldPassword, ok := args["old_password"]; ok {
+		hasOld, err := m.matches(ctx, conn, types.ConvertToString(oldPassword), current)
+		if err != nil {
+			return nil, err
+		}
+		if !hasOld {
+			return nil, fmt.Errorf("the password of %s matches neither old_password nor password; it was changed elsewhere", name)
+		}
+	}
+
+	hashed, err := newShaCryptHash(password, scheme, rounds)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		hashed = "!" + hashed
+	}
+	if !checkMode {
+		// printf is a shell builtin, so the hash does not show up in the
+		// process list
+		cmd := fmt.Sprintf("printf '%%s\\n' %s | chpasswd -e", m.cli.shellEscape(name+":"+hashed))
+		if _, err := m.cli.run(ctx, conn, "setting password of "+name, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, "rotated password of "+name, checkMode, false, "", "", startTime), nil
+}
+
+// shadowHash reads the password hash of an account from the shadow database
+func (m *PasswordRotateModule) shadowHash(ctx context.Context, conn types.Connection, name string) (string, error) {
+	output, exists, err := m.cli.inspect(ctx, conn, "account "+name, "getent passwd "+m.cli.shellEscape(name), "getent shadow "+m.cli.shellEscape(name))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("account %s does not exist", name)
+	}
+	fields := strings.Split(strings.TrimSpace(output), ":")
+	if len(fields) < 2 {
+		return "", fmt.Errorf("cannot read the shadow entry of %s; reading it requires root", name)
+	}
+	return fields[1], nil
+}
+
+// matches reports whether password matches hashed. SHA-crypt hashes are
+// checked locally; other schemes such as yescrypt are checked by the
+// host's crypt(3) through perl, with the password on stdin.
+func (m *PasswordRotateModule) matches(ctx context.Context, conn types.Connection, password, hashed string) (bool, error) {
+	if !strings.HasPrefix(hashed, "$") {
+		// Empty, * or ! entries accept no password
+		return false, nil
+	}
+	if match, supported := shaCryptVerify(password, hashed); supported {
+		return match, nil
+	}
+	cmd := fmt.Sprintf(`printf '%%s' %s | perl -e 'my $p = <STDIN>; print crypt($p, $ARGV[0]) eq $ARGV[0] ? "match" : "mismatch"' %s`,
+		m.cli.shellEscape(password), m.cli.shellEscape(hashed))
+	result, err := m.cli.run(ctx, conn, "verifying password", cmd)
+	if err != nil {
+		return false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return strings.TrimSpace(stdout) == "match", nil
+}
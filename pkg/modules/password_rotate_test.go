@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+const (
+	newPasswordHash = "$6$saltstring$xwVXmdoFwkuFfkvrCnFqepsb1G.0z7.VoLCq69.god.O.zXvYSQsLM/oDMuI05ufmibO/tzUYEh4IPt9kZAc0."
+	oldPasswordHash = "$6$saltstring$RheBayajNyG/422VR0agy4oPHwZLdKhAMu00sJIAT7gr42M3ryP7Q6eAxq6myFwbFaKYt27WSrRNbk2gym8eX0"
+)
+
+func TestShaCrypt(t *testing.T) {
+	// Test vectors of the SHA-crypt specification
+	vectors := []struct{ setting, password, hash string }{
+		{"$5$saltstring", "Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"$5$rounds=10000$saltstringsaltstring", "Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"$6$saltstring", "Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"$6$rounds=1400$anotherlongsaltstring", "Hello world!", "$6$rounds=1400$anotherlongsalts$5FGyu8c4BZDX4wJgs0Un26YOw2XibT5eTkHF1I1aP3QqStoJI9BHD2YPJYsAjEePVGUyBjdZxcNqMWlrrbIOC."},
+	}
+	for _, v := range vectors {
+		if hash, err := shaCrypt(v.password, v.setting); err != nil || hash != v.hash {
+			t.Errorf("shaCrypt(%q) = %q, %v, want %q", v.setting, hash, err, v.hash)
+		}
+	}
+
+	hash, err := newShaCryptHash("s3cret", "sha256", 6000)
+	if err != nil || !strings.HasPrefix(hash, "$5$rounds=6000$") {
+		t.Fatalf("unexpected hash %q, %v", hash, err)
+	}
+	if match, supported := shaCryptVerify("s3cret", hash); !match || !supported {
+		t.Errorf("expected the generated hash to verify")
+	}
+	if match, _ := shaCryptVerify("other", hash); match {
+		t.Errorf("expected another password not to verify")
+	}
+	if _, supported := shaCryptVerify("s3cret", "$y$j9T$salt$hash"); supported {
+		t.Errorf("expected yescrypt hashes to be unsupported")
+	}
+}
+
+func TestPasswordRotateModule(t *testing.T) {
+	module := NewPasswordRotateModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "deploy", "password": "s3cret", "rounds": 10000}, ExpectValid: true},
+		{Name: "MissingPassword", Args: map[string]interface{}{"name": "deploy"}, ExpectValid: false},
+		{Name: "Colon", Args: map[string]interface{}{"name": "deploy:x", "password": "s3cret"}, ExpectValid: false},
+		{Name: "FewRounds", Args: map[string]interface{}{"name": "deploy", "password": "s3cret", "rounds": 10}, ExpectValid: false},
+		{Name: "InvalidScheme", Args: map[string]interface{}{"name": "deploy", "password": "s3cret", "hash_scheme": "md5"}, ExpectValid: false},
+	})
+
+	shadow := func(hash string) *testhelper.CommandResponse {
+		return &testhelper.CommandResponse{Stdout: existsMarker + "deploy:" + hash + ":19800:0:99999:7:::\n"}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Rotate",
+			Args: map[string]interface{}{"name": "deploy", "old_password": "0ld-s3cret", "password": "s3cret"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if getent passwd 'deploy' .*getent shadow 'deploy'`, shadow(oldPasswordHash))
+				h.GetConnection().ExpectCommandPattern(`^printf '%s\\n' 'deploy:\$6\$[./0-9A-Za-z]{16}\$[./0-9A-Za-z]{86}' \| chpasswd -e$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Rotated password of deploy")
+				h.AssertDataValue(result, "locked", false)
+			},
+		},
+		{
+			Name: "AlreadyRotated",
+			Args: map[string]interface{}{"name": "deploy", "old_password": "0ld-s3cret", "password": "s3cret"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, shadow(newPasswordHash))
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`chpasswd`, 0)
+			},
+		},
+		{
+			Name:        "ChangedElsewhere",
+			Args:        map[string]interface{}{"name": "deploy", "old_password": "stale", "password": "s3cret"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, shadow(oldPasswordHash))
+			},
+		},
+		{
+			Name: "LockedYescrypt",
+			Args: map[string]interface{}{"name": "deploy", "old_password": "0ld-s3cret", "password": "s3cret", "hash_scheme": "sha256"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, shadow("!$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7"))
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: `printf '%s' 's3cret' | perl -e 'my $p = <STDIN>; print crypt($p, $ARGV[0]) eq $ARGV[0] ? "match" : "mismatch"' '$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7'`, Response: &testhelper.CommandResponse{Stdout: "mismatch"}},
+					testhelper.ExpectedCall{Command: `printf '%s' '0ld-s3cret' | perl -e 'my $p = <STDIN>; print crypt($p, $ARGV[0]) eq $ARGV[0] ? "match" : "mismatch"' '$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7'`, Response: &testhelper.CommandResponse{Stdout: "match"}},
+				)
+				h.GetConnection().ExpectCommandPattern(`^printf '%s\\n' 'deploy:!\$5\$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "locked", true)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "deploy", "password": "s3cret"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, shadow("*"))
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(`chpasswd`, 0)
+			},
+		},
+		{
+			Name:        "MissingAccount",
+			Args:        map[string]interface{}{"name": "nobody2", "password": "s3cret"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'nobody2'`, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
@@ -0,0 +1,180 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// defaultPausePrompt is shown when a pause waits for the operator without
+// a prompt of its own
+const defaultPausePrompt = "Press enter to continue, Ctrl+C to interrupt"
+
+// Prompter reaches the operator running gosible for the pause module. The
+// command line provides one reading the terminal; without it pauses still
+// wait, but prompts continue without input.
+type Prompter interface {
+	// Interactive reports whether the operator can answer prompts
+	Interactive() bool
+	// Announce shows a pause as it starts, with its prompt and how long it
+	// lasts, zero when it waits for the operator
+	Announce(prompt string, duration time.Duration)
+	// ReadInput reads a line typed by the operator, hiding it unless echo
+	ReadInput(ctx context.Context, echo bool) (string, error)
+}
+
+// PauseModule implements the pause module, which waits for a time or until
+// the operator answers a prompt
+type PauseModule struct {
+	*BaseModule
+	mu       sync.RWMutex
+	prompter Prompter
+}
+
+// NewPauseModule creates a new pause module
+func NewPauseModule() *PauseModule {
+	doc := types.ModuleDoc{
+		Name:        "pause",
+		Description: "Pause the run for a time or until the operator answers a prompt",
+		Parameters: map[string]types.ParamDoc{
+			"seconds": {
+				Description: "Seconds to pause for. Without seconds or minutes the pause waits for the operator",
+				Required:    false,
+				Type:        "int",
+			},
+			"minutes": {
+				Description: "Minutes to pause for, added to seconds",
+				Required:    false,
+				Type:        "int",
+			},
+			"prompt": {
+				Description: "Text shown to the operator. A pause waiting for the operator continues with no input when gosible is not attached to a terminal",
+				Required:    false,
+				Type:        "string",
+			},
+			"echo": {
+				Description: "Show what the operator types. Turn it off to read secrets",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			`- name: Let the load balancer drain
+  pause:
+    seconds: 30`,
+			`- name: Wait for the operator
+  pause:
+    prompt: Check the canary, then press enter`,
+			`- name: Ask for the deploy token
+  pause:
+    prompt: Deploy token
+    echo: false
+  register: token`,
+		},
+		Returns: map[string]string{
+			"user_input": "What the operator typed, empty for a timed pause or without a terminal",
+			"start":      "When the pause started",
+			"stop":       "When the pause ended",
+			"delta":      "Seconds the pause lasted",
+			"echo":       "Whether the input was shown",
+		},
+	}
+
+	base := NewBaseModule("pause", doc)
+	// Pausing changes nothing, so it runs alike in check mode
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     false,
+		Platform:     "any",
+		RequiresRoot: false,
+	})
+
+	return &PauseModule{
+		BaseModule: base,
+	}
+}
+
+// SetPrompter makes the module announce pauses and read answers through
+// prompter
+func (m *PauseModule) SetPrompter(prompter Prompter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompter = prompter
+}
+
+// Validate validates the module arguments
+func (m *PauseModule) Validate(args map[string]interface{}) error {
+	for _, key := range []string{"seconds", "minutes"} {
+		value, err := m.GetIntArg(args, key, 0)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %v", key, err)
+		}
+		if value < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+	}
+
+	fieldTypes := map[string]string{
+		"prompt": "string",
+		"echo":   "bool",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the pause module
+func (m *PauseModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+
+		seconds, _ := m.GetIntArg(args, "seconds", 0)
+		minutes, _ := m.GetIntArg(args, "minutes", 0)
+		duration := time.Duration(seconds)*time.Second + time.Duration(minutes)*time.Minute
+		prompt := m.GetStringArg(args, "prompt", "")
+		echo := m.GetBoolArg(args, "echo", true)
+
+		m.mu.RLock()
+		prompter := m.prompter
+		m.mu.RUnlock()
+
+		start := time.Now()
+		data := map[string]interface{}{"user_input": "", "echo": echo}
+		message := ""
+		if duration > 0 {
+			if prompter != nil {
+				prompter.Announce(prompt, duration)
+			}
+			select {
+			case <-ctx.Done():
+				return m.CreateErrorResult(host, "Pause interrupted", ctx.Err()), nil
+			case <-time.After(duration):
+			}
+			message = fmt.Sprintf("Paused for %s", duration)
+		} else {
+			if prompt == "" {
+				prompt = defaultPausePrompt
+			}
+			if prompter == nil || !prompter.Interactive() {
+				// Nobody can answer, so waiting would hang the run
+				message = "Not attached to a terminal, continuing without input"
+			} else {
+				prompter.Announce(prompt, 0)
+				input, err := prompter.ReadInput(ctx, echo)
+				if err != nil {
+					return m.CreateErrorResult(host, "Failed to read the operator's input", err), nil
+				}
+				data["user_input"] = input
+				message = "Paused until the operator continued"
+			}
+		}
+
+		stop := time.Now()
+		data["start"] = start.Format(time.RFC3339)
+		data["stop"] = stop.Format(time.RFC3339)
+		data["delta"] = int(stop.Sub(start).Seconds())
+		return m.CreateSuccessResult(host, false, message, data), nil
+	})
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+// fakePrompter answers prompts with a fixed line
+type fakePrompter struct {
+	interactive bool
+	input       string
+	announced   []string
+	echo        []bool
+}
+
+func (p *fakePrompter) Interactive() bool { return p.interactive }
+
+func (p *fakePrompter) Announce(prompt string, duration time.Duration) {
+	p.announced = append(p.announced, prompt+"|"+duration.String())
+}
+
+func (p *fakePrompter) ReadInput(ctx context.Context, echo bool) (string, error) {
+	p.echo = append(p.echo, echo)
+	return p.input, nil
+}
+
+func TestPauseModule(t *testing.T) {
+	ctx := context.Background()
+	conn := testhelper.NewMockConnection(t)
+
+	t.Run("Prompt", func(t *testing.T) {
+		prompter := &fakePrompter{interactive: true, input: "s3cret"}
+		m := NewPauseModule()
+		m.SetPrompter(prompter)
+
+		result, err := m.Run(ctx, conn, map[string]interface{}{"prompt": "Token", "echo": false})
+		if err != nil || !result.Success || result.Changed {
+			t.Fatalf("expected an unchanged success, got %+v (%v)", result, err)
+		}
+		if result.Data["user_input"] != "s3cret" {
+			t.Errorf("expected the operator's input, got %v", result.Data["user_input"])
+		}
+		if len(prompter.announced) != 1 || prompter.announced[0] != "Token|0s" || len(prompter.echo) != 1 || prompter.echo[0] {
+			t.Errorf("expected the prompt to be read without echo, got %v %v", prompter.announced, prompter.echo)
+		}
+	})
+
+	t.Run("NotInteractive", func(t *testing.T) {
+		for _, prompter := range []Prompter{nil, &fakePrompter{input: "ignored"}} {
+			m := NewPauseModule()
+			if prompter != nil {
+				m.SetPrompter(prompter)
+			}
+			result, _ := m.Run(ctx, conn, map[string]interface{}{})
+			if !result.Success || result.Data["user_input"] != "" {
+				t.Errorf("expected the pause to continue without input, got %+v", result)
+			}
+		}
+	})
+
+	t.Run("Timed", func(t *testing.T) {
+		prompter := &fakePrompter{interactive: true}
+		m := NewPauseModule()
+		m.SetPrompter(prompter)
+
+		start := time.Now()
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"seconds": "1", "prompt": "Draining"})
+		if !result.Success || time.Since(start) < time.Second {
+			t.Errorf("expected a one second pause, got %+v after %s", result, time.Since(start))
+		}
+		if len(prompter.announced) != 1 || prompter.announced[0] != "Draining|1s" || len(prompter.echo) != 0 {
+			t.Errorf("expected an announced pause without input, got %v %v", prompter.announced, prompter.echo)
+		}
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		result, _ := NewPauseModule().Run(cancelled, conn, map[string]interface{}{"minutes": 5})
+		if result.Success {
+			t.Error("expected an interrupted pause to fail")
+		}
+	})
+}
+
+func TestPauseModule_Validate(t *testing.T) {
+	m := NewPauseModule()
+	for _, args := range []map[string]interface{}{
+		{"seconds": -1},
+		{"minutes": "soon"},
+		{"echo": "maybe"},
+	} {
+		if err := m.Validate(args); err == nil {
+			t.Errorf("expected %v to be invalid", args)
+		}
+	}
+	if err := m.Validate(map[string]interface{}{"minutes": "2", "prompt": "Go?"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -22,6 +22,12 @@ func NewPingModule() *PingModule {
 
 // Run executes the ping module
 func (m *PingModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *PingModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	result := &types.Result{
 		Success: true,
 		Changed: false,
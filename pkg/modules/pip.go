@@ -17,7 +17,9 @@ type PipModule struct {
 // NewPipModule creates a new pip module instance
 func NewPipModule() *PipModule {
 	return &PipModule{
-		BaseModule: BaseModule{},
+		BaseModule: BaseModule{
+			name: "pip",
+		},
 	}
 }
 
@@ -82,6 +84,9 @@ func (m *PipModule) Run(ctx context.Context, conn types.Connection, args map[str
 		pipCmd = executable
 	} else if virtualenv != "" {
 		pipCmd = fmt.Sprintf("%s/bin/pip", virtualenv)
+	} else if python := m.GetTaskVar(args, "ansible_python_interpreter", ""); python != "" {
+		// Install for the interpreter tasks run with, not whichever pip is first on PATH
+		pipCmd = python + " -m pip"
 	}
 
 	// Create virtualenv if needed
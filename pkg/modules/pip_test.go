@@ -2,6 +2,8 @@ package modules
 
 import (
 	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
 )
 
 func TestPipModule(t *testing.T) {
@@ -80,4 +82,18 @@ func TestPipModule(t *testing.T) {
 			})
 		}
 	})
-}
\ No newline at end of file
+	t.Run("DiscoveredInterpreter", func(t *testing.T) {
+		helper := testhelper.NewModuleTestHelper(t, NewPipModule())
+		helper.GetConnection().
+			ExpectCommand("/usr/bin/python3 -m pip show requests 2>/dev/null", &testhelper.CommandResponse{ExitCode: 1}).
+			ExpectCommand("/usr/bin/python3 -m pip install requests", &testhelper.CommandResponse{})
+
+		result := helper.Execute(map[string]interface{}{
+			"name":       "requests",
+			"_task_vars": map[string]interface{}{"ansible_python_interpreter": "/usr/bin/python3"},
+		}, false, false)
+		helper.AssertSuccess(result)
+		helper.AssertChanged(result)
+		helper.Verify()
+	})
+}
@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// defaultLockTimeout is how many seconds package modules wait for another
+// process to release the package database lock
+const defaultLockTimeout = 60
+
+// lockRetryInterval is the pause between attempts while the lock is held
+var lockRetryInterval = 5 * time.Second
+
+// packageLockMessages are printed by apt, dpkg, yum, dnf, rpm, zypper, apk
+// and pacman when another process (often unattended-upgrades or a
+// cloud-init run) holds the package database lock
+var packageLockMessages = []string{
+	"could not get lock",
+	"unable to acquire the dpkg frontend lock",
+	"unable to lock the administration directory",
+	"is another process using it",
+	"another app is currently holding the yum lock",
+	"existing lock /var/run/yum.pid",
+	"waiting for process with pid",
+	"failed to obtain the transaction lock",
+	"can't create transaction lock",
+	"system management is locked",
+	"unable to lock database",
+}
+
+// isPackageLockError reports whether a failed command failed because the
+// package database was locked
+func isPackageLockError(result *types.Result, err error) bool {
+	if err == nil && (result == nil || result.Success) {
+		return false
+	}
+
+	var output strings.Builder
+	if err != nil {
+		output.WriteString(err.Error())
+	}
+	if result != nil && result.Data != nil {
+		stdout, _ := result.Data["stdout"].(string)
+		stderr, _ := result.Data["stderr"].(string)
+		output.WriteString(stdout)
+		output.WriteString(stderr)
+	}
+
+	text := strings.ToLower(output.String())
+	for _, message := range packageLockMessages {
+		if strings.Contains(text, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockTimeoutArg returns the lock_timeout argument
+func (m *BaseModule) lockTimeoutArg(args map[string]interface{}) (time.Duration, error) {
+	seconds, err := m.GetIntArg(args, "lock_timeout", defaultLockTimeout)
+	if err != nil {
+		return 0, types.NewValidationError("lock_timeout", args["lock_timeout"], "lock_timeout must be a number of seconds")
+	}
+	if seconds < 0 {
+		return 0, types.NewValidationError("lock_timeout", seconds, "lock_timeout cannot be negative")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// executeWithLockWait runs a package manager command, retrying it while
+// another process holds the package database lock, for up to timeout
+func (m *BaseModule) executeWithLockWait(ctx context.Context, conn types.Connection, cmd string, timeout time.Duration) (*types.Result, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		if !isPackageLockError(result, err) {
+			return result, err
+		}
+		if time.Now().Add(lockRetryInterval).After(deadline) {
+			if err != nil {
+				err = fmt.Errorf("package manager lock still held after %s: %w", timeout, err)
+			}
+			return result, err
+		}
+
+		m.LogDebug("Package manager lock is held, retrying in %s", lockRetryInterval)
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func lockedResult(stderr string) *types.Result {
+	return &types.Result{
+		Success: false,
+		Data:    map[string]interface{}{"stdout": "", "stderr": stderr, "exit_code": 100},
+	}
+}
+
+func TestAptModule_WaitsForLock(t *testing.T) {
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = time.Millisecond
+
+	module := NewAptModule()
+	ctx := context.Background()
+	mockConn := new(MockConnection)
+	cmd := "DEBIAN_FRONTEND=noninteractive apt install -y nginx"
+
+	locked := lockedResult("E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (unattended-upgr)")
+	mockConn.On("Execute", ctx, cmd, types.ExecuteOptions{}).Return(locked, fmt.Errorf("exit status 100")).Twice()
+	mockConn.On("Execute", ctx, cmd, types.ExecuteOptions{}).Return(&types.Result{
+		Success: true,
+		Data:    map[string]interface{}{"stdout": "1 newly installed"},
+	}, nil).Once()
+
+	result, err := module.Run(ctx, mockConn, map[string]interface{}{"name": "nginx"})
+
+	assert.NoError(t, err)
+	assert.True(t, result.Success)
+	assert.True(t, result.Changed)
+	mockConn.AssertExpectations(t)
+}
+
+func TestDnfModule_LockTimeout(t *testing.T) {
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = time.Millisecond
+
+	module := NewDnfModule()
+	ctx := context.Background()
+	mockConn := new(MockConnection)
+
+	// With no time to wait the lock error is reported after one attempt
+	locked := lockedResult("Waiting for process with pid 4321 to finish.")
+	mockConn.On("Execute", ctx, "dnf install -y  httpd", types.ExecuteOptions{}).Return(locked, fmt.Errorf("exit status 1")).Once()
+
+	result, err := module.Run(ctx, mockConn, map[string]interface{}{"name": "httpd", "lock_timeout": 0})
+
+	assert.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "lock still held")
+	mockConn.AssertExpectations(t)
+}
+
+func TestIsPackageLockError(t *testing.T) {
+	assert.True(t, isPackageLockError(lockedResult("Existing lock /var/run/yum.pid: another copy is running as pid 99."), nil))
+	assert.True(t, isPackageLockError(nil, fmt.Errorf("E: Unable to acquire the dpkg frontend lock")))
+	assert.False(t, isPackageLockError(lockedResult("E: Unable to locate package nosuch"), nil))
+	assert.False(t, isPackageLockError(&types.Result{Success: true, Data: map[string]interface{}{"stdout": "could not get lock"}}, nil))
+
+	module := NewYumModule()
+	assert.Error(t, module.Validate(map[string]interface{}{"name": "httpd", "lock_timeout": -1}))
+	assert.NoError(t, module.Validate(map[string]interface{}{"name": "httpd", "lock_timeout": "120"}))
+}
@@ -0,0 +1,97 @@
+package modules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// PluginModulesSymbol is the function a Go plugin exports to provide its
+// modules, of type func() []types.Module
+const PluginModulesSymbol = "GosibleModules"
+
+// LoadPluginModules opens the Go plugin at path and returns the modules it
+// provides. Go plugins must be built with the same Go version and versions
+// of the packages they share with gosible.
+func LoadPluginModules(path string) ([]types.Module, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin %s: %w", path, err)
+	}
+	symbol, err := p.Lookup(PluginModulesSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s does not export %s: %w", path, PluginModulesSymbol, err)
+	}
+	provide, ok := symbol.(func() []types.Module)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s exports %s as %T, expected func() []types.Module", path, PluginModulesSymbol, symbol)
+	}
+	return provide(), nil
+}
+
+// LoadModulePath registers the third-party modules in directories, like
+// Ansible's library path: Go plugins ending in .so, and executables
+// speaking the external module protocol (see ExternalModule). Other files
+// are ignored. A module may not replace one already registered. The names
+// of the modules loaded are returned.
+func (r *ModuleRegistry) LoadModulePath(dirs ...string) ([]string, error) {
+	var loaded []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to read module path: %w", err)
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+
+			var found []types.Module
+			if filepath.Ext(path) == ".so" {
+				found, err = LoadPluginModules(path)
+			} else if info, statErr := entry.Info(); statErr == nil && info.Mode()&0111 != 0 {
+				var module *ExternalModule
+				if module, err = LoadExternalModule(path); err == nil {
+					found = []types.Module{module}
+				}
+			}
+			if err != nil {
+				return loaded, err
+			}
+
+			for _, module := range found {
+				if err := r.registerNew(module, path); err != nil {
+					return loaded, err
+				}
+				loaded = append(loaded, module.Name())
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// registerNew registers a module loaded from source, refusing to replace
+// a registered module
+func (r *ModuleRegistry) registerNew(module types.Module, source string) error {
+	if module == nil || module.Name() == "" {
+		return fmt.Errorf("%s provides a module without a name", source)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.modules[module.Name()]; exists {
+		return fmt.Errorf("module %s from %s conflicts with a registered module", module.Name(), source)
+	}
+	r.modules[module.Name()] = module
+	return nil
+}
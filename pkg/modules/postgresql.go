@@ -0,0 +1,849 @@
+package modules
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// postgresConnectionParams documents the options shared by the PostgreSQL
+// modules
+var postgresConnectionParams = map[string]types.ParamDoc{
+	"login_user": {
+		Description: "Role to connect as",
+		Required:    false,
+		Type:        "string",
+		Default:     "postgres",
+	},
+	"login_password": {
+		Description: "Password of login_user; passed to psql in PGPASSWORD",
+		Required:    false,
+		Type:        "string",
+	},
+	"login_host": {
+		Description: "Server to connect to over TCP; the local Unix socket is used when omitted",
+		Required:    false,
+		Type:        "string",
+	},
+	"login_port": {
+		Description: "Server port",
+		Required:    false,
+		Type:        "int",
+		Default:     5432,
+	},
+	"login_unix_socket": {
+		Description: "Directory of the Unix socket, when not the client's default",
+		Required:    false,
+		Type:        "path",
+	},
+	"login_db": {
+		Description: "Database to connect to for cluster-wide changes",
+		Required:    false,
+		Type:        "string",
+		Default:     "postgres",
+	},
+}
+
+// postgresClient drives psql on the target host. Peer authentication over
+// the Unix socket needs the task to run as the database superuser's system
+// account, usually with become_user: postgres.
+type postgresClient struct {
+	remoteCLI
+	user     string
+	password string
+	host     string
+	port     int
+	loginDB  string
+}
+
+// newPostgresClient reads the connection options from module arguments
+func newPostgresClient(m *BaseModule, args map[string]interface{}) (*postgresClient, error) {
+	port, err := m.GetIntArg(args, "login_port", 5432)
+	if err != nil {
+		return nil, types.NewValidationError("login_port", args["login_port"], "login_port must be an integer")
+	}
+	host := m.GetStringArg(args, "login_host", "")
+	if host == "" {
+		host = m.GetStringArg(args, "login_unix_socket", "")
+	}
+	return &postgresClient{
+		user:     m.GetStringArg(args, "login_user", "postgres"),
+		password: m.GetStringArg(args, "login_password", ""),
+		host:     host,
+		port:     port,
+		loginDB:  m.GetStringArg(args, "login_db", "postgres"),
+	}, nil
+}
+
+// psql returns the psql invocation for db with unaligned, tab separated
+// output and no psqlrc
+func (c *postgresClient) psql(db string) string {
+	cmd := "psql -AtqX -v ON_ERROR_STOP=1 -F '\t'"
+	if c.host != "" {
+		cmd += " -h " + c.shellEscape(c.host)
+	}
+	cmd += fmt.Sprintf(" -p %d", c.port)
+	if c.user != "" {
+		cmd += " -U " + c.shellEscape(c.user)
+	}
+	return cmd + " -d " + c.shellEscape(db)
+}
+
+// executeOptions passes the login password in the environment
+func (c *postgresClient) executeOptions() types.ExecuteOptions {
+	if c.password == "" {
+		return types.ExecuteOptions{}
+	}
+	return types.ExecuteOptions{Env: map[string]string{"PGPASSWORD": c.password}}
+}
+
+// query runs a read-only query in db and returns its rows
+func (c *postgresClient) query(ctx context.Context, conn types.Connection, db, sql string) ([][]string, error) {
+	result, err := conn.Execute(ctx, c.psql(db)+" -c "+c.shellEscape(sql), c.executeOptions())
+	if err != nil {
+		return nil, fmt.Errorf("psql failed: %w", err)
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("psql failed: %s", commandStderr(result))
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	var rows [][]string
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows, nil
+}
+
+// exec runs statements in db one after another. psql commits each on its
+// own, which CREATE DATABASE and DROP DATABASE require.
+func (c *postgresClient) exec(ctx context.Context, conn types.Connection, db string, statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	script := strings.Join(statements, ";\n") + ";\n"
+	result, err := conn.Execute(ctx, fmt.Sprintf("printf '%%s' %s | %s -f -", c.shellEscape(script), c.psql(db)), c.executeOptions())
+	if err != nil {
+		return fmt.Errorf("psql failed: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("psql failed: %s", commandStderr(result))
+	}
+	return nil
+}
+
+// pgIdent quotes a PostgreSQL identifier
+func pgIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// pgLiteral quotes a PostgreSQL string literal
+func pgLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// pgTable quotes a table name, which may be qualified with its schema
+func pgTable(table string) (schema, name, quoted string) {
+	schema, name, found := strings.Cut(table, ".")
+	if !found {
+		schema, name = "public", table
+	}
+	return schema, name, pgIdent(schema) + "." + pgIdent(name)
+}
+
+// PostgreSQLDBModule creates and drops PostgreSQL databases
+type PostgreSQLDBModule struct {
+	*BaseModule
+}
+
+// NewPostgreSQLDBModule creates a new postgresql_db module instance
+func NewPostgreSQLDBModule() *PostgreSQLDBModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "Database name",
+			Required:    true,
+			Type:        "string",
+		},
+		"state": {
+			Description: "Whether the database exists",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+		"owner": {
+			Description: "Role owning the database",
+			Required:    false,
+			Type:        "string",
+		},
+		"template": {
+			Description: "Template to create the database from",
+			Required:    false,
+			Type:        "string",
+		},
+		"encoding": {
+			Description: "Character encoding, e.g. UTF8; fixed once the database exists",
+			Required:    false,
+			Type:        "string",
+		},
+		"lc_collate": {
+			Description: "Collation order; fixed once the database exists",
+			Required:    false,
+			Type:        "string",
+		},
+		"lc_ctype": {
+			Description: "Character classification; fixed once the database exists",
+			Required:    false,
+			Type:        "string",
+		},
+		"conn_limit": {
+			Description: "Maximum concurrent connections, -1 for no limit",
+			Required:    false,
+			Type:        "int",
+		},
+	}
+	for name, doc := range postgresConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "postgresql_db",
+		Description: "Create, change the owner and connection limit of, and drop PostgreSQL databases with psql on the target host",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Create the application database\n  postgresql_db:\n    name: app\n    owner: app\n    encoding: UTF8\n    template: template0\n  become: true\n  become_user: postgres",
+			"- name: Drop a database over TCP\n  postgresql_db:\n    name: scratch\n    state: absent\n    login_host: db.example.com\n    login_user: admin\n    login_password: \"{{ vault_pg_admin }}\"",
+		},
+		Returns: map[string]string{
+			"name":    "Database name",
+			"queries": "Statements that were run",
+		},
+	}
+
+	base := NewBaseModule("postgresql_db", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &PostgreSQLDBModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *PostgreSQLDBModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if _, err := m.GetIntArg(args, "conn_limit", -1); err != nil {
+		return types.NewValidationError("conn_limit", args["conn_limit"], "conn_limit must be an integer")
+	}
+	_, err := newPostgresClient(m.BaseModule, args)
+	return err
+}
+
+// Run executes the postgresql_db module
+func (m *PostgreSQLDBModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	client, err := newPostgresClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+
+	rows, err := client.query(ctx, conn, client.loginDB, "SELECT pg_catalog.pg_get_userbyid(datdba), pg_catalog.pg_encoding_to_char(encoding), datcollate, datctype, datconnlimit FROM pg_catalog.pg_database WHERE datname = "+pgLiteral(name))
+	if err != nil {
+		return nil, err
+	}
+
+	// settings holds owner, encoding, lc_collate, lc_ctype and conn_limit
+	keys := []string{"owner", "encoding", "lc_collate", "lc_ctype", "conn_limit"}
+	var current map[string]string
+	if len(rows) > 0 && len(rows[0]) == len(keys) {
+		current = make(map[string]string)
+		for i, key := range keys {
+			current[key] = rows[0][i]
+		}
+	}
+	wanted := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := args[key]; ok && types.ConvertToString(value) != "" {
+			wanted[key] = types.ConvertToString(value)
+		}
+	}
+
+	var statements, changes []string
+	desired := current
+	switch {
+	case state == "absent" && current != nil:
+		statements = append(statements, "DROP DATABASE "+pgIdent(name))
+		changes = append(changes, "dropped database "+name)
+		desired = nil
+	case state == "present" && current == nil:
+		sql := "CREATE DATABASE " + pgIdent(name)
+		if owner := wanted["owner"]; owner != "" {
+			sql += " OWNER " + pgIdent(owner)
+		}
+		if template := m.GetStringArg(args, "template", ""); template != "" {
+			sql += " TEMPLATE " + pgIdent(template)
+		}
+		for _, key := range []string{"encoding", "lc_collate", "lc_ctype"} {
+			if value := wanted[key]; value != "" {
+				sql += fmt.Sprintf(" %s %s", strings.ToUpper(key), pgLiteral(value))
+			}
+		}
+		if limit := wanted["conn_limit"]; limit != "" {
+			sql += " CONNECTION LIMIT " + limit
+		}
+		statements = append(statements, sql)
+		changes = append(changes, "created database "+name)
+		desired = wanted
+	case state == "present":
+		for _, key := range []string{"encoding", "lc_collate", "lc_ctype"} {
+			if value := wanted[key]; value != "" && !strings.EqualFold(value, current[key]) {
+				return nil, fmt.Errorf("%s of database %s is %s and cannot be changed to %s", key, name, current[key], value)
+			}
+		}
+		desired = make(map[string]string)
+		for key, value := range current {
+			desired[key] = value
+		}
+		if owner := wanted["owner"]; owner != "" && owner != current["owner"] {
+			statements = append(statements, fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", pgIdent(name), pgIdent(owner)))
+			changes = append(changes, fmt.Sprintf("changed the owner of database %s to %s", name, owner))
+			desired["owner"] = owner
+		}
+		if limit := wanted["conn_limit"]; limit != "" && limit != current["conn_limit"] {
+			statements = append(statements, fmt.Sprintf("ALTER DATABASE %s CONNECTION LIMIT %s", pgIdent(name), limit))
+			changes = append(changes, fmt.Sprintf("set the connection limit of database %s to %s", name, limit))
+			desired["conn_limit"] = limit
+		}
+	}
+
+	if !checkMode {
+		if err := client.exec(ctx, conn, client.loginDB, statements); err != nil {
+			return nil, err
+		}
+	}
+
+	describe := func(settings map[string]string) string {
+		if settings == nil {
+			return ""
+		}
+		out := "database " + name + "\n"
+		for _, key := range keys {
+			if value := settings[key]; value != "" {
+				out += fmt.Sprintf("%s: %s\n", key, value)
+			}
+		}
+		return out
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "Database is already in desired state", map[string]interface{}{
+		"name":    name,
+		"state":   state,
+		"queries": statements,
+	})
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, describe(current), describe(desired), startTime), nil
+}
+
+// pgRoleAttributes are the role attributes role_attr_flags sets, with the
+// pg_roles column holding each
+var pgRoleAttributes = []struct{ flag, column string }{
+	{"SUPERUSER", "rolsuper"},
+	{"CREATEROLE", "rolcreaterole"},
+	{"CREATEDB", "rolcreatedb"},
+	{"INHERIT", "rolinherit"},
+	{"LOGIN", "rolcanlogin"},
+	{"REPLICATION", "rolreplication"},
+	{"BYPASSRLS", "rolbypassrls"},
+}
+
+// pgPrivileges are the privileges that can be granted on databases and
+// tables, which ALL expands to
+var pgPrivileges = map[string][]string{
+	"database": {"CREATE", "CONNECT", "TEMPORARY"},
+	"table":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+}
+
+// pgGrant is a set of privileges on the database, when table is empty, or
+// on one of its tables
+type pgGrant struct {
+	table string
+	privs []string
+}
+
+// parsePgPrivileges parses a priv string such as
+// "CONNECT/products:SELECT,INSERT/public.orders:ALL", where entries without
+// a table name hold database privileges
+func parsePgPrivileges(spec string) ([]pgGrant, error) {
+	var grants []pgGrant
+	for _, entry := range strings.Split(spec, "/") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		table, privs, found := strings.Cut(entry, ":")
+		if !found {
+			table, privs = "", entry
+		}
+		kind := "table"
+		if table == "" {
+			kind = "database"
+		}
+
+		set := make(map[string]bool)
+		for _, priv := range strings.Split(privs, ",") {
+			priv = strings.ToUpper(strings.TrimSpace(priv))
+			switch {
+			case priv == "ALL" || priv == "ALL PRIVILEGES":
+				for _, p := range pgPrivileges[kind] {
+					set[p] = true
+				}
+			case priv == "TEMP" && kind == "database":
+				set["TEMPORARY"] = true
+			case contains(pgPrivileges[kind], priv):
+				set[priv] = true
+			default:
+				return nil, fmt.Errorf("invalid %s privilege %q", kind, priv)
+			}
+		}
+		grants = append(grants, pgGrant{table: strings.TrimSpace(table), privs: setMembers(set)})
+	}
+	return grants, nil
+}
+
+// setMembers returns the members of a set in sorted order
+func setMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member, in := range set {
+		if in {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// pgPasswordMatches reports whether a role's stored password is password.
+// known is false when the stored form cannot be checked.
+func pgPasswordMatches(stored, role, password string) (match, known bool) {
+	switch {
+	case stored == "":
+		return false, true
+	case stored == password:
+		// The password was given already hashed
+		return true, true
+	case strings.HasPrefix(stored, "md5") && len(stored) == 35:
+		sum := md5.Sum([]byte(password + role))
+		return stored == "md5"+hex.EncodeToString(sum[:]), true
+	case strings.HasPrefix(stored, "SCRAM-SHA-256$"):
+		// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+		parts := strings.Split(strings.TrimPrefix(stored, "SCRAM-SHA-256$"), "$")
+		if len(parts) != 2 {
+			return false, false
+		}
+		iterations, salt, _ := strings.Cut(parts[0], ":")
+		storedKey, serverKey, _ := strings.Cut(parts[1], ":")
+		count, err := strconv.Atoi(iterations)
+		if err != nil {
+			return false, false
+		}
+		saltBytes, err := base64.StdEncoding.DecodeString(salt)
+		if err != nil {
+			return false, false
+		}
+		salted, err := pbkdf2.Key(sha256.New, password, saltBytes, count, sha256.Size)
+		if err != nil {
+			return false, false
+		}
+		mac := func(key []byte, message string) []byte {
+			h := hmac.New(sha256.New, key)
+			h.Write([]byte(message))
+			return h.Sum(nil)
+		}
+		clientKey := sha256.Sum256(mac(salted, "Client Key"))
+		return base64.StdEncoding.EncodeToString(clientKey[:]) == storedKey &&
+			base64.StdEncoding.EncodeToString(mac(salted, "Server Key")) == serverKey, true
+	}
+	return false, false
+}
+
+// PostgreSQLUserModule manages PostgreSQL roles, their attributes and their
+// privileges on a database and its tables
+type PostgreSQLUserModule struct {
+	*BaseModule
+}
+
+// NewPostgreSQLUserModule creates a new postgresql_user module instance
+func NewPostgreSQLUserModule() *PostgreSQLUserModule {
+	params := map[string]types.ParamDoc{
+		"name": {
+			Description: "Role name",
+			Required:    true,
+			Type:        "string",
+		},
+		"password": {
+			Description: "Password, in clear text or already hashed as md5... or SCRAM-SHA-256$...",
+			Required:    false,
+			Type:        "string",
+		},
+		"update_password": {
+			Description: "always sets the password when it differs from the stored one, on_create only sets it on new roles",
+			Required:    false,
+			Type:        "string",
+			Default:     "always",
+			Choices:     []string{"always", "on_create"},
+		},
+		"role_attr_flags": {
+			Description: "Role attributes such as CREATEDB or NOSUPERUSER, as a list or comma separated; new roles get LOGIN unless NOLOGIN is given",
+			Required:    false,
+			Type:        "list",
+		},
+		"conn_limit": {
+			Description: "Maximum concurrent connections of the role, -1 for no limit",
+			Required:    false,
+			Type:        "int",
+		},
+		"db": {
+			Description: "Database priv applies to",
+			Required:    false,
+			Type:        "string",
+		},
+		"priv": {
+			Description: "Privileges to grant, e.g. CONNECT/products:SELECT,INSERT; entries without a table name are database privileges",
+			Required:    false,
+			Type:        "string",
+		},
+		"state": {
+			Description: "present creates the role and grants priv, absent revokes priv and drops the role",
+			Required:    false,
+			Type:        "string",
+			Default:     "present",
+			Choices:     []string{"present", "absent"},
+		},
+	}
+	for name, doc := range postgresConnectionParams {
+		params[name] = doc
+	}
+
+	doc := types.ModuleDoc{
+		Name:        "postgresql_user",
+		Description: "Create, change and drop PostgreSQL roles and grant or revoke their privileges on a database and its tables",
+		Parameters:  params,
+		Examples: []string{
+			"- name: Create the application role\n  postgresql_user:\n    name: app\n    password: \"{{ vault_app_db_password }}\"\n    role_attr_flags: CREATEDB,NOSUPERUSER\n    db: app\n    priv: CONNECT/orders:SELECT,INSERT,UPDATE\n  become: true\n  become_user: postgres",
+			"- name: Remove a role and its grants\n  postgresql_user:\n    name: legacy\n    db: app\n    priv: ALL\n    state: absent",
+		},
+		Returns: map[string]string{
+			"name":    "Role name",
+			"queries": "Statements that were run, with passwords masked",
+		},
+	}
+
+	base := NewBaseModule("postgresql_user", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "any",
+	})
+
+	return &PostgreSQLUserModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *PostgreSQLUserModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "update_password", []string{"always", "on_create"}); err != nil {
+		return err
+	}
+	if _, err := pgRoleFlags(args["role_attr_flags"]); err != nil {
+		return types.NewValidationError("role_attr_flags", args["role_attr_flags"], err.Error())
+	}
+	if _, err := m.GetIntArg(args, "conn_limit", -1); err != nil {
+		return types.NewValidationError("conn_limit", args["conn_limit"], "conn_limit must be an integer")
+	}
+	if priv := m.GetStringArg(args, "priv", ""); priv != "" {
+		if m.GetStringArg(args, "db", "") == "" {
+			return types.NewValidationError("db", nil, "db is required with priv")
+		}
+		if _, err := parsePgPrivileges(priv); err != nil {
+			return types.NewValidationError("priv", priv, err.Error())
+		}
+	}
+	_, err := newPostgresClient(m.BaseModule, args)
+	return err
+}
+
+// pgRoleFlags parses role_attr_flags into the attributes to turn on and off
+func pgRoleFlags(value interface{}) (map[string]bool, error) {
+	flags := make(map[string]bool)
+	for _, item := range stringList(value) {
+		for _, flag := range strings.Split(item, ",") {
+			flag = strings.ToUpper(strings.TrimSpace(flag))
+			if flag == "" {
+				continue
+			}
+			on := !strings.HasPrefix(flag, "NO")
+			name := strings.TrimPrefix(flag, "NO")
+			known := false
+			for _, attr := range pgRoleAttributes {
+				known = known || attr.flag == name
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown role attribute %q", flag)
+			}
+			flags[name] = on
+		}
+	}
+	return flags, nil
+}
+
+// Run executes the postgresql_user module
+func (m *PostgreSQLUserModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	client, err := newPostgresClient(m.BaseModule, args)
+	if err != nil {
+		return nil, err
+	}
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	password := m.GetStringArg(args, "password", "")
+	db := m.GetStringArg(args, "db", "")
+	flags, _ := pgRoleFlags(args["role_attr_flags"])
+	grants, _ := parsePgPrivileges(m.GetStringArg(args, "priv", ""))
+
+	columns := make([]string, 0, len(pgRoleAttributes)+1)
+	for _, attr := range pgRoleAttributes {
+		columns = append(columns, attr.column)
+	}
+	rows, err := client.query(ctx, conn, client.loginDB, fmt.Sprintf("SELECT %s, rolconnlimit FROM pg_catalog.pg_roles WHERE rolname = %s", strings.Join(columns, ", "), pgLiteral(name)))
+	if err != nil {
+		return nil, err
+	}
+	exists := len(rows) > 0 && len(rows[0]) == len(columns)+1
+
+	// Role attributes and the connection limit, before and after
+	current := make(map[string]bool)
+	currentLimit := ""
+	if exists {
+		for i, attr := range pgRoleAttributes {
+			current[attr.flag] = rows[0][i] == "t"
+		}
+		currentLimit = rows[0][len(columns)]
+	}
+
+	// Privileges currently granted to the role, per grant of priv
+	granted := make([][]string, len(grants))
+	for i, grant := range grants {
+		if !exists {
+			continue
+		}
+		var rows [][]string
+		if grant.table == "" {
+			rows, err = client.query(ctx, conn, client.loginDB, fmt.Sprintf("SELECT a.privilege_type FROM pg_catalog.pg_database d, pg_catalog.aclexplode(d.datacl) a WHERE d.datname = %s AND a.grantee = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = %s)", pgLiteral(db), pgLiteral(name)))
+		} else {
+			schema, table, _ := pgTable(grant.table)
+			rows, err = client.query(ctx, conn, db, fmt.Sprintf("SELECT privilege_type FROM information_schema.table_privileges WHERE grantee = %s AND table_schema = %s AND table_name = %s", pgLiteral(name), pgLiteral(schema), pgLiteral(table)))
+		}
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]bool)
+		for _, row := range rows {
+			set[row[0]] = true
+		}
+		granted[i] = setMembers(set)
+	}
+
+	// Statements run in the login database come first, so grants on
+	// tables of db find the role in place
+	var roleStatements, dbStatements, changes []string
+	var before, after strings.Builder
+	describeRole := func(out *strings.Builder, present bool, attrs map[string]bool, limit string) {
+		if !present {
+			return
+		}
+		var on []string
+		for _, attr := range pgRoleAttributes {
+			if attrs[attr.flag] {
+				on = append(on, attr.flag)
+			}
+		}
+		fmt.Fprintf(out, "role %s\nattributes: %s\nconn_limit: %s\n", name, strings.Join(on, ", "), limit)
+	}
+	describeRole(&before, exists, current, currentLimit)
+
+	if state == "present" {
+		desired := make(map[string]bool)
+		for flag, on := range current {
+			desired[flag] = on
+		}
+		if !exists {
+			desired["LOGIN"] = true
+		}
+		var options []string
+		for _, attr := range pgRoleAttributes {
+			on, given := flags[attr.flag]
+			if !given {
+				on = desired[attr.flag]
+			}
+			if on != current[attr.flag] || (!exists && given) {
+				option := attr.flag
+				if !on {
+					option = "NO" + option
+				}
+				options = append(options, option)
+			}
+			desired[attr.flag] = on
+		}
+
+		limit := currentLimit
+		if value, ok := args["conn_limit"]; ok {
+			if n, _ := types.ConvertToInt(value); strconv.Itoa(n) != currentLimit {
+				limit = strconv.Itoa(n)
+				options = append(options, "CONNECTION LIMIT "+limit)
+			}
+		}
+		if !exists && limit == "" {
+			limit = "-1"
+		}
+
+		describeRole(&after, true, desired, limit)
+
+		setPassword := false
+		if password != "" && (!exists || m.GetStringArg(args, "update_password", "always") == "always") {
+			setPassword = !exists
+			if exists {
+				// Reading stored passwords needs superuser, a password that
+				// cannot be checked is set again
+				stored, err := client.query(ctx, conn, client.loginDB, "SELECT rolpassword FROM pg_catalog.pg_authid WHERE rolname = "+pgLiteral(name))
+				match := false
+				if err == nil {
+					hash := ""
+					if len(stored) > 0 {
+						hash = stored[0][0]
+					}
+					match, _ = pgPasswordMatches(hash, name, password)
+				}
+				setPassword = !match
+			}
+		}
+		if setPassword {
+			options = append(options, "PASSWORD "+pgLiteral(password))
+			fmt.Fprintf(&before, "password: ********\n")
+			fmt.Fprintf(&after, "password: ******** (changed)\n")
+		}
+
+		switch {
+		case !exists:
+			roleStatements = append(roleStatements, fmt.Sprintf("CREATE ROLE %s WITH %s", pgIdent(name), strings.Join(options, " ")))
+			changes = append(changes, "created role "+name)
+		case len(options) > 0:
+			roleStatements = append(roleStatements, fmt.Sprintf("ALTER ROLE %s WITH %s", pgIdent(name), strings.Join(options, " ")))
+			changes = append(changes, "updated role "+name)
+		}
+	}
+
+	for i, grant := range grants {
+		object, quoted, target := "database "+db, "DATABASE "+pgIdent(db), "on database "+db
+		if grant.table != "" {
+			_, _, table := pgTable(grant.table)
+			object, quoted, target = "table "+grant.table, "TABLE "+table, "on "+grant.table
+		}
+		have := make(map[string]bool)
+		for _, priv := range granted[i] {
+			have[priv] = true
+		}
+
+		var pending []string
+		for _, priv := range grant.privs {
+			if have[priv] == (state == "absent") {
+				pending = append(pending, priv)
+			}
+			have[priv] = state == "present"
+		}
+		fmt.Fprintf(&before, "grants on %s: %s\n", object, strings.Join(granted[i], ", "))
+		fmt.Fprintf(&after, "grants on %s: %s\n", object, strings.Join(setMembers(have), ", "))
+		if len(pending) == 0 {
+			continue
+		}
+
+		sql := fmt.Sprintf("GRANT %s ON %s TO %s", strings.Join(pending, ", "), quoted, pgIdent(name))
+		verb := "granted"
+		if state == "absent" {
+			sql = fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(pending, ", "), quoted, pgIdent(name))
+			verb = "revoked"
+		}
+		if grant.table == "" {
+			roleStatements = append(roleStatements, sql)
+		} else {
+			dbStatements = append(dbStatements, sql)
+		}
+		changes = append(changes, fmt.Sprintf("%s %s %s", verb, strings.Join(pending, ", "), target))
+	}
+
+	if state == "absent" && exists {
+		// Table grants are revoked before the role goes
+		roleStatements = append(roleStatements, "DROP ROLE "+pgIdent(name))
+		changes = append(changes, "dropped role "+name)
+	}
+
+	if !checkMode {
+		if state == "absent" {
+			err = client.exec(ctx, conn, db, dbStatements)
+			if err == nil {
+				err = client.exec(ctx, conn, client.loginDB, roleStatements)
+			}
+		} else {
+			err = client.exec(ctx, conn, client.loginDB, roleStatements)
+			if err == nil {
+				err = client.exec(ctx, conn, db, dbStatements)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	queries := append(roleStatements, dbStatements...)
+	if password != "" {
+		for i, query := range queries {
+			queries[i] = strings.ReplaceAll(query, pgLiteral(password), "'********'")
+		}
+	}
+	result := m.CreateSuccessResult(hostname, false, "Role is already in desired state", map[string]interface{}{
+		"name":    name,
+		"state":   state,
+		"queries": queries,
+	})
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
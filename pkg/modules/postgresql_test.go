@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestPostgresClient(t *testing.T) {
+	m := NewPostgreSQLDBModule()
+	client, err := newPostgresClient(m.BaseModule, map[string]interface{}{"login_host": "db.example.com", "login_user": "admin", "login_password": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.psql("app"), "psql -AtqX -v ON_ERROR_STOP=1 -F '\t' -h 'db.example.com' -p 5432 -U 'admin' -d 'app'"; got != want {
+		t.Errorf("psql() = %q, want %q", got, want)
+	}
+	if got := client.executeOptions().Env["PGPASSWORD"]; got != "s3cret" {
+		t.Errorf("PGPASSWORD = %q", got)
+	}
+
+	client, _ = newPostgresClient(m.BaseModule, map[string]interface{}{"login_unix_socket": "/run/postgresql"})
+	if got, want := client.psql("postgres"), "psql -AtqX -v ON_ERROR_STOP=1 -F '\t' -h '/run/postgresql' -p 5432 -U 'postgres' -d 'postgres'"; got != want {
+		t.Errorf("psql() over the socket = %q, want %q", got, want)
+	}
+	if client.executeOptions().Env != nil {
+		t.Error("expected no environment without a login password")
+	}
+}
+
+func TestPgPasswordMatches(t *testing.T) {
+	scram := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$L/X5gyjyt4GUfeNt2lbtGJNsick3GXPp8n9V0l9KjeI=:DXzXvvUgpV0HOHx/p7sPsFJWh1kYFsshtN2TM6ZSxQg="
+	tests := []struct {
+		name, stored, password string
+		match, known           bool
+	}{
+		{"SCRAM", scram, "s3cret", true, true},
+		{"SCRAMMismatch", scram, "other", false, true},
+		{"MD5", "md5f543b608e355623527b0e4f12e5981e8", "s3cret", true, true},
+		{"MD5Mismatch", "md5f543b608e355623527b0e4f12e5981e8", "other", false, true},
+		{"PreHashed", scram, scram, true, true},
+		{"NoPassword", "", "s3cret", false, true},
+		{"Unknown", "plain", "s3cret", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, known := pgPasswordMatches(tt.stored, "app", tt.password)
+			if match != tt.match || known != tt.known {
+				t.Errorf("pgPasswordMatches() = %v, %v, want %v, %v", match, known, tt.match, tt.known)
+			}
+		})
+	}
+}
+
+func TestParsePgPrivileges(t *testing.T) {
+	grants, err := parsePgPrivileges("CONNECT,temp/orders:select,insert/reports.daily:ALL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pgGrant{
+		{privs: []string{"CONNECT", "TEMPORARY"}},
+		{table: "orders", privs: []string{"INSERT", "SELECT"}},
+		{table: "reports.daily", privs: []string{"DELETE", "INSERT", "REFERENCES", "SELECT", "TRIGGER", "TRUNCATE", "UPDATE"}},
+	}
+	if !reflect.DeepEqual(grants, want) {
+		t.Errorf("parsePgPrivileges() = %v, want %v", grants, want)
+	}
+
+	if _, err := parsePgPrivileges("orders:CONNECT"); err == nil {
+		t.Error("expected an error for a database privilege on a table")
+	}
+}
+
+func TestPostgreSQLDBModule(t *testing.T) {
+	module := NewPostgreSQLDBModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "app", "owner": "app", "conn_limit": 20}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidConnLimit", Args: map[string]interface{}{"name": "app", "conn_limit": "many"}, ExpectValid: false},
+		{Name: "InvalidPort", Args: map[string]interface{}{"name": "app", "login_port": "pg"}, ExpectValid: false},
+	})
+
+	database := func(h *testhelper.ModuleTestHelper, row string) {
+		h.GetConnection().ExpectCommandPattern(`^psql .* -c 'SELECT .* FROM pg_catalog.pg_database WHERE datname = `, &testhelper.CommandResponse{Stdout: row})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Create",
+			Args: map[string]interface{}{"name": "app", "owner": "app", "encoding": "UTF8", "template": "template0"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				database(h, "")
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'CREATE DATABASE "app" OWNER "app" TEMPLATE "template0" ENCODING '"'"'UTF8'"'"';\n' \| psql .* -f -$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created database app")
+			},
+		},
+		{
+			Name:     "ChangeOwnerAndLimit",
+			Args:     map[string]interface{}{"name": "app", "owner": "app", "encoding": "utf8", "conn_limit": 20},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				database(h, "postgres\tUTF8\ten_US.UTF-8\ten_US.UTF-8\t-1\n")
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' 'ALTER DATABASE "app" OWNER TO "app";\nALTER DATABASE "app" CONNECTION LIMIT 20;\n' \| psql`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDiffBefore(result, "database app\nowner: postgres\nencoding: UTF8\nlc_collate: en_US.UTF-8\nlc_ctype: en_US.UTF-8\nconn_limit: -1\n")
+				h.AssertDiffAfter(result, "database app\nowner: app\nencoding: UTF8\nlc_collate: en_US.UTF-8\nlc_ctype: en_US.UTF-8\nconn_limit: 20\n")
+			},
+		},
+		{
+			Name:        "EncodingMismatch",
+			Args:        map[string]interface{}{"name": "app", "encoding": "LATIN1"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				database(h, "postgres\tUTF8\tC\tC\t-1\n")
+			},
+		},
+		{
+			Name: "Drop",
+			Args: map[string]interface{}{"name": "scratch", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				database(h, "postgres\tUTF8\tC\tC\t-1\n")
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'DROP DATABASE "scratch";\n' \| psql`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Dropped database scratch")
+			},
+		},
+		{
+			Name: "AlreadyAbsent",
+			Args: map[string]interface{}{"name": "scratch", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				database(h, "")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`^printf`, 0)
+			},
+		},
+	})
+}
+
+func TestPostgreSQLUserModule(t *testing.T) {
+	module := NewPostgreSQLUserModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "app", "role_attr_flags": "CREATEDB,NOSUPERUSER", "db": "app", "priv": "CONNECT/orders:SELECT"}, ExpectValid: true},
+		{Name: "UnknownFlag", Args: map[string]interface{}{"name": "app", "role_attr_flags": "CREATEWORLD"}, ExpectValid: false},
+		{Name: "PrivWithoutDB", Args: map[string]interface{}{"name": "app", "priv": "CONNECT"}, ExpectValid: false},
+		{Name: "InvalidPriv", Args: map[string]interface{}{"name": "app", "db": "app", "priv": "orders:FLY"}, ExpectValid: false},
+	})
+
+	role := func(h *testhelper.ModuleTestHelper, row string) {
+		h.GetConnection().ExpectCommandPattern(`^psql .* -c 'SELECT rolsuper, .* FROM pg_catalog.pg_roles WHERE rolname = `, &testhelper.CommandResponse{Stdout: row})
+	}
+	scram := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$L/X5gyjyt4GUfeNt2lbtGJNsick3GXPp8n9V0l9KjeI=:DXzXvvUgpV0HOHx/p7sPsFJWh1kYFsshtN2TM6ZSxQg="
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "CreateWithGrants",
+			Args:     map[string]interface{}{"name": "app", "password": "s3cret", "role_attr_flags": "CREATEDB", "db": "app", "priv": "CONNECT/orders:SELECT,INSERT"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				role(h, "")
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' 'CREATE ROLE "app" WITH CREATEDB LOGIN PASSWORD '"'"'s3cret'"'"';\nGRANT CONNECT ON DATABASE "app" TO "app";\n' \| psql .* -d 'postgres' -f -$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'GRANT INSERT, SELECT ON TABLE "public"."orders" TO "app";\n' \| psql .* -d 'app' -f -$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created role app, granted CONNECT on database app, granted INSERT, SELECT on orders")
+				h.AssertDiffAfter(result, "role app\nattributes: CREATEDB, LOGIN\nconn_limit: -1\npassword: ******** (changed)\ngrants on database app: CONNECT\ngrants on table orders: INSERT, SELECT\n")
+				h.GetConnection().AssertCalledBefore(`CREATE ROLE`, `GRANT INSERT`)
+				queries, _ := result.Data["queries"].([]string)
+				if len(queries) != 3 || queries[0] != `CREATE ROLE "app" WITH CREATEDB LOGIN PASSWORD '********'` {
+					t.Errorf("unexpected queries %q", queries)
+				}
+			},
+		},
+		{
+			Name: "ConvergedWithMatchingPassword",
+			Args: map[string]interface{}{"name": "app", "password": "s3cret", "role_attr_flags": "CREATEDB,NOSUPERUSER", "db": "app", "priv": "CONNECT"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				role(h, "f\tf\tt\tt\tt\tf\tf\t-1\n")
+				h.GetConnection().ExpectCommandPattern(`aclexplode`, &testhelper.CommandResponse{Stdout: "CONNECT\n"})
+				h.GetConnection().ExpectCommandPattern(`FROM pg_catalog.pg_authid`, &testhelper.CommandResponse{Stdout: scram + "\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`^printf`, 0)
+			},
+		},
+		{
+			Name: "UpdateAttributesAndPassword",
+			Args: map[string]interface{}{"name": "app", "password": "n3w", "role_attr_flags": []interface{}{"NOCREATEDB", "REPLICATION"}, "conn_limit": 5},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				role(h, "f\tf\tt\tt\tt\tf\tf\t-1\n")
+				h.GetConnection().ExpectCommandPattern(`FROM pg_catalog.pg_authid`, &testhelper.CommandResponse{Stdout: scram + "\n"})
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'ALTER ROLE "app" WITH NOCREATEDB REPLICATION CONNECTION LIMIT 5 PASSWORD `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated role app")
+			},
+		},
+		{
+			Name: "PasswordOnlyOnCreate",
+			Args: map[string]interface{}{"name": "app", "password": "s3cret", "update_password": "on_create"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				role(h, "f\tf\tt\tt\tt\tf\tf\t-1\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`pg_authid`, 0)
+			},
+		},
+		{
+			Name: "DropWithGrants",
+			Args: map[string]interface{}{"name": "legacy", "db": "app", "priv": "CONNECT/orders:ALL", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				role(h, "f\tf\tf\tt\tt\tf\tf\t-1\n")
+				h.GetConnection().ExpectCommandPattern(`aclexplode`, &testhelper.CommandResponse{Stdout: "CONNECT\n"})
+				h.GetConnection().ExpectCommandPattern(`information_schema.table_privileges`, &testhelper.CommandResponse{Stdout: "SELECT\n"})
+				h.GetConnection().ExpectCommandPattern(`^printf '%s' 'REVOKE SELECT ON TABLE "public"."orders" FROM "legacy";\n' \| psql .* -d 'app'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`(?s)^printf '%s' 'REVOKE CONNECT ON DATABASE "app" FROM "legacy";\nDROP ROLE "legacy";\n' \| psql .* -d 'postgres'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Revoked CONNECT on database app, revoked SELECT on orders, dropped role legacy")
+				h.GetConnection().AssertCalledBefore(`REVOKE SELECT`, `DROP ROLE`)
+			},
+		},
+	})
+}
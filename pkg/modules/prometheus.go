@@ -0,0 +1,519 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// monitoringFile describes a Prometheus or Alertmanager configuration file
+// that is validated before it replaces the live file and the server is
+// asked to reload it
+type monitoringFile struct {
+	kind    string             // Human readable file kind for messages
+	path    string             // Destination on the target host
+	content string             // Desired content; empty removes the file
+	mode    string             // File mode of the written file
+	tool    string             // Validation tool, used when installed
+	check   string             // Tool arguments, %s is the file to check
+	lint    func(string) error // Built-in schema check run on the controller
+	reload  string             // Reload URL, empty to skip reloading
+}
+
+// existsMarker is printed before the output of commands that inspect an
+// object on the target host when the object exists
+const existsMarker = "__gosible_exists__\n"
+
+func (f *monitoringFile) shellEscape(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "'\"'\"'"))
+}
+
+// run executes a command and turns a non-zero exit into an error
+func (f *monitoringFile) run(ctx context.Context, conn types.Connection, what, cmd string) (*types.Result, error) {
+	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	if err != nil {
+		return result, fmt.Errorf("%s failed: %w", what, err)
+	}
+	if !result.Success {
+		return result, fmt.Errorf("%s failed: %s", what, commandStderr(result))
+	}
+	return result, nil
+}
+
+// read returns the current file content and whether the file exists
+func (f *monitoringFile) read(ctx context.Context, conn types.Connection) (string, bool, error) {
+	path := f.shellEscape(f.path)
+	cmd := fmt.Sprintf("if [ -f %s ]; then printf '%%s' '%s'; cat %s; fi", path, existsMarker, path)
+	result, err := f.run(ctx, conn, "reading "+f.path, cmd)
+	if err != nil {
+		return "", false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	if !strings.HasPrefix(stdout, existsMarker) {
+		return "", false, nil
+	}
+	return strings.TrimPrefix(stdout, existsMarker), true, nil
+}
+
+// install writes the content to a temporary file next to the destination,
+// validates it with the tool when installed and moves it into place. The
+// live file is left untouched when validation fails.
+func (f *monitoringFile) install(ctx context.Context, conn types.Connection) (bool, error) {
+	tmp := f.shellEscape(f.path + ".gosible.tmp")
+	cmd := fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s && chmod %s %s",
+		f.shellEscape(parentDir(f.path)), f.shellEscape(f.content), tmp, f.shellEscape(f.mode), tmp)
+	if _, err := f.run(ctx, conn, "writing "+f.path, cmd); err != nil {
+		return false, err
+	}
+
+	validated := false
+	if f.tool != "" {
+		if result, _ := conn.Execute(ctx, fmt.Sprintf("command -v %s >/dev/null 2>&1", f.shellEscape(f.tool)), types.ExecuteOptions{}); result != nil && result.Success {
+			check := fmt.Sprintf("%s %s", f.shellEscape(f.tool), fmt.Sprintf(f.check, tmp))
+			if _, err := f.run(ctx, conn, f.tool+" validation", check); err != nil {
+				conn.Execute(ctx, "rm -f "+tmp, types.ExecuteOptions{})
+				return false, err
+			}
+			validated = true
+		}
+	}
+
+	if _, err := f.run(ctx, conn, "installing "+f.path, fmt.Sprintf("mv -f %s %s", tmp, f.shellEscape(f.path))); err != nil {
+		return validated, err
+	}
+	return validated, nil
+}
+
+// reloadServer posts to the reload endpoint from the target host
+func (f *monitoringFile) reloadServer(ctx context.Context, conn types.Connection) error {
+	url := f.shellEscape(f.reload)
+	cmd := fmt.Sprintf("curl -fsS -X POST %s || wget -q -O - --post-data='' %s", url, url)
+	_, err := f.run(ctx, conn, "reloading via "+f.reload, cmd)
+	return err
+}
+
+// apply brings the file to the desired state and builds the module result
+func (f *monitoringFile) apply(ctx context.Context, m *BaseModule, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	if f.content != "" && f.lint != nil {
+		if err := f.lint(f.content); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", f.kind, err)
+		}
+	}
+
+	current, exists, err := f.read(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
+		"path": f.path,
+	})
+
+	var change string
+	switch {
+	case f.content == "" && exists:
+		change = fmt.Sprintf("removed %s %s", f.kind, f.path)
+	case f.content != "" && !exists:
+		change = fmt.Sprintf("created %s %s", f.kind, f.path)
+	case f.content != "" && current != f.content:
+		change = fmt.Sprintf("updated %s %s", f.kind, f.path)
+	}
+
+	if change != "" && !checkMode {
+		if f.content == "" {
+			if _, err := f.run(ctx, conn, "removing "+f.path, "rm -f "+f.shellEscape(f.path)); err != nil {
+				return nil, err
+			}
+		} else {
+			validated, err := f.install(ctx, conn)
+			if err != nil {
+				return nil, err
+			}
+			result.Data["validated"] = validated
+		}
+
+		if f.reload != "" {
+			if err := f.reloadServer(ctx, conn); err != nil {
+				return nil, err
+			}
+			result.Data["reloaded"] = true
+		}
+	}
+
+	result.Changed = change != ""
+	if !result.Changed {
+		result.Message = fmt.Sprintf("%s %s is already in desired state", strings.ToUpper(f.kind[:1])+f.kind[1:], f.path)
+	} else if checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+		result.Message = "Would have " + change
+	} else {
+		result.Message = strings.ToUpper(change[:1]) + change[1:]
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(current, f.content)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, nil
+}
+
+// parentDir returns the directory part of a slash separated path
+func parentDir(path string) string {
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		return path[:i]
+	}
+	if strings.HasPrefix(path, "/") {
+		return "/"
+	}
+	return "."
+}
+
+// monitoringContent returns the content argument, or the structured
+// argument rendered as YAML under key (or as the whole document when key
+// is empty)
+func monitoringContent(m *BaseModule, args map[string]interface{}, structured, key string) (string, error) {
+	if content := m.GetStringArg(args, "content", ""); content != "" {
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content, nil
+	}
+
+	value, ok := args[structured]
+	if !ok {
+		return "", nil
+	}
+	if key != "" {
+		value = map[string]interface{}{key: value}
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", structured, err)
+	}
+	return string(data), nil
+}
+
+// lintPrometheusRules checks the structure promtool would reject
+func lintPrometheusRules(content string) error {
+	var file struct {
+		Groups []struct {
+			Name  string                   `yaml:"name"`
+			Rules []map[string]interface{} `yaml:"rules"`
+		} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for i, group := range file.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("group %d has no name", i+1)
+		}
+		if seen[group.Name] {
+			return fmt.Errorf("group %s is defined more than once", group.Name)
+		}
+		seen[group.Name] = true
+
+		for j, rule := range group.Rules {
+			record, alert := rule["record"] != nil, rule["alert"] != nil
+			switch {
+			case record == alert:
+				return fmt.Errorf("rule %d in group %s must set exactly one of record or alert", j+1, group.Name)
+			case types.ConvertToString(rule["expr"]) == "":
+				return fmt.Errorf("rule %d in group %s has no expr", j+1, group.Name)
+			case record && (rule["for"] != nil || rule["annotations"] != nil):
+				return fmt.Errorf("recording rule %v in group %s cannot set for or annotations", rule["record"], group.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// lintAlertmanagerConfig checks that every receiver used by a route exists
+func lintAlertmanagerConfig(content string) error {
+	var config struct {
+		Route     map[string]interface{} `yaml:"route"`
+		Receivers []struct {
+			Name string `yaml:"name"`
+		} `yaml:"receivers"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		return err
+	}
+	if config.Route == nil {
+		return fmt.Errorf("no route provided")
+	}
+	if config.Route["receiver"] == nil {
+		return fmt.Errorf("root route must specify a default receiver")
+	}
+
+	receivers := make(map[string]bool)
+	for _, receiver := range config.Receivers {
+		if receivers[receiver.Name] {
+			return fmt.Errorf("receiver %s is defined more than once", receiver.Name)
+		}
+		receivers[receiver.Name] = true
+	}
+
+	var walk func(route map[string]interface{}) error
+	walk = func(route map[string]interface{}) error {
+		if name, ok := route["receiver"]; ok && !receivers[types.ConvertToString(name)] {
+			return fmt.Errorf("undefined receiver %v used in route", name)
+		}
+		routes, _ := route["routes"].([]interface{})
+		for _, child := range routes {
+			if childRoute, ok := child.(map[string]interface{}); ok {
+				if err := walk(childRoute); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(config.Route)
+}
+
+// PrometheusRuleModule manages Prometheus recording and alerting rule files
+type PrometheusRuleModule struct {
+	*BaseModule
+}
+
+// NewPrometheusRuleModule creates a new prometheus_rule module instance
+func NewPrometheusRuleModule() *PrometheusRuleModule {
+	doc := types.ModuleDoc{
+		Name:        "prometheus_rule",
+		Description: "Manage Prometheus rule files, validated with promtool before Prometheus is reloaded",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Rule file path, e.g. /etc/prometheus/rules/node.yml",
+				Required:    true,
+				Type:        "path",
+			},
+			"groups": {
+				Description: "Rule groups in Prometheus rule file format",
+				Required:    false,
+				Type:        "list",
+			},
+			"content": {
+				Description: "Raw rule file content, used instead of groups",
+				Required:    false,
+				Type:        "string",
+			},
+			"mode": {
+				Description: "File mode of the rule file",
+				Required:    false,
+				Type:        "string",
+				Default:     "0644",
+			},
+			"promtool": {
+				Description: "promtool executable used for validation when installed",
+				Required:    false,
+				Type:        "string",
+				Default:     "promtool",
+			},
+			"reload": {
+				Description: "Reload Prometheus after the rules change",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"reload_url": {
+				Description: "Reload endpoint, requires --web.enable-lifecycle",
+				Required:    false,
+				Type:        "string",
+				Default:     "http://localhost:9090/-/reload",
+			},
+			"state": {
+				Description: "Whether the rule file should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Alert on unreachable nodes\n  prometheus_rule:\n    path: /etc/prometheus/rules/node.yml\n    groups:\n      - name: node\n        rules:\n          - alert: NodeDown\n            expr: up{job=\"node\"} == 0\n            for: 5m\n            labels:\n              severity: page",
+			"- name: Remove old rules\n  prometheus_rule:\n    path: /etc/prometheus/rules/legacy.yml\n    state: absent",
+		},
+		Returns: map[string]string{
+			"path":      "Rule file path",
+			"validated": "Whether promtool validated the new rules",
+			"reloaded":  "Whether Prometheus was reloaded",
+		},
+	}
+
+	base := NewBaseModule("prometheus_rule", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "linux",
+	})
+
+	return &PrometheusRuleModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *PrometheusRuleModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "path", "") == "" {
+		return types.NewValidationError("path", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "state", "present") == "present" {
+		_, hasGroups := args["groups"]
+		_, hasContent := args["content"]
+		if hasGroups == hasContent {
+			return types.NewValidationError("groups", nil, "exactly one of groups or content is required")
+		}
+	}
+	return nil
+}
+
+// Run executes the prometheus_rule module
+func (m *PrometheusRuleModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	file := &monitoringFile{
+		kind:  "rule file",
+		path:  m.GetStringArg(args, "path", ""),
+		mode:  m.GetStringArg(args, "mode", "0644"),
+		tool:  m.GetStringArg(args, "promtool", "promtool"),
+		check: "check rules %s",
+		lint:  lintPrometheusRules,
+	}
+	if m.GetBoolArg(args, "reload", true) {
+		file.reload = m.GetStringArg(args, "reload_url", "http://localhost:9090/-/reload")
+	}
+
+	if m.GetStringArg(args, "state", "present") == "present" {
+		content, err := monitoringContent(m.BaseModule, args, "groups", "groups")
+		if err != nil {
+			return nil, err
+		}
+		file.content = content
+	}
+
+	return file.apply(ctx, m.BaseModule, conn, args)
+}
+
+// AlertmanagerConfigModule manages the Alertmanager configuration file
+type AlertmanagerConfigModule struct {
+	*BaseModule
+}
+
+// NewAlertmanagerConfigModule creates a new alertmanager_config module instance
+func NewAlertmanagerConfigModule() *AlertmanagerConfigModule {
+	doc := types.ModuleDoc{
+		Name:        "alertmanager_config",
+		Description: "Manage the Alertmanager configuration, checked with amtool before Alertmanager is reloaded",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Configuration file path",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/alertmanager/alertmanager.yml",
+			},
+			"config": {
+				Description: "Alertmanager configuration as a dict",
+				Required:    false,
+				Type:        "dict",
+			},
+			"content": {
+				Description: "Raw configuration content, used instead of config",
+				Required:    false,
+				Type:        "string",
+			},
+			"mode": {
+				Description: "File mode of the configuration file",
+				Required:    false,
+				Type:        "string",
+				Default:     "0640",
+			},
+			"amtool": {
+				Description: "amtool executable used for validation when installed",
+				Required:    false,
+				Type:        "string",
+				Default:     "amtool",
+			},
+			"reload": {
+				Description: "Reload Alertmanager after the configuration changes",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"reload_url": {
+				Description: "Reload endpoint",
+				Required:    false,
+				Type:        "string",
+				Default:     "http://localhost:9093/-/reload",
+			},
+		},
+		Examples: []string{
+			"- name: Route alerts to the on-call team\n  alertmanager_config:\n    config:\n      route:\n        receiver: oncall\n        group_by: [alertname]\n      receivers:\n        - name: oncall\n          webhook_configs:\n            - url: https://pager.example.com/hook",
+		},
+		Returns: map[string]string{
+			"path":      "Configuration file path",
+			"validated": "Whether amtool validated the new configuration",
+			"reloaded":  "Whether Alertmanager was reloaded",
+		},
+	}
+
+	base := NewBaseModule("alertmanager_config", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "linux",
+	})
+
+	return &AlertmanagerConfigModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *AlertmanagerConfigModule) Validate(args map[string]interface{}) error {
+	_, hasConfig := args["config"]
+	_, hasContent := args["content"]
+	if hasConfig == hasContent {
+		return types.NewValidationError("config", nil, "exactly one of config or content is required")
+	}
+	if hasConfig && m.GetMapArg(args, "config") == nil {
+		return types.NewValidationError("config", args["config"], "config must be a map")
+	}
+	return nil
+}
+
+// Run executes the alertmanager_config module
+func (m *AlertmanagerConfigModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	content, err := monitoringContent(m.BaseModule, args, "config", "")
+	if err != nil {
+		return nil, err
+	}
+
+	file := &monitoringFile{
+		kind:    "Alertmanager configuration",
+		path:    m.GetStringArg(args, "path", "/etc/alertmanager/alertmanager.yml"),
+		content: content,
+		mode:    m.GetStringArg(args, "mode", "0640"),
+		tool:    m.GetStringArg(args, "amtool", "amtool"),
+		check:   "check-config %s",
+		lint:    lintAlertmanagerConfig,
+	}
+	if m.GetBoolArg(args, "reload", true) {
+		file.reload = m.GetStringArg(args, "reload_url", "http://localhost:9093/-/reload")
+	}
+
+	return file.apply(ctx, m.BaseModule, conn, args)
+}
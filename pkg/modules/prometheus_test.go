@@ -0,0 +1,201 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+const nodeRules = "groups:\n" +
+	"    - name: node\n" +
+	"      rules:\n" +
+	"        - alert: NodeDown\n" +
+	"          expr: up == 0\n"
+
+func nodeRuleGroups() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"name": "node",
+			"rules": []interface{}{
+				map[string]interface{}{"alert": "NodeDown", "expr": "up == 0"},
+			},
+		},
+	}
+}
+
+func TestLintPrometheusRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "Valid", content: nodeRules},
+		{name: "BothRecordAndAlert", content: "groups:\n- name: a\n  rules:\n  - record: x\n    alert: y\n    expr: up\n", wantErr: "exactly one of record or alert"},
+		{name: "MissingExpr", content: "groups:\n- name: a\n  rules:\n  - record: x\n", wantErr: "has no expr"},
+		{name: "DuplicateGroup", content: "groups:\n- name: a\n- name: a\n", wantErr: "more than once"},
+		{name: "RecordingRuleWithFor", content: "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n    for: 5m\n", wantErr: "cannot set for"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := lintPrometheusRules(tt.content)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLintAlertmanagerConfig(t *testing.T) {
+	valid := "route:\n  receiver: oncall\n  routes:\n  - receiver: db\nreceivers:\n- name: oncall\n- name: db\n"
+	if err := lintAlertmanagerConfig(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	undefined := "route:\n  receiver: oncall\n  routes:\n  - receiver: missing\nreceivers:\n- name: oncall\n"
+	if err := lintAlertmanagerConfig(undefined); err == nil || !strings.Contains(err.Error(), "undefined receiver missing") {
+		t.Errorf("expected undefined receiver error, got %v", err)
+	}
+
+	if err := lintAlertmanagerConfig("receivers:\n- name: oncall\n"); err == nil {
+		t.Error("expected error for missing route")
+	}
+}
+
+func TestPrometheusRuleModule(t *testing.T) {
+	module := NewPrometheusRuleModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidGroups", Args: map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "groups": nodeRuleGroups()}, ExpectValid: true},
+		{Name: "ValidAbsent", Args: map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{"groups": nodeRuleGroups()}, ExpectValid: false},
+		{Name: "GroupsAndContent", Args: map[string]interface{}{"path": "/r.yml", "groups": nodeRuleGroups(), "content": nodeRules}, ExpectValid: false},
+		{Name: "NoRules", Args: map[string]interface{}{"path": "/r.yml"}, ExpectValid: false},
+	})
+
+	missing := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/prometheus/rules/node.yml' \]`, &testhelper.CommandResponse{})
+	}
+	existing := func(content string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{Stdout: existsMarker + content})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "CreateValidatesBeforeReload",
+			Args: map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "groups": nodeRuleGroups()},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				missing(h)
+				h.GetConnection().ExpectCommandPattern(`(?s)^mkdir -p '/etc/prometheus/rules' && printf '%s' 'groups:\n.*' > '/etc/prometheus/rules/node.yml.gosible.tmp' && chmod '0644'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^command -v 'promtool'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^'promtool' check rules '/etc/prometheus/rules/node.yml.gosible.tmp'$`, &testhelper.CommandResponse{Stdout: "SUCCESS: 1 rules found"})
+				h.GetConnection().ExpectCommandPattern(`^mv -f '/etc/prometheus/rules/node.yml.gosible.tmp' '/etc/prometheus/rules/node.yml'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^curl -fsS -X POST 'http://localhost:9090/-/reload'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created rule file /etc/prometheus/rules/node.yml")
+				if result.Data["validated"] != true || result.Data["reloaded"] != true {
+					t.Errorf("expected validation and reload, got %v", result.Data)
+				}
+			},
+		},
+		{
+			Name:  "UnchangedSkipsReload",
+			Args:  map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "groups": nodeRuleGroups()},
+			Setup: existing(nodeRules),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "PromtoolFailureKeepsLiveFile",
+			Args:        map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "content": "groups:\n- name: node\n  rules:\n  - alert: Bad\n    expr: up ==\n", "reload": false},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				existing(nodeRules)(h)
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^command -v `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`check rules`, &testhelper.CommandResponse{ExitCode: 1, Stderr: "parse error: unexpected end of input"})
+				h.GetConnection().ExpectCommandPattern(`^rm -f '/etc/prometheus/rules/node.yml.gosible.tmp'$`, &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name:        "InvalidRulesRejectedBeforeConnecting",
+			Args:        map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "content": "groups:\n- name: node\n  rules:\n  - alert: NoExpr\n"},
+			ExpectError: true,
+		},
+		{
+			Name:      "RemoveInCheckMode",
+			Args:      map[string]interface{}{"path": "/etc/prometheus/rules/node.yml", "state": "absent"},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup:     existing(nodeRules),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would have removed rule file /etc/prometheus/rules/node.yml")
+			},
+		},
+	})
+}
+
+func TestAlertmanagerConfigModule(t *testing.T) {
+	module := NewAlertmanagerConfigModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	config := func() map[string]interface{} {
+		return map[string]interface{}{
+			"route":     map[string]interface{}{"receiver": "oncall"},
+			"receivers": []interface{}{map[string]interface{}{"name": "oncall"}},
+		}
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidConfig", Args: map[string]interface{}{"config": config()}, ExpectValid: true},
+		{Name: "NoConfig", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "ConfigNotMap", Args: map[string]interface{}{"config": "route: {}"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "UpdateWithoutAmtool",
+			Args: map[string]interface{}{"config": config(), "reload_url": "http://am:9093/-/reload"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/alertmanager/alertmanager.yml' \]`, &testhelper.CommandResponse{Stdout: existsMarker + "route:\n  receiver: old\n"})
+				h.GetConnection().ExpectCommandPattern(`(?s)^mkdir -p '/etc/alertmanager' && .* && chmod '0640'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^command -v 'amtool'`, &testhelper.CommandResponse{ExitCode: 1})
+				h.GetConnection().ExpectCommandPattern(`^mv -f `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^curl -fsS -X POST 'http://am:9093/-/reload'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				if result.Data["validated"] != false {
+					t.Errorf("expected validated=false without amtool, got %v", result.Data["validated"])
+				}
+			},
+		},
+		{
+			Name:        "ReloadFailure",
+			Args:        map[string]interface{}{"config": config()},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^command -v `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`check-config`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mv -f `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^curl `, &testhelper.CommandResponse{ExitCode: 7, Stderr: "connection refused"})
+			},
+		},
+	})
+}
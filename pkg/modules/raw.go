@@ -0,0 +1,112 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// RawModule implements the raw module, which sends a command straight over
+// the connection. It assumes nothing of the host beyond a way to run a
+// command, so it works on hosts without python or a POSIX userland and can
+// bootstrap them.
+type RawModule struct {
+	*BaseModule
+}
+
+// NewRawModule creates a new raw module
+func NewRawModule() *RawModule {
+	doc := types.ModuleDoc{
+		Name:        "raw",
+		Description: "Execute a command over the connection without any module machinery",
+		Parameters: map[string]types.ParamDoc{
+			"cmd": {
+				Description: "The command to send over the connection, as is",
+				Required:    true,
+				Type:        "string",
+			},
+			"executable": {
+				Description: "Run the command with this shell, as `executable -c cmd`. By default the command is passed to the connection untouched",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			`- name: Install python on a pristine Debian host
+  raw: test -e /usr/bin/python3 || (apt-get update && apt-get install -y python3)
+  become: true`,
+			`- name: Run through bash
+  raw: echo $BASH_VERSION executable=/bin/bash`,
+		},
+		Returns: map[string]string{
+			"stdout":    "Standard output of the command",
+			"stderr":    "Standard error of the command",
+			"exit_code": "Exit code of the command",
+			"cmd":       "The executed command",
+		},
+	}
+
+	base := NewBaseModule("raw", doc)
+	// Nothing is known about what the command does, so it can neither be
+	// simulated nor diffed
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    false,
+		DiffMode:     false,
+		Platform:     "any",
+		RequiresRoot: false,
+	})
+
+	return &RawModule{
+		BaseModule: base,
+	}
+}
+
+// Validate validates the module arguments
+func (m *RawModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"cmd"}); err != nil {
+		return err
+	}
+
+	fieldTypes := map[string]string{
+		"cmd":        "string",
+		"executable": "string",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the raw module
+func (m *RawModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+
+		cmd := m.GetStringArg(args, "cmd", "")
+		executable := m.GetStringArg(args, "executable", "")
+
+		command := cmd
+		if executable != "" {
+			var cli remoteCLI
+			command = fmt.Sprintf("%s -c %s", executable, cli.shellEscape(cmd))
+		}
+
+		result, err := conn.Execute(ctx, command, types.ExecuteOptions{})
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to execute raw command: %s", cmd), err), nil
+		}
+
+		if result != nil {
+			result.ModuleName = m.name
+			result.Host = host
+
+			if result.Data == nil {
+				result.Data = make(map[string]interface{})
+			}
+			result.Data["cmd"] = cmd
+			// The command's effect is unknown, so a successful run always
+			// counts as a change, as in Ansible
+			result.Changed = result.Success
+		}
+
+		return result, nil
+	})
+}
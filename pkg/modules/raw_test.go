@@ -0,0 +1,58 @@
+package modules
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestRawModule(t *testing.T) {
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewRawModule()
+	if m.Capabilities().CheckMode {
+		t.Error("expected raw not to support check mode")
+	}
+	if err := m.Validate(map[string]interface{}{}); err == nil {
+		t.Error("expected an error without cmd")
+	}
+
+	tests := []struct {
+		name     string
+		args     map[string]interface{}
+		expected string
+	}{
+		{"AsIs", map[string]interface{}{"cmd": "echo raw | tr a-z A-Z"}, "RAW"},
+		{"Executable", map[string]interface{}{"cmd": "echo 'it''s'", "executable": "/bin/sh"}, "its"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := m.Run(ctx, conn, tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.Success || !result.Changed {
+				t.Fatalf("expected a successful change, got %+v", result)
+			}
+			if stdout := strings.TrimSpace(types.ConvertToString(result.Data["stdout"])); stdout != tt.expected {
+				t.Errorf("expected stdout %q, got %q", tt.expected, stdout)
+			}
+			if result.Data["cmd"] != tt.args["cmd"] {
+				t.Errorf("expected cmd %v, got %v", tt.args["cmd"], result.Data["cmd"])
+			}
+		})
+	}
+
+	result, _ := m.Run(ctx, conn, map[string]interface{}{"cmd": "exit 3"})
+	if result.Success || result.Changed {
+		t.Errorf("expected a failed command to fail without a change, got %+v", result)
+	}
+}
@@ -10,10 +10,15 @@ import (
 
 // ModuleRegistry manages registered modules
 type ModuleRegistry struct {
-	mu      sync.RWMutex
-	modules map[string]types.Module
+	mu       sync.RWMutex
+	modules  map[string]types.Module
+	fallback ModuleFallback
 }
 
+// ModuleFallback provides the modules a registry does not hold, returning
+// types.ErrModuleNotFound for those it does not provide either
+type ModuleFallback func(name string) (types.Module, error)
+
 // NewModuleRegistry creates a new module registry
 func NewModuleRegistry() *ModuleRegistry {
 	registry := &ModuleRegistry{
@@ -44,19 +49,45 @@ func (r *ModuleRegistry) RegisterModule(module types.Module) error {
 	return nil
 }
 
-// GetModule retrieves a module by name
+// GetModule retrieves a module by name, asking the fallback for modules
+// that are not registered
 func (r *ModuleRegistry) GetModule(name string) (types.Module, error) {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-
 	module, exists := r.modules[name]
-	if !exists {
+	fallback := r.fallback
+	r.mu.RUnlock()
+
+	if exists {
+		return module, nil
+	}
+	if fallback == nil {
 		return nil, types.ErrModuleNotFound
 	}
 
+	module, err := fallback(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the module, unless another lookup registered one meanwhile
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, exists := r.modules[name]; exists {
+		return existing, nil
+	}
+	r.modules[name] = module
 	return module, nil
 }
 
+// SetFallback sets the fallback providing the modules that are not
+// registered, such as AnsibleModules.Load. Modules it provides are
+// registered as they are first looked up.
+func (r *ModuleRegistry) SetFallback(fallback ModuleFallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = fallback
+}
+
 // ListModules returns all registered module names
 func (r *ModuleRegistry) ListModules() []string {
 	r.mu.RLock()
@@ -127,6 +158,25 @@ func (r *ModuleRegistry) registerBuiltinModules() {
 	// Register shell module
 	r.RegisterModule(NewShellModule())
 
+	// Register raw module
+	r.RegisterModule(NewRawModule())
+
+	// Register script module
+	r.RegisterModule(NewScriptModule())
+
+	// Register fetch and slurp modules
+	r.RegisterModule(NewFetchModule())
+	r.RegisterModule(NewSlurpModule())
+
+	// Register synchronize module
+	r.RegisterModule(NewSynchronizeModule())
+
+	// Register find module
+	r.RegisterModule(NewFindModule())
+
+	// Register pause module
+	r.RegisterModule(NewPauseModule())
+
 	// Register debug module
 	r.RegisterModule(NewDebugModule())
 
@@ -139,6 +189,10 @@ func (r *ModuleRegistry) registerBuiltinModules() {
 	// Register user module
 	r.RegisterModule(NewUserModule())
 
+	// Register credential rotation modules
+	r.RegisterModule(NewPasswordRotateModule())
+	r.RegisterModule(NewSSHKeyRotateModule())
+
 	// Register group module
 	r.RegisterModule(NewGroupModule())
 
@@ -163,14 +217,94 @@ func (r *ModuleRegistry) registerBuiltinModules() {
 	// Register sysctl module
 	r.RegisterModule(NewSysctlModule())
 
-	// Register iptables module
+	// Register firewall modules
 	r.RegisterModule(NewIPTablesModule())
+	r.RegisterModule(NewFirewalldModule())
+	r.RegisterModule(NewUFWModule())
+
+	// Register mandatory access control modules
+	r.RegisterModule(NewSELinuxModule())
+	r.RegisterModule(NewSEBooleanModule())
+	r.RegisterModule(NewSEFContextModule())
+	r.RegisterModule(NewApparmorProfileModule())
+
+	// Register file security modules
+	r.RegisterModule(NewACLModule())
+	r.RegisterModule(NewCapabilitiesModule())
+
+	// Register database modules
+	r.RegisterModule(NewPostgreSQLDBModule())
+	r.RegisterModule(NewPostgreSQLUserModule())
+	r.RegisterModule(NewMySQLDBModule())
+	r.RegisterModule(NewMySQLUserModule())
 
 	// Register OS-specific package managers
 	r.RegisterModule(NewHomebrewModule())
 	r.RegisterModule(NewAptModule())
 	r.RegisterModule(NewYumModule())
 	r.RegisterModule(NewDnfModule())
+	r.RegisterModule(NewZypperModule())
+	r.RegisterModule(NewApkModule())
+	r.RegisterModule(NewPacmanModule())
+
+	// Register identity management modules
+	r.RegisterModule(NewLDAPEntryModule())
+	r.RegisterModule(NewLDAPAttrsModule())
+	r.RegisterModule(NewKeycloakUserModule())
+	r.RegisterModule(NewKeycloakGroupModule())
+
+	// Register monitoring modules
+	r.RegisterModule(NewGrafanaDatasourceModule())
+	r.RegisterModule(NewGrafanaDashboardModule())
+	r.RegisterModule(NewPrometheusRuleModule())
+	r.RegisterModule(NewAlertmanagerConfigModule())
+
+	// Register service discovery modules
+	r.RegisterModule(NewConsulKVModule())
+	r.RegisterModule(NewConsulServiceModule())
+	r.RegisterModule(NewEtcd3Module())
+
+	// Register container modules
+	r.RegisterModule(NewLXDContainerModule())
+	r.RegisterModule(NewNspawnModule())
+	r.RegisterModule(NewDockerContainerModule())
+	r.RegisterModule(NewDockerImageModule())
+	r.RegisterModule(NewDockerComposeModule())
+	r.RegisterModule(NewK8sModule())
+	r.RegisterModule(NewGitModule())
+	r.RegisterModule(NewGetURLModule())
+
+	// Register storage modules
+	r.RegisterModule(NewZFSModule())
+	r.RegisterModule(NewZpoolModule())
+	r.RegisterModule(NewBtrfsSubvolumeModule())
+	r.RegisterModule(NewBtrfsSnapshotModule())
+
+	// Register base system modules
+	r.RegisterModule(NewSwapFileModule())
+	r.RegisterModule(NewModprobeModule())
+	r.RegisterModule(NewTimesyncModule())
+	r.RegisterModule(NewHostnameModule())
+	r.RegisterModule(NewTimezoneModule())
+	r.RegisterModule(NewLocaleGenModule())
+	r.RegisterModule(NewDNSClientModule())
+	r.RegisterModule(NewDomainJoinModule())
+	r.RegisterModule(NewNetworkInterfaceModule())
+	r.RegisterModule(NewTunedModule())
+	r.RegisterModule(NewSysfsModule())
+
+	// Register Java modules
+	r.RegisterModule(NewJavaCertModule())
+	r.RegisterModule(NewJavaKeystoreModule())
+
+	// Register Windows modules
+	r.RegisterModule(NewWinRegeditModule())
+	r.RegisterModule(NewWinDSCModule())
+	r.RegisterModule(NewWinServiceModule())
+	r.RegisterModule(NewWinFeatureModule())
+	r.RegisterModule(NewWinCopyModule())
+	r.RegisterModule(NewWinUserModule())
+	r.RegisterModule(NewWinServicePasswordModule())
 }
 
 // DefaultModuleRegistry provides a default module registry instance
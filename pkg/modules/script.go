@@ -0,0 +1,269 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	tmplengine "github.com/liliang-cn/gosible/pkg/template"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ScriptModule implements the script module, which transfers a script from
+// the controller to the host, runs it and removes it again
+type ScriptModule struct {
+	*BaseModule
+}
+
+// NewScriptModule creates a new script module
+func NewScriptModule() *ScriptModule {
+	doc := types.ModuleDoc{
+		Name:        "script",
+		Description: "Run a local script on targets after transferring it",
+		Parameters: withExecGuardParams(map[string]types.ParamDoc{
+			"cmd": {
+				Description: "Path to the local script, followed by its arguments. Relative paths are looked up in the working directory, then in files/",
+				Required:    false,
+				Type:        "string",
+			},
+			"argv": {
+				Description: "Path to the local script and its arguments as a list, which are passed without shell interpretation. Mutually exclusive with cmd",
+				Required:    false,
+				Type:        "list",
+			},
+			"chdir": {
+				Description: "Change to this directory on the host before running the script",
+				Required:    false,
+				Type:        "string",
+			},
+			"executable": {
+				Description: "Run the script with this interpreter, such as python3, instead of executing it directly",
+				Required:    false,
+				Type:        "string",
+			},
+			"env": {
+				Description: "Environment variables to run the script with",
+				Required:    false,
+				Type:        "dict",
+			},
+			"template": {
+				Description: "Render the script as a Jinja2 template with the task's variables before transferring it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		}),
+		Examples: []string{
+			`- name: Run a script with arguments
+  script: scripts/setup.sh --force`,
+			`- name: Run a script once, from a directory
+  script: scripts/migrate.sh
+  args:
+    chdir: /srv/app
+    creates: /srv/app/.migrated`,
+			`- name: Render a python script and run it with its environment
+  script:
+    argv: [scripts/report.py.j2, --verbose]
+    executable: python3
+    template: true
+    env:
+      REPORT_DIR: /var/reports`,
+		},
+		Returns: map[string]string{
+			"stdout":    "Standard output of the script",
+			"stderr":    "Standard error of the script",
+			"exit_code": "Exit code of the script",
+			"cmd":       "The executed command",
+		},
+	}
+
+	return &ScriptModule{
+		BaseModule: NewBaseModule("script", doc),
+	}
+}
+
+// Validate validates the module arguments
+func (m *ScriptModule) Validate(args map[string]interface{}) error {
+	_, hasCmd := args["cmd"]
+	_, hasArgv := args["argv"]
+	if !hasCmd && !hasArgv {
+		return types.NewValidationError("cmd", nil, "either cmd or argv must be provided")
+	}
+	if hasCmd && hasArgv {
+		return types.NewValidationError("argv", args["argv"], "cmd and argv are mutually exclusive")
+	}
+
+	fieldTypes := map[string]string{
+		"cmd":        "string",
+		"argv":       "slice",
+		"chdir":      "string",
+		"executable": "string",
+		"env":        "map",
+		"template":   "bool",
+		"creates":    "string",
+		"removes":    "string",
+		"unless":     "string",
+		"onlyif":     "string",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the script module
+func (m *ScriptModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+
+		script, scriptArgs := m.scriptArgs(args)
+		if script == "" {
+			return m.CreateErrorResult(host, "No script given", fmt.Errorf("the script path is empty")), nil
+		}
+		cmd := strings.TrimSpace(script + " " + strings.Join(scriptArgs, " "))
+
+		// The script is read up front so a missing one fails in check mode too
+		content, err := m.readScript(script)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to read script %s", script), err), nil
+		}
+		if m.GetBoolArg(args, "template", false) {
+			vars, _ := args["_task_vars"].(map[string]interface{})
+			engine := tmplengine.NewEngine()
+			engine.SetSyntax(tmplengine.SyntaxJinja2)
+			if content, err = engine.Render(content, vars); err != nil {
+				return m.CreateErrorResult(host, fmt.Sprintf("Failed to render script %s", script), err), nil
+			}
+		}
+
+		options := types.ExecuteOptions{
+			WorkingDir: m.GetStringArg(args, "chdir", ""),
+		}
+		if env := m.GetMapArg(args, "env"); len(env) > 0 {
+			options.Env = make(map[string]string, len(env))
+			for name, value := range env {
+				options.Env[name] = types.ConvertToString(value)
+			}
+		}
+
+		// Guards only read the host, so they are evaluated in check mode too
+		guards := execGuardsArg(args)
+		if skipped, err := guards.check(ctx, conn, options); err != nil {
+			return m.CreateErrorResult(host, "Failed to check guards", err), nil
+		} else if skipped != "" {
+			return m.CreateSuccessResult(host, false, skipped, map[string]interface{}{
+				"cmd":     cmd,
+				"skipped": true,
+			}), nil
+		}
+
+		if m.CheckMode(args) {
+			return guards.checkModeResult(m.BaseModule, host, cmd), nil
+		}
+
+		// Transfer the script into a private directory, removed whatever
+		// happens to the run
+		var cli remoteCLI
+		created, err := cli.run(ctx, conn, "creating a temporary directory", "mktemp -d")
+		if err != nil {
+			return m.CreateErrorResult(host, "Failed to transfer script", err), nil
+		}
+		tmpDir := strings.TrimSpace(types.ConvertToString(created.Data["stdout"]))
+		defer conn.Execute(context.Background(), "rm -rf "+cli.shellEscape(tmpDir), types.ExecuteOptions{})
+
+		remote := path.Join(tmpDir, filepath.Base(script))
+		if err := conn.Copy(ctx, strings.NewReader(content), remote, 0700); err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to transfer script %s", script), err), nil
+		}
+
+		command := cli.shellEscape(remote)
+		if executable := m.GetStringArg(args, "executable", ""); executable != "" {
+			command = executable + " " + command
+		}
+		for _, arg := range scriptArgs {
+			if _, ok := args["argv"]; ok {
+				arg = cli.shellEscape(arg)
+			}
+			command += " " + arg
+		}
+
+		result, err := m.execute(ctx, conn, command, options)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to run script %s", script), err), nil
+		}
+
+		result.ModuleName = m.name
+		result.Host = host
+		if result.Data == nil {
+			result.Data = make(map[string]interface{})
+		}
+		result.Data["cmd"] = cmd
+		// What the script did is unknown, so a successful run is a change
+		result.Changed = result.Success
+		return result, nil
+	})
+}
+
+// scriptArgs returns the local script path and its arguments. Free-form
+// arguments are kept as written, for the shell to interpret.
+func (m *ScriptModule) scriptArgs(args map[string]interface{}) (string, []string) {
+	if argv := m.GetSliceArg(args, "argv"); argv != nil {
+		words := make([]string, 0, len(argv))
+		for _, word := range argv {
+			words = append(words, types.ConvertToString(word))
+		}
+		if len(words) == 0 {
+			return "", nil
+		}
+		return words[0], words[1:]
+	}
+
+	script, rest, _ := strings.Cut(strings.TrimSpace(m.GetStringArg(args, "cmd", "")), " ")
+	if rest = strings.TrimSpace(rest); rest == "" {
+		return script, nil
+	}
+	return script, []string{rest}
+}
+
+// readScript reads a script from the controller, as given or from files/
+func (m *ScriptModule) readScript(script string) (string, error) {
+	data, err := os.ReadFile(script)
+	if err != nil && !filepath.IsAbs(script) {
+		if fromFiles, filesErr := os.ReadFile(filepath.Join("files", script)); filesErr == nil {
+			return string(fromFiles), nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// execute runs the script, streaming its output when the connection
+// supports it
+func (m *ScriptModule) execute(ctx context.Context, conn types.Connection, command string, options types.ExecuteOptions) (*types.Result, error) {
+	streamer, ok := conn.(types.StreamingConnection)
+	if !ok {
+		return conn.Execute(ctx, command, options)
+	}
+
+	options.StreamOutput = true
+	events, err := streamer.ExecuteStream(ctx, command, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *types.Result
+	for event := range events {
+		switch event.Type {
+		case types.StreamDone:
+			result = event.Result
+		case types.StreamError:
+			return nil, event.Error
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no result from the host")
+	}
+	return result, nil
+}
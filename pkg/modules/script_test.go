@@ -0,0 +1,106 @@
+package modules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestScriptModule(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "report.sh")
+	content := "#!/bin/sh\necho \"dir=$(dirname \"$0\")\"\necho \"args=$*\"\necho \"env=$REPORT_ENV\"\necho \"pwd=$(pwd)\"\n"
+	if err := os.WriteFile(script, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewScriptModule()
+	output := func(result *types.Result) map[string]string {
+		values := make(map[string]string)
+		for _, line := range strings.Split(types.ConvertToString(result.Data["stdout"]), "\n") {
+			if key, value, ok := strings.Cut(line, "="); ok {
+				values[key] = value
+			}
+		}
+		return values
+	}
+
+	t.Run("FreeForm", func(t *testing.T) {
+		args := map[string]interface{}{"cmd": script + " one 'two three'", "chdir": dir, "env": map[string]interface{}{"REPORT_ENV": "prod"}}
+		if err := m.Validate(args); err != nil {
+			t.Fatalf("unexpected validation error: %v", err)
+		}
+		result, err := m.Run(ctx, conn, args)
+		if err != nil || !result.Success || !result.Changed {
+			t.Fatalf("expected a successful change, got %+v (%v)", result, err)
+		}
+		values := output(result)
+		if values["args"] != "one two three" || values["env"] != "prod" || values["pwd"] != dir {
+			t.Errorf("unexpected script output %v", values)
+		}
+		// The transferred script is removed after the run
+		if _, err := os.Stat(values["dir"]); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", values["dir"], err)
+		}
+	})
+
+	t.Run("ArgvTemplate", func(t *testing.T) {
+		template := filepath.Join(dir, "greet.sh.j2")
+		if err := os.WriteFile(template, []byte("echo \"args=$1 {{ name }}\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := map[string]interface{}{
+			"argv":       []interface{}{template, "hello; false"},
+			"executable": "/bin/sh",
+			"template":   true,
+			"_task_vars": map[string]interface{}{"name": "world"},
+		}
+		result, err := m.Run(ctx, conn, args)
+		if err != nil || !result.Success {
+			t.Fatalf("expected success, got %+v (%v)", result, err)
+		}
+		if args := output(result)["args"]; args != "hello; false world" {
+			t.Errorf("expected the rendered script with literal arguments, got %q", args)
+		}
+	})
+
+	t.Run("Creates", func(t *testing.T) {
+		result, err := m.Run(ctx, conn, map[string]interface{}{"cmd": script, "creates": script})
+		if err != nil || !result.Success || result.Changed || result.Data["skipped"] != true {
+			t.Errorf("expected the script to be skipped, got %+v (%v)", result, err)
+		}
+	})
+
+	t.Run("CheckMode", func(t *testing.T) {
+		result, err := m.Run(ctx, conn, map[string]interface{}{"cmd": script, "removes": script, "_check_mode": true})
+		if err != nil || !result.Success || !result.Changed {
+			t.Errorf("expected a guarded script to report a change in check mode, got %+v (%v)", result, err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"cmd": filepath.Join(dir, "missing.sh")})
+		if result.Success {
+			t.Error("expected a missing script to fail")
+		}
+	})
+
+	if err := m.Validate(map[string]interface{}{}); err == nil {
+		t.Error("expected an error without cmd or argv")
+	}
+	if err := m.Validate(map[string]interface{}{"cmd": script, "argv": []interface{}{script}}); err == nil {
+		t.Error("expected cmd and argv to be mutually exclusive")
+	}
+}
@@ -0,0 +1,533 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// SELinuxModule sets the SELinux mode and policy, at runtime where the
+// kernel allows it and in the configuration read at boot
+type SELinuxModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSELinuxModule creates a new selinux module instance
+func NewSELinuxModule() *SELinuxModule {
+	doc := types.ModuleDoc{
+		Name:        "selinux",
+		Description: "Set the SELinux mode and policy. Switching between enforcing and permissive takes effect immediately; enabling or disabling SELinux, or changing the policy, needs a reboot, which is reported rather than performed",
+		Parameters: map[string]types.ParamDoc{
+			"state": {
+				Description: "SELinux mode",
+				Required:    true,
+				Type:        "string",
+				Choices:     []string{"enforcing", "permissive", "disabled"},
+			},
+			"policy": {
+				Description: "Policy type, e.g. targeted or mls; the configured policy is kept when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+			"configfile": {
+				Description: "SELinux configuration file",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/selinux/config",
+			},
+		},
+		Examples: []string{
+			"- name: Enforce SELinux\n  selinux:\n    state: enforcing\n    policy: targeted",
+			"- name: Run permissive while debugging denials\n  selinux:\n    state: permissive",
+		},
+		Returns: map[string]string{
+			"state":           "Requested mode",
+			"runtime_state":   "Mode the kernel runs in after the change",
+			"configfile":      "SELinux configuration file",
+			"policy":          "Configured policy type",
+			"reboot_required": "Whether the change only takes effect after a reboot",
+		},
+	}
+
+	base := NewBaseModule("selinux", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SELinuxModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SELinuxModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"state"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"enforcing", "permissive", "disabled"}); err != nil {
+		return err
+	}
+	if policy := m.GetStringArg(args, "policy", ""); strings.ContainsAny(policy, " \t\n=/") {
+		return types.NewValidationError("policy", policy, "invalid policy name")
+	}
+	return nil
+}
+
+// selinuxConfig sets keys of an SELinux config file in place, keeping
+// every other line and appending keys that are missing
+func selinuxConfig(current string, settings [][2]string) string {
+	var lines []string
+	if current != "" {
+		lines = strings.Split(strings.TrimSuffix(current, "\n"), "\n")
+	}
+	for _, setting := range settings {
+		found := false
+		for i, line := range lines {
+			if key, _, ok := strings.Cut(strings.TrimSpace(line), "="); ok && key == setting[0] {
+				lines[i] = setting[0] + "=" + setting[1]
+				found = true
+			}
+		}
+		if !found {
+			lines = append(lines, setting[0]+"="+setting[1])
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Run executes the selinux module
+func (m *SELinuxModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	state := m.GetStringArg(args, "state", "")
+	configfile := m.GetStringArg(args, "configfile", "/etc/selinux/config")
+	quoted := m.cli.shellEscape(configfile)
+
+	result, err := m.cli.run(ctx, conn, "reading the SELinux mode", "getenforce 2>/dev/null || echo Disabled")
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	runtime := strings.ToLower(strings.TrimSpace(stdout))
+	if runtime == "" {
+		runtime = "disabled"
+	}
+
+	config, _, err := m.cli.inspect(ctx, conn, configfile, "[ -f "+quoted+" ]", "cat "+quoted)
+	if err != nil {
+		return nil, err
+	}
+	configured := map[string]string{}
+	for _, line := range strings.Split(config, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			configured[key] = strings.Trim(value, `"`)
+		}
+	}
+	policy := m.GetStringArg(args, "policy", configured["SELINUXTYPE"])
+	if policy == "" {
+		policy = "targeted"
+	}
+
+	var changes, steps []string
+	rebootRequired := false
+	newRuntime := runtime
+
+	updated := selinuxConfig(config, [][2]string{{"SELINUX", state}, {"SELINUXTYPE", policy}})
+	if updated != config {
+		steps = append(steps, fmt.Sprintf(`mkdir -p %s && tmp=$(mktemp %s.XXXXXX) && printf '%%s' %s > "$tmp" && chmod 0644 "$tmp" && mv -f "$tmp" %s`,
+			m.cli.shellEscape(parentDir(configfile)), quoted, m.cli.shellEscape(updated), quoted))
+		changes = append(changes, fmt.Sprintf("set SELinux to %s with the %s policy in %s", state, policy, configfile))
+		if configured["SELINUXTYPE"] != "" && configured["SELINUXTYPE"] != policy {
+			rebootRequired = true
+		}
+	}
+
+	switch {
+	case runtime == state:
+	case runtime == "disabled":
+		// The kernel only enables SELinux at boot, with a relabel of the
+		// filesystems that were written while it was off
+		steps = append(steps, "touch /.autorelabel")
+		rebootRequired = true
+	case state == "disabled":
+		// SELinux cannot be turned off at runtime, permissive is the
+		// closest until the reboot
+		if runtime != "permissive" {
+			steps = append(steps, "setenforce 0")
+			changes = append(changes, "switched SELinux to permissive until the next reboot")
+			newRuntime = "permissive"
+		}
+		rebootRequired = true
+	default:
+		flag := "1"
+		if state == "permissive" {
+			flag = "0"
+		}
+		steps = append(steps, "setenforce "+flag)
+		changes = append(changes, "switched SELinux to "+state)
+		newRuntime = state
+	}
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "changing SELinux", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	message := "SELinux is already in desired state"
+	if rebootRequired {
+		message = "SELinux is configured and takes effect after a reboot"
+	}
+	result = m.CreateSuccessResult(hostname, false, message, map[string]interface{}{
+		"state":           state,
+		"runtime_state":   newRuntime,
+		"configfile":      configfile,
+		"policy":          policy,
+		"reboot_required": rebootRequired,
+	})
+	change := strings.Join(changes, ", ")
+	if change != "" && rebootRequired {
+		change += " (a reboot is required)"
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		fmt.Sprintf("runtime: %s\n%s:\n%s", runtime, configfile, config), fmt.Sprintf("runtime: %s\n%s:\n%s", newRuntime, configfile, updated), startTime), nil
+}
+
+// SEBooleanModule sets SELinux booleans
+type SEBooleanModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSEBooleanModule creates a new seboolean module instance
+func NewSEBooleanModule() *SEBooleanModule {
+	doc := types.ModuleDoc{
+		Name:        "seboolean",
+		Description: "Set an SELinux boolean at runtime and, optionally, in the policy so it survives reboots",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Boolean name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the boolean is on",
+				Required:    true,
+				Type:        "bool",
+			},
+			"persistent": {
+				Description: "Also set the boot value with setsebool -P",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Let httpd make outbound connections\n  seboolean:\n    name: httpd_can_network_connect\n    state: true\n    persistent: true",
+		},
+		Returns: map[string]string{
+			"name":       "Boolean name",
+			"state":      "Runtime value after the change",
+			"persistent": "Boot value after the change, when persistent is set",
+		},
+	}
+
+	base := NewBaseModule("seboolean", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SEBooleanModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SEBooleanModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"name", "state"}); err != nil {
+		return err
+	}
+	if name := m.GetStringArg(args, "name", ""); strings.ContainsAny(name, " \t\n=") || strings.HasPrefix(name, "-") {
+		return types.NewValidationError("name", name, "invalid boolean name")
+	}
+	return nil
+}
+
+// onOff renders a boolean the way the SELinux tools do
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+// Run executes the seboolean module
+func (m *SEBooleanModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	want := onOff(m.GetBoolArg(args, "state", false))
+	persistent := m.GetBoolArg(args, "persistent", false)
+
+	// getsebool prints "name --> on"
+	result, err := m.cli.run(ctx, conn, "reading SELinux boolean "+name, "getsebool "+m.cli.shellEscape(name))
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	_, runtime, _ := strings.Cut(strings.TrimSpace(stdout), "--> ")
+
+	data := map[string]interface{}{"name": name, "state": want == "on"}
+	before := fmt.Sprintf("runtime: %s\n", runtime)
+	after := fmt.Sprintf("runtime: %s\n", want)
+	changed := runtime != want
+
+	if persistent {
+		// semanage lists "name (current , boot) description"
+		result, err := m.cli.run(ctx, conn, "reading the boot value of "+name,
+			fmt.Sprintf("semanage boolean -l -n | awk -v b=%s '$1 == b'", m.cli.shellEscape(name)))
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		boot := ""
+		if open := strings.Index(stdout, "("); open >= 0 {
+			if end := strings.Index(stdout[open:], ")"); end > 0 {
+				if _, value, ok := strings.Cut(stdout[open+1:open+end], ","); ok {
+					boot = strings.TrimSpace(value)
+				}
+			}
+		}
+		before += fmt.Sprintf("persistent: %s\n", boot)
+		after += fmt.Sprintf("persistent: %s\n", want)
+		changed = changed || boot != want
+		data["persistent"] = want == "on"
+	}
+
+	change := ""
+	if changed {
+		cmd := "setsebool "
+		if persistent {
+			cmd += "-P "
+		}
+		cmd += m.cli.shellEscape(name) + " " + want
+		if !checkMode {
+			if _, err := m.cli.run(ctx, conn, "setting SELinux boolean "+name, cmd); err != nil {
+				return nil, err
+			}
+		}
+		change = fmt.Sprintf("set SELinux boolean %s to %s", name, want)
+		if persistent {
+			change += " persistently"
+		}
+	}
+
+	result = m.CreateSuccessResult(hostname, false, fmt.Sprintf("SELinux boolean %s is already %s", name, want), data)
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// sefcontextTypes maps semanage file type flags to the names semanage
+// lists them with
+var sefcontextTypes = map[string]string{
+	"a": "all files",
+	"f": "regular file",
+	"d": "directory",
+	"c": "character device",
+	"b": "block device",
+	"s": "socket",
+	"l": "symbolic link",
+	"p": "named pipe",
+}
+
+// SEFContextModule manages local SELinux file context mappings
+type SEFContextModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSEFContextModule creates a new sefcontext module instance
+func NewSEFContextModule() *SEFContextModule {
+	doc := types.ModuleDoc{
+		Name:        "sefcontext",
+		Description: "Add, change or remove local SELinux file context mappings with semanage fcontext. Existing files keep their labels until restorecon runs, unless restore is set",
+		Parameters: map[string]types.ParamDoc{
+			"target": {
+				Description: "Path regular expression, e.g. /srv/web(/.*)?",
+				Required:    true,
+				Type:        "string",
+			},
+			"setype": {
+				Description: "SELinux type of matching files; required when state is present",
+				Required:    false,
+				Type:        "string",
+			},
+			"ftype": {
+				Description: "File type the mapping applies to: a for all files, f, d, c, b, s, l or p",
+				Required:    false,
+				Type:        "string",
+				Default:     "a",
+				Choices:     []string{"a", "f", "d", "c", "b", "s", "l", "p"},
+			},
+			"seuser": {
+				Description: "SELinux user of matching files",
+				Required:    false,
+				Type:        "string",
+			},
+			"selevel": {
+				Description: "MLS/MCS level of matching files",
+				Required:    false,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the mapping exists",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"restore": {
+				Description: "Path to relabel recursively with restorecon after changing the mapping",
+				Required:    false,
+				Type:        "path",
+			},
+		},
+		Examples: []string{
+			"- name: Serve web content from /srv/web\n  sefcontext:\n    target: /srv/web(/.*)?\n    setype: httpd_sys_content_t\n    restore: /srv/web",
+		},
+		Returns: map[string]string{
+			"target":  "Path regular expression",
+			"context": "Context of the mapping after the run",
+		},
+	}
+
+	base := NewBaseModule("sefcontext", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SEFContextModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SEFContextModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"target"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "ftype", []string{"a", "f", "d", "c", "b", "s", "l", "p"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "state", "present") == "present" && m.GetStringArg(args, "setype", "") == "" {
+		return types.NewValidationError("setype", nil, "setype is required when state is present")
+	}
+	return nil
+}
+
+// Run executes the sefcontext module
+func (m *SEFContextModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	target := m.GetStringArg(args, "target", "")
+	ftype := m.GetStringArg(args, "ftype", "a")
+	state := m.GetStringArg(args, "state", "present")
+	setype := m.GetStringArg(args, "setype", "")
+	seuser := m.GetStringArg(args, "seuser", "")
+	selevel := m.GetStringArg(args, "selevel", "")
+
+	// Local mappings are listed as "target  file type  user:role:type:level"
+	result, err := m.cli.run(ctx, conn, "listing SELinux file contexts", "semanage fcontext -l -C -n")
+	if err != nil {
+		return nil, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	current := ""
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != target {
+			continue
+		}
+		if strings.Join(fields[1:len(fields)-1], " ") == sefcontextTypes[ftype] {
+			current = fields[len(fields)-1]
+		}
+	}
+
+	label := current
+	var cmd, change string
+	if state == "present" {
+		parts := strings.Split(current, ":")
+		matches := len(parts) >= 3 && parts[2] == setype &&
+			(seuser == "" || parts[0] == seuser) &&
+			(selevel == "" || (len(parts) >= 4 && strings.Join(parts[3:], ":") == selevel))
+		if !matches {
+			op := "-a"
+			change = fmt.Sprintf("added the file context %s for %s", setype, target)
+			if current != "" {
+				op, change = "-m", fmt.Sprintf("changed the file context of %s to %s", target, setype)
+			}
+			cmd = fmt.Sprintf("semanage fcontext %s -f %s -t %s", op, ftype, m.cli.shellEscape(setype))
+			if seuser != "" {
+				cmd += " -s " + m.cli.shellEscape(seuser)
+			}
+			if selevel != "" {
+				cmd += " -r " + m.cli.shellEscape(selevel)
+			}
+			cmd += " -- " + m.cli.shellEscape(target)
+
+			// semanage keeps the parts that were not given, or defaults
+			// them for a new mapping
+			if len(parts) < 4 {
+				parts = []string{"system_u", "object_r", "", "s0"}
+			}
+			parts[2] = setype
+			if seuser != "" {
+				parts[0] = seuser
+			}
+			if selevel != "" {
+				parts = append(parts[:3], selevel)
+			}
+			label = strings.Join(parts, ":")
+		}
+	} else if current != "" {
+		cmd = fmt.Sprintf("semanage fcontext -d -f %s -- %s", ftype, m.cli.shellEscape(target))
+		change = "removed the file context of " + target
+		label = ""
+	}
+
+	if cmd != "" && !checkMode {
+		if restore := m.GetStringArg(args, "restore", ""); restore != "" {
+			cmd += " && restorecon -R " + m.cli.shellEscape(restore)
+		}
+		if _, err := m.cli.run(ctx, conn, "changing the file context of "+target, cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	result = m.CreateSuccessResult(hostname, false, "SELinux file context is already in desired state", map[string]interface{}{
+		"target":  target,
+		"context": label,
+	})
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode,
+		fmt.Sprintf("%s (%s): %s\n", target, sefcontextTypes[ftype], current), fmt.Sprintf("%s (%s): %s\n", target, sefcontextTypes[ftype], label), startTime), nil
+}
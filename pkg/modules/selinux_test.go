@@ -0,0 +1,195 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSELinuxConfig(t *testing.T) {
+	current := "# This file controls the state of SELinux\nSELINUX=permissive\nSELINUXTYPE=targeted\n"
+	got := selinuxConfig(current, [][2]string{{"SELINUX", "enforcing"}, {"SELINUXTYPE", "targeted"}})
+	if want := "# This file controls the state of SELinux\nSELINUX=enforcing\nSELINUXTYPE=targeted\n"; got != want {
+		t.Errorf("selinuxConfig() = %q, want %q", got, want)
+	}
+	if got := selinuxConfig("", [][2]string{{"SELINUX", "disabled"}}); got != "SELINUX=disabled\n" {
+		t.Errorf("selinuxConfig() on an empty file = %q", got)
+	}
+}
+
+func TestSELinuxModule(t *testing.T) {
+	module := NewSELinuxModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"state": "enforcing", "policy": "targeted"}, ExpectValid: true},
+		{Name: "MissingState", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"state": "on"}, ExpectValid: false},
+		{Name: "InvalidPolicy", Args: map[string]interface{}{"state": "enforcing", "policy": "../mls"}, ExpectValid: false},
+	})
+
+	config := func(h *testhelper.ModuleTestHelper, mode, content string) {
+		h.GetConnection().ExpectCommand("getenforce 2>/dev/null || echo Disabled", &testhelper.CommandResponse{Stdout: mode + "\n"})
+		h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/selinux/config' \]`, &testhelper.CommandResponse{Stdout: existsMarker + content})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "SwitchToPermissive",
+			Args: map[string]interface{}{"state": "permissive"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				config(h, "Enforcing", "SELINUX=enforcing\nSELINUXTYPE=targeted\n")
+				h.GetConnection().ExpectCommandPattern(`(?s)^mkdir -p '/etc/selinux' && .*SELINUX=permissive`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("setenforce 0", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set SELinux to permissive with the targeted policy in /etc/selinux/config, switched SELinux to permissive")
+				h.AssertDataValue(result, "reboot_required", false)
+				h.GetConnection().AssertCalledBefore(`^mkdir -p`, `^setenforce 0$`)
+			},
+		},
+		{
+			Name: "DisableRunning",
+			Args: map[string]interface{}{"state": "disabled"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				config(h, "Enforcing", "SELINUX=enforcing\nSELINUXTYPE=targeted\n")
+				h.GetConnection().ExpectCommandPattern(`(?s)^mkdir -p .*SELINUX=disabled`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("setenforce 0", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "reboot_required", true)
+				h.AssertDataValue(result, "runtime_state", "permissive")
+			},
+		},
+		{
+			Name:      "EnableFromDisabledInCheckMode",
+			Args:      map[string]interface{}{"state": "enforcing"},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				config(h, "Disabled", "SELINUX=disabled\nSELINUXTYPE=targeted\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDataValue(result, "reboot_required", true)
+				h.AssertDiffAfter(result, "runtime: disabled\n/etc/selinux/config:\nSELINUX=enforcing\nSELINUXTYPE=targeted\n")
+				h.GetConnection().AssertPatternCalledTimes(`autorelabel`, 0)
+			},
+		},
+		{
+			Name: "AlreadyEnforcing",
+			Args: map[string]interface{}{"state": "enforcing", "policy": "targeted"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				config(h, "Enforcing", "SELINUX=enforcing\nSELINUXTYPE=targeted\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "reboot_required", false)
+			},
+		},
+	})
+}
+
+func TestSEBooleanModule(t *testing.T) {
+	module := NewSEBooleanModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "httpd_can_network_connect", "state": true}, ExpectValid: true},
+		{Name: "MissingState", Args: map[string]interface{}{"name": "httpd_can_network_connect"}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "-P httpd", "state": true}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "PersistentOnlyAtBoot",
+			Args:     map[string]interface{}{"name": "httpd_can_network_connect", "state": true, "persistent": true},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("getsebool 'httpd_can_network_connect'", &testhelper.CommandResponse{Stdout: "httpd_can_network_connect --> on\n"})
+				h.GetConnection().ExpectCommandPattern(`^semanage boolean -l -n`, &testhelper.CommandResponse{Stdout: "httpd_can_network_connect      (on   ,  off)  Allow httpd to can network connect\n"})
+				h.GetConnection().ExpectCommand("setsebool -P 'httpd_can_network_connect' on", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set SELinux boolean httpd_can_network_connect to on persistently")
+				h.AssertDiffBefore(result, "runtime: on\npersistent: off\n")
+			},
+		},
+		{
+			Name: "AlreadyOff",
+			Args: map[string]interface{}{"name": "httpd_can_network_connect", "state": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("getsebool 'httpd_can_network_connect'", &testhelper.CommandResponse{Stdout: "httpd_can_network_connect --> off\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`^setsebool`, 0)
+			},
+		},
+		{
+			Name:        "UnknownBoolean",
+			Args:        map[string]interface{}{"name": "no_such_boolean", "state": true},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("getsebool 'no_such_boolean'", &testhelper.CommandResponse{ExitCode: 1, Stderr: "Error getting active value for no_such_boolean\n"})
+			},
+		},
+	})
+}
+
+func TestSEFContextModule(t *testing.T) {
+	module := NewSEFContextModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"target": "/srv/web(/.*)?", "setype": "httpd_sys_content_t"}, ExpectValid: true},
+		{Name: "AbsentWithoutType", Args: map[string]interface{}{"target": "/srv/web(/.*)?", "state": "absent"}, ExpectValid: true},
+		{Name: "PresentWithoutType", Args: map[string]interface{}{"target": "/srv/web(/.*)?"}, ExpectValid: false},
+		{Name: "InvalidFileType", Args: map[string]interface{}{"target": "/srv/web", "setype": "httpd_sys_content_t", "ftype": "x"}, ExpectValid: false},
+	})
+
+	mappings := "/srv/web(/.*)?    all files    system_u:object_r:var_t:s0\n/srv/web    directory    system_u:object_r:httpd_sys_content_t:s0\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "ModifyAndRestore",
+			Args:     map[string]interface{}{"target": "/srv/web(/.*)?", "setype": "httpd_sys_content_t", "restore": "/srv/web"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("semanage fcontext -l -C -n", &testhelper.CommandResponse{Stdout: mappings})
+				h.GetConnection().ExpectCommand("semanage fcontext -m -f a -t 'httpd_sys_content_t' -- '/srv/web(/.*)?' && restorecon -R '/srv/web'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "context", "system_u:object_r:httpd_sys_content_t:s0")
+				h.AssertDiffBefore(result, "/srv/web(/.*)? (all files): system_u:object_r:var_t:s0\n")
+			},
+		},
+		{
+			Name: "AddForFileType",
+			Args: map[string]interface{}{"target": "/srv/web", "setype": "httpd_sys_rw_content_t", "ftype": "f", "selevel": "s0:c1"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("semanage fcontext -l -C -n", &testhelper.CommandResponse{Stdout: mappings})
+				h.GetConnection().ExpectCommand("semanage fcontext -a -f f -t 'httpd_sys_rw_content_t' -r 's0:c1' -- '/srv/web'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "context", "system_u:object_r:httpd_sys_rw_content_t:s0:c1")
+			},
+		},
+		{
+			Name: "RemoveMissing",
+			Args: map[string]interface{}{"target": "/srv/app(/.*)?", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("semanage fcontext -l -C -n", &testhelper.CommandResponse{Stdout: mappings})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+	})
+}
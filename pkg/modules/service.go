@@ -24,6 +24,12 @@ func NewServiceModule() *ServiceModule {
 
 // Run executes the service module
 func (m *ServiceModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *ServiceModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	name, _ := args["name"].(string)
 	state, _ := args["state"].(string)
@@ -0,0 +1,96 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSetupModuleMinimalFacts(t *testing.T) {
+	module := NewSetupModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "SingleCommand",
+			Args: map[string]interface{}{"gather_subset": []interface{}{"minimal"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^echo "hostname=`, &testhelper.CommandResponse{
+					Stdout: "hostname=web1\nsystem=Linux\ndistribution=rocky\ndistribution_like=rhel centos fedora\npython=/usr/bin/python3\nshell=/bin/bash\n",
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				facts, _ := result.Data["ansible_facts"].(map[string]interface{})
+				for key, want := range map[string]interface{}{
+					"ansible_hostname":         "web1",
+					"ansible_os_family":        "RedHat",
+					"ansible_python_available": true,
+					"ansible_shell_path":       "/bin/bash",
+				} {
+					if facts[key] != want {
+						t.Errorf("expected %s=%v, got %v", key, want, facts[key])
+					}
+				}
+				if _, ok := facts["ansible_memtotal_mb"]; ok {
+					t.Error("expected no hardware facts with the min subset")
+				}
+			},
+		},
+		{
+			Name: "NoPython",
+			Args: map[string]interface{}{"gather_subset": []interface{}{"min"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^echo "hostname=`, &testhelper.CommandResponse{
+					Stdout: "hostname=edge1\nsystem=Linux\ndistribution=alpine\ndistribution_like=\npython=\nshell=/bin/sh\n",
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				facts, _ := result.Data["ansible_facts"].(map[string]interface{})
+				if facts["ansible_python_available"] != false || facts["ansible_os_family"] != "Alpine" {
+					t.Errorf("unexpected facts %v", facts)
+				}
+			},
+		},
+	})
+}
+
+func TestSetupModuleFactCollector(t *testing.T) {
+	module := NewSetupModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Subsets", Args: map[string]interface{}{"gather_subset": []interface{}{"all", "!hardware"}}, ExpectValid: true},
+		{Name: "UnknownSubset", Args: map[string]interface{}{"gather_subset": []interface{}{"ohai"}}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "NetworkSubset",
+			Args: map[string]interface{}{"gather_subset": []interface{}{"network"}, "filter": "ansible_d*"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("uname -s", &testhelper.CommandResponse{Stdout: "Linux\n"})
+				h.GetConnection().ExpectCommandPattern(`ip -o addr show`, &testhelper.CommandResponse{
+					Stdout: "@@gosible-facts platform\nLinux\n6.1.0\n#1 SMP\nx86_64\ndb1\ndb1.example.com\n" +
+						"@@gosible-facts os_release\nID=debian\nVERSION_ID=\"12\"\nVERSION_CODENAME=bookworm\n" +
+						"@@gosible-facts addr\n2: eth0    inet 10.1.0.7/16 brd 10.1.255.255 scope global eth0\n" +
+						"@@gosible-facts route4\n1.1.1.1 via 10.1.0.1 dev eth0 src 10.1.0.7 uid 0\n",
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				facts, _ := result.Data["ansible_facts"].(map[string]interface{})
+				if facts["ansible_distribution"] != "Debian" || facts["ansible_domain"] != "example.com" {
+					t.Errorf("expected only the filtered distribution and domain facts, got %v", facts)
+				}
+				if gateway, _ := facts["ansible_default_ipv4"].(map[string]interface{}); gateway["netmask"] != "255.255.0.0" {
+					t.Errorf("unexpected default route %v", gateway)
+				}
+				if _, ok := facts["ansible_os_family"]; ok {
+					t.Error("expected the filter to drop ansible_os_family")
+				}
+			},
+		},
+	})
+}
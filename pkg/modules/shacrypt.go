@@ -0,0 +1,208 @@
+package modules
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt ($5$ and $6$) password hashes as glibc's crypt(3) makes them,
+// following Ulrich Drepper's "Unix crypt using SHA-256 and SHA-512"
+
+const (
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptMaxSalt       = 16
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+)
+
+// shaCryptSchemes maps hash scheme names to their crypt(3) IDs
+var shaCryptSchemes = map[string]string{
+	"sha256": "5",
+	"sha512": "6",
+}
+
+// newShaCryptHash hashes password with a random salt. rounds 0 uses the
+// default, which is left out of the hash as crypt(3) does.
+func newShaCryptHash(password, scheme string, rounds int) (string, error) {
+	id, ok := shaCryptSchemes[scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash scheme %q", scheme)
+	}
+	salt := make([]byte, shaCryptMaxSalt)
+	alphabetSize := big.NewInt(int64(len(shaCryptAlphabet)))
+	for i := range salt {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		salt[i] = shaCryptAlphabet[n.Int64()]
+	}
+	setting := "$" + id + "$"
+	if rounds != 0 {
+		setting += fmt.Sprintf("rounds=%d$", rounds)
+	}
+	return shaCrypt(password, setting+string(salt))
+}
+
+// shaCryptVerify reports whether password matches hashed. supported is false
+// when hashed is not a SHA-crypt hash.
+func shaCryptVerify(password, hashed string) (match, supported bool) {
+	if !strings.HasPrefix(hashed, "$5$") && !strings.HasPrefix(hashed, "$6$") {
+		return false, false
+	}
+	computed, err := shaCrypt(password, hashed)
+	if err != nil {
+		return false, false
+	}
+	return computed == hashed, true
+}
+
+// shaCrypt hashes password with the scheme, rounds and salt of setting,
+// which is either a salt such as $6$saltstring or a complete hash
+func shaCrypt(password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var id string
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		newHash, id = sha256.New, "5"
+	case strings.HasPrefix(setting, "$6$"):
+		newHash, id = sha512.New, "6"
+	default:
+		return "", fmt.Errorf("not a SHA-crypt setting: %q", setting)
+	}
+
+	rest := setting[3:]
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if strings.HasPrefix(rest, "rounds=") {
+		end := strings.IndexByte(rest, '$')
+		if end < 0 {
+			return "", fmt.Errorf("invalid rounds in %q", setting)
+		}
+		n, err := strconv.Atoi(rest[len("rounds="):end])
+		if err != nil {
+			return "", fmt.Errorf("invalid rounds in %q", setting)
+		}
+		rounds, customRounds = min(max(n, shaCryptMinRounds), shaCryptMaxRounds), true
+		rest = rest[end+1:]
+	}
+	salt := rest
+	if end := strings.IndexByte(salt, '$'); end >= 0 {
+		salt = salt[:end]
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+
+	var out strings.Builder
+	out.WriteString("$" + id + "$")
+	if customRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt + "$")
+	out.WriteString(shaCryptEncode(id, shaCryptDigest(newHash, []byte(password), []byte(salt), rounds)))
+	return out.String(), nil
+}
+
+// shaCryptDigest runs the SHA-crypt key stretching over key and salt
+func shaCryptDigest(newHash func() hash.Hash, key, saltBytes []byte, rounds int) []byte {
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	// repeat stretches digest over n bytes
+	repeat := func(digest []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for len(out)+len(digest) <= n {
+			out = append(out, digest...)
+		}
+		return append(out, digest[:n-len(out)]...)
+	}
+
+	alternate := sum(key, saltBytes, key)
+	h := newHash()
+	h.Write(key)
+	h.Write(saltBytes)
+	h.Write(repeat(alternate, len(key)))
+	for n := len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(alternate)
+		} else {
+			h.Write(key)
+		}
+	}
+	digest := h.Sum(nil)
+
+	h = newHash()
+	for range key {
+		h.Write(key)
+	}
+	p := repeat(h.Sum(nil), len(key))
+
+	h = newHash()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		h.Write(saltBytes)
+	}
+	s := repeat(h.Sum(nil), len(saltBytes))
+
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(digest)
+		} else {
+			h.Write(p)
+		}
+		digest = h.Sum(nil)
+	}
+	return digest
+}
+
+// shaCryptEncode renders a digest in the crypt(3) base64 alphabet and byte
+// order of the scheme ID
+func shaCryptEncode(id string, digest []byte) string {
+	var out strings.Builder
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	// The digest bytes are encoded in groups of three, in an order that
+	// rotates through the digest
+	groups := len(digest) / 3
+	for i := 0; i < groups; i++ {
+		idx := [3]int{i, i + groups, i + 2*groups}
+		shift := i % 3
+		if id == "5" {
+			shift = (3 - shift) % 3
+		}
+		encode(digest[idx[shift]], digest[idx[(shift+1)%3]], digest[idx[(shift+2)%3]], 4)
+	}
+	if id == "5" {
+		encode(0, digest[31], digest[30], 3)
+	} else {
+		encode(0, 0, digest[63], 2)
+	}
+	return out.String()
+}
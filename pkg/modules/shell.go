@@ -17,7 +17,7 @@ func NewShellModule() *ShellModule {
 	doc := types.ModuleDoc{
 		Name:        "shell",
 		Description: "Execute shell commands on targets",
-		Parameters: map[string]types.ParamDoc{
+		Parameters: withExecGuardParams(map[string]types.ParamDoc{
 			"cmd": {
 				Description: "The shell command to execute",
 				Required:    true,
@@ -29,28 +29,29 @@ func NewShellModule() *ShellModule {
 				Type:        "string",
 			},
 			"executable": {
-				Description: "Change the shell used to execute the command",
+				Description: "Change the shell used to execute the command. Defaults to ansible_shell_executable, which interpreter discovery sets when the inventory does not",
 				Required:    false,
 				Type:        "string",
 				Default:     "/bin/sh",
 			},
-			"creates": {
-				Description: "A filename or glob pattern. If it already exists, this step will not be run",
+			"warn": {
+				Description: "Enable or disable warnings",
 				Required:    false,
-				Type:        "string",
+				Type:        "bool",
+				Default:     true,
 			},
-			"removes": {
-				Description: "A filename or glob pattern. If it does not exist, this step will not be run",
+			"stdin": {
+				Description: "Set the stdin of the command directly to the specified value",
 				Required:    false,
 				Type:        "string",
 			},
-			"warn": {
-				Description: "Enable or disable warnings",
+			"stdin_add_newline": {
+				Description: "Add a newline to the end of stdin",
 				Required:    false,
 				Type:        "bool",
 				Default:     true,
 			},
-		},
+		}),
 		Examples: []string{
 			`- name: Execute complex shell command
   shell: echo "Hello" | grep -o H`,
@@ -62,6 +63,13 @@ func NewShellModule() *ShellModule {
   shell: echo $0
   args:
     executable: /bin/bash`,
+			`- name: Add the repository key unless it is already trusted
+  shell: curl -fsSL https://example.com/key.gpg | gpg --dearmor -o /etc/apt/keyrings/example.gpg
+  args:
+    onlyif: command -v gpg
+    creates: /etc/apt/keyrings/example.gpg`,
+			`- name: Build once, given free-form
+  shell: make all > build.log chdir=/src/app creates=/src/app/build.log`,
 		},
 		Returns: map[string]string{
 			"stdout":    "Standard output of the command",
@@ -85,12 +93,16 @@ func (m *ShellModule) Validate(args map[string]interface{}) error {
 
 	// Validate field types
 	fieldTypes := map[string]string{
-		"cmd":        "string",
-		"chdir":      "string",
-		"executable": "string",
-		"creates":    "string",
-		"removes":    "string",
-		"warn":       "bool",
+		"cmd":               "string",
+		"chdir":             "string",
+		"executable":        "string",
+		"creates":           "string",
+		"removes":           "string",
+		"unless":            "string",
+		"onlyif":            "string",
+		"warn":              "bool",
+		"stdin":             "string",
+		"stdin_add_newline": "bool",
 	}
 	return m.ValidateTypes(args, fieldTypes)
 }
@@ -103,40 +115,30 @@ func (m *ShellModule) Run(ctx context.Context, conn types.Connection, args map[s
 		// Get parameters
 		cmd := m.GetStringArg(args, "cmd", "")
 		chdir := m.GetStringArg(args, "chdir", "")
-		executable := m.GetStringArg(args, "executable", "/bin/sh")
-		creates := m.GetStringArg(args, "creates", "")
-		removes := m.GetStringArg(args, "removes", "")
+		executable := m.GetStringArg(args, "executable", m.GetTaskVar(args, "ansible_shell_executable", "/bin/sh"))
 		warn := m.GetBoolArg(args, "warn", true)
+		stdin := m.GetStringArg(args, "stdin", "")
+		stdinNewline := m.GetBoolArg(args, "stdin_add_newline", true)
 
-		// Check mode handling
-		if m.CheckMode(args) {
-			return m.CreateCheckModeResult(host, true, fmt.Sprintf("Would execute shell command: %s", cmd), map[string]interface{}{
-				"cmd": cmd,
-			}), nil
+		// Prepare execution options
+		options := types.ExecuteOptions{
+			WorkingDir: chdir,
 		}
 
-		// Check creates condition
-		if creates != "" {
-			if exists, err := m.checkFileExists(conn, creates); err != nil {
-				return m.CreateErrorResult(host, "Failed to check creates condition", err), nil
-			} else if exists {
-				return m.CreateSuccessResult(host, false, fmt.Sprintf("Skipped, since %s exists", creates), map[string]interface{}{
-					"cmd":     cmd,
-					"skipped": true,
-				}), nil
-			}
+		// Guards only read the host, so they are evaluated in check mode too
+		guards := execGuardsArg(args)
+		if skipped, err := guards.check(ctx, conn, options); err != nil {
+			return m.CreateErrorResult(host, "Failed to check guards", err), nil
+		} else if skipped != "" {
+			return m.CreateSuccessResult(host, false, skipped, map[string]interface{}{
+				"cmd":     cmd,
+				"skipped": true,
+			}), nil
 		}
 
-		// Check removes condition
-		if removes != "" {
-			if exists, err := m.checkFileExists(conn, removes); err != nil {
-				return m.CreateErrorResult(host, "Failed to check removes condition", err), nil
-			} else if !exists {
-				return m.CreateSuccessResult(host, false, fmt.Sprintf("Skipped, since %s does not exist", removes), map[string]interface{}{
-					"cmd":     cmd,
-					"skipped": true,
-				}), nil
-			}
+		// Check mode handling
+		if m.CheckMode(args) {
+			return guards.checkModeResult(m.BaseModule, host, cmd), nil
 		}
 
 		// Show warnings for potentially dangerous commands
@@ -145,12 +147,7 @@ func (m *ShellModule) Run(ctx context.Context, conn types.Connection, args map[s
 		}
 
 		// Prepare the shell command
-		shellCmd := fmt.Sprintf("%s -c %s", executable, m.escapeShell(cmd))
-
-		// Prepare execution options
-		options := types.ExecuteOptions{
-			WorkingDir: chdir,
-		}
+		shellCmd := withStdin(fmt.Sprintf("%s -c %s", executable, m.escapeShell(cmd)), stdin, stdinNewline)
 
 		// Execute the shell command
 		result, err := conn.Execute(ctx, shellCmd, options)
@@ -174,20 +171,11 @@ func (m *ShellModule) Run(ctx context.Context, conn types.Connection, args map[s
 	})
 }
 
-// checkFileExists checks if a file exists using the connection
-func (m *ShellModule) checkFileExists(conn types.Connection, path string) (bool, error) {
-	result, err := conn.Execute(context.Background(), fmt.Sprintf("test -e %s", m.escapeShell(path)), types.ExecuteOptions{})
-	if err != nil {
-		return false, err
-	}
-	return result.Success, nil
-}
-
 // escapeShell escapes shell special characters
 func (m *ShellModule) escapeShell(input string) string {
-	// Simple shell escaping
-	input = fmt.Sprintf("'%s'", input)
-	return input
+	// Quote for the shell, closing the quotes around single quotes
+	var cli remoteCLI
+	return cli.shellEscape(input)
 }
 
 // checkAndWarnDangerousCommand warns about potentially dangerous shell commands
@@ -0,0 +1,85 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// SlurpModule implements the slurp module, which returns the content of a
+// file on the host in the result
+type SlurpModule struct {
+	*BaseModule
+}
+
+// NewSlurpModule creates a new slurp module
+func NewSlurpModule() *SlurpModule {
+	doc := types.ModuleDoc{
+		Name:        "slurp",
+		Description: "Read a file from remote hosts into the result, base64 encoded",
+		Parameters: map[string]types.ParamDoc{
+			"src": {
+				Description: "The file on the host to read",
+				Required:    true,
+				Type:        "path",
+			},
+		},
+		Examples: []string{
+			`- name: Read the cluster token
+  slurp:
+    src: /var/lib/rancher/k3s/server/node-token
+  register: token
+
+- name: Show it
+  debug:
+    msg: "{{ token.content | b64decode }}"`,
+		},
+		Returns: map[string]string{
+			"content":  "The content of the file, base64 encoded",
+			"encoding": "The encoding of content, always base64",
+			"source":   "The file that was read",
+		},
+	}
+
+	return &SlurpModule{
+		BaseModule: NewBaseModule("slurp", doc),
+	}
+}
+
+// Validate validates the module arguments
+func (m *SlurpModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"src"}); err != nil {
+		return err
+	}
+	return m.ValidateTypes(args, map[string]string{"src": "string"})
+}
+
+// Run executes the slurp module. Reading changes nothing, so it runs in
+// check mode too.
+func (m *SlurpModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+		src := m.GetStringArg(args, "src", "")
+
+		reader, err := conn.Fetch(ctx, src)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to read %s", src), err), nil
+		}
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return m.CreateErrorResult(host, fmt.Sprintf("Failed to read %s", src), err), nil
+		}
+
+		return m.CreateSuccessResult(host, false, fmt.Sprintf("Read %s", src), map[string]interface{}{
+			"content":  base64.StdEncoding.EncodeToString(content),
+			"encoding": "base64",
+			"source":   src,
+		}), nil
+	})
+}
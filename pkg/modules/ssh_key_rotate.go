@@ -0,0 +1,306 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// sshKeyLogin logs in over a new SSH connection and logs out again. Tests
+// replace it.
+var sshKeyLogin = func(ctx context.Context, info types.ConnectionInfo) error {
+	conn := connection.NewSSHConnection()
+	if err := conn.Connect(ctx, info); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// authorizedKeyID identifies the key of an authorized_keys line regardless
+// of its options and comment. ok is false for blank lines, comments and
+// lines that are not keys.
+func authorizedKeyID(line string) (id string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", false
+	}
+	return string(key.Marshal()), true
+}
+
+// SSHKeyRotateModule replaces the SSH keys of an account without locking
+// it out: the new key is installed and a login with it is verified over a
+// separate connection before the old keys are removed
+type SSHKeyRotateModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSSHKeyRotateModule creates a new ssh_key_rotate module instance
+func NewSSHKeyRotateModule() *SSHKeyRotateModule {
+	doc := types.ModuleDoc{
+		Name:        "ssh_key_rotate",
+		Description: "Rotate the SSH keys of an account: install the new key, verify a login with it over a second connection, then remove the old keys",
+		Parameters: map[string]types.ParamDoc{
+			"user": {
+				Description: "Account whose authorized_keys are rotated",
+				Required:    true,
+				Type:        "string",
+			},
+			"key": {
+				Description: "New public key, as an authorized_keys line",
+				Required:    true,
+				Type:        "string",
+			},
+			"old_keys": {
+				Description: "Public keys to remove once the new key is verified",
+				Required:    false,
+				Type:        "list",
+			},
+			"exclusive": {
+				Description: "Remove every key other than key once it is verified",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"private_key_file": {
+				Description: "Private key of key on the control node, used to verify the login",
+				Required:    false,
+				Type:        "string",
+			},
+			"verify": {
+				Description: "Verify a login with the new key before removing old keys; turning it off risks a lockout",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"verify_host": {
+				Description: "Address to verify the login against; ansible_host when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+			"verify_port": {
+				Description: "SSH port to verify the login against; ansible_port or 22 when omitted",
+				Required:    false,
+				Type:        "int",
+			},
+			"path": {
+				Description: "authorized_keys file; ~/.ssh/authorized_keys of user when omitted",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Rotate the deploy key\n  ssh_key_rotate:\n    user: deploy\n    key: \"{{ lookup('file', 'keys/deploy-2026.pub') }}\"\n    private_key_file: keys/deploy-2026\n    old_keys:\n      - \"{{ lookup('file', 'keys/deploy-2025.pub') }}\"",
+		},
+		Returns: map[string]string{
+			"user":         "Account name",
+			"path":         "authorized_keys file",
+			"key_added":    "Whether the new key was installed",
+			"removed_keys": "Number of old keys removed",
+			"verified":     "Whether a login with the new key was verified",
+		},
+	}
+
+	base := NewBaseModule("ssh_key_rotate", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SSHKeyRotateModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SSHKeyRotateModule) Validate(args map[string]interface{}) error {
+	user := m.GetStringArg(args, "user", "")
+	if user == "" {
+		return types.NewValidationError("user", nil, "required parameter")
+	}
+	if strings.ContainsAny(user, ":/\n") {
+		return types.NewValidationError("user", user, "account names cannot contain colons, slashes or newlines")
+	}
+	key := m.GetStringArg(args, "key", "")
+	if _, ok := authorizedKeyID(key); !ok || strings.Contains(strings.TrimSpace(key), "\n") {
+		return types.NewValidationError("key", key, "must be a single authorized_keys line")
+	}
+	for _, old := range stringList(args["old_keys"]) {
+		if _, ok := authorizedKeyID(old); !ok {
+			return types.NewValidationError("old_keys", old, "must be authorized_keys lines")
+		}
+	}
+	if len(stringList(args["old_keys"])) > 0 && m.GetBoolArg(args, "exclusive", false) {
+		return types.NewValidationError("old_keys", args["old_keys"], "old_keys and exclusive are mutually exclusive")
+	}
+	if m.GetBoolArg(args, "verify", true) && m.GetStringArg(args, "private_key_file", "") == "" {
+		return types.NewValidationError("private_key_file", nil, "required to verify the new key; set verify: false to skip the verification")
+	}
+	if _, ok := args["verify_port"]; ok {
+		if port, err := m.GetIntArg(args, "verify_port", 0); err != nil || port < 1 || port > 65535 {
+			return types.NewValidationError("verify_port", args["verify_port"], "must be a port number")
+		}
+	}
+	return nil
+}
+
+// Run executes the ssh_key_rotate module
+func (m *SSHKeyRotateModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	user := m.GetStringArg(args, "user", "")
+	key := strings.TrimSpace(m.GetStringArg(args, "key", ""))
+	newID, _ := authorizedKeyID(key)
+	exclusive := m.GetBoolArg(args, "exclusive", false)
+	oldIDs := make(map[string]bool)
+	for _, old := range stringList(args["old_keys"]) {
+		if id, _ := authorizedKeyID(old); id != newID {
+			oldIDs[id] = true
+		}
+	}
+
+	path, defaultPath, err := m.keysPath(ctx, conn, user, args)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := m.cli.inspect(ctx, conn, path, "test -f "+m.cli.shellEscape(path), "cat "+m.cli.shellEscape(path))
+	if err != nil {
+		return nil, err
+	}
+
+	hasNew := false
+	removed := 0
+	var kept []string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		id, isKey := authorizedKeyID(line)
+		switch {
+		case isKey && id == newID:
+			hasNew = true
+		case isKey && (exclusive || oldIDs[id]):
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	after := strings.Join(kept, "")
+	if !hasNew {
+		if after != "" && !strings.HasSuffix(after, "\n") {
+			after += "\n"
+		}
+		after += key + "\n"
+	}
+
+	var changes []string
+	if !hasNew {
+		changes = append(changes, "added the new key of "+user)
+	}
+	if removed > 0 {
+		changes = append(changes, fmt.Sprintf("removed %d old key(s) of %s", removed, user))
+	}
+	verify := m.GetBoolArg(args, "verify", true)
+
+	data := map[string]interface{}{"user": user, "path": path, "key_added": !hasNew, "removed_keys": removed, "verified": false}
+	result := m.CreateSuccessResult(hostname, false, "SSH keys are already rotated", data)
+
+	if len(changes) > 0 && !checkMode {
+		if !hasNew {
+			if err := m.addKey(ctx, conn, user, path, defaultPath, key); err != nil {
+				return nil, err
+			}
+		}
+		// Old keys stay until a login with the new key works
+		if verify {
+			if err := m.verifyLogin(ctx, user, args); err != nil {
+				return nil, fmt.Errorf("login as %s with the new key failed, the old keys were kept: %w", user, err)
+			}
+			data["verified"] = true
+		}
+		if removed > 0 {
+			tmp := m.cli.shellEscape(path + ".gosible")
+			cmd := fmt.Sprintf("printf '%%s' %s > %s && cat %s > %s && rm -f %s", m.cli.shellEscape(after), tmp, tmp, m.cli.shellEscape(path), tmp)
+			if _, err := m.cli.run(ctx, conn, "removing old keys of "+user, cmd); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, content, after, startTime), nil
+}
+
+// keysPath returns the authorized_keys file of user and whether it is the
+// default one in the home directory
+func (m *SSHKeyRotateModule) keysPath(ctx context.Context, conn types.Connection, user string, args map[string]interface{}) (string, bool, error) {
+	if path := m.GetStringArg(args, "path", ""); path != "" {
+		return path, false, nil
+	}
+	entry, exists, err := m.cli.inspect(ctx, conn, "account "+user, "getent passwd "+m.cli.shellEscape(user), "getent passwd "+m.cli.shellEscape(user))
+	if err != nil {
+		return "", false, err
+	}
+	fields := strings.Split(strings.TrimSpace(entry), ":")
+	if !exists || len(fields) < 6 {
+		return "", false, fmt.Errorf("account %s does not exist", user)
+	}
+	return strings.TrimSuffix(fields[5], "/") + "/.ssh/authorized_keys", true, nil
+}
+
+// addKey appends key to the authorized_keys file, creating ~/.ssh with the
+// permissions sshd insists on when the file is the default one
+func (m *SSHKeyRotateModule) addKey(ctx context.Context, conn types.Connection, user, path string, defaultPath bool, key string) error {
+	file := m.cli.shellEscape(path)
+	cmd := fmt.Sprintf(`if [ -s %s ] && [ -n "$(tail -c 1 %s)" ]; then echo >> %s; fi; printf '%%s\n' %s >> %s`, file, file, file, m.cli.shellEscape(key), file)
+	if defaultPath {
+		dir := m.cli.shellEscape(strings.TrimSuffix(path, "/authorized_keys"))
+		owner := m.cli.shellEscape(user + ":")
+		cmd = fmt.Sprintf("mkdir -p %s && chmod 700 %s && chown %s %s && %s && chmod 600 %s && chown %s %s", dir, dir, owner, dir, cmd, file, owner, file)
+	}
+	_, err := m.cli.run(ctx, conn, "adding the new key of "+user, cmd)
+	return err
+}
+
+// verifyLogin logs in as user with the new private key over a connection
+// of its own, reaching the host the way the inventory does
+func (m *SSHKeyRotateModule) verifyLogin(ctx context.Context, user string, args map[string]interface{}) error {
+	vars, _ := args["_task_vars"].(map[string]interface{})
+	host := m.GetStringArg(args, "verify_host", m.GetTaskVar(args, "ansible_host", ""))
+	if host == "" {
+		return fmt.Errorf("no address to verify against; set verify_host")
+	}
+	port, _ := m.GetIntArg(args, "verify_port", 0)
+	if port == 0 {
+		port, _ = strconv.Atoi(m.GetTaskVar(args, "ansible_port", "22"))
+	}
+
+	info := types.ConnectionInfo{
+		Type:         "ssh",
+		Host:         host,
+		Port:         port,
+		User:         user,
+		PrivateKey:   m.GetStringArg(args, "private_key_file", ""),
+		Timeout:      30 * time.Second,
+		ProxyJump:    m.GetTaskVar(args, "ansible_ssh_proxy_jump", ""),
+		ProxyCommand: m.GetTaskVar(args, "ansible_ssh_proxy_command", ""),
+	}
+	jumpHosts, err := connection.ParseJumpHosts(vars["ansible_ssh_jump_hosts"])
+	if err != nil {
+		return err
+	}
+	info.JumpHosts = jumpHosts
+	return sshKeyLogin(ctx, info)
+}
@@ -0,0 +1,114 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+const (
+	newDeployKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAII29mf8LhdZdPjEWtkG6JFVKEVIyzuvR/y5JABiH5ez1 deploy-2026"
+	oldDeployKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMqxL5+9MOXz4KgY1PLpi3ds0CvMNCS2utaZCMDkJ97o deploy-2025"
+)
+
+func TestSSHKeyRotateModule(t *testing.T) {
+	var logins []types.ConnectionInfo
+	var loginErr error
+	defer func(login func(context.Context, types.ConnectionInfo) error) { sshKeyLogin = login }(sshKeyLogin)
+	sshKeyLogin = func(ctx context.Context, info types.ConnectionInfo) error {
+		logins = append(logins, info)
+		return loginErr
+	}
+
+	module := NewSSHKeyRotateModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"user": "deploy", "key": newDeployKey, "old_keys": []interface{}{oldDeployKey}, "private_key_file": "keys/deploy"}, ExpectValid: true},
+		{Name: "Unverified", Args: map[string]interface{}{"user": "deploy", "key": newDeployKey, "exclusive": true, "verify": false}, ExpectValid: true},
+		{Name: "NoPrivateKey", Args: map[string]interface{}{"user": "deploy", "key": newDeployKey}, ExpectValid: false},
+		{Name: "InvalidKey", Args: map[string]interface{}{"user": "deploy", "key": "not a key", "verify": false}, ExpectValid: false},
+		{Name: "OldKeysAndExclusive", Args: map[string]interface{}{"user": "deploy", "key": newDeployKey, "old_keys": []interface{}{oldDeployKey}, "exclusive": true, "verify": false}, ExpectValid: false},
+	})
+
+	taskVars := map[string]interface{}{"ansible_host": "192.0.2.10", "ansible_port": 2222}
+	passwd := &testhelper.CommandResponse{Stdout: existsMarker + "deploy:x:1001:1001::/home/deploy:/bin/bash\n"}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Rotate",
+			Args:     map[string]interface{}{"user": "deploy", "key": newDeployKey, "old_keys": []interface{}{oldDeployKey}, "private_key_file": "keys/deploy", "_task_vars": taskVars},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				logins = nil
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, passwd)
+				h.GetConnection().ExpectCommandPattern(`^if test -f '/home/deploy/.ssh/authorized_keys'`, &testhelper.CommandResponse{Stdout: existsMarker + "# managed\n" + oldDeployKey})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/home/deploy/.ssh' && chmod 700 .* printf '%s\\n' 'ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAII29`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`printf '%s' '# managed
+`+newDeployKey+`
+' > '/home/deploy/.ssh/authorized_keys.gosible' && cat '/home/deploy/.ssh/authorized_keys.gosible' > '/home/deploy/.ssh/authorized_keys' && rm -f '/home/deploy/.ssh/authorized_keys.gosible'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Added the new key of deploy, removed 1 old key(s) of deploy")
+				h.AssertDataValue(result, "verified", true)
+				h.AssertDiffAfter(result, "# managed\n"+newDeployKey+"\n")
+				if len(logins) != 1 || logins[0].Host != "192.0.2.10" || logins[0].Port != 2222 || logins[0].User != "deploy" || logins[0].PrivateKey != "keys/deploy" {
+					t.Errorf("unexpected verification logins %+v", logins)
+				}
+			},
+		},
+		{
+			Name:        "VerificationFails",
+			Args:        map[string]interface{}{"user": "deploy", "key": newDeployKey, "exclusive": true, "private_key_file": "keys/deploy", "path": "/etc/ssh/keys/deploy", "verify_host": "web1.example.com"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				logins, loginErr = nil, errors.New("ssh: unable to authenticate")
+				h.GetConnection().ExpectCommandPattern(`^if test -f '/etc/ssh/keys/deploy'`, &testhelper.CommandResponse{Stdout: existsMarker + newDeployKey + "\n" + oldDeployKey + "\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				loginErr = nil
+				h.GetConnection().AssertPatternCalledTimes(`authorized_keys.gosible|/etc/ssh/keys/deploy.gosible`, 0)
+				if len(logins) != 1 || logins[0].Host != "web1.example.com" || logins[0].Port != 22 {
+					t.Errorf("unexpected verification logins %+v", logins)
+				}
+			},
+		},
+		{
+			Name: "AlreadyRotated",
+			Args: map[string]interface{}{"user": "deploy", "key": newDeployKey, "old_keys": []interface{}{oldDeployKey}, "private_key_file": "keys/deploy"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				logins = nil
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, passwd)
+				h.GetConnection().ExpectCommandPattern(`^if test -f`, &testhelper.CommandResponse{Stdout: existsMarker + `from="10.0.0.0/8" ` + newDeployKey + "\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				if len(logins) != 0 {
+					t.Errorf("expected no verification login, got %+v", logins)
+				}
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"user": "deploy", "key": newDeployKey, "private_key_file": "keys/deploy"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				logins = nil
+				h.GetConnection().ExpectCommandPattern(`^if getent passwd 'deploy'`, passwd)
+				h.GetConnection().ExpectCommandPattern(`^if test -f`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have added the new key of deploy")
+				if len(logins) != 0 {
+					t.Errorf("expected no verification login in check mode, got %+v", logins)
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,360 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// swapStatus is the state of a swap file or partition on the target host
+type swapStatus struct {
+	exists       bool
+	size         int64  // File size in bytes
+	fsType       string // Signature reported by blkid, "swap" once formatted
+	active       bool
+	used         int64 // Swap in use in KiB
+	priority     int
+	fstab        bool
+	memAvailable int64 // MemAvailable in KiB
+}
+
+// format renders the status for diffs
+func (s *swapStatus) format(path string, device bool) string {
+	if !s.exists {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", path)
+	if !device {
+		fmt.Fprintf(&b, "  size=%d\n", s.size)
+	}
+	fmt.Fprintf(&b, "  type=%s\n", s.fsType)
+	fmt.Fprintf(&b, "  active=%t\n", s.active)
+	if s.active {
+		fmt.Fprintf(&b, "  priority=%d\n", s.priority)
+	}
+	fmt.Fprintf(&b, "  fstab=%t\n", s.fstab)
+	return b.String()
+}
+
+// SwapFileModule manages swap files and swap partitions
+type SwapFileModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSwapFileModule creates a new swap_file module instance
+func NewSwapFileModule() *SwapFileModule {
+	doc := types.ModuleDoc{
+		Name:        "swap_file",
+		Description: "Create, enable and remove swap files and swap partitions. Resizing an active swap file first checks that its contents fit in available memory",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Swap file path, or a block device under /dev",
+				Required:    true,
+				Type:        "path",
+			},
+			"state": {
+				Description: "present creates and enables the swap space, disabled keeps it but turns it off, absent turns it off and removes swap files",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "disabled", "absent"},
+			},
+			"size": {
+				Description: "Size of a swap file, e.g. 2G; required to create one",
+				Required:    false,
+				Type:        "string",
+			},
+			"priority": {
+				Description: "Swap priority passed to swapon",
+				Required:    false,
+				Type:        "int",
+			},
+			"persist": {
+				Description: "Keep the fstab entry in line with the state",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"fstab": {
+				Description: "fstab file to update",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/fstab",
+			},
+			"force": {
+				Description: "Format a partition that carries another filesystem signature",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Add a 2G swap file\n  swap_file:\n    path: /swapfile\n    size: 2G",
+			"- name: Use a dedicated partition\n  swap_file:\n    path: /dev/nvme0n1p3\n    priority: 10",
+		},
+		Returns: map[string]string{
+			"path":   "Swap file or device",
+			"active": "Whether the swap space is enabled",
+			"size":   "Size of the swap file in bytes",
+		},
+	}
+
+	base := NewBaseModule("swap_file", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SwapFileModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SwapFileModule) Validate(args map[string]interface{}) error {
+	path := m.GetStringArg(args, "path", "")
+	if path == "" {
+		return types.NewValidationError("path", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "disabled", "absent"}); err != nil {
+		return err
+	}
+	if size := m.GetStringArg(args, "size", ""); size != "" {
+		if strings.HasPrefix(path, "/dev/") {
+			return types.NewValidationError("size", size, "size cannot be set for a block device")
+		}
+		if bytes, ok := parseByteSize(size); !ok || bytes < 40*1024 {
+			return types.NewValidationError("size", size, "size must be at least 40K, e.g. 512M or 2G")
+		}
+	}
+	if _, ok := args["priority"]; ok {
+		if _, err := m.GetIntArg(args, "priority", 0); err != nil {
+			return types.NewValidationError("priority", args["priority"], "priority must be an integer")
+		}
+	}
+	return nil
+}
+
+// Run executes the swap_file module
+func (m *SwapFileModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	path := m.GetStringArg(args, "path", "")
+	state := m.GetStringArg(args, "state", "present")
+	fstab := m.GetStringArg(args, "fstab", "/etc/fstab")
+	persist := m.GetBoolArg(args, "persist", true)
+	device := strings.HasPrefix(path, "/dev/")
+	_, hasPriority := args["priority"]
+	priority, _ := m.GetIntArg(args, "priority", 0)
+
+	var size int64
+	if s := m.GetStringArg(args, "size", ""); s != "" {
+		bytes, _ := parseByteSize(s)
+		size = int64(bytes)
+	}
+
+	status, err := m.status(ctx, conn, path, fstab)
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := m.cli.shellEscape(path)
+	var steps, changes []string
+	desired := *status
+
+	// Turning swap off pages its contents back in, which must fit in memory
+	swapoff := func() error {
+		if status.used > status.memAvailable {
+			return fmt.Errorf("cannot disable %s: %d KiB of swap in use but only %d KiB of memory available", path, status.used, status.memAvailable)
+		}
+		steps = append(steps, "swapoff "+quoted)
+		desired.active = false
+		return nil
+	}
+
+	switch state {
+	case "present", "disabled":
+		switch {
+		case device && !status.exists:
+			return nil, fmt.Errorf("swap device %s does not exist", path)
+
+		case device && status.fsType != "swap":
+			if status.fsType != "" && !m.GetBoolArg(args, "force", false) {
+				return nil, fmt.Errorf("%s contains a %s filesystem, set force to format it as swap", path, status.fsType)
+			}
+			steps = append(steps, "mkswap "+quoted)
+			changes = append(changes, "formatted "+path)
+
+		case !device && !status.exists:
+			if size == 0 {
+				return nil, fmt.Errorf("size is required to create swap file %s", path)
+			}
+			steps = append(steps, m.createFile(path, size))
+			changes = append(changes, fmt.Sprintf("created swap file %s", path))
+			desired.exists, desired.size = true, size
+
+		case !device && size != 0 && status.size != size:
+			if status.active {
+				if err := swapoff(); err != nil {
+					return nil, err
+				}
+			}
+			steps = append(steps, "rm -f "+quoted, m.createFile(path, size))
+			changes = append(changes, fmt.Sprintf("resized swap file %s from %d to %d bytes", path, status.size, size))
+			desired.size = size
+
+		case !device && status.fsType != "swap":
+			steps = append(steps, "chmod 0600 "+quoted+" && mkswap "+quoted)
+			changes = append(changes, "formatted "+path)
+		}
+		desired.fsType = "swap"
+
+		if state == "present" {
+			if desired.active && hasPriority && status.priority != priority {
+				if err := swapoff(); err != nil {
+					return nil, err
+				}
+				changes = append(changes, fmt.Sprintf("changed priority to %d", priority))
+			}
+			if !desired.active {
+				cmd := "swapon"
+				if hasPriority {
+					cmd += fmt.Sprintf(" -p %d", priority)
+				}
+				steps = append(steps, cmd+" "+quoted)
+				if !status.active {
+					changes = append(changes, "enabled "+path)
+				}
+				desired.active = true
+				if hasPriority {
+					desired.priority = priority
+				}
+			}
+		} else if desired.active {
+			if err := swapoff(); err != nil {
+				return nil, err
+			}
+			changes = append(changes, "disabled "+path)
+		}
+
+		if persist && !status.fstab {
+			options := "sw"
+			if hasPriority {
+				options += fmt.Sprintf(",pri=%d", priority)
+			}
+			entry := fmt.Sprintf("%s none swap %s 0 0", path, options)
+			steps = append(steps, fmt.Sprintf("printf '%%s\\n' %s >> %s", m.cli.shellEscape(entry), m.cli.shellEscape(fstab)))
+			changes = append(changes, "added it to "+fstab)
+			desired.fstab = true
+		}
+
+	case "absent":
+		if status.active {
+			if err := swapoff(); err != nil {
+				return nil, err
+			}
+			changes = append(changes, "disabled "+path)
+		}
+		if persist && status.fstab {
+			steps = append(steps, m.removeFstabEntry(path, fstab))
+			changes = append(changes, "removed it from "+fstab)
+			desired.fstab = false
+		}
+		if !device && status.exists {
+			steps = append(steps, "rm -f "+quoted)
+			changes = append(changes, "removed swap file "+path)
+			desired = swapStatus{}
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Swap %s is already in desired state", path), map[string]interface{}{
+		"path":   path,
+		"active": desired.active,
+	})
+	if !device {
+		result.Data["size"] = desired.size
+	}
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "swap", step); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	change := strings.Join(changes, ", ")
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, status.format(path, device), desired.format(path, device), startTime), nil
+}
+
+// status reads the state of the swap space and the memory available to
+// absorb it
+func (m *SwapFileModule) status(ctx context.Context, conn types.Connection, path, fstab string) (*swapStatus, error) {
+	quoted := m.cli.shellEscape(path)
+	cmd := strings.Join([]string{
+		fmt.Sprintf("if [ -e %s ]; then echo exists=true; echo size=$(stat -Lc %%s %s); echo type=$(blkid -o value -s TYPE %s 2>/dev/null); fi", quoted, quoted, quoted),
+		fmt.Sprintf("awk -v p=%s '$1 == p {print \"active=true\"; print \"used=\" $4; print \"priority=\" $5}' /proc/swaps", quoted),
+		fmt.Sprintf("awk -v p=%s '$1 == p && $3 == \"swap\" {print \"fstab=true\"}' %s 2>/dev/null", quoted, m.cli.shellEscape(fstab)),
+		"awk '/^MemAvailable:/ {print \"mem_available=\" $2}' /proc/meminfo",
+	}, "; ")
+
+	result, err := m.cli.run(ctx, conn, "reading swap status of "+path, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &swapStatus{}
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "exists":
+			status.exists = value == "true"
+		case "size":
+			status.size, _ = strconv.ParseInt(value, 10, 64)
+		case "type":
+			status.fsType = value
+		case "active":
+			status.active = value == "true"
+		case "used":
+			status.used, _ = strconv.ParseInt(value, 10, 64)
+		case "priority":
+			priority, _ := strconv.Atoi(value)
+			status.priority = priority
+		case "fstab":
+			status.fstab = value == "true"
+		case "mem_available":
+			status.memAvailable, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return status, nil
+}
+
+// createFile builds the command allocating and formatting a swap file.
+// fallocate is not supported for swap on every filesystem, so dd is the
+// fallback.
+func (m *SwapFileModule) createFile(path string, size int64) string {
+	quoted := m.cli.shellEscape(path)
+	return fmt.Sprintf("mkdir -p %s && (fallocate -l %d %s 2>/dev/null || dd if=/dev/zero of=%s bs=1024 count=%d status=none) && chmod 0600 %s && mkswap %s",
+		m.cli.shellEscape(parentDir(path)), size, quoted, quoted, size/1024, quoted, quoted)
+}
+
+// removeFstabEntry builds the command dropping the swap entry for path
+func (m *SwapFileModule) removeFstabEntry(path, fstab string) string {
+	file := m.cli.shellEscape(fstab)
+	tmp := m.cli.shellEscape(fstab + ".gosible.tmp")
+	return fmt.Sprintf("awk -v p=%s '!($1 == p && $3 == \"swap\")' %s > %s && cat %s > %s && rm -f %s",
+		m.cli.shellEscape(path), file, tmp, tmp, file, tmp)
+}
@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSwapFileModule(t *testing.T) {
+	module := NewSwapFileModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidFile", Args: map[string]interface{}{"path": "/swapfile", "size": "2G"}, ExpectValid: true},
+		{Name: "ValidDevice", Args: map[string]interface{}{"path": "/dev/sdb2", "priority": 10}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{"size": "2G"}, ExpectValid: false},
+		{Name: "SizeForDevice", Args: map[string]interface{}{"path": "/dev/sdb2", "size": "2G"}, ExpectValid: false},
+		{Name: "InvalidSize", Args: map[string]interface{}{"path": "/swapfile", "size": "lots"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"path": "/swapfile", "state": "on"}, ExpectValid: false},
+	})
+
+	status := func(stdout string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if \[ -e '/swapfile' \]`, &testhelper.CommandResponse{Stdout: stdout})
+		}
+	}
+	const activeSwap = "exists=true\nsize=1073741824\ntype=swap\nactive=true\nused=204800\npriority=-2\nfstab=true\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "CreateAndEnable",
+			Args: map[string]interface{}{"path": "/swapfile", "size": "1G"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status("mem_available=4000000\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/' && \(fallocate -l 1073741824 '/swapfile' 2>/dev/null \|\| dd if=/dev/zero of='/swapfile' bs=1024 count=1048576 status=none\) && chmod 0600 '/swapfile' && mkswap '/swapfile'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^swapon '/swapfile'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^printf '%s\\n' '/swapfile none swap sw 0 0' >> '/etc/fstab'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created swap file /swapfile, enabled /swapfile, added it to /etc/fstab")
+				h.AssertDataValue(result, "active", true)
+			},
+		},
+		{
+			Name:  "AlreadyActive",
+			Args:  map[string]interface{}{"path": "/swapfile", "size": "1G"},
+			Setup: status(activeSwap + "mem_available=4000000\n"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "ResizeActiveFile",
+			Args: map[string]interface{}{"path": "/swapfile", "size": "2G"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status(activeSwap + "mem_available=4000000\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^swapoff '/swapfile'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^rm -f '/swapfile'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`fallocate -l 2147483648 `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^swapon '/swapfile'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Resized swap file /swapfile from 1073741824 to 2147483648 bytes")
+			},
+		},
+		{
+			Name:        "ResizeRefusedWithoutMemory",
+			Args:        map[string]interface{}{"path": "/swapfile", "size": "2G"},
+			ExpectError: true,
+			Setup:       status(activeSwap + "mem_available=1000\n"),
+		},
+		{
+			Name:      "DisableInCheckMode",
+			Args:      map[string]interface{}{"path": "/swapfile", "state": "disabled"},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup:     status(activeSwap + "mem_available=4000000\n"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertDiffAfter(result, "/swapfile\n  size=1073741824\n  type=swap\n  active=false\n  fstab=true\n")
+				h.AssertMessage(result, "Would have disabled /swapfile")
+			},
+		},
+		{
+			Name: "Remove",
+			Args: map[string]interface{}{"path": "/swapfile", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status(activeSwap + "mem_available=4000000\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^swapoff '/swapfile'$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^awk -v p='/swapfile' '!\(\$1 == p && \$3 == "swap"\)' '/etc/fstab' > '/etc/fstab.gosible.tmp'`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^rm -f '/swapfile'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Disabled /swapfile, removed it from /etc/fstab, removed swap file /swapfile")
+			},
+		},
+		{
+			Name:        "DeviceWithFilesystem",
+			Args:        map[string]interface{}{"path": "/dev/sdb2"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -e '/dev/sdb2' \]`, &testhelper.CommandResponse{Stdout: "exists=true\nsize=0\ntype=ext4\n"})
+			},
+		},
+	})
+}
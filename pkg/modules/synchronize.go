@@ -0,0 +1,423 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// rsyncChangedMarker starts the lines rsync prints for changed files
+const rsyncChangedMarker = "<<CHANGED>>"
+
+// SynchronizeModule implements the synchronize module. It runs rsync on
+// the controller when rsync is available on both ends and the host is
+// reached over plain SSH, and otherwise synchronizes through the connection
+// with a native engine comparing sizes and modification times, or
+// checksums.
+type SynchronizeModule struct {
+	*BaseModule
+	cli remoteCLI
+	// lookPath finds rsync on the controller
+	lookPath func(file string) (string, error)
+}
+
+// NewSynchronizeModule creates a new synchronize module
+func NewSynchronizeModule() *SynchronizeModule {
+	doc := types.ModuleDoc{
+		Name:        "synchronize",
+		Description: "Synchronize directories between the controller and hosts, with rsync or a native engine",
+		Parameters: map[string]types.ParamDoc{
+			"src": {
+				Description: "Path to synchronize from: on the controller when pushing, on the host when pulling. With a trailing slash the directory's contents are synchronized, without it the directory itself",
+				Required:    true,
+				Type:        "path",
+			},
+			"dest": {
+				Description: "Path to synchronize to: on the host when pushing, on the controller when pulling",
+				Required:    true,
+				Type:        "path",
+			},
+			"mode": {
+				Description: "Whether to push from the controller to the host or pull from the host",
+				Required:    false,
+				Type:        "string",
+				Default:     "push",
+				Choices:     []string{"push", "pull"},
+			},
+			"delete": {
+				Description: "Delete files in dest that are not in src",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"recursive": {
+				Description: "Descend into directories",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"archive": {
+				Description: "Preserve permissions and modification times",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"checksum": {
+				Description: "Compare files by checksum instead of size and modification time",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"compress": {
+				Description: "Compress file data during transfer. Only used by rsync",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"exclude": {
+				Description: "Patterns of paths to leave alone, in rsync's --exclude syntax",
+				Required:    false,
+				Type:        "list",
+			},
+			"rsync_opts": {
+				Description: "Additional rsync options. The native engine honors --exclude options",
+				Required:    false,
+				Type:        "list",
+			},
+			"rsync_path": {
+				Description: "The command to run rsync on the host, such as \"sudo rsync\". Defaults to sudo rsync when become is set",
+				Required:    false,
+				Type:        "string",
+			},
+			"engine": {
+				Description: "Use rsync or the native engine. auto uses rsync when the controller and host have it and the host is reached over SSH without a password",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "rsync", "native"},
+			},
+		},
+		Examples: []string{
+			`- name: Push the site, removing stale files
+  synchronize:
+    src: build/site/
+    dest: /var/www/site
+    delete: true
+    exclude:
+      - "*.tmp"
+      - .git/`,
+			`- name: Pull logs from the host
+  synchronize:
+    mode: pull
+    src: /var/log/app/
+    dest: logs/{{ inventory_hostname }}/`,
+			`- name: Always use the native engine, comparing checksums
+  synchronize:
+    src: files/config/
+    dest: /etc/app
+    engine: native
+    checksum: true`,
+		},
+		Returns: map[string]string{
+			"engine":  "The engine that synchronized, rsync or native",
+			"changes": "The itemized changes, in rsync's format",
+			"cmd":     "The rsync command line, when rsync was used",
+		},
+	}
+
+	return &SynchronizeModule{
+		BaseModule: NewBaseModule("synchronize", doc),
+		lookPath:   exec.LookPath,
+	}
+}
+
+// Validate validates the module arguments
+func (m *SynchronizeModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateRequired(args, []string{"src", "dest"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "mode", []string{"push", "pull"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "engine", []string{"auto", "rsync", "native"}); err != nil {
+		return err
+	}
+
+	fieldTypes := map[string]string{
+		"src":        "string",
+		"dest":       "string",
+		"mode":       "string",
+		"delete":     "bool",
+		"recursive":  "bool",
+		"archive":    "bool",
+		"checksum":   "bool",
+		"compress":   "bool",
+		"rsync_path": "string",
+		"engine":     "string",
+	}
+	return m.ValidateTypes(args, fieldTypes)
+}
+
+// Run executes the synchronize module
+func (m *SynchronizeModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		host := m.GetHostFromConnection(conn)
+		src := m.GetStringArg(args, "src", "")
+		dest := m.GetStringArg(args, "dest", "")
+
+		engine := m.GetStringArg(args, "engine", "auto")
+		remote, remoteErr := m.rsyncRemote(args)
+		if engine == "auto" {
+			engine = "native"
+			if remoteErr == nil && m.rsyncAvailable(ctx, conn) {
+				engine = "rsync"
+			}
+		}
+		if engine == "rsync" {
+			if remoteErr != nil {
+				return m.CreateErrorResult(host, "Cannot synchronize with rsync", remoteErr), nil
+			}
+			return m.runRsync(ctx, host, remote, args)
+		}
+
+		opts := m.syncOptions(args)
+		var source, target syncTree = localSyncTree{}, remoteSyncTree{conn: conn}
+		if opts.marker == '>' {
+			source, target = target, source
+		}
+		changes, err := m.runNative(ctx, source, target, src, dest, opts, m.CheckMode(args))
+		data := map[string]interface{}{"engine": "native", "changes": changes}
+		if err != nil {
+			return m.CreateFailureResult(host, fmt.Sprintf("Failed to synchronize %s to %s", src, dest), err, data), nil
+		}
+
+		if m.CheckMode(args) {
+			return m.CreateCheckModeResult(host, len(changes) > 0, fmt.Sprintf("Would synchronize %s to %s", src, dest), data), nil
+		}
+		return m.CreateSuccessResult(host, len(changes) > 0, fmt.Sprintf("Synchronized %s to %s", src, dest), data), nil
+	})
+}
+
+// syncOptions reads the settings of the native engine
+func (m *SynchronizeModule) syncOptions(args map[string]interface{}) syncOptions {
+	opts := syncOptions{
+		recursive: m.GetBoolArg(args, "recursive", true),
+		checksum:  m.GetBoolArg(args, "checksum", false),
+		delete:    m.GetBoolArg(args, "delete", false),
+		archive:   m.GetBoolArg(args, "archive", true),
+		excludes:  stringList(args["exclude"]),
+		marker:    '<',
+	}
+	if m.GetStringArg(args, "mode", "push") == "pull" {
+		opts.marker = '>'
+	}
+
+	rsyncOpts := stringList(args["rsync_opts"])
+	for i := 0; i < len(rsyncOpts); i++ {
+		if pattern, ok := strings.CutPrefix(rsyncOpts[i], "--exclude="); ok {
+			opts.excludes = append(opts.excludes, pattern)
+		} else if rsyncOpts[i] == "--exclude" && i+1 < len(rsyncOpts) {
+			opts.excludes = append(opts.excludes, rsyncOpts[i+1])
+			i++
+		}
+	}
+	return opts
+}
+
+// runNative synchronizes src on the source tree to dest on the target tree
+// and returns the itemized changes, which in check mode are only planned
+func (m *SynchronizeModule) runNative(ctx context.Context, source, target syncTree, src, dest string, opts syncOptions, checkMode bool) ([]string, error) {
+	sourceEntries, err := source.list(ctx, src, opts.recursive, opts.checksum)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := sourceEntries["."]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist", src)
+	}
+
+	// Follow rsync: a directory without a trailing slash, and a file
+	// synchronized into a directory, keep their name under dest
+	name := path.Base(filepath.ToSlash(strings.TrimRight(src, "/")))
+	targetRoot := dest
+	var targetEntries map[string]syncEntry
+	switch {
+	case root.dir && !strings.HasSuffix(src, "/"), !root.dir && strings.HasSuffix(dest, "/"):
+		targetRoot = target.join(dest, name)
+	case !root.dir:
+		if targetEntries, err = target.list(ctx, dest, false, opts.checksum); err != nil {
+			return nil, err
+		}
+		if existing, ok := targetEntries["."]; ok && existing.dir {
+			targetRoot, targetEntries = target.join(dest, name), nil
+		}
+	}
+	if targetEntries == nil {
+		if targetEntries, err = target.list(ctx, targetRoot, opts.recursive, opts.checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := planSync(sourceEntries, targetEntries, opts)
+	if checkMode || plan.empty() {
+		return plan.changes, nil
+	}
+	return plan.changes, applySync(ctx, plan, source, src, sourceEntries, target, targetRoot, opts)
+}
+
+// rsyncRemote returns how rsync reaches the host: "" for a local host, or
+// the ssh destination and the remote shell to reach it with. Hosts that
+// need a password or a connection other than SSH cannot use rsync.
+func (m *SynchronizeModule) rsyncRemote(args map[string]interface{}) (*rsyncRemote, error) {
+	connection := m.GetTaskVar(args, "ansible_connection", "")
+	address := m.GetTaskVar(args, "ansible_host", "")
+	if connection == "local" || (connection == "" && (address == "localhost" || address == "127.0.0.1")) {
+		return &rsyncRemote{}, nil
+	}
+	if connection != "" && connection != "ssh" {
+		return nil, fmt.Errorf("rsync cannot reach hosts over %s connections", connection)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("the host has no address")
+	}
+	for _, name := range []string{"ansible_password", "ansible_ssh_pass"} {
+		if m.GetTaskVar(args, name, "") != "" {
+			return nil, fmt.Errorf("rsync cannot log in with a password")
+		}
+	}
+
+	shell := []string{"ssh", "-o", "BatchMode=yes"}
+	if port := m.GetTaskVar(args, "ansible_port", ""); port != "" && port != "0" {
+		shell = append(shell, "-p", port)
+	}
+	for _, name := range []string{"ansible_ssh_private_key_file", "ansible_private_key_file"} {
+		if key := m.GetTaskVar(args, name, ""); key != "" {
+			shell = append(shell, "-i", key)
+			break
+		}
+	}
+	if jump := m.GetTaskVar(args, "ansible_ssh_proxy_jump", ""); jump != "" {
+		shell = append(shell, "-J", jump)
+	}
+
+	if user := m.GetTaskVar(args, "ansible_user", ""); user != "" {
+		address = user + "@" + address
+	}
+	if strings.Contains(address, ":") && !strings.HasPrefix(address, "[") {
+		address = "[" + address + "]"
+	}
+	return &rsyncRemote{address: address, shell: shell}, nil
+}
+
+// rsyncRemote is how rsync reaches a host, with an empty address for the
+// controller itself
+type rsyncRemote struct {
+	address string
+	shell   []string
+}
+
+// rsyncAvailable reports whether the controller and the host have rsync
+func (m *SynchronizeModule) rsyncAvailable(ctx context.Context, conn types.Connection) bool {
+	if _, err := m.lookPath("rsync"); err != nil {
+		return false
+	}
+	result, err := conn.Execute(ctx, "command -v rsync", types.ExecuteOptions{})
+	return err == nil && result != nil && result.Success
+}
+
+// rsyncArgs builds the rsync command line
+func (m *SynchronizeModule) rsyncArgs(remote *rsyncRemote, args map[string]interface{}, checkMode bool) []string {
+	opts := m.syncOptions(args)
+	argv := []string{"rsync", "--out-format=" + rsyncChangedMarker + "%i %n%L"}
+	if opts.archive {
+		argv = append(argv, "--archive")
+	}
+	if !opts.recursive {
+		argv = append(argv, "--no-recursive", "--dirs")
+	}
+	if opts.checksum {
+		argv = append(argv, "--checksum")
+	}
+	if m.GetBoolArg(args, "compress", true) && remote.address != "" {
+		argv = append(argv, "--compress")
+	}
+	if opts.delete {
+		argv = append(argv, "--delete-after")
+	}
+	if checkMode {
+		argv = append(argv, "--dry-run")
+	}
+	for _, pattern := range stringList(args["exclude"]) {
+		argv = append(argv, "--exclude="+pattern)
+	}
+
+	if remote.address != "" {
+		var shell []string
+		for _, word := range remote.shell {
+			shell = append(shell, m.cli.shellEscape(word))
+		}
+		argv = append(argv, "--rsh="+strings.Join(shell, " "))
+	}
+	rsyncPath := m.GetStringArg(args, "rsync_path", "")
+	if rsyncPath == "" && types.ConvertToBool(m.GetTaskVar(args, "ansible_become", "false")) {
+		rsyncPath = "sudo -n rsync"
+		if user := m.GetTaskVar(args, "ansible_become_user", ""); user != "" && user != "root" {
+			rsyncPath = "sudo -n -u " + user + " rsync"
+		}
+	}
+	if rsyncPath != "" && remote.address != "" {
+		argv = append(argv, "--rsync-path="+rsyncPath)
+	}
+	argv = append(argv, stringList(args["rsync_opts"])...)
+
+	src := m.GetStringArg(args, "src", "")
+	dest := m.GetStringArg(args, "dest", "")
+	if remote.address != "" {
+		if m.GetStringArg(args, "mode", "push") == "pull" {
+			src = remote.address + ":" + src
+		} else {
+			dest = remote.address + ":" + dest
+		}
+	}
+	return append(argv, src, dest)
+}
+
+// runRsync runs rsync on the controller
+func (m *SynchronizeModule) runRsync(ctx context.Context, host string, remote *rsyncRemote, args map[string]interface{}) (*types.Result, error) {
+	checkMode := m.CheckMode(args)
+	argv := m.rsyncArgs(remote, args, checkMode)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	var changes []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if change, ok := strings.CutPrefix(line, rsyncChangedMarker); ok {
+			changes = append(changes, change)
+		}
+	}
+	data := map[string]interface{}{
+		"engine":  "rsync",
+		"changes": changes,
+		"cmd":     strings.Join(argv, " "),
+		"stdout":  stdout.String(),
+		"stderr":  stderr.String(),
+	}
+	if err != nil {
+		return m.CreateFailureResult(host, "rsync failed", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String())), data), nil
+	}
+
+	if checkMode {
+		return m.CreateCheckModeResult(host, len(changes) > 0, "rsync dry run", data), nil
+	}
+	return m.CreateSuccessResult(host, len(changes) > 0, "Synchronized with rsync", data), nil
+}
@@ -0,0 +1,524 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// syncEntry is a file or directory of a synchronized tree
+type syncEntry struct {
+	dir      bool
+	size     int64
+	mtime    int64
+	mode     os.FileMode
+	checksum string
+}
+
+// syncTree is one side of a synchronization: the controller's file system
+// or the host's. Paths within a tree are relative to its root, slash
+// separated, with "." for the root itself.
+type syncTree interface {
+	// list returns the entries under root, or none when root does not
+	// exist. With checksum, files carry their SHA1 checksum.
+	list(ctx context.Context, root string, recursive, checksum bool) (map[string]syncEntry, error)
+	open(ctx context.Context, file string) (io.ReadCloser, error)
+	mkdirs(ctx context.Context, dirs map[string]os.FileMode) error
+	write(ctx context.Context, file string, content io.Reader, mode os.FileMode) error
+	// setAttrs sets the mode of paths and, for those given one, their
+	// modification time
+	setAttrs(ctx context.Context, modes map[string]os.FileMode, mtimes map[string]int64) error
+	remove(ctx context.Context, paths []string) error
+	join(root, rel string) string
+}
+
+// syncOptions are the settings of a synchronization
+type syncOptions struct {
+	recursive bool
+	checksum  bool
+	delete    bool
+	archive   bool
+	excludes  []string
+	// marker starts the itemized changes: '<' when sending to the host
+	// and '>' when receiving from it, as rsync prints them
+	marker byte
+}
+
+// syncPlan is what a synchronization does to the target, by relative path
+type syncPlan struct {
+	replace []string // entries of the wrong type, removed first
+	mkdirs  []string
+	copies  []string
+	attrs   []string // entries whose content is current but mode is not
+	deletes []string
+	changes []string // rsync-style itemized changes
+}
+
+// empty reports whether the target is already in sync
+func (p syncPlan) empty() bool {
+	return len(p.changes) == 0
+}
+
+// syncExcluded reports whether a path is excluded, following rsync: a
+// pattern with a slash matches the path from the root, one without matches
+// any path component, and a trailing slash only matches directories.
+// Excluding a directory excludes everything under it.
+func syncExcluded(rel string, dir bool, patterns []string) bool {
+	if rel == "." {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		isDir := dir || i < len(parts)-1
+		for _, pattern := range patterns {
+			dirOnly := strings.HasSuffix(pattern, "/")
+			pattern = strings.TrimSuffix(pattern, "/")
+			if pattern == "" || (dirOnly && !isDir) {
+				continue
+			}
+			subject := parts[i]
+			if strings.Contains(pattern, "/") {
+				subject = prefix
+				pattern = strings.TrimPrefix(pattern, "/")
+			}
+			if matched, _ := path.Match(pattern, subject); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// planSync compares the source and target entries
+func planSync(source, target map[string]syncEntry, opts syncOptions) syncPlan {
+	var plan syncPlan
+	item := func(flags, rel string, dir bool) {
+		name := rel
+		if dir {
+			name += "/"
+		}
+		plan.changes = append(plan.changes, flags+" "+name)
+	}
+	kind := func(dir bool) string {
+		if dir {
+			return "d"
+		}
+		return "f"
+	}
+
+	for _, rel := range sortedSyncPaths(source) {
+		entry := source[rel]
+		if syncExcluded(rel, entry.dir, opts.excludes) {
+			continue
+		}
+		current, exists := target[rel]
+		if exists && current.dir != entry.dir {
+			plan.replace = append(plan.replace, rel)
+			exists = false
+		}
+
+		switch {
+		case entry.dir && !exists:
+			plan.mkdirs = append(plan.mkdirs, rel)
+			item("cd+++++++++", rel, true)
+		case !entry.dir && !exists:
+			plan.copies = append(plan.copies, rel)
+			item(string(opts.marker)+"f+++++++++", rel, false)
+		case !entry.dir && syncContentDiffers(entry, current, opts):
+			plan.copies = append(plan.copies, rel)
+			flags := []byte(string(opts.marker) + "f.st......")
+			if entry.size == current.size {
+				flags[3] = '.'
+			}
+			if opts.checksum {
+				flags[2] = 'c'
+			}
+			item(string(flags), rel, false)
+		case opts.archive && entry.mode.Perm() != current.mode.Perm():
+			plan.attrs = append(plan.attrs, rel)
+			item("."+kind(entry.dir)+"...p.....", rel, entry.dir)
+		}
+	}
+
+	if !opts.delete {
+		return plan
+	}
+	var deleted []string
+	for _, rel := range sortedSyncPaths(target) {
+		entry := target[rel]
+		if _, kept := source[rel]; kept || rel == "." || syncExcluded(rel, entry.dir, opts.excludes) {
+			continue
+		}
+		if syncUnder(rel, deleted) {
+			continue
+		}
+		deleted = append(deleted, rel)
+	}
+	// Report deletions deepest first, as rsync does
+	for i := len(deleted) - 1; i >= 0; i-- {
+		plan.deletes = append(plan.deletes, deleted[i])
+		plan.changes = append(plan.changes, "*deleting   "+deleted[i])
+	}
+	return plan
+}
+
+// syncContentDiffers reports whether a file needs copying: its size
+// differs, or its checksum when comparing checksums and its modification
+// time otherwise
+func syncContentDiffers(source, target syncEntry, opts syncOptions) bool {
+	if source.size != target.size {
+		return true
+	}
+	if opts.checksum {
+		return source.checksum != target.checksum
+	}
+	return source.mtime != target.mtime
+}
+
+// syncUnder reports whether rel lies under one of dirs
+func syncUnder(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedSyncPaths returns the paths of entries, parents before children
+func sortedSyncPaths(entries map[string]syncEntry) []string {
+	paths := make([]string, 0, len(entries))
+	for rel := range entries {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// applySync carries out a plan, copying files from the source tree
+func applySync(ctx context.Context, plan syncPlan, source syncTree, sourceRoot string, sourceEntries map[string]syncEntry, target syncTree, targetRoot string, opts syncOptions) error {
+	if len(plan.replace) > 0 {
+		paths := make([]string, len(plan.replace))
+		for i, rel := range plan.replace {
+			paths[i] = target.join(targetRoot, rel)
+		}
+		if err := target.remove(ctx, paths); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.mkdirs) > 0 {
+		dirs := make(map[string]os.FileMode, len(plan.mkdirs))
+		for _, rel := range plan.mkdirs {
+			dirs[target.join(targetRoot, rel)] = syncMode(sourceEntries[rel], opts, 0755)
+		}
+		if err := target.mkdirs(ctx, dirs); err != nil {
+			return err
+		}
+	}
+
+	modes := make(map[string]os.FileMode)
+	mtimes := make(map[string]int64)
+	for _, rel := range plan.copies {
+		entry := sourceEntries[rel]
+		content, err := source.open(ctx, source.join(sourceRoot, rel))
+		if err != nil {
+			return err
+		}
+		dest := target.join(targetRoot, rel)
+		err = target.write(ctx, dest, content, syncMode(entry, opts, 0644))
+		content.Close()
+		if err != nil {
+			return err
+		}
+		if opts.archive {
+			modes[dest] = entry.mode.Perm()
+			mtimes[dest] = entry.mtime
+		}
+	}
+	for _, rel := range plan.attrs {
+		modes[target.join(targetRoot, rel)] = sourceEntries[rel].mode.Perm()
+	}
+	if len(modes) > 0 {
+		if err := target.setAttrs(ctx, modes, mtimes); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.deletes) > 0 {
+		paths := make([]string, len(plan.deletes))
+		for i, rel := range plan.deletes {
+			paths[i] = target.join(targetRoot, rel)
+		}
+		if err := target.remove(ctx, paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncMode returns the mode a new entry gets: the source's when archiving
+func syncMode(entry syncEntry, opts syncOptions, fallback os.FileMode) os.FileMode {
+	if opts.archive && entry.mode != 0 {
+		return entry.mode.Perm()
+	}
+	return fallback
+}
+
+// localSyncTree is the controller's file system
+type localSyncTree struct{}
+
+func (localSyncTree) join(root, rel string) string {
+	if rel == "." {
+		return root
+	}
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
+
+func (localSyncTree) list(ctx context.Context, root string, recursive, checksum bool) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	add := func(rel, file string, info fs.FileInfo) error {
+		entry := syncEntry{dir: info.IsDir(), size: info.Size(), mtime: info.ModTime().Unix(), mode: info.Mode().Perm()}
+		if entry.dir {
+			entry.size = 0
+		} else if checksum {
+			if entry.checksum, err = fileChecksum(file); err != nil {
+				return err
+			}
+		}
+		entries[rel] = entry
+		return nil
+	}
+	if !info.IsDir() {
+		return entries, add(".", root, info)
+	}
+
+	err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !recursive && strings.Contains(rel, "/") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Symlinks are followed to files; linked directories are skipped
+		info, err := os.Stat(file)
+		if err != nil || (d.Type()&fs.ModeSymlink != 0 && info.IsDir()) {
+			return nil
+		}
+		return add(rel, file, info)
+	})
+	return entries, err
+}
+
+func (localSyncTree) open(ctx context.Context, file string) (io.ReadCloser, error) {
+	return os.Open(file)
+}
+
+func (localSyncTree) mkdirs(ctx context.Context, dirs map[string]os.FileMode) error {
+	for _, dir := range sortedModePaths(dirs) {
+		if err := os.MkdirAll(dir, dirs[dir]); err != nil {
+			return err
+		}
+		if err := os.Chmod(dir, dirs[dir]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (localSyncTree) write(ctx context.Context, file string, content io.Reader, mode os.FileMode) error {
+	return writeFileAtomic(file, content, mode)
+}
+
+func (localSyncTree) setAttrs(ctx context.Context, modes map[string]os.FileMode, mtimes map[string]int64) error {
+	for file, mode := range modes {
+		if err := os.Chmod(file, mode); err != nil {
+			return err
+		}
+	}
+	for file, mtime := range mtimes {
+		when := time.Unix(mtime, 0)
+		if err := os.Chtimes(file, when, when); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (localSyncTree) remove(ctx context.Context, paths []string) error {
+	for _, file := range paths {
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteSyncTree is the host's file system, reached through a connection
+type remoteSyncTree struct {
+	conn types.Connection
+	cli  remoteCLI
+}
+
+func (t remoteSyncTree) join(root, rel string) string {
+	if rel == "." {
+		return root
+	}
+	return path.Join(root, rel)
+}
+
+// list describes every entry in a single round trip, with stat following
+// symlinks, and adds the checksums of files on lines starting with #
+func (t remoteSyncTree) list(ctx context.Context, root string, recursive, checksum bool) (map[string]syncEntry, error) {
+	const format = `'%F|%s|%Y|%a|%n'`
+	depth := ""
+	if !recursive {
+		depth = " -maxdepth 1"
+	}
+	script := fmt.Sprintf(`r=%s; if [ -d "$r" ]; then cd "$r" || exit 1; stat -L -c '%%F|%%s|%%Y|%%a|.' .; find .%s -mindepth 1 -exec stat -L -c %s {} + 2>/dev/null`,
+		t.cli.shellEscape(root), depth, format)
+	if checksum {
+		script += fmt.Sprintf(`; find -L .%s -type f -exec sha1sum {} + 2>/dev/null | sed 's/^/#/'`, depth)
+	}
+	script += `; elif [ -e "$r" ]; then stat -L -c '%F|%s|%Y|%a|.' "$r"`
+	if checksum {
+		script += `; printf '#%s  .\n' "$(sha1sum < "$r" | cut -d' ' -f1)"`
+	}
+	script += "; fi; true"
+
+	result, err := t.cli.run(ctx, t.conn, "listing "+root, script)
+	if err != nil {
+		return nil, err
+	}
+	return parseSyncListing(types.ConvertToString(result.Data["stdout"]))
+}
+
+// parseSyncListing parses the output of a remote listing
+func parseSyncListing(output string) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	sums := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if sum, ok := strings.CutPrefix(line, "#"); ok {
+			hash, name, found := strings.Cut(sum, "  ")
+			if found {
+				sums[syncRel(name)] = hash
+			}
+			continue
+		}
+
+		fields := strings.SplitN(line, "|", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected listing line %q", line)
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		mtime, _ := strconv.ParseInt(fields[2], 10, 64)
+		mode, _ := strconv.ParseUint(fields[3], 8, 32)
+		entry := syncEntry{dir: fields[0] == "directory", size: size, mtime: mtime, mode: os.FileMode(mode).Perm()}
+		if entry.dir {
+			entry.size = 0
+		}
+		entries[syncRel(fields[4])] = entry
+	}
+	for rel, sum := range sums {
+		if entry, ok := entries[rel]; ok && !entry.dir {
+			entry.checksum = sum
+			entries[rel] = entry
+		}
+	}
+	return entries, nil
+}
+
+// syncRel turns a path printed by find into a relative path
+func syncRel(name string) string {
+	if name == "." {
+		return name
+	}
+	return strings.TrimPrefix(name, "./")
+}
+
+func (t remoteSyncTree) open(ctx context.Context, file string) (io.ReadCloser, error) {
+	reader, err := t.conn.Fetch(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", file, err)
+	}
+	if closer, ok := reader.(io.ReadCloser); ok {
+		return closer, nil
+	}
+	return io.NopCloser(reader), nil
+}
+
+func (t remoteSyncTree) mkdirs(ctx context.Context, dirs map[string]os.FileMode) error {
+	steps := make([]string, 0, len(dirs))
+	for _, dir := range sortedModePaths(dirs) {
+		steps = append(steps, fmt.Sprintf("mkdir -p %s && chmod %04o %s", t.cli.shellEscape(dir), dirs[dir], t.cli.shellEscape(dir)))
+	}
+	_, err := t.cli.run(ctx, t.conn, "creating directories", strings.Join(steps, " && "))
+	return err
+}
+
+func (t remoteSyncTree) write(ctx context.Context, file string, content io.Reader, mode os.FileMode) error {
+	if err := t.conn.Copy(ctx, content, file, int(mode.Perm())); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", file, err)
+	}
+	return nil
+}
+
+func (t remoteSyncTree) setAttrs(ctx context.Context, modes map[string]os.FileMode, mtimes map[string]int64) error {
+	var steps []string
+	for _, file := range sortedModePaths(modes) {
+		steps = append(steps, fmt.Sprintf("chmod %04o %s", modes[file], t.cli.shellEscape(file)))
+		if mtime, ok := mtimes[file]; ok {
+			steps = append(steps, fmt.Sprintf("touch -c -m -d @%d %s", mtime, t.cli.shellEscape(file)))
+		}
+	}
+	_, err := t.cli.run(ctx, t.conn, "setting attributes", strings.Join(steps, " && "))
+	return err
+}
+
+func (t remoteSyncTree) remove(ctx context.Context, paths []string) error {
+	quoted := make([]string, len(paths))
+	for i, file := range paths {
+		quoted[i] = t.cli.shellEscape(file)
+	}
+	_, err := t.cli.run(ctx, t.conn, "removing files", "rm -rf -- "+strings.Join(quoted, " "))
+	return err
+}
+
+// sortedModePaths returns the paths of a mode map, parents first
+func sortedModePaths(modes map[string]os.FileMode) []string {
+	paths := make([]string, 0, len(modes))
+	for file := range modes {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,244 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSyncExcluded(t *testing.T) {
+	tests := []struct {
+		rel      string
+		dir      bool
+		patterns []string
+		expected bool
+	}{
+		{"app.tmp", false, []string{"*.tmp"}, true},
+		{"lib/cache/app.tmp", false, []string{"*.tmp"}, true},
+		{".git/config", false, []string{".git/"}, true},
+		{".git", false, []string{".git/"}, false},
+		{"docs/readme", false, []string{"/docs"}, true},
+		{"src/docs/readme", false, []string{"/docs"}, false},
+		{"src/docs/readme", false, []string{"src/docs"}, true},
+		{"main.go", false, []string{"*.tmp", ".git/"}, false},
+		{".", true, []string{"*"}, false},
+	}
+	for _, tt := range tests {
+		if excluded := syncExcluded(tt.rel, tt.dir, tt.patterns); excluded != tt.expected {
+			t.Errorf("syncExcluded(%q, %v) = %t, expected %t", tt.rel, tt.patterns, excluded, tt.expected)
+		}
+	}
+}
+
+func TestParseSyncListing(t *testing.T) {
+	output := "directory|4096|1700000000|755|.\n" +
+		"regular file|6|1700000001|644|./a|b.txt\n" +
+		"regular empty file|0|1700000002|600|./empty\n" +
+		"#2aae6c35c94fcfb415dbe95f408b9ce91ee846ed  ./a|b.txt\n"
+	entries, err := parseSyncListing(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]syncEntry{
+		".":       {dir: true, mtime: 1700000000, mode: 0755},
+		"a|b.txt": {size: 6, mtime: 1700000001, mode: 0644, checksum: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"},
+		"empty":   {mtime: 1700000002, mode: 0600},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+}
+
+func TestSynchronizeNative(t *testing.T) {
+	src := t.TempDir()
+	write := func(rel, content string) {
+		file := filepath.Join(src, rel)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.html", "<h1>hi</h1>\n")
+	write("css/site.css", "body {}\n")
+	write("build.tmp", "scratch\n")
+
+	ctx := context.Background()
+	conn := connection.NewLocalConnection()
+	if err := conn.Connect(ctx, types.ConnectionInfo{Type: "local", Host: "localhost"}); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	m := NewSynchronizeModule()
+	dest := filepath.Join(t.TempDir(), "site")
+	args := map[string]interface{}{"src": src + "/", "dest": dest, "engine": "native", "exclude": []interface{}{"*.tmp"}}
+	run := func(args map[string]interface{}) *types.Result {
+		t.Helper()
+		result, err := m.Run(ctx, conn, args)
+		if err != nil || !result.Success {
+			t.Fatalf("synchronize failed: %+v (%v)", result, err)
+		}
+		return result
+	}
+
+	t.Run("CheckMode", func(t *testing.T) {
+		checkArgs := map[string]interface{}{"_check_mode": true}
+		for k, v := range args {
+			checkArgs[k] = v
+		}
+		if result := run(checkArgs); !result.Changed {
+			t.Error("expected check mode to report the pending changes")
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Error("expected check mode not to create dest")
+		}
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		result := run(args)
+		expected := []string{"cd+++++++++ ./", "cd+++++++++ css/", "<f+++++++++ css/site.css", "<f+++++++++ index.html"}
+		if !result.Changed || !reflect.DeepEqual(result.Data["changes"], expected) {
+			t.Errorf("expected changes %v, got %v", expected, result.Data["changes"])
+		}
+		if _, err := os.Stat(filepath.Join(dest, "build.tmp")); !os.IsNotExist(err) {
+			t.Error("expected the excluded file not to be copied")
+		}
+		info, err := os.Stat(filepath.Join(dest, "css", "site.css"))
+		if err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("expected the file's mode to be preserved, got %v (%v)", info, err)
+		}
+
+		if result := run(args); result.Changed {
+			t.Errorf("expected a second run to change nothing, got %v", result.Data["changes"])
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		write("index.html", "<h1>hello</h1>\n")
+		later := time.Now().Add(time.Hour)
+		os.Chtimes(filepath.Join(src, "index.html"), later, later)
+		for _, rel := range []string{"stale.html", "keep.tmp"} {
+			os.WriteFile(filepath.Join(dest, rel), nil, 0644)
+		}
+
+		deleteArgs := map[string]interface{}{"delete": true}
+		for k, v := range args {
+			deleteArgs[k] = v
+		}
+		result := run(deleteArgs)
+		expected := []string{"<f.st...... index.html", "*deleting   stale.html"}
+		if !reflect.DeepEqual(result.Data["changes"], expected) {
+			t.Errorf("expected changes %v, got %v", expected, result.Data["changes"])
+		}
+		if content, _ := os.ReadFile(filepath.Join(dest, "index.html")); string(content) != "<h1>hello</h1>\n" {
+			t.Errorf("expected the updated content, got %q", content)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "keep.tmp")); err != nil {
+			t.Error("expected excluded files not to be deleted")
+		}
+	})
+
+	t.Run("DirectoryName", func(t *testing.T) {
+		parent := t.TempDir()
+		run(map[string]interface{}{"src": src, "dest": parent, "engine": "native"})
+		if _, err := os.Stat(filepath.Join(parent, filepath.Base(src), "index.html")); err != nil {
+			t.Errorf("expected the directory to be synchronized under dest: %v", err)
+		}
+	})
+
+	t.Run("FileIntoDirectory", func(t *testing.T) {
+		parent := t.TempDir()
+		run(map[string]interface{}{"src": filepath.Join(src, "index.html"), "dest": parent, "engine": "native", "checksum": true})
+		if _, err := os.Stat(filepath.Join(parent, "index.html")); err != nil {
+			t.Errorf("expected the file to be synchronized into dest: %v", err)
+		}
+	})
+
+	t.Run("Pull", func(t *testing.T) {
+		local := filepath.Join(t.TempDir(), "pulled")
+		pullArgs := map[string]interface{}{"src": dest + "/", "dest": local, "mode": "pull", "engine": "native", "checksum": true}
+		result := run(pullArgs)
+		if changes, _ := result.Data["changes"].([]string); len(changes) == 0 || !strings.HasPrefix(changes[len(changes)-1], ">f+++++++++") {
+			t.Errorf("expected received files, got %v", result.Data["changes"])
+		}
+		if content, _ := os.ReadFile(filepath.Join(local, "css", "site.css")); string(content) != "body {}\n" {
+			t.Errorf("expected the pulled content, got %q", content)
+		}
+		if result := run(pullArgs); result.Changed {
+			t.Errorf("expected a second pull to change nothing, got %v", result.Data["changes"])
+		}
+	})
+
+	t.Run("MissingSource", func(t *testing.T) {
+		result, _ := m.Run(ctx, conn, map[string]interface{}{"src": filepath.Join(src, "missing"), "dest": dest, "engine": "native"})
+		if result.Success {
+			t.Error("expected a missing source to fail")
+		}
+	})
+}
+
+func TestSynchronizeRsync(t *testing.T) {
+	m := NewSynchronizeModule()
+	args := map[string]interface{}{
+		"src":        "build/",
+		"dest":       "/var/www",
+		"delete":     true,
+		"exclude":    []interface{}{".git/"},
+		"rsync_opts": []string{"--no-motd"},
+		"_task_vars": map[string]interface{}{
+			"ansible_host":                 "web1.example.com",
+			"ansible_port":                 2222,
+			"ansible_user":                 "deploy",
+			"ansible_ssh_private_key_file": "/keys/deploy",
+			"ansible_become":               true,
+		},
+	}
+
+	remote, err := m.rsyncRemote(args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"rsync", "--out-format=<<CHANGED>>%i %n%L", "--archive", "--compress", "--delete-after", "--dry-run", "--exclude=.git/",
+		"--rsh='ssh' '-o' 'BatchMode=yes' '-p' '2222' '-i' '/keys/deploy'", "--rsync-path=sudo -n rsync", "--no-motd",
+		"build/", "deploy@web1.example.com:/var/www",
+	}
+	if argv := m.rsyncArgs(remote, args, true); !reflect.DeepEqual(argv, expected) {
+		t.Errorf("expected %q, got %q", expected, argv)
+	}
+
+	// Hosts rsync cannot reach fall back to the native engine
+	for _, vars := range []map[string]interface{}{
+		{"ansible_host": "web1", "ansible_connection": "winrm"},
+		{"ansible_host": "web1", "ansible_password": "secret"},
+	} {
+		if _, err := m.rsyncRemote(map[string]interface{}{"_task_vars": vars}); err == nil {
+			t.Errorf("expected rsync not to reach a host with %v", vars)
+		}
+	}
+	local, err := m.rsyncRemote(map[string]interface{}{"_task_vars": map[string]interface{}{"ansible_host": "localhost"}})
+	if err != nil || local.address != "" {
+		t.Errorf("expected localhost to be synchronized locally, got %+v (%v)", local, err)
+	}
+
+	// Without rsync on the controller, auto uses the native engine
+	m.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a"), []byte("a"), 0644)
+	conn := connection.NewLocalConnection()
+	conn.Connect(context.Background(), types.ConnectionInfo{Type: "local", Host: "localhost"})
+	result, _ := m.Run(context.Background(), conn, map[string]interface{}{"src": src + "/", "dest": t.TempDir()})
+	if !result.Success || result.Data["engine"] != "native" {
+		t.Errorf("expected the native engine, got %+v", result)
+	}
+}
@@ -3,7 +3,6 @@ package modules
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -12,71 +11,140 @@ import (
 
 // SysctlModule manages kernel parameters via sysctl
 type SysctlModule struct {
-	BaseModule
+	*BaseModule
+	cli remoteCLI
 }
 
 // NewSysctlModule creates a new sysctl module instance
 func NewSysctlModule() *SysctlModule {
-	return &SysctlModule{
-		BaseModule: BaseModule{},
+	doc := types.ModuleDoc{
+		Name:        "sysctl",
+		Description: "Set kernel parameters at runtime and persist them in a sysctl.d file, comparing values the way the kernel reports them",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Kernel parameter, e.g. net.ipv4.ip_forward",
+				Required:    true,
+				Type:        "string",
+			},
+			"value": {
+				Description: "Desired value; numbers, booleans and whitespace separated lists compare equal to the kernel's rendering, so 1, \"1\" and true match",
+				Required:    false,
+				Type:        "raw",
+			},
+			"state": {
+				Description: "present sets the parameter, absent removes it from the file and leaves the running value alone",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"sysctl_file": {
+				Description: "File holding the persistent setting",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/sysctl.d/99-gosible.conf",
+			},
+			"persistent": {
+				Description: "Keep the setting in sysctl_file; false only changes the running value",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"sysctl_set": {
+				Description: "Set the running value with sysctl -w when it differs",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"reload": {
+				Description: "Load sysctl_file with sysctl -p after changing it",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"ignoreerrors": {
+				Description: "Ignore unknown keys when reading, setting and reloading",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Enable IPv4 forwarding\n  sysctl:\n    name: net.ipv4.ip_forward\n    value: 1",
+			"- name: Widen the ephemeral port range\n  sysctl:\n    name: net.ipv4.ip_local_port_range\n    value: \"10240 65000\"\n    sysctl_file: /etc/sysctl.d/60-network.conf",
+		},
+		Returns: map[string]string{
+			"name":          "Kernel parameter",
+			"value":         "Normalized desired value",
+			"current_value": "Running value before the change",
+			"sysctl_file":   "File holding the persistent setting",
+		},
 	}
-}
-
-// Name returns the module name
-func (m *SysctlModule) Name() string {
-	return "sysctl"
-}
 
-// Capabilities returns the module capabilities  
-func (m *SysctlModule) Capabilities() *types.ModuleCapability {
-	return &types.ModuleCapability{
+	base := NewBaseModule("sysctl", doc)
+	base.SetCapabilities(&types.ModuleCapability{
 		CheckMode:    true,
 		DiffMode:     true,
 		Platform:     "linux",
 		RequiresRoot: true,
-	}
+	})
+
+	return &SysctlModule{BaseModule: base}
 }
 
 // Validate validates the module arguments
 func (m *SysctlModule) Validate(args map[string]interface{}) error {
-	// Required parameters
+	if err := m.ValidateRequired(args, []string{"name"}); err != nil {
+		return err
+	}
 	name := m.GetStringArg(args, "name", "")
-	if name == "" {
-		return types.NewValidationError("name", nil, "name is required")
+	if strings.ContainsAny(name, " \t\n=") || strings.HasPrefix(name, "-") {
+		return types.NewValidationError("name", name, "invalid kernel parameter name")
 	}
-
-	// State validation
-	state := m.GetStringArg(args, "state", "present")
-	validStates := []string{"present", "absent"}
-	if !m.isValidChoice(state, validStates) {
-		return types.NewValidationError("state", state, fmt.Sprintf("state must be one of: %v", validStates))
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
 	}
-
-	// Value is required for present state
-	if state == "present" {
-		value := m.GetStringArg(args, "value", "")
-		if value == "" {
+	if m.GetStringArg(args, "state", "present") == "present" {
+		if value, ok := args["value"]; !ok || sysctlValue(value) == "" {
 			return types.NewValidationError("value", nil, "value is required when state is present")
 		}
 	}
-
-	// Validate sysctl_set
-	sysctlSet, ok := args["sysctl_set"]
-	if ok {
-		if _, ok := sysctlSet.(bool); !ok {
-			return types.NewValidationError("sysctl_set", sysctlSet, "sysctl_set must be a boolean")
+	for _, key := range []string{"persistent", "sysctl_set", "reload", "ignoreerrors"} {
+		if value, ok := args[key]; ok {
+			if _, isBool := value.(bool); !isBool {
+				return types.NewValidationError(key, value, key+" must be a boolean")
+			}
 		}
 	}
+	return nil
+}
 
-	// Validate reload
-	reload, ok := args["reload"]
-	if ok {
-		if _, ok := reload.(bool); !ok {
-			return types.NewValidationError("reload", reload, "reload must be a boolean")
+// sysctlValue renders a value the way sysctl prints it: booleans become 1
+// and 0 and list values are separated by single spaces, as the kernel
+// separates them with tabs
+func sysctlValue(value interface{}) string {
+	if b, ok := value.(bool); ok {
+		if b {
+			return "1"
 		}
+		return "0"
 	}
+	return strings.Join(strings.Fields(types.ConvertToString(value)), " ")
+}
 
-	return nil
+// sysctlSetting parses a "key = value" line of a sysctl.d file, returning
+// an empty key for comments and blank lines
+func sysctlSetting(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return "", ""
+	}
+	key, value, found := strings.Cut(line, "=")
+	if !found {
+		return "", ""
+	}
+	// A leading dash tells systemd-sysctl to ignore errors for the key
+	return strings.TrimPrefix(strings.TrimSpace(key), "-"), sysctlValue(value)
 }
 
 // Run executes the sysctl module
@@ -86,270 +154,112 @@ func (m *SysctlModule) Run(ctx context.Context, conn types.Connection, args map[
 	checkMode := m.CheckMode(args)
 	diffMode := m.DiffMode(args)
 
-	// Parse arguments
 	name := m.GetStringArg(args, "name", "")
-	value := m.GetStringArg(args, "value", "")
+	value := sysctlValue(args["value"])
 	state := m.GetStringArg(args, "state", "present")
-	sysctlFile := m.GetStringArg(args, "sysctl_file", "/etc/sysctl.conf")
-	sysctlSet := m.GetBoolArg(args, "sysctl_set", true)
-	reload := m.GetBoolArg(args, "reload", true)
-	ignoreFail := m.GetBoolArg(args, "ignoreerrors", false)
-
-	// Convert dots to slashes for /proc/sys path
-	procPath := "/proc/sys/" + strings.ReplaceAll(name, ".", "/")
-
-	// Get current value
-	currentValue, err := m.getCurrentValue(ctx, conn, name, procPath)
-	if err != nil && !ignoreFail {
-		return nil, fmt.Errorf("failed to get current value for %s: %w", name, err)
-	}
-
-	// Read current sysctl config file
-	currentConfig, err := m.readSysctlFile(ctx, conn, sysctlFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read sysctl file: %w", err)
+	file := m.GetStringArg(args, "sysctl_file", "/etc/sysctl.d/99-gosible.conf")
+	persistent := m.GetBoolArg(args, "persistent", true)
+	ignoreErrors := m.GetBoolArg(args, "ignoreerrors", false)
+	quotedFile := m.cli.shellEscape(file)
+
+	flags := ""
+	if ignoreErrors {
+		flags = "-e "
 	}
 
-	// Store original for diff mode
-	originalConfig := make(map[string]string)
-	for k, v := range currentConfig {
-		originalConfig[k] = v
-	}
-
-	// Initialize result
-	result := m.CreateSuccessResult(hostname, false, "", map[string]interface{}{
-		"name":          name,
-		"value":         value,
-		"current_value": currentValue,
-		"state":         state,
-	})
+	data := map[string]interface{}{"name": name, "value": value, "state": state}
+	var changes, steps []string
+	var before, after strings.Builder
 
-	changed := false
-	var changes []string
-
-	if state == "present" {
-		// Check if we need to update the running value
-		if currentValue != value && sysctlSet {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Set %s = %s (was %s)", name, value, currentValue))
-			
-			if !checkMode {
-				if err := m.setSysctlValue(ctx, conn, name, value, procPath); err != nil {
-					if !ignoreFail {
-						return nil, fmt.Errorf("failed to set sysctl value: %w", err)
-					}
-					if result.Data == nil {
-						result.Data = make(map[string]interface{})
-					}
-					result.Data["warning"] = fmt.Sprintf("Failed to set runtime value: %v", err)
-				}
-			}
+	if state == "present" && m.GetBoolArg(args, "sysctl_set", true) {
+		result, err := conn.Execute(ctx, "sysctl -n "+m.cli.shellEscape(name), types.ExecuteOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s failed: %w", name, err)
 		}
-
-		// Check if we need to update the config file
-		configValue, exists := currentConfig[name]
-		if !exists || configValue != value {
-			changed = true
-			if exists {
-				changes = append(changes, fmt.Sprintf("Updated %s in %s", name, sysctlFile))
-			} else {
-				changes = append(changes, fmt.Sprintf("Added %s to %s", name, sysctlFile))
-			}
-			
-			currentConfig[name] = value
-			
-			if !checkMode {
-				if err := m.writeSysctlFile(ctx, conn, sysctlFile, currentConfig); err != nil {
-					return nil, fmt.Errorf("failed to write sysctl file: %w", err)
-				}
+		switch {
+		case result.Success:
+			stdout, _ := result.Data["stdout"].(string)
+			current := sysctlValue(stdout)
+			data["current_value"] = current
+			fmt.Fprintf(&before, "runtime: %s = %s\n", name, current)
+			fmt.Fprintf(&after, "runtime: %s = %s\n", name, value)
+			if current != value {
+				steps = append(steps, "sysctl "+flags+"-w "+m.cli.shellEscape(name+"="+value))
+				changes = append(changes, fmt.Sprintf("set %s to %s (was %s)", name, value, current))
 			}
+		case !ignoreErrors:
+			return nil, fmt.Errorf("reading %s failed: %s", name, commandStderr(result))
 		}
+	}
 
-	} else { // state == "absent"
-		// Check if parameter exists in config
-		if _, exists := currentConfig[name]; exists {
-			changed = true
-			changes = append(changes, fmt.Sprintf("Removed %s from %s", name, sysctlFile))
-			
-			delete(currentConfig, name)
-			
-			if !checkMode {
-				if err := m.writeSysctlFile(ctx, conn, sysctlFile, currentConfig); err != nil {
-					return nil, fmt.Errorf("failed to write sysctl file: %w", err)
-				}
-			}
+	if persistent || state == "absent" {
+		data["sysctl_file"] = file
+		content, _, err := m.cli.inspect(ctx, conn, file, "[ -f "+quotedFile+" ]", "cat "+quotedFile)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// Reload sysctl if needed and requested
-	if changed && reload && !checkMode {
-		if err := m.reloadSysctl(ctx, conn); err != nil {
-			if !ignoreFail {
-				return nil, fmt.Errorf("failed to reload sysctl: %w", err)
-			}
-			if result.Data == nil {
-				result.Data = make(map[string]interface{})
+		// The first line of the key is updated in place, later duplicates
+		// would override it and are dropped
+		var lines, updated []string
+		if content != "" {
+			lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		}
+		existed, found := false, false
+		for _, line := range lines {
+			key, current := sysctlSetting(line)
+			existed = existed || key == name
+			switch {
+			case key != name:
+				updated = append(updated, line)
+			case state == "absent" || found:
+			case current == value:
+				updated = append(updated, line)
+				found = true
+			default:
+				updated = append(updated, name+" = "+value)
+				found = true
 			}
-			result.Data["warning"] = fmt.Sprintf("Failed to reload sysctl: %v", err)
 		}
-	}
-
-	result.Changed = changed
-	
-	if changed {
-		result.Message = strings.Join(changes, ", ")
-		
-		if diffMode {
-			// Generate diff for config file changes
-			originalContent := m.formatSysctlConfig(originalConfig)
-			newContent := m.formatSysctlConfig(currentConfig)
-			result.Diff = m.GenerateDiff(originalContent, newContent)
+		if state == "present" && !found {
+			updated = append(updated, name+" = "+value)
 		}
-	} else {
-		result.Message = "Sysctl parameter is already in desired state"
-	}
-
-	// Set timing information
-	result.StartTime = startTime
-	result.EndTime = time.Now()
-	result.Duration = result.EndTime.Sub(result.StartTime)
-
-	return result, nil
-}
-
-// Helper methods
 
-func (m *SysctlModule) getCurrentValue(ctx context.Context, conn types.Connection, name, procPath string) (string, error) {
-	// Try using sysctl command first
-	result, err := conn.Execute(ctx, fmt.Sprintf("sysctl -n %s", name), types.ExecuteOptions{})
-	if err == nil && result.Success {
-		if stdout, ok := result.Data["stdout"].(string); ok {
-			return strings.TrimSpace(stdout), nil
+		oldContent, newContent := strings.Join(lines, "\n"), strings.Join(updated, "\n")
+		if oldContent != "" {
+			oldContent += "\n"
 		}
-		return "", fmt.Errorf("no stdout in result")
-	}
-
-	// Fallback to reading from /proc/sys
-	result, err = conn.Execute(ctx, fmt.Sprintf("cat %s", procPath), types.ExecuteOptions{})
-	if err != nil {
-		return "", err
-	}
-	
-	if stdout, ok := result.Data["stdout"].(string); ok {
-		return strings.TrimSpace(stdout), nil
-	}
-	return "", fmt.Errorf("no stdout in result")
-}
-
-func (m *SysctlModule) setSysctlValue(ctx context.Context, conn types.Connection, name, value, procPath string) error {
-	// Try using sysctl command first
-	result, err := conn.Execute(ctx, fmt.Sprintf("sysctl -w %s=%s", name, value), types.ExecuteOptions{})
-	if err == nil && result.Success {
-		return nil
-	}
-
-	// Fallback to writing to /proc/sys
-	_, err = conn.Execute(ctx, fmt.Sprintf("echo '%s' > %s", value, procPath), types.ExecuteOptions{})
-	return err
-}
-
-func (m *SysctlModule) readSysctlFile(ctx context.Context, conn types.Connection, file string) (map[string]string, error) {
-	config := make(map[string]string)
-	
-	result, err := conn.Execute(ctx, fmt.Sprintf("cat %s 2>/dev/null || true", file), types.ExecuteOptions{})
-	if err != nil {
-		return config, err
-	}
-	
-	var lines []string
-	if stdout, ok := result.Data["stdout"].(string); ok {
-		lines = strings.Split(stdout, "\n")
-	}
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		if newContent != "" {
+			newContent += "\n"
 		}
-		
-		// Parse key = value
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			config[key] = value
+		fmt.Fprintf(&before, "%s:\n%s", file, oldContent)
+		fmt.Fprintf(&after, "%s:\n%s", file, newContent)
+
+		if oldContent != newContent {
+			switch {
+			case state == "absent":
+				changes = append(changes, fmt.Sprintf("removed %s from %s", name, file))
+			case !existed:
+				changes = append(changes, fmt.Sprintf("added %s to %s", name, file))
+			default:
+				changes = append(changes, fmt.Sprintf("updated %s in %s", name, file))
+			}
+			steps = append(steps, fmt.Sprintf(`mkdir -p %s && tmp=$(mktemp %s.XXXXXX) && printf '%%s' %s > "$tmp" && chmod 0644 "$tmp" && mv -f "$tmp" %s`,
+				m.cli.shellEscape(parentDir(file)), quotedFile, m.cli.shellEscape(newContent), quotedFile))
+			if state == "present" && m.GetBoolArg(args, "reload", true) {
+				steps = append(steps, "sysctl "+flags+"-p "+quotedFile)
+			}
 		}
 	}
-	
-	return config, nil
-}
-
-func (m *SysctlModule) writeSysctlFile(ctx context.Context, conn types.Connection, file string, config map[string]string) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(file)
-	if _, err := conn.Execute(ctx, fmt.Sprintf("mkdir -p %s", dir), types.ExecuteOptions{}); err != nil {
-		return err
-	}
-	
-	// Format config content
-	content := m.formatSysctlConfig(config)
-	
-	// Write to temp file first
-	tmpFile := fmt.Sprintf("%s.tmp.%d", file, time.Now().Unix())
-	cmd := fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", tmpFile, content)
-	if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-		return err
-	}
-	
-	// Move temp file to final location
-	if _, err := conn.Execute(ctx, fmt.Sprintf("mv %s %s", tmpFile, file), types.ExecuteOptions{}); err != nil {
-		// Clean up temp file on error
-		conn.Execute(ctx, fmt.Sprintf("rm -f %s", tmpFile), types.ExecuteOptions{})
-		return err
-	}
-	
-	return nil
-}
 
-func (m *SysctlModule) formatSysctlConfig(config map[string]string) string {
-	var lines []string
-	
-	// Add header comment
-	lines = append(lines, "# Sysctl configuration managed by gosible")
-	lines = append(lines, fmt.Sprintf("# Generated at %s", time.Now().Format(time.RFC3339)))
-	lines = append(lines, "")
-	
-	// Sort keys for consistent output
-	keys := make([]string, 0, len(config))
-	for k := range config {
-		keys = append(keys, k)
-	}
-	
-	// Format each parameter
-	for _, key := range keys {
-		lines = append(lines, fmt.Sprintf("%s = %s", key, config[key]))
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "sysctl", step); err != nil {
+				return nil, err
+			}
+		}
 	}
-	
-	return strings.Join(lines, "\n")
-}
 
-func (m *SysctlModule) reloadSysctl(ctx context.Context, conn types.Connection) error {
-	// Try sysctl --system first (newer systems)
-	result, err := conn.Execute(ctx, "sysctl --system", types.ExecuteOptions{})
-	if err == nil && result.Success {
-		return nil
-	}
-	
-	// Fallback to sysctl -p
-	_, err = conn.Execute(ctx, "sysctl -p", types.ExecuteOptions{})
-	return err
+	result := m.CreateSuccessResult(hostname, false, "Sysctl parameter is already in desired state", data)
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
 }
-
-func (m *SysctlModule) isValidChoice(value string, choices []string) bool {
-	for _, choice := range choices {
-		if value == choice {
-			return true
-		}
-	}
-	return false
-}
\ No newline at end of file
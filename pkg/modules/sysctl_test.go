@@ -2,6 +2,9 @@ package modules
 
 import (
 	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
 )
 
 func TestSysctlModule(t *testing.T) {
@@ -83,4 +86,110 @@ func TestSysctlModule(t *testing.T) {
 			})
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestSysctlValue(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{1, "1"},
+		{"1", "1"},
+		{true, "1"},
+		{false, "0"},
+		{"32768\t60999\n", "32768 60999"},
+		{" 4096  87380 6291456 ", "4096 87380 6291456"},
+	} {
+		if got := sysctlValue(tc.value); got != tc.want {
+			t.Errorf("sysctlValue(%#v) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestSysctlModuleRun(t *testing.T) {
+	module := NewSysctlModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	const file = "/etc/sysctl.d/99-gosible.conf"
+	readFile := `^if \[ -f '` + file + `' \]`
+	existing := existsMarker + "# managed\nvm.swappiness = 60\nnet.core.somaxconn=1024\n"
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "SetAndPersist",
+			Args:     map[string]interface{}{"name": "vm.swappiness", "value": 10},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("sysctl -n 'vm.swappiness'", &testhelper.CommandResponse{Stdout: "60\n"})
+				h.GetConnection().ExpectCommandPattern(readFile, &testhelper.CommandResponse{Stdout: existing})
+				h.GetConnection().ExpectCommand("sysctl -w 'vm.swappiness=10'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/sysctl.d' && tmp=\$\(mktemp '`+file+`'\.XXXXXX\) && printf '%s' '# managed\nvm.swappiness = 10\nnet.core.somaxconn=1024\n' > "\$tmp"`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("sysctl -p '"+file+"'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set vm.swappiness to 10 (was 60), updated vm.swappiness in "+file)
+				h.AssertDataValue(result, "current_value", "60")
+				h.AssertDiffBefore(result, "runtime: vm.swappiness = 60\n"+file+":\n# managed\nvm.swappiness = 60\nnet.core.somaxconn=1024\n")
+			},
+		},
+		{
+			Name: "NormalizedValuesConverged",
+			Args: map[string]interface{}{"name": "net.ipv4.ip_local_port_range", "value": "32768  60999"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("sysctl -n 'net.ipv4.ip_local_port_range'", &testhelper.CommandResponse{Stdout: "32768\t60999\n"})
+				h.GetConnection().ExpectCommandPattern(readFile, &testhelper.CommandResponse{Stdout: existsMarker + "net.ipv4.ip_local_port_range=32768 60999\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "BooleanAddsToNewFile",
+			Args: map[string]interface{}{"name": "net.ipv4.ip_forward", "value": true, "sysctl_file": "/etc/sysctl.d/60-router.conf", "reload": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("sysctl -n 'net.ipv4.ip_forward'", &testhelper.CommandResponse{Stdout: "1\n"})
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/sysctl.d/60-router.conf' \]`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`printf '%s' 'net.ipv4.ip_forward = 1\n' > "\$tmp"`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Added net.ipv4.ip_forward to /etc/sysctl.d/60-router.conf")
+				h.GetConnection().AssertPatternCalledTimes(`^sysctl -p`, 0)
+			},
+		},
+		{
+			Name:      "AbsentCheckMode",
+			Args:      map[string]interface{}{"name": "vm.swappiness", "state": "absent"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(readFile, &testhelper.CommandResponse{Stdout: existing})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have removed vm.swappiness from "+file)
+			},
+		},
+		{
+			Name: "RuntimeOnly",
+			Args: map[string]interface{}{"name": "kernel.panic", "value": "10", "persistent": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("sysctl -n 'kernel.panic'", &testhelper.CommandResponse{Stdout: "0\n"})
+				h.GetConnection().ExpectCommand("sysctl -w 'kernel.panic=10'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set kernel.panic to 10 (was 0)")
+			},
+		},
+		{
+			Name:        "UnknownKey",
+			Args:        map[string]interface{}{"name": "vm.bogus", "value": "1"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("sysctl -n 'vm.bogus'", &testhelper.CommandResponse{ExitCode: 255, Stderr: "sysctl: cannot stat /proc/sys/vm/bogus: No such file or directory"})
+			},
+		},
+	})
+}
@@ -0,0 +1,249 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// sysfsValue normalises a value read from or written to a kernel tunable.
+// Files offering a choice, such as a block device's scheduler or
+// transparent_hugepage/enabled, read as every option with the selected one
+// in brackets; files holding several numbers separate them with tabs.
+func sysfsValue(value string) string {
+	fields := strings.Fields(value)
+	for _, field := range fields {
+		if len(field) > 2 && strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			return field[1 : len(field)-1]
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// tmpfilesLine returns the systemd-tmpfiles line writing value to file at
+// boot. Specifiers and backslashes in the value are escaped.
+func tmpfilesLine(file, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "%", "%%")
+	return fmt.Sprintf("w %s - - - - %s", file, value)
+}
+
+// tmpfilesContent sets or, when line is empty, removes the "w" line of file
+// in a tmpfiles.d configuration, keeping every other line
+func tmpfilesContent(current, file, line string) string {
+	var lines []string
+	replaced := false
+	for _, existing := range strings.Split(strings.TrimSuffix(current, "\n"), "\n") {
+		fields := strings.Fields(existing)
+		if len(fields) >= 2 && (fields[0] == "w" || fields[0] == "w+") && fields[1] == file {
+			if line != "" && !replaced {
+				lines = append(lines, line)
+			}
+			replaced = true
+			continue
+		}
+		if existing != "" || len(lines) > 0 {
+			lines = append(lines, existing)
+		}
+	}
+	if line != "" && !replaced {
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// SysfsModule sets kernel tunables under /sys and /proc/sys, such as the
+// I/O scheduler and read-ahead of block devices, transparent huge pages and
+// vm settings, verifies the kernel accepted them and persists them with
+// systemd-tmpfiles
+type SysfsModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewSysfsModule creates a new sysfs module instance
+func NewSysfsModule() *SysfsModule {
+	doc := types.ModuleDoc{
+		Name:        "sysfs",
+		Description: "Set a kernel tunable under /sys or /proc/sys, verify the kernel reports the new value and persist it across reboots with a systemd-tmpfiles line",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Tunable file, e.g. /sys/block/sda/queue/scheduler or /proc/sys/vm/swappiness",
+				Required:    true,
+				Type:        "path",
+			},
+			"value": {
+				Description: "Value to write; required when state is present",
+				Required:    false,
+				Type:        "string",
+			},
+			"state": {
+				Description: "present sets and persists the value, absent only removes its persistence",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"persistent": {
+				Description: "Write the value at boot with systemd-tmpfiles",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"tmpfiles_file": {
+				Description: "tmpfiles.d configuration holding the persisted values",
+				Required:    false,
+				Type:        "path",
+				Default:     "/etc/tmpfiles.d/gosible-sysfs.conf",
+			},
+		},
+		Examples: []string{
+			"- name: Use mq-deadline for the database disks\n  sysfs:\n    path: /sys/block/nvme0n1/queue/scheduler\n    value: mq-deadline",
+			"- name: Disable transparent huge pages\n  sysfs:\n    path: /sys/kernel/mm/transparent_hugepage/enabled\n    value: never",
+			"- name: Keep page cache over swapping\n  sysfs:\n    path: /proc/sys/vm/vfs_cache_pressure\n    value: \"50\"",
+		},
+		Returns: map[string]string{
+			"path":     "Tunable file",
+			"value":    "Value the kernel reports",
+			"previous": "Value before the change",
+		},
+	}
+
+	base := NewBaseModule("sysfs", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &SysfsModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *SysfsModule) Validate(args map[string]interface{}) error {
+	file := m.GetStringArg(args, "path", "")
+	if file == "" {
+		return types.NewValidationError("path", nil, "required parameter")
+	}
+	if clean := path.Clean(file); clean != file || !(strings.HasPrefix(file, "/sys/") || strings.HasPrefix(file, "/proc/sys/")) || strings.ContainsAny(file, " \t\n") {
+		return types.NewValidationError("path", file, "path must be a clean path under /sys or /proc/sys")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	value := m.GetStringArg(args, "value", "")
+	if m.GetStringArg(args, "state", "present") == "present" && strings.TrimSpace(value) == "" {
+		return types.NewValidationError("value", nil, "value is required when state is present")
+	}
+	if strings.Contains(value, "\n") {
+		return types.NewValidationError("value", value, "value must be a single line")
+	}
+	return nil
+}
+
+// Run executes the sysfs module
+func (m *SysfsModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	file := m.GetStringArg(args, "path", "")
+	value := strings.TrimSpace(m.GetStringArg(args, "value", ""))
+	present := m.GetStringArg(args, "state", "present") == "present"
+	persistent := m.GetBoolArg(args, "persistent", true)
+	tmpfiles := m.GetStringArg(args, "tmpfiles_file", "/etc/tmpfiles.d/gosible-sysfs.conf")
+
+	quoted := m.cli.shellEscape(file)
+	current, err := m.read(ctx, conn, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes, steps []string
+	var before, after strings.Builder
+	fmt.Fprintf(&before, "%s = %s\n", file, current)
+	fmt.Fprintf(&after, "%s = %s\n", file, current)
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s is already in desired state", file), map[string]interface{}{
+		"path":     file,
+		"value":    current,
+		"previous": current,
+	})
+
+	if present && current != sysfsValue(value) {
+		after.Reset()
+		fmt.Fprintf(&after, "%s = %s\n", file, sysfsValue(value))
+		steps = append(steps, fmt.Sprintf("printf '%%s' %s > %s", m.cli.shellEscape(value), quoted))
+		changes = append(changes, fmt.Sprintf("set %s to %s", file, value))
+	}
+
+	if persistent || !present {
+		line := ""
+		if present {
+			line = tmpfilesLine(file, value)
+		}
+		quotedConf := m.cli.shellEscape(tmpfiles)
+		config, exists, err := m.cli.inspect(ctx, conn, tmpfiles, "[ -f "+quotedConf+" ]", "cat "+quotedConf)
+		if err != nil {
+			return nil, err
+		}
+		desired := tmpfilesContent(config, file, line)
+		fmt.Fprintf(&before, "%s:\n%s", tmpfiles, config)
+		fmt.Fprintf(&after, "%s:\n%s", tmpfiles, desired)
+
+		switch {
+		case desired == config:
+		case desired == "":
+			steps = append(steps, "rm -f "+quotedConf)
+			changes = append(changes, "removed "+tmpfiles)
+		default:
+			steps = append(steps, fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s",
+				m.cli.shellEscape(parentDir(tmpfiles)), m.cli.shellEscape(desired), quotedConf))
+			if exists {
+				changes = append(changes, "updated "+tmpfiles)
+			} else {
+				changes = append(changes, "created "+tmpfiles)
+			}
+		}
+	}
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "setting "+file, step); err != nil {
+				return nil, err
+			}
+		}
+		if present {
+			// The kernel may reject or round a value without failing the write
+			applied, err := m.read(ctx, conn, file)
+			if err != nil {
+				return nil, err
+			}
+			if applied != sysfsValue(value) {
+				return nil, fmt.Errorf("wrote %q to %s but it reads %q", value, file, applied)
+			}
+			result.Data["value"] = applied
+		}
+	}
+
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// read returns the normalised value of a tunable
+func (m *SysfsModule) read(ctx context.Context, conn types.Connection, file string) (string, error) {
+	result, err := m.cli.run(ctx, conn, "reading "+file, "cat "+m.cli.shellEscape(file))
+	if err != nil {
+		return "", err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return sysfsValue(stdout), nil
+}
@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSysfsValue(t *testing.T) {
+	tests := map[string]string{
+		"[mq-deadline] kyber bfq none\n": "mq-deadline",
+		"always madvise [never]\n":       "never",
+		"4096\t87380\t6291456\n":         "4096 87380 6291456",
+		"60\n":                           "60",
+	}
+	for input, expected := range tests {
+		if got := sysfsValue(input); got != expected {
+			t.Errorf("sysfsValue(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestTmpfilesContent(t *testing.T) {
+	current := "# tuning\nw /sys/kernel/mm/transparent_hugepage/enabled - - - - always\nw /proc/sys/vm/swappiness - - - - 60\n"
+	got := tmpfilesContent(current, "/sys/kernel/mm/transparent_hugepage/enabled", tmpfilesLine("/sys/kernel/mm/transparent_hugepage/enabled", "never"))
+	if expected := "# tuning\nw /sys/kernel/mm/transparent_hugepage/enabled - - - - never\nw /proc/sys/vm/swappiness - - - - 60\n"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if got := tmpfilesContent(current, "/proc/sys/vm/swappiness", ""); got != "# tuning\nw /sys/kernel/mm/transparent_hugepage/enabled - - - - always\n" {
+		t.Errorf("expected the line to be removed, got %q", got)
+	}
+	if got := tmpfilesContent("", "/proc/sys/vm/swappiness", "w /proc/sys/vm/swappiness - - - - 10"); got != "w /proc/sys/vm/swappiness - - - - 10\n" {
+		t.Errorf("expected a new file, got %q", got)
+	}
+	if got := tmpfilesLine("/sys/x", "50%"); got != "w /sys/x - - - - 50%%" {
+		t.Errorf("expected specifiers to be escaped, got %q", got)
+	}
+}
+
+func TestSysfsModule(t *testing.T) {
+	module := NewSysfsModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidSys", Args: map[string]interface{}{"path": "/sys/block/sda/queue/read_ahead_kb", "value": 4096}, ExpectValid: true},
+		{Name: "ValidAbsent", Args: map[string]interface{}{"path": "/proc/sys/vm/swappiness", "state": "absent"}, ExpectValid: true},
+		{Name: "OutsideSysfs", Args: map[string]interface{}{"path": "/etc/passwd", "value": "x"}, ExpectValid: false},
+		{Name: "Traversal", Args: map[string]interface{}{"path": "/sys/../etc/shadow", "value": "x"}, ExpectValid: false},
+		{Name: "MissingValue", Args: map[string]interface{}{"path": "/sys/kernel/mm/ksm/run"}, ExpectValid: false},
+	})
+
+	scheduler := "/sys/block/sda/queue/scheduler"
+	conf := "/etc/tmpfiles.d/gosible-sysfs.conf"
+	inspectConf := `^if \[ -f '` + conf + `' \]`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "SetAndPersist",
+			Args: map[string]interface{}{"path": scheduler, "value": "mq-deadline"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: "cat '" + scheduler + "'", Response: &testhelper.CommandResponse{Stdout: "mq-deadline kyber [bfq] none\n"}},
+					testhelper.ExpectedCall{Command: "cat '" + scheduler + "'", Response: &testhelper.CommandResponse{Stdout: "[mq-deadline] kyber bfq none\n"}},
+				)
+				h.GetConnection().ExpectCommandPattern(inspectConf, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("printf '%s' 'mq-deadline' > '"+scheduler+"'", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("mkdir -p '/etc/tmpfiles.d' && printf '%s' 'w "+scheduler+" - - - - mq-deadline\n' > '"+conf+"'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set "+scheduler+" to mq-deadline, created "+conf)
+				h.AssertDataValue(result, "previous", "bfq")
+				h.AssertDataValue(result, "value", "mq-deadline")
+			},
+		},
+		{
+			Name: "AlreadySet",
+			Args: map[string]interface{}{"path": scheduler, "value": "mq-deadline"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat '"+scheduler+"'", &testhelper.CommandResponse{Stdout: "[mq-deadline] none\n"})
+				h.GetConnection().ExpectCommandPattern(inspectConf, &testhelper.CommandResponse{Stdout: existsMarker + "w " + scheduler + " - - - - mq-deadline\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "KernelRejectsValue",
+			Args:        map[string]interface{}{"path": "/proc/sys/vm/swappiness", "value": "250", "persistent": false},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: "cat '/proc/sys/vm/swappiness'", Response: &testhelper.CommandResponse{Stdout: "60\n"}},
+					testhelper.ExpectedCall{Command: "printf '%s' '250' > '/proc/sys/vm/swappiness'", Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: "cat '/proc/sys/vm/swappiness'", Response: &testhelper.CommandResponse{Stdout: "200\n"}},
+				)
+			},
+		},
+		{
+			Name:     "AbsentRemovesPersistence",
+			Args:     map[string]interface{}{"path": "/proc/sys/vm/swappiness", "state": "absent"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("cat '/proc/sys/vm/swappiness'", &testhelper.CommandResponse{Stdout: "10\n"})
+				h.GetConnection().ExpectCommandPattern(inspectConf, &testhelper.CommandResponse{Stdout: existsMarker + "w /proc/sys/vm/swappiness - - - - 10\n"})
+				h.GetConnection().ExpectCommand("rm -f '"+conf+"'", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Removed "+conf)
+				h.AssertDiffAfter(result, "/proc/sys/vm/swappiness = 10\n"+conf+":\n")
+			},
+		},
+	})
+}
@@ -267,6 +267,8 @@ func (m *SystemdModule) Run(ctx context.Context, conn types.Connection, args map
 		result.Message = fmt.Sprintf("Service %s is already in desired state", serviceName)
 	}
 
+	m.SetState(result, args, beforeState, *finalState)
+
 	// Set timing information
 	result.StartTime = startTime
 	result.EndTime = time.Now()
@@ -383,6 +383,7 @@ func testSystemdDiffMode(t *testing.T, helper *testhelper.ModuleTestHelper) {
 		{
 			Name:     "StartServiceDiffMode",
 			DiffMode: true,
+			Snapshot: "testdata/systemd_start_diff.golden.json",
 			Args: map[string]interface{}{
 				"name":  "nginx",
 				"state": "started",
@@ -415,6 +416,7 @@ func testSystemdDiffMode(t *testing.T, helper *testhelper.ModuleTestHelper) {
 		{
 			Name:     "EnableServiceDiffMode",
 			DiffMode: true,
+			Snapshot: "testdata/systemd_enable_diff.golden.json",
 			Args: map[string]interface{}{
 				"name":    "nginx",
 				"enabled": true,
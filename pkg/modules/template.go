@@ -8,6 +8,7 @@ import (
 	"strings"
 	"text/template"
 	
+	tmplengine "github.com/liliang-cn/gosible/pkg/template"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -27,6 +28,12 @@ func NewTemplateModule() *TemplateModule {
 
 // Run executes the template module
 func (m *TemplateModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *TemplateModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	src, _ := args["src"].(string)
 	dest, _ := args["dest"].(string)
@@ -35,6 +42,7 @@ func (m *TemplateModule) Run(ctx context.Context, conn types.Connection, args ma
 	owner, _ := args["owner"].(string)
 	group, _ := args["group"].(string)
 	vars, _ := args["vars"].(map[string]interface{})
+	validate, _ := args["validate"].(string)
 	
 	result := &types.Result{
 		Success: true,
@@ -50,8 +58,13 @@ func (m *TemplateModule) Run(ctx context.Context, conn types.Connection, args ma
 		return result, nil
 	}
 	
-	// Render template
-	rendered, err := m.renderTemplate(templateContent, vars)
+	// Render template, as Jinja2 for .j2 sources
+	var rendered string
+	if strings.HasSuffix(src, ".j2") {
+		rendered, err = m.renderJinja2(templateContent, vars)
+	} else {
+		rendered, err = m.renderTemplate(templateContent, vars)
+	}
 	if err != nil {
 		result.Success = false
 		result.Error = fmt.Errorf("failed to render template: %v", err)
@@ -67,30 +80,23 @@ func (m *TemplateModule) Run(ctx context.Context, conn types.Connection, args ma
 		return result, nil
 	}
 	
-	// Backup existing file if requested
-	if backup && destExists {
-		backupPath := fmt.Sprintf("%s.backup", dest)
-		backupCmd := fmt.Sprintf("cp %s %s", dest, backupPath)
-		if _, err := conn.Execute(ctx, backupCmd, types.ExecuteOptions{}); err != nil {
-			result.Success = false
-			result.Error = fmt.Errorf("failed to backup file: %v", err)
-			return result, nil
-		}
-		result.Data["backup_file"] = backupPath
-	}
-	
-	// Copy rendered content to destination
+	// Copy rendered content to destination through a validated temporary
+	// file renamed over it, backing up the file it replaces if requested
 	reader := strings.NewReader(rendered)
 	modeInt := 0644
 	if mode != "" {
 		fmt.Sscanf(mode, "%o", &modeInt)
 	}
 	
-	if err := conn.Copy(ctx, reader, dest, modeInt); err != nil {
+	backupPath, err := remoteCLI{}.installFile(ctx, conn, reader, dest, fileInstall{mode: modeInt, validate: validate, backup: backup})
+	if err != nil {
 		result.Success = false
 		result.Error = fmt.Errorf("failed to copy rendered template: %v", err)
 		return result, nil
 	}
+	if backupPath != "" {
+		result.Data["backup_file"] = backupPath
+	}
 	
 	// Set ownership if specified
 	if owner != "" || group != "" {
@@ -160,6 +166,13 @@ func (m *TemplateModule) renderTemplate(templateContent string, vars map[string]
 	return buf.String(), nil
 }
 
+// renderJinja2 renders a Jinja2 template with variables
+func (m *TemplateModule) renderJinja2(templateContent string, vars map[string]interface{}) (string, error) {
+	engine := tmplengine.NewEngine()
+	engine.SetSyntax(tmplengine.SyntaxJinja2)
+	return engine.Render(templateContent, vars)
+}
+
 // convertJinja2ToGoTemplate converts common Jinja2 patterns to Go template syntax
 func (m *TemplateModule) convertJinja2ToGoTemplate(content string) string {
 	// This is a simplified conversion - a full implementation would need more sophisticated parsing
@@ -255,7 +268,8 @@ func (m *TemplateModule) Validate(args map[string]interface{}) error {
 		return types.NewValidationError("dest", dest, "required field is missing")
 	}
 	
-	return nil
+	validate, _ := args["validate"].(string)
+	return validateInstallCommand(validate)
 }
 
 // Documentation returns the module documentation
@@ -275,11 +289,16 @@ func (m *TemplateModule) Documentation() types.ModuleDoc {
 				Type:        "string",
 			},
 			"backup": {
-				Description: "Create a backup file if the destination already exists",
+				Description: "Keep a copy of the replaced file, named after it with a timestamp",
 				Required:    false,
 				Type:        "bool",
 				Default:     false,
 			},
+			"validate": {
+				Description: "Command checking the rendered file before it replaces dest, with %s standing for its path, e.g. \"nginx -t -c %s\"",
+				Required:    false,
+				Type:        "string",
+			},
 			"mode": {
 				Description: "Permissions of the destination file (octal)",
 				Required:    false,
@@ -303,7 +322,7 @@ func (m *TemplateModule) Documentation() types.ModuleDoc {
 			},
 		},
 		Examples: []string{
-			"- name: Template configuration file\n  template:\n    src: nginx.conf.j2\n    dest: /etc/nginx/nginx.conf\n    mode: '0644'\n    backup: true",
+			"- name: Template configuration file\n  template:\n    src: nginx.conf.j2\n    dest: /etc/nginx/nginx.conf\n    mode: '0644'\n    backup: true\n    validate: nginx -t -c %s",
 			"- name: Template with variables\n  template:\n    src: app.config.j2\n    dest: /opt/app/config.yml\n    vars:\n      port: 8080\n      debug: false",
 		},
 		Returns: map[string]string{
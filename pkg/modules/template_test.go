@@ -156,7 +156,15 @@ func TestTemplateModule_Run_NewFile(t *testing.T) {
 			return string(buf[:n]) == expectedContent
 		}
 		return false
-	}), "/tmp/test.conf", 0644).Return(nil)
+	}), mock.MatchedBy(func(tmp string) bool {
+		// The content goes to a temporary file next to dest first
+		return strings.HasPrefix(tmp, "/tmp/.test.conf.gosible.")
+	}), 0644).Return(nil)
+	
+	// Mock: Rename the temporary file over dest
+	mockConn.On("Execute", ctx, mock.MatchedBy(func(cmd string) bool {
+		return strings.HasSuffix(cmd, "'/tmp/test.conf'") && strings.Contains(cmd, "mv -f '/tmp/.test.conf.gosible.")
+	}), types.ExecuteOptions{}).Return(&types.Result{Success: true}, nil)
 	
 	result, err := module.Run(ctx, mockConn, args)
 	
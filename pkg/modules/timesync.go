@@ -0,0 +1,321 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// timesyncProvider describes where a time synchronisation daemon keeps its
+// configuration and how to query its synchronisation status
+type timesyncProvider struct {
+	name    string
+	config  string
+	service string
+}
+
+// status returns the command that reports the synchronisation status
+func (p timesyncProvider) status() string {
+	if p.name == "chrony" {
+		return "chronyc -n tracking 2>/dev/null"
+	}
+	return "ntpq -pn 2>/dev/null"
+}
+
+// wait returns the command that waits up to timeout seconds for the clock
+// to synchronise, failing when it does not
+func (p timesyncProvider) wait(timeout int) string {
+	if p.name == "chrony" {
+		return fmt.Sprintf("chronyc waitsync %d 0 0 1", timeout)
+	}
+	return fmt.Sprintf("i=0; until ntpq -pn 2>/dev/null | grep -q '^\\*'; do i=$((i+1)); [ $i -ge %d ] && exit 1; sleep 1; done", timeout)
+}
+
+// synchronized interprets the output of the status command. chronyc reports
+// a leap status of "Not synchronised" until a source is selected, and ntpq
+// marks the selected peer with an asterisk.
+func (p timesyncProvider) synchronized(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if p.name == "chrony" {
+			key, value, ok := strings.Cut(line, ":")
+			if ok && strings.TrimSpace(key) == "Leap status" {
+				return strings.TrimSpace(value) != "Not synchronised"
+			}
+		} else if strings.HasPrefix(line, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList converts a string or list argument to strings
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, types.ConvertToString(item))
+		}
+		return values
+	case []string:
+		return v
+	default:
+		return []string{types.ConvertToString(v)}
+	}
+}
+
+// timesyncConfig replaces the server and pool directives of a chrony or
+// ntpd configuration, keeping every other line. The new directives take the
+// place of the first old one, or are appended when there was none.
+func timesyncConfig(current string, directives []string) string {
+	var lines []string
+	inserted := false
+	for _, line := range strings.Split(strings.TrimSuffix(current, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == "server" || fields[0] == "pool") {
+			if !inserted {
+				lines = append(lines, directives...)
+				inserted = true
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !inserted {
+		if len(lines) == 1 && lines[0] == "" {
+			lines = nil
+		}
+		lines = append(lines, directives...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// TimesyncModule configures the NTP servers of chrony or ntpd and verifies
+// that the clock synchronises
+type TimesyncModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewTimesyncModule creates a new timesync module instance
+func NewTimesyncModule() *TimesyncModule {
+	doc := types.ModuleDoc{
+		Name:        "timesync",
+		Description: "Configure the servers and pools used by chrony or ntpd, restart the daemon when they change and optionally wait for the clock to synchronise",
+		Parameters: map[string]types.ParamDoc{
+			"servers": {
+				Description: "NTP servers",
+				Required:    false,
+				Type:        "list",
+			},
+			"pools": {
+				Description: "NTP pools; at least one server or pool is required",
+				Required:    false,
+				Type:        "list",
+			},
+			"provider": {
+				Description: "Time synchronisation daemon, auto prefers chrony when installed",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     []string{"auto", "chrony", "ntpd"},
+			},
+			"iburst": {
+				Description: "Add the iburst option to every server and pool for a faster initial sync",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"config_file": {
+				Description: "Configuration file, defaults to the distribution's location for the provider",
+				Required:    false,
+				Type:        "path",
+			},
+			"service": {
+				Description: "Service restarted after the configuration changes, defaults to the distribution's name for the provider",
+				Required:    false,
+				Type:        "string",
+			},
+			"wait_sync": {
+				Description: "Fail unless the clock synchronises within sync_timeout",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"sync_timeout": {
+				Description: "Seconds to wait for synchronisation",
+				Required:    false,
+				Type:        "int",
+				Default:     60,
+			},
+		},
+		Examples: []string{
+			"- name: Use the internal time servers\n  timesync:\n    servers:\n      - ntp1.example.com\n      - ntp2.example.com\n    wait_sync: true",
+			"- name: Use the public pool with ntpd\n  timesync:\n    provider: ntpd\n    pools: [pool.ntp.org]",
+		},
+		Returns: map[string]string{
+			"provider":     "Time synchronisation daemon that was configured",
+			"config_file":  "Configuration file",
+			"synchronized": "Whether the clock is synchronised",
+		},
+	}
+
+	base := NewBaseModule("timesync", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &TimesyncModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *TimesyncModule) Validate(args map[string]interface{}) error {
+	servers, pools := stringList(args["servers"]), stringList(args["pools"])
+	if len(servers) == 0 && len(pools) == 0 {
+		return types.NewValidationError("servers", nil, "at least one server or pool is required")
+	}
+	for _, server := range append(servers, pools...) {
+		if server == "" || strings.ContainsAny(server, " \t\n#") {
+			return types.NewValidationError("servers", server, "invalid NTP server")
+		}
+	}
+	if err := m.ValidateChoices(args, "provider", []string{"auto", "chrony", "ntpd"}); err != nil {
+		return err
+	}
+	if timeout, err := m.GetIntArg(args, "sync_timeout", 60); err != nil || timeout < 1 {
+		return types.NewValidationError("sync_timeout", args["sync_timeout"], "sync_timeout must be a positive number of seconds")
+	}
+	return nil
+}
+
+// Run executes the timesync module
+func (m *TimesyncModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	servers, pools := stringList(args["servers"]), stringList(args["pools"])
+	timeout, _ := m.GetIntArg(args, "sync_timeout", 60)
+
+	provider, err := m.provider(ctx, conn, args)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := ""
+	if m.GetBoolArg(args, "iburst", true) {
+		suffix = " iburst"
+	}
+	var directives []string
+	for _, server := range servers {
+		directives = append(directives, "server "+server+suffix)
+	}
+	for _, pool := range pools {
+		directives = append(directives, "pool "+pool+suffix)
+	}
+
+	quoted := m.cli.shellEscape(provider.config)
+	current, exists, err := m.cli.inspect(ctx, conn, provider.config, "[ -f "+quoted+" ]", "cat "+quoted)
+	if err != nil {
+		return nil, err
+	}
+	desired := timesyncConfig(current, directives)
+
+	change := ""
+	if !exists {
+		change = "created " + provider.config
+	} else if current != desired {
+		change = "updated " + provider.config
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Time synchronisation with %s is already in desired state", provider.name), map[string]interface{}{
+		"provider":    provider.name,
+		"config_file": provider.config,
+	})
+
+	if change != "" && !checkMode {
+		tmp := m.cli.shellEscape(provider.config + ".gosible.tmp")
+		cmd := fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s && mv -f %s %s",
+			m.cli.shellEscape(parentDir(provider.config)), m.cli.shellEscape(desired), tmp, tmp, quoted)
+		if _, err := m.cli.run(ctx, conn, "writing "+provider.config, cmd); err != nil {
+			return nil, err
+		}
+		if _, err := m.cli.run(ctx, conn, "restarting "+provider.service, "systemctl restart "+m.cli.shellEscape(provider.service)); err != nil {
+			return nil, err
+		}
+		change += ", restarted " + provider.service
+	}
+
+	// A daemon that is about to be reconfigured has not synchronised with
+	// the new servers yet, so only report the status outside check mode
+	if !checkMode || change == "" {
+		if m.GetBoolArg(args, "wait_sync", false) {
+			if _, err := m.cli.run(ctx, conn, "waiting for time synchronisation", provider.wait(timeout)); err != nil {
+				return nil, fmt.Errorf("clock did not synchronise with %s within %d seconds: %w", provider.name, timeout, err)
+			}
+			result.Data["synchronized"] = true
+		} else {
+			status, err := conn.Execute(ctx, provider.status(), types.ExecuteOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("reading %s status failed: %w", provider.name, err)
+			}
+			stdout, _ := status.Data["stdout"].(string)
+			result.Data["synchronized"] = status.Success && provider.synchronized(stdout)
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current, desired, startTime), nil
+}
+
+// provider resolves the daemon to configure, detecting the installed one and
+// the distribution's file and service names unless they are given
+func (m *TimesyncModule) provider(ctx context.Context, conn types.Connection, args map[string]interface{}) (timesyncProvider, error) {
+	cmd := "if command -v chronyd >/dev/null 2>&1; then echo provider=chrony; elif command -v ntpd >/dev/null 2>&1; then echo provider=ntpd; fi; if [ -f /etc/debian_version ]; then echo debian=true; fi"
+	result, err := m.cli.run(ctx, conn, "detecting time synchronisation daemon", cmd)
+	if err != nil {
+		return timesyncProvider{}, err
+	}
+
+	installed, debian := "", false
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "provider":
+			installed = value
+		case "debian":
+			debian = value == "true"
+		}
+	}
+
+	p := timesyncProvider{name: m.GetStringArg(args, "provider", "auto")}
+	if p.name == "auto" {
+		if installed == "" {
+			return p, fmt.Errorf("neither chrony nor ntpd is installed")
+		}
+		p.name = installed
+	}
+
+	switch {
+	case p.name == "chrony" && debian:
+		p.config, p.service = "/etc/chrony/chrony.conf", "chrony"
+	case p.name == "chrony":
+		p.config, p.service = "/etc/chrony.conf", "chronyd"
+	case debian:
+		p.config, p.service = "/etc/ntp.conf", "ntp"
+	default:
+		p.config, p.service = "/etc/ntp.conf", "ntpd"
+	}
+	p.config = m.GetStringArg(args, "config_file", p.config)
+	p.service = m.GetStringArg(args, "service", p.service)
+	return p, nil
+}
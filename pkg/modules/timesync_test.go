@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestTimesyncConfig(t *testing.T) {
+	current := "# Use public servers\npool 2.debian.pool.ntp.org iburst\nserver old.example.com\n\ndriftfile /var/lib/chrony/chrony.drift\n"
+	expected := "# Use public servers\nserver ntp1.example.com iburst\n\ndriftfile /var/lib/chrony/chrony.drift\n"
+	if got := timesyncConfig(current, []string{"server ntp1.example.com iburst"}); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if got := timesyncConfig("driftfile /var/lib/ntp/drift\n", []string{"pool pool.ntp.org"}); got != "driftfile /var/lib/ntp/drift\npool pool.ntp.org\n" {
+		t.Errorf("expected the pool to be appended, got %q", got)
+	}
+	if got := timesyncConfig("", []string{"pool pool.ntp.org"}); got != "pool pool.ntp.org\n" {
+		t.Errorf("expected a new file, got %q", got)
+	}
+}
+
+func TestTimesyncSynchronized(t *testing.T) {
+	chrony := timesyncProvider{name: "chrony"}
+	if !chrony.synchronized("Reference ID    : C0A80001 (ntp1)\nLeap status     : Normal\n") {
+		t.Error("expected chrony to be synchronised")
+	}
+	if chrony.synchronized("Reference ID    : 00000000 ()\nLeap status     : Not synchronised\n") {
+		t.Error("expected chrony not to be synchronised")
+	}
+
+	ntpd := timesyncProvider{name: "ntpd"}
+	if !ntpd.synchronized("     remote           refid      st t when poll reach   delay   offset  jitter\n==============================================================================\n*10.0.0.1        .GPS.            1 u   33   64  377    0.412    0.021   0.010\n") {
+		t.Error("expected ntpd to be synchronised")
+	}
+	if ntpd.synchronized(" 10.0.0.1        .INIT.          16 u    -   64    0    0.000    0.000   0.000\n") {
+		t.Error("expected ntpd not to be synchronised")
+	}
+}
+
+func TestTimesyncModule(t *testing.T) {
+	module := NewTimesyncModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidServers", Args: map[string]interface{}{"servers": []interface{}{"ntp1.example.com"}}, ExpectValid: true},
+		{Name: "ValidPool", Args: map[string]interface{}{"pools": "pool.ntp.org", "provider": "ntpd"}, ExpectValid: true},
+		{Name: "NoServers", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidServer", Args: map[string]interface{}{"servers": []interface{}{"ntp1 iburst"}}, ExpectValid: false},
+		{Name: "InvalidProvider", Args: map[string]interface{}{"servers": "ntp1", "provider": "timesyncd"}, ExpectValid: false},
+		{Name: "InvalidTimeout", Args: map[string]interface{}{"servers": "ntp1", "sync_timeout": 0}, ExpectValid: false},
+	})
+
+	chronyOnDebian := func(h *testhelper.ModuleTestHelper) {
+		h.GetConnection().ExpectCommandPattern(`^if command -v chronyd `, &testhelper.CommandResponse{Stdout: "provider=chrony\ndebian=true\n"})
+	}
+	config := func(path, content string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^if \[ -f '`+path+`' \]`, &testhelper.CommandResponse{Stdout: existsMarker + content})
+		}
+	}
+	tracking := func(leap string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^chronyc -n tracking`, &testhelper.CommandResponse{Stdout: "Leap status     : " + leap + "\n"})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "ConfigureChrony",
+			Args: map[string]interface{}{"servers": []interface{}{"ntp1.example.com"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				chronyOnDebian(h)
+				config("/etc/chrony/chrony.conf", "pool 2.debian.pool.ntp.org iburst\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/chrony' && printf '%s' 'server ntp1.example.com iburst\n' > '/etc/chrony/chrony.conf.gosible.tmp' && mv -f `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^systemctl restart 'chrony'$`, &testhelper.CommandResponse{})
+				tracking("Not synchronised")(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated /etc/chrony/chrony.conf, restarted chrony")
+				h.AssertDataValue(result, "synchronized", false)
+			},
+		},
+		{
+			Name: "AlreadyConfigured",
+			Args: map[string]interface{}{"servers": []interface{}{"ntp1.example.com"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				chronyOnDebian(h)
+				config("/etc/chrony/chrony.conf", "server ntp1.example.com iburst\n")(h)
+				tracking("Normal")(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "synchronized", true)
+			},
+		},
+		{
+			Name:      "NtpdInCheckMode",
+			Args:      map[string]interface{}{"pools": "pool.ntp.org", "provider": "ntpd", "iburst": false},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if command -v chronyd `, &testhelper.CommandResponse{})
+				config("/etc/ntp.conf", "driftfile /var/lib/ntp/drift\nserver 0.centos.pool.ntp.org\n")(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffAfter(result, "driftfile /var/lib/ntp/drift\npool pool.ntp.org\n")
+				h.AssertMessage(result, "Would have updated /etc/ntp.conf")
+			},
+		},
+		{
+			Name:        "WaitSyncTimesOut",
+			Args:        map[string]interface{}{"servers": "ntp1.example.com", "wait_sync": true, "sync_timeout": 30},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				chronyOnDebian(h)
+				config("/etc/chrony/chrony.conf", "server ntp1.example.com iburst\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^chronyc waitsync 30 0 0 1$`, &testhelper.CommandResponse{ExitCode: 1})
+			},
+		},
+		{
+			Name:        "NothingInstalled",
+			Args:        map[string]interface{}{"servers": "ntp1.example.com"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if command -v chronyd `, &testhelper.CommandResponse{})
+			},
+		},
+	})
+}
@@ -0,0 +1,174 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// zoneinfoDir holds the time zone database on Linux hosts
+const zoneinfoDir = "/usr/share/zoneinfo"
+
+// timezonePattern matches IANA time zone names such as Europe/Berlin, UTC
+// or America/Argentina/Buenos_Aires
+var timezonePattern = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// timezoneFactsCommand prints the configured time zone as timedatectl,
+// /etc/localtime, /etc/timezone and /etc/sysconfig/clock see it, the
+// hardware clock mode and whether the zone name exists, as key=value lines
+func timezoneFactsCommand(name string) string {
+	return `if command -v timedatectl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then echo systemd=yes; fi; ` +
+		`echo "localtime=$(readlink /etc/localtime 2>/dev/null)"; ` +
+		`echo "timezone=$(cat /etc/timezone 2>/dev/null)"; ` +
+		`echo "sysconfig=$(sed -n 's/^ZONE=//p' /etc/sysconfig/clock 2>/dev/null | tr -d '"')"; ` +
+		`echo "hwclock=$(sed -n 3p /etc/adjtime 2>/dev/null)"; ` +
+		fmt.Sprintf(`if [ -f %s ]; then echo zone=yes; fi`, remoteCLI{}.shellEscape(zoneinfoDir+"/"+name))
+}
+
+// currentTimezone returns the zone the host is set to, preferring the target
+// of the /etc/localtime link the C library reads
+func currentTimezone(facts map[string]string) string {
+	if _, zone, ok := strings.Cut(facts["localtime"], "zoneinfo/"); ok {
+		return zone
+	}
+	if facts["timezone"] != "" {
+		return facts["timezone"]
+	}
+	return facts["sysconfig"]
+}
+
+// TimezoneModule sets the time zone and hardware clock mode of a host
+type TimezoneModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewTimezoneModule creates a new timezone module instance
+func NewTimezoneModule() *TimezoneModule {
+	doc := types.ModuleDoc{
+		Name:        "timezone",
+		Description: "Set the time zone with timedatectl on systemd hosts or by linking /etc/localtime elsewhere, and whether the hardware clock keeps UTC or local time",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "IANA time zone name, e.g. Europe/Berlin",
+				Required:    false,
+				Type:        "string",
+			},
+			"hwclock": {
+				Description: "Whether the hardware clock keeps UTC or local time; at least one of name and hwclock is required",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"UTC", "local"},
+			},
+		},
+		Examples: []string{
+			"- name: Run the servers on UTC\n  timezone:\n    name: Etc/UTC\n    hwclock: UTC",
+			"- name: Use the office time zone\n  timezone:\n    name: Europe/Berlin",
+		},
+		Returns: map[string]string{
+			"name":    "The time zone",
+			"hwclock": "The hardware clock mode",
+		},
+	}
+
+	base := NewBaseModule("timezone", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &TimezoneModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *TimezoneModule) Validate(args map[string]interface{}) error {
+	name, hwclock := m.GetStringArg(args, "name", ""), m.GetStringArg(args, "hwclock", "")
+	if name == "" && hwclock == "" {
+		return types.NewValidationError("name", nil, "at least one of name and hwclock is required")
+	}
+	if name != "" && (!timezonePattern.MatchString(name) || strings.Contains("/"+name+"/", "/../")) {
+		return types.NewValidationError("name", name, "invalid time zone name")
+	}
+	return m.ValidateChoices(args, "hwclock", []string{"UTC", "local"})
+}
+
+// Run executes the timezone module
+func (m *TimezoneModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name, hwclock := m.GetStringArg(args, "name", ""), m.GetStringArg(args, "hwclock", "")
+	output, err := m.cli.run(ctx, conn, "reading the time zone", timezoneFactsCommand(name))
+	if err != nil {
+		return nil, err
+	}
+	facts := keyValueLines(types.ConvertToString(output.Data["stdout"]))
+	if name != "" && facts["zone"] != "yes" {
+		return nil, fmt.Errorf("unknown time zone %s: %s/%s does not exist", name, zoneinfoDir, name)
+	}
+	_, systemd := facts["systemd"]
+
+	// adjtime only names the mode once the clock was set, UTC otherwise
+	currentZone, currentClock := currentTimezone(facts), "UTC"
+	if facts["hwclock"] == "LOCAL" {
+		currentClock = "local"
+	}
+	wantZone, wantClock := currentZone, currentClock
+	if name != "" {
+		wantZone = name
+	}
+	if hwclock != "" {
+		wantClock = hwclock
+	}
+
+	var changes, cmds []string
+	quoted := m.cli.shellEscape(wantZone)
+	if wantZone != currentZone {
+		changes = append(changes, fmt.Sprintf("set the time zone to %s (was %s)", wantZone, currentZone))
+		if systemd {
+			cmds = append(cmds, "timedatectl set-timezone "+quoted)
+		} else {
+			cmds = append(cmds, fmt.Sprintf("ln -sf %s /etc/localtime", m.cli.shellEscape(zoneinfoDir+"/"+wantZone)))
+			if facts["timezone"] != "" {
+				cmds = append(cmds, fmt.Sprintf("echo %s > /etc/timezone", quoted))
+			}
+			if facts["sysconfig"] != "" {
+				cmds = append(cmds, fmt.Sprintf("sed -i 's|^ZONE=.*|ZONE=\"%s\"|' /etc/sysconfig/clock", wantZone))
+			}
+		}
+	}
+	if wantClock != currentClock {
+		changes = append(changes, "set the hardware clock to "+wantClock)
+		switch {
+		case systemd && wantClock == "local":
+			cmds = append(cmds, "timedatectl set-local-rtc 1")
+		case systemd:
+			cmds = append(cmds, "timedatectl set-local-rtc 0")
+		case wantClock == "local":
+			cmds = append(cmds, "hwclock --systohc --localtime")
+		default:
+			cmds = append(cmds, "hwclock --systohc --utc")
+		}
+	}
+
+	if len(cmds) > 0 && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "setting the time zone", strings.Join(cmds, " && ")); err != nil {
+			return nil, err
+		}
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("Time zone is already %s", wantZone), map[string]interface{}{
+		"name":    wantZone,
+		"hwclock": wantClock,
+	})
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode,
+		fmt.Sprintf("timezone: %s\nhwclock: %s\n", currentZone, currentClock), fmt.Sprintf("timezone: %s\nhwclock: %s\n", wantZone, wantClock), startTime), nil
+}
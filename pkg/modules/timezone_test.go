@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestCurrentTimezone(t *testing.T) {
+	tests := []struct {
+		facts    map[string]string
+		expected string
+	}{
+		{map[string]string{"localtime": "/usr/share/zoneinfo/Europe/Berlin", "timezone": "Etc/UTC"}, "Europe/Berlin"},
+		{map[string]string{"localtime": "../usr/share/zoneinfo/America/New_York"}, "America/New_York"},
+		{map[string]string{"timezone": "Asia/Tokyo"}, "Asia/Tokyo"},
+		{map[string]string{"sysconfig": "Europe/Paris"}, "Europe/Paris"},
+		{map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		if zone := currentTimezone(tt.facts); zone != tt.expected {
+			t.Errorf("currentTimezone(%v) = %q, expected %q", tt.facts, zone, tt.expected)
+		}
+	}
+}
+
+func TestTimezoneModule(t *testing.T) {
+	module := NewTimezoneModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "America/Argentina/Buenos_Aires"}, ExpectValid: true},
+		{Name: "HwclockOnly", Args: map[string]interface{}{"hwclock": "local"}, ExpectValid: true},
+		{Name: "Nothing", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "Traversal", Args: map[string]interface{}{"name": "../../etc/passwd"}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "Europe Berlin"}, ExpectValid: false},
+		{Name: "InvalidHwclock", Args: map[string]interface{}{"name": "UTC", "hwclock": "rtc"}, ExpectValid: false},
+	})
+
+	facts := func(h *testhelper.ModuleTestHelper, stdout string) {
+		h.GetConnection().ExpectCommandPattern(`^if command -v timedatectl `, &testhelper.CommandResponse{Stdout: stdout})
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Timedatectl",
+			Args:     map[string]interface{}{"name": "Europe/Berlin", "hwclock": "UTC"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "systemd=yes\nlocaltime=/usr/share/zoneinfo/Etc/UTC\ntimezone=Etc/UTC\nhwclock=LOCAL\nzone=yes\n")
+				h.GetConnection().ExpectCommand("timedatectl set-timezone 'Europe/Berlin' && timedatectl set-local-rtc 0", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set the time zone to Europe/Berlin (was Etc/UTC), set the hardware clock to UTC")
+				h.AssertDiffBefore(result, "timezone: Etc/UTC\nhwclock: local\n")
+				h.AssertDiffAfter(result, "timezone: Europe/Berlin\nhwclock: UTC\n")
+			},
+		},
+		{
+			Name: "LinkLocaltime",
+			Args: map[string]interface{}{"name": "Asia/Tokyo"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "localtime=/usr/share/zoneinfo/UTC\ntimezone=UTC\nhwclock=\nzone=yes\n")
+				h.GetConnection().ExpectCommand("ln -sf '/usr/share/zoneinfo/Asia/Tokyo' /etc/localtime && echo 'Asia/Tokyo' > /etc/timezone", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "hwclock", "UTC")
+			},
+		},
+		{
+			Name: "LocalHwclockWithoutSystemd",
+			Args: map[string]interface{}{"hwclock": "local"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "localtime=/usr/share/zoneinfo/UTC\nhwclock=UTC\n")
+				h.GetConnection().ExpectCommand("hwclock --systohc --localtime", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDataValue(result, "name", "UTC")
+			},
+		},
+		{
+			Name: "AlreadySet",
+			Args: map[string]interface{}{"name": "Europe/Berlin"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "systemd=yes\nlocaltime=../usr/share/zoneinfo/Europe/Berlin\nzone=yes\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"name": "Europe/Berlin"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "systemd=yes\nlocaltime=/usr/share/zoneinfo/UTC\nzone=yes\n")
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name:        "UnknownZone",
+			Args:        map[string]interface{}{"name": "Mars/Olympus_Mons"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				facts(h, "systemd=yes\nlocaltime=/usr/share/zoneinfo/UTC\n")
+			},
+		},
+	})
+}
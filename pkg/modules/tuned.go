@@ -0,0 +1,327 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// tunedProfilePattern matches tuned profile names
+var tunedProfilePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// tunedStatus is the state of the tuned daemon and its active profiles
+type tunedStatus struct {
+	running bool
+	active  string // Space separated profiles, empty when none is active
+}
+
+// parseTunedStatus reads the output of the status command: the daemon
+// state, then the output of tuned-adm active
+func parseTunedStatus(output string) tunedStatus {
+	var status tunedStatus
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "daemon="); ok {
+			status.running = value == "active"
+		} else if value, ok := strings.CutPrefix(line, "Current active profile:"); ok {
+			status.active = strings.Join(strings.Fields(value), " ")
+		}
+	}
+	return status
+}
+
+// tunedProfileContent renders a tuned.conf from an optional parent profile
+// and settings by section, sorting sections and keys for stable output
+func tunedProfileContent(include string, settings map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("# Managed by gosible\n")
+	if include != "" {
+		fmt.Fprintf(&b, "[main]\ninclude=%s\n", include)
+	}
+
+	sections := make([]string, 0, len(settings))
+	for section := range settings {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		values, _ := settings[section].(map[string]interface{})
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if b.Len() > len("# Managed by gosible\n") {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", section)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s=%s\n", key, types.ConvertToString(values[key]))
+		}
+	}
+	return b.String()
+}
+
+// TunedModule activates and verifies tuned profiles and deploys custom ones
+type TunedModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewTunedModule creates a new tuned module instance
+func NewTunedModule() *TunedModule {
+	doc := types.ModuleDoc{
+		Name:        "tuned",
+		Description: "Activate tuned profiles, deploy custom profiles to /etc/tuned and verify that the system settings match the active profile, reapplying it when they drifted",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Profile to activate, or a list of profiles tuned merges; required unless state is off",
+				Required:    false,
+				Type:        "raw",
+			},
+			"state": {
+				Description: "active activates the profiles, off deactivates tuned tuning",
+				Required:    false,
+				Type:        "string",
+				Default:     "active",
+				Choices:     []string{"active", "off"},
+			},
+			"content": {
+				Description: "tuned.conf of a custom profile deployed to /etc/tuned/<name>",
+				Required:    false,
+				Type:        "string",
+			},
+			"include": {
+				Description: "Parent profile of a custom profile built from settings",
+				Required:    false,
+				Type:        "string",
+			},
+			"settings": {
+				Description: "Settings of a custom profile by tuned plugin section, e.g. sysctl or vm",
+				Required:    false,
+				Type:        "dict",
+			},
+			"verify": {
+				Description: "Check with tuned-adm verify that the system matches the profile, failing when it does not after applying it",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			"- name: Tune database hosts for throughput\n  tuned:\n    name: throughput-performance",
+			"- name: Deploy and activate a custom profile\n  tuned:\n    name: postgres\n    include: throughput-performance\n    settings:\n      sysctl:\n        vm.swappiness: 1\n        vm.dirty_background_ratio: 5\n      vm:\n        transparent_hugepages: never",
+		},
+		Returns: map[string]string{
+			"profile":  "Profiles that should be active",
+			"previous": "Profiles that were active before",
+			"verified": "Whether the system settings match the active profile",
+		},
+	}
+
+	base := NewBaseModule("tuned", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &TunedModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *TunedModule) Validate(args map[string]interface{}) error {
+	if err := m.ValidateChoices(args, "state", []string{"active", "off"}); err != nil {
+		return err
+	}
+	profiles := m.profiles(args)
+	if m.GetStringArg(args, "state", "active") == "active" && len(profiles) == 0 {
+		return types.NewValidationError("name", nil, "a profile is required unless state is off")
+	}
+	for _, profile := range profiles {
+		if !tunedProfilePattern.MatchString(profile) {
+			return types.NewValidationError("name", profile, "invalid tuned profile name")
+		}
+	}
+
+	_, hasContent := args["content"]
+	custom := hasContent || args["include"] != nil || args["settings"] != nil
+	if custom && len(profiles) != 1 {
+		return types.NewValidationError("name", args["name"], "a custom profile needs exactly one name")
+	}
+	if hasContent && (args["include"] != nil || args["settings"] != nil) {
+		return types.NewValidationError("content", nil, "content is mutually exclusive with include and settings")
+	}
+	if include := m.GetStringArg(args, "include", ""); include != "" && !tunedProfilePattern.MatchString(include) {
+		return types.NewValidationError("include", include, "invalid tuned profile name")
+	}
+	for section, values := range m.GetMapArg(args, "settings") {
+		if _, ok := values.(map[string]interface{}); !ok || !tunedProfilePattern.MatchString(section) {
+			return types.NewValidationError("settings", section, "settings must map section names to settings")
+		}
+	}
+	return nil
+}
+
+// profiles returns the requested profile names
+func (m *TunedModule) profiles(args map[string]interface{}) []string {
+	var profiles []string
+	for _, name := range stringList(args["name"]) {
+		profiles = append(profiles, strings.Fields(name)...)
+	}
+	return profiles
+}
+
+// Run executes the tuned module
+func (m *TunedModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	state := m.GetStringArg(args, "state", "active")
+	profile := strings.Join(m.profiles(args), " ")
+	verify := m.GetBoolArg(args, "verify", true)
+
+	status, err := m.status(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes, steps []string
+	var before, after strings.Builder
+	fmt.Fprintf(&before, "active=%s\n", status.active)
+
+	result := m.CreateSuccessResult(hostname, false, "tuned is already in desired state", map[string]interface{}{
+		"profile":  profile,
+		"previous": status.active,
+	})
+
+	if state == "off" {
+		after.WriteString("active=\n")
+		if status.active != "" {
+			steps = append(steps, "tuned-adm off")
+			changes = append(changes, "turned tuned off")
+		}
+		if len(changes) > 0 && !checkMode {
+			if _, err := m.cli.run(ctx, conn, "turning tuned off", strings.Join(steps, " && ")); err != nil {
+				return nil, err
+			}
+		}
+		return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+	}
+	fmt.Fprintf(&after, "active=%s\n", profile)
+
+	profileChanged := false
+	if content, custom := m.customProfile(args); custom {
+		file := "/etc/tuned/" + profile + "/tuned.conf"
+		quoted := m.cli.shellEscape(file)
+		current, exists, err := m.cli.inspect(ctx, conn, file, "[ -f "+quoted+" ]", "cat "+quoted)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&before, "%s:\n%s", file, current)
+		fmt.Fprintf(&after, "%s:\n%s", file, content)
+		if current != content {
+			profileChanged = true
+			steps = append(steps, fmt.Sprintf("mkdir -p %s && printf '%%s' %s > %s",
+				m.cli.shellEscape(parentDir(file)), m.cli.shellEscape(content), quoted))
+			if exists {
+				changes = append(changes, "updated profile "+profile)
+			} else {
+				changes = append(changes, "deployed profile "+profile)
+			}
+		}
+	}
+
+	if !status.running {
+		steps = append(steps, "systemctl enable --now tuned")
+		changes = append(changes, "started tuned")
+	}
+
+	activate := "tuned-adm profile " + strings.Join(strings.Fields(profile), " ")
+	switch {
+	case status.active != profile:
+		steps = append(steps, activate)
+		changes = append(changes, "activated "+profile)
+	case profileChanged:
+		steps = append(steps, activate)
+		changes = append(changes, "reapplied "+profile)
+	case verify && status.running:
+		// The profile is active; settings changed behind tuned's back are
+		// restored by applying it again
+		if verified, err := m.verify(ctx, conn); err != nil {
+			return nil, err
+		} else if !verified {
+			steps = append(steps, activate)
+			changes = append(changes, "reapplied "+profile+" whose settings had drifted")
+		} else {
+			result.Data["verified"] = true
+		}
+	}
+
+	if len(changes) > 0 && !checkMode {
+		for _, step := range steps {
+			if _, err := m.cli.run(ctx, conn, "tuned", step); err != nil {
+				return nil, err
+			}
+		}
+		if verify {
+			verified, err := m.verify(ctx, conn)
+			if err != nil {
+				return nil, err
+			}
+			if !verified {
+				return nil, fmt.Errorf("activated tuned profile %s but the system settings do not match it, see tuned-adm verify and /var/log/tuned/tuned.log", profile)
+			}
+			result.Data["verified"] = true
+		}
+	}
+
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// customProfile returns the tuned.conf of the custom profile the arguments
+// describe, if any
+func (m *TunedModule) customProfile(args map[string]interface{}) (string, bool) {
+	if content, ok := args["content"]; ok {
+		content := types.ConvertToString(content)
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content, true
+	}
+	if args["include"] == nil && args["settings"] == nil {
+		return "", false
+	}
+	return tunedProfileContent(m.GetStringArg(args, "include", ""), m.GetMapArg(args, "settings")), true
+}
+
+// status reads whether the daemon runs and which profiles are active
+func (m *TunedModule) status(ctx context.Context, conn types.Connection) (tunedStatus, error) {
+	cmd := "command -v tuned-adm >/dev/null 2>&1 || { echo 'tuned-adm not found' >&2; exit 127; }; " +
+		"echo \"daemon=$(systemctl is-active tuned 2>/dev/null)\"; tuned-adm active 2>/dev/null; true"
+	result, err := m.cli.run(ctx, conn, "reading tuned status", cmd)
+	if err != nil {
+		return tunedStatus{}, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	return parseTunedStatus(stdout), nil
+}
+
+// verify runs tuned-adm verify, which exits non-zero when a setting of the
+// active profile does not match the system
+func (m *TunedModule) verify(ctx context.Context, conn types.Connection) (bool, error) {
+	result, err := conn.Execute(ctx, "tuned-adm verify", types.ExecuteOptions{})
+	if result == nil {
+		return false, fmt.Errorf("verifying tuned profile failed: %w", err)
+	}
+	return err == nil && result.Success, nil
+}
@@ -0,0 +1,121 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseTunedStatus(t *testing.T) {
+	status := parseTunedStatus("daemon=active\nCurrent active profile: virtual-guest  mssql\n")
+	if !status.running || status.active != "virtual-guest mssql" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	status = parseTunedStatus("daemon=inactive\nNo current active profile.\n")
+	if status.running || status.active != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestTunedProfileContent(t *testing.T) {
+	got := tunedProfileContent("throughput-performance", map[string]interface{}{
+		"vm":     map[string]interface{}{"transparent_hugepages": "never"},
+		"sysctl": map[string]interface{}{"vm.swappiness": 1, "vm.dirty_background_ratio": 5},
+	})
+	expected := "# Managed by gosible\n[main]\ninclude=throughput-performance\n\n[sysctl]\nvm.dirty_background_ratio=5\nvm.swappiness=1\n\n[vm]\ntransparent_hugepages=never\n"
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestTunedModule(t *testing.T) {
+	module := NewTunedModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidProfile", Args: map[string]interface{}{"name": "latency-performance"}, ExpectValid: true},
+		{Name: "ValidMerge", Args: map[string]interface{}{"name": []interface{}{"virtual-guest", "mssql"}}, ExpectValid: true},
+		{Name: "ValidOff", Args: map[string]interface{}{"state": "off"}, ExpectValid: true},
+		{Name: "ValidCustom", Args: map[string]interface{}{"name": "pg", "include": "balanced", "settings": map[string]interface{}{"sysctl": map[string]interface{}{"vm.swappiness": 1}}}, ExpectValid: true},
+		{Name: "MissingProfile", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidProfile", Args: map[string]interface{}{"name": "../etc"}, ExpectValid: false},
+		{Name: "CustomMerge", Args: map[string]interface{}{"name": "a b", "content": "[main]\n"}, ExpectValid: false},
+		{Name: "ContentAndSettings", Args: map[string]interface{}{"name": "pg", "content": "[main]\n", "include": "balanced"}, ExpectValid: false},
+		{Name: "FlatSettings", Args: map[string]interface{}{"name": "pg", "settings": map[string]interface{}{"vm.swappiness": 1}}, ExpectValid: false},
+	})
+
+	status := func(output string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^command -v tuned-adm `, &testhelper.CommandResponse{Stdout: output})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "Activate",
+			Args: map[string]interface{}{"name": "throughput-performance"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status("daemon=active\nCurrent active profile: balanced\n")(h)
+				h.GetConnection().ExpectCommand("tuned-adm profile throughput-performance", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("tuned-adm verify", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Activated throughput-performance")
+				h.AssertDataValue(result, "previous", "balanced")
+				h.AssertDataValue(result, "verified", true)
+			},
+		},
+		{
+			Name: "ReapplyDrifted",
+			Args: map[string]interface{}{"name": "throughput-performance"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status("daemon=active\nCurrent active profile: throughput-performance\n")(h)
+				h.GetConnection().ExpectInOrder(
+					testhelper.ExpectedCall{Command: "tuned-adm verify", Response: &testhelper.CommandResponse{ExitCode: 1, Stdout: "Verification failed"}},
+					testhelper.ExpectedCall{Command: "tuned-adm profile throughput-performance", Response: &testhelper.CommandResponse{}},
+					testhelper.ExpectedCall{Command: "tuned-adm verify", Response: &testhelper.CommandResponse{}},
+				)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Reapplied throughput-performance whose settings had drifted")
+			},
+		},
+		{
+			Name: "DeployCustomProfile",
+			Args: map[string]interface{}{"name": "postgres", "include": "throughput-performance", "settings": map[string]interface{}{"sysctl": map[string]interface{}{"vm.swappiness": 1}}, "verify": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status("daemon=inactive\nNo current active profile.\n")(h)
+				h.GetConnection().ExpectCommandPattern(`^if \[ -f '/etc/tuned/postgres/tuned.conf' \]`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^mkdir -p '/etc/tuned/postgres' && printf '%s' '# Managed by gosible\n\[main\]\ninclude=throughput-performance\n\n\[sysctl\]\nvm.swappiness=1\n' > `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("systemctl enable --now tuned", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("tuned-adm profile postgres", &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Deployed profile postgres, started tuned, activated postgres")
+			},
+		},
+		{
+			Name:        "VerificationFails",
+			Args:        map[string]interface{}{"name": "latency-performance"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				status("daemon=active\nCurrent active profile: balanced\n")(h)
+				h.GetConnection().ExpectCommand("tuned-adm profile latency-performance", &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand("tuned-adm verify", &testhelper.CommandResponse{ExitCode: 1})
+			},
+		},
+		{
+			Name:      "OffInCheckMode",
+			Args:      map[string]interface{}{"state": "off"},
+			CheckMode: true,
+			Setup:     status("daemon=active\nCurrent active profile: balanced\n"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, "Would have turned tuned off")
+			},
+		},
+	})
+}
@@ -0,0 +1,400 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ufwPolicies maps the iptables targets /etc/default/ufw stores to the
+// policy names ufw takes
+var ufwPolicies = map[string]string{"ACCEPT": "allow", "DROP": "deny", "REJECT": "reject"}
+
+// ufwDefaultKeys maps default policy directions to their /etc/default/ufw
+// keys
+var ufwDefaultKeys = map[string]string{
+	"incoming": "DEFAULT_INPUT_POLICY",
+	"outgoing": "DEFAULT_OUTPUT_POLICY",
+	"routed":   "DEFAULT_FORWARD_POLICY",
+}
+
+// UFWModule manages ufw rules, default policies, logging and whether the
+// firewall is enabled
+type UFWModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewUFWModule creates a new ufw module instance
+func NewUFWModule() *UFWModule {
+	doc := types.ModuleDoc{
+		Name:        "ufw",
+		Description: "Manage ufw rules, default policies and logging, and enable or disable the firewall. Rules are compared by ufw itself, so a rule that exists in any spelling is left alone",
+		Parameters: map[string]types.ParamDoc{
+			"state": {
+				Description: "enabled and disabled turn the firewall on and off, reloaded reloads the rules and reset restores the installation defaults",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"enabled", "disabled", "reloaded", "reset"},
+			},
+			"default": {
+				Description: "Default policy for the direction",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"allow", "deny", "reject"},
+			},
+			"direction": {
+				Description: "incoming, outgoing or routed for default; in or out for rule",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"in", "out", "incoming", "outgoing", "routed"},
+			},
+			"logging": {
+				Description: "Logging level; on is the same as low",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"on", "off", "low", "medium", "high", "full"},
+			},
+			"rule": {
+				Description: "Rule action",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"allow", "deny", "reject", "limit"},
+			},
+			"port": {
+				Description: "Destination port or range, e.g. 443 or 60000:61000",
+				Required:    false,
+				Type:        "string",
+			},
+			"proto": {
+				Description: "Protocol of the rule",
+				Required:    false,
+				Type:        "string",
+				Default:     "any",
+				Choices:     []string{"any", "tcp", "udp", "ipv6", "esp", "ah", "gre", "igmp"},
+			},
+			"from_ip": {
+				Description: "Source address or network",
+				Required:    false,
+				Type:        "string",
+				Default:     "any",
+			},
+			"from_port": {
+				Description: "Source port or range",
+				Required:    false,
+				Type:        "string",
+			},
+			"to_ip": {
+				Description: "Destination address or network",
+				Required:    false,
+				Type:        "string",
+				Default:     "any",
+			},
+			"interface": {
+				Description: "Interface the rule applies to; requires direction",
+				Required:    false,
+				Type:        "string",
+			},
+			"route": {
+				Description: "Apply the rule to routed traffic",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"log": {
+				Description: "Log new connections matching the rule",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"comment": {
+				Description: "Comment stored with the rule",
+				Required:    false,
+				Type:        "string",
+			},
+			"delete": {
+				Description: "Delete the rule instead of adding it",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Allow SSH before enabling\n  ufw:\n    rule: limit\n    port: 22\n    proto: tcp",
+			"- name: Deny incoming traffic by default\n  ufw:\n    default: deny\n    direction: incoming",
+			"- name: Allow the app network to reach PostgreSQL\n  ufw:\n    rule: allow\n    from_ip: 10.0.0.0/8\n    port: 5432\n    proto: tcp\n    comment: app servers",
+			"- name: Enable the firewall\n  ufw:\n    state: enabled",
+		},
+		Returns: map[string]string{
+			"rule":     "ufw arguments of the managed rule",
+			"status":   "Whether ufw is enabled after the run",
+			"commands": "ufw commands that changed something",
+		},
+	}
+
+	base := NewBaseModule("ufw", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &UFWModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *UFWModule) Validate(args map[string]interface{}) error {
+	for param, choices := range map[string][]string{
+		"state":     {"enabled", "disabled", "reloaded", "reset"},
+		"default":   {"allow", "deny", "reject"},
+		"direction": {"in", "out", "incoming", "outgoing", "routed"},
+		"logging":   {"on", "off", "low", "medium", "high", "full"},
+		"rule":      {"allow", "deny", "reject", "limit"},
+		"proto":     {"any", "tcp", "udp", "ipv6", "esp", "ah", "gre", "igmp"},
+	} {
+		if err := m.ValidateChoices(args, param, choices); err != nil {
+			return err
+		}
+	}
+
+	rule := m.GetStringArg(args, "rule", "")
+	if rule == "" {
+		for _, param := range []string{"port", "from_ip", "from_port", "to_ip", "interface", "comment", "delete", "route", "log"} {
+			if _, ok := args[param]; ok {
+				return types.NewValidationError(param, args[param], param+" requires rule")
+			}
+		}
+		if m.GetStringArg(args, "state", "") == "" && m.GetStringArg(args, "default", "") == "" && m.GetStringArg(args, "logging", "") == "" {
+			return types.NewValidationError("rule", nil, "one of state, default, logging or rule is required")
+		}
+	}
+
+	direction := m.GetStringArg(args, "direction", "")
+	if m.GetStringArg(args, "default", "") != "" && rule != "" {
+		return types.NewValidationError("default", args["default"], "default and rule cannot share a direction, use separate tasks")
+	}
+	if rule != "" {
+		if direction == "routed" {
+			return types.NewValidationError("direction", direction, "use route: true for routed rules")
+		}
+		if m.GetStringArg(args, "interface", "") != "" && direction == "" {
+			return types.NewValidationError("interface", args["interface"], "interface requires direction")
+		}
+		proto := m.GetStringArg(args, "proto", "any")
+		if (m.GetStringArg(args, "port", "") != "" || m.GetStringArg(args, "from_port", "") != "") && proto != "any" && proto != "tcp" && proto != "udp" {
+			return types.NewValidationError("proto", proto, "ports need proto tcp, udp or any")
+		}
+	} else if direction == "in" || direction == "out" {
+		return types.NewValidationError("direction", direction, "default policies take incoming, outgoing or routed")
+	}
+	return nil
+}
+
+// ufwRuleDirections maps the direction parameter to ufw rule keywords
+var ufwRuleDirections = map[string]string{"in": "in", "incoming": "in", "out": "out", "outgoing": "out"}
+
+// ruleArgs builds the extended ufw syntax for the rule, without the
+// delete keyword, both shell quoted and as plain text for messages
+func (m *UFWModule) ruleArgs(args map[string]interface{}) (string, string) {
+	var quoted, plain []string
+	add := func(keyword, value string) {
+		quoted = append(quoted, keyword)
+		plain = append(plain, keyword)
+		if value != "" {
+			quoted = append(quoted, m.cli.shellEscape(value))
+			plain = append(plain, value)
+		}
+	}
+
+	if m.GetBoolArg(args, "route", false) {
+		add("route", "")
+	}
+	add(m.GetStringArg(args, "rule", ""), "")
+	if direction := ufwRuleDirections[m.GetStringArg(args, "direction", "")]; direction != "" {
+		add(direction, "")
+		if iface := m.GetStringArg(args, "interface", ""); iface != "" {
+			add("on", iface)
+		}
+	}
+	if m.GetBoolArg(args, "log", false) {
+		add("log", "")
+	}
+	if proto := m.GetStringArg(args, "proto", "any"); proto != "any" {
+		add("proto", proto)
+	}
+	add("from", m.GetStringArg(args, "from_ip", "any"))
+	if port := m.GetStringArg(args, "from_port", ""); port != "" {
+		add("port", port)
+	}
+	add("to", m.GetStringArg(args, "to_ip", "any"))
+	if port := m.GetStringArg(args, "port", ""); port != "" {
+		add("port", port)
+	}
+	if comment := m.GetStringArg(args, "comment", ""); comment != "" {
+		add("comment", comment)
+	}
+	return strings.Join(quoted, " "), strings.Join(plain, " ")
+}
+
+// settings reads whether ufw is enabled, its logging level and default
+// policies from its configuration files, which hold them even while the
+// firewall is disabled
+func (m *UFWModule) settings(ctx context.Context, conn types.Connection) (map[string]string, error) {
+	result, err := m.cli.run(ctx, conn, "reading the ufw configuration",
+		"grep -hE '^(ENABLED|LOGLEVEL|DEFAULT_(INPUT|OUTPUT|FORWARD)_POLICY)=' /etc/ufw/ufw.conf /etc/default/ufw || true")
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			settings[key] = strings.Trim(value, `"'`)
+		}
+	}
+	return settings, nil
+}
+
+// Run executes the ufw module
+func (m *UFWModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	settings, err := m.settings(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	enabled := settings["ENABLED"] == "yes"
+
+	data := map[string]interface{}{}
+	var changes, commands []string
+	var before, after strings.Builder
+	apply := func(cmd, change string) error {
+		commands = append(commands, cmd)
+		changes = append(changes, change)
+		if checkMode {
+			return nil
+		}
+		_, err := m.cli.run(ctx, conn, "running "+cmd, cmd)
+		return err
+	}
+
+	// Rules go first, so an allow rule for SSH is in place before a task
+	// that also enables the firewall does so
+	if rule := m.GetStringArg(args, "rule", ""); rule != "" {
+		quoted, description := m.ruleArgs(args)
+		data["rule"] = description
+
+		remove := m.GetBoolArg(args, "delete", false)
+		cmd := "ufw " + quoted
+		if remove {
+			cmd = "ufw delete " + quoted
+		}
+
+		// ufw skips rules that already exist and deletions of rules that
+		// do not, for IPv4 and IPv6 separately, and a dry run reports the
+		// outcome without touching the rules
+		result, err := m.cli.run(ctx, conn, "checking the ufw rule", strings.Replace(cmd, "ufw ", "ufw --dry-run ", 1))
+		if err != nil {
+			return nil, err
+		}
+		stdout, _ := result.Data["stdout"].(string)
+		outcome := ""
+		for _, line := range strings.Split(stdout, "\n") {
+			switch {
+			case strings.HasPrefix(line, "Rule updated"):
+				outcome = "updated"
+			case strings.HasPrefix(line, "Rule added"), strings.HasPrefix(line, "Rule inserted"):
+				if outcome == "" {
+					outcome = "added"
+				}
+			case strings.HasPrefix(line, "Rule deleted"):
+				outcome = "deleted"
+			}
+		}
+
+		existed := !remove
+		switch outcome {
+		case "added":
+			existed = false
+		case "deleted", "updated":
+			existed = true
+		}
+		fmt.Fprintf(&before, "rule %s: %t\n", description, existed)
+		fmt.Fprintf(&after, "rule %s: %t\n", description, !remove)
+		if outcome != "" {
+			if err := apply(cmd, fmt.Sprintf("%s the rule %q", outcome, description)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if policy := m.GetStringArg(args, "default", ""); policy != "" {
+		direction := m.GetStringArg(args, "direction", "incoming")
+		current := ufwPolicies[settings[ufwDefaultKeys[direction]]]
+		fmt.Fprintf(&before, "default %s: %s\n", direction, current)
+		fmt.Fprintf(&after, "default %s: %s\n", direction, policy)
+		if current != policy {
+			cmd := fmt.Sprintf("ufw default %s %s", policy, direction)
+			if err := apply(cmd, fmt.Sprintf("set the default %s policy to %s", direction, policy)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if level := m.GetStringArg(args, "logging", ""); level != "" {
+		if level == "on" {
+			level = "low"
+		}
+		current := settings["LOGLEVEL"]
+		fmt.Fprintf(&before, "logging: %s\n", current)
+		fmt.Fprintf(&after, "logging: %s\n", level)
+		if current != level {
+			if err := apply("ufw logging "+level, "set logging to "+level); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	status := enabled
+	switch m.GetStringArg(args, "state", "") {
+	case "enabled":
+		status = true
+		if !enabled {
+			if err := apply("ufw --force enable", "enabled ufw"); err != nil {
+				return nil, err
+			}
+		}
+	case "disabled":
+		status = false
+		if enabled {
+			if err := apply("ufw disable", "disabled ufw"); err != nil {
+				return nil, err
+			}
+		}
+	case "reloaded":
+		if enabled {
+			if err := apply("ufw reload", "reloaded ufw"); err != nil {
+				return nil, err
+			}
+		}
+	case "reset":
+		status = false
+		if err := apply("ufw --force reset", "reset ufw"); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintf(&before, "enabled: %t\n", enabled)
+	fmt.Fprintf(&after, "enabled: %t\n", status)
+	data["status"] = status
+	data["commands"] = commands
+
+	result := m.CreateSuccessResult(hostname, false, "Ufw is already in desired state", data)
+	return changeResult(m.BaseModule, result, strings.Join(changes, ", "), checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
@@ -0,0 +1,99 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestUFWModule(t *testing.T) {
+	module := NewUFWModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidRule", Args: map[string]interface{}{"rule": "allow", "port": "443", "proto": "tcp"}, ExpectValid: true},
+		{Name: "ValidDefault", Args: map[string]interface{}{"default": "deny", "direction": "incoming"}, ExpectValid: true},
+		{Name: "NothingToManage", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "PortWithoutRule", Args: map[string]interface{}{"port": "22"}, ExpectValid: false},
+		{Name: "RuleDirectionForDefault", Args: map[string]interface{}{"default": "deny", "direction": "in"}, ExpectValid: false},
+		{Name: "InterfaceWithoutDirection", Args: map[string]interface{}{"rule": "allow", "interface": "eth0"}, ExpectValid: false},
+		{Name: "PortWithGRE", Args: map[string]interface{}{"rule": "allow", "port": "22", "proto": "gre"}, ExpectValid: false},
+		{Name: "InvalidLogging", Args: map[string]interface{}{"logging": "verbose"}, ExpectValid: false},
+	})
+
+	settings := func(enabled string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`^grep -hE .* /etc/ufw/ufw.conf /etc/default/ufw`, &testhelper.CommandResponse{
+				Stdout: "ENABLED=" + enabled + "\nLOGLEVEL=low\nDEFAULT_INPUT_POLICY=\"ACCEPT\"\nDEFAULT_OUTPUT_POLICY=\"ACCEPT\"\nDEFAULT_FORWARD_POLICY=\"DROP\"\n",
+			})
+		}
+	}
+	const rule = `ufw allow in on 'eth0' proto 'tcp' from '10.0.0.0/8' to 'any' port '5432' comment 'app servers'`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "AddRuleAndEnable",
+			Args:     map[string]interface{}{"rule": "allow", "direction": "in", "interface": "eth0", "proto": "tcp", "from_ip": "10.0.0.0/8", "port": "5432", "comment": "app servers", "state": "enabled"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				settings("no")(h)
+				h.GetConnection().ExpectCommand(`ufw --dry-run allow in on 'eth0' proto 'tcp' from '10.0.0.0/8' to 'any' port '5432' comment 'app servers'`, &testhelper.CommandResponse{Stdout: "Rule added\n"})
+				h.GetConnection().ExpectCommand(rule, &testhelper.CommandResponse{Stdout: "Rule added\n"})
+				h.GetConnection().ExpectCommand("ufw --force enable", &testhelper.CommandResponse{Stdout: "Firewall is active and enabled on system startup\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, `Added the rule "allow in on eth0 proto tcp from 10.0.0.0/8 to any port 5432 comment app servers", enabled ufw`)
+				h.AssertDataValue(result, "status", true)
+				h.AssertDiffBefore(result, "rule allow in on eth0 proto tcp from 10.0.0.0/8 to any port 5432 comment app servers: false\nenabled: false\n")
+				h.GetConnection().AssertCalledBefore(`^ufw allow `, `^ufw --force enable$`)
+			},
+		},
+		{
+			Name: "ExistingRuleSkipped",
+			Args: map[string]interface{}{"rule": "limit", "port": "22", "proto": "tcp"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				settings("yes")(h)
+				h.GetConnection().ExpectCommand(`ufw --dry-run limit proto 'tcp' from 'any' to 'any' port '22'`, &testhelper.CommandResponse{Stdout: "Skipping adding existing rule\nSkipping adding existing rule (v6)\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "DeleteRule",
+			Args: map[string]interface{}{"rule": "allow", "port": "8080", "delete": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				settings("yes")(h)
+				h.GetConnection().ExpectCommand(`ufw --dry-run delete allow from 'any' to 'any' port '8080'`, &testhelper.CommandResponse{Stdout: "Rule deleted\nCould not delete non-existent rule (v6)\n"})
+				h.GetConnection().ExpectCommand(`ufw delete allow from 'any' to 'any' port '8080'`, &testhelper.CommandResponse{Stdout: "Rule deleted\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, `Deleted the rule "allow from any to any port 8080"`)
+			},
+		},
+		{
+			Name:      "DefaultAndLoggingCheckMode",
+			Args:      map[string]interface{}{"default": "deny", "direction": "incoming", "logging": "on"},
+			CheckMode: true,
+			Setup:     settings("yes"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.AssertMessage(result, "Would have set the default incoming policy to deny")
+				h.GetConnection().AssertPatternCalledTimes(`^ufw default`, 0)
+			},
+		},
+		{
+			Name:  "DisableWhenDisabled",
+			Args:  map[string]interface{}{"state": "disabled"},
+			Setup: settings("no"),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "status", false)
+			},
+		},
+	})
+}
@@ -3,584 +3,476 @@ package modules
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
+// unarchiveFormats are the supported archive formats, detected from the
+// source name when format is auto
+var unarchiveFormats = []string{"auto", "tar", "tar.gz", "tar.bz2", "tar.xz", "tar.zst", "zip", "gz", "bz2", "xz"}
+
+// unarchiveExtensions maps file name suffixes to formats, longest first
+var unarchiveExtensions = []struct{ suffix, format string }{
+	{".tar.gz", "tar.gz"}, {".tar.bz2", "tar.bz2"}, {".tar.xz", "tar.xz"}, {".tar.zst", "tar.zst"},
+	{".tgz", "tar.gz"}, {".tbz2", "tar.bz2"}, {".tbz", "tar.bz2"}, {".txz", "tar.xz"}, {".tzst", "tar.zst"},
+	{".tar", "tar"}, {".zip", "zip"}, {".jar", "zip"}, {".gz", "gz"}, {".bz2", "bz2"}, {".xz", "xz"},
+}
+
+// unarchiveTarFlags are the tar decompression flags of each tar format
+var unarchiveTarFlags = map[string]string{"tar": "", "tar.gz": "z", "tar.bz2": "j", "tar.xz": "J", "tar.zst": "--zstd "}
+
+// unarchiveDecompressors decompress single file formats to stdout
+var unarchiveDecompressors = map[string]string{"gz": "gzip -dc", "bz2": "bzip2 -dc", "xz": "xz -dc"}
+
+// detectUnarchiveFormat detects the archive format from a file name or URL
+func detectUnarchiveFormat(name string) string {
+	if parsed, err := url.Parse(name); err == nil && parsed.Scheme != "" {
+		name = parsed.Path
+	}
+	lower := strings.ToLower(path.Base(name))
+	for _, ext := range unarchiveExtensions {
+		if strings.HasSuffix(lower, ext.suffix) {
+			return ext.format
+		}
+	}
+	return ""
+}
+
 // UnarchiveModule handles extraction of archive files
 type UnarchiveModule struct {
-	BaseModule
+	*BaseModule
+	cli remoteCLI
 }
 
 // NewUnarchiveModule creates a new unarchive module instance
 func NewUnarchiveModule() *UnarchiveModule {
-	return &UnarchiveModule{
-		BaseModule: BaseModule{
-			name: "unarchive",
+	doc := types.ModuleDoc{
+		Name:        "unarchive",
+		Description: "Extract tar, zip and compressed archives into a directory, only replacing entries whose content differs",
+		Parameters: map[string]types.ParamDoc{
+			"src": {
+				Description: "Archive on the controller, on the target host with remote_src, or an HTTP(S) URL downloaded by the target host",
+				Required:    true,
+				Type:        "string",
+			},
+			"dest": {
+				Description: "Directory to extract into, created when missing",
+				Required:    true,
+				Type:        "path",
+			},
+			"remote_src": {
+				Description: "src is a path on the target host instead of the controller",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"format": {
+				Description: "Archive format, detected from the name of src when auto",
+				Required:    false,
+				Type:        "string",
+				Default:     "auto",
+				Choices:     unarchiveFormats,
+			},
+			"creates": {
+				Description: "Skip extraction when this path exists on the target host",
+				Required:    false,
+				Type:        "path",
+			},
+			"strip_components": {
+				Description: "Leading path components removed from tar entries",
+				Required:    false,
+				Type:        "int",
+			},
+			"include": {
+				Description: "Entries to extract, all when empty",
+				Required:    false,
+				Type:        "list",
+			},
+			"exclude": {
+				Description: "Entry patterns not to extract",
+				Required:    false,
+				Type:        "list",
+			},
+			"extra_opts": {
+				Description: "Extra options passed to tar or unzip",
+				Required:    false,
+				Type:        "list",
+			},
+			"keep_newer": {
+				Description: "Keep files in dest that are newer than their archive entry",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"list_files": {
+				Description: "Return the entries of the archive in files",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"validate_certs": {
+				Description: "Verify the TLS certificate when src is an HTTPS URL",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"mode": {
+				Description: "Permissions applied to the extracted entries",
+				Required:    false,
+				Type:        "string",
+			},
+			"owner": {
+				Description: "Owner of the extracted entries",
+				Required:    false,
+				Type:        "string",
+			},
+			"group": {
+				Description: "Group of the extracted entries",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Install node_exporter\n  unarchive:\n    src: https://github.com/prometheus/node_exporter/releases/download/v1.8.2/node_exporter-1.8.2.linux-amd64.tar.gz\n    dest: /usr/local/bin\n    include: [node_exporter-1.8.2.linux-amd64/node_exporter]\n    strip_components: 1",
+			"- name: Deploy the site from the controller\n  unarchive:\n    src: build/site.zip\n    dest: /var/www/site\n    owner: www-data\n    group: www-data\n    list_files: true",
+		},
+		Returns: map[string]string{
+			"dest":          "Directory extracted into",
+			"changed_files": "Entries created or replaced in dest",
+			"files":         "All entries of the archive, with list_files",
 		},
 	}
+
+	base := NewBaseModule("unarchive", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+	})
+
+	return &UnarchiveModule{BaseModule: base}
 }
 
 // Validate validates the module arguments
 func (m *UnarchiveModule) Validate(args map[string]interface{}) error {
-	// Validate required fields
-	src, hasSrc := args["src"].(string)
-	if !hasSrc || src == "" {
-		return fmt.Errorf("src is required")
+	if err := m.ValidateRequired(args, []string{"src", "dest"}); err != nil {
+		return err
 	}
-
-	dest, hasDest := args["dest"].(string)
-	if !hasDest || dest == "" {
-		return fmt.Errorf("dest is required")
+	if err := m.ValidateChoices(args, "format", unarchiveFormats); err != nil {
+		return err
 	}
-
-	// Validate remote_src
-	if remoteSrc, hasRemoteSrc := args["remote_src"]; hasRemoteSrc {
-		if _, ok := remoteSrc.(bool); !ok {
-			return types.NewValidationError("remote_src", remoteSrc, "remote_src must be a boolean")
+	for _, name := range []string{"remote_src", "keep_newer", "list_files", "validate_certs"} {
+		if value, ok := args[name]; ok {
+			if _, isBool := value.(bool); !isBool {
+				return types.NewValidationError(name, value, name+" must be a boolean")
+			}
 		}
 	}
-
-	// Validate creates
-	if creates, hasCreates := args["creates"]; hasCreates {
-		if _, ok := creates.(string); !ok {
-			return types.NewValidationError("creates", creates, "creates must be a string")
+	for _, name := range []string{"include", "exclude", "extra_opts"} {
+		if value, ok := args[name]; ok && value != nil {
+			if _, isString := value.(string); !isString && !isStringList(value) {
+				return types.NewValidationError(name, value, name+" must be a string or list of strings")
+			}
 		}
 	}
-
-	// Validate list_files
-	if listFiles, hasListFiles := args["list_files"]; hasListFiles {
-		if _, ok := listFiles.(bool); !ok {
-			return types.NewValidationError("list_files", listFiles, "list_files must be a boolean")
-		}
+	if mode := m.GetStringArg(args, "mode", ""); mode != "" && !isOctalMode(mode) {
+		return types.NewValidationError("mode", mode, "mode must be octal, e.g. 0755")
 	}
 
-	// Validate mode
-	if mode, hasMode := args["mode"]; hasMode {
-		if _, ok := mode.(string); !ok && mode != nil {
-			return types.NewValidationError("mode", mode, "mode must be a string")
-		}
+	format := m.format(args)
+	if format == "" {
+		return types.NewValidationError("format", m.GetStringArg(args, "src", ""), "cannot detect the archive format from src, set format")
 	}
-
-	// Validate owner
-	if owner, hasOwner := args["owner"]; hasOwner {
-		if _, ok := owner.(string); !ok && owner != nil {
-			return types.NewValidationError("owner", owner, "owner must be a string")
-		}
+	if strip, err := m.GetIntArg(args, "strip_components", 0); err != nil || strip < 0 {
+		return types.NewValidationError("strip_components", args["strip_components"], "must be a positive number")
+	} else if strip > 0 && !strings.HasPrefix(format, "tar") {
+		return types.NewValidationError("strip_components", strip, "strip_components is only supported for tar archives")
 	}
+	return nil
+}
 
-	// Validate group
-	if group, hasGroup := args["group"]; hasGroup {
-		if _, ok := group.(string); !ok && group != nil {
-			return types.NewValidationError("group", group, "group must be a string")
-		}
+// isStringList reports whether value is a list of strings
+func isStringList(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		_, ok = value.([]string)
+		return ok
 	}
-
-	// Validate exclude patterns
-	if exclude, hasExclude := args["exclude"]; hasExclude {
-		switch v := exclude.(type) {
-		case string:
-			// Single exclude pattern - OK
-		case []interface{}:
-			// List of exclude patterns - validate each is a string
-			for _, pattern := range v {
-				if _, ok := pattern.(string); !ok {
-					return types.NewValidationError("exclude", exclude, "exclude must be a string or list of strings")
-				}
-			}
-		default:
-			return types.NewValidationError("exclude", exclude, "exclude must be a string or list of strings")
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return false
 		}
 	}
+	return true
+}
 
-	// Validate include patterns
-	if include, hasInclude := args["include"]; hasInclude {
-		switch v := include.(type) {
-		case string:
-			// Single include pattern - OK
-		case []interface{}:
-			// List of include patterns - validate each is a string
-			for _, pattern := range v {
-				if _, ok := pattern.(string); !ok {
-					return types.NewValidationError("include", include, "include must be a string or list of strings")
-				}
-			}
-		default:
-			return types.NewValidationError("include", include, "include must be a string or list of strings")
-		}
+// format returns the archive format of the task
+func (m *UnarchiveModule) format(args map[string]interface{}) string {
+	if format := m.GetStringArg(args, "format", "auto"); format != "auto" {
+		return format
 	}
+	return detectUnarchiveFormat(m.GetStringArg(args, "src", ""))
+}
 
-	// Validate keep_newer
-	if keepNewer, hasKeepNewer := args["keep_newer"]; hasKeepNewer {
-		if _, ok := keepNewer.(bool); !ok {
-			return types.NewValidationError("keep_newer", keepNewer, "keep_newer must be a boolean")
+// extractCommand extracts archive into the directory in $stage
+func (m *UnarchiveModule) extractCommand(args map[string]interface{}, format, archive string) string {
+	quote := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = m.cli.shellEscape(value)
 		}
+		return strings.Join(quoted, " ")
 	}
+	include, exclude, extra := stringList(args["include"]), stringList(args["exclude"]), stringList(args["extra_opts"])
 
-	// Validate validate_certs
-	if validateCerts, hasValidateCerts := args["validate_certs"]; hasValidateCerts {
-		if _, ok := validateCerts.(bool); !ok {
-			return types.NewValidationError("validate_certs", validateCerts, "validate_certs must be a boolean")
+	if flags, ok := unarchiveTarFlags[format]; ok {
+		options := append([]string{}, extra...)
+		if strip, _ := m.GetIntArg(args, "strip_components", 0); strip > 0 {
+			options = append(options, "--strip-components="+strconv.Itoa(strip))
 		}
-	}
-
-	return nil
-}
-
-// Run executes the unarchive module
-func (m *UnarchiveModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
-	// Validate arguments
-	if err := m.Validate(args); err != nil {
-		return &types.Result{
-			Success: false,
-			Message: err.Error(),
-		}, err
-	}
-
-	// Parse arguments
-	src := args["src"].(string)
-	dest := args["dest"].(string)
-	remoteSrc := m.GetBoolArg(args, "remote_src", false)
-	creates := m.GetStringArg(args, "creates", "")
-	listFiles := m.GetBoolArg(args, "list_files", false)
-	mode := m.GetStringArg(args, "mode", "")
-	owner := m.GetStringArg(args, "owner", "")
-	group := m.GetStringArg(args, "group", "")
-	keepNewer := m.GetBoolArg(args, "keep_newer", false)
-	validateCerts := m.GetBoolArg(args, "validate_certs", true)
-
-	// Get exclude and include patterns
-	excludePatterns := m.getPatterns(args, "exclude")
-	includePatterns := m.getPatterns(args, "include")
-
-	// Check if destination exists
-	destExists, err := m.pathExists(ctx, conn, dest)
-	if err != nil {
-		return &types.Result{
-			Success: false,
-			Message: fmt.Sprintf("Failed to check destination: %v", err),
-		}, err
-	}
-
-	if !destExists {
-		// Create destination directory
-		if m.CheckMode(args) {
-			return &types.Result{
-				Success: true,
-				Changed: true,
-				Message: fmt.Sprintf("Would create destination directory %s", dest),
-			}, nil
+		for _, pattern := range exclude {
+			options = append(options, "--exclude="+pattern)
 		}
-
-		if err := m.createDirectory(ctx, conn, dest, mode, owner, group); err != nil {
-			return &types.Result{
-				Success: false,
-				Message: fmt.Sprintf("Failed to create destination: %v", err),
-			}, err
+		cmd := fmt.Sprintf(`tar -x%sf %s -C "$stage"`, strings.TrimSpace(flags), archive)
+		if strings.HasPrefix(flags, "--") {
+			cmd = fmt.Sprintf(`tar %s-xf %s -C "$stage"`, flags, archive)
 		}
-	}
-
-	// Check if creates path exists
-	if creates != "" {
-		createsExists, err := m.pathExists(ctx, conn, creates)
-		if err != nil {
-			return &types.Result{
-				Success: false,
-				Message: fmt.Sprintf("Failed to check creates path: %v", err),
-			}, err
+		if len(options) > 0 {
+			cmd += " " + quote(options)
 		}
-
-		if createsExists {
-			return &types.Result{
-				Success: true,
-				Changed: false,
-				Message: fmt.Sprintf("Creates path %s already exists, skipping extraction", creates),
-			}, nil
+		if len(include) > 0 {
+			cmd += " -- " + quote(include)
 		}
+		return cmd
 	}
-
-	// Download remote archive if needed
-	archivePath := src
-	if !remoteSrc && strings.HasPrefix(src, "http") {
-		if m.CheckMode(args) {
-			return &types.Result{
-				Success: true,
-				Changed: true,
-				Message: fmt.Sprintf("Would download and extract %s to %s", src, dest),
-			}, nil
+	if format == "zip" {
+		cmd := "unzip -q -o"
+		if len(extra) > 0 {
+			cmd += " " + quote(extra)
 		}
-
-		// Download archive
-		tempPath, err := m.downloadArchive(ctx, conn, src, validateCerts)
-		if err != nil {
-			return &types.Result{
-				Success: false,
-				Message: fmt.Sprintf("Failed to download archive: %v", err),
-			}, err
+		cmd += " " + archive
+		if len(include) > 0 {
+			cmd += " " + quote(include)
 		}
-		archivePath = tempPath
-		defer m.cleanupTempFile(ctx, conn, tempPath)
-	}
-
-	// Detect archive type
-	archiveType := m.detectArchiveType(archivePath)
-	if archiveType == "" {
-		return &types.Result{
-			Success: false,
-			Message: fmt.Sprintf("Unsupported archive format: %s", archivePath),
-		}, fmt.Errorf("unsupported archive format")
-	}
-
-	// Check mode
-	if m.CheckMode(args) {
-		return &types.Result{
-			Success: true,
-			Changed: true,
-			Message: fmt.Sprintf("Would extract %s to %s", archivePath, dest),
-		}, nil
-	}
-
-	// Extract archive
-	extractedFiles, err := m.extractArchive(ctx, conn, archivePath, dest, archiveType, 
-		excludePatterns, includePatterns, keepNewer, listFiles)
-	if err != nil {
-		return &types.Result{
-			Success: false,
-			Message: fmt.Sprintf("Failed to extract archive: %v", err),
-		}, err
-	}
-
-	// Set permissions on extracted files if specified
-	if mode != "" || owner != "" || group != "" {
-		if err := m.setPermissions(ctx, conn, dest, mode, owner, group); err != nil {
-			return &types.Result{
-				Success: false,
-				Message: fmt.Sprintf("Failed to set permissions: %v", err),
-			}, err
+		if len(exclude) > 0 {
+			cmd += " -x " + quote(exclude)
 		}
+		return cmd + ` -d "$stage"`
 	}
 
-	result := &types.Result{
-		Success: true,
-		Changed: len(extractedFiles) > 0,
-		Message: fmt.Sprintf("Extracted %d files from %s to %s", len(extractedFiles), archivePath, dest),
-		Data:    make(map[string]interface{}),
+	// Single compressed files are named after the source without suffix
+	name := path.Base(m.GetStringArg(args, "src", ""))
+	if parsed, err := url.Parse(m.GetStringArg(args, "src", "")); err == nil && parsed.Scheme != "" {
+		name = path.Base(parsed.Path)
 	}
-
-	if listFiles {
-		result.Data["files"] = extractedFiles
+	if i := strings.LastIndex(name, "."); i > 0 {
+		name = name[:i]
 	}
+	return fmt.Sprintf(`%s %s > "$stage"/%s`, unarchiveDecompressors[format], archive, m.cli.shellEscape(name))
+}
 
-	return result, nil
+// attributesCommand applies mode and ownership to the staged entries,
+// leaving symbolic links alone
+func (m *UnarchiveModule) attributesCommand(args map[string]interface{}) string {
+	var steps []string
+	if mode := m.GetStringArg(args, "mode", ""); mode != "" {
+		steps = append(steps, fmt.Sprintf(`find "$stage" -mindepth 1 ! -type l -exec chmod %s {} +`, mode))
+	}
+	owner, group := m.GetStringArg(args, "owner", ""), m.GetStringArg(args, "group", "")
+	switch {
+	case owner != "" && group != "":
+		steps = append(steps, fmt.Sprintf(`chown -hR %s:%s "$stage"`, m.cli.shellEscape(owner), m.cli.shellEscape(group)))
+	case owner != "":
+		steps = append(steps, fmt.Sprintf(`chown -hR %s "$stage"`, m.cli.shellEscape(owner)))
+	case group != "":
+		steps = append(steps, fmt.Sprintf(`chgrp -hR %s "$stage"`, m.cli.shellEscape(group)))
+	}
+	return strings.Join(steps, " && ")
 }
 
-// getPatterns extracts patterns from arguments
-func (m *UnarchiveModule) getPatterns(args map[string]interface{}, key string) []string {
-	patterns := []string{}
-	
-	if value, exists := args[key]; exists {
-		switch v := value.(type) {
-		case string:
-			patterns = append(patterns, v)
-		case []interface{}:
-			for _, pattern := range v {
-				if str, ok := pattern.(string); ok {
-					patterns = append(patterns, str)
-				}
-			}
-		}
-	}
-	
-	return patterns
+// stageCommand extracts the archive to a staging directory next to dest
+// and compares every entry with dest: files by SHA-256 of their content,
+// links by target, and the attributes when mode or ownership is managed.
+// Entries differing are listed in $stage.changed for installCommand.
+func (m *UnarchiveModule) stageCommand(args map[string]interface{}, format, archive, dest string) string {
+	compareFile := `[ -f "$t" ] && [ ! -L "$t" ] && [ "$(sha256sum < "$f")" = "$(sha256sum < "$t")" ]`
+	if m.GetBoolArg(args, "keep_newer", false) {
+		compareFile = `[ -f "$t" ] && [ ! -L "$t" ] && { [ "$t" -nt "$f" ] || [ "$(sha256sum < "$f")" = "$(sha256sum < "$t")" ]; }`
+	}
+	compareAttributes := ""
+	if m.GetStringArg(args, "mode", "") != "" || m.GetStringArg(args, "owner", "") != "" || m.GetStringArg(args, "group", "") != "" {
+		compareAttributes = ` && [ "$(stat -c '%a %U %G' "$f")" = "$(stat -c '%a %U %G' "$t")" ]`
+	}
+
+	steps := []string{
+		"dest=" + m.cli.shellEscape(strings.TrimSuffix(dest, "/")),
+		`stage=$(mktemp -d "$(dirname "$dest")/.gosible-unarchive.XXXXXX") || exit 1`,
+		`printf 'stage=%s\n' "$stage"`,
+		`: > "$stage.changed"`,
+		fmt.Sprintf(`%s || { rm -rf "$stage" "$stage.changed"; exit 1; }`, m.extractCommand(args, format, archive)),
+	}
+	if attributes := m.attributesCommand(args); attributes != "" {
+		steps = append(steps, fmt.Sprintf(`{ %s; } || { rm -rf "$stage" "$stage.changed"; exit 1; }`, attributes))
+	}
+	steps = append(steps, `cd "$stage" && find . -mindepth 1 | sort | while IFS= read -r f; do `+
+		`t="$dest/${f#./}"; printf 'file=%s\n' "${f#./}"; `+
+		`if [ -L "$f" ]; then [ -L "$t" ] && [ "$(readlink "$f")" = "$(readlink "$t")" ]; `+
+		`elif [ -d "$f" ]; then [ -d "$t" ] && [ ! -L "$t" ]`+compareAttributes+`; `+
+		`else `+compareFile+compareAttributes+`; fi && continue; `+
+		`if [ -e "$t" ] || [ -L "$t" ]; then printf 'changed=%s\n' "${f#./}"; else printf 'new=%s\n' "${f#./}"; fi; `+
+		`printf '%s\n' "$f" >> "$stage.changed"; done`)
+	return strings.Join(steps, "; ")
 }
 
-// pathExists checks if a path exists
-func (m *UnarchiveModule) pathExists(ctx context.Context, conn types.Connection, path string) (bool, error) {
-	result, err := conn.Execute(ctx, fmt.Sprintf("test -e %s", path), types.ExecuteOptions{})
-	if err != nil {
-		return false, nil
-	}
-	return result.Success, nil
+// installCommand copies the changed staged entries into dest, keeping
+// their attributes, and removes the staging directory
+func (m *UnarchiveModule) installCommand(stage, dest string) string {
+	quotedStage := m.cli.shellEscape(stage)
+	return fmt.Sprintf(`mkdir -p %s && cd %s && tar -cf - --no-recursion -T %s | tar -xpf - -C %s; rc=$?; rm -rf %s %s; exit $rc`,
+		m.cli.shellEscape(dest), quotedStage, m.cli.shellEscape(stage+".changed"), m.cli.shellEscape(dest), quotedStage, m.cli.shellEscape(stage+".changed"))
 }
 
-// createDirectory creates a directory with specified permissions
-func (m *UnarchiveModule) createDirectory(ctx context.Context, conn types.Connection, path, mode, owner, group string) error {
-	cmd := fmt.Sprintf("mkdir -p %s", path)
-	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
-	if err != nil {
-		return err
-	}
-	if !result.Success {
-		return fmt.Errorf("failed to create directory")
-	}
+// cleanupCommand removes the staging directory
+func (m *UnarchiveModule) cleanupCommand(stage string) string {
+	return fmt.Sprintf("rm -rf %s %s", m.cli.shellEscape(stage), m.cli.shellEscape(stage+".changed"))
+}
 
-	// Set permissions if specified
-	if mode != "" {
-		cmd = fmt.Sprintf("chmod %s %s", mode, path)
-		if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-			return err
+// source makes the archive available on the target host, returning its
+// path there and a command removing it once extracted when it is a copy
+func (m *UnarchiveModule) source(ctx context.Context, conn types.Connection, args map[string]interface{}) (string, string, error) {
+	src := m.GetStringArg(args, "src", "")
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		insecure := ""
+		if !m.GetBoolArg(args, "validate_certs", true) {
+			insecure = " --insecure"
+		}
+		cmd := fmt.Sprintf(`tmp=$(mktemp) && curl --location --fail --silent --show-error%s --output "$tmp" -- %s && printf '%%s' "$tmp"`, insecure, m.cli.shellEscape(src))
+		result, err := m.cli.run(ctx, conn, "downloading "+src, cmd)
+		if err != nil {
+			return "", "", err
 		}
+		tmp, _ := result.Data["stdout"].(string)
+		tmp = strings.TrimSpace(tmp)
+		return tmp, "rm -f " + m.cli.shellEscape(tmp), nil
 	}
-
-	// Set ownership if specified
-	if owner != "" || group != "" {
-		ownerGroup := ""
-		if owner != "" && group != "" {
-			ownerGroup = fmt.Sprintf("%s:%s", owner, group)
-		} else if owner != "" {
-			ownerGroup = owner
-		} else {
-			ownerGroup = fmt.Sprintf(":%s", group)
-		}
-		cmd = fmt.Sprintf("chown %s %s", ownerGroup, path)
-		if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-			return err
-		}
+	if m.GetBoolArg(args, "remote_src", false) {
+		return src, "", nil
 	}
 
-	return nil
-}
-
-// downloadArchive downloads a remote archive
-func (m *UnarchiveModule) downloadArchive(ctx context.Context, conn types.Connection, url string, validateCerts bool) (string, error) {
-	tempFile := fmt.Sprintf("/tmp/ansible_unarchive_%d", m.GetCurrentTimestamp())
-	
-	cmd := "wget"
-	if !validateCerts {
-		cmd += " --no-check-certificate"
+	file, err := os.Open(src)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open archive: %w", err)
 	}
-	cmd += fmt.Sprintf(" -O %s %s", tempFile, url)
-
-	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	defer file.Close()
+	result, err := m.cli.run(ctx, conn, "creating a temporary file", "mktemp")
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	if !result.Success {
-		return "", fmt.Errorf("failed to download archive")
+	tmp, _ := result.Data["stdout"].(string)
+	tmp = strings.TrimSpace(tmp)
+	if err := conn.Copy(ctx, file, tmp, 0600); err != nil {
+		return "", "", fmt.Errorf("failed to upload %s: %w", src, err)
 	}
-
-	return tempFile, nil
+	return tmp, "rm -f " + m.cli.shellEscape(tmp), nil
 }
 
-// cleanupTempFile removes a temporary file
-func (m *UnarchiveModule) cleanupTempFile(ctx context.Context, conn types.Connection, path string) {
-	conn.Execute(ctx, fmt.Sprintf("rm -f %s", path), types.ExecuteOptions{})
-}
+// Run executes the unarchive module
+func (m *UnarchiveModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
 
-// detectArchiveType detects the type of archive based on extension
-func (m *UnarchiveModule) detectArchiveType(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-	base := strings.ToLower(filepath.Base(path))
-
-	// Check for tar variants
-	if strings.Contains(base, ".tar.") {
-		if strings.HasSuffix(base, ".tar.gz") || strings.HasSuffix(base, ".tgz") {
-			return "tar.gz"
-		} else if strings.HasSuffix(base, ".tar.bz2") || strings.HasSuffix(base, ".tbz2") {
-			return "tar.bz2"
-		} else if strings.HasSuffix(base, ".tar.xz") || strings.HasSuffix(base, ".txz") {
-			return "tar.xz"
-		} else if strings.HasSuffix(base, ".tar.Z") {
-			return "tar.Z"
-		}
-		return "tar"
-	}
-
-	// Check single extensions
-	switch ext {
-	case ".zip":
-		return "zip"
-	case ".gz":
-		return "gz"
-	case ".bz2":
-		return "bz2"
-	case ".xz":
-		return "xz"
-	case ".tar":
-		return "tar"
-	case ".tgz":
-		return "tar.gz"
-	case ".tbz2", ".tbz":
-		return "tar.bz2"
-	case ".txz":
-		return "tar.xz"
-	case ".7z":
-		return "7z"
-	case ".rar":
-		return "rar"
+	if err := m.Validate(args); err != nil {
+		return nil, err
 	}
+	src := m.GetStringArg(args, "src", "")
+	dest := m.GetStringArg(args, "dest", "")
+	data := map[string]interface{}{"src": src, "dest": dest}
 
-	return ""
-}
-
-// extractArchive extracts an archive
-func (m *UnarchiveModule) extractArchive(ctx context.Context, conn types.Connection, archivePath, dest, archiveType string,
-	excludePatterns, includePatterns []string, keepNewer, listFiles bool) ([]string, error) {
-	
-	var cmd string
-	var listCmd string
-
-	switch archiveType {
-	case "tar":
-		cmd = fmt.Sprintf("tar -xf %s -C %s", archivePath, dest)
-		listCmd = fmt.Sprintf("tar -tf %s", archivePath)
-		if keepNewer {
-			cmd = fmt.Sprintf("tar -xf %s -C %s --keep-newer-files", archivePath, dest)
-		}
-	case "tar.gz", "tgz":
-		cmd = fmt.Sprintf("tar -xzf %s -C %s", archivePath, dest)
-		listCmd = fmt.Sprintf("tar -tzf %s", archivePath)
-		if keepNewer {
-			cmd = fmt.Sprintf("tar -xzf %s -C %s --keep-newer-files", archivePath, dest)
-		}
-	case "tar.bz2", "tbz2":
-		cmd = fmt.Sprintf("tar -xjf %s -C %s", archivePath, dest)
-		listCmd = fmt.Sprintf("tar -tjf %s", archivePath)
-		if keepNewer {
-			cmd = fmt.Sprintf("tar -xjf %s -C %s --keep-newer-files", archivePath, dest)
-		}
-	case "tar.xz", "txz":
-		cmd = fmt.Sprintf("tar -xJf %s -C %s", archivePath, dest)
-		listCmd = fmt.Sprintf("tar -tJf %s", archivePath)
-		if keepNewer {
-			cmd = fmt.Sprintf("tar -xJf %s -C %s --keep-newer-files", archivePath, dest)
-		}
-	case "zip":
-		cmd = fmt.Sprintf("unzip -o %s -d %s", archivePath, dest)
-		listCmd = fmt.Sprintf("unzip -l %s", archivePath)
-		if keepNewer {
-			cmd = fmt.Sprintf("unzip -u %s -d %s", archivePath, dest)
+	if creates := m.GetStringArg(args, "creates", ""); creates != "" {
+		_, exists, err := m.cli.inspect(ctx, conn, creates, "[ -e "+m.cli.shellEscape(creates)+" ]", "")
+		if err != nil {
+			return nil, err
 		}
-	case "gz":
-		// Single file gzip
-		outputFile := filepath.Join(dest, strings.TrimSuffix(filepath.Base(archivePath), ".gz"))
-		cmd = fmt.Sprintf("gunzip -c %s > %s", archivePath, outputFile)
-		listCmd = fmt.Sprintf("gunzip -l %s", archivePath)
-	case "bz2":
-		// Single file bzip2
-		outputFile := filepath.Join(dest, strings.TrimSuffix(filepath.Base(archivePath), ".bz2"))
-		cmd = fmt.Sprintf("bunzip2 -c %s > %s", archivePath, outputFile)
-		listCmd = ""
-	case "xz":
-		// Single file xz
-		outputFile := filepath.Join(dest, strings.TrimSuffix(filepath.Base(archivePath), ".xz"))
-		cmd = fmt.Sprintf("xz -dc %s > %s", archivePath, outputFile)
-		listCmd = ""
-	case "7z":
-		cmd = fmt.Sprintf("7z x -o%s %s -y", dest, archivePath)
-		listCmd = fmt.Sprintf("7z l %s", archivePath)
-	case "rar":
-		cmd = fmt.Sprintf("unrar x -o+ %s %s", archivePath, dest)
-		listCmd = fmt.Sprintf("unrar l %s", archivePath)
-	default:
-		return nil, fmt.Errorf("unsupported archive type: %s", archiveType)
-	}
-
-	// Add exclude patterns
-	for _, pattern := range excludePatterns {
-		switch archiveType {
-		case "tar", "tar.gz", "tar.bz2", "tar.xz":
-			cmd += fmt.Sprintf(" --exclude='%s'", pattern)
-		case "zip":
-			cmd += fmt.Sprintf(" -x '%s'", pattern)
+		if exists {
+			return m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s exists, skipping extraction", creates), data), nil
 		}
 	}
 
-	// Get list of files if requested
-	var extractedFiles []string
-	if listFiles && listCmd != "" {
-		result, err := conn.Execute(ctx, listCmd, types.ExecuteOptions{})
-		if err == nil && result.Success {
-			if stdout, ok := result.Data["stdout"].(string); ok {
-				extractedFiles = m.parseFileList(stdout, archiveType)
-			}
+	// Comparing a controller archive needs it on the host, which check
+	// mode must not do
+	remote := m.GetBoolArg(args, "remote_src", false) || strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+	if checkMode && !remote {
+		if _, err := os.Stat(src); err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
 		}
+		result := m.CreateSuccessResult(hostname, false, "", data)
+		return changeResult(m.BaseModule, result, fmt.Sprintf("extracted %s to %s", src, dest), checkMode, diffMode, "", src+"\n", startTime), nil
 	}
 
-	// Extract the archive
-	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	archive, cleanup, err := m.source(ctx, conn, args)
 	if err != nil {
 		return nil, err
 	}
-	if !result.Success {
-		return nil, fmt.Errorf("extraction failed")
+	if cleanup != "" {
+		defer func() { _, _ = m.cli.run(context.WithoutCancel(ctx), conn, "removing the archive copy", cleanup) }()
 	}
 
-	return extractedFiles, nil
-}
-
-// parseFileList parses the file list from archive listing output
-func (m *UnarchiveModule) parseFileList(output, archiveType string) []string {
-	files := []string{}
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		switch archiveType {
-		case "tar", "tar.gz", "tar.bz2", "tar.xz":
-			// tar lists files directly
-			files = append(files, line)
-		case "zip":
-			// unzip -l format: skip header and parse file names
-			fields := strings.Fields(line)
-			if len(fields) >= 4 && fields[0] != "Length" {
-				// File name is the last field
-				files = append(files, fields[len(fields)-1])
-			}
-		case "7z":
-			// 7z l format: parse file names from listing
-			if strings.Contains(line, "....A") {
-				fields := strings.Fields(line)
-				if len(fields) >= 6 {
-					files = append(files, fields[len(fields)-1])
-				}
-			}
-		}
+	staged, err := m.cli.run(ctx, conn, "extracting "+src, m.stageCommand(args, m.format(args), m.cli.shellEscape(archive), dest))
+	if err != nil {
+		return nil, err
 	}
-
-	return files
-}
-
-// setPermissions sets permissions on extracted files
-func (m *UnarchiveModule) setPermissions(ctx context.Context, conn types.Connection, path, mode, owner, group string) error {
-	// Set permissions recursively if specified
-	if mode != "" {
-		cmd := fmt.Sprintf("find %s -type f -exec chmod %s {} +", path, mode)
-		if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-			return err
-		}
-		cmd = fmt.Sprintf("find %s -type d -exec chmod %s {} +", path, mode)
-		if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-			return err
-		}
+	stdout, _ := staged.Data["stdout"].(string)
+	var stage string
+	var files, changed []string
+	var before, after strings.Builder
+	for _, line := range strings.Split(stdout, "\n") {
+		key, value, _ := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		switch key {
+		case "stage":
+			stage = value
+		case "file":
+			files = append(files, value)
+		case "changed":
+			changed = append(changed, value)
+			fmt.Fprintf(&before, "%s\n", value)
+			fmt.Fprintf(&after, "%s\n", value)
+		case "new":
+			changed = append(changed, value)
+			fmt.Fprintf(&after, "%s\n", value)
+		}
+	}
+	if stage == "" {
+		return nil, fmt.Errorf("extracting %s failed: no staging directory reported", src)
+	}
+
+	if len(changed) > 0 && !checkMode {
+		_, err = m.cli.run(ctx, conn, "installing into "+dest, m.installCommand(stage, dest))
+	} else {
+		_, err = m.cli.run(ctx, conn, "removing the staging directory", m.cleanupCommand(stage))
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Set ownership recursively if specified
-	if owner != "" || group != "" {
-		ownerGroup := ""
-		if owner != "" && group != "" {
-			ownerGroup = fmt.Sprintf("%s:%s", owner, group)
-		} else if owner != "" {
-			ownerGroup = owner
-		} else {
-			ownerGroup = fmt.Sprintf(":%s", group)
-		}
-		cmd := fmt.Sprintf("chown -R %s %s", ownerGroup, path)
-		if _, err := conn.Execute(ctx, cmd, types.ExecuteOptions{}); err != nil {
-			return err
-		}
+	if changed == nil {
+		changed = []string{}
+	}
+	data["changed_files"] = changed
+	if m.GetBoolArg(args, "list_files", false) {
+		data["files"] = files
 	}
 
-	return nil
+	change := ""
+	if len(changed) > 0 {
+		change = fmt.Sprintf("extracted %d of %d entries from %s to %s", len(changed), len(files), src, dest)
+	}
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("%s already has the content of %s", dest, src), data)
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before.String(), after.String(), startTime), nil
 }
-
-// GetCurrentTimestamp returns current timestamp
-func (m *UnarchiveModule) GetCurrentTimestamp() int64 {
-	return types.GetCurrentTime().Unix()
-}
\ No newline at end of file
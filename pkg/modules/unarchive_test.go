@@ -1,7 +1,12 @@
 package modules
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
 )
 
 func TestUnarchiveModule(t *testing.T) {
@@ -80,4 +85,115 @@ func TestUnarchiveModule(t *testing.T) {
 			})
 		}
 	})
-}
\ No newline at end of file
+}
+func TestDetectUnarchiveFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"/tmp/app.tar.gz": "tar.gz",
+		"app.TGZ":         "tar.gz",
+		"https://example.com/dl/app.tar.xz?sig=1": "tar.xz",
+		"app.tbz2":    "tar.bz2",
+		"site.zip":    "zip",
+		"dump.sql.gz": "gz",
+		"README":      "",
+	} {
+		if got := detectUnarchiveFormat(name); got != want {
+			t.Errorf("detectUnarchiveFormat(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnarchiveModuleRun(t *testing.T) {
+	module := NewUnarchiveModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	if err := os.WriteFile(archive, []byte("PK\x05\x06"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "StripZip", Args: map[string]interface{}{"src": "/tmp/site.zip", "dest": "/srv", "strip_components": 1}, ExpectValid: false},
+		{Name: "UnknownFormat", Args: map[string]interface{}{"src": "/tmp/site.bin", "dest": "/srv"}, ExpectValid: false},
+		{Name: "ExplicitFormat", Args: map[string]interface{}{"src": "/tmp/site.bin", "dest": "/srv", "format": "tar.gz"}, ExpectValid: true},
+		{Name: "SymbolicMode", Args: map[string]interface{}{"src": "/tmp/site.zip", "dest": "/srv", "mode": "a+r"}, ExpectValid: false},
+	})
+
+	stage := "stage=/srv/.gosible-unarchive.k2P\n"
+	install := `^mkdir -p '/srv/app' && cd '/srv/\.gosible-unarchive\.k2P' && tar -cf - --no-recursion -T '/srv/\.gosible-unarchive\.k2P\.changed' \| tar -xpf - -C '/srv/app'`
+	cleanup := `rm -rf '/srv/.gosible-unarchive.k2P' '/srv/.gosible-unarchive.k2P.changed'`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "ExtractChanged",
+			Args:     map[string]interface{}{"src": "/tmp/app-1.4.tar.gz", "dest": "/srv/app", "remote_src": true, "strip_components": 1, "exclude": []interface{}{"*.md"}, "list_files": true},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^dest='/srv/app'; stage=\$\(mktemp -d .*; tar -xzf '/tmp/app-1\.4\.tar\.gz' -C "\$stage" '--strip-components=1' '--exclude=\*\.md' \|\| .*sha256sum`,
+					&testhelper.CommandResponse{Stdout: stage + "file=bin\nfile=bin/app\nchanged=bin/app\nfile=etc\nfile=etc/app.conf\nnew=etc/app.conf\n"})
+				h.GetConnection().ExpectCommandPattern(install, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Extracted 2 of 4 entries from /tmp/app-1.4.tar.gz to /srv/app")
+				h.AssertDiffBefore(result, "bin/app\n")
+				h.AssertDiffAfter(result, "bin/app\netc/app.conf\n")
+				if files, _ := result.Data["files"].([]string); len(files) != 4 {
+					t.Errorf("expected the archive entries to be listed, got %v", result.Data["files"])
+				}
+			},
+		},
+		{
+			Name: "AlreadyExtracted",
+			Args: map[string]interface{}{"src": "/tmp/app.tar.xz", "dest": "/srv/app", "remote_src": true, "owner": "app", "mode": "0750"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`tar -xJf '/tmp/app\.tar\.xz' .*chmod 0750 \{\} \+ && chown -hR 'app' "\$stage"; \} .*stat -c '%a %U %G'`, &testhelper.CommandResponse{Stdout: stage + "file=bin\nfile=bin/app\n"})
+				h.GetConnection().ExpectCommand(cleanup, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`tar -cf`, 0)
+			},
+		},
+		{
+			Name: "Creates",
+			Args: map[string]interface{}{"src": "/tmp/app.tar", "dest": "/srv/app", "remote_src": true, "creates": "/srv/app/bin/app"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if \[ -e '/srv/app/bin/app' \]`, &testhelper.CommandResponse{Stdout: existsMarker})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "UploadFromController",
+			Args: map[string]interface{}{"src": archive, "dest": "/srv/app", "include": []interface{}{"index.html"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommand("mktemp", &testhelper.CommandResponse{Stdout: "/tmp/tmp.Qw3\n"})
+				h.GetConnection().ExpectCommandPattern(`unzip -q -o '/tmp/tmp\.Qw3' 'index\.html' -d "\$stage"`, &testhelper.CommandResponse{Stdout: stage + "file=index.html\nnew=index.html\n"})
+				h.GetConnection().ExpectCommandPattern(install, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`rm -f '/tmp/tmp.Qw3'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				if transfers := h.GetConnection().GetTransfers(); len(transfers) != 1 || transfers[0] != "/tmp/tmp.Qw3" {
+					t.Errorf("expected the archive to be uploaded, got %v", transfers)
+				}
+			},
+		},
+		{
+			Name:      "CheckModeRemote",
+			Args:      map[string]interface{}{"src": "https://example.com/app.tar.gz", "dest": "/srv/app"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^tmp=\$\(mktemp\) && curl .* -- 'https://example\.com/app\.tar\.gz'`, &testhelper.CommandResponse{Stdout: "/tmp/tmp.Zx1"})
+				h.GetConnection().ExpectCommandPattern(`tar -xzf '/tmp/tmp\.Zx1'`, &testhelper.CommandResponse{Stdout: stage + "file=bin\nnew=bin\n"})
+				h.GetConnection().ExpectCommand(cleanup, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommand(`rm -f '/tmp/tmp.Zx1'`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+			},
+		},
+	})
+}
@@ -25,6 +25,12 @@ func NewUserModule() *UserModule {
 
 // Run executes the user module
 func (m *UserModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	return m.ExecuteWithTiming(ctx, conn, args, func() (*types.Result, error) {
+		return m.run(ctx, conn, args)
+	})
+}
+
+func (m *UserModule) run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
 	// Get arguments
 	name, _ := args["name"].(string)
 	state, _ := args["state"].(string)
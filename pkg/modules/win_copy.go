@@ -0,0 +1,265 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// windowsPathPattern matches absolute Windows paths: drive paths such as
+// C:\app and UNC paths such as \\server\share\app
+var windowsPathPattern = regexp.MustCompile(`^([A-Za-z]:\\|\\\\[^\\]+\\[^\\]+)`)
+
+// winCopyDiffLimit is the largest file whose content is shown in diffs
+const winCopyDiffLimit = 64 * 1024
+
+// windowsPath normalises slashes in a Windows path and reports whether it is
+// absolute
+func windowsPath(path string) (string, bool) {
+	path = strings.ReplaceAll(path, "/", `\`)
+	return path, windowsPathPattern.MatchString(path)
+}
+
+// windowsFile is a path on a Windows host as the inspect script reports it
+type windowsFile struct {
+	Exists    bool   `json:"exists"`
+	Directory bool   `json:"directory"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum"`
+	Content   []byte `json:"content"` // Only read for diffs of small files
+}
+
+// WinCopyModule copies files to Windows hosts, from the controller or
+// between paths and shares the host can reach
+type WinCopyModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinCopyModule creates a new win_copy module instance
+func NewWinCopyModule() *WinCopyModule {
+	doc := types.ModuleDoc{
+		Name:        "win_copy",
+		Description: "Copy a file from the controller, inline content or a path on the Windows host, including UNC paths, to a local or UNC destination, replacing it only when the SHA-256 checksums differ",
+		Parameters: map[string]types.ParamDoc{
+			"dest": {
+				Description: "Absolute destination, e.g. C:\\app\\app.conf or \\\\server\\share\\app.conf; a trailing backslash copies src into that directory",
+				Required:    true,
+				Type:        "string",
+			},
+			"src": {
+				Description: "File on the controller, or on the host or a share it reaches when remote_src is set",
+				Required:    false,
+				Type:        "string",
+			},
+			"content": {
+				Description: "Content to write instead of copying src",
+				Required:    false,
+				Type:        "string",
+			},
+			"remote_src": {
+				Description: "src is a path on the Windows host, such as a UNC path, rather than on the controller",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"force": {
+				Description: "Replace dest when its content differs; when false dest is only written if it does not exist",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+		},
+		Examples: []string{
+			"- name: Deploy the application configuration\n  win_copy:\n    src: files/app.conf\n    dest: C:\\app\\app.conf",
+			"- name: Fetch the installer from the software share\n  win_copy:\n    src: \\\\fileserver\\software\\app\\setup.msi\n    dest: C:\\Temp\\\n    remote_src: true",
+		},
+		Returns: map[string]string{
+			"dest":     "Destination path",
+			"src":      "Source path, when copying a file",
+			"checksum": "SHA-256 checksum of the destination",
+			"size":     "Size of the destination in bytes",
+		},
+	}
+
+	base := NewBaseModule("win_copy", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "windows",
+	})
+
+	return &WinCopyModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinCopyModule) Validate(args map[string]interface{}) error {
+	dest := m.GetStringArg(args, "dest", "")
+	if dest == "" {
+		return types.NewValidationError("dest", nil, "required parameter")
+	}
+	if _, ok := windowsPath(dest); !ok {
+		return types.NewValidationError("dest", dest, "dest must be an absolute drive or UNC path")
+	}
+
+	src := m.GetStringArg(args, "src", "")
+	_, hasContent := args["content"]
+	if (src == "") == !hasContent {
+		return types.NewValidationError("src", src, "exactly one of src and content is required")
+	}
+	if m.GetBoolArg(args, "remote_src", false) {
+		if hasContent {
+			return types.NewValidationError("remote_src", true, "remote_src requires src")
+		}
+		if _, ok := windowsPath(src); !ok {
+			return types.NewValidationError("src", src, "a remote src must be an absolute drive or UNC path")
+		}
+	}
+	if hasContent && strings.HasSuffix(dest, `\`) {
+		return types.NewValidationError("dest", dest, "dest must name a file when content is given")
+	}
+	return nil
+}
+
+// Run executes the win_copy module
+func (m *WinCopyModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	dest, _ := windowsPath(m.GetStringArg(args, "dest", ""))
+	src := m.GetStringArg(args, "src", "")
+	remote := m.GetBoolArg(args, "remote_src", false)
+	force := m.GetBoolArg(args, "force", true)
+
+	if remote {
+		src, _ = windowsPath(src)
+	}
+	if strings.HasSuffix(dest, `\`) {
+		dest += src[strings.LastIndexAny(src, `\/`)+1:]
+	}
+
+	// The source is read on the controller, or inspected on the host
+	var source, current windowsFile
+	if remote {
+		files, err := m.inspect(ctx, conn, false, dest, src)
+		if err != nil {
+			return nil, err
+		}
+		if current, source = files[0], files[1]; !source.Exists || source.Directory {
+			return nil, fmt.Errorf("remote src %s is not a file", src)
+		}
+	} else {
+		var content []byte
+		if value, ok := args["content"]; ok {
+			content = []byte(types.ConvertToString(value))
+		} else {
+			var err error
+			if content, err = os.ReadFile(src); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", src, err)
+			}
+		}
+		sum := sha256.Sum256(content)
+		source = windowsFile{Exists: true, Size: int64(len(content)), Checksum: hex.EncodeToString(sum[:]), Content: content}
+
+		files, err := m.inspect(ctx, conn, diffMode, dest)
+		if err != nil {
+			return nil, err
+		}
+		current = files[0]
+	}
+	if current.Directory {
+		return nil, fmt.Errorf("dest %s is a directory; end it with a backslash to copy into it", dest)
+	}
+
+	data := map[string]interface{}{"dest": dest, "checksum": current.Checksum, "size": current.Size}
+	if src != "" {
+		data["src"] = src
+	}
+	result := m.CreateSuccessResult(hostname, false, "File is already in desired state", data)
+
+	change := ""
+	switch {
+	case !current.Exists:
+		change = "created " + dest
+	case force && !strings.EqualFold(current.Checksum, source.Checksum):
+		change = "updated " + dest
+	}
+
+	if change != "" {
+		data["checksum"] = source.Checksum
+		data["size"] = source.Size
+	}
+	if change != "" && !checkMode {
+		if remote {
+			script := fmt.Sprintf("New-Item -ItemType Directory -Path (Split-Path -Parent %s) -Force | Out-Null\nCopy-Item -LiteralPath %s -Destination %s -Force",
+				m.ps.quote(dest), m.ps.quote(src), m.ps.quote(dest))
+			if _, err := m.ps.run(ctx, conn, "copying "+src, script); err != nil {
+				return nil, err
+			}
+		} else if err := conn.Copy(ctx, bytes.NewReader(source.Content), dest, 0); err != nil {
+			return nil, fmt.Errorf("copying to %s failed: %w", dest, err)
+		}
+	}
+
+	before, after := renderWindowsFiles(current, source)
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// inspect reports whether each path exists and the checksum of files. In
+// diff mode the content of small files is read as well.
+func (m *WinCopyModule) inspect(ctx context.Context, conn types.Connection, diffMode bool, paths ...string) ([]windowsFile, error) {
+	script := fmt.Sprintf(`$content = %s
+$files = @(%s | ForEach-Object {
+    $state = @{ exists = $false; directory = $false }
+    if (Test-Path -LiteralPath $_) {
+        $item = Get-Item -LiteralPath $_ -Force
+        $state.exists = $true
+        $state.directory = $item.PSIsContainer
+        if (-not $item.PSIsContainer) {
+            $state.size = $item.Length
+            $state.checksum = (Get-FileHash -LiteralPath $_ -Algorithm SHA256).Hash.ToLower()
+            if ($content -and $item.Length -le %d) { $state.content = [Convert]::ToBase64String([IO.File]::ReadAllBytes($_)) }
+        }
+    }
+    $state
+})
+ConvertTo-Json -InputObject $files -Compress`, m.ps.literal(diffMode), m.ps.literal(paths), winCopyDiffLimit)
+
+	var files []windowsFile
+	if err := m.ps.query(ctx, conn, "inspecting "+strings.Join(paths, " and "), script, &files); err != nil {
+		return nil, err
+	}
+	if len(files) != len(paths) {
+		return nil, fmt.Errorf("inspecting %s returned %d results", strings.Join(paths, " and "), len(files))
+	}
+	return files, nil
+}
+
+// renderWindowsFiles renders the destination before and after the copy for
+// diffs: their content when both are small text, otherwise their checksums
+func renderWindowsFiles(before, after windowsFile) (string, string) {
+	text := (!before.Exists || before.Content != nil && utf8.Valid(before.Content)) &&
+		after.Content != nil && len(after.Content) <= winCopyDiffLimit && utf8.Valid(after.Content)
+	render := func(file windowsFile) string {
+		switch {
+		case !file.Exists:
+			return ""
+		case text:
+			return strings.ReplaceAll(string(file.Content), "\r\n", "\n")
+		default:
+			return fmt.Sprintf("sha256: %s\nsize: %d\n", file.Checksum, file.Size)
+		}
+	}
+	return render(before), render(after)
+}
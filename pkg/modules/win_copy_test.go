@@ -0,0 +1,107 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestWindowsPath(t *testing.T) {
+	for _, path := range []string{`C:\app`, `d:/data/file.txt`, `\\server\share\file`, `\\?\C:\long`} {
+		if _, ok := windowsPath(path); !ok {
+			t.Errorf("expected %s to be absolute", path)
+		}
+	}
+	for _, path := range []string{`app\file`, `C:app`, `\\server`, `/etc/passwd`} {
+		if _, ok := windowsPath(path); ok {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+}
+
+func TestWinCopyModule(t *testing.T) {
+	module := NewWinCopyModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidContent", Args: map[string]interface{}{"content": "x", "dest": `C:\app\x.txt`}, ExpectValid: true},
+		{Name: "ValidUNC", Args: map[string]interface{}{"src": `\\fs\share\setup.msi`, "dest": `C:\Temp\`, "remote_src": true}, ExpectValid: true},
+		{Name: "RelativeDest", Args: map[string]interface{}{"content": "x", "dest": `app\x.txt`}, ExpectValid: false},
+		{Name: "SrcAndContent", Args: map[string]interface{}{"src": "a", "content": "x", "dest": `C:\x`}, ExpectValid: false},
+		{Name: "RelativeRemoteSrc", Args: map[string]interface{}{"src": "setup.msi", "dest": `C:\x`, "remote_src": true}, ExpectValid: false},
+		{Name: "ContentIntoDirectory", Args: map[string]interface{}{"content": "x", "dest": `C:\Temp\`}, ExpectValid: false},
+	})
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(src, []byte("port=80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "UpdateFromController",
+			Args:     map[string]interface{}{"src": src, "dest": `C:\app\`},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`\$content = \$true\n\$files = @\(@\('C:\\app\\app.conf'\)`, &testhelper.CommandResponse{
+					Stdout: `[{"exists":true,"directory":false,"size":8,"checksum":"00","content":"cG9ydD04MQ0K"}]`,
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, `Updated C:\app\app.conf`)
+				h.AssertDiffAfter(result, "port=80\n")
+				if transfers := h.GetConnection().GetTransfers(); len(transfers) != 1 || transfers[0] != `C:\app\app.conf` {
+					t.Errorf("expected app.conf to be transferred, got %v", transfers)
+				}
+			},
+		},
+		{
+			Name: "ContentUnchanged",
+			Args: map[string]interface{}{"content": "port=80\n", "dest": `C:\app\app.conf`},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-FileHash`, &testhelper.CommandResponse{
+					Stdout: `[{"exists":true,"directory":false,"size":8,"checksum":"8AC56BA2B165FCD437CA405EF420A36CCBDA0F41CE603A07DB42752FF00335A2"}]`,
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "RemoteFromShare",
+			Args: map[string]interface{}{"src": `\\fs\software\setup.msi`, "dest": `C:\Temp\`, "remote_src": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`@\('C:\\Temp\\setup.msi', '\\\\fs\\software\\setup.msi'\)`, &testhelper.CommandResponse{
+					Stdout: `[{"exists":false,"directory":false},{"exists":true,"directory":false,"size":1024,"checksum":"ab"}]`,
+				})
+				h.GetConnection().ExpectCommandPattern(`Copy-Item -LiteralPath '\\\\fs\\software\\setup.msi' -Destination 'C:\\Temp\\setup.msi' -Force$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, `Created C:\Temp\setup.msi`)
+				h.AssertDataValue(result, "checksum", "ab")
+			},
+		},
+		{
+			Name: "NoForceKeepsExisting",
+			Args: map[string]interface{}{"content": "new", "dest": `C:\app\app.conf`, "force": false},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-FileHash`, &testhelper.CommandResponse{Stdout: `[{"exists":true,"directory":false,"size":3,"checksum":"00"}]`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "DestIsDirectory",
+			Args:        map[string]interface{}{"content": "x", "dest": `C:\app`},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-FileHash`, &testhelper.CommandResponse{Stdout: `[{"exists":true,"directory":true}]`})
+			},
+		},
+	})
+}
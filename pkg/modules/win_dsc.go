@@ -0,0 +1,135 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// WinDSCModule applies PowerShell DSC resources through Invoke-DscResource,
+// so any resource in the DSC ecosystem can be used as a task
+type WinDSCModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinDSCModule creates a new win_dsc module instance
+func NewWinDSCModule() *WinDSCModule {
+	doc := types.ModuleDoc{
+		Name:        "win_dsc",
+		Description: "Invoke a PowerShell DSC resource, testing it first and setting it only when the host is not in the desired state",
+		Parameters: map[string]types.ParamDoc{
+			"resource_name": {
+				Description: "DSC resource name, e.g. File, Registry or xWebsite",
+				Required:    true,
+				Type:        "string",
+			},
+			"module_name": {
+				Description: "Module providing the resource",
+				Required:    false,
+				Type:        "string",
+				Default:     "PSDesiredStateConfiguration",
+			},
+			"module_version": {
+				Description: "Exact module version, when several are installed",
+				Required:    false,
+				Type:        "string",
+			},
+			"properties": {
+				Description: "Resource properties. Lists become arrays, dicts become hashtables, and a dict of exactly username and password becomes a PSCredential",
+				Required:    false,
+				Type:        "dict",
+			},
+		},
+		Examples: []string{
+			"- name: Install IIS\n  win_dsc:\n    resource_name: WindowsFeature\n    properties:\n      Name: Web-Server\n      Ensure: Present",
+			"- name: Create a site\n  win_dsc:\n    resource_name: WebSite\n    module_name: WebAdministrationDsc\n    module_version: 4.1.0\n    properties:\n      Name: app\n      PhysicalPath: C:\\inetpub\\app\n      State: Started",
+		},
+		Returns: map[string]string{
+			"resource_name":    "DSC resource name",
+			"module_name":      "Module providing the resource",
+			"in_desired_state": "Whether the resource was in the desired state before the task",
+			"reboot_required":  "Whether the resource asked for a reboot after Set",
+		},
+	}
+
+	base := NewBaseModule("win_dsc", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     false,
+		Platform:     "windows",
+		RequiresRoot: true,
+	})
+
+	return &WinDSCModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinDSCModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "resource_name", "") == "" {
+		return types.NewValidationError("resource_name", nil, "required parameter")
+	}
+	if props, ok := args["properties"]; ok && props != nil {
+		if _, ok := props.(map[string]interface{}); !ok {
+			return types.NewValidationError("properties", props, "must be a dict of resource properties")
+		}
+	}
+	return nil
+}
+
+// Run executes the win_dsc module
+func (m *WinDSCModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+
+	resource := m.GetStringArg(args, "resource_name", "")
+	moduleName := m.GetStringArg(args, "module_name", "PSDesiredStateConfiguration")
+	properties, _ := args["properties"].(map[string]interface{})
+
+	var tested struct {
+		InDesiredState bool `json:"InDesiredState"`
+	}
+	if err := m.ps.query(ctx, conn, "testing DSC resource "+resource, m.invokeScript(args, "Test", properties), &tested); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"resource_name":    resource,
+		"module_name":      moduleName,
+		"in_desired_state": tested.InDesiredState,
+		"reboot_required":  false,
+	}
+	result := m.CreateSuccessResult(hostname, false, "Resource is already in desired state", data)
+
+	change := ""
+	if !tested.InDesiredState {
+		change = fmt.Sprintf("set DSC resource %s", resource)
+	}
+	if change != "" && !checkMode {
+		var set struct {
+			RebootRequired bool `json:"RebootRequired"`
+		}
+		if err := m.ps.query(ctx, conn, "setting DSC resource "+resource, m.invokeScript(args, "Set", properties), &set); err != nil {
+			return nil, err
+		}
+		data["reboot_required"] = set.RebootRequired
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, false, "", "", startTime), nil
+}
+
+// invokeScript builds the Invoke-DscResource call for a method. A module
+// version is passed as a fully qualified module specification.
+func (m *WinDSCModule) invokeScript(args map[string]interface{}, method string, properties map[string]interface{}) string {
+	module := m.ps.quote(m.GetStringArg(args, "module_name", "PSDesiredStateConfiguration"))
+	if version := m.GetStringArg(args, "module_version", ""); version != "" {
+		module = fmt.Sprintf("@{ModuleName = %s; ModuleVersion = %s}", module, m.ps.quote(version))
+	}
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	return fmt.Sprintf("Invoke-DscResource -Name %s -ModuleName %s -Method %s -Property %s | ConvertTo-Json -Compress",
+		m.ps.quote(m.GetStringArg(args, "resource_name", "")), module, method, m.ps.hashtable(properties))
+}
@@ -0,0 +1,106 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestPowerShellLiteral(t *testing.T) {
+	var ps powerShell
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "$null"},
+		{true, "$true"},
+		{float64(3), "3"},
+		{1.5, "1.5"},
+		{"it's", "'it''s'"},
+		{[]interface{}{"a", 1}, "@('a', 1)"},
+		{map[string]interface{}{"b": "x", "a": false}, "@{'a' = $false; 'b' = 'x'}"},
+		{
+			map[string]interface{}{"username": `CORP\svc`, "password": "p'w"},
+			`(New-Object System.Management.Automation.PSCredential('CORP\svc', (ConvertTo-SecureString 'p''w' -AsPlainText -Force)))`,
+		},
+	}
+	for _, tt := range tests {
+		if got := ps.literal(tt.value); got != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestWinDSCModule(t *testing.T) {
+	module := NewWinDSCModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"resource_name": "WindowsFeature", "properties": map[string]interface{}{"Name": "Web-Server"}}, ExpectValid: true},
+		{Name: "MissingResource", Args: map[string]interface{}{"properties": map[string]interface{}{}}, ExpectValid: false},
+		{Name: "InvalidProperties", Args: map[string]interface{}{"resource_name": "File", "properties": "Name=x"}, ExpectValid: false},
+	})
+
+	feature := map[string]interface{}{
+		"resource_name": "WindowsFeature",
+		"properties":    map[string]interface{}{"Name": "Web-Server", "Ensure": "Present"},
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "SetWhenNotInDesiredState",
+			Args: feature,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Invoke-DscResource -Name 'WindowsFeature' -ModuleName 'PSDesiredStateConfiguration' -Method Test -Property @\{'Ensure' = 'Present'; 'Name' = 'Web-Server'\}`, &testhelper.CommandResponse{Stdout: `{"InDesiredState":false}`})
+				h.GetConnection().ExpectCommandPattern(`-Method Set `, &testhelper.CommandResponse{Stdout: `{"RebootRequired":true}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set DSC resource WindowsFeature")
+				h.AssertDataValue(result, "reboot_required", true)
+			},
+		},
+		{
+			Name: "InDesiredState",
+			Args: feature,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Method Test `, &testhelper.CommandResponse{Stdout: `{"InDesiredState":true}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.AssertDataValue(result, "in_desired_state", true)
+			},
+		},
+		{
+			Name:      "CheckModeOnlyTests",
+			Args:      feature,
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Method Test `, &testhelper.CommandResponse{Stdout: `{"InDesiredState":false}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(`-Method Set `, 0)
+			},
+		},
+		{
+			Name: "ModuleVersion",
+			Args: map[string]interface{}{"resource_name": "WebSite", "module_name": "WebAdministrationDsc", "module_version": "4.1.0"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-ModuleName @\{ModuleName = 'WebAdministrationDsc'; ModuleVersion = '4.1.0'\} -Method Test -Property @\{\}`, &testhelper.CommandResponse{Stdout: `{"InDesiredState":true}`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "ResourceNotFound",
+			Args:        feature,
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Method Test `, &testhelper.CommandResponse{ExitCode: 1, Stderr: "Resource WindowsFeature was not found."})
+			},
+		},
+	})
+}
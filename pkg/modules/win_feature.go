@@ -0,0 +1,202 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// windowsFeaturePattern matches Windows feature names such as Web-Server or
+// RSAT-AD-PowerShell
+var windowsFeaturePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// windowsFeature is a feature as Get-WindowsFeature reports it
+type windowsFeature struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+}
+
+// WinFeatureModule installs and removes Windows Server roles and features
+type WinFeatureModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinFeatureModule creates a new win_feature module instance
+func NewWinFeatureModule() *WinFeatureModule {
+	doc := types.ModuleDoc{
+		Name:        "win_feature",
+		Description: "Install or remove Windows Server roles and features with Install-WindowsFeature, reporting whether a reboot is required",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Feature name or list of names, as Get-WindowsFeature lists them",
+				Required:    true,
+				Type:        "list",
+			},
+			"state": {
+				Description: "Whether the features should be installed",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"include_sub_features": {
+				Description: "Also install the sub-features of the features",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"include_management_tools": {
+				Description: "Also install the management tools of the features",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"source": {
+				Description: "Side-by-side store holding the feature files, e.g. a UNC path to the sources\\sxs directory of the installation media",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Install IIS with its management console\n  win_feature:\n    name: Web-Server\n    include_management_tools: true",
+			"- name: Install .NET 3.5 from the installation media\n  win_feature:\n    name: NET-Framework-Core\n    source: \\\\fileserver\\media\\2022\\sources\\sxs",
+		},
+		Returns: map[string]string{
+			"features":        "Features that were installed or removed",
+			"reboot_required": "Whether a reboot is required to complete the change",
+		},
+	}
+
+	base := NewBaseModule("win_feature", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "windows",
+		RequiresRoot: true,
+	})
+
+	return &WinFeatureModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinFeatureModule) Validate(args map[string]interface{}) error {
+	names := stringList(args["name"])
+	if len(names) == 0 {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	for _, name := range names {
+		if !windowsFeaturePattern.MatchString(name) {
+			return types.NewValidationError("name", name, "invalid feature name")
+		}
+	}
+	return m.ValidateChoices(args, "state", []string{"present", "absent"})
+}
+
+// Run executes the win_feature module
+func (m *WinFeatureModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	names := stringList(args["name"])
+	present := m.GetStringArg(args, "state", "present") == "present"
+
+	features, err := m.inspect(ctx, conn, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	var before, after strings.Builder
+	for _, feature := range features {
+		fmt.Fprintf(&before, "%s: %s\n", feature.Name, featureState(feature.Installed))
+		fmt.Fprintf(&after, "%s: %s\n", feature.Name, featureState(present))
+		if feature.Installed != present {
+			pending = append(pending, feature.Name)
+		}
+	}
+
+	data := map[string]interface{}{"features": pending, "reboot_required": false}
+	result := m.CreateSuccessResult(hostname, false, "Features are already in desired state", data)
+
+	change := ""
+	if len(pending) > 0 {
+		verb := "installed"
+		if !present {
+			verb = "removed"
+		}
+		change = fmt.Sprintf("%s %s", verb, strings.Join(pending, ", "))
+	}
+	if change != "" && !checkMode {
+		var outcome struct {
+			Success        bool   `json:"success"`
+			RebootRequired bool   `json:"reboot_required"`
+			ExitCode       string `json:"exit_code"`
+		}
+		if err := m.ps.query(ctx, conn, "changing features", m.changeScript(args, pending, present), &outcome); err != nil {
+			return nil, err
+		}
+		if !outcome.Success {
+			return nil, fmt.Errorf("changing features %s failed: %s", strings.Join(pending, ", "), outcome.ExitCode)
+		}
+		data["reboot_required"] = outcome.RebootRequired
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before.String(), after.String(), startTime), nil
+}
+
+// inspect returns the install state of each named feature, sorted by name.
+// Get-WindowsFeature silently skips unknown names, which are reported here.
+func (m *WinFeatureModule) inspect(ctx context.Context, conn types.Connection, names []string) ([]windowsFeature, error) {
+	script := fmt.Sprintf("$features = @(Get-WindowsFeature -Name %s | ForEach-Object { @{ name = $_.Name; installed = [bool]$_.Installed } })\n"+
+		"ConvertTo-Json -InputObject $features -Compress", m.ps.literal(names))
+
+	var features []windowsFeature
+	if err := m.ps.query(ctx, conn, "inspecting features", script, &features); err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(features))
+	for _, feature := range features {
+		found[strings.ToLower(feature.Name)] = true
+	}
+	for _, name := range names {
+		if !found[strings.ToLower(name)] {
+			return nil, fmt.Errorf("unknown Windows feature %s", name)
+		}
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i].Name < features[j].Name })
+	return features, nil
+}
+
+// changeScript installs or uninstalls features and prints the outcome
+func (m *WinFeatureModule) changeScript(args map[string]interface{}, names []string, present bool) string {
+	command := "Uninstall-WindowsFeature -Name " + m.ps.literal(names)
+	if present {
+		command = "Install-WindowsFeature -Name " + m.ps.literal(names)
+		if m.GetBoolArg(args, "include_sub_features", false) {
+			command += " -IncludeAllSubFeature"
+		}
+		if m.GetBoolArg(args, "include_management_tools", false) {
+			command += " -IncludeManagementTools"
+		}
+		if source := m.GetStringArg(args, "source", ""); source != "" {
+			command += " -Source " + m.ps.quote(source)
+		}
+	}
+	return fmt.Sprintf("$r = %s\n"+
+		"@{ success = [bool]$r.Success; reboot_required = ($r.RestartNeeded -eq 'Yes'); exit_code = $r.ExitCode.ToString() } | ConvertTo-Json -Compress", command)
+}
+
+// featureState renders whether a feature is installed for diffs
+func featureState(installed bool) string {
+	if installed {
+		return "installed"
+	}
+	return "available"
+}
@@ -0,0 +1,70 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestWinFeatureModule(t *testing.T) {
+	module := NewWinFeatureModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": []interface{}{"Web-Server", "Web-Mgmt-Console"}}, ExpectValid: true},
+		{Name: "ValidAbsent", Args: map[string]interface{}{"name": "Telnet-Client", "state": "absent"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": "Web-Server; Restart-Computer"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "Web-Server", "state": "installed"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "InstallMissing",
+			Args:     map[string]interface{}{"name": []interface{}{"Web-Server", "NET-Framework-Core"}, "include_management_tools": true, "source": `\\fs\media\sxs`},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-WindowsFeature -Name @\('Web-Server', 'NET-Framework-Core'\)`, &testhelper.CommandResponse{
+					Stdout: `[{"name":"Web-Server","installed":true},{"name":"NET-Framework-Core","installed":false}]`,
+				})
+				h.GetConnection().ExpectCommandPattern(`\$r = Install-WindowsFeature -Name @\('NET-Framework-Core'\) -IncludeManagementTools -Source '\\\\fs\\media\\sxs'\n`, &testhelper.CommandResponse{
+					Stdout: `{"success":true,"reboot_required":true,"exit_code":"SuccessRestartRequired"}`,
+				})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Installed NET-Framework-Core")
+				h.AssertDataValue(result, "reboot_required", true)
+				h.AssertDiffAfter(result, "NET-Framework-Core: installed\nWeb-Server: installed\n")
+			},
+		},
+		{
+			Name: "AlreadyRemoved",
+			Args: map[string]interface{}{"name": "Telnet-Client", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-WindowsFeature`, &testhelper.CommandResponse{Stdout: `[{"name":"Telnet-Client","installed":false}]`})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "UnknownFeature",
+			Args:        map[string]interface{}{"name": []interface{}{"Web-Server", "Web-Nonexistent"}},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-WindowsFeature`, &testhelper.CommandResponse{Stdout: `[{"name":"Web-Server","installed":false}]`})
+			},
+		},
+		{
+			Name:        "InstallFails",
+			Args:        map[string]interface{}{"name": "Web-Server"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`Get-WindowsFeature`, &testhelper.CommandResponse{Stdout: `[{"name":"Web-Server","installed":false}]`})
+				h.GetConnection().ExpectCommandPattern(`Install-WindowsFeature`, &testhelper.CommandResponse{Stdout: `{"success":false,"reboot_required":false,"exit_code":"Failed"}`})
+			},
+		},
+	})
+}
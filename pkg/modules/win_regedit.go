@@ -0,0 +1,425 @@
+package modules
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// registryHives maps hive abbreviations and names to the names the
+// Registry:: provider path uses
+var registryHives = map[string]string{
+	"HKLM":                "HKEY_LOCAL_MACHINE",
+	"HKEY_LOCAL_MACHINE":  "HKEY_LOCAL_MACHINE",
+	"HKCU":                "HKEY_CURRENT_USER",
+	"HKEY_CURRENT_USER":   "HKEY_CURRENT_USER",
+	"HKCR":                "HKEY_CLASSES_ROOT",
+	"HKEY_CLASSES_ROOT":   "HKEY_CLASSES_ROOT",
+	"HKU":                 "HKEY_USERS",
+	"HKEY_USERS":          "HKEY_USERS",
+	"HKCC":                "HKEY_CURRENT_CONFIG",
+	"HKEY_CURRENT_CONFIG": "HKEY_CURRENT_CONFIG",
+}
+
+// registryKinds maps the type parameter to .NET RegistryValueKind names
+var registryKinds = map[string]string{
+	"string":       "String",
+	"expandstring": "ExpandString",
+	"binary":       "Binary",
+	"dword":        "DWord",
+	"qword":        "QWord",
+	"multistring":  "MultiString",
+	"none":         "None",
+}
+
+// registryPath converts HKLM:\Software\App, HKLM\Software\App or
+// HKEY_LOCAL_MACHINE\Software\App to a Registry:: provider path, which
+// reaches every hive rather than just the ones mapped as drives
+func registryPath(path string) (string, error) {
+	path = strings.TrimPrefix(strings.ReplaceAll(path, "/", `\`), "Registry::")
+	hive, rest, _ := strings.Cut(path, `\`)
+	hive = strings.TrimSuffix(hive, ":")
+
+	full, ok := registryHives[strings.ToUpper(hive)]
+	if !ok {
+		return "", fmt.Errorf("unknown registry hive %q", hive)
+	}
+	if rest = strings.Trim(rest, `\`); rest != "" {
+		full += `\` + rest
+	}
+	return "Registry::" + full, nil
+}
+
+// registryValue is a value's type and its data in a canonical text form:
+// decimal numbers, lower-case comma-separated hex bytes and newline-joined
+// strings
+type registryValue struct {
+	kind string
+	data string
+}
+
+func (v registryValue) String() string {
+	return v.kind + ":" + v.data
+}
+
+// registryData canonicalizes the data argument for a value type
+func registryData(kind string, data interface{}) (string, error) {
+	switch kind {
+	case "dword", "qword":
+		max := uint64(math.MaxUint32)
+		if kind == "qword" {
+			max = math.MaxUint64
+		}
+		var n uint64
+		var err error
+		switch v := data.(type) {
+		case nil:
+		case int:
+			if v < 0 {
+				return "", fmt.Errorf("%s data must not be negative", kind)
+			}
+			n = uint64(v)
+		case float64:
+			if v < 0 || v != math.Trunc(v) {
+				return "", fmt.Errorf("%s data must be a non-negative integer", kind)
+			}
+			n = uint64(v)
+		default:
+			s := strings.TrimSpace(types.ConvertToString(v))
+			if strings.HasPrefix(strings.ToLower(s), "0x") {
+				n, err = strconv.ParseUint(s[2:], 16, 64)
+			} else {
+				n, err = strconv.ParseUint(s, 10, 64)
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid %s data %q", kind, s)
+			}
+		}
+		if n > max {
+			return "", fmt.Errorf("%d does not fit in a %s", n, kind)
+		}
+		return strconv.FormatUint(n, 10), nil
+	case "binary":
+		var raw []byte
+		switch v := data.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range v {
+				b, err := strconv.ParseUint(strings.ToLower(types.ConvertToString(item)), 0, 8)
+				if err != nil {
+					return "", fmt.Errorf("invalid binary byte %v", item)
+				}
+				raw = append(raw, byte(b))
+			}
+		default:
+			s := strings.TrimPrefix(strings.ToLower(types.ConvertToString(v)), "hex:")
+			s = strings.NewReplacer(",", "", " ", "", "0x", "").Replace(s)
+			var err error
+			if raw, err = hex.DecodeString(s); err != nil {
+				return "", fmt.Errorf("invalid binary data: %v", err)
+			}
+		}
+		bytes := make([]string, len(raw))
+		for i, b := range raw {
+			bytes[i] = fmt.Sprintf("%02x", b)
+		}
+		return strings.Join(bytes, ","), nil
+	case "multistring":
+		return strings.Join(stringList(data), "\n"), nil
+	case "none":
+		return "", nil
+	default:
+		if data == nil {
+			return "", nil
+		}
+		return types.ConvertToString(data), nil
+	}
+}
+
+// registryState is what the inspection script reports
+type registryState struct {
+	Key   bool            `json:"key"`
+	Value bool            `json:"value"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// value canonicalizes the reported value. PowerShell reports DWORDs and
+// QWORDs as signed integers.
+func (s registryState) value() (registryValue, error) {
+	for kind, name := range registryKinds {
+		if name != s.Type {
+			continue
+		}
+		switch kind {
+		case "dword", "qword":
+			n, err := strconv.ParseInt(string(s.Data), 10, 64)
+			if err != nil {
+				return registryValue{}, fmt.Errorf("unexpected %s data %s", kind, s.Data)
+			}
+			if kind == "dword" {
+				return registryValue{kind, strconv.FormatUint(uint64(uint32(int32(n))), 10)}, nil
+			}
+			return registryValue{kind, strconv.FormatUint(uint64(n), 10)}, nil
+		case "multistring":
+			var items []string
+			if err := json.Unmarshal(s.Data, &items); err != nil {
+				var item string
+				json.Unmarshal(s.Data, &item)
+				items = []string{item}
+			}
+			return registryValue{kind, strings.Join(items, "\n")}, nil
+		case "none":
+			return registryValue{kind, ""}, nil
+		default:
+			var data string
+			json.Unmarshal(s.Data, &data)
+			return registryValue{kind, data}, nil
+		}
+	}
+	return registryValue{strings.ToLower(s.Type), string(s.Data)}, nil
+}
+
+// WinRegeditModule manages Windows registry keys and values
+type WinRegeditModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinRegeditModule creates a new win_regedit module instance
+func NewWinRegeditModule() *WinRegeditModule {
+	doc := types.ModuleDoc{
+		Name:        "win_regedit",
+		Description: "Manage Windows registry keys and values, with typed data and diffs of the old and new value",
+		Parameters: map[string]types.ParamDoc{
+			"path": {
+				Description: "Registry key, e.g. HKLM:\\Software\\App, HKLM\\Software\\App or HKEY_LOCAL_MACHINE\\Software\\App",
+				Required:    true,
+				Type:        "string",
+			},
+			"name": {
+				Description: "Value name; an empty string is the key's default value. When omitted only the key is managed",
+				Required:    false,
+				Type:        "string",
+			},
+			"data": {
+				Description: "Value data: a string, an integer or 0x-prefixed hex string for dword and qword, a list for multistring, and hex bytes or a list of bytes for binary",
+				Required:    false,
+				Type:        "raw",
+			},
+			"type": {
+				Description: "Value type",
+				Required:    false,
+				Type:        "string",
+				Default:     "string",
+				Choices:     []string{"string", "expandstring", "binary", "dword", "qword", "multistring", "none"},
+			},
+			"state": {
+				Description: "Whether the value, or the key and all its subkeys when no name is given, should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+		},
+		Examples: []string{
+			"- name: Disable SMBv1\n  win_regedit:\n    path: HKLM:\\SYSTEM\\CurrentControlSet\\Services\\LanmanServer\\Parameters\n    name: SMB1\n    type: dword\n    data: 0",
+			"- name: Remove the application's settings\n  win_regedit:\n    path: HKCU:\\Software\\App\n    state: absent",
+		},
+		Returns: map[string]string{
+			"path": "Registry:: provider path of the key",
+			"name": "Value name",
+			"type": "Value type",
+			"data": "Value data in canonical form",
+		},
+	}
+
+	base := NewBaseModule("win_regedit", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode: true,
+		DiffMode:  true,
+		Platform:  "windows",
+	})
+
+	return &WinRegeditModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinRegeditModule) Validate(args map[string]interface{}) error {
+	path := m.GetStringArg(args, "path", "")
+	if path == "" {
+		return types.NewValidationError("path", nil, "required parameter")
+	}
+	if _, err := registryPath(path); err != nil {
+		return types.NewValidationError("path", path, err.Error())
+	}
+	if err := m.ValidateChoices(args, "type", []string{"string", "expandstring", "binary", "dword", "qword", "multistring", "none"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+
+	kind := m.GetStringArg(args, "type", "string")
+	if _, err := registryData(kind, args["data"]); err != nil {
+		return types.NewValidationError("data", args["data"], err.Error())
+	}
+	return nil
+}
+
+// Run executes the win_regedit module
+func (m *WinRegeditModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	path, err := registryPath(m.GetStringArg(args, "path", ""))
+	if err != nil {
+		return nil, err
+	}
+	name, hasName := args["name"]
+	valueName := types.ConvertToString(name)
+	hasName = hasName && name != nil
+	kind := m.GetStringArg(args, "type", "string")
+	desiredData, err := registryData(kind, args["data"])
+	if err != nil {
+		return nil, err
+	}
+	desired := registryValue{kind, desiredData}
+	state := m.GetStringArg(args, "state", "present")
+
+	current, err := m.inspect(ctx, conn, path, valueName, hasName)
+	if err != nil {
+		return nil, err
+	}
+	var currentValue registryValue
+	if current.Value {
+		if currentValue, err = current.value(); err != nil {
+			return nil, err
+		}
+	}
+
+	before := renderRegistry(path, valueName, current.Key, current.Value, currentValue)
+	after := before
+	change, script := "", ""
+	switch {
+	case state == "absent" && hasName && current.Value:
+		change = fmt.Sprintf("removed value %q from %s", valueName, path)
+		script = fmt.Sprintf("Remove-ItemProperty -LiteralPath %s -Name %s", m.ps.quote(path), m.ps.quote(valueName))
+		after = renderRegistry(path, valueName, true, false, registryValue{})
+	case state == "absent" && !hasName && current.Key:
+		change = "removed key " + path
+		script = fmt.Sprintf("Remove-Item -LiteralPath %s -Recurse -Force", m.ps.quote(path))
+		after = ""
+	case state == "present" && hasName && (!current.Value || currentValue != desired):
+		change = fmt.Sprintf("set %q to %s in %s", valueName, desired, path)
+		script = m.createKeyScript(path) + fmt.Sprintf("New-ItemProperty -LiteralPath %s -Name %s -PropertyType %s -Value %s -Force | Out-Null",
+			m.ps.quote(path), m.ps.quote(valueName), registryKinds[kind], m.valueLiteral(desired))
+		after = renderRegistry(path, valueName, true, true, desired)
+	case state == "present" && !hasName && !current.Key:
+		change = "created key " + path
+		script = m.createKeyScript(path)
+		after = renderRegistry(path, "", true, false, registryValue{})
+	}
+
+	data := map[string]interface{}{"path": path}
+	if hasName {
+		data["name"] = valueName
+		data["type"] = kind
+		data["data"] = desiredData
+		if state == "absent" || (!current.Value && checkMode) {
+			delete(data, "data")
+		}
+	}
+	result := m.CreateSuccessResult(hostname, false, "Registry is already in desired state", data)
+
+	if change != "" && !checkMode {
+		if _, err := m.ps.run(ctx, conn, "updating "+path, script); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// inspect reports whether the key and value exist, and the value's type and
+// data
+func (m *WinRegeditModule) inspect(ctx context.Context, conn types.Connection, path, name string, hasName bool) (registryState, error) {
+	nameLiteral := "$null"
+	if hasName {
+		nameLiteral = m.ps.quote(name)
+	}
+	script := fmt.Sprintf(`$path = %s
+$name = %s
+$state = @{ key = $false; value = $false }
+if (Test-Path -LiteralPath $path) {
+    $state.key = $true
+    $item = Get-Item -LiteralPath $path
+    if ($null -ne $name -and $item.GetValueNames() -contains $name) {
+        $state.value = $true
+        $state.type = $item.GetValueKind($name).ToString()
+        $data = $item.GetValue($name, $null, 'DoNotExpandEnvironmentNames')
+        if ($data -is [byte[]]) { $data = ($data | ForEach-Object { '{0:x2}' -f $_ }) -join ',' }
+        $state.data = $data
+    }
+}
+$state | ConvertTo-Json -Compress`, m.ps.quote(path), nameLiteral)
+
+	var state registryState
+	err := m.ps.query(ctx, conn, "inspecting "+path, script, &state)
+	return state, err
+}
+
+func (m *WinRegeditModule) createKeyScript(path string) string {
+	return fmt.Sprintf("if (-not (Test-Path -LiteralPath %s)) { New-Item -Path %s -Force | Out-Null }\n", m.ps.quote(path), m.ps.quote(path))
+}
+
+// valueLiteral renders canonical data as the value New-ItemProperty takes.
+// DWORDs and QWORDs above the signed range are passed as their two's
+// complement, which is how the registry API stores them.
+func (m *WinRegeditModule) valueLiteral(value registryValue) string {
+	switch value.kind {
+	case "dword":
+		n, _ := strconv.ParseUint(value.data, 10, 32)
+		return strconv.FormatInt(int64(int32(uint32(n))), 10)
+	case "qword":
+		n, _ := strconv.ParseUint(value.data, 10, 64)
+		return strconv.FormatInt(int64(n), 10)
+	case "binary", "none":
+		if value.data == "" {
+			return "([byte[]]@())"
+		}
+		bytes := strings.Split(value.data, ",")
+		for i := range bytes {
+			bytes[i] = "0x" + bytes[i]
+		}
+		return "([byte[]](" + strings.Join(bytes, ",") + "))"
+	case "multistring":
+		if value.data == "" {
+			return "([string[]]@())"
+		}
+		return "([string[]]" + m.ps.literal(strings.Split(value.data, "\n")) + ")"
+	default:
+		return m.ps.quote(value.data)
+	}
+}
+
+// renderRegistry renders a key and value for diffs, in the style of a .reg
+// file
+func renderRegistry(path, name string, key, exists bool, value registryValue) string {
+	if !key {
+		return ""
+	}
+	out := "[" + strings.TrimPrefix(path, "Registry::") + "]\n"
+	if exists {
+		out += fmt.Sprintf("%q=%s\n", name, value)
+	}
+	return out
+}
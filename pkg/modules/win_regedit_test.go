@@ -0,0 +1,167 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestRegistryPath(t *testing.T) {
+	tests := map[string]string{
+		`HKLM:\Software\App`:               `Registry::HKEY_LOCAL_MACHINE\Software\App`,
+		`HKLM\Software\App\`:               `Registry::HKEY_LOCAL_MACHINE\Software\App`,
+		`hkcu:/Software/App`:               `Registry::HKEY_CURRENT_USER\Software\App`,
+		`HKEY_USERS\.DEFAULT`:              `Registry::HKEY_USERS\.DEFAULT`,
+		`Registry::HKEY_CLASSES_ROOT\.txt`: `Registry::HKEY_CLASSES_ROOT\.txt`,
+		`HKCC`:                             `Registry::HKEY_CURRENT_CONFIG`,
+	}
+	for path, expected := range tests {
+		if got, err := registryPath(path); err != nil || got != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", path, expected, got, err)
+		}
+	}
+	if _, err := registryPath(`HKXX:\Software`); err == nil {
+		t.Error("expected an unknown hive to be rejected")
+	}
+}
+
+func TestRegistryData(t *testing.T) {
+	tests := []struct {
+		kind     string
+		data     interface{}
+		expected string
+	}{
+		{"dword", 1, "1"},
+		{"dword", "0xffffffff", "4294967295"},
+		{"qword", float64(1 << 40), "1099511627776"},
+		{"binary", "DE,AD,be,ef", "de,ad,be,ef"},
+		{"binary", "hex:0102", "01,02"},
+		{"binary", []interface{}{1, "0xff"}, "01,ff"},
+		{"multistring", []interface{}{"a", "b"}, "a\nb"},
+		{"multistring", "a", "a"},
+		{"string", nil, ""},
+		{"expandstring", "%SystemRoot%\\app", "%SystemRoot%\\app"},
+	}
+	for _, tt := range tests {
+		if got, err := registryData(tt.kind, tt.data); err != nil || got != tt.expected {
+			t.Errorf("%s %v: expected %q, got %q (%v)", tt.kind, tt.data, tt.expected, got, err)
+		}
+	}
+
+	for _, invalid := range []struct {
+		kind string
+		data interface{}
+	}{{"dword", "0x100000000"}, {"dword", -1}, {"qword", "many"}, {"binary", "xyz"}} {
+		if _, err := registryData(invalid.kind, invalid.data); err == nil {
+			t.Errorf("%s %v: expected an error", invalid.kind, invalid.data)
+		}
+	}
+
+	// PowerShell reports DWORDs as signed integers
+	state := registryState{Value: true, Type: "DWord", Data: []byte("-1")}
+	if value, err := state.value(); err != nil || value.String() != "dword:4294967295" {
+		t.Errorf("expected the DWORD to be read unsigned, got %s (%v)", value, err)
+	}
+}
+
+func TestWinRegeditModule(t *testing.T) {
+	module := NewWinRegeditModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidValue", Args: map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Port", "type": "dword", "data": 8080}, ExpectValid: true},
+		{Name: "ValidKey", Args: map[string]interface{}{"path": `HKCU\Software\App`, "state": "absent"}, ExpectValid: true},
+		{Name: "MissingPath", Args: map[string]interface{}{"name": "Port"}, ExpectValid: false},
+		{Name: "UnknownHive", Args: map[string]interface{}{"path": `HKXX:\Software`}, ExpectValid: false},
+		{Name: "InvalidType", Args: map[string]interface{}{"path": `HKLM:\Software`, "type": "float"}, ExpectValid: false},
+		{Name: "InvalidData", Args: map[string]interface{}{"path": `HKLM:\Software`, "name": "Port", "type": "dword", "data": "eighty"}, ExpectValid: false},
+	})
+
+	inspect := func(state string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`\$path = 'Registry::HKEY_LOCAL_MACHINE\\Software\\App'`, &testhelper.CommandResponse{Stdout: state})
+		}
+	}
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "SetValue",
+			Args:     map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Flags", "type": "dword", "data": "0xffffffff"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":true,"value":true,"type":"DWord","data":0}`)(h)
+				h.GetConnection().ExpectCommandPattern(`New-ItemProperty -LiteralPath 'Registry::HKEY_LOCAL_MACHINE\\Software\\App' -Name 'Flags' -PropertyType DWord -Value -1 -Force`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertDiffAfter(result, "[HKEY_LOCAL_MACHINE\\Software\\App]\n\"Flags\"=dword:4294967295\n")
+				h.AssertDataValue(result, "data", "4294967295")
+			},
+		},
+		{
+			Name: "ValueUnchanged",
+			Args: map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Servers", "type": "multistring", "data": []interface{}{"a", "b"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":true,"value":true,"type":"MultiString","data":["a","b"]}`)(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "TypeChange",
+			Args: map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Port", "type": "dword", "data": 80},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":true,"value":true,"type":"String","data":"80"}`)(h)
+				h.GetConnection().ExpectCommandPattern(`-PropertyType DWord -Value 80 -Force`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name:      "CreateBinaryInCheckMode",
+			Args:      map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Blob", "type": "binary", "data": "de,ad"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":false,"value":false}`)(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, `Would have set "Blob" to binary:de,ad in Registry::HKEY_LOCAL_MACHINE\Software\App`)
+			},
+		},
+		{
+			Name: "RemoveKey",
+			Args: map[string]interface{}{"path": `HKLM:\Software\App`, "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":true,"value":false}`)(h)
+				h.GetConnection().ExpectCommandPattern(`Remove-Item -LiteralPath 'Registry::HKEY_LOCAL_MACHINE\\Software\\App' -Recurse -Force`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, `Removed key Registry::HKEY_LOCAL_MACHINE\Software\App`)
+			},
+		},
+		{
+			Name: "RemoveMissingValue",
+			Args: map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Gone", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":false,"value":false}`)(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:        "AccessDenied",
+			Args:        map[string]interface{}{"path": `HKLM:\Software\App`, "name": "Port", "data": "80"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"key":true,"value":false}`)(h)
+				h.GetConnection().ExpectCommandPattern(`New-ItemProperty`, &testhelper.CommandResponse{ExitCode: 1, Stderr: "Requested registry access is not allowed."})
+			},
+		},
+	})
+}
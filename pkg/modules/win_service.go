@@ -0,0 +1,318 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// serviceStartModes maps the start_mode parameter to Set-Service startup
+// types; delayed is Automatic with the DelayedAutostart flag
+var serviceStartModes = map[string]string{
+	"auto":     "Automatic",
+	"delayed":  "Automatic",
+	"manual":   "Manual",
+	"disabled": "Disabled",
+}
+
+// serviceState is a Windows service as Win32_Service reports it
+type serviceState struct {
+	Exists      bool   `json:"exists"`
+	State       string `json:"state"`
+	StartMode   string `json:"start_mode"`
+	Delayed     bool   `json:"delayed"`
+	DisplayName string `json:"display_name"`
+	Description string `json:"description"`
+	Path        string `json:"path"`
+	Username    string `json:"username"`
+}
+
+// startMode returns the start mode in terms of the start_mode parameter
+func (s serviceState) startMode() string {
+	mode := strings.ToLower(s.StartMode)
+	if mode == "auto" && s.Delayed {
+		return "delayed"
+	}
+	return mode
+}
+
+// running reports the service state in terms of the state parameter
+func (s serviceState) running() string {
+	if strings.EqualFold(s.State, "Running") {
+		return "started"
+	}
+	return "stopped"
+}
+
+// render renders the service for diffs
+func (s serviceState) render() string {
+	if !s.Exists {
+		return ""
+	}
+	return fmt.Sprintf("state: %s\nstart_mode: %s\ndisplay_name: %s\ndescription: %s\npath: %s\nusername: %s\n",
+		s.running(), s.startMode(), s.DisplayName, s.Description, s.Path, s.Username)
+}
+
+// serviceAccount normalises the names Windows accepts for the built-in
+// service accounts, so that LocalSystem and NT AUTHORITY\SYSTEM compare equal
+func serviceAccount(name string) string {
+	switch strings.ToLower(name) {
+	case "", "localsystem", "system", `nt authority\system`:
+		return "LocalSystem"
+	case "localservice", "local service", `nt authority\localservice`, `nt authority\local service`:
+		return `NT AUTHORITY\LocalService`
+	case "networkservice", "network service", `nt authority\networkservice`, `nt authority\network service`:
+		return `NT AUTHORITY\NetworkService`
+	}
+	return name
+}
+
+// WinServiceModule manages Windows services
+type WinServiceModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinServiceModule creates a new win_service module instance
+func NewWinServiceModule() *WinServiceModule {
+	doc := types.ModuleDoc{
+		Name:        "win_service",
+		Description: "Manage Windows services: create and remove them, set their start mode, account and binary, and start, stop or restart them",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Service name, not its display name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Desired state; restarted always restarts the service",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"started", "stopped", "restarted", "absent"},
+			},
+			"start_mode": {
+				Description: "How the service starts; delayed is automatic with a delayed start",
+				Required:    false,
+				Type:        "string",
+				Choices:     []string{"auto", "delayed", "manual", "disabled"},
+			},
+			"path": {
+				Description: "Command line of the service binary; required to create the service",
+				Required:    false,
+				Type:        "string",
+			},
+			"display_name": {
+				Description: "Display name of the service",
+				Required:    false,
+				Type:        "string",
+			},
+			"description": {
+				Description: "Description of the service",
+				Required:    false,
+				Type:        "string",
+			},
+			"username": {
+				Description: "Account the service runs as, e.g. LocalSystem, NT AUTHORITY\\NetworkService or .\\svc-app",
+				Required:    false,
+				Type:        "string",
+			},
+			"password": {
+				Description: "Password of username; it cannot be read back, so it is only set when the account changes or the service is created",
+				Required:    false,
+				Type:        "string",
+			},
+		},
+		Examples: []string{
+			"- name: Keep the print spooler stopped\n  win_service:\n    name: Spooler\n    state: stopped\n    start_mode: disabled",
+			"- name: Install the application service\n  win_service:\n    name: AppSvc\n    path: C:\\app\\appsvc.exe --service\n    display_name: Application\n    start_mode: delayed\n    username: .\\svc-app\n    password: \"{{ app_password }}\"\n    state: started",
+		},
+		Returns: map[string]string{
+			"name":       "Service name",
+			"exists":     "Whether the service exists",
+			"state":      "started or stopped",
+			"start_mode": "auto, delayed, manual or disabled",
+			"username":   "Account the service runs as",
+		},
+	}
+
+	base := NewBaseModule("win_service", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "windows",
+		RequiresRoot: true,
+	})
+
+	return &WinServiceModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinServiceModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if strings.ContainsAny(name, `'"\/`) {
+		return types.NewValidationError("name", name, "service names cannot contain quotes or slashes")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"started", "stopped", "restarted", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "start_mode", []string{"auto", "delayed", "manual", "disabled"}); err != nil {
+		return err
+	}
+	if m.GetStringArg(args, "password", "") != "" && m.GetStringArg(args, "username", "") == "" {
+		return types.NewValidationError("password", nil, "password requires username")
+	}
+	return nil
+}
+
+// Run executes the win_service module
+func (m *WinServiceModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "")
+
+	current, err := m.inspect(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := current
+	var changes, script []string
+	quoted := m.ps.quote(name)
+
+	if state == "absent" {
+		if current.Exists {
+			desired = serviceState{}
+			changes = append(changes, "removed service "+name)
+			script = append(script,
+				fmt.Sprintf("Stop-Service -Name %s -Force -ErrorAction SilentlyContinue", quoted),
+				fmt.Sprintf("& sc.exe delete %s | Out-Null", quoted),
+				`if ($LASTEXITCODE -ne 0) { throw "sc.exe delete exited with $LASTEXITCODE" }`)
+		}
+	} else {
+		if !current.Exists {
+			path := m.GetStringArg(args, "path", "")
+			if path == "" {
+				return nil, fmt.Errorf("service %s does not exist and no path was given to create it", name)
+			}
+			desired = serviceState{Exists: true, State: "Stopped", StartMode: "Manual", DisplayName: name, Path: path, Username: "LocalSystem"}
+			changes = append(changes, "created service "+name)
+			script = append(script, fmt.Sprintf("New-Service -Name %s -BinaryPathName %s -StartupType Manual | Out-Null", quoted, m.ps.quote(path)))
+		}
+		changes, script = m.configure(args, current.Exists, &desired, changes, script)
+
+		running := desired.running()
+		switch {
+		case state == "started" && running != "started":
+			desired.State = "Running"
+			changes = append(changes, "started "+name)
+			script = append(script, "Start-Service -Name "+quoted)
+		case state == "stopped" && running != "stopped":
+			desired.State = "Stopped"
+			changes = append(changes, "stopped "+name)
+			script = append(script, fmt.Sprintf("Stop-Service -Name %s -Force", quoted))
+		case state == "restarted":
+			desired.State = "Running"
+			changes = append(changes, "restarted "+name)
+			script = append(script, fmt.Sprintf("Restart-Service -Name %s -Force", quoted))
+		}
+	}
+
+	data := map[string]interface{}{"name": name, "exists": desired.Exists}
+	if desired.Exists {
+		data["state"] = desired.running()
+		data["start_mode"] = desired.startMode()
+		data["username"] = desired.Username
+	}
+	result := m.CreateSuccessResult(hostname, false, "Service is already in desired state", data)
+
+	change := strings.Join(changes, ", ")
+	if change != "" && !checkMode {
+		if _, err := m.ps.run(ctx, conn, "configuring service "+name, strings.Join(script, "\n")); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current.render(), desired.render(), startTime), nil
+}
+
+// configure adds the steps that bring the service's properties to those
+// requested, updating desired to match
+func (m *WinServiceModule) configure(args map[string]interface{}, exists bool, desired *serviceState, changes, script []string) ([]string, []string) {
+	name := m.GetStringArg(args, "name", "")
+	quoted := m.ps.quote(name)
+
+	if displayName := m.GetStringArg(args, "display_name", ""); displayName != "" && displayName != desired.DisplayName {
+		desired.DisplayName = displayName
+		changes = append(changes, "set display name of "+name)
+		script = append(script, fmt.Sprintf("Set-Service -Name %s -DisplayName %s", quoted, m.ps.quote(displayName)))
+	}
+	if description, ok := args["description"]; ok && types.ConvertToString(description) != desired.Description {
+		desired.Description = types.ConvertToString(description)
+		changes = append(changes, "set description of "+name)
+		script = append(script, fmt.Sprintf("Set-Service -Name %s -Description %s", quoted, m.ps.quote(desired.Description)))
+	}
+	if mode := m.GetStringArg(args, "start_mode", ""); mode != "" && mode != desired.startMode() {
+		desired.StartMode = mode
+		desired.Delayed = mode == "delayed"
+		changes = append(changes, fmt.Sprintf("set start mode of %s to %s", name, mode))
+		script = append(script, fmt.Sprintf("Set-Service -Name %s -StartupType %s", quoted, serviceStartModes[mode]))
+		if mode == "auto" || mode == "delayed" {
+			delayed := 0
+			if desired.Delayed {
+				delayed = 1
+			}
+			script = append(script, fmt.Sprintf("Set-ItemProperty -LiteralPath %s -Name DelayedAutostart -Value %d -Type DWord",
+				m.ps.quote(`HKLM:\SYSTEM\CurrentControlSet\Services\`+name), delayed))
+		}
+	}
+
+	// The binary and account can only be changed through Win32_Service
+	change := map[string]interface{}{}
+	if path := m.GetStringArg(args, "path", ""); path != "" && exists && path != desired.Path {
+		desired.Path = path
+		change["PathName"] = path
+		changes = append(changes, "set path of "+name)
+	}
+	if username := m.GetStringArg(args, "username", ""); username != "" && !strings.EqualFold(serviceAccount(username), serviceAccount(desired.Username)) {
+		desired.Username = username
+		change["StartName"] = username
+		change["StartPassword"] = m.GetStringArg(args, "password", "")
+		changes = append(changes, fmt.Sprintf("set account of %s to %s", name, username))
+	}
+	if len(change) > 0 {
+		script = append(script,
+			fmt.Sprintf(`$r = Get-CimInstance -ClassName Win32_Service -Filter "Name='%s'" | Invoke-CimMethod -MethodName Change -Arguments %s`, name, m.ps.hashtable(change)),
+			fmt.Sprintf(`if ($r.ReturnValue -ne 0) { throw "changing service %s failed with code $($r.ReturnValue)" }`, name))
+	}
+	return changes, script
+}
+
+// inspect reads the service from Win32_Service
+func (m *WinServiceModule) inspect(ctx context.Context, conn types.Connection, name string) (serviceState, error) {
+	script := fmt.Sprintf(`$state = @{ exists = $false }
+$svc = Get-CimInstance -ClassName Win32_Service -Filter "Name='%s'"
+if ($svc) {
+    $state.exists = $true
+    $state.state = $svc.State
+    $state.start_mode = $svc.StartMode
+    $state.delayed = [bool]$svc.DelayedAutoStart
+    $state.display_name = $svc.DisplayName
+    $state.description = [string]$svc.Description
+    $state.path = $svc.PathName
+    $state.username = $svc.StartName
+}
+$state | ConvertTo-Json -Compress`, name)
+
+	var state serviceState
+	err := m.ps.query(ctx, conn, "inspecting service "+name, script, &state)
+	return state, err
+}
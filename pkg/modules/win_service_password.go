@@ -0,0 +1,225 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// accountService is a service as the win_service_password inspection
+// reports it
+type accountService struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	State    string `json:"state"`
+}
+
+// sameAccount reports whether two account names are the same account,
+// treating .\name like name
+func sameAccount(a, b string) bool {
+	short := func(name string) string { return strings.ToLower(strings.TrimPrefix(name, `.\`)) }
+	return short(a) == short(b)
+}
+
+// WinServicePasswordModule updates the password services log on with after
+// the password of their account changed
+type WinServicePasswordModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinServicePasswordModule creates a new win_service_password module
+// instance
+func NewWinServicePasswordModule() *WinServicePasswordModule {
+	doc := types.ModuleDoc{
+		Name:        "win_service_password",
+		Description: "Update the password of the account Windows services run as, after checking that the account accepts it",
+		Parameters: map[string]types.ParamDoc{
+			"username": {
+				Description: "Account the services run as, e.g. .\\svc-app or CORP\\svc-app",
+				Required:    true,
+				Type:        "string",
+			},
+			"password": {
+				Description: "New password of the account",
+				Required:    true,
+				Type:        "string",
+			},
+			"services": {
+				Description: "Services to update; every service running as username when omitted",
+				Required:    false,
+				Type:        "list",
+			},
+			"validate": {
+				Description: "Check the password against the account before storing it, so services are never given a password that would fail their logon and count towards a lockout",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"restart": {
+				Description: "Restart running services so they log on with the new password now rather than at their next start",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Give the application services the new password\n  win_service_password:\n    username: .\\svc-app\n    password: \"{{ app_password }}\"\n    restart: true",
+		},
+		Returns: map[string]string{
+			"username":  "Account name",
+			"services":  "Services whose password was updated",
+			"restarted": "Services that were restarted",
+		},
+	}
+
+	base := NewBaseModule("win_service_password", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     false,
+		Platform:     "windows",
+		RequiresRoot: true,
+	})
+
+	return &WinServicePasswordModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinServicePasswordModule) Validate(args map[string]interface{}) error {
+	username := m.GetStringArg(args, "username", "")
+	if username == "" {
+		return types.NewValidationError("username", nil, "required parameter")
+	}
+	if account := serviceAccount(username); account == "LocalSystem" || strings.HasPrefix(account, `NT AUTHORITY\`) {
+		return types.NewValidationError("username", username, "built-in service accounts have no password")
+	}
+	if m.GetStringArg(args, "password", "") == "" {
+		return types.NewValidationError("password", nil, "required parameter")
+	}
+	for _, name := range stringList(args["services"]) {
+		if strings.ContainsAny(name, `'"\/`) {
+			return types.NewValidationError("services", name, "service names cannot contain quotes or slashes")
+		}
+	}
+	return nil
+}
+
+// Run executes the win_service_password module. A stored password cannot
+// be read back, so every matching service is updated on each run.
+func (m *WinServicePasswordModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+
+	username := m.GetStringArg(args, "username", "")
+	password := m.GetStringArg(args, "password", "")
+	restart := m.GetBoolArg(args, "restart", false)
+
+	services, err := m.inspect(ctx, conn, stringList(args["services"]))
+	if err != nil {
+		return nil, err
+	}
+	var names, restarted []string
+	for _, svc := range services {
+		if !sameAccount(svc.Username, username) {
+			if len(stringList(args["services"])) > 0 {
+				return nil, fmt.Errorf("service %s runs as %s, not %s", svc.Name, svc.Username, username)
+			}
+			continue
+		}
+		names = append(names, svc.Name)
+		if restart && strings.EqualFold(svc.State, "Running") {
+			restarted = append(restarted, svc.Name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(restarted)
+
+	data := map[string]interface{}{"username": username, "services": names, "restarted": restarted}
+	result := m.CreateSuccessResult(hostname, false, "No service runs as "+username, data)
+	if len(names) == 0 {
+		return changeResult(m.BaseModule, result, "", checkMode, false, "", "", startTime), nil
+	}
+
+	if m.GetBoolArg(args, "validate", true) {
+		valid, err := m.validCredentials(ctx, conn, username, password)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, fmt.Errorf("%s does not accept the password; change the account password before updating its services", username)
+		}
+	}
+
+	change := fmt.Sprintf("updated the password of %s for %s", username, strings.Join(names, ", "))
+	if len(restarted) > 0 {
+		change += ", restarted " + strings.Join(restarted, ", ")
+	}
+	if !checkMode {
+		script := []string{"$password = " + m.ps.quote(password)}
+		for _, name := range names {
+			script = append(script,
+				fmt.Sprintf(`$svc = Get-CimInstance -ClassName Win32_Service -Filter "Name='%s'"`, name),
+				`$r = $svc | Invoke-CimMethod -MethodName Change -Arguments @{ StartName = $svc.StartName; StartPassword = $password }`,
+				fmt.Sprintf(`if ($r.ReturnValue -ne 0) { throw "changing service %s failed with code $($r.ReturnValue)" }`, name))
+		}
+		for _, name := range restarted {
+			script = append(script, fmt.Sprintf("Restart-Service -Name %s -Force", m.ps.quote(name)))
+		}
+		if _, err := m.ps.run(ctx, conn, "updating service passwords", strings.Join(script, "\n")); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, false, "", "", startTime), nil
+}
+
+// inspect lists the named services, or every service with an account when
+// names is empty
+func (m *WinServicePasswordModule) inspect(ctx context.Context, conn types.Connection, names []string) ([]accountService, error) {
+	filter := "StartName IS NOT NULL"
+	if len(names) > 0 {
+		clauses := make([]string, len(names))
+		for i, name := range names {
+			clauses[i] = fmt.Sprintf("Name='%s'", name)
+		}
+		filter = strings.Join(clauses, " OR ")
+	}
+	script := fmt.Sprintf(`$services = @(Get-CimInstance -ClassName Win32_Service -Filter "%s" | ForEach-Object { @{ name = $_.Name; username = [string]$_.StartName; state = [string]$_.State } })
+ConvertTo-Json -InputObject $services -Compress`, filter)
+
+	var services []accountService
+	if err := m.ps.query(ctx, conn, "listing services", script, &services); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		found := false
+		for _, svc := range services {
+			found = found || strings.EqualFold(svc.Name, name)
+		}
+		if !found {
+			return nil, fmt.Errorf("service %s does not exist", name)
+		}
+	}
+	return services, nil
+}
+
+// validCredentials checks password against the local or domain account
+// without logging on
+func (m *WinServicePasswordModule) validCredentials(ctx context.Context, conn types.Connection, username, password string) (bool, error) {
+	script := fmt.Sprintf(`$username = %s
+$domain, $name = $username -split '\\', 2
+if ($null -eq $name) { $name = $domain; $domain = '.' }
+$contextType = 'Machine'
+if ($name -match '@' -or ($domain -ne '.' -and $domain -ne $env:COMPUTERNAME)) { $contextType = 'Domain' }
+Add-Type -AssemblyName System.DirectoryServices.AccountManagement
+$context = New-Object System.DirectoryServices.AccountManagement.PrincipalContext($contextType)
+ConvertTo-Json -InputObject $context.ValidateCredentials($name, %s) -Compress`, m.ps.quote(username), m.ps.quote(password))
+
+	var valid bool
+	err := m.ps.query(ctx, conn, "validating the password of "+username, script, &valid)
+	return valid, err
+}
@@ -0,0 +1,84 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestWinServicePasswordModule(t *testing.T) {
+	module := NewWinServicePasswordModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"username": `.\svc-app`, "password": "s3cret", "services": []interface{}{"AppSvc"}}, ExpectValid: true},
+		{Name: "BuiltinAccount", Args: map[string]interface{}{"username": "LocalSystem", "password": "s3cret"}, ExpectValid: false},
+		{Name: "MissingPassword", Args: map[string]interface{}{"username": `.\svc-app`}, ExpectValid: false},
+		{Name: "QuotedService", Args: map[string]interface{}{"username": `.\svc-app`, "password": "s3cret", "services": "App'Svc"}, ExpectValid: false},
+	})
+
+	services := `[{"name":"AppSvc","username":".\\svc-app","state":"Running"},{"name":"AppWorker","username":"svc-app","state":"Stopped"},{"name":"Spooler","username":"LocalSystem","state":"Running"}]`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "UpdateAndRestart",
+			Args: map[string]interface{}{"username": "svc-app", "password": "s3cret", "restart": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Filter "StartName IS NOT NULL"`, &testhelper.CommandResponse{Stdout: services})
+				h.GetConnection().ExpectCommandPattern(`ValidateCredentials`, &testhelper.CommandResponse{Stdout: "true"})
+				h.GetConnection().ExpectCommandPattern(`(?s)Name='AppSvc'.*Name='AppWorker'.*Restart-Service -Name 'AppSvc' -Force$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Updated the password of svc-app for AppSvc, AppWorker, restarted AppSvc")
+				h.GetConnection().AssertPatternCalledTimes(`Restart-Service -Name 'AppWorker'`, 0)
+			},
+		},
+		{
+			Name:        "RejectedPassword",
+			Args:        map[string]interface{}{"username": `.\svc-app`, "password": "wrong", "services": []interface{}{"AppSvc"}},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Filter "Name='AppSvc'"`, &testhelper.CommandResponse{Stdout: `[{"name":"AppSvc","username":".\\svc-app","state":"Running"}]`})
+				h.GetConnection().ExpectCommandPattern(`ValidateCredentials`, &testhelper.CommandResponse{Stdout: "false"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.GetConnection().AssertPatternCalledTimes(`Invoke-CimMethod`, 0)
+			},
+		},
+		{
+			Name:        "ServiceOfAnotherAccount",
+			Args:        map[string]interface{}{"username": `.\svc-app`, "password": "s3cret", "services": []interface{}{"Spooler"}},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`-Filter "Name='Spooler'"`, &testhelper.CommandResponse{Stdout: `[{"name":"Spooler","username":"LocalSystem","state":"Running"}]`})
+			},
+		},
+		{
+			Name: "NoServices",
+			Args: map[string]interface{}{"username": `CORP\svc-db`, "password": "s3cret"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`StartName IS NOT NULL`, &testhelper.CommandResponse{Stdout: services})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+				h.GetConnection().AssertPatternCalledTimes(`ValidateCredentials`, 0)
+			},
+		},
+		{
+			Name:      "CheckMode",
+			Args:      map[string]interface{}{"username": "svc-app", "password": "s3cret"},
+			CheckMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`StartName IS NOT NULL`, &testhelper.CommandResponse{Stdout: services})
+				h.GetConnection().ExpectCommandPattern(`ValidateCredentials`, &testhelper.CommandResponse{Stdout: "true"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(`Invoke-CimMethod`, 0)
+			},
+		},
+	})
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestServiceAccount(t *testing.T) {
+	tests := map[string]string{
+		"":                           "LocalSystem",
+		`NT AUTHORITY\SYSTEM`:        "LocalSystem",
+		"NetworkService":             `NT AUTHORITY\NetworkService`,
+		`nt authority\local service`: `NT AUTHORITY\LocalService`,
+		`.\svc-app`:                  `.\svc-app`,
+	}
+	for name, expected := range tests {
+		if got := serviceAccount(name); got != expected {
+			t.Errorf("serviceAccount(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestWinServiceModule(t *testing.T) {
+	module := NewWinServiceModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "Spooler", "state": "stopped", "start_mode": "disabled"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"state": "started"}, ExpectValid: false},
+		{Name: "QuotedName", Args: map[string]interface{}{"name": "a'b"}, ExpectValid: false},
+		{Name: "InvalidState", Args: map[string]interface{}{"name": "Spooler", "state": "paused"}, ExpectValid: false},
+		{Name: "PasswordWithoutUser", Args: map[string]interface{}{"name": "AppSvc", "password": "secret"}, ExpectValid: false},
+	})
+
+	inspect := func(state string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`Get-CimInstance -ClassName Win32_Service -Filter "Name='AppSvc'"\n`, &testhelper.CommandResponse{Stdout: state})
+		}
+	}
+	running := `{"exists":true,"state":"Running","start_mode":"Auto","delayed":false,"display_name":"Application","description":"","path":"C:\\app\\appsvc.exe","username":"LocalSystem"}`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "StopAndDisable",
+			Args: map[string]interface{}{"name": "AppSvc", "state": "stopped", "start_mode": "disabled"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(running)(h)
+				h.GetConnection().ExpectCommandPattern(`Set-Service -Name 'AppSvc' -StartupType Disabled\nStop-Service -Name 'AppSvc' -Force$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Set start mode of AppSvc to disabled, stopped AppSvc")
+				h.AssertDataValue(result, "state", "stopped")
+			},
+		},
+		{
+			Name: "AlreadyRunning",
+			Args: map[string]interface{}{"name": "AppSvc", "state": "started", "start_mode": "auto", "username": `NT AUTHORITY\SYSTEM`},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(running)(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:     "CreateDelayed",
+			Args:     map[string]interface{}{"name": "AppSvc", "path": `C:\app\appsvc.exe`, "start_mode": "delayed", "username": `.\svc-app`, "password": "s3cret", "state": "started"},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"exists":false}`)(h)
+				h.GetConnection().ExpectCommandPattern(`New-Service -Name 'AppSvc' -BinaryPathName 'C:\\app\\appsvc.exe' -StartupType Manual \| Out-Null\n`+
+					`Set-Service -Name 'AppSvc' -StartupType Automatic\n`+
+					`Set-ItemProperty -LiteralPath 'HKLM:\\SYSTEM\\CurrentControlSet\\Services\\AppSvc' -Name DelayedAutostart -Value 1 -Type DWord\n`+
+					`\$r = .* -MethodName Change -Arguments @\{'StartName' = '\.\\svc-app'; 'StartPassword' = 's3cret'\}\n`+
+					`.*\nStart-Service -Name 'AppSvc'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, `Created service AppSvc, set start mode of AppSvc to delayed, set account of AppSvc to .\svc-app, started AppSvc`)
+				h.AssertDiffAfter(result, "state: started\nstart_mode: delayed\ndisplay_name: AppSvc\ndescription: \npath: C:\\app\\appsvc.exe\nusername: .\\svc-app\n")
+			},
+		},
+		{
+			Name:        "MissingWithoutPath",
+			Args:        map[string]interface{}{"name": "AppSvc", "state": "started"},
+			ExpectError: true,
+			Setup:       inspect(`{"exists":false}`),
+		},
+		{
+			Name:      "RemoveInCheckMode",
+			Args:      map[string]interface{}{"name": "AppSvc", "state": "absent"},
+			CheckMode: true,
+			Setup:     inspect(running),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.AssertMessage(result, "Would have removed service AppSvc")
+				h.GetConnection().AssertPatternCalledTimes(`sc\.exe delete`, 0)
+			},
+		},
+	})
+}
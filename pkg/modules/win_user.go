@@ -0,0 +1,318 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// localUserState is a local Windows account as Get-LocalUser reports it
+type localUserState struct {
+	Exists               bool     `json:"exists"`
+	FullName             string   `json:"full_name"`
+	Description          string   `json:"description"`
+	Disabled             bool     `json:"disabled"`
+	PasswordNeverExpires bool     `json:"password_never_expires"`
+	Groups               []string `json:"groups"`
+	PasswordValid        bool     `json:"password_valid"`
+}
+
+// render renders the account for diffs; passwords are never shown
+func (s localUserState) render() string {
+	if !s.Exists {
+		return ""
+	}
+	groups := append([]string(nil), s.Groups...)
+	sort.Slice(groups, func(i, j int) bool { return strings.ToLower(groups[i]) < strings.ToLower(groups[j]) })
+	return fmt.Sprintf("full_name: %s\ndescription: %s\ndisabled: %t\npassword_never_expires: %t\ngroups: %s\n",
+		s.FullName, s.Description, s.Disabled, s.PasswordNeverExpires, strings.Join(groups, ", "))
+}
+
+// hasGroup reports whether groups holds group, ignoring case as Windows does
+func hasGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// WinUserModule manages local Windows accounts and their group memberships
+type WinUserModule struct {
+	*BaseModule
+	ps powerShell
+}
+
+// NewWinUserModule creates a new win_user module instance
+func NewWinUserModule() *WinUserModule {
+	doc := types.ModuleDoc{
+		Name:        "win_user",
+		Description: "Manage local Windows accounts: create and remove them, set their password, name and flags, and manage their membership of local groups",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Account name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the account should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"password": {
+				Description: "Password of the account",
+				Required:    false,
+				Type:        "string",
+			},
+			"update_password": {
+				Description: "always sets the password when it does not validate, on_create only sets it on new accounts",
+				Required:    false,
+				Type:        "string",
+				Default:     "always",
+				Choices:     []string{"always", "on_create"},
+			},
+			"fullname": {
+				Description: "Full name of the account",
+				Required:    false,
+				Type:        "string",
+			},
+			"description": {
+				Description: "Description of the account",
+				Required:    false,
+				Type:        "string",
+			},
+			"account_disabled": {
+				Description: "Whether the account is disabled",
+				Required:    false,
+				Type:        "bool",
+			},
+			"password_never_expires": {
+				Description: "Whether the password never expires",
+				Required:    false,
+				Type:        "bool",
+			},
+			"groups": {
+				Description: "Local groups of the account",
+				Required:    false,
+				Type:        "list",
+			},
+			"groups_action": {
+				Description: "add adds the account to groups, remove removes it from them and replace makes groups its only memberships",
+				Required:    false,
+				Type:        "string",
+				Default:     "replace",
+				Choices:     []string{"add", "remove", "replace"},
+			},
+		},
+		Examples: []string{
+			"- name: Create the application account\n  win_user:\n    name: svc-app\n    password: \"{{ app_password }}\"\n    password_never_expires: true\n    groups: [Users, Performance Log Users]",
+			"- name: Grant administrator rights\n  win_user:\n    name: alice\n    groups: [Administrators]\n    groups_action: add",
+		},
+		Returns: map[string]string{
+			"name":   "Account name",
+			"exists": "Whether the account exists",
+			"groups": "Local groups of the account",
+		},
+	}
+
+	base := NewBaseModule("win_user", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "windows",
+		RequiresRoot: true,
+	})
+
+	return &WinUserModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *WinUserModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if len(name) > 20 || strings.ContainsAny(name, `"/\[]:;|=,+*?<>@`) || strings.TrimRight(name, ". ") != name {
+		return types.NewValidationError("name", name, "invalid local account name")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if err := m.ValidateChoices(args, "update_password", []string{"always", "on_create"}); err != nil {
+		return err
+	}
+	return m.ValidateChoices(args, "groups_action", []string{"add", "remove", "replace"})
+}
+
+// Run executes the win_user module
+func (m *WinUserModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	password, hasPassword := args["password"]
+	checkPassword := hasPassword && m.GetStringArg(args, "update_password", "always") == "always"
+
+	current, err := m.inspect(ctx, conn, name, checkPassword, types.ConvertToString(password))
+	if err != nil {
+		return nil, err
+	}
+
+	desired := current
+	var changes, script []string
+	quoted := m.ps.quote(name)
+	secure := fmt.Sprintf("(ConvertTo-SecureString %s -AsPlainText -Force)", m.ps.quote(types.ConvertToString(password)))
+
+	if m.GetStringArg(args, "state", "present") == "absent" {
+		if current.Exists {
+			desired = localUserState{}
+			changes = append(changes, "removed user "+name)
+			script = append(script, "Remove-LocalUser -Name "+quoted)
+		}
+	} else {
+		if !current.Exists {
+			desired = localUserState{Exists: true}
+			changes = append(changes, "created user "+name)
+			if hasPassword {
+				script = append(script, fmt.Sprintf("New-LocalUser -Name %s -Password %s | Out-Null", quoted, secure))
+			} else {
+				script = append(script, fmt.Sprintf("New-LocalUser -Name %s -NoPassword | Out-Null", quoted))
+			}
+		} else if checkPassword && !current.PasswordValid {
+			changes = append(changes, "changed password of "+name)
+			script = append(script, fmt.Sprintf("Set-LocalUser -Name %s -Password %s", quoted, secure))
+		}
+
+		if fullname, ok := args["fullname"]; ok && types.ConvertToString(fullname) != desired.FullName {
+			desired.FullName = types.ConvertToString(fullname)
+			changes = append(changes, "set full name of "+name)
+			script = append(script, fmt.Sprintf("Set-LocalUser -Name %s -FullName %s", quoted, m.ps.quote(desired.FullName)))
+		}
+		if description, ok := args["description"]; ok && types.ConvertToString(description) != desired.Description {
+			desired.Description = types.ConvertToString(description)
+			changes = append(changes, "set description of "+name)
+			script = append(script, fmt.Sprintf("Set-LocalUser -Name %s -Description %s", quoted, m.ps.quote(desired.Description)))
+		}
+		if _, ok := args["password_never_expires"]; ok {
+			if never := m.GetBoolArg(args, "password_never_expires", false); never != desired.PasswordNeverExpires {
+				desired.PasswordNeverExpires = never
+				changes = append(changes, fmt.Sprintf("set password_never_expires of %s to %t", name, never))
+				script = append(script, fmt.Sprintf("Set-LocalUser -Name %s -PasswordNeverExpires %s", quoted, m.ps.literal(never)))
+			}
+		}
+		if _, ok := args["account_disabled"]; ok {
+			if disabled := m.GetBoolArg(args, "account_disabled", false); disabled != desired.Disabled {
+				desired.Disabled = disabled
+				if disabled {
+					changes = append(changes, "disabled "+name)
+					script = append(script, "Disable-LocalUser -Name "+quoted)
+				} else {
+					changes = append(changes, "enabled "+name)
+					script = append(script, "Enable-LocalUser -Name "+quoted)
+				}
+			}
+		}
+		if _, ok := args["groups"]; ok {
+			changes, script = m.memberships(args, &desired, changes, script)
+		}
+	}
+
+	data := map[string]interface{}{"name": name, "exists": desired.Exists}
+	if desired.Exists {
+		data["groups"] = desired.Groups
+	}
+	result := m.CreateSuccessResult(hostname, false, "User is already in desired state", data)
+
+	change := strings.Join(changes, ", ")
+	if change != "" && !checkMode {
+		if _, err := m.ps.run(ctx, conn, "configuring user "+name, strings.Join(script, "\n")); err != nil {
+			return nil, err
+		}
+	}
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, current.render(), desired.render(), startTime), nil
+}
+
+// memberships adds the steps that bring the account's local group
+// memberships to those requested, updating desired to match
+func (m *WinUserModule) memberships(args map[string]interface{}, desired *localUserState, changes, script []string) ([]string, []string) {
+	name := m.GetStringArg(args, "name", "")
+	groups := stringList(args["groups"])
+	action := m.GetStringArg(args, "groups_action", "replace")
+
+	var add, remove []string
+	if action != "remove" {
+		for _, group := range groups {
+			if !hasGroup(desired.Groups, group) {
+				add = append(add, group)
+			}
+		}
+	}
+	for _, group := range desired.Groups {
+		if (action == "remove" && hasGroup(groups, group)) || (action == "replace" && !hasGroup(groups, group)) {
+			remove = append(remove, group)
+		}
+	}
+
+	var kept []string
+	for _, group := range desired.Groups {
+		if !hasGroup(remove, group) {
+			kept = append(kept, group)
+		}
+	}
+	desired.Groups = append(kept, add...)
+
+	for _, group := range add {
+		script = append(script, fmt.Sprintf("Add-LocalGroupMember -Group %s -Member %s", m.ps.quote(group), m.ps.quote(name)))
+	}
+	for _, group := range remove {
+		script = append(script, fmt.Sprintf("Remove-LocalGroupMember -Group %s -Member %s", m.ps.quote(group), m.ps.quote(name)))
+	}
+	if len(add) > 0 {
+		changes = append(changes, fmt.Sprintf("added %s to %s", name, strings.Join(add, ", ")))
+	}
+	if len(remove) > 0 {
+		changes = append(changes, fmt.Sprintf("removed %s from %s", name, strings.Join(remove, ", ")))
+	}
+	return changes, script
+}
+
+// inspect reads the account and its group memberships. When checkPassword
+// is set it also reports whether password validates, which is the only way
+// to compare a password.
+func (m *WinUserModule) inspect(ctx context.Context, conn types.Connection, name string, checkPassword bool, password string) (localUserState, error) {
+	passwordLiteral := "$null"
+	if checkPassword {
+		passwordLiteral = m.ps.quote(password)
+	}
+	script := fmt.Sprintf(`$name = %s
+$password = %s
+$state = @{ exists = $false; groups = @() }
+$user = Get-LocalUser -Name $name -ErrorAction SilentlyContinue
+if ($user) {
+    $state.exists = $true
+    $state.full_name = $user.FullName
+    $state.description = $user.Description
+    $state.disabled = -not $user.Enabled
+    $state.password_never_expires = $null -eq $user.PasswordExpires
+    $state.groups = @(Get-LocalGroup | Where-Object { @(Get-LocalGroupMember -Group $_ -ErrorAction SilentlyContinue | Where-Object SID -eq $user.SID).Count -gt 0 } | ForEach-Object { $_.Name })
+    if ($null -ne $password) {
+        Add-Type -AssemblyName System.DirectoryServices.AccountManagement
+        $context = New-Object System.DirectoryServices.AccountManagement.PrincipalContext('Machine')
+        $state.password_valid = $context.ValidateCredentials($name, $password)
+    }
+}
+$state | ConvertTo-Json -Compress`, m.ps.quote(name), passwordLiteral)
+
+	var state localUserState
+	err := m.ps.query(ctx, conn, "inspecting user "+name, script, &state)
+	return state, err
+}
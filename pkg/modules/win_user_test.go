@@ -0,0 +1,96 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestWinUserModule(t *testing.T) {
+	module := NewWinUserModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "svc-app", "password": "s3cret", "groups": []interface{}{"Users"}}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"password": "s3cret"}, ExpectValid: false},
+		{Name: "InvalidName", Args: map[string]interface{}{"name": `CORP\alice`}, ExpectValid: false},
+		{Name: "TooLong", Args: map[string]interface{}{"name": "a-very-long-account-name"}, ExpectValid: false},
+		{Name: "InvalidGroupsAction", Args: map[string]interface{}{"name": "alice", "groups_action": "set"}, ExpectValid: false},
+	})
+
+	inspect := func(state string) func(h *testhelper.ModuleTestHelper) {
+		return func(h *testhelper.ModuleTestHelper) {
+			h.GetConnection().ExpectCommandPattern(`\$name = 'alice'\n`, &testhelper.CommandResponse{Stdout: state})
+		}
+	}
+	existing := `{"exists":true,"full_name":"Alice","description":"","disabled":false,"password_never_expires":false,"groups":["Users","Remote Desktop Users"],"password_valid":true}`
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name:     "Create",
+			Args:     map[string]interface{}{"name": "alice", "password": "s3cret", "fullname": "Alice", "groups": []interface{}{"Users"}},
+			DiffMode: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(`{"exists":false,"groups":[]}`)(h)
+				h.GetConnection().ExpectCommandPattern(`New-LocalUser -Name 'alice' -Password \(ConvertTo-SecureString 's3cret' -AsPlainText -Force\) \| Out-Null\n`+
+					`Set-LocalUser -Name 'alice' -FullName 'Alice'\nAdd-LocalGroupMember -Group 'Users' -Member 'alice'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Created user alice, set full name of alice, added alice to Users")
+				h.AssertDiffAfter(result, "full_name: Alice\ndescription: \ndisabled: false\npassword_never_expires: false\ngroups: Users\n")
+				if strings.Contains(result.Diff.After, "s3cret") {
+					t.Error("expected the password to be kept out of the diff")
+				}
+			},
+		},
+		{
+			Name: "Unchanged",
+			Args: map[string]interface{}{"name": "alice", "password": "s3cret", "groups": []interface{}{"users", "Remote Desktop Users"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(existing)(h)
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name: "ReplaceGroupsAndPassword",
+			Args: map[string]interface{}{"name": "alice", "password": "n3w", "groups": []interface{}{"Administrators", "Users"}, "account_disabled": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				inspect(strings.Replace(existing, `"password_valid":true`, `"password_valid":false`, 1))(h)
+				h.GetConnection().ExpectCommandPattern(`Set-LocalUser -Name 'alice' -Password \(ConvertTo-SecureString 'n3w' -AsPlainText -Force\)\n`+
+					`Disable-LocalUser -Name 'alice'\n`+
+					`Add-LocalGroupMember -Group 'Administrators' -Member 'alice'\n`+
+					`Remove-LocalGroupMember -Group 'Remote Desktop Users' -Member 'alice'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Changed password of alice, disabled alice, added alice to Administrators, removed alice from Remote Desktop Users")
+				if groups, _ := result.Data["groups"].([]string); strings.Join(groups, ",") != "Users,Administrators" {
+					t.Errorf("unexpected groups %v", result.Data["groups"])
+				}
+			},
+		},
+		{
+			Name: "OnCreateSkipsPasswordCheck",
+			Args: map[string]interface{}{"name": "alice", "password": "n3w", "update_password": "on_create"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`\$password = \$null\n`, &testhelper.CommandResponse{Stdout: existing})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "RemoveInCheckMode",
+			Args:      map[string]interface{}{"name": "alice", "state": "absent"},
+			CheckMode: true,
+			Setup:     inspect(existing),
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertCheckModeSimulated(result)
+				h.GetConnection().AssertPatternCalledTimes(`Remove-LocalUser`, 0)
+			},
+		},
+	})
+}
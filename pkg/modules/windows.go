@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// powerShell runs scripts on Windows hosts, which WinRM connections execute
+// through powershell.exe when the shell is set
+type powerShell struct{}
+
+// quote quotes s as a PowerShell single-quoted string
+func (p powerShell) quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// run executes a script and turns a non-zero exit into an error
+func (p powerShell) run(ctx context.Context, conn types.Connection, what, script string) (*types.Result, error) {
+	result, err := conn.Execute(ctx, "$ErrorActionPreference = 'Stop'\n"+script, types.ExecuteOptions{Shell: "powershell"})
+	if err != nil {
+		return result, fmt.Errorf("%s failed: %w", what, err)
+	}
+	if !result.Success {
+		return result, fmt.Errorf("%s failed: %s", what, commandStderr(result))
+	}
+	return result, nil
+}
+
+// query runs a script that prints a JSON document and decodes it into v
+func (p powerShell) query(ctx context.Context, conn types.Connection, what, script string, v interface{}) error {
+	result, err := p.run(ctx, conn, what, script)
+	if err != nil {
+		return err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), v); err != nil {
+		return fmt.Errorf("%s returned invalid output: %w", what, err)
+	}
+	return nil
+}
+
+// literal renders a value from task arguments as a PowerShell expression.
+// Maps become hashtables, except those holding exactly a username and a
+// password, which become PSCredential objects as DSC resources expect.
+func (p powerShell) literal(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "$null"
+	case bool:
+		if v {
+			return "$true"
+		}
+		return "$false"
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == float64(int64(v)) {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = p.literal(item)
+		}
+		return "@(" + strings.Join(items, ", ") + ")"
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = p.quote(item)
+		}
+		return "@(" + strings.Join(items, ", ") + ")"
+	case map[string]interface{}:
+		if user, password, ok := credential(v); ok {
+			return fmt.Sprintf("(New-Object System.Management.Automation.PSCredential(%s, (ConvertTo-SecureString %s -AsPlainText -Force)))",
+				p.quote(user), p.quote(password))
+		}
+		return p.hashtable(v)
+	default:
+		return p.quote(fmt.Sprintf("%v", v))
+	}
+}
+
+// hashtable renders a map as a PowerShell hashtable with sorted keys
+func (p powerShell) hashtable(values map[string]interface{}) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = fmt.Sprintf("%s = %s", p.quote(key), p.literal(values[key]))
+	}
+	return "@{" + strings.Join(entries, "; ") + "}"
+}
+
+// credential recognizes a {username, password} map
+func credential(v map[string]interface{}) (string, string, bool) {
+	if len(v) != 2 {
+		return "", "", false
+	}
+	user, userOK := v["username"].(string)
+	password, passwordOK := v["password"].(string)
+	return user, password, userOK && passwordOK
+}
@@ -63,10 +63,21 @@ func NewYumModule() *YumModule {
 				Default:     false,
 				Type:        "bool",
 			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Default:     60,
+				Type:        "int",
+			},
 		},
 	}
+	base := NewBaseModule("yum", doc)
+	caps := types.DefaultCapabilities()
+	caps.ConcurrencyClass = types.ConcurrencyClassPackageManager
+	base.SetCapabilities(caps)
+
 	return &YumModule{
-		BaseModule: NewBaseModule("yum", doc),
+		BaseModule: base,
 	}
 }
 
@@ -81,6 +92,10 @@ func (m *YumModule) Run(ctx context.Context, conn types.Connection, args map[str
 	updateCache := m.GetBoolArg(args, "update_cache", false)
 	securityUpdates := m.GetBoolArg(args, "security", false)
 	autoremove := m.GetBoolArg(args, "autoremove", false)
+	lockTimeout, err := m.lockTimeoutArg(args)
+	if err != nil {
+		return m.CreateErrorResult("", err.Error(), nil), nil
+	}
 
 	// Convert slice to string array
 	var names []string
@@ -135,7 +150,7 @@ func (m *YumModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Update cache if requested
 	if updateCache {
 		cmd := "yum makecache"
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to update YUM cache", err), nil
 		}
@@ -149,7 +164,7 @@ func (m *YumModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Handle security updates without specific packages
 	if securityUpdates && len(packages) == 0 {
 		cmd := fmt.Sprintf("yum update -y --security %s", optionsStr)
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to apply security updates", err), nil
 		}
@@ -168,7 +183,7 @@ func (m *YumModule) Run(ctx context.Context, conn types.Connection, args map[str
 	for _, pkg := range packages {
 		cmd := m.buildYumCommand(pkg, state, optionsStr)
 		
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			// Check if package is already in desired state
 			if result != nil && result.Data != nil {
@@ -206,7 +221,7 @@ func (m *YumModule) Run(ctx context.Context, conn types.Connection, args map[str
 	// Autoremove if requested
 	if autoremove {
 		cmd := "yum autoremove -y"
-		result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+		result, err := m.executeWithLockWait(ctx, conn, cmd, lockTimeout)
 		if err != nil {
 			return m.CreateErrorResult("", "Failed to autoremove packages", err), nil
 		}
@@ -278,6 +293,10 @@ func (m *YumModule) Validate(args map[string]interface{}) error {
 		}
 	}
 
+	if _, err := m.lockTimeoutArg(args); err != nil {
+		return err
+	}
+
 	// Check that at least one action is specified
 	name := m.GetStringArg(args, "name", "")
 	namesSlice := m.GetSliceArg(args, "names")
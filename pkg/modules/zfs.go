@@ -0,0 +1,596 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// remoteCLI runs system tools such as zfs, btrfs, mkswap and modprobe on
+// the target host
+type remoteCLI struct{}
+
+// shellEscape escapes a string for shell usage
+func (c remoteCLI) shellEscape(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "'\"'\"'"))
+}
+
+// run executes a command and turns a non-zero exit into an error
+func (c remoteCLI) run(ctx context.Context, conn types.Connection, what, cmd string) (*types.Result, error) {
+	result, err := conn.Execute(ctx, cmd, types.ExecuteOptions{})
+	if err != nil {
+		return result, fmt.Errorf("%s failed: %w", what, err)
+	}
+	if !result.Success {
+		return result, fmt.Errorf("%s failed: %s", what, commandStderr(result))
+	}
+	return result, nil
+}
+
+// inspect runs probe, which must succeed only when the object exists, and
+// returns the output of show when it does
+func (c remoteCLI) inspect(ctx context.Context, conn types.Connection, what, probe, show string) (string, bool, error) {
+	cmd := fmt.Sprintf("if %s >/dev/null 2>&1; then printf '%%s' '%s'", probe, existsMarker)
+	if show != "" {
+		cmd += "; " + show
+	}
+	cmd += "; fi"
+
+	result, err := c.run(ctx, conn, "inspecting "+what, cmd)
+	if err != nil {
+		return "", false, err
+	}
+	stdout, _ := result.Data["stdout"].(string)
+	if !strings.HasPrefix(stdout, existsMarker) {
+		return "", false, nil
+	}
+	return strings.TrimPrefix(stdout, existsMarker), true, nil
+}
+
+// zfsProperties reads the named properties of a dataset or pool. getter is
+// the get subcommand, e.g. "zfs get".
+func (c remoteCLI) zfsProperties(ctx context.Context, conn types.Connection, lister, getter, name string, keys []string) (map[string]string, bool, error) {
+	show := ""
+	if len(keys) > 0 {
+		show = fmt.Sprintf("%s -H -o property,value %s %s", getter, c.shellEscape(strings.Join(keys, ",")), c.shellEscape(name))
+	}
+	output, exists, err := c.inspect(ctx, conn, name, fmt.Sprintf("%s %s", lister, c.shellEscape(name)), show)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	current := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimRight(line, "\r"), "\t", 2)
+		if len(fields) == 2 {
+			current[fields[0]] = fields[1]
+		}
+	}
+	return current, true, nil
+}
+
+// zfsPropertiesArg converts a properties argument to ZFS property values,
+// mapping booleans to on and off
+func zfsPropertiesArg(value interface{}) map[string]string {
+	props := make(map[string]string)
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return props
+	}
+	for key, v := range m {
+		switch b := v.(type) {
+		case bool:
+			if b {
+				props[key] = "on"
+			} else {
+				props[key] = "off"
+			}
+		default:
+			props[key] = types.ConvertToString(v)
+		}
+	}
+	return props
+}
+
+// sortedKeys returns the keys of a property map in order
+func sortedKeys(props map[string]string) []string {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// zfsChangedProperties returns the desired properties whose current value
+// differs
+func zfsChangedProperties(current, desired map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for key, want := range desired {
+		if have, ok := current[key]; !ok || !zfsValuesEqual(want, have) {
+			changed[key] = want
+		}
+	}
+	return changed
+}
+
+var zfsSizePattern = regexp.MustCompile(`(?i)^([0-9]+(?:\.[0-9]+)?)([KMGTPE]?)(?:i?B)?$`)
+
+// parseByteSize parses a size such as 10G or 1.5TiB into bytes
+func parseByteSize(s string) (float64, bool) {
+	match := zfsSizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if match[2] != "" {
+		exp := strings.Index("KMGTPE", strings.ToUpper(match[2])) + 1
+		value *= math.Pow(1024, float64(exp))
+	}
+	return value, true
+}
+
+// zfsValuesEqual compares a desired property value with the value zfs
+// reports. Sizes are displayed rounded to three significant digits, so
+// they match within half a percent.
+func zfsValuesEqual(want, have string) bool {
+	if strings.EqualFold(want, have) {
+		return true
+	}
+	wantSize, ok1 := parseByteSize(want)
+	haveSize, ok2 := parseByteSize(have)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if wantSize == haveSize {
+		return true
+	}
+	return math.Abs(wantSize-haveSize) <= 0.005*math.Max(wantSize, haveSize)
+}
+
+// mergeProperties returns current with the changed properties applied
+func mergeProperties(current, changed map[string]string) map[string]string {
+	merged := make(map[string]string, len(current))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range changed {
+		merged[key] = value
+	}
+	return merged
+}
+
+// formatStorageProperties renders an object and its properties for diffs
+func formatStorageProperties(name string, props map[string]string) string {
+	var b strings.Builder
+	b.WriteString(name + "\n")
+	for _, key := range sortedKeys(props) {
+		fmt.Fprintf(&b, "  %s=%s\n", key, props[key])
+	}
+	return b.String()
+}
+
+// zfsOptions renders properties as repeated option flags, e.g. -o k=v
+func (c remoteCLI) zfsOptions(flag string, props map[string]string) string {
+	var opts []string
+	for _, key := range sortedKeys(props) {
+		opts = append(opts, flag, c.shellEscape(key+"="+props[key]))
+	}
+	return strings.Join(opts, " ")
+}
+
+// changeResult fills in the common parts of the result of a module that
+// makes at most one described change
+func changeResult(m *BaseModule, result *types.Result, change string, checkMode, diffMode bool, before, after string, startTime time.Time) *types.Result {
+	result.Changed = change != ""
+	if result.Changed && checkMode {
+		result.Simulated = true
+		result.Data["check_mode"] = true
+		result.Message = "Would have " + change
+	} else if result.Changed {
+		result.Message = strings.ToUpper(change[:1]) + change[1:]
+	}
+
+	if diffMode && result.Changed {
+		result.Diff = m.GenerateDiff(before, after)
+	}
+
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result
+}
+
+// ZFSModule manages ZFS datasets, volumes and snapshots
+type ZFSModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewZFSModule creates a new zfs module instance
+func NewZFSModule() *ZFSModule {
+	doc := types.ModuleDoc{
+		Name:        "zfs",
+		Description: "Manage ZFS filesystems, volumes, clones and snapshots and their properties",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Dataset name, e.g. tank/data; names containing @ are snapshots",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the dataset should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"properties": {
+				Description: "ZFS properties to set, e.g. compression: lz4. A volsize property creates a volume",
+				Required:    false,
+				Type:        "dict",
+			},
+			"origin": {
+				Description: "Snapshot to clone the dataset from when it is created",
+				Required:    false,
+				Type:        "string",
+			},
+			"create_parent": {
+				Description: "Create missing parent datasets",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"recursive": {
+				Description: "Snapshot or destroy descendent datasets as well",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Create a compressed dataset\n  zfs:\n    name: tank/postgres\n    properties:\n      compression: lz4\n      recordsize: 16K\n      quota: 200G",
+			"- name: Snapshot before upgrading\n  zfs:\n    name: tank/postgres@pre-upgrade\n    state: present",
+			"- name: Create a 10G volume\n  zfs:\n    name: tank/vm01\n    properties:\n      volsize: 10G",
+		},
+		Returns: map[string]string{
+			"name":               "Dataset name",
+			"changed_properties": "Properties that were (or would be) set",
+		},
+	}
+
+	base := NewBaseModule("zfs", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &ZFSModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ZFSModule) Validate(args map[string]interface{}) error {
+	name := m.GetStringArg(args, "name", "")
+	if name == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	if props, ok := args["properties"]; ok {
+		if _, ok := props.(map[string]interface{}); !ok {
+			return types.NewValidationError("properties", props, "properties must be a dict")
+		}
+	}
+	if origin := m.GetStringArg(args, "origin", ""); origin != "" {
+		if !strings.Contains(origin, "@") {
+			return types.NewValidationError("origin", origin, "origin must be a snapshot")
+		}
+		if strings.Contains(name, "@") {
+			return types.NewValidationError("origin", origin, "a snapshot cannot be cloned from an origin")
+		}
+	}
+	return nil
+}
+
+// Run executes the zfs module
+func (m *ZFSModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	recursive := m.GetBoolArg(args, "recursive", false)
+	desired := zfsPropertiesArg(args["properties"])
+	snapshot := strings.Contains(name, "@")
+
+	current, exists, err := m.cli.zfsProperties(ctx, conn, "zfs list -H -t all -o name", "zfs get", name, sortedKeys(desired))
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "dataset"
+	if snapshot {
+		kind = "snapshot"
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("ZFS %s %s is already in desired state", kind, name), map[string]interface{}{
+		"name":  name,
+		"state": state,
+	})
+
+	var change, cmd, before, after string
+	switch {
+	case state == "absent" && exists:
+		change = fmt.Sprintf("destroyed %s %s", kind, name)
+		before = formatStorageProperties(name, current)
+		cmd = "zfs destroy"
+		if recursive {
+			cmd += " -r"
+		}
+		cmd += " " + m.cli.shellEscape(name)
+
+	case state == "present" && !exists:
+		change = fmt.Sprintf("created %s %s", kind, name)
+		after = formatStorageProperties(name, desired)
+		result.Data["changed_properties"] = desired
+		cmd = m.createCommand(name, desired, m.GetStringArg(args, "origin", ""), recursive, m.GetBoolArg(args, "create_parent", true))
+
+	case state == "present":
+		changed := zfsChangedProperties(current, desired)
+		if len(changed) == 0 {
+			break
+		}
+		change = fmt.Sprintf("set %s on %s", strings.Join(sortedKeys(changed), ", "), name)
+		before = formatStorageProperties(name, current)
+		after = formatStorageProperties(name, mergeProperties(current, changed))
+		result.Data["changed_properties"] = changed
+
+		var sets []string
+		for _, key := range sortedKeys(changed) {
+			sets = append(sets, fmt.Sprintf("zfs set %s %s", m.cli.shellEscape(key+"="+changed[key]), m.cli.shellEscape(name)))
+		}
+		cmd = strings.Join(sets, " && ")
+	}
+
+	if change != "" && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "zfs", cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
+
+// createCommand builds the command creating a snapshot, clone, volume or
+// filesystem
+func (m *ZFSModule) createCommand(name string, props map[string]string, origin string, recursive, parents bool) string {
+	var parts []string
+	options := make(map[string]string, len(props))
+	for key, value := range props {
+		options[key] = value
+	}
+
+	switch {
+	case strings.Contains(name, "@"):
+		parts = append(parts, "zfs snapshot")
+		if recursive {
+			parts = append(parts, "-r")
+		}
+	case origin != "":
+		parts = append(parts, "zfs clone")
+		if parents {
+			parts = append(parts, "-p")
+		}
+	default:
+		parts = append(parts, "zfs create")
+		if parents {
+			parts = append(parts, "-p")
+		}
+		if size, ok := options["volsize"]; ok {
+			parts = append(parts, "-V", m.cli.shellEscape(size))
+			delete(options, "volsize")
+		}
+	}
+
+	if len(options) > 0 {
+		parts = append(parts, m.cli.zfsOptions("-o", options))
+	}
+	if origin != "" {
+		parts = append(parts, m.cli.shellEscape(origin))
+	}
+	parts = append(parts, m.cli.shellEscape(name))
+	return strings.Join(parts, " ")
+}
+
+// ZpoolModule manages ZFS storage pools
+type ZpoolModule struct {
+	*BaseModule
+	cli remoteCLI
+}
+
+// NewZpoolModule creates a new zpool module instance
+func NewZpoolModule() *ZpoolModule {
+	doc := types.ModuleDoc{
+		Name:        "zpool",
+		Description: "Manage ZFS storage pools and their properties. The vdev layout of an existing pool is not changed",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Pool name",
+				Required:    true,
+				Type:        "string",
+			},
+			"state": {
+				Description: "Whether the pool should exist",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     []string{"present", "absent"},
+			},
+			"vdevs": {
+				Description: "Virtual devices used to create the pool, e.g. \"mirror /dev/sdb /dev/sdc\"",
+				Required:    false,
+				Type:        "list",
+			},
+			"properties": {
+				Description: "Pool properties, e.g. ashift: 12 or autotrim: on",
+				Required:    false,
+				Type:        "dict",
+			},
+			"filesystem_properties": {
+				Description: "Properties of the root dataset, applied when the pool is created",
+				Required:    false,
+				Type:        "dict",
+			},
+			"force": {
+				Description: "Force creating or destroying the pool",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+		},
+		Examples: []string{
+			"- name: Create a mirrored pool\n  zpool:\n    name: tank\n    vdevs:\n      - mirror /dev/sdb /dev/sdc\n    properties:\n      ashift: 12\n    filesystem_properties:\n      compression: lz4",
+		},
+		Returns: map[string]string{
+			"name":               "Pool name",
+			"changed_properties": "Pool properties that were (or would be) set",
+		},
+	}
+
+	base := NewBaseModule("zpool", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:    true,
+		DiffMode:     true,
+		Platform:     "linux",
+		RequiresRoot: true,
+	})
+
+	return &ZpoolModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ZpoolModule) Validate(args map[string]interface{}) error {
+	if m.GetStringArg(args, "name", "") == "" {
+		return types.NewValidationError("name", nil, "required parameter")
+	}
+	if err := m.ValidateChoices(args, "state", []string{"present", "absent"}); err != nil {
+		return err
+	}
+	for _, key := range []string{"properties", "filesystem_properties"} {
+		if props, ok := args[key]; ok {
+			if _, ok := props.(map[string]interface{}); !ok {
+				return types.NewValidationError(key, props, key+" must be a dict")
+			}
+		}
+	}
+	if vdevs, ok := args["vdevs"]; ok {
+		if _, ok := vdevs.([]interface{}); !ok {
+			return types.NewValidationError("vdevs", vdevs, "vdevs must be a list")
+		}
+	}
+	return nil
+}
+
+// Run executes the zpool module
+func (m *ZpoolModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	startTime := time.Now()
+	hostname := m.GetHostFromConnection(conn)
+	checkMode := m.CheckMode(args)
+	diffMode := m.DiffMode(args)
+
+	name := m.GetStringArg(args, "name", "")
+	state := m.GetStringArg(args, "state", "present")
+	force := m.GetBoolArg(args, "force", false)
+	desired := zfsPropertiesArg(args["properties"])
+
+	current, exists, err := m.cli.zfsProperties(ctx, conn, "zpool list -H -o name", "zpool get", name, sortedKeys(desired))
+	if err != nil {
+		return nil, err
+	}
+
+	result := m.CreateSuccessResult(hostname, false, fmt.Sprintf("ZFS pool %s is already in desired state", name), map[string]interface{}{
+		"name":  name,
+		"state": state,
+	})
+
+	var change, cmd, before, after string
+	switch {
+	case state == "absent" && exists:
+		change = "destroyed pool " + name
+		before = formatStorageProperties(name, current)
+		cmd = "zpool destroy"
+		if force {
+			cmd += " -f"
+		}
+		cmd += " " + m.cli.shellEscape(name)
+
+	case state == "present" && !exists:
+		var vdevs []string
+		for _, vdev := range m.GetSliceArg(args, "vdevs") {
+			vdevs = append(vdevs, strings.Fields(types.ConvertToString(vdev))...)
+		}
+		if len(vdevs) == 0 {
+			return nil, fmt.Errorf("vdevs are required to create pool %s", name)
+		}
+
+		change = "created pool " + name
+		after = formatStorageProperties(name, desired)
+		result.Data["changed_properties"] = desired
+
+		parts := []string{"zpool create"}
+		if force {
+			parts = append(parts, "-f")
+		}
+		if len(desired) > 0 {
+			parts = append(parts, m.cli.zfsOptions("-o", desired))
+		}
+		if fsProps := zfsPropertiesArg(args["filesystem_properties"]); len(fsProps) > 0 {
+			parts = append(parts, m.cli.zfsOptions("-O", fsProps))
+		}
+		parts = append(parts, m.cli.shellEscape(name))
+		for _, vdev := range vdevs {
+			parts = append(parts, m.cli.shellEscape(vdev))
+		}
+		cmd = strings.Join(parts, " ")
+
+	case state == "present":
+		changed := zfsChangedProperties(current, desired)
+		if len(changed) == 0 {
+			break
+		}
+		change = fmt.Sprintf("set %s on pool %s", strings.Join(sortedKeys(changed), ", "), name)
+		before = formatStorageProperties(name, current)
+		after = formatStorageProperties(name, mergeProperties(current, changed))
+		result.Data["changed_properties"] = changed
+
+		var sets []string
+		for _, key := range sortedKeys(changed) {
+			sets = append(sets, fmt.Sprintf("zpool set %s %s", m.cli.shellEscape(key+"="+changed[key]), m.cli.shellEscape(name)))
+		}
+		cmd = strings.Join(sets, " && ")
+	}
+
+	if change != "" && !checkMode {
+		if _, err := m.cli.run(ctx, conn, "zpool", cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	return changeResult(m.BaseModule, result, change, checkMode, diffMode, before, after, startTime), nil
+}
@@ -0,0 +1,204 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestZFSValuesEqual(t *testing.T) {
+	tests := []struct {
+		want, have string
+		equal      bool
+	}{
+		{"lz4", "lz4", true},
+		{"ON", "on", true},
+		{"10G", "10G", true},
+		{"10240M", "10G", true},
+		{"1500M", "1.46G", true},
+		{"10G", "11G", false},
+		{"lz4", "zstd", false},
+		{"none", "10G", false},
+	}
+
+	for _, tt := range tests {
+		if got := zfsValuesEqual(tt.want, tt.have); got != tt.equal {
+			t.Errorf("zfsValuesEqual(%q, %q) = %v, want %v", tt.want, tt.have, got, tt.equal)
+		}
+	}
+}
+
+func TestZFSModule(t *testing.T) {
+	module := NewZFSModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "ValidDataset", Args: map[string]interface{}{"name": "tank/data", "properties": map[string]interface{}{"compression": "lz4"}}, ExpectValid: true},
+		{Name: "ValidClone", Args: map[string]interface{}{"name": "tank/clone", "origin": "tank/data@snap"}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{}, ExpectValid: false},
+		{Name: "PropertiesNotDict", Args: map[string]interface{}{"name": "tank/data", "properties": "compression=lz4"}, ExpectValid: false},
+		{Name: "OriginNotSnapshot", Args: map[string]interface{}{"name": "tank/clone", "origin": "tank/data"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "CreateDataset",
+			Args: map[string]interface{}{"name": "tank/pg", "properties": map[string]interface{}{"compression": "lz4", "atime": false}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if zfs list -H -t all -o name 'tank/pg' >/dev/null 2>&1; then .*; zfs get -H -o property,value 'atime,compression' 'tank/pg'; fi$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^zfs create -p -o 'atime=off' -o 'compression=lz4' 'tank/pg'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created dataset tank/pg")
+			},
+		},
+		{
+			Name: "CreateVolume",
+			Args: map[string]interface{}{"name": "tank/vm01", "properties": map[string]interface{}{"volsize": "10G"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^zfs create -p -V '10G' 'tank/vm01'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "RecursiveSnapshot",
+			Args: map[string]interface{}{"name": "tank@nightly", "recursive": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^zfs snapshot -r 'tank@nightly'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Created snapshot tank@nightly")
+			},
+		},
+		{
+			Name: "EquivalentPropertiesUnchanged",
+			Args: map[string]interface{}{"name": "tank/pg", "properties": map[string]interface{}{"compression": "lz4", "quota": "204800M"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{Stdout: existsMarker + "compression\tlz4\nquota\t200G\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+		{
+			Name:      "PropertyDiffInCheckMode",
+			Args:      map[string]interface{}{"name": "tank/pg", "properties": map[string]interface{}{"compression": "zstd", "quota": "200G"}},
+			CheckMode: true,
+			DiffMode:  true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{Stdout: existsMarker + "compression\tlz4\nquota\t200G\n"})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertCheckModeSimulated(result)
+				h.AssertDiffPresent(result)
+				h.AssertMessage(result, "Would have set compression on tank/pg")
+				changed := result.Data["changed_properties"].(map[string]string)
+				if len(changed) != 1 || changed["compression"] != "zstd" {
+					t.Errorf("expected only compression to change, got %v", changed)
+				}
+				if !strings.Contains(result.Diff.After, "compression=zstd") || !strings.Contains(result.Diff.Before, "compression=lz4") {
+					t.Errorf("unexpected diff %+v", result.Diff)
+				}
+			},
+		},
+		{
+			Name: "SetChangedProperties",
+			Args: map[string]interface{}{"name": "tank/pg", "properties": map[string]interface{}{"compression": "zstd", "quota": "100G"}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{Stdout: existsMarker + "compression\tlz4\nquota\t200G\n"})
+				h.GetConnection().ExpectCommandPattern(`^zfs set 'compression=zstd' 'tank/pg' && zfs set 'quota=100G' 'tank/pg'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "Destroy",
+			Args: map[string]interface{}{"name": "tank/old", "state": "absent", "recursive": true},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list -H -t all -o name 'tank/old' >/dev/null 2>&1; then printf '%s' '__gosible_exists__\n'; fi$`, &testhelper.CommandResponse{Stdout: existsMarker})
+				h.GetConnection().ExpectCommandPattern(`^zfs destroy -r 'tank/old'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertMessage(result, "Destroyed dataset tank/old")
+			},
+		},
+		{
+			Name:        "CommandFailure",
+			Args:        map[string]interface{}{"name": "tank/pg"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zfs list `, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^zfs create `, &testhelper.CommandResponse{ExitCode: 1, Stderr: "cannot create 'tank/pg': no such pool 'tank'"})
+			},
+		},
+	})
+}
+
+func TestZpoolModule(t *testing.T) {
+	module := NewZpoolModule()
+	helper := testhelper.NewModuleTestHelper(t, module)
+
+	helper.RunValidationTests([]testhelper.ValidationTestCase{
+		{Name: "Valid", Args: map[string]interface{}{"name": "tank", "vdevs": []interface{}{"mirror /dev/sdb /dev/sdc"}}, ExpectValid: true},
+		{Name: "MissingName", Args: map[string]interface{}{"vdevs": []interface{}{"/dev/sdb"}}, ExpectValid: false},
+		{Name: "VdevsNotList", Args: map[string]interface{}{"name": "tank", "vdevs": "/dev/sdb"}, ExpectValid: false},
+	})
+
+	helper.RunTestCases([]testhelper.TestCase{
+		{
+			Name: "CreatePool",
+			Args: map[string]interface{}{
+				"name":                  "tank",
+				"vdevs":                 []interface{}{"mirror /dev/sdb /dev/sdc"},
+				"properties":            map[string]interface{}{"ashift": 12},
+				"filesystem_properties": map[string]interface{}{"compression": "lz4"},
+			},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`(?s)^if zpool list -H -o name 'tank' .*zpool get -H -o property,value 'ashift' 'tank'; fi$`, &testhelper.CommandResponse{})
+				h.GetConnection().ExpectCommandPattern(`^zpool create -o 'ashift=12' -O 'compression=lz4' 'tank' 'mirror' '/dev/sdb' '/dev/sdc'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+				h.AssertMessage(result, "Created pool tank")
+			},
+		},
+		{
+			Name:        "CreateWithoutVdevs",
+			Args:        map[string]interface{}{"name": "tank"},
+			ExpectError: true,
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zpool list `, &testhelper.CommandResponse{})
+			},
+		},
+		{
+			Name: "UpdateProperty",
+			Args: map[string]interface{}{"name": "tank", "properties": map[string]interface{}{"autotrim": true}},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zpool list `, &testhelper.CommandResponse{Stdout: existsMarker + "autotrim\toff\n"})
+				h.GetConnection().ExpectCommandPattern(`^zpool set 'autotrim=on' 'tank'$`, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertChanged(result)
+			},
+		},
+		{
+			Name: "AbsentPoolUnchanged",
+			Args: map[string]interface{}{"name": "tank", "state": "absent"},
+			Setup: func(h *testhelper.ModuleTestHelper) {
+				h.GetConnection().ExpectCommandPattern(`^if zpool list `, &testhelper.CommandResponse{})
+			},
+			Assertions: func(h *testhelper.ModuleTestHelper, result *types.Result) {
+				h.AssertNotChanged(result)
+			},
+		},
+	})
+}
@@ -0,0 +1,125 @@
+package modules
+
+import (
+	"context"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ZypperModule manages packages using zypper on SUSE and openSUSE systems
+type ZypperModule struct {
+	*BaseModule
+}
+
+// NewZypperModule creates a new zypper module instance
+func NewZypperModule() *ZypperModule {
+	doc := types.ModuleDoc{
+		Name:        "zypper",
+		Description: "Manage packages using zypper on SUSE Linux Enterprise and openSUSE systems",
+		Parameters: map[string]types.ParamDoc{
+			"name": {
+				Description: "Package name, or list or comma separated string of names",
+				Required:    false,
+				Type:        "list",
+			},
+			"names": {
+				Description: "List of packages to manage",
+				Required:    false,
+				Type:        "list",
+			},
+			"state": {
+				Description: "State of the packages",
+				Required:    false,
+				Type:        "string",
+				Default:     "present",
+				Choices:     packageStates,
+			},
+			"update_cache": {
+				Description: "Refresh the repositories before managing packages",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"disable_recommends": {
+				Description: "Do not install recommended packages",
+				Required:    false,
+				Type:        "bool",
+				Default:     true,
+			},
+			"disable_gpg_check": {
+				Description: "Install packages whose signature cannot be checked",
+				Required:    false,
+				Type:        "bool",
+				Default:     false,
+			},
+			"lock_timeout": {
+				Description: "Seconds to wait for another process to release the package manager lock",
+				Required:    false,
+				Type:        "int",
+				Default:     defaultLockTimeout,
+			},
+		},
+		Examples: []string{
+			"- name: Install the web server\n  zypper:\n    name: [nginx, logrotate]\n    update_cache: true",
+			"- name: Keep OpenSSL up to date\n  zypper:\n    name: openssl\n    state: latest",
+		},
+		Returns: map[string]string{
+			"installed":     "Packages that were installed",
+			"upgraded":      "Packages that were upgraded",
+			"removed":       "Packages that were removed",
+			"cache_updated": "Whether the repositories were refreshed",
+		},
+	}
+
+	base := NewBaseModule("zypper", doc)
+	base.SetCapabilities(&types.ModuleCapability{
+		CheckMode:        true,
+		DiffMode:         true,
+		Platform:         "linux",
+		RequiresRoot:     true,
+		ConcurrencyClass: types.ConcurrencyClassPackageManager,
+	})
+
+	return &ZypperModule{BaseModule: base}
+}
+
+// Validate validates the module arguments
+func (m *ZypperModule) Validate(args map[string]interface{}) error {
+	return validatePackageArgs(m.BaseModule, args)
+}
+
+// Run executes the zypper module
+func (m *ZypperModule) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	global := "zypper --non-interactive"
+	if m.GetBoolArg(args, "disable_gpg_check", false) {
+		global += " --no-gpg-checks"
+	}
+	options := " --auto-agree-with-licenses"
+	if m.GetBoolArg(args, "disable_recommends", true) {
+		options += " --no-recommends"
+	}
+	return nativePackageManager{
+		name:          "zypper",
+		probe:         "rpm -q --quiet",
+		outdated:      "zypper --non-interactive --quiet list-updates",
+		refresh:       global + " refresh",
+		install:       global + " install" + options,
+		upgrade:       global + " update" + options,
+		remove:        global + " remove",
+		parseOutdated: parseZypperUpdates,
+	}.run(ctx, m.BaseModule, conn, args)
+}
+
+// parseZypperUpdates reads the table of zypper list-updates, whose rows
+// start with "v |" and name the package in the third column
+func parseZypperUpdates(stdout string) []string {
+	var packages []string
+	for _, line := range strings.Split(stdout, "\n") {
+		columns := strings.Split(line, "|")
+		if len(columns) >= 3 && strings.TrimSpace(columns[0]) == "v" {
+			packages = append(packages, strings.TrimSpace(columns[2]))
+		}
+	}
+	return packages
+}
@@ -0,0 +1,225 @@
+package playbook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// playGraph orders the plays of a playbook by their depends_on
+type playGraph struct {
+	dependencies [][]int // plays each play waits for
+	dependents   [][]int // plays waiting for each play
+}
+
+// hasPlayDependencies reports whether any play declares depends_on, which
+// switches the playbook from running its plays in order to running them as
+// a graph
+func hasPlayDependencies(plays []types.Play) bool {
+	for i := range plays {
+		if len(plays[i].DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newPlayGraph resolves the depends_on of each play to play indexes. Plays
+// named by depends_on must exist and be uniquely named, and the
+// dependencies must not form a cycle.
+func newPlayGraph(plays []types.Play) (*playGraph, error) {
+	byName := make(map[string]int, len(plays))
+	duplicates := make(map[string]bool)
+	for i := range plays {
+		if _, seen := byName[plays[i].Name]; seen {
+			duplicates[plays[i].Name] = true
+		}
+		byName[plays[i].Name] = i
+	}
+
+	graph := &playGraph{
+		dependencies: make([][]int, len(plays)),
+		dependents:   make([][]int, len(plays)),
+	}
+	for i := range plays {
+		listed := make(map[int]bool)
+		for _, name := range plays[i].DependsOn {
+			dep, ok := byName[name]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("play '%s' depends on unknown play '%s'", plays[i].Name, name)
+			case duplicates[name]:
+				return nil, fmt.Errorf("play '%s' depends on '%s', which names more than one play", plays[i].Name, name)
+			case dep == i:
+				return nil, fmt.Errorf("play '%s' cannot depend on itself", name)
+			case listed[dep]:
+				continue
+			}
+			listed[dep] = true
+			graph.dependencies[i] = append(graph.dependencies[i], dep)
+			graph.dependents[dep] = append(graph.dependents[dep], i)
+		}
+	}
+
+	if cycle := graph.cycle(); cycle != nil {
+		names := make([]string, len(cycle))
+		for i, play := range cycle {
+			names[i] = plays[play].Name
+		}
+		return nil, fmt.Errorf("circular depends_on: %s", strings.Join(names, " -> "))
+	}
+	return graph, nil
+}
+
+// cycle returns the plays of a dependency cycle, starting and ending with
+// the same play, or nil when there is none
+func (g *playGraph) cycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.dependencies))
+	var path []int
+
+	var visit func(play int) []int
+	visit = func(play int) []int {
+		state[play] = visiting
+		path = append(path, play)
+		for _, dep := range g.dependencies[play] {
+			switch state[dep] {
+			case visiting:
+				for i, p := range path {
+					if p == dep {
+						return append(append([]int{}, path[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[play] = visited
+		return nil
+	}
+
+	for play := range g.dependencies {
+		if state[play] == unvisited {
+			if cycle := visit(play); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// skipDependents marks every play that depends on play, directly or
+// through other plays, as skipped, returning the plays newly marked
+func (g *playGraph) skipDependents(play int, skipped []bool) []int {
+	var marked []int
+	for _, dependent := range g.dependents[play] {
+		if skipped[dependent] {
+			continue
+		}
+		skipped[dependent] = true
+		marked = append(marked, dependent)
+		marked = append(marked, g.skipDependents(dependent, skipped)...)
+	}
+	return marked
+}
+
+// playOutcome is the result of one play run by executeGraph
+type playOutcome struct {
+	index   int
+	results []types.Result
+	err     error
+}
+
+// executeGraph runs the plays of a playbook as soon as the plays they
+// depend on have completed, so independent plays run concurrently. A play
+// that fails skips the plays that depend on it; the others still run, and
+// the first failure is returned once they have. Results are returned in
+// playbook order.
+func (e *Executor) executeGraph(ctx context.Context, playbook *types.Playbook, graph *playGraph, vars map[string]interface{}) ([]types.Result, error) {
+	plays := playbook.Plays
+	waiting := make([]int, len(plays))
+	for i := range plays {
+		waiting[i] = len(graph.dependencies[i])
+	}
+	skipped := make([]bool, len(plays))
+	results := make([][]types.Result, len(plays))
+
+	done := make(chan playOutcome)
+	running := 0
+	start := func(i int) {
+		running++
+		// Each play gets its own executor, as the strategy, handlers and
+		// maintenance window of the play being executed live on it
+		child := e.forPlay()
+		go func() {
+			res, err := child.runPlay(ctx, i, &plays[i], vars)
+			done <- playOutcome{index: i, results: res, err: err}
+		}()
+	}
+	for i := range plays {
+		if waiting[i] == 0 {
+			start(i)
+		}
+	}
+
+	var firstErr error
+	for running > 0 {
+		outcome := <-done
+		running--
+		results[outcome.index] = outcome.results
+
+		if outcome.err != nil || e.shouldStopOnFailure(outcome.results) {
+			if firstErr == nil && outcome.err != nil {
+				firstErr = outcome.err
+			}
+			for _, i := range graph.skipDependents(outcome.index, skipped) {
+				e.emitEvent(types.Event{
+					Type:      types.EventPlaySkipped,
+					Timestamp: types.GetCurrentTime(),
+					Play:      plays[i].Name,
+					Data: map[string]interface{}{
+						"play_index": i,
+						"play_name":  plays[i].Name,
+						"failed":     plays[outcome.index].Name,
+					},
+				})
+			}
+			continue
+		}
+
+		for _, dependent := range graph.dependents[outcome.index] {
+			waiting[dependent]--
+			if waiting[dependent] == 0 && !skipped[dependent] {
+				start(dependent)
+			}
+		}
+	}
+
+	var allResults []types.Result
+	for _, res := range results {
+		allResults = append(allResults, res...)
+	}
+	return allResults, firstErr
+}
+
+// forPlay returns a copy of the executor for running one play alongside
+// others. It shares the runner, inventory, callbacks, roles and includes,
+// and starts without per-play state.
+func (e *Executor) forPlay() *Executor {
+	child := *e
+	child.strategy = nil
+	child.forks = 0
+	child.failures = nil
+	child.handlers = nil
+	child.window = nil
+	return &child
+}
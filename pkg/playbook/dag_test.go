@@ -0,0 +1,240 @@
+package playbook
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/strategy"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func dependentPlay(name string, dependsOn ...string) types.Play {
+	return types.Play{
+		Name:      name,
+		Hosts:     "web1",
+		Vars:      map[string]interface{}{"gather_facts": false},
+		Tasks:     []types.Task{debugTask(name)},
+		DependsOn: dependsOn,
+	}
+}
+
+func TestPlayGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		plays []types.Play
+		err   string
+	}{
+		{
+			name:  "Valid",
+			plays: []types.Play{dependentPlay("db"), dependentPlay("cache"), dependentPlay("app", "db", "cache", "db")},
+		},
+		{
+			name:  "UnknownPlay",
+			plays: []types.Play{dependentPlay("app", "db")},
+			err:   "play 'app' depends on unknown play 'db'",
+		},
+		{
+			name:  "SelfDependency",
+			plays: []types.Play{dependentPlay("app", "app")},
+			err:   "play 'app' cannot depend on itself",
+		},
+		{
+			name:  "AmbiguousName",
+			plays: []types.Play{dependentPlay("db"), dependentPlay("db"), dependentPlay("app", "db")},
+			err:   "names more than one play",
+		},
+		{
+			name:  "Cycle",
+			plays: []types.Play{dependentPlay("db", "app"), dependentPlay("cache", "db"), dependentPlay("app", "cache")},
+			err:   "circular depends_on: db -> app -> cache -> db",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := newPlayGraph(tt.plays)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("newPlayGraph failed: %v", err)
+				}
+				if deps := graph.dependencies[2]; len(deps) != 2 || deps[0] != 0 || deps[1] != 1 {
+					t.Errorf("expected app to depend on plays 0 and 1 once, got %v", deps)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestExecutorPlayDependencies(t *testing.T) {
+	runner := newRecordingRunner()
+
+	// db only finishes once cache has started, which deadlocks unless the
+	// two plays run concurrently
+	cacheStarted := make(chan struct{})
+	runner.onRun = func(task types.Task) {
+		switch task.Name {
+		case "cache":
+			close(cacheStarted)
+		case "db":
+			select {
+			case <-cacheStarted:
+			case <-time.After(5 * time.Second):
+				t.Error("expected cache to run alongside db")
+			}
+		}
+	}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	playbook := &types.Playbook{Plays: []types.Play{
+		dependentPlay("db"),
+		dependentPlay("cache"),
+		dependentPlay("app", "db", "cache"),
+		dependentPlay("smoke", "app"),
+	}}
+	results, err := executor.Execute(context.Background(), playbook, nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	got := runner.taskNames()
+	if len(got) != 4 || got[2] != "app" || got[3] != "smoke" {
+		t.Errorf("expected app and smoke to run after db and cache, got %v", got)
+	}
+	var order []string
+	for _, result := range results {
+		order = append(order, result.TaskName)
+	}
+	if strings.Join(order, ",") != "db,cache,app,smoke" {
+		t.Errorf("expected results in playbook order, got %v", order)
+	}
+}
+
+func TestExecutorPlayDependencyFailure(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.failOn["db"] = map[string]bool{"web1": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	var mu sync.Mutex
+	var skipped []string
+	executor.AddEventCallback(func(event types.Event) {
+		if event.Type == types.EventPlaySkipped {
+			mu.Lock()
+			skipped = append(skipped, event.Play+" after "+event.Data["failed"].(string))
+			mu.Unlock()
+		}
+	})
+
+	playbook := &types.Playbook{Plays: []types.Play{
+		dependentPlay("db"),
+		dependentPlay("cache"),
+		dependentPlay("app", "db", "cache"),
+		dependentPlay("smoke", "app"),
+		dependentPlay("cache-warm", "cache"),
+	}}
+	_, err := executor.Execute(context.Background(), playbook, nil)
+	if err == nil || !strings.Contains(err.Error(), "db") {
+		t.Fatalf("expected the db play to fail, got %v", err)
+	}
+
+	ran := map[string]bool{}
+	for _, name := range runner.taskNames() {
+		ran[name] = true
+	}
+	if ran["app"] || ran["smoke"] {
+		t.Errorf("expected the plays depending on db to be skipped, ran %v", runner.taskNames())
+	}
+	if !ran["cache"] || !ran["cache-warm"] {
+		t.Errorf("expected the plays independent of db to run, ran %v", runner.taskNames())
+	}
+	if strings.Join(skipped, ",") != "app after db,smoke after db" {
+		t.Errorf("unexpected skip events %v", skipped)
+	}
+}
+
+// forksStrategy records the forks each task ran with, running tasks
+// through a free strategy shared by all plays
+type forksStrategy struct {
+	*strategy.FreeStrategy
+	mu    sync.Mutex
+	forks map[string]int
+}
+
+func (s *forksStrategy) Name() string { return "recorded_forks" }
+
+func (s *forksStrategy) Execute(ctx context.Context, tasks []types.Task, hosts []types.Host, executor strategy.TaskExecutor) ([]types.Result, error) {
+	s.mu.Lock()
+	for _, task := range tasks {
+		s.forks[task.Name] = strategy.Forks(ctx, 0)
+	}
+	s.mu.Unlock()
+	return s.FreeStrategy.Execute(ctx, tasks, hosts, executor)
+}
+
+func TestExecutorPlayDependencyForks(t *testing.T) {
+	runner := newRecordingRunner()
+
+	// db and cache wait for each other, so they run at the same time
+	var started sync.WaitGroup
+	started.Add(2)
+	runner.onRun = func(task types.Task) {
+		if task.Name != "app" {
+			started.Done()
+			started.Wait()
+		}
+	}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+	recorded := &forksStrategy{FreeStrategy: strategy.NewFreeStrategy(), forks: make(map[string]int)}
+	executor.RegisterStrategy(recorded)
+
+	play := func(name string, forks int) types.Play {
+		p := dependentPlay(name)
+		p.Strategy = recorded.Name()
+		p.Vars["ansible_forks"] = forks
+		return p
+	}
+	playbook := &types.Playbook{Plays: []types.Play{play("db", 1), play("cache", 7), dependentPlay("app", "db", "cache")}}
+	if _, err := executor.Execute(context.Background(), playbook, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if recorded.forks["db"] != 1 || recorded.forks["cache"] != 7 {
+		t.Errorf("expected each play to run with its own forks, got %v", recorded.forks)
+	}
+}
+
+func TestParsePlayDependencies(t *testing.T) {
+	data := `
+- name: db
+  hosts: db
+  tasks:
+    - name: migrate
+      debug:
+        msg: migrate
+- name: app
+  hosts: web
+  depends_on: [db, cache]
+  tasks:
+    - name: deploy
+      debug:
+        msg: deploy
+`
+	_, err := NewParser().Parse([]byte(data), "site.yml")
+	if err == nil || !strings.Contains(err.Error(), "play 'app' depends on unknown play 'cache'") {
+		t.Fatalf("expected an unknown dependency error, got %v", err)
+	}
+
+	playbook, err := NewParser().Parse([]byte(strings.Replace(data, "[db, cache]", "[db]", 1)), "site.yml")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if deps := playbook.Plays[1].DependsOn; len(deps) != 1 || deps[0] != "db" {
+		t.Errorf("expected app to depend on db, got %v", deps)
+	}
+}
@@ -3,9 +3,16 @@ package playbook
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/roles"
+	"github.com/liliang-cn/gosible/pkg/strategy"
 	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
 )
 
 // Executor handles playbook execution
@@ -14,23 +21,96 @@ type Executor struct {
 	inventory types.Inventory
 	varMgr    types.VarManager
 	events    []types.EventCallback
+
+	// Callback plugins reporting plays, tasks and host results
+	callbacks *callback.CallbackManager
+
+	// Reachability check of the playbook's hosts before its first task
+	preflight *preflightCheck
+
+	// Execution strategies available to plays, and the one selected for the
+	// play being executed (nil runs tasks in lockstep) with its forks. The
+	// strategies are shared by plays running alongside each other, so forks
+	// are passed to each Execute rather than set on the strategy.
+	strategies *strategy.StrategyManager
+	strategy   strategy.Strategy
+	forks      int
+
+	// Failed hosts of the current serial batch when the play sets
+	// max_fail_percentage (nil stops the play on any failure)
+	failures *batchFailures
+
+	// Handlers notified during the play being executed
+	handlers *handlerQueue
+
+	// Roles applied by plays through their roles keyword
+	roles *roles.RoleManager
+
+	// Resolves the task files of include_tasks whose names use variables
+	includes *IncludeManager
+
+	// Decrypts vault encrypted role and task files loaded while running
+	vaults *vault.Manager
+
+	// Selects the tasks to run by their tags
+	tags TagSelection
+
+	// Host pattern restricting the hosts of every play, like --limit
+	limit string
+
+	// Task to start at and the operator's confirmation of each task,
+	// shared by plays running alongside each other
+	step *stepControl
+
+	// Maintenance window enforcement for the play being executed
+	window *windowGuard
+	clock  func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
 }
 
 // NewExecutor creates a new playbook executor
 func NewExecutor(runner types.Runner, inventory types.Inventory, varMgr types.VarManager) *Executor {
 	return &Executor{
-		runner:    runner,
-		inventory: inventory,
-		varMgr:    varMgr,
-		events:    make([]types.EventCallback, 0),
+		runner:     runner,
+		inventory:  inventory,
+		varMgr:     varMgr,
+		events:     make([]types.EventCallback, 0),
+		strategies: strategy.NewStrategyManager(),
+		roles:      roles.NewRoleManager(nil),
+		includes:   NewIncludeManager("."),
+		step:       &stepControl{},
+		clock:      time.Now,
+		sleep:      sleepContext,
 	}
 }
 
+// SetVaultManager decrypts the role and task files loaded while the
+// playbook runs, and inline !vault values in them, with the passwords of
+// vaults. Playbooks are decrypted by the parser.
+func (e *Executor) SetVaultManager(vaults *vault.Manager) {
+	e.vaults = vaults
+	e.includes.vaults = vaults
+	e.roles.SetVaultManager(vaults)
+}
+
 // AddEventCallback adds an event callback
 func (e *Executor) AddEventCallback(callback types.EventCallback) {
 	e.events = append(e.events, callback)
 }
 
+// SetCallbacks reports the plays, tasks and host results of the runs to the
+// callback plugins of the manager. The caller ends the run with
+// OnRunnerEnd once all playbooks have executed.
+func (e *Executor) SetCallbacks(callbacks *callback.CallbackManager) {
+	e.callbacks = callbacks
+}
+
+// RegisterStrategy makes a custom execution strategy available to plays
+// through their strategy keyword
+func (e *Executor) RegisterStrategy(s strategy.Strategy) {
+	e.strategies.Register(s)
+}
+
 // emitEvent emits an event to all callbacks
 func (e *Executor) emitEvent(event types.Event) {
 	for _, callback := range e.events {
@@ -43,55 +123,109 @@ func (e *Executor) Execute(ctx context.Context, playbook *types.Playbook, extraV
 	var allResults []types.Result
 
 	// Merge playbook vars with extra vars
-	playbookVars := make(map[string]interface{})
-	if playbook.Vars != nil {
-		playbookVars = types.DeepMergeInterfaceMaps(playbookVars, playbook.Vars)
+	playbookVars := runVars(playbook, extraVars)
+
+	// Start at the start task, if any, on every run
+	e.beginSteps()
+
+	// Find unreachable hosts before running anything
+	if e.preflight != nil {
+		results, err := e.runPreflight(ctx, playbook)
+		allResults = append(allResults, results...)
+		if err != nil {
+			return allResults, err
+		}
+		defer func() { e.preflight.unreachable = nil }()
 	}
-	if extraVars != nil {
-		playbookVars = types.DeepMergeInterfaceMaps(playbookVars, extraVars)
+
+	// Plays with dependencies run as a graph, the others in order
+	if hasPlayDependencies(playbook.Plays) {
+		graph, err := newPlayGraph(playbook.Plays)
+		if err != nil {
+			return allResults, types.NewPlaybookError("playbook", "", "", "invalid play dependencies", err)
+		}
+		results, err := e.executeGraph(ctx, playbook, graph, playbookVars)
+		allResults = append(allResults, results...)
+		if err != nil {
+			return allResults, err
+		}
+		return allResults, e.checkStarted()
 	}
 
 	// Execute each play in the playbook
-	for i, play := range playbook.Plays {
-		e.emitEvent(types.Event{
-			Type:      types.EventPlayStart,
-			Timestamp: types.GetCurrentTime(),
-			Play:      play.Name,
-			Data: map[string]interface{}{
-				"play_index": i,
-				"play_name":  play.Name,
-			},
-		})
-
-		results, err := e.ExecutePlay(ctx, &play, playbookVars)
+	for i := range playbook.Plays {
+		results, err := e.runPlay(ctx, i, &playbook.Plays[i], playbookVars)
+		allResults = append(allResults, results...)
 		if err != nil {
-			e.emitEvent(types.Event{
-				Type:      types.EventError,
-				Timestamp: types.GetCurrentTime(),
-				Play:      play.Name,
-				Error:     err,
-			})
-			return allResults, types.NewPlaybookError("playbook", play.Name, "", "play execution failed", err)
+			return allResults, err
 		}
 
-		allResults = append(allResults, results...)
+		// Check if we should stop on failure
+		if e.shouldStopOnFailure(results) {
+			return allResults, nil
+		}
+	}
 
+	return allResults, e.checkStarted()
+}
+
+// runVars merges the vars of a playbook with the extra vars of a run
+func runVars(playbook *types.Playbook, extraVars map[string]interface{}) map[string]interface{} {
+	vars := make(map[string]interface{})
+	if playbook.Vars != nil {
+		vars = types.DeepMergeInterfaceMaps(vars, playbook.Vars)
+	}
+	if extraVars != nil {
+		vars = types.DeepMergeInterfaceMaps(vars, extraVars)
+
+		// Extra vars override every other variable, so they are carried
+		// along to be applied again over play, task and host variables
+		vars[extraVarsVar] = extraVars
+	}
+	return vars
+}
+
+// runPlay executes the play at index i of a playbook, reporting its start
+// and end to callbacks and event listeners
+func (e *Executor) runPlay(ctx context.Context, i int, play *types.Play, vars map[string]interface{}) ([]types.Result, error) {
+	if e.callbacks != nil {
+		e.callbacks.OnPlayStart(play)
+	}
+	e.emitEvent(types.Event{
+		Type:      types.EventPlayStart,
+		Timestamp: types.GetCurrentTime(),
+		Play:      play.Name,
+		Data: map[string]interface{}{
+			"play_index": i,
+			"play_name":  play.Name,
+		},
+	})
+
+	results, err := e.ExecutePlay(ctx, play, vars)
+	if err != nil {
 		e.emitEvent(types.Event{
-			Type:      types.EventPlayComplete,
+			Type:      types.EventError,
 			Timestamp: types.GetCurrentTime(),
 			Play:      play.Name,
-			Data: map[string]interface{}{
-				"results_count": len(results),
-			},
+			Error:     err,
 		})
+		return results, types.NewPlaybookError("playbook", play.Name, "", "play execution failed", err)
+	}
 
-		// Check if we should stop on failure
-		if e.shouldStopOnFailure(results) {
-			break
-		}
+	if e.callbacks != nil {
+		e.callbacks.OnPlayEnd(play, results)
 	}
 
-	return allResults, nil
+	e.emitEvent(types.Event{
+		Type:      types.EventPlayComplete,
+		Timestamp: types.GetCurrentTime(),
+		Play:      play.Name,
+		Data: map[string]interface{}{
+			"results_count": len(results),
+		},
+	})
+
+	return results, nil
 }
 
 // ExecutePlay executes a single play
@@ -101,28 +235,109 @@ func (e *Executor) ExecutePlay(ctx context.Context, play *types.Play, vars map[s
 	if err != nil {
 		return nil, fmt.Errorf("failed to get hosts for play %s: %w", play.Name, err)
 	}
+	hosts = e.reachableHosts(hosts)
 
 	if len(hosts) == 0 {
 		return []types.Result{}, nil
 	}
 
+	// Enforce the maintenance window before making any changes
+	if play.MaintenanceWindow != nil {
+		guard, err := e.newWindowGuard(play)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window for play %s: %w", play.Name, err)
+		}
+		if err := guard.start(ctx); err != nil {
+			return nil, err
+		}
+		e.window = guard
+		defer func() { e.window = nil }()
+	}
+
 	// Merge play vars with provided vars
 	playVars := e.mergePlayVars(play, vars)
 
+	// Roles run as the first tasks of the play, scoped to their variables
+	if len(play.Roles) > 0 {
+		expanded, err := e.expandRoles(play, playVars)
+		if err != nil {
+			return nil, fmt.Errorf("play %s: %w", play.Name, err)
+		}
+		play = expanded
+	}
+
+	// Tasks, including those of roles, inherit the play's tags
+	play = inheritPlayTags(play)
+
+	handlers, err := newHandlerQueue(play)
+	if err != nil {
+		return nil, fmt.Errorf("play %s: %w", play.Name, err)
+	}
+	e.handlers = handlers
+	defer func() { e.handlers = nil }()
+
+	// Select the execution strategy for this play
+	strat, err := e.playStrategy(play)
+	if err != nil {
+		return nil, fmt.Errorf("play %s: %w", play.Name, err)
+	}
+	e.strategy = strat
+	e.forks, _ = playVars["ansible_forks"].(int)
+	defer func() { e.strategy, e.forks = nil, 0 }()
+
+	// Split the hosts into rolling-update batches
+	batches, err := e.playBatches(play, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("play %s has invalid serial: %w", play.Name, err)
+	}
+	defer func() { e.failures = nil }()
+
+	var allResults []types.Result
+
+	for i, batch := range batches {
+		if e.window != nil {
+			e.window.batch = i
+		}
+		if play.MaxFailPercentage != nil {
+			e.failures = newBatchFailures(len(batch), *play.MaxFailPercentage)
+		}
+
+		results, err := e.executeBatch(ctx, play, batch, playVars)
+		allResults = append(allResults, results...)
+		if err != nil {
+			if len(batches) > 1 {
+				err = fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+			}
+			return allResults, err
+		}
+	}
+
+	if e.window != nil {
+		if err := e.window.finish(); err != nil {
+			return allResults, err
+		}
+	}
+
+	return allResults, nil
+}
+
+// executeBatch runs the play's tasks on one batch of hosts, flushing the
+// notified handlers after each section as Ansible does
+func (e *Executor) executeBatch(ctx context.Context, play *types.Play, hosts []types.Host, playVars map[string]interface{}) ([]types.Result, error) {
 	var allResults []types.Result
 
 	// Execute pre_tasks
 	if len(play.PreTasks) > 0 {
-		results, err := e.executeTasks(ctx, play.PreTasks, hosts, playVars, play.Name, "pre_tasks")
+		results, err := e.executeSection(ctx, play.PreTasks, hosts, playVars, play.Name, "pre_tasks")
+		allResults = append(allResults, results...)
 		if err != nil {
 			return allResults, err
 		}
-		allResults = append(allResults, results...)
 	}
 
 	// Gather facts if needed
 	if e.shouldGatherFacts(playVars) {
-		factResults, err := e.gatherFacts(ctx, hosts)
+		factResults, err := e.gatherFacts(ctx, hosts, playVars)
 		if err != nil {
 			return allResults, fmt.Errorf("failed to gather facts: %w", err)
 		}
@@ -131,41 +346,94 @@ func (e *Executor) ExecutePlay(ctx context.Context, play *types.Play, vars map[s
 
 	// Execute main tasks
 	if len(play.Tasks) > 0 {
-		results, err := e.executeTasks(ctx, play.Tasks, hosts, playVars, play.Name, "tasks")
+		results, err := e.executeSection(ctx, play.Tasks, hosts, playVars, play.Name, "tasks")
+		allResults = append(allResults, results...)
 		if err != nil {
 			return allResults, err
 		}
-		allResults = append(allResults, results...)
 	}
 
 	// Execute post_tasks
 	if len(play.PostTasks) > 0 {
-		results, err := e.executeTasks(ctx, play.PostTasks, hosts, playVars, play.Name, "post_tasks")
-		if err != nil {
-			return allResults, err
-		}
+		results, err := e.executeSection(ctx, play.PostTasks, hosts, playVars, play.Name, "post_tasks")
 		allResults = append(allResults, results...)
-	}
-
-	// Execute handlers (triggered tasks)
-	if len(play.Handlers) > 0 {
-		handlerResults, err := e.executeHandlers(ctx, play.Handlers, hosts, playVars, play.Name)
 		if err != nil {
 			return allResults, err
 		}
-		allResults = append(allResults, handlerResults...)
 	}
 
 	return allResults, nil
 }
 
+// executeSection executes the tasks of a play section, then the handlers
+// they notified
+func (e *Executor) executeSection(ctx context.Context, tasks []types.Task, hosts []types.Host, vars map[string]interface{}, playName, taskType string) ([]types.Result, error) {
+	results, err := e.executeTasks(ctx, tasks, hosts, vars, playName, taskType)
+	if err != nil {
+		return results, err
+	}
+
+	handlerResults, err := e.flushHandlers(ctx, hosts, vars, playName)
+	return append(results, handlerResults...), err
+}
+
 // executeTasks executes a list of tasks
 func (e *Executor) executeTasks(ctx context.Context, tasks []types.Task, hosts []types.Host, vars map[string]interface{}, playName, taskType string) ([]types.Result, error) {
+	if e.strategy != nil {
+		return e.executeTasksWithStrategy(ctx, tasks, hosts, vars, playName, taskType)
+	}
+	return e.runTasks(ctx, tasks, hosts, vars, playName, taskType, e.window)
+}
+
+// runTasks runs tasks in lockstep across the hosts. The maintenance window,
+// when given, is checked before each task.
+func (e *Executor) runTasks(ctx context.Context, tasks []types.Task, hosts []types.Host, vars map[string]interface{}, playName, taskType string, window *windowGuard) ([]types.Result, error) {
 	var allResults []types.Result
 
 	for i, task := range tasks {
-		// Skip tasks that don't match tags or conditions
-		if e.shouldSkipTask(&task, vars) {
+		// Respect the maintenance window, resuming after a checkpoint
+		if window != nil {
+			if window.skip(taskType, i) {
+				continue
+			}
+			if err := window.beforeTask(ctx, taskType, i, &task); err != nil {
+				return allResults, err
+			}
+		}
+
+		// Meta tasks act on the play rather than running on hosts
+		if _, ok := metaAction(&task); ok {
+			results, err := e.executeMeta(ctx, &task, hosts, vars, playName)
+			allResults = append(allResults, results...)
+			if err != nil {
+				return allResults, err
+			}
+			continue
+		}
+
+		// Skip tasks that don't match tags. Included task files pass their
+		// tags on to the tasks they hold, which are selected one by one.
+		// Conditions are evaluated on each host by the runner.
+		if !isTaskInclude(&task) && !e.tags.selects(&task) {
+			continue
+		}
+
+		// Included task files run as part of this task
+		if isTaskInclude(&task) {
+			results, err := e.includeTasks(ctx, &task, hosts, vars, playName, taskType)
+			allResults = append(allResults, results...)
+			if err != nil {
+				return allResults, err
+			}
+			continue
+		}
+
+		// Skip tasks before the start task, or that the operator declines
+		run, err := e.confirmTask(ctx, &task)
+		if err != nil {
+			return allResults, err
+		}
+		if !run {
 			continue
 		}
 
@@ -185,8 +453,16 @@ func (e *Executor) executeTasks(ctx context.Context, tasks []types.Task, hosts [
 		// Merge task vars
 		taskVars := e.mergeTaskVars(&task, vars)
 
+		// Hosts that failed earlier in the batch take no further part
+		taskHosts := hosts
+		if e.failures != nil {
+			if taskHosts = e.failures.active(hosts); len(taskHosts) == 0 {
+				break
+			}
+		}
+
 		// Execute task
-		results, err := e.executeTask(ctx, &task, hosts, taskVars)
+		results, err := e.executeTask(ctx, &task, taskHosts, taskVars)
 		if err != nil {
 			e.emitEvent(types.Event{
 				Type:      types.EventTaskFailed,
@@ -202,6 +478,7 @@ func (e *Executor) executeTasks(ctx context.Context, tasks []types.Task, hosts [
 		}
 
 		allResults = append(allResults, results...)
+		e.handlers.notify(&task, results)
 
 		// Emit task complete event
 		e.emitEvent(types.Event{
@@ -216,110 +493,270 @@ func (e *Executor) executeTasks(ctx context.Context, tasks []types.Task, hosts [
 
 		// Check for failures
 		if e.shouldStopOnTaskFailure(results, &task) {
-			return allResults, fmt.Errorf("task '%s' failed on one or more hosts", task.Name)
+			if e.failures == nil {
+				return allResults, fmt.Errorf("task '%s' failed on one or more hosts", task.Name)
+			}
+			e.failures.record(results)
+			if e.failures.exceeded() {
+				return allResults, fmt.Errorf("task '%s': %w", task.Name, e.failures.err())
+			}
 		}
 	}
 
 	return allResults, nil
 }
 
-// executeTask executes a single task on multiple hosts
-func (e *Executor) executeTask(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
-	// Handle loop execution
-	if task.Loop != nil {
-		return e.executeTaskWithLoop(ctx, task, hosts, vars)
+// playStrategy resolves the strategy named by the play. The built-in linear
+// strategy returns nil, since executeTasks already runs tasks in lockstep
+// with the runner parallelising each task across hosts.
+func (e *Executor) playStrategy(play *types.Play) (strategy.Strategy, error) {
+	name := play.Strategy
+	if name == "" {
+		name = "linear"
 	}
 
-	// Handle delegation
-	if task.Delegate != "" {
-		return e.executeTaskWithDelegation(ctx, task, hosts, vars)
+	strat, err := e.strategies.Get(name)
+	if err != nil {
+		return nil, err
 	}
-
-	// Handle run_once
-	if task.RunOnce {
-		return e.executeTaskRunOnce(ctx, task, hosts, vars)
+	if _, ok := strat.(*strategy.LinearStrategy); ok {
+		return nil, nil
 	}
-
-	// Normal execution
-	return e.runner.Run(ctx, *task, hosts, vars)
+	return strat, nil
 }
 
-// executeTaskWithLoop executes a task with a loop
-func (e *Executor) executeTaskWithLoop(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
-	loopItems, err := e.resolveLoopItems(task.Loop, vars)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve loop items: %w", err)
+// executeTasksWithStrategy executes a list of tasks through the play's
+// strategy, which decides how hosts progress through them
+func (e *Executor) executeTasksWithStrategy(ctx context.Context, tasks []types.Task, hosts []types.Host, vars map[string]interface{}, playName, taskType string) ([]types.Result, error) {
+	// Tags and resumed checkpoints do not depend on the host, so filter
+	// them out before handing the tasks to the strategy. Conditions are
+	// evaluated on each host by the runner.
+	var runnable []types.Task
+	var indexes []int
+	for i, task := range tasks {
+		if e.window != nil && e.window.skip(taskType, i) {
+			continue
+		}
+		if !isTaskInclude(&task) && !e.tags.selects(&task) {
+			continue
+		}
+		if !isTaskInclude(&task) {
+			run, err := e.confirmTask(ctx, &task)
+			if err != nil {
+				return nil, err
+			}
+			if !run {
+				continue
+			}
+		}
+		runnable = append(runnable, task)
+		indexes = append(indexes, i)
+	}
+	if len(runnable) == 0 {
+		return []types.Result{}, nil
 	}
 
-	var allResults []types.Result
+	// Strategies run each host's tasks in order, so counting the calls per
+	// host recovers the task's position in the play
+	var mu sync.Mutex
+	next := make(map[string]int, len(hosts))
 
-	for i, item := range loopItems {
-		// Create task copy with loop variables
-		loopTask := *task
-		loopVars := make(map[string]interface{})
-		for k, v := range vars {
-			loopVars[k] = v
+	executor := func(ctx context.Context, task types.Task, host types.Host) (*types.Result, error) {
+		mu.Lock()
+		pos := next[host.Name]
+		next[host.Name]++
+		var err error
+		if e.window != nil {
+			err = e.window.beforeTask(ctx, taskType, indexes[pos], &task)
 		}
-		loopVars["item"] = item
-		loopVars["item_index"] = i
-
-		results, err := e.runner.Run(ctx, loopTask, hosts, loopVars)
+		mu.Unlock()
 		if err != nil {
-			if !task.IgnoreErrors {
-				return allResults, err
+			return nil, err
+		}
+
+		e.emitEvent(types.Event{
+			Type:      types.EventTaskStart,
+			Timestamp: types.GetCurrentTime(),
+			Host:      host.Name,
+			Task:      task.Name,
+			Play:      playName,
+			Data: map[string]interface{}{
+				"task_index": indexes[pos],
+				"task_type":  taskType,
+			},
+		})
+
+		// run_once tasks only run on the first host of the play
+		if task.RunOnce && host.Name != hosts[0].Name {
+			return &types.Result{Host: host.Name, Success: true, Message: "skipped: run_once"}, nil
+		}
+
+		// Meta tasks apply to the host that reached them
+		if _, ok := metaAction(&task); ok {
+			results, err := e.executeMeta(ctx, &task, []types.Host{host}, vars, playName)
+			if err != nil || len(results) == 0 {
+				return &types.Result{Host: host.Name, Success: err == nil, Error: err}, err
 			}
+			return combineHostResults(host.Name, results), nil
 		}
-		allResults = append(allResults, results...)
+
+		// Hosts work through included task files on their own
+		if isTaskInclude(&task) {
+			results, err := e.includeTasks(ctx, &task, []types.Host{host}, vars, playName, taskType)
+			if len(results) == 0 {
+				return &types.Result{Host: host.Name, Success: err == nil, Error: err, Message: "included: " + includeFile(&task)}, err
+			}
+			return combineHostResults(host.Name, results), err
+		}
+
+		taskVars := e.mergeTaskVars(&task, vars)
+		results, err := e.executeTask(ctx, &task, []types.Host{host}, taskVars)
+		if err != nil {
+			e.emitEvent(types.Event{
+				Type:      types.EventTaskFailed,
+				Timestamp: types.GetCurrentTime(),
+				Host:      host.Name,
+				Task:      task.Name,
+				Play:      playName,
+				Error:     err,
+			})
+			return nil, err
+		}
+		e.handlers.notify(&task, results)
+
+		e.emitEvent(types.Event{
+			Type:      types.EventTaskComplete,
+			Timestamp: types.GetCurrentTime(),
+			Host:      host.Name,
+			Task:      task.Name,
+			Play:      playName,
+			Data: map[string]interface{}{
+				"results_count": len(results),
+			},
+		})
+
+		return combineHostResults(host.Name, results), nil
 	}
 
-	return allResults, nil
+	if e.failures != nil {
+		if hosts = e.failures.active(hosts); len(hosts) == 0 {
+			return []types.Result{}, nil
+		}
+	}
+
+	results, err := e.strategy.Execute(strategy.WithForks(ctx, e.forks), runnable, hosts, executor)
+	if err != nil && e.failures != nil {
+		e.failures.record(results)
+		if e.failures.exceeded() {
+			return results, e.failures.err()
+		}
+		return results, nil
+	}
+	return results, err
 }
 
-// executeTaskWithDelegation executes a task with delegation to another host
-func (e *Executor) executeTaskWithDelegation(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
-	// Find delegate host
-	delegateHost, err := e.inventory.GetHost(task.Delegate)
-	if err != nil {
-		return nil, fmt.Errorf("delegate host %s not found: %w", task.Delegate, err)
+// combineHostResults folds the results of one task on one host, which a
+// loop may have produced several of, into a single result
+func combineHostResults(host string, results []types.Result) *types.Result {
+	if len(results) == 1 {
+		return &results[0]
 	}
 
-	// Execute on delegate host
-	return e.runner.Run(ctx, *task, []types.Host{*delegateHost}, vars)
+	combined := &types.Result{
+		Host:    host,
+		Success: true,
+		Message: fmt.Sprintf("%d results", len(results)),
+		Data: map[string]interface{}{
+			"results": results,
+		},
+	}
+	for _, result := range results {
+		if !result.Success {
+			combined.Success = false
+			combined.Error = result.Error
+			combined.Message = result.Message
+		}
+		combined.Changed = combined.Changed || result.Changed
+	}
+	return combined
 }
 
-// executeTaskRunOnce executes a task only once (on first host)
-func (e *Executor) executeTaskRunOnce(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
-	if len(hosts) == 0 {
-		return []types.Result{}, nil
+// executeTask executes a single task on multiple hosts, reporting it to the
+// callback plugins
+func (e *Executor) executeTask(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	if e.callbacks == nil {
+		return e.dispatchTask(ctx, task, hosts, vars)
 	}
 
-	// Execute only on first host
-	return e.runner.Run(ctx, *task, hosts[:1], vars)
+	e.callbacks.OnTaskStart(task, hosts)
+	results, err := e.dispatchTask(ctx, task, hosts, vars)
+	e.reportResults(task, hosts, results, err)
+	return results, err
+}
+
+// reportResults passes the results of a task to the callback plugins. A task
+// that failed without results is reported as failed on each of its hosts.
+func (e *Executor) reportResults(task *types.Task, hosts []types.Host, results []types.Result, err error) {
+	if err != nil && len(results) == 0 {
+		for _, host := range hosts {
+			e.callbacks.OnTaskResult(task, &types.Result{
+				Host:       host.Name,
+				Error:      err,
+				Message:    err.Error(),
+				TaskName:   task.Name,
+				ModuleName: task.Module.String(),
+			})
+		}
+		return
+	}
+	for i := range results {
+		e.callbacks.OnTaskResult(task, &results[i])
+	}
 }
 
-// executeHandlers executes handler tasks
-func (e *Executor) executeHandlers(ctx context.Context, handlers []types.Task, hosts []types.Host, vars map[string]interface{}, playName string) ([]types.Result, error) {
-	// In a full implementation, this would track which handlers were notified
-	// For now, we'll skip handler execution
-	return []types.Result{}, nil
+// dispatchTask runs a task on the hosts. The runner handles loops,
+// delegation and run_once itself.
+func (e *Executor) dispatchTask(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	return e.runner.Run(ctx, *task, hosts, vars)
 }
 
-// getPlayHosts resolves the hosts for a play
+// getPlayHosts resolves the hosts for a play. The play's patterns are
+// matched as one pattern, so intersections and exclusions apply across
+// them, and the limit then narrows the result.
 func (e *Executor) getPlayHosts(play *types.Play) ([]types.Host, error) {
 	parser := NewParser()
 	patterns := parser.ParseInventoryPattern(play.Hosts)
+	if len(patterns) == 0 {
+		return nil, nil
+	}
 
-	var allHosts []types.Host
-	for _, pattern := range patterns {
-		hosts, err := e.inventory.GetHosts(pattern)
+	hosts, err := e.inventory.GetHosts(strings.Join(patterns, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.limit != "" {
+		limited, err := e.inventory.GetHosts(e.limit)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid limit %q: %w", e.limit, err)
 		}
-		allHosts = append(allHosts, hosts...)
+		allowed := make(map[string]bool, len(limited))
+		for _, host := range limited {
+			allowed[host.Name] = true
+		}
+		hosts = slices.DeleteFunc(hosts, func(host types.Host) bool {
+			return !allowed[host.Name]
+		})
 	}
 
 	// Remove duplicates
-	return e.removeDuplicateHosts(allHosts), nil
+	return e.removeDuplicateHosts(hosts), nil
+}
+
+// SetLimit restricts the hosts of every play to those also matching the
+// pattern, like Ansible's --limit
+func (e *Executor) SetLimit(pattern string) {
+	e.limit = pattern
 }
 
 // removeDuplicateHosts removes duplicate hosts from a slice
@@ -348,7 +785,30 @@ func (e *Executor) mergePlayVars(play *types.Play, globalVars map[string]interfa
 
 	// Add play vars
 	if play.Vars != nil {
-		result = types.DeepMergeInterfaceMaps(result, play.Vars)
+		result = withExtraVars(types.DeepMergeInterfaceMaps(result, play.Vars))
+	}
+
+	// Play become keywords are passed to tasks as ansible_become* variables
+	if play.Become != nil {
+		result["ansible_become"] = *play.Become
+	}
+	if play.BecomeUser != "" {
+		result["ansible_become_user"] = play.BecomeUser
+	}
+	if play.BecomeMethod != "" {
+		result["ansible_become_method"] = play.BecomeMethod
+	}
+
+	// The runner applies the play's environment, module_defaults and
+	// no_log to every task
+	if len(play.Environment) > 0 {
+		result["_environment"] = play.Environment
+	}
+	if len(play.ModuleDefaults) > 0 {
+		result["_module_defaults"] = play.ModuleDefaults
+	}
+	if play.NoLog != nil {
+		result["_no_log"] = *play.NoLog
 	}
 
 	return result
@@ -365,58 +825,22 @@ func (e *Executor) mergeTaskVars(task *types.Task, playVars map[string]interface
 
 	// Add task vars
 	if task.Vars != nil {
-		result = types.DeepMergeInterfaceMaps(result, task.Vars)
+		result = withExtraVars(types.DeepMergeInterfaceMaps(result, task.Vars))
 	}
 
 	return result
 }
 
-// shouldSkipTask determines if a task should be skipped
-func (e *Executor) shouldSkipTask(task *types.Task, vars map[string]interface{}) bool {
-	// Check when condition
-	if task.When != nil {
-		// Convert to string for simple evaluation
-		whenStr := ""
-		switch v := task.When.(type) {
-		case string:
-			whenStr = v
-		case bool:
-			if !v {
-				return true
-			}
-			return false
-		default:
-			// For complex conditions, skip for now
-			return false
-		}
-		
-		if whenStr != "" {
-			// Simple condition evaluation - in a real implementation, this would be more complex
-			return !e.evaluateCondition(whenStr, vars)
-		}
-	}
-
-	// Check tags (simplified - real Ansible has complex tag logic)
-	// For now, assume all tasks run
-	return false
-}
+// extraVarsVar carries the extra vars of a run in its variables, matching
+// the runner's
+const extraVarsVar = "_extra_vars"
 
-// evaluateCondition evaluates a when condition
-func (e *Executor) evaluateCondition(condition string, vars map[string]interface{}) bool {
-	// Simplified condition evaluation
-	// Real Ansible uses Jinja2 expressions
-	condition = types.ExpandVariables(condition, vars)
-
-	// Basic true/false evaluation
-	switch condition {
-	case "true", "True", "yes", "Yes", "1":
-		return true
-	case "false", "False", "no", "No", "0":
-		return false
-	default:
-		// For complex conditions, assume true for now
-		return true
+// withExtraVars applies the extra vars carried in vars over them again
+func withExtraVars(vars map[string]interface{}) map[string]interface{} {
+	if extra, ok := vars[extraVarsVar].(map[string]interface{}); ok {
+		return types.DeepMergeInterfaceMaps(vars, extra)
 	}
+	return vars
 }
 
 // resolveLoopItems resolves loop items from various sources
@@ -445,20 +869,28 @@ func (e *Executor) resolveLoopItems(loop interface{}, vars map[string]interface{
 // shouldGatherFacts determines if facts should be gathered
 func (e *Executor) shouldGatherFacts(vars map[string]interface{}) bool {
 	if gatherFacts, exists := vars["gather_facts"]; exists {
+		if gatherFacts == "minimal" {
+			return true
+		}
 		return types.ConvertToBool(gatherFacts)
 	}
 	return true // Default to gathering facts
 }
 
-// gatherFacts gathers facts from hosts
-func (e *Executor) gatherFacts(ctx context.Context, hosts []types.Host) ([]types.Result, error) {
+// gatherFacts gathers facts from hosts. With gather_facts: minimal only the
+// hostname, OS family and interpreter facts are collected, in a single
+// command per host.
+func (e *Executor) gatherFacts(ctx context.Context, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
 	setupTask := types.Task{
 		Name:   "Gathering Facts",
 		Module: "setup",
 		Args:   make(map[string]interface{}),
 	}
+	if vars["gather_facts"] == "minimal" {
+		setupTask.Args["gather_subset"] = []interface{}{"min"}
+	}
 
-	return e.runner.Run(ctx, setupTask, hosts, make(map[string]interface{}))
+	return e.executeTask(ctx, &setupTask, hosts, make(map[string]interface{}))
 }
 
 // shouldStopOnFailure determines if execution should stop on failure
@@ -0,0 +1,461 @@
+package playbook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/strategy"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// recordingRunner is a types.Runner that records each task it is asked to
+// run and succeeds on every host unless the task is listed in failOn,
+// reporting a change on the hosts listed in changeOn
+type recordingRunner struct {
+	mu       sync.Mutex
+	calls    []recordedCall
+	failOn   map[string]map[string]bool // task name -> host name -> fail
+	changeOn map[string]map[string]bool // task name -> host name -> changed
+	onRun    func(task types.Task)
+}
+
+type recordedCall struct {
+	Task  string
+	Hosts []string
+	Vars  map[string]interface{}
+}
+
+func newRecordingRunner() *recordingRunner {
+	return &recordingRunner{
+		failOn:   make(map[string]map[string]bool),
+		changeOn: make(map[string]map[string]bool),
+	}
+}
+
+func (r *recordingRunner) Run(ctx context.Context, task types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	if r.onRun != nil {
+		r.onRun(task)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	call := recordedCall{Task: task.Name, Vars: vars}
+	var results []types.Result
+	for _, host := range hosts {
+		call.Hosts = append(call.Hosts, host.Name)
+		success := !r.failOn[task.Name][host.Name]
+		results = append(results, types.Result{
+			Host:       host.Name,
+			TaskName:   task.Name,
+			ModuleName: task.Module.String(),
+			Success:    success,
+			Changed:    r.changeOn[task.Name][host.Name],
+		})
+	}
+	r.calls = append(r.calls, call)
+	return results, nil
+}
+
+func (r *recordingRunner) RunPlay(ctx context.Context, play types.Play, inv types.Inventory, vars map[string]interface{}) ([]types.Result, error) {
+	return nil, nil
+}
+
+func (r *recordingRunner) RunPlaybook(ctx context.Context, playbook types.Playbook, inv types.Inventory, vars map[string]interface{}) ([]types.Result, error) {
+	return nil, nil
+}
+
+func (r *recordingRunner) SetMaxConcurrency(max int)                   {}
+func (r *recordingRunner) RegisterModule(module types.Module) error    { return nil }
+func (r *recordingRunner) GetModule(name string) (types.Module, error) { return nil, nil }
+
+// taskNames returns the names of the tasks run so far, in order
+func (r *recordingRunner) taskNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, len(r.calls))
+	for i, call := range r.calls {
+		names[i] = call.Task
+	}
+	return names
+}
+
+// newTestInventory creates an inventory containing the named hosts
+func newTestInventory(t *testing.T, names ...string) *inventory.StaticInventory {
+	t.Helper()
+
+	inv := inventory.NewStaticInventory()
+	for _, name := range names {
+		if err := inv.AddHost(types.Host{Name: name, Address: name}); err != nil {
+			t.Fatalf("failed to add host %s: %v", name, err)
+		}
+	}
+	return inv
+}
+
+func debugTask(name string) types.Task {
+	return types.Task{Name: name, Module: "debug", Args: map[string]interface{}{"msg": name}}
+}
+
+func TestExecutorExecutePlay(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	play := &types.Play{
+		Name:      "test",
+		Hosts:     "web1,web2",
+		Vars:      map[string]interface{}{"gather_facts": false},
+		PreTasks:  []types.Task{debugTask("pre")},
+		Tasks:     []types.Task{debugTask("main")},
+		PostTasks: []types.Task{debugTask("post")},
+	}
+
+	results, err := executor.ExecutePlay(context.Background(), play, nil)
+	if err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+	if len(results) != 6 {
+		t.Errorf("expected 6 results, got %d", len(results))
+	}
+
+	got := runner.taskNames()
+	expected := []string{"pre", "main", "post"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected tasks %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected task %d to be %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestExecutorLeavesLoopsToRunner(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	task := debugTask("install")
+	task.Loop = []interface{}{"nginx", "curl", "git"}
+	play := &types.Play{
+		Name:  "test",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{task},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+	if got := runner.taskNames(); len(got) != 1 {
+		t.Errorf("expected the loop task to be passed to the runner once, got %v", got)
+	}
+}
+
+func TestExecutorPlayEnvironmentAndModuleDefaults(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	play := &types.Play{
+		Name:           "test",
+		Hosts:          "web1",
+		Vars:           map[string]interface{}{"gather_facts": false},
+		Tasks:          []types.Task{debugTask("main")},
+		Environment:    map[string]string{"http_proxy": "http://proxy:3128"},
+		ModuleDefaults: map[string]map[string]interface{}{"apt": {"cache_valid_time": 3600}},
+	}
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	vars := runner.calls[0].Vars
+	if !reflect.DeepEqual(vars["_environment"], play.Environment) {
+		t.Errorf("expected the play environment to reach the runner, got %v", vars["_environment"])
+	}
+	if !reflect.DeepEqual(vars["_module_defaults"], play.ModuleDefaults) {
+		t.Errorf("expected the play module_defaults to reach the runner, got %v", vars["_module_defaults"])
+	}
+}
+
+func TestExecutorLimit(t *testing.T) {
+	inv := inventory.NewStaticInventory()
+	for _, host := range []types.Host{
+		{Name: "web1", Groups: []string{"webservers", "staging"}},
+		{Name: "web2", Groups: []string{"webservers", "production"}},
+		{Name: "db1", Groups: []string{"databases", "staging"}},
+	} {
+		if err := inv.AddHost(host); err != nil {
+			t.Fatalf("failed to add host %s: %v", host.Name, err)
+		}
+	}
+
+	tests := []struct {
+		hosts    interface{}
+		limit    string
+		expected []string
+	}{
+		{"all", "", []string{"db1", "web1", "web2"}},
+		{"webservers:&staging", "", []string{"web1"}},
+		{[]interface{}{"all", "!databases"}, "", []string{"web1", "web2"}},
+		{"all", "staging", []string{"db1", "web1"}},
+		{"webservers", "all:!web1", []string{"web2"}},
+		{"databases", "webservers", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v limit %s", tt.hosts, tt.limit), func(t *testing.T) {
+			runner := newRecordingRunner()
+			executor := NewExecutor(runner, inv, nil)
+			executor.SetLimit(tt.limit)
+
+			play := &types.Play{
+				Name:  "test",
+				Hosts: tt.hosts,
+				Vars:  map[string]interface{}{"gather_facts": false},
+				Tasks: []types.Task{debugTask("main")},
+			}
+			if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+				t.Fatalf("ExecutePlay failed: %v", err)
+			}
+
+			var hosts []string
+			if len(runner.calls) > 0 {
+				hosts = runner.calls[0].Hosts
+			}
+			if !reflect.DeepEqual(hosts, tt.expected) {
+				t.Errorf("expected hosts %v, got %v", tt.expected, hosts)
+			}
+		})
+	}
+
+	executor := NewExecutor(newRecordingRunner(), inv, nil)
+	executor.SetLimit("~web[")
+	play := &types.Play{Name: "test", Hosts: "all", Tasks: []types.Task{debugTask("main")}}
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err == nil {
+		t.Error("expected an invalid limit to fail the play")
+	}
+}
+
+func TestExecutorExtraVarsPrecedence(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	task := debugTask("main")
+	task.Vars = map[string]interface{}{"version": "task"}
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "test",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false, "version": "play", "port": 80},
+		Tasks: []types.Task{task},
+	}}}
+	if _, err := executor.Execute(context.Background(), playbook, map[string]interface{}{"version": "extra"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	vars := runner.calls[0].Vars
+	if vars["version"] != "extra" || vars["port"] != 80 {
+		t.Errorf("expected extra vars to override play and task vars, got version %v, port %v", vars["version"], vars["port"])
+	}
+	if !reflect.DeepEqual(vars["_extra_vars"], map[string]interface{}{"version": "extra"}) {
+		t.Errorf("expected the extra vars to reach the runner, got %v", vars["_extra_vars"])
+	}
+}
+
+func TestExecutorPlayNoLog(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	noLog := true
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "test",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		NoLog: &noLog,
+		Tasks: []types.Task{debugTask("main")},
+	}}}
+	if _, err := executor.Execute(context.Background(), playbook, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if runner.calls[0].Vars["_no_log"] != true {
+		t.Errorf("expected the play's no_log to reach the runner, got %v", runner.calls[0].Vars["_no_log"])
+	}
+}
+
+func TestExecutorMinimalFacts(t *testing.T) {
+	runner := newRecordingRunner()
+	var subset interface{}
+	runner.onRun = func(task types.Task) {
+		if task.Module == "setup" {
+			subset = task.Args["gather_subset"]
+		}
+	}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	play := &types.Play{
+		Name:  "quick",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": "minimal"},
+		Tasks: []types.Task{debugTask("main")},
+	}
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	expected := []string{"Gathering Facts", "main"}
+	if got := runner.taskNames(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if !reflect.DeepEqual(subset, []interface{}{"min"}) {
+		t.Errorf("expected the min fact subset, got %v", subset)
+	}
+}
+
+func TestExecutorFreeStrategy(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.failOn["first"] = map[string]bool{"web1": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	play := &types.Play{
+		Name:     "free",
+		Hosts:    "web1,web2",
+		Strategy: "free",
+		Vars:     map[string]interface{}{"gather_facts": false},
+		Tasks:    []types.Task{debugTask("first"), debugTask("second")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err == nil {
+		t.Fatal("expected the failure on web1 to be reported")
+	}
+
+	// Each task is run per host, and web2 carries on after web1 fails
+	ran := make(map[string][]string)
+	for _, call := range runner.calls {
+		if len(call.Hosts) != 1 {
+			t.Fatalf("expected one host per call, got %v", call.Hosts)
+		}
+		ran[call.Hosts[0]] = append(ran[call.Hosts[0]], call.Task)
+	}
+	if len(ran["web1"]) != 1 || len(ran["web2"]) != 2 {
+		t.Errorf("expected web1 to stop after first and web2 to finish, got %v", ran)
+	}
+}
+
+// orderStrategy is a custom strategy that runs hosts one after another
+type orderStrategy struct {
+	used bool
+}
+
+func (s *orderStrategy) Name() string                                    { return "serial_hosts" }
+func (s *orderStrategy) SetOptions(options map[string]interface{}) error { return nil }
+
+func (s *orderStrategy) Execute(ctx context.Context, tasks []types.Task, hosts []types.Host, executor strategy.TaskExecutor) ([]types.Result, error) {
+	s.used = true
+	var results []types.Result
+	for _, host := range hosts {
+		for _, task := range tasks {
+			result, err := executor(ctx, task, host)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, *result)
+		}
+	}
+	return results, nil
+}
+
+func TestExecutorCustomStrategy(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	custom := &orderStrategy{}
+	executor.RegisterStrategy(custom)
+
+	play := &types.Play{
+		Name:     "custom",
+		Hosts:    "web1,web2",
+		Strategy: "serial_hosts",
+		Vars:     map[string]interface{}{"gather_facts": false},
+		Tasks:    []types.Task{debugTask("a"), debugTask("b")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+	if !custom.used {
+		t.Fatal("expected the registered strategy to be used")
+	}
+
+	var order []string
+	for _, call := range runner.calls {
+		order = append(order, call.Hosts[0]+":"+call.Task)
+	}
+	expected := []string{"web1:a", "web1:b", "web2:a", "web2:b"}
+	if strings.Join(order, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+
+	play.Strategy = "missing"
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestExecutorCallbacks(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.changeOn["restart"] = map[string]bool{"web2": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	var buf bytes.Buffer
+	plugin := callback.NewJSONLinesCallback()
+	plugin.SetOutput(&buf)
+	manager := callback.NewCallbackManager()
+	manager.Register(plugin)
+	executor.SetCallbacks(manager)
+
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "deploy",
+		Hosts: "web1,web2",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{debugTask("check"), debugTask("restart")},
+	}}}
+	if _, err := executor.Execute(context.Background(), playbook, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event line %q: %v", line, err)
+		}
+		name := event["event"].(string)
+		if task, ok := event["task"].(string); ok {
+			name += ":" + task
+		}
+		if host, ok := event["host"].(string); ok {
+			name += ":" + host
+		}
+		events = append(events, name)
+	}
+
+	expected := []string{
+		"play_start",
+		"task_start:check", "task_result:check:web1", "task_result:check:web2",
+		"task_start:restart", "task_result:restart:web1", "task_result:restart:web2",
+		"play_end",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+	if stats := manager.Stats().HostStats["web2"]; stats.Ok != 2 || stats.Changed != 1 {
+		t.Errorf("expected web2 to have 2 ok and 1 changed, got %+v", stats)
+	}
+}
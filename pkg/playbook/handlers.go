@@ -0,0 +1,201 @@
+package playbook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// metaModule is the pseudo-module of tasks such as "meta: flush_handlers",
+// which act on the executor rather than on hosts
+const metaModule = "meta"
+
+// handlerQueue tracks the handlers notified during a play. A notification
+// names a handler or a listen topic several handlers subscribe to, and a
+// handler notified many times runs once per flush on each notified host.
+type handlerQueue struct {
+	handlers []types.Task
+
+	mu      sync.Mutex
+	pending map[int]map[string]bool
+}
+
+// newHandlerQueue creates the queue for a play, rejecting notifications no
+// handler answers to before any task runs
+func newHandlerQueue(play *types.Play) (*handlerQueue, error) {
+	q := &handlerQueue{
+		handlers: play.Handlers,
+		pending:  make(map[int]map[string]bool),
+	}
+
+	for _, tasks := range [][]types.Task{play.PreTasks, play.Tasks, play.PostTasks, play.Handlers} {
+		for _, task := range tasks {
+			for _, name := range task.Notify {
+				if len(q.resolve(name)) == 0 {
+					return nil, fmt.Errorf("task '%s' notifies handler '%s', which is neither a handler name nor a listen topic", task.Name, name)
+				}
+			}
+		}
+	}
+	return q, nil
+}
+
+// resolve returns the indexes of the handlers named by a notification,
+// either directly or through their listen topic
+func (q *handlerQueue) resolve(name string) []int {
+	var indexes []int
+	for i, handler := range q.handlers {
+		if handler.Name == name || handler.Listen == name {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// notify queues the handlers a task notifies for the hosts it changed
+func (q *handlerQueue) notify(task *types.Task, results []types.Result) {
+	if q == nil || len(task.Notify) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, result := range results {
+		if !result.Changed || result.Host == "" {
+			continue
+		}
+		for _, name := range task.Notify {
+			for _, i := range q.resolve(name) {
+				if q.pending[i] == nil {
+					q.pending[i] = make(map[string]bool)
+				}
+				q.pending[i][result.Host] = true
+			}
+		}
+	}
+}
+
+// next dequeues the first handler, in definition order, that is pending on
+// any of hosts and has not already run on them during this flush
+func (q *handlerQueue) next(hosts []types.Host, ran map[int]map[string]bool) (int, []types.Host) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.handlers {
+		var notified []types.Host
+		for _, host := range hosts {
+			if q.pending[i][host.Name] && !ran[i][host.Name] {
+				notified = append(notified, host)
+				delete(q.pending[i], host.Name)
+			}
+		}
+		if len(notified) > 0 {
+			return i, notified
+		}
+	}
+	return -1, nil
+}
+
+// flushHandlers runs the handlers notified on hosts. Handlers notified by
+// other handlers run in the same flush, unless they already ran on the host,
+// in which case they wait for the next one.
+func (e *Executor) flushHandlers(ctx context.Context, hosts []types.Host, vars map[string]interface{}, playName string) ([]types.Result, error) {
+	if e.handlers == nil {
+		return []types.Result{}, nil
+	}
+
+	var allResults []types.Result
+	ran := make(map[int]map[string]bool)
+
+	for {
+		// Hosts that failed earlier in the batch do not run handlers
+		if e.failures != nil {
+			hosts = e.failures.active(hosts)
+		}
+
+		i, notified := e.handlers.next(hosts, ran)
+		if i < 0 {
+			return allResults, nil
+		}
+		if ran[i] == nil {
+			ran[i] = make(map[string]bool)
+		}
+		for _, host := range notified {
+			ran[i][host.Name] = true
+		}
+
+		handler := e.handlers.handlers[i]
+
+		e.emitEvent(types.Event{
+			Type:      types.EventTaskStart,
+			Timestamp: types.GetCurrentTime(),
+			Task:      handler.Name,
+			Play:      playName,
+			Data: map[string]interface{}{
+				"task_index": i,
+				"task_type":  "handlers",
+			},
+		})
+
+		results, err := e.executeTask(ctx, &handler, notified, e.mergeTaskVars(&handler, vars))
+		if err != nil {
+			e.emitEvent(types.Event{
+				Type:      types.EventTaskFailed,
+				Timestamp: types.GetCurrentTime(),
+				Task:      handler.Name,
+				Play:      playName,
+				Error:     err,
+			})
+
+			if !handler.IgnoreErrors {
+				return allResults, err
+			}
+		}
+
+		allResults = append(allResults, results...)
+		e.handlers.notify(&handler, results)
+
+		e.emitEvent(types.Event{
+			Type:      types.EventTaskComplete,
+			Timestamp: types.GetCurrentTime(),
+			Task:      handler.Name,
+			Play:      playName,
+			Data: map[string]interface{}{
+				"results_count": len(results),
+			},
+		})
+
+		if e.shouldStopOnTaskFailure(results, &handler) {
+			if e.failures == nil {
+				return allResults, fmt.Errorf("handler '%s' failed on one or more hosts", handler.Name)
+			}
+			e.failures.record(results)
+			if e.failures.exceeded() {
+				return allResults, fmt.Errorf("handler '%s': %w", handler.Name, e.failures.err())
+			}
+		}
+	}
+}
+
+// metaAction returns the action of a meta task
+func metaAction(task *types.Task) (string, bool) {
+	if string(task.Module) != metaModule {
+		return "", false
+	}
+	action, _ := task.Args["action"].(string)
+	return action, true
+}
+
+// executeMeta runs a meta task on hosts
+func (e *Executor) executeMeta(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}, playName string) ([]types.Result, error) {
+	action, _ := metaAction(task)
+	switch action {
+	case "flush_handlers":
+		return e.flushHandlers(ctx, hosts, vars, playName)
+	case "noop":
+		return []types.Result{}, nil
+	default:
+		return nil, fmt.Errorf("task '%s': unsupported meta action '%s'", task.Name, action)
+	}
+}
@@ -0,0 +1,162 @@
+package playbook
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// notifyingTask is a debug task that notifies the named handlers
+func notifyingTask(name string, notify ...string) types.Task {
+	task := debugTask(name)
+	task.Notify = notify
+	return task
+}
+
+// handlerCalls returns the recorded calls as "task:host,host" strings
+func handlerCalls(runner *recordingRunner) []string {
+	var got []string
+	for _, call := range runner.calls {
+		got = append(got, call.Task+":"+strings.Join(call.Hosts, ","))
+	}
+	return got
+}
+
+func TestExecutorHandlers(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.changeOn["configure"] = map[string]bool{"web1": true}
+	runner.changeOn["tune"] = map[string]bool{"web1": true, "web2": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	restart := debugTask("restart nginx")
+	restart.Listen = "restart web stack"
+	reload := debugTask("reload php")
+	reload.Listen = "restart web stack"
+
+	play := &types.Play{
+		Name:  "web",
+		Hosts: "web1,web2",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{
+			notifyingTask("configure", "restart web stack"),
+			notifyingTask("tune", "restart nginx"),
+			debugTask("verify"),
+		},
+		Handlers: []types.Task{restart, reload, debugTask("never notified")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	// Handlers run once, in definition order, after the section and only
+	// on the hosts that notified them
+	expected := []string{
+		"configure:web1,web2", "tune:web1,web2", "verify:web1,web2",
+		"restart nginx:web1,web2", "reload php:web1",
+	}
+	if got := handlerCalls(runner); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestExecutorFlushHandlers(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.changeOn["configure"] = map[string]bool{"web1": true}
+	runner.changeOn["restart"] = map[string]bool{"web1": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	flush := types.Task{Name: "flush", Module: metaModule, Args: map[string]interface{}{"action": "flush_handlers"}}
+	play := &types.Play{
+		Name:  "web",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{
+			notifyingTask("configure", "restart"),
+			flush,
+			debugTask("verify"),
+		},
+		Handlers: []types.Task{notifyingTask("restart", "check"), debugTask("check")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	// The chained check handler runs in the same flush, and nothing is left
+	// for the end of the section
+	expected := []string{"configure:web1", "restart:web1", "check:web1", "verify:web1"}
+	if got := handlerCalls(runner); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestExecutorHandlersWithStrategy(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.changeOn["configure"] = map[string]bool{"web1": true, "web2": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	play := &types.Play{
+		Name:     "web",
+		Hosts:    "web1,web2",
+		Strategy: "free",
+		Vars:     map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{
+			notifyingTask("configure", "restart"),
+			{Name: "flush", Module: metaModule, Args: map[string]interface{}{"action": "flush_handlers"}},
+		},
+		Handlers: []types.Task{debugTask("restart")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	// Each host flushes its own notification when it reaches the meta task
+	restarts := 0
+	for _, call := range runner.calls {
+		if call.Task == "restart" {
+			restarts += len(call.Hosts)
+		}
+	}
+	if restarts != 2 {
+		t.Errorf("expected the handler to run once per host, got %v", handlerCalls(runner))
+	}
+}
+
+func TestExecutorUnknownHandler(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+	play := &types.Play{
+		Name:     "web",
+		Hosts:    "web1",
+		Vars:     map[string]interface{}{"gather_facts": false},
+		Tasks:    []types.Task{notifyingTask("configure", "restart apache")},
+		Handlers: []types.Task{debugTask("restart nginx")},
+	}
+
+	_, err := executor.ExecutePlay(context.Background(), play, nil)
+	if err == nil || !strings.Contains(err.Error(), "restart apache") {
+		t.Fatalf("expected an unknown handler error, got %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("expected no tasks to run, got %v", handlerCalls(runner))
+	}
+}
+
+func TestParseMetaTask(t *testing.T) {
+	var task types.Task
+	if err := yaml.Unmarshal([]byte("name: flush\nmeta: flush_handlers\n"), &task); err != nil {
+		t.Fatalf("failed to parse task: %v", err)
+	}
+
+	action, ok := metaAction(&task)
+	if !ok || action != "flush_handlers" {
+		t.Errorf("expected a flush_handlers meta task, got %+v", task)
+	}
+}
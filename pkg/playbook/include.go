@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/liliang-cn/gosible/pkg/expression"
 	"github.com/liliang-cn/gosible/pkg/types"
-	"gopkg.in/yaml.v3"
+	"github.com/liliang-cn/gosible/pkg/vault"
 )
 
 // IncludeType represents the type of include operation
@@ -18,6 +21,13 @@ const (
 	IncludeDynamic IncludeType = "dynamic" // include_tasks, include_role
 )
 
+// Task modules that pull in task files. import_tasks is expanded when the
+// playbook is parsed, include_tasks when the executor reaches it.
+const (
+	importTasksModule  = "import_tasks"
+	includeTasksModule = "include_tasks"
+)
+
 // IncludeTask represents an include or import task
 type IncludeTask struct {
 	Type        IncludeType            `yaml:"-"`
@@ -25,7 +35,7 @@ type IncludeTask struct {
 	Name        string                 `yaml:"name,omitempty"`
 	Vars        map[string]interface{} `yaml:"vars,omitempty"`
 	Tags        []string               `yaml:"tags,omitempty"`
-	When        string                 `yaml:"when,omitempty"`
+	When        interface{}            `yaml:"when,omitempty"`
 	Loop        interface{}            `yaml:"loop,omitempty"`
 	LoopControl *LoopControl           `yaml:"loop_control,omitempty"`
 	Tasks       []types.Task           `yaml:"-"` // Loaded tasks
@@ -43,6 +53,8 @@ type LoopControl struct {
 type IncludeManager struct {
 	basePath  string
 	taskCache map[string][]types.Task
+	cacheMu   sync.Mutex     // Guards taskCache for plays running concurrently
+	vaults    *vault.Manager // Decrypts vault encrypted task files
 }
 
 // NewIncludeManager creates a new include manager
@@ -57,18 +69,22 @@ func NewIncludeManager(basePath string) *IncludeManager {
 func (im *IncludeManager) ProcessInclude(ctx context.Context, includeTask *IncludeTask) ([]types.Task, error) {
 	// Resolve the file path
 	filePath := im.resolveFilePath(includeTask.File)
-	
-	// Check cache for static includes
-	if includeTask.Type == IncludeStatic {
-		if cachedTasks, exists := im.taskCache[filePath]; exists {
-			return im.applyIncludeVars(cachedTasks, includeTask.Vars), nil
-		}
-	}
 
-	// Load tasks from file
-	tasks, err := im.loadTasksFromFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load tasks from %s: %w", filePath, err)
+	// Static includes load each file once
+	im.cacheMu.Lock()
+	tasks, cached := im.taskCache[filePath]
+	im.cacheMu.Unlock()
+	if !cached || includeTask.Type != IncludeStatic {
+		var err error
+		tasks, err = im.loadTasksFromFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tasks from %s: %w", filePath, err)
+		}
+		if includeTask.Type == IncludeStatic {
+			im.cacheMu.Lock()
+			im.taskCache[filePath] = tasks
+			im.cacheMu.Unlock()
+		}
 	}
 
 	// Apply include-level variables
@@ -80,7 +96,7 @@ func (im *IncludeManager) ProcessInclude(ctx context.Context, includeTask *Inclu
 	}
 
 	// Apply when condition if specified
-	if includeTask.When != "" {
+	if includeTask.When != nil {
 		tasks = im.applyWhenCondition(tasks, includeTask.When)
 	}
 
@@ -89,12 +105,66 @@ func (im *IncludeManager) ProcessInclude(ctx context.Context, includeTask *Inclu
 		tasks = im.expandLoop(tasks, includeTask.Loop, includeTask.LoopControl)
 	}
 
-	// Cache static includes
-	if includeTask.Type == IncludeStatic {
-		im.taskCache[filePath] = tasks
+	return tasks, nil
+}
+
+// SetIncludePath sets the directory that include_tasks files are resolved
+// against, normally the directory of the playbook. The parser already
+// anchors file names without variables to the file that includes them.
+func (e *Executor) SetIncludePath(dir string) {
+	e.includes = NewIncludeManager(dir)
+	e.includes.vaults = e.vaults
+}
+
+// isTaskInclude reports whether a task pulls in a task file. import_tasks
+// normally disappears when the playbook is parsed, but role task files are
+// only loaded at run time.
+func isTaskInclude(task *types.Task) bool {
+	return task.Module == importTasksModule || task.Module == includeTasksModule
+}
+
+// includeTasks loads the task file of an include_tasks task when the play
+// reaches it, so that the file name and loop can use variables, and runs its
+// tasks on the hosts
+func (e *Executor) includeTasks(ctx context.Context, task *types.Task, hosts []types.Host, vars map[string]interface{}, playName, taskType string) ([]types.Result, error) {
+	taskVars := e.mergeTaskVars(task, vars)
+
+	// The runner never sees the include, so its condition is evaluated
+	// here, the way the runner evaluates those of tasks
+	if task.When != nil {
+		run, err := expression.Condition(task.When, taskVars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate when condition of include '%s': %w", task.Name, err)
+		}
+		if !run {
+			return nil, nil
+		}
+	}
+
+	include := includeFromTask(task, IncludeDynamic)
+	include.File = types.ExpandVariables(include.File, taskVars)
+	include.When = nil // Already evaluated for the include itself
+	if task.Loop != nil {
+		items, err := e.resolveLoopItems(task.Loop, taskVars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve loop items of include '%s': %w", include.File, err)
+		}
+		include.Loop = items
 	}
 
-	return tasks, nil
+	tasks, err := e.includes.ProcessInclude(ctx, include)
+	if err != nil {
+		e.emitEvent(types.Event{
+			Type:      types.EventTaskFailed,
+			Timestamp: types.GetCurrentTime(),
+			Task:      task.Name,
+			Play:      playName,
+			Error:     err,
+		})
+		return nil, err
+	}
+
+	return e.runTasks(ctx, tasks, hosts, vars, playName, taskType, nil)
 }
 
 // ImportTasks imports tasks statically (at parse time)
@@ -127,10 +197,10 @@ func (im *IncludeManager) ImportPlaybook(file string, vars map[string]interface{
 	}
 
 	var playbooks []types.Playbook
-	if err := yaml.Unmarshal(data, &playbooks); err != nil {
+	if err := im.vaults.DecodeYAML(data, &playbooks); err != nil {
 		// Try single playbook format
 		var playbook types.Playbook
-		if err := yaml.Unmarshal(data, &playbook); err != nil {
+		if err := im.vaults.DecodeYAML(data, &playbook); err != nil {
 			return nil, fmt.Errorf("failed to parse playbook %s: %w", filePath, err)
 		}
 		playbooks = []types.Playbook{playbook}
@@ -217,17 +287,103 @@ func (im *IncludeManager) resolveFilePath(file string) string {
 }
 
 func (im *IncludeManager) loadTasksFromFile(file string) ([]types.Task, error) {
+	return im.loadTaskFile(file, nil)
+}
+
+// loadTaskFile loads a task file and expands the files it imports, which
+// are resolved relative to the importing file. chain holds the files being
+// imported to report import cycles.
+func (im *IncludeManager) loadTaskFile(file string, chain []string) ([]types.Task, error) {
+	for _, seen := range chain {
+		if seen == file {
+			return nil, fmt.Errorf("circular import_tasks: %s -> %s", strings.Join(chain, " -> "), file)
+		}
+	}
+
 	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
 	var tasks []types.Task
-	if err := yaml.Unmarshal(data, &tasks); err != nil {
+	if err := im.vaults.DecodeYAML(data, &tasks); err != nil {
 		return nil, err
 	}
 
-	return tasks, nil
+	imports := NewIncludeManager(filepath.Dir(file))
+	imports.vaults = im.vaults
+	return imports.expandImports(tasks, append(chain, file))
+}
+
+// expandImports replaces import_tasks tasks with the tasks of their file and
+// anchors the files of include_tasks tasks to the base path, so that they
+// still resolve when the executor reaches them
+func (im *IncludeManager) expandImports(tasks []types.Task, chain []string) ([]types.Task, error) {
+	var expanded []types.Task
+	for _, task := range tasks {
+		switch task.Module {
+		case importTasksModule:
+			file := includeFile(&task)
+			if file == "" || strings.Contains(file, "{{") {
+				return nil, fmt.Errorf("import_tasks '%s' needs a file name without variables; use include_tasks for dynamic files", task.Name)
+			}
+			imported, err := im.loadTaskFile(im.resolveFilePath(file), chain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to import tasks from %s: %w", file, err)
+			}
+			include := includeFromTask(&task, IncludeStatic)
+			imported = im.applyIncludeVars(imported, include.Vars)
+			imported = im.applyTags(imported, include.Tags)
+			if include.When != nil {
+				imported = im.applyWhenCondition(imported, include.When)
+			}
+			expanded = append(expanded, imported...)
+
+		case includeTasksModule:
+			if file := includeFile(&task); file != "" && !strings.Contains(file, "{{") && !filepath.IsAbs(file) {
+				if path := im.resolveFilePath(file); path != file {
+					args := make(map[string]interface{}, len(task.Args))
+					for k, v := range task.Args {
+						args[k] = v
+					}
+					args["file"] = path
+					task.Args = args
+				}
+			}
+			expanded = append(expanded, task)
+
+		default:
+			expanded = append(expanded, task)
+		}
+	}
+	return expanded, nil
+}
+
+// includeFile returns the task file named by an import_tasks or include_tasks task
+func includeFile(task *types.Task) string {
+	file, _ := task.Args["file"].(string)
+	return file
+}
+
+// includeFromTask describes an import_tasks or include_tasks task as an
+// include. The directive's vars, tags and condition apply to every task of
+// the file.
+func includeFromTask(task *types.Task, includeType IncludeType) *IncludeTask {
+	include := &IncludeTask{
+		Type: includeType,
+		File: includeFile(task),
+		Name: task.Name,
+		Vars: task.Vars,
+		Tags: task.Tags,
+		When: task.When,
+		Loop: task.Loop,
+	}
+	if task.LoopControl != nil {
+		include.LoopControl = &LoopControl{}
+		include.LoopControl.LoopVar, _ = task.LoopControl["loop_var"].(string)
+		include.LoopControl.IndexVar, _ = task.LoopControl["index_var"].(string)
+	}
+	return include
 }
 
 func (im *IncludeManager) applyIncludeVars(tasks []types.Task, includeVars map[string]interface{}) []types.Task {
@@ -239,8 +395,9 @@ func (im *IncludeManager) applyIncludeVars(tasks []types.Task, includeVars map[s
 	result := make([]types.Task, len(tasks))
 	for i, task := range tasks {
 		result[i] = task
-		if result[i].Vars == nil {
-			result[i].Vars = make(map[string]interface{})
+		result[i].Vars = make(map[string]interface{}, len(task.Vars)+len(includeVars))
+		for k, v := range task.Vars {
+			result[i].Vars[k] = v
 		}
 		// Include vars have lower priority than task vars
 		for k, v := range includeVars {
@@ -258,38 +415,43 @@ func (im *IncludeManager) applyTags(tasks []types.Task, tags []string) []types.T
 	for i, task := range tasks {
 		result[i] = task
 		// Append tags to existing tags
-		result[i].Tags = append(result[i].Tags, tags...)
+		result[i].Tags = append(append([]string{}, task.Tags...), tags...)
 	}
 	return result
 }
 
-func (im *IncludeManager) applyWhenCondition(tasks []types.Task, when string) []types.Task {
+func (im *IncludeManager) applyWhenCondition(tasks []types.Task, when interface{}) []types.Task {
 	result := make([]types.Task, len(tasks))
 	for i, task := range tasks {
 		result[i] = task
 		// Combine conditions with AND
-		if result[i].When != nil {
-			result[i].When = fmt.Sprintf("(%v) and (%s)", result[i].When, when)
-		} else {
-			result[i].When = when
-		}
+		result[i].When = joinConditions(when, task.When)
 	}
 	return result
 }
 
+// expandLoop repeats the tasks for each item of a resolved loop, with the
+// item in the loop variable
 func (im *IncludeManager) expandLoop(tasks []types.Task, loop interface{}, control *LoopControl) []types.Task {
-	// This would expand tasks for each loop item
-	// For now, mark tasks as having a loop
-	result := make([]types.Task, len(tasks))
-	for i, task := range tasks {
-		result[i] = task
-		result[i].Loop = loop
-		if control != nil {
-			// Apply loop control settings
-			if result[i].Args == nil {
-				result[i].Args = make(map[string]interface{})
-			}
-			result[i].Args["_loop_control"] = control
+	items, ok := loop.([]interface{})
+	if !ok {
+		items = []interface{}{loop}
+	}
+
+	loopVar := "item"
+	if control != nil && control.LoopVar != "" {
+		loopVar = control.LoopVar
+	}
+
+	var result []types.Task
+	for index, item := range items {
+		vars := map[string]interface{}{loopVar: item}
+		if control != nil && control.IndexVar != "" {
+			vars[control.IndexVar] = index
+		}
+		for _, task := range tasks {
+			task.Vars = types.DeepMergeInterfaceMaps(types.DeepMergeInterfaceMaps(map[string]interface{}{}, task.Vars), vars)
+			result = append(result, task)
 		}
 	}
 	return result
@@ -331,7 +493,7 @@ func ParseIncludeTask(data map[string]interface{}) (*IncludeTask, error) {
 		}
 	}
 
-	if when, ok := data["when"].(string); ok {
+	if when, ok := data["when"]; ok {
 		include.When = when
 	}
 
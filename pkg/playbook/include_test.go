@@ -0,0 +1,174 @@
+package playbook
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
+)
+
+func TestParserImports(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"site.yml": "- import_playbook: web.yml\n  vars:\n    env: prod\n  tags: [web]\n" +
+			"- name: db\n  hosts: db1\n  tasks:\n    - name: migrate\n      debug:\n        msg: migrate\n",
+		"web.yml": "- name: web\n  hosts: web1\n  tasks:\n" +
+			"    - import_tasks: tasks/setup.yml\n      when: manage_web\n      tags: setup\n" +
+			"    - include_tasks: tasks/deploy.yml\n",
+		"tasks/setup.yml":  "- name: install\n  debug:\n    msg: install\n- import_tasks: common.yml\n",
+		"tasks/common.yml": "- name: common\n  debug:\n    msg: common\n  when: common_enabled\n",
+		"tasks/deploy.yml": "- name: deploy\n  debug:\n    msg: deploy\n",
+	})
+
+	playbook, err := NewParser().ParseFile(filepath.Join(dir, "site.yml"))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(playbook.Plays) != 2 || playbook.Plays[0].Name != "web" || playbook.Plays[1].Name != "db" {
+		t.Fatalf("expected the imported web play before db, got %+v", playbook.Plays)
+	}
+
+	web := playbook.Plays[0]
+	if web.Vars["env"] != "prod" || !reflect.DeepEqual(web.Tags, []string{"web"}) {
+		t.Errorf("expected the import's vars and tags on the play, got %v %v", web.Vars, web.Tags)
+	}
+
+	var names []string
+	for _, task := range web.Tasks {
+		names = append(names, task.Name)
+	}
+	if expected := []string{"install", "common", ""}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected tasks %v, got %v", expected, names)
+	}
+
+	common := web.Tasks[1]
+	if !reflect.DeepEqual(common.When, []interface{}{"manage_web", "common_enabled"}) {
+		t.Errorf("expected the import condition to be combined, got %v", common.When)
+	}
+	if !reflect.DeepEqual(common.Tags, []string{"setup"}) {
+		t.Errorf("expected the import tags to be inherited, got %v", common.Tags)
+	}
+
+	include := web.Tasks[2]
+	if file := includeFile(&include); file != filepath.Join(dir, "tasks", "deploy.yml") {
+		t.Errorf("expected include_tasks to be anchored to the playbook, got %s", file)
+	}
+}
+
+func TestParserImportCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"site.yml": "- name: p\n  hosts: all\n  tasks:\n    - import_tasks: a.yml\n",
+		"a.yml":    "- import_tasks: b.yml\n",
+		"b.yml":    "- import_tasks: a.yml\n",
+	})
+
+	_, err := NewParser().ParseFile(filepath.Join(dir, "site.yml"))
+	if err == nil || !strings.Contains(err.Error(), "circular import_tasks") {
+		t.Fatalf("expected an import cycle error, got %v", err)
+	}
+}
+
+func TestParserVault(t *testing.T) {
+	vaults := vault.NewManager()
+	vaults.AddVault(vault.DefaultVaultIDLabel, "letmein")
+	secret, err := vaults.EncryptString("db_password", "s3cr3t", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := vaults.Encrypt([]byte("- name: rotate\n  debug:\n    msg: rotate\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"site.yml": "- name: app\n  hosts: all\n  vars:\n    " + strings.ReplaceAll(strings.TrimSpace(secret), "\n", "\n    ") + "\n" +
+			"  tasks:\n    - import_tasks: secret.yml\n",
+		"secret.yml": tasks,
+	})
+
+	parser := NewParser()
+	parser.SetVaultManager(vaults)
+	playbook, err := parser.ParseFile(filepath.Join(dir, "site.yml"))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if password := playbook.Plays[0].Vars["db_password"]; password != "s3cr3t" {
+		t.Errorf("expected the inline value to be decrypted, got %v", password)
+	}
+	if len(playbook.Plays[0].Tasks) != 1 || playbook.Plays[0].Tasks[0].Name != "rotate" {
+		t.Errorf("expected the encrypted task file to be imported, got %+v", playbook.Plays[0].Tasks)
+	}
+
+	// Without a password the encrypted task file cannot be read
+	_, err = NewParser().ParseFile(filepath.Join(dir, "site.yml"))
+	if err == nil || !strings.Contains(err.Error(), "no vault password") {
+		t.Fatalf("expected a missing vault password error, got %v", err)
+	}
+}
+
+func TestExecutorIncludeTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"Debian.yml": "- name: configure\n  debug:\n    msg: configure\n  tags: config\n",
+	})
+
+	runner := newRecordingRunner()
+	var tags [][]string
+	runner.onRun = func(task types.Task) { tags = append(tags, task.Tags) }
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+	executor.SetIncludePath(dir)
+
+	play := &types.Play{
+		Name:  "include",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false, "family": "Debian"},
+		Tasks: []types.Task{
+			{
+				Module:      includeTasksModule,
+				Args:        map[string]interface{}{"file": "{{ family }}.yml"},
+				Loop:        []interface{}{"a", "b"},
+				LoopControl: map[string]interface{}{"loop_var": "site"},
+				Tags:        []string{"sites"},
+			},
+			{
+				Module: includeTasksModule,
+				Args:   map[string]interface{}{"file": "missing.yml"},
+				When:   false,
+			},
+			{
+				Module: includeTasksModule,
+				Args:   map[string]interface{}{"file": "missing.yml"},
+				When:   "family == 'RedHat'",
+			},
+			// The conditions of tasks are left to the runner
+			{
+				Name:   "redhat",
+				Module: "debug",
+				Args:   map[string]interface{}{"msg": "redhat"},
+				When:   "family == 'RedHat'",
+			},
+		},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	if got := runner.taskNames(); !reflect.DeepEqual(got, []string{"configure", "configure", "redhat"}) {
+		t.Fatalf("expected the included task once per loop item and the conditional task, got %v", got)
+	}
+	for i, site := range []string{"a", "b"} {
+		if runner.calls[i].Vars["site"] != site {
+			t.Errorf("expected site=%s, got %v", site, runner.calls[i].Vars["site"])
+		}
+	}
+	if !reflect.DeepEqual(tags[0], []string{"config", "sites"}) {
+		t.Errorf("expected the include tags to be inherited, got %v", tags[0])
+	}
+}
@@ -0,0 +1,384 @@
+package playbook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Maintenance window modes
+const (
+	WindowModeRefuse = "refuse"
+	WindowModeWait   = "wait"
+)
+
+// maxWindowSearch bounds the search for the next window opening
+const maxWindowSearch = 366 * 24 * time.Hour
+
+// cronSchedule is a parsed five field cron expression
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week" with support
+// for *, lists, ranges and steps
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// 7 is an alias for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+
+	return &s, nil
+}
+
+// parseCronField parses a single cron field into a bitset of allowed values
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range [%d-%d] in %q", min, max, field)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// matches reports whether t falls on a minute selected by the schedule
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	// As in cron, a restricted day of month and day of week are OR-ed
+	if !s.domAny && !s.dowAny {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+type windowSpan struct {
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// maintenanceWindow is the validated form of types.MaintenanceWindow
+type maintenanceWindow struct {
+	spans      []windowSpan
+	location   *time.Location
+	mode       string
+	checkpoint string
+}
+
+// newMaintenanceWindow validates a play's maintenance window configuration
+func newMaintenanceWindow(cfg *types.MaintenanceWindow) (*maintenanceWindow, error) {
+	if len(cfg.Windows) == 0 {
+		return nil, fmt.Errorf("maintenance_window requires at least one window")
+	}
+
+	w := &maintenanceWindow{
+		location:   time.Local,
+		mode:       cfg.Mode,
+		checkpoint: cfg.Checkpoint,
+	}
+
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window timezone %q: %w", cfg.Timezone, err)
+		}
+		w.location = loc
+	}
+
+	switch w.mode {
+	case "":
+		w.mode = WindowModeRefuse
+	case WindowModeRefuse, WindowModeWait:
+	default:
+		return nil, fmt.Errorf("invalid maintenance window mode %q, expected %q or %q", cfg.Mode, WindowModeRefuse, WindowModeWait)
+	}
+
+	for i, spec := range cfg.Windows {
+		schedule, err := parseCron(spec.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: %w", i+1, err)
+		}
+		duration, err := time.ParseDuration(spec.Duration)
+		if err != nil || duration < time.Minute {
+			return nil, fmt.Errorf("maintenance window %d: duration must be at least 1m, got %q", i+1, spec.Duration)
+		}
+		w.spans = append(w.spans, windowSpan{schedule: schedule, duration: duration})
+	}
+
+	return w, nil
+}
+
+// isOpen reports whether any window covers t
+func (w *maintenanceWindow) isOpen(t time.Time) bool {
+	t = t.In(w.location)
+	for _, span := range w.spans {
+		for start := t.Truncate(time.Minute); t.Sub(start) < span.duration; start = start.Add(-time.Minute) {
+			if span.schedule.matches(start) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextOpen returns the earliest time at or after t when a window is open
+func (w *maintenanceWindow) nextOpen(t time.Time) (time.Time, error) {
+	if w.isOpen(t) {
+		return t, nil
+	}
+
+	t = t.In(w.location)
+	limit := t.Add(maxWindowSearch)
+	for next := t.Truncate(time.Minute).Add(time.Minute); next.Before(limit); next = next.Add(time.Minute) {
+		for _, span := range w.spans {
+			if span.schedule.matches(next) {
+				return next, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no maintenance window opens within %s", maxWindowSearch)
+}
+
+// windowCheckpoint records where a play stopped when its window closed
+type windowCheckpoint struct {
+	Play    string    `json:"play"`
+	Batch   int       `json:"batch,omitempty"`
+	Section string    `json:"section"`
+	Task    int       `json:"task"`
+	Name    string    `json:"task_name"`
+	Time    time.Time `json:"time"`
+}
+
+// sectionOrder ranks task sections so a checkpoint can be compared against them
+var sectionOrder = map[string]int{"pre_tasks": 0, "tasks": 1, "post_tasks": 2}
+
+// windowGuard enforces a maintenance window for the duration of one play
+type windowGuard struct {
+	window   *maintenanceWindow
+	executor *Executor
+	play     string
+	resume   *windowCheckpoint
+	batch    int // Serial batch being executed
+}
+
+// newWindowGuard prepares window enforcement for a play, picking up any
+// checkpoint left by an earlier run of the same play
+func (e *Executor) newWindowGuard(play *types.Play) (*windowGuard, error) {
+	window, err := newMaintenanceWindow(play.MaintenanceWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &windowGuard{window: window, executor: e, play: play.Name}
+	if window.checkpoint != "" {
+		cp, err := loadWindowCheckpoint(window.checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && cp.Play == play.Name {
+			g.resume = cp
+		}
+	}
+
+	return g, nil
+}
+
+// start checks the window before the play begins
+func (g *windowGuard) start(ctx context.Context) error {
+	now := g.executor.clock()
+	if g.window.isOpen(now) {
+		return nil
+	}
+
+	next, err := g.window.nextOpen(now)
+	if err != nil {
+		return err
+	}
+	if g.window.mode == WindowModeRefuse {
+		return fmt.Errorf("play '%s' is outside its maintenance window, next window opens at %s", g.play, next.Format(time.RFC3339))
+	}
+	return g.wait(ctx, next, "")
+}
+
+// skip reports whether a task already completed before the checkpoint
+func (g *windowGuard) skip(section string, index int) bool {
+	if g.resume == nil {
+		return false
+	}
+	if g.batch != g.resume.Batch {
+		return g.batch < g.resume.Batch
+	}
+	if sectionOrder[section] != sectionOrder[g.resume.Section] {
+		return sectionOrder[section] < sectionOrder[g.resume.Section]
+	}
+	return index < g.resume.Task
+}
+
+// beforeTask pauses or stops the play when the window has closed, writing a
+// checkpoint so that the next run resumes at this task
+func (g *windowGuard) beforeTask(ctx context.Context, section string, index int, task *types.Task) error {
+	now := g.executor.clock()
+	if g.window.isOpen(now) {
+		return nil
+	}
+
+	if g.window.checkpoint != "" {
+		cp := windowCheckpoint{Play: g.play, Batch: g.batch, Section: section, Task: index, Name: task.Name, Time: now}
+		if err := saveWindowCheckpoint(g.window.checkpoint, cp); err != nil {
+			return err
+		}
+	}
+
+	next, err := g.window.nextOpen(now)
+	if err != nil {
+		return err
+	}
+	if g.window.mode == WindowModeRefuse {
+		return fmt.Errorf("maintenance window closed before task '%s' in play '%s', next window opens at %s", task.Name, g.play, next.Format(time.RFC3339))
+	}
+	return g.wait(ctx, next, task.Name)
+}
+
+// wait blocks until the window opens again
+func (g *windowGuard) wait(ctx context.Context, until time.Time, taskName string) error {
+	g.executor.emitEvent(types.Event{
+		Type:      types.EventWindowWait,
+		Timestamp: types.GetCurrentTime(),
+		Task:      taskName,
+		Play:      g.play,
+		Data: map[string]interface{}{
+			"resume_at": until,
+		},
+	})
+
+	return g.executor.sleep(ctx, until.Sub(g.executor.clock()))
+}
+
+// finish removes the checkpoint once the play has completed
+func (g *windowGuard) finish() error {
+	if g.window.checkpoint == "" {
+		return nil
+	}
+	if err := os.Remove(g.window.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove maintenance checkpoint: %w", err)
+	}
+	return nil
+}
+
+func loadWindowCheckpoint(path string) (*windowCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maintenance checkpoint: %w", err)
+	}
+
+	var cp windowCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid maintenance checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+func saveWindowCheckpoint(path string, cp windowCheckpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write maintenance checkpoint: %w", err)
+	}
+	return nil
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,230 @@
+package playbook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		match   []string
+		noMatch []string
+		wantErr bool
+	}{
+		{
+			name:    "saturday night",
+			expr:    "0 22 * * 6",
+			match:   []string{"2026-10-17T22:00:00Z"},
+			noMatch: []string{"2026-10-17T22:01:00Z", "2026-10-16T22:00:00Z"},
+		},
+		{
+			name:    "ranges steps and lists",
+			expr:    "*/15 1-3 * * 1,3",
+			match:   []string{"2026-10-12T01:15:00Z", "2026-10-14T03:45:00Z"},
+			noMatch: []string{"2026-10-12T01:10:00Z", "2026-10-13T02:00:00Z", "2026-10-12T04:00:00Z"},
+		},
+		{
+			name:  "sunday as 7",
+			expr:  "30 2 * * 7",
+			match: []string{"2026-10-18T02:30:00Z"},
+		},
+		{
+			name:    "day of month or day of week",
+			expr:    "0 0 1 * 1",
+			match:   []string{"2026-10-01T00:00:00Z", "2026-10-12T00:00:00Z"},
+			noMatch: []string{"2026-10-13T00:00:00Z"},
+		},
+		{name: "too few fields", expr: "0 22 * *", wantErr: true},
+		{name: "out of range", expr: "0 24 * * *", wantErr: true},
+		{name: "bad step", expr: "*/0 * * * *", wantErr: true},
+		{name: "not a number", expr: "x * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, ts := range tt.match {
+				if !schedule.matches(mustParseTime(t, ts)) {
+					t.Errorf("expected %s to match %q", ts, tt.expr)
+				}
+			}
+			for _, ts := range tt.noMatch {
+				if schedule.matches(mustParseTime(t, ts)) {
+					t.Errorf("expected %s not to match %q", ts, tt.expr)
+				}
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	window, err := newMaintenanceWindow(&types.MaintenanceWindow{
+		Timezone: "America/New_York",
+		Windows:  []types.WindowSchedule{{Cron: "0 22 * * 6", Duration: "3h"}},
+	})
+	if err != nil {
+		t.Fatalf("newMaintenanceWindow failed: %v", err)
+	}
+
+	// Saturday 22:00 EDT is Sunday 02:00 UTC
+	tests := []struct {
+		at   string
+		open bool
+	}{
+		{"2026-10-18T01:59:00Z", false},
+		{"2026-10-18T02:00:00Z", true},
+		{"2026-10-18T04:59:59Z", true},
+		{"2026-10-18T05:00:00Z", false},
+	}
+	for _, tt := range tests {
+		if got := window.isOpen(mustParseTime(t, tt.at)); got != tt.open {
+			t.Errorf("isOpen(%s) = %v, expected %v", tt.at, got, tt.open)
+		}
+	}
+
+	next, err := window.nextOpen(mustParseTime(t, "2026-10-15T12:00:00Z"))
+	if err != nil {
+		t.Fatalf("nextOpen failed: %v", err)
+	}
+	if expected := mustParseTime(t, "2026-10-18T02:00:00Z"); !next.Equal(expected) {
+		t.Errorf("expected next window at %s, got %s", expected, next)
+	}
+
+	invalid := []*types.MaintenanceWindow{
+		{},
+		{Windows: []types.WindowSchedule{{Cron: "0 22 * * 6", Duration: "soon"}}},
+		{Windows: []types.WindowSchedule{{Cron: "0 22 * * 6", Duration: "1h"}}, Timezone: "Nowhere/Land"},
+		{Windows: []types.WindowSchedule{{Cron: "0 22 * * 6", Duration: "1h"}}, Mode: "ignore"},
+	}
+	for i, cfg := range invalid {
+		if _, err := newMaintenanceWindow(cfg); err == nil {
+			t.Errorf("expected error for invalid config %d", i)
+		}
+	}
+}
+
+func TestExecutorMaintenanceWindow(t *testing.T) {
+	windowPlay := func(mode, checkpoint string) *types.Play {
+		return &types.Play{
+			Name:  "patch",
+			Hosts: "db1",
+			Vars:  map[string]interface{}{"gather_facts": false},
+			Tasks: []types.Task{debugTask("one"), debugTask("two"), debugTask("three")},
+			MaintenanceWindow: &types.MaintenanceWindow{
+				Timezone:   "UTC",
+				Mode:       mode,
+				Checkpoint: checkpoint,
+				Windows:    []types.WindowSchedule{{Cron: "0 2 * * *", Duration: "1h"}},
+			},
+		}
+	}
+
+	t.Run("refuses to start outside the window", func(t *testing.T) {
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "db1"), nil)
+		executor.clock = func() time.Time { return mustParseTime(t, "2026-10-15T12:00:00Z") }
+
+		_, err := executor.ExecutePlay(context.Background(), windowPlay("", ""), nil)
+		if err == nil || !strings.Contains(err.Error(), "outside its maintenance window") {
+			t.Fatalf("expected refusal, got %v", err)
+		}
+		if len(runner.taskNames()) != 0 {
+			t.Errorf("expected no tasks to run, got %v", runner.taskNames())
+		}
+	})
+
+	t.Run("stops at the boundary and resumes from the checkpoint", func(t *testing.T) {
+		checkpoint := filepath.Join(t.TempDir(), "patch.checkpoint")
+		now := mustParseTime(t, "2026-10-15T02:58:00Z")
+
+		runner := newRecordingRunner()
+		runner.onRun = func(task types.Task) {
+			// Each task takes a minute, so the window closes before "three"
+			now = now.Add(time.Minute)
+		}
+		executor := NewExecutor(runner, newTestInventory(t, "db1"), nil)
+		executor.clock = func() time.Time { return now }
+
+		_, err := executor.ExecutePlay(context.Background(), windowPlay(WindowModeRefuse, checkpoint), nil)
+		if err == nil || !strings.Contains(err.Error(), "closed before task 'three'") {
+			t.Fatalf("expected window closed error, got %v", err)
+		}
+		if _, err := os.Stat(checkpoint); err != nil {
+			t.Fatalf("expected checkpoint to be written: %v", err)
+		}
+
+		// Next night, only the remaining task runs and the checkpoint is cleared
+		now = mustParseTime(t, "2026-10-16T02:00:00Z")
+		runner.onRun = nil
+		if _, err := executor.ExecutePlay(context.Background(), windowPlay(WindowModeRefuse, checkpoint), nil); err != nil {
+			t.Fatalf("resumed run failed: %v", err)
+		}
+
+		got := runner.taskNames()
+		if len(got) != 3 || got[2] != "three" {
+			t.Errorf("expected tasks [one two three], got %v", got)
+		}
+		if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+			t.Errorf("expected checkpoint to be removed, got %v", err)
+		}
+	})
+
+	t.Run("waits for the next window", func(t *testing.T) {
+		now := mustParseTime(t, "2026-10-15T01:30:00Z")
+		var waited time.Duration
+		var events []types.Event
+
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "db1"), nil)
+		executor.clock = func() time.Time { return now }
+		executor.sleep = func(ctx context.Context, d time.Duration) error {
+			waited += d
+			now = now.Add(d)
+			return nil
+		}
+		executor.AddEventCallback(func(event types.Event) { events = append(events, event) })
+
+		if _, err := executor.ExecutePlay(context.Background(), windowPlay(WindowModeWait, ""), nil); err != nil {
+			t.Fatalf("ExecutePlay failed: %v", err)
+		}
+		if waited != 30*time.Minute {
+			t.Errorf("expected to wait 30m, waited %s", waited)
+		}
+		if len(runner.taskNames()) != 3 {
+			t.Errorf("expected all tasks to run, got %v", runner.taskNames())
+		}
+
+		found := false
+		for _, event := range events {
+			if event.Type == types.EventWindowWait {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("expected a maintenance window wait event")
+		}
+	})
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("invalid time %q: %v", value, err)
+	}
+	return ts
+}
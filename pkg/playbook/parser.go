@@ -4,21 +4,31 @@ package playbook
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
 )
 
 // Parser handles parsing of YAML playbook files
-type Parser struct{}
+type Parser struct {
+	vaults *vault.Manager // Decrypts vault encrypted files and values
+}
 
 // NewParser creates a new playbook parser
 func NewParser() *Parser {
 	return &Parser{}
 }
 
+// SetVaultManager decrypts vault encrypted playbooks and task files, and
+// inline !vault values in them, with the passwords of vaults
+func (p *Parser) SetVaultManager(vaults *vault.Manager) {
+	p.vaults = vaults
+}
+
 // ParseFile parses a playbook from a YAML file
 func (p *Parser) ParseFile(filepath string) (*types.Playbook, error) {
 	data, err := os.ReadFile(filepath)
@@ -29,11 +39,130 @@ func (p *Parser) ParseFile(filepath string) (*types.Playbook, error) {
 	return p.Parse(data, filepath)
 }
 
-// Parse parses a playbook from YAML data
+// Parse parses a playbook from YAML data. Imported playbooks and task
+// files are resolved relative to the directory of source.
 func (p *Parser) Parse(data []byte, source string) (*types.Playbook, error) {
+	var chain []string
+	if source != "" {
+		chain = []string{filepath.Clean(source)}
+	}
+	playbook, err := p.parseImported(data, source, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plays may depend on plays of imported playbooks, so dependencies are
+	// checked once the imports are expanded
+	if hasPlayDependencies(playbook.Plays) {
+		if _, err := newPlayGraph(playbook.Plays); err != nil {
+			return nil, types.NewPlaybookError(source, "", "", "playbook validation failed", err)
+		}
+	}
+	return playbook, nil
+}
+
+// parseImported parses a playbook and expands its import_playbook entries
+// and import_tasks tasks. chain holds the playbook files being imported to
+// report import cycles.
+func (p *Parser) parseImported(data []byte, source string, chain []string) (*types.Playbook, error) {
+	playbook, err := p.parsePlays(data, source)
+	if err != nil {
+		return nil, err
+	}
+
+	includes := NewIncludeManager(filepath.Dir(source))
+	includes.vaults = p.vaults
+	plays := make([]types.Play, 0, len(playbook.Plays))
+	for _, play := range playbook.Plays {
+		if play.ImportPlaybook == "" {
+			if err := p.importTasks(&play, includes); err != nil {
+				return nil, types.NewPlaybookError(source, play.Name, "", "failed to import tasks", err)
+			}
+			plays = append(plays, play)
+			continue
+		}
+
+		imported, err := p.importPlaybook(&play, includes, chain)
+		if err != nil {
+			return nil, types.NewPlaybookError(source, "", "", "failed to import playbook", err)
+		}
+		plays = append(plays, imported...)
+	}
+	playbook.Plays = plays
+
+	return playbook, nil
+}
+
+// importPlaybook returns the plays of the playbook an import_playbook entry
+// names, with the entry's vars and tags applied
+func (p *Parser) importPlaybook(entry *types.Play, includes *IncludeManager, chain []string) ([]types.Play, error) {
+	if strings.Contains(entry.ImportPlaybook, "{{") {
+		return nil, fmt.Errorf("import_playbook '%s' cannot use variables", entry.ImportPlaybook)
+	}
+
+	path := filepath.Clean(includes.resolveFilePath(entry.ImportPlaybook))
+	for _, seen := range chain {
+		if seen == path {
+			return nil, fmt.Errorf("circular import_playbook: %s -> %s", strings.Join(chain, " -> "), path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playbook %s: %w", path, err)
+	}
+	imported, err := p.parseImported(data, path, append(chain, path))
+	if err != nil {
+		return nil, err
+	}
+
+	plays := imported.Plays
+	for i := range plays {
+		vars := types.DeepMergeInterfaceMaps(map[string]interface{}{}, imported.Vars)
+		vars = types.DeepMergeInterfaceMaps(vars, plays[i].Vars)
+		vars = types.DeepMergeInterfaceMaps(vars, entry.Vars)
+		if len(vars) > 0 {
+			plays[i].Vars = vars
+		}
+		if len(entry.Tags) > 0 {
+			plays[i].Tags = append(append([]string{}, entry.Tags...), plays[i].Tags...)
+		}
+	}
+	return plays, nil
+}
+
+// importTasks expands the import_tasks tasks of every section of a play
+func (p *Parser) importTasks(play *types.Play, includes *IncludeManager) error {
+	for _, section := range []*[]types.Task{&play.PreTasks, &play.Tasks, &play.PostTasks, &play.Handlers} {
+		if len(*section) == 0 {
+			continue
+		}
+		tasks, err := includes.expandImports(*section, nil)
+		if err != nil {
+			return err
+		}
+		*section = tasks
+	}
+	return nil
+}
+
+// parsePlays parses the plays of a playbook without resolving imports
+func (p *Parser) parsePlays(data []byte, source string) (*types.Playbook, error) {
+	// Decrypt vault data once and decode the formats below from the tree
+	var document yaml.Node
+	if err := p.vaults.DecodeYAML(data, &document); err != nil {
+		return nil, types.NewPlaybookError(source, "", "", "failed to parse YAML", err)
+	}
+	decode := func(out interface{}) error {
+		if document.Kind == 0 {
+			return nil
+		}
+		return document.Decode(out)
+	}
+
 	// First try to parse as array of plays (standard format)
 	var plays []types.Play
-	if err := yaml.Unmarshal(data, &plays); err == nil && len(plays) > 0 {
+	if err := decode(&plays); err == nil && len(plays) > 0 {
 		return &types.Playbook{
 			Plays: plays,
 		}, nil
@@ -41,7 +170,7 @@ func (p *Parser) Parse(data []byte, source string) (*types.Playbook, error) {
 
 	// Try to parse as single play
 	var singlePlay types.Play
-	if err := yaml.Unmarshal(data, &singlePlay); err == nil && singlePlay.Name != "" {
+	if err := decode(&singlePlay); err == nil && singlePlay.Name != "" {
 		return &types.Playbook{
 			Plays: []types.Play{singlePlay},
 		}, nil
@@ -53,7 +182,7 @@ func (p *Parser) Parse(data []byte, source string) (*types.Playbook, error) {
 		Plays []types.Play          `yaml:"plays,omitempty"`
 	}
 	
-	if err := yaml.Unmarshal(data, &playbookData); err != nil {
+	if err := decode(&playbookData); err != nil {
 		return nil, types.NewPlaybookError(source, "", "", "failed to parse YAML", err)
 	}
 
@@ -92,6 +221,11 @@ func (p *Parser) validatePlaybook(playbook *types.Playbook) error {
 
 // validatePlay validates a single play
 func (p *Parser) validatePlay(play *types.Play, index int) error {
+	// Imported playbooks are validated when they are parsed
+	if play.ImportPlaybook != "" {
+		return nil
+	}
+
 	if play.Name == "" {
 		return fmt.Errorf("play %d must have a name", index)
 	}
@@ -114,6 +248,32 @@ func (p *Parser) validatePlay(play *types.Play, index int) error {
 		return fmt.Errorf("play '%s' hosts must be string or array", play.Name)
 	}
 
+	// Validate serial batches against a nominal host count
+	if play.Serial != nil {
+		if _, err := batchSizes(play.Serial, 100); err != nil {
+			return fmt.Errorf("play '%s' has invalid serial: %w", play.Name, err)
+		}
+	}
+
+	if domain := play.FailureDomain; domain != nil {
+		if strings.TrimSpace(domain.Var) == "" {
+			return fmt.Errorf("play '%s' failure_domain must name a host variable", play.Name)
+		}
+		if domain.MaxHosts < 0 {
+			return fmt.Errorf("play '%s' failure_domain max_hosts must be positive", play.Name)
+		}
+	}
+
+	if play.MaxFailPercentage != nil && (*play.MaxFailPercentage < 0 || *play.MaxFailPercentage > 100) {
+		return fmt.Errorf("play '%s' max_fail_percentage must be between 0 and 100", play.Name)
+	}
+
+	for i, role := range play.Roles {
+		if strings.TrimSpace(role.Role) == "" {
+			return fmt.Errorf("role %d in play '%s' must have a name", i, play.Name)
+		}
+	}
+
 	// Validate tasks
 	for i, task := range play.Tasks {
 		if err := p.validateTask(&task, i, play.Name); err != nil {
@@ -147,6 +307,14 @@ func (p *Parser) validatePlay(play *types.Play, index int) error {
 
 // validateTask validates a single task
 func (p *Parser) validateTask(task *types.Task, index int, playName string) error {
+	// Task file directives are commonly left unnamed
+	if task.Module == importTasksModule || task.Module == includeTasksModule {
+		if includeFile(task) == "" {
+			return fmt.Errorf("%s task %d in play '%s' must name a file", task.Module, index, playName)
+		}
+		return nil
+	}
+
 	if task.Name == "" {
 		return fmt.Errorf("task %d in play '%s' must have a name", index, playName)
 	}
@@ -193,11 +361,6 @@ func (p *Parser) processPlay(play *types.Play) error {
 	// Normalize hosts to consistent format
 	play.Hosts = p.normalizeHosts(play.Hosts)
 
-	// Set default serial value
-	if play.Serial == 0 {
-		play.Serial = 1
-	}
-
 	// Set default strategy
 	if play.Strategy == "" {
 		play.Strategy = "linear"
@@ -368,7 +531,7 @@ func (p *Parser) LoadIncludeFile(filepath string) (interface{}, error) {
 	}
 
 	var result interface{}
-	if err := yaml.Unmarshal(data, &result); err != nil {
+	if err := p.vaults.DecodeYAML(data, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse include file %s: %w", filepath, err)
 	}
 
@@ -0,0 +1,480 @@
+package playbook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/expression"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Statuses of the tasks of an execution plan
+const (
+	PlanRun         = "run"         // Runs on the play's hosts, except any skipped ones
+	PlanConditional = "conditional" // Depends on what only the run can tell
+	PlanSkip        = "skip"        // Skipped on every host
+)
+
+// maxPlanIncludeDepth bounds the nesting of included task files a plan
+// follows, so that files including themselves end
+const maxPlanIncludeDepth = 10
+
+// ExecutionPlan is the ordered preview of what running a playbook would do,
+// worked out without connecting to any host
+type ExecutionPlan struct {
+	Playbook string        `json:"playbook,omitempty"`
+	Plays    []PlannedPlay `json:"plays"`
+	Summary  PlanSummary   `json:"summary"`
+}
+
+// PlannedPlay is a play of an execution plan and the hosts it targets
+type PlannedPlay struct {
+	Name        string        `json:"name"`
+	Pattern     string        `json:"pattern"`
+	Hosts       []string      `json:"hosts"`
+	GatherFacts bool          `json:"gather_facts"`
+	Serial      interface{}   `json:"serial,omitempty"`
+	Strategy    string        `json:"strategy,omitempty"`
+	DependsOn   []string      `json:"depends_on,omitempty"`
+	Tasks       []PlannedTask `json:"tasks"`
+}
+
+// PlannedTask is a task of an execution plan. Tasks of included files
+// follow the include with a greater depth.
+type PlannedTask struct {
+	Section      string                 `json:"section"`
+	Name         string                 `json:"name"`
+	Module       string                 `json:"module"`
+	Args         map[string]interface{} `json:"args,omitempty"`
+	Status       string                 `json:"status"`
+	Reason       string                 `json:"reason,omitempty"`
+	When         string                 `json:"when,omitempty"`
+	SkippedHosts []string               `json:"skipped_hosts,omitempty"`
+	Loop         string                 `json:"loop,omitempty"`
+	Delegate     string                 `json:"delegate_to,omitempty"`
+	Notify       []string               `json:"notify,omitempty"`
+	Depth        int                    `json:"depth,omitempty"`
+}
+
+// PlanSummary contains totals for an execution plan
+type PlanSummary struct {
+	Plays       int `json:"plays"`
+	Hosts       int `json:"hosts"`
+	Tasks       int `json:"tasks"`
+	Conditional int `json:"conditional"`
+	Skipped     int `json:"skipped"`
+}
+
+// Plan works out the plays, hosts and tasks running the playbook would go
+// through, in order, without connecting to any host. Hosts come from the
+// inventory and limit, tasks from the tag selection, roles and included
+// task files whose names are known. Conditions are evaluated per host with
+// the inventory, play and extra vars; those depending on facts, registered
+// results or set_fact are left to the run.
+func (e *Executor) Plan(playbook *types.Playbook, extraVars map[string]interface{}) (*ExecutionPlan, error) {
+	plan := &ExecutionPlan{Plays: make([]PlannedPlay, 0, len(playbook.Plays))}
+	vars := runVars(playbook, extraVars)
+
+	seen := make(map[string]bool)
+	for i := range playbook.Plays {
+		planned, err := e.planPlay(&playbook.Plays[i], vars)
+		if err != nil {
+			return nil, err
+		}
+		plan.Plays = append(plan.Plays, *planned)
+
+		for _, host := range planned.Hosts {
+			seen[host] = true
+		}
+		for _, task := range planned.Tasks {
+			plan.Summary.Tasks++
+			switch task.Status {
+			case PlanConditional:
+				plan.Summary.Conditional++
+			case PlanSkip:
+				plan.Summary.Skipped++
+			}
+		}
+	}
+	plan.Summary.Plays = len(plan.Plays)
+	plan.Summary.Hosts = len(seen)
+
+	return plan, nil
+}
+
+// taskPlanner plans the tasks of one play
+type taskPlanner struct {
+	e     *Executor
+	hosts []types.Host
+	tasks []PlannedTask
+
+	// runtime holds the variables that only the run defines: registered
+	// results and facts set by tasks
+	runtime map[string]bool
+}
+
+func (e *Executor) planPlay(play *types.Play, vars map[string]interface{}) (*PlannedPlay, error) {
+	hosts, err := e.getPlayHosts(play)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hosts for play %s: %w", play.Name, err)
+	}
+
+	playVars := e.mergePlayVars(play, vars)
+	if len(play.Roles) > 0 {
+		expanded, err := e.expandRoles(play, playVars)
+		if err != nil {
+			return nil, fmt.Errorf("play %s: %w", play.Name, err)
+		}
+		play = expanded
+	}
+	play = inheritPlayTags(play)
+
+	planned := &PlannedPlay{
+		Name:        play.Name,
+		Pattern:     strings.Join(NewParser().ParseInventoryPattern(play.Hosts), ","),
+		Hosts:       make([]string, 0, len(hosts)),
+		GatherFacts: e.shouldGatherFacts(playVars),
+		Serial:      play.Serial,
+		Strategy:    play.Strategy,
+		DependsOn:   play.DependsOn,
+		Tasks:       []PlannedTask{},
+	}
+	for _, host := range hosts {
+		planned.Hosts = append(planned.Hosts, host.Name)
+	}
+	if len(hosts) == 0 {
+		return planned, nil
+	}
+
+	p := &taskPlanner{e: e, hosts: hosts, runtime: make(map[string]bool)}
+	for _, section := range [][]types.Task{play.PreTasks, play.Tasks, play.PostTasks, play.Handlers} {
+		p.collectRuntimeVars(section)
+	}
+	p.plan(play.PreTasks, playVars, "pre_tasks", 0)
+	p.plan(play.Tasks, playVars, "tasks", 0)
+	p.plan(play.PostTasks, playVars, "post_tasks", 0)
+	p.planHandlers(play.Handlers, playVars)
+	planned.Tasks = p.tasks
+
+	return planned, nil
+}
+
+// collectRuntimeVars records the variables tasks register or set as facts
+func (p *taskPlanner) collectRuntimeVars(tasks []types.Task) {
+	for _, task := range tasks {
+		if task.Register != "" {
+			p.runtime[task.Register] = true
+		}
+		if task.Module == "set_fact" {
+			for name := range task.Args {
+				p.runtime[name] = true
+			}
+		}
+	}
+}
+
+// plan adds the tasks of a section that the tag selection runs
+func (p *taskPlanner) plan(tasks []types.Task, vars map[string]interface{}, section string, depth int) {
+	for i := range tasks {
+		task := &tasks[i]
+		include := isTaskInclude(task)
+		if !include && !p.e.tags.selects(task) {
+			continue
+		}
+
+		taskVars := p.e.mergeTaskVars(task, vars)
+		planned := p.newTask(task, taskVars, section, depth)
+		p.tasks = append(p.tasks, planned)
+
+		if include && planned.Status != PlanSkip {
+			p.planInclude(task, taskVars, vars, section, depth)
+		}
+	}
+}
+
+// planInclude follows an included task file whose name is known before
+// the run. Its tasks carry the condition of the include.
+func (p *taskPlanner) planInclude(task *types.Task, taskVars, vars map[string]interface{}, section string, depth int) {
+	planned := &p.tasks[len(p.tasks)-1]
+
+	include := includeFromTask(task, IncludeDynamic)
+	include.File = types.ExpandVariables(include.File, taskVars)
+	include.Loop = nil
+	switch {
+	case strings.Contains(include.File, "{{"):
+		planned.Reason = "task file named at run time"
+		return
+	case depth >= maxPlanIncludeDepth:
+		planned.Reason = "task files nested too deeply to follow"
+		return
+	}
+
+	tasks, err := p.e.includes.ProcessInclude(context.Background(), include)
+	if err != nil {
+		planned.Reason = err.Error()
+		return
+	}
+	p.collectRuntimeVars(tasks)
+	p.plan(tasks, vars, section, depth+1)
+}
+
+// planHandlers adds the handlers the planned tasks notify, which run only
+// when those tasks change hosts
+func (p *taskPlanner) planHandlers(handlers []types.Task, vars map[string]interface{}) {
+	notified := make(map[string]bool)
+	for _, task := range p.tasks {
+		for _, name := range task.Notify {
+			notified[name] = true
+		}
+	}
+
+	for i := range handlers {
+		handler := &handlers[i]
+		if !notified[handler.Name] && (handler.Listen == "" || !notified[handler.Listen]) {
+			continue
+		}
+		planned := p.newTask(handler, p.e.mergeTaskVars(handler, vars), "handlers", 0)
+		if planned.Status == PlanRun {
+			planned.Status = PlanConditional
+			planned.Reason = "runs when notified"
+		}
+		p.tasks = append(p.tasks, planned)
+	}
+}
+
+// newTask plans a task on the play's hosts
+func (p *taskPlanner) newTask(task *types.Task, vars map[string]interface{}, section string, depth int) PlannedTask {
+	noLog := task.NoLogEnabled()
+	if task.NoLog == nil {
+		noLog, _ = vars["_no_log"].(bool)
+	}
+	args := task.Args
+	if noLog {
+		args = types.CensorValues(args)
+	}
+
+	planned := PlannedTask{
+		Section:  section,
+		Name:     task.Name,
+		Module:   string(task.Module),
+		Args:     args,
+		Status:   PlanRun,
+		When:     conditionText(task.When),
+		Loop:     loopText(task.Loop),
+		Delegate: task.Delegate,
+		Notify:   task.Notify,
+		Depth:    depth,
+	}
+	if planned.Name == "" {
+		planned.Name = planned.Module
+	}
+	if task.When == nil {
+		return planned
+	}
+
+	for _, host := range p.hosts {
+		hostVars := p.hostVars(host, vars)
+		if p.dependsOnRun(planned.When, hostVars) {
+			planned.Status = PlanConditional
+			planned.Reason = "condition decided at run time"
+			planned.SkippedHosts = nil
+			return planned
+		}
+		ok, err := expression.Condition(task.When, hostVars)
+		if err != nil {
+			planned.Status = PlanConditional
+			planned.Reason = "condition decided at run time"
+			planned.SkippedHosts = nil
+			return planned
+		}
+		if !ok {
+			planned.SkippedHosts = append(planned.SkippedHosts, host.Name)
+		}
+	}
+	if len(planned.SkippedHosts) == len(p.hosts) {
+		planned.Status = PlanSkip
+		planned.Reason = "condition is false on every host"
+		planned.SkippedHosts = nil
+	}
+	return planned
+}
+
+// planIdentifier matches the variable names a condition may use
+var planIdentifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// dependsOnRun reports whether a condition uses variables only the run
+// sets: registered results, set_fact facts and gathered facts. Tests for
+// defined variables count too, since the run may define them.
+func (p *taskPlanner) dependsOnRun(condition string, vars map[string]interface{}) bool {
+	for _, name := range planIdentifier.FindAllString(condition, -1) {
+		if _, known := vars[name]; known {
+			continue
+		}
+		if p.runtime[name] || name == "defined" || name == "undefined" || strings.HasPrefix(name, "ansible_") {
+			return true
+		}
+	}
+	return false
+}
+
+// hostVars returns the variables of a task on a host, without the facts
+// and results the run adds
+func (p *taskPlanner) hostVars(host types.Host, vars map[string]interface{}) map[string]interface{} {
+	hostVars := host.Variables
+	if p.e.inventory != nil {
+		if inherited, err := p.e.inventory.GetHostVars(host.Name); err == nil {
+			hostVars = inherited
+		}
+	}
+	merged := withExtraVars(types.DeepMergeInterfaceMaps(hostVars, vars))
+	merged["inventory_hostname"] = host.Name
+	return merged
+}
+
+// conditionText returns a when condition as written, joining lists of
+// conditions with "and"
+func conditionText(condition interface{}) string {
+	switch v := condition.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = conditionText(part)
+		}
+		return strings.Join(parts, " and ")
+	case []string:
+		return strings.Join(v, " and ")
+	}
+	return types.ConvertToString(condition)
+}
+
+// loopText describes the loop of a task: its number of items, or the
+// expression giving them
+func loopText(loop interface{}) string {
+	switch v := loop.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		return fmt.Sprintf("%d item(s)", len(v))
+	}
+	return types.ConvertToString(loop)
+}
+
+// planArgLength is the length of the longest argument value the text plan
+// shows in full
+const planArgLength = 60
+
+// Text renders the plan for review, one play after another with the tasks
+// in the order they run. Tasks are marked + when they run, ? when the run
+// decides and - when skipped.
+func (p *ExecutionPlan) Text() string {
+	var b strings.Builder
+
+	title := p.Playbook
+	if title == "" {
+		title = "playbook"
+	}
+	fmt.Fprintf(&b, "Execution plan for %s: %d play(s), %d host(s), %d task(s)", title, p.Summary.Plays, p.Summary.Hosts, p.Summary.Tasks)
+	if p.Summary.Conditional > 0 || p.Summary.Skipped > 0 {
+		fmt.Fprintf(&b, " (%d conditional, %d skipped)", p.Summary.Conditional, p.Summary.Skipped)
+	}
+	b.WriteString("\n")
+
+	for i, play := range p.Plays {
+		fmt.Fprintf(&b, "\nPLAY #%d [%s] hosts: %s\n", i+1, play.Name, play.Pattern)
+		if len(play.Hosts) == 0 {
+			b.WriteString("  no hosts matched, the play is skipped\n")
+			continue
+		}
+		fmt.Fprintf(&b, "  targets (%d): %s\n", len(play.Hosts), strings.Join(play.Hosts, ", "))
+		if play.GatherFacts {
+			b.WriteString("  gathers facts\n")
+		}
+		if play.Serial != nil {
+			fmt.Fprintf(&b, "  serial: %v\n", play.Serial)
+		}
+		if play.Strategy != "" {
+			fmt.Fprintf(&b, "  strategy: %s\n", play.Strategy)
+		}
+		if len(play.DependsOn) > 0 {
+			fmt.Fprintf(&b, "  after: %s\n", strings.Join(play.DependsOn, ", "))
+		}
+
+		section := ""
+		for _, task := range play.Tasks {
+			if task.Section != section {
+				section = task.Section
+				fmt.Fprintf(&b, "\n  %s:\n", section)
+			}
+			writePlannedTask(&b, task)
+		}
+	}
+
+	return b.String()
+}
+
+func writePlannedTask(b *strings.Builder, task PlannedTask) {
+	marker := "+"
+	switch task.Status {
+	case PlanConditional:
+		marker = "?"
+	case PlanSkip:
+		marker = "-"
+	}
+	indent := strings.Repeat("  ", task.Depth+2)
+	fmt.Fprintf(b, "%s%s %s (%s)\n", indent, marker, task.Name, task.Module)
+
+	indent += "    "
+	for _, key := range sortedArgKeys(task.Args) {
+		fmt.Fprintf(b, "%s%s: %s\n", indent, key, planArgValue(task.Args[key]))
+	}
+	if task.Loop != "" {
+		fmt.Fprintf(b, "%sloop: %s\n", indent, task.Loop)
+	}
+	if task.Delegate != "" {
+		fmt.Fprintf(b, "%sdelegate_to: %s\n", indent, task.Delegate)
+	}
+	if task.When != "" {
+		fmt.Fprintf(b, "%swhen: %s\n", indent, task.When)
+	}
+	if len(task.SkippedHosts) > 0 {
+		fmt.Fprintf(b, "%sskipped on: %s\n", indent, strings.Join(task.SkippedHosts, ", "))
+	}
+	if len(task.Notify) > 0 {
+		fmt.Fprintf(b, "%snotify: %s\n", indent, strings.Join(task.Notify, ", "))
+	}
+	if task.Reason != "" {
+		fmt.Fprintf(b, "%s(%s)\n", indent, task.Reason)
+	}
+}
+
+func sortedArgKeys(args map[string]interface{}) []string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// planArgValue formats an argument value on one line, shortening long ones
+func planArgValue(value interface{}) string {
+	text, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprintf("%v", value))
+		}
+		text = string(encoded)
+	}
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\n", "\\n")
+	if len(text) > planArgLength {
+		text = text[:planArgLength] + "..."
+	}
+	return text
+}
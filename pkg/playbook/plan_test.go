@@ -0,0 +1,174 @@
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// planPlaybook is a playbook with conditions of every kind, a role, an
+// included task file and a handler
+const planPlaybook = `
+- name: web
+  hosts: web
+  vars:
+    gather_facts: false
+    env: prod
+  roles:
+    - role: base
+  tasks:
+    - name: install nginx
+      apt: {name: nginx, state: present}
+      tags: [packages]
+    - name: only in staging
+      debug: {msg: staging}
+      when: env == 'staging'
+    - name: only on primaries
+      debug: {msg: primary}
+      when: primary | default(false)
+    - name: check config
+      command: nginx -t
+      register: check
+    - name: report
+      debug: {msg: broken}
+      when: check.rc != 0
+    - name: secret
+      user: {name: deploy, password: hunter2}
+      no_log: true
+      notify: restart nginx
+    - name: extras
+      include_tasks: extras.yml
+  handlers:
+    - name: restart nginx
+      service: {name: nginx, state: restarted}
+    - name: never notified
+      debug: {msg: idle}
+
+- name: db
+  hosts: db
+  tasks:
+    - name: migrate
+      debug: {msg: migrate}
+`
+
+func TestExecutorPlan(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, filepath.Join(dir, "roles"), map[string]string{
+		"base/tasks/main.yml": "- name: base setup\n  debug:\n    msg: base\n",
+	})
+	if err := os.WriteFile(filepath.Join(dir, "extras.yml"), []byte("- name: extra\n  debug:\n    msg: extra\n  tags: [extra]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var plays []types.Play
+	if err := yaml.Unmarshal([]byte(planPlaybook), &plays); err != nil {
+		t.Fatalf("failed to parse playbook: %v", err)
+	}
+
+	inv := inventory.NewStaticInventory()
+	for _, host := range []types.Host{
+		{Name: "web1", Variables: map[string]interface{}{"primary": true}},
+		{Name: "web2"},
+	} {
+		if err := inv.AddHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := inv.AddGroup(types.Group{Name: "web", Hosts: []string{"web1", "web2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	executor := NewExecutor(newRecordingRunner(), inv, nil)
+	executor.SetRolesPath(filepath.Join(dir, "roles"))
+	executor.SetIncludePath(dir)
+	executor.SetTags(TagSelection{SkipTags: []string{"packages"}})
+
+	plan, err := executor.Plan(&types.Playbook{Plays: plays}, nil)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if len(plan.Plays) != 2 {
+		t.Fatalf("expected 2 plays, got %d", len(plan.Plays))
+	}
+	web := plan.Plays[0]
+	if !reflect.DeepEqual(web.Hosts, []string{"web1", "web2"}) || web.GatherFacts {
+		t.Errorf("unexpected play %+v", web)
+	}
+	if db := plan.Plays[1]; len(db.Hosts) != 0 || len(db.Tasks) != 0 {
+		t.Errorf("expected the db play to match no hosts, got %+v", db)
+	}
+
+	type planned struct {
+		name, status string
+		skipped      []string
+		depth        int
+	}
+	var got []planned
+	for _, task := range web.Tasks {
+		got = append(got, planned{task.Name, task.Status, task.SkippedHosts, task.Depth})
+	}
+	expected := []planned{
+		{"base setup", PlanRun, nil, 0},
+		{"only in staging", PlanSkip, nil, 0},
+		{"only on primaries", PlanRun, []string{"web2"}, 0},
+		{"check config", PlanRun, nil, 0},
+		{"report", PlanConditional, nil, 0},
+		{"secret", PlanRun, nil, 0},
+		{"extras", PlanRun, nil, 0},
+		{"extra", PlanRun, nil, 1},
+		{"restart nginx", PlanConditional, nil, 0},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected tasks %+v, got %+v", expected, got)
+	}
+
+	if secret := web.Tasks[5]; secret.Args["password"] != types.NoLogValue {
+		t.Errorf("expected no_log arguments to be censored, got %v", secret.Args)
+	}
+	if plan.Summary != (PlanSummary{Plays: 2, Hosts: 2, Tasks: 9, Conditional: 2, Skipped: 1}) {
+		t.Errorf("unexpected summary %+v", plan.Summary)
+	}
+
+	text := plan.Text()
+	for _, line := range []string{
+		"Execution plan for playbook: 2 play(s), 2 host(s), 9 task(s) (2 conditional, 1 skipped)",
+		"  targets (2): web1, web2",
+		"    - only in staging (debug)",
+		"        skipped on: web2",
+		"      + extra (debug)",
+		"        password: VALUE_SPECIFIED_IN_NO_LOG_PARAMETER",
+		"        (runs when notified)",
+		"  no hosts matched, the play is skipped",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("expected %q in plan:\n%s", line, text)
+		}
+	}
+}
+
+func TestExecutorPlan_Limit(t *testing.T) {
+	var plays []types.Play
+	if err := yaml.Unmarshal([]byte("- name: all\n  hosts: all\n  tasks:\n    - debug: {msg: hi}\n"), &plays); err != nil {
+		t.Fatalf("failed to parse playbook: %v", err)
+	}
+	executor := NewExecutor(newRecordingRunner(), newTestInventory(t, "web1", "web2", "db1"), nil)
+	executor.SetLimit("web*")
+
+	plan, err := executor.Plan(&types.Playbook{Plays: plays}, map[string]interface{}{"env": "prod"})
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if hosts := plan.Plays[0].Hosts; !reflect.DeepEqual(hosts, []string{"web1", "web2"}) {
+		t.Errorf("expected the limited hosts, got %v", hosts)
+	}
+	if task := plan.Plays[0].Tasks[0]; task.Name != "debug" || !plan.Plays[0].GatherFacts {
+		t.Errorf("unexpected plan %+v", plan.Plays[0])
+	}
+}
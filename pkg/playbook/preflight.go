@@ -0,0 +1,81 @@
+package playbook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Preflighter checks that hosts are reachable, returning a failed result for
+// each one that is not. *runner.TaskRunner implements it.
+type Preflighter interface {
+	Preflight(ctx context.Context, hosts []types.Host, timeout time.Duration) []types.Result
+}
+
+// preflightCheck is the reachability check run before a playbook's first
+// task, and the hosts it found unreachable
+type preflightCheck struct {
+	checker     Preflighter
+	timeout     time.Duration
+	abort       bool
+	unreachable map[string]bool
+}
+
+// SetPreflight checks that all hosts targeted by the playbook are reachable
+// before any task runs. Unreachable hosts are reported up front and left out
+// of every play, or, with abort, the playbook stops before running anything.
+func (e *Executor) SetPreflight(checker Preflighter, timeout time.Duration, abort bool) {
+	e.preflight = &preflightCheck{checker: checker, timeout: timeout, abort: abort}
+}
+
+// runPreflight probes the hosts of every play in the playbook at once
+func (e *Executor) runPreflight(ctx context.Context, playbook *types.Playbook) ([]types.Result, error) {
+	var hosts []types.Host
+	for i := range playbook.Plays {
+		playHosts, err := e.getPlayHosts(&playbook.Plays[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hosts for play %s: %w", playbook.Plays[i].Name, err)
+		}
+		hosts = append(hosts, playHosts...)
+	}
+	hosts = e.removeDuplicateHosts(hosts)
+
+	task := &types.Task{Name: "Preflight", Module: "preflight"}
+	if e.callbacks != nil {
+		e.callbacks.OnTaskStart(task, hosts)
+	}
+
+	results := e.preflight.checker.Preflight(ctx, hosts, e.preflight.timeout)
+	e.preflight.unreachable = make(map[string]bool, len(results))
+	names := make([]string, len(results))
+	for i := range results {
+		e.preflight.unreachable[results[i].Host] = true
+		names[i] = results[i].Host
+		if e.callbacks != nil {
+			e.callbacks.OnTaskResult(task, &results[i])
+		}
+	}
+
+	if e.preflight.abort && len(results) > 0 {
+		return results, fmt.Errorf("preflight failed: %d of %d hosts unreachable: %s", len(results), len(hosts), strings.Join(names, ", "))
+	}
+	return results, nil
+}
+
+// reachableHosts leaves out the hosts the preflight check found unreachable
+func (e *Executor) reachableHosts(hosts []types.Host) []types.Host {
+	if e.preflight == nil || len(e.preflight.unreachable) == 0 {
+		return hosts
+	}
+
+	reachable := make([]types.Host, 0, len(hosts))
+	for _, host := range hosts {
+		if !e.preflight.unreachable[host.Name] {
+			reachable = append(reachable, host)
+		}
+	}
+	return reachable
+}
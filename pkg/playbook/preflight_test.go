@@ -0,0 +1,81 @@
+package playbook
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// fakePreflighter reports the listed hosts as unreachable
+type fakePreflighter struct {
+	unreachable map[string]bool
+	probed      []string
+}
+
+func (f *fakePreflighter) Preflight(ctx context.Context, hosts []types.Host, timeout time.Duration) []types.Result {
+	var results []types.Result
+	for _, host := range hosts {
+		f.probed = append(f.probed, host.Name)
+		if f.unreachable[host.Name] {
+			results = append(results, types.Result{
+				Host:     host.Name,
+				TaskName: "Preflight",
+				Error:    errors.New("connection refused"),
+				Data:     map[string]interface{}{"unreachable": true},
+			})
+		}
+	}
+	return results
+}
+
+func TestExecutorPreflight(t *testing.T) {
+	playbook := &types.Playbook{Plays: []types.Play{
+		{Name: "web", Hosts: "web1,web2", Vars: map[string]interface{}{"gather_facts": false}, Tasks: []types.Task{debugTask("deploy")}},
+		{Name: "all", Hosts: "web1,web2,db1", Vars: map[string]interface{}{"gather_facts": false}, Tasks: []types.Task{debugTask("check")}},
+	}}
+
+	t.Run("LeavesOutUnreachableHosts", func(t *testing.T) {
+		runner := newRecordingRunner()
+		checker := &fakePreflighter{unreachable: map[string]bool{"web2": true}}
+		executor := NewExecutor(runner, newTestInventory(t, "web1", "web2", "db1"), nil)
+		executor.SetPreflight(checker, time.Second, false)
+
+		results, err := executor.Execute(context.Background(), playbook, nil)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+
+		if expected := []string{"web1", "web2", "db1"}; !reflect.DeepEqual(checker.probed, expected) {
+			t.Errorf("expected each host to be probed once, got %v", checker.probed)
+		}
+		if len(results) == 0 || results[0].Host != "web2" || results[0].TaskName != "Preflight" {
+			t.Errorf("expected the unreachable host to be reported first, got %+v", results)
+		}
+		if len(runner.calls) != 2 {
+			t.Fatalf("expected 2 task runs, got %d", len(runner.calls))
+		}
+		if !reflect.DeepEqual(runner.calls[0].Hosts, []string{"web1"}) || !reflect.DeepEqual(runner.calls[1].Hosts, []string{"web1", "db1"}) {
+			t.Errorf("expected web2 to be left out of every play, got %v and %v", runner.calls[0].Hosts, runner.calls[1].Hosts)
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		runner := newRecordingRunner()
+		checker := &fakePreflighter{unreachable: map[string]bool{"db1": true}}
+		executor := NewExecutor(runner, newTestInventory(t, "web1", "web2", "db1"), nil)
+		executor.SetPreflight(checker, time.Second, true)
+
+		_, err := executor.Execute(context.Background(), playbook, nil)
+		if err == nil || !strings.Contains(err.Error(), "1 of 3 hosts unreachable: db1") {
+			t.Fatalf("expected the preflight to abort the run, got %v", err)
+		}
+		if len(runner.calls) != 0 {
+			t.Errorf("expected no task to run, got %v", runner.taskNames())
+		}
+	})
+}
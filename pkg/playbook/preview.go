@@ -0,0 +1,351 @@
+package playbook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Preview payload formats
+const (
+	PreviewFormatJSON  = "json"
+	PreviewFormatSlack = "slack"
+)
+
+// ChangePreview is the consolidated report of changes a check mode run
+// predicts, published for review before the real run proceeds
+type ChangePreview struct {
+	ID          string                     `json:"id"`
+	Playbook    string                     `json:"playbook,omitempty"`
+	GeneratedAt time.Time                  `json:"generated_at"`
+	Hosts       map[string][]PlannedChange `json:"hosts"`
+	Failures    map[string][]PlannedChange `json:"failures,omitempty"`
+	Summary     PreviewSummary             `json:"summary"`
+}
+
+// PlannedChange describes a single task predicted to change a host
+type PlannedChange struct {
+	Task    string `json:"task"`
+	Module  string `json:"module"`
+	Message string `json:"message,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+}
+
+// PreviewSummary contains totals for a change preview
+type PreviewSummary struct {
+	HostsChanged int `json:"hosts_changed"`
+	Changes      int `json:"changes"`
+	Failures     int `json:"failures"`
+}
+
+// HasChanges reports whether the preview predicts any change
+func (p *ChangePreview) HasChanges() bool {
+	return p.Summary.Changes > 0
+}
+
+// BuildChangePreview consolidates check mode results into a preview
+func BuildChangePreview(name string, results []types.Result) *ChangePreview {
+	preview := &ChangePreview{
+		ID:          newPreviewID(),
+		Playbook:    name,
+		GeneratedAt: types.GetCurrentTime(),
+		Hosts:       make(map[string][]PlannedChange),
+		Failures:    make(map[string][]PlannedChange),
+	}
+
+	for i := range results {
+		result := types.CensorResult(&results[i])
+		change := PlannedChange{
+			Task:    result.TaskName,
+			Module:  result.ModuleName,
+			Message: result.Message,
+		}
+		if result.Diff != nil {
+			change.Diff = formatPreviewDiff(result.Diff)
+		}
+
+		switch {
+		case !result.Success:
+			if result.Error != nil && change.Message == "" {
+				change.Message = result.Error.Error()
+			}
+			preview.Failures[result.Host] = append(preview.Failures[result.Host], change)
+			preview.Summary.Failures++
+		case result.Changed:
+			preview.Hosts[result.Host] = append(preview.Hosts[result.Host], change)
+			preview.Summary.Changes++
+		}
+	}
+	preview.Summary.HostsChanged = len(preview.Hosts)
+
+	return preview
+}
+
+// formatPreviewDiff prefers a unified diff and falls back to before/after
+func formatPreviewDiff(diff *types.DiffResult) string {
+	if diff.Diff != "" {
+		return diff.Diff
+	}
+	if !diff.Prepared && diff.Before == "" && diff.After == "" {
+		return ""
+	}
+	return fmt.Sprintf("--- before\n%s\n+++ after\n%s", diff.Before, diff.After)
+}
+
+// Text renders the preview as a plain text report
+func (p *ChangePreview) Text() string {
+	var b strings.Builder
+
+	title := p.Playbook
+	if title == "" {
+		title = p.ID
+	}
+	fmt.Fprintf(&b, "Planned changes for %s: %d change(s) on %d host(s)", title, p.Summary.Changes, p.Summary.HostsChanged)
+	if p.Summary.Failures > 0 {
+		fmt.Fprintf(&b, ", %d failure(s) in check mode", p.Summary.Failures)
+	}
+	b.WriteString("\n")
+
+	for _, host := range sortedHostNames(p.Hosts) {
+		fmt.Fprintf(&b, "\n%s\n", host)
+		for _, change := range p.Hosts[host] {
+			fmt.Fprintf(&b, "  ~ %s (%s)\n", change.Task, change.Module)
+			if change.Diff != "" {
+				for _, line := range strings.Split(strings.TrimRight(change.Diff, "\n"), "\n") {
+					fmt.Fprintf(&b, "      %s\n", line)
+				}
+			}
+		}
+	}
+
+	for _, host := range sortedHostNames(p.Failures) {
+		fmt.Fprintf(&b, "\n%s (check mode failures)\n", host)
+		for _, change := range p.Failures[host] {
+			fmt.Fprintf(&b, "  ! %s: %s\n", change.Task, change.Message)
+		}
+	}
+
+	return b.String()
+}
+
+func sortedHostNames(m map[string][]PlannedChange) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newPreviewID() string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(buf))
+}
+
+// PreviewPublisher sends a change preview to reviewers
+type PreviewPublisher interface {
+	Publish(ctx context.Context, preview *ChangePreview) error
+}
+
+// PreviewAcknowledger blocks until reviewers approve or reject a preview
+type PreviewAcknowledger interface {
+	WaitForAck(ctx context.Context, preview *ChangePreview) error
+}
+
+// WebhookPublisher posts previews as JSON, either the raw report or a Slack
+// incoming webhook message
+type WebhookPublisher struct {
+	URL     string
+	Format  string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// NewWebhookPublisher creates a publisher posting to url in the given format
+func NewWebhookPublisher(url, format string) *WebhookPublisher {
+	if format == "" {
+		format = PreviewFormatJSON
+	}
+	return &WebhookPublisher{
+		URL:     url,
+		Format:  format,
+		Headers: make(map[string]string),
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Publish implements PreviewPublisher
+func (w *WebhookPublisher) Publish(ctx context.Context, preview *ChangePreview) error {
+	var payload interface{}
+	switch w.Format {
+	case PreviewFormatJSON:
+		payload = preview
+	case PreviewFormatSlack:
+		payload = map[string]string{"text": "```\n" + preview.Text() + "```"}
+	default:
+		return fmt.Errorf("unsupported preview format %q", w.Format)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode change preview: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish change preview: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("change preview webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// HTTPAcknowledger polls an approval endpoint until the preview is approved
+// or rejected. The endpoint receives the preview id as the "id" query
+// parameter and answers {"status": "approved"|"rejected"|"pending"}.
+type HTTPAcknowledger struct {
+	URL          string
+	PollInterval time.Duration
+	Timeout      time.Duration
+	Client       *http.Client
+}
+
+// NewHTTPAcknowledger creates an acknowledger polling url
+func NewHTTPAcknowledger(url string, timeout time.Duration) *HTTPAcknowledger {
+	return &HTTPAcknowledger{
+		URL:          url,
+		PollInterval: 10 * time.Second,
+		Timeout:      timeout,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// WaitForAck implements PreviewAcknowledger
+func (a *HTTPAcknowledger) WaitForAck(ctx context.Context, preview *ChangePreview) error {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	target, err := url.Parse(a.URL)
+	if err != nil {
+		return fmt.Errorf("invalid acknowledgment URL: %w", err)
+	}
+	query := target.Query()
+	query.Set("id", preview.ID)
+	target.RawQuery = query.Encode()
+
+	for {
+		status, err := a.poll(ctx, target.String())
+		if err != nil {
+			return err
+		}
+
+		switch status {
+		case "approved":
+			return nil
+		case "rejected":
+			return fmt.Errorf("change preview %s was rejected", preview.ID)
+		}
+
+		if err := sleepContext(ctx, a.PollInterval); err != nil {
+			return fmt.Errorf("timed out waiting for approval of change preview %s: %w", preview.ID, err)
+		}
+	}
+}
+
+func (a *HTTPAcknowledger) poll(ctx context.Context, target string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to poll acknowledgment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("acknowledgment endpoint returned %s", resp.Status)
+	}
+
+	var ack struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return "", fmt.Errorf("invalid acknowledgment response: %w", err)
+	}
+	return strings.ToLower(ack.Status), nil
+}
+
+// PreviewOptions configures ExecuteWithPreview
+type PreviewOptions struct {
+	Name         string
+	Publisher    PreviewPublisher
+	Acknowledger PreviewAcknowledger // Optional approval gate
+	SkipIfNoop   bool                // Skip review when nothing would change
+}
+
+// ExecuteWithPreview runs the playbook in check mode, publishes the planned
+// changes and, once acknowledged when a gate is configured, runs it for real
+func (e *Executor) ExecuteWithPreview(ctx context.Context, playbook *types.Playbook, extraVars map[string]interface{}, opts PreviewOptions) ([]types.Result, *ChangePreview, error) {
+	checkVars := make(map[string]interface{})
+	for k, v := range extraVars {
+		checkVars[k] = v
+	}
+	checkVars["ansible_check_mode"] = true
+
+	// Only the real run is reported to the callback plugins
+	callbacks := e.callbacks
+	e.callbacks = nil
+	checkResults, err := e.Execute(ctx, playbook, checkVars)
+	e.callbacks = callbacks
+	if err != nil {
+		return nil, nil, fmt.Errorf("check mode run for change preview failed: %w", err)
+	}
+
+	preview := BuildChangePreview(opts.Name, checkResults)
+	review := preview.HasChanges() || preview.Summary.Failures > 0 || !opts.SkipIfNoop
+
+	if review && opts.Publisher != nil {
+		if err := opts.Publisher.Publish(ctx, preview); err != nil {
+			return nil, preview, err
+		}
+	}
+
+	if review && opts.Acknowledger != nil {
+		if err := opts.Acknowledger.WaitForAck(ctx, preview); err != nil {
+			return nil, preview, err
+		}
+	}
+
+	results, err := e.Execute(ctx, playbook, extraVars)
+	return results, preview, err
+}
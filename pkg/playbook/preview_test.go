@@ -0,0 +1,231 @@
+package playbook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestBuildChangePreview(t *testing.T) {
+	results := []types.Result{
+		{Host: "web1", TaskName: "config", ModuleName: "template", Success: true, Changed: true,
+			Diff: &types.DiffResult{Before: "port=80", After: "port=8080", Prepared: true}},
+		{Host: "web1", TaskName: "ping", ModuleName: "ping", Success: true},
+		{Host: "web2", TaskName: "config", ModuleName: "template", Success: true, Changed: true,
+			Diff: &types.DiffResult{Diff: "-port=80\n+port=8080\n"}},
+		{Host: "web3", TaskName: "config", ModuleName: "template", Error: errors.New("template missing")},
+	}
+
+	preview := BuildChangePreview("site.yml", results)
+
+	if preview.Summary.Changes != 2 || preview.Summary.HostsChanged != 2 || preview.Summary.Failures != 1 {
+		t.Errorf("unexpected summary: %+v", preview.Summary)
+	}
+	if !preview.HasChanges() {
+		t.Error("expected preview to have changes")
+	}
+	if diff := preview.Hosts["web1"][0].Diff; !strings.Contains(diff, "+++ after\nport=8080") {
+		t.Errorf("expected before/after diff, got %q", diff)
+	}
+	if diff := preview.Hosts["web2"][0].Diff; diff != "-port=80\n+port=8080\n" {
+		t.Errorf("expected unified diff to be kept, got %q", diff)
+	}
+	if msg := preview.Failures["web3"][0].Message; msg != "template missing" {
+		t.Errorf("expected failure message, got %q", msg)
+	}
+
+	text := preview.Text()
+	for _, want := range []string{"site.yml: 2 change(s) on 2 host(s)", "~ config (template)", "! config: template missing"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, text)
+		}
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{
+			format: PreviewFormatJSON,
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["playbook"] != "site.yml" {
+					t.Errorf("expected playbook field, got %v", body)
+				}
+			},
+		},
+		{
+			format: PreviewFormatSlack,
+			check: func(t *testing.T, body map[string]interface{}) {
+				text, _ := body["text"].(string)
+				if !strings.Contains(text, "Planned changes for site.yml") {
+					t.Errorf("expected slack text, got %v", body)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var body map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+			}))
+			defer server.Close()
+
+			publisher := NewWebhookPublisher(server.URL, tt.format)
+			publisher.Headers["Authorization"] = "Bearer token"
+
+			if err := publisher.Publish(context.Background(), BuildChangePreview("site.yml", nil)); err != nil {
+				t.Fatalf("Publish failed: %v", err)
+			}
+			tt.check(t, body)
+		})
+	}
+
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid_token", http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		err := NewWebhookPublisher(server.URL, "").Publish(context.Background(), BuildChangePreview("", nil))
+		if err == nil || !strings.Contains(err.Error(), "invalid_token") {
+			t.Errorf("expected webhook error with body, got %v", err)
+		}
+	})
+}
+
+func TestHTTPAcknowledger(t *testing.T) {
+	newServer := func(statuses ...string) (*httptest.Server, *int) {
+		var mu sync.Mutex
+		polls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			status := statuses[len(statuses)-1]
+			if polls < len(statuses) {
+				status = statuses[polls]
+			}
+			polls++
+			if r.URL.Query().Get("id") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"status": status})
+		}))
+		return server, &polls
+	}
+
+	t.Run("approved after pending", func(t *testing.T) {
+		server, polls := newServer("pending", "approved")
+		defer server.Close()
+
+		ack := NewHTTPAcknowledger(server.URL, time.Second)
+		ack.PollInterval = time.Millisecond
+		if err := ack.WaitForAck(context.Background(), BuildChangePreview("", nil)); err != nil {
+			t.Fatalf("expected approval, got %v", err)
+		}
+		if *polls != 2 {
+			t.Errorf("expected 2 polls, got %d", *polls)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		server, _ := newServer("rejected")
+		defer server.Close()
+
+		err := NewHTTPAcknowledger(server.URL, time.Second).WaitForAck(context.Background(), BuildChangePreview("", nil))
+		if err == nil || !strings.Contains(err.Error(), "rejected") {
+			t.Errorf("expected rejection, got %v", err)
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		server, _ := newServer("pending")
+		defer server.Close()
+
+		ack := NewHTTPAcknowledger(server.URL, 20*time.Millisecond)
+		ack.PollInterval = 5 * time.Millisecond
+		if err := ack.WaitForAck(context.Background(), BuildChangePreview("", nil)); err == nil {
+			t.Error("expected timeout error")
+		}
+	})
+}
+
+type stubPublisher struct{ published []*ChangePreview }
+
+func (s *stubPublisher) Publish(ctx context.Context, preview *ChangePreview) error {
+	s.published = append(s.published, preview)
+	return nil
+}
+
+type stubAcknowledger struct{ err error }
+
+func (s stubAcknowledger) WaitForAck(ctx context.Context, preview *ChangePreview) error {
+	return s.err
+}
+
+func TestExecutorExecuteWithPreview(t *testing.T) {
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "deploy",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{debugTask("configure")},
+	}}}
+
+	t.Run("runs for real after approval", func(t *testing.T) {
+		runner := newRecordingRunner()
+		publisher := &stubPublisher{}
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+		_, preview, err := executor.ExecuteWithPreview(context.Background(), playbook, nil, PreviewOptions{
+			Publisher:    publisher,
+			Acknowledger: stubAcknowledger{},
+		})
+		if err != nil {
+			t.Fatalf("ExecuteWithPreview failed: %v", err)
+		}
+		if len(publisher.published) != 1 || publisher.published[0] != preview {
+			t.Errorf("expected the preview to be published once")
+		}
+
+		if len(runner.calls) != 2 {
+			t.Fatalf("expected a check run and a real run, got %d calls", len(runner.calls))
+		}
+		if runner.calls[0].Vars["ansible_check_mode"] != true {
+			t.Error("expected first run to be in check mode")
+		}
+		if _, ok := runner.calls[1].Vars["ansible_check_mode"]; ok {
+			t.Error("expected real run without check mode")
+		}
+	})
+
+	t.Run("rejection stops the run", func(t *testing.T) {
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+		_, _, err := executor.ExecuteWithPreview(context.Background(), playbook, nil, PreviewOptions{
+			Acknowledger: stubAcknowledger{err: errors.New("rejected")},
+		})
+		if err == nil {
+			t.Fatal("expected rejection error")
+		}
+		if len(runner.calls) != 1 {
+			t.Errorf("expected only the check run, got %d calls", len(runner.calls))
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package playbook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Reconcile round triggers other than events
+const (
+	TriggerStartup  = "startup"
+	TriggerInterval = "interval"
+)
+
+// ReconcileOptions configures Reconcile
+type ReconcileOptions struct {
+	Name        string
+	Interval    time.Duration // Time between rounds
+	MaxPasses   int           // Runs per round while hosts keep changing, 3 when 0
+	DetectDrift bool          // Start rounds with a check mode run and only apply when it finds drift
+	Rounds      int           // Stop after this many rounds, 0 to run until the context is done
+
+	// Triggers start a round before the interval elapses, e.g. on
+	// inventory or fact changes; the received value names the event
+	Triggers <-chan string
+
+	// Reload re-reads the playbook and inventory before each round, picking
+	// up changes pulled into the working copy. A nil playbook or inventory
+	// keeps the current one.
+	Reload func() (*types.Playbook, types.Inventory, error)
+
+	Publisher PreviewPublisher      // Told about rounds that changed or failed hosts, never about quiet ones
+	OnRound   func(*ReconcileRound) // Called after every round
+}
+
+// ReconcileRound reports one round of a reconcile loop
+type ReconcileRound struct {
+	Number    int
+	Trigger   string
+	Started   time.Time
+	Duration  time.Duration
+	Passes    int            // Real runs of the playbook
+	Drift     bool           // Hosts had drifted from the playbook when the round started
+	Converged bool           // The last run changed no host
+	Changes   *ChangePreview // Changes made and failures met by the round's runs
+	Err       error
+}
+
+// Reconcile applies a playbook in rounds until the context is done: one
+// round at startup, then one per interval or trigger. Within a round the
+// playbook is run again while it keeps changing hosts, up to MaxPasses
+// runs, so changes enabling further changes converge in the same round.
+//
+// With DetectDrift a round first runs the playbook in check mode, without
+// reporting to the callback plugins, and leaves converged hosts alone, so
+// quiet rounds produce no output at all. Tasks whose module cannot run in
+// check mode always make the round apply the playbook. Run errors are reported in the
+// round and do not stop the loop; only a failing Reload does.
+func (e *Executor) Reconcile(ctx context.Context, playbook *types.Playbook, extraVars map[string]interface{}, opts ReconcileOptions) error {
+	if opts.MaxPasses <= 0 {
+		opts.MaxPasses = 3
+	}
+
+	trigger := TriggerStartup
+	for number := 1; ; number++ {
+		if opts.Reload != nil {
+			reloaded, inventory, err := opts.Reload()
+			if err != nil {
+				return fmt.Errorf("failed to reload for reconcile round %d: %w", number, err)
+			}
+			if reloaded != nil {
+				playbook = reloaded
+			}
+			if inventory != nil {
+				e.inventory = inventory
+			}
+		}
+
+		round := e.reconcileRound(ctx, playbook, extraVars, opts)
+		round.Number = number
+		round.Trigger = trigger
+
+		if opts.Publisher != nil && (round.Changes.HasChanges() || round.Changes.Summary.Failures > 0) {
+			if err := opts.Publisher.Publish(ctx, round.Changes); err != nil && round.Err == nil {
+				round.Err = err
+			}
+		}
+		if opts.OnRound != nil {
+			opts.OnRound(round)
+		}
+
+		if opts.Rounds > 0 && number >= opts.Rounds {
+			return nil
+		}
+		var ok bool
+		if trigger, ok = e.waitForRound(ctx, opts); !ok {
+			return nil
+		}
+	}
+}
+
+// reconcileRound runs the playbook until it converges or runs out of
+// passes
+func (e *Executor) reconcileRound(ctx context.Context, playbook *types.Playbook, extraVars map[string]interface{}, opts ReconcileOptions) *ReconcileRound {
+	round := &ReconcileRound{Started: e.clock()}
+	defer func() { round.Duration = e.clock().Sub(round.Started) }()
+
+	if opts.DetectDrift {
+		checkVars := make(map[string]interface{}, len(extraVars)+1)
+		for k, v := range extraVars {
+			checkVars[k] = v
+		}
+		checkVars["ansible_check_mode"] = true
+
+		callbacks := e.callbacks
+		e.callbacks = nil
+		results, err := e.Execute(ctx, playbook, checkVars)
+		e.callbacks = callbacks
+
+		drift := BuildChangePreview(opts.Name, results)
+		if err != nil || !drift.HasChanges() && !uncheckable(results) {
+			round.Changes = drift
+			round.Converged = err == nil && drift.Summary.Failures == 0
+			round.Err = err
+			return round
+		}
+	}
+
+	var applied []types.Result
+	for round.Passes < opts.MaxPasses {
+		round.Passes++
+		results, err := e.Execute(ctx, playbook, extraVars)
+		applied = append(applied, results...)
+		if err != nil {
+			round.Err = err
+			break
+		}
+
+		pass := BuildChangePreview(opts.Name, results)
+		if round.Passes == 1 {
+			round.Drift = pass.HasChanges()
+		}
+		if !pass.HasChanges() {
+			round.Converged = pass.Summary.Failures == 0
+			break
+		}
+	}
+	if opts.DetectDrift {
+		round.Drift = true
+	}
+	round.Changes = BuildChangePreview(opts.Name, applied)
+	return round
+}
+
+// uncheckable reports whether a check mode run skipped tasks whose module
+// cannot run in check mode, which leaves their drift unknown
+func uncheckable(results []types.Result) bool {
+	for _, result := range results {
+		if result.Data["reason"] == "module_no_check_support" {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForRound blocks until the next round is due, returning its trigger,
+// or false once the context is done
+func (e *Executor) waitForRound(ctx context.Context, opts ReconcileOptions) (string, bool) {
+	var timer <-chan time.Time
+	if opts.Interval > 0 {
+		t := time.NewTimer(opts.Interval)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", false
+	case <-timer:
+		return TriggerInterval, true
+	case event, ok := <-opts.Triggers:
+		if !ok {
+			// Without triggers, only the interval starts rounds
+			opts.Triggers = nil
+			return e.waitForRound(ctx, opts)
+		}
+		// Events arriving together start a single round
+	drain:
+		for {
+			select {
+			case _, open := <-opts.Triggers:
+				if !open {
+					break drain
+				}
+			default:
+				break drain
+			}
+		}
+		return event, true
+	}
+}
+
+// WatchFiles sends the path of a file on the returned channel whenever its
+// modification time or size changes, checking every interval until the
+// context is done. Files that do not exist yet are reported once created.
+func WatchFiles(ctx context.Context, interval time.Duration, paths ...string) <-chan string {
+	type stamp struct {
+		modTime time.Time
+		size    int64
+	}
+	read := func(path string) stamp {
+		info, err := os.Stat(path)
+		if err != nil {
+			return stamp{}
+		}
+		return stamp{info.ModTime(), info.Size()}
+	}
+
+	events := make(chan string, len(paths))
+	stamps := make(map[string]stamp, len(paths))
+	for _, path := range paths {
+		stamps[path] = read(path)
+	}
+
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, path := range paths {
+				current := read(path)
+				if current == stamps[path] {
+					continue
+				}
+				stamps[path] = current
+				select {
+				case events <- "changed: " + path:
+				default:
+				}
+			}
+		}
+	}()
+	return events
+}
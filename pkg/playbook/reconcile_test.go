@@ -0,0 +1,164 @@
+package playbook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestExecutorReconcile(t *testing.T) {
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "enforce",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{debugTask("configure"), debugTask("restart")},
+	}}}
+
+	t.Run("runs again until converged", func(t *testing.T) {
+		runner := newRecordingRunner()
+		runner.changeOn["configure"] = map[string]bool{"web1": true}
+		// The second run finds the host corrected
+		runner.onRun = func(task types.Task) {
+			if task.Name == "restart" {
+				runner.changeOn["configure"] = nil
+			}
+		}
+		publisher := &stubPublisher{}
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+		var rounds []*ReconcileRound
+		err := executor.Reconcile(context.Background(), playbook, nil, ReconcileOptions{
+			Rounds:    2,
+			Interval:  time.Millisecond,
+			Publisher: publisher,
+			OnRound:   func(round *ReconcileRound) { rounds = append(rounds, round) },
+		})
+		if err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+
+		if len(rounds) != 2 {
+			t.Fatalf("expected 2 rounds, got %d", len(rounds))
+		}
+		first, second := rounds[0], rounds[1]
+		if first.Trigger != TriggerStartup || first.Passes != 2 || !first.Drift || !first.Converged || first.Changes.Summary.Changes != 1 {
+			t.Errorf("unexpected first round %+v", first)
+		}
+		if second.Trigger != TriggerInterval || second.Passes != 1 || second.Drift || !second.Converged {
+			t.Errorf("unexpected second round %+v", second)
+		}
+		if len(publisher.published) != 1 || publisher.published[0] != first.Changes {
+			t.Errorf("expected only the round that changed hosts to be published, got %d", len(publisher.published))
+		}
+	})
+
+	t.Run("gives up after max passes", func(t *testing.T) {
+		runner := newRecordingRunner()
+		runner.changeOn["configure"] = map[string]bool{"web1": true}
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+
+		var round *ReconcileRound
+		err := executor.Reconcile(context.Background(), playbook, nil, ReconcileOptions{
+			Rounds:    1,
+			MaxPasses: 2,
+			OnRound:   func(r *ReconcileRound) { round = r },
+		})
+		if err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+		if round.Passes != 2 || round.Converged || round.Changes.Summary.Changes != 2 {
+			t.Errorf("expected an unconverged round of 2 passes, got %+v", round)
+		}
+	})
+
+	t.Run("drift detection leaves converged hosts alone", func(t *testing.T) {
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+		triggers := make(chan string, 2)
+		triggers <- "changed: inventory.yml"
+		triggers <- "changed: site.yml"
+
+		var rounds []*ReconcileRound
+		reloads := 0
+		err := executor.Reconcile(context.Background(), playbook, nil, ReconcileOptions{
+			Rounds:      2,
+			DetectDrift: true,
+			Triggers:    triggers,
+			Reload: func() (*types.Playbook, types.Inventory, error) {
+				reloads++
+				return nil, nil, nil
+			},
+			OnRound: func(round *ReconcileRound) { rounds = append(rounds, round) },
+		})
+		if err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+
+		if reloads != 2 {
+			t.Errorf("expected a reload per round, got %d", reloads)
+		}
+		if rounds[1].Trigger != "changed: inventory.yml" {
+			t.Errorf("expected the second round to be triggered by the event, got %q", rounds[1].Trigger)
+		}
+		if len(triggers) != 0 {
+			t.Error("expected events arriving together to start a single round")
+		}
+		for _, round := range rounds {
+			if round.Passes != 0 || round.Drift || !round.Converged {
+				t.Errorf("expected no real run without drift, got %+v", round)
+			}
+		}
+		for _, call := range runner.calls {
+			if call.Vars["ansible_check_mode"] != true {
+				t.Errorf("expected only check mode runs, got a real run of %s", call.Task)
+			}
+		}
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		executor := NewExecutor(newRecordingRunner(), newTestInventory(t, "web1"), nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		rounds := 0
+		err := executor.Reconcile(ctx, playbook, nil, ReconcileOptions{
+			Interval: time.Hour,
+			OnRound: func(round *ReconcileRound) {
+				rounds++
+				cancel()
+			},
+		})
+		if err != nil || rounds != 1 {
+			t.Errorf("expected one round and a clean stop, got %d rounds and %v", rounds, err)
+		}
+	})
+}
+
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.yml")
+	if err := os.WriteFile(path, []byte("all: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := WatchFiles(ctx, 5*time.Millisecond, path)
+
+	if err := os.WriteFile(path, []byte("all:\n  hosts: {web1: {}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event != "changed: "+path {
+			t.Errorf("unexpected event %q", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a change event")
+	}
+
+	cancel()
+	for range events {
+	}
+}
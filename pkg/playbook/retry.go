@@ -0,0 +1,58 @@
+package playbook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// RetryFilePath returns where the retry file of a playbook is written:
+// named after the playbook with a .retry extension, in dir when set and
+// next to the playbook otherwise
+func RetryFilePath(playbookFile, dir string) string {
+	base := filepath.Base(playbookFile)
+	name := strings.TrimSuffix(base, filepath.Ext(base)) + ".retry"
+	if dir == "" {
+		dir = filepath.Dir(playbookFile)
+	}
+	return filepath.Join(dir, name)
+}
+
+// FailedHosts returns the names of the hosts that failed a task or were
+// unreachable, in name order
+func FailedHosts(results []types.Result) []string {
+	failed := make(map[string]bool)
+	for i := range results {
+		skipped, _ := results[i].Data["skipped"].(bool)
+		if !results[i].Success && !skipped {
+			failed[results[i].Host] = true
+		}
+	}
+
+	hosts := make([]string, 0, len(failed))
+	for host := range failed {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// WriteRetryFile writes the hosts to a retry file, one per line, so the
+// run can be repeated on them with a limit of "@" and the file's path
+func WriteRetryFile(path string, hosts []string) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create retry file directory: %w", err)
+		}
+	}
+
+	content := strings.Join(hosts, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write retry file: %w", err)
+	}
+	return nil
+}
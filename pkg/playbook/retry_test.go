@@ -0,0 +1,75 @@
+package playbook
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestRetryFilePath(t *testing.T) {
+	if path := RetryFilePath("deploy/site.yml", ""); path != filepath.Join("deploy", "site.retry") {
+		t.Errorf("expected the retry file next to the playbook, got %s", path)
+	}
+	if path := RetryFilePath("deploy/site.yml", "/var/retry"); path != filepath.Join("/var/retry", "site.retry") {
+		t.Errorf("expected the retry file in the save path, got %s", path)
+	}
+}
+
+func TestFailedHosts(t *testing.T) {
+	results := []types.Result{
+		{Host: "web2", Success: false, Error: errors.New("failed")},
+		{Host: "web1", Success: true},
+		{Host: "db1", Success: false, Data: map[string]interface{}{"unreachable": true}},
+		{Host: "db2", Success: false, Data: map[string]interface{}{"skipped": true}},
+		{Host: "web2", Success: false, Error: errors.New("failed again")},
+	}
+
+	if hosts := FailedHosts(results); !reflect.DeepEqual(hosts, []string{"db1", "web2"}) {
+		t.Errorf("expected the failed and unreachable hosts, got %v", hosts)
+	}
+	if hosts := FailedHosts(nil); len(hosts) != 0 {
+		t.Errorf("expected no failed hosts, got %v", hosts)
+	}
+}
+
+func TestWriteRetryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry", "site.retry")
+	if err := WriteRetryFile(path, []string{"db1", "web2"}); err != nil {
+		t.Fatalf("WriteRetryFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read retry file: %v", err)
+	}
+	if string(data) != "db1\nweb2\n" {
+		t.Errorf("unexpected retry file content %q", data)
+	}
+}
+
+func TestExecutorFailedRunKeepsResults(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.failOn["deploy"] = map[string]bool{"web2": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	playbook := &types.Playbook{Plays: []types.Play{{
+		Name:  "web",
+		Hosts: "web1,web2",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Tasks: []types.Task{debugTask("deploy"), debugTask("verify")},
+	}}}
+	results, err := executor.Execute(context.Background(), playbook, nil)
+	if err == nil {
+		t.Fatal("expected the failed task to fail the playbook")
+	}
+
+	// The results of the failed play are returned for the retry file
+	if hosts := FailedHosts(results); !reflect.DeepEqual(hosts, []string{"web2"}) {
+		t.Errorf("expected web2 to have failed, got %v from %d results", hosts, len(results))
+	}
+}
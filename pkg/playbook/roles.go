@@ -0,0 +1,167 @@
+package playbook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/roles"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// roleExpansion collects the tasks and handlers of a play's roles, in the
+// order Ansible runs them: each role's dependencies before the role itself
+type roleExpansion struct {
+	manager  *roles.RoleManager
+	playVars map[string]interface{}
+	tasks    []types.Task
+	handlers []types.Task
+	applied  map[string]bool // Role instances already expanded, by name and parameters
+	stack    []string        // Roles being expanded, to report dependency cycles
+}
+
+// SetRolesPath sets the directories searched for roles, such as the roles
+// directory next to the playbook
+func (e *Executor) SetRolesPath(paths ...string) {
+	e.roles = roles.NewRoleManager(paths)
+	e.roles.SetVaultManager(e.vaults)
+}
+
+// expandRoles returns a copy of the play whose tasks start with those of its
+// roles and whose handlers include the roles' handlers
+func (e *Executor) expandRoles(play *types.Play, playVars map[string]interface{}) (*types.Play, error) {
+	x := &roleExpansion{
+		manager:  e.roles,
+		playVars: playVars,
+		applied:  make(map[string]bool),
+	}
+	for _, ref := range play.Roles {
+		if err := x.apply(ref.Role, ref.Vars, ref.When, ref.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	expanded := *play
+	expanded.Tasks = append(x.tasks, play.Tasks...)
+	expanded.Handlers = append(append([]types.Task{}, play.Handlers...), x.handlers...)
+	return &expanded, nil
+}
+
+// apply expands a role after its dependencies. Like Ansible, a role applied
+// again with the same parameters only runs once per play.
+func (x *roleExpansion) apply(name string, params map[string]interface{}, when interface{}, tags []string) error {
+	for i, active := range x.stack {
+		if active == name {
+			return fmt.Errorf("circular role dependency: %s -> %s", strings.Join(x.stack[i:], " -> "), name)
+		}
+	}
+
+	key := name + fmt.Sprint(params)
+	if x.applied[key] {
+		return nil
+	}
+
+	role, err := x.manager.LoadRole(name)
+	if err != nil {
+		return err
+	}
+
+	x.stack = append(x.stack, name)
+	for _, dep := range role.Dependencies {
+		if err := x.apply(dep.Role, dep.Vars, joinConditions(when, dep.When), append(append([]string{}, tags...), dep.Tags...)); err != nil {
+			return fmt.Errorf("dependency of role '%s': %w", name, err)
+		}
+	}
+	x.stack = x.stack[:len(x.stack)-1]
+	x.applied[key] = true
+
+	vars := x.roleVars(role, params)
+	for _, task := range role.Tasks {
+		x.tasks = append(x.tasks, scopeRoleTask(task, role, vars, params, when, tags))
+	}
+	for _, handler := range role.Handlers {
+		x.handlers = append(x.handlers, scopeRoleTask(handler, role, vars, params, nil, nil))
+	}
+	return nil
+}
+
+// roleVars returns the variables a role's tasks see on top of the play
+// vars. Defaults only fill in variables the play leaves unset, while role
+// vars override play vars.
+func (x *roleExpansion) roleVars(role *roles.Role, params map[string]interface{}) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for k, v := range role.Defaults {
+		if _, set := x.playVars[k]; !set {
+			vars[k] = v
+		}
+	}
+	vars = types.DeepMergeInterfaceMaps(vars, role.Vars)
+	vars["role_name"] = role.Name
+	vars["role_path"] = role.Path
+	return vars
+}
+
+// scopeRoleTask applies a role's variables, condition and tags to one of its
+// tasks. Task vars override role vars, and role parameters override both.
+func scopeRoleTask(task types.Task, role *roles.Role, vars, params map[string]interface{}, when interface{}, tags []string) types.Task {
+	scoped := types.DeepMergeInterfaceMaps(map[string]interface{}{}, vars)
+	if task.Vars != nil {
+		scoped = types.DeepMergeInterfaceMaps(scoped, task.Vars)
+	}
+	if params != nil {
+		scoped = types.DeepMergeInterfaceMaps(scoped, params)
+	}
+	task.Vars = scoped
+
+	task.When = joinConditions(when, task.When)
+	if len(tags) > 0 {
+		task.Tags = append(append([]string{}, tags...), task.Tags...)
+	}
+
+	// Relative sources come from the role's files and templates directories,
+	// and included task files from its tasks directory
+	key, dir := "src", "files"
+	switch {
+	case task.Module == types.TypeTemplate:
+		dir = "templates"
+	case isTaskInclude(&task):
+		key, dir = "file", "tasks"
+	}
+	if src, ok := task.Args[key].(string); ok && src != "" && !filepath.IsAbs(src) {
+		if path := filepath.Join(role.Path, dir, src); fileExists(path) {
+			args := make(map[string]interface{}, len(task.Args))
+			for k, v := range task.Args {
+				args[k] = v
+			}
+			args[key] = path
+			task.Args = args
+		}
+	}
+	return task
+}
+
+// joinConditions combines two when conditions, which must both hold
+func joinConditions(outer, inner interface{}) interface{} {
+	if outer == nil {
+		return inner
+	}
+	if inner == nil {
+		return outer
+	}
+
+	var conditions []interface{}
+	for _, c := range []interface{}{outer, inner} {
+		if list, ok := c.([]interface{}); ok {
+			conditions = append(conditions, list...)
+		} else {
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,157 @@
+package playbook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// writeRoleFiles creates files under dir from a map of relative paths
+func writeRoleFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func TestExecutorRoles(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"common/tasks/main.yml":      "- name: common setup\n  debug:\n    msg: common\n",
+		"web/meta/main.yml":          "dependencies:\n  - common\n",
+		"web/defaults/main.yml":      "port: 80\nworkers: 2\n",
+		"web/vars/main.yml":          "user: www-data\n",
+		"web/tasks/main.yml":         "- name: configure web\n  template:\n    src: site.conf.j2\n    dest: /etc/nginx/site.conf\n  notify: restart web\n",
+		"web/handlers/main.yml":      "- name: restart web\n  debug:\n    msg: restart\n",
+		"web/templates/site.conf.j2": "listen {{ port }};\n",
+	})
+
+	runner := newRecordingRunner()
+	runner.changeOn["configure web"] = map[string]bool{"web1": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+	executor.SetRolesPath(dir)
+
+	var play types.Play
+	playYAML := `
+name: web
+hosts: web1
+vars:
+  gather_facts: false
+  port: 8080
+  user: nobody
+roles:
+  - common
+  - role: web
+    workers: 4
+tasks:
+  - name: verify
+    debug:
+      msg: verify
+`
+	if err := yaml.Unmarshal([]byte(playYAML), &play); err != nil {
+		t.Fatalf("failed to parse play: %v", err)
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), &play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	// common runs once even though web depends on it too, and the role
+	// handler runs after the play's tasks
+	expected := []string{"common setup", "configure web", "verify", "restart web"}
+	if got := runner.taskNames(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	vars := runner.calls[1].Vars
+	for key, want := range map[string]interface{}{
+		"port":      8080,       // play vars beat role defaults
+		"user":      "www-data", // role vars beat play vars
+		"workers":   4,          // role parameters beat role defaults
+		"role_name": "web",
+	} {
+		if vars[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, vars[key])
+		}
+	}
+}
+
+func TestScopeRoleTaskResolvesSources(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"app/templates/app.conf.j2": "",
+		"app/files/motd":            "",
+	})
+
+	executor := NewExecutor(newRecordingRunner(), newTestInventory(t, "web1"), nil)
+	executor.SetRolesPath(dir)
+	role, err := executor.roles.LoadRole("app")
+	if err != nil {
+		t.Fatalf("failed to load role: %v", err)
+	}
+
+	template := scopeRoleTask(types.Task{Module: types.TypeTemplate, Args: map[string]interface{}{"src": "app.conf.j2"}}, role, nil, nil, nil, nil)
+	if src := template.Args["src"]; src != filepath.Join(dir, "app", "templates", "app.conf.j2") {
+		t.Errorf("expected the template to resolve in the role, got %v", src)
+	}
+	copyTask := scopeRoleTask(types.Task{Module: types.TypeCopy, Args: map[string]interface{}{"src": "motd"}}, role, nil, nil, "enabled", []string{"app"})
+	if src := copyTask.Args["src"]; src != filepath.Join(dir, "app", "files", "motd") {
+		t.Errorf("expected the file to resolve in the role, got %v", src)
+	}
+	if copyTask.When != "enabled" || !reflect.DeepEqual(copyTask.Tags, []string{"app"}) {
+		t.Errorf("expected the role condition and tags, got %v %v", copyTask.When, copyTask.Tags)
+	}
+}
+
+func TestExecutorRoleDependencyCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"a/meta/main.yml": "dependencies:\n  - role: b\n",
+		"b/meta/main.yml": "dependencies:\n  - a\n",
+	})
+
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+	executor.SetRolesPath(dir)
+
+	play := &types.Play{
+		Name:  "cycle",
+		Hosts: "web1",
+		Vars:  map[string]interface{}{"gather_facts": false},
+		Roles: []types.RoleReference{{Role: "a"}},
+	}
+
+	_, err := executor.ExecutePlay(context.Background(), play, nil)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Fatalf("expected a dependency cycle error, got %v", err)
+	}
+}
+
+func TestParseRoleReferences(t *testing.T) {
+	var play types.Play
+	playYAML := "name: p\nhosts: all\nroles:\n  - common\n  - role: web\n    vars:\n      port: 80\n    when: web_enabled\n    tags: web\n    workers: 4\n"
+	if err := yaml.Unmarshal([]byte(playYAML), &play); err != nil {
+		t.Fatalf("failed to parse play: %v", err)
+	}
+
+	expected := []types.RoleReference{
+		{Role: "common"},
+		{Role: "web", Vars: map[string]interface{}{"port": 80, "workers": 4}, When: "web_enabled", Tags: []string{"web"}},
+	}
+	if !reflect.DeepEqual(play.Roles, expected) {
+		t.Errorf("expected %+v, got %+v", expected, play.Roles)
+	}
+}
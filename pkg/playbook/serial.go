@@ -0,0 +1,244 @@
+package playbook
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// batchSizes resolves a play's serial keyword into batch sizes for total
+// hosts. Serial is a host count, a percentage such as "30%", or a list of
+// them; the last size repeats until every host has been placed.
+func batchSizes(serial interface{}, total int) ([]int, error) {
+	if serial == nil {
+		return []int{total}, nil
+	}
+	specs, err := serialSpecs(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	var sizes []int
+	placed := 0
+	for i := 0; placed < total; i++ {
+		size, err := batchSize(batchSpec(specs, i), total)
+		if err != nil {
+			return nil, err
+		}
+		if size > total-placed {
+			size = total - placed
+		}
+		sizes = append(sizes, size)
+		placed += size
+	}
+	return sizes, nil
+}
+
+// serialSpecs returns the batch sizes listed by a serial keyword
+func serialSpecs(serial interface{}) ([]interface{}, error) {
+	if list, ok := serial.([]interface{}); ok {
+		if len(list) == 0 {
+			return nil, fmt.Errorf("serial list cannot be empty")
+		}
+		return list, nil
+	}
+	return []interface{}{serial}, nil
+}
+
+// batchSpec returns the size spec of batch i; the last one repeats
+func batchSpec(specs []interface{}, i int) interface{} {
+	if i < len(specs) {
+		return specs[i]
+	}
+	return specs[len(specs)-1]
+}
+
+// batchSize resolves a single serial value. Percentages round down but a
+// batch always holds at least one host.
+func batchSize(spec interface{}, total int) (int, error) {
+	var size int
+	switch v := spec.(type) {
+	case int:
+		size = v
+	case float64:
+		size = int(v)
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasSuffix(s, "%") {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			if err != nil || pct <= 0 || pct > 100 {
+				return 0, fmt.Errorf("invalid serial percentage %q", v)
+			}
+			size = int(math.Floor(float64(total) * pct / 100))
+			if size < 1 {
+				size = 1
+			}
+			return size, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid serial value %q", v)
+		}
+		size = n
+	default:
+		return 0, fmt.Errorf("invalid serial value %v", spec)
+	}
+
+	if size < 1 {
+		return 0, fmt.Errorf("serial batch size must be positive, got %d", size)
+	}
+	return size, nil
+}
+
+// serialBatches splits the play hosts into rolling-update batches
+func serialBatches(serial interface{}, hosts []types.Host) ([][]types.Host, error) {
+	sizes, err := batchSizes(serial, len(hosts))
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make([][]types.Host, 0, len(sizes))
+	start := 0
+	for _, size := range sizes {
+		batches = append(batches, hosts[start:start+size])
+		start += size
+	}
+	return batches, nil
+}
+
+// domainBatches splits the play hosts into rolling-update batches that hold
+// at most maxHosts hosts of any one failure domain. Each batch takes the
+// earliest pending hosts that fit, so it may be smaller than its serial size
+// when one domain dominates the remaining hosts. Hosts without a domain are
+// not limited.
+func domainBatches(serial interface{}, hosts []types.Host, domainOf func(types.Host) string, maxHosts int) ([][]types.Host, error) {
+	var specs []interface{}
+	if serial != nil {
+		var err error
+		if specs, err = serialSpecs(serial); err != nil {
+			return nil, err
+		}
+	}
+	if maxHosts < 1 {
+		maxHosts = 1
+	}
+
+	var batches [][]types.Host
+	pending := hosts
+	for i := 0; len(pending) > 0; i++ {
+		limit := len(hosts)
+		if specs != nil {
+			size, err := batchSize(batchSpec(specs, i), len(hosts))
+			if err != nil {
+				return nil, err
+			}
+			limit = size
+		}
+
+		var batch, rest []types.Host
+		perDomain := make(map[string]int)
+		for _, host := range pending {
+			domain := domainOf(host)
+			if len(batch) < limit && (domain == "" || perDomain[domain] < maxHosts) {
+				batch = append(batch, host)
+				if domain != "" {
+					perDomain[domain]++
+				}
+			} else {
+				rest = append(rest, host)
+			}
+		}
+		batches = append(batches, batch)
+		pending = rest
+	}
+	return batches, nil
+}
+
+// playBatches splits the play hosts into batches according to its serial
+// and failure_domain keywords
+func (e *Executor) playBatches(play *types.Play, hosts []types.Host) ([][]types.Host, error) {
+	if play.FailureDomain == nil {
+		return serialBatches(play.Serial, hosts)
+	}
+	domainOf := func(host types.Host) string {
+		return e.failureDomain(host, play.FailureDomain.Var)
+	}
+	return domainBatches(play.Serial, hosts, domainOf, play.FailureDomain.MaxHosts)
+}
+
+// failureDomain returns the value of a host's domain variable, looking at
+// its inventory variables including those inherited from groups
+func (e *Executor) failureDomain(host types.Host, name string) string {
+	vars := host.Variables
+	if e.inventory != nil {
+		if hostVars, err := e.inventory.GetHostVars(host.Name); err == nil {
+			vars = hostVars
+		}
+	}
+	if value, ok := vars[name]; ok && value != nil {
+		return types.ConvertToString(value)
+	}
+	return ""
+}
+
+// batchFailures tracks the hosts that failed in the current batch so that
+// the play continues on the others until max_fail_percentage is exceeded
+type batchFailures struct {
+	mu     sync.Mutex // Hosts may fail concurrently in included task files
+	size   int
+	max    float64
+	failed map[string]bool
+}
+
+func newBatchFailures(size int, max float64) *batchFailures {
+	return &batchFailures{size: size, max: max, failed: make(map[string]bool)}
+}
+
+// record marks the hosts of failed results
+func (b *batchFailures) record(results []types.Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, result := range results {
+		if !result.Success && result.Host != "" {
+			b.failed[result.Host] = true
+		}
+	}
+}
+
+// exceeded reports whether the batch failed, either because too many hosts
+// failed or because none are left
+func (b *batchFailures) exceeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.failed) >= b.size {
+		return true
+	}
+	return float64(len(b.failed))*100/float64(b.size) > b.max
+}
+
+// err describes the batch failure
+func (b *batchFailures) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fmt.Errorf("%d of %d hosts in the batch failed, exceeding max_fail_percentage of %g%%", len(b.failed), b.size, b.max)
+}
+
+// active returns the hosts that have not failed
+func (b *batchFailures) active(hosts []types.Host) []types.Host {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.failed) == 0 {
+		return hosts
+	}
+	active := make([]types.Host, 0, len(hosts))
+	for _, host := range hosts {
+		if !b.failed[host.Name] {
+			active = append(active, host)
+		}
+	}
+	return active
+}
@@ -0,0 +1,256 @@
+package playbook
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBatchSizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		serial   interface{}
+		total    int
+		expected []int
+		wantErr  bool
+	}{
+		{name: "Unset", serial: nil, total: 5, expected: []int{5}},
+		{name: "Count", serial: 2, total: 5, expected: []int{2, 2, 1}},
+		{name: "CountString", serial: "3", total: 5, expected: []int{3, 2}},
+		{name: "LargerThanHosts", serial: 10, total: 4, expected: []int{4}},
+		{name: "Percentage", serial: "30%", total: 10, expected: []int{3, 3, 3, 1}},
+		{name: "PercentageAtLeastOne", serial: "10%", total: 3, expected: []int{1, 1, 1}},
+		{name: "List", serial: []interface{}{1, "30%", "100%"}, total: 10, expected: []int{1, 3, 6}},
+		{name: "ListLastRepeats", serial: []interface{}{1, 2}, total: 6, expected: []int{1, 2, 2, 1}},
+		{name: "Zero", serial: 0, total: 3, wantErr: true},
+		{name: "BadPercentage", serial: "150%", total: 3, wantErr: true},
+		{name: "EmptyList", serial: []interface{}{}, total: 3, wantErr: true},
+		{name: "WrongType", serial: true, total: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizes, err := batchSizes(tt.serial, tt.total)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", sizes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(sizes, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, sizes)
+			}
+		})
+	}
+}
+
+func TestExecutorSerialBatches(t *testing.T) {
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2", "web3", "web4"), nil)
+
+	play := &types.Play{
+		Name:   "rolling",
+		Hosts:  "web1,web2,web3,web4",
+		Serial: []interface{}{1, "50%"},
+		Vars:   map[string]interface{}{"gather_facts": false},
+		Tasks:  []types.Task{debugTask("drain"), debugTask("upgrade")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	var got []string
+	for _, call := range runner.calls {
+		got = append(got, call.Task+":"+strings.Join(call.Hosts, ","))
+	}
+	expected := []string{
+		"drain:web1", "upgrade:web1",
+		"drain:web2,web3", "upgrade:web2,web3",
+		"drain:web4", "upgrade:web4",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestExecutorSerialStopsOnFailure(t *testing.T) {
+	runner := newRecordingRunner()
+	runner.failOn["upgrade"] = map[string]bool{"web1": true}
+	executor := NewExecutor(runner, newTestInventory(t, "web1", "web2"), nil)
+
+	play := &types.Play{
+		Name:   "rolling",
+		Hosts:  "web1,web2",
+		Serial: 1,
+		Vars:   map[string]interface{}{"gather_facts": false},
+		Tasks:  []types.Task{debugTask("upgrade")},
+	}
+
+	_, err := executor.ExecutePlay(context.Background(), play, nil)
+	if err == nil || !strings.Contains(err.Error(), "batch 1 of 2") {
+		t.Fatalf("expected the first batch to fail, got %v", err)
+	}
+	if len(runner.calls) != 1 {
+		t.Errorf("expected later batches not to run, got %d calls", len(runner.calls))
+	}
+}
+
+func TestExecutorMaxFailPercentage(t *testing.T) {
+	maxFail := 30.0
+
+	t.Run("ToleratesFailures", func(t *testing.T) {
+		runner := newRecordingRunner()
+		runner.failOn["upgrade"] = map[string]bool{"web2": true}
+		executor := NewExecutor(runner, newTestInventory(t, "web1", "web2", "web3", "web4"), nil)
+
+		play := &types.Play{
+			Name:              "rolling",
+			Hosts:             "web1,web2,web3,web4",
+			MaxFailPercentage: &maxFail,
+			Vars:              map[string]interface{}{"gather_facts": false},
+			Tasks:             []types.Task{debugTask("upgrade"), debugTask("verify")},
+		}
+
+		if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+			t.Fatalf("expected 1 of 4 failures to be tolerated, got %v", err)
+		}
+
+		// The failed host takes no part in later tasks
+		verify := runner.calls[1]
+		if verify.Task != "verify" || strings.Join(verify.Hosts, ",") != "web1,web3,web4" {
+			t.Errorf("expected verify on the healthy hosts, got %+v", verify)
+		}
+	})
+
+	t.Run("AbortsWhenExceeded", func(t *testing.T) {
+		runner := newRecordingRunner()
+		runner.failOn["upgrade"] = map[string]bool{"web1": true, "web2": true}
+		executor := NewExecutor(runner, newTestInventory(t, "web1", "web2", "web3", "web4"), nil)
+
+		play := &types.Play{
+			Name:              "rolling",
+			Hosts:             "web1,web2,web3,web4",
+			Serial:            2,
+			MaxFailPercentage: &maxFail,
+			Vars:              map[string]interface{}{"gather_facts": false},
+			Tasks:             []types.Task{debugTask("upgrade")},
+		}
+
+		_, err := executor.ExecutePlay(context.Background(), play, nil)
+		if err == nil || !strings.Contains(err.Error(), "exceeding max_fail_percentage") {
+			t.Fatalf("expected max_fail_percentage error, got %v", err)
+		}
+		if len(runner.calls) != 1 {
+			t.Errorf("expected the second batch not to run, got %d calls", len(runner.calls))
+		}
+	})
+}
+
+func TestDomainBatches(t *testing.T) {
+	// Racks a and b hold three hosts each, db7 has no rack
+	racks := map[string]string{"db1": "a", "db2": "a", "db3": "a", "db4": "b", "db5": "b", "db6": "b"}
+	var hosts []types.Host
+	for _, name := range []string{"db1", "db2", "db3", "db4", "db5", "db6", "db7"} {
+		hosts = append(hosts, types.Host{Name: name})
+	}
+	domainOf := func(host types.Host) string { return racks[host.Name] }
+
+	tests := []struct {
+		name     string
+		serial   interface{}
+		maxHosts int
+		expected []string
+	}{
+		{name: "Unset", serial: nil, maxHosts: 1, expected: []string{"db1,db4,db7", "db2,db5", "db3,db6"}},
+		{name: "DefaultMax", serial: nil, maxHosts: 0, expected: []string{"db1,db4,db7", "db2,db5", "db3,db6"}},
+		{name: "TwoPerDomain", serial: nil, maxHosts: 2, expected: []string{"db1,db2,db4,db5,db7", "db3,db6"}},
+		{name: "SerialBelowDomains", serial: 2, maxHosts: 1, expected: []string{"db1,db4", "db2,db5", "db3,db6", "db7"}},
+		{name: "SerialList", serial: []interface{}{1, "100%"}, maxHosts: 1, expected: []string{"db1", "db2,db4,db7", "db3,db5", "db6"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches, err := domainBatches(tt.serial, hosts, domainOf, tt.maxHosts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, batch := range batches {
+				var names []string
+				for _, host := range batch {
+					names = append(names, host.Name)
+				}
+				got = append(got, strings.Join(names, ","))
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := domainBatches(0, hosts, domainOf, 1); err == nil {
+		t.Error("expected an invalid serial to be rejected")
+	}
+}
+
+func TestExecutorFailureDomain(t *testing.T) {
+	inv := newTestInventory(t)
+	for name, zone := range map[string]string{"etcd1": "us-east-1a", "etcd2": "us-east-1b", "etcd3": "us-east-1a", "etcd4": "us-east-1b"} {
+		if err := inv.AddHost(types.Host{Name: name, Address: name, Variables: map[string]interface{}{"zone": zone}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner := newRecordingRunner()
+	executor := NewExecutor(runner, inv, nil)
+
+	play := &types.Play{
+		Name:          "rolling",
+		Hosts:         "etcd1,etcd2,etcd3,etcd4",
+		Serial:        3,
+		FailureDomain: &types.FailureDomain{Var: "zone"},
+		Vars:          map[string]interface{}{"gather_facts": false},
+		Tasks:         []types.Task{debugTask("restart")},
+	}
+
+	if _, err := executor.ExecutePlay(context.Background(), play, nil); err != nil {
+		t.Fatalf("ExecutePlay failed: %v", err)
+	}
+
+	var got []string
+	for _, call := range runner.calls {
+		got = append(got, strings.Join(call.Hosts, ","))
+	}
+	expected := []string{"etcd1,etcd2", "etcd3,etcd4"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected one host per zone in each batch %v, got %v", expected, got)
+	}
+}
+
+func TestFailureDomainYAML(t *testing.T) {
+	var plays []types.Play
+	content := `
+- hosts: etcd
+  failure_domain: rack
+- hosts: kafka
+  failure_domain:
+    var: availability_zone
+    max_hosts: 2
+`
+	if err := yaml.Unmarshal([]byte(content), &plays); err != nil {
+		t.Fatalf("failed to parse plays: %v", err)
+	}
+	if plays[0].FailureDomain == nil || *plays[0].FailureDomain != (types.FailureDomain{Var: "rack"}) {
+		t.Errorf("expected the shorthand to name the variable, got %+v", plays[0].FailureDomain)
+	}
+	if plays[1].FailureDomain == nil || *plays[1].FailureDomain != (types.FailureDomain{Var: "availability_zone", MaxHosts: 2}) {
+		t.Errorf("expected the mapping form, got %+v", plays[1].FailureDomain)
+	}
+}
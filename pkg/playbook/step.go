@@ -0,0 +1,156 @@
+package playbook
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// StepAnswer is the operator's answer when asked whether to run a task
+type StepAnswer int
+
+const (
+	// StepNo skips the task
+	StepNo StepAnswer = iota
+	// StepYes runs the task
+	StepYes
+	// StepContinue runs the task and every remaining task without asking
+	StepContinue
+)
+
+// Stepper asks the operator whether to run each task, like Ansible's --step
+type Stepper interface {
+	ConfirmTask(ctx context.Context, task *types.Task) (StepAnswer, error)
+}
+
+// promptStepper asks on a terminal, or any reader and writer
+type promptStepper struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPromptStepper returns a Stepper asking "(N)o/(y)es/(c)ontinue" on out
+// and reading the answers from in. Anything but yes or continue skips the
+// task.
+func NewPromptStepper(in io.Reader, out io.Writer) Stepper {
+	return &promptStepper{in: bufio.NewReader(in), out: out}
+}
+
+// ConfirmTask prompts for the task and reads the answer
+func (p *promptStepper) ConfirmTask(ctx context.Context, task *types.Task) (StepAnswer, error) {
+	if err := ctx.Err(); err != nil {
+		return StepNo, err
+	}
+
+	fmt.Fprintf(p.out, "Perform task: TASK: %s (N)o/(y)es/(c)ontinue: ", task.Name)
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return StepNo, fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return StepYes, nil
+	case "c", "continue":
+		return StepContinue, nil
+	default:
+		return StepNo, nil
+	}
+}
+
+// stepControl resumes a playbook at a task and asks the operator before
+// each task when stepping. Every run of the playbook starts over.
+type stepControl struct {
+	mu      sync.Mutex
+	startAt string // Task to start at
+	stepper Stepper
+
+	// State of the current run
+	pending   bool // The start task is yet to be reached
+	continued bool // The operator answered continue
+}
+
+// SetStartAtTask skips the tasks before the first one named name, like
+// Ansible's --start-at-task. The name may use shell wildcards. Facts are
+// still gathered, and the playbook fails when no task matches.
+func (e *Executor) SetStartAtTask(name string) {
+	e.step.mu.Lock()
+	defer e.step.mu.Unlock()
+
+	e.step.startAt = name
+	e.step.pending = name != ""
+}
+
+// SetStepper asks stepper whether to run each task before running it,
+// until it answers StepContinue
+func (e *Executor) SetStepper(stepper Stepper) {
+	e.step.mu.Lock()
+	defer e.step.mu.Unlock()
+
+	e.step.stepper = stepper
+}
+
+// confirmTask reports whether a selected task runs: tasks before the one
+// the playbook starts at are skipped, and when stepping the operator
+// confirms each task
+func (e *Executor) confirmTask(ctx context.Context, task *types.Task) (bool, error) {
+	e.step.mu.Lock()
+	defer e.step.mu.Unlock()
+
+	if e.step.pending {
+		if !matchTaskName(e.step.startAt, task.Name) {
+			return false, nil
+		}
+		e.step.pending = false
+	}
+
+	if e.step.stepper == nil || e.step.continued {
+		return true, nil
+	}
+	answer, err := e.step.stepper.ConfirmTask(ctx, task)
+	if err != nil {
+		return false, fmt.Errorf("step: %w", err)
+	}
+	if answer == StepContinue {
+		e.step.continued = true
+	}
+	return answer != StepNo, nil
+}
+
+// beginSteps starts a run of the playbook from its start task, asking
+// before each task again when stepping
+func (e *Executor) beginSteps() {
+	e.step.mu.Lock()
+	defer e.step.mu.Unlock()
+
+	e.step.pending = e.step.startAt != ""
+	e.step.continued = false
+}
+
+// checkStarted fails when the run ended without reaching the task it was
+// to start at
+func (e *Executor) checkStarted() error {
+	e.step.mu.Lock()
+	defer e.step.mu.Unlock()
+
+	if e.step.pending {
+		return fmt.Errorf("no task matching %q found to start at", e.step.startAt)
+	}
+	return nil
+}
+
+// matchTaskName reports whether a task name matches a start-at-task name,
+// either exactly or as a shell pattern
+func matchTaskName(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
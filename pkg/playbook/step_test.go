@@ -0,0 +1,130 @@
+package playbook
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// scriptedStepper answers with answers in turn, recording the tasks asked
+type scriptedStepper struct {
+	answers []StepAnswer
+	asked   []string
+}
+
+func (s *scriptedStepper) ConfirmTask(ctx context.Context, task *types.Task) (StepAnswer, error) {
+	s.asked = append(s.asked, task.Name)
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	return answer, nil
+}
+
+func stepPlaybook() *types.Playbook {
+	return &types.Playbook{Plays: []types.Play{
+		{Name: "first", Hosts: "web1", Vars: map[string]interface{}{"gather_facts": false}, Tasks: []types.Task{debugTask("install"), debugTask("configure")}},
+		{Name: "second", Hosts: "web1", Vars: map[string]interface{}{"gather_facts": false}, Tasks: []types.Task{debugTask("restart"), debugTask("verify")}},
+	}}
+}
+
+func TestExecutorStartAtTask(t *testing.T) {
+	tests := []struct {
+		startAt  string
+		expected []string
+	}{
+		{"configure", []string{"configure", "restart", "verify"}},
+		{"rest*", []string{"restart", "verify"}},
+		{"verify", []string{"verify"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.startAt, func(t *testing.T) {
+			runner := newRecordingRunner()
+			executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+			executor.SetStartAtTask(tt.startAt)
+
+			if _, err := executor.Execute(context.Background(), stepPlaybook(), nil); err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if !reflect.DeepEqual(runner.taskNames(), tt.expected) {
+				t.Errorf("expected tasks %v, got %v", tt.expected, runner.taskNames())
+			}
+		})
+	}
+
+	t.Run("EveryRun", func(t *testing.T) {
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+		executor.SetStartAtTask("verify")
+
+		for i := 0; i < 2; i++ {
+			if _, err := executor.Execute(context.Background(), stepPlaybook(), nil); err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+		}
+		if expected := []string{"verify", "verify"}; !reflect.DeepEqual(runner.taskNames(), expected) {
+			t.Errorf("expected each run to start at the task, got %v", runner.taskNames())
+		}
+	})
+
+	t.Run("NoMatch", func(t *testing.T) {
+		runner := newRecordingRunner()
+		executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+		executor.SetStartAtTask("missing")
+
+		_, err := executor.Execute(context.Background(), stepPlaybook(), nil)
+		if err == nil || !strings.Contains(err.Error(), `no task matching "missing"`) {
+			t.Errorf("expected an error for a missing start task, got %v", err)
+		}
+		if len(runner.calls) != 0 {
+			t.Errorf("expected no task to run, got %v", runner.taskNames())
+		}
+	})
+}
+
+func TestExecutorStep(t *testing.T) {
+	runner := newRecordingRunner()
+	stepper := &scriptedStepper{answers: []StepAnswer{StepNo, StepYes, StepContinue}}
+	executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+	executor.SetStartAtTask("configure")
+	executor.SetStepper(stepper)
+
+	if _, err := executor.Execute(context.Background(), stepPlaybook(), nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// Tasks before the start task are not asked about, and continuing
+	// stops the questions
+	if expected := []string{"configure", "restart", "verify"}; !reflect.DeepEqual(stepper.asked, expected) {
+		t.Errorf("expected to be asked about %v, got %v", expected, stepper.asked)
+	}
+	if expected := []string{"restart", "verify"}; !reflect.DeepEqual(runner.taskNames(), expected) {
+		t.Errorf("expected tasks %v, got %v", expected, runner.taskNames())
+	}
+}
+
+func TestPromptStepper(t *testing.T) {
+	var out bytes.Buffer
+	stepper := NewPromptStepper(strings.NewReader("y\n\nNO\nc"), &out)
+	task := &types.Task{Name: "restart nginx"}
+
+	for _, expected := range []StepAnswer{StepYes, StepNo, StepNo, StepContinue} {
+		answer, err := stepper.ConfirmTask(context.Background(), task)
+		if err != nil {
+			t.Fatalf("ConfirmTask failed: %v", err)
+		}
+		if answer != expected {
+			t.Errorf("expected answer %d, got %d", expected, answer)
+		}
+	}
+	if !strings.HasPrefix(out.String(), "Perform task: TASK: restart nginx (N)o/(y)es/(c)ontinue: ") {
+		t.Errorf("unexpected prompt %q", out.String())
+	}
+
+	if _, err := stepper.ConfirmTask(context.Background(), task); err == nil {
+		t.Error("expected an error once the input is exhausted")
+	}
+}
@@ -0,0 +1,97 @@
+package playbook
+
+import (
+	"sort"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// TagSelection selects the tasks of a run by their tags, like Ansible's
+// --tags and --skip-tags. Tasks inherit the tags of their play, role and
+// imported task file.
+type TagSelection struct {
+	Tags     []string // Run only tasks with one of these tags; empty runs all
+	SkipTags []string // Skip tasks with one of these tags
+}
+
+// selects reports whether the task runs with the selection
+func (s TagSelection) selects(task *types.Task) bool {
+	return types.ShouldRunTags(task.Tags, s.Tags, s.SkipTags)
+}
+
+// SetTags selects the tasks the executor runs by their tags. Handlers run
+// when notified whatever their tags.
+func (e *Executor) SetTags(selection TagSelection) {
+	e.tags = selection
+}
+
+// PlayTags lists the tags of a play: its own and those of its tasks,
+// including the tasks of its roles
+type PlayTags struct {
+	Name     string
+	Hosts    interface{}
+	Tags     []string
+	TaskTags []string
+}
+
+// ListTags returns the tags of each play in a playbook, as --list-tags
+// shows them
+func (e *Executor) ListTags(playbook *types.Playbook) ([]PlayTags, error) {
+	listed := make([]PlayTags, 0, len(playbook.Plays))
+	for i := range playbook.Plays {
+		play := &playbook.Plays[i]
+		if len(play.Roles) > 0 {
+			expanded, err := e.expandRoles(play, e.mergePlayVars(play, nil))
+			if err != nil {
+				return nil, err
+			}
+			play = expanded
+		}
+		play = inheritPlayTags(play)
+
+		seen := make(map[string]bool)
+		for _, section := range [][]types.Task{play.PreTasks, play.Tasks, play.PostTasks} {
+			for _, task := range section {
+				for _, tag := range task.Tags {
+					seen[tag] = true
+				}
+			}
+		}
+		taskTags := make([]string, 0, len(seen))
+		for tag := range seen {
+			taskTags = append(taskTags, tag)
+		}
+		sort.Strings(taskTags)
+
+		listed = append(listed, PlayTags{
+			Name:     play.Name,
+			Hosts:    play.Hosts,
+			Tags:     types.UniqueStrings(play.Tags),
+			TaskTags: taskTags,
+		})
+	}
+	return listed, nil
+}
+
+// inheritPlayTags returns the play with its tags added to those of its
+// tasks, so that selecting a play's tag selects all of its tasks
+func inheritPlayTags(play *types.Play) *types.Play {
+	if len(play.Tags) == 0 {
+		return play
+	}
+	inherited := *play
+	inherited.PreTasks = withTags(play.PreTasks, play.Tags)
+	inherited.Tasks = withTags(play.Tasks, play.Tags)
+	inherited.PostTasks = withTags(play.PostTasks, play.Tags)
+	return &inherited
+}
+
+// withTags returns copies of tasks with tags added in front of their own
+func withTags(tasks []types.Task, tags []string) []types.Task {
+	tagged := make([]types.Task, len(tasks))
+	for i, task := range tasks {
+		tagged[i] = task
+		tagged[i].Tags = append(append([]string{}, tags...), task.Tags...)
+	}
+	return tagged
+}
@@ -0,0 +1,136 @@
+package playbook
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// tagsPlaybook is a playbook with tagged plays, role, tasks and handler
+const tagsPlaybook = `
+- name: web
+  hosts: web1
+  tags: [web]
+  vars:
+    gather_facts: false
+  roles:
+    - role: base
+      tags: [base]
+  tasks:
+    - name: install
+      debug: {msg: install}
+      tags: [packages]
+    - name: configure
+      debug: {msg: configure}
+      tags: [config]
+      notify: restart
+    - name: check
+      debug: {msg: check}
+      tags: [always]
+    - name: debug dump
+      debug: {msg: dump}
+      tags: [never, debug]
+    - name: untagged
+      debug: {msg: untagged}
+  handlers:
+    - name: restart
+      debug: {msg: restart}
+      tags: [restart]
+`
+
+func TestExecutorTags(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"base/tasks/main.yml": "- name: base setup\n  debug:\n    msg: base\n",
+	})
+
+	tests := []struct {
+		name      string
+		selection TagSelection
+		expected  []string
+	}{
+		{
+			name:     "all but never",
+			expected: []string{"base setup", "install", "configure", "check", "untagged", "restart"},
+		},
+		{
+			name:      "task tag with always",
+			selection: TagSelection{Tags: []string{"config"}},
+			expected:  []string{"configure", "check", "restart"},
+		},
+		{
+			name:      "role tag",
+			selection: TagSelection{Tags: []string{"base"}},
+			expected:  []string{"base setup", "check"},
+		},
+		{
+			// As in Ansible, an inherited tag asked for also runs never tasks
+			name:      "play tag is inherited",
+			selection: TagSelection{Tags: []string{"web"}, SkipTags: []string{"packages", "base"}},
+			expected:  []string{"configure", "check", "debug dump", "untagged", "restart"},
+		},
+		{
+			name:      "never runs when asked for",
+			selection: TagSelection{Tags: []string{"debug"}},
+			expected:  []string{"check", "debug dump"},
+		},
+		{
+			name:      "skip always by name",
+			selection: TagSelection{Tags: []string{"packages"}, SkipTags: []string{"always"}},
+			expected:  []string{"install"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var plays []types.Play
+			if err := yaml.Unmarshal([]byte(tagsPlaybook), &plays); err != nil {
+				t.Fatalf("failed to parse playbook: %v", err)
+			}
+
+			runner := newRecordingRunner()
+			runner.changeOn["configure"] = map[string]bool{"web1": true}
+			executor := NewExecutor(runner, newTestInventory(t, "web1"), nil)
+			executor.SetRolesPath(dir)
+			executor.SetTags(tt.selection)
+
+			if _, err := executor.ExecutePlay(context.Background(), &plays[0], nil); err != nil {
+				t.Fatalf("ExecutePlay failed: %v", err)
+			}
+			if got := runner.taskNames(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExecutorListTags(t *testing.T) {
+	dir := t.TempDir()
+	writeRoleFiles(t, dir, map[string]string{
+		"base/tasks/main.yml": "- name: base setup\n  debug:\n    msg: base\n  tags: [bootstrap]\n",
+	})
+
+	var plays []types.Play
+	if err := yaml.Unmarshal([]byte(tagsPlaybook), &plays); err != nil {
+		t.Fatalf("failed to parse playbook: %v", err)
+	}
+	executor := NewExecutor(newRecordingRunner(), newTestInventory(t, "web1"), nil)
+	executor.SetRolesPath(dir)
+
+	listed, err := executor.ListTags(&types.Playbook{Plays: plays})
+	if err != nil {
+		t.Fatalf("ListTags failed: %v", err)
+	}
+	expected := []PlayTags{{
+		Name:     "web",
+		Hosts:    "web1",
+		Tags:     []string{"web"},
+		TaskTags: []string{"always", "base", "bootstrap", "config", "debug", "never", "packages", "web"},
+	}}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected %+v, got %+v", expected, listed)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vault"
 	"gopkg.in/yaml.v3"
 )
 
@@ -50,12 +52,67 @@ type RoleDependency struct {
 	Version string                 `yaml:"version,omitempty"`
 	Vars    map[string]interface{} `yaml:"vars,omitempty"`
 	Tags    []string               `yaml:"tags,omitempty"`
+	When    interface{}            `yaml:"when,omitempty"`
+}
+
+// UnmarshalYAML accepts a dependency written as a plain role name, and
+// treats unknown keys of a mapping as role parameters as Ansible does
+func (d *RoleDependency) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		d.Role = value.Value
+		return nil
+	}
+
+	var raw map[string]interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	for key, v := range raw {
+		switch key {
+		case "role", "name":
+			d.Role = types.ConvertToString(v)
+		case "src":
+			d.Src = types.ConvertToString(v)
+		case "version":
+			d.Version = types.ConvertToString(v)
+		case "when":
+			d.When = v
+		case "tags":
+			switch tags := v.(type) {
+			case string:
+				d.Tags = []string{tags}
+			case []interface{}:
+				for _, tag := range tags {
+					d.Tags = append(d.Tags, types.ConvertToString(tag))
+				}
+			}
+		case "vars":
+			if vars, ok := v.(map[string]interface{}); ok {
+				for k, val := range vars {
+					d.setVar(k, val)
+				}
+			}
+		default:
+			d.setVar(key, v)
+		}
+	}
+	return nil
+}
+
+func (d *RoleDependency) setVar(key string, value interface{}) {
+	if d.Vars == nil {
+		d.Vars = make(map[string]interface{})
+	}
+	d.Vars[key] = value
 }
 
 // RoleManager manages roles
 type RoleManager struct {
 	rolesPath   []string
 	loadedRoles map[string]*Role
+	loadedMu    sync.Mutex     // Guards loadedRoles for plays running concurrently
+	vaults      *vault.Manager // Decrypts vault encrypted role files
 }
 
 // NewRoleManager creates a new role manager
@@ -69,10 +126,19 @@ func NewRoleManager(rolesPath []string) *RoleManager {
 	}
 }
 
+// SetVaultManager decrypts vault encrypted role files, and inline !vault
+// values in them, with the passwords of vaults
+func (rm *RoleManager) SetVaultManager(vaults *vault.Manager) {
+	rm.vaults = vaults
+}
+
 // LoadRole loads a role by name
 func (rm *RoleManager) LoadRole(name string) (*Role, error) {
 	// Check if already loaded
-	if role, exists := rm.loadedRoles[name]; exists {
+	rm.loadedMu.Lock()
+	role, exists := rm.loadedRoles[name]
+	rm.loadedMu.Unlock()
+	if exists {
 		return role, nil
 	}
 
@@ -90,7 +156,7 @@ func (rm *RoleManager) LoadRole(name string) (*Role, error) {
 		return nil, fmt.Errorf("role '%s' not found in paths: %v", name, rm.rolesPath)
 	}
 
-	role := &Role{
+	role = &Role{
 		Name: name,
 		Path: rolePath,
 	}
@@ -121,7 +187,9 @@ func (rm *RoleManager) LoadRole(name string) (*Role, error) {
 	role.Templates = rm.listRoleFiles(role, "templates")
 
 	// Cache the loaded role
+	rm.loadedMu.Lock()
 	rm.loadedRoles[name] = role
+	rm.loadedMu.Unlock()
 
 	return role, nil
 }
@@ -140,7 +208,7 @@ func (rm *RoleManager) loadRoleTasks(role *Role) error {
 	}
 
 	var tasks []types.Task
-	if err := yaml.Unmarshal(data, &tasks); err != nil {
+	if err := rm.vaults.DecodeYAML(data, &tasks); err != nil {
 		return err
 	}
 
@@ -161,7 +229,7 @@ func (rm *RoleManager) loadRoleHandlers(role *Role) error {
 	}
 
 	var handlers []types.Task
-	if err := yaml.Unmarshal(data, &handlers); err != nil {
+	if err := rm.vaults.DecodeYAML(data, &handlers); err != nil {
 		return err
 	}
 
@@ -183,7 +251,7 @@ func (rm *RoleManager) loadRoleVars(role *Role) error {
 	}
 
 	vars := make(map[string]interface{})
-	if err := yaml.Unmarshal(data, &vars); err != nil {
+	if err := rm.vaults.DecodeYAML(data, &vars); err != nil {
 		return err
 	}
 
@@ -205,7 +273,7 @@ func (rm *RoleManager) loadRoleDefaults(role *Role) error {
 	}
 
 	defaults := make(map[string]interface{})
-	if err := yaml.Unmarshal(data, &defaults); err != nil {
+	if err := rm.vaults.DecodeYAML(data, &defaults); err != nil {
 		return err
 	}
 
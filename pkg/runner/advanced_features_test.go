@@ -2,6 +2,7 @@ package runner
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 	
@@ -350,4 +351,59 @@ func TestTaskRunnerRetries(t *testing.T) {
 	if len(results) != 1 {
 		t.Errorf("expected 1 result, got %d", len(results))
 	}
-}
\ No newline at end of file
+}
+
+func TestTaskRunnerUntilPolling(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+	counter := filepath.Join(t.TempDir(), "attempts")
+
+	// The command counts its runs; the task is polled until the third one
+	task := types.Task{
+		Name:     "Wait for the third run",
+		Module:   "shell",
+		Args:     map[string]interface{}{"cmd": "echo run >> " + counter + " && wc -l < " + counter},
+		Register: "probe",
+		Until:    "probe.stdout | trim | int >= 3 and probe.attempts == 3",
+		Retries:  5,
+	}
+	results, err := runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !results[0].Success || results[0].Data["attempts"] != 3 {
+		t.Fatalf("expected success on the third attempt, got success=%v attempts=%v", results[0].Success, results[0].Data["attempts"])
+	}
+
+	// Later tasks see the registered result of the last attempt
+	hostVars, err := runner.getHostVariables(hosts[0], nil)
+	if err != nil {
+		t.Fatalf("getHostVariables failed: %v", err)
+	}
+	probe, _ := hostVars["probe"].(map[string]interface{})
+	if probe["attempts"] != 3 || probe["failed"] != false {
+		t.Errorf("expected the registered result to record 3 attempts, got %#v", probe)
+	}
+
+	// Until defaults to 3 retries and fails the task when it never holds
+	task.Until = "result.stdout | trim == 'never'"
+	task.Retries = 0
+	results, err = runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Success || results[0].Data["attempts"] != 4 || results[0].Error == nil {
+		t.Errorf("expected failure after 4 attempts, got success=%v attempts=%v error=%v", results[0].Success, results[0].Data["attempts"], results[0].Error)
+	}
+
+	// The delay between attempts stops with the context
+	task.Delay = 60
+	cancelled, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, _ = runner.Run(cancelled, task, hosts, nil)
+	if time.Since(start) > 5*time.Second || len(results) != 1 || results[0].Success {
+		t.Errorf("expected the cancelled poll to fail promptly, got %+v after %v", results, time.Since(start))
+	}
+}
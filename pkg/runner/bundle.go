@@ -0,0 +1,419 @@
+package runner
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/become"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vars"
+)
+
+// SupportBundles configures the support bundles collected for each host a
+// task fails on. A bundle is a zip holding the failing task with its
+// resolved args, the commands recently run on the host, journal or syslog
+// excerpts for the services involved and the host's facts. Secrets are
+// redacted throughout.
+type SupportBundles struct {
+	Dir      string        // Directory bundles are written to
+	LogLines int           // Journal or syslog lines kept per log, defaults to 200
+	Timeout  time.Duration // Limit on collecting from the host, defaults to 30s
+}
+
+// commandLogSize is the number of recent commands kept per host
+const commandLogSize = 50
+
+// redacted replaces secrets in support bundles
+const redacted = "********"
+
+// sensitiveName matches argument, variable and fact names holding secrets
+var sensitiveName = regexp.MustCompile(`(?i)pass|secret|token|credential|private_key|api_key|access_key|auth`)
+
+// serviceModules are the modules whose name argument is a service
+var serviceModules = []string{"service", "systemd", "systemd_service", "sysvinit", "supervisorctl"}
+
+// bundleCollector writes support bundles and keeps the command log of
+// each host they include
+type bundleCollector struct {
+	SupportBundles
+	mu   sync.Mutex
+	logs map[string][]commandRecord
+}
+
+// commandRecord is a command a module ran on a host
+type commandRecord struct {
+	Time     time.Time
+	Command  string
+	Duration time.Duration
+	ExitCode interface{}
+	Stderr   string
+	Err      error
+}
+
+// SetSupportBundles collects a support bundle for each host a task fails
+// on, unless its errors are ignored. An empty Dir disables collection.
+func (r *TaskRunner) SetSupportBundles(bundles SupportBundles) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if bundles.Dir == "" {
+		r.bundles = nil
+		return
+	}
+	if bundles.LogLines <= 0 {
+		bundles.LogLines = 200
+	}
+	if bundles.Timeout <= 0 {
+		bundles.Timeout = 30 * time.Second
+	}
+	r.bundles = &bundleCollector{SupportBundles: bundles, logs: make(map[string][]commandRecord)}
+}
+
+// supportBundles returns the bundle collector, or nil when disabled
+func (r *TaskRunner) supportBundles() *bundleCollector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bundles
+}
+
+// record returns conn with the commands run through it added to the
+// host's command log
+func (b *bundleCollector) record(host string, conn types.Connection) types.Connection {
+	recorder := &recordingConnection{Connection: conn, host: host, bundles: b}
+	if stream, ok := conn.(types.StreamingConnection); ok {
+		return &recordingStreamingConnection{recordingConnection: recorder, stream: stream}
+	}
+	return recorder
+}
+
+// add appends a command to a host's log, dropping the oldest past the limit
+func (b *bundleCollector) add(host string, record commandRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log := append(b.logs[host], record)
+	if len(log) > commandLogSize {
+		log = log[len(log)-commandLogSize:]
+	}
+	b.logs[host] = log
+}
+
+// commands returns a copy of a host's command log
+func (b *bundleCollector) commands(host string) []commandRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]commandRecord(nil), b.logs[host]...)
+}
+
+// recordingConnection adds each command a module runs to the command log
+type recordingConnection struct {
+	types.Connection
+	host    string
+	bundles *bundleCollector
+}
+
+// recordingStreamingConnection is a recording connection whose transport
+// supports streaming
+type recordingStreamingConnection struct {
+	*recordingConnection
+	stream types.StreamingConnection
+}
+
+// Execute implements types.Connection
+func (c *recordingConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	start := time.Now()
+	result, err := c.Connection.Execute(ctx, command, options)
+
+	record := commandRecord{Time: start, Command: command, Duration: time.Since(start), Err: err}
+	if result != nil && result.Data != nil {
+		record.ExitCode = result.Data["exit_code"]
+		record.Stderr, _ = result.Data["stderr"].(string)
+	}
+	c.bundles.add(c.host, record)
+	return result, err
+}
+
+// ExecuteStream implements types.StreamingConnection
+func (c *recordingStreamingConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	events, err := c.stream.ExecuteStream(ctx, command, options)
+	c.bundles.add(c.host, commandRecord{Time: time.Now(), Command: command, ExitCode: "streamed", Err: err})
+	return events, err
+}
+
+// GetHostname reports the wrapped connection's host name when available
+func (c *recordingConnection) GetHostname() (string, error) {
+	if provider, ok := c.Connection.(interface{ GetHostname() (string, error) }); ok {
+		return provider.GetHostname()
+	}
+	return "", fmt.Errorf("connection does not report a hostname")
+}
+
+// collectSupportBundle writes the support bundle for a host a task failed
+// on and returns its path. Parts that cannot be collected, such as logs
+// from a host that is down, hold the error instead.
+func (r *TaskRunner) collectSupportBundle(ctx context.Context, bundles *bundleCollector, task types.Task, host types.Host, taskVars map[string]interface{}, result *types.Result) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bundles.Timeout)
+	defer cancel()
+
+	args := task.Args
+	hostVars, err := r.getHostVariables(host, taskVars)
+	if err == nil {
+		if expanded, err := r.expandTaskArguments(task.Args, hostVars); err == nil {
+			args = expanded
+		}
+	}
+	redactor := newRedactor(host, hostVars, args)
+
+	files := map[string][]byte{
+		"task.json":      redactor.json(taskReport(task, host, args, result)),
+		"connection.log": []byte(redactor.scrub(commandLogText(host, bundles.commands(host.Name)))),
+	}
+
+	// The commands, arguments and result of a no_log task stay hidden
+	if task.NoLogEnabled() {
+		censored := *result
+		censored.NoLog = true
+		files["task.json"] = redactor.json(taskReport(task, host, types.CensorValues(args), types.CensorResult(&censored)))
+		files["connection.log"] = []byte(types.NoLogValue + "\n")
+	}
+
+	conn, err := r.getConnection(ctx, host)
+	if err == nil {
+		var config *types.BecomeConfig
+		if config, err = r.becomeConfig(task, hostVars); err == nil {
+			conn = become.WrapConnection(conn, config)
+		}
+	}
+	if err != nil {
+		message := []byte(fmt.Sprintf("not collected: %v\n", err))
+		files["logs.txt"] = message
+		files["facts.json"] = message
+	} else {
+		files["logs.txt"] = []byte(redactor.scrub(collectLogs(ctx, conn, task, args, bundles.LogLines)))
+		if collector, err := vars.NewFactCollector([]string{"all"}, 0); err != nil {
+			files["facts.json"] = []byte(fmt.Sprintf("not collected: %v\n", err))
+		} else if facts, err := collector.Collect(ctx, conn); err != nil {
+			files["facts.json"] = []byte(fmt.Sprintf("not collected: %v\n", err))
+		} else {
+			files["facts.json"] = redactor.json(facts)
+		}
+	}
+
+	name := unsafeFileChars.ReplaceAllString(fmt.Sprintf("%s-%s-%s", host.Name, task.Name, time.Now().Format("20060102T150405")), "_")
+	return writeBundle(filepath.Join(bundles.Dir, name+".zip"), files)
+}
+
+// taskReport describes the failing task and its result
+func taskReport(task types.Task, host types.Host, args map[string]interface{}, result *types.Result) map[string]interface{} {
+	report := map[string]interface{}{
+		"host":         host.Name,
+		"address":      host.Address,
+		"task":         task.Name,
+		"module":       task.Module.String(),
+		"args":         args,
+		"collected_at": time.Now().Format(time.RFC3339),
+		"result": map[string]interface{}{
+			"success": result.Success,
+			"changed": result.Changed,
+			"message": result.Message,
+			"data":    result.Data,
+		},
+	}
+	if result.Error != nil {
+		report["result"].(map[string]interface{})["error"] = result.Error.Error()
+	}
+	if task.When != nil {
+		report["when"] = task.When
+	}
+	return report
+}
+
+// commandLogText formats a host's command log, oldest first
+func commandLogText(host types.Host, commands []commandRecord) string {
+	if len(commands) == 0 {
+		return fmt.Sprintf("no commands were run on %s\n", host.Name)
+	}
+
+	var b strings.Builder
+	for _, record := range commands {
+		fmt.Fprintf(&b, "%s rc=%v %s: %s\n", record.Time.Format(time.RFC3339), record.ExitCode, record.Duration.Round(time.Millisecond), record.Command)
+		if record.Err != nil {
+			fmt.Fprintf(&b, "  error: %v\n", record.Err)
+		}
+		if stderr := strings.TrimSpace(record.Stderr); stderr != "" {
+			if len(stderr) > 2048 {
+				stderr = "..." + stderr[len(stderr)-2048:]
+			}
+			fmt.Fprintf(&b, "  stderr: %s\n", strings.ReplaceAll(stderr, "\n", "\n          "))
+		}
+	}
+	return b.String()
+}
+
+// collectLogs reads the journal of the services the task manages, and the
+// system journal or syslog
+func collectLogs(ctx context.Context, conn types.Connection, task types.Task, args map[string]interface{}, lines int) string {
+	var script strings.Builder
+	if service := types.ConvertToString(args["name"]); service != "" && types.StringSliceContains(serviceModules, task.Module.String()) {
+		fmt.Fprintf(&script, "echo '== journalctl -u %[1]s'; journalctl -u '%[1]s' -n %[2]d --no-pager 2>&1; ", strings.ReplaceAll(service, "'", `'\''`), lines)
+	}
+	fmt.Fprintf(&script, `if command -v journalctl >/dev/null 2>&1; then echo '== journalctl'; journalctl -n %[1]d --no-pager 2>&1; `+
+		`elif [ -r /var/log/syslog ]; then echo '== /var/log/syslog'; tail -n %[1]d /var/log/syslog; `+
+		`elif [ -r /var/log/messages ]; then echo '== /var/log/messages'; tail -n %[1]d /var/log/messages; `+
+		`else echo 'no journal or syslog found'; fi`, lines)
+
+	result, err := conn.Execute(ctx, script.String(), types.ExecuteOptions{})
+	if err != nil {
+		return fmt.Sprintf("not collected: %v\n", err)
+	}
+	output, _ := result.Data["stdout"].(string)
+	return output
+}
+
+// writeBundle zips files, in name order, to path
+func writeBundle(path string, files map[string][]byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create support bundle directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create support bundle: %w", err)
+	}
+	defer file.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	archive := zip.NewWriter(file)
+	for _, name := range names {
+		w, err := archive.Create(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to write support bundle: %w", err)
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return "", fmt.Errorf("failed to write support bundle: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return "", fmt.Errorf("failed to write support bundle: %w", err)
+	}
+	return path, file.Close()
+}
+
+// redactor hides secrets in support bundles: values under sensitive names
+// are replaced, and those values are scrubbed from free text such as
+// commands and logs
+type redactor struct {
+	secrets []string
+}
+
+// newRedactor gathers the secrets in a host's connection settings,
+// variables and the task's args
+func newRedactor(host types.Host, hostVars, args map[string]interface{}) *redactor {
+	r := &redactor{}
+	r.gather(host.Password)
+	r.collect(hostVars)
+	r.collect(args)
+
+	// Scrub longer secrets first so one containing another is fully hidden
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	return r
+}
+
+// collect gathers the values under sensitive names in value
+func (r *redactor) collect(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if sensitiveName.MatchString(key) {
+				r.gather(item)
+			} else {
+				r.collect(item)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			r.collect(item)
+		}
+	}
+}
+
+// gather adds every string in a sensitive value to the secrets
+func (r *redactor) gather(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		// Very short values would scrub unrelated text
+		if len(v) >= 4 {
+			r.secrets = append(r.secrets, v)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			r.gather(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			r.gather(item)
+		}
+	}
+}
+
+// scrub replaces the gathered secrets in text
+func (r *redactor) scrub(text string) string {
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// redact returns a copy of value with sensitive names masked and secrets
+// scrubbed from strings
+func (r *redactor) redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if sensitiveName.MatchString(key) && item != nil && item != "" {
+				masked[key] = redacted
+			} else {
+				masked[key] = r.redact(item)
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = r.redact(item)
+		}
+		return masked
+	case []string:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = r.scrub(item)
+		}
+		return masked
+	case string:
+		return r.scrub(v)
+	}
+	return value
+}
+
+// json encodes value with secrets redacted
+func (r *redactor) json(value interface{}) []byte {
+	encoded, err := json.MarshalIndent(r.redact(value), "", "  ")
+	if err != nil {
+		return []byte(fmt.Sprintf("not encoded: %v\n", err))
+	}
+	return append(encoded, '\n')
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestSupportBundle(t *testing.T) {
+	runner := NewTaskRunner()
+	runner.SetSupportBundles(SupportBundles{Dir: t.TempDir(), LogLines: 5})
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	task := types.Task{
+		Name:   "Deploy",
+		Module: "shell",
+		Args: map[string]interface{}{
+			"cmd": "echo deploying with {{ api_token }} >&2; exit 3",
+		},
+	}
+	results, err := runner.Run(context.Background(), task, hosts, map[string]interface{}{"api_token": "s3cr3t-value"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Success {
+		t.Fatalf("expected the task to fail, got %+v", results)
+	}
+
+	path, ok := results[0].Data["support_bundle"].(string)
+	if !ok {
+		t.Fatalf("expected a support bundle path, got %v", results[0].Data)
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("failed to open support bundle: %v", err)
+	}
+	defer archive.Close()
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(content)
+	}
+
+	for _, name := range []string{"task.json", "connection.log", "logs.txt", "facts.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected %s in the bundle, got %v", name, archive.File)
+		}
+	}
+	for name, content := range files {
+		if strings.Contains(content, "s3cr3t-value") {
+			t.Errorf("expected the secret to be redacted from %s:\n%s", name, content)
+		}
+	}
+	if !strings.Contains(files["task.json"], `"module": "shell"`) || !strings.Contains(files["task.json"], "deploying with ********") {
+		t.Errorf("expected the resolved, redacted args in task.json:\n%s", files["task.json"])
+	}
+	if !strings.Contains(files["connection.log"], "rc=3") {
+		t.Errorf("expected the failing command in connection.log:\n%s", files["connection.log"])
+	}
+	if !strings.Contains(files["facts.json"], "ansible_system") {
+		t.Errorf("expected host facts in facts.json:\n%s", files["facts.json"])
+	}
+}
+
+func TestSupportBundleSkipsIgnoredErrors(t *testing.T) {
+	runner := NewTaskRunner()
+	runner.SetSupportBundles(SupportBundles{Dir: t.TempDir()})
+
+	task := types.Task{Name: "Probe", Module: "shell", Args: map[string]interface{}{"cmd": "exit 1"}, IgnoreErrors: true}
+	results, err := runner.Run(context.Background(), task, []types.Host{{Name: "localhost", Address: "localhost"}}, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, ok := results[0].Data["support_bundle"]; ok {
+		t.Errorf("expected no bundle for an ignored failure, got %v", results[0].Data)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor(types.Host{Password: "hostpass"}, map[string]interface{}{
+		"ansible_become_password": "becomepw",
+		"db":                      map[string]interface{}{"user": "app", "secret_key": "abcd1234"},
+	}, map[string]interface{}{"token": "xy"})
+
+	redacted := r.redact(map[string]interface{}{
+		"password": "anything",
+		"cmd":      "mysql -papp -pbecomepw --key abcd1234 hostpass",
+		"list":     []interface{}{"becomepw", "plain"},
+	}).(map[string]interface{})
+
+	if redacted["password"] != "********" {
+		t.Errorf("expected sensitive names to be masked, got %v", redacted["password"])
+	}
+	if redacted["cmd"] != "mysql -papp -p******** --key ******** ********" {
+		t.Errorf("expected secrets scrubbed from text, got %v", redacted["cmd"])
+	}
+	if list := redacted["list"].([]interface{}); list[0] != "********" || list[1] != "plain" {
+		t.Errorf("expected secrets scrubbed from lists, got %v", list)
+	}
+}
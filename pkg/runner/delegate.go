@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// SetInventory sets the inventory delegate_to hosts are looked up in. A
+// delegate missing from it is reached by its name, and localhost runs on
+// the controller.
+func (r *TaskRunner) SetInventory(inventory types.Inventory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inventory = inventory
+}
+
+// delegateHost resolves the host a task runs on for host. It is host
+// itself unless the task is delegated; delegate_to may be a template using
+// the host's variables.
+func (r *TaskRunner) delegateHost(task types.Task, host types.Host, hostVars map[string]interface{}) (types.Host, error) {
+	if task.Delegate == "" {
+		return host, nil
+	}
+	rendered, err := r.expandValue(task.Delegate, hostVars)
+	if err != nil {
+		return host, err
+	}
+	name := types.ConvertToString(rendered)
+	if name == "" || name == host.Name {
+		return host, nil
+	}
+
+	r.mu.RLock()
+	inventory := r.inventory
+	r.mu.RUnlock()
+	if inventory != nil {
+		if delegate, err := inventory.GetHost(name); err == nil && delegate != nil {
+			return *delegate, nil
+		}
+	}
+	if name == "localhost" || name == "127.0.0.1" {
+		return types.Host{Name: name, Address: "localhost"}, nil
+	}
+	return types.Host{Name: name, Address: name}, nil
+}
+
+// runOnce runs a run_once task on the first host and gives every other
+// host a copy of its results and of the variable it registers
+func (r *TaskRunner) runOnce(ctx context.Context, task types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	first := hosts[0]
+	results, err := r.run(ctx, task, hosts[:1], vars)
+	if err != nil {
+		return results, err
+	}
+
+	fanned := make([]types.Result, 0, len(results)*len(hosts))
+	fanned = append(fanned, results...)
+	for _, host := range hosts[1:] {
+		for _, result := range results {
+			copied := result
+			copied.Host = host.Name
+			copied.Data = make(map[string]interface{}, len(result.Data))
+			for k, v := range result.Data {
+				copied.Data[k] = v
+			}
+			fanned = append(fanned, copied)
+		}
+	}
+
+	if task.Register != "" {
+		r.mu.Lock()
+		if registered, ok := r.hostState[first.Name][task.Register]; ok {
+			for _, host := range hosts[1:] {
+				if r.hostState[host.Name] == nil {
+					r.hostState[host.Name] = make(map[string]interface{})
+				}
+				r.hostState[host.Name][task.Register] = registered
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	return fanned, nil
+}
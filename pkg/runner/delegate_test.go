@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/inventory"
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vars"
+)
+
+func TestTaskRunnerDelegateTo(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+
+	inv := inventory.NewStaticInventory()
+	if err := inv.AddHost(types.Host{Name: "control", Address: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	runner.SetInventory(inv)
+
+	// The host itself cannot be reached; the delegate runs the command with
+	// the host's variables
+	host := types.Host{Name: "web1", Address: "web1.invalid", Variables: map[string]interface{}{"role": "web", "controller": "control"}}
+	task := types.Task{
+		Name:     "Add to the load balancer",
+		Module:   "shell",
+		Args:     map[string]interface{}{"cmd": "echo {{ inventory_hostname }} {{ role }}"},
+		Delegate: "{{ controller }}",
+		Register: "added",
+	}
+	results, err := runner.Run(ctx, task, []types.Host{host}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := results[0]
+	if !result.Success || result.Host != "web1" || result.Data["delegated_to"] != "control" {
+		t.Fatalf("expected a successful result for web1 delegated to control, got %+v", result)
+	}
+	if stdout := strings.TrimSpace(types.ConvertToString(result.Data["stdout"])); stdout != "web1 web" {
+		t.Errorf("expected the host's variables, got %q", stdout)
+	}
+
+	// The result is registered on the host, not the delegate
+	hostVars, _ := runner.getHostVariables(host, nil)
+	if _, ok := hostVars["added"]; !ok {
+		t.Error("expected the delegated result to be registered on web1")
+	}
+	controlVars, _ := runner.getHostVariables(types.Host{Name: "control"}, nil)
+	if _, ok := controlVars["added"]; ok {
+		t.Error("expected nothing registered on the delegate")
+	}
+
+	// Delegates missing from the inventory are reached by name
+	delegate, err := runner.delegateHost(types.Task{Delegate: "127.0.0.1"}, host, nil)
+	if err != nil || delegate.Name != "127.0.0.1" || delegate.Address != "localhost" {
+		t.Errorf("expected 127.0.0.1 to run locally, got %+v, %v", delegate, err)
+	}
+	delegate, _ = runner.delegateHost(types.Task{Delegate: "db9"}, host, nil)
+	if delegate.Address != "db9" {
+		t.Errorf("expected an unknown delegate to be reached by name, got %+v", delegate)
+	}
+}
+
+func TestTaskRunnerDelegateFacts(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+	host := types.Host{Name: "web1", Address: "web1.invalid"}
+
+	task := types.Task{Name: "Gather controller facts", Module: "setup", Delegate: "localhost", DelegateFacts: true}
+	results, err := runner.Run(ctx, task, []types.Host{host}, nil)
+	if err != nil || !results[0].Success {
+		t.Fatalf("expected setup to run on the delegate, got %+v, %v", results, err)
+	}
+
+	webVars, _ := runner.getHostVariables(host, nil)
+	localVars, _ := runner.getHostVariables(types.Host{Name: "localhost"}, nil)
+	if _, ok := webVars["ansible_facts"]; ok {
+		t.Error("expected the facts to be left off web1 with delegate_facts")
+	}
+	if _, ok := localVars["ansible_facts"]; !ok {
+		t.Error("expected the facts to belong to the delegate with delegate_facts")
+	}
+
+	task.DelegateFacts = false
+	if _, err := runner.Run(ctx, task, []types.Host{host}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	webVars, _ = runner.getHostVariables(host, nil)
+	if _, ok := webVars["ansible_facts"]; !ok {
+		t.Error("expected the facts to belong to web1 without delegate_facts")
+	}
+}
+
+func TestTaskRunnerRunOnce(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+	counter := filepath.Join(t.TempDir(), "runs")
+	hosts := []types.Host{
+		{Name: "app1", Address: "localhost"},
+		{Name: "app2", Address: "localhost"},
+		{Name: "app3", Address: "localhost"},
+	}
+
+	task := types.Task{
+		Name:     "Migrate the database",
+		Module:   "shell",
+		Args:     map[string]interface{}{"cmd": "echo run >> " + counter + " && echo {{ inventory_hostname }}"},
+		RunOnce:  true,
+		Register: "migration",
+	}
+	results, err := runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("expected the task to run once, ran %d times", n)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected a result per host, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.Host != hosts[i].Name || strings.TrimSpace(types.ConvertToString(result.Data["stdout"])) != "app1" {
+			t.Errorf("expected host %s to get the result of app1, got %+v", hosts[i].Name, result)
+		}
+	}
+
+	hostVars, _ := runner.getHostVariables(hosts[2], nil)
+	migration, _ := hostVars["migration"].(map[string]interface{})
+	if strings.TrimSpace(types.ConvertToString(migration["stdout"])) != "app1" {
+		t.Errorf("expected the result to be registered on every host, got %#v", hostVars["migration"])
+	}
+}
+
+// countingPrompter counts the pauses announced to the operator
+type countingPrompter struct {
+	pauses int
+}
+
+func (p *countingPrompter) Interactive() bool { return true }
+
+func (p *countingPrompter) Announce(prompt string, duration time.Duration) { p.pauses++ }
+
+func (p *countingPrompter) ReadInput(ctx context.Context, echo bool) (string, error) {
+	return "yes", nil
+}
+
+func TestTaskRunnerPauseOnce(t *testing.T) {
+	registry := modules.NewModuleRegistry()
+	runner := NewTaskRunnerWithDependencies(registry, connection.NewConnectionManager(), vars.NewVarManager())
+	prompter := &countingPrompter{}
+	runner.SetPrompter(prompter)
+	hosts := []types.Host{
+		{Name: "app1", Address: "localhost"},
+		{Name: "app2", Address: "localhost"},
+	}
+
+	task := types.Task{Name: "Confirm", Module: types.TypePause, Args: map[string]interface{}{"prompt": "Proceed?"}}
+	results, err := runner.Run(context.Background(), task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompter.pauses != 1 {
+		t.Errorf("expected the operator to be asked once, asked %d times", prompter.pauses)
+	}
+	for _, result := range results {
+		if result.Data["user_input"] != "yes" {
+			t.Errorf("expected host %s to get the answer, got %+v", result.Host, result)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// discoveryCommand finds the interpreters a POSIX host offers, and whether
+// its sudo insists on a terminal, in a single command. /bin/sh stays the
+// shell wherever it exists so tasks behave as they did before discovery.
+const discoveryCommand = `echo "python=$(command -v python3 || command -v python)"; ` +
+	`echo "shell=$(if [ -x /bin/sh ]; then echo /bin/sh; else command -v sh || command -v bash; fi)"; ` +
+	`echo "bash=$(command -v bash)"; echo "pwsh=$(command -v pwsh)"; ` +
+	`if command -v sudo >/dev/null 2>&1 && sudo -n true 2>&1 | grep -q 'must have a tty'; then echo "sudo_requiretty=true"; fi`
+
+// windowsDiscoveryCommand is the PowerShell equivalent for WinRM hosts
+const windowsDiscoveryCommand = `foreach ($name in 'python', 'pwsh') { ` +
+	`$cmd = Get-Command "$name.exe" -ErrorAction SilentlyContinue | Select-Object -First 1; "$name=$($cmd.Source)" }`
+
+// discoveredVars maps the variables users used to set by hand to the
+// discovered facts that now provide their defaults
+var discoveredVars = map[string]string{
+	"ansible_python_interpreter": "discovered_interpreter_python",
+	"ansible_shell_executable":   "discovered_shell_executable",
+}
+
+// hostDiscovery caches what interpreter discovery found on a host
+type hostDiscovery struct {
+	once  sync.Once
+	facts map[string]interface{}
+}
+
+// SetInterpreterDiscovery enables or disables interpreter discovery. When
+// enabled, which is the default, the runner probes each host once for its
+// python, shell and pwsh paths and for sudo's requiretty setting, stores
+// them as discovered_* facts and uses them in place of unset variables such
+// as ansible_python_interpreter and ansible_shell_executable.
+func (r *TaskRunner) SetInterpreterDiscovery(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discovery = enabled
+}
+
+// applyDiscovery adds the host's discovered facts to hostVars without
+// overriding variables that are already set
+func (r *TaskRunner) applyDiscovery(ctx context.Context, conn types.Connection, host types.Host, hostVars map[string]interface{}) {
+	r.mu.Lock()
+	if !r.discovery {
+		r.mu.Unlock()
+		return
+	}
+	d, ok := r.discoveries[host.Name]
+	if !ok {
+		d = &hostDiscovery{}
+		r.discoveries[host.Name] = d
+	}
+	r.mu.Unlock()
+
+	d.once.Do(func() {
+		d.facts = discoverInterpreters(ctx, conn, host)
+	})
+
+	for name, value := range d.facts {
+		if _, set := hostVars[name]; !set {
+			hostVars[name] = value
+		}
+	}
+	for name, fact := range discoveredVars {
+		if _, set := hostVars[name]; !set {
+			if value, ok := d.facts[fact]; ok {
+				hostVars[name] = value
+			}
+		}
+	}
+}
+
+// discoverInterpreters probes a host for its interpreters. A failed probe
+// yields no facts rather than failing the task, since explicitly set
+// variables keep working without them.
+func discoverInterpreters(ctx context.Context, conn types.Connection, host types.Host) map[string]interface{} {
+	command, options := discoveryCommand, types.ExecuteOptions{}
+	if info, err := connectionInfo(host); err == nil && info.Type == "winrm" {
+		command, options = windowsDiscoveryCommand, types.ExecuteOptions{Shell: "powershell"}
+	}
+
+	facts := make(map[string]interface{})
+	result, err := conn.Execute(ctx, command, options)
+	if err != nil || result == nil {
+		return facts
+	}
+
+	stdout, _ := result.Data["stdout"].(string)
+	for _, line := range strings.Split(stdout, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || value == "" {
+			continue
+		}
+		switch key {
+		case "python", "bash", "pwsh":
+			facts["discovered_interpreter_"+key] = value
+		case "shell":
+			facts["discovered_shell_executable"] = value
+		case "sudo_requiretty":
+			facts["discovered_sudo_requiretty"] = value == "true"
+		}
+	}
+	return facts
+}
@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vars"
+)
+
+// discoveryConnection answers the discovery probe with a fixed report and
+// records every command with its options
+type discoveryConnection struct {
+	report    string
+	connected bool
+	mu        *sync.Mutex
+	commands  *[]types.ExecuteOptions
+	lines     *[]string
+}
+
+func (c *discoveryConnection) Connect(ctx context.Context, info types.ConnectionInfo) error {
+	c.connected = true
+	return nil
+}
+
+func (c *discoveryConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	c.mu.Lock()
+	*c.lines = append(*c.lines, command)
+	*c.commands = append(*c.commands, options)
+	c.mu.Unlock()
+
+	stdout := ""
+	if command == discoveryCommand {
+		stdout = c.report
+	}
+	return &types.Result{Success: true, Data: map[string]interface{}{"stdout": stdout, "exit_code": 0}}, nil
+}
+
+func (c *discoveryConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	return nil
+}
+
+func (c *discoveryConnection) Fetch(ctx context.Context, src string) (io.Reader, error) {
+	return bytes.NewReader(nil), nil
+}
+
+func (c *discoveryConnection) Close() error {
+	c.connected = false
+	return nil
+}
+
+func (c *discoveryConnection) IsConnected() bool {
+	return c.connected
+}
+
+func TestTaskRunnerInterpreterDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	var options []types.ExecuteOptions
+	report := "python=/usr/libexec/platform-python\nshell=/bin/sh\nbash=\npwsh=\nsudo_requiretty=true\n"
+
+	connections := connection.NewConnectionManager()
+	connections.RegisterPlugin("discovery", func() types.Connection {
+		return &discoveryConnection{report: report, mu: &mu, commands: &options, lines: &lines}
+	})
+	runner := NewTaskRunnerWithDependencies(modules.DefaultModuleRegistry, connections, vars.NewVarManager())
+
+	hosts := []types.Host{
+		{Name: "rhel", Address: "10.0.0.1", Variables: map[string]interface{}{"ansible_connection": "discovery"}},
+		{Name: "custom", Address: "10.0.0.2", Variables: map[string]interface{}{
+			"ansible_connection":       "discovery",
+			"ansible_shell_executable": "/usr/local/bin/bash",
+		}},
+	}
+	become := true
+	task := types.Task{
+		Name:   "Report",
+		Module: "shell",
+		Args:   map[string]interface{}{"cmd": "echo {{ ansible_python_interpreter }}"},
+		Become: &become,
+	}
+
+	for run := 0; run < 2; run++ {
+		results, err := runner.Run(context.Background(), task, hosts, nil)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		for _, result := range results {
+			if !result.Success {
+				t.Fatalf("expected %s to succeed, got %v", result.Host, result.Error)
+			}
+		}
+	}
+
+	probes := 0
+	shells := make(map[string]int)
+	for i, line := range lines {
+		if line == discoveryCommand {
+			probes++
+			if options[i].Become != nil {
+				t.Error("expected the probe to run without become")
+			}
+			continue
+		}
+		if options[i].Become == nil || !options[i].Become.RequireTTY {
+			t.Errorf("expected sudo to be marked as requiring a terminal for %q", line)
+		}
+		for _, shell := range []string{"/bin/sh", "/usr/local/bin/bash"} {
+			if strings.HasPrefix(line, shell+" -c ") {
+				shells[shell]++
+			}
+		}
+		if !strings.Contains(line, "/usr/libexec/platform-python") {
+			t.Errorf("expected the discovered python in %q", line)
+		}
+	}
+
+	if probes != len(hosts) {
+		t.Errorf("expected each host to be probed once, got %d probes", probes)
+	}
+	if shells["/bin/sh"] != 2 || shells["/usr/local/bin/bash"] != 2 {
+		t.Errorf("expected the discovered shell unless the inventory sets one, got %v", shells)
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		lines = nil
+		options = nil
+		runner := NewTaskRunnerWithDependencies(modules.DefaultModuleRegistry, connections, vars.NewVarManager())
+		runner.SetInterpreterDiscovery(false)
+
+		task := types.Task{Name: "Echo", Module: "shell", Args: map[string]interface{}{"cmd": "echo hi"}}
+		if _, err := runner.Run(context.Background(), task, hosts[:1], nil); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if len(lines) != 1 || lines[0] == discoveryCommand {
+			t.Errorf("expected only the task's command, got %v", lines)
+		}
+	})
+}
+
+func TestDiscoverInterpreters(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	var options []types.ExecuteOptions
+	conn := &discoveryConnection{report: "python=/usr/bin/python3\nshell=/bin/sh\nbash=/bin/bash\npwsh=\n", mu: &mu, commands: &options, lines: &lines}
+
+	facts := discoverInterpreters(context.Background(), conn, types.Host{Name: "web", Address: "10.0.0.1"})
+	expected := map[string]interface{}{
+		"discovered_interpreter_python": "/usr/bin/python3",
+		"discovered_interpreter_bash":   "/bin/bash",
+		"discovered_shell_executable":   "/bin/sh",
+	}
+	if len(facts) != len(expected) {
+		t.Errorf("expected facts %v, got %v", expected, facts)
+	}
+	for name, value := range expected {
+		if facts[name] != value {
+			t.Errorf("expected %s=%v, got %v", name, value, facts[name])
+		}
+	}
+
+	lines = nil
+	discoverInterpreters(context.Background(), conn, types.Host{Name: "win", Address: "10.0.0.2", Variables: map[string]interface{}{"ansible_connection": "winrm"}})
+	if len(lines) != 1 || lines[0] != windowsDiscoveryCommand || options[len(options)-1].Shell != "powershell" {
+		t.Errorf("expected the PowerShell probe on WinRM hosts, got %v", lines)
+	}
+}
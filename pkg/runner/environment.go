@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Plays hand their environment and module_defaults to the runner as these
+// variables, under what tasks set themselves
+const (
+	environmentVar    = "_environment"
+	moduleDefaultsVar = "_module_defaults"
+)
+
+// taskEnvironment returns the environment of a task's commands on a host:
+// the play's environment overlaid with the task's, rendered with the host's
+// variables
+func (r *TaskRunner) taskEnvironment(task types.Task, hostVars map[string]interface{}) (map[string]string, error) {
+	env := make(map[string]interface{})
+	switch inherited := hostVars[environmentVar].(type) {
+	case map[string]string:
+		for k, v := range inherited {
+			env[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range inherited {
+			env[k] = v
+		}
+	}
+	for k, v := range task.Environment {
+		env[k] = v
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+
+	rendered := make(map[string]string, len(env))
+	for k, v := range env {
+		value, err := r.expandValue(v, hostVars)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", k, err)
+		}
+		rendered[k] = types.ConvertToString(value)
+	}
+	return rendered, nil
+}
+
+// withModuleDefaults returns a task's arguments on top of the defaults the
+// play sets for its module, by short or fully qualified name
+func withModuleDefaults(task types.Task, hostVars map[string]interface{}) map[string]interface{} {
+	var defaults map[string]interface{}
+	switch all := hostVars[moduleDefaultsVar].(type) {
+	case map[string]map[string]interface{}:
+		for name, args := range all {
+			if moduleDefaultsApply(name, task.Module.String()) {
+				defaults = mergeArgs(defaults, args)
+			}
+		}
+	case map[string]interface{}:
+		for name, args := range all {
+			if args, ok := args.(map[string]interface{}); ok && moduleDefaultsApply(name, task.Module.String()) {
+				defaults = mergeArgs(defaults, args)
+			}
+		}
+	}
+	if len(defaults) == 0 {
+		return task.Args
+	}
+	return mergeArgs(defaults, task.Args)
+}
+
+// moduleDefaultsApply reports whether module_defaults given for name, such
+// as apt or ansible.builtin.apt, apply to module
+func moduleDefaultsApply(name, module string) bool {
+	if name == module {
+		return true
+	}
+	i := strings.LastIndex(name, ".")
+	return i >= 0 && name[i+1:] == module
+}
+
+func mergeArgs(base, overlay map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
+
+var errNoHostname = errors.New("connection does not report a hostname")
+
+// envConnection sets a task's environment for every command a module runs.
+// Variables a module sets itself win.
+type envConnection struct {
+	types.Connection
+	env map[string]string
+}
+
+// envStreamingConnection is an envConnection whose transport streams
+type envStreamingConnection struct {
+	*envConnection
+	stream types.StreamingConnection
+}
+
+// withEnvironment returns conn with env applied to every command. An empty
+// env returns conn unchanged.
+func withEnvironment(conn types.Connection, env map[string]string) types.Connection {
+	if len(env) == 0 {
+		return conn
+	}
+	wrapped := &envConnection{Connection: conn, env: env}
+	if stream, ok := conn.(types.StreamingConnection); ok {
+		return &envStreamingConnection{envConnection: wrapped, stream: stream}
+	}
+	return wrapped
+}
+
+func (c *envConnection) options(options types.ExecuteOptions) types.ExecuteOptions {
+	env := make(map[string]string, len(c.env)+len(options.Env))
+	for k, v := range c.env {
+		env[k] = v
+	}
+	for k, v := range options.Env {
+		env[k] = v
+	}
+	options.Env = env
+	return options
+}
+
+// Execute implements types.Connection
+func (c *envConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	return c.Connection.Execute(ctx, command, c.options(options))
+}
+
+// GetHostname reports the wrapped connection's host name when available
+func (c *envConnection) GetHostname() (string, error) {
+	if provider, ok := c.Connection.(interface{ GetHostname() (string, error) }); ok {
+		return provider.GetHostname()
+	}
+	return "", errNoHostname
+}
+
+// ExecuteStream implements types.StreamingConnection
+func (c *envStreamingConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	return c.stream.ExecuteStream(ctx, command, c.options(options))
+}
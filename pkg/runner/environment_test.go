@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestTaskRunnerEnvironment(t *testing.T) {
+	runner := NewTaskRunner()
+	hosts := []types.Host{{Name: "app1", Address: "localhost", Variables: map[string]interface{}{"tier": "web"}}}
+
+	// The play sets the environment of every task; tasks add to and
+	// override it, with templates rendered per host
+	vars := map[string]interface{}{
+		environmentVar: map[string]string{"APP_ENV": "prod", "APP_TIER": "none"},
+	}
+	task := types.Task{
+		Name:        "Print environment",
+		Module:      "shell",
+		Args:        map[string]interface{}{"cmd": "echo $APP_ENV $APP_TIER $APP_HOST"},
+		Environment: map[string]string{"APP_TIER": "{{ tier }}", "APP_HOST": "{{ inventory_hostname }}"},
+	}
+	results, err := runner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(types.ConvertToString(results[0].Data["stdout"])); got != "prod web app1" {
+		t.Errorf("expected the merged environment, got %q", got)
+	}
+}
+
+func TestWithModuleDefaults(t *testing.T) {
+	vars := map[string]interface{}{
+		moduleDefaultsVar: map[string]map[string]interface{}{
+			"apt":                 {"cache_valid_time": 3600, "update_cache": true},
+			"ansible.builtin.apt": {"install_recommends": false},
+			"yum":                 {"enablerepo": "epel"},
+		},
+	}
+
+	task := types.Task{Module: "apt", Args: map[string]interface{}{"name": "nginx", "update_cache": false}}
+	expected := map[string]interface{}{
+		"name":               "nginx",
+		"update_cache":       false,
+		"cache_valid_time":   3600,
+		"install_recommends": false,
+	}
+	if got := withModuleDefaults(task, vars); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	task = types.Task{Module: "debug", Args: map[string]interface{}{"msg": "hi"}}
+	if got := withModuleDefaults(task, vars); !reflect.DeepEqual(got, task.Args) {
+		t.Errorf("expected no defaults for debug, got %v", got)
+	}
+}
+
+func TestTaskRunnerModuleDefaults(t *testing.T) {
+	runner := NewTaskRunner()
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	// A required argument may come from module_defaults alone
+	vars := map[string]interface{}{
+		moduleDefaultsVar: map[string]interface{}{
+			"ansible.builtin.shell": map[string]interface{}{"cmd": "echo {{ greeting }}"},
+		},
+		"greeting": "hello",
+	}
+	task := types.Task{Name: "Greet", Module: "shell"}
+	results, err := runner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(types.ConvertToString(results[0].Data["stdout"])); got != "hello" {
+		t.Errorf("expected the default command to run, got %q", got)
+	}
+}
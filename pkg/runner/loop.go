@@ -0,0 +1,482 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// loopVarName matches a bare variable name used as loop source, as in
+// "with_items: packages"
+var loopVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loopControl holds the loop_control settings of a task
+type loopControl struct {
+	loopVar  string        // Variable holding the current item, item by default
+	indexVar string        // Variable holding the 0-based item index
+	label    interface{}   // Template shown for an item instead of the item
+	pause    time.Duration // Wait between items
+	extended bool          // Expose ansible_loop to templates
+}
+
+// hasLoop reports whether a task iterates over items
+func hasLoop(task types.Task) bool {
+	return task.Loop != nil || task.WithItems != nil || task.WithDict != nil ||
+		task.WithFileglob != nil || task.WithSubelements != nil || task.WithNested != nil
+}
+
+// parseLoopControl reads the loop_control settings of a task
+func parseLoopControl(task types.Task) (loopControl, error) {
+	control := loopControl{loopVar: "item"}
+	if task.LoopControl == nil {
+		return control, nil
+	}
+
+	if lv, ok := task.LoopControl["loop_var"].(string); ok && lv != "" {
+		control.loopVar = lv
+	}
+	if iv, ok := task.LoopControl["index_var"].(string); ok {
+		control.indexVar = iv
+	}
+	control.label = task.LoopControl["label"]
+	if pause, ok := task.LoopControl["pause"]; ok {
+		seconds, err := strconv.ParseFloat(types.ConvertToString(pause), 64)
+		if err != nil || seconds < 0 {
+			return control, fmt.Errorf("invalid loop_control pause %v", pause)
+		}
+		control.pause = time.Duration(seconds * float64(time.Second))
+	}
+	if extended, ok := task.LoopControl["extended"]; ok {
+		control.extended = types.ConvertToBool(extended)
+	}
+	return control, nil
+}
+
+// executeWithLoop executes a task once per loop item on every host. Items
+// are resolved per host, so loops can use facts and registered results.
+// The results of a host's items follow each other, hosts in order.
+func (r *TaskRunner) executeWithLoop(ctx context.Context, task types.Task, module types.Module, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	control, err := parseLoopControl(task)
+	if err != nil {
+		return nil, err
+	}
+
+	hostResults := make([][]types.Result, len(hosts))
+	g, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, r.maxConcurrency)
+
+	for i, host := range hosts {
+		i, host := i, host
+
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results, err := r.loopOnHost(ctx, task, module, host, vars, control)
+			hostResults[i] = results
+			return err
+		})
+	}
+	err = g.Wait()
+
+	allResults := []types.Result{}
+	for _, results := range hostResults {
+		allResults = append(allResults, results...)
+	}
+	return allResults, err
+}
+
+// loopOnHost runs the items of a loop on one host and registers the
+// results of all items together
+func (r *TaskRunner) loopOnHost(ctx context.Context, task types.Task, module types.Module, host types.Host, vars map[string]interface{}, control loopControl) ([]types.Result, error) {
+	hostVars, err := r.getHostVariables(host, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host variables: %w", err)
+	}
+	items, err := r.loopItems(task, hostVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate loop items on host %s: %w", host.Name, err)
+	}
+
+	// Items register together once the loop is done
+	itemTask := task
+	itemTask.Register = ""
+
+	var results []types.Result
+	for index, item := range items {
+		if index > 0 && control.pause > 0 {
+			select {
+			case <-time.After(control.pause):
+			case <-ctx.Done():
+				return results, ctx.Err()
+			}
+		}
+
+		loopVars := make(map[string]interface{}, len(vars)+4)
+		for k, v := range vars {
+			loopVars[k] = v
+		}
+		loopVars[control.loopVar] = item
+		loopVars["ansible_loop_var"] = control.loopVar
+		if control.indexVar != "" {
+			loopVars[control.indexVar] = index
+			loopVars["ansible_index_var"] = control.indexVar
+		}
+		if control.extended {
+			loopVars["ansible_loop"] = extendedLoopVars(items, index)
+		}
+
+		itemResults, err := r.executeOnHosts(ctx, itemTask, module, []types.Host{host}, loopVars)
+		if err != nil {
+			return results, err
+		}
+
+		for i := range itemResults {
+			result := &itemResults[i]
+			if result.Data == nil {
+				result.Data = make(map[string]interface{})
+			}
+			result.Data["ansible_loop"] = map[string]interface{}{
+				"index":         index,
+				"index0":        index,
+				"index1":        index + 1,
+				"first":         index == 0,
+				"last":          index == len(items)-1,
+				"length":        len(items),
+				control.loopVar: item,
+			}
+			result.Data[control.loopVar] = item
+			result.Data["ansible_loop_var"] = control.loopVar
+			if control.label != nil {
+				label, err := r.loopLabel(control.label, host, loopVars)
+				if err != nil {
+					return results, err
+				}
+				result.Data["_ansible_item_label"] = label
+			}
+			results = append(results, *result)
+		}
+	}
+
+	if task.Register != "" {
+		registered := loopRegistered(results, control.loopVar)
+		if r.varManager != nil {
+			r.varManager.SetVar(task.Register, registered)
+		}
+		r.mu.Lock()
+		if r.hostState[host.Name] == nil {
+			r.hostState[host.Name] = make(map[string]interface{})
+		}
+		r.hostState[host.Name][task.Register] = registered
+		r.mu.Unlock()
+	}
+
+	return results, nil
+}
+
+// loopLabel renders the loop_control label of an item
+func (r *TaskRunner) loopLabel(label interface{}, host types.Host, loopVars map[string]interface{}) (string, error) {
+	hostVars, err := r.getHostVariables(host, loopVars)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := r.expandValue(label, hostVars)
+	if err != nil {
+		return "", fmt.Errorf("failed to render loop label: %w", err)
+	}
+	return types.ConvertToString(rendered), nil
+}
+
+// extendedLoopVars is the ansible_loop variable loop_control.extended
+// exposes to templates
+func extendedLoopVars(items []interface{}, index int) map[string]interface{} {
+	loop := map[string]interface{}{
+		"allitems":  items,
+		"index":     index + 1,
+		"index0":    index,
+		"revindex":  len(items) - index,
+		"revindex0": len(items) - index - 1,
+		"first":     index == 0,
+		"last":      index == len(items)-1,
+		"length":    len(items),
+	}
+	if index > 0 {
+		loop["previtem"] = items[index-1]
+	}
+	if index < len(items)-1 {
+		loop["nextitem"] = items[index+1]
+	}
+	return loop
+}
+
+// loopRegistered is the value registered for a loop: the registered result
+// of every item in results, changed or failed when any item was
+func loopRegistered(results []types.Result, loopVar string) map[string]interface{} {
+	items := make([]interface{}, len(results))
+	changed, failed := false, false
+	for i := range results {
+		registered := registeredResult(&results[i])
+		registered[loopVar] = results[i].Data[loopVar]
+		items[i] = registered
+		changed = changed || results[i].Changed
+		failed = failed || !results[i].Success
+	}
+
+	msg := "All items completed"
+	if failed {
+		msg = "One or more items failed"
+	}
+	return map[string]interface{}{
+		"results": items,
+		"changed": changed,
+		"failed":  failed,
+		"msg":     msg,
+	}
+}
+
+// loopItems resolves the items a task iterates over with a host's
+// variables
+func (r *TaskRunner) loopItems(task types.Task, vars map[string]interface{}) ([]interface{}, error) {
+	switch {
+	case task.Loop != nil:
+		return r.listItems(task.Loop, vars)
+	case task.WithItems != nil:
+		items, err := r.listItems(task.WithItems, vars)
+		if err != nil {
+			return nil, err
+		}
+		// with_items flattens one level of nested lists
+		var flat []interface{}
+		for _, item := range items {
+			if list, ok := loopList(item); ok {
+				flat = append(flat, list...)
+			} else {
+				flat = append(flat, item)
+			}
+		}
+		return flat, nil
+	case task.WithDict != nil:
+		value, err := r.loopValue(task.WithDict, vars)
+		if err != nil {
+			return nil, err
+		}
+		return dictItems(value)
+	case task.WithFileglob != nil:
+		value, err := r.expandValue(task.WithFileglob, vars)
+		if err != nil {
+			return nil, err
+		}
+		patterns, ok := loopList(value)
+		if !ok {
+			patterns = []interface{}{value}
+		}
+		return fileglobItems(patterns)
+	case task.WithSubelements != nil:
+		terms, err := r.listItems(task.WithSubelements, vars)
+		if err != nil {
+			return nil, err
+		}
+		if len(terms) > 0 {
+			if terms[0], err = r.loopValue(terms[0], vars); err != nil {
+				return nil, err
+			}
+		}
+		return subelementItems(terms)
+	case task.WithNested != nil:
+		terms, err := r.listItems(task.WithNested, vars)
+		if err != nil {
+			return nil, err
+		}
+		lists := make([][]interface{}, len(terms))
+		for i, term := range terms {
+			if lists[i], err = r.listItems(term, vars); err != nil {
+				return nil, err
+			}
+		}
+		return nestedItems(lists), nil
+	}
+	return nil, nil
+}
+
+// loopValue resolves a loop source: a bare variable name, or a value
+// rendered like a task argument
+func (r *TaskRunner) loopValue(value interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := value.(string); ok && loopVarName.MatchString(name) {
+		if v, exists := vars[name]; exists {
+			return v, nil
+		}
+	}
+	return r.expandValue(value, vars)
+}
+
+// listItems resolves a loop source to a list of items
+func (r *TaskRunner) listItems(source interface{}, vars map[string]interface{}) ([]interface{}, error) {
+	value, err := r.loopValue(source, vars)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if list, ok := loopList(value); ok {
+		return list, nil
+	}
+	// Strings without templates may still be dotted variable references or
+	// ranges such as "1-5"
+	if s, ok := value.(string); ok && s == source {
+		return NewConditionEvaluator(vars).EvaluateLoopItems(s)
+	}
+	return []interface{}{value}, nil
+}
+
+// loopList returns the elements of a slice of any type
+func loopList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// dictItems turns a dict into key/value items, sorted by key
+func dictItems(value interface{}) ([]interface{}, error) {
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("with_dict expects a dict, got %T", value)
+	}
+	items := make([]interface{}, 0, len(dict))
+	for _, key := range sortedLoopKeys(dict) {
+		items = append(items, map[string]interface{}{"key": key, "value": dict[key]})
+	}
+	return items, nil
+}
+
+// fileglobItems returns the files on the controller matching the patterns,
+// as absolute paths. Directories are left out.
+func fileglobItems(patterns []interface{}) ([]interface{}, error) {
+	var items []interface{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(types.ConvertToString(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid fileglob pattern %v: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			if abs, err := filepath.Abs(match); err == nil {
+				match = abs
+			}
+			items = append(items, match)
+		}
+	}
+	return items, nil
+}
+
+// subelementItems pairs each element of a list of dicts with each entry
+// of one of its list keys. The terms are the list, the key, which may be a
+// dotted path, and optionally {skip_missing: true} to skip elements
+// without the key.
+func subelementItems(terms []interface{}) ([]interface{}, error) {
+	if len(terms) < 2 || len(terms) > 3 {
+		return nil, fmt.Errorf("with_subelements expects a list, a key and optional flags, got %d terms", len(terms))
+	}
+
+	elements, ok := loopList(terms[0])
+	if !ok {
+		dict, isDict := terms[0].(map[string]interface{})
+		if !isDict {
+			return nil, fmt.Errorf("with_subelements expects a list or dict of dicts, got %T", terms[0])
+		}
+		for _, key := range sortedLoopKeys(dict) {
+			elements = append(elements, dict[key])
+		}
+	}
+	path := types.ConvertToString(terms[1])
+	skipMissing := false
+	if len(terms) == 3 {
+		flags, ok := terms[2].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("with_subelements flags must be a dict, got %T", terms[2])
+		}
+		skipMissing = types.ConvertToBool(flags["skip_missing"])
+	}
+
+	var items []interface{}
+	for i, element := range elements {
+		var value interface{} = element
+		found := true
+		for _, key := range strings.Split(path, ".") {
+			dict, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, found = dict[key]; !found {
+				break
+			}
+		}
+		if !found {
+			if skipMissing {
+				continue
+			}
+			return nil, fmt.Errorf("with_subelements: element %d has no key %s", i, path)
+		}
+		subelements, ok := loopList(value)
+		if !ok {
+			return nil, fmt.Errorf("with_subelements: %s of element %d is not a list", path, i)
+		}
+		for _, sub := range subelements {
+			items = append(items, []interface{}{element, sub})
+		}
+	}
+	return items, nil
+}
+
+// nestedItems returns the product of lists, each item a list with one
+// element of each
+func nestedItems(lists [][]interface{}) []interface{} {
+	if len(lists) == 0 {
+		return nil
+	}
+	items := []interface{}{[]interface{}{}}
+	for _, list := range lists {
+		var next []interface{}
+		for _, prefix := range items {
+			for _, element := range list {
+				combined := append(append([]interface{}{}, prefix.([]interface{})...), element)
+				next = append(next, combined)
+			}
+		}
+		items = next
+	}
+	return items
+}
+
+// sortedLoopKeys returns the keys of a dict in sorted order
+func sortedLoopKeys(dict map[string]interface{}) []string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestTaskRunnerLoopItems(t *testing.T) {
+	runner := NewTaskRunner()
+
+	dir := t.TempDir()
+	for _, name := range []string{"b.conf", "a.conf", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	users := []interface{}{
+		map[string]interface{}{"name": "alice", "auth": map[string]interface{}{"keys": []interface{}{"k1", "k2"}}},
+		map[string]interface{}{"name": "bob"},
+	}
+	vars := map[string]interface{}{
+		"packages": []interface{}{"nginx", "curl"},
+		"ports":    map[string]interface{}{"https": 443, "http": 80},
+		"users":    users,
+		"conf_dir": dir,
+	}
+
+	tests := []struct {
+		name     string
+		task     types.Task
+		expected []interface{}
+	}{
+		{
+			name:     "loop template",
+			task:     types.Task{Loop: "{{ packages | reverse | list }}"},
+			expected: []interface{}{"curl", "nginx"},
+		},
+		{
+			name:     "with_items variable is flattened",
+			task:     types.Task{WithItems: []interface{}{"{{ packages }}", "git"}},
+			expected: []interface{}{"nginx", "curl", "git"},
+		},
+		{
+			name: "with_dict",
+			task: types.Task{WithDict: "ports"},
+			expected: []interface{}{
+				map[string]interface{}{"key": "http", "value": 80},
+				map[string]interface{}{"key": "https", "value": 443},
+			},
+		},
+		{
+			name:     "with_fileglob skips directories",
+			task:     types.Task{WithFileglob: "{{ conf_dir }}/*.conf"},
+			expected: []interface{}{filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")},
+		},
+		{
+			name: "with_subelements",
+			task: types.Task{WithSubelements: []interface{}{"users", "auth.keys", map[string]interface{}{"skip_missing": true}}},
+			expected: []interface{}{
+				[]interface{}{users[0], "k1"},
+				[]interface{}{users[0], "k2"},
+			},
+		},
+		{
+			name: "with_nested",
+			task: types.Task{WithNested: []interface{}{[]interface{}{"web", "db"}, "{{ packages }}"}},
+			expected: []interface{}{
+				[]interface{}{"web", "nginx"},
+				[]interface{}{"web", "curl"},
+				[]interface{}{"db", "nginx"},
+				[]interface{}{"db", "curl"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := runner.loopItems(tt.task, vars)
+			if err != nil {
+				t.Fatalf("loopItems failed: %v", err)
+			}
+			if !reflect.DeepEqual(items, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, items)
+			}
+		})
+	}
+
+	items, err := runner.loopItems(types.Task{WithFileglob: []interface{}{filepath.Join(dir, "*.txt")}}, vars)
+	if err != nil || !reflect.DeepEqual(items, []interface{}{filepath.Join(dir, "notes.txt")}) {
+		t.Errorf("expected a literal fileglob pattern to match, got %v, %v", items, err)
+	}
+
+	_, err = runner.loopItems(types.Task{WithSubelements: []interface{}{"users", "auth.keys"}}, vars)
+	if err == nil || !strings.Contains(err.Error(), "element 1 has no key auth.keys") {
+		t.Errorf("expected an error for an element without the subelement key, got %v", err)
+	}
+	if _, err := runner.loopItems(types.Task{WithDict: "packages"}, vars); err == nil {
+		t.Error("expected with_dict over a list to fail")
+	}
+}
+
+func TestTaskRunnerLoopControlAndRegister(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	task := types.Task{
+		Name:     "Open ports",
+		Module:   "debug",
+		Args:     map[string]interface{}{"msg": "{{ port.key }} {{ idx }}/{{ ansible_loop.length }} next={{ ansible_loop.nextitem.key | default('none') }}"},
+		WithDict: map[string]interface{}{"http": 80, "https": 443},
+		Register: "opened",
+		LoopControl: map[string]interface{}{
+			"loop_var":  "port",
+			"index_var": "idx",
+			"label":     "{{ port.key }}:{{ port.value }}",
+			"extended":  true,
+		},
+	}
+
+	results, err := runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Data["msg"] != "http 0/2 next=https" || results[1].Data["msg"] != "https 1/2 next=none" {
+		t.Errorf("unexpected messages %v and %v", results[0].Data["msg"], results[1].Data["msg"])
+	}
+	if results[1].Data["_ansible_item_label"] != "https:443" {
+		t.Errorf("expected the item label to be rendered, got %v", results[1].Data["_ansible_item_label"])
+	}
+
+	// The loop registers the results of all its items
+	hostVars, err := runner.getHostVariables(hosts[0], nil)
+	if err != nil {
+		t.Fatalf("getHostVariables failed: %v", err)
+	}
+	opened, ok := hostVars["opened"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected the loop results to be registered, got %#v", hostVars["opened"])
+	}
+	items, _ := opened["results"].([]interface{})
+	if len(items) != 2 || opened["failed"] != false {
+		t.Fatalf("expected 2 successful item results, got %#v", opened)
+	}
+	if item := items[0].(map[string]interface{}); item["msg"] != "http 0/2 next=https" || !reflect.DeepEqual(item["port"], map[string]interface{}{"key": "http", "value": 80}) {
+		t.Errorf("unexpected first item result %#v", item)
+	}
+}
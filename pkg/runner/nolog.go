@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// Plays hand their no_log to the runner as this variable, under what tasks
+// set themselves
+const noLogVar = "_no_log"
+
+// taskNoLog reports whether a task hides its arguments and results: its
+// own no_log when set, and the play's otherwise
+func taskNoLog(task types.Task, vars map[string]interface{}) bool {
+	if task.NoLog != nil {
+		return *task.NoLog
+	}
+	noLog, _ := vars[noLogVar].(bool)
+	return noLog
+}
+
+// noLogConnection marks the stream events of a no_log task, so loggers and
+// stream servers censor them
+type noLogConnection struct {
+	types.Connection
+	stream types.StreamingConnection
+}
+
+// withNoLog returns conn marking its stream events as no_log. Connections
+// that do not stream, or tasks without no_log, return conn unchanged.
+func withNoLog(conn types.Connection, noLog bool) types.Connection {
+	stream, ok := conn.(types.StreamingConnection)
+	if !noLog || !ok {
+		return conn
+	}
+	return &noLogConnection{Connection: conn, stream: stream}
+}
+
+// GetHostname reports the wrapped connection's host name when available
+func (c *noLogConnection) GetHostname() (string, error) {
+	if provider, ok := c.Connection.(interface{ GetHostname() (string, error) }); ok {
+		return provider.GetHostname()
+	}
+	return "", errNoHostname
+}
+
+// ExecuteStream implements types.StreamingConnection
+func (c *noLogConnection) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	events, err := c.stream.ExecuteStream(ctx, command, options)
+	if err != nil {
+		return nil, err
+	}
+
+	marked := make(chan types.StreamEvent)
+	go func() {
+		defer close(marked)
+		for event := range events {
+			event.NoLog = true
+			if event.Result != nil {
+				event.Result.NoLog = true
+			}
+			select {
+			case marked <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return marked, nil
+}
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// streamStub streams a fixed set of events
+type streamStub struct {
+	types.Connection
+	events []types.StreamEvent
+}
+
+func (c *streamStub) ExecuteStream(ctx context.Context, command string, options types.ExecuteOptions) (<-chan types.StreamEvent, error) {
+	events := make(chan types.StreamEvent, len(c.events))
+	for _, event := range c.events {
+		events <- event
+	}
+	close(events)
+	return events, nil
+}
+
+func TestTaskRunnerNoLog(t *testing.T) {
+	runner := NewTaskRunner()
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	// The play's no_log marks the results, while the registered result
+	// keeps the real output
+	vars := map[string]interface{}{noLogVar: true}
+	task := types.Task{Name: "Print secret", Module: "shell", Args: map[string]interface{}{"cmd": "echo s3cret"}, Register: "out"}
+	results, err := runner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !results[0].NoLog {
+		t.Error("expected the result to be marked no_log")
+	}
+	registered, _ := runner.hostState["localhost"]["out"].(map[string]interface{})
+	if !strings.Contains(types.ConvertToString(registered["stdout"]), "s3cret") {
+		t.Errorf("expected the registered result to keep the output, got %v", registered)
+	}
+
+	// A task's own no_log overrides the play's
+	noLog := false
+	task.NoLog = &noLog
+	results, err = runner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].NoLog {
+		t.Error("expected the task's no_log to override the play's")
+	}
+}
+
+// argsProbe is a module recording the arguments it is given
+type argsProbe struct {
+	args map[string]interface{}
+}
+
+func (m *argsProbe) Name() string                               { return "nolog_args_probe" }
+func (m *argsProbe) Validate(args map[string]interface{}) error { return nil }
+func (m *argsProbe) Documentation() types.ModuleDoc             { return types.ModuleDoc{Name: m.Name()} }
+func (m *argsProbe) Run(ctx context.Context, conn types.Connection, args map[string]interface{}) (*types.Result, error) {
+	m.args = args
+	return &types.Result{Success: true}, nil
+}
+
+func TestTaskRunnerNoLogArgument(t *testing.T) {
+	runner := NewTaskRunner()
+	probe := &argsProbe{}
+	if err := runner.RegisterModule(probe); err != nil {
+		t.Fatalf("RegisterModule failed: %v", err)
+	}
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	// Modules are told about no_log, so those logging on the host do not
+	task := types.Task{Name: "Probe", Module: types.ModuleType(probe.Name())}
+	if _, err := runner.Run(context.Background(), task, hosts, map[string]interface{}{noLogVar: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if probe.args["_no_log"] != true {
+		t.Errorf("expected the module to get _no_log, got %v", probe.args["_no_log"])
+	}
+
+	if _, err := runner.Run(context.Background(), task, hosts, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := probe.args["_no_log"]; ok {
+		t.Error("expected no _no_log without no_log")
+	}
+}
+
+func TestWithNoLog(t *testing.T) {
+	stub := &streamStub{events: []types.StreamEvent{
+		{Type: types.StreamStdout, Data: "s3cret"},
+		{Type: types.StreamDone, Result: &types.Result{Success: true}},
+	}}
+	if conn := withNoLog(stub, false); conn != types.Connection(stub) {
+		t.Error("expected the connection of a task without no_log to be unchanged")
+	}
+
+	conn := withNoLog(stub, true).(types.StreamingConnection)
+	events, err := conn.ExecuteStream(context.Background(), "echo s3cret", types.ExecuteOptions{})
+	if err != nil {
+		t.Fatalf("ExecuteStream failed: %v", err)
+	}
+	count := 0
+	for event := range events {
+		count++
+		if !event.NoLog {
+			t.Errorf("expected the %s event to be marked no_log", event.Type)
+		}
+		if event.Result != nil && !event.Result.NoLog {
+			t.Error("expected the final result to be marked no_log")
+		}
+	}
+	if count != 2 {
+		t.Errorf("expected 2 events, got %d", count)
+	}
+}
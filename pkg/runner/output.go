@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// OutputLimits caps the stdout and stderr kept in task results, so commands
+// that print megabytes do not bloat memory, logs and WebSocket clients
+type OutputLimits struct {
+	MaxBytes  int    // Largest stdout or stderr kept in a result, 0 for no limit
+	HeadBytes int    // Bytes kept from the start, the rest comes from the end; defaults to half
+	SpoolDir  string // Directory the full output of truncated streams is written to
+}
+
+// outputStreams are the result data keys that hold captured output
+var outputStreams = []string{"stdout", "stderr"}
+
+// unsafeFileChars are replaced in spool file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SetOutputLimits sets the caps on captured output in task results
+func (r *TaskRunner) SetOutputLimits(limits OutputLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outputLimits = limits
+}
+
+// apply truncates oversized output streams of a result, keeping the head
+// and tail around a marker. Truncated streams get <stream>_truncated and
+// <stream>_bytes, and <stream>_file when the full output was spooled.
+func (l OutputLimits) apply(result *types.Result) error {
+	if l.MaxBytes <= 0 || result == nil || result.Data == nil {
+		return nil
+	}
+
+	for _, stream := range outputStreams {
+		output, ok := result.Data[stream].(string)
+		if !ok || len(output) <= l.MaxBytes {
+			continue
+		}
+
+		if l.SpoolDir != "" {
+			path, err := l.spool(result, stream, output)
+			if err != nil {
+				return err
+			}
+			result.Data[stream+"_file"] = path
+		}
+
+		truncated := l.truncate(output)
+		result.Data[stream] = truncated
+		result.Data[stream+"_truncated"] = true
+		result.Data[stream+"_bytes"] = len(output)
+		if _, ok := result.Data[stream+"_lines"]; ok {
+			result.Data[stream+"_lines"] = strings.Split(truncated, "\n")
+		}
+		if result.Message == output {
+			result.Message = truncated
+		}
+	}
+	return nil
+}
+
+// truncate keeps HeadBytes from the start and the rest of MaxBytes from the
+// end of the output
+func (l OutputLimits) truncate(output string) string {
+	head := l.HeadBytes
+	if head <= 0 || head > l.MaxBytes {
+		head = l.MaxBytes / 2
+	}
+	tailStart := len(output) - (l.MaxBytes - head)
+
+	// Do not split multi-byte characters
+	for head > 0 && !utf8.RuneStart(output[head]) {
+		head--
+	}
+	for tailStart < len(output) && !utf8.RuneStart(output[tailStart]) {
+		tailStart++
+	}
+
+	return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", output[:head], tailStart-head, output[tailStart:])
+}
+
+// spool writes the full output of a stream to a file in SpoolDir
+func (l OutputLimits) spool(result *types.Result, stream, output string) (string, error) {
+	if err := os.MkdirAll(l.SpoolDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create output spool directory: %w", err)
+	}
+
+	name := unsafeFileChars.ReplaceAllString(fmt.Sprintf("%s-%s-%s", result.Host, result.TaskName, stream), "_")
+	f, err := os.CreateTemp(l.SpoolDir, name+"-*.log")
+	if err != nil {
+		return "", fmt.Errorf("failed to spool %s: %w", stream, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(output); err != nil {
+		return "", fmt.Errorf("failed to spool %s: %w", stream, err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestOutputLimitsApply(t *testing.T) {
+	output := strings.Repeat("a", 40) + strings.Repeat("b", 100) + strings.Repeat("c", 60)
+	result := &types.Result{
+		Host:     "web1",
+		TaskName: "noisy task",
+		Message:  output,
+		Data: map[string]interface{}{
+			"stdout":       output,
+			"stdout_lines": []string{output},
+			"stderr":       "short",
+		},
+	}
+
+	limits := OutputLimits{MaxBytes: 100, HeadBytes: 40, SpoolDir: t.TempDir()}
+	if err := limits.apply(result); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	expected := strings.Repeat("a", 40) + "\n... [100 bytes truncated] ...\n" + strings.Repeat("c", 60)
+	if result.Data["stdout"] != expected {
+		t.Errorf("expected head and tail around a marker, got %q", result.Data["stdout"])
+	}
+	if result.Message != expected {
+		t.Errorf("expected the message to be truncated too, got %q", result.Message)
+	}
+	if result.Data["stdout_truncated"] != true || result.Data["stdout_bytes"] != 200 {
+		t.Errorf("expected truncation details, got %v", result.Data)
+	}
+	if lines := result.Data["stdout_lines"].([]string); len(lines) != 3 {
+		t.Errorf("expected stdout_lines to follow the truncated output, got %v", lines)
+	}
+
+	spooled, err := os.ReadFile(result.Data["stdout_file"].(string))
+	if err != nil || string(spooled) != output {
+		t.Errorf("expected the full output to be spooled, got %v", err)
+	}
+	if !strings.HasPrefix(result.Data["stdout_file"].(string), limits.SpoolDir+"/web1-noisy_task-stdout-") {
+		t.Errorf("expected a spool file named after the host and task, got %s", result.Data["stdout_file"])
+	}
+
+	// Streams within the limit are untouched
+	if result.Data["stderr"] != "short" || result.Data["stderr_truncated"] != nil {
+		t.Errorf("expected stderr to be kept, got %v", result.Data)
+	}
+}
+
+func TestOutputLimitsMultiByte(t *testing.T) {
+	output := strings.Repeat("é", 50)
+	truncated := OutputLimits{MaxBytes: 21}.truncate(output)
+
+	head, tail, _ := strings.Cut(truncated, "\n... [")
+	_, tail, _ = strings.Cut(tail, "] ...\n")
+	if !strings.HasPrefix(output, head) || !strings.HasSuffix(output, tail) {
+		t.Errorf("expected characters to stay whole, got %q", truncated)
+	}
+}
+
+func TestTaskRunnerOutputLimits(t *testing.T) {
+	runner := NewTaskRunner()
+	runner.SetOutputLimits(OutputLimits{MaxBytes: 64})
+
+	task := types.Task{
+		Name:   "noisy",
+		Module: "command",
+		Args:   map[string]interface{}{"cmd": "seq 1 1000"},
+	}
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+
+	results, err := runner.Run(context.Background(), task, hosts, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	stdout, _ := results[0].Data["stdout"].(string)
+	if results[0].Data["stdout_truncated"] != true || !strings.HasPrefix(stdout, "1\n2\n") || !strings.HasSuffix(strings.TrimSpace(stdout), "1000") {
+		t.Errorf("expected truncated command output, got %q", stdout)
+	}
+}
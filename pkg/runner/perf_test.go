@@ -0,0 +1,234 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vars"
+)
+
+// simConnection simulates a remote host: commands succeed after a fixed
+// latency, standing in for the network round trip
+type simConnection struct {
+	latency   time.Duration
+	connected bool
+	commands  *int64
+}
+
+func (c *simConnection) Connect(ctx context.Context, info types.ConnectionInfo) error {
+	c.connected = true
+	return nil
+}
+
+func (c *simConnection) Execute(ctx context.Context, command string, options types.ExecuteOptions) (*types.Result, error) {
+	atomic.AddInt64(c.commands, 1)
+	if c.latency > 0 {
+		select {
+		case <-time.After(c.latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &types.Result{
+		Success: true,
+		Message: "ok",
+		Data:    map[string]interface{}{"stdout": "ok", "stderr": "", "exit_code": 0},
+	}, nil
+}
+
+func (c *simConnection) Copy(ctx context.Context, src io.Reader, dest string, mode int) error {
+	return nil
+}
+
+func (c *simConnection) Fetch(ctx context.Context, src string) (io.Reader, error) {
+	return bytes.NewReader(nil), nil
+}
+
+func (c *simConnection) Close() error {
+	c.connected = false
+	return nil
+}
+
+func (c *simConnection) IsConnected() bool {
+	return c.connected
+}
+
+// simFleet is a simulated inventory of hosts behind simConnections
+type simFleet struct {
+	runner   *TaskRunner
+	hosts    []types.Host
+	commands int64
+}
+
+func newSimFleet(size int, latency time.Duration, concurrency int) *simFleet {
+	fleet := &simFleet{}
+
+	connections := connection.NewConnectionManager()
+	connections.RegisterPlugin("sim", func() types.Connection {
+		return &simConnection{latency: latency, commands: &fleet.commands}
+	})
+	fleet.runner = NewTaskRunnerWithDependencies(modules.DefaultModuleRegistry, connections, vars.NewVarManager())
+	fleet.runner.SetMaxConcurrency(concurrency)
+	// Count only the task's own commands
+	fleet.runner.SetInterpreterDiscovery(false)
+
+	for i := 0; i < size; i++ {
+		fleet.hosts = append(fleet.hosts, types.Host{
+			Name:      fmt.Sprintf("host%04d", i),
+			Address:   fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			Variables: map[string]interface{}{"ansible_connection": "sim"},
+		})
+	}
+	return fleet
+}
+
+func (f *simFleet) run(tb testing.TB, task types.Task) {
+	results, err := f.runner.Run(context.Background(), task, f.hosts, nil)
+	if err != nil {
+		tb.Fatalf("Run failed: %v", err)
+	}
+	if len(results) != len(f.hosts) {
+		tb.Fatalf("expected %d results, got %d", len(f.hosts), len(results))
+	}
+}
+
+var simTask = types.Task{
+	Name:   "uptime",
+	Module: "command",
+	Args:   map[string]interface{}{"cmd": "uptime"},
+}
+
+// TestRunnerPerformanceThresholds guards large-fleet performance: hosts must
+// run in parallel up to the concurrency limit, and per-host overhead must
+// stay bounded. The bounds are loose enough for slow CI machines but catch
+// accidental serialization or per-host leaks.
+func TestRunnerPerformanceThresholds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping performance thresholds in short mode")
+	}
+
+	const (
+		hosts       = 500
+		concurrency = 50
+		latency     = 5 * time.Millisecond
+	)
+	fleet := newSimFleet(hosts, latency, concurrency)
+	fleet.run(t, simTask) // Warm up connections
+
+	start := time.Now()
+	fleet.run(t, simTask)
+	elapsed := time.Since(start)
+	if commands := atomic.LoadInt64(&fleet.commands); commands != 2*hosts {
+		t.Fatalf("expected every host to run the task twice, got %d commands", commands)
+	}
+
+	// Perfect scheduling takes hosts/concurrency rounds of the latency
+	ideal := time.Duration(hosts/concurrency) * latency
+	if limit := 10 * ideal; elapsed > limit {
+		t.Errorf("running %d hosts took %s, over the %s limit (ideal %s): hosts are not running in parallel", hosts, elapsed, limit, ideal)
+	}
+
+	// Memory allocated per host for one task
+	fleet = newSimFleet(hosts, 0, concurrency)
+	fleet.run(t, simTask)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	fleet.run(t, simTask)
+	runtime.ReadMemStats(&after)
+
+	const memoryLimit = 256 << 10
+	if perHost := (after.TotalAlloc - before.TotalAlloc) / hosts; perHost > memoryLimit {
+		t.Errorf("one task allocated %d bytes per host, over the %d byte limit", perHost, memoryLimit)
+	}
+}
+
+func BenchmarkRunnerRun(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("hosts=%d", size), func(b *testing.B) {
+			fleet := newSimFleet(size, 0, 50)
+			fleet.run(b, simTask)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				fleet.run(b, simTask)
+			}
+			elapsed := time.Since(start)
+
+			b.ReportMetric(float64(size*b.N)/elapsed.Seconds(), "tasks/s")
+		})
+	}
+}
+
+func BenchmarkRunnerMemoryPerHost(b *testing.B) {
+	const size = 1000
+	fleet := newSimFleet(size, 0, 50)
+	fleet.run(b, simTask)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fleet.run(b, simTask)
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(size*b.N), "B/host")
+}
+
+// BenchmarkRunnerSchedulingOverhead measures what the runner adds on top
+// of the simulated host latency when the fleet exceeds the concurrency limit
+func BenchmarkRunnerSchedulingOverhead(b *testing.B) {
+	const (
+		size        = 200
+		concurrency = 20
+		latency     = time.Millisecond
+	)
+	fleet := newSimFleet(size, latency, concurrency)
+	fleet.run(b, simTask)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		fleet.run(b, simTask)
+	}
+	elapsed := time.Since(start)
+
+	ideal := time.Duration(size/concurrency) * latency
+	b.ReportMetric(float64(elapsed/time.Duration(b.N)-ideal)/float64(time.Microsecond), "overhead-us/op")
+}
+
+func BenchmarkHostSchedulerAcquire(b *testing.B) {
+	scheduler := newHostScheduler()
+	ctx := context.Background()
+	hosts := make([]string, 100)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d", i)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			release, err := scheduler.acquire(ctx, hosts[i%len(hosts)], types.ConcurrencyClassPackageManager)
+			if err != nil {
+				b.Fatal(err)
+			}
+			release()
+			i++
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// preflightConcurrency bounds the probes in flight. Probes only open a
+// socket, so they run far wider than tasks.
+const preflightConcurrency = 64
+
+// Preflight checks that every host's connection endpoint answers before any
+// task runs, probing the hosts in parallel. It returns a failed result,
+// marked unreachable, for each host that did not answer within the timeout.
+func (r *TaskRunner) Preflight(ctx context.Context, hosts []types.Host, timeout time.Duration) []types.Result {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var unreachable []types.Result
+	sem := make(chan struct{}, preflightConcurrency)
+
+	for _, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			startTime := time.Now()
+			info, err := connectionInfo(host)
+			probe := connection.ProbeResult{Err: err}
+			if err == nil {
+				probe = connection.Probe(ctx, info, timeout)
+			}
+			if probe.Reachable() {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			unreachable = append(unreachable, types.Result{
+				Host:       host.Name,
+				Success:    false,
+				Error:      types.NewConnectionError(host.Name, "host unreachable", probe.Err),
+				Message:    fmt.Sprintf("Host unreachable: %v", probe.Err),
+				TaskName:   "Preflight",
+				ModuleName: "preflight",
+				StartTime:  startTime,
+				EndTime:    time.Now(),
+				Duration:   time.Since(startTime),
+				Data: map[string]interface{}{
+					"unreachable": true,
+					"endpoint":    probe.Endpoint,
+				},
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Report hosts in inventory order whatever order the probes finished in
+	order := make(map[string]int, len(hosts))
+	for i, host := range hosts {
+		order[host.Name] = i
+	}
+	sort.Slice(unreachable, func(i, j int) bool {
+		return order[unreachable[i].Host] < order[unreachable[j].Host]
+	})
+	return unreachable
+}
@@ -0,0 +1,210 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// QueryOptions selects what a fleet query reports of each host's result
+type QueryOptions struct {
+	Fields  []string // Dotted paths into the result data reported per host, e.g. ansible_facts.ansible_distribution
+	GroupBy string   // Dotted path whose distinct values the hosts are grouped by
+}
+
+// QueryRow is the answer of one host to a fleet query
+type QueryRow struct {
+	Host   string                 `json:"host"`
+	Values map[string]interface{} `json:"values,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// QueryGroup is the hosts that answered a fleet query with the same value
+type QueryGroup struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+	Hosts []string    `json:"hosts"`
+}
+
+// QueryReport aggregates the results of a read-only module run across
+// many hosts into one document
+type QueryReport struct {
+	Fields   []string     `json:"fields,omitempty"`
+	GroupBy  string       `json:"group_by,omitempty"`
+	Hosts    int          `json:"hosts"`
+	Failed   int          `json:"failed"`
+	Distinct int          `json:"distinct"`
+	Groups   []QueryGroup `json:"groups,omitempty"`
+	Rows     []QueryRow   `json:"rows"`
+}
+
+// AggregateQuery builds the report of a fleet query from the results of
+// its hosts. Rows are sorted by host; groups by descending count, so the
+// most common value comes first. Failed hosts are reported but not grouped.
+func AggregateQuery(results []types.Result, opts QueryOptions) *QueryReport {
+	report := &QueryReport{Fields: opts.Fields, GroupBy: opts.GroupBy, Hosts: len(results)}
+
+	groups := make(map[string]*QueryGroup)
+	for _, result := range results {
+		row := QueryRow{Host: result.Host}
+		if !result.Success {
+			report.Failed++
+			row.Error = result.Message
+			if result.Error != nil {
+				row.Error = result.Error.Error()
+			}
+			if row.Error == "" {
+				row.Error = "failed"
+			}
+			report.Rows = append(report.Rows, row)
+			continue
+		}
+
+		if len(opts.Fields) > 0 {
+			row.Values = make(map[string]interface{}, len(opts.Fields))
+			for _, field := range opts.Fields {
+				row.Values[field], _ = LookupResultPath(result, field)
+			}
+		}
+		report.Rows = append(report.Rows, row)
+
+		if opts.GroupBy == "" {
+			continue
+		}
+		value, _ := LookupResultPath(result, opts.GroupBy)
+		key := formatQueryValue(value)
+		group, ok := groups[key]
+		if !ok {
+			group = &QueryGroup{Value: value}
+			groups[key] = group
+		}
+		group.Count++
+		group.Hosts = append(group.Hosts, result.Host)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Host < report.Rows[j].Host })
+
+	for _, group := range groups {
+		sort.Strings(group.Hosts)
+		report.Groups = append(report.Groups, *group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Count != report.Groups[j].Count {
+			return report.Groups[i].Count > report.Groups[j].Count
+		}
+		return formatQueryValue(report.Groups[i].Value) < formatQueryValue(report.Groups[j].Value)
+	})
+	report.Distinct = len(report.Groups)
+
+	return report
+}
+
+// LookupResultPath resolves a dotted path into the data of a result, with
+// numeric segments indexing lists. "msg" falls back to the result message.
+func LookupResultPath(result types.Result, path string) (interface{}, bool) {
+	var current interface{} = result.Data
+	for _, segment := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, ok := value[segment]
+			if !ok {
+				if path == "msg" {
+					return result.Message, true
+				}
+				return nil, false
+			}
+			current = next
+		case map[string]string:
+			next, ok := value[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			current = value[index]
+		case []string:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			current = value[index]
+		default:
+			if path == "msg" {
+				return result.Message, true
+			}
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// formatQueryValue renders a value on one table cell: strings are trimmed
+// with their line breaks escaped, anything else is written as JSON
+func formatQueryValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		return strings.ReplaceAll(strings.TrimSpace(v), "\n", `\n`)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// WriteTable writes the report as aligned text: the groups when the query
+// groups hosts, otherwise one row per host, followed by a summary line
+func (r *QueryReport) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if r.GroupBy != "" {
+		fmt.Fprintf(table, "%s\tCOUNT\tHOSTS\n", strings.ToUpper(r.GroupBy))
+		for _, group := range r.Groups {
+			fmt.Fprintf(table, "%s\t%d\t%s\n", formatQueryValue(group.Value), group.Count, strings.Join(group.Hosts, ","))
+		}
+	} else {
+		header := []string{"HOST"}
+		for _, field := range r.Fields {
+			header = append(header, strings.ToUpper(field))
+		}
+		fmt.Fprintln(table, strings.Join(header, "\t"))
+		for _, row := range r.Rows {
+			if row.Error != "" {
+				continue
+			}
+			cells := []string{row.Host}
+			for _, field := range r.Fields {
+				cells = append(cells, formatQueryValue(row.Values[field]))
+			}
+			fmt.Fprintln(table, strings.Join(cells, "\t"))
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	for _, row := range r.Rows {
+		if row.Error != "" {
+			fmt.Fprintf(w, "FAILED %s: %s\n", row.Host, formatQueryValue(row.Error))
+		}
+	}
+
+	summary := fmt.Sprintf("%d hosts, %d failed", r.Hosts, r.Failed)
+	if r.GroupBy != "" {
+		summary += fmt.Sprintf(", %d distinct values", r.Distinct)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestAggregateQuery(t *testing.T) {
+	facts := func(host, version string) types.Result {
+		return types.Result{Host: host, Success: true, Data: map[string]interface{}{
+			"ansible_facts": map[string]interface{}{"openssl_version": version, "ipv4": []interface{}{"10.0.0." + host[len(host)-1:]}},
+		}}
+	}
+	results := []types.Result{
+		facts("web3", "3.0.13"),
+		facts("web1", "3.0.2"),
+		facts("db1", "3.0.13"),
+		{Host: "web2", Success: false, Error: errors.New("connection refused")},
+		{Host: "db2", Success: true, Data: map[string]interface{}{}},
+	}
+
+	report := AggregateQuery(results, QueryOptions{
+		Fields:  []string{"ansible_facts.ipv4.0"},
+		GroupBy: "ansible_facts.openssl_version",
+	})
+
+	if report.Hosts != 5 || report.Failed != 1 || report.Distinct != 3 {
+		t.Fatalf("unexpected counts %+v", report)
+	}
+	if report.Groups[0].Value != "3.0.13" || report.Groups[0].Count != 2 || strings.Join(report.Groups[0].Hosts, ",") != "db1,web3" {
+		t.Errorf("expected the most common version first, got %+v", report.Groups[0])
+	}
+	if report.Groups[1].Value != nil || report.Groups[1].Hosts[0] != "db2" {
+		t.Errorf("expected hosts without the value grouped together, got %+v", report.Groups[1])
+	}
+	if report.Rows[0].Host != "db1" || report.Rows[0].Values["ansible_facts.ipv4.0"] != "10.0.0.1" {
+		t.Errorf("expected rows sorted by host with their fields, got %+v", report.Rows[0])
+	}
+	if report.Rows[3].Host != "web2" || report.Rows[3].Error != "connection refused" {
+		t.Errorf("expected the failed host reported, got %+v", report.Rows[3])
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteTable(&out); err != nil {
+		t.Fatalf("WriteTable failed: %v", err)
+	}
+	expected := `ANSIBLE_FACTS.OPENSSL_VERSION  COUNT  HOSTS
+3.0.13                         2      db1,web3
+-                              1      db2
+3.0.2                          1      web1
+FAILED web2: connection refused
+5 hosts, 1 failed, 3 distinct values
+`
+	if out.String() != expected {
+		t.Errorf("unexpected table:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestLookupResultPath(t *testing.T) {
+	result := types.Result{Message: "pong", Data: map[string]interface{}{
+		"stdout": "OpenSSL 3.0.13\n",
+		"items":  []string{"a", "b"},
+	}}
+
+	tests := []struct {
+		path  string
+		value interface{}
+		found bool
+	}{
+		{"stdout", "OpenSSL 3.0.13\n", true},
+		{"items.1", "b", true},
+		{"items.2", nil, false},
+		{"stdout.length", nil, false},
+		{"msg", "pong", true},
+		{"missing", nil, false},
+	}
+	for _, test := range tests {
+		value, found := LookupResultPath(result, test.path)
+		if value != test.value || found != test.found {
+			t.Errorf("LookupResultPath(%q) = %v, %v; want %v, %v", test.path, value, found, test.value, test.found)
+		}
+	}
+}
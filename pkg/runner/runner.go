@@ -4,28 +4,52 @@ package runner
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/liliang-cn/gosible/pkg/become"
+	"github.com/liliang-cn/gosible/pkg/callback"
 	"github.com/liliang-cn/gosible/pkg/types"
 	"github.com/liliang-cn/gosible/pkg/connection"
 	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/template"
 	"github.com/liliang-cn/gosible/pkg/vars"
+	"github.com/liliang-cn/gosible/pkg/vault"
 )
 
+// defaultUntilRetries is how often a task with until but no retries is
+// retried, as in Ansible
+const defaultUntilRetries = 3
+
 // TaskRunner implements the Runner interface with parallel execution support
 type TaskRunner struct {
 	maxConcurrency int
 	moduleRegistry *modules.ModuleRegistry
 	connectionMgr  *connection.ConnectionManager
 	varManager     *vars.VarManager
+	vaultManager   *vault.Manager // Decrypts vaulted become passwords
 	handlerManager *HandlerManager
+	scheduler      *hostScheduler // Serializes conflicting modules per host
 	mu             sync.RWMutex
 	connections    map[string]types.Connection
+	hostState      map[string]map[string]interface{}
 	connectionTTL  time.Duration
 	tags           []string // Tags to filter task execution
+	skipTags       []string // Tags of tasks to skip
+	outputLimits   OutputLimits
+	callbacks      *callback.CallbackManager // Receives task starts and results
+	bundles        *bundleCollector          // Collects support bundles on failure
+	discovery      bool                      // Probe hosts for their interpreters
+	discoveries    map[string]*hostDiscovery // Interpreter facts by host name
+	templates      *template.Engine          // Renders task arguments
+	templateArgs   bool                      // Render task arguments as templates
+	strictVars     bool                      // Fail on undefined variables in arguments
+	inventory      types.Inventory           // Resolves delegate_to hosts
+	hashBehaviour  vars.HashBehaviour        // Replaces or merges maps across precedence levels
 }
 
 // NewTaskRunner creates a new task runner
@@ -36,9 +60,16 @@ func NewTaskRunner() *TaskRunner {
 		connectionMgr:  connection.DefaultConnectionManager,
 		varManager:     vars.NewVarManager(),
 		handlerManager: NewHandlerManager(),
+		scheduler:      newHostScheduler(),
 		connections:    make(map[string]types.Connection),
 		connectionTTL:  30 * time.Minute,
 		tags:           []string{},
+		discovery:      true,
+		discoveries:    make(map[string]*hostDiscovery),
+		templates:      template.NewEngine(),
+		templateArgs:   true,
+		hostState:      make(map[string]map[string]interface{}),
+		hashBehaviour:  vars.HashReplace,
 	}
 }
 
@@ -50,9 +81,16 @@ func NewTaskRunnerWithDependencies(moduleRegistry *modules.ModuleRegistry, conne
 		connectionMgr:  connectionMgr,
 		varManager:     varMgr,
 		handlerManager: NewHandlerManager(),
+		scheduler:      newHostScheduler(),
 		connections:    make(map[string]types.Connection),
 		connectionTTL:  30 * time.Minute,
 		tags:           []string{},
+		discovery:      true,
+		discoveries:    make(map[string]*hostDiscovery),
+		templates:      template.NewEngine(),
+		templateArgs:   true,
+		hostState:      make(map[string]map[string]interface{}),
+		hashBehaviour:  vars.HashReplace,
 	}
 }
 
@@ -71,44 +109,43 @@ func (r *TaskRunner) SetTags(tags []string) {
 	r.tags = tags
 }
 
-// GetHandlerManager returns the handler manager
-func (r *TaskRunner) GetHandlerManager() *HandlerManager {
-	return r.handlerManager
+// SetCallbacks makes the runner report the start of each task and its
+// result on every host to the callback plugins of the manager. The playbook
+// executor reports its tasks itself, so a runner used by an executor with
+// callbacks should not have them too.
+func (r *TaskRunner) SetCallbacks(callbacks *callback.CallbackManager) {
+	r.callbacks = callbacks
 }
 
-// shouldRunTask checks if a task should run based on tags
-func (r *TaskRunner) shouldRunTask(task types.Task) bool {
-	// If no tags are set, run all tasks
-	if len(r.tags) == 0 {
-		return true
+// SetPrompter lets pause tasks announce themselves and read the operator's
+// answers through prompter
+func (r *TaskRunner) SetPrompter(prompter modules.Prompter) {
+	module, err := r.moduleRegistry.GetModule(types.TypePause.String())
+	if err != nil {
+		return
 	}
-
-	// If task has no tags, skip it when tags are specified
-	if len(task.Tags) == 0 {
-		// Unless 'always' or 'all' tag is specified
-		for _, tag := range r.tags {
-			if tag == "always" || tag == "all" {
-				return true
-			}
-		}
-		return false
+	if pause, ok := module.(interface{ SetPrompter(modules.Prompter) }); ok {
+		pause.SetPrompter(prompter)
 	}
+}
 
-	// Check if any task tag matches runner tags
-	for _, taskTag := range task.Tags {
-		// Always run tasks with 'always' tag
-		if taskTag == "always" {
-			return true
-		}
+// GetHandlerManager returns the handler manager
+func (r *TaskRunner) GetHandlerManager() *HandlerManager {
+	return r.handlerManager
+}
 
-		for _, runnerTag := range r.tags {
-			if taskTag == runnerTag {
-				return true
-			}
-		}
-	}
+// SetSkipTags sets the tags of tasks to skip, like --skip-tags
+func (r *TaskRunner) SetSkipTags(tags []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipTags = tags
+}
 
-	return false
+// shouldRunTask checks if a task should run based on tags
+func (r *TaskRunner) shouldRunTask(task types.Task) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return types.ShouldRunTags(task.Tags, r.tags, r.skipTags)
 }
 
 // RegisterModule registers a module for use
@@ -127,6 +164,66 @@ func (r *TaskRunner) Run(ctx context.Context, task types.Task, hosts []types.Hos
 		return []types.Result{}, nil
 	}
 
+	// Results of no_log tasks are marked for callbacks and loggers to
+	// censor, while registered variables keep the real values
+	noLog := taskNoLog(task, vars)
+	task.NoLog = &noLog
+	if r.callbacks == nil {
+		results, err := r.run(ctx, task, hosts, vars)
+		return markNoLog(results, noLog), err
+	}
+
+	r.callbacks.OnTaskStart(&task, hosts)
+	results, err := r.run(ctx, task, hosts, vars)
+	results = markNoLog(results, noLog)
+	reported := results
+	if err != nil && len(results) == 0 {
+		reported = markNoLog(failedResults(task, hosts, err), noLog)
+	}
+	for i := range reported {
+		r.callbacks.OnTaskResult(&task, &reported[i])
+	}
+	return results, err
+}
+
+// markNoLog marks the results of a no_log task
+func markNoLog(results []types.Result, noLog bool) []types.Result {
+	if noLog {
+		for i := range results {
+			results[i].NoLog = true
+		}
+	}
+	return results
+}
+
+// failedResults gives every host a failed result for a task that could not
+// run at all, so callbacks still see an outcome per host
+func failedResults(task types.Task, hosts []types.Host, err error) []types.Result {
+	results := make([]types.Result, len(hosts))
+	for i, host := range hosts {
+		results[i] = types.Result{
+			Host:       host.Name,
+			Success:    false,
+			Error:      err,
+			Message:    err.Error(),
+			TaskName:   task.Name,
+			ModuleName: task.Module.String(),
+			StartTime:  types.GetCurrentTime(),
+			EndTime:    types.GetCurrentTime(),
+			Data:       make(map[string]interface{}),
+		}
+	}
+	return results
+}
+
+// run executes the task, applying its run_once, tags, condition and loop
+func (r *TaskRunner) run(ctx context.Context, task types.Task, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
+	// A run_once task runs on the first host for all of them, and so does
+	// a pause, which holds up every host at once
+	if (task.RunOnce || task.Module == types.TypePause) && len(hosts) > 1 {
+		return r.runOnce(ctx, task, hosts, vars)
+	}
+
 	// Check if task should be skipped based on tags
 	if !r.shouldRunTask(task) {
 		// Skip task due to tags
@@ -147,6 +244,8 @@ func (r *TaskRunner) Run(ctx context.Context, task types.Task, hosts []types.Hos
 		return results, nil
 	}
 
+	allHosts := hosts
+
 	// Merge task vars with provided vars
 	mergedVars := make(map[string]interface{})
 	for k, v := range vars {
@@ -158,31 +257,41 @@ func (r *TaskRunner) Run(ctx context.Context, task types.Task, hosts []types.Hos
 		}
 	}
 
-	// Evaluate when condition
+	// Evaluate the when condition on each host, with its facts and
+	// registered results
+	var skipped []types.Result
 	if task.When != nil {
-		evaluator := NewConditionEvaluator(mergedVars)
-		shouldRun, err := evaluator.EvaluateWhen(task.When)
-		if err != nil {
-			return nil, fmt.Errorf("failed to evaluate when condition: %w", err)
-		}
-		if !shouldRun {
-			// Skip task - return success results with skipped flag
-			results := make([]types.Result, len(hosts))
-			for i, host := range hosts {
-				results[i] = types.Result{
-					Host:       host.Name,
-					Success:    true,
-					Changed:    false,
-					Message:    "Skipped due to when condition",
-					Data:       map[string]interface{}{"skipped": true},
-					TaskName:   task.Name,
-					ModuleName: task.Module.String(),
-					StartTime:  types.GetCurrentTime(),
-					EndTime:    types.GetCurrentTime(),
-				}
+		var runHosts []types.Host
+		for _, host := range hosts {
+			hostVars, err := r.getHostVariables(host, mergedVars)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get host variables: %w", err)
+			}
+			shouldRun, err := NewConditionEvaluator(hostVars).EvaluateWhen(task.When)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate when condition on host %s: %w", host.Name, err)
+			}
+			if shouldRun {
+				runHosts = append(runHosts, host)
+				continue
 			}
-			return results, nil
+			// Skip task - return success results with skipped flag
+			skipped = append(skipped, types.Result{
+				Host:       host.Name,
+				Success:    true,
+				Changed:    false,
+				Message:    "Skipped due to when condition",
+				Data:       map[string]interface{}{"skipped": true},
+				TaskName:   task.Name,
+				ModuleName: task.Module.String(),
+				StartTime:  types.GetCurrentTime(),
+				EndTime:    types.GetCurrentTime(),
+			})
+		}
+		if len(runHosts) == 0 {
+			return skipped, nil
 		}
+		hosts = runHosts
 	}
 
 	// Get the module
@@ -191,20 +300,24 @@ func (r *TaskRunner) Run(ctx context.Context, task types.Task, hosts []types.Hos
 		return nil, fmt.Errorf("module %s not found: %w", task.Module, err)
 	}
 
-	// Validate module arguments
-	if err := module.Validate(task.Args); err != nil {
+	// Validate module arguments, which may come from module_defaults
+	if err := module.Validate(withModuleDefaults(task, mergedVars)); err != nil {
 		return nil, fmt.Errorf("module validation failed: %w", err)
 	}
 
 	// Handle loops
 	var results []types.Result
-	if task.Loop != nil || task.WithItems != nil {
+	if hasLoop(task) {
 		results, err = r.executeWithLoop(ctx, task, module, hosts, mergedVars)
 	} else {
 		// Execute task on all hosts with controlled concurrency
 		results, err = r.executeOnHosts(ctx, task, module, hosts, mergedVars)
 	}
 
+	if len(skipped) > 0 {
+		results = inHostOrder(append(results, skipped...), allHosts)
+	}
+
 	if err != nil {
 		return results, err
 	}
@@ -228,6 +341,19 @@ func (r *TaskRunner) Run(ctx context.Context, task types.Task, hosts []types.Hos
 	return results, nil
 }
 
+// inHostOrder sorts results into the order of their hosts, keeping the
+// order of a host's own results
+func inHostOrder(results []types.Result, hosts []types.Host) []types.Result {
+	position := make(map[string]int, len(hosts))
+	for i, host := range hosts {
+		position[host.Name] = i
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return position[results[i].Host] < position[results[j].Host]
+	})
+	return results
+}
+
 // executeOnHosts executes a task on multiple hosts with parallel execution
 func (r *TaskRunner) executeOnHosts(ctx context.Context, task types.Task, module types.Module, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
 	results := make([]types.Result, len(hosts))
@@ -265,6 +391,18 @@ func (r *TaskRunner) executeOnHosts(ctx context.Context, task types.Task, module
 				}
 			}
 
+			// Collect what is needed to debug the failure while it is fresh
+			if bundles := r.supportBundles(); bundles != nil && !result.Success && !task.IgnoreErrors {
+				if result.Data == nil {
+					result.Data = make(map[string]interface{})
+				}
+				if path, err := r.collectSupportBundle(ctx, bundles, task, host, vars, result); err != nil {
+					result.Data["support_bundle_error"] = err.Error()
+				} else {
+					result.Data["support_bundle"] = path
+				}
+			}
+
 			results[i] = *result
 			return nil
 		})
@@ -280,18 +418,25 @@ func (r *TaskRunner) executeOnHosts(ctx context.Context, task types.Task, module
 
 // executeOnHost executes a task on a single host
 func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module types.Module, host types.Host, vars map[string]interface{}) (*types.Result, error) {
-	// Get or create connection to host
-	conn, err := r.getConnection(ctx, host)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to host %s: %w", host.Name, err)
-	}
-
 	// Merge host variables with task variables
 	hostVars, err := r.getHostVariables(host, vars)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get host variables: %w", err)
 	}
 
+	// A delegated task runs on the delegate's connection with the
+	// variables of the host
+	target, err := r.delegateHost(task, host, hostVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve delegate_to for host %s: %w", host.Name, err)
+	}
+
+	// Get or create connection to host
+	conn, err := r.getConnection(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to host %s: %w", target.Name, err)
+	}
+
 	// Set environment variables if specified
 	if task.Environment != nil {
 		for k, v := range task.Environment {
@@ -299,8 +444,36 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 		}
 	}
 
-	// Expand variables in task arguments
-	expandedArgs := r.expandTaskArguments(task.Args, hostVars)
+	// Fill in interpreter variables the inventory leaves unset. raw tasks
+	// skip discovery, as they must run on hosts that cannot answer it yet.
+	if task.Module != types.TypeRaw {
+		r.applyDiscovery(ctx, conn, target, hostVars)
+	}
+
+	// Set the play and task environment for every command the module runs
+	env, err := r.taskEnvironment(task, hostVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render environment for host %s: %w", host.Name, err)
+	}
+	conn = withEnvironment(conn, env)
+	conn = withNoLog(conn, task.NoLogEnabled())
+
+	// Apply privilege escalation to every command the module runs
+	becomeConfig, err := r.becomeConfig(task, hostVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure become for host %s: %w", host.Name, err)
+	}
+	conn = become.WrapConnection(conn, becomeConfig)
+	if bundles := r.supportBundles(); bundles != nil {
+		conn = bundles.record(host.Name, conn)
+	}
+
+	// Render task arguments, over the play's module_defaults, with the
+	// host's variables
+	expandedArgs, err := r.expandTaskArguments(withModuleDefaults(task, hostVars), hostVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render arguments for host %s: %w", host.Name, err)
+	}
 
 	// Create task with expanded arguments and host variables
 	expandedTask := task
@@ -319,6 +492,10 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 	} else if checkMode, exists := hostVars["ansible_check_mode"]; exists {
 		moduleArgs["_check_mode"] = checkMode
 	}
+	// A task's check_mode always simulates it, or runs it for real
+	if task.CheckMode != nil {
+		moduleArgs["_check_mode"] = *task.CheckMode
+	}
 
 	if diffMode, exists := hostVars["_diff"]; exists {
 		moduleArgs["_diff"] = diffMode
@@ -326,19 +503,46 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 		moduleArgs["_diff"] = diffMode
 	}
 
+	// State capture records the before/after state of changes for the
+	// audit trail
+	if captureState, exists := hostVars["_capture_state"]; exists {
+		moduleArgs["_capture_state"] = captureState
+	}
+
+	// Modules that log on the host, such as Ansible's, must not log the
+	// arguments of no_log tasks
+	if task.NoLog != nil && *task.NoLog {
+		moduleArgs["_no_log"] = true
+	}
+
 	// Add task variables to module args for access
 	moduleArgs["_task_vars"] = hostVars
 
-	// Handle retries if specified
-	maxRetries := 1
+	// A task with until is polled: re-run after delay seconds, up to retries
+	// times after the first attempt, until the condition holds. Retries
+	// without until re-run a failing task.
+	maxAttempts := 1
 	if task.Retries > 0 {
-		maxRetries = task.Retries + 1
+		maxAttempts = task.Retries + 1
+	} else if task.Until != nil {
+		maxAttempts = defaultUntilRetries + 1
 	}
 
 	var result *types.Result
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 && task.Delay > 0 {
-			time.Sleep(time.Duration(task.Delay) * time.Second)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 && task.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(task.Delay) * time.Second):
+			}
+		}
+
+		// Wait for modules of the same concurrency class on this host, like
+		// another package manager run, to finish
+		release, err := r.scheduler.acquire(ctx, target.Name, concurrencyClass(module))
+		if err != nil {
+			return nil, err
 		}
 
 		// Execute the module with check/diff mode support
@@ -355,9 +559,16 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 			if diffMode, ok := moduleArgs["_diff"].(bool); ok && diffMode {
 				opts.DiffMode = true
 			}
+			if captureState, ok := moduleArgs["_capture_state"].(bool); ok && captureState {
+				opts.CaptureState = true
+			}
 
-			// Validate module supports requested modes
+			// Validate module supports requested modes; modules declaring
+			// no capabilities are assumed to support neither
 			caps := capModule.Capabilities()
+			if caps == nil {
+				caps = &types.ModuleCapability{}
+			}
 			if opts.CheckMode && !caps.CheckMode {
 				// Module doesn't support check mode, skip execution
 				result = &types.Result{
@@ -384,14 +595,20 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 			// Normal module execution
 			result, err = module.Run(ctx, conn, moduleArgs)
 		}
-		if err != nil && task.IgnoreErrors {
-			// Convert error to result with success = false
+		release()
+		if err != nil && (task.IgnoreErrors || attempt < maxAttempts) {
+			// Convert error to result with success = false, which is
+			// ignored or retried
+			message := fmt.Sprintf("Error: %v", err)
+			if task.IgnoreErrors {
+				message = fmt.Sprintf("Error (ignored): %v", err)
+			}
 			result = &types.Result{
 				Host:       host.Name,
 				Success:    false,
 				Changed:    false,
 				Error:      err,
-				Message:    fmt.Sprintf("Error (ignored): %v", err),
+				Message:    message,
 				TaskName:   task.Name,
 				ModuleName: task.Module.String(),
 				StartTime:  types.GetCurrentTime(),
@@ -407,6 +624,23 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 		if result != nil {
 			result.TaskName = task.Name
 			result.Host = host.Name
+			if target.Name != host.Name {
+				if result.Data == nil {
+					result.Data = make(map[string]interface{})
+				}
+				result.Data["delegated_to"] = target.Name
+			}
+			if maxAttempts > 1 {
+				if result.Data == nil {
+					result.Data = make(map[string]interface{})
+				}
+				result.Data["attempts"] = attempt
+			}
+		}
+
+		// Conditions on the result can use the variable it registers
+		if task.Register != "" && result != nil {
+			hostVars[task.Register] = registeredResult(result)
 		}
 
 		// Evaluate changed_when condition
@@ -432,117 +666,90 @@ func (r *TaskRunner) executeOnHost(ctx context.Context, task types.Task, module
 			}
 		}
 
-		// Check until condition for retries
-		if task.Until != nil && attempt < maxRetries-1 {
+		if task.Until != nil {
+			// Poll until the condition holds; the result is also
+			// available to it as "result"
+			hostVars["result"] = registeredResult(result)
 			evaluator := NewConditionEvaluator(hostVars)
-			// Add result to vars for until evaluation
-			hostVars["result"] = result
-			success, evalErr := evaluator.EvaluateWhen(task.Until)
+			done, evalErr := evaluator.EvaluateWhen(task.Until)
 			if evalErr != nil {
 				return nil, fmt.Errorf("failed to evaluate until condition: %w", evalErr)
 			}
-			if success {
-				break // Condition met, stop retrying
+			if done {
+				break
+			}
+			if attempt == maxAttempts {
+				result.Success = false
+				if !task.IgnoreErrors {
+					result.Error = fmt.Errorf("until condition not met after %d attempts", attempt)
+				}
 			}
-			// Continue to next retry
-		} else if !result.Success && attempt < maxRetries-1 {
-			// Retry on failure if retries are configured
 			continue
-		} else {
+		}
+		if result.Success {
 			break
 		}
 	}
 
+	// Cap captured output once the conditions above have seen all of it
+	r.mu.RLock()
+	limits := r.outputLimits
+	r.mu.RUnlock()
+	if err := limits.apply(result); err != nil {
+		return nil, err
+	}
+
 	// Register result if specified
 	if task.Register != "" && r.varManager != nil {
 		r.varManager.SetVar(task.Register, result)
 	}
+	factsHost := host.Name
+	if task.DelegateFacts {
+		factsHost = target.Name
+	}
+	r.recordHostVars(host.Name, factsHost, task, result)
 
 	return result, nil
 }
 
-// executeWithLoop executes a task with loop iterations
-func (r *TaskRunner) executeWithLoop(ctx context.Context, task types.Task, module types.Module, hosts []types.Host, vars map[string]interface{}) ([]types.Result, error) {
-	// Determine loop items
-	var loopItems interface{}
-	if task.Loop != nil {
-		loopItems = task.Loop
-	} else if task.WithItems != nil {
-		loopItems = task.WithItems
+// getConnection gets or creates a connection to a host
+func (r *TaskRunner) getConnection(ctx context.Context, host types.Host) (types.Connection, error) {
+	r.mu.RLock()
+	if conn, exists := r.connections[host.Name]; exists && conn.IsConnected() {
+		r.mu.RUnlock()
+		return conn, nil
 	}
+	r.mu.RUnlock()
 
-	// Evaluate loop items
-	evaluator := NewConditionEvaluator(vars)
-	items, err := evaluator.EvaluateLoopItems(loopItems)
+	connInfo, err := connectionInfo(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to evaluate loop items: %w", err)
-	}
-
-	if len(items) == 0 {
-		return []types.Result{}, nil
+		return nil, err
 	}
 
-	// Collect all results
-	var allResults []types.Result
-
-	// Get loop control settings
-	var loopVar string = "item"
-	var indexVar string = ""
-	if task.LoopControl != nil {
-		if lv, ok := task.LoopControl["loop_var"].(string); ok {
-			loopVar = lv
-		}
-		if iv, ok := task.LoopControl["index_var"].(string); ok {
-			indexVar = iv
-		}
+	// Create connection
+	conn, err := r.connectionMgr.GetConnection(ctx, connInfo)
+	if err != nil {
+		return nil, err
 	}
 
-	// Execute task for each item
-	for index, item := range items {
-		// Create vars with loop item
-		loopVars := make(map[string]interface{})
-		for k, v := range vars {
-			loopVars[k] = v
-		}
-		loopVars[loopVar] = item
-		if indexVar != "" {
-			loopVars[indexVar] = index
-		}
-
-		// Execute task with loop vars
-		results, err := r.executeOnHosts(ctx, task, module, hosts, loopVars)
-		if err != nil {
-			return allResults, err
-		}
-
-		// Add loop info to results
-		for i := range results {
-			results[i].Data["ansible_loop"] = map[string]interface{}{
-				"index":  index,
-				"index0": index,
-				"index1": index + 1,
-				"first":  index == 0,
-				"last":   index == len(items)-1,
-				"length": len(items),
-				loopVar:  item,
-			}
-			allResults = append(allResults, results[i])
-		}
-	}
+	// Store connection for reuse
+	r.mu.Lock()
+	r.connections[host.Name] = conn
+	r.mu.Unlock()
 
-	return allResults, nil
+	return conn, nil
 }
 
-// getConnection gets or creates a connection to a host
-func (r *TaskRunner) getConnection(ctx context.Context, host types.Host) (types.Connection, error) {
-	r.mu.RLock()
-	if conn, exists := r.connections[host.Name]; exists && conn.IsConnected() {
-		r.mu.RUnlock()
-		return conn, nil
-	}
-	r.mu.RUnlock()
-
-	// Create connection info
+// connectionInfo builds the connection parameters for a host from its
+// inventory fields and connection variables. Jump hosts with per-hop
+// credentials come from ansible_ssh_jump_hosts, for example:
+//
+//	ansible_ssh_jump_hosts:
+//	  - host: bastion.example.com
+//	    user: jump
+//	    private_key_file: /home/deploy/.ssh/bastion
+//	  - ops@inner-bastion:2222
+func connectionInfo(host types.Host) (types.ConnectionInfo, error) {
 	connInfo := types.ConnectionInfo{
 		Type:      "ssh", // Default connection type
 		Host:      host.Address,
@@ -558,32 +765,54 @@ func (r *TaskRunner) getConnection(ctx context.Context, host types.Host) (types.
 		connInfo.Type = "local"
 	}
 
-	// Create connection
-	conn, err := r.connectionMgr.GetConnection(ctx, connInfo)
-	if err != nil {
-		return nil, err
+	// An explicit connection plugin always wins
+	if v, ok := host.Variables["ansible_connection"]; ok {
+		connInfo.Type = types.ConvertToString(v)
 	}
 
-	// Store connection for reuse
-	r.mu.Lock()
-	r.connections[host.Name] = conn
-	r.mu.Unlock()
+	for _, name := range []string{"ansible_ssh_private_key_file", "ansible_private_key_file"} {
+		if key, ok := host.Variables[name]; ok {
+			connInfo.PrivateKey = types.ConvertToString(key)
+			break
+		}
+	}
 
-	return conn, nil
+	if v, ok := host.Variables["ansible_ssh_proxy_jump"]; ok {
+		connInfo.ProxyJump = types.ConvertToString(v)
+	}
+	if v, ok := host.Variables["ansible_ssh_proxy_command"]; ok {
+		connInfo.ProxyCommand = types.ConvertToString(v)
+	}
+
+	jumpHosts, err := connection.ParseJumpHosts(host.Variables["ansible_ssh_jump_hosts"])
+	if err != nil {
+		return connInfo, types.NewConnectionError(host.Name, "invalid jump host configuration", err)
+	}
+	connInfo.JumpHosts = jumpHosts
+
+	return connInfo, nil
 }
 
-// getHostVariables gets all variables for a host
+// extraVarsVar carries the extra vars of a run in the task variables, so
+// they can override the host's inventory variables and facts
+const extraVarsVar = "_extra_vars"
+
+// getHostVariables gets all variables for a host. Inventory variables are
+// overridden by the play and task variables, those by the facts and results
+// registered on the host, and everything by extra vars.
 func (r *TaskRunner) getHostVariables(host types.Host, taskVars map[string]interface{}) (map[string]interface{}, error) {
-	// Start with task variables
-	result := make(map[string]interface{})
-	if taskVars != nil {
-		result = types.DeepMergeInterfaceMaps(result, taskVars)
-	}
+	r.mu.RLock()
+	precedence := vars.NewPrecedence(r.hashBehaviour)
+	state := r.hostState[host.Name]
+	r.mu.RUnlock()
 
-	// Add host variables
-	if host.Variables != nil {
-		result = types.DeepMergeInterfaceMaps(result, host.Variables)
+	precedence.Add(vars.LevelInventoryHostVars, host.Variables)
+	precedence.Add(vars.LevelTaskVars, taskVars)
+	precedence.Add(vars.LevelSetFacts, state)
+	if extra, ok := taskVars[extraVarsVar].(map[string]interface{}); ok {
+		precedence.Add(vars.LevelExtraVars, extra)
 	}
+	result := precedence.Resolve()
 
 	// Add built-in host variables
 	result["inventory_hostname"] = host.Name
@@ -599,39 +828,6 @@ func (r *TaskRunner) getHostVariables(host types.Host, taskVars map[string]inter
 	return result, nil
 }
 
-// expandTaskArguments expands variables in task arguments
-func (r *TaskRunner) expandTaskArguments(args map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
-	expanded := make(map[string]interface{})
-
-	for key, value := range args {
-		expanded[key] = r.expandValue(value, vars)
-	}
-
-	return expanded
-}
-
-// expandValue recursively expands variables in a value
-func (r *TaskRunner) expandValue(value interface{}, vars map[string]interface{}) interface{} {
-	switch v := value.(type) {
-	case string:
-		return types.ExpandVariables(v, vars)
-	case map[string]interface{}:
-		expanded := make(map[string]interface{})
-		for k, val := range v {
-			expanded[k] = r.expandValue(val, vars)
-		}
-		return expanded
-	case []interface{}:
-		expanded := make([]interface{}, len(v))
-		for i, val := range v {
-			expanded[i] = r.expandValue(val, vars)
-		}
-		return expanded
-	default:
-		return value
-	}
-}
-
 // RunPlay executes a play
 func (r *TaskRunner) RunPlay(ctx context.Context, play types.Play, inventory types.Inventory, vars map[string]interface{}) ([]types.Result, error) {
 	// This would use the playbook executor, but to avoid circular dependencies,
@@ -763,6 +959,42 @@ func (r *TaskRunner) ExecuteTask(ctx context.Context, taskName, moduleName strin
 	return r.Run(ctx, task, hosts, vars)
 }
 
+// becomeConfig resolves the become settings for a task on a host. Task
+// keywords override the ansible_become* variables. sudo runs in a
+// pseudo-terminal on hosts discovered to require one.
+func (r *TaskRunner) becomeConfig(task types.Task, hostVars map[string]interface{}) (*types.BecomeConfig, error) {
+	settings := make(map[string]interface{})
+	for k, v := range hostVars {
+		if strings.HasPrefix(k, "ansible_become") {
+			settings[k] = v
+		}
+	}
+
+	if task.Become != nil {
+		settings["ansible_become"] = *task.Become
+	}
+	if task.BecomeUser != "" {
+		settings["ansible_become_user"] = task.BecomeUser
+	}
+	if task.BecomeMethod != "" {
+		settings["ansible_become_method"] = task.BecomeMethod
+	}
+	if task.BecomeFlags != "" {
+		settings["ansible_become_flags"] = task.BecomeFlags
+	}
+
+	config, err := become.FromVars(settings, r.vaultManager)
+	if config != nil && (config.Method == "" || config.Method == "sudo") {
+		config.RequireTTY = types.ConvertToBool(hostVars["discovered_sudo_requiretty"])
+	}
+	return config, err
+}
+
+// SetVaultManager sets the vault manager used to decrypt become passwords
+func (r *TaskRunner) SetVaultManager(vaultMgr *vault.Manager) {
+	r.vaultManager = vaultMgr
+}
+
 // SetVarManager sets the variable manager
 func (r *TaskRunner) SetVarManager(varMgr *vars.VarManager) {
 	r.varManager = varMgr
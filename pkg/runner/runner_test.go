@@ -1,13 +1,22 @@
 package runner
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/callback"
+	"github.com/liliang-cn/gosible/pkg/config"
 	"github.com/liliang-cn/gosible/pkg/connection"
+	"github.com/liliang-cn/gosible/pkg/inventory"
 	"github.com/liliang-cn/gosible/pkg/modules"
 	"github.com/liliang-cn/gosible/pkg/vars"
 )
@@ -251,6 +260,72 @@ func TestTaskRunnerRunWithInvalidModule(t *testing.T) {
 	}
 }
 
+func TestTaskRunnerCallbacks(t *testing.T) {
+	runner := NewTaskRunner()
+	var buf bytes.Buffer
+	plugin := callback.NewDefaultCallback()
+	plugin.SetOutput(&buf)
+	manager := callback.NewCallbackManager()
+	manager.Register(plugin)
+	runner.SetCallbacks(manager)
+
+	hosts := []types.Host{
+		{Name: "host1", Address: "localhost"},
+		{Name: "host2", Address: "localhost"},
+	}
+	task := types.Task{Name: "Say hello", Module: "debug", Args: map[string]interface{}{"msg": "hello"}}
+	if _, err := runner.Run(context.Background(), task, hosts, nil); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	invalid := types.Task{Name: "Broken", Module: "nonexistent_module"}
+	if _, err := runner.Run(context.Background(), invalid, hosts[:1], nil); err == nil {
+		t.Fatal("Run should fail with invalid module")
+	}
+
+	stats := manager.Stats()
+	if stats.TotalTasks != 2 || stats.SuccessTasks != 2 || stats.FailedTasks != 1 {
+		t.Errorf("expected 2 tasks with 2 successes and 1 failure, got %+v", stats)
+	}
+
+	output := buf.String()
+	for _, expected := range []string{"TASK [Say hello]", "ok: [host1]", "ok: [host2]", "TASK [Broken]", "failed: [host1] => module nonexistent_module not found"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestTaskRunnerPreflight(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	hosts := []types.Host{
+		{Name: "down2", Address: "127.0.0.1", Port: closedPort, Variables: map[string]interface{}{"ansible_connection": "ssh"}},
+		{Name: "local", Address: "localhost"},
+		{Name: "down1", Address: "127.0.0.1", Port: closedPort, Variables: map[string]interface{}{"ansible_connection": "ssh"}},
+	}
+
+	runner := NewTaskRunner()
+	results := runner.Preflight(context.Background(), hosts, time.Second)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 unreachable hosts, got %d", len(results))
+	}
+	for i, name := range []string{"down2", "down1"} {
+		result := results[i]
+		if result.Host != name || result.Success || result.Data["unreachable"] != true {
+			t.Errorf("expected %s to be reported unreachable in inventory order, got %+v", name, result)
+		}
+		if result.Data["endpoint"] != fmt.Sprintf("127.0.0.1:%d", closedPort) {
+			t.Errorf("unexpected endpoint %v", result.Data["endpoint"])
+		}
+	}
+}
+
 func TestTaskRunnerExpandTaskArguments(t *testing.T) {
 	runner := NewTaskRunner()
 
@@ -270,7 +345,10 @@ func TestTaskRunnerExpandTaskArguments(t *testing.T) {
 		"item2":  "dynamic_item",
 	}
 
-	expanded := runner.expandTaskArguments(args, vars)
+	expanded, err := runner.expandTaskArguments(args, vars)
+	if err != nil {
+		t.Fatalf("expandTaskArguments failed: %v", err)
+	}
 
 	if expanded["message"] != "Hello Alice" {
 		t.Errorf("expected 'Hello Alice', got %v", expanded["message"])
@@ -300,6 +378,166 @@ func TestTaskRunnerExpandTaskArguments(t *testing.T) {
 	}
 }
 
+func TestTaskRunnerArgTemplating(t *testing.T) {
+	runner := NewTaskRunner()
+
+	vars := map[string]interface{}{
+		"inventory_hostname": "web1",
+		"packages":           []interface{}{"nginx", "curl"},
+		"port":               8080,
+	}
+	args := map[string]interface{}{
+		"msg":  "Host: {{ inventory_hostname | upper }}",
+		"name": "{{ packages }}",
+		"nested": map[string]interface{}{
+			"ports": []interface{}{"{{ port }}", "{{ port + 1 }}"},
+		},
+		"command": "docker ps --format '{{.Names}}'",
+		"path":    "/srv/{{ missing }}",
+	}
+
+	// Undefined variables and invalid templates are left as written unless
+	// strict
+	expanded, err := runner.expandTaskArguments(args, vars)
+	if err != nil {
+		t.Fatalf("expandTaskArguments failed: %v", err)
+	}
+	if expanded["msg"] != "Host: WEB1" {
+		t.Errorf("expected filters to apply, got %v", expanded["msg"])
+	}
+	if packages, ok := expanded["name"].([]interface{}); !ok || len(packages) != 2 {
+		t.Errorf("expected a single expression to keep its list type, got %#v", expanded["name"])
+	}
+	ports := expanded["nested"].(map[string]interface{})["ports"].([]interface{})
+	if ports[0] != 8080 || ports[1] != 8081 {
+		t.Errorf("expected nested values to be rendered, got %v", ports)
+	}
+	if expanded["command"] != "docker ps --format '{{.Names}}'" || expanded["path"] != "/srv/{{ missing }}" {
+		t.Errorf("expected unrenderable arguments to be kept, got %v and %v", expanded["command"], expanded["path"])
+	}
+
+	runner.SetArgTemplating(true, true)
+	_, err = runner.expandTaskArguments(map[string]interface{}{"nested": map[string]interface{}{"path": "/srv/{{ missing }}"}}, vars)
+	if err == nil || !strings.Contains(err.Error(), "argument nested: path:") || !strings.Contains(err.Error(), "'missing' is undefined") {
+		t.Errorf("expected an undefined variable error naming the argument, got %v", err)
+	}
+
+	runner.SetArgTemplating(false, true)
+	expanded, err = runner.expandTaskArguments(args, vars)
+	if err != nil || expanded["msg"] != args["msg"] {
+		t.Errorf("expected arguments to be passed as written, got %v, %v", expanded["msg"], err)
+	}
+}
+
+func TestTaskRunnerHostStateVariables(t *testing.T) {
+	runner := NewTaskRunner()
+	host := types.Host{Name: "web1", Address: "10.0.0.1", Variables: map[string]interface{}{"role": "web"}}
+
+	runner.recordHostVars("web1", "web1", types.Task{Module: "setup"}, &types.Result{
+		Success: true,
+		Data: map[string]interface{}{
+			"ansible_facts": map[string]interface{}{"ansible_distribution": "Ubuntu"},
+		},
+	})
+	runner.recordHostVars("web1", "web1", types.Task{Module: "command", Register: "uptime"}, &types.Result{
+		Success: true,
+		Changed: true,
+		Data:    map[string]interface{}{"stdout": "up 3 days", "exit_code": 0},
+	})
+	runner.recordHostVars("web2", "web2", types.Task{Module: "command", Register: "other"}, &types.Result{Success: true})
+
+	hostVars, err := runner.getHostVariables(host, nil)
+	if err != nil {
+		t.Fatalf("getHostVariables failed: %v", err)
+	}
+	if _, ok := hostVars["other"]; ok {
+		t.Error("expected results registered on another host to be left out")
+	}
+
+	expanded, err := runner.expandTaskArguments(map[string]interface{}{
+		"msg": "{{ inventory_hostname }} ({{ role }}) runs {{ ansible_distribution }}/{{ ansible_facts.ansible_distribution }}: {{ uptime.stdout }} rc={{ uptime.rc }} changed={{ uptime.changed }}",
+	}, hostVars)
+	if err != nil {
+		t.Fatalf("expandTaskArguments failed: %v", err)
+	}
+	if want := "web1 (web) runs Ubuntu/Ubuntu: up 3 days rc=0 changed=True"; expanded["msg"] != want {
+		t.Errorf("expected %q, got %q", want, expanded["msg"])
+	}
+}
+
+func TestTaskRunnerWhenPerHost(t *testing.T) {
+	runner := NewTaskRunner()
+	ctx := context.Background()
+	hosts := []types.Host{
+		{Name: "web1", Address: "localhost", Variables: map[string]interface{}{"role": "web"}},
+		{Name: "db1", Address: "localhost", Variables: map[string]interface{}{"role": "db"}},
+	}
+	runner.recordHostVars("db1", "db1", types.Task{Module: "setup"}, &types.Result{
+		Success: true,
+		Data:    map[string]interface{}{"ansible_facts": map[string]interface{}{"ansible_os_family": "Debian"}},
+	})
+
+	task := types.Task{
+		Name:   "Web or Debian",
+		Module: "debug",
+		Args:   map[string]interface{}{"msg": "ok"},
+		When:   "role == 'web' or ansible_os_family | default('') == 'Debian' and role != 'db'",
+	}
+	results, err := runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[0].Host != "web1" || results[1].Host != "db1" {
+		t.Fatalf("expected a result per host in inventory order, got %+v", results)
+	}
+	if results[0].Data["skipped"] == true || results[1].Data["skipped"] != true {
+		t.Errorf("expected web1 to run and db1 to be skipped, got %v and %v", results[0].Data, results[1].Data)
+	}
+
+	task.When = "ansible_os_family is defined"
+	results, err = runner.Run(ctx, task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Data["skipped"] != true || results[1].Data["skipped"] == true {
+		t.Errorf("expected only the host with gathered facts to run, got %v and %v", results[0].Data, results[1].Data)
+	}
+}
+
+func TestTaskRunnerBecomeConfig(t *testing.T) {
+	runner := NewTaskRunner()
+	hostVars := map[string]interface{}{
+		"ansible_become":      true,
+		"ansible_become_user": "root",
+		"unrelated":           "value",
+	}
+
+	config, err := runner.becomeConfig(types.Task{}, hostVars)
+	if err != nil {
+		t.Fatalf("becomeConfig failed: %v", err)
+	}
+	if config == nil || config.User != "root" {
+		t.Fatalf("expected become config from variables, got %+v", config)
+	}
+
+	config, err = runner.becomeConfig(types.Task{BecomeUser: "postgres", BecomeMethod: "su"}, hostVars)
+	if err != nil {
+		t.Fatalf("becomeConfig failed: %v", err)
+	}
+	if config.User != "postgres" || config.Method != "su" {
+		t.Errorf("expected task keywords to override variables, got %+v", config)
+	}
+
+	disabled := false
+	config, err = runner.becomeConfig(types.Task{Become: &disabled}, hostVars)
+	if err != nil {
+		t.Fatalf("becomeConfig failed: %v", err)
+	}
+	if config != nil {
+		t.Errorf("expected become: false to disable escalation, got %+v", config)
+	}
+}
+
 func TestTaskRunnerGetHostVariables(t *testing.T) {
 	runner := NewTaskRunner()
 
@@ -350,6 +588,63 @@ func TestTaskRunnerGetHostVariables(t *testing.T) {
 	}
 }
 
+func TestConnectionInfoFromInventory(t *testing.T) {
+	inv, err := inventory.NewFromYAML([]byte(`
+all:
+  hosts:
+    private-db:
+      ansible_host: 10.0.0.5
+      ansible_user: deploy
+      ansible_ssh_private_key_file: /keys/deploy
+      ansible_ssh_proxy_jump: bastion1,ops@bastion2:2200
+      ansible_ssh_jump_hosts:
+        - host: bastion1
+          user: jump
+          private_key_file: /keys/bastion1
+        - host: bastion2
+          port: 2200
+          password: secret
+`))
+	if err != nil {
+		t.Fatalf("failed to parse inventory: %v", err)
+	}
+
+	host, err := inv.GetHost("private-db")
+	if err != nil {
+		t.Fatalf("failed to get host: %v", err)
+	}
+
+	connInfo, err := connectionInfo(*host)
+	if err != nil {
+		t.Fatalf("connectionInfo failed: %v", err)
+	}
+
+	if connInfo.PrivateKey != "/keys/deploy" {
+		t.Errorf("expected private key '/keys/deploy', got %q", connInfo.PrivateKey)
+	}
+	if connInfo.ProxyJump != "bastion1,ops@bastion2:2200" {
+		t.Errorf("unexpected proxy jump %q", connInfo.ProxyJump)
+	}
+
+	expected := []types.JumpHost{
+		{Host: "bastion1", User: "jump", PrivateKey: "/keys/bastion1"},
+		{Host: "bastion2", Port: 2200, Password: "secret"},
+	}
+	if !reflect.DeepEqual(connInfo.JumpHosts, expected) {
+		t.Errorf("expected jump hosts %+v, got %+v", expected, connInfo.JumpHosts)
+	}
+
+	t.Run("invalid jump hosts", func(t *testing.T) {
+		_, err := connectionInfo(types.Host{
+			Name:      "bad",
+			Variables: map[string]interface{}{"ansible_ssh_jump_hosts": "bastion"},
+		})
+		if err == nil {
+			t.Error("expected error for non-list ansible_ssh_jump_hosts")
+		}
+	})
+}
+
 func TestTaskRunnerValidateTask(t *testing.T) {
 	runner := NewTaskRunner()
 
@@ -579,3 +874,102 @@ func BenchmarkTaskRunnerRunParallel(b *testing.B) {
 		}
 	}
 }
+
+func TestTaskRunnerRunBatches(t *testing.T) {
+	fleet := newSimFleet(25, 0, 4)
+
+	var batches []int
+	err := fleet.runner.RunBatches(context.Background(), simTask, slices.Values(fleet.hosts), 10, nil, func(results []types.Result) error {
+		batches = append(batches, len(results))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunBatches failed: %v", err)
+	}
+	if fmt.Sprint(batches) != "[10 10 5]" {
+		t.Errorf("expected batches of 10, 10 and 5 results, got %v", batches)
+	}
+	if fleet.commands != 25 {
+		t.Errorf("expected the task to run once per host, got %d commands", fleet.commands)
+	}
+
+	// An error from the handler stops the run
+	stop := errors.New("stop")
+	err = fleet.runner.RunBatches(context.Background(), simTask, slices.Values(fleet.hosts), 0, nil, func(results []types.Result) error {
+		return stop
+	})
+	if !errors.Is(err, stop) || fleet.commands != 29 {
+		t.Errorf("expected one batch of 4 before stopping, got %v after %d commands", err, fleet.commands)
+	}
+}
+
+func TestTaskRunnerVariablePrecedence(t *testing.T) {
+	runner := NewTaskRunner()
+	host := types.Host{Name: "web1", Variables: map[string]interface{}{
+		"port": 80,
+		"user": "inventory",
+		"app":  map[string]interface{}{"port": 80, "workers": 2},
+	}}
+	runner.recordHostVars(host.Name, host.Name, types.Task{Register: "user"}, &types.Result{Success: true})
+
+	taskVars := map[string]interface{}{
+		"port":       8080,
+		"app":        map[string]interface{}{"port": 8080},
+		"version":    "1.0",
+		extraVarsVar: map[string]interface{}{"version": "2.0"},
+	}
+
+	hostVars, err := runner.getHostVariables(host, taskVars)
+	if err != nil {
+		t.Fatalf("getHostVariables failed: %v", err)
+	}
+	if hostVars["port"] != 8080 {
+		t.Errorf("expected play and task vars to override inventory vars, got %v", hostVars["port"])
+	}
+	if _, ok := hostVars["user"].(map[string]interface{}); !ok {
+		t.Errorf("expected the registered result to override inventory vars, got %v", hostVars["user"])
+	}
+	if hostVars["version"] != "2.0" {
+		t.Errorf("expected extra vars to override everything, got %v", hostVars["version"])
+	}
+	if !reflect.DeepEqual(hostVars["app"], map[string]interface{}{"port": 8080}) {
+		t.Errorf("expected the task's app to replace the inventory's, got %v", hostVars["app"])
+	}
+
+	// With hash_behaviour=merge, maps are merged across levels
+	cfg := config.NewConfig()
+	cfg.SetString("hash_behaviour", "merge")
+	runner.Configure(cfg)
+	hostVars, _ = runner.getHostVariables(host, taskVars)
+	if !reflect.DeepEqual(hostVars["app"], map[string]interface{}{"port": 8080, "workers": 2}) {
+		t.Errorf("expected the app maps to be merged, got %v", hostVars["app"])
+	}
+}
+
+func TestTaskRunnerTaskCheckMode(t *testing.T) {
+	runner := NewTaskRunner()
+	hosts := []types.Host{{Name: "localhost", Address: "localhost"}}
+	vars := map[string]interface{}{"ansible_check_mode": true}
+
+	// check_mode: false runs the task for real in check mode
+	runReal := false
+	task := types.Task{Name: "Read", Module: "shell", Args: map[string]interface{}{"cmd": "echo real"}, CheckMode: &runReal}
+	results, err := runner.Run(context.Background(), task, hosts, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Simulated || strings.TrimSpace(types.ConvertToString(results[0].Data["stdout"])) != "real" {
+		t.Errorf("expected the task to run, got %+v", results[0])
+	}
+
+	// check_mode: true simulates it outside check mode
+	simulate := true
+	task.CheckMode = &simulate
+	results, err = runner.Run(context.Background(), task, hosts, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !results[0].Simulated {
+		t.Errorf("expected the task to be simulated, got %+v", results[0])
+	}
+}
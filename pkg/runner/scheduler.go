@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// hostScheduler serializes modules that share a concurrency class on the
+// same host, such as package managers contending for the dpkg lock, while
+// letting every other module interleave freely
+type hostScheduler struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{} // host name and class -> single slot
+}
+
+// newHostScheduler creates an empty host scheduler
+func newHostScheduler() *hostScheduler {
+	return &hostScheduler{slots: make(map[string]chan struct{})}
+}
+
+// acquire waits until no other module of the class runs on the host and
+// returns the function that releases it. Modules without a class never wait.
+func (s *hostScheduler) acquire(ctx context.Context, host, class string) (func(), error) {
+	if class == "" {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	key := host + "\x00" + class
+	slot, ok := s.slots[key]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		s.slots[key] = slot
+	}
+	s.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// concurrencyClass returns the concurrency class a module declares, if any
+func concurrencyClass(module types.Module) string {
+	if capModule, ok := module.(types.ModuleWithCapabilities); ok {
+		if caps := capModule.Capabilities(); caps != nil {
+			return caps.ConcurrencyClass
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/modules"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+func TestHostSchedulerSerializesClass(t *testing.T) {
+	scheduler := newHostScheduler()
+	ctx := context.Background()
+
+	var running, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := scheduler.acquire(ctx, "web1", types.ConcurrencyClassPackageManager)
+			if err != nil {
+				t.Errorf("acquire failed: %v", err)
+				return
+			}
+			defer release()
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	if overlaps != 0 {
+		t.Errorf("expected package manager modules to run one at a time, got %d overlaps", overlaps)
+	}
+}
+
+func TestHostSchedulerAllowsOthers(t *testing.T) {
+	scheduler := newHostScheduler()
+	ctx := context.Background()
+
+	release, err := scheduler.acquire(ctx, "web1", types.ConcurrencyClassPackageManager)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	defer release()
+
+	// Other hosts and modules without a class are not held up
+	other, err := scheduler.acquire(ctx, "web2", types.ConcurrencyClassPackageManager)
+	if err != nil {
+		t.Fatalf("expected another host to acquire the class, got %v", err)
+	}
+	other()
+	unclassed, err := scheduler.acquire(ctx, "web1", "")
+	if err != nil {
+		t.Fatalf("expected modules without a class to run, got %v", err)
+	}
+	unclassed()
+
+	// A conflicting module gives up when the context ends
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := scheduler.acquire(timeout, "web1", types.ConcurrencyClassPackageManager); err == nil {
+		t.Error("expected acquire to wait for the running package manager")
+	}
+}
+
+func TestConcurrencyClass(t *testing.T) {
+	for _, module := range []types.Module{modules.NewAptModule(), modules.NewYumModule(), modules.NewDnfModule(), modules.NewPackageModule()} {
+		if class := concurrencyClass(module); class != types.ConcurrencyClassPackageManager {
+			t.Errorf("expected %s to declare the package manager class, got %q", module.Name(), class)
+		}
+	}
+	if class := concurrencyClass(modules.NewCommandModule()); class != "" {
+		t.Errorf("expected command to have no concurrency class, got %q", class)
+	}
+}
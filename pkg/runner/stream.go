@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"context"
+	"iter"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// RunBatches executes a task on the hosts from an iterator, batchSize hosts
+// at a time, handing each batch's results to handle. Neither the hosts nor
+// the results of the whole fleet are held at once, so very large inventories
+// can be targeted with memory bounded by the batch size. A batchSize of 0
+// uses the concurrency limit. Iteration stops at the first error returned by
+// Run or handle.
+func (r *TaskRunner) RunBatches(ctx context.Context, task types.Task, hosts iter.Seq[types.Host], batchSize int, vars map[string]interface{}, handle func([]types.Result) error) error {
+	if batchSize <= 0 {
+		batchSize = r.maxConcurrency
+	}
+
+	batch := make([]types.Host, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := r.Run(ctx, task, batch, vars)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return handle(results)
+	}
+
+	for host := range hosts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = append(batch, host)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
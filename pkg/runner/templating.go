@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/liliang-cn/gosible/pkg/config"
+	"github.com/liliang-cn/gosible/pkg/types"
+	"github.com/liliang-cn/gosible/pkg/vars"
+)
+
+// SetArgTemplating sets how task arguments are rendered before they reach
+// a module. With templating disabled arguments are passed as written. With
+// strict set, an argument that uses an undefined variable or is not a valid
+// template fails the task; otherwise it is expanded the legacy way, leaving
+// unknown {{var}} references in place.
+func (r *TaskRunner) SetArgTemplating(enabled, strict bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templateArgs = enabled
+	r.strictVars = strict
+}
+
+// SetHashBehaviour sets whether a map variable defined at a higher
+// precedence level replaces the lower level's map or is merged into it
+func (r *TaskRunner) SetHashBehaviour(behaviour vars.HashBehaviour) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashBehaviour = behaviour
+}
+
+// Configure applies the runner settings of a gosible configuration:
+// template_module_args, error_on_undefined_vars and hash_behaviour. An
+// invalid hash_behaviour, which Config.Validate reports, is ignored.
+func (r *TaskRunner) Configure(cfg *config.Config) {
+	r.SetArgTemplating(cfg.GetBool("template_module_args"), cfg.GetBool("error_on_undefined_vars"))
+	if behaviour, err := vars.ParseHashBehaviour(cfg.GetString("hash_behaviour")); err == nil {
+		r.SetHashBehaviour(behaviour)
+	}
+}
+
+// expandTaskArguments renders task arguments for a host. Strings are
+// rendered as Jinja2 with the host's variables, facts and registered
+// results; maps and lists are rendered recursively.
+func (r *TaskRunner) expandTaskArguments(args map[string]interface{}, vars map[string]interface{}) (map[string]interface{}, error) {
+	r.mu.RLock()
+	enabled := r.templateArgs
+	r.mu.RUnlock()
+
+	expanded := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if !enabled {
+			expanded[key] = value
+			continue
+		}
+		rendered, err := r.expandValue(value, vars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to template argument %s: %w", key, err)
+		}
+		expanded[key] = rendered
+	}
+	return expanded, nil
+}
+
+// expandValue recursively renders a value. A string holding a single
+// expression, like "{{ packages }}", takes the expression's type.
+func (r *TaskRunner) expandValue(value interface{}, vars map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		rendered, err := r.templates.RenderNative(v, vars)
+		if err != nil {
+			r.mu.RLock()
+			strict := r.strictVars
+			r.mu.RUnlock()
+			if strict {
+				return nil, err
+			}
+			return types.ExpandVariables(v, vars), nil
+		}
+		return rendered, nil
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			rendered, err := r.expandValue(val, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			expanded[k] = rendered
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, val := range v {
+			rendered, err := r.expandValue(val, vars)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			expanded[i] = rendered
+		}
+		return expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+// recordHostVars keeps what a task result adds to the variables of hosts:
+// the registered result for host and any gathered facts for factsHost,
+// which is the delegate with delegate_facts
+func (r *TaskRunner) recordHostVars(host, factsHost string, task types.Task, result *types.Result) {
+	if result == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := func(name string) map[string]interface{} {
+		vars := r.hostState[name]
+		if vars == nil {
+			vars = make(map[string]interface{})
+			r.hostState[name] = vars
+		}
+		return vars
+	}
+
+	if facts, ok := result.Data["ansible_facts"].(map[string]interface{}); ok {
+		vars := state(factsHost)
+		gathered, _ := vars["ansible_facts"].(map[string]interface{})
+		merged := make(map[string]interface{}, len(gathered)+len(facts))
+		for k, v := range gathered {
+			merged[k] = v
+		}
+		for k, v := range facts {
+			merged[k] = v
+			vars[k] = v
+		}
+		vars["ansible_facts"] = merged
+	}
+
+	if task.Register != "" {
+		state(host)[task.Register] = registeredResult(result)
+	}
+}
+
+// registeredResult is the value of a registered variable: the result data,
+// such as stdout and rc, with changed, failed and msg
+func registeredResult(result *types.Result) map[string]interface{} {
+	registered := make(map[string]interface{}, len(result.Data)+4)
+	for k, v := range result.Data {
+		registered[k] = v
+	}
+	if _, ok := registered["rc"]; !ok {
+		if rc, ok := result.Data["exit_code"]; ok {
+			registered["rc"] = rc
+		}
+	}
+	registered["changed"] = result.Changed
+	registered["failed"] = !result.Success
+	registered["msg"] = result.Message
+	return registered
+}
@@ -0,0 +1,190 @@
+// Package scaffold generates skeletons for new gosible modules. A generated
+// module follows the conventions of the built-in ones: a ModuleDoc that
+// drives argument validation and documentation, check and diff mode through
+// changeResult, tests built on the ModuleTestHelper and the conformance
+// suite, and registration in registerBuiltinModules.
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+// moduleName matches the snake_case names modules are invoked by
+var moduleName = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// initialisms are upper-cased in Go type names, as in DNSClientModule
+var initialisms = map[string]bool{
+	"acl": true, "api": true, "aws": true, "cpu": true, "dns": true, "gid": true,
+	"http": true, "id": true, "ip": true, "ldap": true, "lxd": true, "ntp": true,
+	"ssh": true, "ssl": true, "tls": true, "uid": true, "url": true, "vm": true,
+	"xml": true, "zfs": true,
+}
+
+// Options configure the generated module
+type Options struct {
+	// Dir is the modules package directory, pkg/modules by default
+	Dir string
+
+	// Description is the module's one-line documentation
+	Description string
+
+	// Platform is the platform the module declares, linux by default
+	Platform string
+
+	// RequiresRoot declares that the module needs privilege escalation
+	RequiresRoot bool
+
+	// Register adds the module to registerBuiltinModules in registry.go
+	Register bool
+
+	// Force overwrites existing files
+	Force bool
+}
+
+// moduleData is what the templates render
+type moduleData struct {
+	Name         string
+	Type         string
+	Title        string
+	Noun         string
+	Description  string
+	Platform     string
+	RequiresRoot bool
+}
+
+// TypeName returns the Go type prefix for a module name, e.g. DNSRecord for
+// dns_record
+func TypeName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if initialisms[part] {
+			b.WriteString(strings.ToUpper(part))
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// GenerateModule writes NAME.go and NAME_test.go into the modules directory
+// and, when requested, registers the module. It returns the paths it wrote.
+func GenerateModule(name string, opts Options) ([]string, error) {
+	if !moduleName.MatchString(name) {
+		return nil, fmt.Errorf("invalid module name %q: use lower case letters, digits and underscores", name)
+	}
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join("pkg", "modules")
+	}
+	if opts.Platform == "" {
+		opts.Platform = "linux"
+	}
+
+	noun := strings.ReplaceAll(name, "_", " ")
+	data := moduleData{
+		Name:         name,
+		Type:         TypeName(name),
+		Title:        strings.ToUpper(noun[:1]) + noun[1:],
+		Noun:         noun,
+		Description:  opts.Description,
+		Platform:     opts.Platform,
+		RequiresRoot: opts.RequiresRoot,
+	}
+	if data.Description == "" {
+		data.Description = "Manage " + noun + "s"
+	}
+
+	files := []struct{ template, path string }{
+		{"module.go.tmpl", filepath.Join(opts.Dir, name+".go")},
+		{"module_test.go.tmpl", filepath.Join(opts.Dir, name+"_test.go")},
+	}
+	if !opts.Force {
+		for _, file := range files {
+			if _, err := os.Stat(file.path); err == nil {
+				return nil, fmt.Errorf("%s already exists", file.path)
+			}
+		}
+	}
+
+	var written []string
+	for _, file := range files {
+		source, err := render(file.template, data)
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(file.path, source, 0644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		written = append(written, file.path)
+	}
+
+	if opts.Register {
+		registry := filepath.Join(opts.Dir, "registry.go")
+		changed, err := register(registry, data)
+		if err != nil {
+			return written, err
+		}
+		if changed {
+			written = append(written, registry)
+		}
+	}
+	return written, nil
+}
+
+// render executes a template and gofmts the result
+func render(name string, data moduleData) ([]byte, error) {
+	tmpl, err := template.ParseFS(templates, "templates/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format %s: %w", name, err)
+	}
+	return source, nil
+}
+
+// register adds the module's constructor to the end of
+// registerBuiltinModules, reporting whether the registry changed
+func register(path string, data moduleData) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	constructor := fmt.Sprintf("New%sModule()", data.Type)
+	if bytes.Contains(content, []byte(constructor)) {
+		return false, nil
+	}
+
+	start := bytes.Index(content, []byte("func (r *ModuleRegistry) registerBuiltinModules() {"))
+	if start < 0 {
+		return false, fmt.Errorf("registerBuiltinModules not found in %s", path)
+	}
+	end := bytes.Index(content[start:], []byte("\n}\n"))
+	if end < 0 {
+		return false, fmt.Errorf("end of registerBuiltinModules not found in %s", path)
+	}
+	end += start
+
+	registration := fmt.Sprintf("\n\n\t// Register %s module\n\tr.RegisterModule(%s)", data.Name, constructor)
+	updated := append(append(append([]byte{}, content[:end]...), registration...), content[end:]...)
+	if err := os.WriteFile(path, updated, 0644); err != nil {
+		return false, fmt.Errorf("failed to update registry: %w", err)
+	}
+	return true, nil
+}
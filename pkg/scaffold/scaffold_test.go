@@ -0,0 +1,137 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"widget":         "Widget",
+		"dns_record":     "DNSRecord",
+		"ufw_rule":       "UfwRule",
+		"ldap_attr_list": "LDAPAttrList",
+		"http2_proxy":    "Http2Proxy",
+	} {
+		if got := TypeName(name); got != expected {
+			t.Errorf("TypeName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+// modulesDir copies registry.go into a temporary modules directory
+func modulesDir(t *testing.T) string {
+	dir := t.TempDir()
+	registry, err := os.ReadFile(filepath.Join("..", "modules", "registry.go"))
+	if err != nil {
+		t.Fatalf("failed to read registry: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "registry.go"), registry, 0644); err != nil {
+		t.Fatalf("failed to write registry: %v", err)
+	}
+	return dir
+}
+
+func TestGenerateModule(t *testing.T) {
+	dir := modulesDir(t)
+
+	written, err := GenerateModule("dns_record", Options{Dir: dir, Description: "Manage DNS records", Register: true})
+	if err != nil {
+		t.Fatalf("GenerateModule failed: %v", err)
+	}
+	if len(written) != 3 {
+		t.Errorf("expected the module, its test and the registry to be written, got %v", written)
+	}
+
+	source, _ := os.ReadFile(filepath.Join(dir, "dns_record.go"))
+	for _, expected := range []string{
+		"type DNSRecordModule struct",
+		"func NewDNSRecordModule() *DNSRecordModule",
+		`Description: "Manage DNS records"`,
+		`Platform:     "linux"`,
+		"RequiresRoot: false",
+		"changeResult(m.BaseModule, result, change, checkMode, diffMode",
+	} {
+		if !strings.Contains(string(source), expected) {
+			t.Errorf("expected %q in the generated module:\n%s", expected, source)
+		}
+	}
+
+	tests, _ := os.ReadFile(filepath.Join(dir, "dns_record_test.go"))
+	for _, expected := range []string{"func TestDNSRecordModule(t *testing.T)", "testhelper.NewModuleTestHelper(t, module)", "testhelper.RunConformance"} {
+		if !strings.Contains(string(tests), expected) {
+			t.Errorf("expected %q in the generated test:\n%s", expected, tests)
+		}
+	}
+
+	registry, _ := os.ReadFile(filepath.Join(dir, "registry.go"))
+	if !strings.Contains(string(registry), "\t// Register dns_record module\n\tr.RegisterModule(NewDNSRecordModule())\n}\n") {
+		t.Errorf("expected the module registered at the end of registerBuiltinModules:\n%s", registry)
+	}
+
+	if _, err := GenerateModule("dns_record", Options{Dir: dir}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected existing files to be kept, got %v", err)
+	}
+
+	written, err = GenerateModule("dns_record", Options{Dir: dir, Register: true, Force: true})
+	if err != nil {
+		t.Fatalf("GenerateModule with force failed: %v", err)
+	}
+	registry, _ = os.ReadFile(filepath.Join(dir, "registry.go"))
+	if len(written) != 2 || strings.Count(string(registry), "NewDNSRecordModule()") != 1 {
+		t.Errorf("expected the module to be registered once, wrote %v", written)
+	}
+}
+
+func TestGenerateModuleInvalidName(t *testing.T) {
+	for _, name := range []string{"", "Widget", "my-module", "1widget", "widget_", "../widget"} {
+		if _, err := GenerateModule(name, Options{Dir: t.TempDir()}); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+// TestGeneratedModulePasses builds the modules package with a generated
+// module overlaid and runs its tests along with the built-in conformance
+// suite, which picks it up through the registry
+func TestGeneratedModulePasses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go test of the generated module in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+
+	dir := modulesDir(t)
+	written, err := GenerateModule("scaffold_widget", Options{Dir: dir, RequiresRoot: true, Register: true})
+	if err != nil {
+		t.Fatalf("GenerateModule failed: %v", err)
+	}
+
+	modules, err := filepath.Abs(filepath.Join("..", "modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replace := make(map[string]string)
+	for _, path := range written {
+		replace[filepath.Join(modules, filepath.Base(path))] = path
+	}
+	overlay, _ := json.Marshal(map[string]interface{}{"Replace": replace})
+	overlayFile := filepath.Join(t.TempDir(), "overlay.json")
+	if err := os.WriteFile(overlayFile, overlay, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goTool, "test", "-overlay", overlayFile, "-count=1",
+		"-run", "^(TestScaffoldWidgetModule|TestBuiltinModuleConformance)$", ".")
+	cmd.Dir = modules
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated module failed its tests: %v\n%s", err, output)
+	}
+}
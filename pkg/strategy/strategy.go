@@ -19,9 +19,29 @@ type Strategy interface {
 	SetOptions(options map[string]interface{}) error
 }
 
-// TaskExecutor executes a single task on a host
+// TaskExecutor executes a single task on a host. Strategies must run each
+// host's tasks in play order; they may interleave hosts freely.
 type TaskExecutor func(ctx context.Context, task types.Task, host types.Host) (*types.Result, error)
 
+// forksKey is the context key of the forks of one Execute call
+type forksKey struct{}
+
+// WithForks returns a context limiting the strategies executing with it
+// to forks hosts at a time. Unlike SetOptions it leaves the strategy
+// untouched, so plays sharing a strategy can each run with their own forks.
+func WithForks(ctx context.Context, forks int) context.Context {
+	return context.WithValue(ctx, forksKey{}, forks)
+}
+
+// Forks returns the forks set on ctx with WithForks, or fallback when none
+// are. Custom strategies call it to honour the forks of the play.
+func Forks(ctx context.Context, fallback int) int {
+	if forks, ok := ctx.Value(forksKey{}).(int); ok && forks > 0 {
+		return forks
+	}
+	return fallback
+}
+
 // StrategyManager manages execution strategies
 type StrategyManager struct {
 	strategies map[string]Strategy
@@ -38,6 +58,7 @@ func NewStrategyManager() *StrategyManager {
 	sm.Register(NewLinearStrategy())
 	sm.Register(NewFreeStrategy())
 	sm.Register(NewDebugStrategy())
+	sm.Register(NewHostPinnedStrategy())
 	
 	return sm
 }
@@ -95,7 +116,7 @@ func (ls *LinearStrategy) Execute(ctx context.Context, tasks []types.Task, hosts
 	for _, task := range tasks {
 		// Use errgroup for parallel execution with fork limit
 		g, ctx := errgroup.WithContext(ctx)
-		g.SetLimit(ls.forks)
+		g.SetLimit(Forks(ctx, ls.forks))
 		
 		results := make([]types.Result, len(hosts))
 		resultsMu := sync.Mutex{}
@@ -172,9 +193,10 @@ func (fs *FreeStrategy) Execute(ctx context.Context, tasks []types.Task, hosts [
 	var allResults []types.Result
 	resultsMu := sync.Mutex{}
 	
-	// Create a pool of workers
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(fs.forks)
+	// Create a pool of workers. A failing host must not cancel the others,
+	// so the group does not share a context.
+	var g errgroup.Group
+	g.SetLimit(Forks(ctx, fs.forks))
 	
 	// Queue all task-host combinations
 	for _, host := range hosts {
@@ -359,8 +381,9 @@ func (hp *HostPinnedStrategy) Execute(ctx context.Context, tasks []types.Task, h
 	var allResults []types.Result
 	resultsMu := sync.Mutex{}
 	
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(hp.forks)
+	// As with the free strategy, failing hosts do not stop the others
+	var g errgroup.Group
+	g.SetLimit(Forks(ctx, hp.forks))
 	
 	// Process each host completely before moving to next
 	for _, host := range hosts {
@@ -79,7 +79,7 @@ func TestStrategyManager(t *testing.T) {
 	sm := NewStrategyManager()
 	
 	// Check built-in strategies are registered
-	strategies := []string{"linear", "free", "debug"}
+	strategies := []string{"linear", "free", "debug", "host_pinned"}
 	for _, name := range strategies {
 		strategy, err := sm.Get(name)
 		if err != nil {
@@ -513,4 +513,25 @@ func TestStrategy_SetOptions(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestStrategy_WithForks(t *testing.T) {
+	strategy := NewFreeStrategy()
+
+	executor := NewMockExecutor()
+	executor.delay = 10 * time.Millisecond
+
+	tasks := []types.Task{{Name: "task1"}}
+	hosts := []types.Host{{Name: "host1"}, {Name: "host2"}, {Name: "host3"}}
+
+	// The forks of the context override the strategy's own 5
+	start := time.Now()
+	if _, err := strategy.Execute(WithForks(context.Background(), 1), tasks, hosts, executor.Execute); err != nil {
+		t.Fatalf("Strategy execution failed: %v", err)
+	}
+	if duration := time.Since(start); duration < 30*time.Millisecond {
+		t.Errorf("Expected sequential execution to take at least 30ms, took %v", duration)
+	}
+	if strategy.forks != 5 {
+		t.Errorf("Expected the strategy's forks to be left alone, got %d", strategy.forks)
+	}
+}
@@ -10,29 +10,94 @@ import (
 	"sync"
 	"text/template"
 
+	"github.com/liliang-cn/gosible/pkg/filter"
+	"github.com/liliang-cn/gosible/pkg/lookup"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
+// Syntax selects the template language an engine renders
+type Syntax string
+
+const (
+	// SyntaxGo renders Go text/template syntax
+	SyntaxGo Syntax = "go"
+	// SyntaxJinja2 renders Jinja2 syntax, as used by Ansible .j2 templates
+	SyntaxJinja2 Syntax = "jinja2"
+)
+
+// jinjaHeader is the first line that marks a template as Jinja2 regardless
+// of the engine's syntax, e.g. "#jinja2: trim_blocks: True"
+const jinjaHeader = "#jinja2:"
+
 // Engine implements the TemplateEngine interface
 type Engine struct {
 	mu        sync.RWMutex
 	functions map[string]interface{}
+	syntax    Syntax
+	filters   *filter.FilterManager
+	registry  *FilterRegistry
+	lookups   *lookup.LookupManager
 }
 
 // NewEngine creates a new template engine
 func NewEngine() *Engine {
 	engine := &Engine{
 		functions: make(map[string]interface{}),
+		syntax:    SyntaxGo,
+		filters:   filter.NewFilterManager(),
+		registry:  NewFilterRegistry(),
 	}
 
 	// Register built-in functions
 	engine.registerBuiltinFunctions()
+	engine.SetLookupManager(lookup.NewLookupManager())
 
 	return engine
 }
 
+// SetSyntax sets the template language used by Render
+func (e *Engine) SetSyntax(syntax Syntax) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.syntax = syntax
+}
+
+// SetFilterManager sets the filter plugins available to Jinja2 templates
+func (e *Engine) SetFilterManager(filters *filter.FilterManager) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.filters = filters
+}
+
 // Render processes a template string with the given variables
 func (e *Engine) Render(templateStr string, vars map[string]interface{}) (string, error) {
+	e.mu.RLock()
+	syntax := e.syntax
+	e.mu.RUnlock()
+
+	return e.render(templateStr, vars, syntax)
+}
+
+// render processes a template string in the given syntax, unless a
+// #jinja2: header asks for Jinja2
+func (e *Engine) render(templateStr string, vars map[string]interface{}, syntax Syntax) (string, error) {
+	if strings.HasPrefix(templateStr, jinjaHeader) {
+		syntax = SyntaxJinja2
+		templateStr = stripJinjaHeader(templateStr)
+	}
+
+	if syntax == SyntaxJinja2 {
+		renderer := e.jinjaRenderer()
+		nodes, err := parseJinja(templateStr)
+		if err != nil {
+			return "", types.NewTemplateError("inline", 0, 0, "failed to parse template", err)
+		}
+		if err := renderer.render(nodes, jinjaRootScope(vars)); err != nil {
+			return "", types.NewTemplateError("inline", 0, 0, "failed to execute template", err)
+		}
+		return renderer.out.String(), nil
+	}
+
 	e.mu.RLock()
 	functions := make(map[string]interface{})
 	for k, v := range e.functions {
@@ -58,7 +123,79 @@ func (e *Engine) Render(templateStr string, vars map[string]interface{}) (string
 	return result.String(), nil
 }
 
-// RenderFile processes a template file with the given variables
+// RenderNative renders a string as Jinja2 the way task arguments are
+// rendered. A string that is a single expression, like "{{ packages }}",
+// evaluates to the expression's value rather than its text, so lists, dicts
+// and numbers keep their type; any other template renders to a string.
+// Strings without template syntax are returned unchanged. As in Render,
+// undefined variables are errors.
+func (e *Engine) RenderNative(templateStr string, vars map[string]interface{}) (interface{}, error) {
+	if !strings.Contains(templateStr, "{{") && !strings.Contains(templateStr, "{%") && !strings.Contains(templateStr, "{#") {
+		return templateStr, nil
+	}
+
+	nodes, err := parseJinja(templateStr)
+	if err != nil {
+		return nil, types.NewTemplateError("inline", 0, 0, "failed to parse template", err)
+	}
+	if len(nodes) == 1 {
+		if output, ok := nodes[0].(*jinjaOutput); ok {
+			value, err := e.jinjaRenderer().evalDefined(output.expr, jinjaRootScope(vars))
+			if err != nil {
+				return nil, types.NewTemplateError("inline", output.line, 0, "failed to execute template", err)
+			}
+			return value, nil
+		}
+	}
+	return e.render(templateStr, vars, SyntaxJinja2)
+}
+
+// Evaluate evaluates a single Jinja2 expression, such as a task condition
+// like "result.rc == 0 and 'ok' in result.stdout", and returns its value.
+// Using an undefined variable is an error, except in "is defined" tests
+// and default filters.
+func (e *Engine) Evaluate(expr string, vars map[string]interface{}) (interface{}, error) {
+	parsed, err := parseJinjaExpr(expr)
+	if err != nil {
+		return nil, types.NewTemplateError("expression", 0, 0, "failed to parse expression", err)
+	}
+	value, err := e.jinjaRenderer().evalDefined(parsed, jinjaRootScope(vars))
+	if err != nil {
+		return nil, types.NewTemplateError("expression", 0, 0, "failed to evaluate expression", err)
+	}
+	return value, nil
+}
+
+// Truthy reports whether a value is true in a Jinja2 condition: false,
+// none, zero and empty strings, lists and dicts are false
+func Truthy(value interface{}) bool {
+	return jinjaTruthy(value)
+}
+
+// jinjaRenderer returns a Jinja2 renderer with the engine's functions,
+// filters and lookups
+func (e *Engine) jinjaRenderer() *jinjaRenderer {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	functions := make(map[string]interface{})
+	for k, v := range e.functions {
+		functions[k] = v
+	}
+	return &jinjaRenderer{functions: functions, filters: e.filters, registry: e.registry, lookups: e.lookups}
+}
+
+// jinjaRootScope returns the top-level scope of a template over its
+// variables; variables the template sets go in a scope of their own
+func jinjaRootScope(vars map[string]interface{}) *jinjaScope {
+	if vars == nil {
+		vars = make(map[string]interface{})
+	}
+	return &jinjaScope{vars: make(map[string]interface{}), parent: &jinjaScope{vars: vars}}
+}
+
+// RenderFile processes a template file with the given variables. Files
+// with a .j2 extension are rendered as Jinja2.
 func (e *Engine) RenderFile(filepath string, vars map[string]interface{}) (string, error) {
 	// Read template file
 	content, err := os.ReadFile(filepath)
@@ -67,7 +204,7 @@ func (e *Engine) RenderFile(filepath string, vars map[string]interface{}) (strin
 	}
 
 	// Render the template content
-	result, err := e.Render(string(content), vars)
+	result, err := e.render(string(content), vars, e.fileSyntax(filepath))
 	if err != nil {
 		// Update error with file information
 		if templateErr, ok := err.(*types.TemplateError); ok {
@@ -80,6 +217,25 @@ func (e *Engine) RenderFile(filepath string, vars map[string]interface{}) (strin
 	return result, nil
 }
 
+// fileSyntax returns the syntax a template file is rendered in
+func (e *Engine) fileSyntax(path string) Syntax {
+	if strings.HasSuffix(path, ".j2") {
+		return SyntaxJinja2
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.syntax
+}
+
+// stripJinjaHeader removes the #jinja2: header line
+func stripJinjaHeader(templateStr string) string {
+	if idx := strings.Index(templateStr, "\n"); idx >= 0 {
+		return templateStr[idx+1:]
+	}
+	return ""
+}
+
 // AddFunction adds a custom function to the template engine
 func (e *Engine) AddFunction(name string, fn interface{}) error {
 	if name == "" {
@@ -153,6 +309,10 @@ func (e *Engine) registerBuiltinFunctions() {
 	// List functions
 	e.functions["list"] = e.list
 	e.functions["dict"] = e.dict
+
+	// Lookup plugin functions
+	e.functions["lookup"] = e.lookup
+	e.functions["query"] = e.query
 }
 
 // Built-in template function implementations
@@ -366,6 +526,25 @@ func (e *Engine) RenderFileWithDefaults(filepath string, vars, defaults map[stri
 
 // ValidateTemplate validates that a template string is syntactically correct
 func (e *Engine) ValidateTemplate(templateStr string) error {
+	e.mu.RLock()
+	syntax := e.syntax
+	e.mu.RUnlock()
+
+	return e.validate(templateStr, syntax)
+}
+
+func (e *Engine) validate(templateStr string, syntax Syntax) error {
+	if strings.HasPrefix(templateStr, jinjaHeader) {
+		syntax = SyntaxJinja2
+		templateStr = stripJinjaHeader(templateStr)
+	}
+	if syntax == SyntaxJinja2 {
+		if _, err := parseJinja(templateStr); err != nil {
+			return types.NewTemplateError("validation", 0, 0, "template validation failed", err)
+		}
+		return nil
+	}
+
 	e.mu.RLock()
 	functions := make(map[string]interface{})
 	for k, v := range e.functions {
@@ -392,7 +571,7 @@ func (e *Engine) ValidateTemplateFile(filepath string) error {
 		return types.NewTemplateError(filepath, 0, 0, "failed to read template file", err)
 	}
 
-	if err := e.ValidateTemplate(string(content)); err != nil {
+	if err := e.validate(string(content), e.fileSyntax(filepath)); err != nil {
 		if templateErr, ok := err.(*types.TemplateError); ok {
 			templateErr.Template = filepath
 		}
@@ -422,6 +601,10 @@ func (e *Engine) Clone() *Engine {
 
 	clone := &Engine{
 		functions: make(map[string]interface{}),
+		syntax:    e.syntax,
+		filters:   e.filters,
+		registry:  e.registry,
+		lookups:   e.lookups,
 	}
 
 	for k, v := range e.functions {
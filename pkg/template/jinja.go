@@ -0,0 +1,899 @@
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Jinja2 support covers the subset of the language Ansible templates use:
+// {{ }} expressions with filters and tests, {% if %}, {% for %} with the
+// loop variable, {% set %}, {% raw %} and {# #} comments, with whitespace
+// control and trim_blocks enabled as in Ansible.
+
+// jinjaNode is a node of a parsed Jinja2 template
+type jinjaNode interface{}
+
+type jinjaText struct {
+	text string
+}
+
+type jinjaOutput struct {
+	expr jinjaExpr
+	line int
+}
+
+type jinjaIf struct {
+	conds  []jinjaExpr
+	bodies [][]jinjaNode
+	orElse []jinjaNode
+}
+
+type jinjaFor struct {
+	vars   []string // Loop variable, or key and value when unpacking
+	iter   jinjaExpr
+	filter jinjaExpr // Optional "if" clause
+	body   []jinjaNode
+	orElse []jinjaNode // Rendered when nothing was iterated
+	line   int
+}
+
+type jinjaSet struct {
+	name string
+	expr jinjaExpr
+}
+
+// jinjaEndRaw matches the tag that closes {% raw %}
+var jinjaEndRaw = regexp.MustCompile(`\{%-?\s*endraw\s*-?%\}`)
+
+// jinjaSegment is a piece of template source: text, an expression or a tag
+type jinjaSegment struct {
+	kind    byte // 't' text, 'e' expression, 's' statement
+	content string
+	line    int
+}
+
+// parseJinja parses a Jinja2 template
+func parseJinja(src string) ([]jinjaNode, error) {
+	segments, err := scanJinja(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &jinjaParser{segments: segments}
+	nodes, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if end != "" {
+		return nil, fmt.Errorf("line %d: unexpected {%% %s %%}", p.line(), end)
+	}
+	return nodes, nil
+}
+
+// scanJinja splits template source into text, expression and statement
+// segments, applying whitespace control and trim_blocks
+func scanJinja(src string) ([]jinjaSegment, error) {
+	var segments []jinjaSegment
+	line := 1
+	trimNext := false
+
+	for len(src) > 0 {
+		start := nextJinjaTag(src)
+		text := src
+		if start >= 0 {
+			text = src[:start]
+		}
+		if trimNext {
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			trimNext = false
+		}
+		if start >= 0 && start+2 < len(src) && src[start+2] == '-' {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		if text != "" {
+			segments = append(segments, jinjaSegment{kind: 't', content: text, line: line})
+		}
+		if start < 0 {
+			break
+		}
+		line += strings.Count(src[:start], "\n")
+
+		open := src[start+1]
+		closer := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[open]
+		body := src[start+2:]
+		end := findJinjaClose(body, closer, open != '#')
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unclosed %s", line, src[start:start+2])
+		}
+		content := body[:end]
+		rest := body[end+2:]
+		content = strings.TrimPrefix(content, "-")
+		if strings.HasSuffix(content, "-") {
+			content = strings.TrimSuffix(content, "-")
+			trimNext = true
+		}
+		content = strings.TrimSpace(content)
+
+		switch open {
+		case '{':
+			segments = append(segments, jinjaSegment{kind: 'e', content: content, line: line})
+		case '%':
+			if content == "raw" {
+				// Everything up to endraw is literal text
+				loc := jinjaEndRaw.FindStringIndex(rest)
+				if loc == nil {
+					return nil, fmt.Errorf("line %d: unclosed {%% raw %%}", line)
+				}
+				segments = append(segments, jinjaSegment{kind: 't', content: rest[:loc[0]], line: line})
+				line += strings.Count(body[:end+2], "\n") + strings.Count(rest[:loc[1]], "\n")
+				src = trimBlockNewline(rest[loc[1]:], false)
+				continue
+			}
+			segments = append(segments, jinjaSegment{kind: 's', content: content, line: line})
+		}
+
+		line += strings.Count(body[:end+2], "\n")
+		if open != '{' {
+			rest = trimBlockNewline(rest, trimNext)
+		}
+		src = rest
+	}
+	return segments, nil
+}
+
+// trimBlockNewline removes the newline after a block tag, as trim_blocks does
+func trimBlockNewline(rest string, trimmed bool) string {
+	if trimmed {
+		return rest
+	}
+	if strings.HasPrefix(rest, "\r\n") {
+		return rest[2:]
+	}
+	return strings.TrimPrefix(rest, "\n")
+}
+
+// nextJinjaTag returns the index of the next {{, {% or {#
+func nextJinjaTag(src string) int {
+	for i := 0; i+1 < len(src); i++ {
+		if src[i] == '{' && (src[i+1] == '{' || src[i+1] == '%' || src[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// findJinjaClose returns the index of closer in body, skipping quoted
+// strings in expressions and statements
+func findJinjaClose(body, closer string, code bool) int {
+	var quote byte
+	for i := 0; i+1 < len(body); i++ {
+		c := body[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if code && (c == '\'' || c == '"') {
+			quote = c
+			continue
+		}
+		if body[i:i+2] == closer {
+			return i
+		}
+	}
+	return -1
+}
+
+// jinjaParser builds nodes from segments
+type jinjaParser struct {
+	segments []jinjaSegment
+	pos      int
+}
+
+func (p *jinjaParser) line() int {
+	if p.pos > 0 && p.pos <= len(p.segments) {
+		return p.segments[p.pos-1].line
+	}
+	return 0
+}
+
+// parseBody parses nodes until a closing or intermediate tag such as endif
+// or else, which it returns without consuming its contents
+func (p *jinjaParser) parseBody() ([]jinjaNode, string, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.segments) {
+		seg := p.segments[p.pos]
+		p.pos++
+
+		switch seg.kind {
+		case 't':
+			nodes = append(nodes, &jinjaText{text: seg.content})
+		case 'e':
+			expr, err := parseJinjaExpr(seg.content)
+			if err != nil {
+				return nil, "", fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			nodes = append(nodes, &jinjaOutput{expr: expr, line: seg.line})
+		case 's':
+			tag, rest := splitJinjaTag(seg.content)
+			switch tag {
+			case "if":
+				node, err := p.parseIf(rest, seg.line)
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, node)
+			case "for":
+				node, err := p.parseFor(rest, seg.line)
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, node)
+			case "set":
+				name, value, ok := strings.Cut(rest, "=")
+				name = strings.TrimSpace(name)
+				if !ok || !isJinjaName(name) {
+					return nil, "", fmt.Errorf("line %d: invalid set statement %q", seg.line, seg.content)
+				}
+				expr, err := parseJinjaExpr(value)
+				if err != nil {
+					return nil, "", fmt.Errorf("line %d: %w", seg.line, err)
+				}
+				nodes = append(nodes, &jinjaSet{name: name, expr: expr})
+			case "elif", "else", "endif", "endfor":
+				// Hand the tag and its arguments back to the enclosing block
+				return nodes, seg.content, nil
+			default:
+				return nil, "", fmt.Errorf("line %d: unsupported tag {%% %s %%}", seg.line, tag)
+			}
+		}
+	}
+	return nodes, "", nil
+}
+
+func (p *jinjaParser) parseIf(cond string, line int) (jinjaNode, error) {
+	node := &jinjaIf{}
+	for {
+		expr, err := parseJinjaExpr(cond)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		body, end, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		node.conds = append(node.conds, expr)
+		node.bodies = append(node.bodies, body)
+
+		tag, rest := splitJinjaTag(end)
+		switch tag {
+		case "elif":
+			cond, line = rest, p.line()
+			continue
+		case "else":
+			orElse, end, err := p.parseBody()
+			if err != nil {
+				return nil, err
+			}
+			if end != "endif" {
+				return nil, fmt.Errorf("line %d: expected {%% endif %%}", line)
+			}
+			node.orElse = orElse
+			return node, nil
+		case "endif":
+			return node, nil
+		default:
+			return nil, fmt.Errorf("line %d: {%% if %%} is not closed", line)
+		}
+	}
+}
+
+func (p *jinjaParser) parseFor(spec string, line int) (jinjaNode, error) {
+	target, source, ok := strings.Cut(spec, " in ")
+	if !ok {
+		return nil, fmt.Errorf("line %d: expected 'for x in items'", line)
+	}
+	node := &jinjaFor{line: line}
+	for _, name := range strings.Split(target, ",") {
+		name = strings.TrimSpace(name)
+		if !isJinjaName(name) {
+			return nil, fmt.Errorf("line %d: invalid loop variable %q", line, name)
+		}
+		node.vars = append(node.vars, name)
+	}
+
+	lexer, err := lexJinja(source)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	ep := &jinjaExprParser{tokens: lexer}
+	if node.iter, err = ep.parseOr(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	if ep.acceptName("if") {
+		if node.filter, err = ep.parseOr(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if !ep.done() {
+		return nil, fmt.Errorf("line %d: unexpected %q in for loop", line, ep.peek().text)
+	}
+
+	body, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	node.body = body
+	if end == "else" {
+		if node.orElse, end, err = p.parseBody(); err != nil {
+			return nil, err
+		}
+	}
+	if end != "endfor" {
+		return nil, fmt.Errorf("line %d: {%% for %%} is not closed", line)
+	}
+	return node, nil
+}
+
+func splitJinjaTag(content string) (string, string) {
+	tag, rest, _ := strings.Cut(strings.TrimSpace(content), " ")
+	return tag, strings.TrimSpace(rest)
+}
+
+func isJinjaName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Expressions
+
+type jinjaExpr interface{}
+
+type jinjaLiteral struct{ value interface{} }
+type jinjaName struct{ name string }
+type jinjaList struct{ items []jinjaExpr }
+type jinjaDict struct{ keys, values []jinjaExpr }
+type jinjaAttr struct {
+	obj  jinjaExpr
+	name string
+}
+type jinjaIndex struct{ obj, index jinjaExpr }
+type jinjaCall struct {
+	fn     jinjaExpr
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+type jinjaFilter struct {
+	value  jinjaExpr
+	name   string
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+type jinjaTest struct {
+	value  jinjaExpr
+	name   string
+	args   []jinjaExpr
+	negate bool
+}
+type jinjaUnary struct {
+	op      string
+	operand jinjaExpr
+}
+type jinjaBinary struct {
+	op          string
+	left, right jinjaExpr
+}
+type jinjaCond struct{ cond, then, orElse jinjaExpr }
+
+type jinjaToken struct {
+	kind byte // 'n' name, 's' string, 'i' int, 'f' float, 'o' operator
+	text string
+}
+
+// lexJinja splits an expression into tokens
+func lexJinja(src string) ([]jinjaToken, error) {
+	var tokens []jinjaToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string in %q", src)
+			}
+			tokens = append(tokens, jinjaToken{kind: 's', text: b.String()})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			kind := byte('i')
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' && kind == 'i' && j+1 < len(src) && src[j+1] >= '0' && src[j+1] <= '9') {
+				if src[j] == '.' {
+					kind = 'f'
+				}
+				j++
+			}
+			tokens = append(tokens, jinjaToken{kind: kind, text: src[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, jinjaToken{kind: 'n', text: src[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"//", "**", "==", "!=", "<=", ">="} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("()[]{},:.|~+-*/%<>=", rune(c)) {
+					return nil, fmt.Errorf("unexpected character %q in %q", c, src)
+				}
+				op = string(c)
+			}
+			tokens = append(tokens, jinjaToken{kind: 'o', text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// parseJinjaExpr parses a complete expression
+func parseJinjaExpr(src string) (jinjaExpr, error) {
+	tokens, err := lexJinja(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &jinjaExprParser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q in %q", p.peek().text, src)
+	}
+	return expr, nil
+}
+
+// jinjaExprParser is a recursive descent parser following Jinja2's
+// operator precedence
+type jinjaExprParser struct {
+	tokens []jinjaToken
+	pos    int
+}
+
+func (p *jinjaExprParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *jinjaExprParser) peek() jinjaToken {
+	if p.done() {
+		return jinjaToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *jinjaExprParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == 'o' && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) acceptName(name string) bool {
+	if t := p.peek(); t.kind == 'n' && t.text == name {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		if p.done() {
+			return fmt.Errorf("expected %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q, got %q", op, p.peek().text)
+	}
+	return nil
+}
+
+func (p *jinjaExprParser) parseExpr() (jinjaExpr, error) {
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptName("if") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		var orElse jinjaExpr
+		if p.acceptName("else") {
+			if orElse, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		return &jinjaCond{cond: cond, then: expr, orElse: orElse}, nil
+	}
+	return expr, nil
+}
+
+func (p *jinjaExprParser) parseOr() (jinjaExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.acceptName("or") {
+		var right jinjaExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = &jinjaBinary{op: "or", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAnd() (jinjaExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.acceptName("and") {
+		var right jinjaExpr
+		if right, err = p.parseNot(); err == nil {
+			left = &jinjaBinary{op: "and", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseNot() (jinjaExpr, error) {
+	if p.acceptName("not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &jinjaUnary{op: "not", operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *jinjaExprParser) parseCompare() (jinjaExpr, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == 'o' && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == ">" || t.text == "<=" || t.text == ">="):
+			p.pos++
+			right, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+			left = &jinjaBinary{op: t.text, left: left, right: right}
+		case t.kind == 'n' && t.text == "in":
+			p.pos++
+			right, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+			left = &jinjaBinary{op: "in", left: left, right: right}
+		case t.kind == 'n' && t.text == "not" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == "in":
+			p.pos += 2
+			right, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+			left = &jinjaUnary{op: "not", operand: &jinjaBinary{op: "in", left: left, right: right}}
+		case t.kind == 'n' && t.text == "is":
+			p.pos++
+			test := &jinjaTest{value: left, negate: p.acceptName("not")}
+			name := p.peek()
+			if name.kind != 'n' {
+				return nil, fmt.Errorf("expected a test name after 'is'")
+			}
+			p.pos++
+			test.name = name.text
+			if p.acceptOp("(") {
+				if test.args, _, err = p.parseArgs(); err != nil {
+					return nil, err
+				}
+			} else if next := p.peek(); next.kind == 's' || next.kind == 'i' || next.kind == 'f' {
+				// Tests take a single argument without parentheses: x is divisibleby 3
+				arg, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				test.args = []jinjaExpr{arg}
+			}
+			left = test
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *jinjaExprParser) parseConcat() (jinjaExpr, error) {
+	left, err := p.parseAdd()
+	for err == nil && p.acceptOp("~") {
+		var right jinjaExpr
+		if right, err = p.parseAdd(); err == nil {
+			left = &jinjaBinary{op: "~", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAdd() (jinjaExpr, error) {
+	left, err := p.parseMul()
+	for err == nil {
+		t := p.peek()
+		if t.kind != 'o' || (t.text != "+" && t.text != "-") {
+			break
+		}
+		p.pos++
+		var right jinjaExpr
+		if right, err = p.parseMul(); err == nil {
+			left = &jinjaBinary{op: t.text, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseMul() (jinjaExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != 'o' || (t.text != "*" && t.text != "/" && t.text != "//" && t.text != "%" && t.text != "**") {
+			break
+		}
+		p.pos++
+		var right jinjaExpr
+		if right, err = p.parseUnary(); err == nil {
+			left = &jinjaBinary{op: t.text, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseUnary() (jinjaExpr, error) {
+	if p.acceptOp("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &jinjaUnary{op: "-", operand: operand}, nil
+	}
+	p.acceptOp("+")
+	return p.parsePostfix()
+}
+
+func (p *jinjaExprParser) parsePostfix() (jinjaExpr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.acceptOp("."):
+			t := p.peek()
+			if t.kind != 'n' && t.kind != 'i' {
+				return nil, fmt.Errorf("expected an attribute name after '.'")
+			}
+			p.pos++
+			expr = &jinjaAttr{obj: expr, name: t.text}
+		case p.acceptOp("["):
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			expr = &jinjaIndex{obj: expr, index: index}
+		case p.acceptOp("("):
+			args, kwargs, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			expr = &jinjaCall{fn: expr, args: args, kwargs: kwargs}
+		case p.acceptOp("|"):
+			t := p.peek()
+			if t.kind != 'n' {
+				return nil, fmt.Errorf("expected a filter name after '|'")
+			}
+			p.pos++
+			filter := &jinjaFilter{value: expr, name: t.text}
+			if p.acceptOp("(") {
+				args, kwargs, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				filter.args = args
+				filter.kwargs = kwargs
+			}
+			expr = filter
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// parseArgs parses call arguments after the opening parenthesis
+func (p *jinjaExprParser) parseArgs() ([]jinjaExpr, map[string]jinjaExpr, error) {
+	var args []jinjaExpr
+	var kwargs map[string]jinjaExpr
+	for !p.acceptOp(")") {
+		if len(args) > 0 || len(kwargs) > 0 {
+			if err := p.expectOp(","); err != nil {
+				return nil, nil, err
+			}
+			if p.acceptOp(")") {
+				break
+			}
+		}
+		if t := p.peek(); t.kind == 'n' && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == "=" {
+			p.pos += 2
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, nil, err
+			}
+			if kwargs == nil {
+				kwargs = make(map[string]jinjaExpr)
+			}
+			kwargs[t.text] = value
+			continue
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, arg)
+		if p.done() {
+			return nil, nil, fmt.Errorf("expected ')' at end of expression")
+		}
+	}
+	return args, kwargs, nil
+}
+
+func (p *jinjaExprParser) parsePrimary() (jinjaExpr, error) {
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case 's':
+		// Adjacent strings are concatenated
+		value := t.text
+		for p.peek().kind == 's' {
+			value += p.tokens[p.pos].text
+			p.pos++
+		}
+		return &jinjaLiteral{value: value}, nil
+	case 'i':
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &jinjaLiteral{value: n}, nil
+	case 'f':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &jinjaLiteral{value: f}, nil
+	case 'n':
+		switch t.text {
+		case "true", "True":
+			return &jinjaLiteral{value: true}, nil
+		case "false", "False":
+			return &jinjaLiteral{value: false}, nil
+		case "none", "None":
+			return &jinjaLiteral{value: nil}, nil
+		}
+		return &jinjaName{name: t.text}, nil
+	}
+
+	switch t.text {
+	case "(":
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptOp(",") {
+			// A tuple, treated as a list
+			list := &jinjaList{items: []jinjaExpr{expr}}
+			for !p.acceptOp(")") {
+				item, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.acceptOp(",") {
+					if err := p.expectOp(")"); err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+			return list, nil
+		}
+		return expr, p.expectOp(")")
+	case "[":
+		list := &jinjaList{}
+		for !p.acceptOp("]") {
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+			if !p.acceptOp(",") {
+				if err := p.expectOp("]"); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		return list, nil
+	case "{":
+		dict := &jinjaDict{}
+		for !p.acceptOp("}") {
+			key, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			dict.keys = append(dict.keys, key)
+			dict.values = append(dict.values, value)
+			if !p.acceptOp(",") {
+				if err := p.expectOp("}"); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
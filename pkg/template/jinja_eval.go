@@ -0,0 +1,1343 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/filter"
+	"github.com/liliang-cn/gosible/pkg/lookup"
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// jinjaUndefined is the value of a variable or attribute that does not
+// exist. Like Ansible, rendering it is an error unless a default applies.
+type jinjaUndefined struct {
+	name string
+}
+
+func (u jinjaUndefined) err() error {
+	return fmt.Errorf("'%s' is undefined", u.name)
+}
+
+// jinjaScope holds the variables visible to a part of a template. Loops get
+// their own scope, so variables they set do not leak out.
+type jinjaScope struct {
+	vars   map[string]interface{}
+	parent *jinjaScope
+}
+
+func (s *jinjaScope) lookup(name string) (interface{}, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.vars[name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// jinjaRenderer renders parsed Jinja2 templates. Filters come from the
+// filter plugin manager, then the template filter registry; lookup() and
+// query() call lookup plugins.
+type jinjaRenderer struct {
+	functions map[string]interface{}
+	filters   *filter.FilterManager
+	registry  *FilterRegistry
+	lookups   *lookup.LookupManager
+	out       strings.Builder
+}
+
+func (r *jinjaRenderer) render(nodes []jinjaNode, scope *jinjaScope) error {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *jinjaText:
+			r.out.WriteString(n.text)
+
+		case *jinjaOutput:
+			value, err := r.eval(n.expr, scope)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n.line, err)
+			}
+			if u, ok := value.(jinjaUndefined); ok {
+				return fmt.Errorf("line %d: %w", n.line, u.err())
+			}
+			r.out.WriteString(jinjaString(value))
+
+		case *jinjaIf:
+			body := n.orElse
+			for i, cond := range n.conds {
+				value, err := r.eval(cond, scope)
+				if err != nil {
+					return err
+				}
+				if jinjaTruthy(value) {
+					body = n.bodies[i]
+					break
+				}
+			}
+			if err := r.render(body, scope); err != nil {
+				return err
+			}
+
+		case *jinjaFor:
+			if err := r.renderFor(n, scope); err != nil {
+				return err
+			}
+
+		case *jinjaSet:
+			value, err := r.eval(n.expr, scope)
+			if err != nil {
+				return err
+			}
+			scope.vars[n.name] = value
+		}
+	}
+	return nil
+}
+
+func (r *jinjaRenderer) renderFor(n *jinjaFor, scope *jinjaScope) error {
+	value, err := r.eval(n.iter, scope)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", n.line, err)
+	}
+	items, err := jinjaItems(value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", n.line, err)
+	}
+
+	// Bind the loop variables of each item, dropping those the if clause rejects
+	var bound []map[string]interface{}
+	for _, item := range items {
+		vars := make(map[string]interface{})
+		if len(n.vars) == 1 {
+			vars[n.vars[0]] = item
+		} else {
+			values, err := jinjaItems(item)
+			if err != nil || len(values) != len(n.vars) {
+				return fmt.Errorf("line %d: cannot unpack %s into %d variables", n.line, jinjaRepr(item), len(n.vars))
+			}
+			for i, name := range n.vars {
+				vars[name] = values[i]
+			}
+		}
+		if n.filter != nil {
+			keep, err := r.eval(n.filter, &jinjaScope{vars: vars, parent: scope})
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n.line, err)
+			}
+			if !jinjaTruthy(keep) {
+				continue
+			}
+		}
+		bound = append(bound, vars)
+	}
+
+	if len(bound) == 0 {
+		return r.render(n.orElse, &jinjaScope{vars: map[string]interface{}{}, parent: scope})
+	}
+	for i, vars := range bound {
+		vars["loop"] = map[string]interface{}{
+			"index":     i + 1,
+			"index0":    i,
+			"revindex":  len(bound) - i,
+			"revindex0": len(bound) - i - 1,
+			"first":     i == 0,
+			"last":      i == len(bound)-1,
+			"length":    len(bound),
+		}
+		if err := r.render(n.body, &jinjaScope{vars: vars, parent: scope}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *jinjaRenderer) eval(expr jinjaExpr, scope *jinjaScope) (interface{}, error) {
+	switch e := expr.(type) {
+	case *jinjaLiteral:
+		return e.value, nil
+
+	case *jinjaName:
+		if value, ok := scope.lookup(e.name); ok {
+			return value, nil
+		}
+		return jinjaUndefined{name: e.name}, nil
+
+	case *jinjaList:
+		list := make([]interface{}, 0, len(e.items))
+		for _, item := range e.items {
+			value, err := r.evalDefined(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case *jinjaDict:
+		dict := make(map[string]interface{}, len(e.keys))
+		for i := range e.keys {
+			key, err := r.evalDefined(e.keys[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.evalDefined(e.values[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			dict[jinjaString(key)] = value
+		}
+		return dict, nil
+
+	case *jinjaAttr:
+		obj, err := r.eval(e.obj, scope)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGetItem(obj, e.name, describeJinja(e)), nil
+
+	case *jinjaIndex:
+		obj, err := r.eval(e.obj, scope)
+		if err != nil {
+			return nil, err
+		}
+		index, err := r.evalDefined(e.index, scope)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGetItem(obj, index, describeJinja(e)), nil
+
+	case *jinjaCall:
+		return r.call(e, scope)
+
+	case *jinjaFilter:
+		return r.applyFilter(e, scope)
+
+	case *jinjaTest:
+		return r.applyTest(e, scope)
+
+	case *jinjaUnary:
+		operand, err := r.eval(e.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "not" {
+			return !jinjaTruthy(operand), nil
+		}
+		if u, ok := operand.(jinjaUndefined); ok {
+			return nil, u.err()
+		}
+		return jinjaArith("-", 0, operand)
+
+	case *jinjaBinary:
+		return r.evalBinary(e, scope)
+
+	case *jinjaCond:
+		cond, err := r.eval(e.cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		if jinjaTruthy(cond) {
+			return r.eval(e.then, scope)
+		}
+		if e.orElse == nil {
+			return jinjaUndefined{name: "conditional expression"}, nil
+		}
+		return r.eval(e.orElse, scope)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// evalDefined evaluates an expression that must not be undefined
+func (r *jinjaRenderer) evalDefined(expr jinjaExpr, scope *jinjaScope) (interface{}, error) {
+	value, err := r.eval(expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := value.(jinjaUndefined); ok {
+		return nil, u.err()
+	}
+	return value, nil
+}
+
+func (r *jinjaRenderer) evalBinary(e *jinjaBinary, scope *jinjaScope) (interface{}, error) {
+	left, err := r.eval(e.left, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// and/or short-circuit and return an operand, like Python
+	switch e.op {
+	case "and":
+		if !jinjaTruthy(left) {
+			return left, nil
+		}
+		return r.eval(e.right, scope)
+	case "or":
+		if jinjaTruthy(left) {
+			return left, nil
+		}
+		return r.eval(e.right, scope)
+	}
+
+	right, err := r.eval(e.right, scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, operand := range []interface{}{left, right} {
+		if u, ok := operand.(jinjaUndefined); ok {
+			return nil, u.err()
+		}
+	}
+
+	switch e.op {
+	case "~":
+		return jinjaString(left) + jinjaString(right), nil
+	case "==":
+		return jinjaEqual(left, right), nil
+	case "!=":
+		return !jinjaEqual(left, right), nil
+	case "<", ">", "<=", ">=":
+		cmp, err := jinjaCompare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]bool{"<": cmp < 0, ">": cmp > 0, "<=": cmp <= 0, ">=": cmp >= 0}[e.op], nil
+	case "in":
+		return jinjaContains(right, left)
+	}
+	return jinjaArith(e.op, left, right)
+}
+
+// call evaluates a function or method call
+func (r *jinjaRenderer) call(e *jinjaCall, scope *jinjaScope) (interface{}, error) {
+	if fn, ok := e.fn.(*jinjaName); ok && isLookupFunction(fn.name) {
+		if _, shadowed := scope.lookup(fn.name); !shadowed {
+			return r.lookup(e, fn.name, scope)
+		}
+	}
+
+	args := make([]interface{}, 0, len(e.args))
+	for _, arg := range e.args {
+		value, err := r.evalDefined(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	for _, name := range sortedKeys(e.kwargs) {
+		value, err := r.evalDefined(e.kwargs[name], scope)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+
+	switch fn := e.fn.(type) {
+	case *jinjaAttr:
+		// Methods of strings, lists and dicts
+		obj, err := r.evalDefined(fn.obj, scope)
+		if err != nil {
+			return nil, err
+		}
+		if result, ok, err := jinjaMethod(obj, fn.name, args); ok {
+			return result, err
+		}
+		target := jinjaGetItem(obj, fn.name, describeJinja(fn))
+		if u, ok := target.(jinjaUndefined); ok {
+			return nil, u.err()
+		}
+		return callGoFunction(fn.name, target, args)
+
+	case *jinjaName:
+		if value, ok := scope.lookup(fn.name); ok {
+			return callGoFunction(fn.name, value, args)
+		}
+		if fn.name == "range" {
+			return jinjaRange(args)
+		}
+		if f, ok := r.functions[fn.name]; ok {
+			return callGoFunction(fn.name, f, args)
+		}
+		return nil, fmt.Errorf("'%s' is undefined", fn.name)
+	}
+	return nil, fmt.Errorf("cannot call %s", describeJinja(e.fn))
+}
+
+// applyFilter applies a filter. default and d handle undefined values,
+// every other filter needs a defined input.
+func (r *jinjaRenderer) applyFilter(e *jinjaFilter, scope *jinjaScope) (interface{}, error) {
+	value, err := r.eval(e.value, scope)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, 0, len(e.args)+len(e.kwargs))
+	for _, arg := range e.args {
+		v, err := r.evalDefined(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	kwargs := make(map[string]interface{}, len(e.kwargs))
+	for name, arg := range e.kwargs {
+		v, err := r.evalDefined(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		kwargs[name] = v
+	}
+	if e.name == "combine" {
+		return jinjaCombine(value, args, kwargs)
+	}
+
+	// Other filters take keyword arguments after the positional ones, in
+	// name order
+	for _, name := range sortedKeys(kwargs) {
+		args = append(args, kwargs[name])
+	}
+
+	switch e.name {
+	case "default", "d":
+		var fallback interface{} = ""
+		if len(args) > 0 {
+			fallback = args[0]
+		}
+		_, undefined := value.(jinjaUndefined)
+		if undefined || (len(args) > 1 && jinjaTruthy(args[1]) && !jinjaTruthy(value)) {
+			return fallback, nil
+		}
+		return value, nil
+	case "mandatory":
+		if u, ok := value.(jinjaUndefined); ok {
+			return nil, fmt.Errorf("mandatory variable %w", u.err())
+		}
+		return value, nil
+	}
+
+	if u, ok := value.(jinjaUndefined); ok {
+		return nil, u.err()
+	}
+	switch e.name {
+	case "string":
+		return jinjaString(value), nil
+	case "length", "count":
+		items, err := jinjaItems(value)
+		if err != nil {
+			return nil, fmt.Errorf("length: %w", err)
+		}
+		return len(items), nil
+	case "list":
+		return jinjaItems(value)
+	case "select", "reject", "selectattr", "rejectattr":
+		return jinjaSelect(e.name, value, args)
+	}
+
+	if r.filters != nil {
+		if _, err := r.filters.Get(e.name); err == nil {
+			return r.filters.Apply(e.name, value, args...)
+		}
+	}
+	if r.registry != nil {
+		if fn, ok := r.registry.Get(e.name); ok {
+			return fn(value, args...)
+		}
+	}
+	return nil, fmt.Errorf("no filter named '%s'", e.name)
+}
+
+// jinjaCombine merges dicts like Ansible's combine filter: each argument,
+// or each dict of a list argument, overrides the ones before it. The
+// recursive and list_merge keyword arguments are those of
+// types.CombineMaps.
+func jinjaCombine(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if u, ok := value.(jinjaUndefined); ok {
+		return nil, u.err()
+	}
+
+	recursive := jinjaTruthy(kwargs["recursive"])
+	listMerge := "replace"
+	if mode, ok := kwargs["list_merge"]; ok {
+		listMerge = jinjaString(mode)
+	}
+	for name := range kwargs {
+		if name != "recursive" && name != "list_merge" {
+			return nil, fmt.Errorf("combine: unexpected keyword argument %s", name)
+		}
+	}
+
+	dicts := []interface{}{value}
+	for _, arg := range args {
+		if list, ok := arg.([]interface{}); ok {
+			dicts = append(dicts, list...)
+		} else {
+			dicts = append(dicts, arg)
+		}
+	}
+
+	result := map[string]interface{}{}
+	for _, dict := range dicts {
+		m, ok := dict.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("combine: expected dicts, got %T", dict)
+		}
+		merged, err := types.CombineMaps(result, m, recursive, listMerge)
+		if err != nil {
+			return nil, fmt.Errorf("combine: %w", err)
+		}
+		result = merged
+	}
+	return result, nil
+}
+
+func (r *jinjaRenderer) applyTest(e *jinjaTest, scope *jinjaScope) (interface{}, error) {
+	value, err := r.eval(e.value, scope)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, 0, len(e.args))
+	for _, arg := range e.args {
+		v, err := r.evalDefined(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	_, undefined := value.(jinjaUndefined)
+	var result bool
+	switch e.name {
+	case "defined":
+		result = !undefined
+	case "undefined":
+		result = undefined
+	default:
+		if undefined {
+			return nil, value.(jinjaUndefined).err()
+		}
+		if result, err = jinjaTestValue(e.name, value, args); err != nil {
+			return nil, err
+		}
+	}
+	return result != e.negate, nil
+}
+
+// jinjaTestValue runs the tests other than defined and undefined
+func jinjaTestValue(name string, value interface{}, args []interface{}) (bool, error) {
+	arg := func() (interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("test '%s' needs an argument", name)
+		}
+		return args[0], nil
+	}
+
+	switch name {
+	case "none":
+		return value == nil, nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok, nil
+	case "true":
+		return value == true, nil
+	case "false":
+		return value == false, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	case "number", "integer", "float":
+		if name == "integer" {
+			_, ok := jinjaInt(value)
+			return ok, nil
+		}
+		if name == "float" {
+			_, ok := value.(float64)
+			return ok, nil
+		}
+		_, ok := jinjaFloat(value)
+		return ok, nil
+	case "mapping":
+		return reflect.ValueOf(value).Kind() == reflect.Map, nil
+	case "sequence", "iterable":
+		_, err := jinjaItems(value)
+		return err == nil, nil
+	case "even", "odd", "divisibleby":
+		n, ok := jinjaInt(value)
+		if !ok {
+			return false, fmt.Errorf("test '%s' needs an integer", name)
+		}
+		switch name {
+		case "even":
+			return n%2 == 0, nil
+		case "odd":
+			return n%2 != 0, nil
+		}
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		d, ok := jinjaInt(a)
+		if !ok || d == 0 {
+			return false, fmt.Errorf("divisibleby needs a non-zero integer")
+		}
+		return n%d == 0, nil
+	case "eq", "equalto", "==", "ne", "!=":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		return jinjaEqual(value, a) == (name != "ne" && name != "!="), nil
+	case "lt", "gt", "le", "ge":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		cmp, err := jinjaCompare(value, a)
+		if err != nil {
+			return false, err
+		}
+		return map[string]bool{"lt": cmp < 0, "gt": cmp > 0, "le": cmp <= 0, "ge": cmp >= 0}[name], nil
+	case "in":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		return jinjaContains(a, value)
+	case "match", "search", "regex":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		pattern := jinjaString(a)
+		if name == "match" {
+			pattern = "^(?:" + pattern + ")"
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(jinjaString(value)), nil
+	case "contains":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		return jinjaContains(value, a)
+	case "subset", "superset":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		small, large := value, a
+		if name == "superset" {
+			small, large = a, value
+		}
+		items, err := jinjaItems(small)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range items {
+			if ok, err := jinjaContains(large, item); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case "version", "version_compare":
+		a, err := arg()
+		if err != nil {
+			return false, err
+		}
+		op := "eq"
+		if len(args) > 1 {
+			op = jinjaString(args[1])
+		}
+		return jinjaVersionTest(jinjaString(value), jinjaString(a), op)
+	case "succeeded", "success", "failed", "failure", "changed", "change", "skipped", "skip":
+		return jinjaResultTest(name, value)
+	}
+	return false, fmt.Errorf("no test named '%s'", name)
+}
+
+// jinjaMethod calls the Python methods templates commonly use on strings,
+// lists and dicts
+func jinjaMethod(obj interface{}, name string, args []interface{}) (interface{}, bool, error) {
+	strArg := func(i int) string {
+		if i < len(args) {
+			return jinjaString(args[i])
+		}
+		return ""
+	}
+
+	if s, ok := obj.(string); ok {
+		switch name {
+		case "upper":
+			return strings.ToUpper(s), true, nil
+		case "lower":
+			return strings.ToLower(s), true, nil
+		case "strip":
+			if len(args) > 0 {
+				return strings.Trim(s, strArg(0)), true, nil
+			}
+			return strings.TrimSpace(s), true, nil
+		case "startswith":
+			return strings.HasPrefix(s, strArg(0)), true, nil
+		case "endswith":
+			return strings.HasSuffix(s, strArg(0)), true, nil
+		case "replace":
+			return strings.ReplaceAll(s, strArg(0), strArg(1)), true, nil
+		case "split":
+			var parts []string
+			if len(args) == 0 {
+				parts = strings.Fields(s)
+			} else {
+				parts = strings.Split(s, strArg(0))
+			}
+			list := make([]interface{}, len(parts))
+			for i, part := range parts {
+				list[i] = part
+			}
+			return list, true, nil
+		case "join":
+			items, err := jinjaItems(args[0])
+			if err != nil {
+				return nil, true, err
+			}
+			strs := make([]string, len(items))
+			for i, item := range items {
+				strs[i] = jinjaString(item)
+			}
+			return strings.Join(strs, s), true, nil
+		case "format":
+			return fmt.Sprintf(strings.ReplaceAll(s, "%s", "%v"), args...), true, nil
+		}
+		return nil, false, nil
+	}
+
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Map {
+		return nil, false, nil
+	}
+	keys := jinjaMapKeys(v)
+	switch name {
+	case "keys":
+		list := make([]interface{}, len(keys))
+		for i, key := range keys {
+			list[i] = key.Interface()
+		}
+		return list, true, nil
+	case "values", "items":
+		list := make([]interface{}, len(keys))
+		for i, key := range keys {
+			if name == "values" {
+				list[i] = v.MapIndex(key).Interface()
+			} else {
+				list[i] = []interface{}{key.Interface(), v.MapIndex(key).Interface()}
+			}
+		}
+		return list, true, nil
+	case "get":
+		if len(args) == 0 {
+			return nil, true, fmt.Errorf("get needs a key")
+		}
+		if value := jinjaGetItem(obj, args[0], ""); !isJinjaUndefined(value) {
+			return value, true, nil
+		}
+		if len(args) > 1 {
+			return args[1], true, nil
+		}
+		return nil, true, nil
+	}
+	return nil, false, nil
+}
+
+// callGoFunction calls a function registered with the engine, converting
+// the arguments to its parameter types
+func callGoFunction(name string, fn interface{}, args []interface{}) (interface{}, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func {
+		return nil, fmt.Errorf("'%s' is not callable", name)
+	}
+	t := f.Type()
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var want reflect.Type
+		switch {
+		case t.IsVariadic() && i >= t.NumIn()-1:
+			want = t.In(t.NumIn() - 1).Elem()
+		case i < t.NumIn():
+			want = t.In(i)
+		default:
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", name, t.NumIn(), len(args))
+		}
+		value := reflect.ValueOf(arg)
+		switch {
+		case arg == nil:
+			value = reflect.Zero(want)
+		case value.Type().AssignableTo(want):
+		case value.Type().ConvertibleTo(want) && value.Kind() != reflect.String && want.Kind() != reflect.String:
+			value = value.Convert(want)
+		case want.Kind() == reflect.String:
+			value = reflect.ValueOf(jinjaString(arg)).Convert(want)
+		case want.Kind() == reflect.Slice && value.Kind() == reflect.Slice:
+			converted := reflect.MakeSlice(want, value.Len(), value.Len())
+			for j := 0; j < value.Len(); j++ {
+				item := reflect.ValueOf(value.Index(j).Interface())
+				if !item.IsValid() || !item.Type().ConvertibleTo(want.Elem()) {
+					return nil, fmt.Errorf("%s: argument %d has the wrong type", name, i+1)
+				}
+				converted.Index(j).Set(item.Convert(want.Elem()))
+			}
+			value = converted
+		default:
+			return nil, fmt.Errorf("%s: argument %d must be %s, got %T", name, i+1, want, arg)
+		}
+		in[i] = value
+	}
+	if !t.IsVariadic() && len(in) < t.NumIn() || t.IsVariadic() && len(in) < t.NumIn()-1 {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, t.NumIn(), len(args))
+	}
+
+	out := f.Call(in)
+	if len(out) == 0 {
+		return nil, nil
+	}
+	if last := out[len(out)-1]; t.Out(len(out)-1) == reflect.TypeOf((*error)(nil)).Elem() {
+		if !last.IsNil() {
+			return nil, last.Interface().(error)
+		}
+		if len(out) == 1 {
+			return nil, nil
+		}
+	}
+	return out[0].Interface(), nil
+}
+
+func jinjaRange(args []interface{}) (interface{}, error) {
+	bounds := make([]int, len(args))
+	for i, arg := range args {
+		n, ok := jinjaInt(arg)
+		if !ok {
+			return nil, fmt.Errorf("range needs integers")
+		}
+		bounds[i] = n
+	}
+	start, stop, step := 0, 0, 1
+	switch len(bounds) {
+	case 1:
+		stop = bounds[0]
+	case 2:
+		start, stop = bounds[0], bounds[1]
+	case 3:
+		start, stop, step = bounds[0], bounds[1], bounds[2]
+	default:
+		return nil, fmt.Errorf("range takes 1 to 3 arguments")
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("range step cannot be zero")
+	}
+	var list []interface{}
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+// Values
+
+func isJinjaUndefined(value interface{}) bool {
+	_, ok := value.(jinjaUndefined)
+	return ok
+}
+
+// describeJinja names an expression in undefined variable errors
+func describeJinja(expr jinjaExpr) string {
+	switch e := expr.(type) {
+	case *jinjaName:
+		return e.name
+	case *jinjaAttr:
+		return describeJinja(e.obj) + "." + e.name
+	case *jinjaIndex:
+		if lit, ok := e.index.(*jinjaLiteral); ok {
+			return fmt.Sprintf("%s[%s]", describeJinja(e.obj), jinjaRepr(lit.value))
+		}
+		return describeJinja(e.obj) + "[...]"
+	}
+	return "expression"
+}
+
+// jinjaGetItem returns a dict key, list index or struct field, or an
+// undefined value named after the expression
+func jinjaGetItem(obj, key interface{}, name string) interface{} {
+	if isJinjaUndefined(obj) {
+		return jinjaUndefined{name: name}
+	}
+
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Map:
+		k := reflect.ValueOf(key)
+		if k.IsValid() && k.Type().AssignableTo(v.Type().Key()) {
+			if value := v.MapIndex(k); value.IsValid() {
+				return value.Interface()
+			}
+		} else if v.Type().Key().Kind() == reflect.String {
+			if value := v.MapIndex(reflect.ValueOf(jinjaString(key)).Convert(v.Type().Key())); value.IsValid() {
+				return value.Interface()
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.String:
+		i, ok := jinjaInt(key)
+		if s, isString := key.(string); isString {
+			i, ok = 0, false
+			if n, err := strconv.Atoi(s); err == nil {
+				i, ok = n, true
+			}
+		}
+		if ok {
+			if i < 0 {
+				i += v.Len()
+			}
+			if i >= 0 && i < v.Len() {
+				if v.Kind() == reflect.String {
+					return string(v.String()[i])
+				}
+				return v.Index(i).Interface()
+			}
+		}
+	case reflect.Ptr, reflect.Struct:
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if s, ok := key.(string); ok && v.Kind() == reflect.Struct {
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+				if field.IsExported() && strings.EqualFold(field.Name, strings.ReplaceAll(s, "_", "")) {
+					return v.Field(i).Interface()
+				}
+			}
+		}
+	}
+	return jinjaUndefined{name: name}
+}
+
+// jinjaItems returns the items a for loop iterates: list elements, dict
+// keys in sorted order or string characters
+func jinjaItems(value interface{}) ([]interface{}, error) {
+	if u, ok := value.(jinjaUndefined); ok {
+		return nil, u.err()
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+		return items, nil
+	case reflect.Map:
+		keys := jinjaMapKeys(v)
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = key.Interface()
+		}
+		return items, nil
+	case reflect.String:
+		var items []interface{}
+		for _, r := range v.String() {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", jinjaRepr(value))
+}
+
+// jinjaMapKeys returns the keys of a map sorted by their string form, since
+// Go maps have no insertion order
+func jinjaMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return jinjaString(keys[i].Interface()) < jinjaString(keys[j].Interface())
+	})
+	return keys
+}
+
+// jinjaTruthy applies Python truthiness
+// jinjaSelect implements select, reject, selectattr and rejectattr: the
+// items, or the items whose attribute, pass a test such as "match", or are
+// true when no test is given
+func jinjaSelect(name string, value interface{}, args []interface{}) (interface{}, error) {
+	items, err := jinjaItems(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	attr := ""
+	if strings.HasSuffix(name, "attr") {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s needs an attribute", name)
+		}
+		attr, args = jinjaString(args[0]), args[1:]
+	}
+	keep := strings.HasPrefix(name, "select")
+
+	selected := []interface{}{}
+	for _, item := range items {
+		subject := item
+		if attr != "" {
+			for _, key := range strings.Split(attr, ".") {
+				subject = jinjaGetItem(subject, key, attr)
+			}
+		}
+		var ok bool
+		switch {
+		case len(args) == 0:
+			ok = jinjaTruthy(subject)
+		case jinjaString(args[0]) == "defined" || jinjaString(args[0]) == "undefined":
+			ok = isJinjaUndefined(subject) == (jinjaString(args[0]) == "undefined")
+		case isJinjaUndefined(subject):
+			ok = false
+		default:
+			if ok, err = jinjaTestValue(jinjaString(args[0]), subject, args[1:]); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if ok == keep {
+			selected = append(selected, item)
+		}
+	}
+	return selected, nil
+}
+
+// jinjaResultTest runs the Ansible tests on registered task results:
+// succeeded, failed, changed and skipped
+func jinjaResultTest(name string, value interface{}) (bool, error) {
+	if reflect.ValueOf(value).Kind() != reflect.Map {
+		return false, fmt.Errorf("test '%s' needs a task result, got %s", name, jinjaRepr(value))
+	}
+	field := func(key string) bool {
+		v := jinjaGetItem(value, key, key)
+		return !isJinjaUndefined(v) && jinjaTruthy(v)
+	}
+	switch name {
+	case "succeeded", "success":
+		return !field("failed"), nil
+	case "failed", "failure":
+		return field("failed"), nil
+	case "changed", "change":
+		return field("changed"), nil
+	}
+	return field("skipped"), nil
+}
+
+// jinjaVersionTest compares two versions with an operator such as ">=" or
+// "lt". Numeric parts compare as numbers, so 1.10 is newer than 1.9.
+func jinjaVersionTest(version, other, op string) (bool, error) {
+	cmp := compareVersions(version, other)
+	switch op {
+	case "<", "lt":
+		return cmp < 0, nil
+	case "<=", "le":
+		return cmp <= 0, nil
+	case ">", "gt":
+		return cmp > 0, nil
+	case ">=", "ge":
+		return cmp >= 0, nil
+	case "==", "=", "eq":
+		return cmp == 0, nil
+	case "!=", "<>", "ne":
+		return cmp != 0, nil
+	}
+	return false, fmt.Errorf("invalid version operator '%s'", op)
+}
+
+// versionPart matches the numeric and alphabetic runs of a version
+var versionPart = regexp.MustCompile(`\d+|[A-Za-z]+`)
+
+// compareVersions returns -1, 0 or 1 as version a is older than, the same
+// as or newer than b
+func compareVersions(a, b string) int {
+	pa, pb := versionPart.FindAllString(a, -1), versionPart.FindAllString(b, -1)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) {
+			return -1
+		}
+		if i >= len(pb) {
+			return 1
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func jinjaTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if f, ok := jinjaFloat(value); ok {
+		return f != 0
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() > 0
+	case reflect.Ptr:
+		return !rv.IsNil()
+	}
+	return true
+}
+
+func jinjaInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case bool:
+		return 0, false
+	case int:
+		return v, true
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), true
+	}
+	return 0, false
+}
+
+func jinjaFloat(value interface{}) (float64, bool) {
+	if n, ok := jinjaInt(value); ok {
+		return float64(n), true
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func jinjaEqual(a, b interface{}) bool {
+	if fa, ok := jinjaFloat(a); ok {
+		if fb, ok := jinjaFloat(b); ok {
+			return fa == fb
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jinjaCompare(a, b interface{}) (int, error) {
+	if fa, ok := jinjaFloat(a); ok {
+		if fb, ok := jinjaFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if okA && okB {
+		return strings.Compare(sa, sb), nil
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", jinjaRepr(a), jinjaRepr(b))
+}
+
+// jinjaContains implements the in operator
+func jinjaContains(container, item interface{}) (bool, error) {
+	if s, ok := container.(string); ok {
+		return strings.Contains(s, jinjaString(item)), nil
+	}
+	v := reflect.ValueOf(container)
+	if v.Kind() == reflect.Map {
+		return !isJinjaUndefined(jinjaGetItem(container, item, "")), nil
+	}
+	items, err := jinjaItems(container)
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range items {
+		if jinjaEqual(candidate, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jinjaArith implements arithmetic with Python semantics: integers stay
+// integers except for true division, + also joins strings and lists
+func jinjaArith(op string, a, b interface{}) (interface{}, error) {
+	if op == "+" {
+		if sa, ok := a.(string); ok {
+			if sb, ok := b.(string); ok {
+				return sa + sb, nil
+			}
+		}
+		if la, ok := a.([]interface{}); ok {
+			if lb, ok := b.([]interface{}); ok {
+				return append(append([]interface{}{}, la...), lb...), nil
+			}
+		}
+	}
+	if op == "*" {
+		if s, ok := a.(string); ok {
+			if n, ok := jinjaInt(b); ok && n >= 0 {
+				return strings.Repeat(s, n), nil
+			}
+		}
+	}
+
+	ia, intA := jinjaInt(a)
+	ib, intB := jinjaInt(b)
+	if intA && intB && op != "/" && op != "**" {
+		switch op {
+		case "+":
+			return ia + ib, nil
+		case "-":
+			return ia - ib, nil
+		case "*":
+			return ia * ib, nil
+		case "//", "%":
+			if ib == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			q, m := ia/ib, ia%ib
+			if m != 0 && (m < 0) != (ib < 0) {
+				q, m = q-1, m+ib
+			}
+			if op == "//" {
+				return q, nil
+			}
+			return m, nil
+		}
+	}
+
+	fa, okA := jinjaFloat(a)
+	fb, okB := jinjaFloat(b)
+	if !okA || !okB {
+		return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", op, jinjaRepr(a), jinjaRepr(b))
+	}
+	switch op {
+	case "+":
+		return fa + fb, nil
+	case "-":
+		return fa - fb, nil
+	case "*":
+		return fa * fb, nil
+	case "/":
+		if fb == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return fa / fb, nil
+	case "//":
+		if fb == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Floor(fa / fb), nil
+	case "%":
+		if fb == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return fa - fb*math.Floor(fa/fb), nil
+	case "**":
+		result := math.Pow(fa, fb)
+		if intA && intB && ib >= 0 {
+			return int(result), nil
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+// jinjaString converts a value to text as Python's str does
+func jinjaString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return jinjaFloatString(v)
+	case float32:
+		return jinjaFloatString(float64(v))
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return jinjaRepr(value)
+	}
+	return fmt.Sprint(value)
+}
+
+func jinjaFloatString(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".eInN") {
+		s += ".0"
+	}
+	return s
+}
+
+// jinjaRepr formats a value as Python's repr does, used for values inside
+// lists and dicts
+func jinjaRepr(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), "'", `\'`) + "'"
+	case jinjaUndefined:
+		return "Undefined"
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = jinjaRepr(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := jinjaMapKeys(rv)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = jinjaRepr(key.Interface()) + ": " + jinjaRepr(rv.MapIndex(key).Interface())
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return jinjaString(value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
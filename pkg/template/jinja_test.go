@@ -0,0 +1,216 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEngineRenderJinja2(t *testing.T) {
+	engine := NewEngine()
+	engine.SetSyntax(SyntaxJinja2)
+
+	vars := map[string]interface{}{
+		"name":    "web",
+		"port":    8080,
+		"ratio":   1.5,
+		"enabled": true,
+		"users":   []interface{}{"alice", "bob"},
+		"limits":  map[string]interface{}{"nofile": 1024, "nproc": 64},
+		"server":  map[string]interface{}{"host": "db1", "tags": []interface{}{"a", "b"}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"variable", "Hello {{ name }}!", "Hello web!"},
+		{"filter", "{{ name | upper }}", "WEB"},
+		{"filter chain with args", "{{ users | join(', ') | upper }}", "ALICE, BOB"},
+		{"default for undefined", "{{ missing | default('none') }}", "none"},
+		{"default keeps defined", "{{ name | d('none') }}", "web"},
+		{"default for falsy", "{{ '' | default('empty', true) }}", "empty"},
+		{"attribute and index", "{{ server.host }} {{ server['tags'][-1] }} {{ users[0] }}", "db1 b alice"},
+		{"arithmetic", "{{ port + 1 }} {{ port // 3 }} {{ 7 / 2 }} {{ ratio * 2 }}", "8081 2693 3.5 3.0"},
+		{"python values", "{{ enabled }} {{ none }} {{ users }}", "True None ['alice', 'bob']"},
+		{"concat", "{{ name ~ ':' ~ port }}", "web:8080"},
+		{"conditional expression", "{{ 'on' if enabled else 'off' }}", "on"},
+		{"tests", "{{ missing is defined }} {{ port is even }} {{ name is not none }}", "False True True"},
+		{"in", "{{ 'bob' in users }} {{ 'nproc' in limits }}", "True True"},
+		{"methods", "{{ name.upper() }} {{ limits.get('core', 0) }}", "WEB 0"},
+		{"length", "{{ users | length }}", "2"},
+		{"if elif else", "{% if port > 9000 %}high{% elif port > 8000 %}mid{% else %}low{% endif %}", "mid"},
+		{"for loop", "{% for user in users %}{{ loop.index }}={{ user }}{% if not loop.last %},{% endif %}{% endfor %}", "1=alice,2=bob"},
+		{"for over items", "{% for key, value in limits.items() %}{{ key }}={{ value }} {% endfor %}", "nofile=1024 nproc=64 "},
+		{"for with filter and else", "{% for user in users if user == 'carol' %}{{ user }}{% else %}nobody{% endfor %}", "nobody"},
+		{"range", "{% for i in range(3) %}{{ i }}{% endfor %}", "012"},
+		{"set", "{% set greeting = 'hi ' ~ name %}{{ greeting }}", "hi web"},
+		{"comment", "a{# hidden #}b", "ab"},
+		{"raw", "{% raw %}{{ name }}{% endraw %}", "{{ name }}"},
+		{"whitespace control", "a  {{- name -}}  b", "awebb"},
+		{"trim blocks", "{% for user in users %}\n{{ user }}\n{% endfor %}\n", "alice\nbob\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Render(tt.template, vars)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestEngineRenderJinja2Errors(t *testing.T) {
+	engine := NewEngine()
+	engine.SetSyntax(SyntaxJinja2)
+
+	tests := []struct {
+		name     string
+		template string
+		contains string
+	}{
+		{"undefined variable", "{{ missing }}", "'missing' is undefined"},
+		{"undefined attribute", "{{ server.port }}", "'server.port' is undefined"},
+		{"unknown filter", "{{ 'x' | nosuchfilter }}", "no filter named 'nosuchfilter'"},
+		{"unclosed block", "{% if true %}x", "is not closed"},
+		{"unsupported tag", "{% macro m() %}{% endmacro %}", "unsupported tag"},
+	}
+
+	vars := map[string]interface{}{"server": map[string]interface{}{"host": "db1"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := engine.Render(tt.template, vars)
+			if err == nil || !strings.Contains(err.Error(), tt.contains) {
+				t.Fatalf("expected an error containing %q, got %v", tt.contains, err)
+			}
+		})
+	}
+}
+
+func TestEngineRenderNative(t *testing.T) {
+	// Go syntax engines still render arguments as Jinja2
+	engine := NewEngine()
+
+	vars := map[string]interface{}{
+		"port":     8080,
+		"packages": []interface{}{"nginx", "curl"},
+		"limits":   map[string]interface{}{"nofile": 1024},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected interface{}
+	}{
+		{"plain string", "/etc/nginx/nginx.conf", "/etc/nginx/nginx.conf"},
+		{"single expression keeps its type", "{{ port }}", 8080},
+		{"list", "{{ packages }}", []interface{}{"nginx", "curl"}},
+		{"dict attribute", "{{ limits.nofile + 1 }}", 1025},
+		{"text around an expression", "port={{ port }}", "port=8080"},
+		{"block", "{% for p in packages %}{{ p }} {% endfor %}", "nginx curl "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.RenderNative(tt.template, vars)
+			if err != nil {
+				t.Fatalf("RenderNative(%q) failed: %v", tt.template, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("RenderNative(%q) = %#v, want %#v", tt.template, got, tt.expected)
+			}
+		})
+	}
+
+	for _, template := range []string{"{{ missing }}", "path={{ missing }}", "{{ limits.nproc }}"} {
+		if _, err := engine.RenderNative(template, vars); err == nil || !strings.Contains(err.Error(), "is undefined") {
+			t.Errorf("expected RenderNative(%q) to fail on an undefined variable, got %v", template, err)
+		}
+	}
+}
+
+func TestEngineCombine(t *testing.T) {
+	engine := NewEngine()
+	vars := map[string]interface{}{
+		"defaults":  map[string]interface{}{"app": map[string]interface{}{"port": 80, "users": []interface{}{"web"}}},
+		"overrides": map[string]interface{}{"app": map[string]interface{}{"users": []interface{}{"deploy"}}},
+	}
+
+	tests := []struct {
+		template string
+		expected interface{}
+	}{
+		{"{{ defaults | combine(overrides) }}", vars["overrides"]},
+		{"{{ defaults | combine(overrides, recursive=true) }}",
+			map[string]interface{}{"app": map[string]interface{}{"port": 80, "users": []interface{}{"deploy"}}}},
+		{"{{ defaults | combine(overrides, recursive=true, list_merge='append') }}",
+			map[string]interface{}{"app": map[string]interface{}{"port": 80, "users": []interface{}{"web", "deploy"}}}},
+		{"{{ {'a': 1} | combine({'b': 2}, {'a': 3}) }}", map[string]interface{}{"a": 3, "b": 2}},
+		{"{{ {'a': 1} | combine([{'b': 2}, {'a': 3}]) }}", map[string]interface{}{"a": 3, "b": 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := engine.RenderNative(tt.template, vars)
+			if err != nil {
+				t.Fatalf("RenderNative(%q) failed: %v", tt.template, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("RenderNative(%q) = %#v, want %#v", tt.template, got, tt.expected)
+			}
+		})
+	}
+
+	for _, template := range []string{"{{ defaults | combine(overrides, list_merge='merge') }}", "{{ defaults | combine(overrides, deep=true) }}", "{{ defaults | combine('x') }}"} {
+		if _, err := engine.RenderNative(template, vars); err == nil {
+			t.Errorf("expected RenderNative(%q) to fail", template)
+		}
+	}
+}
+
+func TestEngineJinja2Selection(t *testing.T) {
+	engine := NewEngine()
+	vars := map[string]interface{}{"name": "web"}
+
+	// Go syntax stays the default
+	result, err := engine.Render("{{.name}}", vars)
+	if err != nil || result != "web" {
+		t.Fatalf("expected Go syntax by default, got %q, %v", result, err)
+	}
+
+	// A #jinja2: header selects Jinja2 for one template
+	result, err = engine.Render("#jinja2: trim_blocks: True\n{{ name | upper }}", vars)
+	if err != nil || result != "WEB" {
+		t.Fatalf("expected the header to select Jinja2, got %q, %v", result, err)
+	}
+
+	// .j2 files are rendered as Jinja2
+	path := filepath.Join(t.TempDir(), "motd.j2")
+	if err := os.WriteFile(path, []byte("Welcome to {{ name }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = engine.RenderFile(path, vars)
+	if err != nil || result != "Welcome to web" {
+		t.Fatalf("expected .j2 files to use Jinja2, got %q, %v", result, err)
+	}
+	if err := engine.ValidateTemplateFile(path); err != nil {
+		t.Errorf("expected the .j2 file to validate, got %v", err)
+	}
+
+	// Engine functions can be called from Jinja2
+	engine.SetSyntax(SyntaxJinja2)
+	result, err = engine.Render("{{ basename('/etc/motd') }}", vars)
+	if err != nil || result != "motd" {
+		t.Fatalf("expected engine functions to be callable, got %q, %v", result, err)
+	}
+	if err := engine.ValidateTemplate("{% for x in %}"); err == nil {
+		t.Error("expected an invalid Jinja2 template to fail validation")
+	}
+}
@@ -0,0 +1,159 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/lookup"
+)
+
+// SetLookupManager sets the lookup plugins templates reach through lookup()
+// and query(). The template lookup renders with this engine.
+func (e *Engine) SetLookupManager(lookups *lookup.LookupManager) {
+	if plugin, err := lookups.Get("template"); err == nil {
+		if templates, ok := plugin.(*lookup.TemplateLookup); ok {
+			templates.SetRenderer(func(source string, vars map[string]interface{}) (string, error) {
+				return e.render(source, vars, SyntaxJinja2)
+			})
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lookups = lookups
+}
+
+// lookup runs a lookup plugin from Go templates, e.g. {{ lookup "env" "HOME" }}
+func (e *Engine) lookup(name string, terms ...string) (interface{}, error) {
+	results, err := e.runLookup(name, terms, nil)
+	if err != nil {
+		return nil, err
+	}
+	return lookupValue(results), nil
+}
+
+// query runs a lookup plugin and always returns a list
+func (e *Engine) query(name string, terms ...string) ([]interface{}, error) {
+	return e.runLookup(name, terms, nil)
+}
+
+func (e *Engine) runLookup(name string, terms []string, vars map[string]interface{}) ([]interface{}, error) {
+	e.mu.RLock()
+	lookups := e.lookups
+	e.mu.RUnlock()
+
+	if lookups == nil {
+		return nil, fmt.Errorf("lookup plugins are not available")
+	}
+	return lookups.Lookup(context.Background(), name, terms, vars)
+}
+
+// lookupValue converts lookup results as Ansible's lookup() does: a single
+// result is returned as is and several are joined with commas
+func lookupValue(results []interface{}) interface{} {
+	if len(results) == 1 {
+		return results[0]
+	}
+	values := make([]string, len(results))
+	for i, result := range results {
+		values[i] = jinjaString(result)
+	}
+	return strings.Join(values, ",")
+}
+
+// isLookupFunction reports whether a Jinja2 call is lookup() or query()
+func isLookupFunction(name string) bool {
+	return name == "lookup" || name == "query" || name == "q"
+}
+
+// lookup runs a lookup plugin from a Jinja2 template. List arguments are
+// flattened into terms; wantlist and errors keyword arguments behave as in
+// Ansible, other keyword arguments are appended to each term as key=value.
+func (r *jinjaRenderer) lookup(call *jinjaCall, name string, scope *jinjaScope) (interface{}, error) {
+	if len(call.args) == 0 {
+		return nil, fmt.Errorf("%s needs a plugin name", name)
+	}
+
+	var plugin string
+	var terms []string
+	for i, arg := range call.args {
+		value, err := r.evalDefined(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			plugin = jinjaString(value)
+			continue
+		}
+		if s, ok := value.(string); ok {
+			terms = append(terms, s)
+			continue
+		}
+		items, err := jinjaItems(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			terms = append(terms, jinjaString(item))
+		}
+	}
+
+	wantlist := name != "lookup"
+	errors := "strict"
+	var options []string
+	for _, key := range sortedKeys(call.kwargs) {
+		value, err := r.evalDefined(call.kwargs[key], scope)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "wantlist":
+			wantlist = jinjaTruthy(value)
+		case "errors":
+			errors = jinjaString(value)
+		default:
+			options = append(options, key+"="+jinjaString(value))
+		}
+	}
+	if len(options) > 0 {
+		for i := range terms {
+			terms[i] += " " + strings.Join(options, " ")
+		}
+	}
+
+	if r.lookups == nil {
+		return nil, fmt.Errorf("lookup plugins are not available")
+	}
+	results, err := r.lookups.Lookup(context.Background(), plugin, terms, scope.flatten())
+	if err != nil {
+		switch errors {
+		case "ignore", "warn":
+			if wantlist {
+				return []interface{}{}, nil
+			}
+			return nil, nil
+		}
+		return nil, fmt.Errorf("lookup('%s') failed: %w", plugin, err)
+	}
+	if wantlist {
+		return results, nil
+	}
+	return lookupValue(results), nil
+}
+
+// flatten returns every variable visible in the scope
+func (s *jinjaScope) flatten() map[string]interface{} {
+	var chain []*jinjaScope
+	for scope := s; scope != nil; scope = scope.parent {
+		chain = append(chain, scope)
+	}
+
+	vars := make(map[string]interface{})
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i].vars {
+			vars[k] = v
+		}
+	}
+	return vars
+}
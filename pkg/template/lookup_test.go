@@ -0,0 +1,56 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEngineLookup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "motd.j2"), []byte("Welcome to {{ host | upper }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOSIBLE_LOOKUP_TEST", "from-env")
+
+	engine := NewEngine()
+	engine.SetSyntax(SyntaxJinja2)
+	vars := map[string]interface{}{"host": "web1", "dir": dir}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"env", "{{ lookup('env', 'GOSIBLE_LOOKUP_TEST') }}", "from-env"},
+		{"pipe", "{{ lookup('pipe', 'echo a; echo b') }}", "a\nb"},
+		{"joined results", "{{ lookup('env', 'GOSIBLE_LOOKUP_TEST', 'GOSIBLE_LOOKUP_TEST') }}", "from-env,from-env"},
+		{"query", "{{ query('env', 'GOSIBLE_LOOKUP_TEST') | length }}", "1"},
+		{"wantlist", "{{ lookup('env', 'GOSIBLE_LOOKUP_TEST', wantlist=True) }}", "['from-env']"},
+		{"template renders with variables", "{{ lookup('template', dir ~ '/motd.j2') }}", "Welcome to WEB1"},
+		{"errors ignore", "{{ lookup('file', '/nonexistent/gosible', errors='ignore') }}", "None"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Render(tt.template, vars)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	if _, err := engine.Render("{{ lookup('file', '/nonexistent/gosible') }}", vars); err == nil {
+		t.Error("expected a failing lookup to fail the render")
+	}
+
+	// Go templates call the same plugins
+	engine.SetSyntax(SyntaxGo)
+	result, err := engine.Render(`{{ lookup "env" "GOSIBLE_LOOKUP_TEST" }}`, vars)
+	if err != nil || result != "from-env" {
+		t.Fatalf("expected lookup from a Go template, got %q, %v", result, err)
+	}
+}
@@ -0,0 +1,281 @@
+package testing
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ConformanceSpec describes how RunConformance exercises a module
+type ConformanceSpec struct {
+	// Args is a minimal valid argument set. When nil it is built from the
+	// documented required parameters.
+	Args map[string]interface{}
+
+	Cases []ConformanceCase
+}
+
+// ConformanceCase describes a host state a module is exercised against by
+// RunConformance. Pending and Converged set up the mock connection before
+// each run; any command they leave unmocked succeeds with empty output.
+type ConformanceCase struct {
+	Name string
+
+	// Args defaults to the spec's minimal argument set
+	Args map[string]interface{}
+
+	// Pending sets up a host on which the module has work to do
+	Pending func(conn *MockConnection)
+
+	// Converged sets up a host on which that work is already done
+	Converged func(conn *MockConnection)
+
+	// Mutating holds patterns for commands that change the host
+	Mutating []string
+}
+
+// RunConformance runs the behavior contract every module is expected to
+// honor. The argument checks derive from the module documentation and always
+// run; the check mode, diff and idempotence checks run once per case.
+func RunConformance(t *testing.T, module types.Module, spec ConformanceSpec) {
+	t.Helper()
+
+	caps := types.DefaultCapabilities()
+	if declared, ok := module.(types.ModuleWithCapabilities); ok && declared.Capabilities() != nil {
+		caps = declared.Capabilities()
+	}
+
+	args := spec.Args
+	if args == nil {
+		args = conformanceArgs(module)
+	}
+
+	t.Run("ArgSpec", func(t *testing.T) {
+		if err := module.Validate(copyArgs(args)); err != nil {
+			t.Fatalf("expected the minimal arguments to be valid: %v", err)
+		}
+		conformArgSpec(t, module, args)
+	})
+
+	if caps.CheckMode {
+		t.Run("CheckMode", func(t *testing.T) {
+			conn := conformanceConnection(t, nil)
+			result := runConformance(t, module, conn, withModes(args, true, false))
+			if result != nil {
+				assertNoTransfers(t, conn)
+			}
+		})
+	}
+
+	for _, tc := range spec.Cases {
+		tc := tc
+		if tc.Args == nil {
+			tc.Args = args
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.Pending != nil && caps.CheckMode {
+				t.Run("CheckMode", func(t *testing.T) {
+					conn := conformanceConnection(t, tc.Pending)
+					result := runConformance(t, module, conn, withModes(tc.Args, true, false))
+					if result == nil {
+						return
+					}
+					assertNoMutations(t, conn, tc.Mutating)
+					if !result.Success {
+						t.Errorf("expected check mode to succeed, got %q (%v)", result.Message, result.Error)
+					}
+					if !result.Changed {
+						t.Error("expected check mode to report the pending change")
+					}
+					if !result.Simulated && result.Data["check_mode"] != true {
+						t.Error("expected the result to be marked as simulated")
+					}
+				})
+			}
+
+			if tc.Pending != nil && caps.CheckMode && caps.DiffMode {
+				t.Run("Diff", func(t *testing.T) {
+					conn := conformanceConnection(t, tc.Pending)
+					result := runConformance(t, module, conn, withModes(tc.Args, true, true))
+					if result == nil {
+						return
+					}
+					if result.Changed && result.Diff == nil {
+						t.Error("expected a diff for a changed result in diff mode")
+					}
+				})
+			}
+
+			if tc.Converged != nil {
+				t.Run("Idempotence", func(t *testing.T) {
+					for run := 1; run <= 2; run++ {
+						conn := conformanceConnection(t, tc.Converged)
+						result := runConformance(t, module, conn, withModes(tc.Args, false, false))
+						if result == nil {
+							return
+						}
+						assertNoMutations(t, conn, tc.Mutating)
+						if !result.Success || result.Changed {
+							t.Errorf("run %d: expected an unchanged success on a converged host, got success=%t changed=%t (%v)",
+								run, result.Success, result.Changed, result.Error)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// conformArgSpec checks that dropping a required parameter or passing a value
+// outside its choices fails validation with an error naming the parameter
+func conformArgSpec(t *testing.T, module types.Module, args map[string]interface{}) {
+	doc := module.Documentation()
+	for _, name := range sortedParams(doc) {
+		param := doc.Parameters[name]
+
+		if param.Required {
+			invalid := copyArgs(args)
+			delete(invalid, name)
+			assertNamedError(t, module.Validate(invalid), name, "omitting required parameter")
+		}
+		if len(param.Choices) > 0 {
+			invalid := copyArgs(args)
+			invalid[name] = "conformance-invalid-choice"
+			assertNamedError(t, module.Validate(invalid), name, "passing an unknown choice for")
+		}
+	}
+}
+
+func assertNamedError(t *testing.T, err error, param, action string) {
+	t.Helper()
+
+	if err == nil {
+		t.Errorf("expected %s %q to fail validation", action, param)
+		return
+	}
+	if !strings.Contains(err.Error(), param) {
+		t.Errorf("expected the error for %s %q to name it, got: %v", action, param, err)
+	}
+}
+
+// conformanceArgs builds the smallest argument set the documentation allows:
+// every required parameter, set to its first choice or a value of its type
+func conformanceArgs(module types.Module) map[string]interface{} {
+	doc := module.Documentation()
+	args := make(map[string]interface{})
+	for _, name := range sortedParams(doc) {
+		param := doc.Parameters[name]
+		if !param.Required {
+			continue
+		}
+		if len(param.Choices) > 0 {
+			args[name] = param.Choices[0]
+			continue
+		}
+		switch strings.ToLower(param.Type) {
+		case "bool", "boolean":
+			args[name] = true
+		case "int", "integer":
+			args[name] = 1
+		case "list", "array":
+			args[name] = []interface{}{"conformance"}
+		case "dict", "map", "object":
+			args[name] = map[string]interface{}{"conformance": "value"}
+		case "path":
+			args[name] = "/tmp/conformance"
+		default:
+			args[name] = "conformance"
+		}
+	}
+	return args
+}
+
+// conformanceConnection returns a mock connection on which unmocked
+// commands succeed, so cases only need to describe what matters
+func conformanceConnection(t *testing.T, setup func(conn *MockConnection)) *MockConnection {
+	conn := NewMockConnection(t)
+	conn.SetDefaultCommandResponse(&CommandResponse{ExitCode: 0})
+	if setup != nil {
+		setup(conn)
+	}
+	return conn
+}
+
+// runConformance runs the module and checks the result fields every module
+// must populate. It returns nil when the module failed before producing one.
+func runConformance(t *testing.T, module types.Module, conn *MockConnection, args map[string]interface{}) *types.Result {
+	t.Helper()
+
+	result, err := module.Run(context.Background(), conn, args)
+	if result == nil {
+		if err == nil {
+			t.Error("expected a result or an error, got neither")
+		}
+		return nil
+	}
+
+	if result.ModuleName != module.Name() {
+		t.Errorf("expected the result module name to be %q, got %q", module.Name(), result.ModuleName)
+	}
+	if result.StartTime.IsZero() || result.EndTime.IsZero() {
+		t.Error("expected the result start and end times to be set")
+	}
+	if result.Data == nil {
+		t.Error("expected the result data to be initialized")
+	}
+	return result
+}
+
+func assertNoMutations(t *testing.T, conn *MockConnection, mutating []string) {
+	t.Helper()
+
+	assertNoTransfers(t, conn)
+	for _, pattern := range mutating {
+		regex := regexp.MustCompile(pattern)
+		for _, command := range conn.GetCallOrder() {
+			if regex.MatchString(command) {
+				t.Errorf("expected no changes to the host, but ran %q", command)
+			}
+		}
+	}
+}
+
+func assertNoTransfers(t *testing.T, conn *MockConnection) {
+	t.Helper()
+
+	for _, dest := range conn.GetTransfers() {
+		t.Errorf("expected no changes to the host, but copied a file to %s", dest)
+	}
+}
+
+func withModes(args map[string]interface{}, checkMode, diffMode bool) map[string]interface{} {
+	args = copyArgs(args)
+	if checkMode {
+		args["_check_mode"] = true
+	}
+	if diffMode {
+		args["_diff"] = true
+	}
+	return args
+}
+
+func copyArgs(args map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		copied[key] = value
+	}
+	return copied
+}
+
+func sortedParams(doc types.ModuleDoc) []string {
+	names := make([]string, 0, len(doc.Parameters))
+	for name := range doc.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
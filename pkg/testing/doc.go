@@ -6,6 +6,7 @@
 //   - MockFileSystem: A mock filesystem for testing file operations without touching the real filesystem
 //   - ModuleTestHelper: A high-level testing framework that simplifies module testing
 //   - Specialized helpers: SystemdTestHelper, FileTestHelper, and PackageTestHelper for common scenarios
+//   - RunConformance: A behavior contract suite covering validation, check mode, diff, idempotence and result fields
 //
 // Usage Example:
 //
@@ -2,8 +2,15 @@ package testing
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/liliang-cn/gosible/pkg/types"
 )
@@ -318,6 +325,79 @@ func TestMockConnectionFeatures(t *testing.T) {
 			t.Error("Expected command with wrong env to use default response")
 		}
 	})
+
+	t.Run("SuccessiveResponses", func(t *testing.T) {
+		conn.Reset()
+
+		conn.ExpectCommandTimes("systemctl is-active nginx", 2, &CommandResponse{ExitCode: 3, Stdout: "inactive"})
+		conn.ExpectCommand("systemctl start nginx", &CommandResponse{})
+		conn.ExpectCommand("systemctl is-active nginx", &CommandResponse{Stdout: "active"})
+
+		ctx := context.Background()
+		var states []string
+		for _, command := range []string{"systemctl is-active nginx", "systemctl is-active nginx", "systemctl start nginx", "systemctl is-active nginx"} {
+			result, _ := conn.Execute(ctx, command, types.ExecuteOptions{})
+			if command == "systemctl is-active nginx" {
+				states = append(states, result.Message)
+			}
+		}
+
+		if strings.Join(states, ",") != "inactive,inactive,active" {
+			t.Errorf("Expected successive responses, got %v", states)
+		}
+		if err := conn.VerifyAllExpectationsMet(); err != nil {
+			t.Errorf("Expectations not met: %v", err)
+		}
+		conn.AssertCommandCalledTimes("systemctl is-active nginx", 3)
+		conn.AssertPatternCalledTimes(`^systemctl `, 4)
+		conn.AssertCalledBefore(`is-active`, `start nginx`)
+	})
+
+	t.Run("ExactCallCounts", func(t *testing.T) {
+		conn.Reset()
+
+		conn.ExpectCommandTimes("sync", 2, &CommandResponse{})
+		conn.Execute(context.Background(), "sync", types.ExecuteOptions{})
+
+		if err := conn.VerifyAllExpectationsMet(); err == nil || !strings.Contains(err.Error(), "called 2 times, but it was called 1 times") {
+			t.Errorf("Expected a call count mismatch, got %v", err)
+		}
+	})
+
+	t.Run("InOrder", func(t *testing.T) {
+		conn.Reset()
+
+		conn.ExpectInOrder(
+			ExpectedCall{Command: "systemctl is-active nginx", Response: &CommandResponse{ExitCode: 3}},
+			ExpectedCall{Pattern: `^systemctl (re)?start nginx$`},
+			ExpectedCall{Command: "systemctl is-active nginx", Response: &CommandResponse{Stdout: "active"}},
+		)
+
+		ctx := context.Background()
+		first, _ := conn.Execute(ctx, "systemctl is-active nginx", types.ExecuteOptions{})
+		conn.Execute(ctx, "systemctl start nginx", types.ExecuteOptions{})
+		last, _ := conn.Execute(ctx, "systemctl is-active nginx", types.ExecuteOptions{})
+		conn.Copy(ctx, strings.NewReader("x"), "/etc/nginx/nginx.conf", 0644)
+
+		if first.Success || last.Message != "active" {
+			t.Errorf("Expected the sequence's responses in order, got %v then %q", first.Success, last.Message)
+		}
+		conn.Verify()
+		conn.AssertTranscript(
+			`^systemctl is-active nginx$`,
+			`^systemctl start nginx$`,
+			`^systemctl is-active nginx$`,
+			`^copy /etc/nginx/nginx.conf$`,
+		)
+
+		transcript := conn.Transcript()
+		if transcript[0].ExitCode != 3 || !transcript[0].Matched || transcript[3].Operation != "copy" {
+			t.Errorf("Unexpected transcript entries: %+v", transcript)
+		}
+		if out := conn.TranscriptString(); !strings.Contains(out, "  1. systemctl is-active nginx => exit 3\n") {
+			t.Errorf("Unexpected transcript rendering:\n%s", out)
+		}
+	})
 }
 
 // TestMockFileSystemFeatures demonstrates MockFileSystem capabilities
@@ -403,6 +483,169 @@ func TestMockFileSystemFeatures(t *testing.T) {
 			t.Errorf("Expected at least 1 read operation, got %d", counts["read"])
 		}
 	})
+
+	t.Run("SeedTree", func(t *testing.T) {
+		fs := NewMockFileSystem(t)
+		mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		fs.SeedTree(map[string]FileSpec{
+			"/etc/nginx/nginx.conf":          {Content: "worker_processes 2;\n", Mode: 0640, Owner: "root", Group: "nginx", ModTime: mtime},
+			"/etc/nginx/sites-enabled/app":   {Symlink: "../sites-available/app"},
+			"/etc/nginx/sites-available/app": {Content: "server {}\n"},
+			"/var/www":                       {Dir: true, Owner: "www-data"},
+		})
+
+		fs.AssertDirExists("/etc/nginx/sites-enabled")
+		fs.AssertFileMode("/etc/nginx/nginx.conf", 0640)
+		fs.AssertFileOwner("/etc/nginx/nginx.conf", "root", "nginx")
+		fs.AssertFileOwner("/var/www", "www-data", "root")
+		fs.AssertSymlinkTarget("/etc/nginx/sites-enabled/app", "../sites-available/app")
+		fs.AssertFileContent("/etc/nginx/sites-enabled/app", []byte("server {}\n"))
+
+		info, _ := fs.Lstat("/etc/nginx/sites-enabled/app")
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected Lstat to report a symlink, got %v", info.Mode())
+		}
+		if info, _ := fs.Stat("/etc/nginx/sites-enabled/app"); !info.Mode().IsRegular() || info.Size() != 10 {
+			t.Errorf("Expected Stat to follow the link, got %v %d", info.Mode(), info.Size())
+		}
+		if stat := info.Sys().(*FileStat); stat.Uid != 0 {
+			t.Errorf("Expected a root-owned link, got uid %d", stat.Uid)
+		}
+
+		entries, _ := fs.ReadDir("/etc/nginx")
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if strings.Join(names, ",") != "nginx.conf,sites-available,sites-enabled" {
+			t.Errorf("Expected sorted entries, got %v", names)
+		}
+
+		out, err := fs.StatOutput("/etc/nginx/nginx.conf", "%a %U:%G %s %Y %F")
+		if err != nil || out != fmt.Sprintf("640 root:nginx 20 %d regular file", mtime.Unix()) {
+			t.Errorf("Unexpected stat output %q (%v)", out, err)
+		}
+		if out, _ := fs.StatOutput("/etc/nginx/sites-enabled/app", "%N %A"); out != "'/etc/nginx/sites-enabled/app' -> '../sites-available/app' lrwxrwxrwx" {
+			t.Errorf("Unexpected symlink stat output %q", out)
+		}
+	})
+
+	t.Run("Fidelity", func(t *testing.T) {
+		fs := NewMockFileSystem(t)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		fs.SetClock(func() time.Time { return now })
+		fs.SeedTree(map[string]FileSpec{"/srv/app.conf": {Content: "a", Mode: 0600}})
+
+		// Writes keep the existing mode and owner, and follow links
+		fs.Symlink("/srv/app.conf", "/srv/current")
+		now = now.Add(time.Minute)
+		if err := fs.WriteFile("/srv/current", []byte("b"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		fs.AssertFileContent("/srv/app.conf", []byte("b"))
+		fs.AssertFileMode("/srv/app.conf", 0600)
+		fs.AssertFileUnmodifiedSince("/srv/app.conf", now)
+
+		if err := fs.Chown("/srv/app.conf", "deploy", "root"); err == nil {
+			t.Error("Expected chown to an unknown user to fail")
+		}
+		fs.AddUser("deploy", 1001)
+		if err := fs.Chown("/srv/current", "deploy", "root"); err != nil {
+			t.Fatalf("Chown failed: %v", err)
+		}
+		fs.AssertFileOwner("/srv/app.conf", "deploy", "root")
+
+		fs.Symlink("loop-b", "/srv/loop-a")
+		fs.Symlink("loop-a", "/srv/loop-b")
+		if _, err := fs.ReadFile("/srv/loop-a"); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Expected a symlink loop to fail with ELOOP, got %v", err)
+		}
+		if err := fs.WriteFile("/srv/app.conf/child", nil, 0644); !errors.Is(err, syscall.ENOTDIR) {
+			t.Errorf("Expected ENOTDIR below a file, got %v", err)
+		}
+		if err := fs.RemoveFile("/srv"); !errors.Is(err, syscall.ENOTEMPTY) {
+			t.Errorf("Expected a non-empty directory to stay, got %v", err)
+		}
+		fs.RemoveFile("/srv/current")
+		fs.AssertFileExists("/srv/app.conf")
+	})
+
+	t.Run("ConnectionTransfers", func(t *testing.T) {
+		fs := NewMockFileSystem(t)
+		conn := NewMockConnection(t).UseFileSystem(fs)
+
+		if err := conn.Copy(context.Background(), strings.NewReader("key"), "/etc/app/key.pem", 0600); err != nil {
+			t.Fatalf("Copy failed: %v", err)
+		}
+		fs.AssertFileContent("/etc/app/key.pem", []byte("key"))
+		fs.AssertFileMode("/etc/app/key.pem", 0600)
+
+		reader, err := conn.Fetch(context.Background(), "/etc/app/key.pem")
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if data, _ := io.ReadAll(reader); string(data) != "key" {
+			t.Errorf("Expected to fetch the uploaded content, got %q", data)
+		}
+		if _, err := conn.Fetch(context.Background(), "/missing"); !os.IsNotExist(err) {
+			t.Errorf("Expected fetching a missing file to fail, got %v", err)
+		}
+	})
+}
+
+func TestResultSnapshots(t *testing.T) {
+	result := &types.Result{
+		Success:    true,
+		Changed:    true,
+		Message:    "Updated /etc/app.conf",
+		ModuleName: "lineinfile",
+		StartTime:  time.Now(),
+		EndTime:    time.Now(),
+		Duration:   42 * time.Millisecond,
+		Data: map[string]interface{}{
+			"path":     "/etc/app.conf",
+			"mtime":    time.Now(),
+			"checked":  time.Now().Format(time.RFC3339),
+			"elapsed":  time.Second,
+			"attempts": []interface{}{map[string]interface{}{"at": time.Now()}},
+		},
+		Diff: &types.DiffResult{Before: "a=1\n", After: "a=2\n"},
+	}
+
+	t.Run("Normalization", func(t *testing.T) {
+		first, err := SnapshotResult(result)
+		if err != nil {
+			t.Fatalf("SnapshotResult failed: %v", err)
+		}
+		result.StartTime = result.StartTime.Add(time.Hour)
+		result.Data["mtime"] = time.Now().Add(time.Minute)
+		second, _ := SnapshotResult(result)
+		if string(first) != string(second) {
+			t.Errorf("Expected timestamps to be normalized:\n%s\n%s", first, second)
+		}
+		for _, want := range []string{`"elapsed": "<duration>"`, `"checked": "<timestamp>"`, `"at": "<timestamp>"`, `"before": "a=1\n"`} {
+			if !strings.Contains(string(first), want) {
+				t.Errorf("Expected snapshot to contain %s:\n%s", want, first)
+			}
+		}
+	})
+
+	t.Run("UpdateAndCompare", func(t *testing.T) {
+		golden := filepath.Join(t.TempDir(), "testdata", "result.golden.json")
+		assertSnapshot(t, golden, result, true)
+		assertSnapshot(t, golden, result, false)
+
+		helper := NewModuleTestHelper(t, nil)
+		helper.lastResult = result
+		helper.AssertResultSnapshot(t, golden)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		diff := snapshotDiff("{\n  \"changed\": true\n}\n", "{\n  \"changed\": false\n}\n")
+		if !strings.Contains(diff, `2 -   "changed": true`) || !strings.Contains(diff, `2 +   "changed": false`) {
+			t.Errorf("Unexpected snapshot diff:\n%s", diff)
+		}
+	})
 }
 
 // Helper function for string containment check  
@@ -1,9 +1,11 @@
 package testing
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
@@ -28,7 +30,47 @@ type CommandExpectation struct {
 	Called      bool
 	CallCount   int
 	MaxCalls    int // 0 means unlimited
+	Times       int // When set, Verify requires exactly this many calls
 	Environment map[string]string // Expected environment variables
+
+	// Ordered expectations must be called in the order they were added
+	// with ExpectInOrder
+	sequence *callSequence
+	position int
+}
+
+// ExpectedCall is one step of an ExpectInOrder sequence. Command matches
+// exactly; otherwise Pattern is a regular expression.
+type ExpectedCall struct {
+	Command  string
+	Pattern  string
+	Response *CommandResponse
+}
+
+// callSequence tracks progress through an ExpectInOrder sequence
+type callSequence struct {
+	steps []*CommandExpectation
+	next  int
+}
+
+// TranscriptEntry records one operation performed on the connection
+type TranscriptEntry struct {
+	Operation string // "execute", "copy" or "fetch"
+	Command   string // Command executed, or the path copied to or fetched
+	Env       map[string]string
+	Become    bool
+	ExitCode  int
+	Stdout    string
+	Matched   bool // Whether an expectation answered the command
+}
+
+// String renders the entry the way AssertTranscript matches it: the
+// command for executions, "copy DEST" and "fetch SRC" for transfers
+func (e TranscriptEntry) String() string {
+	if e.Operation == "execute" {
+		return e.Command
+	}
+	return e.Operation + " " + e.Command
 }
 
 // MockConnection implements types.Connection for testing
@@ -41,6 +83,9 @@ type MockConnection struct {
 	hostname    string
 	strictOrder bool
 	defaultResponse *CommandResponse
+	transfers   []string // Destinations written by Copy
+	transcript  []TranscriptEntry
+	filesystem  *MockFileSystem // Backs Copy and Fetch when set
 }
 
 // NewMockConnection creates a new mock connection for testing
@@ -67,6 +112,57 @@ func (m *MockConnection) ExpectCommand(command string, response *CommandResponse
 	return m
 }
 
+// ExpectCommandTimes adds an expectation for an exact command that answers
+// exactly times calls. Later calls fall through to expectations added after
+// it, so the same command can return different output on successive calls:
+//
+//	conn.ExpectCommandTimes("systemctl is-active nginx", 1, &CommandResponse{ExitCode: 3, Stdout: "inactive"})
+//	conn.ExpectCommand("systemctl is-active nginx", &CommandResponse{Stdout: "active"})
+func (m *MockConnection) ExpectCommandTimes(command string, times int, response *CommandResponse) *MockConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expectations = append(m.expectations, &CommandExpectation{
+		Command:  command,
+		Response: response,
+		MaxCalls: times,
+		Times:    times,
+	})
+	return m
+}
+
+// ExpectInOrder adds expectations that are each called once, in the given
+// order. A call that skips ahead in the sequence fails the test.
+func (m *MockConnection) ExpectInOrder(calls ...ExpectedCall) *MockConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sequence := &callSequence{}
+	for i, call := range calls {
+		exp := &CommandExpectation{
+			Command:  call.Command,
+			Response: call.Response,
+			MaxCalls: 1,
+			Times:    1,
+			sequence: sequence,
+			position: i,
+		}
+		if call.Command == "" {
+			regex, err := regexp.Compile(call.Pattern)
+			if err != nil {
+				m.t.Fatalf("Invalid regex pattern %s: %v", call.Pattern, err)
+			}
+			exp.Pattern = regex
+		}
+		if exp.Response == nil {
+			exp.Response = &CommandResponse{}
+		}
+		sequence.steps = append(sequence.steps, exp)
+		m.expectations = append(m.expectations, exp)
+	}
+	return m
+}
+
 // ExpectCommandPattern adds an expectation for a command matching a regex pattern
 func (m *MockConnection) ExpectCommandPattern(pattern string, response *CommandResponse) *MockConnection {
 	m.mu.Lock()
@@ -133,57 +229,63 @@ func (m *MockConnection) Execute(ctx context.Context, command string, options ty
 	
 	// Record the call
 	m.callOrder = append(m.callOrder, command)
+	entry := TranscriptEntry{Operation: "execute", Command: command, Env: options.Env, Become: options.Become != nil}
+	defer func() { m.transcript = append(m.transcript, entry) }()
 	
 	// Find matching expectation
-	for _, exp := range m.expectations {
-		if m.matchesExpectation(exp, command, options.Env) {
-			exp.Called = true
-			exp.CallCount++
-			
-			// Check if we've exceeded the maximum calls
-			if exp.MaxCalls > 0 && exp.CallCount > exp.MaxCalls {
-				m.t.Errorf("Command '%s' called %d times, but max calls is %d", command, exp.CallCount, exp.MaxCalls)
-				return nil, fmt.Errorf("too many calls to command: %s", command)
-			}
-			
-			// Create result based on response
-			result := &types.Result{
-				Host:       m.hostname,
-				Success:    exp.Response.ExitCode == 0,
-				Changed:    false, // Mock connection doesn't track changes by default
-				Message:    exp.Response.Stdout,
-				Data:       make(map[string]interface{}),
-				StartTime:  types.GetCurrentTime(),
-				EndTime:    types.GetCurrentTime(),
-				TaskName:   command,
-				ModuleName: "mock",
-			}
-			
-			// Add stdout and stderr to data
-			result.Data["stdout"] = exp.Response.Stdout
-			result.Data["exit_code"] = exp.Response.ExitCode
-			if exp.Response.Stderr != "" {
-				result.Data["stderr"] = exp.Response.Stderr
-			}
-			
-			// Return error if configured or if exit code is non-zero
-			if exp.Response.Error != nil {
-				result.Error = exp.Response.Error
-				return result, exp.Response.Error
-			}
-			
-			if exp.Response.ExitCode != 0 {
-				err := fmt.Errorf("command failed with exit code %d: %s", exp.Response.ExitCode, exp.Response.Stderr)
-				result.Error = err
-				return result, err
-			}
-			
-			return result, nil
+	if exp := m.findExpectation(command, options.Env); exp != nil {
+		exp.Called = true
+		exp.CallCount++
+		entry.Matched = true
+		entry.ExitCode = exp.Response.ExitCode
+		entry.Stdout = exp.Response.Stdout
+		m.checkSequence(exp, command)
+		
+		// Check if we've exceeded the maximum calls
+		if exp.MaxCalls > 0 && exp.CallCount > exp.MaxCalls {
+			m.t.Errorf("Command '%s' called %d times, but max calls is %d", command, exp.CallCount, exp.MaxCalls)
+			return nil, fmt.Errorf("too many calls to command: %s", command)
+		}
+		
+		// Create result based on response
+		result := &types.Result{
+			Host:       m.hostname,
+			Success:    exp.Response.ExitCode == 0,
+			Changed:    false, // Mock connection doesn't track changes by default
+			Message:    exp.Response.Stdout,
+			Data:       make(map[string]interface{}),
+			StartTime:  types.GetCurrentTime(),
+			EndTime:    types.GetCurrentTime(),
+			TaskName:   command,
+			ModuleName: "mock",
+		}
+		
+		// Add stdout and stderr to data
+		result.Data["stdout"] = exp.Response.Stdout
+		result.Data["exit_code"] = exp.Response.ExitCode
+		if exp.Response.Stderr != "" {
+			result.Data["stderr"] = exp.Response.Stderr
+		}
+		
+		// Return error if configured or if exit code is non-zero
+		if exp.Response.Error != nil {
+			result.Error = exp.Response.Error
+			return result, exp.Response.Error
 		}
+		
+		if exp.Response.ExitCode != 0 {
+			err := fmt.Errorf("command failed with exit code %d: %s", exp.Response.ExitCode, exp.Response.Stderr)
+			result.Error = err
+			return result, err
+		}
+		
+		return result, nil
 	}
 	
 	// No expectation found - use default response if set
 	if m.defaultResponse != nil {
+		entry.ExitCode = m.defaultResponse.ExitCode
+		entry.Stdout = m.defaultResponse.Stdout
 		result := &types.Result{
 			Host:       m.hostname,
 			Success:    m.defaultResponse.ExitCode == 0,
@@ -221,6 +323,48 @@ func (m *MockConnection) Execute(ctx context.Context, command string, options ty
 	return nil, fmt.Errorf("unexpected command: %s", command)
 }
 
+// findExpectation returns the first expectation matching a command that
+// has calls left. When every match is used up the first one is returned,
+// so Execute reports the extra call.
+func (m *MockConnection) findExpectation(command string, env map[string]string) *CommandExpectation {
+	var exhausted *CommandExpectation
+	for _, exp := range m.expectations {
+		if !m.matchesExpectation(exp, command, env) {
+			continue
+		}
+		if exp.MaxCalls == 0 || exp.CallCount < exp.MaxCalls {
+			return exp
+		}
+		if exhausted == nil {
+			exhausted = exp
+		}
+	}
+	return exhausted
+}
+
+// checkSequence fails the test when an ordered expectation is called
+// before the steps preceding it
+func (m *MockConnection) checkSequence(exp *CommandExpectation, command string) {
+	sequence := exp.sequence
+	if sequence == nil || exp.CallCount > 1 {
+		return
+	}
+	if exp.position != sequence.next {
+		m.t.Errorf("Command '%s' executed out of order: expected '%s' first", command, sequence.steps[sequence.next].describe())
+	}
+	if exp.position >= sequence.next {
+		sequence.next = exp.position + 1
+	}
+}
+
+// describe returns the command or pattern an expectation matches
+func (exp *CommandExpectation) describe() string {
+	if exp.Command == "" && exp.Pattern != nil {
+		return exp.Pattern.String()
+	}
+	return exp.Command
+}
+
 // matchesExpectation checks if a command matches an expectation
 func (m *MockConnection) matchesExpectation(exp *CommandExpectation, command string, env map[string]string) bool {
 	// Check command match
@@ -260,9 +404,15 @@ func (m *MockConnection) Copy(ctx context.Context, src io.Reader, dest string, m
 	
 	// Record the operation with content size for testing
 	m.callOrder = append(m.callOrder, fmt.Sprintf("copy %d bytes to %s", len(content), dest))
+	m.transfers = append(m.transfers, dest)
+	m.transcript = append(m.transcript, TranscriptEntry{Operation: "copy", Command: dest, Matched: true})
 	
-	// For testing, we could add expectations for copy operations
-	// For now, just return success
+	if m.filesystem != nil {
+		if err := m.filesystem.WriteFile(dest, content, os.FileMode(mode)); err != nil {
+			return err
+		}
+		return m.filesystem.Chmod(dest, os.FileMode(mode))
+	}
 	return nil
 }
 
@@ -273,12 +423,29 @@ func (m *MockConnection) Fetch(ctx context.Context, src string) (io.Reader, erro
 	
 	// Record the operation
 	m.callOrder = append(m.callOrder, fmt.Sprintf("fetch from %s", src))
+	m.transcript = append(m.transcript, TranscriptEntry{Operation: "fetch", Command: src, Matched: true})
+	
+	if m.filesystem != nil {
+		content, err := m.filesystem.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(content), nil
+	}
 	
 	// For testing, return empty reader by default
-	// This could be enhanced to support configured responses
 	return strings.NewReader(""), nil
 }
 
+// UseFileSystem backs Copy and Fetch with a mock filesystem, so uploads land
+// in it with their mode and fetches read from it
+func (m *MockConnection) UseFileSystem(fs *MockFileSystem) *MockConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filesystem = fs
+	return m
+}
+
 // IsConnected implements types.Connection.IsConnected
 func (m *MockConnection) IsConnected() bool {
 	m.mu.RLock()
@@ -340,6 +507,8 @@ func (m *MockConnection) Verify() {
 				command = exp.Pattern.String()
 			}
 			m.t.Errorf("Expectation %d was not met: expected command '%s' was not called", i, command)
+		} else if exp.Times > 0 && exp.CallCount != exp.Times {
+			m.t.Errorf("Expectation %d was not met: expected command '%s' to be called %d times, but it was called %d times", i, exp.describe(), exp.Times, exp.CallCount)
 		}
 	}
 }
@@ -357,6 +526,9 @@ func (m *MockConnection) VerifyAllExpectationsMet() error {
 			}
 			return fmt.Errorf("expectation %d was not met: expected command '%s' was not called", i, command)
 		}
+		if exp.Times > 0 && exp.CallCount != exp.Times {
+			return fmt.Errorf("expectation %d was not met: expected command '%s' to be called %d times, but it was called %d times", i, exp.describe(), exp.Times, exp.CallCount)
+		}
 	}
 	return nil
 }
@@ -372,6 +544,43 @@ func (m *MockConnection) GetCallOrder() []string {
 	return result
 }
 
+// GetTransfers returns the destinations of files copied to the host
+func (m *MockConnection) GetTransfers() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	result := make([]string, len(m.transfers))
+	copy(result, m.transfers)
+	return result
+}
+
+// Transcript returns every operation performed on the connection, in order
+func (m *MockConnection) Transcript() []TranscriptEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]TranscriptEntry, len(m.transcript))
+	copy(result, m.transcript)
+	return result
+}
+
+// TranscriptString renders the transcript one numbered operation per line,
+// for use in failure messages
+func (m *MockConnection) TranscriptString() string {
+	var b strings.Builder
+	for i, entry := range m.Transcript() {
+		fmt.Fprintf(&b, "%3d. %s", i+1, entry)
+		if entry.Operation == "execute" {
+			fmt.Fprintf(&b, " => exit %d", entry.ExitCode)
+			if !entry.Matched {
+				b.WriteString(" (unexpected)")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 // GetExecutionOrder is an alias for GetCallOrder for compatibility
 func (m *MockConnection) GetExecutionOrder() []string {
 	return m.GetCallOrder()
@@ -398,6 +607,8 @@ func (m *MockConnection) Reset() *MockConnection {
 	
 	m.expectations = make([]*CommandExpectation, 0)
 	m.callOrder = make([]string, 0)
+	m.transfers = nil
+	m.transcript = nil
 	m.strictOrder = false
 	m.defaultResponse = nil
 	return m
@@ -450,6 +661,60 @@ func (m *MockConnection) AssertCommandOrder(commands ...string) {
 	m.t.Errorf("Expected command sequence %v was not found in call order %v", commands, callOrder)
 }
 
+// AssertPatternCalledTimes asserts that commands matching a regex pattern
+// were called exactly n times
+func (m *MockConnection) AssertPatternCalledTimes(pattern string, times int) {
+	regex := regexp.MustCompile(pattern)
+	count := 0
+	for _, call := range m.GetCallOrder() {
+		if regex.MatchString(call) {
+			count++
+		}
+	}
+	if count != times {
+		m.t.Errorf("Expected commands matching '%s' to be called %d times, but they were called %d times", pattern, times, count)
+	}
+}
+
+// AssertCalledBefore asserts that the first command matching the first
+// pattern ran before the first command matching the second
+func (m *MockConnection) AssertCalledBefore(first, second string) {
+	firstIndex, secondIndex := -1, -1
+	firstRegex, secondRegex := regexp.MustCompile(first), regexp.MustCompile(second)
+	for i, entry := range m.Transcript() {
+		if firstIndex < 0 && firstRegex.MatchString(entry.String()) {
+			firstIndex = i
+		}
+		if secondIndex < 0 && secondRegex.MatchString(entry.String()) {
+			secondIndex = i
+		}
+	}
+
+	switch {
+	case firstIndex < 0:
+		m.t.Errorf("Expected a command matching '%s', but none ran:\n%s", first, m.TranscriptString())
+	case secondIndex < 0:
+		m.t.Errorf("Expected a command matching '%s', but none ran:\n%s", second, m.TranscriptString())
+	case firstIndex > secondIndex:
+		m.t.Errorf("Expected '%s' to run before '%s':\n%s", first, second, m.TranscriptString())
+	}
+}
+
+// AssertTranscript asserts that the connection saw exactly the given
+// operations, each matched by a regex pattern against TranscriptEntry.String
+func (m *MockConnection) AssertTranscript(patterns ...string) {
+	transcript := m.Transcript()
+	if len(transcript) != len(patterns) {
+		m.t.Errorf("Expected %d operations, but %d were performed:\n%s", len(patterns), len(transcript), m.TranscriptString())
+		return
+	}
+	for i, pattern := range patterns {
+		if !regexp.MustCompile(pattern).MatchString(transcript[i].String()) {
+			m.t.Errorf("Operation %d '%s' does not match '%s':\n%s", i+1, transcript[i], pattern, m.TranscriptString())
+		}
+	}
+}
+
 // CreateStandardSystemdMocks creates common systemd command mocks
 func (m *MockConnection) CreateStandardSystemdMocks(serviceName string) *MockConnection {
 	// Common systemd commands
@@ -3,9 +3,13 @@ package testing
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -19,10 +23,38 @@ type MockFile struct {
 	Exists      bool
 	Owner       string
 	Group       string
+	Uid         int
+	Gid         int
+	Symlink     string // Target when the entry is a symbolic link
 	ReadOnly    bool
 	AccessError error // Error to return when accessing this file
 }
 
+// FileSpec describes an entry for SeedTree. Mode defaults to 0644 for
+// files, 0755 for directories and 0777 for symlinks, Owner and Group to
+// root, and ModTime to the filesystem clock.
+type FileSpec struct {
+	Content string
+	Mode    os.FileMode
+	Dir     bool
+	Symlink string
+	Owner   string
+	Group   string
+	ModTime time.Time
+}
+
+// FileStat is what MockFileInfo.Sys returns, in place of syscall.Stat_t
+type FileStat struct {
+	Uid   int
+	Gid   int
+	Owner string
+	Group string
+}
+
+// maxSymlinks is how many links a lookup follows before failing with ELOOP,
+// as Linux does
+const maxSymlinks = 40
+
 // MockFileSystem provides an in-memory filesystem for testing
 type MockFileSystem struct {
 	t         *testing.T
@@ -31,6 +63,9 @@ type MockFileSystem struct {
 	operations []FileOperation
 	readOnlyPaths []string
 	simulateErrors bool
+	users     map[string]int
+	groups    map[string]int
+	clock     func() time.Time
 }
 
 // FileOperation records filesystem operations for testing
@@ -52,6 +87,9 @@ func NewMockFileSystem(t *testing.T) *MockFileSystem {
 		operations: make([]FileOperation, 0),
 		readOnlyPaths: make([]string, 0),
 		simulateErrors: false,
+		users:     map[string]int{"root": 0},
+		groups:    map[string]int{"root": 0},
+		clock:     time.Now,
 	}
 }
 
@@ -60,15 +98,7 @@ func (m *MockFileSystem) CreateFile(path string, content []byte, mode os.FileMod
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	m.files[path] = &MockFile{
-		Content: content,
-		Mode:    mode,
-		ModTime: time.Now(),
-		IsDir:   false,
-		Exists:  true,
-		Owner:   "root",
-		Group:   "root",
-	}
+	m.files[cleanPath(path)] = m.newEntry(content, mode, false)
 	
 	return m
 }
@@ -81,15 +111,7 @@ func (m *MockFileSystem) AddFile(path string, content []byte, mode os.FileMode)
 	// Record the write operation for consistency with other methods
 	m.recordOperation("write", path, content, mode, true, nil)
 	
-	m.files[path] = &MockFile{
-		Content: content,
-		Mode:    mode,
-		ModTime: time.Now(),
-		IsDir:   false,
-		Exists:  true,
-		Owner:   "root",
-		Group:   "root",
-	}
+	m.files[cleanPath(path)] = m.newEntry(content, mode, false)
 	
 	return nil
 }
@@ -99,14 +121,7 @@ func (m *MockFileSystem) CreateDirectory(path string, mode os.FileMode) *MockFil
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	m.files[path] = &MockFile{
-		Mode:    mode,
-		ModTime: time.Now(),
-		IsDir:   true,
-		Exists:  true,
-		Owner:   "root",
-		Group:   "root",
-	}
+	m.files[cleanPath(path)] = m.newEntry(nil, mode, true)
 	
 	return m
 }
@@ -117,14 +132,17 @@ func (m *MockFileSystem) AddDir(path string, mode os.FileMode) error {
 	return nil
 }
 
-// SetFileOwner sets the owner and group of a file
+// SetFileOwner sets the owner and group of a file. Names not yet known to
+// the filesystem are given the next free ID from 1000.
 func (m *MockFileSystem) SetFileOwner(path, owner, group string) *MockFileSystem {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	if file, exists := m.files[path]; exists {
+	if file, exists := m.files[cleanPath(path)]; exists {
 		file.Owner = owner
 		file.Group = group
+		file.Uid = m.register(m.users, owner)
+		file.Gid = m.register(m.groups, group)
 	}
 	
 	return m
@@ -135,7 +153,7 @@ func (m *MockFileSystem) SetFileReadOnly(path string, readOnly bool) *MockFileSy
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	if file, exists := m.files[path]; exists {
+	if file, exists := m.files[cleanPath(path)]; exists {
 		file.ReadOnly = readOnly
 	}
 	
@@ -147,11 +165,11 @@ func (m *MockFileSystem) SetFileError(path string, err error) *MockFileSystem {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	if file, exists := m.files[path]; exists {
+	if file, exists := m.files[cleanPath(path)]; exists {
 		file.AccessError = err
 	} else {
 		// Create a file that doesn't exist but has an error
-		m.files[path] = &MockFile{
+		m.files[cleanPath(path)] = &MockFile{
 			Exists:      false,
 			AccessError: err,
 		}
@@ -194,13 +212,14 @@ func (m *MockFileSystem) SimulateIOErrors() *MockFileSystem {
 	return m
 }
 
-// FileExists checks if a file exists in the mock filesystem
+// FileExists checks if a file exists in the mock filesystem. Symlinks are
+// followed, so a dangling link does not exist.
 func (m *MockFileSystem) FileExists(path string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	file, exists := m.files[path]
-	return exists && file.Exists
+	_, file, err := m.lookup(path, true)
+	return err == nil && file.Exists
 }
 
 // Exists is an alias for FileExists for compatibility
@@ -213,53 +232,36 @@ func (m *MockFileSystem) IsDir(path string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	file, exists := m.files[path]
-	return exists && file.Exists && file.IsDir
+	_, file, err := m.lookup(path, true)
+	return err == nil && file.IsDir
 }
 
-// ReadDir lists directory contents
+// ReadDir lists directory contents sorted by name, as os.ReadDir does.
+// Symlinks are listed as links rather than as their targets.
 func (m *MockFileSystem) ReadDir(dirPath string) ([]os.FileInfo, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
 	// Check if directory exists
-	dir, exists := m.files[dirPath]
-	if !exists || !dir.Exists || !dir.IsDir {
-		return nil, &os.PathError{Op: "readdir", Path: dirPath, Err: os.ErrNotExist}
+	resolved, dir, err := m.lookup(dirPath, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirPath, Err: unwrapPathError(err)}
+	}
+	if !dir.IsDir {
+		return nil, &os.PathError{Op: "readdir", Path: dirPath, Err: syscall.ENOTDIR}
 	}
 	
 	// Find all files in this directory
 	var files []os.FileInfo
-	dirPrefix := dirPath
-	if !strings.HasSuffix(dirPrefix, "/") {
-		dirPrefix += "/"
-	}
-	
-	for path, file := range m.files {
-		if !file.Exists {
-			continue
-		}
-		
-		// Check if this file is directly in the directory (not in subdirectory)
-		if strings.HasPrefix(path, dirPrefix) {
-			relativePath := strings.TrimPrefix(path, dirPrefix)
-			// Only include direct children (no subdirectory separator)
-			if !strings.Contains(relativePath, "/") && relativePath != "" {
-				files = append(files, &MockFileInfo{
-					name:    relativePath,
-					size:    int64(len(file.Content)),
-					mode:    file.Mode,
-					modTime: file.ModTime,
-					isDir:   file.IsDir,
-				})
-			}
-		}
+	for _, child := range m.children(resolved) {
+		files = append(files, m.fileInfo(child, m.files[child]))
 	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
 	
 	return files, nil
 }
 
-// ReadFile reads a file from the mock filesystem
+// ReadFile reads a file from the mock filesystem, following symlinks
 func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -280,9 +282,9 @@ func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
 		}
 	}
 	
-	file, exists := m.files[path]
-	if !exists || !file.Exists {
-		err := &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+	resolved, file, err := m.lookup(path, true)
+	if err != nil {
+		err := &os.PathError{Op: "read", Path: path, Err: unwrapPathError(err)}
 		m.updateLastOperation(false, err)
 		return nil, err
 	}
@@ -292,8 +294,14 @@ func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
 		return nil, file.AccessError
 	}
 	
+	if file.IsDir {
+		err := &os.PathError{Op: "read", Path: path, Err: syscall.EISDIR}
+		m.updateLastOperation(false, err)
+		return nil, err
+	}
+	
 	// Simulate permission error for read-only files
-	if m.isReadOnlyPath(path) && file.ReadOnly {
+	if m.isReadOnlyPath(resolved) && file.ReadOnly {
 		err := &os.PathError{Op: "read", Path: path, Err: os.ErrPermission}
 		m.updateLastOperation(false, err)
 		return nil, err
@@ -302,38 +310,54 @@ func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
 	return file.Content, nil
 }
 
-// WriteFile writes a file to the mock filesystem
+// WriteFile writes a file to the mock filesystem. Like os.WriteFile it
+// writes through symlinks, and an existing file keeps its mode and owner.
 func (m *MockFileSystem) WriteFile(path string, content []byte, mode os.FileMode) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
 	m.recordOperation("write", path, content, mode, true, nil)
 	
-	// Check if path is read-only
-	if m.isReadOnlyPath(path) {
-		err := &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+	resolved, file, err := m.lookup(path, true)
+	if err != nil && !os.IsNotExist(err) {
+		err := &os.PathError{Op: "write", Path: path, Err: unwrapPathError(err)}
 		m.updateLastOperation(false, err)
 		return err
 	}
 	
-	// Check if existing file is read-only
-	if file, exists := m.files[path]; exists && file.ReadOnly {
+	// Check if path is read-only
+	if m.isReadOnlyPath(resolved) {
 		err := &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
 		m.updateLastOperation(false, err)
 		return err
 	}
 	
-	// Create or update the file
-	m.files[path] = &MockFile{
-		Content: content,
-		Mode:    mode,
-		ModTime: time.Now(),
-		IsDir:   false,
-		Exists:  true,
-		Owner:   "root",
-		Group:   "root",
+	if file != nil && file.Exists {
+		// Check if existing file is read-only
+		if file.ReadOnly {
+			err := &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+			m.updateLastOperation(false, err)
+			return err
+		}
+		if file.IsDir {
+			err := &os.PathError{Op: "write", Path: path, Err: syscall.EISDIR}
+			m.updateLastOperation(false, err)
+			return err
+		}
+		file.Content = content
+		file.ModTime = m.clock()
+		return nil
 	}
 	
+	if err := m.checkParent(resolved); err != nil {
+		err := &os.PathError{Op: "write", Path: path, Err: err}
+		m.updateLastOperation(false, err)
+		return err
+	}
+	
+	// Create the file
+	m.files[resolved] = m.newEntry(content, mode, false)
+	
 	return nil
 }
 
@@ -351,82 +375,64 @@ func (m *MockFileSystem) CreateDir(path string, mode os.FileMode) error {
 		return err
 	}
 	
-	m.files[path] = &MockFile{
-		Mode:    mode,
-		ModTime: time.Now(),
-		IsDir:   true,
-		Exists:  true,
-		Owner:   "root",
-		Group:   "root",
-	}
+	m.files[cleanPath(path)] = m.newEntry(nil, mode, true)
 	
 	return nil
 }
 
-// RemoveFile removes a file from the mock filesystem
+// RemoveFile removes a file, a symlink itself rather than its target, or an
+// empty directory from the mock filesystem
 func (m *MockFileSystem) RemoveFile(path string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
 	m.recordOperation("delete", path, nil, 0, true, nil)
 	
-	file, exists := m.files[path]
-	if !exists || !file.Exists {
-		err := &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	resolved, file, err := m.lookup(path, false)
+	if err != nil {
+		err := &os.PathError{Op: "remove", Path: path, Err: unwrapPathError(err)}
 		m.updateLastOperation(false, err)
 		return err
 	}
 	
 	// Check if path is read-only
-	if m.isReadOnlyPath(path) || file.ReadOnly {
+	if m.isReadOnlyPath(resolved) || file.ReadOnly {
 		err := &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
 		m.updateLastOperation(false, err)
 		return err
 	}
 	
+	if file.IsDir && len(m.children(resolved)) > 0 {
+		err := &os.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+		m.updateLastOperation(false, err)
+		return err
+	}
+	
 	file.Exists = false
 	return nil
 }
 
-// Stat returns file information
+// Stat returns file information, following symlinks
 func (m *MockFileSystem) Stat(path string) (os.FileInfo, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	file, exists := m.files[path]
-	if !exists || !file.Exists {
-		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
-	}
-	
-	if file.AccessError != nil {
-		return nil, file.AccessError
-	}
-	
-	return &MockFileInfo{
-		name:    filepath.Base(path),
-		size:    int64(len(file.Content)),
-		mode:    file.Mode,
-		modTime: file.ModTime,
-		isDir:   file.IsDir,
-	}, nil
+	return m.stat("stat", path, true)
 }
 
-// Chmod changes file permissions
+// Chmod changes file permissions, following symlinks
 func (m *MockFileSystem) Chmod(path string, mode os.FileMode) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
 	m.recordOperation("chmod", path, nil, mode, true, nil)
 	
-	file, exists := m.files[path]
-	if !exists || !file.Exists {
-		err := &os.PathError{Op: "chmod", Path: path, Err: os.ErrNotExist}
+	resolved, file, err := m.lookup(path, true)
+	if err != nil {
+		err := &os.PathError{Op: "chmod", Path: path, Err: unwrapPathError(err)}
 		m.updateLastOperation(false, err)
 		return err
 	}
 	
 	// Check if path is read-only
-	if m.isReadOnlyPath(path) || file.ReadOnly {
+	if m.isReadOnlyPath(resolved) || file.ReadOnly {
 		err := &os.PathError{Op: "chmod", Path: path, Err: os.ErrPermission}
 		m.updateLastOperation(false, err)
 		return err
@@ -436,30 +442,11 @@ func (m *MockFileSystem) Chmod(path string, mode os.FileMode) error {
 	return nil
 }
 
-// Chown changes file ownership
+// Chown changes file ownership, following symlinks. Like chown(1) it fails
+// for users and groups the filesystem does not know; see AddUser and
+// AddGroup.
 func (m *MockFileSystem) Chown(path, owner, group string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	m.recordOperation("chown", path, []byte(fmt.Sprintf("%s:%s", owner, group)), 0, true, nil)
-	
-	file, exists := m.files[path]
-	if !exists || !file.Exists {
-		err := &os.PathError{Op: "chown", Path: path, Err: os.ErrNotExist}
-		m.updateLastOperation(false, err)
-		return err
-	}
-	
-	// Check if path is read-only
-	if m.isReadOnlyPath(path) || file.ReadOnly {
-		err := &os.PathError{Op: "chown", Path: path, Err: os.ErrPermission}
-		m.updateLastOperation(false, err)
-		return err
-	}
-	
-	file.Owner = owner
-	file.Group = group
-	return nil
+	return m.chown("chown", path, owner, group, true)
 }
 
 // GetFileContent returns the content of a file (for testing)
@@ -467,18 +454,18 @@ func (m *MockFileSystem) GetFileContent(path string) []byte {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	if file, exists := m.files[path]; exists && file.Exists {
+	if _, file, err := m.lookup(path, true); err == nil {
 		return file.Content
 	}
 	return nil
 }
 
-// GetFileMode returns the mode of a file (for testing)
+// GetFileMode returns the permission bits of a file (for testing)
 func (m *MockFileSystem) GetFileMode(path string) os.FileMode {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	if file, exists := m.files[path]; exists && file.Exists {
+	if _, file, err := m.lookup(path, true); err == nil {
 		return file.Mode
 	}
 	return 0
@@ -596,6 +583,253 @@ func (m *MockFileSystem) AssertOperationNotOccurred(operation, path string) {
 	}
 }
 
+// Lstat returns file information without following a final symlink
+func (m *MockFileSystem) Lstat(path string) (os.FileInfo, error) {
+	return m.stat("lstat", path, false)
+}
+
+// Symlink creates link pointing at target. Relative targets resolve against
+// the link's directory, and the target need not exist.
+func (m *MockFileSystem) Symlink(target, link string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordOperation("symlink", link, []byte(target), 0, true, nil)
+
+	resolved, file, err := m.lookup(link, false)
+	if err == nil && file.Exists {
+		err := &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+		m.updateLastOperation(false, err)
+		return err
+	}
+	if err == nil || os.IsNotExist(err) {
+		err = m.checkParent(resolved)
+	}
+	if err != nil {
+		err := &os.LinkError{Op: "symlink", Old: target, New: link, Err: unwrapPathError(err)}
+		m.updateLastOperation(false, err)
+		return err
+	}
+
+	entry := m.newEntry(nil, 0777, false)
+	entry.Symlink = target
+	m.files[resolved] = entry
+	return nil
+}
+
+// Readlink returns the target of a symlink
+func (m *MockFileSystem) Readlink(path string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, file, err := m.lookup(path, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: unwrapPathError(err)}
+	}
+	if file.Symlink == "" {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: syscall.EINVAL}
+	}
+	return file.Symlink, nil
+}
+
+// MkdirAll creates a directory and any missing parents with the given mode
+func (m *MockFileSystem) MkdirAll(path string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordOperation("create", path, nil, mode, true, nil)
+
+	if err := m.mkdirAll(path, mode); err != nil {
+		err := &os.PathError{Op: "mkdir", Path: path, Err: unwrapPathError(err)}
+		m.updateLastOperation(false, err)
+		return err
+	}
+	return nil
+}
+
+// Lchown changes the ownership of a symlink itself
+func (m *MockFileSystem) Lchown(path, owner, group string) error {
+	return m.chown("lchown", path, owner, group, false)
+}
+
+// AddUser makes a user name known to the filesystem with the given UID
+func (m *MockFileSystem) AddUser(name string, uid int) *MockFileSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[name] = uid
+	return m
+}
+
+// AddGroup makes a group name known to the filesystem with the given GID
+func (m *MockFileSystem) AddGroup(name string, gid int) *MockFileSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.groups[name] = gid
+	return m
+}
+
+// SetClock sets the source of modification times, so tests can pin them or
+// step them forward between runs
+func (m *MockFileSystem) SetClock(now func() time.Time) *MockFileSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = now
+	return m
+}
+
+// SetModTime sets the modification time of a file
+func (m *MockFileSystem) SetModTime(path string, modTime time.Time) *MockFileSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, file, err := m.lookup(path, true); err == nil {
+		file.ModTime = modTime
+	}
+	return m
+}
+
+// SeedTree creates files, directories and symlinks, along with any missing
+// parent directories, which are created 0755 and owned by root
+func (m *MockFileSystem) SeedTree(tree map[string]FileSpec) *MockFileSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths := make([]string, 0, len(tree))
+	for p := range tree {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		spec := tree[p]
+		key := cleanPath(p)
+		if err := m.mkdirAll(path.Dir(key), 0755); err != nil {
+			m.t.Fatalf("SeedTree: %s: %v", p, err)
+		}
+
+		mode := spec.Mode
+		if mode == 0 {
+			switch {
+			case spec.Symlink != "":
+				mode = 0777
+			case spec.Dir:
+				mode = 0755
+			default:
+				mode = 0644
+			}
+		}
+		entry := m.newEntry([]byte(spec.Content), mode, spec.Dir)
+		entry.Symlink = spec.Symlink
+		if spec.Owner != "" {
+			entry.Owner, entry.Uid = spec.Owner, m.register(m.users, spec.Owner)
+		}
+		if spec.Group != "" {
+			entry.Group, entry.Gid = spec.Group, m.register(m.groups, spec.Group)
+		}
+		if !spec.ModTime.IsZero() {
+			entry.ModTime = spec.ModTime
+		}
+		m.files[key] = entry
+	}
+	return m
+}
+
+// StatOutput renders GNU stat -c output for a file, so tests can answer the
+// stat commands modules run with the mock filesystem's state. It supports
+// %a %A %F %g %G %n %N %s %u %U %Y and %%.
+func (m *MockFileSystem) StatOutput(path, format string) (string, error) {
+	info, err := m.stat("stat", path, false)
+	if err != nil {
+		return "", err
+	}
+	stat := info.Sys().(*FileStat)
+
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			out.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			out.WriteString(strconv.FormatUint(uint64(unixMode(info.Mode())&07777), 8))
+		case 'A':
+			out.WriteString(strings.Replace(info.Mode().String(), "L", "l", 1))
+		case 'F':
+			out.WriteString(fileType(info.Mode()))
+		case 'g':
+			out.WriteString(strconv.Itoa(stat.Gid))
+		case 'G':
+			out.WriteString(stat.Group)
+		case 'n':
+			out.WriteString(path)
+		case 'N':
+			out.WriteString("'" + path + "'")
+			if target, err := m.Readlink(path); err == nil {
+				out.WriteString(" -> '" + target + "'")
+			}
+		case 's':
+			out.WriteString(strconv.FormatInt(info.Size(), 10))
+		case 'u':
+			out.WriteString(strconv.Itoa(stat.Uid))
+		case 'U':
+			out.WriteString(stat.Owner)
+		case 'Y':
+			out.WriteString(strconv.FormatInt(info.ModTime().Unix(), 10))
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteByte('%')
+			out.WriteByte(format[i])
+		}
+	}
+	return out.String(), nil
+}
+
+// AssertSymlinkTarget asserts that a path is a symlink pointing at target
+func (m *MockFileSystem) AssertSymlinkTarget(path, target string) {
+	actual, err := m.Readlink(path)
+	if err != nil {
+		m.t.Errorf("Expected '%s' to be a symlink to '%s', but %v", path, target, err)
+	} else if actual != target {
+		m.t.Errorf("Expected '%s' to point at '%s', but it points at '%s'", path, target, actual)
+	}
+}
+
+// AssertFileOwner asserts the owner and group of a file
+func (m *MockFileSystem) AssertFileOwner(path, owner, group string) {
+	info, err := m.Stat(path)
+	if err != nil {
+		m.t.Errorf("Expected '%s' to be owned by %s:%s, but %v", path, owner, group, err)
+		return
+	}
+	if stat := info.Sys().(*FileStat); stat.Owner != owner || stat.Group != group {
+		m.t.Errorf("Expected '%s' to be owned by %s:%s, but it is owned by %s:%s", path, owner, group, stat.Owner, stat.Group)
+	}
+}
+
+// AssertDirExists asserts that a path is a directory
+func (m *MockFileSystem) AssertDirExists(path string) {
+	if !m.IsDir(path) {
+		m.t.Errorf("Expected '%s' to be a directory, but it isn't", path)
+	}
+}
+
+// AssertFileUnmodifiedSince asserts that a file has not been written since
+// the given time, which is how a second, idempotent run should leave it
+func (m *MockFileSystem) AssertFileUnmodifiedSince(path string, since time.Time) {
+	info, err := m.Stat(path)
+	if err != nil {
+		m.t.Errorf("Expected '%s' to be unmodified, but %v", path, err)
+	} else if info.ModTime().After(since) {
+		m.t.Errorf("Expected '%s' to be unmodified since %s, but it was modified at %s", path, since.Format(time.RFC3339Nano), info.ModTime().Format(time.RFC3339Nano))
+	}
+}
+
 // isReadOnlyPath checks if a path matches any read-only patterns
 func (m *MockFileSystem) isReadOnlyPath(path string) bool {
 	for _, pattern := range m.readOnlyPaths {
@@ -631,6 +865,255 @@ func (m *MockFileSystem) updateLastOperation(success bool, err error) {
 	}
 }
 
+// newEntry creates an existing, root-owned entry stamped with the clock
+func (m *MockFileSystem) newEntry(content []byte, mode os.FileMode, isDir bool) *MockFile {
+	return &MockFile{
+		Content: content,
+		Mode:    mode,
+		ModTime: m.clock(),
+		IsDir:   isDir,
+		Exists:  true,
+		Owner:   "root",
+		Group:   "root",
+	}
+}
+
+// cleanPath is the key a path is stored under
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	return path.Clean(p)
+}
+
+// lookup resolves symlinks in every directory component of p, and in the
+// final one when follow is set, and returns the resolved path and its entry.
+// A missing entry yields the resolved path with ErrNotExist, so callers can
+// create it there.
+func (m *MockFileSystem) lookup(p string, follow bool) (string, *MockFile, error) {
+	return m.lookupHops(cleanPath(p), follow, 0)
+}
+
+func (m *MockFileSystem) lookupHops(p string, follow bool, hops int) (string, *MockFile, error) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	resolved := ""
+	if strings.HasPrefix(p, "/") {
+		resolved = "/"
+	}
+
+	for i, part := range parts {
+		next := path.Join(resolved, part)
+		file, exists := m.files[next]
+		last := i == len(parts)-1
+		if !exists || !file.Exists {
+			if last {
+				return next, file, os.ErrNotExist
+			}
+			// Parents that were never created explicitly are implied, as
+			// they always have been for paths added directly
+			resolved = next
+			continue
+		}
+
+		if file.Symlink != "" && (follow || !last) {
+			if hops++; hops > maxSymlinks {
+				return next, nil, syscall.ELOOP
+			}
+			target := file.Symlink
+			if !path.IsAbs(target) {
+				target = path.Join(resolved, target)
+			}
+			rest := append([]string{target}, parts[i+1:]...)
+			return m.lookupHops(path.Join(rest...), follow, hops)
+		}
+		if !last && !file.IsDir {
+			return next, nil, syscall.ENOTDIR
+		}
+		if last {
+			return next, file, nil
+		}
+		resolved = next
+	}
+	return resolved, m.files[resolved], nil
+}
+
+// checkParent fails when the parent of a path to be created is a file
+func (m *MockFileSystem) checkParent(p string) error {
+	_, parent, err := m.lookup(path.Dir(p), true)
+	if err == nil && !parent.IsDir {
+		return syscall.ENOTDIR
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// mkdirAll creates a directory and its missing parents; callers hold the
+// lock
+func (m *MockFileSystem) mkdirAll(p string, mode os.FileMode) error {
+	resolved, file, err := m.lookup(p, true)
+	if err == nil {
+		if !file.IsDir {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := path.Dir(resolved); parent != resolved {
+		if err := m.mkdirAll(parent, mode); err != nil {
+			return err
+		}
+	}
+	m.files[resolved] = m.newEntry(nil, mode, true)
+	return nil
+}
+
+// children returns the paths of a directory's direct entries
+func (m *MockFileSystem) children(dir string) []string {
+	prefix := dir
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var children []string
+	for p, file := range m.files {
+		if !file.Exists || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if rel := strings.TrimPrefix(p, prefix); rel != "" && !strings.Contains(rel, "/") {
+			children = append(children, p)
+		}
+	}
+	return children
+}
+
+// stat backs Stat, Lstat and StatOutput
+func (m *MockFileSystem) stat(op, p string, follow bool) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	resolved, file, err := m.lookup(p, follow)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: unwrapPathError(err)}
+	}
+	if file.AccessError != nil {
+		return nil, file.AccessError
+	}
+	info := m.fileInfo(resolved, file)
+	info.name = path.Base(cleanPath(p))
+	return info, nil
+}
+
+// chown backs Chown and Lchown
+func (m *MockFileSystem) chown(op, p, owner, group string, follow bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordOperation("chown", p, []byte(fmt.Sprintf("%s:%s", owner, group)), 0, true, nil)
+
+	fail := func(err error) error {
+		err = &os.PathError{Op: op, Path: p, Err: err}
+		m.updateLastOperation(false, err)
+		return err
+	}
+
+	resolved, file, err := m.lookup(p, follow)
+	if err != nil {
+		return fail(unwrapPathError(err))
+	}
+
+	// Check if path is read-only
+	if m.isReadOnlyPath(resolved) || file.ReadOnly {
+		return fail(os.ErrPermission)
+	}
+
+	uid, known := m.users[owner]
+	if !known {
+		return fail(fmt.Errorf("invalid user: %q", owner))
+	}
+	gid, known := m.groups[group]
+	if !known {
+		return fail(fmt.Errorf("invalid group: %q", group))
+	}
+
+	file.Owner, file.Uid = owner, uid
+	file.Group, file.Gid = group, gid
+	return nil
+}
+
+// register returns the ID of a user or group name, allocating one from 1000
+// when the name is new
+func (m *MockFileSystem) register(ids map[string]int, name string) int {
+	if id, ok := ids[name]; ok {
+		return id
+	}
+	next := 1000
+	for _, id := range ids {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	ids[name] = next
+	return next
+}
+
+// fileInfo describes an entry with its type bits set, like os.Lstat
+func (m *MockFileSystem) fileInfo(p string, file *MockFile) *MockFileInfo {
+	info := &MockFileInfo{
+		name:    path.Base(p),
+		size:    int64(len(file.Content)),
+		mode:    file.Mode,
+		modTime: file.ModTime,
+		isDir:   file.IsDir,
+		stat:    &FileStat{Uid: file.Uid, Gid: file.Gid, Owner: file.Owner, Group: file.Group},
+	}
+	switch {
+	case file.Symlink != "":
+		info.mode |= os.ModeSymlink
+		info.size = int64(len(file.Symlink))
+	case file.IsDir:
+		info.mode |= os.ModeDir
+	}
+	return info
+}
+
+// unwrapPathError returns the errno inside an error from lookup
+func unwrapPathError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+// unixMode converts Go's mode bits to the octal permission bits stat prints
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// fileType is the %F description stat gives
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	case mode.IsDir():
+		return "directory"
+	default:
+		return "regular file"
+	}
+}
+
 // MockFileInfo implements os.FileInfo for testing
 type MockFileInfo struct {
 	name    string
@@ -638,6 +1121,7 @@ type MockFileInfo struct {
 	mode    os.FileMode
 	modTime time.Time
 	isDir   bool
+	stat    *FileStat
 }
 
 func (m *MockFileInfo) Name() string       { return m.name }
@@ -645,7 +1129,13 @@ func (m *MockFileInfo) Size() int64        { return m.size }
 func (m *MockFileInfo) Mode() os.FileMode  { return m.mode }
 func (m *MockFileInfo) ModTime() time.Time { return m.modTime }
 func (m *MockFileInfo) IsDir() bool        { return m.isDir }
-func (m *MockFileInfo) Sys() interface{}   { return nil }
+// Sys returns a *FileStat with the owner and group
+func (m *MockFileInfo) Sys() interface{} {
+	if m.stat == nil {
+		return nil
+	}
+	return m.stat
+}
 
 // Helper functions for common test scenarios
 
@@ -15,6 +15,7 @@ type ModuleTestHelper struct {
 	connection *MockConnection
 	filesystem *MockFileSystem
 	context    context.Context
+	lastResult *types.Result
 }
 
 // NewModuleTestHelper creates a new module test helper
@@ -77,6 +78,7 @@ func (h *ModuleTestHelper) ExecuteWithHost(args map[string]interface{}, checkMod
 		h.t.Fatal("Module returned nil result")
 	}
 	
+	h.lastResult = result
 	return result
 }
 
@@ -277,6 +279,7 @@ type TestCase struct {
 	ExpectError  bool
 	Setup        func(helper *ModuleTestHelper) // Optional setup function
 	Assertions   func(helper *ModuleTestHelper, result *types.Result) // Custom assertions
+	Snapshot     string // Optional golden file the result must match
 }
 
 // RunTestCases executes multiple test cases in sequence
@@ -307,6 +310,10 @@ func (h *ModuleTestHelper) RunTestCases(cases []TestCase) {
 				if tc.Assertions != nil {
 					tc.Assertions(caseHelper, result)
 				}
+				
+				if tc.Snapshot != "" {
+					AssertResultSnapshot(t, tc.Snapshot, result)
+				}
 			}
 			
 			// Verify mocks
@@ -0,0 +1,219 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// updateSnapshots rewrites golden files instead of comparing against them:
+//
+//	go test ./pkg/modules -run TestLineInFile -update
+var updateSnapshots = flag.Bool("update", false, "rewrite golden result snapshots")
+
+// Placeholders for values that change from run to run
+const (
+	snapshotTimestamp = "<timestamp>"
+	snapshotDuration  = "<duration>"
+)
+
+// resultSnapshot is the serialized form of a result. Fields are listed in a
+// fixed order and map keys are sorted, so golden files only change when the
+// result does.
+type resultSnapshot struct {
+	Success    bool                   `json:"success"`
+	Changed    bool                   `json:"changed"`
+	Simulated  bool                   `json:"simulated,omitempty"`
+	Host       string                 `json:"host,omitempty"`
+	ModuleName string                 `json:"module_name,omitempty"`
+	TaskName   string                 `json:"task_name,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	StartTime  string                 `json:"start_time,omitempty"`
+	EndTime    string                 `json:"end_time,omitempty"`
+	Duration   string                 `json:"duration,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Diff       *diffSnapshot          `json:"diff,omitempty"`
+}
+
+type diffSnapshot struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// SnapshotResult serializes a result for a golden file, replacing
+// timestamps and durations, including those in the result data, with
+// placeholders
+func SnapshotResult(result *types.Result) ([]byte, error) {
+	snapshot := resultSnapshot{
+		Success:    result.Success,
+		Changed:    result.Changed,
+		Simulated:  result.Simulated,
+		Host:       result.Host,
+		ModuleName: result.ModuleName,
+		TaskName:   result.TaskName,
+		Message:    result.Message,
+	}
+	if result.Error != nil {
+		snapshot.Error = result.Error.Error()
+	}
+	if !result.StartTime.IsZero() {
+		snapshot.StartTime = snapshotTimestamp
+	}
+	if !result.EndTime.IsZero() {
+		snapshot.EndTime = snapshotTimestamp
+	}
+	if result.Duration != 0 {
+		snapshot.Duration = snapshotDuration
+	}
+	if len(result.Data) > 0 {
+		snapshot.Data = normalizeSnapshotValue(result.Data).(map[string]interface{})
+	}
+	if result.Diff != nil {
+		snapshot.Diff = &diffSnapshot{Before: result.Diff.Before, After: result.Diff.After, Diff: result.Diff.Diff}
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// normalizeSnapshotValue replaces times, durations and strings holding an
+// RFC 3339 timestamp, and turns errors into their messages
+func normalizeSnapshotValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return snapshotTimestamp
+	case *time.Time:
+		return snapshotTimestamp
+	case time.Duration:
+		return snapshotDuration
+	case error:
+		return v.Error()
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return snapshotTimestamp
+		}
+		return v
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeSnapshotValue(item)
+		}
+		return normalized
+	case map[string]string:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeSnapshotValue(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeSnapshotValue(item)
+		}
+		return normalized
+	case []map[string]interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeSnapshotValue(item)
+		}
+		return normalized
+	default:
+		return v
+	}
+}
+
+// AssertResultSnapshot compares a result with a golden file, or rewrites
+// the file when the tests run with -update
+func AssertResultSnapshot(t *testing.T, golden string, result *types.Result) {
+	t.Helper()
+	assertSnapshot(t, golden, result, *updateSnapshots)
+}
+
+func assertSnapshot(t *testing.T, golden string, result *types.Result, update bool) {
+	t.Helper()
+
+	if result == nil {
+		t.Fatalf("no result to compare with %s", golden)
+	}
+	actual, err := SnapshotResult(result)
+	if err != nil {
+		t.Fatalf("serializing the result for %s: %v", golden, err)
+	}
+
+	if update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("creating the directory for %s: %v", golden, err)
+		}
+		if err := os.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("updating %s: %v", golden, err)
+		}
+		t.Logf("updated %s", golden)
+		return
+	}
+
+	expected, err := os.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist; run the test with -update to create it", golden)
+	}
+	if err != nil {
+		t.Fatalf("reading %s: %v", golden, err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("result does not match %s (run with -update to accept it):\n%s", golden, snapshotDiff(string(expected), string(actual)))
+	}
+}
+
+// snapshotDiff lists the lines that differ between the golden file and the
+// actual snapshot
+func snapshotDiff(expected, actual string) string {
+	want := strings.Split(expected, "\n")
+	got := strings.Split(actual, "\n")
+
+	var out strings.Builder
+	lines := len(want)
+	if len(got) > lines {
+		lines = len(got)
+	}
+	for i := 0; i < lines; i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w == g {
+			continue
+		}
+		if i < len(want) {
+			fmt.Fprintf(&out, "%4d - %s\n", i+1, w)
+		}
+		if i < len(got) {
+			fmt.Fprintf(&out, "%4d + %s\n", i+1, g)
+		}
+	}
+	return out.String()
+}
+
+// AssertResultSnapshot compares the result of the last Execute with a
+// golden file; see the package-level AssertResultSnapshot
+func (h *ModuleTestHelper) AssertResultSnapshot(t *testing.T, golden string) {
+	t.Helper()
+	AssertResultSnapshot(t, golden, h.lastResult)
+}
@@ -14,8 +14,16 @@ type ModuleCapability struct {
 	AsyncMode   bool   `json:"async"`
 	Platform    string `json:"platform"` // "linux", "windows", "all"
 	RequiresRoot bool  `json:"requires_root"`
+	// ConcurrencyClass names a host-wide resource the module locks, such as
+	// the dpkg or rpm database. The runner never runs two modules of the
+	// same class on a host at once.
+	ConcurrencyClass string `json:"concurrency_class,omitempty"`
 }
 
+// ConcurrencyClassPackageManager is shared by modules that run the system
+// package manager, which holds an exclusive lock while it works
+const ConcurrencyClassPackageManager = "package_manager"
+
 // DefaultCapabilities returns default module capabilities
 func DefaultCapabilities() *ModuleCapability {
 	return &ModuleCapability{
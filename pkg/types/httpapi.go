@@ -0,0 +1,80 @@
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPAPIConnection is implemented by connections to devices and appliances
+// managed through an HTTP API instead of a shell, such as load balancers
+// and firewalls. Modules for them send requests over the connection's
+// authenticated session.
+type HTTPAPIConnection interface {
+	Connection
+
+	// Send sends a request to the device API and returns its response,
+	// whatever its status
+	Send(ctx context.Context, request HTTPRequest) (*HTTPResponse, error)
+}
+
+// HTTPRequest is a request sent over an HTTPAPIConnection
+type HTTPRequest struct {
+	Method  string
+	Path    string // Relative to the API base path, with an optional query
+	Headers map[string]string
+	Body    []byte
+}
+
+// NewJSONRequest creates a request with body encoded as JSON, or without
+// a body when it is nil
+func NewJSONRequest(method, path string, body interface{}) (HTTPRequest, error) {
+	request := HTTPRequest{Method: method, Path: path}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return request, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		request.Body = data
+		request.Headers = map[string]string{"Content-Type": "application/json"}
+	}
+	return request, nil
+}
+
+// HTTPResponse is the response to an HTTPRequest
+type HTTPResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+}
+
+// OK reports whether the request succeeded with a 2xx status
+func (r *HTTPResponse) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// JSON decodes the response body into v
+func (r *HTTPResponse) JSON(v interface{}) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return nil
+}
+
+// Error describes a failed response with its status and the start of its
+// body, which devices use to explain the failure
+func (r *HTTPResponse) Error() error {
+	if r.OK() {
+		return nil
+	}
+	body := strings.TrimSpace(string(r.Body))
+	if len(body) > 512 {
+		body = body[:512] + "..."
+	}
+	if body == "" {
+		return fmt.Errorf("HTTP %d %s", r.StatusCode, http.StatusText(r.StatusCode))
+	}
+	return fmt.Errorf("HTTP %d %s: %s", r.StatusCode, http.StatusText(r.StatusCode), body)
+}
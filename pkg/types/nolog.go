@@ -0,0 +1,113 @@
+package types
+
+import "errors"
+
+// NoLogValue replaces the arguments and results of tasks with no_log set
+// wherever they are shown or logged, as in Ansible
+const NoLogValue = "VALUE_SPECIFIED_IN_NO_LOG_PARAMETER"
+
+// noLogStatusKeys are result data kept when censoring, as they only give
+// the outcome that run statistics and callbacks count
+var noLogStatusKeys = map[string]bool{
+	"changed":     true,
+	"failed":      true,
+	"skipped":     true,
+	"unreachable": true,
+}
+
+// NoLogEnabled reports whether the task's arguments and results are hidden
+// from output
+func (t *Task) NoLogEnabled() bool {
+	return t.NoLog != nil && *t.NoLog
+}
+
+// CensorValues returns a copy of values with every value replaced by
+// NoLogValue
+func CensorValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	censored := make(map[string]interface{}, len(values))
+	for key := range values {
+		censored[key] = NoLogValue
+	}
+	return censored
+}
+
+// CensorTask returns a copy of a no_log task with its arguments and vars
+// censored. Other tasks are returned unchanged.
+func CensorTask(task *Task) *Task {
+	if task == nil || !task.NoLogEnabled() {
+		return task
+	}
+	censored := *task
+	censored.Args = CensorValues(task.Args)
+	censored.Vars = CensorValues(task.Vars)
+	return &censored
+}
+
+// CensorResult returns a copy of a no_log result with its message, error,
+// data, diff and captured state censored, keeping only its outcome. Other
+// results are returned unchanged.
+func CensorResult(result *Result) *Result {
+	if result == nil || !result.NoLog {
+		return result
+	}
+	censored := *result
+	if result.Message != "" {
+		censored.Message = NoLogValue
+	}
+	if result.Error != nil {
+		censored.Error = errors.New(NoLogValue)
+	}
+	if result.Data != nil {
+		censored.Data = make(map[string]interface{}, len(result.Data))
+		for key, value := range result.Data {
+			if noLogStatusKeys[key] {
+				censored.Data[key] = value
+			} else {
+				censored.Data[key] = NoLogValue
+			}
+		}
+	}
+	censored.Diff = nil
+	if result.State != nil {
+		censored.State = &StateCapture{Before: NoLogValue, After: NoLogValue}
+	}
+	return &censored
+}
+
+// CensorStreamEvent returns a copy of an event of a no_log task with its
+// output, error, result, step and progress censored. Other events are
+// returned unchanged.
+func CensorStreamEvent(event StreamEvent) StreamEvent {
+	if !event.NoLog && (event.Result == nil || !event.Result.NoLog) {
+		return event
+	}
+	event.NoLog = true
+	if event.Data != "" {
+		event.Data = NoLogValue
+	}
+	if event.Error != nil {
+		event.Error = errors.New(NoLogValue)
+	}
+	if event.Result != nil {
+		result := *event.Result
+		result.NoLog = true
+		event.Result = CensorResult(&result)
+	}
+	if event.Step != nil {
+		step := *event.Step
+		step.Description = NoLogValue
+		step.Metadata = CensorValues(step.Metadata)
+		event.Step = &step
+	}
+	if event.Progress != nil {
+		progress := *event.Progress
+		progress.Message = NoLogValue
+		progress.CurrentStep = nil
+		progress.CompletedSteps = nil
+		event.Progress = &progress
+	}
+	return event
+}
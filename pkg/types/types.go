@@ -5,6 +5,7 @@ package types
 import (
 	"context"
 	"io"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -29,17 +30,22 @@ const (
 	// Execution modules
 	TypeCommand ModuleType = "command"
 	TypeShell   ModuleType = "shell"
+	TypeRaw     ModuleType = "raw"
 
 	// Utility modules
 	TypePing  ModuleType = "ping"
 	TypeSetup ModuleType = "setup"
 	TypeDebug ModuleType = "debug"
+	TypePause ModuleType = "pause"
 
 	// OS-specific package managers
 	TypeHomebrew ModuleType = "homebrew"
 	TypeApt      ModuleType = "apt"
 	TypeYum      ModuleType = "yum"
 	TypeDnf      ModuleType = "dnf"
+	TypeZypper   ModuleType = "zypper"
+	TypeApk      ModuleType = "apk"
+	TypePacman   ModuleType = "pacman"
 )
 
 // String returns the string representation of the module type
@@ -53,7 +59,7 @@ func (m ModuleType) IsValid() bool {
 	case TypeFile, TypeService, TypePackage, TypeUser, TypeGroup,
 		TypeCopy, TypeTemplate, TypeCommand, TypeShell,
 		TypePing, TypeSetup, TypeDebug,
-		TypeHomebrew, TypeApt, TypeYum, TypeDnf:
+		TypeHomebrew, TypeApt, TypeYum, TypeDnf, TypeZypper, TypeApk, TypePacman:
 		return true
 	default:
 		return false
@@ -66,7 +72,7 @@ func AllModuleTypes() []ModuleType {
 		TypeFile, TypeService, TypePackage, TypeUser, TypeGroup,
 		TypeCopy, TypeTemplate, TypeCommand, TypeShell,
 		TypePing, TypeSetup, TypeDebug,
-		TypeHomebrew, TypeApt, TypeYum, TypeDnf,
+		TypeHomebrew, TypeApt, TypeYum, TypeDnf, TypeZypper, TypeApk, TypePacman,
 	}
 }
 
@@ -110,6 +116,18 @@ type Result struct {
 	ModuleName string                 `json:"module_name"`
 	Diff       *DiffResult            `json:"diff,omitempty"`     // Diff output for diff mode
 	Simulated  bool                   `json:"simulated,omitempty"` // True when in check mode
+	State      *StateCapture          `json:"state,omitempty"`     // Before/after state when state capture is enabled
+
+	// NoLog marks the result of a task with no_log set, which CensorResult
+	// hides before it is shown or logged
+	NoLog bool `json:"no_log,omitempty"`
+}
+
+// StateCapture records what a module manages as it was before the task and
+// as the task left it, for audit trails
+type StateCapture struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
 }
 
 // Host represents a target host in the inventory
@@ -143,6 +161,9 @@ type Task struct {
 	IgnoreErrors bool                   `yaml:"ignore_errors,omitempty" json:"ignore_errors,omitempty"`
 	RunOnce      bool                   `yaml:"run_once,omitempty" json:"run_once,omitempty"`
 	Delegate     string                 `yaml:"delegate_to,omitempty" json:"delegate_to,omitempty"`
+
+	// Facts gathered by a delegated task belong to the delegate host
+	DelegateFacts bool `yaml:"delegate_facts,omitempty" json:"delegate_facts,omitempty"`
 	
 	// Advanced conditional execution
 	FailedWhen   interface{}            `yaml:"failed_when,omitempty" json:"failed_when,omitempty"`
@@ -154,6 +175,13 @@ type Task struct {
 	// Loop control
 	WithItems    interface{}            `yaml:"with_items,omitempty" json:"with_items,omitempty"`
 	LoopControl  map[string]interface{} `yaml:"loop_control,omitempty" json:"loop_control,omitempty"`
+
+	// Lookup loops over the entries of a dict, controller files matching
+	// globs, the subelements of a list of dicts or the product of lists
+	WithDict        interface{} `yaml:"with_dict,omitempty" json:"with_dict,omitempty"`
+	WithFileglob    interface{} `yaml:"with_fileglob,omitempty" json:"with_fileglob,omitempty"`
+	WithSubelements interface{} `yaml:"with_subelements,omitempty" json:"with_subelements,omitempty"`
+	WithNested      interface{} `yaml:"with_nested,omitempty" json:"with_nested,omitempty"`
 	
 	// Handler support
 	Notify       []string               `yaml:"notify,omitempty" json:"notify,omitempty"`
@@ -165,9 +193,20 @@ type Task struct {
 	Async        int                    `yaml:"async,omitempty" json:"async,omitempty"`
 	Poll         int                    `yaml:"poll,omitempty" json:"poll,omitempty"`
 	
-	// Execution modes
-	CheckMode    bool                   `yaml:"check_mode,omitempty" json:"check_mode,omitempty"`
+	// Execution modes. CheckMode true always simulates the task, and false
+	// runs it for real even in check mode.
+	CheckMode    *bool                  `yaml:"check_mode,omitempty" json:"check_mode,omitempty"`
 	DiffMode     bool                   `yaml:"diff,omitempty" json:"diff,omitempty"`
+	
+	// Privilege escalation, overriding ansible_become* variables
+	Become       *bool                  `yaml:"become,omitempty" json:"become,omitempty"`
+	BecomeUser   string                 `yaml:"become_user,omitempty" json:"become_user,omitempty"`
+	BecomeMethod string                 `yaml:"become_method,omitempty" json:"become_method,omitempty"`
+	BecomeFlags  string                 `yaml:"become_flags,omitempty" json:"become_flags,omitempty"`
+
+	// NoLog hides the task's arguments and results from output and logs,
+	// overriding the play's no_log
+	NoLog *bool `yaml:"no_log,omitempty" json:"no_log,omitempty"`
 }
 
 // UnmarshalYAML implements custom YAML unmarshalling for Ansible-style task syntax
@@ -215,6 +254,9 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 			}
 		}
 		delete(rawTask, "tags")
+	} else if tag, ok := rawTask["tags"].(string); ok {
+		alias.Tags = []string{tag}
+		delete(rawTask, "tags")
 	}
 	if ignoreErrors, ok := rawTask["ignore_errors"].(bool); ok {
 		alias.IgnoreErrors = ignoreErrors
@@ -228,6 +270,10 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 		alias.Delegate = delegate
 		delete(rawTask, "delegate_to")
 	}
+	if delegateFacts, ok := rawTask["delegate_facts"].(bool); ok {
+		alias.DelegateFacts = delegateFacts
+		delete(rawTask, "delegate_facts")
+	}
 	
 	// Parse new advanced fields
 	if failedWhen, ok := rawTask["failed_when"]; ok {
@@ -254,6 +300,18 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 		alias.WithItems = withItems
 		delete(rawTask, "with_items")
 	}
+	for key, field := range map[string]*interface{}{
+		"with_dict":        &alias.WithDict,
+		"with_fileglob":    &alias.WithFileglob,
+		"with_subelements": &alias.WithSubelements,
+		"with_nested":      &alias.WithNested,
+		"with_cartesian":   &alias.WithNested,
+	} {
+		if value, ok := rawTask[key]; ok {
+			*field = value
+			delete(rawTask, key)
+		}
+	}
 	if loopControl, ok := rawTask["loop_control"].(map[string]interface{}); ok {
 		alias.LoopControl = loopControl
 		delete(rawTask, "loop_control")
@@ -283,9 +341,7 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 	if environment, ok := rawTask["environment"].(map[string]interface{}); ok {
 		alias.Environment = make(map[string]string)
 		for k, v := range environment {
-			if vStr, ok := v.(string); ok {
-				alias.Environment[k] = vStr
-			}
+			alias.Environment[k] = ConvertToString(v)
 		}
 		delete(rawTask, "environment")
 	}
@@ -297,6 +353,30 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 		alias.Poll = poll
 		delete(rawTask, "poll")
 	}
+	if become, ok := rawTask["become"].(bool); ok {
+		alias.Become = &become
+		delete(rawTask, "become")
+	}
+	if becomeUser, ok := rawTask["become_user"].(string); ok {
+		alias.BecomeUser = becomeUser
+		delete(rawTask, "become_user")
+	}
+	if becomeMethod, ok := rawTask["become_method"].(string); ok {
+		alias.BecomeMethod = becomeMethod
+		delete(rawTask, "become_method")
+	}
+	if becomeFlags, ok := rawTask["become_flags"].(string); ok {
+		alias.BecomeFlags = becomeFlags
+		delete(rawTask, "become_flags")
+	}
+	if checkMode, ok := rawTask["check_mode"].(bool); ok {
+		alias.CheckMode = &checkMode
+		delete(rawTask, "check_mode")
+	}
+	if noLog, ok := rawTask["no_log"].(bool); ok {
+		alias.NoLog = &noLog
+		delete(rawTask, "no_log")
+	}
 	
 	// If module is not set, look for Ansible-style module syntax (e.g., "command: {...}")
 	if alias.Module == "" {
@@ -306,30 +386,168 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 			"package", "user", "group", "debug", "setup", "lineinfile",
 			"replace", "blockinfile", "fetch", "synchronize", "unarchive",
 			"git", "apt", "yum", "pip", "systemd", "cron", "mount",
+			"raw", "script", "slurp", "pause", "find",
 		}
 		
 		for _, moduleName := range knownModules {
 			if moduleArgs, exists := rawTask[moduleName]; exists {
 				alias.Module = ModuleType(moduleName)
-				if moduleArgs != nil {
-					if argsMap, ok := moduleArgs.(map[string]interface{}); ok {
-						alias.Args = argsMap
-					} else {
-						// Handle case where module has no args (e.g., "setup:")
-						alias.Args = make(map[string]interface{})
-					}
-				} else {
-					alias.Args = make(map[string]interface{})
+				var inline map[string]interface{}
+				switch v := moduleArgs.(type) {
+				case map[string]interface{}:
+					inline = v
+				case string:
+					// Free-form arguments, like "command: make creates=build"
+					inline = ParseFreeFormArgs(alias.Module, v)
 				}
+				// Arguments given inline win over the task's args
+				args := make(map[string]interface{}, len(alias.Args)+len(inline))
+				for k, v := range alias.Args {
+					args[k] = v
+				}
+				for k, v := range inline {
+					args[k] = v
+				}
+				alias.Args = args
 				break
 			}
 		}
+
+		// local_action runs a module on the controller, as delegate_to:
+		// localhost
+		if action, ok := rawTask["local_action"]; ok && alias.Module == "" {
+			alias.Module, alias.Args = parseLocalAction(action)
+			alias.Delegate = "localhost"
+		}
+
+		// Meta actions such as "meta: flush_handlers" are run by the executor
+		if action, ok := rawTask["meta"].(string); ok && alias.Module == "" {
+			alias.Module = ModuleType("meta")
+			alias.Args = map[string]interface{}{"action": action}
+		}
+
+		// Task files are pulled in by the playbook parser and executor, and
+		// take the file either directly or as the file argument
+		for _, directive := range []string{"import_tasks", "include_tasks"} {
+			spec, ok := rawTask[directive]
+			if !ok || alias.Module != "" {
+				continue
+			}
+			alias.Module = ModuleType(directive)
+			switch v := spec.(type) {
+			case string:
+				alias.Args = map[string]interface{}{"file": v}
+			case map[string]interface{}:
+				alias.Args = v
+			default:
+				alias.Args = make(map[string]interface{})
+			}
+		}
 	}
 	
 	*t = Task(alias)
 	return nil
 }
 
+// parseLocalAction parses the module of a local_action, given as a map
+// with a module key or as "module args". The args of command, shell and raw
+// are the command line; other modules take key=value pairs.
+func parseLocalAction(action interface{}) (ModuleType, map[string]interface{}) {
+	args := make(map[string]interface{})
+	switch v := action.(type) {
+	case map[string]interface{}:
+		module, _ := v["module"].(string)
+		for key, value := range v {
+			if key != "module" {
+				args[key] = value
+			}
+		}
+		return ModuleType(module), args
+	case string:
+		module, params, _ := strings.Cut(strings.TrimSpace(v), " ")
+		return ModuleType(module), ParseFreeFormArgs(ModuleType(module), params)
+	}
+	return "", args
+}
+
+// freeFormOptions are the options command-style modules accept as
+// key=value words within their command line, like Ansible's free-form
+// arguments
+var freeFormOptions = map[ModuleType][]string{
+	"command": {"creates", "removes", "chdir", "stdin", "stdin_add_newline", "warn"},
+	"shell":   {"creates", "removes", "chdir", "executable", "stdin", "stdin_add_newline", "warn"},
+	"raw":     {"executable"},
+	"script":  {"creates", "removes", "chdir", "executable"},
+}
+
+// ParseFreeFormArgs parses the arguments of a module given as a string. The
+// command line of command, shell, raw and script becomes cmd, apart from the
+// key=value options they accept such as creates= and chdir=; other modules
+// take key=value pairs. Quoted values may contain spaces.
+func ParseFreeFormArgs(module ModuleType, params string) map[string]interface{} {
+	args := make(map[string]interface{})
+	options, freeForm := freeFormOptions[module]
+
+	var command []string
+	for _, word := range splitFreeForm(params) {
+		key, value, ok := strings.Cut(word, "=")
+		switch {
+		case ok && freeForm && StringSliceContains(options, key):
+			args[key] = unquoteFreeForm(value)
+		case freeForm:
+			command = append(command, word)
+		case ok:
+			args[key] = unquoteFreeForm(value)
+		}
+	}
+	if len(command) > 0 {
+		args["cmd"] = strings.Join(command, " ")
+	}
+	return args
+}
+
+// splitFreeForm splits free-form arguments into words at spaces outside
+// quotes, keeping the quotes
+func splitFreeForm(params string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range params {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t' || r == '\n':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		word.WriteRune(r)
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// unquoteFreeForm removes the quotes around a free-form option value
+func unquoteFreeForm(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
 // Play represents a collection of tasks to execute on hosts
 type Play struct {
 	Name      string                 `yaml:"name" json:"name"`
@@ -340,8 +558,137 @@ type Play struct {
 	PostTasks []Task                 `yaml:"post_tasks,omitempty" json:"post_tasks,omitempty"`
 	Handlers  []Task                 `yaml:"handlers,omitempty" json:"handlers,omitempty"`
 	Tags      []string               `yaml:"tags,omitempty" json:"tags,omitempty"`
-	Serial    int                    `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Serial    interface{}            `yaml:"serial,omitempty" json:"serial,omitempty"` // batch size, "30%" or a list of them
 	Strategy  string                 `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+
+	// Roles run after pre_tasks and before the play's own tasks
+	Roles []RoleReference `yaml:"roles,omitempty" json:"roles,omitempty"`
+
+	// ImportPlaybook replaces this entry with the plays of another playbook
+	// file, which receive the entry's vars and tags
+	ImportPlaybook string `yaml:"import_playbook,omitempty" json:"import_playbook,omitempty"`
+
+	// DependsOn names the plays that must complete before this one starts.
+	// When any play of a playbook declares dependencies, plays that do not
+	// depend on each other run concurrently.
+	DependsOn []string `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+
+	// MaxFailPercentage aborts the play when more than this share of the
+	// hosts in a serial batch fail. When unset any failure stops the play.
+	MaxFailPercentage *float64 `yaml:"max_fail_percentage,omitempty" json:"max_fail_percentage,omitempty"`
+
+	// FailureDomain caps how many hosts of one rack or zone a serial batch
+	// takes down at once
+	FailureDomain *FailureDomain `yaml:"failure_domain,omitempty" json:"failure_domain,omitempty"`
+
+	Become       *bool  `yaml:"become,omitempty" json:"become,omitempty"`
+	BecomeUser   string `yaml:"become_user,omitempty" json:"become_user,omitempty"`
+	BecomeMethod string `yaml:"become_method,omitempty" json:"become_method,omitempty"`
+
+	MaintenanceWindow *MaintenanceWindow `yaml:"maintenance_window,omitempty" json:"maintenance_window,omitempty"`
+
+	// Environment is set for the commands of every task in the play, under
+	// the task's own environment
+	Environment map[string]string `yaml:"environment,omitempty" json:"environment,omitempty"`
+
+	// ModuleDefaults gives default arguments by module name, such as apt or
+	// ansible.builtin.apt, under the arguments a task sets
+	ModuleDefaults map[string]map[string]interface{} `yaml:"module_defaults,omitempty" json:"module_defaults,omitempty"`
+
+	// NoLog hides the arguments and results of every task in the play from
+	// output and logs, unless a task sets its own no_log
+	NoLog *bool `yaml:"no_log,omitempty" json:"no_log,omitempty"`
+}
+
+// RoleReference applies a role in a play, written either as the role name
+// or as a mapping with the role's vars, when condition and tags
+type RoleReference struct {
+	Role string                 `yaml:"role" json:"role"`
+	Vars map[string]interface{} `yaml:"vars,omitempty" json:"vars,omitempty"`
+	When interface{}            `yaml:"when,omitempty" json:"when,omitempty"`
+	Tags []string               `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain role name, and treats the keys of a mapping
+// other than role (or name), vars, when and tags as role parameters
+func (r *RoleReference) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Role = value.Value
+		return nil
+	}
+
+	var raw map[string]interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	for key, v := range raw {
+		switch key {
+		case "role", "name":
+			r.Role = ConvertToString(v)
+		case "vars":
+			if vars, ok := v.(map[string]interface{}); ok {
+				for k, val := range vars {
+					r.setVar(k, val)
+				}
+			}
+		case "when":
+			r.When = v
+		case "tags":
+			switch tags := v.(type) {
+			case string:
+				r.Tags = []string{tags}
+			case []interface{}:
+				for _, tag := range tags {
+					r.Tags = append(r.Tags, ConvertToString(tag))
+				}
+			}
+		default:
+			r.setVar(key, v)
+		}
+	}
+	return nil
+}
+
+func (r *RoleReference) setVar(key string, value interface{}) {
+	if r.Vars == nil {
+		r.Vars = make(map[string]interface{})
+	}
+	r.Vars[key] = value
+}
+
+// FailureDomain groups hosts by a host variable such as rack or
+// availability_zone so that no serial batch holds more than MaxHosts hosts
+// of one domain. It is written either as the variable name or as a mapping.
+type FailureDomain struct {
+	Var      string `yaml:"var" json:"var"`
+	MaxHosts int    `yaml:"max_hosts,omitempty" json:"max_hosts,omitempty"` // Defaults to 1
+}
+
+// UnmarshalYAML accepts a plain variable name
+func (d *FailureDomain) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		d.Var = value.Value
+		return nil
+	}
+
+	type plain FailureDomain
+	return value.Decode((*plain)(d))
+}
+
+// MaintenanceWindow restricts when a play may make changes
+type MaintenanceWindow struct {
+	Windows    []WindowSchedule `yaml:"windows" json:"windows"`
+	Timezone   string           `yaml:"timezone,omitempty" json:"timezone,omitempty"`     // IANA name, defaults to local time
+	Mode       string           `yaml:"mode,omitempty" json:"mode,omitempty"`             // "refuse" (default) or "wait"
+	Checkpoint string           `yaml:"checkpoint,omitempty" json:"checkpoint,omitempty"` // File recording where a paused run stopped
+}
+
+// WindowSchedule is a recurring window that opens on a cron schedule
+// ("minute hour day-of-month month day-of-week") and stays open for Duration
+type WindowSchedule struct {
+	Cron     string `yaml:"cron" json:"cron"`
+	Duration string `yaml:"duration" json:"duration"` // e.g. "2h", "90m"
 }
 
 // Playbook represents a collection of plays
@@ -364,6 +711,30 @@ type ConnectionInfo struct {
 	// Windows/WinRM specific fields
 	UseSSL     bool          `yaml:"use_ssl,omitempty" json:"use_ssl,omitempty"`
 	SkipVerify bool          `yaml:"skip_verify,omitempty" json:"skip_verify,omitempty"`
+	
+	// TLS options of HTTPS connections such as httpapi: PEM files of the CAs
+	// to trust and of a client certificate, and the name to verify the
+	// server certificate against when it differs from Host
+	CACert        string `yaml:"ca_cert,omitempty" json:"ca_cert,omitempty"`
+	ClientCert    string `yaml:"client_cert,omitempty" json:"client_cert,omitempty"`
+	ClientKey     string `yaml:"client_key,omitempty" json:"client_key,omitempty"`
+	TLSServerName string `yaml:"tls_server_name,omitempty" json:"tls_server_name,omitempty"`
+	
+	// SSH bastion / jump host support. ProxyCommand and ProxyJump (or
+	// JumpHosts) are mutually exclusive, as in OpenSSH.
+	ProxyJump    string     `yaml:"proxy_jump,omitempty" json:"proxy_jump,omitempty"`       // e.g. "user@bastion1:22,bastion2"
+	ProxyCommand string     `yaml:"proxy_command,omitempty" json:"proxy_command,omitempty"` // e.g. "ssh -W %h:%p bastion"
+	JumpHosts    []JumpHost `yaml:"jump_hosts,omitempty" json:"jump_hosts,omitempty"`       // Hops with per-hop credentials
+}
+
+// JumpHost describes a single SSH hop used to reach a target host.
+// Empty credential fields inherit from the target ConnectionInfo.
+type JumpHost struct {
+	Host       string `yaml:"host" json:"host"`
+	Port       int    `yaml:"port,omitempty" json:"port,omitempty"`
+	User       string `yaml:"user,omitempty" json:"user,omitempty"`
+	Password   string `yaml:"password,omitempty" json:"password,omitempty"`
+	PrivateKey string `yaml:"private_key,omitempty" json:"private_key,omitempty"`
 }
 
 // IsWindows returns true if this connection is for a Windows host
@@ -379,10 +750,12 @@ type ExecuteOptions struct {
 	User       string
 	Sudo       bool
 	Shell      string // For WinRM: "powershell" or "cmd"
+	Become     *BecomeConfig // Privilege escalation; takes precedence over User/Sudo
 	
 	// Execution modes
 	CheckMode    bool `json:"check_mode"`    // Don't make actual changes
 	DiffMode     bool `json:"diff_mode"`     // Show what would change
+	CaptureState bool `json:"capture_state"` // Record the before/after state in the result
 	ForceHandler bool `json:"force_handler"` // Force handler execution
 	
 	// Streaming options
@@ -394,6 +767,19 @@ type ExecuteOptions struct {
 	ModuleOptions map[string]interface{} `json:"module_options,omitempty"` // Module-specific overrides
 }
 
+// BecomeConfig describes how a command is run as another user
+type BecomeConfig struct {
+	Method   string // Become plugin: sudo (default), su, doas or runas
+	User     string // Target user, defaults to the plugin's superuser
+	Password string
+	Flags    string // Replaces the plugin's default flags when set
+	Exe      string // Replaces the plugin's executable when set
+
+	// RequireTTY is set when sudo on the host is configured with
+	// requiretty, so commands must run in a pseudo-terminal
+	RequireTTY bool
+}
+
 // StepInfo contains detailed information about a specific step
 type StepInfo struct {
 	ID          string                 `json:"id"`          // Unique step identifier
@@ -457,6 +843,10 @@ type StreamEvent struct {
 	Result    *Result        `json:"result,omitempty"`    // Final result (only for "done" events)
 	Error     error          `json:"error,omitempty"`     // Error (only for "error" events)
 	Timestamp time.Time      `json:"timestamp"`
+
+	// NoLog marks events of a task with no_log set, which
+	// CensorStreamEvent hides before they are logged or broadcast
+	NoLog bool `json:"no_log,omitempty"`
 }
 
 // Connection interface defines methods for connecting to and executing commands on hosts
@@ -630,6 +1020,8 @@ const (
 	EventPlayStart    EventType = "play_start"
 	EventPlayComplete EventType = "play_complete"
 	EventError        EventType = "error"
+	EventWindowWait   EventType = "maintenance_window_wait"
+	EventPlaySkipped  EventType = "play_skipped"
 )
 
 // Event represents an execution event
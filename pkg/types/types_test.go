@@ -1,7 +1,11 @@
 package types
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestModuleType_String(t *testing.T) {
@@ -72,7 +76,7 @@ func TestAllModuleTypes(t *testing.T) {
 	moduleTypes := AllModuleTypes()
 
 	// Check that we have all expected module types
-	expectedCount := 16 // Updated to include homebrew, apt, yum, dnf modules
+	expectedCount := 19 // Updated to include homebrew, apt, yum, dnf, zypper, apk, pacman modules
 	if len(moduleTypes) != expectedCount {
 		t.Errorf("AllModuleTypes() returned %d types, want %d", len(moduleTypes), expectedCount)
 	}
@@ -194,4 +198,296 @@ func TestTask_TypeSafety(t *testing.T) {
 			t.Errorf("Task %d has invalid module type: %s", i, task.Module)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestTask_UnmarshalYAMLLoops(t *testing.T) {
+	var tasks []Task
+	err := yaml.Unmarshal([]byte(`
+- name: Users
+  debug:
+    msg: "{{ item.key }}"
+  with_dict: "{{ users }}"
+- name: Keys
+  debug:
+    msg: "{{ item.1 }}"
+  with_subelements:
+    - users
+    - keys
+- name: Grid
+  debug:
+    msg: "{{ item }}"
+  with_cartesian:
+    - [a, b]
+    - [1, 2]
+- name: Configs
+  copy:
+    src: "{{ item }}"
+  with_fileglob: files/*.conf
+  loop_control:
+    label: "{{ item | basename }}"
+`), &tasks)
+	if err != nil {
+		t.Fatalf("failed to parse tasks: %v", err)
+	}
+
+	if tasks[0].WithDict != "{{ users }}" {
+		t.Errorf("expected with_dict to be parsed, got %v", tasks[0].WithDict)
+	}
+	if terms, ok := tasks[1].WithSubelements.([]interface{}); !ok || len(terms) != 2 {
+		t.Errorf("expected with_subelements terms, got %v", tasks[1].WithSubelements)
+	}
+	if lists, ok := tasks[2].WithNested.([]interface{}); !ok || len(lists) != 2 {
+		t.Errorf("expected with_cartesian as with_nested, got %v", tasks[2].WithNested)
+	}
+	if tasks[3].WithFileglob != "files/*.conf" || tasks[3].Module != "copy" || tasks[3].LoopControl["label"] == nil {
+		t.Errorf("expected with_fileglob and loop_control to be parsed, got %+v", tasks[3])
+	}
+}
+
+func TestTask_UnmarshalYAMLDelegation(t *testing.T) {
+	var tasks []Task
+	err := yaml.Unmarshal([]byte(`
+- name: Drain
+  command: /usr/local/bin/drain
+  delegate_to: "{{ groups['lb'][0] }}"
+  delegate_facts: true
+  run_once: true
+- name: Notify
+  local_action: shell curl -s https://hooks.example.com/deploy
+- name: Pause monitoring
+  local_action:
+    module: uri
+    url: https://monitor.example.com/pause
+    method: POST
+- name: Record
+  local_action: lineinfile path=/tmp/deploys line='web1 done'
+`), &tasks)
+	if err != nil {
+		t.Fatalf("failed to parse tasks: %v", err)
+	}
+
+	if tasks[0].Delegate != "{{ groups['lb'][0] }}" || !tasks[0].DelegateFacts || !tasks[0].RunOnce {
+		t.Errorf("expected delegate_to, delegate_facts and run_once to be parsed, got %+v", tasks[0])
+	}
+	if tasks[1].Module != "shell" || tasks[1].Delegate != "localhost" || tasks[1].Args["cmd"] != "curl -s https://hooks.example.com/deploy" {
+		t.Errorf("expected local_action with a command line, got %+v", tasks[1])
+	}
+	if tasks[2].Module != "uri" || tasks[2].Delegate != "localhost" || tasks[2].Args["method"] != "POST" || tasks[2].Args["module"] != nil {
+		t.Errorf("expected local_action with a module map, got %+v", tasks[2])
+	}
+	if tasks[3].Module != "lineinfile" || tasks[3].Args["path"] != "/tmp/deploys" {
+		t.Errorf("expected local_action with key=value args, got %+v", tasks[3])
+	}
+}
+
+func TestShouldRunTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		only     []string
+		skip     []string
+		expected bool
+	}{
+		{"no selection", []string{"web"}, nil, nil, true},
+		{"untagged without selection", nil, nil, nil, true},
+		{"matching tag", []string{"web", "config"}, []string{"config"}, nil, true},
+		{"other tag", []string{"web"}, []string{"db"}, nil, false},
+		{"untagged with selection", nil, []string{"db"}, nil, false},
+		{"untagged selected", nil, []string{"untagged"}, nil, true},
+		{"tagged selected", []string{"web"}, []string{"tagged"}, nil, true},
+		{"always", []string{"always"}, []string{"db"}, nil, true},
+		{"never", []string{"never", "debug"}, nil, nil, false},
+		{"never asked for", []string{"never", "debug"}, []string{"debug"}, nil, true},
+		{"skipped tag", []string{"web", "slow"}, nil, []string{"slow"}, false},
+		{"skip tagged", []string{"web"}, nil, []string{"tagged"}, false},
+		{"skip all keeps always", []string{"always"}, nil, []string{"all"}, true},
+		{"skip always by name", []string{"always"}, nil, []string{"always"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldRunTags(tt.tags, tt.only, tt.skip); got != tt.expected {
+				t.Errorf("ShouldRunTags(%v, %v, %v) = %v, want %v", tt.tags, tt.only, tt.skip, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCombineMaps(t *testing.T) {
+	base := map[string]interface{}{
+		"app":   map[string]interface{}{"port": 80, "hosts": []interface{}{"a", "b"}},
+		"peers": []interface{}{"a", "b"},
+	}
+	override := map[string]interface{}{
+		"app":   map[string]interface{}{"user": "web", "hosts": []interface{}{"b", "c"}},
+		"peers": []interface{}{"b", "c"},
+	}
+
+	tests := []struct {
+		recursive bool
+		listMerge string
+		app       interface{}
+		peers     interface{}
+	}{
+		{false, "", override["app"], []interface{}{"b", "c"}},
+		{true, "replace", map[string]interface{}{"port": 80, "user": "web", "hosts": []interface{}{"b", "c"}}, []interface{}{"b", "c"}},
+		{true, "keep", map[string]interface{}{"port": 80, "user": "web", "hosts": []interface{}{"a", "b"}}, []interface{}{"a", "b"}},
+		{false, "append", override["app"], []interface{}{"a", "b", "b", "c"}},
+		{false, "prepend", override["app"], []interface{}{"b", "c", "a", "b"}},
+		{true, "append_rp", map[string]interface{}{"port": 80, "user": "web", "hosts": []interface{}{"a", "b", "c"}}, []interface{}{"a", "b", "c"}},
+		{false, "prepend_rp", override["app"], []interface{}{"b", "c", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("recursive=%v list_merge=%s", tt.recursive, tt.listMerge), func(t *testing.T) {
+			result, err := CombineMaps(base, override, tt.recursive, tt.listMerge)
+			if err != nil {
+				t.Fatalf("CombineMaps failed: %v", err)
+			}
+			if !reflect.DeepEqual(result["app"], tt.app) {
+				t.Errorf("expected app %v, got %v", tt.app, result["app"])
+			}
+			if !reflect.DeepEqual(result["peers"], tt.peers) {
+				t.Errorf("expected peers %v, got %v", tt.peers, result["peers"])
+			}
+		})
+	}
+
+	if _, err := CombineMaps(base, override, false, "merge"); err == nil {
+		t.Error("expected an error for an unknown list_merge")
+	}
+	if peers := base["peers"].([]interface{}); len(peers) != 2 || peers[0] != "a" {
+		t.Errorf("expected base to be unchanged, got %v", base)
+	}
+}
+
+func TestCensorResult(t *testing.T) {
+	result := &Result{
+		Host:    "web1",
+		Success: false,
+		Message: "password hunter2 rejected",
+		Error:   fmt.Errorf("hunter2 rejected"),
+		Data:    map[string]interface{}{"failed": true, "stdout": "hunter2", "rc": 1},
+		Diff:    &DiffResult{After: "hunter2"},
+	}
+	if CensorResult(result) != result {
+		t.Error("expected a result without no_log to be unchanged")
+	}
+
+	result.NoLog = true
+	censored := CensorResult(result)
+	if censored.Message != NoLogValue || censored.Error.Error() != NoLogValue || censored.Diff != nil {
+		t.Errorf("expected the message, error and diff to be censored, got %+v", censored)
+	}
+	expected := map[string]interface{}{"failed": true, "stdout": NoLogValue, "rc": NoLogValue}
+	if !reflect.DeepEqual(censored.Data, expected) {
+		t.Errorf("expected data %v, got %v", expected, censored.Data)
+	}
+	if result.Data["stdout"] != "hunter2" {
+		t.Error("expected the original result to be unchanged")
+	}
+}
+
+func TestCensorTaskAndStreamEvent(t *testing.T) {
+	noLog := true
+	task := &Task{Name: "Set password", Args: map[string]interface{}{"password": "hunter2"}, NoLog: &noLog}
+	if censored := CensorTask(task); censored.Args["password"] != NoLogValue || task.Args["password"] != "hunter2" {
+		t.Errorf("expected a censored copy of the task, got %v", censored.Args)
+	}
+
+	event := StreamEvent{Type: StreamStdout, Data: "hunter2"}
+	if CensorStreamEvent(event).Data != "hunter2" {
+		t.Error("expected an event without no_log to be unchanged")
+	}
+	event.NoLog = true
+	if censored := CensorStreamEvent(event); censored.Data != NoLogValue {
+		t.Errorf("expected the output to be censored, got %q", censored.Data)
+	}
+
+	done := StreamEvent{Type: StreamDone, Result: &Result{Message: "hunter2", NoLog: true}}
+	if censored := CensorStreamEvent(done); !censored.NoLog || censored.Result.Message != NoLogValue {
+		t.Errorf("expected the final result to be censored, got %+v", censored.Result)
+	}
+}
+
+func TestTask_UnmarshalYAMLNoLog(t *testing.T) {
+	var tasks []Task
+	if err := yaml.Unmarshal([]byte("- name: Secret\n  command: echo hi\n  no_log: true\n- name: Plain\n  command: echo hi\n"), &tasks); err != nil {
+		t.Fatalf("failed to parse tasks: %v", err)
+	}
+	if !tasks[0].NoLogEnabled() || tasks[1].NoLog != nil {
+		t.Errorf("expected no_log to be parsed, got %v and %v", tasks[0].NoLog, tasks[1].NoLog)
+	}
+}
+
+func TestParseFreeFormArgs(t *testing.T) {
+	tests := []struct {
+		module   ModuleType
+		params   string
+		expected map[string]interface{}
+	}{
+		{"command", "/usr/bin/make_db.sh arg1 creates=/var/db chdir='/srv/my app'", map[string]interface{}{
+			"cmd": "/usr/bin/make_db.sh arg1", "creates": "/var/db", "chdir": "/srv/my app",
+		}},
+		{"shell", `echo "a=b  c" | grep x=y removes=/tmp/x`, map[string]interface{}{
+			"cmd": `echo "a=b  c" | grep x=y`, "removes": "/tmp/x",
+		}},
+		{"script", "scripts/setup.sh --force executable=python3", map[string]interface{}{
+			"cmd": "scripts/setup.sh --force", "executable": "python3",
+		}},
+		{"command", "", map[string]interface{}{}},
+		{"lineinfile", "path=/tmp/deploys line='web1 done'", map[string]interface{}{
+			"path": "/tmp/deploys", "line": "web1 done",
+		}},
+	}
+
+	for _, tt := range tests {
+		if args := ParseFreeFormArgs(tt.module, tt.params); !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("ParseFreeFormArgs(%s, %q) = %v, expected %v", tt.module, tt.params, args, tt.expected)
+		}
+	}
+}
+
+func TestTask_UnmarshalYAMLFreeForm(t *testing.T) {
+	var tasks []Task
+	err := yaml.Unmarshal([]byte(`
+- name: Build
+  command: make all creates=build/app
+  args:
+    chdir: /src
+    creates: ignored
+  check_mode: false
+- name: Add user
+  command:
+    argv: [useradd, deploy]
+- name: Install python
+  raw: apt-get install -y python3 executable=/bin/bash
+- name: Migrate
+  script: scripts/migrate.sh --all creates=/srv/.migrated
+- name: Drain
+  pause: minutes=2
+`), &tasks)
+	if err != nil {
+		t.Fatalf("failed to parse tasks: %v", err)
+	}
+
+	expected := map[string]interface{}{"cmd": "make all", "creates": "build/app", "chdir": "/src"}
+	if !reflect.DeepEqual(tasks[0].Args, expected) {
+		t.Errorf("expected args %v, got %v", expected, tasks[0].Args)
+	}
+	if tasks[0].CheckMode == nil || *tasks[0].CheckMode {
+		t.Errorf("expected check_mode false to be parsed, got %v", tasks[0].CheckMode)
+	}
+	if !reflect.DeepEqual(tasks[1].Args["argv"], []interface{}{"useradd", "deploy"}) {
+		t.Errorf("expected the argv list, got %v", tasks[1].Args)
+	}
+	if tasks[2].Module != TypeRaw || tasks[2].Args["cmd"] != "apt-get install -y python3" || tasks[2].Args["executable"] != "/bin/bash" {
+		t.Errorf("expected a free-form raw task, got %s %v", tasks[2].Module, tasks[2].Args)
+	}
+	if tasks[3].Module != "script" || tasks[3].Args["cmd"] != "scripts/migrate.sh --all" || tasks[3].Args["creates"] != "/srv/.migrated" {
+		t.Errorf("expected a free-form script task, got %s %v", tasks[3].Module, tasks[3].Args)
+	}
+	if tasks[4].Module != TypePause || tasks[4].Args["minutes"] != "2" {
+		t.Errorf("expected a pause task, got %s %v", tasks[4].Module, tasks[4].Args)
+	}
+}
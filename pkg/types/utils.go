@@ -210,6 +210,87 @@ func DeepMergeInterfaceMaps(base, override map[string]interface{}) map[string]in
 	return result
 }
 
+// CombineMaps merges override into base like Ansible's combine filter.
+// With recursive, maps present in both are combined key by key instead of
+// the override replacing them. listMerge decides how lists present in both
+// are combined: replace (the default), keep, append, prepend, append_rp or
+// prepend_rp, where the _rp variants first drop the base elements the
+// override also holds.
+func CombineMaps(base, override map[string]interface{}, recursive bool, listMerge string) (map[string]interface{}, error) {
+	switch listMerge {
+	case "", "replace", "keep", "append", "prepend", "append_rp", "prepend_rp":
+	default:
+		return nil, fmt.Errorf("invalid list_merge %q", listMerge)
+	}
+
+	result := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range override {
+		existing, exists := result[k]
+		if !exists {
+			result[k] = v
+			continue
+		}
+
+		if recursive {
+			existingMap, ok1 := existing.(map[string]interface{})
+			overrideMap, ok2 := v.(map[string]interface{})
+			if ok1 && ok2 {
+				merged, err := CombineMaps(existingMap, overrideMap, recursive, listMerge)
+				if err != nil {
+					return nil, err
+				}
+				result[k] = merged
+				continue
+			}
+		}
+
+		existingList, ok1 := existing.([]interface{})
+		overrideList, ok2 := v.([]interface{})
+		if ok1 && ok2 {
+			result[k] = combineLists(existingList, overrideList, listMerge)
+			continue
+		}
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+// combineLists combines two lists as CombineMaps' listMerge says
+func combineLists(base, override []interface{}, listMerge string) []interface{} {
+	if strings.HasSuffix(listMerge, "_rp") {
+		kept := make([]interface{}, 0, len(base))
+		for _, item := range base {
+			duplicate := false
+			for _, o := range override {
+				if reflect.DeepEqual(item, o) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				kept = append(kept, item)
+			}
+		}
+		base = kept
+	}
+
+	switch listMerge {
+	case "keep":
+		return base
+	case "append", "append_rp":
+		return append(append([]interface{}{}, base...), override...)
+	case "prepend", "prepend_rp":
+		return append(append([]interface{}{}, override...), base...)
+	default:
+		return override
+	}
+}
+
 // ExpandVariables expands variables in a string using Jinja2-style {{VAR}} syntax
 func ExpandVariables(text string, vars map[string]interface{}) string {
 	// Regular expression to match {{VAR}} patterns (Jinja2-style)
@@ -307,6 +388,54 @@ func UniqueStrings(slice []string) []string {
 	return result
 }
 
+// ShouldRunTags reports whether a task with tags is selected by Ansible's
+// --tags (only) and --skip-tags (skip). With no only tags every task runs
+// except those tagged never. The special tags always, never, all, tagged
+// and untagged work as in Ansible: always runs unless skipped by name, and
+// never only runs when one of the task's tags is asked for.
+func ShouldRunTags(tags, only, skip []string) bool {
+	if len(only) == 0 {
+		only = []string{"all"}
+	}
+	if len(tags) == 0 {
+		tags = []string{"untagged"}
+	}
+	tagged := !(len(tags) == 1 && tags[0] == "untagged")
+
+	shouldRun := false
+	switch {
+	case StringSliceContains(tags, "always"):
+		shouldRun = true
+	case StringSliceContains(only, "all") && !StringSliceContains(tags, "never"):
+		shouldRun = true
+	case StringSliceContains(only, "tagged") && tagged:
+		shouldRun = true
+	default:
+		for _, tag := range tags {
+			if StringSliceContains(only, tag) {
+				shouldRun = true
+				break
+			}
+		}
+	}
+	if !shouldRun || len(skip) == 0 {
+		return shouldRun
+	}
+
+	if StringSliceContains(skip, "all") {
+		return StringSliceContains(tags, "always") && !StringSliceContains(skip, "always")
+	}
+	if StringSliceContains(skip, "tagged") && tagged {
+		return false
+	}
+	for _, tag := range tags {
+		if StringSliceContains(skip, tag) {
+			return false
+		}
+	}
+	return true
+}
+
 // GetCurrentTime returns the current time
 func GetCurrentTime() time.Time {
 	return time.Now()
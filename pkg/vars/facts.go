@@ -0,0 +1,464 @@
+package vars
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// FactSubsets lists the gather_subset values that add to the min facts.
+// The min facts (platform, distribution, package manager, user and
+// environment) are always gathered.
+var FactSubsets = []string{"hardware", "network", "virtual", "selinux", "apparmor"}
+
+// FactCollector gathers ansible_facts from a host. The commands for a
+// platform are batched into one script whose output is split into sections,
+// so a gather costs two round trips: one to detect the platform and one to
+// collect.
+type FactCollector struct {
+	subsets map[string]bool
+	timeout time.Duration
+}
+
+// NewFactCollector creates a collector for the given gather_subset values.
+// all selects every subset, a ! prefix excludes one and min, minimal and env
+// select nothing beyond the min facts. A zero timeout disables the limit.
+func NewFactCollector(subsets []string, timeout time.Duration) (*FactCollector, error) {
+	selected := make(map[string]bool)
+	var excluded []string
+	for _, subset := range subsets {
+		name, exclude := strings.CutPrefix(strings.TrimSpace(subset), "!")
+		switch {
+		case name == "all" && exclude:
+			excluded = append(excluded, FactSubsets...)
+		case name == "all":
+			for _, s := range FactSubsets {
+				selected[s] = true
+			}
+		case name == "min" || name == "minimal" || name == "env":
+		case types.StringSliceContains(FactSubsets, name):
+			if exclude {
+				excluded = append(excluded, name)
+			} else {
+				selected[name] = true
+			}
+		default:
+			return nil, fmt.Errorf("unknown gather_subset %q, expected one of all, min, %s", subset, strings.Join(FactSubsets, ", "))
+		}
+	}
+	for _, name := range excluded {
+		delete(selected, name)
+	}
+
+	return &FactCollector{subsets: selected, timeout: timeout}, nil
+}
+
+// Collect gathers the facts of the host behind conn. Hosts without uname are
+// treated as Windows and queried through PowerShell.
+func (c *FactCollector) Collect(ctx context.Context, conn types.Connection) (map[string]interface{}, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	system := ""
+	if result, err := conn.Execute(ctx, "uname -s", types.ExecuteOptions{}); err == nil && result.Success {
+		system = strings.TrimSpace(factOutput(result))
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to detect the platform: %w", ctx.Err())
+	}
+	if system == "" {
+		return c.collectWindows(ctx, conn)
+	}
+
+	sections := linuxFactSections
+	if system == "Darwin" {
+		sections = darwinFactSections
+	}
+	result, err := conn.Execute(ctx, c.script(sections), types.ExecuteOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather facts: %w", err)
+	}
+	output := splitFactSections(factOutput(result))
+
+	facts := platformFacts(output["platform"])
+	if system == "Darwin" {
+		mergeFacts(facts, darwinDistributionFacts(output["sw_vers"], facts))
+	} else {
+		mergeFacts(facts, distributionFacts(system, output["os_release"]))
+	}
+	facts["ansible_pkg_mgr"] = pkgMgr(types.ConvertToString(facts["ansible_os_family"]), output["pkg_mgr"])
+	mergeFacts(facts, userFacts(output["user"], output["environ"]))
+
+	if system == "Darwin" {
+		if c.subsets["hardware"] {
+			mergeFacts(facts, darwinHardwareFacts(output["sysctl"], output["vm_stat"]))
+			facts["ansible_mounts"] = darwinMounts(output["df"], output["mount"])
+		}
+		if c.subsets["network"] {
+			mergeFacts(facts, darwinNetworkFacts(output["ifconfig"], output["route"]))
+		}
+		if c.subsets["virtual"] {
+			mergeFacts(facts, darwinVirtualFacts(output["sysctl"]))
+		}
+		return facts, nil
+	}
+
+	if c.subsets["hardware"] {
+		mergeFacts(facts, memoryFacts(output["meminfo"]))
+		mergeFacts(facts, cpuFacts(output["cpuinfo"], output["nproc"]))
+		mergeFacts(facts, dmiFacts(output["dmi"]))
+		facts["ansible_mounts"] = linuxMounts(output["df"], output["mounts"])
+	}
+	if c.subsets["network"] {
+		mergeFacts(facts, linuxNetworkFacts(output["link"], output["addr"], output["route4"], output["route6"]))
+	}
+	if c.subsets["virtual"] {
+		mergeFacts(facts, linuxVirtualFacts(output))
+	}
+	if c.subsets["selinux"] {
+		mergeFacts(facts, selinuxFacts(output["selinux"]))
+	}
+	if c.subsets["apparmor"] {
+		mergeFacts(facts, apparmorFacts(output["apparmor"]))
+	}
+	return facts, nil
+}
+
+// factSection is one command of a platform's fact script. Sections without
+// subsets belong to the min facts.
+type factSection struct {
+	name    string
+	subsets []string
+	command string
+}
+
+// commonFactSections are gathered the same way on every POSIX platform
+var commonFactSections = []factSection{
+	{name: "platform", command: "uname -s; uname -r; uname -v; uname -m; uname -n; hostname -f || uname -n"},
+	{name: "pkg_mgr", command: "for p in " + strings.Join(pkgMgrBinaries(), " ") + "; do command -v $p; done"},
+	{name: "user", command: `id -un; id -u; id -g; echo "$HOME"; echo "$SHELL"`},
+	{name: "environ", command: "env"},
+}
+
+var linuxFactSections = append(append([]factSection{}, commonFactSections...), []factSection{
+	{name: "os_release", command: "cat /etc/os-release || cat /usr/lib/os-release"},
+	{name: "meminfo", subsets: []string{"hardware"}, command: "cat /proc/meminfo"},
+	{name: "cpuinfo", subsets: []string{"hardware", "virtual"}, command: "cat /proc/cpuinfo"},
+	{name: "nproc", subsets: []string{"hardware"}, command: "nproc"},
+	{name: "df", subsets: []string{"hardware"}, command: "df -P -k"},
+	{name: "mounts", subsets: []string{"hardware"}, command: "cat /proc/mounts"},
+	{name: "dmi", subsets: []string{"hardware", "virtual"}, command: `for f in product_name sys_vendor product_version; do echo "$f=$(cat /sys/class/dmi/id/$f)"; done`},
+	{name: "link", subsets: []string{"network"}, command: "ip -o link show"},
+	{name: "addr", subsets: []string{"network"}, command: "ip -o addr show"},
+	{name: "route4", subsets: []string{"network"}, command: "ip -4 route get 1.1.1.1"},
+	{name: "route6", subsets: []string{"network"}, command: "ip -6 route get 2606:4700:4700::1111"},
+	{name: "detect_virt", subsets: []string{"virtual"}, command: "systemd-detect-virt"},
+	{name: "container", subsets: []string{"virtual"}, command: "test -f /.dockerenv && echo docker; test -f /run/.containerenv && echo podman; cat /proc/1/cgroup"},
+	{name: "kvm_host", subsets: []string{"virtual"}, command: "grep '^kvm ' /proc/modules"},
+	{name: "selinux", subsets: []string{"selinux"}, command: `echo "mode=$(getenforce)"; echo "policyvers=$(cat /sys/fs/selinux/policyvers)"; grep -E '^SELINUX(TYPE)?=' /etc/selinux/config`},
+	{name: "apparmor", subsets: []string{"apparmor"}, command: "cat /sys/module/apparmor/parameters/enabled && cat /sys/kernel/security/apparmor/profiles"},
+}...)
+
+var darwinFactSections = append(append([]factSection{}, commonFactSections...), []factSection{
+	{name: "sw_vers", command: "sw_vers"},
+	{name: "sysctl", subsets: []string{"hardware", "virtual"}, command: "sysctl hw.model hw.memsize hw.ncpu hw.packages hw.physicalcpu hw.logicalcpu machdep.cpu.brand_string vm.swapusage kern.hv_vmm_present"},
+	{name: "vm_stat", subsets: []string{"hardware"}, command: "vm_stat"},
+	{name: "df", subsets: []string{"hardware"}, command: "df -P -k"},
+	{name: "mount", subsets: []string{"hardware"}, command: "mount"},
+	{name: "ifconfig", subsets: []string{"network"}, command: "ifconfig -a"},
+	{name: "route", subsets: []string{"network"}, command: "route -n get default"},
+}...)
+
+// factSectionMarker starts each section in the output of a fact script
+const factSectionMarker = "@@gosible-facts "
+
+// script joins the sections needed for the selected subsets into one shell
+// script. Errors are discarded; a missing tool leaves its section empty.
+func (c *FactCollector) script(sections []factSection) string {
+	var b strings.Builder
+	for _, section := range sections {
+		wanted := len(section.subsets) == 0
+		for _, subset := range section.subsets {
+			wanted = wanted || c.subsets[subset]
+		}
+		if wanted {
+			fmt.Fprintf(&b, "echo '%s%s'; { %s; } 2>/dev/null; ", factSectionMarker, section.name, section.command)
+		}
+	}
+	b.WriteString("true")
+	return b.String()
+}
+
+// splitFactSections splits the output of a fact script by section name
+func splitFactSections(output string) map[string]string {
+	sections := make(map[string]string)
+	name := ""
+	var body []string
+	flush := func() {
+		if name != "" {
+			sections[name] = strings.Join(body, "\n")
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if next, ok := strings.CutPrefix(line, factSectionMarker); ok {
+			flush()
+			name, body = strings.TrimSpace(next), nil
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	return sections
+}
+
+// factOutput returns the stdout of a command result
+func factOutput(result *types.Result) string {
+	stdout, _ := result.Data["stdout"].(string)
+	return stdout
+}
+
+// platformFacts parses the uname and hostname lines of the platform section
+func platformFacts(output string) map[string]interface{} {
+	lines := strings.Split(output, "\n")
+	line := func(i int) string {
+		if i < len(lines) {
+			return strings.TrimSpace(lines[i])
+		}
+		return ""
+	}
+
+	nodename := line(4)
+	fqdn := line(5)
+	if fqdn == "" {
+		fqdn = nodename
+	}
+	hostname, _, _ := strings.Cut(nodename, ".")
+	_, domain, _ := strings.Cut(fqdn, ".")
+
+	return map[string]interface{}{
+		"ansible_system":         line(0),
+		"ansible_kernel":         line(1),
+		"ansible_kernel_version": line(2),
+		"ansible_machine":        line(3),
+		"ansible_architecture":   line(3),
+		"ansible_nodename":       nodename,
+		"ansible_hostname":       hostname,
+		"ansible_fqdn":           fqdn,
+		"ansible_domain":         domain,
+	}
+}
+
+// distributionNames maps os-release IDs to the distribution names Ansible
+// reports
+var distributionNames = map[string]string{
+	"ubuntu": "Ubuntu", "debian": "Debian", "raspbian": "Debian", "linuxmint": "Linux Mint",
+	"rhel": "RedHat", "centos": "CentOS", "fedora": "Fedora", "rocky": "Rocky", "almalinux": "AlmaLinux",
+	"ol": "OracleLinux", "amzn": "Amazon",
+	"opensuse-leap": "openSUSE Leap", "opensuse-tumbleweed": "openSUSE Tumbleweed", "sles": "SLES",
+	"arch": "Archlinux", "alpine": "Alpine", "gentoo": "Gentoo",
+}
+
+// OSFamily maps an os-release ID, falling back to ID_LIKE, to the OS family
+// names Ansible uses
+func OSFamily(system, id, idLike string) string {
+	families := map[string]string{
+		"debian": "Debian", "ubuntu": "Debian",
+		"rhel": "RedHat", "centos": "RedHat", "fedora": "RedHat", "rocky": "RedHat", "almalinux": "RedHat", "ol": "RedHat", "amzn": "RedHat",
+		"suse": "Suse", "opensuse": "Suse", "sles": "Suse",
+		"arch": "Archlinux", "alpine": "Alpine", "gentoo": "Gentoo",
+	}
+	for _, candidate := range append([]string{id}, strings.Fields(idLike)...) {
+		if family, ok := families[candidate]; ok {
+			return family
+		}
+	}
+	if system == "Darwin" {
+		return "Darwin"
+	}
+	return system
+}
+
+// distributionFacts derives the distribution facts from os-release
+func distributionFacts(system, osRelease string) map[string]interface{} {
+	release := parseKeyValues(osRelease, "=")
+	for key, value := range release {
+		release[key] = strings.Trim(value, `"'`)
+	}
+
+	name, ok := distributionNames[release["ID"]]
+	if !ok {
+		name = release["NAME"]
+	}
+	if name == "" {
+		name = system
+	}
+
+	codename := release["VERSION_CODENAME"]
+	if codename == "" {
+		codename = release["UBUNTU_CODENAME"]
+	}
+	if codename == "" {
+		if _, rest, ok := strings.Cut(release["VERSION"], "("); ok {
+			codename = strings.TrimSuffix(rest, ")")
+		}
+	}
+
+	version := release["VERSION_ID"]
+	major, _, _ := strings.Cut(version, ".")
+
+	return map[string]interface{}{
+		"ansible_distribution":               name,
+		"ansible_distribution_version":       naIfEmpty(version),
+		"ansible_distribution_major_version": naIfEmpty(major),
+		"ansible_distribution_release":       naIfEmpty(codename),
+		"ansible_os_family":                  OSFamily(system, release["ID"], release["ID_LIKE"]),
+	}
+}
+
+// darwinDistributionFacts derives the distribution facts from sw_vers. Like
+// Ansible, the release is the Darwin kernel version.
+func darwinDistributionFacts(swVers string, platform map[string]interface{}) map[string]interface{} {
+	values := parseKeyValues(swVers, ":")
+	version := values["ProductVersion"]
+	major, _, _ := strings.Cut(version, ".")
+
+	return map[string]interface{}{
+		"ansible_distribution":               "MacOSX",
+		"ansible_distribution_version":       naIfEmpty(version),
+		"ansible_distribution_major_version": naIfEmpty(major),
+		"ansible_distribution_release":       platform["ansible_kernel"],
+		"ansible_distribution_build":         values["BuildVersion"],
+		"ansible_os_family":                  "Darwin",
+	}
+}
+
+// pkgManagers maps package manager binaries to the names Ansible uses for
+// ansible_pkg_mgr, in order of preference
+var pkgManagers = []struct{ binary, name string }{
+	{"dnf5", "dnf5"}, {"dnf", "dnf"}, {"yum", "yum"}, {"apt-get", "apt"}, {"zypper", "zypper"},
+	{"pacman", "pacman"}, {"apk", "apk"}, {"emerge", "portage"}, {"pkg", "pkgng"},
+	{"brew", "homebrew"}, {"port", "macports"}, {"choco", "chocolatey"}, {"winget", "winget"},
+}
+
+// familyPkgManagers restricts the package managers considered for an OS
+// family, so that a stray apt-get on a RedHat host is not picked
+var familyPkgManagers = map[string][]string{
+	"RedHat":    {"dnf5", "dnf", "yum"},
+	"Debian":    {"apt"},
+	"Suse":      {"zypper"},
+	"Archlinux": {"pacman"},
+	"Alpine":    {"apk"},
+	"Gentoo":    {"portage"},
+	"FreeBSD":   {"pkgng"},
+	"Darwin":    {"homebrew", "macports"},
+}
+
+func pkgMgrBinaries() []string {
+	binaries := make([]string, len(pkgManagers))
+	for i, manager := range pkgManagers {
+		binaries[i] = manager.binary
+	}
+	return binaries
+}
+
+// pkgMgr picks the package manager from the binaries found on the host,
+// one path per line
+func pkgMgr(family, found string) string {
+	present := make(map[string]bool)
+	for _, line := range strings.Split(found, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			present[path.Base(line)] = true
+		}
+	}
+
+	var candidates []string
+	for _, manager := range pkgManagers {
+		if present[manager.binary] {
+			candidates = append(candidates, manager.name)
+		}
+	}
+	if preferred, ok := familyPkgManagers[family]; ok {
+		for _, candidate := range candidates {
+			if types.StringSliceContains(preferred, candidate) {
+				return candidate
+			}
+		}
+		return "unknown"
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return "unknown"
+}
+
+// userFacts parses the id lines of the user section and the output of env
+func userFacts(user, environ string) map[string]interface{} {
+	lines := strings.Split(user, "\n")
+	for len(lines) < 5 {
+		lines = append(lines, "")
+	}
+
+	facts := map[string]interface{}{
+		"ansible_user_id":    strings.TrimSpace(lines[0]),
+		"ansible_user_dir":   strings.TrimSpace(lines[3]),
+		"ansible_user_shell": strings.TrimSpace(lines[4]),
+	}
+	if uid, err := types.ConvertToInt(strings.TrimSpace(lines[1])); err == nil {
+		facts["ansible_user_uid"] = uid
+	}
+	if gid, err := types.ConvertToInt(strings.TrimSpace(lines[2])); err == nil {
+		facts["ansible_user_gid"] = gid
+	}
+
+	env := make(map[string]interface{})
+	for _, line := range strings.Split(environ, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok && key != "" && !strings.ContainsAny(key, " \t") {
+			env[key] = value
+		}
+	}
+	facts["ansible_env"] = env
+	return facts
+}
+
+// parseKeyValues parses "key<sep>value" lines, trimming both sides
+func parseKeyValues(content, sep string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		if key, value, ok := strings.Cut(line, sep); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func naIfEmpty(value string) string {
+	if value == "" {
+		return "NA"
+	}
+	return value
+}
+
+func mergeFacts(dest, src map[string]interface{}) {
+	for k, v := range src {
+		dest[k] = v
+	}
+}
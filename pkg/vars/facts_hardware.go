@@ -0,0 +1,336 @@
+package vars
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// memoryFacts parses /proc/meminfo into the memtotal/memfree/swap facts and
+// the ansible_memory_mb breakdown, all in MB
+func memoryFacts(meminfo string) map[string]interface{} {
+	kb := make(map[string]int)
+	for key, value := range parseKeyValues(meminfo, ":") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, " kB")); err == nil {
+			kb[key] = n
+		}
+	}
+	if _, ok := kb["MemTotal"]; !ok {
+		return nil
+	}
+
+	mb := func(key string) int { return kb[key] / 1024 }
+	nocacheFree := mb("MemFree") + mb("Buffers") + mb("Cached")
+
+	return map[string]interface{}{
+		"ansible_memtotal_mb":  mb("MemTotal"),
+		"ansible_memfree_mb":   mb("MemFree"),
+		"ansible_swaptotal_mb": mb("SwapTotal"),
+		"ansible_swapfree_mb":  mb("SwapFree"),
+		"ansible_memory_mb": map[string]interface{}{
+			"real":    map[string]interface{}{"total": mb("MemTotal"), "free": mb("MemFree"), "used": mb("MemTotal") - mb("MemFree")},
+			"nocache": map[string]interface{}{"free": nocacheFree, "used": mb("MemTotal") - nocacheFree},
+			"swap":    map[string]interface{}{"total": mb("SwapTotal"), "free": mb("SwapFree"), "used": mb("SwapTotal") - mb("SwapFree"), "cached": mb("SwapCached")},
+		},
+	}
+}
+
+// cpuFacts parses /proc/cpuinfo. ansible_processor lists the index, vendor
+// and model of each logical CPU, as Ansible does. Without physical ids, as on
+// most ARM hosts, every logical CPU counts as a single core socket.
+func cpuFacts(cpuinfo, nproc string) map[string]interface{} {
+	var processors []interface{}
+	sockets := make(map[string]bool)
+	vcpus, cores := 0, 0
+	for _, block := range strings.Split(cpuinfo, "\n\n") {
+		entry := parseKeyValues(block, ":")
+		index, ok := entry["processor"]
+		if !ok {
+			continue
+		}
+		vcpus++
+		processors = append(processors, index)
+		for _, key := range []string{"vendor_id", "model name"} {
+			if entry[key] != "" {
+				processors = append(processors, entry[key])
+			}
+		}
+		if id, ok := entry["physical id"]; ok {
+			sockets[id] = true
+		}
+		if n, err := strconv.Atoi(entry["cpu cores"]); err == nil {
+			cores = n
+		}
+	}
+	if vcpus == 0 {
+		return nil
+	}
+
+	count, threadsPerCore := vcpus, 1
+	if len(sockets) > 0 && cores > 0 {
+		count = len(sockets)
+		threadsPerCore = max(vcpus/(count*cores), 1)
+	} else {
+		cores = 1
+	}
+	nprocs, err := strconv.Atoi(strings.TrimSpace(nproc))
+	if err != nil {
+		nprocs = vcpus
+	}
+
+	return map[string]interface{}{
+		"ansible_processor":                  processors,
+		"ansible_processor_count":            count,
+		"ansible_processor_cores":            cores,
+		"ansible_processor_threads_per_core": threadsPerCore,
+		"ansible_processor_vcpus":            vcpus,
+		"ansible_processor_nproc":            nprocs,
+	}
+}
+
+// dmiFacts parses the product and vendor read from /sys/class/dmi/id
+func dmiFacts(dmi string) map[string]interface{} {
+	values := parseKeyValues(dmi, "=")
+	return map[string]interface{}{
+		"ansible_product_name":    naIfEmpty(values["product_name"]),
+		"ansible_system_vendor":   naIfEmpty(values["sys_vendor"]),
+		"ansible_product_version": naIfEmpty(values["product_version"]),
+	}
+}
+
+// dfSizes parses the output of df -P -k into the total and available bytes
+// of each mount point
+func dfSizes(df string) map[string][2]int64 {
+	sizes := make(map[string][2]int64)
+	lines := strings.Split(df, "\n")
+	for _, line := range lines[min(1, len(lines)):] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		total, errTotal := strconv.ParseInt(fields[1], 10, 64)
+		available, errAvailable := strconv.ParseInt(fields[3], 10, 64)
+		if errTotal == nil && errAvailable == nil {
+			sizes[strings.Join(fields[5:], " ")] = [2]int64{total * 1024, available * 1024}
+		}
+	}
+	return sizes
+}
+
+// mountFact builds an ansible_mounts entry
+func mountFact(device, mount, fstype, options string, sizes map[string][2]int64) map[string]interface{} {
+	fact := map[string]interface{}{
+		"device":  device,
+		"mount":   mount,
+		"fstype":  fstype,
+		"options": options,
+	}
+	if size, ok := sizes[mount]; ok {
+		fact["size_total"] = size[0]
+		fact["size_available"] = size[1]
+	}
+	return fact
+}
+
+// linuxMounts parses /proc/mounts, keeping block device and network mounts
+// like Ansible does, with sizes from df
+func linuxMounts(df, procMounts string) []interface{} {
+	sizes := dfSizes(df)
+	unescape := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+	mounts := []interface{}{}
+	seen := make(map[string]int)
+	for _, line := range strings.Split(procMounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		device, mount := unescape.Replace(fields[0]), unescape.Replace(fields[1])
+		if !strings.HasPrefix(device, "/") && !strings.Contains(device, ":/") {
+			continue
+		}
+		fact := mountFact(device, mount, fields[2], fields[3], sizes)
+		// Later entries shadow earlier mounts on the same point
+		if i, ok := seen[mount]; ok {
+			mounts[i] = fact
+			continue
+		}
+		seen[mount] = len(mounts)
+		mounts = append(mounts, fact)
+	}
+	return mounts
+}
+
+// darwinMounts parses the output of mount, lines like
+// "/dev/disk3s1s1 on / (apfs, sealed, local, read-only, journaled)"
+func darwinMounts(df, mountOutput string) []interface{} {
+	sizes := dfSizes(df)
+
+	mounts := []interface{}{}
+	for _, line := range strings.Split(mountOutput, "\n") {
+		device, rest, ok := strings.Cut(line, " on ")
+		open := strings.LastIndex(rest, " (")
+		if !ok || open < 0 || !strings.HasPrefix(device, "/") {
+			continue
+		}
+		fstype, options, _ := strings.Cut(strings.TrimSuffix(rest[open+2:], ")"), ", ")
+		options = strings.ReplaceAll(options, ", ", ",")
+		mounts = append(mounts, mountFact(device, rest[:open], fstype, options, sizes))
+	}
+	return mounts
+}
+
+// darwinHardwareFacts parses sysctl and vm_stat output
+func darwinHardwareFacts(sysctl, vmStat string) map[string]interface{} {
+	values := parseKeyValues(sysctl, ":")
+	number := func(key string) int {
+		n, _ := strconv.Atoi(values[key])
+		return n
+	}
+
+	facts := map[string]interface{}{
+		"ansible_product_name":  naIfEmpty(values["hw.model"]),
+		"ansible_system_vendor": "Apple Inc.",
+	}
+
+	if memsize, err := strconv.ParseInt(values["hw.memsize"], 10, 64); err == nil {
+		facts["ansible_memtotal_mb"] = int(memsize / 1024 / 1024)
+	}
+
+	// vm_stat counts pages; the page size is in its header line
+	pageSize := 4096
+	pages := make(map[string]int)
+	for _, line := range strings.Split(vmStat, "\n") {
+		if _, rest, ok := strings.Cut(line, "page size of "); ok {
+			if n, err := strconv.Atoi(strings.Fields(rest)[0]); err == nil {
+				pageSize = n
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), ".")); err == nil {
+				pages[strings.TrimSpace(key)] = n
+			}
+		}
+	}
+	if _, ok := pages["Pages free"]; ok {
+		free := (pages["Pages free"] + pages["Pages inactive"] + pages["Pages speculative"]) * pageSize
+		facts["ansible_memfree_mb"] = free / 1024 / 1024
+	}
+
+	// vm.swapusage: total = 2048.00M  used = 1024.25M  free = 1023.75M  (encrypted)
+	swap := strings.Fields(values["vm.swapusage"])
+	for i := 0; i+2 < len(swap); i++ {
+		if swap[i+1] != "=" {
+			continue
+		}
+		if megabytes, err := strconv.ParseFloat(strings.TrimSuffix(swap[i+2], "M"), 64); err == nil {
+			switch swap[i] {
+			case "total":
+				facts["ansible_swaptotal_mb"] = int(megabytes)
+			case "free":
+				facts["ansible_swapfree_mb"] = int(megabytes)
+			}
+		}
+	}
+
+	if vcpus := number("hw.logicalcpu"); vcpus > 0 {
+		count := max(number("hw.packages"), 1)
+		cores := max(number("hw.physicalcpu"), 1)
+		facts["ansible_processor"] = []interface{}{values["machdep.cpu.brand_string"]}
+		facts["ansible_processor_count"] = count
+		facts["ansible_processor_cores"] = max(cores/count, 1)
+		facts["ansible_processor_threads_per_core"] = max(vcpus/cores, 1)
+		facts["ansible_processor_vcpus"] = vcpus
+		facts["ansible_processor_nproc"] = max(number("hw.ncpu"), vcpus)
+	}
+	return facts
+}
+
+// detectVirtTypes maps systemd-detect-virt output to the virtualization
+// types Ansible reports
+var detectVirtTypes = map[string]string{
+	"kvm": "kvm", "qemu": "kvm", "bochs": "kvm", "vmware": "VMware", "oracle": "virtualbox",
+	"microsoft": "VirtualPC", "xen": "xen", "amazon": "kvm", "parallels": "parallels", "bhyve": "bhyve",
+	"docker": "docker", "podman": "podman", "lxc": "lxc", "lxc-libvirt": "lxc", "systemd-nspawn": "systemd-nspawn",
+	"openvz": "openvz", "wsl": "wsl", "rkt": "rkt",
+}
+
+// dmiVirtTypes maps DMI product names and vendors to virtualization types
+var dmiVirtTypes = []struct{ match, virtType string }{
+	{"KVM", "kvm"}, {"QEMU", "kvm"}, {"Bochs", "kvm"}, {"VMware", "VMware"}, {"VirtualBox", "virtualbox"},
+	{"innotek", "virtualbox"}, {"HVM domU", "xen"}, {"Xen", "xen"}, {"Amazon EC2", "kvm"},
+	{"Parallels", "parallels"}, {"Google Compute Engine", "kvm"}, {"OpenStack", "openstack"},
+}
+
+// linuxVirtualFacts detects the virtualization type and role from container
+// markers, systemd-detect-virt, DMI data, the hypervisor cpu flag and the
+// kvm module, in that order
+func linuxVirtualFacts(output map[string]string) map[string]interface{} {
+	virtual := func(virtType, role string) map[string]interface{} {
+		return map[string]interface{}{
+			"ansible_virtualization_type": virtType,
+			"ansible_virtualization_role": role,
+		}
+	}
+
+	markers := output["container"]
+	for _, runtime := range []string{"docker", "podman", "lxc", "kubepods"} {
+		for _, line := range strings.Split(markers, "\n") {
+			if line == runtime || strings.Contains(line, "/"+runtime) {
+				if runtime == "kubepods" {
+					runtime = "container"
+				}
+				return virtual(runtime, "guest")
+			}
+		}
+	}
+
+	if virtType, ok := detectVirtTypes[strings.TrimSpace(output["detect_virt"])]; ok {
+		return virtual(virtType, "guest")
+	}
+
+	dmi := parseKeyValues(output["dmi"], "=")
+	if dmi["sys_vendor"] == "Microsoft Corporation" && dmi["product_name"] == "Virtual Machine" {
+		return virtual("VirtualPC", "guest")
+	}
+	for _, candidate := range dmiVirtTypes {
+		if strings.Contains(dmi["product_name"], candidate.match) || strings.Contains(dmi["sys_vendor"], candidate.match) {
+			return virtual(candidate.virtType, "guest")
+		}
+	}
+
+	for _, line := range strings.Split(output["cpuinfo"], "\n") {
+		if key, flags, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "flags" {
+			if types.StringSliceContains(strings.Fields(flags), "hypervisor") {
+				return virtual("NA", "guest")
+			}
+			break
+		}
+	}
+
+	if strings.HasPrefix(output["kvm_host"], "kvm ") {
+		return virtual("kvm", "host")
+	}
+	return virtual("NA", "NA")
+}
+
+// darwinVirtualFacts detects macOS guests from the hypervisor flag the kernel
+// exposes and the hardware model
+func darwinVirtualFacts(sysctl string) map[string]interface{} {
+	values := parseKeyValues(sysctl, ":")
+	virtType, role := "NA", "NA"
+	if values["kern.hv_vmm_present"] == "1" {
+		role = "guest"
+	}
+	for _, candidate := range []struct{ match, virtType string }{{"VMware", "VMware"}, {"Parallels", "parallels"}, {"VirtualBox", "virtualbox"}, {"VirtualMac", "apple"}} {
+		if strings.Contains(values["hw.model"], candidate.match) {
+			virtType, role = candidate.virtType, "guest"
+		}
+	}
+	return map[string]interface{}{
+		"ansible_virtualization_type": virtType,
+		"ansible_virtualization_role": role,
+	}
+}
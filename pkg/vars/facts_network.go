@@ -0,0 +1,288 @@
+package vars
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// ipv4Fact builds the ipv4 entry of an interface, deriving the netmask,
+// network and, when the host did not report one, the broadcast address
+func ipv4Fact(address string, bits int, broadcast string) map[string]interface{} {
+	fact := map[string]interface{}{"address": address, "prefix": strconv.Itoa(bits)}
+	addr, err := netip.ParseAddr(address)
+	if err != nil || !addr.Is4() || bits < 0 || bits > 32 {
+		return fact
+	}
+
+	mask := uint32(0)
+	if bits > 0 {
+		mask = ^uint32(0) << (32 - bits)
+	}
+	ip := addr.As4()
+	value := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	toAddr := func(v uint32) string {
+		return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}).String()
+	}
+
+	fact["netmask"] = toAddr(mask)
+	fact["network"] = toAddr(value & mask)
+	if broadcast == "" && bits < 31 {
+		broadcast = toAddr(value | ^mask)
+	}
+	if broadcast != "" {
+		fact["broadcast"] = broadcast
+	}
+	return fact
+}
+
+// interfaceFactName is the fact holding an interface, with the characters
+// Ansible replaces
+func interfaceFactName(device string) string {
+	return "ansible_" + strings.NewReplacer("-", "_", ":", "_").Replace(device)
+}
+
+// networkFacts assembles the per-interface facts, the address lists and the
+// default routes. default4 and default6 hold the gateway, interface and
+// source address of the default route of each family.
+func networkFacts(interfaces map[string]map[string]interface{}, default4, default6 map[string]string) map[string]interface{} {
+	facts := make(map[string]interface{})
+	names := sortedKeys(interfaces)
+	facts["ansible_interfaces"] = names
+
+	ipv4 := []string{}
+	ipv6 := []string{}
+	for _, name := range names {
+		iface := interfaces[name]
+		facts[interfaceFactName(name)] = iface
+
+		if primary, ok := iface["ipv4"].(map[string]interface{}); ok {
+			entries := append([]interface{}{primary}, sliceOf(iface["ipv4_secondaries"])...)
+			for _, entry := range entries {
+				address := entry.(map[string]interface{})["address"].(string)
+				if !strings.HasPrefix(address, "127.") {
+					ipv4 = append(ipv4, address)
+				}
+			}
+		}
+		for _, entry := range sliceOf(iface["ipv6"]) {
+			if address := entry.(map[string]interface{})["address"].(string); address != "::1" {
+				ipv6 = append(ipv6, address)
+			}
+		}
+	}
+	facts["ansible_all_ipv4_addresses"] = ipv4
+	facts["ansible_all_ipv6_addresses"] = ipv6
+	facts["ansible_default_ipv4"] = defaultRouteFact(interfaces, default4, "ipv4")
+	facts["ansible_default_ipv6"] = defaultRouteFact(interfaces, default6, "ipv6")
+	return facts
+}
+
+// defaultRouteFact enriches a default route with the details of its
+// interface, as ansible_default_ipv4 and ansible_default_ipv6 do
+func defaultRouteFact(interfaces map[string]map[string]interface{}, route map[string]string, family string) map[string]interface{} {
+	fact := make(map[string]interface{})
+	iface, ok := interfaces[route["interface"]]
+	if !ok {
+		return fact
+	}
+
+	fact["interface"] = route["interface"]
+	fact["alias"] = route["interface"]
+	if route["gateway"] != "" {
+		fact["gateway"] = route["gateway"]
+	}
+	for _, key := range []string{"macaddress", "mtu", "type"} {
+		if value, ok := iface[key]; ok {
+			fact[key] = value
+		}
+	}
+
+	var addresses []interface{}
+	if family == "ipv4" {
+		if primary, ok := iface["ipv4"]; ok {
+			addresses = append([]interface{}{primary}, sliceOf(iface["ipv4_secondaries"])...)
+		}
+	} else {
+		addresses = sliceOf(iface["ipv6"])
+	}
+	// Prefer the source address the route uses
+	for i, entry := range addresses {
+		if entry.(map[string]interface{})["address"] == route["address"] || (route["address"] == "" && i == 0) {
+			mergeFacts(fact, entry.(map[string]interface{}))
+			break
+		}
+	}
+	return fact
+}
+
+func sliceOf(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
+
+// linuxNetworkFacts parses ip -o link, ip -o addr and ip route get output
+func linuxNetworkFacts(link, addr, route4, route6 string) map[string]interface{} {
+	interfaces := make(map[string]map[string]interface{})
+	device := func(name string) map[string]interface{} {
+		name, _, _ = strings.Cut(strings.TrimSuffix(name, ":"), "@")
+		if _, ok := interfaces[name]; !ok {
+			interfaces[name] = map[string]interface{}{"device": name}
+		}
+		return interfaces[name]
+	}
+
+	// 2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP ...\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+	for _, line := range strings.Split(link, "\n") {
+		fields := strings.Fields(strings.ReplaceAll(line, `\`, " "))
+		if len(fields) < 3 {
+			continue
+		}
+		iface := device(fields[1])
+		flags := strings.Split(strings.Trim(fields[2], "<>"), ",")
+		iface["active"] = types.StringSliceContains(flags, "UP")
+		iface["promisc"] = types.StringSliceContains(flags, "PROMISC")
+		for i := 3; i+1 < len(fields); i++ {
+			switch {
+			case fields[i] == "mtu":
+				if mtu, err := strconv.Atoi(fields[i+1]); err == nil {
+					iface["mtu"] = mtu
+				}
+			case strings.HasPrefix(fields[i], "link/"):
+				iface["type"] = strings.TrimPrefix(fields[i], "link/")
+				if iface["type"] != "loopback" && iface["type"] != "none" {
+					iface["macaddress"] = fields[i+1]
+				}
+			}
+		}
+	}
+
+	// 2: eth0    inet 10.0.2.15/24 brd 10.0.2.255 scope global dynamic eth0\       valid_lft ...
+	for _, line := range strings.Split(addr, "\n") {
+		fields := strings.Fields(strings.ReplaceAll(line, `\`, " "))
+		if len(fields) < 4 {
+			continue
+		}
+		iface := device(fields[1])
+		address, bitsText, _ := strings.Cut(fields[3], "/")
+		bits, _ := strconv.Atoi(bitsText)
+		options := fieldOptions(fields[4:])
+
+		switch fields[2] {
+		case "inet":
+			entry := ipv4Fact(address, bits, options["brd"])
+			if _, ok := iface["ipv4"]; ok {
+				iface["ipv4_secondaries"] = append(sliceOf(iface["ipv4_secondaries"]), entry)
+			} else {
+				iface["ipv4"] = entry
+			}
+		case "inet6":
+			entry := map[string]interface{}{"address": address, "prefix": bitsText, "scope": options["scope"]}
+			iface["ipv6"] = append(sliceOf(iface["ipv6"]), entry)
+		}
+	}
+
+	// 1.1.1.1 via 10.0.2.2 dev eth0 src 10.0.2.15 uid 0
+	route := func(output string) map[string]string {
+		options := fieldOptions(strings.Fields(output))
+		return map[string]string{"gateway": options["via"], "interface": options["dev"], "address": options["src"]}
+	}
+	return networkFacts(interfaces, route(route4), route(route6))
+}
+
+// darwinNetworkFacts parses ifconfig -a and route -n get default output
+func darwinNetworkFacts(ifconfig, route string) map[string]interface{} {
+	interfaces := make(map[string]map[string]interface{})
+	var iface map[string]interface{}
+	for _, line := range strings.Split(ifconfig, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// en0: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+		if line[0] != ' ' && line[0] != '\t' {
+			name := strings.TrimSuffix(fields[0], ":")
+			_, flagText, _ := strings.Cut(line, "<")
+			flagText, _, _ = strings.Cut(flagText, ">")
+			flags := strings.Split(flagText, ",")
+			iface = map[string]interface{}{
+				"device":  name,
+				"active":  types.StringSliceContains(flags, "UP"),
+				"promisc": types.StringSliceContains(flags, "PROMISC"),
+				"type":    "unknown",
+			}
+			if types.StringSliceContains(flags, "LOOPBACK") {
+				iface["type"] = "loopback"
+			}
+			if options := fieldOptions(fields); options["mtu"] != "" {
+				if mtu, err := strconv.Atoi(options["mtu"]); err == nil {
+					iface["mtu"] = mtu
+				}
+			}
+			interfaces[name] = iface
+			continue
+		}
+		if iface == nil {
+			continue
+		}
+
+		options := fieldOptions(fields)
+		switch fields[0] {
+		case "ether":
+			iface["macaddress"] = fields[1]
+			iface["type"] = "ether"
+		case "inet":
+			entry := ipv4Fact(fields[1], hexMaskBits(options["netmask"]), options["broadcast"])
+			if _, ok := iface["ipv4"]; ok {
+				iface["ipv4_secondaries"] = append(sliceOf(iface["ipv4_secondaries"]), entry)
+			} else {
+				iface["ipv4"] = entry
+			}
+		case "inet6":
+			address, _, _ := strings.Cut(fields[1], "%")
+			scope := "global"
+			if strings.HasPrefix(address, "fe80:") {
+				scope = "link"
+			}
+			entry := map[string]interface{}{"address": address, "prefix": options["prefixlen"], "scope": scope}
+			iface["ipv6"] = append(sliceOf(iface["ipv6"]), entry)
+		case "status:":
+			iface["active"] = iface["active"] == true && fields[1] == "active"
+		}
+	}
+
+	values := parseKeyValues(route, ":")
+	defaultRoute := map[string]string{"gateway": values["gateway"], "interface": values["interface"]}
+	return networkFacts(interfaces, defaultRoute, nil)
+}
+
+// fieldOptions maps each field to the one after it, for "key value" output
+// like ip and ifconfig print
+func fieldOptions(fields []string) map[string]string {
+	options := make(map[string]string)
+	for i := 0; i+1 < len(fields); i++ {
+		if _, ok := options[fields[i]]; !ok {
+			options[fields[i]] = fields[i+1]
+		}
+	}
+	return options
+}
+
+// hexMaskBits converts an ifconfig netmask like 0xffffff00 to a prefix length
+func hexMaskBits(mask string) int {
+	raw, err := hex.DecodeString(strings.TrimPrefix(mask, "0x"))
+	if err != nil || len(raw) != 4 {
+		return 32
+	}
+	bits := 0
+	for _, b := range raw {
+		for ; b&0x80 != 0; b <<= 1 {
+			bits++
+		}
+	}
+	return bits
+}
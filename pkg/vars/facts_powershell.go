@@ -0,0 +1,210 @@
+package vars
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// windowsFactsScript queries CIM for everything the Windows facts need and
+// prints it as one JSON document
+const windowsFactsScript = `$ErrorActionPreference = 'SilentlyContinue'
+$os = Get-CimInstance Win32_OperatingSystem
+$cs = Get-CimInstance Win32_ComputerSystem
+[pscustomobject]@{
+  os = $os | Select-Object Caption, Version, OSArchitecture, TotalVisibleMemorySize, FreePhysicalMemory, SizeStoredInPagingFiles, FreeSpaceInPagingFiles
+  computer = $cs | Select-Object Name, DNSHostName, Domain, Manufacturer, Model, NumberOfProcessors, NumberOfLogicalProcessors, HypervisorPresent
+  processors = @(Get-CimInstance Win32_Processor | Select-Object Manufacturer, Name, NumberOfCores, NumberOfLogicalProcessors)
+  adapters = @(Get-CimInstance Win32_NetworkAdapterConfiguration -Filter 'IPEnabled=True' | Select-Object InterfaceIndex, Description, MACAddress, IPAddress, IPSubnet, DefaultIPGateway, DNSDomain)
+  disks = @(Get-CimInstance Win32_LogicalDisk -Filter 'DriveType=3' | Select-Object DeviceID, FileSystem, Size, FreeSpace, VolumeName)
+  user = [Environment]::UserName
+  userdir = $env:USERPROFILE
+  env = [Environment]::GetEnvironmentVariables()
+  pkg = @('choco', 'winget' | Where-Object { Get-Command $_ })
+} | ConvertTo-Json -Depth 4 -Compress`
+
+// windowsFacts is the document windowsFactsScript prints. Memory sizes are
+// in KB and disk sizes in bytes, as CIM reports them.
+type windowsFacts struct {
+	OS struct {
+		Caption                 string `json:"Caption"`
+		Version                 string `json:"Version"`
+		OSArchitecture          string `json:"OSArchitecture"`
+		TotalVisibleMemorySize  uint64 `json:"TotalVisibleMemorySize"`
+		FreePhysicalMemory      uint64 `json:"FreePhysicalMemory"`
+		SizeStoredInPagingFiles uint64 `json:"SizeStoredInPagingFiles"`
+		FreeSpaceInPagingFiles  uint64 `json:"FreeSpaceInPagingFiles"`
+	} `json:"os"`
+	Computer struct {
+		Name                      string `json:"Name"`
+		DNSHostName               string `json:"DNSHostName"`
+		Domain                    string `json:"Domain"`
+		Manufacturer              string `json:"Manufacturer"`
+		Model                     string `json:"Model"`
+		NumberOfProcessors        int    `json:"NumberOfProcessors"`
+		NumberOfLogicalProcessors int    `json:"NumberOfLogicalProcessors"`
+		HypervisorPresent         bool   `json:"HypervisorPresent"`
+	} `json:"computer"`
+	Processors []struct {
+		Manufacturer              string `json:"Manufacturer"`
+		Name                      string `json:"Name"`
+		NumberOfCores             int    `json:"NumberOfCores"`
+		NumberOfLogicalProcessors int    `json:"NumberOfLogicalProcessors"`
+	} `json:"processors"`
+	Adapters []struct {
+		InterfaceIndex   int      `json:"InterfaceIndex"`
+		Description      string   `json:"Description"`
+		MACAddress       string   `json:"MACAddress"`
+		IPAddress        []string `json:"IPAddress"`
+		IPSubnet         []string `json:"IPSubnet"`
+		DefaultIPGateway []string `json:"DefaultIPGateway"`
+		DNSDomain        string   `json:"DNSDomain"`
+	} `json:"adapters"`
+	Disks []struct {
+		DeviceID   string `json:"DeviceID"`
+		FileSystem string `json:"FileSystem"`
+		Size       int64  `json:"Size"`
+		FreeSpace  int64  `json:"FreeSpace"`
+		VolumeName string `json:"VolumeName"`
+	} `json:"disks"`
+	User    string            `json:"user"`
+	UserDir string            `json:"userdir"`
+	Env     map[string]string `json:"env"`
+	Pkg     []string          `json:"pkg"`
+}
+
+// collectWindows gathers the facts of a Windows host through PowerShell
+func (c *FactCollector) collectWindows(ctx context.Context, conn types.Connection) (map[string]interface{}, error) {
+	result, err := conn.Execute(ctx, windowsFactsScript, types.ExecuteOptions{Shell: "powershell"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather facts: host has neither uname nor PowerShell: %w", err)
+	}
+
+	var host windowsFacts
+	if err := json.Unmarshal([]byte(strings.TrimSpace(factOutput(result))), &host); err != nil {
+		return nil, fmt.Errorf("failed to parse Windows facts: %w", err)
+	}
+	return c.windowsFacts(&host), nil
+}
+
+// windowsFacts maps the CIM data to the keys Ansible's Windows setup module
+// returns
+func (c *FactCollector) windowsFacts(host *windowsFacts) map[string]interface{} {
+	major, _, _ := strings.Cut(host.OS.Version, ".")
+	fqdn := host.Computer.DNSHostName
+	if host.Computer.Domain != "" && !strings.EqualFold(host.Computer.Domain, "WORKGROUP") {
+		fqdn += "." + host.Computer.Domain
+	}
+
+	env := make(map[string]interface{}, len(host.Env))
+	for key, value := range host.Env {
+		env[key] = value
+	}
+	pkgManager := "unknown"
+	if len(host.Pkg) > 0 {
+		pkgManager = pkgMgr("Windows", strings.Join(host.Pkg, "\n"))
+	}
+
+	facts := map[string]interface{}{
+		"ansible_system":                     "Win32NT",
+		"ansible_os_family":                  "Windows",
+		"ansible_os_name":                    host.OS.Caption,
+		"ansible_distribution":               host.OS.Caption,
+		"ansible_distribution_version":       host.OS.Version,
+		"ansible_distribution_major_version": naIfEmpty(major),
+		"ansible_kernel":                     host.OS.Version,
+		"ansible_architecture":               host.OS.OSArchitecture,
+		"ansible_hostname":                   host.Computer.Name,
+		"ansible_nodename":                   fqdn,
+		"ansible_fqdn":                       fqdn,
+		"ansible_domain":                     host.Computer.Domain,
+		"ansible_pkg_mgr":                    pkgManager,
+		"ansible_user_id":                    host.User,
+		"ansible_user_dir":                   host.UserDir,
+		"ansible_env":                        env,
+	}
+
+	if c.subsets["hardware"] {
+		facts["ansible_memtotal_mb"] = int(host.OS.TotalVisibleMemorySize / 1024)
+		facts["ansible_memfree_mb"] = int(host.OS.FreePhysicalMemory / 1024)
+		facts["ansible_swaptotal_mb"] = int(host.OS.SizeStoredInPagingFiles / 1024)
+		facts["ansible_swapfree_mb"] = int(host.OS.FreeSpaceInPagingFiles / 1024)
+		facts["ansible_product_name"] = naIfEmpty(host.Computer.Model)
+		facts["ansible_system_vendor"] = naIfEmpty(host.Computer.Manufacturer)
+
+		var processors []interface{}
+		cores, vcpus := 0, 0
+		for i, processor := range host.Processors {
+			processors = append(processors, fmt.Sprint(i), processor.Manufacturer, processor.Name)
+			cores += processor.NumberOfCores
+			vcpus += processor.NumberOfLogicalProcessors
+		}
+		if len(host.Processors) > 0 {
+			facts["ansible_processor"] = processors
+			facts["ansible_processor_count"] = len(host.Processors)
+			facts["ansible_processor_cores"] = max(cores/len(host.Processors), 1)
+			facts["ansible_processor_threads_per_core"] = max(vcpus/max(cores, 1), 1)
+			facts["ansible_processor_vcpus"] = vcpus
+		}
+
+		mounts := []interface{}{}
+		for _, disk := range host.Disks {
+			mounts = append(mounts, map[string]interface{}{
+				"device":         disk.DeviceID,
+				"mount":          disk.DeviceID + `\`,
+				"fstype":         disk.FileSystem,
+				"label":          disk.VolumeName,
+				"size_total":     disk.Size,
+				"size_available": disk.FreeSpace,
+			})
+		}
+		facts["ansible_mounts"] = mounts
+	}
+
+	if c.subsets["network"] {
+		interfaces := []interface{}{}
+		addresses := []string{}
+		for _, adapter := range host.Adapters {
+			iface := map[string]interface{}{
+				"interface_index": adapter.InterfaceIndex,
+				"interface_name":  adapter.Description,
+				"macaddress":      adapter.MACAddress,
+				"dns_domain":      adapter.DNSDomain,
+			}
+			if len(adapter.DefaultIPGateway) > 0 {
+				iface["default_gateway"] = adapter.DefaultIPGateway[0]
+			}
+			interfaces = append(interfaces, iface)
+			addresses = append(addresses, adapter.IPAddress...)
+		}
+		sort.Strings(addresses)
+		facts["ansible_interfaces"] = interfaces
+		facts["ansible_ip_addresses"] = addresses
+	}
+
+	if c.subsets["virtual"] {
+		virtType, role := "NA", "NA"
+		model := host.Computer.Manufacturer + " " + host.Computer.Model
+		switch {
+		case strings.Contains(model, "Microsoft") && strings.Contains(model, "Virtual Machine"):
+			virtType, role = "Hyper-V", "guest"
+		case strings.Contains(model, "VMware"):
+			virtType, role = "VMware", "guest"
+		case strings.Contains(model, "VirtualBox"):
+			virtType, role = "VirtualBox", "guest"
+		case strings.Contains(model, "QEMU") || strings.Contains(model, "KVM"):
+			virtType, role = "kvm", "guest"
+		case strings.Contains(model, "Xen") || strings.Contains(model, "HVM domU"):
+			virtType, role = "xen", "guest"
+		case host.Computer.HypervisorPresent:
+			role = "guest"
+		}
+		facts["ansible_virtualization_type"] = virtType
+		facts["ansible_virtualization_role"] = role
+	}
+	return facts
+}
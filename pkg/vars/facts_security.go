@@ -0,0 +1,47 @@
+package vars
+
+import (
+	"strconv"
+	"strings"
+)
+
+// selinuxFacts builds ansible_selinux from the selinux section: the
+// getenforce mode, the loaded policy version and the SELINUX and
+// SELINUXTYPE lines of /etc/selinux/config
+func selinuxFacts(output string) map[string]interface{} {
+	values := parseKeyValues(output, "=")
+	mode := strings.ToLower(values["mode"])
+	if mode == "" || mode == "disabled" {
+		return map[string]interface{}{"ansible_selinux": map[string]interface{}{"status": "disabled"}}
+	}
+
+	selinux := map[string]interface{}{
+		"status":      "enabled",
+		"mode":        mode,
+		"config_mode": strings.ToLower(strings.Trim(values["SELINUX"], `"`)),
+		"type":        strings.Trim(values["SELINUXTYPE"], `"`),
+	}
+	if version, err := strconv.Atoi(values["policyvers"]); err == nil {
+		selinux["policyvers"] = version
+	}
+	return map[string]interface{}{"ansible_selinux": selinux}
+}
+
+// apparmorFacts builds ansible_apparmor from the apparmor section: the
+// module's enabled parameter followed by the loaded profiles, one
+// "name (mode)" line each
+func apparmorFacts(output string) map[string]interface{} {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "Y" {
+		return map[string]interface{}{"ansible_apparmor": map[string]interface{}{"status": "disabled"}}
+	}
+
+	profiles := make(map[string]interface{})
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if open := strings.LastIndex(line, " ("); open > 0 && strings.HasSuffix(line, ")") {
+			profiles[line[:open]] = line[open+2 : len(line)-1]
+		}
+	}
+	return map[string]interface{}{"ansible_apparmor": map[string]interface{}{"status": "enabled", "profiles": profiles}}
+}
@@ -0,0 +1,333 @@
+package vars
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	testhelper "github.com/liliang-cn/gosible/pkg/testing"
+)
+
+// factScriptOutput builds the output of a fact script from its sections
+func factScriptOutput(sections map[string]string) string {
+	var b strings.Builder
+	for _, name := range sortedKeys(sections) {
+		b.WriteString(factSectionMarker + name + "\n" + sections[name] + "\n")
+	}
+	return b.String()
+}
+
+var rockyFactSections = map[string]string{
+	"platform": "Linux\n5.14.0-362.el9.x86_64\n#1 SMP PREEMPT_DYNAMIC\nx86_64\nweb1.example.com\nweb1.example.com",
+	"os_release": `NAME="Rocky Linux"
+VERSION="9.3 (Blue Onyx)"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+VERSION_ID="9.3"`,
+	"pkg_mgr": "/usr/bin/dnf\n/usr/bin/yum\n/usr/bin/apt-get",
+	"user":    "deploy\n1000\n1000\n/home/deploy\n/bin/bash",
+	"environ": "PATH=/usr/bin:/bin\nLANG=en_US.UTF-8",
+	"meminfo": "MemTotal:        8048576 kB\nMemFree:         1048576 kB\nBuffers:          102400 kB\nCached:          2097152 kB\nSwapTotal:       2097152 kB\nSwapFree:        2097152 kB",
+	"cpuinfo": `processor	: 0
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) CPU
+physical id	: 0
+siblings	: 2
+cpu cores	: 1
+flags		: fpu vme hypervisor
+
+processor	: 1
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) CPU
+physical id	: 0
+siblings	: 2
+cpu cores	: 1
+flags		: fpu vme hypervisor`,
+	"nproc": "2",
+	"df": `Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/vda1         10485760 5242880   5242880      50% /
+tmpfs               102400       0    102400       0% /run
+/dev/vdb1          1048576       0   1048576       0% /srv/my data`,
+	"mounts": `/dev/vda1 / xfs rw,relatime 0 0
+tmpfs /run tmpfs rw,nosuid 0 0
+/dev/vdb1 /srv/my\040data ext4 rw 0 0
+nfs.example.com:/export /mnt/nfs nfs4 rw 0 0`,
+	"dmi":         "product_name=KVM\nsys_vendor=Red Hat\nproduct_version=RHEL 9",
+	"link":        "1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN\\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00\n2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP\\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff\n3: br-lan@eth0: <BROADCAST,MULTICAST> mtu 1500 qdisc noop state DOWN\\    link/ether 52:54:00:ab:cd:ef brd ff:ff:ff:ff:ff:ff",
+	"addr":        "1: lo    inet 127.0.0.1/8 scope host lo\\       valid_lft forever\n2: eth0    inet 10.0.2.15/24 brd 10.0.2.255 scope global eth0\\       valid_lft forever\n2: eth0    inet 10.0.2.16/24 scope global secondary eth0\\       valid_lft forever\n2: eth0    inet6 fe80::5054:ff:fe12:3456/64 scope link \\       valid_lft forever",
+	"route4":      "1.1.1.1 via 10.0.2.2 dev eth0 src 10.0.2.15 uid 1000\n    cache",
+	"detect_virt": "kvm",
+	"selinux":     "mode=Enforcing\npolicyvers=33\nSELINUX=enforcing\nSELINUXTYPE=targeted",
+}
+
+func TestFactCollectorLinux(t *testing.T) {
+	conn := testhelper.NewMockConnection(t)
+	conn.ExpectCommand("uname -s", &testhelper.CommandResponse{Stdout: "Linux\n"})
+	conn.ExpectCommandPattern("^echo '"+factSectionMarker+"platform'", &testhelper.CommandResponse{Stdout: factScriptOutput(rockyFactSections)})
+
+	collector, err := NewFactCollector([]string{"all"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	facts, err := collector.Collect(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	for key, want := range map[string]interface{}{
+		"ansible_system":                     "Linux",
+		"ansible_hostname":                   "web1",
+		"ansible_domain":                     "example.com",
+		"ansible_distribution":               "Rocky",
+		"ansible_distribution_version":       "9.3",
+		"ansible_distribution_major_version": "9",
+		"ansible_distribution_release":       "Blue Onyx",
+		"ansible_os_family":                  "RedHat",
+		"ansible_pkg_mgr":                    "dnf",
+		"ansible_user_id":                    "deploy",
+		"ansible_user_uid":                   1000,
+		"ansible_memtotal_mb":                7859,
+		"ansible_swapfree_mb":                2048,
+		"ansible_processor_count":            1,
+		"ansible_processor_threads_per_core": 2,
+		"ansible_processor_vcpus":            2,
+		"ansible_product_name":               "KVM",
+		"ansible_virtualization_type":        "kvm",
+		"ansible_virtualization_role":        "guest",
+	} {
+		if facts[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, facts[key])
+		}
+	}
+	if env := facts["ansible_env"].(map[string]interface{}); env["LANG"] != "en_US.UTF-8" {
+		t.Errorf("expected the environment, got %v", env)
+	}
+
+	mounts := facts["ansible_mounts"].([]interface{})
+	if len(mounts) != 3 {
+		t.Fatalf("expected the block device and nfs mounts, got %v", mounts)
+	}
+	data := mounts[1].(map[string]interface{})
+	if data["mount"] != "/srv/my data" || data["size_total"] != int64(1073741824) {
+		t.Errorf("expected the escaped mount point with its size, got %v", data)
+	}
+
+	if !reflect.DeepEqual(facts["ansible_interfaces"], []string{"br-lan", "eth0", "lo"}) {
+		t.Errorf("unexpected interfaces %v", facts["ansible_interfaces"])
+	}
+	if _, ok := facts["ansible_br_lan"]; !ok {
+		t.Error("expected dashes in interface facts to become underscores")
+	}
+	eth0 := facts["ansible_eth0"].(map[string]interface{})
+	if eth0["macaddress"] != "52:54:00:12:34:56" || eth0["mtu"] != 1500 || eth0["active"] != true {
+		t.Errorf("unexpected link details %v", eth0)
+	}
+	ipv4 := eth0["ipv4"].(map[string]interface{})
+	if ipv4["netmask"] != "255.255.255.0" || ipv4["network"] != "10.0.2.0" || ipv4["broadcast"] != "10.0.2.255" {
+		t.Errorf("unexpected ipv4 %v", ipv4)
+	}
+	if len(sliceOf(eth0["ipv4_secondaries"])) != 1 || len(sliceOf(eth0["ipv6"])) != 1 {
+		t.Errorf("expected a secondary ipv4 and a link local ipv6 address, got %v", eth0)
+	}
+	if !reflect.DeepEqual(facts["ansible_all_ipv4_addresses"], []string{"10.0.2.15", "10.0.2.16"}) {
+		t.Errorf("expected the non loopback addresses, got %v", facts["ansible_all_ipv4_addresses"])
+	}
+	gateway := facts["ansible_default_ipv4"].(map[string]interface{})
+	if gateway["gateway"] != "10.0.2.2" || gateway["address"] != "10.0.2.15" || gateway["macaddress"] != "52:54:00:12:34:56" {
+		t.Errorf("unexpected default route %v", gateway)
+	}
+	want := map[string]interface{}{"status": "enabled", "mode": "enforcing", "config_mode": "enforcing", "type": "targeted", "policyvers": 33}
+	if !reflect.DeepEqual(facts["ansible_selinux"], want) {
+		t.Errorf("unexpected selinux facts %v", facts["ansible_selinux"])
+	}
+	if !reflect.DeepEqual(facts["ansible_apparmor"], map[string]interface{}{"status": "disabled"}) {
+		t.Errorf("expected apparmor to be disabled without the module, got %v", facts["ansible_apparmor"])
+	}
+}
+
+func TestSecurityFacts(t *testing.T) {
+	if got := selinuxFacts("mode=\nSELINUX=enforcing")["ansible_selinux"]; !reflect.DeepEqual(got, map[string]interface{}{"status": "disabled"}) {
+		t.Errorf("expected selinux without getenforce to be disabled, got %v", got)
+	}
+	got := apparmorFacts("Y\n/usr/sbin/cupsd (enforce)\nnvidia_modprobe (complain)\n/usr/bin/man (enforce)")["ansible_apparmor"]
+	want := map[string]interface{}{"status": "enabled", "profiles": map[string]interface{}{
+		"/usr/sbin/cupsd": "enforce", "nvidia_modprobe": "complain", "/usr/bin/man": "enforce",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected apparmor facts %v", got)
+	}
+}
+
+func TestFactCollectorSubsets(t *testing.T) {
+	collector, err := NewFactCollector([]string{"all", "!hardware"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := collector.script(linuxFactSections)
+	if strings.Contains(script, "/proc/meminfo") || !strings.Contains(script, "ip -o addr show") {
+		t.Errorf("expected network but not hardware commands, got %s", script)
+	}
+	// The cpuinfo section is shared with the virtual subset
+	if !strings.Contains(script, "/proc/cpuinfo") {
+		t.Error("expected cpuinfo for virtualization detection")
+	}
+
+	collector, _ = NewFactCollector([]string{"min"}, 0)
+	if script := collector.script(linuxFactSections); strings.Contains(script, "ip -o") || !strings.Contains(script, "os-release") {
+		t.Errorf("expected only the min facts, got %s", script)
+	}
+
+	if _, err := NewFactCollector([]string{"facter"}, 0); err == nil {
+		t.Error("expected an unknown subset to be rejected")
+	}
+}
+
+func TestFactCollectorDarwin(t *testing.T) {
+	conn := testhelper.NewMockConnection(t)
+	conn.ExpectCommand("uname -s", &testhelper.CommandResponse{Stdout: "Darwin\n"})
+	conn.ExpectCommandPattern("sw_vers", &testhelper.CommandResponse{Stdout: factScriptOutput(map[string]string{
+		"platform": "Darwin\n23.2.0\nDarwin Kernel Version 23.2.0\narm64\nmbp.local\nmbp.local",
+		"sw_vers":  "ProductName:\t\tmacOS\nProductVersion:\t\t14.2.1\nBuildVersion:\t\t23C71",
+		"pkg_mgr":  "/opt/homebrew/bin/brew",
+		"sysctl":   "hw.model: Mac14,2\nhw.memsize: 17179869184\nhw.ncpu: 8\nhw.packages: 1\nhw.physicalcpu: 8\nhw.logicalcpu: 8\nmachdep.cpu.brand_string: Apple M2\nvm.swapusage: total = 2048.00M  used = 1024.00M  free = 1024.00M  (encrypted)\nkern.hv_vmm_present: 0",
+		"vm_stat":  "Mach Virtual Memory Statistics: (page size of 16384 bytes)\nPages free:                               65536.\nPages inactive:                           32768.\nPages speculative:                            0.",
+		"df":       "Filesystem   1024-blocks     Used Available Capacity  Mounted on\n/dev/disk3s1s1 482797652 10071116 261231524     4%    /",
+		"mount":    "/dev/disk3s1s1 on / (apfs, sealed, local, read-only, journaled)\nmap auto_home on /System/Volumes/Data/home (autofs, automounted, nobrowse)",
+		"ifconfig": "lo0: flags=8049<UP,LOOPBACK,RUNNING,MULTICAST> mtu 16384\n\tinet 127.0.0.1 netmask 0xff000000\n\tinet6 ::1 prefixlen 128\nen0: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500\n\tether 3c:22:fb:01:02:03\n\tinet6 fe80::1c%en0 prefixlen 64 secured scopeid 0xe\n\tinet 192.168.1.23 netmask 0xffffff00 broadcast 192.168.1.255\n\tstatus: active",
+		"route":    "   route to: default\ndestination: default\n    gateway: 192.168.1.1\n  interface: en0",
+	})})
+
+	collector, _ := NewFactCollector([]string{"all"}, 0)
+	facts, err := collector.Collect(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	for key, want := range map[string]interface{}{
+		"ansible_distribution":               "MacOSX",
+		"ansible_distribution_major_version": "14",
+		"ansible_os_family":                  "Darwin",
+		"ansible_pkg_mgr":                    "homebrew",
+		"ansible_memtotal_mb":                16384,
+		"ansible_memfree_mb":                 1536,
+		"ansible_swaptotal_mb":               2048,
+		"ansible_processor_vcpus":            8,
+		"ansible_virtualization_role":        "NA",
+	} {
+		if facts[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, facts[key])
+		}
+	}
+	if mounts := facts["ansible_mounts"].([]interface{}); len(mounts) != 1 || mounts[0].(map[string]interface{})["fstype"] != "apfs" {
+		t.Errorf("expected the apfs root volume only, got %v", mounts)
+	}
+	gateway := facts["ansible_default_ipv4"].(map[string]interface{})
+	if gateway["address"] != "192.168.1.23" || gateway["netmask"] != "255.255.255.0" || gateway["gateway"] != "192.168.1.1" {
+		t.Errorf("unexpected default route %v", gateway)
+	}
+}
+
+func TestFactCollectorWindows(t *testing.T) {
+	conn := testhelper.NewMockConnection(t)
+	conn.ExpectCommand("uname -s", &testhelper.CommandResponse{ExitCode: 1, Stderr: "'uname' is not recognized"})
+	conn.ExpectCommandPattern("ConvertTo-Json", &testhelper.CommandResponse{Stdout: `{"os":{"Caption":"Microsoft Windows Server 2022 Datacenter","Version":"10.0.20348","OSArchitecture":"64-bit","TotalVisibleMemorySize":8388608,"FreePhysicalMemory":4194304},` +
+		`"computer":{"Name":"WIN1","DNSHostName":"win1","Domain":"corp.example.com","Manufacturer":"Microsoft Corporation","Model":"Virtual Machine","HypervisorPresent":true},` +
+		`"processors":[{"Manufacturer":"GenuineIntel","Name":"Intel(R) Xeon(R)","NumberOfCores":2,"NumberOfLogicalProcessors":4}],` +
+		`"adapters":[{"InterfaceIndex":4,"Description":"Microsoft Hyper-V Network Adapter","MACAddress":"00:15:5D:01:02:03","IPAddress":["10.0.0.5","fe80::1"],"DefaultIPGateway":["10.0.0.1"]}],` +
+		`"disks":[{"DeviceID":"C:","FileSystem":"NTFS","Size":136365211648,"FreeSpace":68182605824}],` +
+		`"user":"Administrator","env":{"COMPUTERNAME":"WIN1"},"pkg":["choco"]}`})
+
+	collector, _ := NewFactCollector([]string{"all"}, 0)
+	facts, err := collector.Collect(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	for key, want := range map[string]interface{}{
+		"ansible_os_family":                  "Windows",
+		"ansible_distribution_major_version": "10",
+		"ansible_fqdn":                       "win1.corp.example.com",
+		"ansible_memtotal_mb":                8192,
+		"ansible_processor_threads_per_core": 2,
+		"ansible_pkg_mgr":                    "chocolatey",
+		"ansible_virtualization_type":        "Hyper-V",
+	} {
+		if facts[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, facts[key])
+		}
+	}
+	if !reflect.DeepEqual(facts["ansible_ip_addresses"], []string{"10.0.0.5", "fe80::1"}) {
+		t.Errorf("unexpected addresses %v", facts["ansible_ip_addresses"])
+	}
+}
+
+func TestDistributionFacts(t *testing.T) {
+	ubuntu := distributionFacts("Linux", `NAME="Ubuntu"
+VERSION="20.04.3 LTS (Focal Fossa)"
+ID=ubuntu
+ID_LIKE=debian
+VERSION_ID="20.04"
+VERSION_CODENAME=focal`)
+	if ubuntu["ansible_distribution"] != "Ubuntu" || ubuntu["ansible_os_family"] != "Debian" || ubuntu["ansible_distribution_release"] != "focal" {
+		t.Errorf("unexpected Ubuntu facts %v", ubuntu)
+	}
+
+	// Unknown distributions keep their name and fall back to ID_LIKE
+	derived := distributionFacts("Linux", "NAME=\"Acme Linux\"\nID=acme\nID_LIKE=\"suse opensuse\"")
+	if derived["ansible_distribution"] != "Acme Linux" || derived["ansible_os_family"] != "Suse" || derived["ansible_distribution_version"] != "NA" {
+		t.Errorf("unexpected derived facts %v", derived)
+	}
+}
+
+func TestPkgMgr(t *testing.T) {
+	tests := []struct{ family, found, want string }{
+		{"Debian", "/usr/bin/apt-get", "apt"},
+		{"RedHat", "/usr/bin/yum", "yum"},
+		{"RedHat", "/usr/bin/apt-get", "unknown"},
+		{"Alpine", "/sbin/apk", "apk"},
+		{"Slackware", "/usr/bin/pacman\n/usr/bin/apk", "pacman"},
+		{"Linux", "", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := pkgMgr(tt.family, tt.found); got != tt.want {
+			t.Errorf("pkgMgr(%s, %q) = %s, want %s", tt.family, tt.found, got, tt.want)
+		}
+	}
+}
+
+func TestLinuxVirtualFacts(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   map[string]string
+		virtType string
+		role     string
+	}{
+		{"Docker", map[string]string{"container": "docker", "detect_virt": "kvm"}, "docker", "guest"},
+		{"Kubernetes", map[string]string{"container": "0::/kubepods/burstable/pod1"}, "container", "guest"},
+		{"VMware", map[string]string{"dmi": "product_name=VMware Virtual Platform\nsys_vendor=VMware, Inc."}, "VMware", "guest"},
+		{"HyperV", map[string]string{"dmi": "product_name=Virtual Machine\nsys_vendor=Microsoft Corporation"}, "VirtualPC", "guest"},
+		{"HypervisorFlag", map[string]string{"cpuinfo": "processor : 0\nflags : fpu hypervisor"}, "NA", "guest"},
+		{"KVMHost", map[string]string{"detect_virt": "none", "kvm_host": "kvm 1network 1 kvm_intel, Live"}, "kvm", "host"},
+		{"BareMetal", map[string]string{"detect_virt": "none"}, "NA", "NA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts := linuxVirtualFacts(tt.output)
+			if facts["ansible_virtualization_type"] != tt.virtType || facts["ansible_virtualization_role"] != tt.role {
+				t.Errorf("expected %s/%s, got %v", tt.virtType, tt.role, facts)
+			}
+		})
+	}
+}
+
+func TestIPv4Fact(t *testing.T) {
+	fact := ipv4Fact("172.16.5.4", 20, "")
+	if fact["netmask"] != "255.255.240.0" || fact["network"] != "172.16.0.0" || fact["broadcast"] != "172.16.15.255" {
+		t.Errorf("unexpected ipv4 fact %v", fact)
+	}
+	if bits := hexMaskBits("0xfffffe00"); bits != 23 {
+		t.Errorf("expected 23 bits, got %d", bits)
+	}
+}
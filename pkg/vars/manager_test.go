@@ -212,140 +212,6 @@ func TestVarManagerMergeVars(t *testing.T) {
 	}
 }
 
-func TestVarManagerParseOSRelease(t *testing.T) {
-	vm := NewVarManager()
-
-	osReleaseContent := `NAME="Ubuntu"
-VERSION="20.04.3 LTS (Focal Fossa)"
-ID=ubuntu
-ID_LIKE=debian
-PRETTY_NAME="Ubuntu 20.04.3 LTS"
-VERSION_ID="20.04"
-HOME_URL="https://www.ubuntu.com/"
-VERSION_CODENAME=focal`
-
-	result := vm.parseOSRelease(osReleaseContent)
-
-	if result["ansible_distribution"] != "Ubuntu" {
-		t.Errorf("expected Ubuntu, got %v", result["ansible_distribution"])
-	}
-
-	if result["ansible_distribution_version"] != "20.04" {
-		t.Errorf("expected 20.04, got %v", result["ansible_distribution_version"])
-	}
-
-	if result["ansible_distribution_release"] != "focal" {
-		t.Errorf("expected focal, got %v", result["ansible_distribution_release"])
-	}
-
-	if result["ansible_os_family"] != "ubuntu" {
-		t.Errorf("expected ubuntu, got %v", result["ansible_os_family"])
-	}
-}
-
-func TestVarManagerParseDefaultRoute(t *testing.T) {
-	vm := NewVarManager()
-
-	routeInfo := "1.1.1.1 via 192.168.1.1 dev eth0 src 192.168.1.100 uid 1000"
-	result := vm.parseDefaultRoute(routeInfo)
-
-	if result["address"] != "192.168.1.100" {
-		t.Errorf("expected address 192.168.1.100, got %v", result["address"])
-	}
-
-	if result["interface"] != "eth0" {
-		t.Errorf("expected interface eth0, got %v", result["interface"])
-	}
-
-	if result["gateway"] != "192.168.1.1" {
-		t.Errorf("expected gateway 192.168.1.1, got %v", result["gateway"])
-	}
-}
-
-func TestVarManagerParseMounts(t *testing.T) {
-	vm := NewVarManager()
-
-	dfOutput := `Filesystem     1K-blocks     Used Available Use% Mounted on
-/dev/sda1      102687672 12345678  87654321  13% /
-tmpfs           1234567       0   1234567   0% /dev/shm
-/dev/sda2      10485760  5242880   5242880  50% /home`
-
-	mounts := vm.parseMounts(dfOutput)
-
-	if len(mounts) != 3 {
-		t.Errorf("expected 3 mounts, got %d", len(mounts))
-		return
-	}
-
-	// Check first mount
-	mount0 := mounts[0]
-	if mount0["device"] != "/dev/sda1" {
-		t.Errorf("expected device /dev/sda1, got %v", mount0["device"])
-	}
-	if mount0["mount"] != "/" {
-		t.Errorf("expected mount /, got %v", mount0["mount"])
-	}
-
-	// Check that percentage is calculated
-	if _, exists := mount0["size_percent"]; !exists {
-		t.Error("size_percent should be calculated")
-	}
-}
-
-func TestVarManagerParseIdOutput(t *testing.T) {
-	vm := NewVarManager()
-
-	idOutput := "uid=1000(testuser) gid=1000(testuser) groups=1000(testuser),4(adm),24(cdrom)"
-	result := vm.parseIdOutput(idOutput)
-
-	if result["ansible_user_uid"] != 1000 {
-		t.Errorf("expected UID 1000, got %v", result["ansible_user_uid"])
-	}
-
-	if result["ansible_user_gid"] != 1000 {
-		t.Errorf("expected GID 1000, got %v", result["ansible_user_gid"])
-	}
-}
-
-func TestVarManagerParseIPv4FromAddr(t *testing.T) {
-	vm := NewVarManager()
-
-	addrOutput := `2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc pfifo_fast state UP group default qlen 1000
-    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
-    inet 192.168.1.100/24 brd 192.168.1.255 scope global eth0
-       valid_lft forever preferred_lft forever
-    inet6 fe80::5054:ff:fe12:3456/64 scope link
-       valid_lft forever preferred_lft forever`
-
-	result := vm.parseIPv4FromAddr(addrOutput)
-
-	if result == nil {
-		t.Fatal("result should not be nil")
-	}
-
-	if result["address"] != "192.168.1.100" {
-		t.Errorf("expected address 192.168.1.100, got %v", result["address"])
-	}
-
-	if result["netmask"] != "255.255.255.0" {
-		t.Errorf("expected netmask 255.255.255.0, got %v", result["netmask"])
-	}
-}
-
-func TestVarManagerParseMACFromAddr(t *testing.T) {
-	vm := NewVarManager()
-
-	addrOutput := `2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc pfifo_fast state UP group default qlen 1000
-    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
-    inet 192.168.1.100/24 brd 192.168.1.255 scope global eth0`
-
-	mac := vm.parseMACFromAddr(addrOutput)
-
-	if mac != "52:54:00:12:34:56" {
-		t.Errorf("expected MAC 52:54:00:12:34:56, got %s", mac)
-	}
-}
-
 func TestVarManagerConcurrency(t *testing.T) {
 	vm := NewVarManager()
 
@@ -0,0 +1,127 @@
+package vars
+
+import (
+	"fmt"
+
+	"github.com/liliang-cn/gosible/pkg/types"
+)
+
+// HashBehaviour decides what happens when a variable holding a map is
+// defined at two precedence levels, like Ansible's hash_behaviour setting
+type HashBehaviour string
+
+const (
+	// HashReplace lets the higher level's map replace the lower one, as
+	// Ansible does by default
+	HashReplace HashBehaviour = "replace"
+	// HashMerge combines the maps key by key, recursively
+	HashMerge HashBehaviour = "merge"
+)
+
+// ParseHashBehaviour parses a hash_behaviour setting. Empty selects
+// HashReplace.
+func ParseHashBehaviour(value string) (HashBehaviour, error) {
+	switch HashBehaviour(value) {
+	case "", HashReplace:
+		return HashReplace, nil
+	case HashMerge:
+		return HashMerge, nil
+	default:
+		return "", fmt.Errorf("invalid hash_behaviour %q: expected replace or merge", value)
+	}
+}
+
+// Merge returns base overridden by override. Maps defined in both are
+// replaced or merged recursively depending on the behaviour; lists are
+// always replaced.
+func (b HashBehaviour) Merge(base, override map[string]interface{}) map[string]interface{} {
+	// The default list_merge is always valid
+	merged, _ := types.CombineMaps(base, override, b == HashMerge, "replace")
+	return merged
+}
+
+// Level is a variable precedence level. Levels follow Ansible's documented
+// order, lowest first, so variables from a higher level override those from
+// a lower one.
+type Level int
+
+const (
+	// LevelRoleDefaults holds the defaults/main.yml of roles
+	LevelRoleDefaults Level = iota
+	// LevelInventoryGroupVars holds group vars from the inventory file
+	LevelInventoryGroupVars
+	// LevelInventoryGroupVarsAll holds the inventory's group_vars/all
+	LevelInventoryGroupVarsAll
+	// LevelPlaybookGroupVarsAll holds the playbook's group_vars/all
+	LevelPlaybookGroupVarsAll
+	// LevelInventoryGroupVarsFiles holds the inventory's group_vars/*
+	LevelInventoryGroupVarsFiles
+	// LevelPlaybookGroupVarsFiles holds the playbook's group_vars/*
+	LevelPlaybookGroupVarsFiles
+	// LevelInventoryHostVars holds host vars from the inventory file
+	LevelInventoryHostVars
+	// LevelInventoryHostVarsFiles holds the inventory's host_vars/*
+	LevelInventoryHostVarsFiles
+	// LevelPlaybookHostVarsFiles holds the playbook's host_vars/*
+	LevelPlaybookHostVarsFiles
+	// LevelHostFacts holds gathered facts and cached set_facts
+	LevelHostFacts
+	// LevelPlayVars holds the vars of the play
+	LevelPlayVars
+	// LevelPlayVarsPrompt holds the answers to the play's vars_prompt
+	LevelPlayVarsPrompt
+	// LevelPlayVarsFiles holds the play's vars_files
+	LevelPlayVarsFiles
+	// LevelRoleVars holds the vars/main.yml of roles
+	LevelRoleVars
+	// LevelBlockVars holds the vars of blocks
+	LevelBlockVars
+	// LevelTaskVars holds the vars of tasks
+	LevelTaskVars
+	// LevelIncludeVars holds variables loaded by include_vars
+	LevelIncludeVars
+	// LevelSetFacts holds set_fact results and registered variables
+	LevelSetFacts
+	// LevelRoleParams holds the parameters of roles and include_role
+	LevelRoleParams
+	// LevelIncludeParams holds the parameters of include_tasks
+	LevelIncludeParams
+	// LevelExtraVars holds extra vars (-e), which always win
+	LevelExtraVars
+
+	numLevels
+)
+
+// Precedence resolves the variables of a host from the variables defined
+// at each precedence level
+type Precedence struct {
+	behaviour HashBehaviour
+	levels    [numLevels][]map[string]interface{}
+}
+
+// NewPrecedence creates an empty precedence chain combining maps with the
+// given hash behaviour
+func NewPrecedence(behaviour HashBehaviour) *Precedence {
+	return &Precedence{behaviour: behaviour}
+}
+
+// Add defines variables at a level. Variables added later to the same
+// level override earlier ones.
+func (p *Precedence) Add(level Level, vars map[string]interface{}) {
+	if level < 0 || level >= numLevels || len(vars) == 0 {
+		return
+	}
+	p.levels[level] = append(p.levels[level], vars)
+}
+
+// Resolve returns the variables of every level, merged from the lowest
+// level to the highest
+func (p *Precedence) Resolve() map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, level := range p.levels {
+		for _, vars := range level {
+			result = p.behaviour.Merge(result, vars)
+		}
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package vars
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrecedenceOrder(t *testing.T) {
+	// Each level defines the same variable; the highest level wins, whatever
+	// order the levels are added in
+	levels := []Level{
+		LevelRoleDefaults,
+		LevelInventoryGroupVars,
+		LevelInventoryGroupVarsAll,
+		LevelPlaybookGroupVarsAll,
+		LevelInventoryGroupVarsFiles,
+		LevelPlaybookGroupVarsFiles,
+		LevelInventoryHostVars,
+		LevelInventoryHostVarsFiles,
+		LevelPlaybookHostVarsFiles,
+		LevelHostFacts,
+		LevelPlayVars,
+		LevelPlayVarsPrompt,
+		LevelPlayVarsFiles,
+		LevelRoleVars,
+		LevelBlockVars,
+		LevelTaskVars,
+		LevelIncludeVars,
+		LevelSetFacts,
+		LevelRoleParams,
+		LevelIncludeParams,
+		LevelExtraVars,
+	}
+
+	for n := 1; n <= len(levels); n++ {
+		precedence := NewPrecedence(HashReplace)
+		for i := n - 1; i >= 0; i-- {
+			precedence.Add(levels[i], map[string]interface{}{"value": int(levels[i])})
+		}
+		if value := precedence.Resolve()["value"]; value != int(levels[n-1]) {
+			t.Errorf("with %d levels, expected level %d to win, got %v", n, levels[n-1], value)
+		}
+	}
+}
+
+func TestPrecedenceSameLevel(t *testing.T) {
+	precedence := NewPrecedence(HashReplace)
+	precedence.Add(LevelPlayVarsFiles, map[string]interface{}{"port": 80, "user": "web"})
+	precedence.Add(LevelPlayVarsFiles, map[string]interface{}{"port": 8080})
+
+	expected := map[string]interface{}{"port": 8080, "user": "web"}
+	if vars := precedence.Resolve(); !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected later files of a level to win, got %v", vars)
+	}
+}
+
+func TestPrecedenceHashBehaviour(t *testing.T) {
+	inventory := map[string]interface{}{
+		"app":   map[string]interface{}{"port": 80, "user": "web"},
+		"peers": []interface{}{"a", "b"},
+	}
+	play := map[string]interface{}{
+		"app":   map[string]interface{}{"port": 8080},
+		"peers": []interface{}{"c"},
+	}
+
+	tests := []struct {
+		behaviour HashBehaviour
+		app       map[string]interface{}
+	}{
+		{HashReplace, map[string]interface{}{"port": 8080}},
+		{HashMerge, map[string]interface{}{"port": 8080, "user": "web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.behaviour), func(t *testing.T) {
+			precedence := NewPrecedence(tt.behaviour)
+			precedence.Add(LevelPlayVars, play)
+			precedence.Add(LevelInventoryHostVars, inventory)
+
+			vars := precedence.Resolve()
+			if !reflect.DeepEqual(vars["app"], tt.app) {
+				t.Errorf("expected app %v, got %v", tt.app, vars["app"])
+			}
+			// Lists are replaced either way
+			if !reflect.DeepEqual(vars["peers"], []interface{}{"c"}) {
+				t.Errorf("expected the play's peers, got %v", vars["peers"])
+			}
+		})
+	}
+
+	// Resolving leaves the added maps untouched
+	if len(inventory["app"].(map[string]interface{})) != 2 {
+		t.Errorf("expected the inventory vars to be unchanged, got %v", inventory)
+	}
+}
+
+func TestParseHashBehaviour(t *testing.T) {
+	for value, expected := range map[string]HashBehaviour{"": HashReplace, "replace": HashReplace, "merge": HashMerge} {
+		behaviour, err := ParseHashBehaviour(value)
+		if err != nil || behaviour != expected {
+			t.Errorf("ParseHashBehaviour(%q) = %q, %v; expected %q", value, behaviour, err, expected)
+		}
+	}
+	if _, err := ParseHashBehaviour("deep"); err == nil {
+		t.Error("expected an error for an unknown hash_behaviour")
+	}
+}
@@ -173,6 +173,66 @@ func (m *Manager) DecryptYAMLFile(filename string) (map[string]interface{}, erro
 	return result, nil
 }
 
+// DecodeYAML unmarshals YAML into out like yaml.Unmarshal, first
+// decrypting a vault encrypted file and then inline !vault values wherever
+// they appear, so structs such as playbooks receive plain text. A nil or
+// empty manager leaves inline values encrypted and rejects encrypted files.
+func (m *Manager) DecodeYAML(data []byte, out interface{}) error {
+	locked := m == nil || len(m.vaults) == 0
+	if IsVaultFile(data) {
+		if locked {
+			return ErrNoVaultPassword
+		}
+		decrypted, err := m.Decrypt(string(data))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt vault file: %w", err)
+		}
+		data = decrypted
+	}
+	if locked {
+		return yaml.Unmarshal(data, out)
+	}
+	
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	if node.Kind == 0 {
+		// Empty document
+		return nil
+	}
+	if err := m.decryptNode(&node); err != nil {
+		return err
+	}
+	return node.Decode(out)
+}
+
+// decryptNode replaces vault encrypted scalars in a YAML tree with their
+// plain text
+func (m *Manager) decryptNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if node.Tag != "!vault" && !strings.HasPrefix(node.Value, VaultHeader) {
+			return nil
+		}
+		decrypted, err := m.Decrypt(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: failed to decrypt vault value: %w", node.Line, err)
+		}
+		node.Value = string(decrypted)
+		m.reveal(node.Value)
+		node.Tag = "!!str"
+		node.Style = 0
+		return nil
+	}
+	
+	for _, child := range node.Content {
+		if err := m.decryptNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // EncryptYAMLFile encrypts sensitive values in a YAML file
 func (m *Manager) EncryptYAMLFile(filename string, keys []string, vaultID string) error {
 	// Read file
@@ -2,17 +2,33 @@ package vault
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
+// SecretSink receives the plain text of decrypted vault values, so that
+// logs and output can mask them
+type SecretSink interface {
+	AddSecret(value string)
+}
+
 // Manager manages multiple vault passwords and IDs
 type Manager struct {
 	vaults map[string]*Vault
 	defaultVaultID string
+	
+	// Plain text of the inline values decrypted so far, and where to
+	// report them
+	revealMu sync.Mutex
+	revealed []string
+	sink     SecretSink
 }
 
 // NewManager creates a new vault manager
@@ -23,6 +39,35 @@ func NewManager() *Manager {
 	}
 }
 
+// SetSecretSink reports the plain text of every inline vault value
+// decrypted to sink, including those decrypted before. Whole vaulted files
+// are not reported, as they are documents rather than secrets.
+func (m *Manager) SetSecretSink(sink SecretSink) {
+	m.revealMu.Lock()
+	defer m.revealMu.Unlock()
+	m.sink = sink
+	if sink != nil {
+		for _, value := range m.revealed {
+			sink.AddSecret(value)
+		}
+	}
+}
+
+// reveal records the plain text of a decrypted inline value
+func (m *Manager) reveal(value string) {
+	m.revealMu.Lock()
+	defer m.revealMu.Unlock()
+	for _, known := range m.revealed {
+		if known == value {
+			return
+		}
+	}
+	m.revealed = append(m.revealed, value)
+	if m.sink != nil {
+		m.sink.AddSecret(value)
+	}
+}
+
 // AddVault adds a vault with the given ID and password
 func (m *Manager) AddVault(vaultID, password string) {
 	if vaultID == "" {
@@ -55,6 +100,30 @@ func (m *Manager) AddVaultFromScript(vaultID, script string) error {
 	return nil
 }
 
+// AddVaultFromSource reads a password from a file, or from the output of
+// the file when it is executable, like an ANSIBLE_VAULT_IDENTITY_LIST entry
+func (m *Manager) AddVaultFromSource(vaultID, source string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to read vault password source: %w", err)
+	}
+	
+	if info.Mode()&0111 != 0 {
+		return m.AddVaultFromScript(vaultID, source)
+	}
+	return m.AddVaultFromFile(vaultID, source)
+}
+
+// VaultIDs returns the IDs of the loaded vaults, sorted
+func (m *Manager) VaultIDs() []string {
+	ids := make([]string, 0, len(m.vaults))
+	for id := range m.vaults {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // SetDefaultVaultID sets the default vault ID
 func (m *Manager) SetDefaultVaultID(vaultID string) {
 	m.defaultVaultID = vaultID
@@ -86,6 +155,13 @@ func (m *Manager) Encrypt(data []byte, vaultID string) (string, error) {
 
 // Decrypt attempts to decrypt data with available vaults
 func (m *Manager) Decrypt(vaultData string) ([]byte, error) {
+	result, _, err := m.decrypt(vaultData)
+	return result, err
+}
+
+// decrypt decrypts data with available vaults, also returning the ID of
+// the vault that decrypted it
+func (m *Manager) decrypt(vaultData string) ([]byte, string, error) {
 	// Extract vault ID from header if present
 	vaultID := m.extractVaultID(vaultData)
 	
@@ -94,26 +170,26 @@ func (m *Manager) Decrypt(vaultData string) ([]byte, error) {
 		if vault, exists := m.vaults[vaultID]; exists {
 			result, err := vault.Decrypt(vaultData)
 			if err == nil {
-				return result, nil
+				return result, vaultID, nil
 			}
 		}
 	}
 	
 	// Try all vaults
 	var lastErr error
-	for _, vault := range m.vaults {
+	for id, vault := range m.vaults {
 		result, err := vault.Decrypt(vaultData)
 		if err == nil {
-			return result, nil
+			return result, id, nil
 		}
 		lastErr = err
 	}
 	
 	if lastErr != nil {
-		return nil, lastErr
+		return nil, "", lastErr
 	}
 	
-	return nil, ErrInvalidPassword
+	return nil, "", ErrInvalidPassword
 }
 
 // DecryptFile decrypts a file
@@ -207,62 +283,116 @@ func (m *Manager) View(filename string, w io.Writer) error {
 	return err
 }
 
-// Edit decrypts a file for editing and re-encrypts after
+// Edit decrypts a file into a temporary file, opens it in $EDITOR and
+// re-encrypts it with the vault that decrypted it. An encrypted file is
+// left untouched when its content did not change.
 func (m *Manager) Edit(filename string) error {
-	// Decrypt file
-	decrypted, err := m.DecryptFile(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	
+	decrypted, vaultID := data, m.defaultVaultID
+	if IsVaultFile(data) {
+		if decrypted, vaultID, err = m.decrypt(string(data)); err != nil {
+			return err
+		}
+	}
+	
+	edited, err := editContent(decrypted, filepath.Ext(filename))
 	if err != nil {
 		return err
 	}
+	if IsVaultFile(data) && bytes.Equal(edited, decrypted) {
+		return nil
+	}
 	
-	// Create temp file
-	tmpFile, err := os.CreateTemp("", "vault-edit-*.yml")
+	// Re-encrypt
+	encrypted, err := m.Encrypt(edited, vaultID)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to re-encrypt: %w", err)
+	}
+	
+	return os.WriteFile(filename, []byte(encrypted), 0600)
+}
+
+// Create opens $EDITOR on an empty file and encrypts what was written to it
+// into filename, which must not exist
+func (m *Manager) Create(filename, vaultID string) error {
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("file %s already exists", filename)
+	}
+	
+	content, err := editContent(nil, filepath.Ext(filename))
+	if err != nil {
+		return err
+	}
+	
+	encrypted, err := m.Encrypt(content, vaultID)
+	if err != nil {
+		return err
+	}
+	
+	return os.WriteFile(filename, []byte(encrypted), 0600)
+}
+
+// EncryptString encrypts a value as an inline !vault block. With a name
+// the block is a YAML mapping entry ready to paste into a vars file.
+func (m *Manager) EncryptString(name, value, vaultID string) (string, error) {
+	vault, err := m.GetVault(vaultID)
+	if err != nil {
+		return "", err
+	}
+	
+	block, err := NewVaultString(vault, value).Encrypt()
+	if err != nil {
+		return "", err
+	}
+	
+	if name != "" {
+		block = name + ": " + block
+	}
+	return block, nil
+}
+
+// editContent writes content to a temporary file, opens it in $EDITOR,
+// which may include arguments, and returns the file's content afterwards
+func editContent(content []byte, ext string) ([]byte, error) {
+	tmpFile, err := os.CreateTemp("", "vault-edit-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(tmpFile.Name())
 	
 	// Write decrypted content
-	if _, err := tmpFile.Write(decrypted); err != nil {
+	if _, err := tmpFile.Write(content); err != nil {
 		tmpFile.Close()
-		return fmt.Errorf("failed to write temp file: %w", err)
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
 	}
 	tmpFile.Close()
 	
 	// Get editor from environment
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = "vi"
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
 	}
 	
 	// Open editor
-	cmd := exec.Command(editor, tmpFile.Name())
+	cmd := exec.Command(editor[0], append(editor[1:], tmpFile.Name())...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run editor: %w", err)
+		return nil, fmt.Errorf("failed to run editor: %w", err)
 	}
 	
 	// Read edited content
 	edited, err := os.ReadFile(tmpFile.Name())
 	if err != nil {
-		return fmt.Errorf("failed to read edited file: %w", err)
+		return nil, fmt.Errorf("failed to read edited file: %w", err)
 	}
-	
-	// Re-encrypt
-	vaultID := m.extractVaultID(string(decrypted))
-	if vaultID == "" {
-		vaultID = m.defaultVaultID
-	}
-	
-	encrypted, err := m.Encrypt(edited, vaultID)
-	if err != nil {
-		return fmt.Errorf("failed to re-encrypt: %w", err)
-	}
-	
-	return os.WriteFile(filename, []byte(encrypted), 0600)
+	return edited, nil
 }
 
 // readPasswordFile reads a password from a file
@@ -372,5 +502,6 @@ func (m *Manager) decryptInlineVault(encrypted string) (string, error) {
 		return "", err
 	}
 	
+	m.reveal(string(decrypted))
 	return string(decrypted), nil
 }
\ No newline at end of file
@@ -58,6 +58,9 @@ var (
 	
 	// ErrInvalidPadding indicates invalid PKCS7 padding
 	ErrInvalidPadding = errors.New("invalid padding")
+	
+	// ErrNoVaultPassword indicates encrypted data was found without a vault password
+	ErrNoVaultPassword = errors.New("found vault encrypted data but no vault password was given")
 )
 
 // Vault provides encryption and decryption of Ansible Vault format
@@ -125,9 +128,14 @@ func (v *Vault) Encrypt(plaintext []byte) (string, error) {
 	// Encode to hex
 	hexPayload := hex.EncodeToString(vaultPayload)
 	
-	// Format as Ansible Vault
+	// Format as Ansible Vault, naming the vault ID in a 1.2 header unless it
+	// is the default
 	var result strings.Builder
-	result.WriteString(fmt.Sprintf("%s;%s;%s\n", VaultHeader, VaultFormatVersion, VaultCipher))
+	if v.vaultID != "" && v.vaultID != DefaultVaultIDLabel {
+		result.WriteString(fmt.Sprintf("%s;1.2;%s;%s\n", VaultHeader, VaultCipher, v.vaultID))
+	} else {
+		result.WriteString(fmt.Sprintf("%s;%s;%s\n", VaultHeader, VaultFormatVersion, VaultCipher))
+	}
 	
 	// Wrap hex string at 80 characters
 	for i := 0; i < len(hexPayload); i += 80 {
@@ -155,8 +163,9 @@ func (v *Vault) Decrypt(vaultData string) ([]byte, error) {
 		return nil, ErrInvalidVaultFormat
 	}
 	
+	// 1.2 headers carry the vault ID as a fourth field
 	headerParts := strings.Split(header, ";")
-	if len(headerParts) != 3 {
+	if len(headerParts) != 3 && (len(headerParts) != 4 || headerParts[1] != "1.2") {
 		return nil, ErrInvalidVaultFormat
 	}
 	
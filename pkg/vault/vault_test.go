@@ -342,6 +342,44 @@ func TestProcessVariables(t *testing.T) {
 	}
 }
 
+type recordingSink struct {
+	secrets []string
+}
+
+func (s *recordingSink) AddSecret(value string) {
+	s.secrets = append(s.secrets, value)
+}
+
+func TestSecretSink(t *testing.T) {
+	manager := NewManager()
+	manager.AddVault("default", testPassword)
+	
+	vs := NewVaultString(New(testPassword), "secret_password")
+	encrypted, err := vs.Encrypt()
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	
+	// Values decrypted before the sink is set are replayed to it
+	if err := manager.ProcessVariables(map[string]interface{}{"a": encrypted, "b": encrypted}); err != nil {
+		t.Fatalf("Failed to process variables: %v", err)
+	}
+	sink := &recordingSink{}
+	manager.SetSecretSink(sink)
+	if len(sink.secrets) != 1 || sink.secrets[0] != "secret_password" {
+		t.Fatalf("Expected the decrypted value once, got %v", sink.secrets)
+	}
+	
+	// Later values are reported as they are decrypted
+	other, _ := NewVaultString(New(testPassword), "other_secret").Encrypt()
+	if err := manager.ProcessVariables(map[string]interface{}{"c": other}); err != nil {
+		t.Fatalf("Failed to process variables: %v", err)
+	}
+	if len(sink.secrets) != 2 || sink.secrets[1] != "other_secret" {
+		t.Errorf("Expected the new value to be reported, got %v", sink.secrets)
+	}
+}
+
 func TestVaultCompatibility(t *testing.T) {
 	// Test data encrypted with actual Ansible Vault (for compatibility testing)
 	// This was encrypted with ansible-vault using password "test"
@@ -363,4 +401,152 @@ func TestVaultCompatibility(t *testing.T) {
 	if string(decrypted) != "test" {
 		t.Errorf("Ansible compatibility failed: got %s, want 'test'", string(decrypted))
 	}
-}
\ No newline at end of file
+}
+func TestVaultIDHeader(t *testing.T) {
+	manager := NewManager()
+	manager.AddVault("default", "password1")
+	manager.AddVault("prod", "password2")
+
+	encrypted, err := manager.Encrypt([]byte(testPlaintext), "prod")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "$ANSIBLE_VAULT;1.2;AES256;prod\n") {
+		t.Errorf("Expected a 1.2 header naming the vault ID, got %q", strings.SplitN(encrypted, "\n", 2)[0])
+	}
+
+	decrypted, err := manager.Decrypt(encrypted)
+	if err != nil || string(decrypted) != testPlaintext {
+		t.Errorf("Failed to decrypt labelled data: %v", err)
+	}
+
+	encrypted, _ = manager.Encrypt([]byte(testPlaintext), "default")
+	if !strings.HasPrefix(encrypted, "$ANSIBLE_VAULT;1.1;AES256\n") {
+		t.Errorf("Expected a 1.1 header for the default vault ID, got %q", strings.SplitN(encrypted, "\n", 2)[0])
+	}
+}
+
+func TestVaultEncryptString(t *testing.T) {
+	manager := NewManager()
+	manager.AddVault("default", testPassword)
+
+	block, err := manager.EncryptString("db_password", "s3cr3t", "")
+	if err != nil {
+		t.Fatalf("Failed to encrypt string: %v", err)
+	}
+	if !strings.HasPrefix(block, "db_password: !vault |\n          $ANSIBLE_VAULT;1.1;AES256\n") {
+		t.Errorf("Expected a named inline vault block, got:\n%s", block)
+	}
+
+	vars := map[string]interface{}{"db_password": strings.TrimPrefix(block, "db_password: ")}
+	if err := manager.ProcessVariables(vars); err != nil {
+		t.Fatalf("Failed to decrypt inline block: %v", err)
+	}
+	if vars["db_password"] != "s3cr3t" {
+		t.Errorf("Expected s3cr3t, got %v", vars["db_password"])
+	}
+}
+
+func TestVaultEdit(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "secrets.yml")
+
+	manager := NewManager()
+	manager.AddVault("default", "password1")
+	manager.AddVault("prod", "password2")
+
+	encrypted, _ := manager.Encrypt([]byte("token: old\n"), "prod")
+	if err := os.WriteFile(testFile, []byte(encrypted), 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// An unchanged file is not rewritten
+	t.Setenv("EDITOR", "true")
+	if err := manager.Edit(testFile); err != nil {
+		t.Fatalf("Failed to edit: %v", err)
+	}
+	if data, _ := os.ReadFile(testFile); string(data) != encrypted {
+		t.Error("Expected an unchanged file to be left untouched")
+	}
+
+	t.Setenv("EDITOR", "sed -i s/old/new/")
+	if err := manager.Edit(testFile); err != nil {
+		t.Fatalf("Failed to edit: %v", err)
+	}
+	data, _ := os.ReadFile(testFile)
+	if !strings.HasPrefix(string(data), "$ANSIBLE_VAULT;1.2;AES256;prod\n") {
+		t.Errorf("Expected the file to be re-encrypted with the prod vault, got %q", strings.SplitN(string(data), "\n", 2)[0])
+	}
+	if decrypted, err := manager.Decrypt(string(data)); err != nil || string(decrypted) != "token: new\n" {
+		t.Errorf("Expected the edited content, got %q (%v)", decrypted, err)
+	}
+}
+
+func TestVaultCreate(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "new.yml")
+
+	manager := NewManager()
+	manager.AddVault("default", testPassword)
+
+	editor := filepath.Join(tmpDir, "editor.sh")
+	if err := os.WriteFile(editor, []byte("#!/bin/sh\necho 'created: true' > \"$1\"\n"), 0700); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	t.Setenv("EDITOR", editor)
+	if err := manager.Create(testFile, ""); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	decrypted, err := manager.DecryptFile(testFile)
+	if err != nil || string(decrypted) != "created: true\n" {
+		t.Errorf("Expected the created content, got %q (%v)", decrypted, err)
+	}
+
+	if err := manager.Create(testFile, ""); err == nil {
+		t.Error("Expected creating an existing file to fail")
+	}
+}
+
+func TestDecodeYAML(t *testing.T) {
+	manager := NewManager()
+	manager.AddVault("default", testPassword)
+
+	block, _ := manager.EncryptString("token", "abc123", "")
+	document := "name: app\n" + block + "nested:\n  - plain\n"
+
+	var vars map[string]interface{}
+	if err := manager.DecodeYAML([]byte(document), &vars); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if vars["token"] != "abc123" || vars["name"] != "app" {
+		t.Errorf("Expected the inline value to be decrypted, got %v", vars)
+	}
+
+	encrypted, _ := manager.Encrypt([]byte(document), "")
+	vars = nil
+	if err := manager.DecodeYAML([]byte(encrypted), &vars); err != nil {
+		t.Fatalf("Failed to decode encrypted file: %v", err)
+	}
+	if vars["token"] != "abc123" {
+		t.Errorf("Expected the encrypted file and its inline value to be decrypted, got %v", vars)
+	}
+
+	// Without passwords inline values stay encrypted and files are rejected
+	var locked *Manager
+	vars = nil
+	if err := locked.DecodeYAML([]byte(document), &vars); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if !IsVaultString(vars["token"].(string)) {
+		t.Errorf("Expected the inline value to stay encrypted, got %v", vars["token"])
+	}
+	if err := NewManager().DecodeYAML([]byte(encrypted), &vars); err != ErrNoVaultPassword {
+		t.Errorf("Expected ErrNoVaultPassword, got %v", err)
+	}
+
+	wrong := NewManager()
+	wrong.AddVault("default", "wrong")
+	if err := wrong.DecodeYAML([]byte(document), &vars); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected a decryption error naming the line, got %v", err)
+	}
+}
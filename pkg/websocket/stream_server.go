@@ -2,14 +2,18 @@ package websocket
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/liliang-cn/gosible/pkg/logging"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -23,6 +27,42 @@ type StreamServer struct {
 	unregister chan *Client
 	running    bool
 	runningMux sync.RWMutex
+	redactor   *logging.Redactor
+
+	// authenticator, when set, must accept the token of a client before it
+	// connects
+	authenticator Authenticator
+
+	// replay keeps the most recent messages, in a ring, for clients that
+	// connect mid-run
+	replay     []StreamMessage
+	replayNext int
+	replayFull bool
+	replayMux  sync.Mutex
+}
+
+// DefaultReplaySize is how many recent messages a StreamServer replays to
+// clients as they connect, unless SetReplaySize changes it
+const DefaultReplaySize = 128
+
+// Authenticator checks the token a client presents, returning the user it
+// identifies and whether it is accepted
+type Authenticator func(token string) (userID string, ok bool)
+
+// StaticTokens accepts the tokens of a fixed set, mapped to the users they
+// identify
+func StaticTokens(tokens map[string]string) Authenticator {
+	return func(token string) (string, bool) {
+		if token == "" {
+			return "", false
+		}
+		for known, userID := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				return userID, true
+			}
+		}
+		return "", false
+	}
 }
 
 // Client represents a WebSocket client connection
@@ -34,6 +74,11 @@ type Client struct {
 	sessionInfo   ClientSession
 	lastPing      time.Time
 	subscriptions map[string]bool // Event type subscriptions
+
+	// channels are the runs or sessions the client follows; a client
+	// following none receives the messages of every channel
+	channels    map[string]bool
+	channelsMux sync.RWMutex
 }
 
 // ClientSession contains client session information
@@ -52,6 +97,8 @@ type StreamMessage struct {
 	Timestamp time.Time              `json:"timestamp"`
 	Source    string                 `json:"source,omitempty"`     // Module or connection source
 	SessionID string                 `json:"session_id,omitempty"` // Client session ID
+	Channel   string                 `json:"channel,omitempty"`    // Run or session the message belongs to, empty for all
+	Replay    bool                   `json:"replay,omitempty"`     // Sent again to a client that connected later
 	Data      map[string]interface{} `json:"data,omitempty"`
 
 	// gosible-specific event data
@@ -71,16 +118,14 @@ const (
 	MessageTypeUnsubscribe = "unsubscribe"
 	MessageTypePing        = "ping"
 	MessageTypePong        = "pong"
+	MessageTypeJoin        = "join"
+	MessageTypeLeave       = "leave"
 )
 
 // NewStreamServer creates a new WebSocket stream server
 func NewStreamServer() *StreamServer {
-	return &StreamServer{
+	s := &StreamServer{
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				// In production, implement proper origin checking
-				return true
-			},
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
 		},
@@ -88,7 +133,51 @@ func NewStreamServer() *StreamServer {
 		broadcast:  make(chan StreamMessage, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		replay:     make([]StreamMessage, DefaultReplaySize),
 	}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	return s
+}
+
+// checkOrigin accepts WebSocket requests from any origin when clients must
+// present a token, which pages of other origins do not hold. Otherwise a
+// browser may only connect from the server's own origin, so other pages the
+// user visits cannot follow the stream.
+func (s *StreamServer) checkOrigin(r *http.Request) bool {
+	if s.authenticator != nil {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// SetAuthenticator requires clients to present a token the authenticator
+// accepts, as a bearer token in the Authorization header or in the token
+// query parameter, which browsers must use. The user it identifies becomes
+// the client's user. It should be set before the server starts.
+func (s *StreamServer) SetAuthenticator(authenticator Authenticator) {
+	s.authenticator = authenticator
+}
+
+// SetReplaySize sets how many recent messages are replayed to clients as
+// they connect or join a channel, 0 disabling replay. Messages already kept
+// are dropped.
+func (s *StreamServer) SetReplaySize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	s.replayMux.Lock()
+	defer s.replayMux.Unlock()
+	s.replay = make([]StreamMessage, size)
+	s.replayNext = 0
+	s.replayFull = false
 }
 
 // Start begins the WebSocket server message processing
@@ -134,8 +223,21 @@ func (s *StreamServer) Stop() {
 	log.Println("WebSocket stream server stopped")
 }
 
-// HandleWebSocket handles WebSocket upgrade and client management
+// HandleWebSocket handles WebSocket upgrade and client management. Clients
+// follow the channels given in channel query parameters, and may join and
+// leave channels later.
 func (s *StreamServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	var authenticatedUser string
+	if s.authenticator != nil {
+		userID, ok := s.authenticator(requestToken(r))
+		if !ok {
+			log.Printf("WebSocket client refused: %s presented no valid token", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		authenticatedUser = userID
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
@@ -150,6 +252,7 @@ func (s *StreamServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		id:            sessionID,
 		lastPing:      time.Now(),
 		subscriptions: make(map[string]bool),
+		channels:      make(map[string]bool),
 		sessionInfo: ClientSession{
 			SessionID:   sessionID,
 			ConnectedAt: time.Now(),
@@ -159,10 +262,18 @@ func (s *StreamServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	// Extract user info from query params or headers
-	if userID := r.URL.Query().Get("user_id"); userID != "" {
+	// Extract user info from query params or headers, which only
+	// authentication can be trusted for
+	if s.authenticator != nil {
+		client.sessionInfo.UserID = authenticatedUser
+	} else if userID := r.URL.Query().Get("user_id"); userID != "" {
 		client.sessionInfo.UserID = userID
 	}
+	for _, channel := range r.URL.Query()["channel"] {
+		if channel != "" {
+			client.channels[channel] = true
+		}
+	}
 
 	s.register <- client
 
@@ -173,12 +284,43 @@ func (s *StreamServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Client connected: %s from %s", sessionID, r.RemoteAddr)
 }
 
+// SetRedactor masks secrets in every message before it is sent to clients.
+// It should be set before the server starts.
+func (s *StreamServer) SetRedactor(redactor *logging.Redactor) {
+	s.redactor = redactor
+}
+
+// redact masks secrets in a message
+func (s *StreamServer) redact(message StreamMessage) StreamMessage {
+	if s.redactor == nil {
+		return message
+	}
+	if message.Data != nil {
+		message.Data = s.redactor.Value(message.Data).(map[string]interface{})
+	}
+	if message.StreamEvent != nil {
+		event := s.redactor.StreamEvent(*message.StreamEvent)
+		message.StreamEvent = &event
+	}
+	message.StepInfo = s.redactor.Step(message.StepInfo)
+	message.Progress = s.redactor.Progress(message.Progress)
+	return message
+}
+
 // BroadcastStreamEvent broadcasts a gosiblestream event to all clients
 func (s *StreamServer) BroadcastStreamEvent(event types.StreamEvent, source string) {
+	s.PublishStreamEvent("", event, source)
+}
+
+// PublishStreamEvent sends a gosiblestream event to the clients following
+// channel, or to all clients when channel is empty
+func (s *StreamServer) PublishStreamEvent(channel string, event types.StreamEvent, source string) {
+	event = types.CensorStreamEvent(event)
 	message := StreamMessage{
 		Type:        MessageTypeStreamEvent,
 		Timestamp:   time.Now(),
 		Source:      source,
+		Channel:     channel,
 		StreamEvent: &event,
 	}
 
@@ -194,23 +336,42 @@ func (s *StreamServer) BroadcastStreamEvent(event types.StreamEvent, source stri
 		}
 	}
 
-	s.broadcast <- message
+	s.broadcast <- s.redact(message)
 }
 
 // BroadcastProgress broadcasts progress information
 func (s *StreamServer) BroadcastProgress(progress types.ProgressInfo, source string) {
+	s.PublishProgress("", progress, source)
+}
+
+// PublishProgress sends progress information to the clients following
+// channel, or to all clients when channel is empty
+func (s *StreamServer) PublishProgress(channel string, progress types.ProgressInfo, source string) {
 	message := StreamMessage{
 		Type:      MessageTypeProgress,
 		Timestamp: time.Now(),
 		Source:    source,
+		Channel:   channel,
 		Progress:  &progress,
 	}
 
-	s.broadcast <- message
+	s.broadcast <- s.redact(message)
+}
+
+// Publish sends a message of any type to the clients following channel,
+// or to all clients when channel is empty
+func (s *StreamServer) Publish(channel string, message StreamMessage) {
+	message.Channel = channel
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	s.broadcast <- s.redact(message)
 }
 
 // BroadcastToClient sends a message to a specific client
 func (s *StreamServer) BroadcastToClient(clientID string, message StreamMessage) {
+	message = s.redact(message)
 	s.clientsMux.RLock()
 	defer s.clientsMux.RUnlock()
 
@@ -260,10 +421,14 @@ func (s *StreamServer) run() {
 					"status":      "connected",
 					"session_id":  client.id,
 					"server_time": time.Now().Unix(),
+					"channels":    client.channelNames(),
 				},
 			}
 			client.send <- welcomeMsg
 
+			// Catch the client up on what it missed
+			s.replayTo(client, client.follows)
+
 		case client := <-s.unregister:
 			s.clientsMux.Lock()
 			if _, ok := s.clients[client]; ok {
@@ -279,10 +444,11 @@ func (s *StreamServer) run() {
 			log.Printf("Client disconnected: %s", client.id)
 
 		case message := <-s.broadcast:
+			s.remember(message)
+
 			s.clientsMux.RLock()
 			for client := range s.clients {
-				// Check if client is subscribed to this message type
-				if len(client.subscriptions) > 0 && !client.subscriptions[message.Type] {
+				if !client.wants(message) {
 					continue
 				}
 
@@ -315,6 +481,55 @@ func (s *StreamServer) run() {
 	}
 }
 
+// remember keeps a message for replay, dropping the oldest kept
+func (s *StreamServer) remember(message StreamMessage) {
+	s.replayMux.Lock()
+	defer s.replayMux.Unlock()
+
+	if len(s.replay) == 0 {
+		return
+	}
+	s.replay[s.replayNext] = message
+	s.replayNext = (s.replayNext + 1) % len(s.replay)
+	if s.replayNext == 0 {
+		s.replayFull = true
+	}
+}
+
+// recent returns the kept messages matching filter, oldest first
+func (s *StreamServer) recent(filter func(StreamMessage) bool) []StreamMessage {
+	s.replayMux.Lock()
+	defer s.replayMux.Unlock()
+
+	kept := s.replay[:s.replayNext]
+	if s.replayFull {
+		kept = append(append([]StreamMessage{}, s.replay[s.replayNext:]...), s.replay[:s.replayNext]...)
+	}
+	var messages []StreamMessage
+	for _, message := range kept {
+		if filter(message) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// replayTo sends a client the kept messages matching filter and its
+// subscriptions, marked as replayed. Replay stops rather than wait when the
+// client's buffer is full.
+func (s *StreamServer) replayTo(client *Client, filter func(StreamMessage) bool) {
+	for _, message := range s.recent(func(message StreamMessage) bool {
+		return client.subscribed(message) && filter(message)
+	}) {
+		message.Replay = true
+		select {
+		case client.send <- message:
+		default:
+			return
+		}
+	}
+}
+
 // cleanupClients removes inactive clients
 func (s *StreamServer) cleanupClients() {
 	s.clientsMux.Lock()
@@ -398,6 +613,37 @@ func (c *Client) writePump() {
 	}
 }
 
+// wants reports whether the client receives a message: it must be
+// subscribed to its type, and follow its channel
+func (c *Client) wants(message StreamMessage) bool {
+	return c.subscribed(message) && c.follows(message)
+}
+
+// subscribed reports whether the client is subscribed to the type of a
+// message, as all clients are until they subscribe to some
+func (c *Client) subscribed(message StreamMessage) bool {
+	return len(c.subscriptions) == 0 || c.subscriptions[message.Type]
+}
+
+// follows reports whether the client follows the channel of a message.
+// Messages without a channel go to every client, and clients following no
+// channel receive every message.
+func (c *Client) follows(message StreamMessage) bool {
+	if message.Channel == "" {
+		return true
+	}
+	c.channelsMux.RLock()
+	defer c.channelsMux.RUnlock()
+	return len(c.channels) == 0 || c.channels[message.Channel]
+}
+
+// channelNames returns the channels the client follows
+func (c *Client) channelNames() []string {
+	c.channelsMux.RLock()
+	defer c.channelsMux.RUnlock()
+	return getSubscriptionKeys(c.channels)
+}
+
 // handleMessage processes incoming messages from the client
 func (c *Client) handleMessage(messageBytes []byte) {
 	var message StreamMessage
@@ -411,6 +657,10 @@ func (c *Client) handleMessage(messageBytes []byte) {
 		c.handleSubscribe(message)
 	case MessageTypeUnsubscribe:
 		c.handleUnsubscribe(message)
+	case MessageTypeJoin:
+		c.handleJoin(message)
+	case MessageTypeLeave:
+		c.handleLeave(message)
 	case MessageTypePong:
 		c.lastPing = time.Now()
 	}
@@ -462,8 +712,83 @@ func (c *Client) handleUnsubscribe(message StreamMessage) {
 	c.send <- response
 }
 
+// handleJoin handles requests to follow channels, replaying what was kept
+// of them
+func (c *Client) handleJoin(message StreamMessage) {
+	joined := make(map[string]bool)
+	c.channelsMux.Lock()
+	for _, channel := range messageChannels(message) {
+		if !c.channels[channel] {
+			c.channels[channel] = true
+			joined[channel] = true
+		}
+	}
+	c.channelsMux.Unlock()
+
+	// Send confirmation
+	response := StreamMessage{
+		Type:      MessageTypeJoin,
+		Timestamp: time.Now(),
+		SessionID: c.id,
+		Data: map[string]interface{}{
+			"status":   "joined",
+			"channels": c.channelNames(),
+		},
+	}
+	c.send <- response
+
+	if c.server != nil {
+		c.server.replayTo(c, func(message StreamMessage) bool {
+			return joined[message.Channel]
+		})
+	}
+}
+
+// handleLeave handles requests to stop following channels
+func (c *Client) handleLeave(message StreamMessage) {
+	c.channelsMux.Lock()
+	for _, channel := range messageChannels(message) {
+		delete(c.channels, channel)
+	}
+	c.channelsMux.Unlock()
+
+	// Send confirmation
+	response := StreamMessage{
+		Type:      MessageTypeLeave,
+		Timestamp: time.Now(),
+		SessionID: c.id,
+		Data: map[string]interface{}{
+			"status":   "left",
+			"channels": c.channelNames(),
+		},
+	}
+	c.send <- response
+}
+
 // Helper functions
 
+// requestToken returns the token a client presents, as a bearer token or
+// in the token query parameter
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return r.URL.Query().Get("token")
+}
+
+// messageChannels returns the channels a join or leave message names
+func messageChannels(message StreamMessage) []string {
+	var channels []string
+	if names, ok := message.Data["channels"].([]interface{}); ok {
+		for _, name := range names {
+			if channel, ok := name.(string); ok && channel != "" {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
 // generateSessionID generates a unique session ID
 func generateSessionID() string {
 	return fmt.Sprintf("ws_%d_%d", time.Now().UnixNano(), time.Now().Unix())
@@ -480,8 +805,9 @@ func getSubscriptionKeys(subscriptions map[string]bool) []string {
 
 // StreamingWebSocketAdapter adapts gosiblestreaming to WebSocket
 type StreamingWebSocketAdapter struct {
-	server *StreamServer
-	source string
+	server  *StreamServer
+	source  string
+	channel string
 }
 
 // NewStreamingWebSocketAdapter creates a new adapter
@@ -492,6 +818,12 @@ func NewStreamingWebSocketAdapter(server *StreamServer, source string) *Streamin
 	}
 }
 
+// SetChannel publishes the adapter's events to the clients following
+// channel, such as the ID of the run they come from, instead of to all
+func (a *StreamingWebSocketAdapter) SetChannel(channel string) {
+	a.channel = channel
+}
+
 // HandleStreamEvents processes a channel of stream events and broadcasts them
 func (a *StreamingWebSocketAdapter) HandleStreamEvents(ctx context.Context, events <-chan types.StreamEvent) {
 	for {
@@ -500,7 +832,7 @@ func (a *StreamingWebSocketAdapter) HandleStreamEvents(ctx context.Context, even
 			if !ok {
 				return
 			}
-			a.server.BroadcastStreamEvent(event, a.source)
+			a.server.PublishStreamEvent(a.channel, event, a.source)
 
 		case <-ctx.Done():
 			return
@@ -511,6 +843,6 @@ func (a *StreamingWebSocketAdapter) HandleStreamEvents(ctx context.Context, even
 // CreateProgressCallback creates a progress callback that broadcasts to WebSocket
 func (a *StreamingWebSocketAdapter) CreateProgressCallback() func(progress types.ProgressInfo) {
 	return func(progress types.ProgressInfo) {
-		a.server.BroadcastProgress(progress, a.source)
+		a.server.PublishProgress(a.channel, progress, a.source)
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/liliang-cn/gosible/pkg/logging"
 	"github.com/liliang-cn/gosible/pkg/types"
 )
 
@@ -564,4 +565,208 @@ func BenchmarkStreamMessage_JSONMarshal(b *testing.B) {
 			b.Fatalf("Marshal failed: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestStreamServer_Redactor(t *testing.T) {
+	server := NewStreamServer()
+	redactor, err := logging.NewRedactor(logging.RedactionRules{Secrets: []string{"s3cr3t-token"}})
+	if err != nil {
+		t.Fatalf("NewRedactor failed: %v", err)
+	}
+	server.SetRedactor(redactor)
+	
+	event := types.StreamEvent{
+		Type: types.StreamStdout,
+		Data: "export TOKEN=s3cr3t-token",
+	}
+	server.BroadcastStreamEvent(event, "test_source")
+	
+	select {
+	case message := <-server.broadcast:
+		if message.StreamEvent.Data != "export TOKEN=********" {
+			t.Errorf("Expected the secret to be masked, got %q", message.StreamEvent.Data)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Message should have been sent to broadcast channel")
+	}
+	
+	if event.Data != "export TOKEN=s3cr3t-token" {
+		t.Error("Expected the original event to be unchanged")
+	}
+}
+
+// dialStreamServer connects a client to a test server, adding query to the
+// URL, and reads the connection message
+func dialStreamServer(t *testing.T, httpServer *httptest.Server, query string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+query, header)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var welcome StreamMessage
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != MessageTypeConnection {
+		t.Fatalf("Expected a connection message, got %+v: %v", welcome, err)
+	}
+	return conn
+}
+
+// readStages reads n progress messages, returning their stages
+func readStages(t *testing.T, conn *websocket.Conn, n int) []string {
+	t.Helper()
+	var stages []string
+	for len(stages) < n {
+		var message StreamMessage
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read message %d: %v", len(stages)+1, err)
+		}
+		if message.Type == MessageTypeProgress {
+			stages = append(stages, message.Progress.Stage)
+		}
+	}
+	return stages
+}
+
+// waitForReplay waits until the server keeps n messages
+func waitForReplay(t *testing.T, server *StreamServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(server.recent(func(StreamMessage) bool { return true })) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d messages to be kept", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamServer_Authentication(t *testing.T) {
+	server := NewStreamServer()
+	server.SetAuthenticator(StaticTokens(map[string]string{"t0ken": "alice"}))
+	server.Start()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	for _, query := range []string{"", "?token=wrong"} {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+query, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%q: expected the client to be refused, got %v", query, err)
+		}
+	}
+
+	dialStreamServer(t, httpServer, "", http.Header{"Authorization": []string{"Bearer t0ken"}})
+	dialStreamServer(t, httpServer, "?token=t0ken&user_id=mallory", nil)
+	for _, session := range server.GetConnectedClients() {
+		if session.UserID != "alice" {
+			t.Errorf("Expected the authenticated user, got %q", session.UserID)
+		}
+	}
+	if clients := server.GetConnectedClients(); len(clients) != 2 {
+		t.Errorf("Expected 2 clients, got %d", len(clients))
+	}
+}
+
+func TestStreamServer_Origin(t *testing.T) {
+	server := NewStreamServer()
+	server.Start()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	// Without authentication, browsers may only connect from the server's
+	// own origin, while clients sending no origin are not browsers
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": []string{"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin client to be refused, got %v", err)
+	}
+	dialStreamServer(t, httpServer, "", http.Header{"Origin": []string{httpServer.URL}})
+	dialStreamServer(t, httpServer, "", nil)
+
+	// With authentication, the token is what counts
+	server.SetAuthenticator(StaticTokens(map[string]string{"t0ken": "alice"}))
+	dialStreamServer(t, httpServer, "?token=t0ken", http.Header{"Origin": []string{"https://ui.example"}})
+}
+
+func TestStreamServer_Channels(t *testing.T) {
+	server := NewStreamServer()
+	server.Start()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer httpServer.Close()
+
+	run1 := dialStreamServer(t, httpServer, "?channel=run-1", nil)
+	run2 := dialStreamServer(t, httpServer, "?channel=run-2", nil)
+	everything := dialStreamServer(t, httpServer, "", nil)
+
+	server.PublishProgress("run-1", types.ProgressInfo{Stage: "one"}, "test")
+	server.PublishProgress("run-2", types.ProgressInfo{Stage: "two"}, "test")
+	server.BroadcastProgress(types.ProgressInfo{Stage: "all"}, "test")
+
+	if stages := readStages(t, run1, 2); stages[0] != "one" || stages[1] != "all" {
+		t.Errorf("Expected run-1 and broadcast messages, got %v", stages)
+	}
+	if stages := readStages(t, run2, 2); stages[0] != "two" || stages[1] != "all" {
+		t.Errorf("Expected run-2 and broadcast messages, got %v", stages)
+	}
+	if stages := readStages(t, everything, 3); strings.Join(stages, ",") != "one,two,all" {
+		t.Errorf("Expected every message, got %v", stages)
+	}
+
+	// Joining a channel replays what was kept of it
+	if err := run1.WriteJSON(StreamMessage{Type: MessageTypeJoin, Data: map[string]interface{}{"channels": []interface{}{"run-2"}}}); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	var joined StreamMessage
+	run1.SetReadDeadline(time.Now().Add(time.Second))
+	if err := run1.ReadJSON(&joined); err != nil || joined.Type != MessageTypeJoin {
+		t.Fatalf("Expected a join confirmation, got %+v: %v", joined, err)
+	}
+	if stages := readStages(t, run1, 1); stages[0] != "two" {
+		t.Errorf("Expected the run-2 message to be replayed, got %v", stages)
+	}
+}
+
+func TestStreamServer_Replay(t *testing.T) {
+	server := NewStreamServer()
+	server.SetReplaySize(2)
+	server.Start()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer httpServer.Close()
+
+	server.PublishProgress("run-1", types.ProgressInfo{Stage: "first"}, "test")
+	server.PublishProgress("run-2", types.ProgressInfo{Stage: "other"}, "test")
+	server.PublishProgress("run-1", types.ProgressInfo{Stage: "second"}, "test")
+	server.PublishProgress("run-1", types.ProgressInfo{Stage: "third"}, "test")
+	waitForReplay(t, server, 2)
+
+	// Only the most recent messages are kept, and only those of the
+	// client's channel replayed
+	conn := dialStreamServer(t, httpServer, "?channel=run-1", nil)
+	for _, stage := range []string{"second", "third"} {
+		var message StreamMessage
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read replayed message: %v", err)
+		}
+		if !message.Replay || message.Progress == nil || message.Progress.Stage != stage {
+			t.Errorf("Expected %s to be replayed, got %+v", stage, message)
+		}
+	}
+
+	server.PublishProgress("run-1", types.ProgressInfo{Stage: "live"}, "test")
+	var live StreamMessage
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&live); err != nil || live.Replay || live.Progress.Stage != "live" {
+		t.Errorf("Expected the live message, got %+v: %v", live, err)
+	}
+}